@@ -0,0 +1,404 @@
+package main
+
+import (
+    "encoding/base64"
+    "errors"
+    "io"
+    "log"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/audit"
+    "github.com/volly-org/volly-signaling/pkg/volly/configstore"
+    "github.com/volly-org/volly-signaling/pkg/volly/doctor"
+    "github.com/volly-org/volly-signaling/pkg/volly/killswitch"
+    "github.com/volly-org/volly-signaling/pkg/volly/revocation"
+    "github.com/volly-org/volly-signaling/pkg/volly/secure"
+    "github.com/volly-org/volly-signaling/pkg/volly/snapshot"
+    "github.com/volly-org/volly-signaling/pkg/volly/tagset"
+)
+
+type createKeyRequest struct {
+    Scopes []string `json:"scopes,omitempty"`
+}
+
+// createKeyResponse is the only time a generated secret is returned
+type createKeyResponse struct {
+    ID     string `json:"id"`
+    Tenant string `json:"tenant"`
+    Secret string `json:"secret"`
+}
+
+// maxSnapshotBytes bounds state imports
+const maxSnapshotBytes = 256 << 20
+
+type importSummary struct {
+    CreatedAt     time.Time `json:"createdAt"`
+    Tenants       int       `json:"tenants"`
+    APIKeys       int       `json:"apiKeys"`
+    RoomPolicies  int       `json:"roomPolicies"`
+    Revocations   int       `json:"revocations"`
+    RevokedTokens int       `json:"revokedTokens"`
+}
+
+type killSwitchRequest struct {
+    Scope  killswitch.Scope `json:"scope"`
+    Reason string           `json:"reason,omitempty"`
+}
+
+// newAdmin serves the management API behind the VOLLY_ADMIN_TOKEN bearer token and, when
+// configured, a DPoP proof
+func newAdmin(cfg *config, s *stores) (http.Handler, error) {
+    if cfg.AdminToken == "" {
+        return nil, errors.New("VOLLY_ADMIN_TOKEN must be set to run admin")
+    }
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("GET /v1/tenants", func(w http.ResponseWriter, r *http.Request) {
+        tenants, err := s.config.ListTenants(r.Context())
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, tenants)
+    })
+    mux.HandleFunc("PUT /v1/tenants/{tenant}", func(w http.ResponseWriter, r *http.Request) {
+        var t configstore.Tenant
+        if !readJSON(w, r, &t) {
+            return
+        }
+        t.ID = r.PathValue("tenant")
+        if err := s.config.PutTenant(r.Context(), t); err != nil {
+            writeError(w, err)
+            return
+        }
+        record(r, s, "tenant.put", t.ID, t.ID)
+        writeJSON(w, http.StatusOK, t)
+    })
+    mux.HandleFunc("DELETE /v1/tenants/{tenant}", func(w http.ResponseWriter, r *http.Request) {
+        ctx := r.Context()
+        tenant := r.PathValue("tenant")
+        // Keys go with their tenant so nothing can mint tokens for it afterwards
+        keys, err := s.config.ListAPIKeys(ctx, tenant)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        for _, k := range keys {
+            if err := s.config.DeleteAPIKey(ctx, k.ID); err != nil {
+                writeError(w, err)
+                return
+            }
+        }
+        if err := s.config.DeleteTenant(ctx, tenant); err != nil {
+            writeError(w, err)
+            return
+        }
+        record(r, s, "tenant.delete", tenant, tenant)
+        w.WriteHeader(http.StatusNoContent)
+    })
+
+    mux.HandleFunc("GET /v1/tenants/{tenant}/keys", func(w http.ResponseWriter, r *http.Request) {
+        keys, err := s.config.ListAPIKeys(r.Context(), r.PathValue("tenant"))
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, keys)
+    })
+    mux.HandleFunc("POST /v1/tenants/{tenant}/keys", func(w http.ResponseWriter, r *http.Request) {
+        ctx := r.Context()
+        tenant := r.PathValue("tenant")
+        var req createKeyRequest
+        if !readJSON(w, r, &req) {
+            return
+        }
+        if _, err := s.config.GetTenant(ctx, tenant); err != nil {
+            writeError(w, err)
+            return
+        }
+        id, secretText, err := configstore.GenerateAPIKey()
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        resp := createKeyResponse{ID: id, Tenant: tenant, Secret: string(secretText)}
+        secret, err := secure.FromBytes(secretText)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        if err := s.config.PutAPIKey(ctx, configstore.APIKey{ID: id, Tenant: tenant, Scopes: req.Scopes, Secret: secret}); err != nil {
+            writeError(w, err)
+            return
+        }
+        record(r, s, "apikey.create", tenant, id)
+        writeJSON(w, http.StatusCreated, resp)
+    })
+    mux.HandleFunc("DELETE /v1/keys/{key}", func(w http.ResponseWriter, r *http.Request) {
+        id := r.PathValue("key")
+        key, err := s.config.GetAPIKey(r.Context(), id)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        if err := s.config.DeleteAPIKey(r.Context(), id); err != nil {
+            writeError(w, err)
+            return
+        }
+        record(r, s, "apikey.delete", key.Tenant, id)
+        w.WriteHeader(http.StatusNoContent)
+    })
+
+    // Passkeys are listed per identity; deleting one is how a lost authenticator is cut off
+    mux.HandleFunc("GET /v1/tenants/{tenant}/webauthn-credentials", func(w http.ResponseWriter, r *http.Request) {
+        identity := r.URL.Query().Get("identity")
+        if identity == "" {
+            http.Error(w, "identity is required", http.StatusBadRequest)
+            return
+        }
+        creds, err := s.webauthn.List(r.Context(), r.PathValue("tenant"), identity)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, creds)
+    })
+    mux.HandleFunc("DELETE /v1/tenants/{tenant}/webauthn-credentials/{id}", func(w http.ResponseWriter, r *http.Request) {
+        tenant, id := r.PathValue("tenant"), r.PathValue("id")
+        if err := s.webauthn.Delete(r.Context(), tenant, id); err != nil {
+            writeError(w, err)
+            return
+        }
+        record(r, s, "webauthn.delete", tenant, id)
+        w.WriteHeader(http.StatusNoContent)
+    })
+    directoryRoutes(mux, s)
+    erasureRoutes(mux, s)
+    retentionRoutes(mux, s)
+    usageRoutes(mux, s)
+    shadowRoutes(mux, s)
+    rolloutRoutes(mux, s)
+    bundleRoutes(mux, s)
+    envelopeRoutes(mux, s)
+    auditChainRoutes(mux, s)
+    anomalyRoutes(mux, s)
+    decoyRoutes(mux, cfg, s)
+    pinRoutes(mux, s)
+    federationRoutes(mux, s)
+
+    mux.HandleFunc("POST /v1/revocations", func(w http.ResponseWriter, r *http.Request) {
+        var p revocation.Predicate
+        if !readJSON(w, r, &p) {
+            return
+        }
+        if err := s.revocations.RevokeWhere(r.Context(), p); err != nil {
+            writeError(w, err)
+            return
+        }
+        record(r, s, "revocation.create", p.Tenant, p.Room+"/"+p.Identity)
+        w.WriteHeader(http.StatusNoContent)
+    })
+
+    mux.HandleFunc("GET /v1/killswitch", func(w http.ResponseWriter, r *http.Request) {
+        writeJSON(w, http.StatusOK, s.killSwitch.Engaged())
+    })
+    mux.HandleFunc("POST /v1/killswitch", func(w http.ResponseWriter, r *http.Request) {
+        var req killSwitchRequest
+        if !readJSON(w, r, &req) {
+            return
+        }
+        if err := s.killSwitch.Engage(r.Context(), req.Scope, req.Reason); err != nil {
+            writeError(w, err)
+            return
+        }
+        record(r, s, "killswitch.engage", req.Scope.Tenant, req.Scope.Room)
+        w.WriteHeader(http.StatusNoContent)
+    })
+    mux.HandleFunc("DELETE /v1/killswitch", func(w http.ResponseWriter, r *http.Request) {
+        scope := killswitch.Scope{Tenant: r.URL.Query().Get("tenant"), Room: r.URL.Query().Get("room")}
+        if err := s.killSwitch.Release(r.Context(), scope); err != nil {
+            writeError(w, err)
+            return
+        }
+        record(r, s, "killswitch.release", scope.Tenant, scope.Room)
+        w.WriteHeader(http.StatusNoContent)
+    })
+
+    mux.HandleFunc("GET /v1/audit", func(w http.ResponseWriter, r *http.Request) {
+        q := r.URL.Query()
+        query := audit.Query{Tenant: q.Get("tenant"), Actor: q.Get("actor"), Action: q.Get("action")}
+        if limit := q.Get("limit"); limit != "" {
+            n, err := strconv.Atoi(limit)
+            if err != nil {
+                http.Error(w, "invalid limit", http.StatusBadRequest)
+                return
+            }
+            query.Limit = n
+        }
+        // tags=cost-center=eng selects the entries of actions taken with tokens carrying it
+        tags, err := tagset.Parse(q.Get("tags"))
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        query.Tags = tags
+        entries, err := s.audit.Query(r.Context(), query)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        // redact=true applies the redaction policy, for collectors feeding a log vendor
+        if q.Get("redact") == "true" {
+            for i := range entries {
+                entries[i] = s.redactor.Entry(entries[i])
+            }
+        }
+        writeJSON(w, http.StatusOK, entries)
+    })
+
+    // State snapshots carry every API secret, sealed under the snapshot key
+    mux.HandleFunc("GET /v1/state", func(w http.ResponseWriter, r *http.Request) {
+        key, err := snapshotKey(cfg)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusServiceUnavailable)
+            return
+        }
+        defer key.Close()
+        data, err := snapshot.ExportState(r.Context(), snapshot.Stores{Config: s.config, Revocations: s.revoked}, key)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        record(r, s, "state.export", "", "")
+        w.Header().Set("Content-Type", "application/octet-stream")
+        w.Write(data)
+    })
+    mux.HandleFunc("POST /v1/state", func(w http.ResponseWriter, r *http.Request) {
+        key, err := snapshotKey(cfg)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusServiceUnavailable)
+            return
+        }
+        defer key.Close()
+        data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSnapshotBytes))
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+            return
+        }
+        state, err := snapshot.ImportState(r.Context(), data, snapshot.Stores{Config: s.config, Revocations: s.revoked}, key)
+        switch {
+        case errors.Is(err, snapshot.ErrInvalidSnapshot), errors.Is(err, snapshot.ErrSnapshotTampered), errors.Is(err, snapshot.ErrUnknownVersion):
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        case err != nil:
+            writeError(w, err)
+            return
+        }
+        record(r, s, "state.import", "", "")
+        writeJSON(w, http.StatusOK, importSummary{
+            CreatedAt:     state.CreatedAt,
+            Tenants:       len(state.Tenants),
+            APIKeys:       len(state.APIKeys),
+            RoomPolicies:  len(state.RoomPolicies),
+            Revocations:   len(state.Cutoffs),
+            RevokedTokens: len(state.Tokens),
+        })
+    })
+
+    mux.HandleFunc("GET /v1/cache/stats", func(w http.ResponseWriter, r *http.Request) {
+        stats := s.configCache.Stats()
+        for kind, st := range s.keyCache.Stats() {
+            stats[kind] = st
+        }
+        stats["grant"] = s.grantCache.Stats()
+        writeJSON(w, http.StatusOK, stats)
+    })
+    mux.HandleFunc("GET /v1/gateway/rooms", func(w http.ResponseWriter, r *http.Request) {
+        writeJSON(w, http.StatusOK, s.rooms.Stats())
+    })
+    mux.HandleFunc("GET /v1/gateway/connections", func(w http.ResponseWriter, r *http.Request) {
+        writeJSON(w, http.StatusOK, s.connections.Stats())
+    })
+    mux.HandleFunc("GET /v1/canary", func(w http.ResponseWriter, r *http.Request) {
+        if s.canary == nil {
+            http.Error(w, "canary is off; set canary.interval", http.StatusNotFound)
+            return
+        }
+        writeJSON(w, http.StatusOK, s.canary.Stats())
+    })
+    // Runs the canary now, for checking a key rotation without waiting for the next run
+    mux.HandleFunc("POST /v1/canary", func(w http.ResponseWriter, r *http.Request) {
+        if s.canary == nil {
+            http.Error(w, "canary is off; set canary.interval", http.StatusNotFound)
+            return
+        }
+        writeJSON(w, http.StatusOK, s.canary.Probe(r.Context()))
+    })
+    // Reports for product analytics; since is RFC 3339 and defaults to every kept window,
+    // and tags selects the reports of tokens carrying every pair
+    mux.HandleFunc("GET /v1/analytics", func(w http.ResponseWriter, r *http.Request) {
+        if s.analytics == nil {
+            http.Error(w, "analytics is off; set analytics.window", http.StatusNotFound)
+            return
+        }
+        var since time.Time
+        if v := r.URL.Query().Get("since"); v != "" {
+            var err error
+            if since, err = time.Parse(time.RFC3339, v); err != nil {
+                http.Error(w, "invalid since", http.StatusBadRequest)
+                return
+            }
+        }
+        tags, err := tagset.Parse(r.URL.Query().Get("tags"))
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, s.analytics.Reports(since, tags))
+    })
+    mux.HandleFunc("GET /v1/doctor", func(w http.ResponseWriter, r *http.Request) {
+        writeJSON(w, http.StatusOK, doctor.Run(r.Context(), doctor.DefaultCheckTimeout, doctorChecks(cfg, s)...))
+    })
+    mux.HandleFunc("GET /v1/revocations/filter", func(w http.ResponseWriter, r *http.Request) {
+        if s.revocationFilter == nil {
+            http.Error(w, "revocation filter is only used with a SQL backend", http.StatusNotFound)
+            return
+        }
+        writeJSON(w, http.StatusOK, s.revocationFilter.Stats())
+    })
+
+    return adminDPoP(cfg, s, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if !constantTimeEqual(credential(r), cfg.AdminToken) {
+            http.Error(w, "admin token required", http.StatusUnauthorized)
+            return
+        }
+        mux.ServeHTTP(w, r)
+    })), nil
+}
+
+// record appends to the audit log; the shared admin token has no per-user identity, so the
+// actor is taken from the optional Volly-Actor header set by the calling tool
+func record(r *http.Request, s *stores, action, tenant, target string) {
+    actor := r.Header.Get("Volly-Actor")
+    if actor == "" {
+        actor = "admin"
+    }
+    e := audit.Entry{Actor: actor, Action: action, Tenant: tenant, Target: target,
+        Detail: map[string]string{"remoteAddr": r.RemoteAddr}}
+    if err := s.audit.Append(r.Context(), e); err != nil {
+        log.Printf("audit append failed: %v", err)
+    }
+}
+
+func snapshotKey(cfg *config) (*secure.SecureBytes, error) {
+    if cfg.SnapshotKey == "" {
+        return nil, errors.New("VOLLY_SNAPSHOT_KEY is not set")
+    }
+    raw, err := base64.StdEncoding.DecodeString(cfg.SnapshotKey)
+    if err != nil || len(raw) != 32 {
+        return nil, snapshot.ErrNoSnapshotKey
+    }
+    return secure.FromBytes(raw)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net/http"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/anomaly"
+    "github.com/volly-org/volly-signaling/pkg/volly/audit"
+    "github.com/volly-org/volly-signaling/pkg/volly/events"
+    "github.com/volly-org/volly-signaling/pkg/volly/revocation"
+)
+
+// eventAnomaly is published on the bus for every finding acted on
+const eventAnomaly = "anomaly.detected"
+
+// newAnomaly builds the detectors cfg.Anomaly names, nil when there are none. Every finding
+// is logged, audited and published; step-up findings make the identity prove itself at
+// POST /v1/admit, and revoke findings revoke all its tokens
+func newAnomaly(cfg *config, s *stores) (*anomaly.Monitor, error) {
+    a := cfg.Anomaly
+    var detectors []anomaly.Detector
+    if a.Enabled {
+        h := anomaly.NewHeuristics()
+        if a.SharedTokenAddresses > 0 || a.SharedTokenWindow.Duration > 0 {
+            addresses, window := a.SharedTokenAddresses, a.SharedTokenWindow.Duration
+            if addresses <= 0 {
+                addresses = anomaly.DefaultSharedTokenAddresses
+            }
+            if window <= 0 {
+                window = anomaly.DefaultSharedTokenWindow
+            }
+            h.SetSharedToken(addresses, window)
+        }
+        if a.IssuanceBurst > 0 || a.IssuanceWindow.Duration > 0 {
+            n, window := a.IssuanceBurst, a.IssuanceWindow.Duration
+            if n <= 0 {
+                n = anomaly.DefaultIssuanceBurst
+            }
+            if window <= 0 {
+                window = anomaly.DefaultIssuanceWindow
+            }
+            h.SetIssuanceBurst(n, window)
+        }
+        if a.MaxTravelSpeed > 0 {
+            h.SetImpossibleTravel(a.MaxTravelSpeed, anomaly.DefaultMinDistance)
+        }
+        detectors = append(detectors, h)
+    }
+    if a.Remote != "" {
+        r := anomaly.NewRemote(a.Remote).SetToken(cfg.AnomalyToken)
+        if d := a.RemoteTimeout.Duration; d > 0 {
+            r.SetTimeout(d)
+        }
+        detectors = append(detectors, r)
+    }
+    if len(detectors) == 0 {
+        return nil, nil
+    }
+
+    m := anomaly.NewMonitor(detectors...)
+    for rule, action := range a.Actions {
+        switch action {
+        case anomaly.ActionAlert, anomaly.ActionRevoke:
+        case anomaly.ActionStepUp:
+            if s.passkeys == nil {
+                return nil, fmt.Errorf("anomaly.actions.%s is step-up, which needs webauthn.rpId", rule)
+            }
+        default:
+            return nil, fmt.Errorf("anomaly.actions.%s: unknown action %q", rule, action)
+        }
+        m.SetAction(rule, action)
+    }
+    if d := a.StepUpTTL.Duration; d > 0 {
+        m.SetStepUpTTL(d)
+    }
+    if d := a.Cooldown.Duration; d > 0 {
+        m.SetCooldown(d)
+    }
+    m.SetAlertHook(func(ctx context.Context, f anomaly.Finding) error {
+        log.Printf("anomaly: %s for %s in tenant %s (%s): %s", f.Rule, s.redactor.Identity(f.Identity), f.Tenant, f.Action, f.Detail)
+        detail := map[string]string{"action": string(f.Action), "detail": f.Detail}
+        if f.TokenID != "" {
+            detail["token"] = f.TokenID
+        }
+        s.bus.Publish(ctx, events.Event{Type: eventAnomaly, Identity: f.Identity, Data: map[string]string{
+            "tenant": f.Tenant, "rule": f.Rule, "action": string(f.Action)}})
+        return s.audit.Append(ctx, audit.Entry{Actor: "volly", Action: "anomaly." + f.Rule, Tenant: f.Tenant, Target: f.Identity, Detail: detail})
+    })
+    m.SetRevokeHook(func(ctx context.Context, f anomaly.Finding) error {
+        return s.revocations.RevokeWhere(ctx, revocation.Predicate{Tenant: f.Tenant, Identity: f.Identity})
+    })
+    return m, nil
+}
+
+// observe hands e to anomaly detection, if it is on
+func observe(ctx context.Context, s *stores, e anomaly.Event) {
+    if s.anomaly != nil {
+        s.anomaly.Observe(ctx, e)
+    }
+}
+
+// anomalyRoutes lists the latest findings acted on
+func anomalyRoutes(mux *http.ServeMux, s *stores) {
+    mux.HandleFunc("GET /v1/anomalies", func(w http.ResponseWriter, r *http.Request) {
+        if s.anomaly == nil {
+            http.Error(w, "anomaly detection is off; set anomaly.enabled or anomaly.remote", http.StatusNotFound)
+            return
+        }
+        writeJSON(w, http.StatusOK, s.anomaly.Recent())
+    })
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/bundle"
+)
+
+// configBundle keeps the config store in step with the signed bundle at cfg.Bundle.Path
+type configBundle struct {
+    path   string
+    loader *bundle.Loader
+
+    mu       sync.Mutex
+    last     []byte
+    summary  *bundle.Summary
+    loadedAt time.Time
+    lastErr  error
+}
+
+// newConfigBundle applies the bundle cfg.Bundle names, nil when it names none. A bundle
+// that does not verify, or is older than one applied before, stops startup rather than
+// leaving the process on whatever the store held
+func newConfigBundle(ctx context.Context, cfg *config, s *stores) (*configBundle, error) {
+    c := cfg.Bundle
+    if c.Path == "" {
+        return nil, nil
+    }
+    if len(c.PublicKey) == 0 {
+        return nil, errors.New("bundle.publicKey is required with bundle.path")
+    }
+    versionFile := c.VersionFile
+    if versionFile == "" {
+        versionFile = c.Path + ".version"
+    }
+    b := &configBundle{
+        path:   c.Path,
+        loader: bundle.NewLoader(c.Algorithm, c.PublicKey, bundle.FileVersion(versionFile), s.config),
+    }
+    if _, err := b.reload(ctx); err != nil {
+        return nil, fmt.Errorf("bundle %s: %w", c.Path, err)
+    }
+    if d := c.ReloadInterval.Duration; d > 0 {
+        go b.run(ctx, d)
+    }
+    return b, nil
+}
+
+// reload applies the bundle file if it changed since the last load
+func (b *configBundle) reload(ctx context.Context) (*bundle.Summary, error) {
+    data, err := os.ReadFile(b.path)
+    if err != nil {
+        return nil, err
+    }
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if b.summary != nil && bytes.Equal(data, b.last) {
+        return b.summary, nil
+    }
+    summary, err := b.loader.Load(ctx, data)
+    b.lastErr = err
+    if err != nil {
+        return nil, err
+    }
+    b.last, b.summary, b.loadedAt = data, summary, time.Now()
+    return summary, nil
+}
+
+// run picks up bundles the operator drops in place. A bad bundle is logged and the last
+// good one stays applied
+func (b *configBundle) run(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            prev := b.version()
+            summary, err := b.reload(ctx)
+            if err != nil {
+                log.Printf("bundle: %s: %v", b.path, err)
+                continue
+            }
+            if summary.Version != prev {
+                log.Printf("bundle: applied version %d from %s", summary.Version, b.path)
+            }
+        }
+    }
+}
+
+func (b *configBundle) version() uint64 {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if b.summary == nil {
+        return 0
+    }
+    return b.summary.Version
+}
+
+type bundleStatus struct {
+    Path     string          `json:"path"`
+    Applied  *bundle.Summary `json:"applied"`
+    LoadedAt time.Time       `json:"loadedAt"`
+    // Error is why the file on disk was last refused, if it was
+    Error string `json:"error,omitempty"`
+}
+
+func (b *configBundle) status() bundleStatus {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    st := bundleStatus{Path: b.path, Applied: b.summary, LoadedAt: b.loadedAt}
+    if b.lastErr != nil {
+        st.Error = b.lastErr.Error()
+    }
+    return st
+}
+
+// bundleRoutes shows the applied bundle and reloads it on demand. Tenants, keys and room
+// policies written through the admin API are replaced by the next bundle applied
+func bundleRoutes(mux *http.ServeMux, s *stores) {
+    mux.HandleFunc("GET /v1/bundle", func(w http.ResponseWriter, r *http.Request) {
+        if s.bundle == nil {
+            http.Error(w, "config bundles are off; set bundle.path", http.StatusNotFound)
+            return
+        }
+        writeJSON(w, http.StatusOK, s.bundle.status())
+    })
+    mux.HandleFunc("POST /v1/bundle/reload", func(w http.ResponseWriter, r *http.Request) {
+        if s.bundle == nil {
+            http.Error(w, "config bundles are off; set bundle.path", http.StatusNotFound)
+            return
+        }
+        summary, err := s.bundle.reload(r.Context())
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        record(r, s, "bundle.reload", "", fmt.Sprintf("version=%d", summary.Version))
+        writeJSON(w, http.StatusOK, summary)
+    })
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/base64"
+    "errors"
+    "time"
+
+    lkauth "github.com/livekit/protocol/auth"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/canary"
+    "github.com/volly-org/volly-signaling/pkg/volly/configstore"
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+)
+
+var errNoCanaryKey = errors.New("no active API key to issue canary tokens with")
+
+// canaryPath issues like tokend, verifies like the gateway, grant cache included, and revokes
+// like the admin API, so the canary fails wherever a real join would
+type canaryPath struct {
+    cfg *config
+    s   *stores
+}
+
+func (p canaryPath) Issue(ctx context.Context, identity string, ttl time.Duration) (string, string, time.Time, error) {
+    // Looked up every run, so a rotated key is picked up and a retired one is noticed
+    var key configstore.APIKey
+    var err error
+    if p.cfg.Canary.APIKey != "" {
+        key, err = activeKey(ctx, p.s, p.cfg.Canary.APIKey)
+    } else {
+        key, err = doctorKey(ctx, p.cfg, p.s)
+    }
+    if err != nil {
+        return "", "", time.Time{}, err
+    }
+    if key.ID == "" {
+        return "", "", time.Time{}, errNoCanaryKey
+    }
+    pq, err := crypto.GenerateMLKEM768KeyPair()
+    if err != nil {
+        return "", "", time.Time{}, err
+    }
+    b := make([]byte, 16)
+    rand.Read(b)
+    jti := "canary-" + base64.RawURLEncoding.EncodeToString(b)
+    at := accessToken(p.s, key).
+        AddGrant(&auth.VollyVideoGrant{VideoGrant: lkauth.VideoGrant{RoomJoin: true, Room: identity}}).
+        SetIdentity(identity).
+        SetTenant(key.Tenant).
+        SetProvenance(tokendProvenance(auth.ProviderAPIKey)).
+        SetTokenID(jti).
+        SetValidFor(ttl).
+        SetPostQuantumKey(pq.PublicKey, pq.Algorithm).
+        SetIssuanceCheck(p.s.killSwitch.CheckGrant)
+    token, err := scopeToken(p.cfg, at, key.Tenant).ToJWT()
+    return token, jti, time.Now().Add(ttl), err
+}
+
+func (p canaryPath) Verify(ctx context.Context, token string) error {
+    _, err := verifyToken(ctx, p.cfg, p.s, token)
+    return err
+}
+
+func (p canaryPath) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+    return p.s.revocations.Revoke(ctx, jti, expiresAt)
+}
+
+func newCanary(cfg *config, s *stores) *canary.Canary {
+    c := canary.New(canaryPath{cfg: cfg, s: s}).SetInterval(cfg.Canary.Interval.Duration)
+    if cfg.Canary.Threshold > 0 {
+        c.SetThreshold(cfg.Canary.Threshold)
+    }
+    return c
+}
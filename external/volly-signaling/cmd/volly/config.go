@@ -0,0 +1,926 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "runtime"
+    "time"
+
+    lkauth "github.com/livekit/protocol/auth"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/anomaly"
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+    "github.com/volly-org/volly-signaling/pkg/volly/directory"
+    "github.com/volly-org/volly-signaling/pkg/volly/doctor"
+    "github.com/volly-org/volly-signaling/pkg/volly/gateway"
+    "github.com/volly-org/volly-signaling/pkg/volly/issuance"
+    "github.com/volly-org/volly-signaling/pkg/volly/matrix"
+    "github.com/volly-org/volly-signaling/pkg/volly/presence"
+    "github.com/volly-org/volly-signaling/pkg/volly/protocol"
+    "github.com/volly-org/volly-signaling/pkg/volly/redact"
+    "github.com/volly-org/volly-signaling/pkg/volly/saml"
+    "github.com/volly-org/volly-signaling/pkg/volly/sqlstore"
+    "github.com/volly-org/volly-signaling/pkg/volly/websocket"
+)
+
+// config is shared by every subcommand so one ConfigMap can drive a whole Helm release.
+// Secrets come from the environment rather than the file
+type config struct {
+    Tokend    listenConfig `json:"tokend"`
+    Gateway   listenConfig `json:"gateway"`
+    Keyserver listenConfig `json:"keyserver"`
+    Admin     listenConfig `json:"admin"`
+    Proxy     proxyConfig  `json:"proxy"`
+
+    Database databaseConfig `json:"database"`
+
+    // MaxTokenTTL caps the ttl callers may request from tokend
+    MaxTokenTTL duration `json:"maxTokenTTL"`
+    // CanonicalWindow makes tokend return the same token for identical requests within each
+    // window so join responses can be cached; zero issues a unique token per request
+    CanonicalWindow duration `json:"canonicalWindow,omitempty"`
+    // Rollout ramps token features to a percentage of identities, chosen by identity hash,
+    // e.g. {"canonical": 10}. GET /v1/rollout splits issuance and admission counts by cohort
+    Rollout map[string]float64 `json:"rollout,omitempty"`
+    // Issuer names this deployment: tokend stamps it into every token and the gateway admits
+    // only tokens that carry it. Turning it on rejects outstanding tokens until they expire
+    Issuer string `json:"issuer,omitempty"`
+    // Audience is stamped into issued tokens and required on verification, so a staging
+    // token is refused in production even where API keys are shared. TenantAudiences
+    // overrides it by tenant ID
+    Audience        string            `json:"audience,omitempty"`
+    TenantAudiences map[string]string `json:"tenantAudiences,omitempty"`
+    // Provenance is the token provenance the gateway accepts, e.g. only tokens from
+    // volly-tokend whose holder signed in with a passkey or SAML
+    Provenance auth.ProvenancePolicy `json:"provenance"`
+    // ExpiryJitter shortens each token tokend issues by up to this much, so a large room's
+    // tokens do not all expire and refresh in the same second
+    ExpiryJitter duration `json:"expiryJitter,omitempty"`
+    // NotBeforeGrace backdates nbf in issued tokens, for gateways whose clocks lag tokend's
+    NotBeforeGrace duration `json:"notBeforeGrace,omitempty"`
+    // NotBeforeLeeway is how far ahead of the gateway's clock a token's nbf or iat may be
+    NotBeforeLeeway duration `json:"notBeforeLeeway"`
+    // Roles adds or replaces the grant templates behind tokend's role field, over the built-in
+    // host, cohost, speaker, viewer and recorder; TenantRoles does the same for one tenant
+    Roles       map[string]lkauth.VideoGrant            `json:"roles,omitempty"`
+    TenantRoles map[string]map[string]lkauth.VideoGrant `json:"tenantRoles,omitempty"`
+    // CryptoProfile is the cryptography every tenant's tokens must use; TenantCryptoProfiles
+    // hold tenants to stricter ones, such as a government tenant that requires ML-KEM-1024
+    // and short-lived tokens. A tenant profile weaker than the default is refused at startup.
+    // tokend and the gateway both enforce them
+    CryptoProfile        cryptoProfileConfig            `json:"cryptoProfile"`
+    TenantCryptoProfiles map[string]cryptoProfileConfig `json:"tenantCryptoProfiles,omitempty"`
+    // TenantClaims registers, by tenant ID, the claims tokens of the tenant may carry as
+    // volly.<tenant>.<name>. tokend and the gateway both check tokens against them
+    TenantClaims map[string]map[string]auth.ClaimSchema `json:"tenantClaims,omitempty"`
+    // ClaimTransforms reshape verified grants, in order, before the gateway checks their
+    // claims or hands them on, so tokens from older issuers look like current ones
+    ClaimTransforms []claimTransformConfig `json:"claimTransforms,omitempty"`
+    // WASMPlugins are policy modules run as admission hooks and, after ClaimTransforms, as
+    // claim transformers
+    WASMPlugins []wasmPluginConfig `json:"wasmPlugins,omitempty"`
+    // AllowLegacyTokens lets the gateway admit tokens without PQ claims
+    AllowLegacyTokens bool `json:"allowLegacyTokens"`
+    // AllowViewerTokens lets tokend issue and the gateway admit subscribe-only viewer tokens
+    AllowViewerTokens bool `json:"allowViewerTokens"`
+    // ClaimLimits bound the tokens the gateway will parse; zero fields are unlimited
+    ClaimLimits auth.ClaimLimits `json:"claimLimits"`
+    // TokenBudget bounds the tokens tokend signs: it logs the biggest claims of tokens over
+    // warnBytes and refuses those over maxBytes; zero fields are unlimited
+    TokenBudget auth.TokenBudget `json:"tokenBudget"`
+    // Connections caps concurrent gateway connections; zero caps are unlimited
+    Connections connectionsConfig `json:"connections"`
+    // Sessions caps each identity's concurrent gateway sessions; off by default
+    Sessions sessionsConfig `json:"sessions"`
+    // Geo places client addresses in countries for tokens restricted to allowedCountries
+    Geo geoConfig `json:"geo"`
+    // WebSocket tunes the gateway's room event streams
+    WebSocket websocketConfig `json:"websocket"`
+    // Canary continuously issues, verifies and revokes a throwaway token; off by default
+    Canary canaryConfig `json:"canary"`
+    // Redaction says how identities, client addresses and audit metadata are redacted in log
+    // output and in GET /v1/audit?redact=true; by default nothing is. Hashes are keyed by
+    // VOLLY_REDACTION_KEY, which instances must share for them to match
+    Redaction redact.Policy `json:"redaction"`
+    // Retention prunes old records; a store without a window keeps them forever
+    Retention retentionConfig `json:"retention"`
+    // Usage keeps the daily counts billing reports are built from
+    Usage usageConfig `json:"usage"`
+    // AuditAnchors tunes how the audit chain head is signed, which VOLLY_AUDIT_SIGNING_KEY
+    // turns on
+    AuditAnchors auditAnchorsConfig `json:"auditAnchors"`
+    // Flags asks a feature flag service about per-tenant and per-room features; see
+    // flagsConfig
+    Flags flagsConfig `json:"flags"`
+    // ShadowVerify also checks verified tokens against a candidate signing algorithm, for
+    // metrics only; off by default
+    ShadowVerify shadowVerifyConfig `json:"shadowVerify"`
+    // Bundle loads tenants, API keys and room policies from a signed file instead of
+    // managing them through the admin API, for air-gapped sites
+    Bundle bundleConfig `json:"bundle"`
+    // Analytics aggregates gateway joins, sessions and refusals into k-anonymous reports
+    Analytics analyticsConfig `json:"analytics"`
+    // Elevation lets tokend hand out short-lived elevated tokens behind a second factor
+    Elevation elevationConfig `json:"elevation"`
+    // Delegation lets services exchange a user's token for one that also names them, at
+    // POST /v1/on-behalf-of
+    Delegation delegationConfig `json:"delegation"`
+    // Issuance lets internal services get tokens from tokend by client certificate instead
+    // of an API secret
+    Issuance issuanceConfig `json:"issuance"`
+    // HSM keeps the secrets of some API keys on a hardware security module, where their
+    // tokens are signed and verified
+    HSM hsmConfig `json:"hsm"`
+    // RaiseHand tunes the hands participants raise to ask a host for publish rights
+    RaiseHand raiseHandConfig `json:"raiseHand"`
+    // WebAuthn is the passkey relying party behind passkey login and step-up
+    WebAuthn webAuthnConfig `json:"webauthn"`
+    // SAML lets enterprise users log in through their IdP and get tokens from tokend
+    SAML samlConfig `json:"saml"`
+    // Matrix lets Matrix users, e.g. from Element, get tokens with an OpenID token from their
+    // homeserver at POST /v1/matrix/token
+    Matrix matrixConfig `json:"matrix"`
+    // Directory puts tenants under SCIM provisioning or an LDAP poll
+    Directory directoryConfig `json:"directory"`
+    // StepUp makes the gateway demand fresh WebAuthn proof before sensitive room actions
+    StepUp stepUpConfig `json:"stepUp"`
+    // Anomaly watches issuance and admission for stolen or shared credentials; off by default
+    Anomaly anomalyConfig `json:"anomaly"`
+    // RoomPIN tunes PIN attempts at admission; rooms ask for PINs once VOLLY_PIN_KEY is set
+    RoomPIN roomPINConfig `json:"roomPIN"`
+    // Extensions are plugin binaries that replace the revocation store or verify client
+    // attestations
+    Extensions extensionsConfig `json:"extensions"`
+    // RoomHooks call deployment services before a room opens, before each join and after
+    // each leave
+    RoomHooks roomHooksConfig `json:"roomHooks"`
+    // Federation describes this deployment to peers and discovers peers from their domains
+    Federation federationConfig `json:"federation"`
+    // Decoys tunes what happens when a decoy token from POST /v1/decoys is presented
+    Decoys decoysConfig `json:"decoys"`
+    // Doctor tunes the checks behind GET /v1/doctor
+    Doctor doctorConfig `json:"doctor"`
+    // DPoP checks proof-of-possession headers (RFC 9449) on tokend and admin requests
+    DPoP dpopConfig `json:"dpop"`
+    // VerifyWorkers is how many token verifications run at once, one per CPU by default
+    VerifyWorkers int `json:"verifyWorkers,omitempty"`
+    // SyncInterval is how often kill switch state is resynced from the store
+    SyncInterval    duration `json:"syncInterval"`
+    ShutdownTimeout duration `json:"shutdownTimeout"`
+
+    // Bootstrap seeds one tenant and API key into an empty config store, read from
+    // VOLLY_TENANT, VOLLY_API_KEY and VOLLY_API_SECRET
+    Bootstrap bootstrapConfig `json:"-"`
+    // AdminToken authenticates admin callers, read from VOLLY_ADMIN_TOKEN
+    AdminToken string `json:"-"`
+    // SnapshotKey seals state exports, read from VOLLY_SNAPSHOT_KEY (base64, 32 bytes)
+    SnapshotKey string `json:"-"`
+    // TicketKey seals session resumption tickets, read from VOLLY_TICKET_KEY (base64, 32 bytes).
+    // Gateways behind one load balancer must share it; unset, each process picks its own
+    TicketKey string `json:"-"`
+    // StepUpKey signs step-up challenges and receipts, read from VOLLY_STEPUP_KEY (base64,
+    // 32 bytes). Like TicketKey, gateways behind one load balancer must share it
+    StepUpKey string `json:"-"`
+    // WebAuthnKey signs ceremony challenges and derives passkey user handles, read from
+    // VOLLY_WEBAUTHN_KEY (base64, 32 bytes). It must be set with webauthn.rpId and stay the
+    // same, or registered passkeys stop matching their users
+    WebAuthnKey string `json:"-"`
+    // SAMLKey signs AuthnRequest IDs, read from VOLLY_SAML_KEY (base64, 32 bytes). tokend
+    // instances behind one load balancer must share it, or a login started at one fails at
+    // another
+    SAMLKey string `json:"-"`
+    // ErasureKey signs erasure receipts and derives the tombstones that replace erased
+    // identities, read from VOLLY_ERASURE_KEY (base64, 32 bytes). Erasure is off without it
+    ErasureKey string `json:"-"`
+    // RedactionKey keys redaction hashes, read from VOLLY_REDACTION_KEY (base64, 32 bytes)
+    RedactionKey string `json:"-"`
+    // UsageSigningKey is the Ed25519 seed usage reports are signed with, read from
+    // VOLLY_USAGE_SIGNING_KEY (base64, 32 bytes). Usage metering is off without it
+    UsageSigningKey string `json:"-"`
+    // AuditSigningKey is the ML-DSA-65 seed audit chain anchors are signed with, read from
+    // VOLLY_AUDIT_SIGNING_KEY (base64, 32 bytes). The log is chained either way; without
+    // it nothing is anchored, so rewriting the newest entries goes unnoticed
+    AuditSigningKey string `json:"-"`
+    // PINKey keys room PIN hashes, read from VOLLY_PIN_KEY (base64, 32 bytes). tokend and
+    // every gateway must share it, and it must stay the same or every pinHash stops matching
+    PINKey string `json:"-"`
+    // FlagsToken is sent as a bearer token to the flag service, read from VOLLY_FLAGS_TOKEN
+    FlagsToken string `json:"-"`
+    // AnomalyToken is sent as a bearer token to anomaly.remote, read from VOLLY_ANOMALY_TOKEN
+    AnomalyToken string `json:"-"`
+}
+
+// roles builds the role registry from the built-in roles and the configured ones
+func (c *config) roles() *auth.Roles {
+    r := auth.NewRoles()
+    for name, tmpl := range c.Roles {
+        r.Define(name, tmpl)
+    }
+    for tenant, roles := range c.TenantRoles {
+        for name, tmpl := range roles {
+            r.Override(tenant, name, tmpl)
+        }
+    }
+    return r
+}
+
+// claimSchemas builds the tenant claim registry from TenantClaims
+func (c *config) claimSchemas() (*auth.ClaimSchemas, error) {
+    schemas := auth.NewClaimSchemas()
+    for tenant, claims := range c.TenantClaims {
+        for name, schema := range claims {
+            if err := schemas.Register(tenant, name, schema); err != nil {
+                return nil, err
+            }
+        }
+    }
+    return schemas, nil
+}
+
+// cryptoProfiles builds the default and tenant crypto profiles
+func (c *config) cryptoProfiles() (*auth.CryptoProfiles, error) {
+    profiles := auth.NewCryptoProfiles(c.CryptoProfile.profile())
+    for tenant, p := range c.TenantCryptoProfiles {
+        if err := profiles.SetTenant(tenant, p.profile()); err != nil {
+            return nil, err
+        }
+    }
+    return profiles, nil
+}
+
+// claimTransformers builds the transformer chain from ClaimTransforms. A role that does not
+// exist is refused here rather than failing every verification
+func (c *config) claimTransformers(roles *auth.Roles) (auth.ClaimTransformers, error) {
+    var chain auth.ClaimTransformers
+    for _, tc := range c.ClaimTransforms {
+        var steps auth.ClaimTransformers
+        for from, to := range tc.Rename {
+            steps = append(steps, auth.RenameClaim(from, to))
+        }
+        if len(tc.Defaults) > 0 {
+            defaults, err := auth.DefaultClaims(tc.Defaults)
+            if err != nil {
+                return nil, err
+            }
+            steps = append(steps, defaults)
+        }
+        for claim, values := range tc.Roles {
+            for value, role := range values {
+                if _, err := roles.Template(tc.Tenant, role); err != nil {
+                    return nil, fmt.Errorf("claimTransforms: %w: %q", err, role)
+                }
+                steps = append(steps, auth.ClaimRole(roles, claim, value, role))
+            }
+        }
+        if tc.Tenant == "" {
+            chain = append(chain, steps)
+        } else {
+            chain = append(chain, auth.ForTenant(tc.Tenant, steps))
+        }
+    }
+    return chain, nil
+}
+
+// audience is what tokens of tenant are issued for and verified against
+func (c *config) audience(tenant string) string {
+    if aud, ok := c.TenantAudiences[tenant]; ok {
+        return aud
+    }
+    return c.Audience
+}
+
+type listenConfig struct {
+    Listen string `json:"listen"`
+}
+
+// cryptoProfileConfig is an auth.CryptoProfile; zero fields are unchecked
+type cryptoProfileConfig struct {
+    RequirePQ              bool     `json:"requirePQ,omitempty"`
+    PQAlgorithms           []string `json:"pqAlgorithms,omitempty"`
+    ConfirmationAlgorithms []string `json:"confirmationAlgorithms,omitempty"`
+    MaxTTL                 duration `json:"maxTTL,omitempty"`
+    MaxPQKeyLifetime       duration `json:"maxPQKeyLifetime,omitempty"`
+}
+
+func (c cryptoProfileConfig) profile() auth.CryptoProfile {
+    return auth.CryptoProfile{
+        RequirePQ:              c.RequirePQ,
+        PQAlgorithms:           c.PQAlgorithms,
+        ConfirmationAlgorithms: c.ConfirmationAlgorithms,
+        MaxTTL:                 c.MaxTTL.Duration,
+        MaxPQKeyLifetime:       c.MaxPQKeyLifetime.Duration,
+    }
+}
+
+// claimTransformConfig reshapes the grants of one tenant, or of every tenant when Tenant is
+// empty: claims are renamed first, then defaults filled in, then roles merged
+type claimTransformConfig struct {
+    Tenant string `json:"tenant,omitempty"`
+    // Rename maps old tenant claim names to current ones
+    Rename map[string]string `json:"rename,omitempty"`
+    // Defaults are set on grants without the claim
+    Defaults map[string]interface{} `json:"defaults,omitempty"`
+    // Roles merges a role into grants whose claim has a value, e.g. {"plan": {"pro": "speaker"}}
+    Roles map[string]map[string]string `json:"roles,omitempty"`
+}
+
+// wasmPluginConfig is one WASM policy module. Timeout is 100ms and MemoryPages 256 by
+// default; a plugin that traps or overruns refuses the join or verification unless
+// FailOpen. The file is checked for a new version every ReloadInterval, 5s by default
+type wasmPluginConfig struct {
+    Name           string   `json:"name,omitempty"`
+    Path           string   `json:"path"`
+    Timeout        duration `json:"timeout,omitempty"`
+    MemoryPages    uint32   `json:"memoryPages,omitempty"`
+    FailOpen       bool     `json:"failOpen,omitempty"`
+    ReloadInterval duration `json:"reloadInterval,omitempty"`
+}
+
+// databaseConfig selects the SQL backend; without a DSN every store is in memory
+type databaseConfig struct {
+    // Dialect is sqlite or postgres; Driver overrides the database/sql driver name
+    Dialect         string   `json:"dialect"`
+    Driver          string   `json:"driver,omitempty"`
+    MaxOpenConns    int      `json:"maxOpenConns,omitempty"`
+    MaxIdleConns    int      `json:"maxIdleConns,omitempty"`
+    ConnMaxLifetime duration `json:"connMaxLifetime,omitempty"`
+    // Migrate applies pending schema migrations at startup
+    Migrate bool `json:"migrate"`
+
+    // Envelope seals secrets under rotating data keys wrapped by a KMS key rather than
+    // directly under SealKey
+    Envelope envelopeConfig `json:"envelope"`
+
+    // DSN and SealKey are read from VOLLY_DATABASE_DSN and VOLLY_SEAL_KEY (base64, 32 bytes).
+    // SealKeyPrevious, from VOLLY_SEAL_KEY_PREVIOUS, still unwraps data keys after SealKey
+    // is rotated, until they are rewrapped
+    DSN             string `json:"-"`
+    SealKey         string `json:"-"`
+    SealKeyPrevious string `json:"-"`
+}
+
+// envelopeConfig picks the KMS that wraps data keys
+type envelopeConfig struct {
+    // KMS is "local", wrapping under VOLLY_SEAL_KEY, or "vault" for a Vault transit key;
+    // empty seals directly under VOLLY_SEAL_KEY as before envelopes
+    KMS string `json:"kms,omitempty"`
+    // VaultAddress, VaultKey and VaultMount (transit by default) name the transit key;
+    // the token is read from VOLLY_VAULT_TOKEN
+    VaultAddress string `json:"vaultAddress,omitempty"`
+    VaultKey     string `json:"vaultKey,omitempty"`
+    VaultMount   string `json:"vaultMount,omitempty"`
+    VaultToken   string `json:"-"`
+    // DataKeyLifetime is how long a data key seals new secrets, 30 days by default
+    DataKeyLifetime duration `json:"dataKeyLifetime,omitempty"`
+    // ResealInterval is how often secrets under older keys are resealed, hourly by default
+    ResealInterval duration `json:"resealInterval,omitempty"`
+}
+
+// connectionsConfig caps gateway connections. Connections past a tenant or IP cap are
+// rejected at once; past the instance cap they queue, served one tenant at a time in turn
+type connectionsConfig struct {
+    gateway.ConnectionLimits
+    // TenantLimits overrides MaxPerTenant by tenant ID
+    TenantLimits map[string]int `json:"tenantLimits,omitempty"`
+    MaxQueue     int            `json:"maxQueue,omitempty"`
+    QueueTimeout duration       `json:"queueTimeout,omitempty"`
+    // LeaseTTL frees a connection's slot unless the media edge renews it within the ttl
+    LeaseTTL duration `json:"leaseTTL,omitempty"`
+}
+
+// sessionsConfig caps the gateway sessions one identity holds at once within its tenant.
+// Sessions are counted on the instance that admitted them, so behind a load balancer the
+// cap holds per instance
+type sessionsConfig struct {
+    // MaxPerIdentity is the cap; zero is unlimited
+    MaxPerIdentity int `json:"maxPerIdentity,omitempty"`
+    // Evict is "newest" to refuse a join over the cap, the default, or "oldest" to end the
+    // identity's oldest sessions instead
+    Evict presence.EvictionPolicy `json:"evict,omitempty"`
+    // TenantLimits overrides MaxPerIdentity by tenant ID
+    TenantLimits map[string]int `json:"tenantLimits,omitempty"`
+}
+
+// geoConfig places client addresses in countries. Without it the gateway still enforces
+// deniedCIDRs, but refuses every token restricted to allowedCountries
+type geoConfig struct {
+    // Networks maps CIDRs to ISO 3166-1 alpha-2 country codes; the longest match wins
+    Networks map[string]string `json:"networks,omitempty"`
+}
+
+// hsmConfig signs and verifies the tokens of the API keys in Keys with HMAC keys on an HSM
+// rather than with their secrets in the config store, which are then never used
+type hsmConfig struct {
+    // Module names the PKCS#11 binding, registered with hsm.RegisterModule by a driver
+    // compiled in under its own build tag
+    Module string `json:"module,omitempty"`
+    // Slots hold replicas of the keys, in order of preference
+    Slots []string `json:"slots,omitempty"`
+    // MaxSessions bounds the sessions open on each slot
+    MaxSessions int `json:"maxSessions,omitempty"`
+    // Keys maps API key IDs to the labels of their keys on the HSM
+    Keys map[string]string `json:"keys,omitempty"`
+}
+
+// websocketConfig tunes permessage-deflate and session resumption on WebSocket streams;
+// clients choose JSON or CBOR frames themselves
+type websocketConfig struct {
+    DisableCompression bool `json:"disableCompression,omitempty"`
+    // CompressionThreshold leaves shorter messages uncompressed
+    CompressionThreshold int `json:"compressionThreshold,omitempty"`
+    // TicketTTL is how long a disconnected client may resume without its token
+    TicketTTL duration `json:"ticketTTL"`
+}
+
+// proxyConfig points the recording proxy at a gateway
+type proxyConfig struct {
+    Listen string `json:"listen"`
+    // Upstream is the gateway's base URL
+    Upstream string `json:"upstream"`
+    // Dir receives one JSON transcript per session
+    Dir string `json:"dir"`
+}
+
+// canaryConfig enables the token path canary. A failing canary fails /readyz in every
+// service of the process, so deployments with no API key yet should leave it off
+type canaryConfig struct {
+    // Interval between runs; zero disables the canary
+    Interval duration `json:"interval,omitempty"`
+    // Threshold is how many failed runs in a row fail readiness
+    Threshold int `json:"threshold,omitempty"`
+    // APIKey issues the canary tokens; by default the bootstrap key, else any active key
+    APIKey string `json:"apiKey,omitempty"`
+}
+
+// usageConfig tunes usage metering, which VOLLY_USAGE_SIGNING_KEY turns on
+type usageConfig struct {
+    // Retention is how long daily counts are kept, 400 days by default
+    Retention duration `json:"retention,omitempty"`
+}
+
+// auditAnchorsConfig tunes audit anchoring
+type auditAnchorsConfig struct {
+    // Interval is how often the chain head is signed, 10m by default
+    Interval duration `json:"interval,omitempty"`
+}
+
+// flagsConfig points at an OpenFeature flag service. Three flags are consulted: e2ee-required
+// at admission, viewer-tokens at issuance and admission, defaulting to allowViewerTokens, and
+// handshake-v2 on room event streams. Without a service, and whenever it fails, every flag
+// has its default and nothing changes
+type flagsConfig struct {
+    // OFREP is the base URL of an OpenFeature Remote Evaluation Protocol service
+    OFREP string `json:"ofrep,omitempty"`
+    // CacheTTL is how long an answer is reused, 5s by default
+    CacheTTL duration `json:"cacheTTL,omitempty"`
+    // Timeout bounds one evaluation, 250ms by default
+    Timeout duration `json:"timeout,omitempty"`
+}
+
+// shadowVerifyConfig describes the key set a migration would cut over to
+type shadowVerifyConfig struct {
+    // Algorithm is the candidate signature algorithm, e.g. ML-DSA-65; empty turns shadow
+    // verification off
+    Algorithm string `json:"algorithm,omitempty"`
+    // KeysFile holds candidate key pairs by API key as JSON, {"<api key>": {"Algorithm":
+    // ..., "PublicKey": <base64>, "PrivateKey": <base64>}}. Without it every API key gets a
+    // generated key, which stays in memory
+    KeysFile string `json:"keysFile,omitempty"`
+    // SampleRate is the fraction of tokens checked, all of them by default
+    SampleRate float64 `json:"sampleRate,omitempty"`
+    // Concurrency bounds checks in flight, 4 by default; tokens beyond it are not checked
+    Concurrency int `json:"concurrency,omitempty"`
+}
+
+// bundleConfig names a configuration bundle and the operator key it must be signed by
+type bundleConfig struct {
+    // Path is the bundle file; empty turns bundles off
+    Path string `json:"path,omitempty"`
+    // Algorithm is the operator key's signature algorithm, ML-DSA-65 by default
+    Algorithm string `json:"algorithm,omitempty"`
+    // PublicKey is the operator's public key, base64
+    PublicKey []byte `json:"publicKey,omitempty"`
+    // VersionFile records the highest version applied, Path + ".version" by default. It
+    // must survive restarts, or an older bundle could be rolled back to
+    VersionFile string `json:"versionFile,omitempty"`
+    // ReloadInterval is how often Path is checked for a new bundle; zero loads it at
+    // startup and on POST /v1/bundle/reload only
+    ReloadInterval duration `json:"reloadInterval,omitempty"`
+}
+
+// analyticsConfig enables GET /v1/analytics. Reports count per tenant and window; cells
+// covering fewer than KAnonymity participants are withheld
+type analyticsConfig struct {
+    // Window is how long each report covers; zero disables analytics
+    Window duration `json:"window,omitempty"`
+    // KAnonymity is 5 by default
+    KAnonymity int `json:"kAnonymity,omitempty"`
+    // Epsilon adds Laplace noise of scale 1/Epsilon to released counts; zero adds none
+    Epsilon float64 `json:"epsilon,omitempty"`
+    // Retention is how many windows are kept, 48 by default
+    Retention int `json:"retention,omitempty"`
+}
+
+// retentionConfig sets how long each prunable store keeps records. Windows below a store's
+// minimum are refused at startup: a week for audit, maxTokenTTL for revocations
+type retentionConfig struct {
+    Audit       duration `json:"audit,omitempty"`
+    Revocations duration `json:"revocations,omitempty"`
+    // Interval between prunes, an hour by default
+    Interval duration `json:"interval,omitempty"`
+    // DryRun logs and counts what would be pruned without removing it
+    DryRun bool `json:"dryRun,omitempty"`
+}
+
+// elevationConfig turns on POST /v1/elevate once Webhook names the MFA service that checks
+// second factors
+type elevationConfig struct {
+    Webhook string `json:"webhook,omitempty"`
+    // Permissions narrows what may be elevated to; roomAdmin and roomRecord by default
+    Permissions []string `json:"permissions,omitempty"`
+    MaxTTL      duration `json:"maxTTL,omitempty"`
+    // Secret signs webhook requests
+    Secret string `json:"-"`
+}
+
+// delegationConfig turns on POST /v1/on-behalf-of once Actors lists the services that may
+// act for users
+type delegationConfig struct {
+    // Actors are the client certificate or SPIFFE names, as issuance maps them, of the
+    // services that may act for users. Each calls with that certificate and a token issued to
+    // the same name and bound to its key
+    Actors []string `json:"actors,omitempty"`
+    // MaxTTL caps a delegation's lifetime, which never outlives the tokens it came from
+    MaxTTL duration `json:"maxTTL,omitempty"`
+}
+
+// issuanceConfig serves tokend over TLS with CertFile and KeyFile once Certificates maps a
+// client identity. A client certificate that verifies against ClientCAFile authenticates
+// POST /v1/token in place of basic auth, which callers without one keep using. Its URI or
+// DNS SAN picks a rule, whose tenant's tokens are signed under SigningKeys[tenant]; a
+// scope of room:<room> lets the caller issue for that room, with a trailing * matching any
+// suffix. SPIFFE authenticates mesh workloads by X.509 SVID instead
+type issuanceConfig struct {
+    CertFile     string                     `json:"certFile,omitempty"`
+    KeyFile      string                     `json:"keyFile,omitempty"`
+    ClientCAFile string                     `json:"clientCAFile,omitempty"`
+    Certificates []issuance.CertificateRule `json:"certificates,omitempty"`
+    SPIFFE       spiffeConfig               `json:"spiffe"`
+    // SigningKeys names the API key each tenant's tokens are signed under
+    SigningKeys map[string]string `json:"signingKeys,omitempty"`
+}
+
+// spiffeConfig serves tokend over TLS with its own SVID once Rules maps a SPIFFE ID. The
+// SVID, its key and the trust bundle are the files spire-agent or spiffe-helper keeps
+// current, reloaded as they rotate. A caller's SVID must verify against the bundle and name
+// a workload in TrustDomain; the most specific rule for its ID gives its tenant and scopes
+type spiffeConfig struct {
+    TrustDomain string                `json:"trustDomain,omitempty"`
+    CertFile    string                `json:"certFile,omitempty"`
+    KeyFile     string                `json:"keyFile,omitempty"`
+    BundleFile  string                `json:"bundleFile,omitempty"`
+    Rules       []issuance.SPIFFERule `json:"rules,omitempty"`
+}
+
+// anomalyConfig tunes anomaly detection. The built-in rules are shared-token, a token
+// admitted from too many addresses; issuance-burst, too many tokens issued for one
+// identity; and impossible-travel, an identity admitted at two places too far apart for the
+// time between, from the location the edge passes to POST /v1/admit
+type anomalyConfig struct {
+    // Enabled runs the built-in rules
+    Enabled bool `json:"enabled,omitempty"`
+    // SharedTokenAddresses is how many addresses one token may be admitted from within
+    // SharedTokenWindow, 3 in 10m by default
+    SharedTokenAddresses int      `json:"sharedTokenAddresses,omitempty"`
+    SharedTokenWindow    duration `json:"sharedTokenWindow,omitempty"`
+    // IssuanceBurst is how many tokens one identity may be issued within IssuanceWindow,
+    // 30 in 1m by default
+    IssuanceBurst  int      `json:"issuanceBurst,omitempty"`
+    IssuanceWindow duration `json:"issuanceWindow,omitempty"`
+    // MaxTravelSpeed is the fastest plausible move between admissions, 1000 km/h by default
+    MaxTravelSpeed float64 `json:"maxTravelSpeed,omitempty"`
+    // Actions maps a rule to alert, step-up or revoke; rules not named only alert. step-up
+    // needs webauthn.rpId, and revoke revokes every token of the identity
+    Actions map[string]anomaly.Action `json:"actions,omitempty"`
+    // StepUpTTL is how long a step-up finding holds, 1h by default
+    StepUpTTL duration `json:"stepUpTTL,omitempty"`
+    // Cooldown is how long a rule stays quiet about an identity after firing, 10m by default
+    Cooldown duration `json:"cooldown,omitempty"`
+    // Remote is the URL of an external detector; see anomaly.Remote
+    Remote        string   `json:"remote,omitempty"`
+    RemoteTimeout duration `json:"remoteTimeout,omitempty"`
+}
+
+// decoysConfig tunes decoy tokens. Presenting one is always alerted, audited and published
+type decoysConfig struct {
+    // BlockFor is how long the presenting address is refused by every instance, 24h by
+    // default; a negative value only alerts. Addresses come from the connection, or from
+    // remoteIP at POST /v1/admit, so set it negative if clients reach tokend, the keyserver
+    // or the gateway's own endpoints through a proxy
+    BlockFor duration `json:"blockFor,omitempty"`
+}
+
+// raiseHandConfig keeps a hand raised for TTL, 10m by default, and at most MaxPending hands,
+// 100 by default, raised in a room at a time
+type raiseHandConfig struct {
+    TTL        duration `json:"ttl,omitempty"`
+    MaxPending int      `json:"maxPending,omitempty"`
+}
+
+// roomPINConfig locks an identity out of a room for Lockout after MaxAttempts wrong PINs
+// within Window; 5 in 15m and 15m by default. RoomAttempts wrong PINs by anyone lock the
+// room, 50 by default, and RemoteIPAttempts from one address lock the address, 20 by default
+type roomPINConfig struct {
+    MaxAttempts      int      `json:"maxAttempts,omitempty"`
+    RoomAttempts     int      `json:"roomAttempts,omitempty"`
+    RemoteIPAttempts int      `json:"remoteIPAttempts,omitempty"`
+    Window           duration `json:"window,omitempty"`
+    Lockout          duration `json:"lockout,omitempty"`
+}
+
+// extensionsConfig names the extension plugins. With an attestation verifier, tokend checks
+// the attestation of token requests that carry one, and refuses those without when
+// RequireAttestation is set
+type extensionsConfig struct {
+    RevocationStore     *extensionConfig `json:"revocationStore,omitempty"`
+    AttestationVerifier *extensionConfig `json:"attestationVerifier,omitempty"`
+    RequireAttestation  bool             `json:"requireAttestation,omitempty"`
+}
+
+// extensionConfig is one plugin binary; SHA256, hex encoded, is checked before it starts and
+// CallTimeout is 5s by default
+type extensionConfig struct {
+    Path        string   `json:"path"`
+    Args        []string `json:"args,omitempty"`
+    SHA256      string   `json:"sha256,omitempty"`
+    CallTimeout duration `json:"callTimeout,omitempty"`
+}
+
+// federationConfig makes the keyserver serve this deployment's descriptor once Domain,
+// GatewayURL and JWKSURI are set; the issuer is cfg.Issuer. Peers are discovered by domain
+// through Resolver, a validating DNS resolver as host:port, the first in /etc/resolv.conf by
+// default. CacheDir keeps peers' documents across restarts; Fresh and MaxStale default to 5m
+// and 24h
+type federationConfig struct {
+    Domain        string   `json:"domain,omitempty"`
+    GatewayURL    string   `json:"gatewayUrl,omitempty"`
+    KeyserverURL  string   `json:"keyserverUrl,omitempty"`
+    JWKSURI       string   `json:"jwksUri,omitempty"`
+    Peers         []string `json:"peers,omitempty"`
+    Resolver      string   `json:"resolver,omitempty"`
+    RequireDNSSEC bool     `json:"requireDNSSEC,omitempty"`
+    CacheDir      string   `json:"cacheDir,omitempty"`
+    Fresh         duration `json:"fresh,omitempty"`
+    MaxStale      duration `json:"maxStale,omitempty"`
+}
+
+// dpopConfig tunes DPoP proofs on tokend and admin. Proofs must carry the server nonce
+// from the DPoP-Nonce response header, and each proof's jti is accepted once. With Require,
+// admin callers authorize as DPoP <admin token> and tokend callers send a proof beside their
+// basic auth; otherwise only requests that send a proof have it checked. Nonces and seen
+// jti values are kept per process, so replicas behind one load balancer need sticky
+// sessions. MaxAge is 60s and NonceRotation 5m by default
+type dpopConfig struct {
+    Require bool `json:"require,omitempty"`
+    // AdminKeys are the RFC 7638 thumbprints of the keys whose proofs may present the admin
+    // token; required with Require
+    AdminKeys       []string `json:"adminKeys,omitempty"`
+    MaxAge          duration `json:"maxAge,omitempty"`
+    NonceRotation   duration `json:"nonceRotation,omitempty"`
+    ReplayCacheSize int      `json:"replayCacheSize,omitempty"`
+}
+
+// roomHooksConfig lists the remote room hooks, run in order within each stage
+type roomHooksConfig struct {
+    Hooks []roomHookConfig `json:"hooks,omitempty"`
+    // Secret signs hook requests, read from VOLLY_ROOM_HOOK_SECRET
+    Secret string `json:"-"`
+}
+
+// roomHookConfig is one remote hook. Stage is pre_create, pre_join or post_leave; Timeout
+// is 2s by default, and a hook that errs or times out refuses the join unless FailOpen
+type roomHookConfig struct {
+    Name     string   `json:"name,omitempty"`
+    Stage    string   `json:"stage"`
+    URL      string   `json:"url"`
+    Timeout  duration `json:"timeout,omitempty"`
+    FailOpen bool     `json:"failOpen,omitempty"`
+}
+
+// stepUpConfig tunes the gateway's step-up endpoints, which are on once webauthn.rpId is set
+type stepUpConfig struct {
+    // Actions need a step-up; start_recording and remove_participant by default
+    Actions      []string `json:"actions,omitempty"`
+    ChallengeTTL duration `json:"challengeTTL,omitempty"`
+    ReceiptTTL   duration `json:"receiptTTL,omitempty"`
+}
+
+// webAuthnConfig turns on passkey registration at tokend, and step-up at the gateway, once
+// RPID is set
+type webAuthnConfig struct {
+    RPID    string   `json:"rpId,omitempty"`
+    RPName  string   `json:"rpName,omitempty"`
+    Origins []string `json:"origins,omitempty"`
+    // SkipUserVerification accepts authenticators that only see the user present, with no PIN
+    // or biometric
+    SkipUserVerification bool     `json:"skipUserVerification,omitempty"`
+    Timeout              duration `json:"timeout,omitempty"`
+    // LoginRole is the role of tokens minted for a passkey login; empty turns login off
+    LoginRole string `json:"loginRole,omitempty"`
+}
+
+// samlConfig turns on the SAML service provider at tokend once EntityID is set
+type samlConfig struct {
+    EntityID string `json:"entityId,omitempty"`
+    // ACSURL is tokend's public POST /v1/saml/acs URL
+    ACSURL string `json:"acsUrl,omitempty"`
+    // RedirectURL receives the browser after a login, with the token in the URL fragment;
+    // unset, the ACS answers JSON
+    RedirectURL string `json:"redirectUrl,omitempty"`
+    // AllowIdPInitiated accepts logins started at the IdP rather than at /v1/saml/login
+    AllowIdPInitiated bool            `json:"allowIdpInitiated,omitempty"`
+    ClockSkew         duration        `json:"clockSkew,omitempty"`
+    IdPs              []samlIdPConfig `json:"idps,omitempty"`
+}
+
+// samlIdPConfig is a trusted IdP, from a metadata file or given field by field
+type samlIdPConfig struct {
+    Metadata string `json:"metadata,omitempty"`
+    EntityID string `json:"entityId,omitempty"`
+    SSOURL   string `json:"ssoUrl,omitempty"`
+    // Certificates are PEM signing certificates, added to any in the metadata
+    Certificates []string              `json:"certificates,omitempty"`
+    Mapping      saml.AttributeMapping `json:"mapping"`
+}
+
+// matrixConfig bridges Matrix homeservers; the bridge is off without any. TTL caps the
+// tokens it issues, MaxTokenTTL by default
+type matrixConfig struct {
+    Homeservers []matrix.Homeserver `json:"homeservers,omitempty"`
+    Rooms       matrix.Rooms        `json:"rooms"`
+    TTL         duration            `json:"ttl,omitempty"`
+}
+
+// directoryConfig lists the tenants whose tokens follow their corporate directory
+type directoryConfig struct {
+    Tenants map[string]directoryTenantConfig `json:"tenants,omitempty"`
+}
+
+type directoryTenantConfig struct {
+    // GroupRoles maps directory group names to the roles their members get
+    GroupRoles map[string]string `json:"groupRoles"`
+    // LDAP polls a directory instead of, or as well as, taking SCIM provisioning
+    LDAP *ldapConfig `json:"ldap,omitempty"`
+}
+
+type ldapConfig struct {
+    directory.LDAPConfig
+    // BindPasswordFile holds the bind password, keeping it out of the config file
+    BindPasswordFile string   `json:"bindPasswordFile,omitempty"`
+    Interval         duration `json:"interval,omitempty"`
+}
+
+type doctorConfig struct {
+    // NTPServer is the host:port clock skew is measured against
+    NTPServer string `json:"ntpServer"`
+    // MaxClockSkew fails the clock check, well before skew reaches auth.DefaultLeeway and
+    // fresh tokens start failing nbf
+    MaxClockSkew duration `json:"maxClockSkew"`
+}
+
+type bootstrapConfig struct {
+    Tenant    string
+    APIKey    string
+    APISecret string
+}
+
+// duration unmarshals Go duration strings such as "30s"
+type duration struct {
+    time.Duration
+}
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+    var s string
+    if err := json.Unmarshal(data, &s); err != nil {
+        return err
+    }
+    v, err := time.ParseDuration(s)
+    if err != nil {
+        return err
+    }
+    d.Duration = v
+    return nil
+}
+
+func defaultConfig() *config {
+    return &config{
+        Gateway:         listenConfig{Listen: ":7880"},
+        Tokend:          listenConfig{Listen: ":7881"},
+        Keyserver:       listenConfig{Listen: ":7882"},
+        Admin:           listenConfig{Listen: ":7883"},
+        Proxy:           proxyConfig{Listen: ":7884", Upstream: "http://localhost:7880", Dir: "transcripts"},
+        Database:        databaseConfig{Migrate: true},
+        MaxTokenTTL:     duration{6 * time.Hour},
+        ExpiryJitter:    duration{time.Minute},
+        NotBeforeLeeway: duration{auth.DefaultNotBeforeLeeway},
+        SyncInterval:    duration{5 * time.Second},
+        ShutdownTimeout: duration{25 * time.Second},
+        ClaimLimits:     auth.DefaultClaimLimits,
+        TokenBudget:     auth.DefaultTokenBudget,
+        Connections: connectionsConfig{
+            MaxQueue:     1024,
+            QueueTimeout: duration{5 * time.Second},
+            LeaseTTL:     duration{2 * time.Minute},
+        },
+        WebSocket: websocketConfig{
+            CompressionThreshold: websocket.DefaultCompressionThreshold,
+            TicketTTL:            duration{protocol.DefaultTicketTTL},
+        },
+        Doctor:        doctorConfig{NTPServer: doctor.DefaultNTPServer, MaxClockSkew: duration{5 * time.Second}},
+        Bundle:        bundleConfig{Algorithm: crypto.AlgorithmMLDSA65},
+        Delegation:    delegationConfig{MaxTTL: duration{15 * time.Minute}},
+        DPoP:          dpopConfig{ReplayCacheSize: 100000},
+        VerifyWorkers: runtime.NumCPU(),
+    }
+}
+
+// loadConfig overlays the optional JSON file and environment on the defaults
+func loadConfig(path string) (*config, error) {
+    cfg := defaultConfig()
+    if path != "" {
+        data, err := os.ReadFile(path)
+        if err != nil {
+            return nil, err
+        }
+        if err := json.Unmarshal(data, cfg); err != nil {
+            return nil, err
+        }
+    }
+
+    cfg.AdminToken = os.Getenv("VOLLY_ADMIN_TOKEN")
+    cfg.SnapshotKey = os.Getenv("VOLLY_SNAPSHOT_KEY")
+    cfg.TicketKey = os.Getenv("VOLLY_TICKET_KEY")
+    cfg.StepUpKey = os.Getenv("VOLLY_STEPUP_KEY")
+    cfg.WebAuthnKey = os.Getenv("VOLLY_WEBAUTHN_KEY")
+    cfg.SAMLKey = os.Getenv("VOLLY_SAML_KEY")
+    cfg.ErasureKey = os.Getenv("VOLLY_ERASURE_KEY")
+    cfg.RedactionKey = os.Getenv("VOLLY_REDACTION_KEY")
+    cfg.UsageSigningKey = os.Getenv("VOLLY_USAGE_SIGNING_KEY")
+    cfg.AuditSigningKey = os.Getenv("VOLLY_AUDIT_SIGNING_KEY")
+    cfg.PINKey = os.Getenv("VOLLY_PIN_KEY")
+    cfg.RoomHooks.Secret = os.Getenv("VOLLY_ROOM_HOOK_SECRET")
+    cfg.FlagsToken = os.Getenv("VOLLY_FLAGS_TOKEN")
+    cfg.AnomalyToken = os.Getenv("VOLLY_ANOMALY_TOKEN")
+    cfg.Elevation.Secret = os.Getenv("VOLLY_ELEVATION_SECRET")
+    cfg.Database.DSN = os.Getenv("VOLLY_DATABASE_DSN")
+    cfg.Database.SealKey = os.Getenv("VOLLY_SEAL_KEY")
+    cfg.Database.SealKeyPrevious = os.Getenv("VOLLY_SEAL_KEY_PREVIOUS")
+    cfg.Database.Envelope.VaultToken = os.Getenv("VOLLY_VAULT_TOKEN")
+    if cfg.Database.DSN != "" && cfg.Database.Dialect == "" {
+        cfg.Database.Dialect = string(sqlstore.Postgres)
+    }
+    cfg.Bootstrap = bootstrapConfig{
+        Tenant:    os.Getenv("VOLLY_TENANT"),
+        APIKey:    os.Getenv("VOLLY_API_KEY"),
+        APISecret: os.Getenv("VOLLY_API_SECRET"),
+    }
+    if cfg.Bootstrap.Tenant == "" {
+        cfg.Bootstrap.Tenant = "default"
+    }
+    if (cfg.Bootstrap.APIKey == "") != (cfg.Bootstrap.APISecret == "") {
+        return nil, errors.New("VOLLY_API_KEY and VOLLY_API_SECRET must be set together")
+    }
+    if cfg.Bundle.Path != "" && cfg.Bootstrap.APIKey != "" {
+        return nil, errors.New("VOLLY_API_KEY cannot be used with bundle.path; put the key in the bundle")
+    }
+    if cfg.DPoP.Require && len(cfg.DPoP.AdminKeys) == 0 {
+        return nil, errors.New("dpop.adminKeys must be set with dpop.require")
+    }
+    if i := cfg.Issuance; len(i.Certificates) > 0 && (i.CertFile == "" || i.KeyFile == "" || i.ClientCAFile == "") {
+        return nil, errors.New("issuance.certFile, keyFile and clientCAFile must be set with issuance.certificates")
+    }
+    if sp := cfg.Issuance.SPIFFE; len(sp.Rules) > 0 {
+        if len(cfg.Issuance.Certificates) > 0 {
+            return nil, errors.New("issuance.certificates and issuance.spiffe.rules cannot both be set")
+        }
+        if sp.TrustDomain == "" || sp.CertFile == "" || sp.KeyFile == "" || sp.BundleFile == "" {
+            return nil, errors.New("issuance.spiffe.trustDomain, certFile, keyFile and bundleFile must be set with its rules")
+        }
+    }
+    if i := cfg.Issuance; len(cfg.Delegation.Actors) > 0 && len(i.Certificates) == 0 && len(i.SPIFFE.Rules) == 0 {
+        return nil, errors.New("delegation.actors needs issuance.certificates or issuance.spiffe.rules to authenticate them")
+    }
+    if e := cfg.Sessions.Evict; e != "" && e != presence.EvictOldest && e != presence.EvictNewest {
+        return nil, errors.New(`sessions.evict must be "oldest" or "newest"`)
+    }
+    if h := cfg.HSM; len(h.Keys) > 0 && (h.Module == "" || len(h.Slots) == 0) {
+        return nil, errors.New("hsm.module and hsm.slots must be set with hsm.keys")
+    }
+    if cfg.WebAuthn.RPID != "" && cfg.WebAuthnKey == "" {
+        return nil, errors.New("VOLLY_WEBAUTHN_KEY must be set with webauthn.rpId")
+    }
+    return cfg, nil
+}
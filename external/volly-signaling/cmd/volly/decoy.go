@@ -0,0 +1,203 @@
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/base64"
+    "errors"
+    "log"
+    "net/http"
+    "net/netip"
+    "time"
+
+    lkauth "github.com/livekit/protocol/auth"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/audit"
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+    "github.com/volly-org/volly-signaling/pkg/volly/events"
+    "github.com/volly-org/volly-signaling/pkg/volly/netpolicy"
+    "github.com/volly-org/volly-signaling/pkg/volly/revocation"
+)
+
+const (
+    // eventDecoy is published when a decoy is presented, so every instance blocks the address
+    eventDecoy = "decoy.presented"
+    // eventUnblocked is published when an operator lifts a block
+    eventUnblocked = "address.unblocked"
+)
+
+const (
+    // defaultDecoyTTL is how long a decoy looks valid, and so how long it stays a tripwire
+    defaultDecoyTTL = 30 * 24 * time.Hour
+    // defaultDecoyBlock is how long an address that presented a decoy is refused
+    defaultDecoyBlock = 24 * time.Hour
+)
+
+type decoyRequest struct {
+    // APIKey signs the decoy, so it looks like any other token of the key's tenant
+    APIKey   string `json:"apiKey"`
+    Identity string `json:"identity"`
+    Room     string `json:"room"`
+    TTL      string `json:"ttl,omitempty"`
+}
+
+type decoyResponse struct {
+    Token     string    `json:"token"`
+    TokenID   string    `json:"tokenId"`
+    ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// decoyBlockFor is decoys.blockFor or its default; zero or less only alerts
+func decoyBlockFor(cfg *config) time.Duration {
+    if d := cfg.Decoys.BlockFor.Duration; d != 0 {
+        return d
+    }
+    return defaultDecoyBlock
+}
+
+// newBlocklist refuses, for blockFor, addresses that presented a decoy on this instance
+// or, through the event bus, on any other
+func newBlocklist(blockFor time.Duration, bus events.Bus) *netpolicy.Blocklist {
+    b := netpolicy.NewBlocklist()
+    bus.Subscribe(eventDecoy, func(ctx context.Context, event events.Event) {
+        if ip, err := netip.ParseAddr(event.Data["ip"]); err == nil && blockFor > 0 {
+            b.Block(ip, blockFor, "decoy "+event.Data["token"])
+        }
+    })
+    bus.Subscribe(eventUnblocked, func(ctx context.Context, event events.Event) {
+        if ip, err := netip.ParseAddr(event.Data["ip"]); err == nil {
+            b.Unblock(ip)
+        }
+    })
+    return b
+}
+
+// remoteAddr is the address a request came from, for endpoints clients call directly
+func remoteAddr(r *http.Request) netip.Addr {
+    addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+    if err != nil {
+        return netip.Addr{}
+    }
+    return addrPort.Addr().Unmap()
+}
+
+// verifyRequest is verifyToken for a token the client presents itself, tripping the wire
+// against the request's address when it is a decoy
+func verifyRequest(r *http.Request, cfg *config, s *stores, token string) (*auth.VollyVideoGrant, error) {
+    grant, err := verifyToken(r.Context(), cfg, s, token)
+    tripwire(r.Context(), s, remoteAddr(r), err)
+    return grant, err
+}
+
+// tripwire raises the alarm when err says a decoy was presented, and blocks ip everywhere.
+// ip is unset when only the media edge's address is known, which must never be blocked;
+// the alarm is still raised. It reports whether err was a decoy
+func tripwire(ctx context.Context, s *stores, ip netip.Addr, err error) bool {
+    var decoy *revocation.DecoyError
+    if !errors.As(err, &decoy) {
+        return false
+    }
+    from := "an unknown address"
+    if ip.IsValid() {
+        from = ip.String()
+    }
+    log.Printf("ALERT decoy: token %s of %s in tenant %s presented from %s", decoy.JTI, s.redactor.Identity(decoy.Identity), decoy.Tenant, from)
+    detail := map[string]string{"token": decoy.JTI, "room": decoy.Room, "priority": "high"}
+    data := map[string]string{"tenant": decoy.Tenant, "token": decoy.JTI, "priority": "high"}
+    if ip.IsValid() {
+        detail["remoteAddr"], data["ip"] = ip.String(), ip.String()
+    }
+    if err := s.audit.Append(ctx, audit.Entry{Actor: "volly", Action: "decoy.presented", Tenant: decoy.Tenant, Target: decoy.Identity, Detail: detail}); err != nil {
+        log.Printf("audit append failed: %v", err)
+    }
+    // The event blocks the address on every instance, this one included, but the block here
+    // must not wait on the bus
+    if ip.IsValid() && s.blockFor > 0 {
+        s.blocklist.Block(ip, s.blockFor, "decoy "+decoy.JTI)
+    }
+    if err := s.bus.Publish(ctx, events.Event{Type: eventDecoy, Identity: decoy.Identity, Data: data}); err != nil {
+        log.Printf("decoy: publishing %s failed: %v", eventDecoy, err)
+    }
+    return true
+}
+
+// decoyRoutes mints decoy tokens and manages the addresses blocked for presenting them
+func decoyRoutes(mux *http.ServeMux, cfg *config, s *stores) {
+    mux.HandleFunc("POST /v1/decoys", func(w http.ResponseWriter, r *http.Request) {
+        var req decoyRequest
+        if !readJSON(w, r, &req) {
+            return
+        }
+        if req.Identity == "" || req.Room == "" {
+            http.Error(w, "identity and room are required", http.StatusBadRequest)
+            return
+        }
+        ttl := defaultDecoyTTL
+        if req.TTL != "" {
+            d, err := time.ParseDuration(req.TTL)
+            if err != nil || d <= 0 {
+                http.Error(w, "invalid ttl", http.StatusBadRequest)
+                return
+            }
+            ttl = d
+        }
+        key, err := activeKey(r.Context(), s, req.APIKey)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        pq, err := crypto.GenerateMLKEM768KeyPair()
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        // Shaped like the jti tokend leaves to the token builder, so nothing sets a decoy apart
+        b := make([]byte, 16)
+        if _, err := rand.Read(b); err != nil {
+            writeError(w, err)
+            return
+        }
+        jti := base64.RawURLEncoding.EncodeToString(b)
+        at := accessToken(s, key).
+            AddGrant(&auth.VollyVideoGrant{VideoGrant: lkauth.VideoGrant{RoomJoin: true, Room: req.Room}}).
+            SetIdentity(req.Identity).
+            SetTenant(key.Tenant).
+            SetProvenance(tokendProvenance(auth.ProviderAPIKey)).
+            SetTokenID(jti).
+            SetValidFor(ttl).
+            SetClaimSchemas(s.claimSchemas).
+            SetCryptoProfiles(s.cryptoProfiles).
+            SetPostQuantumKey(pq.PublicKey, pq.Algorithm)
+        token, err := scopeToken(cfg, at, key.Tenant).ToJWT()
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        // Flagged before it is handed out, so the token never exists without its wire
+        expiresAt := at.ExpiresAt()
+        if err := s.revocations.Decoy(r.Context(), jti, expiresAt); err != nil {
+            writeError(w, err)
+            return
+        }
+        record(r, s, "decoy.create", key.Tenant, jti)
+        writeJSON(w, http.StatusOK, decoyResponse{Token: token, TokenID: jti, ExpiresAt: expiresAt})
+    })
+    mux.HandleFunc("GET /v1/blocks", func(w http.ResponseWriter, r *http.Request) {
+        writeJSON(w, http.StatusOK, s.blocklist.List())
+    })
+    mux.HandleFunc("DELETE /v1/blocks/{ip}", func(w http.ResponseWriter, r *http.Request) {
+        ip, err := netip.ParseAddr(r.PathValue("ip"))
+        if err != nil {
+            http.Error(w, "invalid address", http.StatusBadRequest)
+            return
+        }
+        s.blocklist.Unblock(ip)
+        if err := s.bus.Publish(r.Context(), events.Event{Type: eventUnblocked, Data: map[string]string{"ip": ip.String()}}); err != nil {
+            writeError(w, err)
+            return
+        }
+        record(r, s, "block.delete", "", ip.String())
+        w.WriteHeader(http.StatusNoContent)
+    })
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+    "errors"
+    "net/http"
+    "strings"
+    "time"
+
+    lkauth "github.com/livekit/protocol/auth"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/audit"
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/issuance"
+)
+
+var (
+    errDelegationOff = errors.New("on-behalf-of exchange is not configured")
+    errNotAnActor    = errors.New("token holder may not act on behalf of users")
+)
+
+type onBehalfOfRequest struct {
+    // SubjectToken is the user's token, or a delegation token for a service calling onwards
+    SubjectToken string `json:"subjectToken"`
+    TTL          string `json:"ttl,omitempty"`
+}
+
+// onBehalfOf serves POST /v1/on-behalf-of: a service calls with the client certificate or
+// SVID of a principal Delegation.Actors lists, presents its own key-bound token, issued to
+// that principal, as a DPoP credential, and a user's token, and gets a delegation token
+// carrying the user's identity and permissions with the service recorded in the act claim,
+// bound to the service's key. Downstream services see the whole call chain, and revoking
+// the user's or any service's token revokes the delegation
+func onBehalfOf(cfg *config, s *stores, issuers issuance.Authenticator) http.HandlerFunc {
+    actors := make(map[string]bool, len(cfg.Delegation.Actors))
+    for _, identity := range cfg.Delegation.Actors {
+        actors[identity] = true
+    }
+    return func(w http.ResponseWriter, r *http.Request) {
+        if len(actors) == 0 {
+            writeError(w, errDelegationOff)
+            return
+        }
+        token := credential(r)
+        if token == "" || auth.IsViewerToken(token) {
+            writeError(w, errBadCredentials)
+            return
+        }
+        var req onBehalfOfRequest
+        if !readJSON(w, r, &req) {
+            return
+        }
+        if req.SubjectToken == "" {
+            http.Error(w, "subjectToken is required", http.StatusBadRequest)
+            return
+        }
+        ttl := cfg.Delegation.MaxTTL.Duration
+        if req.TTL != "" {
+            d, err := time.ParseDuration(req.TTL)
+            if err != nil || d <= 0 {
+                http.Error(w, "invalid ttl", http.StatusBadRequest)
+                return
+            }
+            if d < ttl {
+                ttl = d
+            }
+        }
+
+        // A token alone does not make its holder an actor: the service must also call with
+        // a credential of its own
+        if issuers == nil || r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+            writeError(w, errNotAnActor)
+            return
+        }
+        principal, err := issuers.Authenticate(r)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        if !actors[principal.Name] {
+            writeError(w, errNotAnActor)
+            return
+        }
+        actor, err := verifyRequest(r, cfg, s, token)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        if actor.Identity != principal.Name || actor.Tenant != principal.Tenant {
+            writeError(w, errNotAnActor)
+            return
+        }
+        subject, err := verifyRequest(r, cfg, s, req.SubjectToken)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        grant, ttl, err := auth.OnBehalfOf(actor, subject, ttl, time.Now())
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        // Signed under the service's key, which verifyRequest has bound to the same tenant
+        parsed, err := lkauth.ParseAPIToken(token)
+        if err != nil {
+            writeError(w, errBadCredentials)
+            return
+        }
+        key, err := activeKey(r.Context(), s, parsed.APIKey())
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        at := accessToken(s, key).
+            AddGrant(grant).
+            SetIdentity(subject.Identity).
+            SetProvenance(subject.Provenance.Derive(provenanceService, version)).
+            SetValidFor(ttl).
+            SetOnBehalfOf(subject.TokenID).
+            SetClaimSchemas(s.claimSchemas).
+            SetCryptoProfiles(s.cryptoProfiles).
+            SetIssuanceCheck(s.killSwitch.CheckGrant)
+        delegated, err := scopeToken(cfg, at, key.Tenant).ToJWT()
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+
+        var chain []string
+        for _, a := range grant.Actor.Chain() {
+            chain = append(chain, a.Identity)
+        }
+        e := audit.Entry{Actor: actor.Identity, Action: "token.on_behalf_of", Tenant: subject.Tenant, Target: subject.Identity, Tags: subject.Tags,
+            Detail: map[string]string{
+                "actors":     strings.Join(chain, ","),
+                "subject":    subject.TokenID,
+                "service":    actor.TokenID,
+                "room":       subject.Room,
+                "ttl":        ttl.String(),
+                "remoteAddr": r.RemoteAddr,
+            }}
+        if err := s.audit.Append(r.Context(), e); err != nil {
+            // Like elevations, a delegation that cannot be audited is not handed out
+            writeError(w, err)
+            return
+        }
+        tokenIssued(r.Context(), s, key, subject.Identity, subject.Tags)
+        writeJSON(w, http.StatusOK, tokenResponse{Token: delegated, ExpiresAt: at.ExpiresAt()})
+    }
+}
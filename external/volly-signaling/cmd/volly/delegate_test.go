@@ -0,0 +1,122 @@
+package main
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/issuance"
+)
+
+// TestOnBehalfOf exchanges a user's token for a delegation as a service calling with its
+// client certificate and a token bound to its key, and checks the delegation is bound to that
+// key. A call without the certificate, with an unbound token or with a token issued to
+// another identity is refused
+func TestOnBehalfOf(t *testing.T) {
+    ca := newTestCA(t)
+    dir := t.TempDir()
+    caFile := filepath.Join(dir, "ca.pem")
+    if err := os.WriteFile(caFile, ca.pem, 0o600); err != nil {
+        t.Fatal(err)
+    }
+    server := ca.issue(t, &x509.Certificate{IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)}}, x509.ExtKeyUsageServerAuth)
+    certFile, keyFile := writePEM(t, dir, "server", server)
+
+    cfg := testConfig()
+    cfg.Issuance = issuanceConfig{
+        CertFile:     certFile,
+        KeyFile:      keyFile,
+        ClientCAFile: caFile,
+        Certificates: []issuance.CertificateRule{{Identity: "svc.internal", Tenant: testTenant, Scopes: []string{"room:*"}}},
+        SigningKeys:  map[string]string{testTenant: testAPIKey},
+    }
+    cfg.Delegation.Actors = []string{"svc.internal"}
+    h, err := newTokend(cfg, testStores(t, cfg))
+    if err != nil {
+        t.Fatal(err)
+    }
+    tlsConfig, err := tokendTLS(cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    srv := httptest.NewUnstartedServer(h)
+    srv.TLS = tlsConfig
+    srv.StartTLS()
+    t.Cleanup(srv.Close)
+
+    roots := x509.NewCertPool()
+    roots.AddCert(ca.cert)
+    client := func(certs ...tls.Certificate) *http.Client {
+        c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+        t.Cleanup(c.CloseIdleConnections)
+        return c
+    }
+    service := client(ca.issue(t, &x509.Certificate{DNSNames: []string{"svc.internal"}}, x509.ExtKeyUsageClientAuth))
+    anonymous := client()
+    nonce := dpopNonceWith(t, anonymous, srv.URL)
+    holder := newProver(t)
+
+    issue := func(c *http.Client, identity string, bind bool) string {
+        t.Helper()
+        var resp tokenResponse
+        if code := postJSONWith(t, c, srv.URL+"/v1/token", tokenRequest{Identity: identity, Room: "r"}, &resp, func(r *http.Request) {
+            if c == anonymous {
+                withAPIKey(r)
+            }
+            if bind {
+                holder.sign(t, r, nonce, "")
+            }
+        }); code != http.StatusOK {
+            t.Fatalf("issue for %s: status %d", identity, code)
+        }
+        return resp.Token
+    }
+    subject := issue(anonymous, "alice", false)
+    exchange := func(c *http.Client, token string, out *tokenResponse) int {
+        t.Helper()
+        return postJSONWith(t, c, srv.URL+"/v1/on-behalf-of", onBehalfOfRequest{SubjectToken: subject}, out, func(r *http.Request) {
+            if keyBound(token) {
+                r.Header.Set("Authorization", "DPoP "+token)
+                holder.sign(t, r, nonce, token)
+                return
+            }
+            r.Header.Set("Authorization", "Bearer "+token)
+        })
+    }
+
+    bound := issue(service, "svc.internal", true)
+    var delegated tokenResponse
+    if code := exchange(service, bound, &delegated); code != http.StatusOK {
+        t.Fatalf("service with its certificate and bound token: status %d, want 200", code)
+    }
+    grant, err := auth.ParseUnverified(delegated.Token)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want, err := holder.thumbprint()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if grant.Confirmation == nil || grant.Confirmation.KeyThumbprint != want {
+        t.Fatalf("delegation cnf = %+v, want the service's jkt %s", grant.Confirmation, want)
+    }
+    if grant.Identity != "alice" || grant.Actor == nil || grant.Actor.Identity != "svc.internal" {
+        t.Fatalf("delegation of %q acted by %+v, want alice acted by svc.internal", grant.Identity, grant.Actor)
+    }
+
+    if code := exchange(anonymous, bound, nil); code != http.StatusForbidden {
+        t.Fatalf("bound token without the certificate: status %d, want 403", code)
+    }
+    if code := exchange(service, issue(service, "svc.internal", false), nil); code != http.StatusForbidden {
+        t.Fatalf("unbound service token: status %d, want 403", code)
+    }
+    if code := exchange(service, issue(anonymous, "mallory", true), nil); code != http.StatusForbidden {
+        t.Fatalf("token issued to another identity: status %d, want 403", code)
+    }
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "os"
+    "strings"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/audit"
+    "github.com/volly-org/volly-signaling/pkg/volly/directory"
+)
+
+var errDirectoryOff = errors.New("tenant is not under directory sync")
+
+// newDirectory puts the configured tenants under d and starts their LDAP polls
+func newDirectory(ctx context.Context, cfg *config, store directory.Store, s *stores) (*directory.Directory, error) {
+    d := directory.New(store).SetRevoker(s.revocations)
+    for tenant, t := range cfg.Directory.Tenants {
+        d.Manage(tenant, t.GroupRoles)
+        if t.LDAP == nil {
+            continue
+        }
+        lc := t.LDAP.LDAPConfig
+        if t.LDAP.BindPasswordFile != "" {
+            pw, err := os.ReadFile(t.LDAP.BindPasswordFile)
+            if err != nil {
+                return nil, err
+            }
+            lc.BindPassword = strings.TrimSpace(string(pw))
+        }
+        poll := directory.NewLDAPSync(d, tenant, lc)
+        if t.LDAP.Interval.Duration > 0 {
+            poll.SetInterval(t.LDAP.Interval.Duration)
+        }
+        s.ldapSyncs = append(s.ldapSyncs, poll)
+        go poll.Run(ctx)
+    }
+    return d, nil
+}
+
+// mountSCIM serves SCIM 2.0 provisioning for directory-managed tenants under /scim/v2. The
+// directory product authenticates with the tenant's API key, as basic auth or as a bearer
+// token of the form key:secret, since many only send bearer tokens
+func mountSCIM(mux *http.ServeMux, s *stores) {
+    const prefix = "/scim/v2"
+    scim := directory.NewSCIMServer(s.directory, prefix)
+    mux.HandleFunc(prefix+"/", func(w http.ResponseWriter, r *http.Request) {
+        id, secret, ok := r.BasicAuth()
+        if !ok {
+            id, secret, ok = strings.Cut(bearer(r), ":")
+        }
+        if !ok {
+            writeError(w, errBadCredentials)
+            return
+        }
+        key, err := checkKey(r.Context(), s, id, secret)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        if !s.directory.Manages(key.Tenant) {
+            writeError(w, errDirectoryOff)
+            return
+        }
+        if r.Method != http.MethodGet {
+            // Provisioning changes who can get tokens, so each is audited before it is made
+            e := audit.Entry{Actor: key.ID, Action: "scim." + strings.ToLower(r.Method), Tenant: key.Tenant,
+                Target: strings.TrimPrefix(r.URL.Path, prefix), Detail: map[string]string{"remoteAddr": r.RemoteAddr}}
+            if err := s.audit.Append(r.Context(), e); err != nil {
+                writeError(w, err)
+                return
+            }
+        }
+        scim.ServeHTTP(w, r.WithContext(directory.WithTenant(r.Context(), key.Tenant)))
+    })
+}
+
+// directoryRoutes adds admin endpoints to inspect a tenant's provisioned users and run its
+// LDAP poll now
+func directoryRoutes(mux *http.ServeMux, s *stores) {
+    mux.HandleFunc("GET /v1/tenants/{tenant}/directory/users", func(w http.ResponseWriter, r *http.Request) {
+        users, err := s.directory.Store().ListUsers(r.Context(), r.PathValue("tenant"))
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, users)
+    })
+    mux.HandleFunc("GET /v1/tenants/{tenant}/directory/users/{identity}/roles", func(w http.ResponseWriter, r *http.Request) {
+        roles, err := s.directory.Roles(r.Context(), r.PathValue("tenant"), r.PathValue("identity"))
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, map[string][]string{"roles": roles})
+    })
+    mux.HandleFunc("POST /v1/tenants/{tenant}/directory/sync", func(w http.ResponseWriter, r *http.Request) {
+        tenant := r.PathValue("tenant")
+        for _, poll := range s.ldapSyncs {
+            if poll.Tenant() != tenant {
+                continue
+            }
+            res, err := poll.Sync(r.Context())
+            if err != nil {
+                writeError(w, err)
+                return
+            }
+            record(r, s, "directory.sync", tenant, "ldap")
+            writeJSON(w, http.StatusOK, res)
+            return
+        }
+        writeError(w, errDirectoryOff)
+    })
+}
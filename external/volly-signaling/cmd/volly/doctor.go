@@ -0,0 +1,229 @@
+package main
+
+import (
+    "context"
+    "encoding/base64"
+    "fmt"
+    "net"
+    "net/url"
+    "time"
+
+    lkauth "github.com/livekit/protocol/auth"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/audit"
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/configstore"
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+    "github.com/volly-org/volly-signaling/pkg/volly/doctor"
+    "github.com/volly-org/volly-signaling/pkg/volly/listing"
+    "github.com/volly-org/volly-signaling/pkg/volly/revocation"
+    "github.com/volly-org/volly-signaling/pkg/volly/sqlstore"
+)
+
+// doctorIdentity names the token the round trip issues, so it is recognisable in logs
+const doctorIdentity = "volly-doctor"
+
+// doctorChecks diagnose this process's view of the deployment: its config and secrets, the
+// stores it shares with the other services, its clock and the token path end to end
+func doctorChecks(cfg *config, s *stores) []doctor.Check {
+    return []doctor.Check{
+        {Name: "config", Run: func(ctx context.Context) []doctor.Finding { return checkConfig(cfg) }},
+        {Name: "secrets", Run: func(ctx context.Context) []doctor.Finding { return checkSecrets(cfg) }},
+        {Name: "stores", Run: func(ctx context.Context) []doctor.Finding { return checkStores(ctx, cfg, s) }},
+        doctor.ClockSkew(cfg.Doctor.NTPServer, cfg.Doctor.MaxClockSkew.Duration),
+        doctor.PQAlgorithms(),
+        {Name: "token-round-trip", Run: func(ctx context.Context) []doctor.Finding { return checkRoundTrip(ctx, cfg, s) }},
+    }
+}
+
+func checkConfig(cfg *config) []doctor.Finding {
+    const name = "config"
+    var findings []doctor.Finding
+    fail := func(detail, fix string) { findings = append(findings, doctor.Fail(name, detail, fix)) }
+    warn := func(detail, fix string) { findings = append(findings, doctor.Warn(name, detail, fix)) }
+
+    seen := map[string]string{}
+    for _, l := range []struct{ service, addr string }{
+        {"tokend", cfg.Tokend.Listen},
+        {"gateway", cfg.Gateway.Listen},
+        {"keyserver", cfg.Keyserver.Listen},
+        {"admin", cfg.Admin.Listen},
+        {"proxy", cfg.Proxy.Listen},
+    } {
+        if _, _, err := net.SplitHostPort(l.addr); err != nil {
+            fail(fmt.Sprintf("%s.listen %q is not host:port", l.service, l.addr), "set it to an address such as \":7880\"")
+            continue
+        }
+        if other, ok := seen[l.addr]; ok {
+            fail(fmt.Sprintf("%s and %s both listen on %s", other, l.service, l.addr), "give each service its own port; \"all\" binds every one")
+        }
+        seen[l.addr] = l.service
+    }
+    if cfg.MaxTokenTTL.Duration <= 0 {
+        fail("maxTokenTTL is not positive, so tokend rejects every request", "set maxTokenTTL, for example \"6h\"")
+    }
+    if w := cfg.CanonicalWindow.Duration; w > 0 && w >= cfg.MaxTokenTTL.Duration {
+        warn(fmt.Sprintf("canonicalWindow %s is not shorter than maxTokenTTL %s, so every token is issued uncached", w, cfg.MaxTokenTTL.Duration),
+            "keep canonicalWindow well under maxTokenTTL")
+    }
+    if cfg.SyncInterval.Duration <= 0 {
+        fail("syncInterval is not positive", "set syncInterval, for example \"5s\"")
+    }
+    if cfg.WebSocket.TicketTTL.Duration <= 0 {
+        warn("websocket.ticketTTL is not positive, so clients cannot resume sessions", "set websocket.ticketTTL, for example \"2m\"")
+    }
+    if d := cfg.Database; d.DSN != "" {
+        switch sqlstore.Dialect(d.Dialect) {
+        case sqlstore.SQLite, sqlstore.Postgres:
+        default:
+            fail(fmt.Sprintf("database.dialect %q is unknown", d.Dialect), "use \"postgres\" or \"sqlite\"")
+        }
+        if !d.Migrate {
+            warn("database.migrate is off", "run with migrate on once after each upgrade, or apply migrations yourself")
+        }
+    } else {
+        warn("no VOLLY_DATABASE_DSN, so every store is in memory and lost on restart",
+            "set VOLLY_DATABASE_DSN for anything beyond a single-process trial; split services need it to share state")
+    }
+    if cfg.Issuer == "" && cfg.Audience == "" && len(cfg.TenantAudiences) == 0 {
+        warn("neither issuer nor audience is set, so tokens minted by any deployment sharing these API keys are accepted",
+            "set issuer and audience to names unique to this deployment, such as \"volly-prod\"")
+    }
+    if b, max := cfg.TokenBudget.MaxBytes, cfg.ClaimLimits.MaxTokenBytes; max > 0 && (b == 0 || b > max) {
+        warn(fmt.Sprintf("tokenBudget.maxBytes allows tokens larger than the gateway's claimLimits.maxTokenBytes %d", max),
+            "keep tokenBudget.maxBytes at or under claimLimits.maxTokenBytes, and under 8192 behind load balancers with header limits")
+    }
+    if cfg.AllowLegacyTokens {
+        warn("allowLegacyTokens admits tokens without PQ claims", "turn it off once every client sends a PQ key")
+    }
+    if u, err := url.Parse(cfg.Proxy.Upstream); err != nil || u.Host == "" {
+        warn(fmt.Sprintf("proxy.upstream %q is not a URL", cfg.Proxy.Upstream), "only matters for \"volly proxy\"; set it to the gateway base URL")
+    }
+    if len(findings) == 0 {
+        findings = append(findings, doctor.OK(name, "config is consistent"))
+    }
+    return findings
+}
+
+// checkSecrets covers the secrets read from the environment, which is where the Kubernetes
+// secret or vault agent delivers them
+func checkSecrets(cfg *config) []doctor.Finding {
+    const name = "secrets"
+    var findings []doctor.Finding
+    key := func(env, value string, required bool, unset string) {
+        switch {
+        case value == "" && required:
+            findings = append(findings, doctor.Fail(name, env+" is not set", "deliver it to the process; "+unset))
+        case value == "":
+            findings = append(findings, doctor.Warn(name, env+" is not set", unset))
+        default:
+            raw, err := base64.StdEncoding.DecodeString(value)
+            if err != nil || len(raw) != 32 {
+                findings = append(findings, doctor.Fail(name, env+" is not 32 bytes of base64", "generate one with: openssl rand -base64 32"))
+                return
+            }
+            findings = append(findings, doctor.OK(name, env+" is present and well formed"))
+        }
+    }
+    key("VOLLY_SEAL_KEY", cfg.Database.SealKey, cfg.Database.DSN != "" && cfg.Database.Envelope.KMS != "vault", "API key secrets are sealed under it in the database")
+    key("VOLLY_SNAPSHOT_KEY", cfg.SnapshotKey, false, "set it to use vollyctl export and import")
+    key("VOLLY_TICKET_KEY", cfg.TicketKey, false, "gateways behind one load balancer need a shared key for resumption to survive a reconnect to another instance")
+
+    switch {
+    case cfg.AdminToken == "":
+        findings = append(findings, doctor.Warn(name, "VOLLY_ADMIN_TOKEN is not set", "set it to run the admin service"))
+    case len(cfg.AdminToken) < 32:
+        findings = append(findings, doctor.Warn(name, "VOLLY_ADMIN_TOKEN is shorter than 32 characters", "use a random token, for example: openssl rand -hex 32"))
+    default:
+        findings = append(findings, doctor.OK(name, "VOLLY_ADMIN_TOKEN is present"))
+    }
+    return findings
+}
+
+func checkStores(ctx context.Context, cfg *config, s *stores) []doctor.Finding {
+    const name = "stores"
+    backend := "memory"
+    if cfg.Database.DSN != "" {
+        backend = cfg.Database.Dialect
+    }
+    fix := "check VOLLY_DATABASE_DSN, that the database accepts connections from this host and that migrations have run"
+    probes := []struct {
+        store string
+        probe func() error
+    }{
+        {"config", func() error { _, err := s.config.ListTenants(ctx); return err }},
+        {"keys", func() error { _, _, err := s.keys.List(ctx, listing.Query{Limit: 1}); return err }},
+        {"revocations", func() error {
+            _, err := s.revoked.IsRevoked(ctx, revocation.Token{IDs: []string{"volly-doctor-probe"}})
+            return err
+        }},
+        {"audit", func() error { _, err := s.audit.Query(ctx, audit.Query{Limit: 1}); return err }},
+    }
+    var findings []doctor.Finding
+    for _, p := range probes {
+        start := time.Now()
+        if err := p.probe(); err != nil {
+            findings = append(findings, doctor.Fail(name, fmt.Sprintf("%s store (%s): %v", p.store, backend, err), fix))
+            continue
+        }
+        findings = append(findings, doctor.OK(name, fmt.Sprintf("%s store (%s) answered in %s", p.store, backend, time.Since(start).Round(time.Millisecond))))
+    }
+    return findings
+}
+
+// checkRoundTrip issues a short-lived token under an existing API key and verifies it the way
+// the gateway does, covering key lookup, signing, kill switches and revocation together
+func checkRoundTrip(ctx context.Context, cfg *config, s *stores) []doctor.Finding {
+    const name = "token-round-trip"
+    key, err := doctorKey(ctx, cfg, s)
+    if err != nil {
+        return []doctor.Finding{doctor.Fail(name, "no API key to issue with: "+err.Error(), "create one with POST /v1/tenants/{tenant}/keys, or set VOLLY_API_KEY and VOLLY_API_SECRET")}
+    }
+    if key.ID == "" {
+        return []doctor.Finding{doctor.Warn(name, "no tenant has an API key yet", "create one with POST /v1/tenants/{tenant}/keys, or set VOLLY_API_KEY and VOLLY_API_SECRET")}
+    }
+    pq, err := crypto.GenerateMLKEM768KeyPair()
+    if err != nil {
+        return []doctor.Finding{doctor.Fail(name, "generating a PQ key: "+err.Error(), "see the pq-algorithms findings")}
+    }
+    at := accessToken(s, key).
+        AddGrant(&auth.VollyVideoGrant{VideoGrant: lkauth.VideoGrant{RoomJoin: true, Room: doctorIdentity}}).
+        SetIdentity(doctorIdentity).
+        SetTenant(key.Tenant).
+        SetProvenance(tokendProvenance(auth.ProviderAPIKey)).
+        SetValidFor(time.Minute).
+        SetPostQuantumKey(pq.PublicKey, pq.Algorithm)
+    token, err := scopeToken(cfg, at, key.Tenant).ToJWT()
+    if err != nil {
+        return []doctor.Finding{doctor.Fail(name, fmt.Sprintf("issuing under key %s: %v", key.ID, err), "check that the key's secret was stored intact; rotate the key if not")}
+    }
+    if _, err := verifyUncached(ctx, cfg, s, token); err != nil {
+        return []doctor.Finding{doctor.Fail(name, fmt.Sprintf("a token issued under key %s did not verify: %v", key.ID, err),
+            "a kill switch or revocation covering tenant "+key.Tenant+" would do this; so would skew between the issuer and verifier clocks")}
+    }
+    return []doctor.Finding{doctor.OK(name, fmt.Sprintf("issued and verified a token under key %s of tenant %s", key.ID, key.Tenant))}
+}
+
+// doctorKey prefers the bootstrap key, then the first active key found; a zero key means
+// there is none yet
+func doctorKey(ctx context.Context, cfg *config, s *stores) (configstore.APIKey, error) {
+    if id := cfg.Bootstrap.APIKey; id != "" {
+        return activeKey(ctx, s, id)
+    }
+    tenants, err := s.config.ListTenants(ctx)
+    if err != nil {
+        return configstore.APIKey{}, err
+    }
+    for _, t := range tenants {
+        keys, err := s.config.ListAPIKeys(ctx, t.ID)
+        if err != nil {
+            return configstore.APIKey{}, err
+        }
+        for _, k := range keys {
+            if key, err := activeKey(ctx, s, k.ID); err == nil {
+                return key, nil
+            }
+        }
+    }
+    return configstore.APIKey{}, nil
+}
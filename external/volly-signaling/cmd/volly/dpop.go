@@ -0,0 +1,101 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "strings"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+    "github.com/volly-org/volly-signaling/pkg/volly/dpop"
+)
+
+var errKeyBoundBearer = errors.New("key-bound token must be sent as DPoP with a proof")
+
+// newDPoP builds the validator for proofs sent to tokend and admin, with a rotating server
+// nonce and a bounded jti replay cache
+func newDPoP(cfg *config) *dpop.Validator {
+    d := cfg.DPoP
+    v := dpop.NewValidator(dpop.NewNonceSource(d.NonceRotation.Duration), dpop.NewMemoryReplayCache(d.ReplayCacheSize))
+    if d.MaxAge.Duration > 0 {
+        v.SetMaxAge(d.MaxAge.Duration)
+    }
+    return v
+}
+
+// adminDPoP puts the admin API behind DPoP: with dpop.require callers authorize as DPoP
+// <admin token> with a proof signed by one of dpop.adminKeys, and otherwise a proof is
+// checked when sent
+func adminDPoP(cfg *config, s *stores, next http.Handler) http.Handler {
+    if cfg.DPoP.Require {
+        return s.dpop.Middleware(adminKeyBinding(cfg.DPoP.AdminKeys), next)
+    }
+    return s.dpop.ProofMiddleware(false, nil, next)
+}
+
+// adminKeyBinding accepts proofs signed by one of keys, the RFC 7638 thumbprints the admin
+// token is bound to; the admin token is not a JWT and has no cnf of its own
+func adminKeyBinding(keys []string) dpop.KeyBinding {
+    return func(ctx context.Context, accessToken, thumbprint string) error {
+        for _, key := range keys {
+            if crypto.EqualString(key, thumbprint) {
+                return nil
+            }
+        }
+        return dpop.ErrTokenBinding
+    }
+}
+
+// tokendDPoP puts tokend behind DPoP. Its callers authenticate with basic auth or the
+// token they present, so the proof only shows they hold the key; with dpop.require it must
+// be sent. A token bound to a key with cnf.jkt must be presented as DPoP <token> with a proof
+// signed by that key
+func tokendDPoP(cfg *config, s *stores, next http.Handler) http.Handler {
+    proofs := s.dpop.ProofMiddleware(cfg.DPoP.Require, tokenKeyBinding, next)
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if token := bearer(r); token != "" && keyBound(token) {
+            w.Header().Set("WWW-Authenticate", `DPoP error="invalid_token"`)
+            http.Error(w, errKeyBoundBearer.Error(), http.StatusUnauthorized)
+            return
+        }
+        proofs.ServeHTTP(w, r)
+    })
+}
+
+// tokenKeyBinding checks a proof against the cnf.jkt of the token it was sent with. The
+// token's signature is checked by the handler, which refuses the request if it was forged
+func tokenKeyBinding(ctx context.Context, accessToken, thumbprint string) error {
+    grant, err := auth.ParseUnverified(accessToken)
+    if err != nil {
+        return dpop.ErrTokenBinding
+    }
+    if cnf := grant.Confirmation; cnf != nil && !crypto.EqualString(cnf.KeyThumbprint, thumbprint) {
+        return dpop.ErrTokenBinding
+    }
+    return nil
+}
+
+// keyBound reports whether token names a key with cnf.jkt
+func keyBound(token string) bool {
+    grant, err := auth.ParseUnverified(token)
+    return err == nil && grant.Confirmation != nil
+}
+
+// confirmDPoP binds at to the key of the request's DPoP proof, if it sent one
+func confirmDPoP(r *http.Request, at *auth.VollyAccessToken) {
+    if proof, ok := dpop.FromContext(r.Context()); ok {
+        at.SetConfirmationThumbprint(proof.Thumbprint, proof.Key.Algorithm())
+    }
+}
+
+// credential is the token from a Bearer or, once its proof has been checked, a DPoP
+// Authorization header
+func credential(r *http.Request) string {
+    if _, ok := dpop.FromContext(r.Context()); ok {
+        if scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " "); strings.EqualFold(scheme, "DPoP") {
+            return strings.TrimSpace(token)
+        }
+    }
+    return bearer(r)
+}
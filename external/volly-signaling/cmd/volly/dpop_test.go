@@ -0,0 +1,142 @@
+package main
+
+import (
+    "crypto/ed25519"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "net/http"
+    "testing"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+)
+
+// prover signs DPoP proofs with an Ed25519 key
+type prover struct {
+    key ed25519.PrivateKey
+    jwk map[string]string
+}
+
+func newProver(t *testing.T) *prover {
+    t.Helper()
+    pub, key, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return &prover{key: key, jwk: map[string]string{"kty": "OKP", "crv": "Ed25519", "x": base64.RawURLEncoding.EncodeToString(pub)}}
+}
+
+// sign sets a proof for r under nonce, bound to token when it is set
+func (p *prover) sign(t *testing.T, r *http.Request, nonce, token string) {
+    t.Helper()
+    header, err := json.Marshal(map[string]interface{}{"typ": "dpop+jwt", "alg": "EdDSA", "jwk": p.jwk})
+    if err != nil {
+        t.Fatal(err)
+    }
+    claims := map[string]interface{}{
+        "jti":   base64.RawURLEncoding.EncodeToString(randomBytes(t)),
+        "htm":   r.Method,
+        "htu":   r.URL.Scheme + "://" + r.URL.Host + r.URL.Path,
+        "iat":   time.Now().Unix(),
+        "nonce": nonce,
+    }
+    if token != "" {
+        sum := sha256.Sum256([]byte(token))
+        claims["ath"] = base64.RawURLEncoding.EncodeToString(sum[:])
+    }
+    payload, err := json.Marshal(claims)
+    if err != nil {
+        t.Fatal(err)
+    }
+    input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+    r.Header.Set("DPoP", input+"."+base64.RawURLEncoding.EncodeToString(ed25519.Sign(p.key, []byte(input))))
+}
+
+func randomBytes(t *testing.T) []byte {
+    t.Helper()
+    b := make([]byte, 16)
+    if _, err := rand.Read(b); err != nil {
+        t.Fatal(err)
+    }
+    return b
+}
+
+// dpopNonce fetches the server nonce proofs to base must carry
+func dpopNonce(t *testing.T, base string) string {
+    t.Helper()
+    return dpopNonceWith(t, http.DefaultClient, base)
+}
+
+// dpopNonceWith is dpopNonce fetched by client
+func dpopNonceWith(t *testing.T, client *http.Client, base string) string {
+    t.Helper()
+    resp, err := client.Get(base + "/v1/token")
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    nonce := resp.Header.Get("DPoP-Nonce")
+    if nonce == "" {
+        t.Fatal("no DPoP-Nonce header")
+    }
+    return nonce
+}
+
+// TestDPoPBindsToken issues a token with a proof, so it carries the proof key's cnf.jkt, and
+// presents it onwards: a proof from another key or no proof at all is refused before the
+// token is looked at. TestOnBehalfOf presents one with the right proof
+func TestDPoPBindsToken(t *testing.T) {
+    cfg := testConfig()
+    srv := testTokend(t, cfg, testStores(t, cfg))
+    nonce := dpopNonce(t, srv.URL)
+    holder := newProver(t)
+
+    var actor tokenResponse
+    if code := postJSON(t, srv.URL+"/v1/token", tokenRequest{Identity: "svc", Room: "r"}, &actor, func(r *http.Request) {
+        withAPIKey(r)
+        holder.sign(t, r, nonce, "")
+    }); code != http.StatusOK {
+        t.Fatalf("issue with proof: status %d", code)
+    }
+    grant, err := auth.ParseUnverified(actor.Token)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want, err := holder.thumbprint()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if grant.Confirmation == nil || grant.Confirmation.KeyThumbprint != want {
+        t.Fatalf("cnf = %+v, want jkt %s", grant.Confirmation, want)
+    }
+
+    var subject tokenResponse
+    if code := postJSON(t, srv.URL+"/v1/token", tokenRequest{Identity: "alice", Room: "r"}, &subject, withAPIKey); code != http.StatusOK {
+        t.Fatalf("issue without proof: status %d", code)
+    }
+    exchange := func(prepare func(*http.Request)) int {
+        return postJSON(t, srv.URL+"/v1/on-behalf-of", onBehalfOfRequest{SubjectToken: subject.Token}, nil, prepare)
+    }
+    if code := exchange(func(r *http.Request) {
+        r.Header.Set("Authorization", "DPoP "+actor.Token)
+        newProver(t).sign(t, r, nonce, actor.Token)
+    }); code != http.StatusUnauthorized {
+        t.Fatalf("proof signed by another key: status %d, want 401", code)
+    }
+    if code := exchange(func(r *http.Request) {
+        r.Header.Set("Authorization", "Bearer "+actor.Token)
+    }); code != http.StatusUnauthorized {
+        t.Fatalf("key-bound token as bearer: status %d, want 401", code)
+    }
+}
+
+// thumbprint is the RFC 7638 thumbprint of the prover's key
+func (p *prover) thumbprint() (string, error) {
+    pub, err := base64.RawURLEncoding.DecodeString(p.jwk["x"])
+    if err != nil {
+        return "", err
+    }
+    return auth.JWKThumbprint(pub, "Ed25519")
+}
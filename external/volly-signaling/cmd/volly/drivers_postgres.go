@@ -0,0 +1,6 @@
+//go:build postgres
+
+package main
+
+// Build with -tags postgres after `go get github.com/jackc/pgx/v5`
+import _ "github.com/jackc/pgx/v5/stdlib"
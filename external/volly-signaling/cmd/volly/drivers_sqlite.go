@@ -0,0 +1,7 @@
+//go:build sqlite
+
+package main
+
+// Build with -tags sqlite after `go get modernc.org/sqlite`, a pure-Go driver that keeps
+// the binary CGO-free
+import _ "modernc.org/sqlite"
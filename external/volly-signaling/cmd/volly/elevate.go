@@ -0,0 +1,129 @@
+package main
+
+import (
+    "errors"
+    "net/http"
+    "net/netip"
+    "strings"
+    "time"
+
+    lkauth "github.com/livekit/protocol/auth"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/audit"
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/elevation"
+)
+
+var errElevationOff = errors.New("elevation is not configured")
+
+type elevateRequest struct {
+    Permissions []string `json:"permissions"`
+    TTL         string   `json:"ttl,omitempty"`
+    // Response is the second factor, passed to the MFA service as is
+    Response string `json:"response"`
+}
+
+// newElevator is nil unless an MFA webhook is configured
+func newElevator(cfg *config) *elevation.Elevator {
+    if cfg.Elevation.Webhook == "" {
+        return nil
+    }
+    e := elevation.New(elevation.NewWebhook(cfg.Elevation.Webhook, []byte(cfg.Elevation.Secret)))
+    if len(cfg.Elevation.Permissions) > 0 {
+        e.SetPermissions(cfg.Elevation.Permissions...)
+    }
+    if d := cfg.Elevation.MaxTTL.Duration; d > 0 {
+        e.SetMaxTTL(d)
+    }
+    return e
+}
+
+// elevate serves POST /v1/elevate: the caller presents their session token as a bearer
+// credential and a second factor, and gets a short-lived token with the extra permissions,
+// signed under the session token's own API key
+func elevate(cfg *config, s *stores, elevator *elevation.Elevator) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if elevator == nil {
+            writeError(w, errElevationOff)
+            return
+        }
+        token := credential(r)
+        if token == "" || auth.IsViewerToken(token) {
+            writeError(w, errBadCredentials)
+            return
+        }
+        var req elevateRequest
+        if !readJSON(w, r, &req) {
+            return
+        }
+        var ttl time.Duration
+        if req.TTL != "" {
+            d, err := time.ParseDuration(req.TTL)
+            if err != nil || d <= 0 {
+                http.Error(w, "invalid ttl", http.StatusBadRequest)
+                return
+            }
+            ttl = d
+        }
+
+        session, err := verifyRequest(r, cfg, s, token)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        parsed, err := lkauth.ParseAPIToken(token)
+        if err != nil {
+            writeError(w, errBadCredentials)
+            return
+        }
+        key, err := activeKey(r.Context(), s, parsed.APIKey())
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+
+        var remote netip.Addr
+        if addrPort, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+            remote = addrPort.Addr()
+        }
+        grant, ttl, err := elevator.Elevate(r.Context(), session, elevation.Request{
+            Permissions: req.Permissions,
+            TTL:         ttl,
+            Response:    req.Response,
+            RemoteIP:    remote,
+        })
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        at := accessToken(s, key).
+            AddGrant(grant).
+            SetIdentity(session.Identity).
+            SetProvenance(session.Provenance.Derive(provenanceService, version)).
+            SetValidFor(ttl).
+            SetElevatedFrom(session.TokenID).
+            SetClaimSchemas(s.claimSchemas).
+            SetCryptoProfiles(s.cryptoProfiles).
+            SetIssuanceCheck(s.killSwitch.CheckGrant)
+        elevated, err := scopeToken(cfg, at, key.Tenant).ToJWT()
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+
+        e := audit.Entry{Actor: session.Identity, Action: "token.elevate", Tenant: session.Tenant, Target: session.Room, Tags: session.Tags,
+            Detail: map[string]string{
+                "permissions": strings.Join(req.Permissions, ","),
+                "session":     session.TokenID,
+                "ttl":         ttl.String(),
+                "remoteAddr":  r.RemoteAddr,
+            }}
+        if err := s.audit.Append(r.Context(), e); err != nil {
+            // An elevation that cannot be audited is not handed out
+            writeError(w, err)
+            return
+        }
+        tokenIssued(r.Context(), s, key, session.Identity, session.Tags)
+        writeJSON(w, http.StatusOK, tokenResponse{Token: elevated, ExpiresAt: at.ExpiresAt()})
+    }
+}
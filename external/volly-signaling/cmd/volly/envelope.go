@@ -0,0 +1,132 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/envelope"
+    "github.com/volly-org/volly-signaling/pkg/volly/secure"
+    "github.com/volly-org/volly-signaling/pkg/volly/sqlstore"
+)
+
+// keyWrapper builds the KMS cfg.Database.Envelope names, nil when envelopes are off
+func keyWrapper(cfg *config) (envelope.KeyWrapper, io.Closer, error) {
+    d := cfg.Database
+    switch d.Envelope.KMS {
+    case "":
+        return nil, nil, nil
+    case "local":
+        current, err := localKEK("VOLLY_SEAL_KEY", d.SealKey)
+        if err != nil {
+            return nil, nil, err
+        }
+        kek := envelope.NewLocalKEK(current.id, current.key)
+        if d.SealKeyPrevious != "" {
+            previous, err := localKEK("VOLLY_SEAL_KEY_PREVIOUS", d.SealKeyPrevious)
+            if err != nil {
+                kek.Close()
+                return nil, nil, err
+            }
+            kek.AddRetired(previous.id, previous.key)
+        }
+        return kek, kek, nil
+    case "vault":
+        e := d.Envelope
+        if e.VaultAddress == "" || e.VaultKey == "" || e.VaultToken == "" {
+            return nil, nil, errors.New("database.envelope.vaultAddress, vaultKey and VOLLY_VAULT_TOKEN must be set with kms vault")
+        }
+        v := envelope.NewVaultTransit(e.VaultAddress, e.VaultKey, e.VaultToken)
+        if e.VaultMount != "" {
+            v.SetMount(e.VaultMount)
+        }
+        return v, nil, nil
+    }
+    return nil, nil, fmt.Errorf("unknown database.envelope.kms %q", d.Envelope.KMS)
+}
+
+type namedKey struct {
+    id  string
+    key *secure.SecureBytes
+}
+
+// localKEK names a seal key by its fingerprint, so a rotated VOLLY_SEAL_KEY is told apart
+func localKEK(env, encoded string) (namedKey, error) {
+    raw, err := base64.StdEncoding.DecodeString(encoded)
+    if err != nil || len(raw) != 32 {
+        return namedKey{}, errors.New(env + " must be 32 bytes of base64")
+    }
+    sum := sha256.Sum256(raw)
+    key, err := secure.FromBytes(raw)
+    if err != nil {
+        return namedKey{}, err
+    }
+    return namedKey{id: "local:" + hex.EncodeToString(sum[:8]), key: key}, nil
+}
+
+// newEnvelope seals the SQL stores' secrets under data keys kept in db, and returns the
+// job that rotates them; nil when envelopes are off
+func newEnvelope(ctx context.Context, cfg *config, s *stores, db *sqlstore.DB, configStore *sqlstore.ConfigStore, registry *sqlstore.KeyRegistry) (*envelope.ResealJob, error) {
+    kms, closer, err := keyWrapper(cfg)
+    if err != nil || kms == nil {
+        return nil, err
+    }
+    if closer != nil {
+        s.closers = append(s.closers, closer)
+    }
+    sealer, err := envelope.NewSealer(ctx, kms, sqlstore.NewDataKeyStore(db))
+    if err != nil {
+        return nil, fmt.Errorf("envelope: %w", err)
+    }
+    s.closers = append(s.closers, sealer)
+    configStore.SetSealer(sealer)
+    registry.SetSealer(sealer)
+
+    e := cfg.Database.Envelope
+    job := envelope.NewResealJob(sealer).
+        Add("api_keys", configStore).
+        Add("pq_keys", registry)
+    if d := e.DataKeyLifetime.Duration; d > 0 {
+        job.SetDataKeyLifetime(d)
+    }
+    if d := e.ResealInterval.Duration; d > 0 {
+        job.SetInterval(d)
+    }
+    s.sealer = sealer
+    return job, nil
+}
+
+type envelopeStatus struct {
+    Current  envelope.DataKey   `json:"current"`
+    DataKeys []envelope.DataKey `json:"dataKeys"`
+    Last     *envelope.Result   `json:"last,omitempty"`
+}
+
+// envelopeRoutes shows the data keys and rotates on demand, e.g. after a suspected leak
+func envelopeRoutes(mux *http.ServeMux, s *stores) {
+    mux.HandleFunc("GET /v1/envelope", func(w http.ResponseWriter, r *http.Request) {
+        if s.envelope == nil {
+            http.Error(w, "envelope encryption is off; set database.envelope.kms", http.StatusNotFound)
+            return
+        }
+        writeJSON(w, http.StatusOK, envelopeStatus{Current: s.sealer.Current(), DataKeys: s.sealer.DataKeys(), Last: s.envelope.Last()})
+    })
+    mux.HandleFunc("POST /v1/envelope/rotate", func(w http.ResponseWriter, r *http.Request) {
+        if s.envelope == nil {
+            http.Error(w, "envelope encryption is off; set database.envelope.kms", http.StatusNotFound)
+            return
+        }
+        res := s.envelope.Pass(r.Context(), true)
+        record(r, s, "envelope.rotate", "", res.Rotated)
+        status := http.StatusOK
+        if len(res.Errors) > 0 {
+            status = http.StatusInternalServerError
+        }
+        writeJSON(w, status, res)
+    })
+}
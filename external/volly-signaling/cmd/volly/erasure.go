@@ -0,0 +1,100 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "net/http"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/audit"
+    "github.com/volly-org/volly-signaling/pkg/volly/erasure"
+    "github.com/volly-org/volly-signaling/pkg/volly/keys"
+)
+
+// newEraser registers every store that holds identities. Tombstones and receipts must be
+// stable across restarts and instances, so erasure needs VOLLY_ERASURE_KEY and is off
+// without it
+func newEraser(cfg *config, s *stores) (*erasure.Eraser, error) {
+    if cfg.ErasureKey == "" {
+        return nil, nil
+    }
+    key, err := sharedKey("VOLLY_ERASURE_KEY", cfg.ErasureKey)
+    if err != nil {
+        return nil, err
+    }
+    e := erasure.New(key)
+    e.Add("keys", erasure.ActionDeleted, erasure.TargetFunc(func(ctx context.Context, sub erasure.Subject) (int, error) {
+        err := s.keys.Delete(ctx, sub.Identity)
+        if errors.Is(err, keys.ErrKeyNotFound) {
+            return 0, nil
+        }
+        return 1, err
+    }))
+    e.Add("passkeys", erasure.ActionDeleted, erasure.TargetFunc(func(ctx context.Context, sub erasure.Subject) (int, error) {
+        creds, err := s.webauthn.List(ctx, sub.Tenant, sub.Identity)
+        if err != nil {
+            return 0, err
+        }
+        for i, c := range creds {
+            if err := s.webauthn.Delete(ctx, sub.Tenant, c.ID); err != nil {
+                return i, err
+            }
+        }
+        return len(creds), nil
+    }))
+    e.Add("presence", erasure.ActionDeleted, erasure.TargetFunc(func(ctx context.Context, sub erasure.Subject) (int, error) {
+        return s.watcher.Forget(sub.Tenant, sub.Identity), nil
+    }))
+    e.Add("grantCache", erasure.ActionDeleted, erasure.TargetFunc(func(ctx context.Context, sub erasure.Subject) (int, error) {
+        return s.grantCache.Forget(sub.Tenant, sub.Identity), nil
+    }))
+    if t, ok := s.audit.(audit.Tombstoner); ok {
+        e.Add("audit", erasure.ActionTombstoned, erasure.TargetFunc(func(ctx context.Context, sub erasure.Subject) (int, error) {
+            return t.Tombstone(ctx, sub.Tenant, sub.Identity, sub.Tombstone)
+        }))
+    }
+    e.Retain("revocations: kept so the subject's revoked tokens stay rejected until they expire")
+    e.Retain("directory: users provisioned over SCIM or LDAP come back unless the identity provider deprovisions them")
+    return e, nil
+}
+
+type erasureRequest struct {
+    Tenant   string `json:"tenant"`
+    Identity string `json:"identity"`
+}
+
+// erasureRoutes serves right-to-be-forgotten requests. The audit entry for an erasure names
+// the subject by its tombstone, so it does not undo what it records
+func erasureRoutes(mux *http.ServeMux, s *stores) {
+    mux.HandleFunc("POST /v1/erasures", func(w http.ResponseWriter, r *http.Request) {
+        if s.eraser == nil {
+            http.Error(w, "erasure is off; set VOLLY_ERASURE_KEY", http.StatusNotFound)
+            return
+        }
+        var req erasureRequest
+        if !readJSON(w, r, &req) {
+            return
+        }
+        receipt, err := s.eraser.Erase(r.Context(), req.Tenant, req.Identity)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        record(r, s, "identity.erase", req.Tenant, receipt.Subject)
+        writeJSON(w, http.StatusOK, receipt)
+    })
+    mux.HandleFunc("POST /v1/erasures/verify", func(w http.ResponseWriter, r *http.Request) {
+        if s.eraser == nil {
+            http.Error(w, "erasure is off; set VOLLY_ERASURE_KEY", http.StatusNotFound)
+            return
+        }
+        var receipt erasure.Receipt
+        if !readJSON(w, r, &receipt) {
+            return
+        }
+        if err := s.eraser.Verify(&receipt); err != nil {
+            writeError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, map[string]bool{"valid": true})
+    })
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+    "context"
+    "log"
+    "strconv"
+    "sync"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/events"
+    "github.com/volly-org/volly-signaling/pkg/volly/gateway"
+    "github.com/volly-org/volly-signaling/pkg/volly/protocol"
+)
+
+// eventGrantExpired is published when a connected participant's grant runs out and again
+// when their connection ends because of it
+const eventGrantExpired = "grant.expired"
+
+// renewResponse is the lease and, once the connection's grant ran out, what the edge must do
+type renewResponse struct {
+    gateway.Lease
+    Expiry *gateway.Expiry `json:"expiry,omitempty"`
+}
+
+// newExpiryEnforcer forgets connections after two lease ttls without a renewal
+func newExpiryEnforcer(cfg *config, s *stores) *gateway.ExpiryEnforcer {
+    x := gateway.NewExpiryEnforcer().
+        SetReport(func(ctx context.Context, e gateway.Expiry) {
+            grantExpired(ctx, s, e)
+        })
+    if ttl := cfg.Connections.LeaseTTL.Duration; ttl > 0 {
+        x.SetStaleAfter(2 * ttl)
+    }
+    return x
+}
+
+// grantExpired logs and publishes an expiry and tells the participant over signaling
+func grantExpired(ctx context.Context, s *stores, e gateway.Expiry) {
+    if e.Ended {
+        log.Printf("gateway: connection %s of %s in room %s ended, its grant ran out", e.ConnectionID, s.redactor.Identity(e.Identity), e.Room)
+    } else {
+        log.Printf("gateway: grant of %s in room %s ran out, applying %s", s.redactor.Identity(e.Identity), e.Room, e.Action)
+    }
+    event := events.Event{Type: eventGrantExpired, Room: e.Room, Identity: e.Identity,
+        Data: map[string]string{"tenant": e.Tenant, "connection": e.ConnectionID, "action": string(e.Action), "ended": strconv.FormatBool(e.Ended)}}
+    if err := s.bus.Publish(ctx, event); err != nil {
+        log.Printf("gateway: publishing %s failed: %v", eventGrantExpired, err)
+    }
+    s.sessions.send(e.Tenant, e.Room, e.Identity, protocol.FeatureExpiryNotice, protocol.Expiring{
+        Type:         protocol.TypeExpiring,
+        Action:       string(e.Action),
+        Deadline:     e.Deadline,
+        DisconnectAt: e.DisconnectAt,
+        Ended:        e.Ended,
+    })
+}
+
+// releaseConnection frees a connection's lease, stops counting and enforcing it and runs the
+// post-leave room hooks
+func releaseConnection(ctx context.Context, s *stores, id string) error {
+    if err := s.connections.Release(id); err != nil {
+        return err
+    }
+    forgetConnection(ctx, s, id)
+    return nil
+}
+
+// forgetConnection drops what is kept for a connection whose lease is gone, released or
+// expired, and runs the post-leave room hooks
+func forgetConnection(ctx context.Context, s *stores, id string) {
+    if s.presence != nil {
+        s.presence.Leave(ctx, id)
+    }
+    s.expiry.Untrack(id)
+    s.roomHooks.Left(ctx, id)
+    if s.analytics != nil {
+        s.analytics.Left(id)
+    }
+    if s.usage != nil {
+        s.usage.SessionEnded(id)
+    }
+}
+
+type participantKey struct {
+    tenant, room, identity string
+}
+
+// signalingSessions are the events WebSockets open on this instance by participant, for
+// messages meant for one participant rather than the whole room
+type signalingSessions struct {
+    mu       sync.Mutex
+    nextID   int
+    sessions map[participantKey]map[int]*protocol.Session
+}
+
+func newSignalingSessions() *signalingSessions {
+    return &signalingSessions{sessions: make(map[participantKey]map[int]*protocol.Session)}
+}
+
+// attach adds a session until the returned function is called
+func (ss *signalingSessions) attach(p protocol.Principal, session *protocol.Session) (detach func()) {
+    key := participantKey{tenant: p.Tenant, room: p.Room, identity: p.Identity}
+    ss.mu.Lock()
+    defer ss.mu.Unlock()
+    if ss.sessions[key] == nil {
+        ss.sessions[key] = make(map[int]*protocol.Session)
+    }
+    id := ss.nextID
+    ss.nextID++
+    ss.sessions[key][id] = session
+    return func() {
+        ss.mu.Lock()
+        defer ss.mu.Unlock()
+        delete(ss.sessions[key], id)
+        if len(ss.sessions[key]) == 0 {
+            delete(ss.sessions, key)
+        }
+    }
+}
+
+// send writes msg to the participant's sessions that negotiated feature
+func (ss *signalingSessions) send(tenant, room, identity, feature string, msg interface{}) {
+    key := participantKey{tenant: tenant, room: room, identity: identity}
+    ss.mu.Lock()
+    var targets []*protocol.Session
+    for _, session := range ss.sessions[key] {
+        if session.Has(feature) {
+            targets = append(targets, session)
+        }
+    }
+    ss.mu.Unlock()
+    for _, session := range targets {
+        // A session that has gone away detaches itself
+        session.Send(msg)
+    }
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+    "context"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "log"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/extension"
+)
+
+var (
+    errAttestationOff      = errors.New("attestation is not configured")
+    errAttestationRequired = errors.New("token requests must carry an attestation")
+)
+
+// openExtension starts the plugin binary pc names; the caller closes it on shutdown
+func openExtension(name string, pc *extensionConfig) (*extension.Client, error) {
+    if pc.Path == "" {
+        return nil, fmt.Errorf("extensions.%s: path is required", name)
+    }
+    opts := extension.Options{Args: pc.Args, CallTimeout: pc.CallTimeout.Duration}
+    if pc.SHA256 != "" {
+        sum, err := hex.DecodeString(pc.SHA256)
+        if err != nil {
+            return nil, fmt.Errorf("extensions.%s: sha256 must be hex", name)
+        }
+        opts.SHA256 = sum
+    }
+    c, err := extension.Open(pc.Path, opts)
+    if err != nil {
+        return nil, fmt.Errorf("extensions.%s: %w", name, err)
+    }
+    log.Printf("extensions: %s plugin %s speaks protocol version %d", name, pc.Path, c.Version())
+    return c, nil
+}
+
+// openExtensions starts the extension plugins in cfg.Extensions: a revocation store that
+// replaces the built-in one, and an attestation verifier for tokend
+func (s *stores) openExtensions(cfg *config) error {
+    if pc := cfg.Extensions.RevocationStore; pc != nil {
+        c, err := openExtension("revocationStore", pc)
+        if err != nil {
+            return err
+        }
+        s.closers = append(s.closers, c)
+        if s.revoked, err = c.RevocationStore(); err != nil {
+            return fmt.Errorf("extensions.revocationStore: %w", err)
+        }
+    }
+    if pc := cfg.Extensions.AttestationVerifier; pc != nil {
+        c, err := openExtension("attestationVerifier", pc)
+        if err != nil {
+            return err
+        }
+        s.closers = append(s.closers, c)
+        if s.attestation, err = c.AttestationVerifier(); err != nil {
+            return fmt.Errorf("extensions.attestationVerifier: %w", err)
+        }
+    }
+    return nil
+}
+
+// checkAttestation has the attestation plugin verify the request's attestation
+func checkAttestation(ctx context.Context, cfg *config, s *stores, tenant string, req tokenRequest) error {
+    switch {
+    case req.Attestation == nil && cfg.Extensions.RequireAttestation:
+        return errAttestationRequired
+    case req.Attestation == nil:
+        return nil
+    case s.attestation == nil:
+        return errAttestationOff
+    }
+    a, err := s.attestation.VerifyAttestation(ctx, extension.AttestationRequest{
+        Tenant:   tenant,
+        Identity: req.Identity,
+        Format:   req.Attestation.Format,
+        Evidence: req.Attestation.Evidence,
+    })
+    if err != nil {
+        return err
+    }
+    log.Printf("tokend: %s attestation of %s verified at level %q", a.Format, s.redactor.Identity(req.Identity), a.Level)
+    return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/cache"
+    "github.com/volly-org/volly-signaling/pkg/volly/federation"
+)
+
+// ownDescriptor is this deployment's descriptor, nil until cfg.Federation describes it
+func ownDescriptor(cfg *config) (*federation.Descriptor, error) {
+    f := cfg.Federation
+    if f.Domain == "" && f.GatewayURL == "" && f.JWKSURI == "" {
+        return nil, nil
+    }
+    d := &federation.Descriptor{
+        Version:      federation.DescriptorVersion,
+        Domain:       f.Domain,
+        Issuer:       cfg.Issuer,
+        GatewayURL:   f.GatewayURL,
+        KeyserverURL: f.KeyserverURL,
+        JWKSURI:      f.JWKSURI,
+    }
+    if err := d.Validate(); err != nil {
+        return nil, fmt.Errorf("federation: %w", err)
+    }
+    return d, nil
+}
+
+// newFederation builds discovery for cfg.Federation.Peers, nil when there are none, and
+// discovers each peer once in the background so their documents are cached before use
+func newFederation(ctx context.Context, cfg *config) (*federation.Peers, error) {
+    f := cfg.Federation
+    if len(f.Peers) == 0 {
+        return nil, nil
+    }
+    resolver, err := federation.NewDNSResolver(f.Resolver)
+    if err != nil {
+        return nil, fmt.Errorf("federation.resolver: %w", err)
+    }
+    if f.CacheDir != "" {
+        if err := os.MkdirAll(f.CacheDir, 0o700); err != nil {
+            return nil, fmt.Errorf("federation.cacheDir: %w", err)
+        }
+    }
+    docs := cache.NewDocuments(f.CacheDir)
+    if d := f.Fresh.Duration; d > 0 {
+        docs.SetFresh(d)
+    }
+    if d := f.MaxStale.Duration; d > 0 {
+        docs.SetMaxStale(d)
+    }
+    peers := federation.NewPeers(federation.NewDiscoverer(resolver, docs).SetRequireDNSSEC(f.RequireDNSSEC), f.Peers)
+    go func() {
+        for _, domain := range peers.Domains() {
+            p, err := peers.Get(ctx, domain)
+            if err != nil {
+                log.Printf("federation: discovering %s: %v", domain, err)
+                continue
+            }
+            log.Printf("federation: %s found via %s (dnssec=%t) with %d keys", domain, p.Source, p.DNSSEC, len(p.Keys))
+        }
+    }()
+    return peers, nil
+}
+
+// federationRoutes shows what discovery finds for the configured peers
+func federationRoutes(mux *http.ServeMux, s *stores) {
+    mux.HandleFunc("GET /v1/federation/peers", func(w http.ResponseWriter, r *http.Request) {
+        if s.federation == nil {
+            http.Error(w, "no federation peers are configured; set federation.peers", http.StatusNotFound)
+            return
+        }
+        writeJSON(w, http.StatusOK, s.federation.Domains())
+    })
+    mux.HandleFunc("GET /v1/federation/peers/{domain}", func(w http.ResponseWriter, r *http.Request) {
+        if s.federation == nil {
+            http.Error(w, "no federation peers are configured; set federation.peers", http.StatusNotFound)
+            return
+        }
+        p, err := s.federation.Get(r.Context(), r.PathValue("domain"))
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, p)
+    })
+}
+
+// wellKnownRoutes serves this deployment's federation descriptor, when it has one
+func wellKnownRoutes(mux *http.ServeMux, s *stores) {
+    if s.descriptor == nil {
+        return
+    }
+    mux.HandleFunc("GET "+federation.WellKnownPath, func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Cache-Control", "max-age=300")
+        writeJSON(w, http.StatusOK, s.descriptor)
+    })
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+    "context"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/flags"
+)
+
+// newFlags asks the OFREP service in cfg.Flags, or answers every flag with its default
+func newFlags(cfg *config) *flags.Flags {
+    c := cfg.Flags
+    if c.OFREP == "" {
+        return flags.New(nil)
+    }
+    provider := flags.NewOFREP(c.OFREP)
+    if cfg.FlagsToken != "" {
+        provider.SetHeader("Authorization", "Bearer "+cfg.FlagsToken)
+    }
+    f := flags.New(provider)
+    if d := c.CacheTTL.Duration; d > 0 {
+        f.SetCacheTTL(d)
+    }
+    if d := c.Timeout.Duration; d > 0 {
+        f.SetTimeout(d)
+    }
+    return f
+}
+
+// viewerTokensOn reports whether viewer tokens are issued and admitted for a tenant's room;
+// allowViewerTokens is the default the viewer-tokens flag overrides
+func viewerTokensOn(ctx context.Context, cfg *config, s *stores, tenant, room, identity string) bool {
+    return s.flags.Bool(ctx, flags.ViewerTokens, flags.Target{Tenant: tenant, Room: room, Identity: identity}, cfg.AllowViewerTokens)
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/base64"
+    "errors"
+    "log"
+    "net/http"
+    "net/netip"
+    "strings"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/anomaly"
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+    "github.com/volly-org/volly-signaling/pkg/volly/flags"
+    "github.com/volly-org/volly-signaling/pkg/volly/gateway"
+    "github.com/volly-org/volly-signaling/pkg/volly/killswitch"
+    "github.com/volly-org/volly-signaling/pkg/volly/lifecycle"
+    "github.com/volly-org/volly-signaling/pkg/volly/netpolicy"
+    "github.com/volly-org/volly-signaling/pkg/volly/presence"
+    "github.com/volly-org/volly-signaling/pkg/volly/protocol"
+    "github.com/volly-org/volly-signaling/pkg/volly/revocation"
+    "github.com/volly-org/volly-signaling/pkg/volly/stepup"
+    "github.com/volly-org/volly-signaling/pkg/volly/tagset"
+    "github.com/volly-org/volly-signaling/pkg/volly/wasmplugin"
+    "github.com/volly-org/volly-signaling/pkg/volly/watch"
+    "github.com/volly-org/volly-signaling/pkg/volly/websocket"
+)
+
+type admitRequest struct {
+    Token string `json:"token"`
+    Room  string `json:"room,omitempty"`
+    // RemoteIP is the client address seen by the media edge, for network and geo hooks
+    RemoteIP string `json:"remoteIP,omitempty"`
+    // Location is where the edge places the client, for anomaly detection's travel check
+    Location *anomaly.Location `json:"location,omitempty"`
+    // Receipt answers the join challenge given to an identity anomaly detection flagged
+    Receipt string `json:"receipt,omitempty"`
+    // PIN is the room access code, sent once the room has asked for it
+    PIN string `json:"pin,omitempty"`
+    // KeyProof answers a challenge from POST /v1/admit/challenge, and is required when the
+    // token is bound to a key
+    KeyProof *keyProofRequest `json:"keyProof,omitempty"`
+}
+
+// keyProofRequest is a signature by the token's bound key over gateway.KeyProofMessage of
+// the challenge nonce, identity and room
+type keyProofRequest struct {
+    Nonce     []byte `json:"nonce"`
+    Algorithm string `json:"algorithm"`
+    PublicKey []byte `json:"publicKey"`
+    Signature []byte `json:"signature"`
+}
+
+type keyProofChallengeResponse struct {
+    Nonce     []byte    `json:"nonce"`
+    ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type admitResponse struct {
+    Identity string        `json:"identity"`
+    Room     string        `json:"room"`
+    Tenant   string        `json:"tenant,omitempty"`
+    PQStatus auth.PQStatus `json:"pqStatus"`
+    // ConnectionID names the connection's lease; the edge renews it while the client stays
+    // and deletes it when the client leaves
+    ConnectionID   string    `json:"connectionId"`
+    LeaseExpiresAt time.Time `json:"leaseExpiresAt,omitempty"`
+    // ElevatedFrom is set for elevation tokens: the edge honours them only on the connection
+    // opened with the session token of that jti
+    ElevatedFrom string `json:"elevatedFrom,omitempty"`
+    // Actor is set for delegation tokens: the services acting for Identity, the last first
+    Actor *auth.Actor `json:"actor,omitempty"`
+}
+
+// newGateway serves POST /v1/admit, which the media edge calls before letting a client join,
+// and the connection lease endpoints it calls while the client stays connected. Clients watch
+// their room's events over a WebSocket at GET /v1/rooms/{room}/events, opening with a
+// protocol hello. A client reconnecting without a token must resume with a ticket from an
+// earlier session. A client whose token is bound to a key proves it holds the key, over the
+// WebSocket with a key_proof or to POST /v1/admit by answering POST /v1/admit/challenge.
+// Before a sensitive action the edge asks POST /v1/step-up/authorize, and
+// a challenged client answers at POST /v1/step-up/verify. Participants who may only watch
+// raise hands under /v1/rooms/{room}/hands for a host to approve
+func newGateway(cfg *config, s *stores) (http.Handler, error) {
+    // Admissions to one room are decided in arrival order, so a kill switch or revocation
+    // that lands between two joins applies to every later one
+    policies := gateway.NewRoomPolicies(s.config).
+        SetCounter(s.watcher).
+        SetShadowReport(func(ctx context.Context, d gateway.ShadowDecision) {
+            log.Printf("gateway: shadow room policies would %s %s in room %s of tenant %s (enforced: %s)",
+                shadowVerdict(d.Proposed), s.redactor.Identity(d.Identity), d.Room, d.Tenant, shadowVerdict(d.Enforced))
+        })
+    chain := gateway.AdmissionChain{s.blocklist, s.killSwitch, s.revocations, s.expiry, s.keyProofs, s.geo, policies}
+    if s.pinGate != nil {
+        // After the policies, so a room that refuses the caller anyway never costs an attempt
+        chain = append(chain, s.pinGate)
+    }
+    for _, p := range s.wasmPlugins {
+        chain = append(chain, p)
+    }
+    // Deployment hooks see only joins the gateway would let through
+    chain = append(chain, s.roomHooks)
+    hooks := s.rooms.Hook(append(chain, gateway.NewFeatureFlags(s.flags), s.watcher))
+    upgrader := websocket.NewUpgrader().
+        SetCompression(!cfg.WebSocket.DisableCompression, cfg.WebSocket.CompressionThreshold)
+    tickets, err := ticketSealer(cfg)
+    if err != nil {
+        return nil, err
+    }
+    refresh := func(ctx context.Context, token string) (protocol.Principal, error) {
+        grant, err := verifyToken(ctx, cfg, s, token)
+        if err != nil {
+            return protocol.Principal{}, err
+        }
+        return grantPrincipal(grant), nil
+    }
+    handshake := protocol.NewServer().
+        SetTickets(tickets).
+        SetClockSkews(s.clockSkews).
+        SetTokenVerifier(refresh).
+        SetNonces(func() ([]byte, error) {
+            challenge, err := s.keyProofs.NewChallenge()
+            if err != nil {
+                return nil, err
+            }
+            return challenge.Nonce, nil
+        })
+    // Streams with the handshake-v2 flag off are held to version 1
+    handshakeV1 := protocol.NewServer().
+        SetVersions(protocol.Version1).
+        SetClockSkews(s.clockSkews)
+
+    guard, err := newStepUpGuard(cfg, s)
+    if err != nil {
+        return nil, err
+    }
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("POST /v1/admit/challenge", func(w http.ResponseWriter, r *http.Request) {
+        challenge, err := s.keyProofs.NewChallenge()
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, keyProofChallengeResponse{Nonce: challenge.Nonce, ExpiresAt: challenge.ExpiresAt})
+    })
+    mux.HandleFunc("POST /v1/admit", func(w http.ResponseWriter, r *http.Request) {
+        var req admitRequest
+        if !readJSON(w, r, &req) {
+            return
+        }
+        grant, err := verifyToken(r.Context(), cfg, s, req.Token)
+        if err != nil {
+            // The edge's own address is never blocked, only the client's it reports
+            client, _ := netip.ParseAddr(req.RemoteIP)
+            tripwire(r.Context(), s, client, err)
+            // Without a verified identity the caller is counted by address
+            subject := req.RemoteIP
+            if subject == "" {
+                subject = r.RemoteAddr
+            }
+            reason := failureReason(err)
+            if reason == "other" {
+                reason = "invalid_token"
+            }
+            joinFailed(s, "", nil, subject, reason)
+            writeError(w, err)
+            return
+        }
+        if req.Room != "" && req.Room != grant.Room {
+            joinFailed(s, grant.Tenant, grant.Tags, grant.Identity, "room_mismatch")
+            http.Error(w, "token is not valid for this room", http.StatusForbidden)
+            return
+        }
+
+        a := &gateway.Admission{Identity: grant.Identity, Room: grant.Room, Grant: grant, PIN: req.PIN}
+        if p := req.KeyProof; p != nil {
+            a.KeyProof = &gateway.KeyProof{Nonce: p.Nonce, Algorithm: p.Algorithm, PublicKey: p.PublicKey, Signature: p.Signature}
+        }
+        if req.RemoteIP != "" {
+            if a.RemoteIP, err = netip.ParseAddr(req.RemoteIP); err != nil {
+                http.Error(w, "invalid remoteIP", http.StatusBadRequest)
+                return
+            }
+        }
+        // Detection sees the join before the hooks, so a revoke finding refuses this one too
+        observe(r.Context(), s, anomaly.Event{Kind: anomaly.KindVerified, Tenant: grant.Tenant, Identity: grant.Identity,
+            Room: grant.Room, TokenID: grant.TokenID, RemoteIP: a.RemoteIP, Location: req.Location})
+        if s.anomaly != nil && guard != nil && s.anomaly.StepUpRequired(grant.Tenant, grant.Identity) {
+            err := guard.Require(grantSubject(grant), stepup.ActionJoin, req.Receipt)
+            if stepUpChallenge(w, stepup.ActionJoin, err) {
+                joinFailed(s, grant.Tenant, grant.Tags, grant.Identity, failureReason(err))
+                return
+            }
+            if err != nil {
+                writeError(w, err)
+                return
+            }
+        }
+        if err := hooks.Admit(r.Context(), a); err != nil {
+            joinFailed(s, grant.Tenant, grant.Tags, grant.Identity, failureReason(err))
+            if !pinChallenge(w, err) {
+                writeError(w, err)
+            }
+            return
+        }
+        // Caps are applied last so a connection that would be refused anyway never waits
+        lease, err := s.connections.Acquire(r.Context(), grant.Tenant, a.RemoteIP)
+        if err != nil {
+            joinFailed(s, grant.Tenant, grant.Tags, grant.Identity, failureReason(err))
+            writeError(w, err)
+            return
+        }
+        if err := admitSession(r.Context(), s, lease, grant); err != nil {
+            joinFailed(s, grant.Tenant, grant.Tags, grant.Identity, failureReason(err))
+            writeError(w, err)
+            return
+        }
+        s.expiry.Track(lease.ID, grant)
+        s.roomHooks.Joined(lease.ID, grant)
+        s.rollout.Observe(grant.Identity, rolloutAdmitted)
+        if s.analytics != nil {
+            s.analytics.Joined(grant.Tenant, grant.Tags, grant.Room, grant.Identity, lease.ID)
+        }
+        if s.usage != nil {
+            s.usage.SessionStarted(grant.Tenant, grant.Tags, lease.ID)
+        }
+        writeJSON(w, http.StatusOK, admitResponse{
+            Identity:       grant.Identity,
+            Room:           grant.Room,
+            Tenant:         grant.Tenant,
+            PQStatus:       grant.PQStatus,
+            ConnectionID:   lease.ID,
+            LeaseExpiresAt: lease.ExpiresAt,
+            ElevatedFrom:   grant.ElevatedFrom,
+            Actor:          grant.Actor,
+        })
+    })
+    mux.HandleFunc("POST /v1/connections/{id}/renew", func(w http.ResponseWriter, r *http.Request) {
+        lease, err := s.connections.Renew(r.PathValue("id"))
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        expiry := s.expiry.Check(r.Context(), lease.ID)
+        if expiry != nil && expiry.Ended {
+            // The edge closes the connection on 410, so its slot is freed here
+            if err := releaseConnection(r.Context(), s, lease.ID); err != nil {
+                writeError(w, err)
+                return
+            }
+            writeJSON(w, http.StatusGone, renewResponse{Lease: lease, Expiry: expiry})
+            return
+        }
+        s.roomHooks.Seen(lease.ID)
+        if s.usage != nil {
+            s.usage.SessionSeen(lease.ID)
+        }
+        writeJSON(w, http.StatusOK, renewResponse{Lease: lease, Expiry: expiry})
+    })
+    mux.HandleFunc("DELETE /v1/connections/{id}", func(w http.ResponseWriter, r *http.Request) {
+        if err := releaseConnection(r.Context(), s, r.PathValue("id")); err != nil {
+            writeError(w, err)
+            return
+        }
+        w.WriteHeader(http.StatusNoContent)
+    })
+    mux.HandleFunc("POST /v1/step-up/authorize", stepUpAuthorize(cfg, s, guard))
+    mux.HandleFunc("POST /v1/step-up/verify", stepUpVerify(cfg, s, guard))
+    handRoutes(mux, cfg, s)
+    mux.HandleFunc("GET /v1/rooms/{room}/events", func(w http.ResponseWriter, r *http.Request) {
+        // Browsers cannot set headers on a WebSocket, so the token may also come as access_token
+        token := bearer(r)
+        if token == "" {
+            token = r.URL.Query().Get("access_token")
+        }
+        var principal protocol.Principal
+        var grant *auth.VollyVideoGrant
+        if token != "" {
+            var err error
+            grant, err = verifyRequest(r, cfg, s, token)
+            if err != nil {
+                writeError(w, err)
+                return
+            }
+            principal = grantPrincipal(grant)
+            if principal.Room != r.PathValue("room") {
+                http.Error(w, "token is not valid for this room", http.StatusForbidden)
+                return
+            }
+        }
+        var types []watch.EventType
+        if v := r.URL.Query().Get("types"); v != "" {
+            for _, t := range strings.Split(v, ",") {
+                types = append(types, watch.EventType(t))
+            }
+        }
+        tags, err := tagset.Parse(r.URL.Query().Get("tags"))
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+
+        conn, err := upgrader.Upgrade(w, r)
+        if err != nil {
+            return
+        }
+        server := handshake
+        if !s.flags.Bool(r.Context(), flags.HandshakeV2, flags.Target{Tenant: principal.Tenant, Room: r.PathValue("room"), Identity: principal.Identity}, true) {
+            server = handshakeV1
+        }
+        session, err := server.Accept(r.Context(), conn)
+        if err != nil {
+            conn.CloseWithCode(websocket.ClosePolicyViolation, err.Error())
+            return
+        }
+        if grant != nil && grant.Confirmation != nil {
+            if err := proveKey(r.Context(), s, session, grant); err != nil {
+                conn.CloseWithCode(websocket.ClosePolicyViolation, err.Error())
+                return
+            }
+        }
+        if token != "" {
+            session.Authenticate(principal)
+        } else if principal, err = session.Resume(); err != nil {
+            conn.CloseWithCode(websocket.ClosePolicyViolation, err.Error())
+            return
+        }
+        if principal.Room != r.PathValue("room") {
+            session.SendError(&protocol.Error{Code: protocol.CodeUnauthorized, Type: protocol.TypeResume, Message: "ticket is not valid for this room"})
+            conn.CloseWithCode(websocket.ClosePolicyViolation, "ticket is not valid for this room")
+            return
+        }
+
+        defer pushHands(s, session, principal)()
+        defer s.sessions.attach(principal, session)()
+        req := watch.WatchRequest{Room: principal.Room, Types: types, Tags: tags}
+        err = s.watcher.Watch(req, watch.NewWebSocketStream(r.Context(), session))
+        if errors.Is(err, watch.ErrSlowConsumer) {
+            conn.CloseWithCode(websocket.CloseTryAgainLater, err.Error())
+            return
+        }
+        conn.Close()
+    })
+    return s.blocklist.Middleware(remoteAddr, mux), nil
+}
+
+// proveKey reads the key_proof of a session opened with a token bound to a key and checks it
+// holds that key, answering the client with an error if not
+func proveKey(ctx context.Context, s *stores, session *protocol.Session, grant *auth.VollyVideoGrant) error {
+    proof, err := session.ReadKeyProof()
+    if err != nil {
+        return err
+    }
+    err = s.keyProofs.Admit(ctx, &gateway.Admission{Identity: grant.Identity, Room: grant.Room, Grant: grant, KeyProof: &gateway.KeyProof{
+        Nonce:     proof.Nonce,
+        Algorithm: proof.Algorithm,
+        PublicKey: proof.PublicKey,
+        Signature: proof.Signature,
+    }})
+    if err != nil {
+        session.SendError(&protocol.Error{Code: protocol.CodeUnauthorized, Type: protocol.TypeKeyProof, Message: err.Error()})
+    }
+    return err
+}
+
+// grantPrincipal is who a WebSocket session opened with grant acts for. A grace period
+// outlasting the token keeps the session open to the end of it, so the warning and the
+// final notice both reach the participant
+func grantPrincipal(grant *auth.VollyVideoGrant) protocol.Principal {
+    expiresAt := time.Unix(grant.ExpiresAt, 0)
+    if grant.ExpiryAction() == auth.ExpiryGrace {
+        if end := grant.Deadline().Add(grant.OnExpiry.GracePeriod()); end.After(expiresAt) {
+            expiresAt = end
+        }
+    }
+    return protocol.Principal{
+        Identity:  grant.Identity,
+        Room:      grant.Room,
+        Tenant:    grant.Tenant,
+        ExpiresAt: expiresAt,
+    }
+}
+
+// joinFailed counts a refused admission for analytics and, when the token was verified and
+// subject is its identity, for the rollout cohorts
+func joinFailed(s *stores, tenant string, tags map[string]string, subject, reason string) {
+    if tenant != "" {
+        s.rollout.Observe(subject, rolloutRejected)
+    }
+    if s.analytics != nil {
+        s.analytics.Failed(tenant, tags, subject, reason)
+    }
+}
+
+// failureReason is the code analytics counts a refused admission under, so reports never
+// carry error text that could name a user or room
+func failureReason(err error) string {
+    var policy *gateway.PolicyError
+    switch {
+    case errors.Is(err, revocation.ErrTokenRevoked):
+        return "revoked"
+    case errors.Is(err, killswitch.ErrKilled):
+        return "kill_switch"
+    case errors.Is(err, netpolicy.ErrAddressBlocked):
+        return "blocked"
+    case errors.Is(err, stepup.ErrStepUpRequired):
+        return "step_up_required"
+    case errors.As(err, &policy):
+        return "room_policy"
+    case errors.Is(err, gateway.ErrRoomRequiresE2EE):
+        return "e2ee_required"
+    case errors.Is(err, gateway.ErrConnectionLimit):
+        return "connection_limit"
+    case errors.Is(err, presence.ErrSessionLimitExceeded):
+        return "session_limit"
+    case errors.Is(err, gateway.ErrGrantEnded):
+        return "grant_ended"
+    case errors.Is(err, lifecycle.ErrDenied), errors.Is(err, lifecycle.ErrHookFailed):
+        return "room_hook"
+    case errors.Is(err, wasmplugin.ErrRefused), errors.Is(err, wasmplugin.ErrPluginFailed):
+        return "policy_plugin"
+    case errors.Is(err, gateway.ErrLocationDenied), errors.Is(err, gateway.ErrLocationUnknown):
+        return "location"
+    case errors.Is(err, gateway.ErrKeyProofRequired), errors.Is(err, gateway.ErrKeyProofChallenge),
+        errors.Is(err, gateway.ErrKeyProofMismatch), errors.Is(err, crypto.ErrInvalidSignature):
+        return "key_proof"
+    case errors.Is(err, auth.ErrProvenanceMissing), errors.Is(err, auth.ErrProvenanceRejected),
+        errors.Is(err, gateway.ErrProviderNotAllowed):
+        return "provenance"
+    }
+    return "other"
+}
+
+// shadowVerdict describes a room policy decision for the shadow log
+func shadowVerdict(err error) string {
+    if err == nil {
+        return "admit"
+    }
+    return "deny (" + err.Error() + ")"
+}
+
+// ticketSealer seals resumption tickets under VOLLY_TICKET_KEY, or under a key of its own when
+// that is unset, in which case only this process can resume its sessions
+func ticketSealer(cfg *config) (*protocol.Tickets, error) {
+    key, err := sharedKey("VOLLY_TICKET_KEY", cfg.TicketKey)
+    if err != nil {
+        return nil, err
+    }
+    return protocol.NewTickets(key, cfg.WebSocket.TicketTTL.Duration)
+}
+
+// sharedKey decodes a 32 byte key from the environment variable env, or makes a random one
+// when it is unset
+func sharedKey(env, encoded string) ([]byte, error) {
+    key := make([]byte, 32)
+    if encoded == "" {
+        if _, err := rand.Read(key); err != nil {
+            return nil, err
+        }
+        return key, nil
+    }
+    raw, err := base64.StdEncoding.DecodeString(encoded)
+    if err != nil || len(raw) != 32 {
+        return nil, errors.New(env + " must be 32 bytes of base64")
+    }
+    return raw, nil
+}
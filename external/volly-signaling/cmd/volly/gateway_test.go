@@ -0,0 +1,58 @@
+package main
+
+import (
+    "crypto/ed25519"
+    "net/http"
+    "testing"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+    "github.com/volly-org/volly-signaling/pkg/volly/gateway"
+)
+
+// TestAdmitKeyBoundToken admits a token bound to a key only with a proof signed by that key
+// over a fresh challenge
+func TestAdmitKeyBoundToken(t *testing.T) {
+    cfg := testConfig()
+    s := testStores(t, cfg)
+    tokend := testTokend(t, cfg, s)
+    gw := testGateway(t, cfg, s)
+    holder := newProver(t)
+
+    var issued tokenResponse
+    nonce := dpopNonce(t, tokend.URL)
+    if code := postJSON(t, tokend.URL+"/v1/token", tokenRequest{Identity: "alice", Room: "r"}, &issued, func(r *http.Request) {
+        withAPIKey(r)
+        holder.sign(t, r, nonce, "")
+    }); code != http.StatusOK {
+        t.Fatalf("issue: status %d", code)
+    }
+
+    prove := func(signer *prover) *keyProofRequest {
+        var challenge keyProofChallengeResponse
+        if code := postJSON(t, gw.URL+"/v1/admit/challenge", struct{}{}, &challenge, nil); code != http.StatusOK {
+            t.Fatalf("challenge: status %d", code)
+        }
+        return &keyProofRequest{
+            Nonce:     challenge.Nonce,
+            Algorithm: crypto.AlgorithmEd25519,
+            PublicKey: signer.key.Public().(ed25519.PublicKey),
+            Signature: ed25519.Sign(signer.key, gateway.KeyProofMessage(challenge.Nonce, "alice", "r")),
+        }
+    }
+    admit := func(proof *keyProofRequest) int {
+        return postJSON(t, gw.URL+"/v1/admit", admitRequest{Token: issued.Token, KeyProof: proof}, nil, nil)
+    }
+    if code := admit(nil); code != http.StatusUnauthorized {
+        t.Fatalf("admit without a key proof: status %d, want 401", code)
+    }
+    if code := admit(prove(newProver(t))); code != http.StatusUnauthorized {
+        t.Fatalf("admit with another key's proof: status %d, want 401", code)
+    }
+    proof := prove(holder)
+    if code := admit(proof); code != http.StatusOK {
+        t.Fatalf("admit with the bound key's proof: status %d, want 200", code)
+    }
+    if code := admit(proof); code != http.StatusUnauthorized {
+        t.Fatalf("admit replaying a proof: status %d, want 401", code)
+    }
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "net/netip"
+    "sort"
+    "strings"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/gateway"
+)
+
+// staticGeo places addresses in the countries of cfg.Geo.Networks, longest prefix first
+type staticGeo []geoNetwork
+
+type geoNetwork struct {
+    prefix  netip.Prefix
+    country string
+}
+
+// newGeoRestriction enforces the location claims of admitted tokens, resolving countries
+// from cfg.Geo.Networks
+func newGeoRestriction(cfg *config) (*gateway.GeoRestriction, error) {
+    if len(cfg.Geo.Networks) == 0 {
+        return gateway.NewGeoRestriction(nil), nil
+    }
+    var networks staticGeo
+    for cidr, country := range cfg.Geo.Networks {
+        prefix, err := netip.ParsePrefix(cidr)
+        if err != nil {
+            return nil, fmt.Errorf("geo.networks: %w", err)
+        }
+        networks = append(networks, geoNetwork{prefix: prefix.Masked(), country: strings.ToUpper(country)})
+    }
+    sort.Slice(networks, func(i, j int) bool { return networks[i].prefix.Bits() > networks[j].prefix.Bits() })
+    return gateway.NewGeoRestriction(networks), nil
+}
+
+// Country returns the country of the most specific network holding ip, or "" when none does
+func (g staticGeo) Country(_ context.Context, ip netip.Addr) (string, error) {
+    for _, n := range g {
+        if n.prefix.Contains(ip) {
+            return n.country, nil
+        }
+    }
+    return "", nil
+}
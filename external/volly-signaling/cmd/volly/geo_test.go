@@ -0,0 +1,58 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+
+    lkauth "github.com/livekit/protocol/auth"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+)
+
+// geoToken signs a token for alice in room r restricted to countries and away from cidrs
+func geoToken(t *testing.T, countries, cidrs []string) string {
+    t.Helper()
+    token, err := auth.NewVollyAccessToken(testAPIKey, testAPISecret).
+        AddGrant(&auth.VollyVideoGrant{VideoGrant: lkauth.VideoGrant{RoomJoin: true, Room: "r"}}).
+        SetIdentity("alice").
+        SetTenant(testTenant).
+        SetGeoRestriction(countries, cidrs).
+        ToJWT()
+    if err != nil {
+        t.Fatal(err)
+    }
+    return token
+}
+
+// TestAdmitGeoRestricted refuses a token from a denied network or from outside its countries,
+// placing addresses in countries by geo.networks
+func TestAdmitGeoRestricted(t *testing.T) {
+    cfg := testConfig()
+    cfg.Geo.Networks = map[string]string{"198.51.100.0/24": "de", "198.51.100.128/25": "FR"}
+    gw := testGateway(t, cfg, testStores(t, cfg))
+    admit := func(token, ip string) int {
+        return postJSON(t, gw.URL+"/v1/admit", admitRequest{Token: token, RemoteIP: ip}, nil, nil)
+    }
+
+    denied := geoToken(t, nil, []string{"203.0.113.0/24"})
+    if code := admit(denied, "203.0.113.9"); code != http.StatusForbidden {
+        t.Fatalf("from a denied network: status %d, want 403", code)
+    }
+    if code := admit(denied, "192.0.2.1"); code != http.StatusOK {
+        t.Fatalf("from elsewhere: status %d, want 200", code)
+    }
+
+    germany := geoToken(t, []string{"DE"}, nil)
+    if code := admit(germany, "198.51.100.7"); code != http.StatusOK {
+        t.Fatalf("from Germany: status %d, want 200", code)
+    }
+    if code := admit(germany, "198.51.100.200"); code != http.StatusForbidden {
+        t.Fatalf("from the more specific French network: status %d, want 403", code)
+    }
+    if code := admit(germany, "192.0.2.1"); code != http.StatusForbidden {
+        t.Fatalf("from an unplaced address: status %d, want 403", code)
+    }
+    if code := admit(germany, ""); code != http.StatusForbidden {
+        t.Fatalf("without an address: status %d, want 403", code)
+    }
+}
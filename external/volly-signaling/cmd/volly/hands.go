@@ -0,0 +1,214 @@
+package main
+
+import (
+    "context"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+
+    lkauth "github.com/livekit/protocol/auth"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/audit"
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/handraise"
+    "github.com/volly-org/volly-signaling/pkg/volly/protocol"
+)
+
+type raiseHandRequest struct {
+    Reason string `json:"reason,omitempty"`
+}
+
+type approveHandRequest struct {
+    // Sources narrows what the participant may publish, e.g. microphone; every source when empty
+    Sources []string `json:"sources,omitempty"`
+}
+
+// handResponse is a hand and, for the participant who raised it once approved, their new token
+type handResponse struct {
+    handraise.Hand
+    Token     string    `json:"token,omitempty"`
+    ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
+
+func newHandDesk(cfg *config, s *stores) *handraise.Desk {
+    d := handraise.New(s.bus)
+    if ttl := cfg.RaiseHand.TTL.Duration; ttl > 0 {
+        d.SetTTL(ttl)
+    }
+    if n := cfg.RaiseHand.MaxPending; n > 0 {
+        d.SetMaxPending(n)
+    }
+    return d
+}
+
+// handRoutes serve raised hands: a participant who may only watch raises one with their
+// session token, an admin of the room lists and approves or denies them with theirs, and
+// the approved participant gets a publishing token pushed over their events WebSocket or
+// fetches it from the hand
+func handRoutes(mux *http.ServeMux, cfg *config, s *stores) {
+    mux.HandleFunc("POST /v1/rooms/{room}/hands", func(w http.ResponseWriter, r *http.Request) {
+        grant, ok := roomGrant(w, r, cfg, s)
+        if !ok {
+            return
+        }
+        var req raiseHandRequest
+        if !readJSON(w, r, &req) {
+            return
+        }
+        hand, err := s.hands.Raise(r.Context(), grant, req.Reason)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, handResponse{Hand: hand})
+    })
+    mux.HandleFunc("GET /v1/rooms/{room}/hands", func(w http.ResponseWriter, r *http.Request) {
+        host, ok := roomGrant(w, r, cfg, s)
+        if !ok {
+            return
+        }
+        hands, err := s.hands.Pending(host)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, hands)
+    })
+    mux.HandleFunc("GET /v1/rooms/{room}/hands/{id}", func(w http.ResponseWriter, r *http.Request) {
+        grant, ok := roomGrant(w, r, cfg, s)
+        if !ok {
+            return
+        }
+        hand, token, expiresAt, err := s.hands.Get(grant, r.PathValue("id"))
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, handResponse{Hand: hand, Token: token, ExpiresAt: expiresAt})
+    })
+    mux.HandleFunc("DELETE /v1/rooms/{room}/hands/{id}", func(w http.ResponseWriter, r *http.Request) {
+        grant, ok := roomGrant(w, r, cfg, s)
+        if !ok {
+            return
+        }
+        if _, err := s.hands.Lower(r.Context(), grant, r.PathValue("id")); err != nil {
+            writeError(w, err)
+            return
+        }
+        w.WriteHeader(http.StatusNoContent)
+    })
+    mux.HandleFunc("POST /v1/rooms/{room}/hands/{id}/approve", func(w http.ResponseWriter, r *http.Request) {
+        host, ok := roomGrant(w, r, cfg, s)
+        if !ok {
+            return
+        }
+        var req approveHandRequest
+        if !readJSON(w, r, &req) {
+            return
+        }
+        hand, err := s.hands.Approve(r.Context(), host, r.PathValue("id"), req.Sources, handMinter(cfg, s, bearer(r)))
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        handDecided(r, s, host, hand)
+        writeJSON(w, http.StatusOK, handResponse{Hand: hand})
+    })
+    mux.HandleFunc("POST /v1/rooms/{room}/hands/{id}/deny", func(w http.ResponseWriter, r *http.Request) {
+        host, ok := roomGrant(w, r, cfg, s)
+        if !ok {
+            return
+        }
+        hand, err := s.hands.Deny(r.Context(), host, r.PathValue("id"))
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        handDecided(r, s, host, hand)
+        writeJSON(w, http.StatusOK, handResponse{Hand: hand})
+    })
+}
+
+// roomGrant verifies the bearer token of a request under /v1/rooms/{room}, which must be for
+// that room
+func roomGrant(w http.ResponseWriter, r *http.Request, cfg *config, s *stores) (*auth.VollyVideoGrant, bool) {
+    token := bearer(r)
+    if token == "" {
+        writeError(w, errBadCredentials)
+        return nil, false
+    }
+    grant, err := verifyRequest(r, cfg, s, token)
+    if err != nil {
+        writeError(w, err)
+        return nil, false
+    }
+    if grant.Room != r.PathValue("room") {
+        http.Error(w, "token is not valid for this room", http.StatusForbidden)
+        return nil, false
+    }
+    return grant, true
+}
+
+// handMinter signs approved grants under the API key of the host's token, which
+// verifyRequest has bound to the hand's tenant
+func handMinter(cfg *config, s *stores, hostToken string) handraise.Minter {
+    return func(ctx context.Context, grant *auth.VollyVideoGrant, ttl time.Duration) (string, time.Time, error) {
+        parsed, err := lkauth.ParseAPIToken(hostToken)
+        if err != nil {
+            return "", time.Time{}, errBadCredentials
+        }
+        key, err := activeKey(ctx, s, parsed.APIKey())
+        if err != nil {
+            return "", time.Time{}, err
+        }
+        if ttl <= 0 || ttl > cfg.MaxTokenTTL.Duration {
+            ttl = cfg.MaxTokenTTL.Duration
+        }
+        at := accessToken(s, key).
+            AddGrant(grant).
+            SetIdentity(grant.Identity).
+            SetProvenance(grant.Provenance.Derive(provenanceService, version)).
+            SetValidFor(ttl).
+            SetElevatedFrom(grant.ElevatedFrom).
+            SetClaimSchemas(s.claimSchemas).
+            SetCryptoProfiles(s.cryptoProfiles).
+            SetIssuanceCheck(s.killSwitch.CheckGrant)
+        token, err := scopeToken(cfg, at, key.Tenant).ToJWT()
+        if err != nil {
+            return "", time.Time{}, err
+        }
+        tokenIssued(ctx, s, key, grant.Identity, grant.Tags)
+        return token, at.ExpiresAt(), nil
+    }
+}
+
+// handDecided audits a host's decision on a hand
+func handDecided(r *http.Request, s *stores, host *auth.VollyVideoGrant, hand handraise.Hand) {
+    e := audit.Entry{Actor: host.Identity, Action: "room.hand_" + string(hand.State), Tenant: hand.Tenant, Target: hand.Identity, Tags: host.Tags,
+        Detail: map[string]string{
+            "room":    hand.Room,
+            "hand":    hand.ID,
+            "sources": strings.Join(hand.Sources, ","),
+        }}
+    if err := s.audit.Append(r.Context(), e); err != nil {
+        log.Printf("audit append failed: %v", err)
+    }
+}
+
+// pushHands sends the decisions on the participant's hands to a session that negotiated
+// grant push, until the returned function is called
+func pushHands(s *stores, session *protocol.Session, p protocol.Principal) (detach func()) {
+    if !session.Has(protocol.FeatureGrantPush) {
+        return func() {}
+    }
+    return s.hands.Attach(p.Tenant, p.Room, p.Identity, func(hand handraise.Hand, token string, expiresAt time.Time) error {
+        // The token goes first, so a client acting on the approval already holds it
+        if token != "" {
+            if err := session.Send(protocol.Grant{Type: protocol.TypeGrant, Token: token, ExpiresAt: expiresAt}); err != nil {
+                return err
+            }
+        }
+        return session.Send(protocol.Hand{Type: protocol.TypeHand, ID: hand.ID, State: string(hand.State)})
+    })
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/configstore"
+    "github.com/volly-org/volly-signaling/pkg/volly/hsm"
+)
+
+var errHSMViewerTokens = errors.New("viewer tokens cannot be signed by a key held on the HSM")
+
+// openHSM opens every slot in cfg.HSM and makes a signer for each API key kept there
+func (s *stores) openHSM(cfg *config) error {
+    h := cfg.HSM
+    if len(h.Keys) == 0 {
+        return nil
+    }
+    var partitions []hsm.Partition
+    for _, slot := range h.Slots {
+        module, err := hsm.OpenModule(h.Module, slot)
+        if err != nil {
+            return fmt.Errorf("hsm: slot %s: %w", slot, err)
+        }
+        pool := hsm.NewSessionPool(module, h.MaxSessions)
+        s.closers = append(s.closers, pool)
+        partitions = append(partitions, hsm.Partition{Name: slot, Pool: pool})
+    }
+    s.hsmKeys = make(map[string]*hsm.Signer, len(h.Keys))
+    for id, label := range h.Keys {
+        signer, err := hsm.NewSigner(label, hsm.AlgorithmHS256, nil, partitions...)
+        if err != nil {
+            return fmt.Errorf("hsm: key %s: %w", id, err)
+        }
+        s.hsmKeys[id] = signer
+    }
+    return nil
+}
+
+// accessToken starts a token signed by key, on the HSM when it keeps the key
+func accessToken(s *stores, key configstore.APIKey) *auth.VollyAccessToken {
+    if signer, ok := s.hsmKeys[key.ID]; ok {
+        return auth.NewVollyAccessTokenWithSigner(key.ID, signer)
+    }
+    return auth.NewVollyAccessTokenWithSecret(key.ID, key.Secret)
+}
+
+// keyVerifier verifies tokens signed by key, on the HSM when it keeps the key
+func keyVerifier(s *stores, key configstore.APIKey) *auth.Verifier {
+    if signer, ok := s.hsmKeys[key.ID]; ok {
+        return auth.NewVerifierWithSigner(key.ID, signer)
+    }
+    return auth.NewVerifierWithSecret(key.ID, key.Secret)
+}
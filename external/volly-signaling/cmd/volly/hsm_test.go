@@ -0,0 +1,62 @@
+package main
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "errors"
+    "net/http"
+    "testing"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/hsm"
+)
+
+// hsmSecret is the key on the test HSM, unlike the API key's secret in the config store
+const hsmSecret = "hsm-secret-with-enough-entropy-00001"
+
+// testModule is a slot holding one HMAC key, computed in memory
+type testModule struct{}
+
+func (testModule) OpenSession() (hsm.SessionHandle, error)                     { return 1, nil }
+func (testModule) CloseSession(hsm.SessionHandle) error                        { return nil }
+func (testModule) FindKey(hsm.SessionHandle, string) (hsm.ObjectHandle, error) { return 1, nil }
+
+func (testModule) Sign(_ hsm.SessionHandle, mechanism hsm.Mechanism, _ hsm.ObjectHandle, data []byte) ([]byte, error) {
+    if mechanism != hsm.MechanismSHA256HMAC {
+        return nil, errors.New("unexpected mechanism")
+    }
+    h := hmac.New(sha256.New, []byte(hsmSecret))
+    h.Write(data)
+    return h.Sum(nil), nil
+}
+
+func init() {
+    hsm.RegisterModule("test", func(slot string) (hsm.Module, error) { return testModule{}, nil })
+}
+
+// TestHSMSignsTokens issues a token for an API key kept on the HSM, which the gateway admits
+// while the key's secret in the config store does not verify it
+func TestHSMSignsTokens(t *testing.T) {
+    cfg := testConfig()
+    cfg.HSM = hsmConfig{Module: "test", Slots: []string{"0"}, Keys: map[string]string{testAPIKey: "volly-test-key"}}
+    s := testStores(t, cfg)
+    tokend := testTokend(t, cfg, s)
+    gw := testGateway(t, cfg, s)
+
+    var issued tokenResponse
+    if code := postJSON(t, tokend.URL+"/v1/token", tokenRequest{Identity: "alice", Room: "r"}, &issued, withAPIKey); code != http.StatusOK {
+        t.Fatalf("issue: status %d", code)
+    }
+    if _, err := auth.NewVerifier(testAPIKey, hsmSecret).SetLegacyPolicy(auth.AdmitLegacy).Verify(issued.Token); err != nil {
+        t.Fatalf("token is not signed with the HSM key: %v", err)
+    }
+    if _, err := auth.NewVerifier(testAPIKey, testAPISecret).SetLegacyPolicy(auth.AdmitLegacy).Verify(issued.Token); !errors.Is(err, auth.ErrInvalidToken) {
+        t.Fatalf("verify with the config store secret: got %v, want ErrInvalidToken", err)
+    }
+    if code := postJSON(t, gw.URL+"/v1/admit", admitRequest{Token: issued.Token}, nil, nil); code != http.StatusOK {
+        t.Fatalf("admit: status %d, want 200", code)
+    }
+    if code := postJSON(t, gw.URL+"/v1/admit", admitRequest{Token: geoToken(t, nil, nil)}, nil, nil); code != http.StatusUnauthorized {
+        t.Fatalf("admit a token signed with the config store secret: status %d, want 401", code)
+    }
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+    "context"
+    "crypto/subtle"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    lkauth "github.com/livekit/protocol/auth"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/bundle"
+    "github.com/volly-org/volly-signaling/pkg/volly/cache"
+    "github.com/volly-org/volly-signaling/pkg/volly/configstore"
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+    "github.com/volly-org/volly-signaling/pkg/volly/directory"
+    "github.com/volly-org/volly-signaling/pkg/volly/elevation"
+    "github.com/volly-org/volly-signaling/pkg/volly/erasure"
+    "github.com/volly-org/volly-signaling/pkg/volly/extension"
+    "github.com/volly-org/volly-signaling/pkg/volly/federation"
+    "github.com/volly-org/volly-signaling/pkg/volly/gateway"
+    "github.com/volly-org/volly-signaling/pkg/volly/handraise"
+    "github.com/volly-org/volly-signaling/pkg/volly/issuance"
+    "github.com/volly-org/volly-signaling/pkg/volly/keys"
+    "github.com/volly-org/volly-signaling/pkg/volly/killswitch"
+    "github.com/volly-org/volly-signaling/pkg/volly/lifecycle"
+    "github.com/volly-org/volly-signaling/pkg/volly/listing"
+    "github.com/volly-org/volly-signaling/pkg/volly/matrix"
+    "github.com/volly-org/volly-signaling/pkg/volly/metering"
+    "github.com/volly-org/volly-signaling/pkg/volly/netpolicy"
+    "github.com/volly-org/volly-signaling/pkg/volly/presence"
+    "github.com/volly-org/volly-signaling/pkg/volly/revocation"
+    "github.com/volly-org/volly-signaling/pkg/volly/rollout"
+    "github.com/volly-org/volly-signaling/pkg/volly/roompin"
+    "github.com/volly-org/volly-signaling/pkg/volly/saml"
+    "github.com/volly-org/volly-signaling/pkg/volly/stepup"
+    "github.com/volly-org/volly-signaling/pkg/volly/tagset"
+    "github.com/volly-org/volly-signaling/pkg/volly/wasmplugin"
+    "github.com/volly-org/volly-signaling/pkg/volly/webauthn"
+)
+
+const maxBodyBytes = 1 << 20
+
+var (
+    errBadCredentials = errors.New("invalid API key or secret")
+    errTenantBlocked  = errors.New("tenant is suspended")
+    errKeyRetired     = errors.New("API key has been retired")
+)
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(v)
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+    dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(v); err != nil {
+        http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+        return false
+    }
+    return true
+}
+
+// connectionRejection tells a client which connection cap turned it away and when to retry
+type connectionRejection struct {
+    Error      string `json:"error"`
+    Reason     string `json:"reason"`
+    Limit      int    `json:"limit"`
+    RetryAfter int    `json:"retryAfter"`
+}
+
+// writeError maps package errors to HTTP statuses
+func writeError(w http.ResponseWriter, err error) {
+    var limitErr *gateway.ConnectionLimitError
+    if errors.As(err, &limitErr) {
+        writeConnectionRejection(w, limitErr)
+        return
+    }
+    status := http.StatusInternalServerError
+    switch {
+    case errors.Is(err, configstore.ErrNotFound), errors.Is(err, keys.ErrKeyNotFound), errors.Is(err, gateway.ErrLeaseNotFound),
+        errors.Is(err, saml.ErrUnknownIdentityProvider), errors.Is(err, directory.ErrUserNotFound), errors.Is(err, directory.ErrGroupNotFound),
+        errors.Is(err, handraise.ErrHandNotFound), errors.Is(err, federation.ErrPeerNotAllowed):
+        status = http.StatusNotFound
+    case errors.Is(err, webauthn.ErrCredentialExists), errors.Is(err, directory.ErrUserNameTaken),
+        errors.Is(err, bundle.ErrRollback), errors.Is(err, handraise.ErrHandDecided), errors.Is(err, handraise.ErrCanPublish):
+        status = http.StatusConflict
+    case errors.Is(err, errBadCredentials), errors.Is(err, errKeyRetired),
+        errors.Is(err, auth.ErrInvalidToken), errors.Is(err, auth.ErrTokenExpired),
+        errors.Is(err, auth.ErrInvalidViewerToken), errors.Is(err, auth.ErrViewerTokenExpired),
+        errors.Is(err, auth.ErrIssuerMismatch), errors.Is(err, auth.ErrAudienceMismatch),
+        errors.Is(err, auth.ErrTokenNotYetValid), errors.Is(err, elevation.ErrSecondFactorMissing),
+        errors.Is(err, elevation.ErrSecondFactorFailed), errors.Is(err, elevation.ErrSessionExpired),
+        errors.Is(err, auth.ErrDelegationExpired), errors.Is(err, handraise.ErrSessionExpired), errors.Is(err, gateway.ErrGrantEnded),
+        errors.Is(err, extension.ErrAttestationRejected), errors.Is(err, errAttestationRequired),
+        errors.Is(err, matrix.ErrTokenRejected), errors.Is(err, matrix.ErrWrongHomeserver), errors.Is(err, matrix.ErrMalformedUserID),
+        errors.Is(err, stepup.ErrInvalidChallenge), errors.Is(err, stepup.ErrProofFailed),
+        errors.Is(err, webauthn.ErrInvalidChallenge), errors.Is(err, webauthn.ErrVerificationFailed),
+        errors.Is(err, webauthn.ErrCredentialNotFound), isSAMLRejection(err),
+        errors.Is(err, gateway.ErrKeyProofRequired), errors.Is(err, gateway.ErrKeyProofChallenge),
+        errors.Is(err, gateway.ErrKeyProofMismatch), errors.Is(err, crypto.ErrInvalidSignature),
+        errors.Is(err, issuance.ErrUnauthenticated), errors.Is(err, issuance.ErrUnmappedCaller), errors.Is(err, issuance.ErrInvalidSPIFFEID):
+        status = http.StatusUnauthorized
+    case errors.Is(err, killswitch.ErrKilled), errors.Is(err, revocation.ErrTokenRevoked), errors.Is(err, netpolicy.ErrAddressBlocked),
+        errors.Is(err, errTenantBlocked), errors.Is(err, auth.ErrPQClaimsMissing), errors.Is(err, auth.ErrPQKeyExpired),
+        errors.Is(err, auth.ErrViewerTokensOff), errors.Is(err, auth.ErrCryptoProfile), errors.Is(err, errElevationOff),
+        errors.Is(err, elevation.ErrAlreadyElevated), errors.Is(err, elevation.ErrDerivedToken), errors.Is(err, elevation.ErrNoSession),
+        errors.Is(err, errStepUpOff), errors.Is(err, errPasskeysOff), errors.Is(err, errPasskeyLoginOff),
+        errors.Is(err, errNoSigningKey), errors.Is(err, errSAMLOff), errors.Is(err, saml.ErrUnmapped),
+        errors.Is(err, errMatrixOff), errors.Is(err, matrix.ErrUnknownHomeserver), errors.Is(err, matrix.ErrUserNotBridged),
+        errors.Is(err, matrix.ErrRoomNotBridged),
+        errors.Is(err, errDirectoryOff), errors.Is(err, directory.ErrNotProvisioned), errors.Is(err, directory.ErrDeprovisioned),
+        errors.Is(err, directory.ErrRoleNotGranted), errors.Is(err, directory.ErrNoRoles),
+        errors.Is(err, gateway.ErrRoomFull), errors.Is(err, gateway.ErrRoomRequiresPQ), errors.Is(err, gateway.ErrRecordingNotAllowed),
+        errors.Is(err, gateway.ErrRoomRequiresE2EE), errors.Is(err, auth.ErrProvenanceMissing),
+        errors.Is(err, auth.ErrProvenanceRejected), errors.Is(err, gateway.ErrProviderNotAllowed),
+        errors.Is(err, errPINOff), errors.Is(err, errDelegationOff), errors.Is(err, errNotAnActor), errors.Is(err, auth.ErrNotDelegable),
+        errors.Is(err, auth.ErrDelegationUnbound),
+        errors.Is(err, auth.ErrDelegationTenant), errors.Is(err, auth.ErrDelegationTooDeep),
+        errors.Is(err, handraise.ErrNoSession), errors.Is(err, handraise.ErrNotHost), errors.Is(err, lifecycle.ErrDenied),
+        errors.Is(err, wasmplugin.ErrRefused), errors.Is(err, errAttestationOff),
+        errors.Is(err, issuance.ErrScopeDenied), errors.Is(err, errNoIssuanceKey), errors.Is(err, errHSMViewerTokens),
+        errors.Is(err, gateway.ErrLocationDenied), errors.Is(err, gateway.ErrLocationUnknown):
+        status = http.StatusForbidden
+    case errors.Is(err, auth.ErrMalformedClaims):
+        status = http.StatusUnauthorized
+    case errors.Is(err, auth.ErrTokenTooLarge), errors.Is(err, auth.ErrTokenOverBudget), errors.Is(err, auth.ErrTooManyClaims),
+        errors.Is(err, auth.ErrClaimsTooDeep), errors.Is(err, auth.ErrClaimStringTooLong),
+        errors.Is(err, auth.ErrClaimNamespace), errors.Is(err, auth.ErrUnknownClaim), errors.Is(err, auth.ErrInvalidClaim),
+        errors.Is(err, auth.ErrMissingClaim):
+        status = http.StatusBadRequest
+    case errors.Is(err, configstore.ErrTenantRequired), errors.Is(err, revocation.ErrEmptyPredicate),
+        errors.Is(err, killswitch.ErrEmptyScope), errors.Is(err, listing.ErrInvalidCursor), errors.Is(err, listing.ErrUnknownField),
+        errors.Is(err, auth.ErrUnknownRole), errors.Is(err, auth.ErrUnknownProvider), errors.Is(err, elevation.ErrNotElevatable),
+        errors.Is(err, stepup.ErrUnknownMethod), errors.Is(err, webauthn.ErrUnsupportedKey),
+        errors.Is(err, erasure.ErrNoSubject), errors.Is(err, erasure.ErrInvalidReceipt),
+        errors.Is(err, metering.ErrUnknownPeriod), errors.Is(err, metering.ErrRangeTooLarge), errors.Is(err, metering.ErrInvalidSignature),
+        errors.Is(err, tagset.ErrInvalidTag), errors.Is(err, rollout.ErrInvalidPercent), errors.Is(err, roompin.ErrInvalidPIN),
+        errors.Is(err, handraise.ErrReasonTooLong), errors.Is(err, handraise.ErrInvalidSource), errors.Is(err, auth.ErrInvalidExpiryPolicy),
+        errors.Is(err, bundle.ErrInvalidBundle), errors.Is(err, bundle.ErrInvalidSignature), errors.Is(err, bundle.ErrUnknownFormat),
+        errors.Is(err, matrix.ErrMalformedToken):
+        status = http.StatusBadRequest
+    case errors.Is(err, handraise.ErrTooManyHands), errors.Is(err, presence.ErrSessionLimitExceeded):
+        status = http.StatusTooManyRequests
+    case errors.Is(err, auth.ErrPoolSaturated), errors.Is(err, gateway.ErrRoomActorsClosed), errors.Is(err, lifecycle.ErrHookFailed),
+        errors.Is(err, wasmplugin.ErrPluginFailed):
+        status = http.StatusServiceUnavailable
+    case errors.As(err, new(*directory.LDAPError)), errors.Is(err, directory.ErrLDAPProtocol), errors.Is(err, directory.ErrEmptyDirectory),
+        errors.Is(err, extension.ErrPluginCall), errors.Is(err, cache.ErrDocumentUnavailable), errors.Is(err, federation.ErrDNSLookup),
+        errors.Is(err, federation.ErrInvalidDescriptor), errors.Is(err, federation.ErrInvalidRecord), errors.Is(err, federation.ErrInsecureDNS),
+        errors.Is(err, federation.ErrNoRecords), errors.Is(err, federation.ErrPinMismatch), errors.Is(err, matrix.ErrHomeserverFailed):
+        status = http.StatusBadGateway
+    }
+    http.Error(w, err.Error(), status)
+}
+
+// writeConnectionRejection answers 503 when the instance is full, so the edge can try another
+// instance, and 429 when the caller's own tenant or address is over its cap
+func writeConnectionRejection(w http.ResponseWriter, err *gateway.ConnectionLimitError) {
+    status := http.StatusTooManyRequests
+    if err.Reason == gateway.RejectInstanceFull {
+        status = http.StatusServiceUnavailable
+    }
+    retry := int((err.RetryAfter + time.Second - 1) / time.Second)
+    w.Header().Set("Retry-After", strconv.Itoa(retry))
+    writeJSON(w, status, connectionRejection{
+        Error:      err.Error(),
+        Reason:     err.Reason,
+        Limit:      err.Limit,
+        RetryAfter: retry,
+    })
+}
+
+// bearer returns the Authorization bearer credential
+func bearer(r *http.Request) string {
+    h := r.Header.Get("Authorization")
+    if len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+        return strings.TrimSpace(h[7:])
+    }
+    return ""
+}
+
+// activeKey returns a usable API key of an active tenant
+func activeKey(ctx context.Context, s *stores, id string) (configstore.APIKey, error) {
+    key, err := s.config.GetAPIKey(ctx, id)
+    if errors.Is(err, configstore.ErrNotFound) {
+        return configstore.APIKey{}, errBadCredentials
+    }
+    if err != nil {
+        return configstore.APIKey{}, err
+    }
+    if !key.NotAfter.IsZero() && !time.Now().Before(key.NotAfter) {
+        return configstore.APIKey{}, errKeyRetired
+    }
+    tenant, err := s.config.GetTenant(ctx, key.Tenant)
+    if err != nil {
+        return configstore.APIKey{}, err
+    }
+    if tenant.Suspended {
+        return configstore.APIKey{}, errTenantBlocked
+    }
+    return key, nil
+}
+
+// verifyToken checks a Volly token against the API key it names, including revocation,
+// kill switches and the key's tenant binding. Results are cached until an event could change them
+func verifyToken(ctx context.Context, cfg *config, s *stores, token string) (*auth.VollyVideoGrant, error) {
+    // Oversized tokens are turned away before anything hashes or parses them
+    if err := cfg.ClaimLimits.Check(token); err != nil {
+        return nil, err
+    }
+    grant, err := s.grantCache.Verify(token, func(token string) (*auth.VollyVideoGrant, error) {
+        return verifyUncached(ctx, cfg, s, token)
+    })
+    // A cached viewer grant outlives the flag that let it in
+    if err == nil && grant.PQStatus == auth.PQStatusViewer && !viewerTokensOn(ctx, cfg, s, grant.Tenant, grant.Room, grant.Identity) {
+        return nil, auth.ErrViewerTokensOff
+    }
+    return grant, err
+}
+
+// tokenAPIKey returns the API key token names, without verifying it, and its viewer claims
+// when it is a viewer token
+func tokenAPIKey(token string) (string, *auth.ViewerClaims, error) {
+    if auth.IsViewerToken(token) {
+        claims, err := auth.ParseViewerToken(token)
+        if err != nil {
+            return "", nil, errBadCredentials
+        }
+        return claims.APIKey, claims, nil
+    }
+    parsed, err := lkauth.ParseAPIToken(token)
+    if err != nil {
+        return "", nil, errBadCredentials
+    }
+    return parsed.APIKey(), nil, nil
+}
+
+// recheckGrant repeats, for a cached grant, the checks whose answer another instance sharing
+// the backend can change without this one hearing of it: the key and its tenant, and revocation
+func recheckGrant(ctx context.Context, s *stores, token string, grant *auth.VollyVideoGrant) error {
+    apiKey, _, err := tokenAPIKey(token)
+    if err != nil {
+        return err
+    }
+    key, err := activeKey(ctx, s, apiKey)
+    if err != nil {
+        return err
+    }
+    if grant.Tenant != key.Tenant {
+        return errBadCredentials
+    }
+    if err := s.killSwitch.CheckGrant(grant); err != nil {
+        return err
+    }
+    return s.revocations.Check(ctx, grant)
+}
+
+func verifyUncached(ctx context.Context, cfg *config, s *stores, token string) (*auth.VollyVideoGrant, error) {
+    apiKey, viewer, err := tokenAPIKey(token)
+    if err != nil {
+        return nil, err
+    }
+    key, err := activeKey(ctx, s, apiKey)
+    if err != nil {
+        return nil, err
+    }
+    v := keyVerifier(s, key).SetGrantCheck(func(grant *auth.VollyVideoGrant) error {
+        // A key can only mint tokens for its own tenant
+        if grant.Tenant != key.Tenant {
+            return errBadCredentials
+        }
+        if err := s.killSwitch.CheckGrant(grant); err != nil {
+            return err
+        }
+        return s.revocations.Check(ctx, grant)
+    })
+    if cfg.AllowLegacyTokens {
+        v.SetLegacyPolicy(auth.AdmitLegacy)
+    }
+    v.SetViewerTokens(viewer != nil && viewerTokensOn(ctx, cfg, s, key.Tenant, viewer.Room, viewer.Identity))
+    v.SetClaimLimits(cfg.ClaimLimits)
+    v.SetClaimSchemas(s.claimSchemas)
+    v.SetCryptoProfiles(s.cryptoProfiles)
+    v.SetClaimTransformers(s.claimTransformers...)
+    v.SetNotBeforeLeeway(cfg.NotBeforeLeeway.Duration)
+    v.SetIssuer(cfg.Issuer)
+    v.SetProvenancePolicy(cfg.Provenance)
+    if aud := cfg.audience(key.Tenant); aud != "" {
+        v.SetAudience(aud)
+    }
+    // Queued under the key's tenant so one tenant's join storm cannot starve the others
+    grant, err := s.verifyPool.Verify(ctx, key.Tenant, token, v.Verify)
+    if s.shadow != nil {
+        s.shadow.Observe(key.ID, token, grant, err)
+    }
+    return grant, err
+}
+
+func constantTimeEqual(a, b string) bool {
+    return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
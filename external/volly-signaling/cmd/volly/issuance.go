@@ -0,0 +1,95 @@
+package main
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "net/http"
+    "os"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/configstore"
+    "github.com/volly-org/volly-signaling/pkg/volly/issuance"
+)
+
+var errNoIssuanceKey = errors.New("no signing key is configured for the caller's tenant")
+
+// newIssuers authenticates client certificates or SVIDs at POST /v1/token; nil unless
+// cfg.Issuance maps one
+func newIssuers(cfg *config) issuance.Authenticator {
+    i := cfg.Issuance
+    switch {
+    case len(i.Certificates) > 0:
+        return issuance.NewMTLSAuthenticator(i.Certificates)
+    case len(i.SPIFFE.Rules) > 0:
+        return issuance.NewSPIFFEAuthenticator(i.SPIFFE.TrustDomain, i.SPIFFE.Rules)
+    }
+    return nil
+}
+
+// tokendTLS serves tokend over TLS, verifying any client certificate against
+// issuance.clientCAFile or the SPIFFE trust bundle; nil, for plain HTTP, unless cfg.Issuance
+// maps a caller
+func tokendTLS(cfg *config) (*tls.Config, error) {
+    i := cfg.Issuance
+    if sp := i.SPIFFE; len(sp.Rules) > 0 {
+        svid, err := issuance.NewFileSVIDProvider(sp.CertFile, sp.KeyFile, sp.BundleFile)
+        if err != nil {
+            return nil, err
+        }
+        // Callers without an SVID authenticate with basic auth instead
+        return issuance.ProviderServerTLSConfig(svid, tls.VerifyClientCertIfGiven), nil
+    }
+    if len(i.Certificates) == 0 {
+        return nil, nil
+    }
+    cert, err := tls.LoadX509KeyPair(i.CertFile, i.KeyFile)
+    if err != nil {
+        return nil, err
+    }
+    data, err := os.ReadFile(i.ClientCAFile)
+    if err != nil {
+        return nil, err
+    }
+    clientCAs := x509.NewCertPool()
+    if !clientCAs.AppendCertsFromPEM(data) {
+        return nil, errors.New("issuance.clientCAFile holds no certificates")
+    }
+    c := issuance.ServerTLSConfig(cert, clientCAs)
+    // Callers without a certificate authenticate with basic auth instead
+    c.ClientAuth = tls.VerifyClientCertIfGiven
+    return c, nil
+}
+
+// tokenCaller authenticates a caller of POST /v1/token by its client certificate when it
+// presented one tokend takes, and otherwise by basic auth, writing the error response on
+// failure. principal is set for a certificate; the caller checks its scopes
+func tokenCaller(w http.ResponseWriter, r *http.Request, cfg *config, s *stores, issuers issuance.Authenticator) (configstore.APIKey, *issuance.Principal, bool) {
+    if issuers == nil || r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+        key, ok := basicAuthKey(w, r, s)
+        return key, nil, ok
+    }
+    principal, err := issuers.Authenticate(r)
+    if err != nil {
+        writeError(w, err)
+        return configstore.APIKey{}, nil, false
+    }
+    id, ok := cfg.Issuance.SigningKeys[principal.Tenant]
+    if !ok {
+        writeError(w, errNoIssuanceKey)
+        return configstore.APIKey{}, nil, false
+    }
+    key, err := activeKey(r.Context(), s, id)
+    if err == nil && key.Tenant != principal.Tenant {
+        err = errNoIssuanceKey
+    }
+    if err != nil {
+        writeError(w, err)
+        return configstore.APIKey{}, nil, false
+    }
+    return key, principal, true
+}
+
+// roomScope is the issuance scope that lets a principal issue tokens for room
+func roomScope(room string) string {
+    return "room:" + room
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "math/big"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/issuance"
+)
+
+// testCA issues certificates for the issuance tests
+type testCA struct {
+    cert *x509.Certificate
+    key  *ecdsa.PrivateKey
+    pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+    t.Helper()
+    key := testKey(t)
+    template := &x509.Certificate{
+        SerialNumber:          big.NewInt(1),
+        Subject:               pkix.Name{CommonName: "volly test CA"},
+        NotBefore:             time.Now().Add(-time.Hour),
+        NotAfter:              time.Now().Add(time.Hour),
+        IsCA:                  true,
+        BasicConstraintsValid: true,
+        KeyUsage:              x509.KeyUsageCertSign,
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    cert, err := x509.ParseCertificate(der)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func testKey(t *testing.T) *ecdsa.PrivateKey {
+    t.Helper()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return key
+}
+
+// issue signs a certificate for template's names, for client or server use
+func (ca *testCA) issue(t *testing.T, template *x509.Certificate, usage x509.ExtKeyUsage) tls.Certificate {
+    t.Helper()
+    key := testKey(t)
+    serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+    if err != nil {
+        t.Fatal(err)
+    }
+    template.SerialNumber = serial
+    template.NotBefore = time.Now().Add(-time.Hour)
+    template.NotAfter = time.Now().Add(time.Hour)
+    template.KeyUsage = x509.KeyUsageDigitalSignature
+    template.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+    der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// writePEM writes cert and its key to dir, returning their paths
+func writePEM(t *testing.T, dir, name string, cert tls.Certificate) (certFile, keyFile string) {
+    t.Helper()
+    keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+    if err != nil {
+        t.Fatal(err)
+    }
+    certFile, keyFile = filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+    if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    return certFile, keyFile
+}
+
+// TestIssueByClientCertificate gets a token from tokend with a client certificate whose DNS
+// SAN is mapped, and is refused for a room outside its scopes or when the name is only the
+// certificate's common name
+func TestIssueByClientCertificate(t *testing.T) {
+    ca := newTestCA(t)
+    dir := t.TempDir()
+    caFile := filepath.Join(dir, "ca.pem")
+    if err := os.WriteFile(caFile, ca.pem, 0o600); err != nil {
+        t.Fatal(err)
+    }
+    server := ca.issue(t, &x509.Certificate{IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)}}, x509.ExtKeyUsageServerAuth)
+    certFile, keyFile := writePEM(t, dir, "server", server)
+
+    cfg := testConfig()
+    cfg.Issuance = issuanceConfig{
+        CertFile:     certFile,
+        KeyFile:      keyFile,
+        ClientCAFile: caFile,
+        Certificates: []issuance.CertificateRule{{Identity: "billing.internal", Tenant: testTenant, Scopes: []string{"room:support-*"}}},
+        SigningKeys:  map[string]string{testTenant: testAPIKey},
+    }
+    h, err := newTokend(cfg, testStores(t, cfg))
+    if err != nil {
+        t.Fatal(err)
+    }
+    tlsConfig, err := tokendTLS(cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    srv := httptest.NewUnstartedServer(h)
+    srv.TLS = tlsConfig
+    srv.StartTLS()
+    t.Cleanup(srv.Close)
+
+    roots := x509.NewCertPool()
+    roots.AddCert(ca.cert)
+    issue := func(cert *tls.Certificate, room string) int {
+        config := &tls.Config{RootCAs: roots}
+        if cert != nil {
+            config.Certificates = []tls.Certificate{*cert}
+        }
+        client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+        defer client.CloseIdleConnections()
+        return postJSONWith(t, client, srv.URL+"/v1/token", tokenRequest{Identity: "alice", Room: room}, nil, nil)
+    }
+
+    mapped := ca.issue(t, &x509.Certificate{DNSNames: []string{"billing.internal"}}, x509.ExtKeyUsageClientAuth)
+    if code := issue(&mapped, "support-7"); code != http.StatusOK {
+        t.Fatalf("mapped certificate: status %d, want 200", code)
+    }
+    if code := issue(&mapped, "lobby"); code != http.StatusForbidden {
+        t.Fatalf("room outside the scopes: status %d, want 403", code)
+    }
+    commonName := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "billing.internal"}}, x509.ExtKeyUsageClientAuth)
+    if code := issue(&commonName, "support-7"); code != http.StatusUnauthorized {
+        t.Fatalf("name only in the common name: status %d, want 401", code)
+    }
+    if code := issue(nil, "support-7"); code != http.StatusUnauthorized {
+        t.Fatalf("no certificate and no basic auth: status %d, want 401", code)
+    }
+}
+
+// TestIssueBySVID gets a token from tokend with an SVID a rule maps, and is refused for an ID
+// that only falls under the rule's /* through a dot segment
+func TestIssueBySVID(t *testing.T) {
+    ca := newTestCA(t)
+    dir := t.TempDir()
+    bundleFile := filepath.Join(dir, "bundle.pem")
+    if err := os.WriteFile(bundleFile, ca.pem, 0o600); err != nil {
+        t.Fatal(err)
+    }
+    spiffeID := func(s string) []*url.URL {
+        u, err := url.Parse(s)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return []*url.URL{u}
+    }
+    server := ca.issue(t, &x509.Certificate{URIs: spiffeID("spiffe://example.org/volly"), IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)}}, x509.ExtKeyUsageServerAuth)
+    certFile, keyFile := writePEM(t, dir, "svid", server)
+
+    cfg := testConfig()
+    cfg.Issuance = issuanceConfig{
+        SPIFFE: spiffeConfig{
+            TrustDomain: "example.org",
+            CertFile:    certFile,
+            KeyFile:     keyFile,
+            BundleFile:  bundleFile,
+            Rules:       []issuance.SPIFFERule{{ID: "spiffe://example.org/billing/*", Tenant: testTenant, Scopes: []string{"room:*"}}},
+        },
+        SigningKeys: map[string]string{testTenant: testAPIKey},
+    }
+    h, err := newTokend(cfg, testStores(t, cfg))
+    if err != nil {
+        t.Fatal(err)
+    }
+    tlsConfig, err := tokendTLS(cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    srv := httptest.NewUnstartedServer(h)
+    srv.TLS = tlsConfig
+    srv.StartTLS()
+    t.Cleanup(srv.Close)
+
+    roots := x509.NewCertPool()
+    roots.AddCert(ca.cert)
+    issue := func(id string) int {
+        svid := ca.issue(t, &x509.Certificate{URIs: spiffeID(id)}, x509.ExtKeyUsageClientAuth)
+        client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{svid}}}}
+        defer client.CloseIdleConnections()
+        return postJSONWith(t, client, srv.URL+"/v1/token", tokenRequest{Identity: "alice", Room: "r"}, nil, nil)
+    }
+    if code := issue("spiffe://example.org/billing/worker"); code != http.StatusOK {
+        t.Fatalf("mapped SVID: status %d, want 200", code)
+    }
+    for _, id := range []string{"spiffe://example.org/billing/../admin", "spiffe://example.org/billing/./worker", "spiffe://example.org/billing//worker"} {
+        if code := issue(id); code != http.StatusUnauthorized {
+            t.Fatalf("%s: status %d, want 401", id, code)
+        }
+    }
+    if code := issue("spiffe://other.org/billing/worker"); code != http.StatusUnauthorized {
+        t.Fatalf("SVID of another trust domain: status %d, want 401", code)
+    }
+}
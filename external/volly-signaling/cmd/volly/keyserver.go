@@ -0,0 +1,102 @@
+package main
+
+import (
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/keys"
+    "github.com/volly-org/volly-signaling/pkg/volly/listing"
+)
+
+type keyRequest struct {
+    Algorithm string    `json:"algorithm"`
+    PublicKey []byte    `json:"publicKey"`
+    ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
+
+type keyPage struct {
+    Keys []keys.KeyRecord `json:"keys"`
+    Next string           `json:"next,omitempty"`
+}
+
+// newKeyserver serves PQ key lookups publicly; publishing or deleting a key requires a
+// Volly token for the same identity
+func newKeyserver(cfg *config, s *stores) (http.Handler, error) {
+    mux := http.NewServeMux()
+    mux.HandleFunc("GET /v1/keys", func(w http.ResponseWriter, r *http.Request) {
+        q := r.URL.Query()
+        query := listing.Query{
+            Filter:     listing.Filter{Identity: q.Get("identity"), Algorithm: q.Get("algorithm")},
+            SortBy:     q.Get("sortBy"),
+            Descending: q.Get("descending") == "true",
+            Cursor:     q.Get("cursor"),
+        }
+        if limit := q.Get("limit"); limit != "" {
+            n, err := strconv.Atoi(limit)
+            if err != nil {
+                http.Error(w, "invalid limit", http.StatusBadRequest)
+                return
+            }
+            query.Limit = n
+        }
+        records, next, err := s.keys.List(r.Context(), query)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, keyPage{Keys: records, Next: next})
+    })
+    mux.HandleFunc("GET /v1/keys/{identity}", func(w http.ResponseWriter, r *http.Request) {
+        record, err := s.keys.Get(r.Context(), r.PathValue("identity"))
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, record)
+    })
+    mux.HandleFunc("PUT /v1/keys/{identity}", func(w http.ResponseWriter, r *http.Request) {
+        identity, ok := keyOwner(w, r, cfg, s)
+        if !ok {
+            return
+        }
+        var req keyRequest
+        if !readJSON(w, r, &req) {
+            return
+        }
+        record := keys.KeyRecord{Identity: identity, Algorithm: req.Algorithm, PublicKey: req.PublicKey, ExpiresAt: req.ExpiresAt}
+        if err := s.keys.Put(r.Context(), record); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        w.WriteHeader(http.StatusNoContent)
+    })
+    mux.HandleFunc("DELETE /v1/keys/{identity}", func(w http.ResponseWriter, r *http.Request) {
+        identity, ok := keyOwner(w, r, cfg, s)
+        if !ok {
+            return
+        }
+        if err := s.keys.Delete(r.Context(), identity); err != nil {
+            writeError(w, err)
+            return
+        }
+        w.WriteHeader(http.StatusNoContent)
+    })
+    wellKnownRoutes(mux, s)
+    return s.blocklist.Middleware(remoteAddr, mux), nil
+}
+
+// keyOwner checks that the caller's token identity matches the path identity
+func keyOwner(w http.ResponseWriter, r *http.Request, cfg *config, s *stores) (string, bool) {
+    grant, err := verifyRequest(r, cfg, s, bearer(r))
+    if err != nil {
+        writeError(w, err)
+        return "", false
+    }
+    identity := r.PathValue("identity")
+    if grant.Identity != identity {
+        http.Error(w, "token identity does not own this key", http.StatusForbidden)
+        return "", false
+    }
+    return identity, true
+}
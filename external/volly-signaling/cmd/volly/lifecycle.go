@@ -0,0 +1,44 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/lifecycle"
+)
+
+// newRoomHooks registers the remote hooks in cfg.RoomHooks. The hooks are always set, so a
+// deployment embedding the gateway can register Go callbacks on them as well
+func newRoomHooks(cfg *config, s *stores) (*lifecycle.Hooks, error) {
+    h := lifecycle.New().
+        SetCounter(s.watcher).
+        SetReport(func(ctx context.Context, f lifecycle.Failure) {
+            policy := "refusing"
+            if f.FailOpen || f.Stage == lifecycle.StagePostLeave {
+                policy = "ignoring it"
+            }
+            log.Printf("gateway: %s hook %s failed, %s: %v", f.Stage, f.Hook, policy, f.Err)
+        })
+    if ttl := cfg.Connections.LeaseTTL.Duration; ttl > 0 {
+        h.SetStaleAfter(2 * ttl)
+    }
+    var secret []byte
+    if cfg.RoomHooks.Secret != "" {
+        secret = []byte(cfg.RoomHooks.Secret)
+    }
+    for i, hc := range cfg.RoomHooks.Hooks {
+        if hc.URL == "" {
+            return nil, fmt.Errorf("roomHooks.hooks[%d]: url is required", i)
+        }
+        name := hc.Name
+        if name == "" {
+            name = hc.URL
+        }
+        opts := lifecycle.Options{Timeout: hc.Timeout.Duration, FailOpen: hc.FailOpen}
+        if err := h.Register(lifecycle.Stage(hc.Stage), name, lifecycle.NewWebhook(hc.URL, secret), opts); err != nil {
+            return nil, fmt.Errorf("roomHooks.hooks[%d]: %w: %q", i, err, hc.Stage)
+        }
+    }
+    return h, nil
+}
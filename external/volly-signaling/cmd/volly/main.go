@@ -0,0 +1,195 @@
+// Command volly runs the Volly control-plane services from one binary: tokend issues
+// tokens, gateway verifies and admits them, keyserver publishes PQ keys and admin manages
+// tenants, API keys, revocations and kill switches. "all" runs every service in one process
+// sharing the same stores, for small deployments. proxy is a debugging aid that sits in front
+// of a gateway recording anonymized signaling transcripts; "all" leaves it out
+package main
+
+import (
+    "context"
+    "crypto/tls"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "os/signal"
+    "sync"
+    "syscall"
+    "time"
+)
+
+// service builds the HTTP handler for one subcommand
+type service struct {
+    name    string
+    summary string
+    listen  func(cfg *config) string
+    handler func(cfg *config, s *stores) (http.Handler, error)
+    // standalone services only run when named
+    standalone bool
+    // tls, when set and returning a config, serves the service over TLS
+    tls func(cfg *config) (*tls.Config, error)
+}
+
+// version is stamped into the provenance of every token; set it at build time with
+// -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+var services = []service{
+    {"tokend", "issue tokens for tenant API keys", func(c *config) string { return c.Tokend.Listen }, newTokend, false, tokendTLS},
+    {"gateway", "verify tokens and run admission checks", func(c *config) string { return c.Gateway.Listen }, newGateway, false, nil},
+    {"keyserver", "publish and look up PQ keys", func(c *config) string { return c.Keyserver.Listen }, newKeyserver, false, nil},
+    {"admin", "manage tenants, API keys, revocations and kill switches", func(c *config) string { return c.Admin.Listen }, newAdmin, false, nil},
+    {"proxy", "record signaling transcripts in front of a gateway", func(c *config) string { return c.Proxy.Listen }, newProxy, true, nil},
+}
+
+func usage() {
+    fmt.Fprintf(os.Stderr, "usage: volly <command> [-config file] [-listen addr]\n\ncommands:\n")
+    for _, s := range services {
+        fmt.Fprintf(os.Stderr, "  %-10s %s\n", s.name, s.summary)
+    }
+    fmt.Fprintf(os.Stderr, "  %-10s %s\n", "all", "run every service but proxy in one process")
+}
+
+func main() {
+    if len(os.Args) < 2 {
+        usage()
+        os.Exit(2)
+    }
+    command := os.Args[1]
+
+    flags := flag.NewFlagSet(command, flag.ExitOnError)
+    configPath := flags.String("config", os.Getenv("VOLLY_CONFIG"), "JSON config file")
+    listen := flags.String("listen", "", "listen address, overriding the config (single service only)")
+    flags.Parse(os.Args[2:])
+
+    var selected []service
+    for _, s := range services {
+        if command == s.name || command == "all" && !s.standalone {
+            selected = append(selected, s)
+        }
+    }
+    if len(selected) == 0 {
+        usage()
+        os.Exit(2)
+    }
+    if *listen != "" && len(selected) > 1 {
+        log.Fatal("-listen applies to a single service; set listen addresses in the config for all")
+    }
+
+    cfg, err := loadConfig(*configPath)
+    if err != nil {
+        log.Fatalf("config: %v", err)
+    }
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    s, err := newStores(ctx, cfg)
+    if err != nil {
+        log.Fatalf("stores: %v", err)
+    }
+    defer s.Close()
+    log.SetOutput(s.redactor.Writer(os.Stderr))
+
+    var servers []*http.Server
+    for _, svc := range selected {
+        h, err := svc.handler(cfg, s)
+        if err != nil {
+            log.Fatalf("%s: %v", svc.name, err)
+        }
+        addr := svc.listen(cfg)
+        if *listen != "" {
+            addr = *listen
+        }
+        srv := &http.Server{
+            Addr:              addr,
+            Handler:           withHealth(h, s),
+            ReadHeaderTimeout: 10 * time.Second,
+        }
+        if svc.tls != nil {
+            if srv.TLSConfig, err = svc.tls(cfg); err != nil {
+                log.Fatalf("%s: %v", svc.name, err)
+            }
+        }
+        servers = append(servers, srv)
+        log.Printf("%s listening on %s", svc.name, addr)
+    }
+
+    var wg sync.WaitGroup
+    failed := make(chan error, len(servers))
+    for _, srv := range servers {
+        wg.Add(1)
+        go func(srv *http.Server) {
+            defer wg.Done()
+            serve := srv.ListenAndServe
+            if srv.TLSConfig != nil {
+                // The certificates are in TLSConfig
+                serve = func() error { return srv.ListenAndServeTLS("", "") }
+            }
+            if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+                failed <- err
+            }
+        }(srv)
+    }
+
+    select {
+    case <-ctx.Done():
+    case err := <-failed:
+        log.Printf("server failed: %v", err)
+    }
+
+    // Kubernetes sends SIGTERM and waits terminationGracePeriodSeconds before SIGKILL
+    shutdown, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration)
+    defer cancel()
+    for _, srv := range servers {
+        srv.Shutdown(shutdown)
+    }
+    wg.Wait()
+}
+
+// withHealth serves liveness and readiness probes and /metrics next to the service routes.
+// With the canary on, readiness follows it and its metrics join the client clock skew ones
+func withHealth(h http.Handler, s *stores) http.Handler {
+    mux := http.NewServeMux()
+    mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusNoContent)
+    })
+    mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+        if s.canary != nil {
+            if err := s.canary.Ready(); err != nil {
+                http.Error(w, err.Error(), http.StatusServiceUnavailable)
+                return
+            }
+        }
+        w.WriteHeader(http.StatusNoContent)
+    })
+    mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+        if s.canary != nil {
+            s.canary.WriteMetrics(w)
+        }
+        s.clockSkews.WriteMetrics(w)
+        if s.retention != nil {
+            s.retention.WriteMetrics(w)
+        }
+        if s.envelope != nil {
+            s.envelope.WriteMetrics(w)
+        }
+        if s.anchorer != nil {
+            s.anchorer.WriteMetrics(w)
+        }
+        if s.anomaly != nil {
+            s.anomaly.WriteMetrics(w)
+        }
+        s.blocklist.WriteMetrics(w)
+        if s.shadow != nil {
+            s.shadow.WriteMetrics(w)
+        }
+        s.rollout.WriteMetrics(w)
+        s.flags.WriteMetrics(w)
+    })
+    mux.Handle("/", h)
+    return mux
+}
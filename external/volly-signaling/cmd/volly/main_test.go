@@ -0,0 +1,105 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+const (
+    testTenant    = "acme"
+    testAPIKey    = "test-key"
+    testAPISecret = "test-secret-with-enough-entropy-0001"
+)
+
+// testConfig is the default config with the test tenant and API key bootstrapped, admitting
+// tokens without PQ claims
+func testConfig() *config {
+    cfg := defaultConfig()
+    cfg.AllowLegacyTokens = true
+    cfg.Bootstrap = bootstrapConfig{Tenant: testTenant, APIKey: testAPIKey, APISecret: testAPISecret}
+    return cfg
+}
+
+// testStores builds the in-memory stores for cfg, stopped when the test ends
+func testStores(t *testing.T, cfg *config) *stores {
+    t.Helper()
+    ctx, cancel := context.WithCancel(context.Background())
+    s, err := newStores(ctx, cfg)
+    if err != nil {
+        cancel()
+        t.Fatal(err)
+    }
+    t.Cleanup(func() {
+        cancel()
+        s.Close()
+    })
+    return s
+}
+
+// testTokend serves tokend for cfg over HTTP
+func testTokend(t *testing.T, cfg *config, s *stores) *httptest.Server {
+    t.Helper()
+    h, err := newTokend(cfg, s)
+    if err != nil {
+        t.Fatal(err)
+    }
+    srv := httptest.NewServer(h)
+    t.Cleanup(srv.Close)
+    return srv
+}
+
+// testGateway serves the gateway for cfg over HTTP
+func testGateway(t *testing.T, cfg *config, s *stores) *httptest.Server {
+    t.Helper()
+    h, err := newGateway(cfg, s)
+    if err != nil {
+        t.Fatal(err)
+    }
+    srv := httptest.NewServer(h)
+    t.Cleanup(srv.Close)
+    return srv
+}
+
+// postJSON posts body as JSON to url after prepare has set its headers, and decodes a 200
+// response into out
+func postJSON(t *testing.T, url string, body, out interface{}, prepare func(*http.Request)) int {
+    t.Helper()
+    return postJSONWith(t, http.DefaultClient, url, body, out, prepare)
+}
+
+// postJSONWith is postJSON sent by client
+func postJSONWith(t *testing.T, client *http.Client, url string, body, out interface{}, prepare func(*http.Request)) int {
+    t.Helper()
+    data, err := json.Marshal(body)
+    if err != nil {
+        t.Fatal(err)
+    }
+    req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+    if err != nil {
+        t.Fatal(err)
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if prepare != nil {
+        prepare(req)
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode == http.StatusOK && out != nil {
+        if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return resp.StatusCode
+}
+
+// withAPIKey authenticates a request with the test API key
+func withAPIKey(r *http.Request) {
+    r.SetBasicAuth(testAPIKey, testAPISecret)
+}
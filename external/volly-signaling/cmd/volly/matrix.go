@@ -0,0 +1,112 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/audit"
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/matrix"
+)
+
+var errMatrixOff = errors.New("the Matrix bridge is not configured")
+
+type matrixTokenRequest struct {
+    // Room is the Matrix room ID or alias, mapped through matrix.rooms
+    Room        string              `json:"room"`
+    OpenIDToken *matrix.OpenIDToken `json:"openidToken"`
+    TTL         string              `json:"ttl,omitempty"`
+}
+
+type matrixTokenResponse struct {
+    Token     string    `json:"token"`
+    ExpiresAt time.Time `json:"expiresAt"`
+    // Room and Identity are what the Matrix room and user were mapped to
+    Room     string `json:"room"`
+    Identity string `json:"identity"`
+}
+
+// newMatrixValidator is nil unless matrix.homeservers names one
+func newMatrixValidator(cfg *config) (*matrix.Validator, error) {
+    if len(cfg.Matrix.Homeservers) == 0 {
+        return nil, nil
+    }
+    v := matrix.NewValidator()
+    for i, h := range cfg.Matrix.Homeservers {
+        if err := v.AddHomeserver(h); err != nil {
+            return nil, fmt.Errorf("matrix.homeservers[%d]: %w", i, err)
+        }
+    }
+    return v, nil
+}
+
+// mountMatrix adds the Matrix bridge at POST /v1/matrix/token: the OpenID token is checked
+// with its homeserver and the user gets a token for the Volly room the Matrix room maps to,
+// as the identity their Matrix ID maps to
+func mountMatrix(mux *http.ServeMux, cfg *config, s *stores, roles *auth.Roles) error {
+    v, err := newMatrixValidator(cfg)
+    if err != nil {
+        return err
+    }
+    mux.HandleFunc("POST /v1/matrix/token", func(w http.ResponseWriter, r *http.Request) {
+        if v == nil {
+            writeError(w, errMatrixOff)
+            return
+        }
+        var req matrixTokenRequest
+        if !readJSON(w, r, &req) {
+            return
+        }
+        if req.Room == "" || req.OpenIDToken == nil {
+            http.Error(w, "room and openidToken are required", http.StatusBadRequest)
+            return
+        }
+        ttl, ok := requestTTL(w, cfg, req.TTL)
+        if !ok {
+            return
+        }
+        if limit := cfg.Matrix.TTL.Duration; limit > 0 && limit < ttl {
+            ttl = limit
+        }
+        room, err := cfg.Matrix.Rooms.Resolve(req.Room)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        login, err := v.Validate(r.Context(), *req.OpenIDToken)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        key, err := tenantKey(r.Context(), s, login.Tenant)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        token, expiresAt, err := loginToken(r.Context(), cfg, s, roles, key, login.Identity, room, login.Role, auth.ProviderMatrix, ttl)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        e := audit.Entry{Actor: login.Identity, Action: "matrix.login", Tenant: login.Tenant, Target: room,
+            Detail: map[string]string{
+                "matrixUser": login.UserID.String(),
+                "matrixRoom": req.Room,
+                "role":       login.Role,
+                "remoteAddr": r.RemoteAddr,
+            }}
+        if err := s.audit.Append(r.Context(), e); err != nil {
+            writeError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, matrixTokenResponse{
+            Token:     token,
+            ExpiresAt: expiresAt,
+            Room:      room,
+            Identity:  login.Identity,
+        })
+    })
+    return nil
+}
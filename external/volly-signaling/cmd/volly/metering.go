@@ -0,0 +1,134 @@
+package main
+
+import (
+    "context"
+    "crypto/ed25519"
+    "net/http"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/anomaly"
+    "github.com/volly-org/volly-signaling/pkg/volly/configstore"
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+    "github.com/volly-org/volly-signaling/pkg/volly/metering"
+    "github.com/volly-org/volly-signaling/pkg/volly/tagset"
+)
+
+// usageDate is the form of the from and to parameters of GET /v1/usage
+const usageDate = "2006-01-02"
+
+// newUsage meters tenants for billing. Customers verify reports against the public key, so
+// it must survive restarts: metering needs VOLLY_USAGE_SIGNING_KEY and is off without it
+func newUsage(cfg *config) (*metering.Meter, error) {
+    if cfg.UsageSigningKey == "" {
+        return nil, nil
+    }
+    seed, err := sharedKey("VOLLY_USAGE_SIGNING_KEY", cfg.UsageSigningKey)
+    if err != nil {
+        return nil, err
+    }
+    priv := ed25519.NewKeyFromSeed(seed)
+    signer := crypto.NewSigner(&crypto.KeyPair{
+        Algorithm:  crypto.AlgorithmEd25519,
+        PublicKey:  priv.Public().(ed25519.PublicKey),
+        PrivateKey: priv,
+    })
+    // A connection that goes away without a release is billed until its last renewal
+    m := metering.NewMeter(signer).SetIdleTimeout(cfg.Connections.LeaseTTL.Duration)
+    if d := cfg.Usage.Retention.Duration; d > 0 {
+        m.SetRetention(d)
+    }
+    return m, nil
+}
+
+// tokenIssued meters a token with tags handed to a tenant's client for identity, and shows
+// it to anomaly detection
+func tokenIssued(ctx context.Context, s *stores, key configstore.APIKey, identity string, tags map[string]string) {
+    if s.usage != nil {
+        s.usage.TokenIssued(key.Tenant, tags)
+    }
+    observe(ctx, s, anomaly.Event{Kind: anomaly.KindIssued, Tenant: key.Tenant, Identity: identity, APIKey: key.ID})
+}
+
+type usageKey struct {
+    Algorithm string `json:"algorithm"`
+    PublicKey []byte `json:"publicKey"`
+}
+
+// usageRoutes serves signed usage reports as JSON or, with format=csv, CSV, and the key
+// they verify against. Egress sessions are only counted once a recording service reports
+// them to the meter
+func usageRoutes(mux *http.ServeMux, s *stores) {
+    // period is day or month, month by default; from and to are UTC dates, to exclusive,
+    // defaulting to the current month. tags=cost-center=eng,project=atlas reports only the
+    // usage of tokens carrying both
+    mux.HandleFunc("GET /v1/usage", func(w http.ResponseWriter, r *http.Request) {
+        if s.usage == nil {
+            http.Error(w, "usage metering is off; set VOLLY_USAGE_SIGNING_KEY", http.StatusNotFound)
+            return
+        }
+        q := r.URL.Query()
+        period := q.Get("period")
+        if period == "" {
+            period = metering.Month
+        }
+        now := time.Now().UTC()
+        from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+        to := from.AddDate(0, 1, 0)
+        var err error
+        if v := q.Get("from"); v != "" {
+            if from, err = time.Parse(usageDate, v); err != nil {
+                http.Error(w, "invalid from", http.StatusBadRequest)
+                return
+            }
+        }
+        if v := q.Get("to"); v != "" {
+            if to, err = time.Parse(usageDate, v); err != nil {
+                http.Error(w, "invalid to", http.StatusBadRequest)
+                return
+            }
+        }
+        tags, err := tagset.Parse(q.Get("tags"))
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        reports, err := s.usage.Reports(q.Get("tenant"), period, from, to, tags)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        switch q.Get("format") {
+        case "", "json":
+            writeJSON(w, http.StatusOK, reports)
+        case "csv":
+            w.Header().Set("Content-Type", "text/csv")
+            metering.WriteCSV(w, reports)
+        default:
+            http.Error(w, "format must be json or csv", http.StatusBadRequest)
+        }
+    })
+    mux.HandleFunc("GET /v1/usage/key", func(w http.ResponseWriter, r *http.Request) {
+        if s.usage == nil {
+            http.Error(w, "usage metering is off; set VOLLY_USAGE_SIGNING_KEY", http.StatusNotFound)
+            return
+        }
+        alg, key := s.usage.PublicKey()
+        writeJSON(w, http.StatusOK, usageKey{Algorithm: alg, PublicKey: key})
+    })
+    mux.HandleFunc("POST /v1/usage/verify", func(w http.ResponseWriter, r *http.Request) {
+        if s.usage == nil {
+            http.Error(w, "usage metering is off; set VOLLY_USAGE_SIGNING_KEY", http.StatusNotFound)
+            return
+        }
+        var report metering.Report
+        if !readJSON(w, r, &report) {
+            return
+        }
+        _, key := s.usage.PublicKey()
+        if err := metering.Verify(&report, key); err != nil {
+            writeError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, map[string]bool{"valid": true})
+    })
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "time"
+
+    lkauth "github.com/livekit/protocol/auth"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/audit"
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/configstore"
+    "github.com/volly-org/volly-signaling/pkg/volly/webauthn"
+)
+
+var (
+    errPasskeysOff     = errors.New("webauthn is not configured")
+    errPasskeyLoginOff = errors.New("passkey login is not configured")
+    errNoSigningKey    = errors.New("tenant has no active API key to sign with")
+)
+
+type passkeyRegisterRequest struct {
+    Identity    string `json:"identity"`
+    DisplayName string `json:"displayName,omitempty"`
+    // Credential is the browser's answer to the options from the begin call
+    Credential *webauthn.Registration `json:"credential,omitempty"`
+}
+
+type passkeyLoginRequest struct {
+    Tenant string `json:"tenant"`
+    // Identity, at begin, limits the login to that identity's passkeys
+    Identity string `json:"identity,omitempty"`
+    Room     string `json:"room,omitempty"`
+    TTL      string `json:"ttl,omitempty"`
+    // Credential is the browser's answer to the options from the begin call
+    Credential *webauthn.Assertion `json:"credential,omitempty"`
+}
+
+// mountPasskeys adds tokend's passkey endpoints. Registration is for the application's backend,
+// which has authenticated the user itself, and uses HTTP basic auth like POST /v1/token; login
+// is for browsers and needs no credential but the passkey
+func mountPasskeys(mux *http.ServeMux, cfg *config, s *stores, roles *auth.Roles) {
+    mux.HandleFunc("POST /v1/webauthn/registrations/begin", func(w http.ResponseWriter, r *http.Request) {
+        if s.passkeys == nil {
+            writeError(w, errPasskeysOff)
+            return
+        }
+        key, ok := basicAuthKey(w, r, s)
+        if !ok {
+            return
+        }
+        var req passkeyRegisterRequest
+        if !readJSON(w, r, &req) {
+            return
+        }
+        if req.Identity == "" {
+            http.Error(w, "identity is required", http.StatusBadRequest)
+            return
+        }
+        options, err := s.passkeys.BeginRegistration(r.Context(), key.Tenant, req.Identity, req.DisplayName)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, options)
+    })
+    mux.HandleFunc("POST /v1/webauthn/registrations/finish", func(w http.ResponseWriter, r *http.Request) {
+        if s.passkeys == nil {
+            writeError(w, errPasskeysOff)
+            return
+        }
+        key, ok := basicAuthKey(w, r, s)
+        if !ok {
+            return
+        }
+        var req passkeyRegisterRequest
+        if !readJSON(w, r, &req) {
+            return
+        }
+        if req.Identity == "" || req.Credential == nil {
+            http.Error(w, "identity and credential are required", http.StatusBadRequest)
+            return
+        }
+        cred, err := s.passkeys.FinishRegistration(r.Context(), key.Tenant, req.Identity, *req.Credential)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        e := audit.Entry{Actor: key.ID, Action: "webauthn.register", Tenant: key.Tenant, Target: req.Identity,
+            Detail: map[string]string{"credential": cred.ID}}
+        if err := s.audit.Append(r.Context(), e); err != nil {
+            writeError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusCreated, cred)
+    })
+
+    mux.HandleFunc("POST /v1/webauthn/login/begin", func(w http.ResponseWriter, r *http.Request) {
+        if s.passkeys == nil || cfg.WebAuthn.LoginRole == "" {
+            writeError(w, errPasskeyLoginOff)
+            return
+        }
+        var req passkeyLoginRequest
+        if !readJSON(w, r, &req) {
+            return
+        }
+        options, err := s.passkeys.BeginLogin(r.Context(), req.Tenant, req.Identity)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, options)
+    })
+    mux.HandleFunc("POST /v1/webauthn/login/finish", func(w http.ResponseWriter, r *http.Request) {
+        if s.passkeys == nil || cfg.WebAuthn.LoginRole == "" {
+            writeError(w, errPasskeyLoginOff)
+            return
+        }
+        var req passkeyLoginRequest
+        if !readJSON(w, r, &req) {
+            return
+        }
+        if req.Room == "" || req.Credential == nil {
+            http.Error(w, "room and credential are required", http.StatusBadRequest)
+            return
+        }
+        ttl, ok := requestTTL(w, cfg, req.TTL)
+        if !ok {
+            return
+        }
+        // Looked up first, so a suspended tenant is refused before a counter moves
+        key, err := tenantKey(r.Context(), s, req.Tenant)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        cred, v, err := s.passkeys.FinishLogin(r.Context(), req.Tenant, *req.Credential)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+
+        token, expiresAt, err := loginToken(r.Context(), cfg, s, roles, key, cred.Identity, req.Room, cfg.WebAuthn.LoginRole, auth.ProviderPasskey, ttl)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        e := audit.Entry{Actor: cred.Identity, Action: "webauthn.login", Tenant: key.Tenant, Target: req.Room,
+            Detail: map[string]string{
+                "credential": cred.ID,
+                "proof":      v.Digest,
+                "remoteAddr": r.RemoteAddr,
+            }}
+        if err := s.audit.Append(r.Context(), e); err != nil {
+            writeError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, tokenResponse{Token: token, ExpiresAt: expiresAt})
+    })
+}
+
+// tenantKey picks an active API key of tenant to sign tokens minted on its behalf, preferring
+// one that is not being rotated out
+func tenantKey(ctx context.Context, s *stores, tenant string) (configstore.APIKey, error) {
+    keys, err := s.config.ListAPIKeys(ctx, tenant)
+    if err != nil {
+        return configstore.APIKey{}, err
+    }
+    var fallback configstore.APIKey
+    for _, k := range keys {
+        key, err := activeKey(ctx, s, k.ID)
+        if errors.Is(err, errTenantBlocked) {
+            return configstore.APIKey{}, err
+        }
+        if err != nil {
+            continue
+        }
+        if key.NotAfter.IsZero() {
+            return key, nil
+        }
+        if fallback.ID == "" {
+            fallback = key
+        }
+    }
+    if fallback.ID == "" {
+        return configstore.APIKey{}, errNoSigningKey
+    }
+    return fallback, nil
+}
+
+// loginToken mints a room join token for identity after it logged in at tokend itself, with
+// provider, rather than through the tenant's backend. It is signed with key and carries role,
+// which the directory must grant in a tenant it manages
+func loginToken(ctx context.Context, cfg *config, s *stores, roles *auth.Roles, key configstore.APIKey, identity, room, role, provider string, ttl time.Duration) (string, time.Time, error) {
+    granted, err := s.directory.Authorize(ctx, key.Tenant, identity, role)
+    if err != nil {
+        return "", time.Time{}, err
+    }
+    at := accessToken(s, key).
+        AddGrant(&auth.VollyVideoGrant{VideoGrant: lkauth.VideoGrant{RoomJoin: true, Room: room}}).
+        SetIdentity(identity).
+        SetTenant(key.Tenant).
+        SetProvenance(tokendProvenance(provider)).
+        SetRoles(roles).
+        SetClaimSchemas(s.claimSchemas).
+        SetCryptoProfiles(s.cryptoProfiles)
+    for _, r := range granted {
+        at.AddRole(r)
+    }
+    at.SetValidFor(ttl).
+        SetExpiryJitter(cfg.ExpiryJitter.Duration).
+        SetNotBeforeGrace(cfg.NotBeforeGrace.Duration).
+        SetIssuanceCheck(s.killSwitch.CheckGrant).
+        SetSizeBudget(cfg.TokenBudget, nil)
+    token, err := scopeToken(cfg, at, key.Tenant).ToJWT()
+    if err != nil {
+        return "", time.Time{}, err
+    }
+    tokenIssued(ctx, s, key, identity, nil)
+    return token, at.ExpiresAt(), nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/wasmplugin"
+)
+
+// newWASMPlugins loads cfg.WASMPlugins. Each runs as an admission hook and a claim
+// transformer when it exports them, and is reloaded when its file changes
+func newWASMPlugins(ctx context.Context, cfg *config) ([]*wasmplugin.File, error) {
+    var plugins []*wasmplugin.File
+    for i, pc := range cfg.WASMPlugins {
+        if pc.Path == "" {
+            return nil, fmt.Errorf("wasmPlugins[%d]: path is required", i)
+        }
+        name := pc.Name
+        if name == "" {
+            name = pc.Path
+        }
+        opts := wasmplugin.Options{Timeout: pc.Timeout.Duration, MemoryPages: pc.MemoryPages}
+        f, err := wasmplugin.OpenFile(ctx, name, pc.Path, opts)
+        if err != nil {
+            return nil, fmt.Errorf("wasmPlugins[%d]: %w", i, err)
+        }
+        f.SetFailOpen(pc.FailOpen).
+            SetReport(func(ctx context.Context, name string, err error) {
+                log.Printf("gateway: wasm plugin %s: %v", name, err)
+            })
+        if d := pc.ReloadInterval.Duration; d > 0 {
+            f.SetInterval(d)
+        }
+        go f.Run(ctx)
+        plugins = append(plugins, f)
+    }
+    return plugins, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+    "log"
+    "net/http"
+    "net/http/httputil"
+    "net/url"
+    "os"
+    "strings"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/replay"
+)
+
+// newProxy records every WebSocket session to cfg.Proxy.Dir and forwards other requests to
+// the gateway untouched, so clients can be pointed at it in place of the gateway
+func newProxy(cfg *config, s *stores) (http.Handler, error) {
+    upstream, err := url.Parse(cfg.Proxy.Upstream)
+    if err != nil {
+        return nil, err
+    }
+    if err := os.MkdirAll(cfg.Proxy.Dir, 0o700); err != nil {
+        return nil, err
+    }
+    sessions, err := replay.NewProxy(cfg.Proxy.Upstream, replay.DirSink(cfg.Proxy.Dir))
+    if err != nil {
+        return nil, err
+    }
+    sessions.OnError(func(err error) { log.Printf("proxy: saving transcript: %v", err) })
+    rest := httputil.NewSingleHostReverseProxy(upstream)
+
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+            sessions.ServeHTTP(w, r)
+            return
+        }
+        rest.ServeHTTP(w, r)
+    }), nil
+}
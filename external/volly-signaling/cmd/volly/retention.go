@@ -0,0 +1,65 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/retention"
+)
+
+// auditMinRetention is the shortest audit retention accepted, so a typo in the config
+// cannot wipe the audit trail
+const auditMinRetention = 7 * 24 * time.Hour
+
+// newRetention prunes the stores cfg.Retention gives a window, or returns nil when it gives
+// none. Revocations must outlive the longest token tokend issues, or a revoked token would
+// verify again before it expires
+func newRetention(cfg *config, s *stores) (*retention.Job, error) {
+    rc := cfg.Retention
+    job := retention.NewJob().SetDryRun(rc.DryRun)
+    if rc.Interval.Duration > 0 {
+        job.SetInterval(rc.Interval.Duration)
+    }
+    added := false
+    if rc.Audit.Duration > 0 {
+        if p, ok := s.audit.(retention.Pruner); ok {
+            if err := job.Add("audit", p, rc.Audit.Duration, auditMinRetention); err != nil {
+                return nil, err
+            }
+            added = true
+        }
+    }
+    if rc.Revocations.Duration > 0 {
+        if p, ok := s.revoked.(retention.Pruner); ok {
+            if err := job.Add("revocations", p, rc.Revocations.Duration, cfg.MaxTokenTTL.Duration); err != nil {
+                return nil, err
+            }
+            added = true
+        }
+    }
+    if !added {
+        return nil, nil
+    }
+    return job, nil
+}
+
+// retentionRoutes reports the retention job and runs it on demand, e.g. to check a dry run
+func retentionRoutes(mux *http.ServeMux, s *stores) {
+    mux.HandleFunc("GET /v1/retention", func(w http.ResponseWriter, r *http.Request) {
+        if s.retention == nil {
+            http.Error(w, "retention is off; set retention.audit or retention.revocations", http.StatusNotFound)
+            return
+        }
+        writeJSON(w, http.StatusOK, s.retention.Stats())
+    })
+    mux.HandleFunc("POST /v1/retention/prune", func(w http.ResponseWriter, r *http.Request) {
+        if s.retention == nil {
+            http.Error(w, "retention is off; set retention.audit or retention.revocations", http.StatusNotFound)
+            return
+        }
+        results := s.retention.Prune(context.WithoutCancel(r.Context()))
+        record(r, s, "retention.prune", "", "")
+        writeJSON(w, http.StatusOK, results)
+    })
+}
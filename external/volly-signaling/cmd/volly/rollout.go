@@ -0,0 +1,76 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/rollout"
+)
+
+// featureCanonical is canonical issuance, see cfg.CanonicalWindow. It is fully rolled out
+// whenever the window is set, unless cfg.Rollout ramps it
+const featureCanonical = "canonical"
+
+// rolloutFeatures are the token features tokend can ramp
+var rolloutFeatures = map[string]bool{featureCanonical: true}
+
+// Outcomes counted by cohort: tokend counts issued tokens and failures to sign them, the
+// gateway admissions and refusals of verified tokens
+const (
+    rolloutIssued      = "issued"
+    rolloutIssueFailed = "issue_failed"
+    rolloutAdmitted    = "admitted"
+    rolloutRejected    = "rejected"
+)
+
+// newRollout applies cfg.Rollout. Unknown features are refused so a misspelt one cannot
+// silently stay off
+func newRollout(cfg *config) (*rollout.Rollout, error) {
+    r := rollout.New()
+    if cfg.CanonicalWindow.Duration > 0 {
+        r.SetPercent(featureCanonical, 100)
+    }
+    for feature, percent := range cfg.Rollout {
+        if !rolloutFeatures[feature] {
+            return nil, fmt.Errorf("rollout: unknown feature %q", feature)
+        }
+        if err := r.SetPercent(feature, percent); err != nil {
+            return nil, err
+        }
+    }
+    return r, nil
+}
+
+type rolloutStatus struct {
+    Percents map[string]float64 `json:"percents"`
+    Counts   []rollout.Count    `json:"counts"`
+}
+
+type rolloutRequest struct {
+    Percent float64 `json:"percent"`
+}
+
+// rolloutRoutes shows and ramps token features. A change applies to this process only;
+// split deployments set cfg.Rollout so tokend and the gateway agree on cohorts
+func rolloutRoutes(mux *http.ServeMux, s *stores) {
+    mux.HandleFunc("GET /v1/rollout", func(w http.ResponseWriter, r *http.Request) {
+        writeJSON(w, http.StatusOK, rolloutStatus{Percents: s.rollout.Percents(), Counts: s.rollout.Stats()})
+    })
+    mux.HandleFunc("PUT /v1/rollout/{feature}", func(w http.ResponseWriter, r *http.Request) {
+        feature := r.PathValue("feature")
+        if !rolloutFeatures[feature] {
+            http.Error(w, "unknown feature", http.StatusNotFound)
+            return
+        }
+        var req rolloutRequest
+        if !readJSON(w, r, &req) {
+            return
+        }
+        if err := s.rollout.SetPercent(feature, req.Percent); err != nil {
+            writeError(w, err)
+            return
+        }
+        record(r, s, "rollout.set", "", fmt.Sprintf("%s=%g", feature, req.Percent))
+        w.WriteHeader(http.StatusNoContent)
+    })
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+    "context"
+    "encoding/base64"
+    "errors"
+    "log"
+    "net/http"
+    "net/netip"
+    "strconv"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/audit"
+    "github.com/volly-org/volly-signaling/pkg/volly/events"
+    "github.com/volly-org/volly-signaling/pkg/volly/roompin"
+)
+
+const (
+    // eventPINLockout is published when an identity runs out of PIN attempts, so every
+    // instance locks it out of the room
+    eventPINLockout = "room.pin_lockout"
+    // eventPINUnlocked is published when an operator lifts a lockout
+    eventPINUnlocked = "room.pin_unlocked"
+)
+
+var errPINOff = errors.New("room PINs are not configured")
+
+type pinHashRequest struct {
+    PIN string `json:"pin"`
+}
+
+type pinHashResponse struct {
+    PINHash string `json:"pinHash"`
+}
+
+// pinRequired is the 401 body when a room asks for a PIN: the edge prompts the participant
+// and calls POST /v1/admit again with it
+type pinRequired struct {
+    Error     string `json:"error"`
+    Challenge string `json:"challenge"`
+}
+
+// newPINGate is nil unless VOLLY_PIN_KEY is set. It checks PINs against the room policies in
+// the config store and counts wrong ones in the shared attempt store; lockouts also go out
+// on the event bus for instances without a database
+func newPINGate(cfg *config, s *stores) (*roompin.Hasher, *roompin.Gate, error) {
+    if cfg.PINKey == "" {
+        return nil, nil, nil
+    }
+    key, err := base64.StdEncoding.DecodeString(cfg.PINKey)
+    if err != nil || len(key) != 32 {
+        return nil, nil, errors.New("VOLLY_PIN_KEY must be 32 bytes of base64")
+    }
+    hasher := roompin.NewHasher(key)
+    gate := roompin.NewGate(hasher, s.config).SetAttemptStore(s.pinAttempts)
+    maxAttempts := cfg.RoomPIN.MaxAttempts
+    if maxAttempts <= 0 {
+        maxAttempts = roompin.DefaultMaxAttempts
+    }
+    roomAttempts, remoteIPAttempts := cfg.RoomPIN.RoomAttempts, cfg.RoomPIN.RemoteIPAttempts
+    if roomAttempts <= 0 {
+        roomAttempts = roompin.DefaultRoomAttempts
+    }
+    if remoteIPAttempts <= 0 {
+        remoteIPAttempts = roompin.DefaultRemoteIPAttempts
+    }
+    window, lockout := cfg.RoomPIN.Window.Duration, cfg.RoomPIN.Lockout.Duration
+    if window <= 0 {
+        window = roompin.DefaultWindow
+    }
+    if lockout <= 0 {
+        lockout = roompin.DefaultLockout
+    }
+    gate.SetLimits(maxAttempts, window, lockout).
+        SetBudgets(roomAttempts, remoteIPAttempts).
+        SetLockoutReport(func(ctx context.Context, l roompin.Lockout) {
+            pinLockedOut(ctx, s, l)
+        })
+    s.bus.Subscribe(eventPINLockout, func(ctx context.Context, event events.Event) {
+        until, err := strconv.ParseInt(event.Data["until"], 10, 64)
+        if err != nil {
+            return
+        }
+        l := roompin.Lockout{Tenant: event.Data["tenant"], Room: event.Room, Identity: event.Identity, Until: time.Unix(until, 0)}
+        if addr := event.Data["remoteIP"]; addr != "" {
+            if l.RemoteIP, err = netip.ParseAddr(addr); err != nil {
+                return
+            }
+        }
+        if err := gate.Lock(ctx, l); err != nil {
+            log.Printf("gateway: applying %s failed: %v", eventPINLockout, err)
+        }
+    })
+    s.bus.Subscribe(eventPINUnlocked, func(ctx context.Context, event events.Event) {
+        if err := gate.Unlock(ctx, event.Data["tenant"], event.Room, event.Identity); err != nil {
+            log.Printf("gateway: applying %s failed: %v", eventPINUnlocked, err)
+        }
+    })
+    return hasher, gate, nil
+}
+
+// pinLockedOut logs, audits and publishes a lockout this instance started
+func pinLockedOut(ctx context.Context, s *stores, l roompin.Lockout) {
+    detail := map[string]string{"attempts": strconv.Itoa(l.Attempts), "until": l.Until.UTC().Format(time.RFC3339)}
+    data := map[string]string{"tenant": l.Tenant, "until": strconv.FormatInt(l.Until.Unix(), 10)}
+    e := audit.Entry{Actor: "volly", Action: "room.pin_lockout", Tenant: l.Tenant, Target: l.Identity, Detail: detail}
+    switch {
+    case l.RemoteIP.IsValid():
+        log.Printf("gateway: %s locked out of PIN rooms in tenant %s after %d wrong PINs", s.redactor.Address(l.RemoteIP.String()), l.Tenant, l.Attempts)
+        e.Target = l.RemoteIP.String()
+        detail["remoteIP"], data["remoteIP"] = l.RemoteIP.String(), l.RemoteIP.String()
+    case l.Identity == "":
+        log.Printf("gateway: room %s in tenant %s locked after %d wrong PINs", l.Room, l.Tenant, l.Attempts)
+        e.Target = l.Room
+        detail["room"] = l.Room
+    default:
+        log.Printf("gateway: %s locked out of room %s in tenant %s after %d wrong PINs", s.redactor.Identity(l.Identity), l.Room, l.Tenant, l.Attempts)
+        detail["room"] = l.Room
+    }
+    if err := s.audit.Append(ctx, e); err != nil {
+        log.Printf("audit append failed: %v", err)
+    }
+    event := events.Event{Type: eventPINLockout, Room: l.Room, Identity: l.Identity, Data: data}
+    if err := s.bus.Publish(ctx, event); err != nil {
+        log.Printf("gateway: publishing %s failed: %v", eventPINLockout, err)
+    }
+}
+
+// pinChallenge answers 401 asking for the PIN when err is roompin.ErrPINRequired or
+// ErrWrongPIN, and 429 until a lockout ends, reporting whether it did either
+func pinChallenge(w http.ResponseWriter, err error) bool {
+    var locked *roompin.LockedError
+    if errors.As(err, &locked) {
+        retry := int(time.Until(locked.Until)/time.Second) + 1
+        w.Header().Set("Retry-After", strconv.Itoa(retry))
+        http.Error(w, err.Error(), http.StatusTooManyRequests)
+        return true
+    }
+    if !errors.Is(err, roompin.ErrPINRequired) && !errors.Is(err, roompin.ErrWrongPIN) {
+        return false
+    }
+    writeJSON(w, http.StatusUnauthorized, pinRequired{Error: err.Error(), Challenge: "pin"})
+    return true
+}
+
+// pinRoutes hash PINs for room policies and lift lockouts
+func pinRoutes(mux *http.ServeMux, s *stores) {
+    mux.HandleFunc("POST /v1/tenants/{tenant}/pin-hash", func(w http.ResponseWriter, r *http.Request) {
+        if s.pinHasher == nil {
+            writeError(w, errPINOff)
+            return
+        }
+        var req pinHashRequest
+        if !readJSON(w, r, &req) {
+            return
+        }
+        hash, err := s.pinHasher.Hash(r.PathValue("tenant"), req.PIN)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, pinHashResponse{PINHash: hash})
+    })
+    mux.HandleFunc("DELETE /v1/tenants/{tenant}/pin-lockouts/{room}/{identity}", func(w http.ResponseWriter, r *http.Request) {
+        if s.pinGate == nil {
+            writeError(w, errPINOff)
+            return
+        }
+        tenant, room, identity := r.PathValue("tenant"), r.PathValue("room"), r.PathValue("identity")
+        event := events.Event{Type: eventPINUnlocked, Room: room, Identity: identity, Data: map[string]string{"tenant": tenant}}
+        if err := s.bus.Publish(r.Context(), event); err != nil {
+            writeError(w, err)
+            return
+        }
+        record(r, s, "room.pin_unlock", tenant, identity)
+        w.WriteHeader(http.StatusNoContent)
+    })
+}
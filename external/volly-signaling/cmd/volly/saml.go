@@ -0,0 +1,165 @@
+package main
+
+import (
+    "errors"
+    "net/http"
+    "net/url"
+    "os"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/audit"
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/saml"
+)
+
+var errSAMLOff = errors.New("SAML login is not configured")
+
+// newServiceProvider is nil unless saml.entityId is set
+func newServiceProvider(cfg *config) (*saml.ServiceProvider, error) {
+    c := cfg.SAML
+    if c.EntityID == "" {
+        return nil, nil
+    }
+    if c.ACSURL == "" || len(c.IdPs) == 0 {
+        return nil, errors.New("saml needs acsUrl and at least one IdP")
+    }
+    key, err := sharedKey("VOLLY_SAML_KEY", cfg.SAMLKey)
+    if err != nil {
+        return nil, err
+    }
+    sp := saml.New(c.EntityID, c.ACSURL, key).AllowUnsolicited(c.AllowIdPInitiated)
+    if d := c.ClockSkew.Duration; d > 0 {
+        sp.SetClockSkew(d)
+    }
+    for _, ic := range c.IdPs {
+        idp := saml.IdentityProvider{EntityID: ic.EntityID, SSOURL: ic.SSOURL}
+        if ic.Metadata != "" {
+            data, err := os.ReadFile(ic.Metadata)
+            if err != nil {
+                return nil, err
+            }
+            if idp, err = saml.ParseIdPMetadata(data); err != nil {
+                return nil, err
+            }
+        }
+        for _, pem := range ic.Certificates {
+            cert, err := saml.ParseCertificate(pem)
+            if err != nil {
+                return nil, err
+            }
+            idp.Certificates = append(idp.Certificates, cert)
+        }
+        if idp.EntityID == "" || len(idp.Certificates) == 0 {
+            return nil, errors.New("saml: every IdP needs an entityId and a certificate")
+        }
+        sp.AddIdentityProvider(idp, ic.Mapping)
+    }
+    return sp, nil
+}
+
+// mountSAML adds tokend's SAML service provider: GET /v1/saml/metadata for IdP
+// administrators, GET /v1/saml/login?idp=&room= to send a browser to its IdP and the
+// assertion consumer service at POST /v1/saml/acs, which mints a token for the mapped
+// tenant, identity and role
+func mountSAML(mux *http.ServeMux, cfg *config, s *stores, roles *auth.Roles) error {
+    sp, err := newServiceProvider(cfg)
+    if err != nil {
+        return err
+    }
+    mux.HandleFunc("GET /v1/saml/metadata", func(w http.ResponseWriter, r *http.Request) {
+        if sp == nil {
+            writeError(w, errSAMLOff)
+            return
+        }
+        w.Header().Set("Content-Type", "application/samlmetadata+xml")
+        w.Write(sp.Metadata())
+    })
+    mux.HandleFunc("GET /v1/saml/login", func(w http.ResponseWriter, r *http.Request) {
+        if sp == nil {
+            writeError(w, errSAMLOff)
+            return
+        }
+        q := r.URL.Query()
+        if q.Get("idp") == "" || q.Get("room") == "" {
+            http.Error(w, "idp and room are required", http.StatusBadRequest)
+            return
+        }
+        // The room rides in RelayState; it is the user's choice as much as at passkey login
+        u, err := sp.AuthnRequestURL(q.Get("idp"), q.Get("room"))
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        http.Redirect(w, r, u, http.StatusFound)
+    })
+    mux.HandleFunc("POST /v1/saml/acs", func(w http.ResponseWriter, r *http.Request) {
+        if sp == nil {
+            writeError(w, errSAMLOff)
+            return
+        }
+        r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+        if err := r.ParseForm(); err != nil {
+            http.Error(w, "malformed form", http.StatusBadRequest)
+            return
+        }
+        login, err := sp.ParseResponse(r.PostForm.Get("SAMLResponse"), r.PostForm.Get("RelayState"))
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        room := login.RelayState
+        if room == "" {
+            http.Error(w, "no room in RelayState", http.StatusBadRequest)
+            return
+        }
+        key, err := tenantKey(r.Context(), s, login.Tenant)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        token, expiresAt, err := loginToken(r.Context(), cfg, s, roles, key, login.Identity, room, login.Role, auth.ProviderSAML, cfg.MaxTokenTTL.Duration)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        e := audit.Entry{Actor: login.Identity, Action: "saml.login", Tenant: login.Tenant, Target: room,
+            Detail: map[string]string{
+                "idp":          login.IdP,
+                "nameId":       login.NameID,
+                "role":         login.Role,
+                "assertion":    login.AssertionID,
+                "sessionIndex": login.SessionIndex,
+                "remoteAddr":   r.RemoteAddr,
+            }}
+        if err := s.audit.Append(r.Context(), e); err != nil {
+            writeError(w, err)
+            return
+        }
+        if cfg.SAML.RedirectURL == "" {
+            writeJSON(w, http.StatusOK, tokenResponse{Token: token, ExpiresAt: expiresAt})
+            return
+        }
+        // In the fragment, so the token stays out of the application's server logs
+        fragment := url.Values{"token": {token}, "room": {room}, "expiresAt": {expiresAt.UTC().Format(time.RFC3339)}}
+        http.Redirect(w, r, cfg.SAML.RedirectURL+"#"+fragment.Encode(), http.StatusSeeOther)
+    })
+    return nil
+}
+
+// isSAMLRejection reports a SAML response that did not check out
+func isSAMLRejection(err error) bool {
+    var status *saml.StatusError
+    if errors.As(err, &status) {
+        return true
+    }
+    for _, target := range []error{
+        saml.ErrMalformedResponse, saml.ErrUnsigned, saml.ErrSignatureInvalid, saml.ErrUnsupportedAlgorithm,
+        saml.ErrEncryptedAssertion, saml.ErrResponseExpired, saml.ErrWrongAudience, saml.ErrWrongDestination,
+        saml.ErrUnsolicited, saml.ErrUnknownRequest, saml.ErrReplayed,
+    } {
+        if errors.Is(err, target) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/gateway"
+    "github.com/volly-org/volly-signaling/pkg/volly/presence"
+)
+
+// newSessionLimits caps the connections an identity holds at once per cfg.Sessions; nil
+// without a cap
+func newSessionLimits(cfg *config, s *stores) (*presence.SessionLimiter, presence.Store) {
+    c := cfg.Sessions
+    if c.MaxPerIdentity <= 0 && len(c.TenantLimits) == 0 {
+        return nil, nil
+    }
+    store := presence.NewMemoryStore()
+    l := presence.NewSessionLimiter(store, connectionDisplacer{s}, presence.Limit{MaxSessions: c.MaxPerIdentity, Policy: c.Evict})
+    for tenant, max := range c.TenantLimits {
+        l.SetTenantLimit(tenant, presence.Limit{MaxSessions: max, Policy: c.Evict})
+    }
+    return l, store
+}
+
+// connectionDisplacer ends a connection the session cap evicted by releasing its lease, so
+// the edge closes it when its next renewal fails
+type connectionDisplacer struct {
+    s *stores
+}
+
+// Displace releases the evicted session's connection
+func (d connectionDisplacer) Displace(ctx context.Context, session presence.Session, reason string) error {
+    err := releaseConnection(ctx, d.s, session.SessionID)
+    if errors.Is(err, gateway.ErrLeaseNotFound) {
+        return nil
+    }
+    return err
+}
+
+// admitSession counts an admitted connection against its identity's session cap, giving up
+// its lease when the cap refuses it
+func admitSession(ctx context.Context, s *stores, lease gateway.Lease, grant *auth.VollyVideoGrant) error {
+    if s.sessionLimits == nil {
+        return nil
+    }
+    _, err := s.sessionLimits.Admit(ctx, presence.Session{
+        SessionID: lease.ID,
+        Tenant:    grant.Tenant,
+        Room:      grant.Room,
+        Identity:  grant.Identity,
+        Kind:      presence.KindHuman,
+        JoinedAt:  time.Now(),
+    })
+    if err != nil {
+        s.connections.Release(lease.ID)
+    }
+    return err
+}
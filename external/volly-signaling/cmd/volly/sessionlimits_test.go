@@ -0,0 +1,59 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/presence"
+)
+
+// TestAdmitSessionCap refuses a join over an identity's session cap until one of its
+// connections is released, and with evict set to oldest ends the oldest instead
+func TestAdmitSessionCap(t *testing.T) {
+    cfg := testConfig()
+    cfg.Sessions.MaxPerIdentity = 2
+    gw := testGateway(t, cfg, testStores(t, cfg))
+    token := geoToken(t, nil, nil)
+    admit := func() (admitResponse, int) {
+        var resp admitResponse
+        code := postJSON(t, gw.URL+"/v1/admit", admitRequest{Token: token}, &resp, nil)
+        return resp, code
+    }
+
+    first, code := admit()
+    if code != http.StatusOK {
+        t.Fatalf("first join: status %d", code)
+    }
+    if _, code := admit(); code != http.StatusOK {
+        t.Fatalf("second join: status %d", code)
+    }
+    if _, code := admit(); code != http.StatusTooManyRequests {
+        t.Fatalf("join over the cap: status %d, want 429", code)
+    }
+    req, err := http.NewRequest(http.MethodDelete, gw.URL+"/v1/connections/"+first.ConnectionID, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if _, code := admit(); code != http.StatusOK {
+        t.Fatalf("join after a leave: status %d, want 200", code)
+    }
+
+    cfg = testConfig()
+    cfg.Sessions = sessionsConfig{MaxPerIdentity: 1, Evict: presence.EvictOldest}
+    gw = testGateway(t, cfg, testStores(t, cfg))
+    oldest, code := admit()
+    if code != http.StatusOK {
+        t.Fatalf("first join: status %d", code)
+    }
+    if _, code := admit(); code != http.StatusOK {
+        t.Fatalf("join evicting the oldest: status %d, want 200", code)
+    }
+    if code := postJSON(t, gw.URL+"/v1/connections/"+oldest.ConnectionID+"/renew", struct{}{}, nil, nil); code != http.StatusNotFound {
+        t.Fatalf("renewing the evicted connection: status %d, want 404", code)
+    }
+}
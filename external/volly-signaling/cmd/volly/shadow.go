@@ -0,0 +1,85 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "os"
+    "sync"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+)
+
+// newShadow builds the shadow verifier cfg.ShadowVerify describes, nil when it names no
+// algorithm. Without a keys file each API key gets a throwaway candidate key on first use,
+// which measures the candidate algorithm's cost and token size but not a real key set
+func newShadow(cfg *config) (*auth.Shadow, error) {
+    c := cfg.ShadowVerify
+    if c.Algorithm == "" {
+        return nil, nil
+    }
+    var candidates auth.CandidateKeys
+    if c.KeysFile != "" {
+        data, err := os.ReadFile(c.KeysFile)
+        if err != nil {
+            return nil, err
+        }
+        var set auth.CandidateKeySet
+        if err := json.Unmarshal(data, &set); err != nil {
+            return nil, fmt.Errorf("shadow verify keys: %w", err)
+        }
+        for apiKey, kp := range set {
+            if kp.Algorithm != c.Algorithm {
+                return nil, fmt.Errorf("shadow verify key for %s is %s, not %s", apiKey, kp.Algorithm, c.Algorithm)
+            }
+        }
+        candidates = set
+    } else {
+        if _, err := crypto.GenerateSigningKeyPair(c.Algorithm); err != nil {
+            return nil, err
+        }
+        candidates = &generatedKeys{algorithm: c.Algorithm, keys: make(map[string]*crypto.KeyPair)}
+    }
+    shadow := auth.NewShadow(candidates).SetClaimLimits(cfg.ClaimLimits)
+    if c.SampleRate > 0 {
+        shadow.SetSampleRate(c.SampleRate)
+    }
+    if c.Concurrency > 0 {
+        shadow.SetConcurrency(c.Concurrency)
+    }
+    return shadow, nil
+}
+
+// generatedKeys makes a candidate key for each API key the first time it is asked
+type generatedKeys struct {
+    algorithm string
+
+    mu   sync.Mutex
+    keys map[string]*crypto.KeyPair
+}
+
+func (g *generatedKeys) CandidateKey(apiKey string) (*crypto.KeyPair, bool) {
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    if kp, ok := g.keys[apiKey]; ok {
+        return kp, true
+    }
+    kp, err := crypto.GenerateSigningKeyPair(g.algorithm)
+    if err != nil {
+        return nil, false
+    }
+    g.keys[apiKey] = kp
+    return kp, true
+}
+
+// shadowRoutes reports how tokens fared against the candidate key set
+func shadowRoutes(mux *http.ServeMux, s *stores) {
+    mux.HandleFunc("GET /v1/shadow-verify", func(w http.ResponseWriter, r *http.Request) {
+        if s.shadow == nil {
+            http.Error(w, "shadow verification is off; set shadowVerify.algorithm", http.StatusNotFound)
+            return
+        }
+        writeJSON(w, http.StatusOK, s.shadow.Stats())
+    })
+}
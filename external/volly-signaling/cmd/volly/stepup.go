@@ -0,0 +1,158 @@
+package main
+
+import (
+    "errors"
+    "net/http"
+    "net/netip"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/audit"
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/stepup"
+)
+
+var errStepUpOff = errors.New("step-up authentication is not configured")
+
+type stepUpAuthorizeRequest struct {
+    Token   string `json:"token"`
+    Action  string `json:"action"`
+    Receipt string `json:"receipt,omitempty"`
+}
+
+type stepUpAuthorizeResponse struct {
+    Authorized bool   `json:"authorized"`
+    Identity   string `json:"identity"`
+    Room       string `json:"room"`
+}
+
+// stepUpRequired is the 401 body when an action needs a proof first
+type stepUpRequired struct {
+    Error     string            `json:"error"`
+    Action    string            `json:"action"`
+    Challenge *stepup.Challenge `json:"challenge"`
+}
+
+// newStepUpGuard is nil unless a WebAuthn relying party is configured
+func newStepUpGuard(cfg *config, s *stores) (*stepup.Guard, error) {
+    if s.passkeys == nil {
+        return nil, nil
+    }
+    key, err := sharedKey("VOLLY_STEPUP_KEY", cfg.StepUpKey)
+    if err != nil {
+        return nil, err
+    }
+    g := stepup.NewGuard(key, stepup.NewWebAuthn(s.passkeys))
+    if len(cfg.StepUp.Actions) > 0 {
+        g.SetActions(cfg.StepUp.Actions...)
+    }
+    if d := cfg.StepUp.ChallengeTTL.Duration; d > 0 {
+        g.SetChallengeTTL(d)
+    }
+    if d := cfg.StepUp.ReceiptTTL.Duration; d > 0 {
+        g.SetReceiptTTL(d)
+    }
+    return g, nil
+}
+
+// grantSubject is who a step-up for grant's session proves
+func grantSubject(grant *auth.VollyVideoGrant) stepup.Subject {
+    return stepup.Subject{Tenant: grant.Tenant, Identity: grant.Identity, Room: grant.Room, TokenID: grant.TokenID}
+}
+
+// stepUpAuthorize serves POST /v1/step-up/authorize, which the media edge calls before
+// honouring a sensitive action such as starting a recording. It answers 200 when the action
+// may go ahead and 401 with a challenge for the client when it needs a proof first. With
+// step-up off nothing is sensitive
+func stepUpAuthorize(cfg *config, s *stores, guard *stepup.Guard) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        var req stepUpAuthorizeRequest
+        if !readJSON(w, r, &req) {
+            return
+        }
+        if req.Action == "" {
+            http.Error(w, "action is required", http.StatusBadRequest)
+            return
+        }
+        grant, err := verifyToken(r.Context(), cfg, s, req.Token)
+        if err != nil {
+            // Asked by the edge, which does not pass on the client's address
+            tripwire(r.Context(), s, netip.Addr{}, err)
+            writeError(w, err)
+            return
+        }
+        if guard != nil {
+            err := guard.Authorize(grantSubject(grant), req.Action, req.Receipt)
+            if stepUpChallenge(w, req.Action, err) {
+                return
+            }
+            if err != nil {
+                writeError(w, err)
+                return
+            }
+        }
+        writeJSON(w, http.StatusOK, stepUpAuthorizeResponse{Authorized: true, Identity: grant.Identity, Room: grant.Room})
+    }
+}
+
+// stepUpChallenge answers 401 with the challenge when err is a *stepup.RequiredError,
+// reporting whether it was
+func stepUpChallenge(w http.ResponseWriter, action string, err error) bool {
+    var required *stepup.RequiredError
+    if !errors.As(err, &required) {
+        return false
+    }
+    writeJSON(w, http.StatusUnauthorized, stepUpRequired{
+        Error:     err.Error(),
+        Action:    action,
+        Challenge: required.Challenge,
+    })
+    return true
+}
+
+// stepUpVerify serves POST /v1/step-up/verify: the client presents its session token as a
+// bearer credential and its answer to a challenge, and gets a receipt for the action. The
+// proof is audited before the receipt is handed out
+func stepUpVerify(cfg *config, s *stores, guard *stepup.Guard) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if guard == nil {
+            writeError(w, errStepUpOff)
+            return
+        }
+        token := bearer(r)
+        if token == "" || auth.IsViewerToken(token) {
+            writeError(w, errBadCredentials)
+            return
+        }
+        var proof stepup.Proof
+        if !readJSON(w, r, &proof) {
+            return
+        }
+        grant, err := verifyRequest(r, cfg, s, token)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        receipt, err := guard.Verify(r.Context(), grantSubject(grant), proof)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+
+        // The method's detail goes first so it cannot mask what was proved and by whom
+        detail := make(map[string]string, len(receipt.Evidence.Detail)+5)
+        for k, v := range receipt.Evidence.Detail {
+            detail[k] = v
+        }
+        detail["action"] = receipt.Action
+        detail["method"] = receipt.Evidence.Method
+        detail["credential"] = receipt.Evidence.Credential
+        detail["session"] = grant.TokenID
+        detail["remoteAddr"] = r.RemoteAddr
+        e := audit.Entry{Actor: grant.Identity, Action: "stepup.verify", Tenant: grant.Tenant, Target: grant.Room, Detail: detail, Tags: grant.Tags}
+        if err := s.audit.Append(r.Context(), e); err != nil {
+            // A proof that cannot be audited does not count
+            writeError(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, receipt)
+    }
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+    "context"
+    "encoding/base64"
+    "errors"
+    "io"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/analytics"
+    "github.com/volly-org/volly-signaling/pkg/volly/anomaly"
+    "github.com/volly-org/volly-signaling/pkg/volly/audit"
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/cache"
+    "github.com/volly-org/volly-signaling/pkg/volly/canary"
+    "github.com/volly-org/volly-signaling/pkg/volly/configstore"
+    "github.com/volly-org/volly-signaling/pkg/volly/directory"
+    "github.com/volly-org/volly-signaling/pkg/volly/dpop"
+    "github.com/volly-org/volly-signaling/pkg/volly/envelope"
+    "github.com/volly-org/volly-signaling/pkg/volly/erasure"
+    "github.com/volly-org/volly-signaling/pkg/volly/events"
+    "github.com/volly-org/volly-signaling/pkg/volly/extension"
+    "github.com/volly-org/volly-signaling/pkg/volly/federation"
+    "github.com/volly-org/volly-signaling/pkg/volly/flags"
+    "github.com/volly-org/volly-signaling/pkg/volly/gateway"
+    "github.com/volly-org/volly-signaling/pkg/volly/handraise"
+    "github.com/volly-org/volly-signaling/pkg/volly/hsm"
+    "github.com/volly-org/volly-signaling/pkg/volly/keys"
+    "github.com/volly-org/volly-signaling/pkg/volly/killswitch"
+    "github.com/volly-org/volly-signaling/pkg/volly/lifecycle"
+    "github.com/volly-org/volly-signaling/pkg/volly/metering"
+    "github.com/volly-org/volly-signaling/pkg/volly/netpolicy"
+    "github.com/volly-org/volly-signaling/pkg/volly/presence"
+    "github.com/volly-org/volly-signaling/pkg/volly/protocol"
+    "github.com/volly-org/volly-signaling/pkg/volly/redact"
+    "github.com/volly-org/volly-signaling/pkg/volly/retention"
+    "github.com/volly-org/volly-signaling/pkg/volly/revocation"
+    "github.com/volly-org/volly-signaling/pkg/volly/rollout"
+    "github.com/volly-org/volly-signaling/pkg/volly/roompin"
+    "github.com/volly-org/volly-signaling/pkg/volly/secure"
+    "github.com/volly-org/volly-signaling/pkg/volly/sqlstore"
+    "github.com/volly-org/volly-signaling/pkg/volly/wasmplugin"
+    "github.com/volly-org/volly-signaling/pkg/volly/watch"
+    "github.com/volly-org/volly-signaling/pkg/volly/webauthn"
+)
+
+// auditLogSize bounds the in-memory audit log
+const auditLogSize = 10000
+
+type keyRegistry interface {
+    keys.Registry
+    keys.Lister
+}
+
+// cachedKeys serves Get from the cache; listing is an admin path and goes to the registry
+type cachedKeys struct {
+    *cache.KeyRegistry
+    keys.Lister
+}
+
+// stores is the state every service shares. In all-in-one mode one instance backs all
+// services; split deployments need the SQL backend so separate processes see the same state
+type stores struct {
+    config      configstore.Store
+    keys        keyRegistry
+    revocations *revocation.Revoker
+    revoked     revocation.Store
+    killSwitch  *killswitch.Switch
+    audit       audit.Log
+    bus         events.Bus
+
+    configCache *cache.ConfigStore
+    keyCache    *cache.KeyRegistry
+    grantCache  *cache.VerifiedGrantCache
+    verifyPool  *auth.VerifyPool
+    // claimSchemas are the tenant claims from cfg.TenantClaims, checked on issue and verify
+    claimSchemas *auth.ClaimSchemas
+    // cryptoProfiles are cfg.CryptoProfile and cfg.TenantCryptoProfiles
+    cryptoProfiles *auth.CryptoProfiles
+    // claimTransformers are cfg.ClaimTransforms, run on every verified grant
+    claimTransformers auth.ClaimTransformers
+    // wasmPlugins are cfg.WASMPlugins, also among claimTransformers
+    wasmPlugins []*wasmplugin.File
+    // rooms runs each room's admissions in order on its own goroutine
+    rooms *gateway.RoomActors
+    // watcher fans room events out to WatchRoom streams
+    watcher *watch.Watcher
+    // connections holds a lease for every admitted gateway connection
+    connections *gateway.ConnectionLimiter
+    // sessionLimits is set when cfg.Sessions caps an identity's connections, each counted in
+    // presence under its lease ID. Like leases, they live on the instance that admitted them
+    sessionLimits *presence.SessionLimiter
+    presence      presence.Store
+    // geo enforces the deniedCIDRs and allowedCountries claims; always set
+    geo *gateway.GeoRestriction
+    // hsmKeys sign and verify the tokens of the API keys in cfg.HSM.Keys
+    hsmKeys map[string]*hsm.Signer
+    // revocationFilter is set with the SQL backend, where every lookup would be a query,
+    // unless an extension plugin stores revocations
+    revocationFilter *revocation.FilteredStore
+    // canary is set when cfg.Canary.Interval is
+    canary *canary.Canary
+    // analytics is set when cfg.Analytics.Window is
+    analytics *analytics.Collector
+    // clockSkews collects the skew of client clocks seen in gateway handshakes
+    clockSkews *protocol.ClockSkews
+    // passkeys is set when cfg.WebAuthn.RPID is; its credentials live in webauthn
+    passkeys *webauthn.RelyingParty
+    webauthn webauthn.Store
+    // directory manages the tenants in cfg.Directory; ldapSyncs poll those with LDAP
+    directory      *directory.Directory
+    directoryStore directory.Store
+    ldapSyncs      []*directory.LDAPSync
+    // eraser is set when VOLLY_ERASURE_KEY is
+    eraser *erasure.Eraser
+    // retention is set when cfg.Retention gives a store a window
+    retention *retention.Job
+    // usage is set when VOLLY_USAGE_SIGNING_KEY is
+    usage *metering.Meter
+    // anchorer is set when VOLLY_AUDIT_SIGNING_KEY is; auditAnchorStore with the SQL backend
+    anchorer         *audit.Anchorer
+    auditAnchorStore audit.AnchorStore
+    // anomaly is set when cfg.Anomaly names a detector
+    anomaly *anomaly.Monitor
+    // blocklist refuses addresses that presented a decoy, for blockFor; always set
+    blocklist *netpolicy.Blocklist
+    blockFor  time.Duration
+    // pinHasher and pinGate are set when VOLLY_PIN_KEY is; pinAttempts always is
+    pinHasher   *roompin.Hasher
+    pinGate     *roompin.Gate
+    pinAttempts roompin.AttemptStore
+    // attestation is set when cfg.Extensions.AttestationVerifier is
+    attestation extension.AttestationVerifier
+    // hands holds the hands raised on this instance and the sessions decisions are pushed to
+    hands *handraise.Desk
+    // expiry applies grants' expiry policies to their connections, and sessions are the
+    // signaling sessions open here that it warns
+    expiry   *gateway.ExpiryEnforcer
+    sessions *signalingSessions
+    // descriptor is set when cfg.Federation describes this deployment, and federation when
+    // it names peers
+    descriptor *federation.Descriptor
+    federation *federation.Peers
+    // dpop checks the DPoP proofs sent to tokend and admin; always set
+    dpop *dpop.Validator
+    // keyProofs issues the challenges holders of key-bound tokens sign to join, and checks
+    // their answers; always set. Challenges live on the instance that issued them
+    keyProofs *gateway.ProofOfPossession
+    // roomHooks runs the pre-create, pre-join and post-leave room hooks; always set
+    roomHooks *lifecycle.Hooks
+    // shadow is set when cfg.ShadowVerify.Algorithm is
+    shadow *auth.Shadow
+    // flags answers feature flags; always set, with defaults only without cfg.Flags.OFREP
+    flags *flags.Flags
+    // rollout ramps token features by identity; always set
+    rollout *rollout.Rollout
+    // envelope rotates and reseals under sealer; both are set when cfg.Database.Envelope.KMS is
+    envelope *envelope.ResealJob
+    sealer   *envelope.Sealer
+    // bundle is set when cfg.Bundle.Path is, and then owns tenants, API keys and room policies
+    bundle *configBundle
+    // redactor applies cfg.Redaction to what is handed to third parties; always set
+    redactor *redact.Redactor
+
+    closers []io.Closer
+}
+
+func newStores(ctx context.Context, cfg *config) (*stores, error) {
+    bus := events.NewMemoryBus()
+    s := &stores{
+        killSwitch: killswitch.NewSwitch(killswitch.NewMemoryStore(), bus).SetSyncInterval(cfg.SyncInterval.Duration),
+        bus:        bus,
+        clockSkews: protocol.NewClockSkews(),
+    }
+
+    if cfg.Database.DSN == "" {
+        registry := keys.NewMemoryRegistry()
+        s.config = configstore.NewMemoryStore()
+        s.keys = registry
+        s.revoked = revocation.NewMemoryStore()
+        s.audit = audit.NewMemoryLog(auditLogSize)
+        s.webauthn = webauthn.NewMemoryStore()
+        s.directoryStore = directory.NewMemoryStore()
+        s.pinAttempts = roompin.NewMemoryAttemptStore()
+        s.closers = append(s.closers, registry)
+    } else {
+        if err := s.openSQL(ctx, cfg); err != nil {
+            return nil, err
+        }
+        if s.envelope != nil {
+            go s.envelope.Run(ctx)
+        }
+    }
+    if err := s.openExtensions(cfg); err != nil {
+        return nil, err
+    }
+    if err := s.openHSM(cfg); err != nil {
+        return nil, err
+    }
+    if cfg.Database.DSN != "" && cfg.Extensions.RevocationStore == nil {
+        s.revocationFilter = revocation.NewFilteredStore(s.revoked, bus).SetRebuildInterval(cfg.SyncInterval.Duration)
+        s.revoked = s.revocationFilter
+        go s.revocationFilter.Run(ctx)
+    }
+    s.revocations = revocation.NewRevoker(s.revoked).SetEventBus(bus)
+    s.blockFor = decoyBlockFor(cfg)
+    s.blocklist = newBlocklist(s.blockFor, bus)
+    dir, err := newDirectory(ctx, cfg, s.directoryStore, s)
+    if err != nil {
+        return nil, err
+    }
+    s.directory = dir
+    if s.claimSchemas, err = cfg.claimSchemas(); err != nil {
+        return nil, err
+    }
+    if s.cryptoProfiles, err = cfg.cryptoProfiles(); err != nil {
+        return nil, err
+    }
+    if s.claimTransformers, err = cfg.claimTransformers(cfg.roles()); err != nil {
+        return nil, err
+    }
+    if s.wasmPlugins, err = newWASMPlugins(ctx, cfg); err != nil {
+        return nil, err
+    }
+    for _, p := range s.wasmPlugins {
+        s.claimTransformers = append(s.claimTransformers, p)
+    }
+    s.grantCache = cache.NewVerifiedGrantCache(bus, cache.DefaultGrantTTL)
+    if cfg.Database.DSN != "" || cfg.Extensions.RevocationStore != nil {
+        // Revocations and key changes made on other instances never reach this bus
+        s.grantCache.SetRecheck(func(token string, grant *auth.VollyVideoGrant) error {
+            return recheckGrant(ctx, s, token, grant)
+        })
+    }
+    s.verifyPool = auth.NewVerifyPool(cfg.VerifyWorkers)
+    s.rooms = gateway.NewRoomActors()
+    s.watcher = watch.NewWatcher(bus)
+    s.hands = newHandDesk(cfg, s)
+    s.sessions = newSignalingSessions()
+    s.expiry = newExpiryEnforcer(cfg, s)
+    s.connections = gateway.NewConnectionLimiter(cfg.Connections.ConnectionLimits).
+        SetQueue(cfg.Connections.MaxQueue, cfg.Connections.QueueTimeout.Duration).
+        SetLeaseTTL(cfg.Connections.LeaseTTL.Duration).
+        SetOnExpire(func(lease gateway.Lease) { forgetConnection(ctx, s, lease.ID) })
+    for tenant, max := range cfg.Connections.TenantLimits {
+        s.connections.SetTenantLimit(tenant, max)
+    }
+    s.sessionLimits, s.presence = newSessionLimits(cfg, s)
+    if s.geo, err = newGeoRestriction(cfg); err != nil {
+        return nil, err
+    }
+    go s.connections.Run(ctx)
+    go s.expiry.Run(ctx)
+    go s.killSwitch.Run(ctx)
+
+    // Every verification looks up its API key, tenant and often a PQ key
+    s.configCache = cache.NewConfigStore(s.config, bus, cache.DefaultTTLs)
+    s.keyCache = cache.NewKeyRegistry(s.keys, bus, cache.DefaultTTLs)
+    s.config = s.configCache
+    s.keys = cachedKeys{KeyRegistry: s.keyCache, Lister: s.keys}
+
+    if b := cfg.Bootstrap; b.APIKey != "" {
+        if err := s.config.PutTenant(ctx, configstore.Tenant{ID: b.Tenant}); err != nil {
+            return nil, err
+        }
+        secret, err := secure.FromString(b.APISecret)
+        if err != nil {
+            return nil, err
+        }
+        if err := s.config.PutAPIKey(ctx, configstore.APIKey{ID: b.APIKey, Tenant: b.Tenant, Secret: secret}); err != nil {
+            return nil, err
+        }
+    }
+
+    if s.bundle, err = newConfigBundle(ctx, cfg, s); err != nil {
+        return nil, err
+    }
+
+    if w := cfg.WebAuthn; w.RPID != "" {
+        key, err := sharedKey("VOLLY_WEBAUTHN_KEY", cfg.WebAuthnKey)
+        if err != nil {
+            return nil, err
+        }
+        s.passkeys = webauthn.New(w.RPID, w.RPName, w.Origins, s.webauthn, key).
+            SetUserVerification(!w.SkipUserVerification)
+        if w.Timeout.Duration > 0 {
+            s.passkeys.SetTimeout(w.Timeout.Duration)
+        }
+    }
+
+    if cfg.Canary.Interval.Duration > 0 {
+        s.canary = newCanary(cfg, s)
+        go s.canary.Run(ctx)
+    }
+    if s.eraser, err = newEraser(cfg, s); err != nil {
+        return nil, err
+    }
+    key, err := sharedKey("VOLLY_REDACTION_KEY", cfg.RedactionKey)
+    if err != nil {
+        return nil, err
+    }
+    if s.redactor, err = redact.New(cfg.Redaction, key); err != nil {
+        return nil, err
+    }
+    if s.usage, err = newUsage(cfg); err != nil {
+        return nil, err
+    }
+    if s.usage != nil {
+        go s.usage.Run(ctx)
+    }
+    if s.anchorer, err = newAnchorer(cfg, s); err != nil {
+        return nil, err
+    }
+    if s.anchorer != nil {
+        go s.anchorer.Run(ctx)
+    }
+    if s.pinHasher, s.pinGate, err = newPINGate(cfg, s); err != nil {
+        return nil, err
+    }
+    if s.roomHooks, err = newRoomHooks(cfg, s); err != nil {
+        return nil, err
+    }
+    go s.roomHooks.Run(ctx)
+    if s.descriptor, err = ownDescriptor(cfg); err != nil {
+        return nil, err
+    }
+    if s.federation, err = newFederation(ctx, cfg); err != nil {
+        return nil, err
+    }
+    if s.anomaly, err = newAnomaly(cfg, s); err != nil {
+        return nil, err
+    }
+    s.flags = newFlags(cfg)
+    s.dpop = newDPoP(cfg)
+    s.keyProofs = gateway.NewProofOfPossession(0)
+    if s.rollout, err = newRollout(cfg); err != nil {
+        return nil, err
+    }
+    if s.shadow, err = newShadow(cfg); err != nil {
+        return nil, err
+    }
+    if s.retention, err = newRetention(cfg, s); err != nil {
+        return nil, err
+    }
+    if s.retention != nil {
+        go s.retention.Run(ctx)
+    }
+    if a := cfg.Analytics; a.Window.Duration > 0 {
+        s.analytics = analytics.NewCollector().SetWindow(a.Window.Duration).SetNoise(a.Epsilon)
+        if a.KAnonymity > 0 {
+            s.analytics.SetK(a.KAnonymity)
+        }
+        if a.Retention > 0 {
+            s.analytics.SetRetention(a.Retention)
+        }
+        go s.analytics.Run(ctx)
+    }
+    return s, nil
+}
+
+// openSQL connects, migrates and builds the SQL-backed stores; the driver named by the
+// config must be compiled in, see drivers_*.go
+func (s *stores) openSQL(ctx context.Context, cfg *config) error {
+    d := cfg.Database
+    // With a Vault KMS the seal key only opens secrets sealed before envelopes
+    var sealKey *secure.SecureBytes
+    if d.SealKey == "" && d.Envelope.KMS != "vault" {
+        return errors.New("VOLLY_SEAL_KEY must be set with a SQL database")
+    }
+    if d.SealKey != "" {
+        raw, err := base64.StdEncoding.DecodeString(d.SealKey)
+        if err != nil || len(raw) != 32 {
+            return sqlstore.ErrNoSealKey
+        }
+        if sealKey, err = secure.FromBytes(raw); err != nil {
+            return err
+        }
+    }
+
+    db, err := sqlstore.Open(ctx, sqlstore.Dialect(d.Dialect), d.DSN, sqlstore.Options{
+        Driver:          d.Driver,
+        MaxOpenConns:    d.MaxOpenConns,
+        MaxIdleConns:    d.MaxIdleConns,
+        ConnMaxLifetime: d.ConnMaxLifetime.Duration,
+    })
+    if err != nil {
+        if sealKey != nil {
+            sealKey.Close()
+        }
+        return err
+    }
+    s.closers = append(s.closers, db)
+    if sealKey != nil {
+        s.closers = append(s.closers, sealKey)
+    }
+    if d.Migrate {
+        if err := db.Migrate(ctx); err != nil {
+            return err
+        }
+    }
+
+    configStore := sqlstore.NewConfigStore(db, sealKey)
+    registry := sqlstore.NewKeyRegistry(db)
+    if s.envelope, err = newEnvelope(ctx, cfg, s, db, configStore, registry); err != nil {
+        return err
+    }
+    s.config = configStore
+    s.keys = registry
+    s.revoked = sqlstore.NewRevocationStore(db)
+    s.audit = sqlstore.NewAuditLog(db)
+    s.auditAnchorStore = sqlstore.NewAuditAnchorStore(db)
+    s.webauthn = sqlstore.NewWebAuthnStore(db)
+    s.directoryStore = sqlstore.NewDirectoryStore(db)
+    s.pinAttempts = sqlstore.NewPINAttemptStore(db)
+    return nil
+}
+
+// Close zeroizes held secrets, closes the database and detaches from the bus
+func (s *stores) Close() {
+    s.killSwitch.Close()
+    s.configCache.Close()
+    s.keyCache.Close()
+    s.grantCache.Close()
+    s.verifyPool.Close()
+    s.rooms.Close()
+    s.watcher.Close()
+    if s.revocationFilter != nil {
+        s.revocationFilter.Close()
+    }
+    for _, c := range s.closers {
+        c.Close()
+    }
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+    "context"
+    "crypto/subtle"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "time"
+
+    lkauth "github.com/livekit/protocol/auth"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/configstore"
+    "github.com/volly-org/volly-signaling/pkg/volly/issuance"
+)
+
+type tokenRequest struct {
+    Identity string `json:"identity"`
+    Room     string `json:"room"`
+    TTL      string `json:"ttl,omitempty"`
+    // Role names a grant template; the permission fields below narrow or widen it
+    Role           string `json:"role,omitempty"`
+    CanPublish     *bool  `json:"canPublish,omitempty"`
+    CanSubscribe   *bool  `json:"canSubscribe,omitempty"`
+    CanPublishData *bool  `json:"canPublishData,omitempty"`
+    PQPublicKey    []byte `json:"pqPublicKey,omitempty"`
+    PQAlgorithm    string `json:"pqAlgorithm,omitempty"`
+    // Claims are the tenant's own claims, named without their volly.<tenant>. namespace
+    Claims map[string]interface{} `json:"claims,omitempty"`
+    // Tags attribute the token's usage, e.g. {"cost-center": "eng", "project": "atlas"}
+    Tags map[string]string `json:"tags,omitempty"`
+    // Provider is how the tenant's backend authenticated the user, e.g. oidc or guest;
+    // api-key when unset
+    Provider string `json:"provider,omitempty"`
+    // PIN makes the gateway ask the holder for it before admitting them; only its hash is
+    // put in the token
+    PIN string `json:"pin,omitempty"`
+    // EndTime ends the grant before the token expires, e.g. when a booked session is over
+    EndTime *time.Time `json:"endTime,omitempty"`
+    // OnExpiry is what the gateway does when the grant runs out mid-session; disconnect by default
+    OnExpiry *auth.ExpiryPolicy `json:"onExpiry,omitempty"`
+    // Attestation is the client's device or app attestation, for the attestation plugin
+    Attestation *attestationEvidence `json:"attestation,omitempty"`
+}
+
+type attestationEvidence struct {
+    Format   string `json:"format"`
+    Evidence []byte `json:"evidence"`
+}
+
+type viewerTokenRequest struct {
+    Identity string `json:"identity,omitempty"`
+    Room     string `json:"room"`
+    TTL      string `json:"ttl,omitempty"`
+}
+
+type tokenResponse struct {
+    Token     string    `json:"token"`
+    ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// newTokend serves POST /v1/token and POST /v1/viewer-token, authenticated with HTTP basic auth as apiKey:apiSecret
+// or, for /v1/token, with a client certificate or SVID cfg.Issuance maps,
+// POST /v1/elevate, authenticated with the session token being elevated, POST /v1/on-behalf-of,
+// authenticated with the token of the service acting for a user, and the passkey
+// registration and login endpoints under /v1/webauthn, the SAML service provider under /v1/saml,
+// the Matrix bridge at POST /v1/matrix/token and SCIM provisioning under /scim/v2
+func newTokend(cfg *config, s *stores) (http.Handler, error) {
+    var canonical *auth.CanonicalIssuer
+    if cfg.CanonicalWindow.Duration > 0 {
+        canonical = auth.NewCanonicalIssuer(cfg.CanonicalWindow.Duration)
+    }
+    roles := cfg.roles()
+    issuers := newIssuers(cfg)
+    mux := http.NewServeMux()
+    mux.HandleFunc("POST /v1/token", func(w http.ResponseWriter, r *http.Request) {
+        key, principal, ok := tokenCaller(w, r, cfg, s, issuers)
+        if !ok {
+            return
+        }
+        var req tokenRequest
+        if !readJSON(w, r, &req) {
+            return
+        }
+        if principal != nil && !principal.Allows(roomScope(req.Room)) {
+            writeError(w, issuance.ErrScopeDenied)
+            return
+        }
+        ttl, ok := requestTTL(w, cfg, req.TTL)
+        if !ok {
+            return
+        }
+        provider := req.Provider
+        if provider == "" {
+            provider = auth.ProviderAPIKey
+        }
+        if !auth.KnownProvider(provider) {
+            writeError(w, fmt.Errorf("%w: %q", auth.ErrUnknownProvider, provider))
+            return
+        }
+        if err := checkAttestation(r.Context(), cfg, s, key.Tenant, req); err != nil {
+            writeError(w, err)
+            return
+        }
+
+        // In a directory-managed tenant the identity must be provisioned and the role granted
+        grantedRoles, err := s.directory.Authorize(r.Context(), key.Tenant, req.Identity, req.Role)
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+
+        grant := &auth.VollyVideoGrant{VideoGrant: lkauth.VideoGrant{RoomJoin: true, Room: req.Room}}
+        at := accessToken(s, key).
+            AddGrant(grant).
+            SetIdentity(req.Identity).
+            SetTenant(key.Tenant).
+            SetProvenance(tokendProvenance(provider)).
+            SetRoles(roles).
+            SetClaimSchemas(s.claimSchemas).
+            SetCryptoProfiles(s.cryptoProfiles)
+        for _, role := range grantedRoles {
+            at.AddRole(role)
+        }
+        for name, value := range req.Claims {
+            at.SetClaim(name, value)
+        }
+        at.SetTags(req.Tags)
+        // Explicit permissions win over the role's
+        if req.CanPublish != nil {
+            grant.CanPublish = req.CanPublish
+        }
+        if req.CanSubscribe != nil {
+            grant.CanSubscribe = req.CanSubscribe
+        }
+        if req.CanPublishData != nil {
+            grant.CanPublishData = req.CanPublishData
+        }
+        at.SetValidFor(ttl).
+            SetExpiryJitter(cfg.ExpiryJitter.Duration).
+            SetNotBeforeGrace(cfg.NotBeforeGrace.Duration).
+            SetIssuanceCheck(s.killSwitch.CheckGrant).
+            SetSizeBudget(cfg.TokenBudget, func(size auth.TokenSize) {
+                log.Printf("tokend: token for %s under key %s is %s, over the %d byte warning", s.redactor.Identity(req.Identity), key.ID, size, cfg.TokenBudget.WarnBytes)
+            })
+        scopeToken(cfg, at, key.Tenant)
+        if len(req.PQPublicKey) > 0 {
+            at.SetPostQuantumKey(req.PQPublicKey, req.PQAlgorithm)
+        }
+        if req.PIN != "" {
+            if s.pinHasher == nil {
+                writeError(w, errPINOff)
+                return
+            }
+            hash, err := s.pinHasher.Hash(key.Tenant, req.PIN)
+            if err != nil {
+                writeError(w, err)
+                return
+            }
+            at.SetPINHash(hash)
+        }
+        if req.EndTime != nil {
+            at.SetEndTime(*req.EndTime)
+        }
+        if req.OnExpiry != nil {
+            at.SetExpiryPolicy(req.OnExpiry)
+        }
+        confirmDPoP(r, at)
+        if canonical == nil || ttl <= canonical.Window() || !s.rollout.Enabled(featureCanonical, req.Identity) {
+            token, err := at.ToJWT()
+            if err != nil {
+                s.rollout.Observe(req.Identity, rolloutIssueFailed)
+                writeError(w, err)
+                return
+            }
+            s.rollout.Observe(req.Identity, rolloutIssued)
+            tokenIssued(r.Context(), s, key, req.Identity, req.Tags)
+            writeJSON(w, http.StatusOK, tokenResponse{Token: token, ExpiresAt: at.ExpiresAt()})
+            return
+        }
+
+        token, expiresAt, err := canonical.Issue(at)
+        if err != nil {
+            s.rollout.Observe(req.Identity, rolloutIssueFailed)
+            writeError(w, err)
+            return
+        }
+        s.rollout.Observe(req.Identity, rolloutIssued)
+        // The response is identical until the window closes, so edges may serve it until then
+        maxAge := time.Until(canonical.WindowEnd(time.Now())) / time.Second
+        w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(int(maxAge)))
+        w.Header().Set("Vary", "Authorization")
+        tokenIssued(r.Context(), s, key, req.Identity, req.Tags)
+        writeJSON(w, http.StatusOK, tokenResponse{Token: token, ExpiresAt: expiresAt})
+    })
+    mux.HandleFunc("POST /v1/elevate", elevate(cfg, s, newElevator(cfg)))
+    mux.HandleFunc("POST /v1/on-behalf-of", onBehalfOf(cfg, s, issuers))
+    mountPasskeys(mux, cfg, s, roles)
+    mountSCIM(mux, s)
+    if err := mountSAML(mux, cfg, s, roles); err != nil {
+        return nil, err
+    }
+    if err := mountMatrix(mux, cfg, s, roles); err != nil {
+        return nil, err
+    }
+    mux.HandleFunc("POST /v1/viewer-token", func(w http.ResponseWriter, r *http.Request) {
+        key, ok := basicAuthKey(w, r, s)
+        if !ok {
+            return
+        }
+        var req viewerTokenRequest
+        if !readJSON(w, r, &req) {
+            return
+        }
+        if !viewerTokensOn(r.Context(), cfg, s, key.Tenant, req.Room, req.Identity) {
+            writeError(w, auth.ErrViewerTokensOff)
+            return
+        }
+        if _, ok := s.hsmKeys[key.ID]; ok {
+            writeError(w, errHSMViewerTokens)
+            return
+        }
+        ttl, ok := requestTTL(w, cfg, req.TTL)
+        if !ok {
+            return
+        }
+        vt := auth.NewViewerTokenWithSecret(key.ID, key.Secret).
+            SetRoom(req.Room).
+            SetIdentity(req.Identity).
+            SetTenant(key.Tenant).
+            SetIssuer(cfg.Issuer).
+            SetAudience(cfg.audience(key.Tenant)).
+            SetValidFor(ttl).
+            SetExpiryJitter(cfg.ExpiryJitter.Duration).
+            SetIssuanceCheck(s.killSwitch.CheckGrant).
+            SetCryptoProfiles(s.cryptoProfiles)
+        token, err := vt.Sign()
+        if err != nil {
+            writeError(w, err)
+            return
+        }
+        tokenIssued(r.Context(), s, key, req.Identity, nil)
+        writeJSON(w, http.StatusOK, tokenResponse{Token: token, ExpiresAt: vt.ExpiresAt()})
+    })
+    return s.blocklist.Middleware(remoteAddr, tokendDPoP(cfg, s, mux)), nil
+}
+
+// basicAuthKey authenticates the caller as apiKey:apiSecret, writing the error response on failure
+func basicAuthKey(w http.ResponseWriter, r *http.Request, s *stores) (configstore.APIKey, bool) {
+    id, secret, ok := r.BasicAuth()
+    if !ok {
+        w.Header().Set("WWW-Authenticate", `Basic realm="volly"`)
+        writeError(w, errBadCredentials)
+        return configstore.APIKey{}, false
+    }
+    key, err := checkKey(r.Context(), s, id, secret)
+    if err != nil {
+        writeError(w, err)
+        return configstore.APIKey{}, false
+    }
+    return key, true
+}
+
+// checkKey returns the active API key id if secret is its secret
+func checkKey(ctx context.Context, s *stores, id, secret string) (configstore.APIKey, error) {
+    key, err := activeKey(ctx, s, id)
+    if err != nil {
+        return configstore.APIKey{}, err
+    }
+    if err := key.Secret.Use(func(b []byte) error {
+        if subtle.ConstantTimeCompare(b, []byte(secret)) != 1 {
+            return errBadCredentials
+        }
+        return nil
+    }); err != nil {
+        return configstore.APIKey{}, err
+    }
+    return key, nil
+}
+
+// requestTTL caps a requested ttl at MaxTokenTTL, which is also the default
+func requestTTL(w http.ResponseWriter, cfg *config, requested string) (time.Duration, bool) {
+    ttl := cfg.MaxTokenTTL.Duration
+    if requested == "" {
+        return ttl, true
+    }
+    d, err := time.ParseDuration(requested)
+    if err != nil || d <= 0 {
+        http.Error(w, "invalid ttl", http.StatusBadRequest)
+        return 0, false
+    }
+    if d < ttl {
+        ttl = d
+    }
+    return ttl, true
+}
+
+// provenanceService names tokend in the provenance of the tokens it mints
+const provenanceService = "volly-tokend"
+
+// tokendProvenance is the provenance of a token tokend mints for a user provider authenticated
+func tokendProvenance(provider string) *auth.Provenance {
+    return &auth.Provenance{Service: provenanceService, Version: version, Provider: provider}
+}
+
+// scopeToken stamps the deployment's issuer and the tenant's audience, when configured
+func scopeToken(cfg *config, at *auth.VollyAccessToken, tenant string) *auth.VollyAccessToken {
+    if cfg.Issuer != "" {
+        at.SetIssuer(cfg.Issuer)
+    }
+    if aud := cfg.audience(tenant); aud != "" {
+        at.SetAudience(aud)
+    }
+    return at
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "crypto/ed25519"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/bundle"
+    "github.com/volly-org/volly-signaling/pkg/volly/configstore"
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+    "github.com/volly-org/volly-signaling/pkg/volly/dpop"
+    "github.com/volly-org/volly-signaling/pkg/volly/secure"
+    "github.com/volly-org/volly-signaling/pkg/volly/shamir"
+    "github.com/volly-org/volly-signaling/pkg/volly/snapshot"
+)
+
+// serviceKeys are the 32-byte secrets a deployment reads from the environment; the
+// ceremony generates them so no process falls back to a key of its own
+var serviceKeys = []string{
+    "VOLLY_ADMIN_TOKEN", "VOLLY_SNAPSHOT_KEY", "VOLLY_TICKET_KEY", "VOLLY_STEPUP_KEY",
+    "VOLLY_WEBAUTHN_KEY", "VOLLY_SAML_KEY", "VOLLY_ERASURE_KEY", "VOLLY_REDACTION_KEY", "VOLLY_SEAL_KEY",
+}
+
+// rootKey is a key the ceremony generates and splits among the custodians
+type rootKey struct {
+    name      string
+    algorithm string
+    jwk       dpop.JWK
+    kid       string
+    public    []byte
+    // secret is what is split: the ML-DSA private key, or the Ed25519 seed
+    secret []byte
+    // check reports whether a recombined secret is this key
+    check func(secret []byte) bool
+}
+
+// keyShare is one custodian's share of one root key
+type keyShare struct {
+    Key       string `json:"key"`
+    Algorithm string `json:"algorithm"`
+    KeyID     string `json:"kid"`
+    PublicKey []byte `json:"publicKey"`
+    shamir.Share
+}
+
+// shareFile is what a custodian takes away; any Threshold of the Shares files recover the keys
+type shareFile struct {
+    Custodian string     `json:"custodian"`
+    Index     int        `json:"index"`
+    Threshold int        `json:"threshold"`
+    Shares    int        `json:"shares"`
+    CreatedAt time.Time  `json:"createdAt"`
+    Keys      []keyShare `json:"keys"`
+}
+
+type jwk struct {
+    dpop.JWK
+    Kid string `json:"kid"`
+    Use string `json:"use"`
+}
+
+// ceremony generates a new deployment's root keys: the ML-DSA operator key that signs
+// config bundles and the Ed25519 key that signs usage reports. Their private halves are
+// split into Shamir shares, one file per custodian, and the operator key is not written
+// anywhere else. Alongside the shares it writes the JWKS of the public keys, the first
+// config bundle, the service secrets and a hash-chained transcript of every step
+func ceremony(args []string) error {
+    flags := flag.NewFlagSet("ceremony", flag.ExitOnError)
+    out := flags.String("out", "ceremony-"+time.Now().UTC().Format("20060102"), "directory to create for the outputs")
+    shares := flags.Int("shares", 5, "custodians to split the root keys among")
+    threshold := flags.Int("threshold", 3, "shares needed to recover the root keys")
+    custodianList := flags.String("custodians", "", "comma-separated custodian names; asked for when empty")
+    tenant := flags.String("tenant", "default", "tenant in the first config bundle")
+    apiKey := flags.String("api-key", "root", "API key in the first config bundle")
+    operator := flags.String("operator", envOr("USER", "unknown"), "who runs the ceremony, for the transcript")
+    yes := flags.Bool("yes", false, "do not stop for confirmation between steps")
+    flags.Parse(args)
+
+    if *threshold < 2 || *threshold > *shares || *shares > shamir.MaxShares {
+        return shamir.ErrInvalidThreshold
+    }
+    p := &prompter{in: bufio.NewReader(os.Stdin), skip: *yes}
+    custodians, err := p.custodians(*custodianList, *shares)
+    if err != nil {
+        return err
+    }
+    fmt.Fprintf(os.Stderr, "Generating root keys for tenant %q into %s, split %d of %d among %s\n",
+        *tenant, *out, *threshold, *shares, strings.Join(custodians, ", "))
+    if err := p.confirm("Proceed?"); err != nil {
+        return err
+    }
+    // Mkdir, not MkdirAll: a ceremony never writes into an earlier one's outputs
+    if err := os.Mkdir(*out, 0o700); err != nil {
+        return err
+    }
+    t, err := newTranscript(filepath.Join(*out, "transcript.jsonl"), *operator)
+    if err != nil {
+        return err
+    }
+    defer t.close()
+    t.log("ceremony.start", map[string]string{
+        "shares": fmt.Sprint(*shares), "threshold": fmt.Sprint(*threshold),
+        "custodians": strings.Join(custodians, ","), "tenant": *tenant, "apiKey": *apiKey,
+    })
+
+    keys, err := generateRootKeys()
+    if err != nil {
+        return err
+    }
+    defer func() {
+        for _, k := range keys {
+            secure.Wipe(k.secret)
+        }
+    }()
+    for _, k := range keys {
+        t.log("key.generated", map[string]string{"key": k.name, "algorithm": k.algorithm, "kid": k.kid})
+    }
+
+    files, err := splitRootKeys(keys, custodians, *threshold)
+    if err != nil {
+        return err
+    }
+    t.log("shares.verified", map[string]string{"subsets": "first and last " + fmt.Sprint(*threshold)})
+    for i, f := range files {
+        name := fmt.Sprintf("share-%d-%s.json", f.Index, fileSafe(f.Custodian))
+        sum, err := writeJSON(filepath.Join(*out, name), f, 0o600)
+        for _, ks := range f.Keys {
+            secure.Wipe(ks.Value)
+        }
+        if err != nil {
+            return err
+        }
+        t.log("share.written", map[string]string{"custodian": f.Custodian, "file": name, "sha256": sum})
+        fmt.Fprintf(os.Stderr, "Share %d of %d is %s\n", i+1, len(files), filepath.Join(*out, name))
+        if err := p.pause(fmt.Sprintf("Hand it to %s, remove it from this machine, then press Enter", f.Custodian)); err != nil {
+            return err
+        }
+    }
+
+    jwks := struct {
+        Keys []jwk `json:"keys"`
+    }{}
+    for _, k := range keys {
+        jwks.Keys = append(jwks.Keys, jwk{JWK: k.jwk, Kid: k.kid, Use: "sig"})
+    }
+    sum, err := writeJSON(filepath.Join(*out, "jwks.json"), jwks, 0o644)
+    if err != nil {
+        return err
+    }
+    t.log("jwks.written", map[string]string{"file": "jwks.json", "sha256": sum})
+
+    env, err := serviceEnv(keys[1].secret)
+    if err != nil {
+        return err
+    }
+    sum, err = writeFile(filepath.Join(*out, "volly.env"), env, 0o600)
+    secure.Wipe(env)
+    if err != nil {
+        return err
+    }
+    t.log("env.written", map[string]string{"file": "volly.env", "sha256": sum,
+        "variables": strings.Join(append([]string{"VOLLY_USAGE_SIGNING_KEY"}, serviceKeys...), ",")})
+
+    apiSecret := make([]byte, 32)
+    if _, err := rand.Read(apiSecret); err != nil {
+        return err
+    }
+    contents := bundle.Contents{
+        Version: 1,
+        Tenants: []configstore.Tenant{{ID: *tenant}},
+        // The secret is the base64 text clients are given, as for VOLLY_API_SECRET
+        APIKeys: []snapshot.APIKey{{ID: *apiKey, Tenant: *tenant, Secret: []byte(base64.RawURLEncoding.EncodeToString(apiSecret))}},
+    }
+    secure.Wipe(apiSecret)
+    signed, err := bundle.Sign(contents, crypto.NewSigner(&crypto.KeyPair{Algorithm: keys[0].algorithm, PublicKey: keys[0].public, PrivateKey: keys[0].secret}))
+    secure.Wipe(contents.APIKeys[0].Secret)
+    if err != nil {
+        return err
+    }
+    if sum, err = writeFile(filepath.Join(*out, "bundle.json"), signed, 0o600); err != nil {
+        return err
+    }
+    t.log("bundle.written", map[string]string{"file": "bundle.json", "sha256": sum, "version": "1"})
+
+    cfg := map[string]interface{}{"bundle": map[string]interface{}{
+        "path": "bundle.json", "algorithm": keys[0].algorithm, "publicKey": keys[0].public,
+    }}
+    if sum, err = writeJSON(filepath.Join(*out, "volly.json"), cfg, 0o644); err != nil {
+        return err
+    }
+    t.log("config.written", map[string]string{"file": "volly.json", "sha256": sum})
+
+    head := t.log("ceremony.complete", nil)
+    if t.err != nil {
+        return t.err
+    }
+    fmt.Fprintf(os.Stderr, "\nCeremony complete. Record the transcript head in the ceremony minutes:\n  %s\n", head)
+    fmt.Fprintf(os.Stderr, "Deploy volly.env through the secret store and delete it here; bundle.json holds the\n"+
+        "API secret for %s; publish jwks.json\n", *apiKey)
+    return nil
+}
+
+// generateRootKeys returns the bundle signing key, then the usage signing key
+func generateRootKeys() ([]*rootKey, error) {
+    op, err := crypto.GenerateSigningKeyPair(crypto.AlgorithmMLDSA65)
+    if err != nil {
+        return nil, err
+    }
+    bundleKey := &rootKey{
+        name:      "bundle",
+        algorithm: op.Algorithm,
+        jwk:       dpop.JWK{Kty: "AKP", Alg: op.Algorithm, Pub: base64.RawURLEncoding.EncodeToString(op.PublicKey)},
+        public:    op.PublicKey,
+        secret:    op.PrivateKey,
+    }
+    bundleKey.check = func(secret []byte) bool {
+        msg := []byte("volly-ceremony-share-check")
+        sig, err := crypto.Sign(op.Algorithm, secret, msg)
+        return err == nil && crypto.VerifySignature(op.Algorithm, op.PublicKey, msg, sig) == nil
+    }
+
+    seed := make([]byte, ed25519.SeedSize)
+    if _, err := rand.Read(seed); err != nil {
+        return nil, err
+    }
+    pub := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+    usageKey := &rootKey{
+        name:      "usage",
+        algorithm: crypto.AlgorithmEd25519,
+        jwk:       dpop.JWK{Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(pub)},
+        public:    pub,
+        secret:    seed,
+        check: func(secret []byte) bool {
+            return len(secret) == ed25519.SeedSize && bytes.Equal(ed25519.NewKeyFromSeed(secret).Public().(ed25519.PublicKey), pub)
+        },
+    }
+
+    keys := []*rootKey{bundleKey, usageKey}
+    for _, k := range keys {
+        if k.kid, err = k.jwk.Thumbprint(); err != nil {
+            return nil, err
+        }
+    }
+    return keys, nil
+}
+
+// splitRootKeys splits every key among the custodians and, before anything is written,
+// checks that two different threshold subsets of the shares recover each key
+func splitRootKeys(keys []*rootKey, custodians []string, threshold int) ([]shareFile, error) {
+    now := time.Now().UTC()
+    files := make([]shareFile, len(custodians))
+    for i, c := range custodians {
+        files[i] = shareFile{Custodian: c, Index: i + 1, Threshold: threshold, Shares: len(custodians), CreatedAt: now}
+    }
+    for _, k := range keys {
+        parts, err := shamir.Split(k.secret, len(custodians), threshold)
+        if err != nil {
+            return nil, err
+        }
+        for _, subset := range [][]shamir.Share{parts[:threshold], parts[len(parts)-threshold:]} {
+            recovered, err := shamir.Combine(subset)
+            if err != nil {
+                return nil, err
+            }
+            ok := k.check(recovered)
+            secure.Wipe(recovered)
+            if !ok {
+                return nil, fmt.Errorf("shares of the %s key do not recover it", k.name)
+            }
+        }
+        for i, part := range parts {
+            files[i].Keys = append(files[i].Keys, keyShare{Key: k.name, Algorithm: k.algorithm, KeyID: k.kid, PublicKey: k.public, Share: part})
+        }
+    }
+    return files, nil
+}
+
+// serviceEnv is an env file with the usage signing seed and a fresh key for each service secret
+func serviceEnv(usageSeed []byte) ([]byte, error) {
+    var buf bytes.Buffer
+    fmt.Fprintf(&buf, "VOLLY_USAGE_SIGNING_KEY=%s\n", base64.StdEncoding.EncodeToString(usageSeed))
+    key := make([]byte, 32)
+    defer secure.Wipe(key)
+    for _, name := range serviceKeys {
+        if _, err := rand.Read(key); err != nil {
+            return nil, err
+        }
+        fmt.Fprintf(&buf, "%s=%s\n", name, base64.StdEncoding.EncodeToString(key))
+    }
+    return buf.Bytes(), nil
+}
+
+// writeJSON writes v indented and returns the file's SHA-256
+func writeJSON(path string, v interface{}, perm os.FileMode) (string, error) {
+    data, err := json.MarshalIndent(v, "", "  ")
+    if err != nil {
+        return "", err
+    }
+    defer secure.Wipe(data)
+    return writeFile(path, append(data, '\n'), perm)
+}
+
+// writeFile creates path, which must not exist
+func writeFile(path string, data []byte, perm os.FileMode) (string, error) {
+    f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+    if err != nil {
+        return "", err
+    }
+    if _, err := f.Write(data); err != nil {
+        f.Close()
+        return "", err
+    }
+    if err := f.Sync(); err != nil {
+        f.Close()
+        return "", err
+    }
+    sum := sha256.Sum256(data)
+    return hex.EncodeToString(sum[:]), f.Close()
+}
+
+func fileSafe(name string) string {
+    return strings.Map(func(r rune) rune {
+        if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+            return r
+        }
+        return '_'
+    }, name)
+}
+
+// transcript is an append-only JSON lines record of the ceremony. Each entry carries the
+// SHA-256 of the line before it, so the final hash, noted in the minutes, pins every step
+type transcript struct {
+    f        *os.File
+    operator string
+    seq      int
+    prev     string
+    err      error
+}
+
+type transcriptEntry struct {
+    Seq      int               `json:"seq"`
+    Time     time.Time         `json:"time"`
+    Operator string            `json:"operator"`
+    Event    string            `json:"event"`
+    Detail   map[string]string `json:"detail,omitempty"`
+    Prev     string            `json:"prev"`
+}
+
+func newTranscript(path, operator string) (*transcript, error) {
+    f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+    if err != nil {
+        return nil, err
+    }
+    return &transcript{f: f, operator: operator, prev: strings.Repeat("0", 64)}, nil
+}
+
+// log appends an event and returns the hash of its line. The first write error sticks
+func (t *transcript) log(event string, detail map[string]string) string {
+    if t.err != nil {
+        return t.prev
+    }
+    t.seq++
+    line, err := json.Marshal(transcriptEntry{Seq: t.seq, Time: time.Now().UTC(), Operator: t.operator, Event: event, Detail: detail, Prev: t.prev})
+    if err != nil {
+        t.err = err
+        return t.prev
+    }
+    line = append(line, '\n')
+    if _, err := t.f.Write(line); err != nil {
+        t.err = err
+        return t.prev
+    }
+    sum := sha256.Sum256(line)
+    t.prev = hex.EncodeToString(sum[:])
+    return t.prev
+}
+
+func (t *transcript) close() {
+    if err := t.f.Sync(); err != nil && t.err == nil {
+        t.err = err
+    }
+    t.f.Close()
+}
+
+// prompter asks the operator questions on the terminal
+type prompter struct {
+    in   *bufio.Reader
+    skip bool
+}
+
+func (p *prompter) line(prompt string) (string, error) {
+    fmt.Fprintf(os.Stderr, "%s ", prompt)
+    s, err := p.in.ReadString('\n')
+    if err != nil && !(errors.Is(err, io.EOF) && s != "") {
+        return "", err
+    }
+    return strings.TrimSpace(s), nil
+}
+
+// custodians returns the names in list, or asks for n of them
+func (p *prompter) custodians(list string, n int) ([]string, error) {
+    var names []string
+    if list != "" {
+        for _, c := range strings.Split(list, ",") {
+            names = append(names, strings.TrimSpace(c))
+        }
+        if len(names) != n {
+            return nil, fmt.Errorf("-custodians names %d custodians, -shares is %d", len(names), n)
+        }
+        return names, nil
+    }
+    for i := 1; i <= n; i++ {
+        name, err := p.line(fmt.Sprintf("Custodian %d of %d:", i, n))
+        if err != nil {
+            return nil, err
+        }
+        if name == "" {
+            return nil, errors.New("every share needs a custodian")
+        }
+        names = append(names, name)
+    }
+    return names, nil
+}
+
+func (p *prompter) confirm(question string) error {
+    if p.skip {
+        return nil
+    }
+    answer, err := p.line(question + " [y/N]")
+    if err != nil {
+        return err
+    }
+    if answer != "y" && answer != "Y" && answer != "yes" {
+        return errors.New("ceremony cancelled")
+    }
+    return nil
+}
+
+func (p *prompter) pause(instruction string) error {
+    if p.skip {
+        return nil
+    }
+    _, err := p.line(instruction)
+    return err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/doctor"
+)
+
+// diagnose asks the admin service to run its checks, after checking that the admin API can
+// be reached at all, which is the first thing to go wrong in a new deployment
+func diagnose(c *client, args []string) error {
+    flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+    asJSON := flags.Bool("json", false, "print the report as JSON")
+    flags.Parse(args)
+
+    report := &doctor.Report{StartedAt: time.Now()}
+    if c.token == "" {
+        report.Add(doctor.Fail("admin-api", "VOLLY_ADMIN_TOKEN is not set", "export the admin token the server was started with"))
+    } else {
+        body, err := c.do(http.MethodGet, "/v1/doctor", nil)
+        switch {
+        case err != nil:
+            report.Add(doctor.Fail("admin-api", err.Error(), adminFix(err)))
+        default:
+            var server doctor.Report
+            if err := json.Unmarshal(body, &server); err != nil {
+                return fmt.Errorf("decoding the doctor report: %v", err)
+            }
+            report.Add(doctor.OK("admin-api", "reached "+c.base))
+            report.Add(server.Findings...)
+        }
+    }
+    report.Elapsed = time.Since(report.StartedAt)
+
+    if *asJSON {
+        enc := json.NewEncoder(os.Stdout)
+        enc.SetIndent("", "  ")
+        if err := enc.Encode(report); err != nil {
+            return err
+        }
+    } else {
+        for _, f := range report.Findings {
+            fmt.Printf("%-5s %-17s %s\n", f.Status, f.Check, f.Detail)
+            if f.Fix != "" {
+                fmt.Printf("%-23s fix: %s\n", "", f.Fix)
+            }
+        }
+        fmt.Printf("\n%d findings, %d warnings, %d failures\n", len(report.Findings), report.Warnings, report.Failures)
+    }
+    if !report.Healthy() {
+        return errors.New("deployment is unhealthy")
+    }
+    return nil
+}
+
+// adminFix turns a failed admin call into the likely cause
+func adminFix(err error) string {
+    msg := err.Error()
+    switch {
+    case strings.Contains(msg, "401"):
+        return "VOLLY_ADMIN_TOKEN does not match the server's"
+    case strings.Contains(msg, "404"):
+        return "the server predates the doctor endpoint, or -server points at a service other than admin"
+    case strings.Contains(msg, "connection refused"), strings.Contains(msg, "no such host"):
+        return "check -server or VOLLY_ADMIN_URL, and that \"volly admin\" or \"volly all\" is running"
+    }
+    return "check -server or VOLLY_ADMIN_URL and the admin service's logs"
+}
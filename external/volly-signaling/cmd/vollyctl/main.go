@@ -0,0 +1,138 @@
+// Command vollyctl drives the volly admin API. export writes an encrypted snapshot of
+// tenants, API keys, room policies and revocations; import restores one, including into a
+// deployment on a different storage backend. Snapshots are sealed by the server under
+// VOLLY_SNAPSHOT_KEY, so both deployments must share that key. replay plays a transcript
+// recorded by "volly proxy" against a test gateway and reports where it diverges. doctor
+// runs the deployment diagnostics and says what to fix. ceremony generates a new
+// deployment's root keys offline, splits them among custodians and writes its first
+// config bundle
+package main
+
+import (
+    "bytes"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+)
+
+func usage() {
+    fmt.Fprintf(os.Stderr, "usage: vollyctl [-server url] <command>\n\ncommands:\n")
+    fmt.Fprintf(os.Stderr, "  export [-o file]             write a state snapshot (stdout by default)\n")
+    fmt.Fprintf(os.Stderr, "  import <file>                restore a state snapshot\n")
+    fmt.Fprintf(os.Stderr, "  replay [flags] <transcript>  replay a recorded session against a gateway\n")
+    fmt.Fprintf(os.Stderr, "  doctor [-json]               check config, secrets, stores, clock, crypto and tokens\n")
+    fmt.Fprintf(os.Stderr, "  ceremony [flags]             generate and split root keys, the JWKS and the first config bundle\n")
+    fmt.Fprintf(os.Stderr, "\nVOLLY_ADMIN_TOKEN authenticates to the admin API; VOLLY_TOKEN is the token replay connects with\n")
+}
+
+func main() {
+    server := flag.String("server", envOr("VOLLY_ADMIN_URL", "http://localhost:7883"), "admin API base URL")
+    flag.Usage = usage
+    flag.Parse()
+    if flag.NArg() < 1 {
+        usage()
+        os.Exit(2)
+    }
+
+    c := &client{
+        base:  strings.TrimSuffix(*server, "/"),
+        token: os.Getenv("VOLLY_ADMIN_TOKEN"),
+        http:  &http.Client{Timeout: 5 * time.Minute},
+    }
+    var err error
+    switch flag.Arg(0) {
+    case "export":
+        err = export(c, flag.Args()[1:])
+    case "import":
+        err = restore(c, flag.Args()[1:])
+    case "doctor":
+        err = diagnose(c, flag.Args()[1:])
+    case "replay":
+        err = replaySession(flag.Args()[1:])
+    case "ceremony":
+        err = ceremony(flag.Args()[1:])
+    default:
+        usage()
+        os.Exit(2)
+    }
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "vollyctl: %v\n", err)
+        os.Exit(1)
+    }
+}
+
+func export(c *client, args []string) error {
+    flags := flag.NewFlagSet("export", flag.ExitOnError)
+    out := flags.String("o", "", "output file")
+    flags.Parse(args)
+
+    body, err := c.do(http.MethodGet, "/v1/state", nil)
+    if err != nil {
+        return err
+    }
+    if *out == "" {
+        _, err = os.Stdout.Write(body)
+        return err
+    }
+    // The snapshot is encrypted, but it is still the keys to every tenant
+    return os.WriteFile(*out, body, 0o600)
+}
+
+func restore(c *client, args []string) error {
+    if len(args) != 1 {
+        return errors.New("import needs exactly one snapshot file")
+    }
+    data, err := os.ReadFile(args[0])
+    if err != nil {
+        return err
+    }
+    body, err := c.do(http.MethodPost, "/v1/state", data)
+    if err != nil {
+        return err
+    }
+    _, err = os.Stdout.Write(body)
+    return err
+}
+
+type client struct {
+    base  string
+    token string
+    http  *http.Client
+}
+
+func (c *client) do(method, path string, body []byte) ([]byte, error) {
+    req, err := http.NewRequest(method, c.base+path, bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Authorization", "Bearer "+c.token)
+    req.Header.Set("Volly-Actor", "vollyctl:"+envOr("USER", "unknown"))
+    if body != nil {
+        req.Header.Set("Content-Type", "application/octet-stream")
+    }
+    resp, err := c.http.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    data, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return nil, err
+    }
+    if resp.StatusCode/100 != 2 {
+        return nil, errors.New(method + " " + path + ": " + resp.Status + ": " + strings.TrimSpace(string(data)))
+    }
+    return data, nil
+}
+
+func envOr(key, def string) string {
+    if v := os.Getenv(key); v != "" {
+        return v
+    }
+    return def
+}
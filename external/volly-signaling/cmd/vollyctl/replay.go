@@ -0,0 +1,57 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/replay"
+)
+
+func replaySession(args []string) error {
+    flags := flag.NewFlagSet("replay", flag.ExitOnError)
+    gateway := flags.String("gateway", envOr("VOLLY_GATEWAY_URL", "http://localhost:7880"), "test gateway base URL")
+    room := flags.String("room", "", "room the token is for")
+    speed := flags.Float64("speed", 1, "timing scale; 0 sends without pausing")
+    asJSON := flags.Bool("json", false, "print the result as JSON")
+    flags.Parse(args)
+    if flags.NArg() != 1 {
+        return errors.New("replay needs exactly one transcript file")
+    }
+    token := os.Getenv("VOLLY_TOKEN")
+    if *room == "" || token == "" {
+        return errors.New("replay needs -room and VOLLY_TOKEN")
+    }
+
+    t, err := replay.Load(flags.Arg(0))
+    if err != nil {
+        return err
+    }
+    res, err := replay.NewReplayer(*gateway, *room, token).SetSpeed(*speed).Replay(context.Background(), t)
+    if err != nil {
+        return err
+    }
+    if *asJSON {
+        enc := json.NewEncoder(os.Stdout)
+        enc.SetIndent("", "  ")
+        if err := enc.Encode(res); err != nil {
+            return err
+        }
+    } else {
+        for _, s := range res.Skipped {
+            fmt.Printf("skipped  %s\n", s)
+        }
+        for _, d := range res.Divergences {
+            fmt.Printf("diverged %s\n", d)
+        }
+        fmt.Printf("%d protocol messages, %d events recorded; %d and %d replayed\n",
+            len(res.Expected), res.ExpectedEvents, len(res.Actual), res.ActualEvents)
+    }
+    if !res.Matches() {
+        return fmt.Errorf("transcript %s diverged in %d places", t.ID, len(res.Divergences))
+    }
+    return nil
+}
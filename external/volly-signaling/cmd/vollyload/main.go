@@ -0,0 +1,197 @@
+// Command vollyload drives concurrent token issuance, verification and PQ
+// handshakes against the signaling libraries and reports latency percentiles
+package main
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+    "os/signal"
+    "sort"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/livekit/protocol/auth"
+
+    vollyauth "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+)
+
+const (
+    loadAPIKey = "vollyload"
+    loadSecret = "vollyload-secret-not-for-production-use"
+)
+
+// scenario is one measured operation; each worker calls it in a loop
+type scenario func(worker int, seq uint64) error
+
+type recorder struct {
+    mu      sync.Mutex
+    samples []time.Duration
+    errors  uint64
+}
+
+func (r *recorder) record(d time.Duration, err error) {
+    if err != nil {
+        atomic.AddUint64(&r.errors, 1)
+        return
+    }
+    r.mu.Lock()
+    r.samples = append(r.samples, d)
+    r.mu.Unlock()
+}
+
+func (r *recorder) report(name string, elapsed time.Duration) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    sort.Slice(r.samples, func(i, j int) bool { return r.samples[i] < r.samples[j] })
+    n := len(r.samples)
+    if n == 0 {
+        fmt.Printf("%-10s no successful operations, %d errors\n", name, r.errors)
+        return
+    }
+    fmt.Printf("%-10s ops=%-8d errors=%-6d rate=%8.0f/s  p50=%-10s p90=%-10s p99=%-10s max=%s\n",
+        name, n, r.errors, float64(n)/elapsed.Seconds(),
+        percentile(r.samples, 0.50), percentile(r.samples, 0.90), percentile(r.samples, 0.99), r.samples[n-1])
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+    idx := int(p * float64(len(sorted)-1))
+    return sorted[idx]
+}
+
+func main() {
+    concurrency := flag.Int("concurrency", 1000, "concurrent workers per scenario")
+    duration := flag.Duration("duration", 30*time.Second, "how long to run")
+    scenarios := flag.String("scenarios", "issue,verify,handshake", "comma-separated scenarios to run")
+    signAlg := flag.String("sign-alg", crypto.AlgorithmMLDSA65, "signature algorithm for handshake transcripts")
+    flag.Parse()
+
+    ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+    defer cancel()
+    ctx, cancelRun := context.WithTimeout(ctx, *duration)
+    defer cancelRun()
+
+    available, err := buildScenarios(*signAlg)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "vollyload:", err)
+        os.Exit(1)
+    }
+
+    var names []string
+    for _, name := range strings.Split(*scenarios, ",") {
+        name = strings.TrimSpace(name)
+        if _, ok := available[name]; !ok {
+            fmt.Fprintf(os.Stderr, "vollyload: unknown scenario %q\n", name)
+            os.Exit(2)
+        }
+        names = append(names, name)
+    }
+
+    recorders := make(map[string]*recorder, len(names))
+    var wg sync.WaitGroup
+    start := time.Now()
+    for _, name := range names {
+        rec := &recorder{}
+        recorders[name] = rec
+        run := available[name]
+        for w := 0; w < *concurrency; w++ {
+            wg.Add(1)
+            go func(worker int) {
+                defer wg.Done()
+                for seq := uint64(0); ctx.Err() == nil; seq++ {
+                    opStart := time.Now()
+                    err := run(worker, seq)
+                    rec.record(time.Since(opStart), err)
+                }
+            }(w)
+        }
+    }
+    wg.Wait()
+    elapsed := time.Since(start)
+
+    fmt.Printf("vollyload: %d workers per scenario for %s\n", *concurrency, elapsed.Round(time.Millisecond))
+    for _, name := range names {
+        recorders[name].report(name, elapsed)
+    }
+}
+
+func buildScenarios(signAlg string) (map[string]scenario, error) {
+    clientKey, err := crypto.GenerateMLKEM768KeyPair()
+    if err != nil {
+        return nil, err
+    }
+    serverKey, err := crypto.GenerateSigningKeyPair(signAlg)
+    if err != nil {
+        return nil, err
+    }
+
+    // Verification reuses a fixed token set so it measures only the verify path
+    tokens := make([]string, 64)
+    for i := range tokens {
+        if tokens[i], err = issueToken(i, 0, clientKey.PublicKey); err != nil {
+            return nil, err
+        }
+    }
+
+    return map[string]scenario{
+        "issue": func(worker int, seq uint64) error {
+            _, err := issueToken(worker, seq, clientKey.PublicKey)
+            return err
+        },
+        "verify": func(worker int, seq uint64) error {
+            token := tokens[(uint64(worker)+seq)%uint64(len(tokens))]
+            _, err := vollyauth.VerifyVollyToken(token, loadAPIKey, loadSecret)
+            return err
+        },
+        "handshake": func(worker int, seq uint64) error {
+            return handshake(serverKey)
+        },
+    }, nil
+}
+
+func issueToken(worker int, seq uint64, pqPublicKey []byte) (string, error) {
+    at := vollyauth.NewVollyAccessToken(loadAPIKey, loadSecret)
+    at.AddGrant(&vollyauth.VollyVideoGrant{
+        VideoGrant: auth.VideoGrant{RoomJoin: true, Room: fmt.Sprintf("load-%d", worker%100)},
+    })
+    at.SetIdentity(fmt.Sprintf("load-%d-%d", worker, seq))
+    at.SetPostQuantumKey(pqPublicKey, crypto.AlgorithmMLKEM768)
+    return at.ToJWT()
+}
+
+// handshake mirrors a session setup: the client sends a fresh ML-KEM key, the
+// server encapsulates and signs the transcript, the client verifies and decapsulates
+func handshake(serverKey *crypto.KeyPair) error {
+    client, err := crypto.GenerateMLKEM768KeyPair()
+    if err != nil {
+        return err
+    }
+    ciphertext, serverSecret, err := crypto.Encapsulate(client.PublicKey)
+    if err != nil {
+        return err
+    }
+    transcript := append(append([]byte{}, client.PublicKey...), ciphertext...)
+    signature, err := crypto.Sign(serverKey.Algorithm, serverKey.PrivateKey, transcript)
+    if err != nil {
+        return err
+    }
+
+    if err := crypto.VerifySignature(serverKey.Algorithm, serverKey.PublicKey, transcript, signature); err != nil {
+        return err
+    }
+    clientSecret, err := crypto.Decapsulate(client.PrivateKey, ciphertext)
+    if err != nil {
+        return err
+    }
+    if !bytes.Equal(clientSecret, serverSecret) {
+        return errors.New("shared secrets differ")
+    }
+    return nil
+}
@@ -1,11 +1,36 @@
 module github.com/volly-org/volly-signaling
 
-go 1.21
+go 1.22.0
 
 require (
+    github.com/cloudflare/circl v1.6.1
+    github.com/hashicorp/go-plugin v1.6.1
     github.com/livekit/livekit-server v1.5.0
     github.com/livekit/protocol v1.10.0
-    google.golang.org/protobuf v1.31.0
+    github.com/miekg/dns v1.1.58
+    github.com/tetratelabs/wazero v1.7.3
+    golang.org/x/sys v0.17.0
+    google.golang.org/protobuf v1.32.0
+)
+
+require (
+    github.com/fatih/color v1.7.0 // indirect
+    github.com/go-jose/go-jose/v3 v3.0.1 // indirect
+    github.com/golang/protobuf v1.5.3 // indirect
+    github.com/hashicorp/go-hclog v0.14.1 // indirect
+    github.com/hashicorp/yamux v0.1.1 // indirect
+    github.com/mattn/go-colorable v0.1.4 // indirect
+    github.com/mattn/go-isatty v0.0.10 // indirect
+    github.com/mitchellh/go-testing-interface v0.0.0-20171004221916-a61a99592b77 // indirect
+    github.com/oklog/run v1.0.0 // indirect
+    github.com/twitchtv/twirp v8.1.3+incompatible // indirect
+    golang.org/x/crypto v0.19.0 // indirect
+    golang.org/x/exp v0.0.0-20240222234643-814bf88cf225 // indirect
+    golang.org/x/net v0.21.0 // indirect
+    golang.org/x/text v0.14.0 // indirect
+    google.golang.org/genproto/googleapis/rpc v0.0.0-20240221002015-b0ce06bbee7c // indirect
+    google.golang.org/grpc v1.62.0 // indirect
+    gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
 // Note: This is a placeholder go.mod file
@@ -0,0 +1,268 @@
+package abuse
+
+import (
+    "context"
+    "errors"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/gateway"
+)
+
+// DefaultWindow is how long reports count towards a subject's score
+const DefaultWindow = 24 * time.Hour
+
+// maxReportsPerSubject bounds the history kept for one identity
+const maxReportsPerSubject = 256
+
+var (
+    ErrBlocked       = errors.New("identity is blocked after abuse reports")
+    ErrInvalidReport = errors.New("abuse report needs an identity and a category")
+)
+
+// Report is one abuse report against an identity, optionally in a room
+type Report struct {
+    Tenant   string    `json:"tenant,omitempty"`
+    Identity string    `json:"identity"`
+    Room     string    `json:"room,omitempty"`
+    Reporter string    `json:"reporter,omitempty"`
+    Category string    `json:"category"`
+    Detail   string    `json:"detail,omitempty"`
+    At       time.Time `json:"at"`
+}
+
+// Scorer turns a subject's recent reports into a score; the latest report is last
+type Scorer interface {
+    Score(ctx context.Context, reports []Report) (float64, error)
+}
+
+// ScorerFunc adapts a function to Scorer
+type ScorerFunc func(ctx context.Context, reports []Report) (float64, error)
+
+// Score calls f
+func (f ScorerFunc) Score(ctx context.Context, reports []Report) (float64, error) {
+    return f(ctx, reports)
+}
+
+// CategoryWeights scores reports by category, counting each reporter once per category;
+// unknown categories weigh 1
+type CategoryWeights map[string]float64
+
+// Score sums the weights of distinct reporter and category pairs
+func (w CategoryWeights) Score(ctx context.Context, reports []Report) (float64, error) {
+    seen := make(map[[2]string]bool, len(reports))
+    var score float64
+    for _, r := range reports {
+        key := [2]string{r.Reporter, r.Category}
+        if r.Reporter != "" && seen[key] {
+            continue
+        }
+        seen[key] = true
+        weight, ok := w[r.Category]
+        if !ok {
+            weight = 1
+        }
+        score += weight
+    }
+    return score, nil
+}
+
+// Consequences are applied to an identity while its score stays in a tier
+type Consequences struct {
+    // RateLimitFactor scales the identity's request rate; 0 leaves it unchanged
+    RateLimitFactor float64 `json:"rateLimitFactor,omitempty"`
+    // MaxTokenTTL caps the lifetime of newly issued tokens; 0 leaves it unchanged
+    MaxTokenTTL time.Duration `json:"maxTokenTTL,omitempty"`
+    // Escalate calls the escalation hook once when the tier is entered
+    Escalate bool `json:"escalate,omitempty"`
+    // Block rejects admission outright
+    Block bool `json:"block,omitempty"`
+}
+
+// Tier applies Consequences from MinScore upwards
+type Tier struct {
+    MinScore float64 `json:"minScore"`
+    Consequences
+}
+
+// Policy maps scores to consequences; the highest tier reached wins
+type Policy struct {
+    Window time.Duration `json:"window,omitempty"`
+    Tiers  []Tier        `json:"tiers"`
+}
+
+// Status is an identity's current standing
+type Status struct {
+    Score float64 `json:"score"`
+    Consequences
+}
+
+// EscalationHook is called when an identity enters an escalating tier, e.g. to revoke its tokens
+// or page trust and safety
+type EscalationHook func(ctx context.Context, report Report, status Status) error
+
+type subject struct {
+    tenant, identity string
+}
+
+// Monitor records abuse reports and derives per-identity consequences
+type Monitor struct {
+    scorer   Scorer
+    escalate EscalationHook
+    clock    clock.Clock
+
+    mu            sync.Mutex
+    defaultPolicy Policy
+    tenantPolicy  map[string]Policy
+    reports       map[subject][]Report
+    status        map[subject]Status
+}
+
+// NewMonitor creates a monitor; def applies to tenants without their own policy
+func NewMonitor(scorer Scorer, def Policy) *Monitor {
+    return &Monitor{
+        scorer:        scorer,
+        clock:         clock.System,
+        defaultPolicy: def,
+        tenantPolicy:  make(map[string]Policy),
+        reports:       make(map[subject][]Report),
+        status:        make(map[subject]Status),
+    }
+}
+
+// SetTenantPolicy overrides the default policy for one tenant
+func (m *Monitor) SetTenantPolicy(tenant string, policy Policy) *Monitor {
+    m.mu.Lock()
+    m.tenantPolicy[tenant] = policy
+    m.mu.Unlock()
+    return m
+}
+
+// SetEscalationHook sets the hook run for escalating tiers
+func (m *Monitor) SetEscalationHook(hook EscalationHook) *Monitor {
+    m.escalate = hook
+    return m
+}
+
+// SetClock sets the time source for report windows
+func (m *Monitor) SetClock(c clock.Clock) *Monitor {
+    m.clock = c
+    return m
+}
+
+// ReportAbuse records a report, rescores the identity and returns its new status
+func (m *Monitor) ReportAbuse(ctx context.Context, report Report) (Status, error) {
+    if report.Identity == "" || report.Category == "" {
+        return Status{}, ErrInvalidReport
+    }
+    now := m.clock.Now()
+    if report.At.IsZero() {
+        report.At = now
+    }
+    key := subject{report.Tenant, report.Identity}
+
+    m.mu.Lock()
+    policy := m.policyFor(report.Tenant)
+    history := append(m.recent(key, policy, now), report)
+    if len(history) > maxReportsPerSubject {
+        history = history[len(history)-maxReportsPerSubject:]
+    }
+    m.reports[key] = history
+    previous := m.status[key]
+    snapshot := append([]Report(nil), history...)
+    m.mu.Unlock()
+
+    score, err := m.scorer.Score(ctx, snapshot)
+    if err != nil {
+        return previous, err
+    }
+    status := Status{Score: score, Consequences: tierFor(policy, score)}
+
+    m.mu.Lock()
+    m.status[key] = status
+    m.mu.Unlock()
+
+    if status.Escalate && !previous.Escalate && m.escalate != nil {
+        if err := m.escalate(ctx, report, status); err != nil {
+            return status, err
+        }
+    }
+    return status, nil
+}
+
+// Status returns an identity's standing; it decays to the zero Status once its reports age out
+func (m *Monitor) Status(tenant, identity string) Status {
+    key := subject{tenant, identity}
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    if len(m.recent(key, m.policyFor(tenant), m.clock.Now())) == 0 {
+        delete(m.reports, key)
+        delete(m.status, key)
+        return Status{}
+    }
+    return m.status[key]
+}
+
+// CapTTL shortens ttl to the identity's MaxTokenTTL, for use at token issuance
+func (m *Monitor) CapTTL(tenant, identity string, ttl time.Duration) time.Duration {
+    if max := m.Status(tenant, identity).MaxTokenTTL; max > 0 && max < ttl {
+        return max
+    }
+    return ttl
+}
+
+// RateLimitFactor returns the multiplier for the identity's request rate, 1 when unrestricted
+func (m *Monitor) RateLimitFactor(tenant, identity string) float64 {
+    if f := m.Status(tenant, identity).RateLimitFactor; f > 0 {
+        return f
+    }
+    return 1
+}
+
+// Admit rejects blocked identities at the gateway
+func (m *Monitor) Admit(ctx context.Context, a *gateway.Admission) error {
+    tenant := ""
+    if a.Grant != nil {
+        tenant = a.Grant.Tenant
+    }
+    if m.Status(tenant, a.Identity).Block {
+        return ErrBlocked
+    }
+    return nil
+}
+
+// recent returns the reports still inside the policy window; callers hold m.mu
+func (m *Monitor) recent(key subject, policy Policy, now time.Time) []Report {
+    window := policy.Window
+    if window <= 0 {
+        window = DefaultWindow
+    }
+    history := m.reports[key]
+    i := sort.Search(len(history), func(i int) bool { return now.Sub(history[i].At) < window })
+    return history[i:]
+}
+
+// policyFor callers hold m.mu
+func (m *Monitor) policyFor(tenant string) Policy {
+    if p, ok := m.tenantPolicy[tenant]; ok {
+        return p
+    }
+    return m.defaultPolicy
+}
+
+func tierFor(policy Policy, score float64) Consequences {
+    var best *Tier
+    for i := range policy.Tiers {
+        t := &policy.Tiers[i]
+        if score >= t.MinScore && (best == nil || t.MinScore > best.MinScore) {
+            best = t
+        }
+    }
+    if best == nil {
+        return Consequences{}
+    }
+    return best.Consequences
+}
@@ -0,0 +1,93 @@
+package abuse
+
+import (
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+)
+
+// maxBuckets triggers a sweep of idle buckets
+const maxBuckets = 100000
+
+// RateLimiter is a per-identity token bucket whose rate is tightened by a Monitor
+type RateLimiter struct {
+    rate    float64
+    burst   float64
+    monitor *Monitor
+    clock   clock.Clock
+
+    mu      sync.Mutex
+    buckets map[subject]*bucket
+}
+
+type bucket struct {
+    tokens float64
+    last   time.Time
+}
+
+// NewRateLimiter allows rate requests per second with bursts of burst; monitor may be nil
+func NewRateLimiter(rate float64, burst int, monitor *Monitor) *RateLimiter {
+    return &RateLimiter{
+        rate:    rate,
+        burst:   float64(burst),
+        monitor: monitor,
+        clock:   clock.System,
+        buckets: make(map[subject]*bucket),
+    }
+}
+
+// SetClock sets the time source for refills
+func (l *RateLimiter) SetClock(c clock.Clock) *RateLimiter {
+    l.clock = c
+    return l
+}
+
+// Allow spends one token for the identity, scaling rate and burst by its abuse factor
+func (l *RateLimiter) Allow(tenant, identity string) bool {
+    factor := 1.0
+    if l.monitor != nil {
+        factor = l.monitor.RateLimitFactor(tenant, identity)
+    }
+    rate, burst := l.rate*factor, l.burst*factor
+    if burst < 1 {
+        burst = 1
+    }
+
+    now := l.clock.Now()
+    key := subject{tenant, identity}
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    b, ok := l.buckets[key]
+    if !ok {
+        if len(l.buckets) >= maxBuckets {
+            l.sweep(now)
+        }
+        b = &bucket{tokens: burst, last: now}
+        l.buckets[key] = b
+    }
+    b.tokens += now.Sub(b.last).Seconds() * rate
+    b.last = now
+    if b.tokens > burst {
+        b.tokens = burst
+    }
+    if b.tokens < 1 {
+        return false
+    }
+    b.tokens--
+    return true
+}
+
+// sweep drops buckets idle long enough to have refilled, which a fresh bucket reproduces; callers hold l.mu
+func (l *RateLimiter) sweep(now time.Time) {
+    if l.rate <= 0 {
+        return
+    }
+    idle := time.Duration(l.burst / l.rate * float64(time.Second))
+    for key, b := range l.buckets {
+        if now.Sub(b.last) >= idle {
+            delete(l.buckets, key)
+        }
+    }
+}
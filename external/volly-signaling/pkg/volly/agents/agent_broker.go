@@ -0,0 +1,203 @@
+package agents
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    lkauth "github.com/livekit/protocol/auth"
+    "github.com/livekit/protocol/livekit"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+    "github.com/volly-org/volly-signaling/pkg/volly/presence"
+    "github.com/volly-org/volly-signaling/pkg/volly/secure"
+)
+
+// DefaultAgentTTL bounds how long an agent token stays valid
+const DefaultAgentTTL = time.Hour
+
+var (
+    ErrRoomRequired    = errors.New("room is required")
+    ErrAgentIDRequired = errors.New("agent id is required")
+    ErrRoleNotAllowed  = errors.New("agent role is not allowed")
+)
+
+// Role describes what a server-side agent does in a room
+type Role string
+
+const (
+    RoleTranscriber Role = "transcriber"
+    RoleTranslator  Role = "translator"
+)
+
+// KeyProvisioner provisions post-quantum keys for agents that don't bring their own
+type KeyProvisioner interface {
+    ProvisionKey(ctx context.Context, identity string) (*crypto.KeyPair, error)
+}
+
+// KeyProvisionerFunc adapts a function to KeyProvisioner
+type KeyProvisionerFunc func(ctx context.Context, identity string) (*crypto.KeyPair, error)
+
+// ProvisionKey calls f
+func (f KeyProvisionerFunc) ProvisionKey(ctx context.Context, identity string) (*crypto.KeyPair, error) {
+    return f(ctx, identity)
+}
+
+// JoinRequest is an agent's request to join a room
+type JoinRequest struct {
+    Room    string
+    AgentID string
+    Role    Role
+    TTL     time.Duration
+
+    // PQPublicKey is optional; a key pair is provisioned when empty
+    PQPublicKey []byte
+}
+
+// JoinResponse carries the constrained credentials for an agent session
+type JoinResponse struct {
+    Token     string
+    Identity  string
+    SessionID string
+    ExpiresAt time.Time
+
+    // KeyPair is set only when the broker provisioned the agent's PQ keys
+    KeyPair *crypto.KeyPair
+}
+
+// AgentBroker issues subscribe-only tokens for server-side agents and registers them in presence
+type AgentBroker struct {
+    apiKey   string
+    secret   *secure.SecureBytes
+    presence presence.Store
+    keys     KeyProvisioner
+    maxTTL   time.Duration
+    roles    map[Role]bool
+    clock    clock.Clock
+}
+
+// NewAgentBroker creates a broker that provisions ML-KEM-768 keys for agents
+func NewAgentBroker(apiKey string, secret *secure.SecureBytes, store presence.Store) *AgentBroker {
+    return &AgentBroker{
+        apiKey:   apiKey,
+        secret:   secret,
+        presence: store,
+        keys: KeyProvisionerFunc(func(ctx context.Context, identity string) (*crypto.KeyPair, error) {
+            return crypto.GenerateMLKEM768KeyPair()
+        }),
+        maxTTL: DefaultAgentTTL,
+        roles: map[Role]bool{
+            RoleTranscriber: true,
+            RoleTranslator:  true,
+        },
+        clock: clock.System,
+    }
+}
+
+// SetKeyProvisioner overrides how agent PQ keys are provisioned
+func (b *AgentBroker) SetKeyProvisioner(keys KeyProvisioner) *AgentBroker {
+    b.keys = keys
+    return b
+}
+
+// SetMaxTTL caps the lifetime of agent tokens
+func (b *AgentBroker) SetMaxTTL(ttl time.Duration) *AgentBroker {
+    b.maxTTL = ttl
+    return b
+}
+
+// SetClock sets the time source agent tokens are issued and expire by
+func (b *AgentBroker) SetClock(c clock.Clock) *AgentBroker {
+    b.clock = c
+    return b
+}
+
+// AllowRole permits an additional agent role
+func (b *AgentBroker) AllowRole(role Role) *AgentBroker {
+    b.roles[role] = true
+    return b
+}
+
+// Join issues a token for the agent and registers it as a non-human participant
+func (b *AgentBroker) Join(ctx context.Context, req JoinRequest) (*JoinResponse, error) {
+    if req.Room == "" {
+        return nil, ErrRoomRequired
+    }
+    if req.AgentID == "" {
+        return nil, ErrAgentIDRequired
+    }
+    if !b.roles[req.Role] {
+        return nil, ErrRoleNotAllowed
+    }
+
+    ttl := req.TTL
+    if ttl <= 0 || ttl > b.maxTTL {
+        ttl = b.maxTTL
+    }
+    identity := "agent:" + string(req.Role) + ":" + req.AgentID
+
+    resp := &JoinResponse{
+        Identity:  identity,
+        SessionID: presence.NewSessionID(),
+    }
+
+    publicKey := req.PQPublicKey
+    if len(publicKey) == 0 {
+        kp, err := b.keys.ProvisionKey(ctx, identity)
+        if err != nil {
+            return nil, err
+        }
+        publicKey = kp.PublicKey
+        resp.KeyPair = kp
+    }
+
+    // Agents may only listen: no publishing of media or data, no admin rights
+    canSubscribe, canPublish := true, false
+    grant := &auth.VollyVideoGrant{
+        VideoGrant: lkauth.VideoGrant{
+            RoomJoin:       true,
+            Room:           req.Room,
+            CanSubscribe:   &canSubscribe,
+            CanPublish:     &canPublish,
+            CanPublishData: &canPublish,
+        },
+    }
+
+    at := auth.NewVollyAccessTokenWithSecret(b.apiKey, b.secret).
+        SetClock(b.clock).
+        AddGrant(grant).
+        SetIdentity(identity).
+        SetKind(livekit.ParticipantInfo_AGENT).
+        SetValidFor(ttl).
+        SetPostQuantumKey(publicKey, crypto.AlgorithmMLKEM768)
+    token, err := at.ToJWT()
+    if err != nil {
+        return nil, err
+    }
+    // The token's own exp, so the two cannot disagree
+    resp.Token, resp.ExpiresAt = token, at.ExpiresAt()
+
+    err = b.presence.Join(ctx, presence.Session{
+        SessionID: resp.SessionID,
+        Room:      req.Room,
+        Identity:  identity,
+        Kind:      presence.KindAgent,
+        JoinedAt:  b.clock.Now(),
+        Metadata: map[string]string{
+            "role":    string(req.Role),
+            "agentId": req.AgentID,
+        },
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    return resp, nil
+}
+
+// Leave removes an agent session from presence
+func (b *AgentBroker) Leave(ctx context.Context, sessionID string) error {
+    return b.presence.Leave(ctx, sessionID)
+}
@@ -0,0 +1,370 @@
+// Package analytics aggregates signaling activity into per-tenant, per-window reports that
+// product analytics can consume without seeing identities. Identities and room names are
+// only kept as keyed hashes, for counting distinct subjects, and never leave the collector;
+// a report cell covering fewer than k distinct participants is withheld, and released
+// counts can carry Laplace noise
+package analytics
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/binary"
+    "log"
+    "math"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/tagset"
+)
+
+const (
+    DefaultWindow = time.Hour
+    // DefaultK is the fewest distinct participants a released cell may cover
+    DefaultK = 5
+    // DefaultRetention is how many closed windows are kept for export
+    DefaultRetention = 48
+    // DefaultMaxSession drops sessions never seen leaving, e.g. when the edge lost them
+    DefaultMaxSession = 24 * time.Hour
+)
+
+// DurationBuckets are the upper bounds of the session duration histogram
+var DurationBuckets = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute, 30 * time.Minute, time.Hour, 2 * time.Hour}
+
+// Report aggregates the activity of one tenant's tokens with one set of cost-attribution
+// tags over one window. A zero Participants means the window's joins were withheld, not
+// that there were none
+type Report struct {
+    Tenant string            `json:"tenant"`
+    Tags   map[string]string `json:"tags,omitempty"`
+    Start  time.Time         `json:"start"`
+    End    time.Time         `json:"end"`
+    // Rooms and Participants count the distinct rooms joined and identities that joined
+    Rooms        int `json:"rooms"`
+    Participants int `json:"participants"`
+    Joins        int `json:"joins"`
+    // Durations counts the sessions that ended in the window by length
+    Durations []Bucket `json:"durations,omitempty"`
+    // Failures counts rejected joins by reason
+    Failures map[string]int `json:"failures,omitempty"`
+    // Withheld is how many cells were left out for covering fewer than k participants
+    Withheld int `json:"withheld,omitempty"`
+}
+
+// Bucket is one histogram cell; Max is the upper bound, "+Inf" for the last
+type Bucket struct {
+    Max   string `json:"max"`
+    Count int    `json:"count"`
+}
+
+// cell counts events and the distinct subjects behind them
+type cell struct {
+    count    int
+    subjects map[uint64]struct{}
+}
+
+func (c *cell) add(subject uint64) {
+    if c.subjects == nil {
+        c.subjects = make(map[uint64]struct{})
+    }
+    c.count++
+    c.subjects[subject] = struct{}{}
+}
+
+type aggregate struct {
+    tenant    string
+    tags      map[string]string
+    rooms     map[uint64]struct{}
+    joins     cell
+    durations []cell
+    failures  map[string]*cell
+}
+
+type session struct {
+    tenant  string
+    tags    map[string]string
+    subject uint64
+    start   time.Time
+}
+
+// Collector records joins, leaves and failed joins and closes a window of reports every
+// Window. Safe for concurrent use
+type Collector struct {
+    window     time.Duration
+    k          int
+    epsilon    float64
+    retention  int
+    maxSession time.Duration
+    clock      clock.Clock
+    key        []byte
+
+    mu    sync.Mutex
+    start time.Time
+    // current is keyed by tenant and tags in tagset.Encode's form
+    current  map[string]*aggregate
+    sessions map[string]session
+    reports  []Report
+}
+
+// NewCollector creates a collector with the defaults and a hashing key of its own
+func NewCollector() *Collector {
+    key := make([]byte, 32)
+    if _, err := rand.Read(key); err != nil {
+        panic("analytics: reading random key: " + err.Error())
+    }
+    return &Collector{
+        window:     DefaultWindow,
+        k:          DefaultK,
+        retention:  DefaultRetention,
+        maxSession: DefaultMaxSession,
+        clock:      clock.System,
+        key:        key,
+        current:    make(map[string]*aggregate),
+        sessions:   make(map[string]session),
+    }
+}
+
+// SetWindow sets how long each report covers; it applies from the next window
+func (c *Collector) SetWindow(d time.Duration) *Collector {
+    c.window = d
+    return c
+}
+
+// SetK sets the fewest distinct participants a released cell may cover
+func (c *Collector) SetK(k int) *Collector {
+    c.k = k
+    return c
+}
+
+// SetNoise adds Laplace noise of scale 1/epsilon to every released count; zero adds none.
+// The scale assumes one participant moves each count by one, which holds for Participants
+// but not for a participant who joins many times, so it is a mitigation rather than a
+// differential privacy guarantee
+func (c *Collector) SetNoise(epsilon float64) *Collector {
+    c.epsilon = epsilon
+    return c
+}
+
+// SetRetention sets how many closed windows are kept for export
+func (c *Collector) SetRetention(n int) *Collector {
+    c.retention = n
+    return c
+}
+
+// SetMaxSession sets how long a session may stay open before it is dropped uncounted
+func (c *Collector) SetMaxSession(d time.Duration) *Collector {
+    c.maxSession = d
+    return c
+}
+
+// SetClock sets the time source for windows and durations
+func (c *Collector) SetClock(clk clock.Clock) *Collector {
+    c.clock = clk
+    return c
+}
+
+// hash keys a subject so distinct ones can be counted without being kept
+func (c *Collector) hash(tenant, value string) uint64 {
+    mac := hmac.New(sha256.New, c.key)
+    mac.Write([]byte(tenant))
+    mac.Write([]byte{0})
+    mac.Write([]byte(value))
+    return binary.BigEndian.Uint64(mac.Sum(nil))
+}
+
+// aggregateLocked returns the aggregate of tenant and tags in the current window, closing
+// the previous window first if it has ended
+func (c *Collector) aggregateLocked(tenant string, tags map[string]string, now time.Time) *aggregate {
+    c.rollLocked(now)
+    key := tenant + "\x00" + tagset.Encode(tags)
+    a := c.current[key]
+    if a == nil {
+        a = &aggregate{
+            tenant:    tenant,
+            tags:      tags,
+            rooms:     make(map[uint64]struct{}),
+            durations: make([]cell, len(DurationBuckets)+1),
+            failures:  make(map[string]*cell),
+        }
+        c.current[key] = a
+    }
+    return a
+}
+
+// Joined records identity joining room in tenant over the connection session, with the
+// tags of its token
+func (c *Collector) Joined(tenant string, tags map[string]string, room, identity, sessionID string) {
+    now := c.clock.Now()
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    a := c.aggregateLocked(tenant, tags, now)
+    subject := c.hash(tenant, identity)
+    a.rooms[c.hash(tenant, "room\x00"+room)] = struct{}{}
+    a.joins.add(subject)
+    if sessionID != "" {
+        c.sessions[sessionID] = session{tenant: tenant, tags: tags, subject: subject, start: now}
+    }
+}
+
+// Left records the end of a session opened with Joined; unknown sessions are ignored
+func (c *Collector) Left(sessionID string) {
+    now := c.clock.Now()
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    s, ok := c.sessions[sessionID]
+    if !ok {
+        return
+    }
+    delete(c.sessions, sessionID)
+    elapsed := now.Sub(s.start)
+    i := sort.Search(len(DurationBuckets), func(i int) bool { return elapsed <= DurationBuckets[i] })
+    c.aggregateLocked(s.tenant, s.tags, now).durations[i].add(s.subject)
+}
+
+// Failed records a rejected join. tenant and tags may be empty when the token could not be
+// read, and subject is whatever identifies the caller, its identity or else its address
+func (c *Collector) Failed(tenant string, tags map[string]string, subject, reason string) {
+    now := c.clock.Now()
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    a := c.aggregateLocked(tenant, tags, now)
+    f := a.failures[reason]
+    if f == nil {
+        f = &cell{}
+        a.failures[reason] = f
+    }
+    f.add(c.hash(tenant, subject))
+}
+
+// Reports returns the closed windows that started at or after since, oldest first, of the
+// reports whose tags carry every pair in filter
+func (c *Collector) Reports(since time.Time, filter map[string]string) []Report {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.rollLocked(c.clock.Now())
+    out := make([]Report, 0, len(c.reports))
+    for _, r := range c.reports {
+        if !r.Start.Before(since) && tagset.Match(r.Tags, filter) {
+            out = append(out, r)
+        }
+    }
+    return out
+}
+
+// Run closes windows on time even when nothing is recorded, until ctx is cancelled
+func (c *Collector) Run(ctx context.Context) {
+    ticker := time.NewTicker(time.Minute)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            c.mu.Lock()
+            c.rollLocked(c.clock.Now())
+            c.mu.Unlock()
+        }
+    }
+}
+
+// rollLocked closes the current window once now is past it: its aggregates become reports
+// and are discarded, along with sessions open longer than maxSession
+func (c *Collector) rollLocked(now time.Time) {
+    if c.start.IsZero() {
+        c.start = now.Truncate(c.window)
+        return
+    }
+    end := c.start.Add(c.window)
+    if now.Before(end) {
+        return
+    }
+    keys := make([]string, 0, len(c.current))
+    for key := range c.current {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    for _, key := range keys {
+        if r, ok := c.release(c.current[key]); ok {
+            r.Start, r.End = c.start, end
+            c.reports = append(c.reports, r)
+        }
+    }
+    if c.retention > 0 && len(c.reports) > c.retention {
+        c.reports = append([]Report(nil), c.reports[len(c.reports)-c.retention:]...)
+    }
+    dropped := 0
+    for id, s := range c.sessions {
+        if now.Sub(s.start) > c.maxSession {
+            delete(c.sessions, id)
+            dropped++
+        }
+    }
+    if dropped > 0 {
+        log.Printf("analytics: dropped %d sessions open longer than %s", dropped, c.maxSession)
+    }
+    c.current = make(map[string]*aggregate)
+    c.start = now.Truncate(c.window)
+}
+
+// release turns an aggregate into a report, withholding every cell under k participants;
+// ok is false when nothing could be released
+func (c *Collector) release(a *aggregate) (Report, bool) {
+    r := Report{Tenant: a.tenant, Tags: a.tags}
+    released := false
+    if n := len(a.joins.subjects); n > 0 && n >= c.k {
+        r.Rooms = c.noisy(len(a.rooms))
+        r.Participants = c.noisy(len(a.joins.subjects))
+        r.Joins = c.noisy(a.joins.count)
+        released = true
+    } else if a.joins.count > 0 {
+        r.Withheld++
+    }
+    for i, d := range a.durations {
+        if d.count == 0 {
+            continue
+        }
+        if len(d.subjects) < c.k {
+            r.Withheld++
+            continue
+        }
+        max := "+Inf"
+        if i < len(DurationBuckets) {
+            max = DurationBuckets[i].String()
+        }
+        r.Durations = append(r.Durations, Bucket{Max: max, Count: c.noisy(d.count)})
+        released = true
+    }
+    for reason, f := range a.failures {
+        if len(f.subjects) < c.k {
+            r.Withheld++
+            continue
+        }
+        if r.Failures == nil {
+            r.Failures = make(map[string]int)
+        }
+        r.Failures[reason] = c.noisy(f.count)
+        released = true
+    }
+    return r, released
+}
+
+// noisy adds Laplace noise to n when SetNoise is on, never going below zero
+func (c *Collector) noisy(n int) int {
+    if c.epsilon <= 0 {
+        return n
+    }
+    var b [8]byte
+    if _, err := rand.Read(b[:]); err != nil {
+        panic("analytics: reading random noise: " + err.Error())
+    }
+    // u is uniform in (-0.5, 0.5); the inverse CDF of the Laplace distribution maps it
+    u := (float64(binary.BigEndian.Uint64(b[:])>>11)+0.5)/(1<<53) - 0.5
+    noise := -math.Copysign(1, u) * math.Log(1-2*math.Abs(u)) / c.epsilon
+    if v := int(math.Round(float64(n) + noise)); v > 0 {
+        return v
+    }
+    return 0
+}
@@ -0,0 +1,320 @@
+// Package anomaly watches token issuance and verification for patterns that suggest a
+// stolen or shared credential: one token used from many addresses, bursts of issuance for
+// one identity, or an identity turning up somewhere it could not have travelled to. Detectors
+// turn events into findings; a Monitor runs them and takes the action configured for each
+// rule, alerting, requiring a step-up or revoking. Heuristics is built in, and Remote hands
+// events to an external scoring service
+package anomaly
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "log"
+    "net/netip"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+)
+
+// Event kinds
+const (
+    KindIssued   = "issued"
+    KindVerified = "verified"
+)
+
+// Action is what a Monitor does about a finding. Every finding is alerted; ActionStepUp and
+// ActionRevoke do so as well as their own work
+type Action string
+
+const (
+    ActionAlert  Action = "alert"
+    ActionStepUp Action = "step-up"
+    ActionRevoke Action = "revoke"
+)
+
+const (
+    // DefaultStepUpTTL is how long a flagged identity must step up to join
+    DefaultStepUpTTL = time.Hour
+    // DefaultCooldown is how long a rule stays quiet about a subject after firing
+    DefaultCooldown = 10 * time.Minute
+)
+
+// maxRecent bounds the findings Recent returns
+const maxRecent = 256
+
+// maxFirings is how many cooldowns are tracked before expired ones are swept
+const maxFirings = 4096
+
+// Location is where a client address is, as far as a geo-IP database or the edge can tell
+type Location struct {
+    Latitude  float64 `json:"latitude"`
+    Longitude float64 `json:"longitude"`
+    Country   string  `json:"country,omitempty"`
+}
+
+// Event is one token issued or verified
+type Event struct {
+    Kind     string `json:"kind"`
+    Tenant   string `json:"tenant,omitempty"`
+    Identity string `json:"identity"`
+    Room     string `json:"room,omitempty"`
+    TokenID  string `json:"tokenId,omitempty"`
+    APIKey   string `json:"apiKey,omitempty"`
+    // RemoteIP is the client address, unset for issuance by a tenant's backend
+    RemoteIP netip.Addr `json:"remoteIP"`
+    // Location is where the edge placed the client, if it knows
+    Location *Location `json:"location,omitempty"`
+    At       time.Time `json:"at"`
+}
+
+// Finding is one pattern a detector matched
+type Finding struct {
+    Rule     string `json:"rule"`
+    Tenant   string `json:"tenant,omitempty"`
+    Identity string `json:"identity"`
+    TokenID  string `json:"tokenId,omitempty"`
+    Detail   string `json:"detail,omitempty"`
+    // Action may be set by a detector sure of what should happen; otherwise the Monitor's
+    // configured action for Rule is taken
+    Action Action    `json:"action,omitempty"`
+    At     time.Time `json:"at"`
+}
+
+// Detector turns events into findings. It is called on the request path, so a slow one
+// delays issuance and admission
+type Detector interface {
+    Observe(ctx context.Context, e Event) ([]Finding, error)
+}
+
+// DetectorFunc adapts a function to Detector
+type DetectorFunc func(ctx context.Context, e Event) ([]Finding, error)
+
+// Observe calls f
+func (f DetectorFunc) Observe(ctx context.Context, e Event) ([]Finding, error) {
+    return f(ctx, e)
+}
+
+// Hook carries out an action for a finding, e.g. paging someone or revoking tokens
+type Hook func(ctx context.Context, f Finding) error
+
+type subject struct {
+    tenant, identity string
+}
+
+type firing struct {
+    rule, tenant, identity, tokenID string
+}
+
+// Monitor runs detectors over events and acts on what they find
+type Monitor struct {
+    detectors []Detector
+    clock     clock.Clock
+    alert     Hook
+    revoke    Hook
+    stepUpTTL time.Duration
+    cooldown  time.Duration
+
+    mu       sync.Mutex
+    actions  map[string]Action
+    stepUp   map[subject]time.Time
+    fired    map[firing]time.Time
+    recent   []Finding
+    counts   map[[2]string]int64
+    failures int64
+}
+
+// NewMonitor runs detectors, alerting on every finding until SetAction says otherwise
+func NewMonitor(detectors ...Detector) *Monitor {
+    return &Monitor{
+        detectors: detectors,
+        clock:     clock.System,
+        stepUpTTL: DefaultStepUpTTL,
+        cooldown:  DefaultCooldown,
+        actions:   make(map[string]Action),
+        stepUp:    make(map[subject]time.Time),
+        fired:     make(map[firing]time.Time),
+        counts:    make(map[[2]string]int64),
+    }
+}
+
+// SetAction sets what is done about rule's findings
+func (m *Monitor) SetAction(rule string, a Action) *Monitor {
+    m.mu.Lock()
+    m.actions[rule] = a
+    m.mu.Unlock()
+    return m
+}
+
+// SetAlertHook sets the hook run for every finding
+func (m *Monitor) SetAlertHook(h Hook) *Monitor {
+    m.alert = h
+    return m
+}
+
+// SetRevokeHook sets the hook run for findings whose action is ActionRevoke; without one
+// they are only alerted
+func (m *Monitor) SetRevokeHook(h Hook) *Monitor {
+    m.revoke = h
+    return m
+}
+
+// SetStepUpTTL sets how long ActionStepUp flags an identity
+func (m *Monitor) SetStepUpTTL(d time.Duration) *Monitor {
+    m.stepUpTTL = d
+    return m
+}
+
+// SetCooldown sets how long a rule stays quiet about the same subject after firing
+func (m *Monitor) SetCooldown(d time.Duration) *Monitor {
+    m.cooldown = d
+    return m
+}
+
+// SetClock sets the time source for events, flags and cooldowns
+func (m *Monitor) SetClock(c clock.Clock) *Monitor {
+    m.clock = c
+    return m
+}
+
+// Observe runs every detector over e and acts on the findings, returning those acted on.
+// A failing detector is logged and skipped, so detection never blocks a request
+func (m *Monitor) Observe(ctx context.Context, e Event) []Finding {
+    now := m.clock.Now()
+    if e.At.IsZero() {
+        e.At = now
+    }
+    var acted []Finding
+    for _, d := range m.detectors {
+        findings, err := d.Observe(ctx, e)
+        if err != nil {
+            m.mu.Lock()
+            m.failures++
+            m.mu.Unlock()
+            log.Printf("anomaly: detector failed: %v", err)
+            continue
+        }
+        for _, f := range findings {
+            if f.At.IsZero() {
+                f.At = now
+            }
+            if m.take(&f, now) {
+                m.act(ctx, f)
+                acted = append(acted, f)
+            }
+        }
+    }
+    return acted
+}
+
+// take settles f's action and records it, unless its rule fired for the subject within the cooldown
+func (m *Monitor) take(f *Finding, now time.Time) bool {
+    key := firing{f.Rule, f.Tenant, f.Identity, f.TokenID}
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if at, ok := m.fired[key]; ok && now.Sub(at) < m.cooldown {
+        return false
+    }
+    m.fired[key] = now
+    if len(m.fired) > maxFirings {
+        for k, at := range m.fired {
+            if now.Sub(at) >= m.cooldown {
+                delete(m.fired, k)
+            }
+        }
+        for k, until := range m.stepUp {
+            if !now.Before(until) {
+                delete(m.stepUp, k)
+            }
+        }
+    }
+    if f.Action == "" {
+        f.Action = m.actions[f.Rule]
+    }
+    if f.Action == "" {
+        f.Action = ActionAlert
+    }
+    if f.Action == ActionStepUp {
+        m.stepUp[subject{f.Tenant, f.Identity}] = now.Add(m.stepUpTTL)
+    }
+    m.recent = append(m.recent, *f)
+    if len(m.recent) > maxRecent {
+        m.recent = append([]Finding(nil), m.recent[len(m.recent)-maxRecent:]...)
+    }
+    m.counts[[2]string{f.Rule, string(f.Action)}]++
+    return true
+}
+
+func (m *Monitor) act(ctx context.Context, f Finding) {
+    if m.alert != nil {
+        if err := m.alert(ctx, f); err != nil {
+            log.Printf("anomaly: alert for %s failed: %v", f.Rule, err)
+        }
+    }
+    if f.Action == ActionRevoke && m.revoke != nil {
+        if err := m.revoke(ctx, f); err != nil {
+            log.Printf("anomaly: revocation for %s failed: %v", f.Rule, err)
+        }
+    }
+}
+
+// StepUpRequired reports whether identity was flagged with ActionStepUp and must prove
+// itself before joining
+func (m *Monitor) StepUpRequired(tenant, identity string) bool {
+    key := subject{tenant, identity}
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    until, ok := m.stepUp[key]
+    if ok && !m.clock.Now().Before(until) {
+        delete(m.stepUp, key)
+        return false
+    }
+    return ok
+}
+
+// Recent returns the latest findings acted on, newest first
+func (m *Monitor) Recent() []Finding {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    out := make([]Finding, len(m.recent))
+    for i, f := range m.recent {
+        out[len(out)-1-i] = f
+    }
+    return out
+}
+
+// WriteMetrics writes the monitor's counters in the Prometheus text format
+func (m *Monitor) WriteMetrics(w io.Writer) error {
+    m.mu.Lock()
+    keys := make([][2]string, 0, len(m.counts))
+    for k := range m.counts {
+        keys = append(keys, k)
+    }
+    sort.Slice(keys, func(i, j int) bool {
+        if keys[i][0] != keys[j][0] {
+            return keys[i][0] < keys[j][0]
+        }
+        return keys[i][1] < keys[j][1]
+    })
+    counts := make([]int64, len(keys))
+    for i, k := range keys {
+        counts[i] = m.counts[k]
+    }
+    flagged, failures := len(m.stepUp), m.failures
+    m.mu.Unlock()
+
+    metric := func(name, kind, help string) {
+        fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+    }
+    metric("volly_anomaly_findings_total", "counter", "Anomalies acted on by rule and action.")
+    for i, k := range keys {
+        fmt.Fprintf(w, "volly_anomaly_findings_total{rule=%q,action=%q} %d\n", k[0], k[1], counts[i])
+    }
+    metric("volly_anomaly_step_up_identities", "gauge", "Identities flagged to step up before joining.")
+    fmt.Fprintf(w, "volly_anomaly_step_up_identities %d\n", flagged)
+    metric("volly_anomaly_detector_failures_total", "counter", "Detector calls that failed and were skipped.")
+    _, err := fmt.Fprintf(w, "volly_anomaly_detector_failures_total %d\n", failures)
+    return err
+}
@@ -0,0 +1,264 @@
+package anomaly
+
+import (
+    "context"
+    "fmt"
+    "math"
+    "net/netip"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+)
+
+// Rules Heuristics reports
+const (
+    RuleSharedToken      = "shared-token"
+    RuleIssuanceBurst    = "issuance-burst"
+    RuleImpossibleTravel = "impossible-travel"
+)
+
+const (
+    DefaultSharedTokenAddresses = 3
+    DefaultSharedTokenWindow    = 10 * time.Minute
+    DefaultIssuanceBurst        = 30
+    DefaultIssuanceWindow       = time.Minute
+    // DefaultMaxSpeed is a little faster than an airliner, in km/h
+    DefaultMaxSpeed = 1000
+    // DefaultMinDistance ignores jumps geo-IP databases make between nearby cities, in km
+    DefaultMinDistance = 500
+)
+
+// sweepEvery is how many events Heuristics sees between sweeps of forgotten subjects
+const sweepEvery = 1024
+
+// earthRadius is the mean radius of the Earth in km
+const earthRadius = 6371
+
+// Locator places a client address, typically from a geo-IP database
+type Locator interface {
+    Locate(ctx context.Context, ip netip.Addr) (*Location, error)
+}
+
+type sighting struct {
+    ip netip.Addr
+    at time.Time
+}
+
+type placement struct {
+    loc Location
+    at  time.Time
+}
+
+// Heuristics is the built-in Detector. It flags a token verified from more distinct
+// addresses than allowed within a window, an identity issued more tokens than allowed
+// within a window, and an identity verified at two places further apart than it could
+// have travelled between. Travel is only checked for events with a Location or when a
+// Locator is set
+type Heuristics struct {
+    sharedAddresses int
+    sharedWindow    time.Duration
+    burst           int
+    burstWindow     time.Duration
+    maxSpeed        float64
+    minDistance     float64
+    locator         Locator
+    clock           clock.Clock
+
+    mu     sync.Mutex
+    seen   map[string][]sighting
+    issued map[subject][]time.Time
+    placed map[subject]placement
+    events int
+}
+
+// NewHeuristics creates the detector with the default thresholds
+func NewHeuristics() *Heuristics {
+    return &Heuristics{
+        sharedAddresses: DefaultSharedTokenAddresses,
+        sharedWindow:    DefaultSharedTokenWindow,
+        burst:           DefaultIssuanceBurst,
+        burstWindow:     DefaultIssuanceWindow,
+        maxSpeed:        DefaultMaxSpeed,
+        minDistance:     DefaultMinDistance,
+        clock:           clock.System,
+        seen:            make(map[string][]sighting),
+        issued:          make(map[subject][]time.Time),
+        placed:          make(map[subject]placement),
+    }
+}
+
+// SetSharedToken flags a token seen from more than addresses distinct addresses within
+// window; zero addresses turns the rule off
+func (h *Heuristics) SetSharedToken(addresses int, window time.Duration) *Heuristics {
+    h.sharedAddresses, h.sharedWindow = addresses, window
+    return h
+}
+
+// SetIssuanceBurst flags an identity issued more than n tokens within window; zero turns
+// the rule off
+func (h *Heuristics) SetIssuanceBurst(n int, window time.Duration) *Heuristics {
+    h.burst, h.burstWindow = n, window
+    return h
+}
+
+// SetImpossibleTravel flags moves faster than maxSpeed km/h over more than minDistance km;
+// a zero maxSpeed turns the rule off
+func (h *Heuristics) SetImpossibleTravel(maxSpeed, minDistance float64) *Heuristics {
+    h.maxSpeed, h.minDistance = maxSpeed, minDistance
+    return h
+}
+
+// SetLocator places events that arrive without a Location
+func (h *Heuristics) SetLocator(l Locator) *Heuristics {
+    h.locator = l
+    return h
+}
+
+// SetClock sets the time source for events without a time
+func (h *Heuristics) SetClock(c clock.Clock) *Heuristics {
+    h.clock = c
+    return h
+}
+
+func (h *Heuristics) Observe(ctx context.Context, e Event) ([]Finding, error) {
+    if e.At.IsZero() {
+        e.At = h.clock.Now()
+    }
+    key := subject{e.Tenant, e.Identity}
+    // The locator may be remote, so it is asked before taking the lock
+    loc := e.Location
+    if loc == nil && e.Kind == KindVerified && h.maxSpeed > 0 && h.locator != nil && e.RemoteIP.IsValid() {
+        var err error
+        if loc, err = h.locator.Locate(ctx, e.RemoteIP); err != nil {
+            return nil, err
+        }
+    }
+
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    if h.events++; h.events%sweepEvery == 0 {
+        h.sweep(e.At)
+    }
+    var out []Finding
+    switch e.Kind {
+    case KindIssued:
+        if f := h.issuance(key, e); f != nil {
+            out = append(out, *f)
+        }
+    case KindVerified:
+        if f := h.sharedToken(e); f != nil {
+            out = append(out, *f)
+        }
+        if loc != nil && h.maxSpeed > 0 {
+            if f := h.travel(key, e, *loc); f != nil {
+                out = append(out, *f)
+            }
+        }
+    }
+    return out, nil
+}
+
+// issuance callers hold h.mu
+func (h *Heuristics) issuance(key subject, e Event) *Finding {
+    if h.burst <= 0 {
+        return nil
+    }
+    times := append(since(h.issued[key], e.At, h.burstWindow), e.At)
+    h.issued[key] = times
+    if len(times) <= h.burst {
+        return nil
+    }
+    return &Finding{Rule: RuleIssuanceBurst, Tenant: e.Tenant, Identity: e.Identity,
+        Detail: fmt.Sprintf("%d tokens issued within %s", len(times), h.burstWindow)}
+}
+
+// sharedToken callers hold h.mu
+func (h *Heuristics) sharedToken(e Event) *Finding {
+    if h.sharedAddresses <= 0 || e.TokenID == "" || !e.RemoteIP.IsValid() {
+        return nil
+    }
+    var kept []sighting
+    distinct := map[netip.Addr]bool{e.RemoteIP: true}
+    for _, s := range h.seen[e.TokenID] {
+        if e.At.Sub(s.at) < h.sharedWindow && s.ip != e.RemoteIP {
+            kept = append(kept, s)
+            distinct[s.ip] = true
+        }
+    }
+    h.seen[e.TokenID] = append(kept, sighting{ip: e.RemoteIP, at: e.At})
+    if len(distinct) <= h.sharedAddresses {
+        return nil
+    }
+    return &Finding{Rule: RuleSharedToken, Tenant: e.Tenant, Identity: e.Identity, TokenID: e.TokenID,
+        Detail: fmt.Sprintf("token used from %d addresses within %s", len(distinct), h.sharedWindow)}
+}
+
+// travel callers hold h.mu
+func (h *Heuristics) travel(key subject, e Event, loc Location) *Finding {
+    last, ok := h.placed[key]
+    h.placed[key] = placement{loc: loc, at: e.At}
+    if !ok {
+        return nil
+    }
+    km := distance(last.loc, loc)
+    if km <= h.minDistance {
+        return nil
+    }
+    hours := e.At.Sub(last.at).Hours()
+    if hours > 0 && km/hours <= h.maxSpeed {
+        return nil
+    }
+    from, to := last.loc.Country, loc.Country
+    if from == "" {
+        from = "?"
+    }
+    if to == "" {
+        to = "?"
+    }
+    return &Finding{Rule: RuleImpossibleTravel, Tenant: e.Tenant, Identity: e.Identity, TokenID: e.TokenID,
+        Detail: fmt.Sprintf("%.0f km from %s to %s in %s", km, from, to, e.At.Sub(last.at).Round(time.Second))}
+}
+
+// sweep forgets subjects with nothing left inside their windows; callers hold h.mu
+func (h *Heuristics) sweep(now time.Time) {
+    for id, sightings := range h.seen {
+        if len(sightings) == 0 || now.Sub(sightings[len(sightings)-1].at) >= h.sharedWindow {
+            delete(h.seen, id)
+        }
+    }
+    for key, times := range h.issued {
+        if len(since(times, now, h.burstWindow)) == 0 {
+            delete(h.issued, key)
+        }
+    }
+    // A placement older than a trip round the world at maxSpeed can no longer flag anything
+    horizon := 24 * time.Hour
+    if h.maxSpeed > 0 {
+        horizon = time.Duration(math.Pi * earthRadius / h.maxSpeed * float64(time.Hour))
+    }
+    for key, p := range h.placed {
+        if now.Sub(p.at) >= horizon {
+            delete(h.placed, key)
+        }
+    }
+}
+
+// since returns the times in window before now, which are in order
+func since(times []time.Time, now time.Time, window time.Duration) []time.Time {
+    i := 0
+    for i < len(times) && now.Sub(times[i]) >= window {
+        i++
+    }
+    return times[i:]
+}
+
+// distance is the great-circle distance between a and b in km
+func distance(a, b Location) float64 {
+    rad := math.Pi / 180
+    dLat := (b.Latitude - a.Latitude) * rad
+    dLon := (b.Longitude - a.Longitude) * rad
+    s := math.Sin(dLat/2)*math.Sin(dLat/2) +
+        math.Cos(a.Latitude*rad)*math.Cos(b.Latitude*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+    return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(s)))
+}
@@ -0,0 +1,85 @@
+package anomaly
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "time"
+)
+
+// DefaultRemoteTimeout bounds one call to a remote detector, which sits on the request path
+const DefaultRemoteTimeout = 500 * time.Millisecond
+
+// Remote is a Detector backed by an external scoring service, such as a model trained on a
+// deployment's own traffic. Each event is POSTed as JSON, and the service answers 200 with
+// {"findings": [...]}, empty when nothing is wrong. Findings may name their own action
+type Remote struct {
+    url     string
+    token   string
+    timeout time.Duration
+    client  *http.Client
+}
+
+// NewRemote sends events to url
+func NewRemote(url string) *Remote {
+    return &Remote{url: url, timeout: DefaultRemoteTimeout, client: &http.Client{}}
+}
+
+// SetToken sends token as a bearer credential
+func (r *Remote) SetToken(token string) *Remote {
+    r.token = token
+    return r
+}
+
+// SetTimeout sets how long one event may take
+func (r *Remote) SetTimeout(d time.Duration) *Remote {
+    r.timeout = d
+    return r
+}
+
+// SetHTTPClient replaces the client used to call the service
+func (r *Remote) SetHTTPClient(client *http.Client) *Remote {
+    r.client = client
+    return r
+}
+
+func (r *Remote) Observe(ctx context.Context, e Event) ([]Finding, error) {
+    body, err := json.Marshal(e)
+    if err != nil {
+        return nil, err
+    }
+    ctx, cancel := context.WithTimeout(ctx, r.timeout)
+    defer cancel()
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if r.token != "" {
+        req.Header.Set("Authorization", "Bearer "+r.token)
+    }
+    resp, err := r.client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("anomaly service: %s", resp.Status)
+    }
+    var out struct {
+        Findings []Finding `json:"findings"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+        return nil, fmt.Errorf("anomaly service: %w", err)
+    }
+    // The service speaks for the event it was given, whatever it echoes back
+    for i := range out.Findings {
+        out.Findings[i].Tenant, out.Findings[i].Identity = e.Tenant, e.Identity
+        if out.Findings[i].Rule == "" {
+            out.Findings[i].Rule = "remote"
+        }
+    }
+    return out.Findings, nil
+}
@@ -0,0 +1,264 @@
+package audit
+
+import (
+    "bytes"
+    "context"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+    "github.com/volly-org/volly-signaling/pkg/volly/transparency"
+)
+
+// anchorContext domain-separates anchor signatures
+const anchorContext = "volly-audit-anchor-v1"
+
+// DefaultAnchorInterval is how often Run anchors the chain head
+const DefaultAnchorInterval = 10 * time.Minute
+
+var ErrNotChained = errors.New("audit log is not hash-chained")
+
+// Anchor commits the log to its head: the entry with sequence number Seq hashes to Head.
+// Rewriting anything up to Seq, or deleting entries up to and past it, then needs the
+// signing key
+type Anchor struct {
+    Seq       uint64 `json:"seq"`
+    Head      []byte `json:"head"`
+    Timestamp int64  `json:"timestamp"`
+    Algorithm string `json:"algorithm"`
+    Signature []byte `json:"signature"`
+}
+
+// signedMessage returns the bytes covered by the anchor signature
+func (a *Anchor) signedMessage() []byte {
+    msg := make([]byte, 0, len(anchorContext)+16+len(a.Head))
+    msg = append(msg, anchorContext...)
+    msg = binary.BigEndian.AppendUint64(msg, a.Seq)
+    msg = binary.BigEndian.AppendUint64(msg, uint64(a.Timestamp))
+    return append(msg, a.Head...)
+}
+
+// Verify checks the anchor signature against the anchoring public key
+func (a *Anchor) Verify(publicKey []byte) error {
+    return crypto.VerifySignature(a.Algorithm, publicKey, a.signedMessage(), a.Signature)
+}
+
+// AnchorStore keeps the anchors. Anchors only help if they outlive whoever tampers with the
+// log, so a store beside the log should be paired with a Publisher
+type AnchorStore interface {
+    PutAnchor(ctx context.Context, a Anchor) error
+    // ListAnchors returns every anchor, oldest first
+    ListAnchors(ctx context.Context) ([]Anchor, error)
+}
+
+// MemoryAnchorStore is an in-process AnchorStore
+type MemoryAnchorStore struct {
+    mu      sync.Mutex
+    anchors []Anchor
+}
+
+// NewMemoryAnchorStore creates an empty anchor store
+func NewMemoryAnchorStore() *MemoryAnchorStore {
+    return &MemoryAnchorStore{}
+}
+
+func (m *MemoryAnchorStore) PutAnchor(ctx context.Context, a Anchor) error {
+    m.mu.Lock()
+    m.anchors = append(m.anchors, a)
+    m.mu.Unlock()
+    return nil
+}
+
+func (m *MemoryAnchorStore) ListAnchors(ctx context.Context) ([]Anchor, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return append([]Anchor(nil), m.anchors...), nil
+}
+
+// Publisher hands anchors to somewhere the log's operator cannot rewrite
+type Publisher interface {
+    Publish(ctx context.Context, a Anchor) error
+}
+
+// PublisherFunc adapts a function to Publisher
+type PublisherFunc func(ctx context.Context, a Anchor) error
+
+// Publish calls f
+func (f PublisherFunc) Publish(ctx context.Context, a Anchor) error {
+    return f(ctx, a)
+}
+
+// KeyLogPublisher appends every anchor to a key transparency log as a binding for
+// identity, carrying the anchor's JSON in place of a public key. Monitors of the log then
+// see every head the audit log committed to, and the Merkle tree stops them being dropped
+func KeyLogPublisher(l *transparency.KeyLog, identity string) Publisher {
+    return PublisherFunc(func(ctx context.Context, a Anchor) error {
+        data, err := json.Marshal(a)
+        if err != nil {
+            return err
+        }
+        _, err = l.Append(transparency.KeyBinding{Identity: identity, Algorithm: a.Algorithm, PublicKey: data, Timestamp: a.Timestamp})
+        return err
+    })
+}
+
+// Anchorer periodically signs the head of a chained log
+type Anchorer struct {
+    log       Chained
+    signer    crypto.Signer
+    store     AnchorStore
+    publisher Publisher
+    interval  time.Duration
+    clock     clock.Clock
+
+    mu       sync.Mutex
+    last     *Anchor
+    anchors  int64
+    failures int64
+}
+
+// NewAnchorer anchors log, which must be Chained, with signer into store
+func NewAnchorer(l Log, signer crypto.Signer, store AnchorStore) (*Anchorer, error) {
+    chained, ok := l.(Chained)
+    if !ok {
+        return nil, ErrNotChained
+    }
+    return &Anchorer{log: chained, signer: signer, store: store, interval: DefaultAnchorInterval, clock: clock.System}, nil
+}
+
+// SetInterval sets how often Run anchors
+func (a *Anchorer) SetInterval(d time.Duration) *Anchorer {
+    a.interval = d
+    return a
+}
+
+// SetPublisher also publishes every anchor to p
+func (a *Anchorer) SetPublisher(p Publisher) *Anchorer {
+    a.publisher = p
+    return a
+}
+
+// SetClock sets the time source for anchor timestamps
+func (a *Anchorer) SetClock(c clock.Clock) *Anchorer {
+    a.clock = c
+    return a
+}
+
+// Algorithm is the algorithm anchors are signed with
+func (a *Anchorer) Algorithm() string { return a.signer.Algorithm() }
+
+// PublicKey is the key anchors verify against
+func (a *Anchorer) PublicKey() []byte { return a.signer.PublicKey() }
+
+// Anchor signs the current head, unless it is already the latest anchor or the log is
+// empty, and returns the latest anchor, nil when there is none. An anchor that fails to
+// publish is still stored, and returned with the error
+func (a *Anchorer) Anchor(ctx context.Context) (*Anchor, error) {
+    head, err := a.log.Head(ctx)
+    if err != nil {
+        return a.fail(err)
+    }
+    a.mu.Lock()
+    last := a.last
+    a.mu.Unlock()
+    if head.Seq == 0 || last != nil && last.Seq == head.Seq && bytes.Equal(last.Head, head.Hash) {
+        return last, nil
+    }
+    anchor := &Anchor{Seq: head.Seq, Head: head.Hash, Timestamp: a.clock.Now().Unix(), Algorithm: a.signer.Algorithm()}
+    if anchor.Signature, err = a.signer.Sign(anchor.signedMessage()); err != nil {
+        return a.fail(err)
+    }
+    if err := a.store.PutAnchor(ctx, *anchor); err != nil {
+        return a.fail(err)
+    }
+    a.mu.Lock()
+    a.last = anchor
+    a.anchors++
+    a.mu.Unlock()
+    if a.publisher != nil {
+        if err := a.publisher.Publish(ctx, *anchor); err != nil {
+            _, err = a.fail(fmt.Errorf("publish: %w", err))
+            return anchor, err
+        }
+    }
+    return anchor, nil
+}
+
+func (a *Anchorer) fail(err error) (*Anchor, error) {
+    a.mu.Lock()
+    a.failures++
+    a.mu.Unlock()
+    return nil, err
+}
+
+// Anchors returns the stored anchors, oldest first
+func (a *Anchorer) Anchors(ctx context.Context) ([]Anchor, error) {
+    anchors, err := a.store.ListAnchors(ctx)
+    if err != nil {
+        return nil, err
+    }
+    sort.Slice(anchors, func(i, j int) bool { return anchors[i].Seq < anchors[j].Seq })
+    return anchors, nil
+}
+
+// Verify checks the whole log against the stored anchors
+func (a *Anchorer) Verify(ctx context.Context) (*Report, error) {
+    anchors, err := a.Anchors(ctx)
+    if err != nil {
+        return nil, err
+    }
+    return Verify(ctx, a.log, anchors, a.signer.Algorithm(), a.signer.PublicKey())
+}
+
+// Run anchors every interval until ctx is cancelled, and once more on the way out so the
+// last entries are covered
+func (a *Anchorer) Run(ctx context.Context) {
+    ticker := time.NewTicker(a.interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            if _, err := a.Anchor(context.WithoutCancel(ctx)); err != nil {
+                log.Printf("audit: anchor failed: %v", err)
+            }
+            return
+        case <-ticker.C:
+            if _, err := a.Anchor(ctx); err != nil {
+                log.Printf("audit: anchor failed: %v", err)
+            }
+        }
+    }
+}
+
+// WriteMetrics writes the anchorer's counters in the Prometheus text format
+func (a *Anchorer) WriteMetrics(w io.Writer) error {
+    a.mu.Lock()
+    var seq uint64
+    var at int64
+    if a.last != nil {
+        seq, at = a.last.Seq, a.last.Timestamp
+    }
+    anchors, failures := a.anchors, a.failures
+    a.mu.Unlock()
+
+    metric := func(name, kind, help string) {
+        fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+    }
+    metric("volly_audit_anchored_seq", "gauge", "Sequence number of the newest anchored audit entry.")
+    fmt.Fprintf(w, "volly_audit_anchored_seq %d\n", seq)
+    metric("volly_audit_anchor_timestamp_seconds", "gauge", "When the newest anchor was signed.")
+    fmt.Fprintf(w, "volly_audit_anchor_timestamp_seconds %d\n", at)
+    metric("volly_audit_anchors_total", "counter", "Anchors signed by this process.")
+    fmt.Fprintf(w, "volly_audit_anchors_total %d\n", anchors)
+    metric("volly_audit_anchor_failures_total", "counter", "Anchors that failed to sign, store or publish.")
+    _, err := fmt.Fprintf(w, "volly_audit_anchor_failures_total %d\n", failures)
+    return err
+}
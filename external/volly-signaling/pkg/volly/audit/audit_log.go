@@ -0,0 +1,234 @@
+package audit
+
+import (
+    "context"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/tagset"
+)
+
+// DefaultQueryLimit bounds Query when the caller sets no limit
+const DefaultQueryLimit = 100
+
+// Entry records one administrative action
+type Entry struct {
+    Time   time.Time         `json:"time"`
+    Actor  string            `json:"actor"`
+    Action string            `json:"action"`
+    Tenant string            `json:"tenant,omitempty"`
+    Target string            `json:"target,omitempty"`
+    Detail map[string]string `json:"detail,omitempty"`
+    // Tags are the cost-attribution tags of the token the action was taken with
+    Tags map[string]string `json:"tags,omitempty"`
+    // Seq, Prev and Hash chain the entry in a Chained log; the log sets them on Append
+    Seq  uint64 `json:"seq,omitempty"`
+    Prev []byte `json:"prev,omitempty"`
+    Hash []byte `json:"hash,omitempty"`
+    // Salts salt the digests Hash commits to, by field; tombstoning destroys a field's salt
+    Salts map[string][]byte `json:"salts,omitempty"`
+}
+
+// Query selects entries, newest first; empty fields match everything
+type Query struct {
+    Tenant string
+    Actor  string
+    Action string
+    Since  time.Time
+    Until  time.Time
+    // Tags selects the entries carrying every pair
+    Tags  map[string]string
+    Limit int
+}
+
+func (q Query) matches(e Entry) bool {
+    return (q.Tenant == "" || q.Tenant == e.Tenant) &&
+        tagset.Match(e.Tags, q.Tags) &&
+        (q.Actor == "" || q.Actor == e.Actor) &&
+        (q.Action == "" || q.Action == e.Action) &&
+        (q.Since.IsZero() || !e.Time.Before(q.Since)) &&
+        (q.Until.IsZero() || e.Time.Before(q.Until))
+}
+
+func (q Query) limit() int {
+    if q.Limit <= 0 {
+        return DefaultQueryLimit
+    }
+    return q.Limit
+}
+
+// Log is an append-only record of administrative actions
+type Log interface {
+    Append(ctx context.Context, e Entry) error
+    Query(ctx context.Context, q Query) ([]Entry, error)
+}
+
+// Tombstoner is implemented by logs that can pseudonymise a data subject. Entries stay, so
+// the record of what was done survives, but the identity is replaced wherever it is the
+// actor, the target or a detail value
+type Tombstoner interface {
+    Tombstone(ctx context.Context, tenant, identity, tombstone string) (int, error)
+}
+
+// TombstoneEntry replaces identity with tombstone in e and returns the digests of the
+// fields it replaced, as TombstoneRecord takes them; none when e did not name identity. The
+// salts of those fields are destroyed, so the digests no longer lead back to identity. The
+// detail and salt maps are copied, not changed, since entries share them with whoever
+// appended or read them
+func TombstoneEntry(e *Entry, identity, tombstone string) map[string][]byte {
+    fields := make(map[string][]byte)
+    if e.Actor == identity {
+        fields[fieldActor] = fieldDigest(e.Seq, fieldActor, identity, e.Salts[fieldActor])
+        e.Actor = tombstone
+    }
+    if e.Target == identity {
+        fields[fieldTarget] = fieldDigest(e.Seq, fieldTarget, identity, e.Salts[fieldTarget])
+        e.Target = tombstone
+    }
+    for _, v := range e.Detail {
+        if v == identity {
+            detail := make(map[string]string, len(e.Detail))
+            for k, v := range e.Detail {
+                if v == identity {
+                    fields[fieldDetail+k] = fieldDigest(e.Seq, fieldDetail+k, identity, e.Salts[fieldDetail+k])
+                    v = tombstone
+                }
+                detail[k] = v
+            }
+            e.Detail = detail
+            break
+        }
+    }
+    if len(fields) == 0 {
+        return nil
+    }
+    if e.Salts != nil {
+        salts := make(map[string][]byte, len(e.Salts))
+        for field, salt := range e.Salts {
+            if _, ok := fields[field]; !ok {
+                salts[field] = salt
+            }
+        }
+        e.Salts = salts
+    }
+    return fields
+}
+
+// MemoryLog is an in-process, Chained Log that keeps the most recent entries
+type MemoryLog struct {
+    mu      sync.RWMutex
+    entries []Entry
+    max     int
+    clock   clock.Clock
+}
+
+// NewMemoryLog keeps up to max entries, dropping the oldest
+func NewMemoryLog(max int) *MemoryLog {
+    return &MemoryLog{max: max, clock: clock.System}
+}
+
+// SetClock sets the time source for entries appended without a time
+func (l *MemoryLog) SetClock(c clock.Clock) *MemoryLog {
+    l.clock = c
+    return l
+}
+
+func (l *MemoryLog) Append(ctx context.Context, e Entry) error {
+    if e.Time.IsZero() {
+        e.Time = l.clock.Now()
+    }
+    l.mu.Lock()
+    l.append(e)
+    l.mu.Unlock()
+    return nil
+}
+
+// append chains e after the newest entry; l.mu must be held
+func (l *MemoryLog) append(e Entry) {
+    var head Entry
+    if len(l.entries) > 0 {
+        head = l.entries[len(l.entries)-1]
+    }
+    Link(&e, head)
+    l.entries = append(l.entries, e)
+    if l.max > 0 && len(l.entries) > l.max {
+        l.entries = append([]Entry(nil), l.entries[len(l.entries)-l.max:]...)
+    }
+}
+
+func (l *MemoryLog) Head(ctx context.Context) (Entry, error) {
+    l.mu.RLock()
+    defer l.mu.RUnlock()
+    if len(l.entries) == 0 {
+        return Entry{}, nil
+    }
+    return l.entries[len(l.entries)-1], nil
+}
+
+func (l *MemoryLog) Range(ctx context.Context, from uint64, limit int) ([]Entry, error) {
+    l.mu.RLock()
+    defer l.mu.RUnlock()
+    // Entries are held in sequence order
+    i := sort.Search(len(l.entries), func(i int) bool { return l.entries[i].Seq >= from })
+    end := len(l.entries)
+    if limit > 0 && end-i > limit {
+        end = i + limit
+    }
+    return append([]Entry(nil), l.entries[i:end]...), nil
+}
+
+// Tombstone replaces identity with tombstone in tenant's entries, returning how many
+// changed, and appends the TombstoneRecord naming them. Their hashes are left as they were,
+// so the chain still links
+func (l *MemoryLog) Tombstone(ctx context.Context, tenant, identity, tombstone string) (int, error) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    rewritten := make(map[uint64]map[string][]byte)
+    for i := range l.entries {
+        if l.entries[i].Tenant != tenant {
+            continue
+        }
+        if fields := TombstoneEntry(&l.entries[i], identity, tombstone); len(fields) > 0 {
+            rewritten[l.entries[i].Seq] = fields
+        }
+    }
+    if len(rewritten) > 0 {
+        record := TombstoneRecord(tenant, tombstone, rewritten)
+        record.Time = l.clock.Now()
+        l.append(record)
+    }
+    return len(rewritten), nil
+}
+
+// Prune drops, or with dryRun counts, the entries from before before. The chain then
+// verifies from the oldest entry kept
+func (l *MemoryLog) Prune(ctx context.Context, before time.Time, dryRun bool) (int, error) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    var kept []Entry
+    for _, e := range l.entries {
+        if !e.Time.Before(before) {
+            kept = append(kept, e)
+        }
+    }
+    n := len(l.entries) - len(kept)
+    if !dryRun && n > 0 {
+        l.entries = kept
+    }
+    return n, nil
+}
+
+func (l *MemoryLog) Query(ctx context.Context, q Query) ([]Entry, error) {
+    l.mu.RLock()
+    defer l.mu.RUnlock()
+
+    var out []Entry
+    for i := len(l.entries) - 1; i >= 0 && len(out) < q.limit(); i-- {
+        if q.matches(l.entries[i]) {
+            out = append(out, l.entries[i])
+        }
+    }
+    return out, nil
+}
@@ -0,0 +1,365 @@
+package audit
+
+import (
+    "bytes"
+    "context"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// chainContext domain-separates entry hashes. Version 2 commits to the actor, the target
+// and each detail value through a digest of its own, so an entry whose identity was
+// tombstoned still checks against its hash given the digests of the fields replaced
+const chainContext = "volly-audit-chain-v2"
+
+// legacyChainContext is the version 1 context, which hashed the fields themselves; entries
+// chained before version 2 still verify against it
+const legacyChainContext = "volly-audit-chain-v1"
+
+// fieldContext domain-separates field digests. Version 2 digests are salted with a random
+// salt per field that tombstoning destroys, so the digest a tombstone record keeps cannot be
+// matched against guessed identities; entries linked before salting keep version 1 digests
+const (
+    fieldContext       = "volly-audit-field-v2"
+    legacyFieldContext = "volly-audit-field-v1"
+)
+
+// saltSize is the size of a field salt
+const saltSize = 16
+
+// ActionTombstone is the action of the entry a Tombstoner appends naming, by sequence
+// number, the entries it rewrote and the digests of the fields it replaced in each. Those
+// entries no longer match their hashes, and Verify accepts them only when a later, intact
+// entry vouches for exactly the fields that now hold its tombstone
+const ActionTombstone = "audit.tombstone"
+
+// verifyPage is how many entries Verify reads at a time
+const verifyPage = 1000
+
+// Chained is implemented by logs that hash-chain their entries: every entry carries a
+// sequence number, the hash of the entry before it and its own hash, so an entry deleted
+// from or altered in the middle of the log breaks the chain
+type Chained interface {
+    // Head returns the newest entry, the zero Entry when none is chained yet
+    Head(ctx context.Context) (Entry, error)
+    // Range returns up to limit entries from sequence number from on, oldest first
+    Range(ctx context.Context, from uint64, limit int) ([]Entry, error)
+}
+
+type chainedEntry struct {
+    Seq    uint64            `json:"seq"`
+    Time   int64             `json:"time"`
+    Action string            `json:"action"`
+    Tenant string            `json:"tenant"`
+    Tags   map[string]string `json:"tags,omitempty"`
+    // Fields holds the digests of the identity-bearing fields, see fieldDigests
+    Fields map[string][]byte `json:"fields"`
+}
+
+// Identity-bearing fields, as named in field digests and tombstone records; detail values
+// are named fieldDetail followed by their key
+const (
+    fieldActor  = "actor"
+    fieldTarget = "target"
+    fieldDetail = "detail."
+)
+
+// fieldDigest commits to one identity-bearing field of the entry at seq under salt; a nil
+// salt gives the unsalted digest of version 1
+func fieldDigest(seq uint64, field, value string, salt []byte) []byte {
+    h := sha256.New()
+    if salt == nil {
+        h.Write([]byte(legacyFieldContext))
+        h.Write([]byte{0})
+    } else {
+        h.Write([]byte(fieldContext))
+        h.Write([]byte{0, saltSize})
+        h.Write(salt)
+    }
+    h.Write([]byte(strconv.FormatUint(seq, 10) + "\x00" + field + "\x00" + value))
+    return h.Sum(nil)
+}
+
+// fieldDigests digests e's actor, target and detail values as they are now
+func fieldDigests(e Entry) map[string][]byte {
+    fields := map[string][]byte{
+        fieldActor:  fieldDigest(e.Seq, fieldActor, e.Actor, e.Salts[fieldActor]),
+        fieldTarget: fieldDigest(e.Seq, fieldTarget, e.Target, e.Salts[fieldTarget]),
+    }
+    for k, v := range e.Detail {
+        fields[fieldDetail+k] = fieldDigest(e.Seq, fieldDetail+k, v, e.Salts[fieldDetail+k])
+    }
+    return fields
+}
+
+// newSalts draws a salt for each of e's identity-bearing fields
+func newSalts(e Entry) map[string][]byte {
+    names := []string{fieldActor, fieldTarget}
+    for k := range e.Detail {
+        names = append(names, fieldDetail+k)
+    }
+    buf := make([]byte, saltSize*len(names))
+    rand.Read(buf)
+    salts := make(map[string][]byte, len(names))
+    for i, name := range names {
+        salts[name] = buf[i*saltSize : (i+1)*saltSize : (i+1)*saltSize]
+    }
+    return salts
+}
+
+// fieldValue is e's current value of field
+func fieldValue(e Entry, field string) (string, bool) {
+    switch {
+    case field == fieldActor:
+        return e.Actor, true
+    case field == fieldTarget:
+        return e.Target, true
+    case strings.HasPrefix(field, fieldDetail):
+        v, ok := e.Detail[strings.TrimPrefix(field, fieldDetail)]
+        return v, ok
+    }
+    return "", false
+}
+
+func chainHash(prev []byte, e Entry, fields map[string][]byte) []byte {
+    // Map keys are sorted, so the encoding is deterministic
+    data, _ := json.Marshal(chainedEntry{Seq: e.Seq, Time: e.Time.UnixNano(), Action: e.Action,
+        Tenant: e.Tenant, Tags: e.Tags, Fields: fields})
+    h := sha256.New()
+    h.Write([]byte(chainContext))
+    h.Write(prev)
+    h.Write(data)
+    return h.Sum(nil)
+}
+
+// ChainHash hashes e, including its sequence number, after prev. Times are hashed as Unix
+// nanoseconds, so an entry read back from a store in another time zone still matches
+func ChainHash(prev []byte, e Entry) []byte {
+    return chainHash(prev, e, fieldDigests(e))
+}
+
+// legacyChainHash is ChainHash as version 1 computed it
+func legacyChainHash(prev []byte, e Entry) []byte {
+    data, _ := json.Marshal(struct {
+        Seq    uint64            `json:"seq"`
+        Time   int64             `json:"time"`
+        Actor  string            `json:"actor"`
+        Action string            `json:"action"`
+        Tenant string            `json:"tenant"`
+        Target string            `json:"target"`
+        Detail map[string]string `json:"detail,omitempty"`
+        Tags   map[string]string `json:"tags,omitempty"`
+    }{e.Seq, e.Time.UnixNano(), e.Actor, e.Action, e.Tenant, e.Target, e.Detail, e.Tags})
+    h := sha256.New()
+    h.Write([]byte(legacyChainContext))
+    h.Write(prev)
+    h.Write(data)
+    return h.Sum(nil)
+}
+
+// Link chains e after head, the log's newest entry or the zero Entry, salting its fields
+func Link(e *Entry, head Entry) {
+    e.Seq = head.Seq + 1
+    e.Prev = head.Hash
+    e.Salts = newSalts(*e)
+    e.Hash = ChainHash(e.Prev, *e)
+}
+
+// TombstoneRecord is the entry a Tombstoner appends after replacing an identity with
+// tombstone in tenant's entries; rewritten maps each entry's sequence number to the digests
+// of the fields replaced in it, as TombstoneEntry returns them. The record keeps only those
+// digests, whose salts TombstoneEntry destroyed, never the identity itself
+func TombstoneRecord(tenant, tombstone string, rewritten map[uint64]map[string][]byte) Entry {
+    seqs := make([]uint64, 0, len(rewritten))
+    digests := make(map[string]map[string][]byte, len(rewritten))
+    for seq, fields := range rewritten {
+        seqs = append(seqs, seq)
+        digests[strconv.FormatUint(seq, 10)] = fields
+    }
+    sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+    parts := make([]string, len(seqs))
+    for i, seq := range seqs {
+        parts[i] = strconv.FormatUint(seq, 10)
+    }
+    encoded, _ := json.Marshal(digests)
+    return Entry{Actor: "volly", Action: ActionTombstone, Tenant: tenant, Target: tombstone,
+        Detail: map[string]string{"seqs": strings.Join(parts, ","), "fields": string(encoded)}}
+}
+
+// tombstoneClaim is what one intact tombstone record says about one rewritten entry
+type tombstoneClaim struct {
+    tenant, tombstone string
+    fields            map[string][]byte
+}
+
+// tombstoneClaims reads the claims record makes about the entries before it
+func tombstoneClaims(record Entry) map[uint64]tombstoneClaim {
+    var digests map[string]map[string][]byte
+    if json.Unmarshal([]byte(record.Detail["fields"]), &digests) != nil {
+        return nil
+    }
+    claims := make(map[uint64]tombstoneClaim)
+    for _, s := range strings.Split(record.Detail["seqs"], ",") {
+        seq, err := strconv.ParseUint(s, 10, 64)
+        if err != nil || seq >= record.Seq || len(digests[s]) == 0 {
+            continue
+        }
+        claims[seq] = tombstoneClaim{tenant: record.Tenant, tombstone: record.Target, fields: digests[s]}
+    }
+    return claims
+}
+
+// tombstoned reports whether altered e is fully explained by claims, oldest first: every
+// claim is for e's tenant, every field claimed holds the tombstone of the last claim on it,
+// and with the digests of the first claims on them e matches its hash
+func tombstoned(e Entry, claims []tombstoneClaim) bool {
+    if len(claims) == 0 {
+        return false
+    }
+    fields := fieldDigests(e)
+    original := make(map[string]bool)
+    last := make(map[string]string)
+    for _, c := range claims {
+        if c.tenant != e.Tenant {
+            return false
+        }
+        for field, digest := range c.fields {
+            if !original[field] {
+                fields[field], original[field] = digest, true
+            }
+            last[field] = c.tombstone
+        }
+    }
+    for field, tombstone := range last {
+        if v, ok := fieldValue(e, field); !ok || v != tombstone {
+            return false
+        }
+    }
+    return bytes.Equal(chainHash(e.Prev, e, fields), e.Hash)
+}
+
+// Report is what Verify found
+type Report struct {
+    // From and To are the first and last sequence numbers checked. From is above 1 once
+    // retention has pruned the oldest entries
+    From uint64 `json:"from"`
+    To   uint64 `json:"to"`
+    // Entries counts the entries checked, Tombstoned those accepted as pseudonymised
+    Entries    int `json:"entries"`
+    Tombstoned int `json:"tombstoned"`
+    // Anchors counts the anchors matched against the chain; Anchored is the newest
+    // sequence number one covers. Entries after it can be rewritten undetected until the
+    // next anchor
+    Anchors  int      `json:"anchors"`
+    Anchored uint64   `json:"anchored"`
+    Problems []string `json:"problems,omitempty"`
+}
+
+// OK reports whether the log showed no sign of tampering
+func (r *Report) OK() bool {
+    return len(r.Problems) == 0
+}
+
+func (r *Report) problem(format string, args ...interface{}) {
+    r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// Verify walks the whole chain of log and checks every anchor against it. Anchors must be
+// signed with algorithm under publicKey. Tampering is reported in the Report's Problems;
+// the error is only for failing to read the log
+func Verify(ctx context.Context, log Chained, anchors []Anchor, algorithm string, publicKey []byte) (*Report, error) {
+    rep := &Report{}
+    anchored := make(map[uint64][]byte)
+    for i := range anchors {
+        a := &anchors[i]
+        if a.Algorithm != algorithm {
+            rep.problem("anchor at %d is signed with %s, not %s", a.Seq, a.Algorithm, algorithm)
+            continue
+        }
+        if err := a.Verify(publicKey); err != nil {
+            rep.problem("anchor at %d has an invalid signature", a.Seq)
+            continue
+        }
+        anchored[a.Seq] = a.Head
+    }
+
+    var prev *Entry
+    altered := make(map[uint64]Entry)
+    claims := make(map[uint64][]tombstoneClaim)
+    heads := make(map[uint64][]byte)
+    for next := uint64(1); ; {
+        page, err := log.Range(ctx, next, verifyPage)
+        if err != nil {
+            return nil, err
+        }
+        if len(page) == 0 {
+            break
+        }
+        for i := range page {
+            e := &page[i]
+            if prev == nil {
+                rep.From = e.Seq
+            } else if e.Seq == prev.Seq+2 {
+                rep.problem("entry %d is missing", prev.Seq+1)
+            } else if e.Seq != prev.Seq+1 {
+                rep.problem("entries %d to %d are missing", prev.Seq+1, e.Seq-1)
+            } else if !bytes.Equal(e.Prev, prev.Hash) {
+                rep.problem("entry %d does not follow entry %d", e.Seq, prev.Seq)
+            }
+            if !bytes.Equal(ChainHash(e.Prev, *e), e.Hash) && !bytes.Equal(legacyChainHash(e.Prev, *e), e.Hash) {
+                altered[e.Seq] = *e
+            } else if e.Action == ActionTombstone {
+                for seq, c := range tombstoneClaims(*e) {
+                    claims[seq] = append(claims[seq], c)
+                }
+            }
+            if _, ok := anchored[e.Seq]; ok {
+                heads[e.Seq] = e.Hash
+            }
+            rep.Entries++
+            prev = e
+        }
+        next = prev.Seq + 1
+    }
+    if prev != nil {
+        rep.To = prev.Seq
+    }
+
+    seqs := make([]uint64, 0, len(altered))
+    for seq := range altered {
+        seqs = append(seqs, seq)
+    }
+    sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+    for _, seq := range seqs {
+        if tombstoned(altered[seq], claims[seq]) {
+            rep.Tombstoned++
+        } else {
+            rep.problem("entry %d was altered", seq)
+        }
+    }
+
+    seqs = seqs[:0]
+    for seq := range anchored {
+        seqs = append(seqs, seq)
+    }
+    sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+    for _, seq := range seqs {
+        switch {
+        case seq > rep.To:
+            rep.problem("anchor at %d is past the end of the log at %d", seq, rep.To)
+        case seq < rep.From:
+            // Pruned by retention
+        case !bytes.Equal(heads[seq], anchored[seq]):
+            rep.problem("entry %d does not match its anchor", seq)
+        default:
+            rep.Anchors++
+            rep.Anchored = seq
+        }
+    }
+    return rep, nil
+}
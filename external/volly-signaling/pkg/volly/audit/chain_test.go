@@ -0,0 +1,59 @@
+package audit
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "strconv"
+    "testing"
+)
+
+// TestTombstoneDestroysSalts tombstones an identity and checks the chain still verifies,
+// that the salts of the replaced fields are gone and that the digests the tombstone record
+// keeps are salted, so a guessed identity cannot be checked against them
+func TestTombstoneDestroysSalts(t *testing.T) {
+    ctx := context.Background()
+    log := NewMemoryLog(0)
+    for _, e := range []Entry{
+        {Actor: "alice", Action: "room.join", Tenant: "acme", Target: "lobby"},
+        {Actor: "admin", Action: "user.ban", Tenant: "acme", Target: "alice", Detail: map[string]string{"by": "bob"}},
+    } {
+        if err := log.Append(ctx, e); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if n, err := log.Tombstone(ctx, "acme", "alice", "erased-1"); err != nil || n != 2 {
+        t.Fatalf("tombstoned %d entries, %v; want 2", n, err)
+    }
+    entries, err := log.Range(ctx, 1, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := entries[0].Salts[fieldActor]; ok {
+        t.Fatal("the salt of a tombstoned actor survived")
+    }
+    if _, ok := entries[1].Salts["detail.by"]; !ok {
+        t.Fatal("the salt of a field that was not tombstoned was destroyed")
+    }
+    var digests map[string]map[string][]byte
+    if err := json.Unmarshal([]byte(entries[2].Detail["fields"]), &digests); err != nil {
+        t.Fatal(err)
+    }
+    for seq, field := range map[uint64]string{1: fieldActor, 2: fieldTarget} {
+        kept := digests[strconv.FormatUint(seq, 10)][field]
+        if kept == nil {
+            t.Fatalf("no digest kept for %s of entry %d", field, seq)
+        }
+        if bytes.Equal(kept, fieldDigest(seq, field, "alice", nil)) {
+            t.Fatalf("the digest kept for %s of entry %d matches the unsalted digest of the identity", field, seq)
+        }
+    }
+
+    rep, err := Verify(ctx, log, nil, "", nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !rep.OK() || rep.Tombstoned != 2 {
+        t.Fatalf("report %+v, want no problems and 2 tombstoned entries", rep)
+    }
+}
@@ -0,0 +1,381 @@
+package auth
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "strings"
+    "time"
+
+    lkauth "github.com/livekit/protocol/auth"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/codec"
+)
+
+// AttenuatedTokenType is the JWT typ of tokens derived with Attenuate
+const AttenuatedTokenType = "volly-attenuated+jwt"
+
+// maxAttenuationDepth bounds verification work for deeply nested derivations
+const maxAttenuationDepth = 8
+
+var (
+    ErrNotSubset          = errors.New("restriction would widen the parent token")
+    ErrInvalidAttenuation = errors.New("invalid attenuated token")
+    ErrAttenuatedExpired  = errors.New("attenuated token has expired")
+)
+
+// Caveats are the restrictions an attenuated token adds to its parent
+type Caveats struct {
+    ExpiresAt     int64  `json:"exp"`
+    Room          string `json:"room,omitempty"`
+    SubscribeOnly bool   `json:"subscribeOnly,omitempty"`
+    NoPublishData bool   `json:"noPublishData,omitempty"`
+}
+
+type attenuatedClaims struct {
+    // Parent is the parent's signing input; its signature is the child's MAC key
+    Parent    string  `json:"parent"`
+    ParentJTI string  `json:"pjti,omitempty"`
+    JTI       string  `json:"jti"`
+    Caveats   Caveats `json:"caveats"`
+}
+
+// attenuation is the state restrictions are applied to
+type attenuation struct {
+    now     time.Time
+    room    string
+    caveats Caveats
+}
+
+// Restriction narrows an attenuated token
+type Restriction func(a *attenuation) error
+
+// WithTTL shortens the child's lifetime; it never outlives its parent
+func WithTTL(ttl time.Duration) Restriction {
+    return func(a *attenuation) error {
+        if exp := a.now.Add(ttl).Unix(); exp < a.caveats.ExpiresAt {
+            a.caveats.ExpiresAt = exp
+        }
+        return nil
+    }
+}
+
+// WithRoom limits the child to one room, which must be within the parent's scope
+func WithRoom(room string) Restriction {
+    return func(a *attenuation) error {
+        if a.room != "" && a.room != room {
+            return ErrNotSubset
+        }
+        a.caveats.Room = room
+        return nil
+    }
+}
+
+// SubscribeOnly leaves the child only joining its room and subscribing, taking away publish,
+// data, metadata and every room, ingress, recording and agent right
+func SubscribeOnly() Restriction {
+    return func(a *attenuation) error {
+        a.caveats.SubscribeOnly = true
+        return nil
+    }
+}
+
+// WithoutPublishData removes the data channel publish right
+func WithoutPublishData() Restriction {
+    return func(a *attenuation) error {
+        a.caveats.NoPublishData = true
+        return nil
+    }
+}
+
+// Attenuate derives a narrower token from parent without contacting the issuer.
+// The child is MACed with the parent's signature, so only a holder of the
+// parent can derive it and only the issuer's secret can verify the chain
+func Attenuate(parent string, restrictions ...Restriction) (string, error) {
+    return AttenuateWithClock(parent, clock.System, restrictions...)
+}
+
+// AttenuateWithClock is Attenuate with c as the time source for WithTTL
+func AttenuateWithClock(parent string, c clock.Clock, restrictions ...Restriction) (string, error) {
+    input, signature, err := splitToken(parent)
+    if err != nil {
+        return "", err
+    }
+    chain, err := decodeChain(input)
+    if err != nil {
+        return "", err
+    }
+
+    // Start from the parent's effective scope so restrictions can only narrow it
+    a := &attenuation{now: c.Now()}
+    a.room, _ = chain.root.grant["room"].(string)
+    var expiry int64
+    if exp, ok := chain.root.claims["exp"].(float64); ok {
+        expiry = int64(exp)
+    }
+    for _, c := range chain.caveats {
+        if c.Room != "" {
+            a.room = c.Room
+        }
+        if expiry == 0 || c.ExpiresAt < expiry {
+            expiry = c.ExpiresAt
+        }
+    }
+    if expiry == 0 {
+        return "", ErrInvalidAttenuation
+    }
+    a.caveats.ExpiresAt = expiry
+    for _, restrict := range restrictions {
+        if err := restrict(a); err != nil {
+            return "", err
+        }
+    }
+
+    jti := make([]byte, 16)
+    if _, err := rand.Read(jti); err != nil {
+        return "", err
+    }
+    header, err := json.Marshal(map[string]string{"alg": "HS256", "typ": AttenuatedTokenType})
+    if err != nil {
+        return "", err
+    }
+    payload, err := json.Marshal(attenuatedClaims{
+        Parent:    input,
+        ParentJTI: chain.lastJTI(),
+        JTI:       base64.RawURLEncoding.EncodeToString(jti),
+        Caveats:   a.caveats,
+    })
+    if err != nil {
+        return "", err
+    }
+
+    childInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+    return childInput + "." + base64.RawURLEncoding.EncodeToString(mac(signature, childInput)), nil
+}
+
+// IsAttenuated reports whether token was produced by Attenuate
+func IsAttenuated(token string) bool {
+    input, _, err := splitToken(token)
+    if err != nil {
+        return false
+    }
+    header, err := decodeHeader(input)
+    return err == nil && header.Typ == AttenuatedTokenType
+}
+
+// VerifyAttenuated verifies an attenuated token and returns the parent grant narrowed by every caveat
+func VerifyAttenuated(token, apiKey, secret string) (*VollyVideoGrant, error) {
+    return VerifyAttenuatedWithClock(token, apiKey, secret, clock.System)
+}
+
+// VerifyAttenuatedWithClock is VerifyAttenuated with c as the time source for expiry
+func VerifyAttenuatedWithClock(token, apiKey, secret string, c clock.Clock) (*VollyVideoGrant, error) {
+    return verifyAttenuated(token, apiKey, keyMAC([]byte(secret)), c.Now(), DefaultNotBeforeLeeway, DefaultNotBeforeLeeway)
+}
+
+// verifyAttenuated checks the root token's exp and nbf with leeway and notBefore, and every
+// link's expiry exactly
+func verifyAttenuated(token, apiKey string, m macFunc, now time.Time, leeway, notBefore time.Duration) (*VollyVideoGrant, error) {
+    input, signature, err := splitToken(token)
+    if err != nil {
+        return nil, err
+    }
+    chain, err := decodeChain(input)
+    if err != nil {
+        return nil, err
+    }
+    if len(chain.links) == 0 {
+        return nil, ErrInvalidAttenuation
+    }
+
+    // Recompute the MAC chain from the issuer secret down to this token
+    rootSignature, err := m(chain.root.input)
+    if err != nil {
+        return nil, err
+    }
+    tag := rootSignature
+    for _, link := range chain.links {
+        tag = mac(tag, link.input)
+    }
+    if !hmac.Equal(tag, signature) {
+        return nil, ErrInvalidAttenuation
+    }
+
+    grant, err := verifyVollyToken(chain.root.input+"."+base64.RawURLEncoding.EncodeToString(rootSignature), apiKey, m, now, leeway, notBefore)
+    if err != nil {
+        return nil, err
+    }
+
+    parentJTI := grant.TokenID
+    chainIDs := []string{grant.TokenID}
+    for _, link := range chain.links {
+        if link.claims.ParentJTI != parentJTI {
+            return nil, ErrInvalidAttenuation
+        }
+        c := link.claims.Caveats
+        if now.Unix() > c.ExpiresAt {
+            return nil, ErrAttenuatedExpired
+        }
+        if err := narrow(grant, c); err != nil {
+            return nil, err
+        }
+        parentJTI = link.claims.JTI
+        chainIDs = append(chainIDs, link.claims.JTI)
+    }
+
+    grant.TokenID = parentJTI
+    grant.TokenChain = append(grant.TokenChain, chainIDs[:len(chainIDs)-1]...)
+    return grant, nil
+}
+
+// narrow applies one link's caveats to the grant it was derived from
+func narrow(grant *VollyVideoGrant, c Caveats) error {
+    if grant.ExpiresAt == 0 || c.ExpiresAt < grant.ExpiresAt {
+        grant.ExpiresAt = c.ExpiresAt
+    }
+    if c.Room != "" {
+        if grant.Room != "" && grant.Room != c.Room {
+            return ErrInvalidAttenuation
+        }
+        grant.Room = c.Room
+    }
+    if c.SubscribeOnly {
+        // Built from what a subscriber keeps rather than by clearing rights, so a right added
+        // to the grant later is not carried over by default
+        f := false
+        grant.VideoGrant = lkauth.VideoGrant{
+            RoomJoin:             grant.RoomJoin,
+            Room:                 grant.Room,
+            CanSubscribe:         grant.CanSubscribe,
+            CanPublish:           &f,
+            CanPublishData:       &f,
+            CanUpdateOwnMetadata: &f,
+            Hidden:               grant.Hidden,
+        }
+    }
+    if c.NoPublishData {
+        grant.SetCanPublishData(false)
+    }
+    return nil
+}
+
+type rootToken struct {
+    input  string
+    claims map[string]interface{}
+    grant  map[string]interface{}
+}
+
+type chainLink struct {
+    input  string
+    claims attenuatedClaims
+}
+
+// tokenChain is an attenuated token unwrapped to its root, links ordered root to leaf
+type tokenChain struct {
+    root    rootToken
+    links   []chainLink
+    caveats []Caveats
+}
+
+func (c *tokenChain) lastJTI() string {
+    if n := len(c.links); n > 0 {
+        return c.links[n-1].claims.JTI
+    }
+    jti, _ := c.root.claims["jti"].(string)
+    return jti
+}
+
+// decodeChain unwraps signing inputs without verifying anything
+func decodeChain(input string) (*tokenChain, error) {
+    chain := &tokenChain{}
+    for depth := 0; ; depth++ {
+        if depth > maxAttenuationDepth {
+            return nil, ErrInvalidAttenuation
+        }
+        header, err := decodeHeader(input)
+        if err != nil {
+            return nil, err
+        }
+        payload, err := decodePayload(input)
+        if err != nil {
+            return nil, err
+        }
+
+        if header.Typ != AttenuatedTokenType {
+            if header.Alg != "HS256" {
+                return nil, ErrInvalidAttenuation
+            }
+            claims, err := codec.UnmarshalObject(payload)
+            if err != nil {
+                return nil, ErrInvalidAttenuation
+            }
+            grant, _ := claims["video"].(map[string]interface{})
+            chain.root = rootToken{input: input, claims: claims, grant: grant}
+            break
+        }
+
+        var claims attenuatedClaims
+        if err := json.Unmarshal(payload, &claims); err != nil || claims.Parent == "" {
+            return nil, ErrInvalidAttenuation
+        }
+        chain.links = append([]chainLink{{input: input, claims: claims}}, chain.links...)
+        chain.caveats = append([]Caveats{claims.Caveats}, chain.caveats...)
+        input = claims.Parent
+    }
+    return chain, nil
+}
+
+func splitToken(token string) (input string, signature []byte, err error) {
+    i := strings.LastIndexByte(token, '.')
+    if i < 0 || strings.Count(token, ".") != 2 {
+        return "", nil, ErrInvalidAttenuation
+    }
+    signature, err = codec.DecodeRawURL(token[i+1:])
+    if err != nil {
+        return "", nil, ErrInvalidAttenuation
+    }
+    return token[:i], signature, nil
+}
+
+type jwtHeader struct {
+    Alg string `json:"alg"`
+    Typ string `json:"typ"`
+}
+
+func decodeHeader(input string) (*jwtHeader, error) {
+    seg, _, ok := strings.Cut(input, ".")
+    if !ok {
+        return nil, ErrInvalidAttenuation
+    }
+    data, err := codec.DecodeRawURL(seg)
+    if err != nil {
+        return nil, ErrInvalidAttenuation
+    }
+    var header jwtHeader
+    if err := json.Unmarshal(data, &header); err != nil {
+        return nil, ErrInvalidAttenuation
+    }
+    return &header, nil
+}
+
+func decodePayload(input string) ([]byte, error) {
+    _, seg, ok := strings.Cut(input, ".")
+    if !ok {
+        return nil, ErrInvalidAttenuation
+    }
+    data, err := codec.DecodeRawURL(seg)
+    if err != nil {
+        return nil, ErrInvalidAttenuation
+    }
+    return data, nil
+}
+
+func mac(key []byte, input string) []byte {
+    h := hmac.New(sha256.New, key)
+    h.Write([]byte(input))
+    return h.Sum(nil)
+}
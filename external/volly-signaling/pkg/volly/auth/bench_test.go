@@ -0,0 +1,73 @@
+package auth
+
+import (
+    "testing"
+
+    "github.com/cloudflare/circl/kem"
+    "github.com/cloudflare/circl/kem/mlkem/mlkem1024"
+    "github.com/cloudflare/circl/kem/mlkem/mlkem768"
+    "github.com/livekit/protocol/auth"
+)
+
+// Benchmarks for tokens carrying a PQ public key, by KEM; run them with
+// go test -bench . ./pkg/volly/auth
+
+const (
+    benchAPIKey = "vollybench"
+    benchSecret = "vollybench-secret-not-for-production-use"
+)
+
+var benchKEMs = []struct {
+    name   string
+    scheme kem.Scheme
+}{
+    {"ML-KEM-768", mlkem768.Scheme()},
+    {"ML-KEM-1024", mlkem1024.Scheme()},
+}
+
+// benchToken issues a token embedding a public key of scheme
+func benchToken(b *testing.B, scheme kem.Scheme) string {
+    pk, _, err := scheme.GenerateKeyPair()
+    if err != nil {
+        b.Fatal(err)
+    }
+    pub, err := pk.MarshalBinary()
+    if err != nil {
+        b.Fatal(err)
+    }
+    token, err := NewVollyAccessToken(benchAPIKey, benchSecret).
+        AddGrant(&VollyVideoGrant{VideoGrant: auth.VideoGrant{RoomJoin: true, Room: "bench"}}).
+        SetIdentity("bench-user").
+        SetPostQuantumKey(pub, scheme.Name()).
+        ToJWT()
+    if err != nil {
+        b.Fatal(err)
+    }
+    return token
+}
+
+func BenchmarkIssueToken(b *testing.B) {
+    for _, k := range benchKEMs {
+        b.Run(k.name, func(b *testing.B) {
+            var size int
+            for i := 0; i < b.N; i++ {
+                size = len(benchToken(b, k.scheme))
+            }
+            b.ReportMetric(float64(size), "B/token")
+        })
+    }
+}
+
+func BenchmarkVerifyToken(b *testing.B) {
+    for _, k := range benchKEMs {
+        b.Run(k.name, func(b *testing.B) {
+            token := benchToken(b, k.scheme)
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                if _, err := VerifyVollyToken(token, benchAPIKey, benchSecret); err != nil {
+                    b.Fatal(err)
+                }
+            }
+        })
+    }
+}
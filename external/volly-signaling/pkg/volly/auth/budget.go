@@ -0,0 +1,110 @@
+package auth
+
+import (
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+)
+
+var ErrTokenOverBudget = errors.New("token exceeds the issuer's size budget")
+
+// jwtHeaderBytes and jwtSignatureBytes are the encoded HS256 header and signature around
+// every payload
+var (
+    jwtHeaderBytes    = base64.RawURLEncoding.EncodedLen(len(`{"alg":"HS256","typ":"JWT"}`))
+    jwtSignatureBytes = base64.RawURLEncoding.EncodedLen(32)
+)
+
+// DefaultTokenBudget warns well before, and refuses at, the 8KB request header limit of AWS
+// ALBs and many proxies, since tokens travel in Authorization headers and query strings
+var DefaultTokenBudget = TokenBudget{WarnBytes: 4 << 10, MaxBytes: 8 << 10}
+
+// TokenBudget bounds the size of tokens an issuer signs; zero fields are unlimited. It is
+// the issuer's counterpart to ClaimLimits, which verifiers enforce and which are far looser
+type TokenBudget struct {
+    WarnBytes int `json:"warnBytes,omitempty"`
+    MaxBytes  int `json:"maxBytes,omitempty"`
+}
+
+// ClaimSize is what one claim adds to the encoded token
+type ClaimSize struct {
+    Claim string `json:"claim"`
+    Bytes int    `json:"bytes"`
+}
+
+// TokenSize is a token's encoded length, computed before signing, and the claims making it
+// up, largest first
+type TokenSize struct {
+    Bytes  int         `json:"bytes"`
+    Claims []ClaimSize `json:"claims"`
+}
+
+// Largest returns the n biggest claims
+func (s TokenSize) Largest(n int) []ClaimSize {
+    if n > len(s.Claims) {
+        n = len(s.Claims)
+    }
+    return s.Claims[:n]
+}
+
+func (s TokenSize) String() string {
+    return fmt.Sprintf("%d bytes; largest claims: %s", s.Bytes, s.offenders())
+}
+
+// offenders lists the three biggest claims with their sizes
+func (s TokenSize) offenders() string {
+    parts := make([]string, 0, 3)
+    for _, c := range s.Largest(3) {
+        parts = append(parts, fmt.Sprintf("%s %d", c.Claim, c.Bytes))
+    }
+    return strings.Join(parts, ", ")
+}
+
+// TokenBudgetError is a token over MaxBytes; it matches ErrTokenOverBudget
+type TokenBudgetError struct {
+    Size  TokenSize
+    Limit int
+}
+
+func (e *TokenBudgetError) Error() string {
+    return fmt.Sprintf("token would be %d bytes, over the %d byte budget; largest claims: %s", e.Size.Bytes, e.Limit, e.Size.offenders())
+}
+
+func (e *TokenBudgetError) Unwrap() error { return ErrTokenOverBudget }
+
+// Check returns a *TokenBudgetError when size is over MaxBytes, and reports whether it is
+// over WarnBytes
+func (b TokenBudget) Check(size TokenSize) (warn bool, err error) {
+    if b.MaxBytes > 0 && size.Bytes > b.MaxBytes {
+        return true, &TokenBudgetError{Size: size, Limit: b.MaxBytes}
+    }
+    return b.WarnBytes > 0 && size.Bytes > b.WarnBytes, nil
+}
+
+// measureClaims computes the encoded length of a token with claims. Each claim is charged
+// its "name":value member, and its share of the base64 expansion
+func measureClaims(claims map[string]interface{}) (TokenSize, error) {
+    size := TokenSize{Claims: make([]ClaimSize, 0, len(claims))}
+    // The braces, less the comma the last member does not have
+    payload := 1
+    for name, value := range claims {
+        v, err := json.Marshal(value)
+        if err != nil {
+            return TokenSize{}, err
+        }
+        member := len(name) + 3 + len(v) + 1
+        payload += member
+        size.Claims = append(size.Claims, ClaimSize{Claim: name, Bytes: (member*4 + 2) / 3})
+    }
+    size.Bytes = jwtHeaderBytes + 1 + base64.RawURLEncoding.EncodedLen(payload) + 1 + jwtSignatureBytes
+    sort.Slice(size.Claims, func(i, j int) bool {
+        if size.Claims[i].Bytes != size.Claims[j].Bytes {
+            return size.Claims[i].Bytes > size.Claims[j].Bytes
+        }
+        return size.Claims[i].Claim < size.Claims[j].Claim
+    })
+    return size, nil
+}
@@ -0,0 +1,219 @@
+package auth
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+)
+
+// DefaultCanonicalEntries bounds how many distinct requests a CanonicalIssuer remembers
+const DefaultCanonicalEntries = 10000
+
+var (
+    ErrCanonicalWindow = errors.New("canonical window must be positive and shorter than the token ttl")
+)
+
+// canonicalRequest is the part of a token request that decides what gets signed. The PQ key
+// stamps are left out because SetPostQuantumKey derives them from the clock
+type canonicalRequest struct {
+    APIKey   string          `json:"k"`
+    Identity string          `json:"i"`
+    Kind     int32           `json:"p"`
+    TTL      int64           `json:"t"`
+    Jitter   int64           `json:"j,omitempty"`
+    Grant    VollyVideoGrant `json:"g"`
+    // Issuer and Audience are not serialized with the grant
+    Issuer   string   `json:"s,omitempty"`
+    Audience []string `json:"a,omitempty"`
+}
+
+// CanonicalForm returns a deterministic encoding of the request: tokens with equal canonical
+// forms grant exactly the same thing
+func (t *VollyAccessToken) CanonicalForm() ([]byte, error) {
+    grant := *t.grant
+    grant.PQKeyIssuedAt = 0
+    grant.PQKeyExpiry = 0
+    grant.AllowedCountries = sortedCopy(grant.AllowedCountries)
+    grant.DeniedCIDRs = sortedCopy(grant.DeniedCIDRs)
+    return json.Marshal(canonicalRequest{
+        APIKey:   t.apiKey,
+        Identity: t.identity,
+        Kind:     int32(t.kind),
+        TTL:      int64(t.ttl / time.Second),
+        Jitter:   int64(t.jitter / time.Second),
+        Grant:    grant,
+        Issuer:   grant.Issuer,
+        Audience: grant.Audience,
+    })
+}
+
+func sortedCopy(s []string) []string {
+    if len(s) == 0 {
+        return nil
+    }
+    out := append([]string(nil), s...)
+    sort.Strings(out)
+    return out
+}
+
+// CanonicalIssuer hands out one token per canonical request per window, so a flood of
+// identical join requests, e.g. viewers of a broadcast, gets the same token and jti and join
+// responses can be cached at the edge. Windows are aligned to wall-clock time and the jti is
+// an HMAC of the canonical form and window start under the signing secret, so every instance
+// sharing the secret agrees on it; revoking that jti revokes every copy
+type CanonicalIssuer struct {
+    window time.Duration
+    max    int
+    clock  clock.Clock
+
+    mu      sync.Mutex
+    entries map[string]*canonicalEntry
+}
+
+type canonicalEntry struct {
+    start     time.Time
+    done      chan struct{}
+    token     string
+    expiresAt time.Time
+    err       error
+}
+
+// NewCanonicalIssuer creates an issuer whose windows last window
+func NewCanonicalIssuer(window time.Duration) *CanonicalIssuer {
+    return &CanonicalIssuer{
+        window:  window,
+        max:     DefaultCanonicalEntries,
+        clock:   clock.System,
+        entries: make(map[string]*canonicalEntry),
+    }
+}
+
+// SetClock sets the time source for window boundaries
+func (c *CanonicalIssuer) SetClock(clk clock.Clock) *CanonicalIssuer {
+    c.clock = clk
+    return c
+}
+
+// SetMaxEntries bounds how many distinct requests are remembered per window
+func (c *CanonicalIssuer) SetMaxEntries(max int) *CanonicalIssuer {
+    c.max = max
+    return c
+}
+
+// Window returns the window length
+func (c *CanonicalIssuer) Window() time.Duration {
+    return c.window
+}
+
+// WindowEnd returns when the window containing now closes
+func (c *CanonicalIssuer) WindowEnd(now time.Time) time.Time {
+    return now.Truncate(c.window).Add(c.window)
+}
+
+// Issue signs t, or returns the token already signed for the same canonical request in the
+// current window along with its expiry. Concurrent identical requests sign once. The ttl must
+// exceed the window since a token handed out late in its window has less of it left
+func (c *CanonicalIssuer) Issue(t *VollyAccessToken) (string, time.Time, error) {
+    if c.window <= 0 || t.ttl <= c.window {
+        return "", time.Time{}, ErrCanonicalWindow
+    }
+    form, err := t.CanonicalForm()
+    if err != nil {
+        return "", time.Time{}, err
+    }
+    sum := sha256.Sum256(form)
+    key := string(sum[:])
+    start := c.clock.Now().Truncate(c.window)
+
+    c.mu.Lock()
+    e, ok := c.entries[key]
+    if ok && e.start.Equal(start) {
+        c.mu.Unlock()
+        <-e.done
+        if e.err == nil && t.check != nil {
+            // The issuance check may depend on state such as a kill switch, so it runs on hits too
+            if err := t.check(t.grant); err != nil {
+                return "", time.Time{}, err
+            }
+        }
+        return e.token, e.expiresAt, e.err
+    }
+    if len(c.entries) >= c.max {
+        c.prune(start)
+    }
+    e = &canonicalEntry{start: start, done: make(chan struct{})}
+    c.entries[key] = e
+    c.mu.Unlock()
+
+    e.token, e.expiresAt, e.err = c.sign(t, form, start)
+    close(e.done)
+    if e.err != nil {
+        // Failures are not remembered; the next request retries
+        c.mu.Lock()
+        if c.entries[key] == e {
+            delete(c.entries, key)
+        }
+        c.mu.Unlock()
+    }
+    return e.token, e.expiresAt, e.err
+}
+
+func (c *CanonicalIssuer) sign(t *VollyAccessToken, form []byte, start time.Time) (string, time.Time, error) {
+    prev := t.tokenID
+    defer func() { t.tokenID = prev }()
+
+    var window [8]byte
+    binary.BigEndian.PutUint64(window[:], uint64(start.Unix()))
+    derive := func(secret []byte) error {
+        mac := hmac.New(sha256.New, secret)
+        mac.Write(form)
+        mac.Write(window[:])
+        t.tokenID = base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+        return nil
+    }
+    if t.secureSecret != nil {
+        if t.secureSecret.Len() == 0 {
+            return "", time.Time{}, ErrSecretUnavailable
+        }
+        if err := t.secureSecret.Use(derive); err != nil {
+            return "", time.Time{}, err
+        }
+    } else {
+        derive([]byte(t.secret))
+    }
+
+    expiresAt := c.clock.Now().Add(t.validFor(t.tokenID))
+    token, err := t.ToJWT()
+    if err != nil {
+        return "", time.Time{}, err
+    }
+    return token, expiresAt, nil
+}
+
+// prune drops entries from earlier windows and, if that is not enough, every settled entry;
+// callers hold c.mu
+func (c *CanonicalIssuer) prune(start time.Time) {
+    for key, e := range c.entries {
+        if e.start.Before(start) {
+            delete(c.entries, key)
+        }
+    }
+    if len(c.entries) < c.max {
+        return
+    }
+    for key, e := range c.entries {
+        select {
+        case <-e.done:
+            delete(c.entries, key)
+        default:
+        }
+    }
+}
@@ -0,0 +1,162 @@
+package auth
+
+import (
+    "encoding/base64"
+    "errors"
+    "io"
+    "strings"
+)
+
+var (
+    ErrTokenTooLarge      = errors.New("token exceeds the size limit")
+    ErrTooManyClaims      = errors.New("token has too many claims")
+    ErrClaimsTooDeep      = errors.New("token claims are nested too deeply")
+    ErrClaimStringTooLong = errors.New("token claim string exceeds the length limit")
+    ErrMalformedClaims    = errors.New("token claims are malformed")
+)
+
+// DefaultClaimLimits leave room for a full attenuation chain over a token carrying an
+// ML-KEM-1024 key
+var DefaultClaimLimits = ClaimLimits{
+    MaxTokenBytes:   64 << 10,
+    MaxClaims:       256,
+    MaxDepth:        16,
+    MaxStringLength: 48 << 10,
+}
+
+// ClaimLimits bound what a token may make a verifier parse; zero fields are unlimited
+type ClaimLimits struct {
+    MaxTokenBytes int `json:"maxTokenBytes,omitempty"`
+    // MaxClaims counts object members and array elements at every depth
+    MaxClaims int `json:"maxClaims,omitempty"`
+    MaxDepth  int `json:"maxDepth,omitempty"`
+    // MaxStringLength counts the encoded bytes of each key and string value
+    MaxStringLength int `json:"maxStringLength,omitempty"`
+}
+
+// Check scans the header and payload of token as they are decoded, without building
+// either, and rejects it as soon as a limit is passed. It checks only the limits, not that
+// the JSON is otherwise valid; viewer tokens have a fixed binary layout and only their
+// size is checked
+func (l ClaimLimits) Check(token string) error {
+    if l.MaxTokenBytes > 0 && len(token) > l.MaxTokenBytes {
+        return ErrTokenTooLarge
+    }
+    if IsViewerToken(token) {
+        return nil
+    }
+
+    header, rest, ok := strings.Cut(token, ".")
+    if !ok {
+        return ErrMalformedClaims
+    }
+    payload, _, ok := strings.Cut(rest, ".")
+    if !ok {
+        return ErrMalformedClaims
+    }
+    var buf [512]byte
+    for _, seg := range []string{header, payload} {
+        s := &claimScanner{limits: l}
+        _, err := io.CopyBuffer(s, base64.NewDecoder(base64.RawURLEncoding, strings.NewReader(seg)), buf[:])
+        if err == nil {
+            err = s.finish()
+        }
+        switch {
+        case err == nil:
+        case errors.Is(err, ErrTooManyClaims), errors.Is(err, ErrClaimsTooDeep),
+            errors.Is(err, ErrClaimStringTooLong), errors.Is(err, ErrMalformedClaims):
+            return err
+        default:
+            return ErrMalformedClaims
+        }
+    }
+    return nil
+}
+
+// claimScanner tracks just enough JSON structure to count members, depth and string lengths
+type claimScanner struct {
+    limits ClaimLimits
+
+    stack      []byte
+    claims     int
+    inString   bool
+    escaped    bool
+    strLen     int
+    expectKey  bool
+    expectElem bool
+}
+
+func (s *claimScanner) Write(p []byte) (int, error) {
+    for _, c := range p {
+        if s.inString {
+            switch {
+            case s.escaped:
+                s.escaped = false
+            case c == '\\':
+                s.escaped = true
+            case c == '"':
+                s.inString = false
+                continue
+            }
+            if s.strLen++; s.limits.MaxStringLength > 0 && s.strLen > s.limits.MaxStringLength {
+                return 0, ErrClaimStringTooLong
+            }
+            continue
+        }
+
+        switch c {
+        case ' ', '\t', '\n', '\r':
+            continue
+        }
+        if s.expectElem && c != ']' {
+            s.expectElem = false
+            if err := s.count(); err != nil {
+                return 0, err
+            }
+        }
+        switch c {
+        case '"':
+            s.inString, s.strLen = true, 0
+            if s.expectKey {
+                s.expectKey = false
+                if err := s.count(); err != nil {
+                    return 0, err
+                }
+            }
+        case '{', '[':
+            s.stack = append(s.stack, c)
+            if s.limits.MaxDepth > 0 && len(s.stack) > s.limits.MaxDepth {
+                return 0, ErrClaimsTooDeep
+            }
+            s.expectKey, s.expectElem = c == '{', c == '['
+        case '}', ']':
+            if len(s.stack) == 0 {
+                return 0, ErrMalformedClaims
+            }
+            s.stack = s.stack[:len(s.stack)-1]
+            s.expectKey, s.expectElem = false, false
+        case ',':
+            if len(s.stack) == 0 {
+                return 0, ErrMalformedClaims
+            }
+            top := s.stack[len(s.stack)-1]
+            s.expectKey, s.expectElem = top == '{', top == '['
+        }
+    }
+    return len(p), nil
+}
+
+func (s *claimScanner) count() error {
+    if s.claims++; s.limits.MaxClaims > 0 && s.claims > s.limits.MaxClaims {
+        return ErrTooManyClaims
+    }
+    return nil
+}
+
+// finish rejects a segment that ended inside a string or container
+func (s *claimScanner) finish() error {
+    if s.inString || len(s.stack) != 0 {
+        return ErrMalformedClaims
+    }
+    return nil
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+    "errors"
+    "fmt"
+    "sync"
+    "time"
+)
+
+var (
+    ErrCryptoProfile       = errors.New("token does not meet the tenant's crypto profile")
+    ErrWeakerCryptoProfile = errors.New("tenant crypto profile is weaker than the deployment default")
+)
+
+// CryptoProfile is the cryptography a tenant's tokens must use; zero fields are unchecked
+type CryptoProfile struct {
+    // RequirePQ refuses tokens without post-quantum claims, viewer tokens included, whatever
+    // the legacy policy
+    RequirePQ bool
+    // PQAlgorithms lists the KEM algorithms an embedded post-quantum key may use
+    PQAlgorithms []string
+    // ConfirmationAlgorithms lists the algorithms a key-bound token's client key may use
+    ConfirmationAlgorithms []string
+    // MaxTTL caps a token's lifetime from issue to expiry
+    MaxTTL time.Duration
+    // MaxPQKeyLifetime caps an embedded post-quantum key's lifetime
+    MaxPQKeyLifetime time.Duration
+}
+
+// AtLeast reports, as ErrWeakerCryptoProfile, every way p accepts a token base refuses
+func (p CryptoProfile) AtLeast(base CryptoProfile) error {
+    switch {
+    case base.RequirePQ && !p.RequirePQ:
+        return fmt.Errorf("%w: it does not require post-quantum claims", ErrWeakerCryptoProfile)
+    case !subset(p.PQAlgorithms, base.PQAlgorithms):
+        return fmt.Errorf("%w: it allows post-quantum algorithms the default does not", ErrWeakerCryptoProfile)
+    case !subset(p.ConfirmationAlgorithms, base.ConfirmationAlgorithms):
+        return fmt.Errorf("%w: it allows confirmation algorithms the default does not", ErrWeakerCryptoProfile)
+    case looser(p.MaxTTL, base.MaxTTL):
+        return fmt.Errorf("%w: its token ttl is longer", ErrWeakerCryptoProfile)
+    case looser(p.MaxPQKeyLifetime, base.MaxPQKeyLifetime):
+        return fmt.Errorf("%w: its post-quantum key lifetime is longer", ErrWeakerCryptoProfile)
+    }
+    return nil
+}
+
+// subset reports whether the list allows is no wider than base; an empty list allows anything
+func subset(allows, base []string) bool {
+    if len(base) == 0 {
+        return true
+    }
+    if len(allows) == 0 {
+        return false
+    }
+    for _, a := range allows {
+        if !contains(base, a) {
+            return false
+        }
+    }
+    return true
+}
+
+// looser reports whether the cap max is above base; zero is no cap
+func looser(max, base time.Duration) bool {
+    return base > 0 && (max == 0 || max > base)
+}
+
+func contains(list []string, s string) bool {
+    for _, v := range list {
+        if v == s {
+            return true
+        }
+    }
+    return false
+}
+
+// Check reports why grant does not meet p, if it does not. ttl is the token's lifetime
+func (p CryptoProfile) Check(grant *VollyVideoGrant, ttl time.Duration) error {
+    if p.RequirePQ && grant.PQPublicKey == "" {
+        return fmt.Errorf("%w: post-quantum key required", ErrCryptoProfile)
+    }
+    if grant.PQPublicKey != "" && len(p.PQAlgorithms) > 0 && !contains(p.PQAlgorithms, grant.PQAlgorithm) {
+        return fmt.Errorf("%w: post-quantum algorithm %q", ErrCryptoProfile, grant.PQAlgorithm)
+    }
+    if c := grant.Confirmation; c != nil && len(p.ConfirmationAlgorithms) > 0 && !contains(p.ConfirmationAlgorithms, c.Algorithm) {
+        return fmt.Errorf("%w: confirmation algorithm %q", ErrCryptoProfile, c.Algorithm)
+    }
+    if p.MaxTTL > 0 && ttl > p.MaxTTL {
+        return fmt.Errorf("%w: ttl %s over %s", ErrCryptoProfile, ttl, p.MaxTTL)
+    }
+    if p.MaxPQKeyLifetime > 0 && grant.PQPublicKey != "" {
+        // Keys minted before pqKeyIssuedAt existed are bounded from the token's issue time
+        issuedAt := grant.PQKeyIssuedAt
+        if issuedAt == 0 {
+            issuedAt = grant.IssuedAt
+        }
+        if lifetime := time.Duration(grant.PQKeyExpiry-issuedAt) * time.Second; lifetime > p.MaxPQKeyLifetime {
+            return fmt.Errorf("%w: post-quantum key lifetime %s over %s", ErrCryptoProfile, lifetime, p.MaxPQKeyLifetime)
+        }
+    }
+    return nil
+}
+
+// CryptoProfiles holds the deployment's default profile and the stricter ones of tenants
+// with their own requirements, so a government tenant and consumer tenants can share a
+// cluster. Issuers and verifiers given the same profiles agree on what a tenant accepts
+type CryptoProfiles struct {
+    def CryptoProfile
+
+    mu      sync.RWMutex
+    tenants map[string]CryptoProfile
+}
+
+// NewCryptoProfiles creates profiles where every tenant has def
+func NewCryptoProfiles(def CryptoProfile) *CryptoProfiles {
+    return &CryptoProfiles{def: def, tenants: make(map[string]CryptoProfile)}
+}
+
+// SetTenant gives tenant its own profile, which must be at least as strict as the default
+func (c *CryptoProfiles) SetTenant(tenant string, p CryptoProfile) error {
+    if err := p.AtLeast(c.def); err != nil {
+        return fmt.Errorf("tenant %s: %w", tenant, err)
+    }
+    c.mu.Lock()
+    c.tenants[tenant] = p
+    c.mu.Unlock()
+    return nil
+}
+
+// Profile returns the profile tenant's tokens are held to
+func (c *CryptoProfiles) Profile(tenant string) CryptoProfile {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    if p, ok := c.tenants[tenant]; ok {
+        return p
+    }
+    return c.def
+}
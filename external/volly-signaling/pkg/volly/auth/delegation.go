@@ -0,0 +1,131 @@
+package auth
+
+import (
+    "errors"
+    "time"
+)
+
+// maxDelegationDepth bounds how many services a call may pass through on a user's behalf
+const maxDelegationDepth = 4
+
+var (
+    ErrNotDelegable      = errors.New("token cannot be delegated")
+    ErrDelegationTenant  = errors.New("service and user tokens belong to different tenants")
+    ErrDelegationTooDeep = errors.New("delegation chain is too long")
+    ErrDelegationExpired = errors.New("a token of the delegation has expired")
+    ErrDelegationUnbound = errors.New("service token must be bound to a key to act for a user")
+)
+
+// Actor is a service acting on a user's behalf, recorded as the act claim (RFC 8693 section
+// 4.1). A delegation passed on to another service nests the earlier actor under the later,
+// so the outermost Actor is the service that presented it last
+type Actor struct {
+    Identity string `json:"sub"`
+    Tenant   string `json:"tenant,omitempty"`
+    // TokenID is the jti of the service token the actor presented
+    TokenID string `json:"jti,omitempty"`
+    Actor   *Actor `json:"act,omitempty"`
+}
+
+// Chain lists the actors from the service that presented the token last to the first
+func (a *Actor) Chain() []*Actor {
+    var chain []*Actor
+    for ; a != nil; a = a.Actor {
+        chain = append(chain, a)
+    }
+    return chain
+}
+
+// OnBehalfOf derives the grant of a delegation token: the user's permissions and identity
+// with actor recorded as acting for them, bound to the key actor's token is bound to, and
+// how long it may live, ttl capped by both tokens' own expiry. subject may itself be a
+// delegation, for a service calling onwards, but actor may not. Sign it with
+// VollyAccessToken.SetOnBehalfOf(subject.TokenID)
+func OnBehalfOf(actor, subject *VollyVideoGrant, ttl time.Duration, now time.Time) (*VollyVideoGrant, time.Duration, error) {
+    switch {
+    case actor.Actor != nil || actor.ElevatedFrom != "" || actor.PQStatus == PQStatusViewer || actor.TokenID == "":
+        return nil, 0, ErrNotDelegable
+    case subject.ElevatedFrom != "" || subject.PQStatus == PQStatusViewer || subject.TokenID == "":
+        return nil, 0, ErrNotDelegable
+    case actor.Confirmation == nil:
+        return nil, 0, ErrDelegationUnbound
+    case actor.Tenant != subject.Tenant:
+        return nil, 0, ErrDelegationTenant
+    }
+    act := &Actor{Identity: actor.Identity, Tenant: actor.Tenant, TokenID: actor.TokenID, Actor: subject.Actor}
+    if len(act.Chain()) > maxDelegationDepth {
+        return nil, 0, ErrDelegationTooDeep
+    }
+    for _, exp := range []int64{actor.ExpiresAt, subject.ExpiresAt} {
+        if exp == 0 {
+            continue
+        }
+        left := time.Unix(exp, 0).Sub(now)
+        if left <= 0 {
+            return nil, 0, ErrDelegationExpired
+        }
+        if left < ttl {
+            ttl = left
+        }
+    }
+
+    delegated := &VollyVideoGrant{
+        VideoGrant:       subject.VideoGrant,
+        PQPublicKey:      subject.PQPublicKey,
+        PQAlgorithm:      subject.PQAlgorithm,
+        PQKeyExpiry:      subject.PQKeyExpiry,
+        PQKeyIssuedAt:    subject.PQKeyIssuedAt,
+        Tenant:           subject.Tenant,
+        Claims:           subject.Claims,
+        Tags:             subject.Tags,
+        AllowedCountries: subject.AllowedCountries,
+        DeniedCIDRs:      subject.DeniedCIDRs,
+        PINHash:          subject.PINHash,
+        EndTime:          subject.EndTime,
+        OnExpiry:         subject.OnExpiry,
+        // The service holds the token now, so it is bound to the service's key, not the user's
+        Confirmation: &Confirmation{KeyThumbprint: actor.Confirmation.KeyThumbprint, Algorithm: actor.Confirmation.Algorithm},
+        Actor:        act,
+        OnBehalfOf:   subject.TokenID,
+    }
+    return delegated, ttl, nil
+}
+
+// SetOnBehalfOf marks the token as a delegation of the user token with jti; call it after
+// AddGrant with a grant from OnBehalfOf
+func (t *VollyAccessToken) SetOnBehalfOf(jti string) *VollyAccessToken {
+    t.grant.OnBehalfOf = jti
+    return t
+}
+
+// actorFromClaim decodes the act claim, nil when it is absent or names no one
+func actorFromClaim(v interface{}, depth int) *Actor {
+    m, ok := v.(map[string]interface{})
+    if !ok || depth > maxDelegationDepth {
+        return nil
+    }
+    a := &Actor{}
+    a.Identity, _ = m["sub"].(string)
+    a.Tenant, _ = m["tenant"].(string)
+    a.TokenID, _ = m["jti"].(string)
+    if a.Identity == "" {
+        return nil
+    }
+    a.Actor = actorFromClaim(m["act"], depth+1)
+    return a
+}
+
+// delegationChain is the jti of every token a delegation was derived from, so revoking the
+// user's token or any service's revokes the delegation too
+func delegationChain(grant *VollyVideoGrant) []string {
+    var chain []string
+    if grant.OnBehalfOf != "" {
+        chain = append(chain, grant.OnBehalfOf)
+    }
+    for _, a := range grant.Actor.Chain() {
+        if a.TokenID != "" {
+            chain = append(chain, a.TokenID)
+        }
+    }
+    return chain
+}
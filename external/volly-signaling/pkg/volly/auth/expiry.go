@@ -0,0 +1,104 @@
+package auth
+
+import (
+    "errors"
+    "time"
+)
+
+// ExpiryAction is what the gateway does to a connection whose grant ran out mid-session
+type ExpiryAction string
+
+const (
+    // ExpiryDisconnect ends the connection as soon as the grant runs out; it is the default
+    ExpiryDisconnect ExpiryAction = "disconnect"
+    // ExpiryDowngrade takes the participant's publish rights away and lets them keep watching
+    // for the grace period, or until they leave when there is none
+    ExpiryDowngrade ExpiryAction = "downgrade"
+    // ExpiryGrace warns the participant and ends the connection once the grace period is over
+    ExpiryGrace ExpiryAction = "grace"
+)
+
+// MaxExpiryGrace bounds how long a grant may be honoured after it ran out
+const MaxExpiryGrace = time.Hour
+
+var ErrInvalidExpiryPolicy = errors.New("invalid expiry policy")
+
+// ExpiryPolicy is the onExpiry claim: what happens when exp or EndTime passes while the
+// holder is connected
+type ExpiryPolicy struct {
+    Action ExpiryAction `json:"action"`
+    // Grace is in seconds; grace needs it and downgrade may bound the viewing time with it
+    Grace int64 `json:"grace,omitempty"`
+}
+
+// Validate checks the action and that the grace period fits it
+func (p *ExpiryPolicy) Validate() error {
+    switch {
+    case p.Action != ExpiryDisconnect && p.Action != ExpiryDowngrade && p.Action != ExpiryGrace:
+        return ErrInvalidExpiryPolicy
+    case p.Grace < 0 || time.Duration(p.Grace)*time.Second > MaxExpiryGrace:
+        return ErrInvalidExpiryPolicy
+    case p.Action == ExpiryGrace && p.Grace == 0, p.Action == ExpiryDisconnect && p.Grace != 0:
+        return ErrInvalidExpiryPolicy
+    }
+    return nil
+}
+
+// GracePeriod is Grace as a duration
+func (p *ExpiryPolicy) GracePeriod() time.Duration {
+    if p == nil {
+        return 0
+    }
+    return time.Duration(p.Grace) * time.Second
+}
+
+// ExpiryAction is the grant's action on expiry, ExpiryDisconnect when it sets none
+func (g *VollyVideoGrant) ExpiryAction() ExpiryAction {
+    if g.OnExpiry == nil {
+        return ExpiryDisconnect
+    }
+    return g.OnExpiry.Action
+}
+
+// Deadline is when the grant runs out: EndTime when it comes before exp, else exp. It is
+// zero for grants that never do
+func (g *VollyVideoGrant) Deadline() time.Time {
+    switch {
+    case g.EndTime != 0 && (g.ExpiresAt == 0 || g.EndTime < g.ExpiresAt):
+        return time.Unix(g.EndTime, 0)
+    case g.ExpiresAt != 0:
+        return time.Unix(g.ExpiresAt, 0)
+    }
+    return time.Time{}
+}
+
+// SetEndTime ends the grant at t, e.g. when a booked session is over, even if the token is
+// valid for longer; the gateway applies the expiry policy to connections still open then
+func (t *VollyAccessToken) SetEndTime(end time.Time) *VollyAccessToken {
+    t.grant.EndTime = end.Unix()
+    return t
+}
+
+// SetExpiryPolicy sets what the gateway does when the grant runs out mid-session
+func (t *VollyAccessToken) SetExpiryPolicy(p *ExpiryPolicy) *VollyAccessToken {
+    t.grant.OnExpiry = p
+    return t
+}
+
+// expiryPolicyFromClaim decodes the onExpiry claim, nil when it is absent or invalid
+func expiryPolicyFromClaim(v interface{}) *ExpiryPolicy {
+    m, ok := v.(map[string]interface{})
+    if !ok {
+        return nil
+    }
+    p := &ExpiryPolicy{}
+    action, _ := m["action"].(string)
+    p.Action = ExpiryAction(action)
+    if grace, ok := m["grace"].(float64); ok {
+        p.Grace = int64(grace)
+    }
+    if p.Validate() != nil {
+        return nil
+    }
+    return p
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+    "context"
+    "crypto/hmac"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/codec"
+    "github.com/volly-org/volly-signaling/pkg/volly/secure"
+)
+
+var (
+    ErrInvalidToken      = errors.New("invalid token")
+    ErrTokenExpired      = errors.New("token has expired")
+    ErrUnsupportedSigner = errors.New("token signer does not sign HS256")
+)
+
+// jwtHeaderHS256 is the header LiveKit signs its tokens with, so LiveKit servers accept ours
+var jwtHeaderHS256 = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// TokenSigner computes HS256 MACs with a key the process never holds, such as a secret key on
+// an HSM; hsm.Signer is one
+type TokenSigner interface {
+    Algorithm() string
+    SignContext(ctx context.Context, message []byte) ([]byte, error)
+}
+
+// macFunc computes the HS256 MAC of a token's signing input
+type macFunc func(input string) ([]byte, error)
+
+// keyMAC computes MACs with key in memory
+func keyMAC(key []byte) macFunc {
+    return func(input string) ([]byte, error) {
+        if len(key) == 0 {
+            return nil, ErrSecretUnavailable
+        }
+        return mac(key, input), nil
+    }
+}
+
+// signerMAC computes MACs with signer, which must sign HS256
+func signerMAC(signer TokenSigner) macFunc {
+    return func(input string) ([]byte, error) {
+        if signer.Algorithm() != "HS256" {
+            return nil, ErrUnsupportedSigner
+        }
+        return signer.SignContext(context.Background(), []byte(input))
+    }
+}
+
+// signJWT signs claims as an HS256 JWT with key. Signing here rather than through LiveKit's
+// AccessToken lets a secret in protected memory be used in place, from inside Use
+func signJWT(key []byte, claims map[string]interface{}) (string, error) {
+    return signJWTWith(keyMAC(key), claims)
+}
+
+// signJWTWith signs claims as an HS256 JWT with the MAC m computes
+func signJWTWith(m macFunc, claims map[string]interface{}) (string, error) {
+    payload, err := json.Marshal(claims)
+    if err != nil {
+        return "", err
+    }
+    input := jwtHeaderHS256 + "." + base64.RawURLEncoding.EncodeToString(payload)
+    signature, err := m(input)
+    if err != nil {
+        return "", err
+    }
+    return input + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// verifyJWT checks an HS256 JWT's signature against the MAC m computes and its iss against
+// apiKey, requires an exp no more than leeway in the past and an nbf, if any, no more than
+// notBefore in the future, and returns its claims
+func verifyJWT(token, apiKey string, m macFunc, now time.Time, leeway, notBefore time.Duration) (map[string]interface{}, error) {
+    input, signature, err := splitToken(token)
+    if err != nil {
+        return nil, ErrInvalidToken
+    }
+    header, err := decodeHeader(input)
+    if err != nil || header.Alg != "HS256" {
+        return nil, ErrInvalidToken
+    }
+    expected, err := m(input)
+    if err != nil {
+        return nil, err
+    }
+    if !hmac.Equal(expected, signature) {
+        return nil, ErrInvalidToken
+    }
+    payload, err := decodePayload(input)
+    if err != nil {
+        return nil, ErrInvalidToken
+    }
+    claims, err := codec.UnmarshalObject(payload)
+    if err != nil || claims == nil {
+        return nil, ErrInvalidToken
+    }
+    if iss, _ := claims["iss"].(string); iss != apiKey {
+        return nil, ErrInvalidToken
+    }
+    exp, ok := claims["exp"].(float64)
+    if !ok {
+        return nil, ErrInvalidToken
+    }
+    if now.Add(-leeway).After(time.Unix(int64(exp), 0)) {
+        return nil, ErrTokenExpired
+    }
+    if nbf, ok := claims["nbf"].(float64); ok && now.Add(notBefore).Before(time.Unix(int64(nbf), 0)) {
+        return nil, ErrTokenNotYetValid
+    }
+    return claims, nil
+}
+
+// withSecret calls fn with secret, or with the bytes of plain when secret is nil. A secret in
+// protected memory is only ever read inside Use, so Close cannot release it under fn
+func withSecret(secret *secure.SecureBytes, plain string, fn func(key []byte) error) error {
+    if secret == nil {
+        return fn([]byte(plain))
+    }
+    if secret.Len() == 0 {
+        return ErrSecretUnavailable
+    }
+    return secret.Use(fn)
+}
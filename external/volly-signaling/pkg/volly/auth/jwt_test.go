@@ -0,0 +1,88 @@
+package auth
+
+import (
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/livekit/protocol/auth"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/secure"
+)
+
+const (
+    jwtAPIKey = "jwt-key"
+    jwtSecret = "jwt-secret-with-enough-entropy-00001"
+)
+
+// TestSecureSecretSigning signs with a secret in protected memory and verifies with the plain
+// one, and the other way round, and refuses both once the secret is closed
+func TestSecureSecretSigning(t *testing.T) {
+    secret, err := secure.FromString(jwtSecret)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer secret.Close()
+
+    token, err := NewVollyAccessTokenWithSecret(jwtAPIKey, secret).
+        AddGrant(&VollyVideoGrant{VideoGrant: auth.VideoGrant{RoomJoin: true, Room: "r"}}).
+        SetIdentity("alice").
+        ToJWT()
+    if err != nil {
+        t.Fatal(err)
+    }
+    grant, err := NewVerifier(jwtAPIKey, jwtSecret).SetLegacyPolicy(AdmitLegacy).Verify(token)
+    if err != nil {
+        t.Fatalf("plain verifier: %v", err)
+    }
+    if grant.Identity != "alice" || grant.Room != "r" || !grant.RoomJoin {
+        t.Fatalf("grant = %+v", grant)
+    }
+    if _, err := NewVerifierWithSecret(jwtAPIKey, secret).SetLegacyPolicy(AdmitLegacy).Verify(token); err != nil {
+        t.Fatalf("secure verifier: %v", err)
+    }
+
+    secret.Close()
+    if _, err := NewVerifierWithSecret(jwtAPIKey, secret).Verify(token); !errors.Is(err, ErrSecretUnavailable) {
+        t.Fatalf("verify with closed secret: got %v, want ErrSecretUnavailable", err)
+    }
+    _, err = NewVollyAccessTokenWithSecret(jwtAPIKey, secret).SetIdentity("alice").ToJWT()
+    if !errors.Is(err, ErrSecretUnavailable) {
+        t.Fatalf("sign with closed secret: got %v, want ErrSecretUnavailable", err)
+    }
+}
+
+// TestVerifyJWTWindow checks the signature, issuer, exp and nbf
+func TestVerifyJWTWindow(t *testing.T) {
+    now := time.Unix(1_700_000_000, 0)
+    sign := func(claims map[string]interface{}) string {
+        token, err := signJWT([]byte(jwtSecret), claims)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return token
+    }
+    valid := sign(map[string]interface{}{"iss": jwtAPIKey, "sub": "alice", "nbf": now.Unix(), "exp": now.Add(time.Hour).Unix()})
+
+    for _, tc := range []struct {
+        name  string
+        token string
+        key   string
+        at    time.Time
+        want  error
+    }{
+        {"valid", valid, jwtSecret, now, nil},
+        {"wrong secret", valid, "another-secret", now, ErrInvalidToken},
+        {"expired", valid, jwtSecret, now.Add(time.Hour + time.Minute), ErrTokenExpired},
+        {"expired within leeway", valid, jwtSecret, now.Add(time.Hour + 10*time.Second), nil},
+        {"not yet valid", valid, jwtSecret, now.Add(-2 * time.Minute), ErrTokenNotYetValid},
+        {"no exp", sign(map[string]interface{}{"iss": jwtAPIKey, "sub": "alice"}), jwtSecret, now, ErrInvalidToken},
+        {"other issuer", sign(map[string]interface{}{"iss": "other", "exp": now.Add(time.Hour).Unix()}), jwtSecret, now, ErrInvalidToken},
+    } {
+        _, err := NewVerifier(jwtAPIKey, tc.key).SetClock(clock.NewManual(tc.at)).SetLegacyPolicy(AdmitLegacy).Verify(tc.token)
+        if !errors.Is(err, tc.want) {
+            t.Errorf("%s: got %v, want %v", tc.name, err, tc.want)
+        }
+    }
+}
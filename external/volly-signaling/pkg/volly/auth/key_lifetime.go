@@ -0,0 +1,37 @@
+package auth
+
+import (
+    "errors"
+    "time"
+)
+
+// DefaultPQKeyLifetime is used by SetPostQuantumKey when no expiry is given
+const DefaultPQKeyLifetime = 24 * time.Hour
+
+var ErrPQKeyLifetime = errors.New("post-quantum key lifetime is outside policy")
+
+// KeyLifetimePolicy bounds how long an embedded PQ key may be valid; zero bounds are unchecked
+type KeyLifetimePolicy struct {
+    Min time.Duration
+    Max time.Duration
+}
+
+// Check validates a key lifetime; a zero issuedAt (tokens minted before
+// pqKeyIssuedAt existed) only bounds the remaining lifetime from now
+func (p KeyLifetimePolicy) Check(issuedAt, expiresAt, now time.Time) error {
+    if issuedAt.IsZero() {
+        if p.Max > 0 && expiresAt.Sub(now) > p.Max {
+            return ErrPQKeyLifetime
+        }
+        return nil
+    }
+
+    lifetime := expiresAt.Sub(issuedAt)
+    if p.Min > 0 && lifetime < p.Min {
+        return ErrPQKeyLifetime
+    }
+    if p.Max > 0 && lifetime > p.Max {
+        return ErrPQKeyLifetime
+    }
+    return nil
+}
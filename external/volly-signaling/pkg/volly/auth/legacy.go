@@ -0,0 +1,39 @@
+package auth
+
+import (
+    "errors"
+)
+
+// PQStatus records whether a verified token carried post-quantum claims
+type PQStatus string
+
+// PQ claim states reported on verified grants
+const (
+    PQStatusPresent PQStatus = "present"
+    // PQStatusAbsent marks a plain LiveKit token accepted in compatibility mode
+    PQStatusAbsent PQStatus = "absent"
+)
+
+var ErrPQClaimsMissing = errors.New("token has no post-quantum claims")
+
+// LegacyPolicy decides whether a token without PQ claims is admitted; return an error to reject
+type LegacyPolicy func(grant *VollyVideoGrant) error
+
+// AdmitLegacy accepts every plain LiveKit token
+func AdmitLegacy(grant *VollyVideoGrant) error {
+    return nil
+}
+
+// AdmitLegacyRooms accepts plain LiveKit tokens only for rooms not yet migrated to PQ
+func AdmitLegacyRooms(rooms ...string) LegacyPolicy {
+    allowed := make(map[string]bool, len(rooms))
+    for _, room := range rooms {
+        allowed[room] = true
+    }
+    return func(grant *VollyVideoGrant) error {
+        if !allowed[grant.Room] {
+            return ErrPQClaimsMissing
+        }
+        return nil
+    }
+}
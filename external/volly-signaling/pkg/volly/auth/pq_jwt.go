@@ -2,11 +2,24 @@ package auth
 
 import (
     "crypto/rand"
+    "crypto/sha256"
     "encoding/base64"
+    "encoding/binary"
+    "encoding/json"
     "errors"
+    "fmt"
+    "net/netip"
+    "strings"
     "time"
 
     "github.com/livekit/protocol/auth"
+    "github.com/livekit/protocol/livekit"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/codec"
+    "github.com/volly-org/volly-signaling/pkg/volly/dpop"
+    "github.com/volly-org/volly-signaling/pkg/volly/secure"
+    "github.com/volly-org/volly-signaling/pkg/volly/tagset"
 )
 
 // VollyVideoGrant extends LiveKit's VideoGrant with post-quantum support
@@ -17,15 +30,155 @@ type VollyVideoGrant struct {
     PQPublicKey string `json:"pqPublicKey,omitempty"`
     PQAlgorithm string `json:"pqAlgorithm,omitempty"`
     PQKeyExpiry int64  `json:"pqKeyExpiry,omitempty"`
+
+    // PQKeyIssuedAt lets verifiers enforce a key lifetime policy
+    PQKeyIssuedAt int64 `json:"pqKeyIssuedAt,omitempty"`
+
+    // PQStatus is set on verification and never serialized
+    PQStatus PQStatus `json:"-"`
+
+    // TokenID is the verified token's jti; TokenChain lists the jti of every
+    // ancestor of an attenuated token, root first
+    TokenID    string   `json:"-"`
+    TokenChain []string `json:"-"`
+
+    // Identity, IssuedAt, NotBefore and ExpiresAt are copied from the verified sub, iat, nbf
+    // and exp claims
+    Identity  string `json:"-"`
+    IssuedAt  int64  `json:"-"`
+    NotBefore int64  `json:"-"`
+    ExpiresAt int64  `json:"-"`
+
+    // ElevatedFrom is the jti of the session token an elevation token was issued against. It
+    // is in TokenChain too, so revoking the session token revokes its elevations
+    ElevatedFrom string `json:"-"`
+    // RenewedFrom is the session token a renewed token replaces. Its jti is in TokenChain
+    // too, so revoking the session token revokes every renewal of it
+    RenewedFrom *Renewal `json:"-"`
+
+    // Tenant scopes the token for multi-tenant revocation and limits
+    Tenant string `json:"tenant,omitempty"`
+    // Claims are the tenant's own claims, named without their volly.<tenant>. namespace
+    Claims map[string]interface{} `json:"claims,omitempty"`
+    // Tags attribute the token's usage to a cost center, project and the like
+    Tags map[string]string `json:"tags,omitempty"`
+
+    // Issuer and Audience name the deployment that minted the token and those meant to accept
+    // it. LiveKit keeps the API key in iss, so the issuer travels in its own claim
+    Issuer   string   `json:"-"`
+    Audience []string `json:"-"`
+
+    // Network restrictions enforced by the gateway at admission
+    AllowedCountries []string `json:"allowedCountries,omitempty"`
+    DeniedCIDRs      []string `json:"deniedCIDRs,omitempty"`
+
+    // Proof-of-possession binding to a client-held key (RFC 7800)
+    Confirmation *Confirmation `json:"cnf,omitempty"`
+
+    // PINHash is the keyed hash of a PIN the holder must enter to be admitted; see roompin
+    PINHash string `json:"pinHash,omitempty"`
+
+    // EndTime, in Unix seconds, ends the grant before exp, e.g. with a booked session, and
+    // OnExpiry is what the gateway does to a connection still open when either passes
+    EndTime  int64         `json:"endTime,omitempty"`
+    OnExpiry *ExpiryPolicy `json:"onExpiry,omitempty"`
+
+    // Provenance says which service minted the token and who authenticated its holder
+    Provenance *Provenance `json:"prov,omitempty"`
+
+    // Actor is the service holding a delegation token on the user's behalf, and OnBehalfOf
+    // the jti of the user token it was exchanged for. Both are in TokenChain too
+    Actor      *Actor `json:"act,omitempty"`
+    OnBehalfOf string `json:"obo,omitempty"`
+}
+
+// Renewal identifies the session token a renewed token replaces
+type Renewal struct {
+    TokenID  string `json:"jti"`
+    IssuedAt int64  `json:"iat"`
+}
+
+func renewalFromClaim(v interface{}) *Renewal {
+    m, ok := v.(map[string]interface{})
+    if !ok {
+        return nil
+    }
+    r := &Renewal{}
+    r.TokenID, _ = m["jti"].(string)
+    iat, _ := m["iat"].(float64)
+    r.IssuedAt = int64(iat)
+    if r.TokenID == "" {
+        return nil
+    }
+    return r
+}
+
+// Confirmation identifies the key a client must prove possession of to use the token
+type Confirmation struct {
+    KeyThumbprint string `json:"jkt"`
+    Algorithm     string `json:"alg"`
+}
+
+// PublicKey decodes the grant's PQ public key, or returns nil when it has none
+func (g *VollyVideoGrant) PublicKey() ([]byte, error) {
+    if g.PQPublicKey == "" {
+        return nil, nil
+    }
+    return codec.DecodeStd(g.PQPublicKey)
+}
+
+// KeyThumbprint returns the base64url SHA-256 digest of a public key, which names KEM keys.
+// It is not a JWK thumbprint; cnf.jkt holds JWKThumbprint
+func KeyThumbprint(publicKey []byte) string {
+    sum := sha256.Sum256(publicKey)
+    return base64.RawURLEncoding.EncodeToString(sum[:])
 }
 
+// JWKThumbprint returns the RFC 7638 thumbprint of an Ed25519 or ML-DSA public key, as
+// cnf.jkt names it and DPoP proofs carry it
+func JWKThumbprint(publicKey []byte, algorithm string) (string, error) {
+    jwk, err := dpop.NewJWK(algorithm, publicKey)
+    if err != nil {
+        return "", err
+    }
+    return jwk.Thumbprint()
+}
+
+// DefaultTTL is how long tokens are valid without SetValidFor, the same six hours LiveKit uses
+const DefaultTTL = 6 * time.Hour
+
 // VollyAccessToken extends LiveKit's AccessToken
 type VollyAccessToken struct {
     apiKey   string
     secret   string
     grant    *VollyVideoGrant
     identity string
+    kind     livekit.ParticipantInfo_Kind
     ttl      time.Duration
+    clock    clock.Clock
+    policy   *KeyLifetimePolicy
+    check    func(grant *VollyVideoGrant) error
+
+    // tokenID, when set, replaces the random jti
+    tokenID string
+
+    nbfGrace  time.Duration
+    jitter    time.Duration
+    expiresAt time.Time
+
+    budget       *TokenBudget
+    onOverBudget func(size TokenSize)
+
+    roles    *Roles
+    schemas  *ClaimSchemas
+    profiles *CryptoProfiles
+    // err is the first AddRole failure, returned by ToJWT
+    err error
+
+    // secureSecret, when set, replaces secret so the signing key never lives on the heap
+    secureSecret *secure.SecureBytes
+    // signer, when set, replaces both: the key never leaves it
+    signer TokenSigner
 }
 
 // NewVollyAccessToken creates an enhanced access token
@@ -34,10 +187,26 @@ func NewVollyAccessToken(apiKey, secret string) *VollyAccessToken {
         apiKey: apiKey,
         secret: secret,
         grant:  &VollyVideoGrant{},
-        ttl:    auth.DefaultTTL,
+        ttl:    DefaultTTL,
+        clock:  clock.System,
     }
 }
 
+// NewVollyAccessTokenWithSecret creates a token signed with a secret held in protected memory
+func NewVollyAccessTokenWithSecret(apiKey string, secret *secure.SecureBytes) *VollyAccessToken {
+    t := NewVollyAccessToken(apiKey, "")
+    t.secureSecret = secret
+    return t
+}
+
+// NewVollyAccessTokenWithSigner creates a token whose MAC signer computes, such as an HSM
+// holding the secret
+func NewVollyAccessTokenWithSigner(apiKey string, signer TokenSigner) *VollyAccessToken {
+    t := NewVollyAccessToken(apiKey, "")
+    t.signer = signer
+    return t
+}
+
 // AddGrant adds video grant permissions
 func (t *VollyAccessToken) AddGrant(grant *VollyVideoGrant) *VollyAccessToken {
     t.grant = grant
@@ -50,50 +219,486 @@ func (t *VollyAccessToken) SetIdentity(identity string) *VollyAccessToken {
     return t
 }
 
-// SetPostQuantumKey adds ML-KEM-768 public key to the token
+// SetTenant sets the tenant the token is issued for
+func (t *VollyAccessToken) SetTenant(tenant string) *VollyAccessToken {
+    t.grant.Tenant = tenant
+    return t
+}
+
+// SetIssuer names the deployment issuing the token, e.g. "volly-prod-eu"; call it after AddGrant
+func (t *VollyAccessToken) SetIssuer(issuer string) *VollyAccessToken {
+    t.grant.Issuer = issuer
+    return t
+}
+
+// SetAudience sets the verifiers the token is meant for; call it after AddGrant
+func (t *VollyAccessToken) SetAudience(audience ...string) *VollyAccessToken {
+    t.grant.Audience = audience
+    return t
+}
+
+// SetRoles sets the registry AddRole looks roles up in; DefaultRoles otherwise
+func (t *VollyAccessToken) SetRoles(roles *Roles) *VollyAccessToken {
+    t.roles = roles
+    return t
+}
+
+// AddRole widens the grant by a role's template, as the tenant set so far sees it; call it
+// after AddGrant and SetTenant, and set individual permissions after it to narrow the role.
+// An unknown role makes ToJWT fail with ErrUnknownRole
+func (t *VollyAccessToken) AddRole(name string) *VollyAccessToken {
+    roles := t.roles
+    if roles == nil {
+        roles = DefaultRoles
+    }
+    tmpl, err := roles.Template(t.grant.Tenant, name)
+    if err != nil {
+        if t.err == nil {
+            t.err = fmt.Errorf("%w: %q", err, name)
+        }
+        return t
+    }
+    mergeRole(&t.grant.VideoGrant, tmpl)
+    return t
+}
+
+// SetClaim adds a claim of the token's tenant, named without its namespace; it is signed as
+// volly.<tenant>.<name>. A value that cannot be encoded makes ToJWT fail
+func (t *VollyAccessToken) SetClaim(name string, value interface{}) *VollyAccessToken {
+    v, err := normalizeClaim(value)
+    if err != nil {
+        if t.err == nil {
+            t.err = fmt.Errorf("%w: %s: %v", ErrInvalidClaim, name, err)
+        }
+        return t
+    }
+    if t.grant.Claims == nil {
+        t.grant.Claims = make(map[string]interface{})
+    }
+    t.grant.Claims[name] = v
+    return t
+}
+
+// SetTags sets the token's cost-attribution tags, replacing any set before. Tags outside
+// tagset's limits make ToJWT fail
+func (t *VollyAccessToken) SetTags(tags map[string]string) *VollyAccessToken {
+    if err := tagset.Validate(tags); err != nil {
+        if t.err == nil {
+            t.err = err
+        }
+        return t
+    }
+    t.grant.Tags = nil
+    if len(tags) > 0 {
+        t.grant.Tags = make(map[string]string, len(tags))
+        for k, v := range tags {
+            t.grant.Tags[k] = v
+        }
+    }
+    return t
+}
+
+// SetClaimSchemas validates the tenant's claims against schemas before signing. Without
+// schemas claims are only checked to be in a tenant's namespace
+func (t *VollyAccessToken) SetClaimSchemas(schemas *ClaimSchemas) *VollyAccessToken {
+    t.schemas = schemas
+    return t
+}
+
+// SetKind sets the participant kind, e.g. agent for server-side bots
+func (t *VollyAccessToken) SetKind(kind livekit.ParticipantInfo_Kind) *VollyAccessToken {
+    t.kind = kind
+    return t
+}
+
+// SetValidFor sets how long the token is valid
+func (t *VollyAccessToken) SetValidFor(ttl time.Duration) *VollyAccessToken {
+    t.ttl = ttl
+    return t
+}
+
+// SetNotBeforeGrace backdates nbf by grace, so verifiers whose clocks lag the issuer's accept
+// the token as soon as it is handed out
+func (t *VollyAccessToken) SetNotBeforeGrace(grace time.Duration) *VollyAccessToken {
+    t.nbfGrace = grace
+    return t
+}
+
+// SetExpiryJitter shortens the validity by up to max, at most half the ttl, by an amount
+// derived from the jti. Tokens issued together for a large room then expire, and are
+// refreshed, spread over max rather than in the same second
+func (t *VollyAccessToken) SetExpiryJitter(max time.Duration) *VollyAccessToken {
+    t.jitter = max
+    return t
+}
+
+// ExpiresAt is the expiry of the token ToJWT last signed, jitter included
+func (t *VollyAccessToken) ExpiresAt() time.Time {
+    return t.expiresAt
+}
+
+// validFor is the ttl after jitter for a token with jti
+func (t *VollyAccessToken) validFor(jti string) time.Duration {
+    return t.ttl - expiryJitter(jti, t.jitter, t.ttl)
+}
+
+// expiryJitter picks whole seconds below max, and below half of ttl, from the jti: stable for
+// a token id, so every instance signing a canonical token agrees on its expiry
+func expiryJitter(jti string, max, ttl time.Duration) time.Duration {
+    if max > ttl/2 {
+        max = ttl / 2
+    }
+    secs := int64(max / time.Second)
+    if secs <= 0 {
+        return 0
+    }
+    sum := sha256.Sum256([]byte(jti))
+    return time.Duration(binary.BigEndian.Uint64(sum[:8])%uint64(secs)) * time.Second
+}
+
+// SetSizeBudget measures the token before signing: ToJWT fails with a *TokenBudgetError
+// past budget.MaxBytes and calls onWarn, which may be nil, past budget.WarnBytes
+func (t *VollyAccessToken) SetSizeBudget(budget TokenBudget, onWarn func(size TokenSize)) *VollyAccessToken {
+    t.budget = &budget
+    t.onOverBudget = onWarn
+    return t
+}
+
+// SetClock sets the time source for PQ key expiry; call it before SetPostQuantumKey
+func (t *VollyAccessToken) SetClock(c clock.Clock) *VollyAccessToken {
+    t.clock = c
+    return t
+}
+
+// SetCryptoProfiles makes ToJWT refuse tokens that fall short of their tenant's profile
+func (t *VollyAccessToken) SetCryptoProfiles(profiles *CryptoProfiles) *VollyAccessToken {
+    t.profiles = profiles
+    return t
+}
+
+// SetKeyLifetimePolicy makes ToJWT refuse PQ key expiries outside policy
+func (t *VollyAccessToken) SetKeyLifetimePolicy(policy KeyLifetimePolicy) *VollyAccessToken {
+    t.policy = &policy
+    return t
+}
+
+// SetIssuanceCheck runs check on the grant before signing, e.g. a least-privilege lint gate
+func (t *VollyAccessToken) SetIssuanceCheck(check func(grant *VollyVideoGrant) error) *VollyAccessToken {
+    t.check = check
+    return t
+}
+
+// SetPostQuantumKey adds ML-KEM-768 public key to the token, valid for DefaultPQKeyLifetime
 func (t *VollyAccessToken) SetPostQuantumKey(publicKey []byte, algorithm string) *VollyAccessToken {
+    return t.SetPostQuantumKeyWithExpiry(publicKey, algorithm, t.clock.Now().Add(DefaultPQKeyLifetime))
+}
+
+// SetPostQuantumKeyWithExpiry adds a PQ public key valid until expiresAt
+func (t *VollyAccessToken) SetPostQuantumKeyWithExpiry(publicKey []byte, algorithm string, expiresAt time.Time) *VollyAccessToken {
     t.grant.PQPublicKey = base64.StdEncoding.EncodeToString(publicKey)
     t.grant.PQAlgorithm = algorithm
-    t.grant.PQKeyExpiry = time.Now().Add(24 * time.Hour).Unix()
+    t.grant.PQKeyIssuedAt = t.clock.Now().Unix()
+    t.grant.PQKeyExpiry = expiresAt.Unix()
+    return t
+}
+
+// SetGeoRestriction limits where the token may be used; countries are ISO 3166-1 alpha-2 codes
+func (t *VollyAccessToken) SetGeoRestriction(allowedCountries, deniedCIDRs []string) *VollyAccessToken {
+    t.grant.AllowedCountries = allowedCountries
+    t.grant.DeniedCIDRs = deniedCIDRs
+    return t
+}
+
+// SetConfirmationKey binds the token to a client key pair (Ed25519 or ML-DSA); ToJWT fails
+// for other keys
+func (t *VollyAccessToken) SetConfirmationKey(publicKey []byte, algorithm string) *VollyAccessToken {
+    jkt, err := JWKThumbprint(publicKey, algorithm)
+    if err != nil {
+        if t.err == nil {
+            t.err = err
+        }
+        return t
+    }
+    return t.SetConfirmationThumbprint(jkt, algorithm)
+}
+
+// SetConfirmationThumbprint binds the token to the key whose RFC 7638 thumbprint is jkt,
+// e.g. the key of the DPoP proof the token was requested with
+func (t *VollyAccessToken) SetConfirmationThumbprint(jkt, algorithm string) *VollyAccessToken {
+    t.grant.Confirmation = &Confirmation{KeyThumbprint: jkt, Algorithm: algorithm}
+    return t
+}
+
+// SetPINHash makes the gateway ask for the PIN whose roompin hash is hash before admitting
+// the holder; call it after AddGrant
+func (t *VollyAccessToken) SetPINHash(hash string) *VollyAccessToken {
+    t.grant.PINHash = hash
+    return t
+}
+
+// SetElevatedFrom marks the token as a short-lived elevation of the session token with jti
+func (t *VollyAccessToken) SetElevatedFrom(jti string) *VollyAccessToken {
+    t.grant.ElevatedFrom = jti
+    return t
+}
+
+// SetRenewedFrom marks the token as a renewal of the session token with jti, first issued
+// at issuedAt; renewals of a renewal keep the first token's
+func (t *VollyAccessToken) SetRenewedFrom(jti string, issuedAt time.Time) *VollyAccessToken {
+    t.grant.RenewedFrom = &Renewal{TokenID: jti, IssuedAt: issuedAt.Unix()}
+    return t
+}
+
+// SetTokenID replaces the random jti, e.g. with a serial number from an offline bundle;
+// it must be unique per issuer or revoking one token revokes the others
+func (t *VollyAccessToken) SetTokenID(jti string) *VollyAccessToken {
+    t.tokenID = jti
     return t
 }
 
 // ToJWT generates the JWT token
 func (t *VollyAccessToken) ToJWT() (string, error) {
-    if t.identity == "" {
-        return "", errors.New("identity is required")
+    if err := t.validate(); err != nil {
+        return "", err
     }
     
-    // Create standard LiveKit token
-    at := auth.NewAccessToken(t.apiKey, t.secret).
-        AddGrant(&t.grant.VideoGrant).
-        SetIdentity(t.identity).
-        SetValidFor(t.ttl)
-    
+    // A unique jti lets tokens be revoked and attenuated tokens be bound to their parent
+    jti := t.tokenID
+    if jti == "" {
+        b := make([]byte, 16)
+        if _, err := rand.Read(b); err != nil {
+            return "", err
+        }
+        jti = base64.RawURLEncoding.EncodeToString(b)
+    }
+    ttl := t.validFor(jti)
+    if t.profiles != nil {
+        if err := t.profiles.Profile(t.grant.Tenant).Check(t.grant, ttl); err != nil {
+            return "", err
+        }
+    }
+    now := t.clock.Now()
+    payload := t.payload(t.claims(jti, now), now, ttl)
+
+    if t.budget != nil {
+        size, err := measureClaims(payload)
+        if err != nil {
+            return "", err
+        }
+        warn, err := t.budget.Check(size)
+        if err != nil {
+            return "", err
+        }
+        if warn && t.onOverBudget != nil {
+            t.onOverBudget(size)
+        }
+    }
+
+    // The secret is only read inside withSecret, never copied out of protected memory
+    var token string
+    var err error
+    if t.signer != nil {
+        token, err = signJWTWith(signerMAC(t.signer), payload)
+    } else {
+        err = withSecret(t.secureSecret, t.secret, func(key []byte) error {
+            var err error
+            token, err = signJWT(key, payload)
+            return err
+        })
+    }
+    if err != nil {
+        return "", err
+    }
+    t.expiresAt = time.Unix(now.Add(ttl).Unix(), 0)
+    return token, nil
+}
+
+// validate runs the checks that must pass before anything is signed or measured
+func (t *VollyAccessToken) validate() error {
+    if t.err != nil {
+        return t.err
+    }
+    if t.identity == "" {
+        return errors.New("identity is required")
+    }
+    for _, cidr := range t.grant.DeniedCIDRs {
+        if _, err := netip.ParsePrefix(cidr); err != nil {
+            return errors.New("invalid denied CIDR: " + cidr)
+        }
+    }
+    if t.grant.OnExpiry != nil {
+        if err := t.grant.OnExpiry.Validate(); err != nil {
+            return err
+        }
+    }
+    if t.schemas != nil {
+        if err := t.schemas.Validate(t.grant.Tenant, t.grant.Claims); err != nil {
+            return err
+        }
+    } else if err := checkClaimNamespace(t.grant.Tenant, t.grant.Claims); err != nil {
+        return err
+    }
+    if t.check != nil {
+        if err := t.check(t.grant); err != nil {
+            return err
+        }
+    }
+    if t.policy != nil && t.grant.PQPublicKey != "" {
+        var issuedAt time.Time
+        if t.grant.PQKeyIssuedAt != 0 {
+            issuedAt = time.Unix(t.grant.PQKeyIssuedAt, 0)
+        }
+        expiresAt := time.Unix(t.grant.PQKeyExpiry, 0)
+        if err := t.policy.Check(issuedAt, expiresAt, t.clock.Now()); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// claims are the Volly claims added to LiveKit's
+func (t *VollyAccessToken) claims(jti string, now time.Time) map[string]interface{} {
+    claims := map[string]interface{}{
+        "jti": jti,
+        // iat lets revocation cut off every matching token issued before a point in time
+        "iat": now.Unix(),
+    }
+    if t.nbfGrace > 0 {
+        claims["nbf"] = now.Add(-t.nbfGrace).Unix()
+    }
+    if t.grant.Tenant != "" {
+        claims["tenant"] = t.grant.Tenant
+    }
+    if t.grant.Issuer != "" {
+        claims["issuer"] = t.grant.Issuer
+    }
+    if t.grant.ElevatedFrom != "" {
+        claims["elev"] = t.grant.ElevatedFrom
+    }
+    if t.grant.RenewedFrom != nil {
+        claims["ren"] = t.grant.RenewedFrom
+    }
+    if len(t.grant.Tags) > 0 {
+        claims["tags"] = t.grant.Tags
+    }
+    if t.grant.Provenance != nil {
+        claims["prov"] = t.grant.Provenance
+    }
+    if t.grant.Actor != nil {
+        claims["act"] = t.grant.Actor
+    }
+    if t.grant.OnBehalfOf != "" {
+        claims["obo"] = t.grant.OnBehalfOf
+    }
+    switch len(t.grant.Audience) {
+    case 0:
+    case 1:
+        claims["aud"] = t.grant.Audience[0]
+    default:
+        claims["aud"] = t.grant.Audience
+    }
+
     // Add custom claims for post-quantum support
-    at.AddClaim("pqPublicKey", t.grant.PQPublicKey)
-    at.AddClaim("pqAlgorithm", t.grant.PQAlgorithm)
-    at.AddClaim("pqKeyExpiry", t.grant.PQKeyExpiry)
-    
-    return at.ToJWT()
+    claims["pqPublicKey"] = t.grant.PQPublicKey
+    claims["pqAlgorithm"] = t.grant.PQAlgorithm
+    claims["pqKeyExpiry"] = t.grant.PQKeyExpiry
+    if t.grant.PQKeyIssuedAt != 0 {
+        claims["pqKeyIssuedAt"] = t.grant.PQKeyIssuedAt
+    }
+
+    if len(t.grant.AllowedCountries) > 0 {
+        claims["allowedCountries"] = t.grant.AllowedCountries
+    }
+    if len(t.grant.DeniedCIDRs) > 0 {
+        claims["deniedCIDRs"] = t.grant.DeniedCIDRs
+    }
+    if t.grant.PINHash != "" {
+        claims["pinHash"] = t.grant.PINHash
+    }
+    if t.grant.EndTime != 0 {
+        claims["endTime"] = t.grant.EndTime
+    }
+    if t.grant.OnExpiry != nil {
+        claims["onExpiry"] = t.grant.OnExpiry
+    }
+    if cnf := t.grant.Confirmation; cnf != nil {
+        claims["cnf"] = map[string]string{
+            "jkt": cnf.KeyThumbprint,
+            "alg": cnf.Algorithm,
+        }
+    }
+    for name, value := range t.grant.Claims {
+        claims[TenantClaim(t.grant.Tenant, name)] = value
+    }
+    return claims
+}
+
+// payload adds the claims LiveKit reads to claims, giving everything the token signs
+func (t *VollyAccessToken) payload(claims map[string]interface{}, now time.Time, ttl time.Duration) map[string]interface{} {
+    all := map[string]interface{}{
+        "iss":   t.apiKey,
+        "sub":   t.identity,
+        "exp":   now.Add(ttl).Unix(),
+        "nbf":   now.Unix(),
+        "video": &t.grant.VideoGrant,
+    }
+    if t.kind != livekit.ParticipantInfo_STANDARD {
+        all["kind"] = strings.ToLower(t.kind.String())
+    }
+    for name, value := range claims {
+        all[name] = value
+    }
+    return all
 }
 
-// VerifyVollyToken verifies and extracts post-quantum data from token
+// Size computes the length of the token ToJWT would sign now, and its biggest claims,
+// without signing it
+func (t *VollyAccessToken) Size() (TokenSize, error) {
+    if err := t.validate(); err != nil {
+        return TokenSize{}, err
+    }
+    jti := t.tokenID
+    if jti == "" {
+        // Random jtis are always this long
+        jti = strings.Repeat("A", base64.RawURLEncoding.EncodedLen(16))
+    }
+    now := t.clock.Now()
+    return measureClaims(t.payload(t.claims(jti, now), now, t.validFor(jti)))
+}
+
+// VerifyVollyToken verifies and extracts post-quantum data from token, allowing LiveKit's
+// minute of clock skew on exp and nbf
 func VerifyVollyToken(token, apiKey, secret string) (*VollyVideoGrant, error) {
-    // Verify standard LiveKit token
-    verifier := auth.NewAccessToken(apiKey, secret)
-    grant, err := verifier.Verify(token)
+    return verifyVollyToken(token, apiKey, keyMAC([]byte(secret)), clock.System.Now(), DefaultNotBeforeLeeway, DefaultNotBeforeLeeway)
+}
+
+func verifyVollyToken(token, apiKey string, m macFunc, now time.Time, leeway, notBefore time.Duration) (*VollyVideoGrant, error) {
+    claims, err := verifyJWT(token, apiKey, m, now, leeway, notBefore)
     if err != nil {
         return nil, err
     }
-    
-    // Extract post-quantum claims
-    vollyGrant := &VollyVideoGrant{
-        VideoGrant: *grant.Video,
+    var video *auth.VideoGrant
+    if raw, ok := claims["video"]; ok {
+        data, err := json.Marshal(raw)
+        if err != nil {
+            return nil, err
+        }
+        video = &auth.VideoGrant{}
+        if err := json.Unmarshal(data, video); err != nil {
+            return nil, ErrInvalidToken
+        }
     }
-    
+    return grantFromClaims(video, claims), nil
+}
+
+// grantFromClaims extracts post-quantum data from verified custom claims
+func grantFromClaims(video *auth.VideoGrant, claims map[string]interface{}) *VollyVideoGrant {
+    vollyGrant := &VollyVideoGrant{}
+    if video != nil {
+        vollyGrant.VideoGrant = *video
+    }
+
     // Parse custom claims
-    claims := verifier.Claims()
     if pqKey, ok := claims["pqPublicKey"].(string); ok {
         vollyGrant.PQPublicKey = pqKey
     }
@@ -103,6 +708,90 @@ func VerifyVollyToken(token, apiKey, secret string) (*VollyVideoGrant, error) {
     if pqExp, ok := claims["pqKeyExpiry"].(float64); ok {
         vollyGrant.PQKeyExpiry = int64(pqExp)
     }
+    if pqIat, ok := claims["pqKeyIssuedAt"].(float64); ok {
+        vollyGrant.PQKeyIssuedAt = int64(pqIat)
+    }
+    if jti, ok := claims["jti"].(string); ok {
+        vollyGrant.TokenID = jti
+    }
+    if sub, ok := claims["sub"].(string); ok {
+        vollyGrant.Identity = sub
+    }
+    if iat, ok := claims["iat"].(float64); ok {
+        vollyGrant.IssuedAt = int64(iat)
+    }
+    if exp, ok := claims["exp"].(float64); ok {
+        vollyGrant.ExpiresAt = int64(exp)
+    }
+    if nbf, ok := claims["nbf"].(float64); ok {
+        vollyGrant.NotBefore = int64(nbf)
+    }
+    if tenant, ok := claims["tenant"].(string); ok {
+        vollyGrant.Tenant = tenant
+    }
+    vollyGrant.Claims = tenantClaims(vollyGrant.Tenant, claims)
+    if issuer, ok := claims["issuer"].(string); ok {
+        vollyGrant.Issuer = issuer
+    }
+    if tags, ok := claims["tags"].(map[string]interface{}); ok && len(tags) > 0 {
+        vollyGrant.Tags = make(map[string]string, len(tags))
+        for k, v := range tags {
+            if s, ok := v.(string); ok {
+                vollyGrant.Tags[k] = s
+            }
+        }
+    }
+    vollyGrant.Provenance = provenanceFromClaim(claims["prov"])
+    if elev, ok := claims["elev"].(string); ok && elev != "" {
+        vollyGrant.ElevatedFrom = elev
+        vollyGrant.TokenChain = append(vollyGrant.TokenChain, elev)
+    }
+    if ren := renewalFromClaim(claims["ren"]); ren != nil {
+        vollyGrant.RenewedFrom = ren
+        vollyGrant.TokenChain = append(vollyGrant.TokenChain, ren.TokenID)
+    }
+    vollyGrant.Actor = actorFromClaim(claims["act"], 1)
+    vollyGrant.OnBehalfOf, _ = claims["obo"].(string)
+    vollyGrant.TokenChain = append(vollyGrant.TokenChain, delegationChain(vollyGrant)...)
+    // aud is a string or an array of them (RFC 7519 section 4.1.3)
+    if aud, ok := claims["aud"].(string); ok {
+        vollyGrant.Audience = []string{aud}
+    } else {
+        vollyGrant.Audience = stringSliceClaim(claims["aud"])
+    }
+    vollyGrant.PQStatus = PQStatusAbsent
+    if vollyGrant.PQPublicKey != "" {
+        vollyGrant.PQStatus = PQStatusPresent
+    }
+    vollyGrant.AllowedCountries = stringSliceClaim(claims["allowedCountries"])
+    vollyGrant.DeniedCIDRs = stringSliceClaim(claims["deniedCIDRs"])
+    vollyGrant.PINHash, _ = claims["pinHash"].(string)
+    if end, ok := claims["endTime"].(float64); ok {
+        vollyGrant.EndTime = int64(end)
+    }
+    vollyGrant.OnExpiry = expiryPolicyFromClaim(claims["onExpiry"])
+    if cnf, ok := claims["cnf"].(map[string]interface{}); ok {
+        jkt, _ := cnf["jkt"].(string)
+        alg, _ := cnf["alg"].(string)
+        if jkt != "" {
+            vollyGrant.Confirmation = &Confirmation{KeyThumbprint: jkt, Algorithm: alg}
+        }
+    }
     
-    return vollyGrant, nil
-}
\ No newline at end of file
+    return vollyGrant
+}
+
+// stringSliceClaim converts a decoded JSON array claim into strings
+func stringSliceClaim(v interface{}) []string {
+    items, ok := v.([]interface{})
+    if !ok {
+        return nil
+    }
+    out := make([]string, 0, len(items))
+    for _, item := range items {
+        if s, ok := item.(string); ok {
+            out = append(out, s)
+        }
+    }
+    return out
+}
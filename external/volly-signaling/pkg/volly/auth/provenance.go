@@ -0,0 +1,117 @@
+package auth
+
+import (
+    "errors"
+    "fmt"
+)
+
+// Providers a Provenance names
+const (
+    // ProviderAPIKey is a tenant's backend vouching for the user itself
+    ProviderAPIKey  = "api-key"
+    ProviderOIDC    = "oidc"
+    ProviderSAML    = "saml"
+    ProviderPasskey = "passkey"
+    // ProviderMatrix is a Matrix homeserver vouching for the user with an OpenID token
+    ProviderMatrix = "matrix"
+    // ProviderGuest is a user nobody authenticated, e.g. one who followed an invite link
+    ProviderGuest = "guest"
+)
+
+var (
+    ErrProvenanceMissing  = errors.New("token carries no provenance")
+    ErrProvenanceRejected = errors.New("token provenance is not accepted")
+    ErrUnknownProvider    = errors.New("unknown provenance provider")
+)
+
+// KnownProvider reports whether name is one of the providers above
+func KnownProvider(name string) bool {
+    switch name {
+    case ProviderAPIKey, ProviderOIDC, ProviderSAML, ProviderPasskey, ProviderMatrix, ProviderGuest:
+        return true
+    }
+    return false
+}
+
+// Provenance records where a token came from: the service that minted it and its version,
+// and the provider that authenticated the holder. A token minted from another, like an
+// elevation from its session token, keeps the provider and lists the earlier issuers in Chain
+type Provenance struct {
+    Service  string `json:"svc"`
+    Version  string `json:"ver,omitempty"`
+    Provider string `json:"prv"`
+    // Chain lists the services that issued the tokens this one derives from, oldest first
+    Chain []string `json:"via,omitempty"`
+}
+
+// Derive returns the provenance of a token service mints from one with p
+func (p *Provenance) Derive(service, version string) *Provenance {
+    if p == nil {
+        return nil
+    }
+    chain := append(append([]string(nil), p.Chain...), p.Service)
+    return &Provenance{Service: service, Version: version, Provider: p.Provider, Chain: chain}
+}
+
+// ProvenancePolicy is the provenance a Verifier accepts. The zero policy accepts any token,
+// with or without provenance
+type ProvenancePolicy struct {
+    // Require rejects tokens without provenance, such as those minted before it existed and
+    // viewer tokens
+    Require bool `json:"require,omitempty"`
+    // Services lists the issuing services accepted, and must list every service in a
+    // token's chain too; empty accepts any
+    Services []string `json:"services,omitempty"`
+    // Providers lists the providers accepted; empty accepts any
+    Providers []string `json:"providers,omitempty"`
+}
+
+// Check applies the policy to a grant's provenance, which may be nil
+func (p ProvenancePolicy) Check(prov *Provenance) error {
+    if prov == nil {
+        if p.Require {
+            return ErrProvenanceMissing
+        }
+        return nil
+    }
+    if len(p.Providers) > 0 && !contains(p.Providers, prov.Provider) {
+        return fmt.Errorf("%w: provider %q", ErrProvenanceRejected, prov.Provider)
+    }
+    if len(p.Services) == 0 {
+        return nil
+    }
+    for _, service := range append(append([]string(nil), prov.Chain...), prov.Service) {
+        if !contains(p.Services, service) {
+            return fmt.Errorf("%w: service %q", ErrProvenanceRejected, service)
+        }
+    }
+    return nil
+}
+
+// SetProvenance records where the token came from; call it after AddGrant
+func (t *VollyAccessToken) SetProvenance(p *Provenance) *VollyAccessToken {
+    t.grant.Provenance = p
+    return t
+}
+
+// SetProvenancePolicy rejects tokens whose provenance policy does not accept
+func (v *Verifier) SetProvenancePolicy(policy ProvenancePolicy) *Verifier {
+    v.provenance = policy
+    return v
+}
+
+// provenanceFromClaim decodes the prov claim, nil when it is absent or has no service
+func provenanceFromClaim(v interface{}) *Provenance {
+    m, ok := v.(map[string]interface{})
+    if !ok {
+        return nil
+    }
+    p := &Provenance{Chain: stringSliceClaim(m["via"])}
+    p.Service, _ = m["svc"].(string)
+    p.Version, _ = m["ver"].(string)
+    p.Provider, _ = m["prv"].(string)
+    if p.Service == "" {
+        return nil
+    }
+    return p
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+    "errors"
+    "sort"
+    "sync"
+
+    "github.com/livekit/protocol/auth"
+)
+
+// Built-in roles
+const (
+    RoleHost     = "host"
+    RoleCohost   = "cohost"
+    RoleSpeaker  = "speaker"
+    RoleViewer   = "viewer"
+    RoleRecorder = "recorder"
+)
+
+var ErrUnknownRole = errors.New("unknown role")
+
+func flag(v bool) *bool { return &v }
+
+// builtinRoles are the templates every registry starts with. None names a room: the token's
+// grant keeps its own
+var builtinRoles = map[string]auth.VideoGrant{
+    RoleHost: {
+        RoomJoin: true, RoomAdmin: true, RoomRecord: true,
+        CanPublish: flag(true), CanSubscribe: flag(true), CanPublishData: flag(true), CanUpdateOwnMetadata: flag(true),
+    },
+    RoleCohost: {
+        RoomJoin: true, RoomAdmin: true,
+        CanPublish: flag(true), CanSubscribe: flag(true), CanPublishData: flag(true), CanUpdateOwnMetadata: flag(true),
+    },
+    RoleSpeaker: {
+        RoomJoin:   true,
+        CanPublish: flag(true), CanSubscribe: flag(true), CanPublishData: flag(true),
+    },
+    RoleViewer: {
+        RoomJoin:   true,
+        CanPublish: flag(false), CanSubscribe: flag(true), CanPublishData: flag(false),
+    },
+    RoleRecorder: {
+        RoomJoin: true, Hidden: true, Recorder: true,
+        CanPublish: flag(false), CanSubscribe: flag(true), CanPublishData: flag(false),
+    },
+}
+
+// Roles maps role names to grant templates, so product code asks for "speaker" instead of
+// assembling the grant by hand. Tenants may override a role or define their own; lookups
+// fall back from the tenant's templates to the deployment's
+type Roles struct {
+    mu      sync.RWMutex
+    roles   map[string]auth.VideoGrant
+    tenants map[string]map[string]auth.VideoGrant
+}
+
+// NewRoles creates a registry holding the built-in roles
+func NewRoles() *Roles {
+    r := &Roles{
+        roles:   make(map[string]auth.VideoGrant, len(builtinRoles)),
+        tenants: make(map[string]map[string]auth.VideoGrant),
+    }
+    for name, tmpl := range builtinRoles {
+        r.roles[name] = tmpl
+    }
+    return r
+}
+
+// DefaultRoles is the registry tokens use unless given another with SetRoles
+var DefaultRoles = NewRoles()
+
+// Define adds or replaces a role for every tenant
+func (r *Roles) Define(name string, tmpl auth.VideoGrant) *Roles {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.roles[name] = tmpl
+    return r
+}
+
+// Override adds or replaces a role for one tenant
+func (r *Roles) Override(tenant, name string, tmpl auth.VideoGrant) *Roles {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    if r.tenants[tenant] == nil {
+        r.tenants[tenant] = make(map[string]auth.VideoGrant)
+    }
+    r.tenants[tenant][name] = tmpl
+    return r
+}
+
+// Template returns the grant a role confers in tenant
+func (r *Roles) Template(tenant, name string) (auth.VideoGrant, error) {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    if tmpl, ok := r.tenants[tenant][name]; ok {
+        return tmpl, nil
+    }
+    if tmpl, ok := r.roles[name]; ok {
+        return tmpl, nil
+    }
+    return auth.VideoGrant{}, ErrUnknownRole
+}
+
+// Names lists the roles tenant can use, sorted
+func (r *Roles) Names(tenant string) []string {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    seen := make(map[string]bool, len(r.roles))
+    for name := range r.roles {
+        seen[name] = true
+    }
+    for name := range r.tenants[tenant] {
+        seen[name] = true
+    }
+    names := make([]string, 0, len(seen))
+    for name := range seen {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// mergeRole widens grant by tmpl: a permission either grants is granted. A publish flag
+// only one of them sets is taken as set, and a source list only stays limited while both
+// limit it
+func mergeRole(grant *auth.VideoGrant, tmpl auth.VideoGrant) {
+    grant.RoomCreate = grant.RoomCreate || tmpl.RoomCreate
+    grant.RoomList = grant.RoomList || tmpl.RoomList
+    grant.RoomRecord = grant.RoomRecord || tmpl.RoomRecord
+    grant.RoomAdmin = grant.RoomAdmin || tmpl.RoomAdmin
+    grant.RoomJoin = grant.RoomJoin || tmpl.RoomJoin
+    grant.IngressAdmin = grant.IngressAdmin || tmpl.IngressAdmin
+    grant.Hidden = grant.Hidden || tmpl.Hidden
+    grant.Recorder = grant.Recorder || tmpl.Recorder
+    grant.Agent = grant.Agent || tmpl.Agent
+    // A publisher with no source list may publish every source. LiveKit reads an unset
+    // canPublish as true, but here it means the grant has not decided yet
+    unlimited := grant.CanPublish != nil && *grant.CanPublish && len(grant.CanPublishSources) == 0
+    mergeFlag := func(dst **bool, src *bool) {
+        if src != nil && (*dst == nil || *src) {
+            v := *src
+            *dst = &v
+        }
+    }
+    mergeFlag(&grant.CanPublish, tmpl.CanPublish)
+    mergeFlag(&grant.CanSubscribe, tmpl.CanSubscribe)
+    mergeFlag(&grant.CanPublishData, tmpl.CanPublishData)
+    mergeFlag(&grant.CanUpdateOwnMetadata, tmpl.CanUpdateOwnMetadata)
+    switch {
+    case unlimited:
+    case len(tmpl.CanPublishSources) == 0:
+        if tmpl.CanPublish != nil && *tmpl.CanPublish {
+            grant.CanPublishSources = nil
+        }
+    default:
+        seen := make(map[string]bool, len(grant.CanPublishSources))
+        for _, s := range grant.CanPublishSources {
+            seen[s] = true
+        }
+        for _, s := range tmpl.CanPublishSources {
+            if !seen[s] {
+                grant.CanPublishSources = append(grant.CanPublishSources, s)
+                seen[s] = true
+            }
+        }
+    }
+}
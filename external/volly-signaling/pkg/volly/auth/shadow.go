@@ -0,0 +1,275 @@
+package auth
+
+import (
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "io"
+    "math/rand"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/livekit/protocol/auth"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/codec"
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+)
+
+// Outcomes of a shadow verification
+const (
+    // ShadowMatch is a candidate verification that reproduced the primary's grant
+    ShadowMatch = "match"
+    // ShadowNoKey is a token whose API key has no candidate key yet
+    ShadowNoKey = "no_candidate_key"
+    // ShadowSignFailed and ShadowRejected are failures to sign or verify the candidate form
+    ShadowSignFailed = "sign_failed"
+    ShadowRejected   = "candidate_rejected"
+    // ShadowMismatch is a candidate grant that differs from the primary's
+    ShadowMismatch = "grant_mismatch"
+    // ShadowTooLarge is a candidate form over the claim limits' token size
+    ShadowTooLarge = "too_large"
+    // ShadowPrimaryRejected, ShadowUnsupported and ShadowDropped are tokens not compared:
+    // ones the primary turned away, viewer and attenuated tokens, and ones that arrived
+    // while every shadow worker was busy
+    ShadowPrimaryRejected = "primary_rejected"
+    ShadowUnsupported     = "unsupported"
+    ShadowDropped         = "dropped"
+)
+
+// DefaultShadowConcurrency bounds the shadow verifications in flight
+const DefaultShadowConcurrency = 4
+
+// CandidateKeys is the key set being migrated to, by API key
+type CandidateKeys interface {
+    CandidateKey(apiKey string) (*crypto.KeyPair, bool)
+}
+
+// CandidateKeySet is a fixed CandidateKeys
+type CandidateKeySet map[string]*crypto.KeyPair
+
+// CandidateKey returns the candidate key for apiKey
+func (s CandidateKeySet) CandidateKey(apiKey string) (*crypto.KeyPair, bool) {
+    kp, ok := s[apiKey]
+    return kp, ok
+}
+
+// ShadowStats counts shadow verifications since start
+type ShadowStats struct {
+    Outcomes map[string]int64 `json:"outcomes"`
+    // CandidateSeconds is the time spent signing and verifying candidate forms
+    CandidateSeconds float64 `json:"candidateSeconds"`
+    // MaxTokenBytes is the largest candidate form seen
+    MaxTokenBytes int `json:"maxTokenBytes"`
+}
+
+// Shadow checks verified tokens against a candidate signing algorithm and key set without
+// affecting the primary decision, to measure readiness before a cutover such as HMAC to
+// ML-DSA. A token signed under the primary key carries no candidate signature, so the
+// shadow re-signs its payload with the candidate key of its API key, verifies that as the
+// candidate verifier would and compares the grant with the primary's. Safe for concurrent use
+type Shadow struct {
+    keys   CandidateKeys
+    limits ClaimLimits
+    rate   float64
+    slots  chan struct{}
+
+    mu       sync.Mutex
+    outcomes map[string]int64
+    elapsed  time.Duration
+    maxBytes int
+}
+
+// NewShadow creates a shadow that checks every token against keys
+func NewShadow(keys CandidateKeys) *Shadow {
+    return &Shadow{
+        keys:     keys,
+        limits:   DefaultClaimLimits,
+        rate:     1,
+        slots:    make(chan struct{}, DefaultShadowConcurrency),
+        outcomes: make(map[string]int64),
+    }
+}
+
+// SetClaimLimits sets the limits a candidate form must fit, normally the verifier's
+func (s *Shadow) SetClaimLimits(limits ClaimLimits) *Shadow {
+    s.limits = limits
+    return s
+}
+
+// SetSampleRate checks only a fraction of tokens, between 0 and 1
+func (s *Shadow) SetSampleRate(rate float64) *Shadow {
+    s.rate = rate
+    return s
+}
+
+// SetConcurrency bounds the checks in flight; tokens arriving beyond it are dropped
+func (s *Shadow) SetConcurrency(n int) *Shadow {
+    s.slots = make(chan struct{}, n)
+    return s
+}
+
+// Observe shadows the primary's verification of token, which named apiKey and produced
+// grant or err. It never blocks on the check and never changes the primary result
+func (s *Shadow) Observe(apiKey, token string, grant *VollyVideoGrant, err error) {
+    switch {
+    case err != nil:
+        s.count(ShadowPrimaryRejected, 0, 0)
+        return
+    case IsViewerToken(token) || IsAttenuated(token):
+        s.count(ShadowUnsupported, 0, 0)
+        return
+    case s.rate < 1 && rand.Float64() >= s.rate:
+        return
+    }
+    select {
+    case s.slots <- struct{}{}:
+    default:
+        s.count(ShadowDropped, 0, 0)
+        return
+    }
+    go func() {
+        defer func() { <-s.slots }()
+        start := time.Now()
+        outcome, size := s.check(apiKey, token, grant)
+        s.count(outcome, time.Since(start), size)
+    }()
+}
+
+func (s *Shadow) check(apiKey, token string, primary *VollyVideoGrant) (string, int) {
+    kp, ok := s.keys.CandidateKey(apiKey)
+    if !ok {
+        return ShadowNoKey, 0
+    }
+    candidate, err := signCandidate(token, apiKey, crypto.NewSigner(kp))
+    if err != nil {
+        return ShadowSignFailed, 0
+    }
+    if s.limits.MaxTokenBytes > 0 && len(candidate) > s.limits.MaxTokenBytes {
+        return ShadowTooLarge, len(candidate)
+    }
+    grant, err := verifyCandidate(candidate, kp.Algorithm, kp.PublicKey)
+    if err != nil {
+        return ShadowRejected, len(candidate)
+    }
+    if !sameGrant(primary, grant) {
+        return ShadowMismatch, len(candidate)
+    }
+    return ShadowMatch, len(candidate)
+}
+
+func (s *Shadow) count(outcome string, elapsed time.Duration, size int) {
+    s.mu.Lock()
+    s.outcomes[outcome]++
+    s.elapsed += elapsed
+    if size > s.maxBytes {
+        s.maxBytes = size
+    }
+    s.mu.Unlock()
+}
+
+// Stats reports the outcomes so far
+func (s *Shadow) Stats() ShadowStats {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    out := ShadowStats{Outcomes: make(map[string]int64, len(s.outcomes)), CandidateSeconds: s.elapsed.Seconds(), MaxTokenBytes: s.maxBytes}
+    for k, v := range s.outcomes {
+        out.Outcomes[k] = v
+    }
+    return out
+}
+
+// WriteMetrics writes the shadow's counters in the Prometheus text format
+func (s *Shadow) WriteMetrics(w io.Writer) error {
+    stats := s.Stats()
+    outcomes := make([]string, 0, len(stats.Outcomes))
+    for k := range stats.Outcomes {
+        outcomes = append(outcomes, k)
+    }
+    sort.Strings(outcomes)
+    metric := func(name, kind, help string) {
+        fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+    }
+    metric("volly_shadow_verifications_total", "counter", "Tokens checked against the candidate key set by outcome.")
+    for _, k := range outcomes {
+        fmt.Fprintf(w, "volly_shadow_verifications_total{outcome=%q} %d\n", k, stats.Outcomes[k])
+    }
+    metric("volly_shadow_candidate_seconds_total", "counter", "Time spent signing and verifying candidate tokens.")
+    fmt.Fprintf(w, "volly_shadow_candidate_seconds_total %g\n", stats.CandidateSeconds)
+    metric("volly_shadow_candidate_token_bytes_max", "gauge", "Largest candidate token seen.")
+    _, err := fmt.Fprintf(w, "volly_shadow_candidate_token_bytes_max %d\n", stats.MaxTokenBytes)
+    return err
+}
+
+// signCandidate re-signs token's payload, byte for byte, under signer with a header naming
+// the candidate algorithm and apiKey
+func signCandidate(token, apiKey string, signer crypto.Signer) (string, error) {
+    input, _, err := splitToken(token)
+    if err != nil {
+        return "", err
+    }
+    _, payload, _ := strings.Cut(input, ".")
+    header, err := json.Marshal(map[string]string{"alg": signer.Algorithm(), "typ": "JWT", "kid": apiKey})
+    if err != nil {
+        return "", err
+    }
+    candidateInput := base64.RawURLEncoding.EncodeToString(header) + "." + payload
+    sig, err := signer.Sign([]byte(candidateInput))
+    if err != nil {
+        return "", err
+    }
+    return candidateInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// verifyCandidate checks a token's signature under algorithm and decodes its grant the way
+// the primary verifier does. Time and scope checks are left to the primary, which has made them
+func verifyCandidate(token, algorithm string, publicKey []byte) (*VollyVideoGrant, error) {
+    input, sig, err := splitToken(token)
+    if err != nil {
+        return nil, err
+    }
+    header, err := decodeHeader(input)
+    if err != nil {
+        return nil, err
+    }
+    if header.Alg != algorithm {
+        return nil, crypto.ErrUnsupportedAlgorithm
+    }
+    if err := crypto.VerifySignature(algorithm, publicKey, []byte(input), sig); err != nil {
+        return nil, err
+    }
+    payload, err := decodePayload(input)
+    if err != nil {
+        return nil, err
+    }
+    claims, err := codec.UnmarshalObject(payload)
+    if err != nil {
+        return nil, err
+    }
+    var video auth.VideoGrant
+    if grant, ok := claims["video"]; ok {
+        data, err := json.Marshal(grant)
+        if err != nil {
+            return nil, err
+        }
+        if err := json.Unmarshal(data, &video); err != nil {
+            return nil, err
+        }
+    }
+    return grantFromClaims(&video, claims), nil
+}
+
+// sameGrant compares what admission and the checks after it read from a grant
+func sameGrant(a, b *VollyVideoGrant) bool {
+    if a.Identity != b.Identity || a.Tenant != b.Tenant || a.TokenID != b.TokenID ||
+        a.IssuedAt != b.IssuedAt || a.NotBefore != b.NotBefore || a.ExpiresAt != b.ExpiresAt ||
+        a.Issuer != b.Issuer || a.ElevatedFrom != b.ElevatedFrom || a.PQStatus != b.PQStatus {
+        return false
+    }
+    // The rest have no json:"-" tag, so their encodings compare them
+    ja, errA := json.Marshal(a)
+    jb, errB := json.Marshal(b)
+    return errA == nil && errB == nil && string(ja) == string(jb) &&
+        strings.Join(a.Audience, " ") == strings.Join(b.Audience, " ")
+}
@@ -0,0 +1,251 @@
+package auth
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+)
+
+// TenantClaimPrefix starts the name of every tenant claim in a token: volly.<tenant>.<name>.
+// A tenant's claims cannot collide with Volly's, LiveKit's or another tenant's
+const TenantClaimPrefix = "volly."
+
+// Tenant claim types
+const (
+    ClaimString  = "string"
+    ClaimNumber  = "number"
+    ClaimBool    = "bool"
+    ClaimStrings = "strings"
+)
+
+var (
+    ErrClaimNamespace     = errors.New("claim is outside the token tenant's namespace")
+    ErrUnknownClaim       = errors.New("claim is not registered for the tenant")
+    ErrInvalidClaim       = errors.New("claim does not match its schema")
+    ErrMissingClaim       = errors.New("required claim is missing")
+    ErrInvalidClaimSchema = errors.New("invalid claim schema")
+)
+
+// ClaimSchema describes one tenant claim
+type ClaimSchema struct {
+    Type string `json:"type"`
+    // Required claims must be in every token of the tenant
+    Required bool `json:"required,omitempty"`
+    // MaxLength bounds a string, or each string of a list; zero is unlimited
+    MaxLength int `json:"maxLength,omitempty"`
+    // MaxItems bounds a list; zero is unlimited
+    MaxItems int `json:"maxItems,omitempty"`
+    // Enum, when set, lists the values a string, or each string of a list, may take
+    Enum []string `json:"enum,omitempty"`
+}
+
+func (s ClaimSchema) check(v interface{}) error {
+    switch s.Type {
+    case ClaimString:
+        str, ok := v.(string)
+        if !ok {
+            return errors.New("not a string")
+        }
+        return s.checkString(str)
+    case ClaimNumber:
+        if _, ok := v.(float64); !ok {
+            return errors.New("not a number")
+        }
+    case ClaimBool:
+        if _, ok := v.(bool); !ok {
+            return errors.New("not a bool")
+        }
+    case ClaimStrings:
+        items, ok := v.([]interface{})
+        if !ok {
+            return errors.New("not a list")
+        }
+        if s.MaxItems > 0 && len(items) > s.MaxItems {
+            return fmt.Errorf("%d items, over %d", len(items), s.MaxItems)
+        }
+        for _, item := range items {
+            str, ok := item.(string)
+            if !ok {
+                return errors.New("not a list of strings")
+            }
+            if err := s.checkString(str); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+func (s ClaimSchema) checkString(v string) error {
+    if s.MaxLength > 0 && len(v) > s.MaxLength {
+        return fmt.Errorf("%d bytes, over %d", len(v), s.MaxLength)
+    }
+    if len(s.Enum) == 0 {
+        return nil
+    }
+    for _, e := range s.Enum {
+        if v == e {
+            return nil
+        }
+    }
+    return fmt.Errorf("%q is not one of %s", v, strings.Join(s.Enum, ", "))
+}
+
+// TenantClaim is the name claim of tenant has in a token
+func TenantClaim(tenant, name string) string {
+    return TenantClaimPrefix + tenant + "." + name
+}
+
+// validClaimName reports whether name may be registered: letters, digits, '_' and '-', so
+// the tenant ID before it is unambiguous
+func validClaimName(name string) bool {
+    if name == "" {
+        return false
+    }
+    for _, c := range name {
+        switch {
+        case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '-':
+        default:
+            return false
+        }
+    }
+    return true
+}
+
+// ClaimSchemas holds the claims each tenant registered, so tenants can carry app-specific
+// data in tokens while issuers and verifiers agree on its shape
+type ClaimSchemas struct {
+    mu      sync.RWMutex
+    tenants map[string]map[string]ClaimSchema
+}
+
+// NewClaimSchemas creates a registry with no claims
+func NewClaimSchemas() *ClaimSchemas {
+    return &ClaimSchemas{tenants: make(map[string]map[string]ClaimSchema)}
+}
+
+// Register adds or replaces a claim of tenant
+func (c *ClaimSchemas) Register(tenant, name string, schema ClaimSchema) error {
+    if tenant == "" || !validClaimName(name) {
+        return fmt.Errorf("%w: bad claim name %q for tenant %q", ErrInvalidClaimSchema, name, tenant)
+    }
+    switch schema.Type {
+    case ClaimString, ClaimNumber, ClaimBool, ClaimStrings:
+    default:
+        return fmt.Errorf("%w: claim %q has unknown type %q", ErrInvalidClaimSchema, name, schema.Type)
+    }
+    if schema.MaxLength < 0 || schema.MaxItems < 0 {
+        return fmt.Errorf("%w: claim %q has a negative bound", ErrInvalidClaimSchema, name)
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.tenants[tenant] == nil {
+        c.tenants[tenant] = make(map[string]ClaimSchema)
+    }
+    c.tenants[tenant][name] = schema
+    return nil
+}
+
+// Remove drops a claim of tenant; tokens carrying it are rejected from then on
+func (c *ClaimSchemas) Remove(tenant, name string) *ClaimSchemas {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    delete(c.tenants[tenant], name)
+    return c
+}
+
+// Schema returns the schema of a claim of tenant
+func (c *ClaimSchemas) Schema(tenant, name string) (ClaimSchema, bool) {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    s, ok := c.tenants[tenant][name]
+    return s, ok
+}
+
+// Names lists the claims tenant registered, sorted
+func (c *ClaimSchemas) Names(tenant string) []string {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    names := make([]string, 0, len(c.tenants[tenant]))
+    for name := range c.tenants[tenant] {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// Validate checks claims, keyed by name without the namespace, against tenant's schemas:
+// each must be registered and match, and every required claim must be there
+func (c *ClaimSchemas) Validate(tenant string, claims map[string]interface{}) error {
+    if err := checkClaimNamespace(tenant, claims); err != nil {
+        return err
+    }
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    schemas := c.tenants[tenant]
+    for name, v := range claims {
+        s, ok := schemas[name]
+        if !ok {
+            return fmt.Errorf("%w: %s", ErrUnknownClaim, TenantClaim(tenant, name))
+        }
+        if err := s.check(v); err != nil {
+            return fmt.Errorf("%w: %s: %v", ErrInvalidClaim, TenantClaim(tenant, name), err)
+        }
+    }
+    for name, s := range schemas {
+        if _, ok := claims[name]; s.Required && !ok {
+            return fmt.Errorf("%w: %s", ErrMissingClaim, TenantClaim(tenant, name))
+        }
+    }
+    return nil
+}
+
+// checkClaimNamespace rejects claims outside tenant's namespace, which grantFromClaims
+// keeps under their full name
+func checkClaimNamespace(tenant string, claims map[string]interface{}) error {
+    for name := range claims {
+        if tenant == "" || !validClaimName(name) {
+            return fmt.Errorf("%w: %s", ErrClaimNamespace, name)
+        }
+    }
+    return nil
+}
+
+// normalizeClaim converts v to what it decodes to from a token, e.g. an int to a float64,
+// so it is checked against its schema as a verifier will see it
+func normalizeClaim(v interface{}) (interface{}, error) {
+    b, err := json.Marshal(v)
+    if err != nil {
+        return nil, err
+    }
+    var out interface{}
+    if err := json.Unmarshal(b, &out); err != nil {
+        return nil, err
+    }
+    return out, nil
+}
+
+// tenantClaims pulls the claims named volly.<tenant>.* out of verified claims, keyed by
+// name without the namespace. Any other volly.* claim keeps its full name, so checking the
+// namespace rejects it
+func tenantClaims(tenant string, claims map[string]interface{}) map[string]interface{} {
+    var out map[string]interface{}
+    own := TenantClaimPrefix + tenant + "."
+    for name, v := range claims {
+        if !strings.HasPrefix(name, TenantClaimPrefix) {
+            continue
+        }
+        if out == nil {
+            out = make(map[string]interface{})
+        }
+        if short := strings.TrimPrefix(name, own); tenant != "" && short != name && validClaimName(short) {
+            out[short] = v
+        } else {
+            out[name] = v
+        }
+    }
+    return out
+}
@@ -0,0 +1,122 @@
+package auth
+
+import "fmt"
+
+// ClaimTransformer reshapes a verified grant before anything reads it, so application code
+// sees one shape whichever version of the issuer minted the token. It may rename and add
+// tenant claims and widen permissions; the signature has been checked by then, and the
+// tenant's claim schemas are checked after every transformer has run
+type ClaimTransformer interface {
+    Transform(grant *VollyVideoGrant) error
+}
+
+// ClaimTransformerFunc adapts a function to ClaimTransformer
+type ClaimTransformerFunc func(grant *VollyVideoGrant) error
+
+// Transform calls f
+func (f ClaimTransformerFunc) Transform(grant *VollyVideoGrant) error {
+    return f(grant)
+}
+
+// ClaimTransformers runs transformers in order and stops at the first error
+type ClaimTransformers []ClaimTransformer
+
+// Transform runs every transformer in the chain
+func (c ClaimTransformers) Transform(grant *VollyVideoGrant) error {
+    for _, t := range c {
+        if err := t.Transform(grant); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// ForTenant applies t to grants of tenant only
+func ForTenant(tenant string, t ClaimTransformer) ClaimTransformer {
+    return ClaimTransformerFunc(func(grant *VollyVideoGrant) error {
+        if grant.Tenant != tenant {
+            return nil
+        }
+        return t.Transform(grant)
+    })
+}
+
+// RenameClaim moves tenant claim from to to, e.g. a claim an older issuer named differently.
+// A token carrying both keeps to and drops from
+func RenameClaim(from, to string) ClaimTransformer {
+    return ClaimTransformerFunc(func(grant *VollyVideoGrant) error {
+        v, ok := grant.Claims[from]
+        if !ok {
+            return nil
+        }
+        delete(grant.Claims, from)
+        if _, ok := grant.Claims[to]; !ok {
+            grant.Claims[to] = v
+        }
+        return nil
+    })
+}
+
+// DefaultClaims sets tenant claims a token does not carry, e.g. a plan for tokens minted
+// before the claim existed. Values are taken as a token would carry them, so an int becomes
+// a float64
+func DefaultClaims(defaults map[string]interface{}) (ClaimTransformer, error) {
+    normalized := make(map[string]interface{}, len(defaults))
+    for name, value := range defaults {
+        v, err := normalizeClaim(value)
+        if err != nil {
+            return nil, fmt.Errorf("%w: %s: %v", ErrInvalidClaim, name, err)
+        }
+        normalized[name] = v
+    }
+    return ClaimTransformerFunc(func(grant *VollyVideoGrant) error {
+        for name, v := range normalized {
+            if _, ok := grant.Claims[name]; ok {
+                continue
+            }
+            if grant.Claims == nil {
+                grant.Claims = make(map[string]interface{}, len(normalized))
+            }
+            grant.Claims[name] = v
+        }
+        return nil
+    }), nil
+}
+
+// ClaimRole widens grants whose tenant claim is value, or is a list holding it, by a role's
+// template as the grant's tenant sees it, e.g. speaker rights for plan "pro"
+func ClaimRole(roles *Roles, claim, value, role string) ClaimTransformer {
+    return ClaimTransformerFunc(func(grant *VollyVideoGrant) error {
+        if !claimHas(grant.Claims[claim], value) {
+            return nil
+        }
+        tmpl, err := roles.Template(grant.Tenant, role)
+        if err != nil {
+            return fmt.Errorf("%w: %q", err, role)
+        }
+        mergeRole(&grant.VideoGrant, tmpl)
+        return nil
+    })
+}
+
+// claimHas reports whether a decoded claim is value or a list holding it
+func claimHas(v interface{}, value string) bool {
+    switch v := v.(type) {
+    case string:
+        return v == value
+    case []interface{}:
+        for _, item := range v {
+            if s, ok := item.(string); ok && s == value {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// SetClaimTransformers reshapes every full token's grant before its claims are checked;
+// viewer tokens carry no tenant claims and are left as they are
+func (v *Verifier) SetClaimTransformers(transformers ...ClaimTransformer) *Verifier {
+    v.transformers = transformers
+    return v
+}
@@ -0,0 +1,321 @@
+package auth
+
+import (
+    "errors"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/secure"
+)
+
+// DefaultLeeway tolerates clock skew between issuers and verifiers
+const DefaultLeeway = 30 * time.Second
+
+// DefaultNotBeforeLeeway tolerates issuers whose clocks run ahead; it matches the minute
+// LiveKit allows on nbf
+const DefaultNotBeforeLeeway = time.Minute
+
+var (
+    ErrPQKeyExpired      = errors.New("post-quantum key in token has expired")
+    ErrSecretUnavailable = errors.New("signing secret is unavailable")
+    ErrIssuerMismatch    = errors.New("token was issued by another deployment")
+    ErrAudienceMismatch  = errors.New("token is not meant for this verifier")
+    ErrTokenNotYetValid  = errors.New("token is not valid yet")
+)
+
+// Verifier checks Volly tokens, including expiry of the embedded PQ key
+type Verifier struct {
+    apiKey       string
+    secret       string
+    secureSecret *secure.SecureBytes
+    signer       TokenSigner
+    clock        clock.Clock
+    leeway       time.Duration
+    notBefore    time.Duration
+    policy       *KeyLifetimePolicy
+    legacy       LegacyPolicy
+    check        func(grant *VollyVideoGrant) error
+    viewers      *ViewerVerifier
+    limits       ClaimLimits
+    issuer       string
+    audience     []string
+    schemas      *ClaimSchemas
+    profiles     *CryptoProfiles
+    provenance   ProvenancePolicy
+    transformers ClaimTransformers
+}
+
+// NewVerifier creates a verifier for tokens signed with secret
+func NewVerifier(apiKey, secret string) *Verifier {
+    return &Verifier{
+        apiKey: apiKey,
+        secret: secret,
+        clock:  clock.System,
+        leeway:    DefaultLeeway,
+        notBefore: DefaultNotBeforeLeeway,
+        limits:    DefaultClaimLimits,
+    }
+}
+
+// NewVerifierWithSecret creates a verifier whose secret is held in protected memory
+func NewVerifierWithSecret(apiKey string, secret *secure.SecureBytes) *Verifier {
+    v := NewVerifier(apiKey, "")
+    v.secureSecret = secret
+    return v
+}
+
+// NewVerifierWithSigner creates a verifier that recomputes MACs with signer, such as an HSM
+// holding the secret
+func NewVerifierWithSigner(apiKey string, signer TokenSigner) *Verifier {
+    v := NewVerifier(apiKey, "")
+    v.signer = signer
+    return v
+}
+
+// SetClock sets the time source for expiry checks
+func (v *Verifier) SetClock(c clock.Clock) *Verifier {
+    v.clock = c
+    return v
+}
+
+// SetLeeway sets the tolerated clock skew
+func (v *Verifier) SetLeeway(leeway time.Duration) *Verifier {
+    v.leeway = leeway
+    return v
+}
+
+// SetNotBeforeLeeway sets how far in the future a token's nbf, or its iat when it has no
+// nbf, may be. LiveKit servers allow a minute on nbf, so issuers that need more for them
+// backdate nbf with VollyAccessToken.SetNotBeforeGrace
+func (v *Verifier) SetNotBeforeLeeway(leeway time.Duration) *Verifier {
+    v.notBefore = leeway
+    return v
+}
+
+// SetKeyLifetimePolicy rejects tokens whose PQ key lifetime is outside policy
+func (v *Verifier) SetKeyLifetimePolicy(policy KeyLifetimePolicy) *Verifier {
+    v.policy = &policy
+    return v
+}
+
+// SetLegacyPolicy enables compatibility mode: plain LiveKit tokens are
+// admitted with PQStatusAbsent when policy allows; without one they are rejected
+func (v *Verifier) SetLegacyPolicy(policy LegacyPolicy) *Verifier {
+    v.legacy = policy
+    return v
+}
+
+// SetClaimLimits bounds the size and shape of tokens accepted for parsing
+func (v *Verifier) SetClaimLimits(limits ClaimLimits) *Verifier {
+    v.limits = limits
+    return v
+}
+
+// SetGrantCheck runs check on every verified grant, e.g. a kill switch or revocation lookup
+func (v *Verifier) SetGrantCheck(check func(grant *VollyVideoGrant) error) *Verifier {
+    v.check = check
+    return v
+}
+
+// SetIssuer requires tokens to name issuer; tokens without an issuer are rejected too
+func (v *Verifier) SetIssuer(issuer string) *Verifier {
+    v.issuer = issuer
+    return v
+}
+
+// SetAudience requires tokens to be meant for at least one of audience; tokens without an
+// audience are rejected too
+func (v *Verifier) SetAudience(audience ...string) *Verifier {
+    v.audience = audience
+    return v
+}
+
+// SetClaimSchemas validates tenant claims against schemas. Without schemas tenant claims are
+// only checked to be in the token tenant's namespace
+func (v *Verifier) SetClaimSchemas(schemas *ClaimSchemas) *Verifier {
+    v.schemas = schemas
+    return v
+}
+
+// SetCryptoProfiles rejects tokens that fall short of their tenant's profile, legacy and
+// viewer tokens included
+func (v *Verifier) SetCryptoProfiles(profiles *CryptoProfiles) *Verifier {
+    v.profiles = profiles
+    return v
+}
+
+// SetViewerTokens accepts subscribe-only viewer tokens alongside full tokens; their grants
+// have PQStatusViewer and skip the legacy and key lifetime policies
+func (v *Verifier) SetViewerTokens(allow bool) *Verifier {
+    v.viewers = nil
+    if allow {
+        if v.secureSecret != nil {
+            v.viewers = NewViewerVerifierWithSecret(v.apiKey, v.secureSecret)
+        } else {
+            v.viewers = NewViewerVerifier(v.apiKey, v.secret)
+        }
+    }
+    return v
+}
+
+// Verify checks the token signature and claims and returns its grant
+func (v *Verifier) Verify(token string) (*VollyVideoGrant, error) {
+    if err := v.limits.Check(token); err != nil {
+        return nil, err
+    }
+    if IsViewerToken(token) {
+        return v.verifyViewer(token)
+    }
+
+    var grant *VollyVideoGrant
+    now := v.clock.Now()
+    verify := func(m macFunc) error {
+        var err error
+        if IsAttenuated(token) {
+            grant, err = verifyAttenuated(token, v.apiKey, m, now, v.leeway, v.notBefore)
+        } else {
+            grant, err = verifyVollyToken(token, v.apiKey, m, now, v.leeway, v.notBefore)
+        }
+        return err
+    }
+    var err error
+    if v.signer != nil {
+        err = verify(signerMAC(v.signer))
+    } else {
+        err = withSecret(v.secureSecret, v.secret, func(key []byte) error {
+            return verify(keyMAC(key))
+        })
+    }
+    if err != nil {
+        return nil, err
+    }
+    if err := v.checkNotBefore(grant); err != nil {
+        return nil, err
+    }
+    if err := v.checkScope(grant); err != nil {
+        return nil, err
+    }
+    if err := v.transformers.Transform(grant); err != nil {
+        return nil, err
+    }
+    if err := v.checkClaims(grant); err != nil {
+        return nil, err
+    }
+    if err := v.checkProfile(grant); err != nil {
+        return nil, err
+    }
+    if v.check != nil {
+        if err := v.check(grant); err != nil {
+            return nil, err
+        }
+    }
+    if grant.PQStatus == PQStatusAbsent {
+        if v.legacy == nil {
+            return nil, ErrPQClaimsMissing
+        }
+        if err := v.legacy(grant); err != nil {
+            return nil, err
+        }
+        return grant, nil
+    }
+
+    if grant.PQKeyExpiry != 0 {
+        now := v.clock.Now()
+        expiry := time.Unix(grant.PQKeyExpiry, 0)
+        if now.After(expiry.Add(v.leeway)) {
+            return nil, ErrPQKeyExpired
+        }
+        if v.policy != nil {
+            var issuedAt time.Time
+            if grant.PQKeyIssuedAt != 0 {
+                issuedAt = time.Unix(grant.PQKeyIssuedAt, 0)
+            }
+            if err := v.policy.Check(issuedAt, expiry, now); err != nil {
+                return nil, err
+            }
+        }
+    }
+    return grant, nil
+}
+
+func (v *Verifier) verifyViewer(token string) (*VollyVideoGrant, error) {
+    if v.viewers == nil {
+        return nil, ErrViewerTokensOff
+    }
+    claims, err := v.viewers.verify(token, v.clock.Now(), v.leeway, v.notBefore)
+    if err != nil {
+        return nil, err
+    }
+    grant := claims.Grant()
+    if err := v.checkScope(grant); err != nil {
+        return nil, err
+    }
+    if err := v.checkProfile(grant); err != nil {
+        return nil, err
+    }
+    if v.check != nil {
+        if err := v.check(grant); err != nil {
+            return nil, err
+        }
+    }
+    return grant, nil
+}
+
+// checkScope enforces the required issuer, provenance and audience
+func (v *Verifier) checkScope(grant *VollyVideoGrant) error {
+    if v.issuer != "" && grant.Issuer != v.issuer {
+        return ErrIssuerMismatch
+    }
+    if err := v.provenance.Check(grant.Provenance); err != nil {
+        return err
+    }
+    if len(v.audience) == 0 {
+        return nil
+    }
+    for _, want := range v.audience {
+        for _, got := range grant.Audience {
+            if got == want {
+                return nil
+            }
+        }
+    }
+    return ErrAudienceMismatch
+}
+
+// checkClaims rejects tenant claims outside the tenant's namespace or its schemas. Viewer
+// tokens cannot carry tenant claims and skip it, required claims or not
+func (v *Verifier) checkClaims(grant *VollyVideoGrant) error {
+    if v.schemas != nil {
+        return v.schemas.Validate(grant.Tenant, grant.Claims)
+    }
+    return checkClaimNamespace(grant.Tenant, grant.Claims)
+}
+
+// checkProfile holds grant to its tenant's crypto profile. The lifetime runs from iat, or
+// from nbf for tokens minted without one
+func (v *Verifier) checkProfile(grant *VollyVideoGrant) error {
+    if v.profiles == nil {
+        return nil
+    }
+    start := grant.IssuedAt
+    if start == 0 {
+        start = grant.NotBefore
+    }
+    var ttl time.Duration
+    if start != 0 && grant.ExpiresAt != 0 {
+        ttl = time.Duration(grant.ExpiresAt-start) * time.Second
+    }
+    return v.profiles.Profile(grant.Tenant).Check(grant, ttl)
+}
+
+// checkNotBefore rejects tokens that are not valid yet by more than the nbf leeway
+func (v *Verifier) checkNotBefore(grant *VollyVideoGrant) error {
+    nbf := grant.NotBefore
+    if nbf == 0 {
+        nbf = grant.IssuedAt
+    }
+    if nbf != 0 && v.clock.Now().Add(v.notBefore).Before(time.Unix(nbf, 0)) {
+        return ErrTokenNotYetValid
+    }
+    return nil
+}
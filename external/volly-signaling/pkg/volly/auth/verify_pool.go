@@ -0,0 +1,189 @@
+package auth
+
+import (
+    "context"
+    "crypto/sha256"
+    "errors"
+    "sync"
+)
+
+// DefaultVerifyQueue bounds how many verifications may wait for a worker
+const DefaultVerifyQueue = 4096
+
+var (
+    ErrPoolSaturated = errors.New("verification queue is full")
+    ErrPoolClosed    = errors.New("verification pool is closed")
+    errNoGrant       = errors.New("verification returned no grant")
+)
+
+// VerifyPoolStats counts VerifyPool work
+type VerifyPoolStats struct {
+    Workers   int    `json:"workers"`
+    Queued    int    `json:"queued"`
+    Tenants   int    `json:"tenants"`
+    Completed uint64 `json:"completed"`
+    // Coalesced verifications joined an identical one already queued or running
+    Coalesced uint64 `json:"coalesced"`
+    Rejected  uint64 `json:"rejected"`
+}
+
+type verifyCall struct {
+    key    [sha256.Size]byte
+    token  string
+    verify func(token string) (*VollyVideoGrant, error)
+    done   chan struct{}
+    grant  *VollyVideoGrant
+    err    error
+}
+
+// VerifyPool runs verifications on a fixed set of workers so slow PQ signature checks do not
+// serialize connection handling. Concurrent verifications of the same token share one call,
+// and each tenant has its own queue served round-robin, so one tenant's burst waits behind
+// itself rather than in front of everyone else
+type VerifyPool struct {
+    workers  int
+    maxQueue int
+
+    mu       sync.Mutex
+    cond     *sync.Cond
+    closed   bool
+    queued   int
+    queues   map[string][]*verifyCall
+    order    []string
+    next     int
+    inflight map[[sha256.Size]byte]*verifyCall
+
+    completed, coalesced, rejected uint64
+}
+
+// NewVerifyPool starts workers goroutines; Close stops them
+func NewVerifyPool(workers int) *VerifyPool {
+    if workers < 1 {
+        workers = 1
+    }
+    p := &VerifyPool{
+        workers:  workers,
+        maxQueue: DefaultVerifyQueue,
+        queues:   make(map[string][]*verifyCall),
+        inflight: make(map[[sha256.Size]byte]*verifyCall),
+    }
+    p.cond = sync.NewCond(&p.mu)
+    for i := 0; i < workers; i++ {
+        go p.work()
+    }
+    return p
+}
+
+// SetMaxQueue bounds waiting verifications; beyond it Verify returns ErrPoolSaturated
+func (p *VerifyPool) SetMaxQueue(n int) *VerifyPool {
+    p.mu.Lock()
+    p.maxQueue = n
+    p.mu.Unlock()
+    return p
+}
+
+// Verify runs verify(token) on a worker, queued under tenant, and waits for it or ctx.
+// Coalesced callers receive copies of one grant; slices are shared and must not be modified
+func (p *VerifyPool) Verify(ctx context.Context, tenant, token string, verify func(token string) (*VollyVideoGrant, error)) (*VollyVideoGrant, error) {
+    key := sha256.Sum256([]byte(token))
+
+    p.mu.Lock()
+    if p.closed {
+        p.mu.Unlock()
+        return nil, ErrPoolClosed
+    }
+    call, ok := p.inflight[key]
+    if ok {
+        p.coalesced++
+    } else {
+        if p.queued >= p.maxQueue {
+            p.rejected++
+            p.mu.Unlock()
+            return nil, ErrPoolSaturated
+        }
+        call = &verifyCall{key: key, token: token, verify: verify, done: make(chan struct{})}
+        p.inflight[key] = call
+        if len(p.queues[tenant]) == 0 {
+            p.order = append(p.order, tenant)
+        }
+        p.queues[tenant] = append(p.queues[tenant], call)
+        p.queued++
+        p.cond.Signal()
+    }
+    p.mu.Unlock()
+
+    select {
+    case <-call.done:
+    case <-ctx.Done():
+        return nil, ctx.Err()
+    }
+    if call.err != nil {
+        return nil, call.err
+    }
+    grant := *call.grant
+    return &grant, nil
+}
+
+// work takes one call at a time from the tenant queues in turn
+func (p *VerifyPool) work() {
+    for {
+        p.mu.Lock()
+        for p.queued == 0 && !p.closed {
+            p.cond.Wait()
+        }
+        if p.queued == 0 {
+            p.mu.Unlock()
+            return
+        }
+        if p.next >= len(p.order) {
+            p.next = 0
+        }
+        tenant := p.order[p.next]
+        queue := p.queues[tenant]
+        call := queue[0]
+        queue[0] = nil
+        if queue = queue[1:]; len(queue) == 0 {
+            delete(p.queues, tenant)
+            p.order = append(p.order[:p.next], p.order[p.next+1:]...)
+        } else {
+            p.queues[tenant] = queue
+            p.next++
+        }
+        p.queued--
+        p.mu.Unlock()
+
+        call.grant, call.err = call.verify(call.token)
+        if call.err == nil && call.grant == nil {
+            call.err = errNoGrant
+        }
+
+        p.mu.Lock()
+        delete(p.inflight, call.key)
+        p.completed++
+        p.mu.Unlock()
+        close(call.done)
+    }
+}
+
+// Stats reports queue depth and counters
+func (p *VerifyPool) Stats() VerifyPoolStats {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    return VerifyPoolStats{
+        Workers:   p.workers,
+        Queued:    p.queued,
+        Tenants:   len(p.order),
+        Completed: p.completed,
+        Coalesced: p.coalesced,
+        Rejected:  p.rejected,
+    }
+}
+
+// Close stops the workers once the queue drains; later calls get ErrPoolClosed
+func (p *VerifyPool) Close() {
+    p.mu.Lock()
+    p.closed = true
+    p.cond.Broadcast()
+    p.mu.Unlock()
+}
@@ -0,0 +1,408 @@
+package auth
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/hex"
+    "errors"
+    "hash"
+    "strings"
+    "sync"
+    "time"
+
+    lkauth "github.com/livekit/protocol/auth"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/codec"
+    "github.com/volly-org/volly-signaling/pkg/volly/secure"
+)
+
+// ViewerTokenPrefix starts every viewer token, so verifiers can route them without parsing
+const ViewerTokenPrefix = "vv1."
+
+// PQStatusViewer marks a viewer token, which never carries PQ claims
+const PQStatusViewer PQStatus = "viewer"
+
+const (
+    viewerVersion = 1
+    // viewerVersionScoped adds issuer and audience; tokens without either stay version 1
+    viewerVersionScoped = 2
+    viewerJTISize = 12
+    viewerTagSize = 16
+)
+
+var (
+    ErrInvalidViewerToken = errors.New("invalid viewer token")
+    ErrViewerTokenExpired = errors.New("viewer token has expired")
+    ErrViewerTokensOff    = errors.New("viewer tokens are not accepted")
+)
+
+// ViewerClaims are the whole claim set of a viewer token
+type ViewerClaims struct {
+    APIKey    string
+    Tenant    string
+    Room      string
+    Identity  string
+    TokenID   string
+    IssuedAt  time.Time
+    ExpiresAt time.Time
+    Issuer    string
+    Audience  string
+}
+
+// Grant returns the subscribe-only grant a viewer token stands for. Viewers are hidden so a
+// large audience does not flood the room's participant list; an empty identity is derived
+// from the jti
+func (c *ViewerClaims) Grant() *VollyVideoGrant {
+    f := false
+    t := true
+    identity := c.Identity
+    if identity == "" {
+        identity = "viewer-" + c.TokenID
+    }
+    var audience []string
+    if c.Audience != "" {
+        audience = []string{c.Audience}
+    }
+    return &VollyVideoGrant{
+        VideoGrant: lkauth.VideoGrant{
+            RoomJoin:             true,
+            Room:                 c.Room,
+            CanSubscribe:         &t,
+            CanPublish:           &f,
+            CanPublishData:       &f,
+            CanUpdateOwnMetadata: &f,
+            Hidden:               true,
+        },
+        PQStatus:  PQStatusViewer,
+        TokenID:   c.TokenID,
+        Identity:  identity,
+        IssuedAt:  c.IssuedAt.Unix(),
+        ExpiresAt: c.ExpiresAt.Unix(),
+        Tenant:    c.Tenant,
+        Issuer:    c.Issuer,
+        Audience:  audience,
+    }
+}
+
+// ViewerToken is a trimmed, subscribe-only token for broadcast audiences. It is a binary
+// claim set with a truncated HMAC-SHA256 tag rather than a JWT, around 70 bytes for a short room,
+// and verifies without JSON parsing
+type ViewerToken struct {
+    apiKey       string
+    secret       string
+    secureSecret *secure.SecureBytes
+    tenant       string
+    room         string
+    identity     string
+    issuer       string
+    audience     string
+    ttl          time.Duration
+    jitter       time.Duration
+    expiresAt    time.Time
+    clock        clock.Clock
+    check        func(grant *VollyVideoGrant) error
+    profiles     *CryptoProfiles
+}
+
+// NewViewerToken creates a viewer token signed with secret
+func NewViewerToken(apiKey, secret string) *ViewerToken {
+    return &ViewerToken{
+        apiKey: apiKey,
+        secret: secret,
+        ttl:    DefaultTTL,
+        clock:  clock.System,
+    }
+}
+
+// NewViewerTokenWithSecret creates a viewer token signed with a secret held in protected memory
+func NewViewerTokenWithSecret(apiKey string, secret *secure.SecureBytes) *ViewerToken {
+    t := NewViewerToken(apiKey, "")
+    t.secureSecret = secret
+    return t
+}
+
+// SetRoom sets the room the viewer may watch
+func (t *ViewerToken) SetRoom(room string) *ViewerToken {
+    t.room = room
+    return t
+}
+
+// SetIdentity sets the viewer identity; leave it empty for anonymous viewers
+func (t *ViewerToken) SetIdentity(identity string) *ViewerToken {
+    t.identity = identity
+    return t
+}
+
+// SetTenant sets the tenant the token is issued for
+func (t *ViewerToken) SetTenant(tenant string) *ViewerToken {
+    t.tenant = tenant
+    return t
+}
+
+// SetIssuer names the deployment issuing the token; see VollyAccessToken.SetIssuer
+func (t *ViewerToken) SetIssuer(issuer string) *ViewerToken {
+    t.issuer = issuer
+    return t
+}
+
+// SetAudience sets the one verifier audience the token is meant for
+func (t *ViewerToken) SetAudience(audience string) *ViewerToken {
+    t.audience = audience
+    return t
+}
+
+// SetValidFor sets how long the token is valid
+func (t *ViewerToken) SetValidFor(ttl time.Duration) *ViewerToken {
+    t.ttl = ttl
+    return t
+}
+
+// SetExpiryJitter spreads expiries over up to max; see VollyAccessToken.SetExpiryJitter
+func (t *ViewerToken) SetExpiryJitter(max time.Duration) *ViewerToken {
+    t.jitter = max
+    return t
+}
+
+// ExpiresAt is the expiry of the token Sign last signed
+func (t *ViewerToken) ExpiresAt() time.Time {
+    return t.expiresAt
+}
+
+// SetClock sets the time source for iat and exp
+func (t *ViewerToken) SetClock(c clock.Clock) *ViewerToken {
+    t.clock = c
+    return t
+}
+
+// SetIssuanceCheck runs check on the viewer grant before signing, e.g. a kill switch
+func (t *ViewerToken) SetIssuanceCheck(check func(grant *VollyVideoGrant) error) *ViewerToken {
+    t.check = check
+    return t
+}
+
+// SetCryptoProfiles makes Sign refuse viewer tokens the tenant's profile does not accept
+func (t *ViewerToken) SetCryptoProfiles(profiles *CryptoProfiles) *ViewerToken {
+    t.profiles = profiles
+    return t
+}
+
+// Sign encodes and signs the token
+func (t *ViewerToken) Sign() (string, error) {
+    if t.room == "" {
+        return "", errors.New("room is required")
+    }
+    jti := make([]byte, viewerJTISize)
+    if _, err := rand.Read(jti); err != nil {
+        return "", err
+    }
+    now := t.clock.Now()
+    tokenID := hex.EncodeToString(jti)
+    claims := ViewerClaims{
+        APIKey:    t.apiKey,
+        Tenant:    t.tenant,
+        Room:      t.room,
+        Identity:  t.identity,
+        TokenID:   tokenID,
+        IssuedAt:  time.Unix(now.Unix(), 0),
+        ExpiresAt: time.Unix(now.Add(t.ttl-expiryJitter(tokenID, t.jitter, t.ttl)).Unix(), 0),
+        Issuer:    t.issuer,
+        Audience:  t.audience,
+    }
+    if t.check != nil {
+        if err := t.check(claims.Grant()); err != nil {
+            return "", err
+        }
+    }
+    if t.profiles != nil {
+        if err := t.profiles.Profile(t.tenant).Check(claims.Grant(), claims.ExpiresAt.Sub(claims.IssuedAt)); err != nil {
+            return "", err
+        }
+    }
+
+    version := byte(viewerVersion)
+    fields := []string{t.apiKey, t.tenant, t.room, t.identity}
+    if t.issuer != "" || t.audience != "" {
+        version = viewerVersionScoped
+        fields = append(fields, t.issuer, t.audience)
+    }
+    buf := []byte{version}
+    buf = binary.AppendUvarint(buf, uint64(claims.IssuedAt.Unix()))
+    buf = binary.AppendUvarint(buf, uint64(claims.ExpiresAt.Unix()))
+    buf = append(buf, jti...)
+    for _, s := range fields {
+        buf = binary.AppendUvarint(buf, uint64(len(s)))
+        buf = append(buf, s...)
+    }
+
+    var tag []byte
+    sign := func(secret []byte) error {
+        m := hmac.New(sha256.New, secret)
+        m.Write(buf)
+        tag = m.Sum(nil)[:viewerTagSize]
+        return nil
+    }
+    if t.secureSecret != nil {
+        if t.secureSecret.Len() == 0 {
+            return "", ErrSecretUnavailable
+        }
+        if err := t.secureSecret.Use(sign); err != nil {
+            return "", err
+        }
+    } else {
+        sign([]byte(t.secret))
+    }
+    t.expiresAt = claims.ExpiresAt
+    return ViewerTokenPrefix + base64.RawURLEncoding.EncodeToString(append(buf, tag...)), nil
+}
+
+// IsViewerToken reports whether token is a viewer token
+func IsViewerToken(token string) bool {
+    return strings.HasPrefix(token, ViewerTokenPrefix)
+}
+
+// ParseViewerToken decodes a viewer token without verifying it, e.g. to look up the
+// secret of the API key it names
+func ParseViewerToken(token string) (*ViewerClaims, error) {
+    claims, _, _, err := decodeViewer(token)
+    return claims, err
+}
+
+// decodeViewer returns the claims, the signed bytes and the tag
+func decodeViewer(token string) (*ViewerClaims, []byte, []byte, error) {
+    if !IsViewerToken(token) {
+        return nil, nil, nil, ErrInvalidViewerToken
+    }
+    data, err := codec.DecodeRawURL(token[len(ViewerTokenPrefix):])
+    if err != nil || len(data) < 1+viewerJTISize+viewerTagSize || data[0] != viewerVersion && data[0] != viewerVersionScoped {
+        return nil, nil, nil, ErrInvalidViewerToken
+    }
+    body, tag := data[:len(data)-viewerTagSize], data[len(data)-viewerTagSize:]
+
+    rest := body[1:]
+    var times [2]int64
+    for i := range times {
+        v, n := binary.Uvarint(rest)
+        if n <= 0 || v > 1<<62 {
+            return nil, nil, nil, ErrInvalidViewerToken
+        }
+        times[i] = int64(v)
+        rest = rest[n:]
+    }
+    if len(rest) < viewerJTISize {
+        return nil, nil, nil, ErrInvalidViewerToken
+    }
+    claims := &ViewerClaims{
+        TokenID:   hex.EncodeToString(rest[:viewerJTISize]),
+        IssuedAt:  time.Unix(times[0], 0),
+        ExpiresAt: time.Unix(times[1], 0),
+    }
+    rest = rest[viewerJTISize:]
+    fields := []*string{&claims.APIKey, &claims.Tenant, &claims.Room, &claims.Identity}
+    if data[0] == viewerVersionScoped {
+        fields = append(fields, &claims.Issuer, &claims.Audience)
+    }
+    for _, field := range fields {
+        l, n := binary.Uvarint(rest)
+        if n <= 0 || l > uint64(len(rest)-n) {
+            return nil, nil, nil, ErrInvalidViewerToken
+        }
+        *field = string(rest[n : n+int(l)])
+        rest = rest[n+int(l):]
+    }
+    if len(rest) != 0 {
+        return nil, nil, nil, ErrInvalidViewerToken
+    }
+    return claims, body, tag, nil
+}
+
+// ViewerVerifier is the high-throughput verification path for viewer tokens: one base64
+// decode, one pooled HMAC and no JSON
+type ViewerVerifier struct {
+    apiKey    string
+    clock     clock.Clock
+    leeway    time.Duration
+    notBefore time.Duration
+    macs      sync.Pool
+}
+
+// NewViewerVerifier creates a verifier for viewer tokens signed with secret
+func NewViewerVerifier(apiKey, secret string) *ViewerVerifier {
+    v := &ViewerVerifier{apiKey: apiKey, clock: clock.System, leeway: DefaultLeeway, notBefore: DefaultNotBeforeLeeway}
+    v.macs.New = func() interface{} {
+        return hmac.New(sha256.New, []byte(secret))
+    }
+    return v
+}
+
+// NewViewerVerifierWithSecret creates a verifier whose secret is held in protected memory
+func NewViewerVerifierWithSecret(apiKey string, secret *secure.SecureBytes) *ViewerVerifier {
+    v := &ViewerVerifier{apiKey: apiKey, clock: clock.System, leeway: DefaultLeeway, notBefore: DefaultNotBeforeLeeway}
+    v.macs.New = func() interface{} {
+        // HMAC keeps its own padded copy of the key; a closed secret yields no MAC
+        var m hash.Hash
+        secret.Use(func(b []byte) error {
+            if len(b) > 0 {
+                m = hmac.New(sha256.New, b)
+            }
+            return nil
+        })
+        if m == nil {
+            return nil
+        }
+        return m
+    }
+    return v
+}
+
+// SetClock sets the time source for expiry checks
+func (v *ViewerVerifier) SetClock(c clock.Clock) *ViewerVerifier {
+    v.clock = c
+    return v
+}
+
+// SetLeeway sets the tolerated clock skew
+func (v *ViewerVerifier) SetLeeway(leeway time.Duration) *ViewerVerifier {
+    v.leeway = leeway
+    return v
+}
+
+// SetNotBeforeLeeway sets how far in the future a token's iat may be
+func (v *ViewerVerifier) SetNotBeforeLeeway(leeway time.Duration) *ViewerVerifier {
+    v.notBefore = leeway
+    return v
+}
+
+// Verify checks the tag, API key, iat and expiry and returns the claims
+func (v *ViewerVerifier) Verify(token string) (*ViewerClaims, error) {
+    return v.verify(token, v.clock.Now(), v.leeway, v.notBefore)
+}
+
+func (v *ViewerVerifier) verify(token string, now time.Time, leeway, notBefore time.Duration) (*ViewerClaims, error) {
+    claims, body, tag, err := decodeViewer(token)
+    if err != nil {
+        return nil, err
+    }
+    m, _ := v.macs.Get().(hash.Hash)
+    if m == nil {
+        return nil, ErrSecretUnavailable
+    }
+    m.Reset()
+    m.Write(body)
+    var sum [sha256.Size]byte
+    ok := hmac.Equal(m.Sum(sum[:0])[:viewerTagSize], tag)
+    v.macs.Put(m)
+    if !ok || claims.APIKey != v.apiKey {
+        return nil, ErrInvalidViewerToken
+    }
+    if now.After(claims.ExpiresAt.Add(leeway)) {
+        return nil, ErrViewerTokenExpired
+    }
+    if now.Add(notBefore).Before(claims.IssuedAt) {
+        return nil, ErrTokenNotYetValid
+    }
+    return claims, nil
+}
@@ -0,0 +1,119 @@
+package biscuit
+
+import (
+    "errors"
+)
+
+var (
+    ErrCheckFailed    = errors.New("biscuit check failed")
+    ErrDenied         = errors.New("biscuit denied by policy")
+    ErrNoPolicyMatch  = errors.New("no biscuit policy matched")
+    ErrNoPolicies     = errors.New("authorizer has no policies")
+    ErrNotAuthorizing = errors.New("authorizer has no credential")
+)
+
+// CheckError names the check that failed; it matches ErrCheckFailed
+type CheckError struct {
+    // Block is 0 for the authority block, -1 for the authorizer's own checks
+    Block int
+    Check string
+}
+
+func (e *CheckError) Error() string {
+    return ErrCheckFailed.Error() + ": " + e.Check
+}
+
+func (e *CheckError) Unwrap() error { return ErrCheckFailed }
+
+// Authorizer evaluates a credential against ambient facts and policies supplied by the verifier
+type Authorizer struct {
+    token    *Biscuit
+    code     Block
+    policies []Policy
+}
+
+// NewAuthorizer starts authorizing token
+func NewAuthorizer(token *Biscuit) *Authorizer {
+    return &Authorizer{token: token}
+}
+
+// AddFact adds an ambient fact such as time or the requested room
+func (a *Authorizer) AddFact(f Predicate) *Authorizer {
+    a.code.Facts = append(a.code.Facts, f)
+    return a
+}
+
+// AddCode parses facts, rules, checks and allow/deny policies
+func (a *Authorizer) AddCode(src string) error {
+    block, policies, err := parse(src)
+    if err != nil {
+        return err
+    }
+    a.code.Facts = append(a.code.Facts, block.Facts...)
+    a.code.Rules = append(a.code.Rules, block.Rules...)
+    a.code.Checks = append(a.code.Checks, block.Checks...)
+    a.policies = append(a.policies, policies...)
+    return nil
+}
+
+// AddPolicy appends an already parsed policy
+func (a *Authorizer) AddPolicy(p Policy) *Authorizer {
+    a.policies = append(a.policies, p)
+    return a
+}
+
+// Authorize runs every check and then the policies in order; the first matching policy decides.
+// Authority checks, authorizer checks and policies see only authority and authorizer facts,
+// so attenuation blocks can restrict but never grant
+func (a *Authorizer) Authorize() error {
+    if a.token == nil {
+        return ErrNotAuthorizing
+    }
+    if len(a.policies) == 0 {
+        return ErrNoPolicies
+    }
+
+    auth := a.token.authority
+    trusted, err := run(
+        append(append([]Predicate(nil), auth.Facts...), a.code.Facts...),
+        append(append([]Rule(nil), auth.Rules...), a.code.Rules...),
+    )
+    if err != nil {
+        return err
+    }
+    for _, c := range a.code.Checks {
+        if !trusted.matches(c.Queries) {
+            return &CheckError{Block: -1, Check: c.String()}
+        }
+    }
+    for _, c := range auth.Checks {
+        if !trusted.matches(c.Queries) {
+            return &CheckError{Block: 0, Check: c.String()}
+        }
+    }
+
+    for i, block := range a.token.blocks {
+        w, err := run(
+            append(append(append([]Predicate(nil), auth.Facts...), a.code.Facts...), block.Facts...),
+            append(append(append([]Rule(nil), auth.Rules...), a.code.Rules...), block.Rules...),
+        )
+        if err != nil {
+            return err
+        }
+        for _, c := range block.Checks {
+            if !w.matches(c.Queries) {
+                return &CheckError{Block: i + 1, Check: c.String()}
+            }
+        }
+    }
+
+    for _, p := range a.policies {
+        if trusted.matches(p.Queries) {
+            if p.Allow {
+                return nil
+            }
+            return ErrDenied
+        }
+    }
+    return ErrNoPolicyMatch
+}
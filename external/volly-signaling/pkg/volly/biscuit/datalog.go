@@ -0,0 +1,332 @@
+package biscuit
+
+import (
+    "errors"
+    "strconv"
+    "strings"
+)
+
+// Evaluation limits keep hostile blocks from exhausting the gateway
+const (
+    maxFacts      = 1000
+    maxIterations = 100
+)
+
+var (
+    ErrLimitsExceeded = errors.New("datalog evaluation exceeded its limits")
+    ErrUnsafeRule     = errors.New("rule head uses a variable not bound in its body")
+)
+
+type termKind uint8
+
+const (
+    termString termKind = iota
+    termInteger
+    termVariable
+)
+
+// Term is a string, an integer or a variable
+type Term struct {
+    kind termKind
+    str  string
+    num  int64
+}
+
+// String makes a string constant
+func String(s string) Term { return Term{kind: termString, str: s} }
+
+// Integer makes an integer constant
+func Integer(n int64) Term { return Term{kind: termInteger, num: n} }
+
+// Variable makes a variable, written $name in source
+func Variable(name string) Term { return Term{kind: termVariable, str: name} }
+
+func (t Term) String() string {
+    switch t.kind {
+    case termInteger:
+        return strconv.FormatInt(t.num, 10)
+    case termVariable:
+        return "$" + t.str
+    default:
+        return strconv.Quote(t.str)
+    }
+}
+
+// Predicate is name(term, ...); without variables it is a fact
+type Predicate struct {
+    Name  string
+    Terms []Term
+}
+
+// Fact builds a ground predicate from strings and int64s
+func Fact(name string, values ...interface{}) Predicate {
+    p := Predicate{Name: name}
+    for _, v := range values {
+        switch v := v.(type) {
+        case int64:
+            p.Terms = append(p.Terms, Integer(v))
+        case int:
+            p.Terms = append(p.Terms, Integer(int64(v)))
+        default:
+            s, _ := v.(string)
+            p.Terms = append(p.Terms, String(s))
+        }
+    }
+    return p
+}
+
+func (p Predicate) String() string {
+    terms := make([]string, len(p.Terms))
+    for i, t := range p.Terms {
+        terms[i] = t.String()
+    }
+    return p.Name + "(" + strings.Join(terms, ", ") + ")"
+}
+
+// Expression compares two terms; ordering operators only apply to integers
+type Expression struct {
+    Left  Term
+    Op    string
+    Right Term
+}
+
+func (e Expression) String() string {
+    return e.Left.String() + " " + e.Op + " " + e.Right.String()
+}
+
+// Rule derives Head for every binding that satisfies Body and Expressions
+type Rule struct {
+    Head        Predicate
+    Body        []Predicate
+    Expressions []Expression
+}
+
+func (r Rule) bodyString() string {
+    parts := make([]string, 0, len(r.Body)+len(r.Expressions))
+    for _, p := range r.Body {
+        parts = append(parts, p.String())
+    }
+    for _, e := range r.Expressions {
+        parts = append(parts, e.String())
+    }
+    return strings.Join(parts, ", ")
+}
+
+func (r Rule) String() string {
+    return r.Head.String() + " <- " + r.bodyString()
+}
+
+// Check passes when at least one of its queries matches
+type Check struct {
+    Queries []Rule
+}
+
+func (c Check) String() string {
+    return "check if " + queriesString(c.Queries)
+}
+
+// Policy is an authorizer allow or deny statement; the first matching policy decides
+type Policy struct {
+    Allow   bool
+    Queries []Rule
+}
+
+func (p Policy) String() string {
+    if p.Allow {
+        return "allow if " + queriesString(p.Queries)
+    }
+    return "deny if " + queriesString(p.Queries)
+}
+
+func queriesString(queries []Rule) string {
+    parts := make([]string, len(queries))
+    for i, q := range queries {
+        parts[i] = q.bodyString()
+    }
+    return strings.Join(parts, " or ")
+}
+
+type bindings map[string]Term
+
+func (b bindings) resolve(t Term) (Term, bool) {
+    if t.kind != termVariable {
+        return t, true
+    }
+    v, ok := b[t.str]
+    return v, ok
+}
+
+// world is a deduplicated fact set
+type world struct {
+    seen  map[string]bool
+    facts []Predicate
+}
+
+func newWorld() *world {
+    return &world{seen: make(map[string]bool)}
+}
+
+func (w *world) add(f Predicate) bool {
+    key := f.String()
+    if w.seen[key] {
+        return false
+    }
+    w.seen[key] = true
+    w.facts = append(w.facts, f)
+    return true
+}
+
+// run evaluates rules to a fixed point over facts
+func run(facts []Predicate, rules []Rule) (*world, error) {
+    w := newWorld()
+    for _, f := range facts {
+        w.add(f)
+    }
+    for i := 0; i < maxIterations; i++ {
+        var derived []Predicate
+        for _, r := range rules {
+            for _, b := range w.query(r) {
+                derived = append(derived, substitute(r.Head, b))
+            }
+        }
+        added := false
+        for _, f := range derived {
+            if w.add(f) {
+                added = true
+            }
+        }
+        if len(w.facts) > maxFacts {
+            return nil, ErrLimitsExceeded
+        }
+        if !added {
+            return w, nil
+        }
+    }
+    return nil, ErrLimitsExceeded
+}
+
+// matches reports whether any query finds a binding
+func (w *world) matches(queries []Rule) bool {
+    for _, q := range queries {
+        if len(w.query(q)) > 0 {
+            return true
+        }
+    }
+    return false
+}
+
+func (w *world) query(r Rule) []bindings {
+    var out []bindings
+    w.join(r.Body, bindings{}, func(b bindings) {
+        for _, e := range r.Expressions {
+            if !evaluate(e, b) {
+                return
+            }
+        }
+        out = append(out, b)
+    })
+    return out
+}
+
+func (w *world) join(body []Predicate, b bindings, emit func(bindings)) {
+    if len(body) == 0 {
+        emit(b)
+        return
+    }
+    goal := body[0]
+    for _, f := range w.facts {
+        if next, ok := unify(goal, f, b); ok {
+            w.join(body[1:], next, emit)
+        }
+    }
+}
+
+func unify(goal, fact Predicate, b bindings) (bindings, bool) {
+    if goal.Name != fact.Name || len(goal.Terms) != len(fact.Terms) {
+        return nil, false
+    }
+    next := make(bindings, len(b)+len(goal.Terms))
+    for k, v := range b {
+        next[k] = v
+    }
+    for i, t := range goal.Terms {
+        if t.kind != termVariable {
+            if t != fact.Terms[i] {
+                return nil, false
+            }
+            continue
+        }
+        if bound, ok := next[t.str]; ok {
+            if bound != fact.Terms[i] {
+                return nil, false
+            }
+            continue
+        }
+        next[t.str] = fact.Terms[i]
+    }
+    return next, true
+}
+
+func substitute(p Predicate, b bindings) Predicate {
+    out := Predicate{Name: p.Name, Terms: make([]Term, len(p.Terms))}
+    for i, t := range p.Terms {
+        out.Terms[i], _ = b.resolve(t)
+    }
+    return out
+}
+
+func evaluate(e Expression, b bindings) bool {
+    left, ok := b.resolve(e.Left)
+    if !ok {
+        return false
+    }
+    right, ok := b.resolve(e.Right)
+    if !ok {
+        return false
+    }
+    switch e.Op {
+    case "==":
+        return left == right
+    case "!=":
+        return left != right
+    }
+    if left.kind != termInteger || right.kind != termInteger {
+        return false
+    }
+    switch e.Op {
+    case "<":
+        return left.num < right.num
+    case "<=":
+        return left.num <= right.num
+    case ">":
+        return left.num > right.num
+    case ">=":
+        return left.num >= right.num
+    }
+    return false
+}
+
+// checkSafe rejects rules whose head would contain unbound variables
+func checkSafe(r Rule) error {
+    bound := make(map[string]bool)
+    for _, p := range r.Body {
+        for _, t := range p.Terms {
+            if t.kind == termVariable {
+                bound[t.str] = true
+            }
+        }
+    }
+    for _, t := range r.Head.Terms {
+        if t.kind == termVariable && !bound[t.str] {
+            return ErrUnsafeRule
+        }
+    }
+    for _, e := range r.Expressions {
+        for _, t := range []Term{e.Left, e.Right} {
+            if t.kind == termVariable && !bound[t.str] {
+                return ErrUnsafeRule
+            }
+        }
+    }
+    return nil
+}
@@ -0,0 +1,305 @@
+package biscuit
+
+import (
+    "errors"
+    "strconv"
+    "strings"
+)
+
+var ErrSyntax = errors.New("datalog syntax error")
+
+// SyntaxError locates a parse failure in Datalog source; it matches ErrSyntax
+type SyntaxError struct {
+    Offset  int
+    Message string
+}
+
+func (e *SyntaxError) Error() string {
+    return ErrSyntax.Error() + " at offset " + strconv.Itoa(e.Offset) + ": " + e.Message
+}
+
+func (e *SyntaxError) Unwrap() error { return ErrSyntax }
+
+// Block is the Datalog carried by one token block
+type Block struct {
+    Facts  []Predicate
+    Rules  []Rule
+    Checks []Check
+}
+
+// ParseBlock parses semicolon separated facts, rules and checks, e.g.
+// right("lobby", "subscribe"); check if time($t), $t < 1700000000
+func ParseBlock(src string) (*Block, error) {
+    block, policies, err := parse(src)
+    if err != nil {
+        return nil, err
+    }
+    if len(policies) > 0 {
+        return nil, &SyntaxError{Message: "policies are only allowed in authorizer code"}
+    }
+    return block, nil
+}
+
+func (b *Block) String() string {
+    var parts []string
+    for _, f := range b.Facts {
+        parts = append(parts, f.String())
+    }
+    for _, r := range b.Rules {
+        parts = append(parts, r.String())
+    }
+    for _, c := range b.Checks {
+        parts = append(parts, c.String())
+    }
+    return strings.Join(parts, ";\n")
+}
+
+type parser struct {
+    src string
+    pos int
+}
+
+func parse(src string) (*Block, []Policy, error) {
+    p := &parser{src: src}
+    block := &Block{}
+    var policies []Policy
+    for {
+        p.skip()
+        if p.pos == len(p.src) {
+            break
+        }
+        switch {
+        case p.keyword("check"):
+            queries, err := p.conditions()
+            if err != nil {
+                return nil, nil, err
+            }
+            block.Checks = append(block.Checks, Check{Queries: queries})
+        case p.keyword("allow"):
+            queries, err := p.conditions()
+            if err != nil {
+                return nil, nil, err
+            }
+            policies = append(policies, Policy{Allow: true, Queries: queries})
+        case p.keyword("deny"):
+            queries, err := p.conditions()
+            if err != nil {
+                return nil, nil, err
+            }
+            policies = append(policies, Policy{Queries: queries})
+        default:
+            head, err := p.predicate()
+            if err != nil {
+                return nil, nil, err
+            }
+            if !p.consume("<-") {
+                for _, t := range head.Terms {
+                    if t.kind == termVariable {
+                        return nil, nil, p.fail("facts cannot contain variables")
+                    }
+                }
+                block.Facts = append(block.Facts, head)
+                break
+            }
+            body, exprs, err := p.body()
+            if err != nil {
+                return nil, nil, err
+            }
+            rule := Rule{Head: head, Body: body, Expressions: exprs}
+            if err := checkSafe(rule); err != nil {
+                return nil, nil, err
+            }
+            block.Rules = append(block.Rules, rule)
+        }
+        if !p.consume(";") {
+            p.skip()
+            if p.pos != len(p.src) {
+                return nil, nil, p.fail("expected ;")
+            }
+        }
+    }
+    return block, policies, nil
+}
+
+func (p *parser) fail(msg string) error {
+    return &SyntaxError{Offset: p.pos, Message: msg}
+}
+
+func (p *parser) skip() {
+    for p.pos < len(p.src) && strings.ContainsRune(" \t\r\n", rune(p.src[p.pos])) {
+        p.pos++
+    }
+}
+
+func (p *parser) consume(s string) bool {
+    p.skip()
+    if strings.HasPrefix(p.src[p.pos:], s) {
+        p.pos += len(s)
+        return true
+    }
+    return false
+}
+
+// keyword consumes w only when it is a whole word
+func (p *parser) keyword(w string) bool {
+    p.skip()
+    rest := p.src[p.pos:]
+    if strings.HasPrefix(rest, w) && (len(rest) == len(w) || !isIdentByte(rest[len(w)])) {
+        p.pos += len(w)
+        return true
+    }
+    return false
+}
+
+// conditions parses "if body [or body ...]"
+func (p *parser) conditions() ([]Rule, error) {
+    if !p.keyword("if") {
+        return nil, p.fail("expected if")
+    }
+    var queries []Rule
+    for {
+        body, exprs, err := p.body()
+        if err != nil {
+            return nil, err
+        }
+        q := Rule{Body: body, Expressions: exprs}
+        if err := checkSafe(q); err != nil {
+            return nil, err
+        }
+        queries = append(queries, q)
+        if !p.keyword("or") {
+            return queries, nil
+        }
+    }
+}
+
+func (p *parser) body() ([]Predicate, []Expression, error) {
+    var preds []Predicate
+    var exprs []Expression
+    for {
+        p.skip()
+        if p.pos < len(p.src) && isIdentStart(p.src[p.pos]) {
+            pred, err := p.predicate()
+            if err != nil {
+                return nil, nil, err
+            }
+            preds = append(preds, pred)
+        } else {
+            expr, err := p.expression()
+            if err != nil {
+                return nil, nil, err
+            }
+            exprs = append(exprs, expr)
+        }
+        if !p.consume(",") {
+            break
+        }
+    }
+    if len(preds) == 0 {
+        return nil, nil, p.fail("a body needs at least one predicate")
+    }
+    return preds, exprs, nil
+}
+
+func (p *parser) expression() (Expression, error) {
+    left, err := p.term()
+    if err != nil {
+        return Expression{}, err
+    }
+    for _, op := range []string{"<=", ">=", "==", "!=", "<", ">"} {
+        if p.consume(op) {
+            right, err := p.term()
+            if err != nil {
+                return Expression{}, err
+            }
+            return Expression{Left: left, Op: op, Right: right}, nil
+        }
+    }
+    return Expression{}, p.fail("expected a comparison operator")
+}
+
+func (p *parser) predicate() (Predicate, error) {
+    p.skip()
+    name := p.ident()
+    if name == "" {
+        return Predicate{}, p.fail("expected a predicate name")
+    }
+    if !p.consume("(") {
+        return Predicate{}, p.fail("expected (")
+    }
+    pred := Predicate{Name: name}
+    if p.consume(")") {
+        return pred, nil
+    }
+    for {
+        t, err := p.term()
+        if err != nil {
+            return Predicate{}, err
+        }
+        pred.Terms = append(pred.Terms, t)
+        if p.consume(")") {
+            return pred, nil
+        }
+        if !p.consume(",") {
+            return Predicate{}, p.fail("expected , or )")
+        }
+    }
+}
+
+func (p *parser) term() (Term, error) {
+    p.skip()
+    if p.pos == len(p.src) {
+        return Term{}, p.fail("expected a term")
+    }
+    switch c := p.src[p.pos]; {
+    case c == '$':
+        p.pos++
+        name := p.ident()
+        if name == "" {
+            return Term{}, p.fail("expected a variable name")
+        }
+        return Variable(name), nil
+    case c == '"':
+        quoted, err := strconv.QuotedPrefix(p.src[p.pos:])
+        if err != nil {
+            return Term{}, p.fail("unterminated string")
+        }
+        s, err := strconv.Unquote(quoted)
+        if err != nil {
+            return Term{}, p.fail("invalid string")
+        }
+        p.pos += len(quoted)
+        return String(s), nil
+    case c == '-' || (c >= '0' && c <= '9'):
+        start := p.pos
+        p.pos++
+        for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+            p.pos++
+        }
+        n, err := strconv.ParseInt(p.src[start:p.pos], 10, 64)
+        if err != nil {
+            return Term{}, p.fail("invalid integer")
+        }
+        return Integer(n), nil
+    }
+    return Term{}, p.fail("expected a term")
+}
+
+func (p *parser) ident() string {
+    start := p.pos
+    if p.pos < len(p.src) && isIdentStart(p.src[p.pos]) {
+        p.pos++
+        for p.pos < len(p.src) && isIdentByte(p.src[p.pos]) {
+            p.pos++
+        }
+    }
+    return p.src[start:p.pos]
+}
+
+func isIdentStart(c byte) bool {
+    return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func isIdentByte(c byte) bool {
+    return isIdentStart(c) || c >= '0' && c <= '9'
+}
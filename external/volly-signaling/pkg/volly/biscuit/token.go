@@ -0,0 +1,159 @@
+package biscuit
+
+import (
+    "crypto/ed25519"
+    "crypto/rand"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+)
+
+// Experimental biscuit-style credential: Ed25519 signed Datalog blocks where each
+// block is signed by a key the previous block committed to. The token carries the
+// last private key, so any holder can append a block offline but cannot remove one.
+// The encoding is JSON, not the biscuit-auth protobuf format, and is not interoperable.
+
+// maxBlocks bounds attenuation depth
+const maxBlocks = 16
+
+var (
+    ErrInvalidToken     = errors.New("invalid biscuit token")
+    ErrInvalidSignature = errors.New("biscuit block signature does not verify")
+    ErrTooManyBlocks    = errors.New("biscuit has too many blocks")
+)
+
+type signedBlock struct {
+    // Source is the block's canonical Datalog; it is what gets signed
+    Source    string `json:"block"`
+    NextKey   []byte `json:"nextKey"`
+    Signature []byte `json:"signature"`
+}
+
+type wireToken struct {
+    Authority signedBlock   `json:"authority"`
+    Blocks    []signedBlock `json:"blocks,omitempty"`
+    Proof     []byte        `json:"proof"`
+}
+
+// Biscuit is a verified credential: an authority block from the issuer followed by attenuation blocks
+type Biscuit struct {
+    wire      wireToken
+    authority *Block
+    blocks    []*Block
+}
+
+// New mints a credential whose authority block is signed by the issuer's root key
+func New(rootKey ed25519.PrivateKey, authority *Block) (*Biscuit, error) {
+    sb, proof, err := signBlock(rootKey, authority)
+    if err != nil {
+        return nil, err
+    }
+    return &Biscuit{
+        wire:      wireToken{Authority: sb, Proof: proof.Seed()},
+        authority: authority,
+    }, nil
+}
+
+// Attenuate appends a block of checks, returning a new credential that can do at most what b can.
+// Facts in the block are only visible to the block's own checks, never to the authorizer
+func (b *Biscuit) Attenuate(block *Block) (*Biscuit, error) {
+    if len(b.blocks)+1 > maxBlocks {
+        return nil, ErrTooManyBlocks
+    }
+    sb, proof, err := signBlock(ed25519.NewKeyFromSeed(b.wire.Proof), block)
+    if err != nil {
+        return nil, err
+    }
+    child := &Biscuit{
+        wire: wireToken{
+            Authority: b.wire.Authority,
+            Blocks:    append(append([]signedBlock(nil), b.wire.Blocks...), sb),
+            Proof:     proof.Seed(),
+        },
+        authority: b.authority,
+        blocks:    append(append([]*Block(nil), b.blocks...), block),
+    }
+    return child, nil
+}
+
+// AttenuateSource parses src with ParseBlock and appends it
+func (b *Biscuit) AttenuateSource(src string) (*Biscuit, error) {
+    block, err := ParseBlock(src)
+    if err != nil {
+        return nil, err
+    }
+    return b.Attenuate(block)
+}
+
+// BlockCount is the number of blocks including the authority block
+func (b *Biscuit) BlockCount() int {
+    return len(b.blocks) + 1
+}
+
+// Serialize encodes the credential for transport
+func (b *Biscuit) Serialize() (string, error) {
+    data, err := json.Marshal(b.wire)
+    if err != nil {
+        return "", err
+    }
+    return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Parse decodes a serialized credential and verifies its signature chain against the root public key
+func Parse(token string, rootKey ed25519.PublicKey) (*Biscuit, error) {
+    data, err := base64.RawURLEncoding.DecodeString(token)
+    if err != nil {
+        return nil, ErrInvalidToken
+    }
+    var wire wireToken
+    if err := json.Unmarshal(data, &wire); err != nil {
+        return nil, ErrInvalidToken
+    }
+    if len(wire.Blocks) > maxBlocks {
+        return nil, ErrTooManyBlocks
+    }
+    if len(rootKey) != ed25519.PublicKeySize || len(wire.Proof) != ed25519.SeedSize {
+        return nil, ErrInvalidToken
+    }
+
+    b := &Biscuit{wire: wire}
+    key := rootKey
+    for i, sb := range append([]signedBlock{wire.Authority}, wire.Blocks...) {
+        if len(sb.NextKey) != ed25519.PublicKeySize || !ed25519.Verify(key, blockMessage(sb), sb.Signature) {
+            return nil, ErrInvalidSignature
+        }
+        block, err := ParseBlock(sb.Source)
+        if err != nil {
+            return nil, err
+        }
+        if i == 0 {
+            b.authority = block
+        } else {
+            b.blocks = append(b.blocks, block)
+        }
+        key = sb.NextKey
+    }
+
+    // The proof must be the private half of the last committed key
+    proof := ed25519.NewKeyFromSeed(wire.Proof).Public().(ed25519.PublicKey)
+    if !proof.Equal(key) {
+        return nil, ErrInvalidSignature
+    }
+    return b, nil
+}
+
+func signBlock(key ed25519.PrivateKey, block *Block) (signedBlock, ed25519.PrivateKey, error) {
+    nextPub, next, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil {
+        return signedBlock{}, nil, err
+    }
+    sb := signedBlock{Source: block.String(), NextKey: nextPub}
+    sb.Signature = ed25519.Sign(key, blockMessage(sb))
+    return sb, next, nil
+}
+
+func blockMessage(sb signedBlock) []byte {
+    msg := make([]byte, 0, len(sb.Source)+len(sb.NextKey))
+    msg = append(msg, sb.Source...)
+    return append(msg, sb.NextKey...)
+}
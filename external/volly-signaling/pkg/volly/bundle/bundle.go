@@ -0,0 +1,273 @@
+// Package bundle loads configuration from signed, versioned files for deployments without an
+// online config store, such as air-gapped sites. An operator signs each bundle with an
+// ML-DSA key whose public half the deployment is configured with; a bundle is applied only
+// if its signature verifies and its version is at least the highest one applied before, so
+// an old bundle holding a since-removed key or a looser policy cannot be replayed
+package bundle
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/configstore"
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+    "github.com/volly-org/volly-signaling/pkg/volly/secure"
+    "github.com/volly-org/volly-signaling/pkg/volly/snapshot"
+)
+
+// Format is the Contents format Sign writes
+const Format = 1
+
+// signingContext separates bundle signatures from anything else the operator key signs
+const signingContext = "volly-config-bundle-v1\x00"
+
+var (
+    ErrInvalidBundle    = errors.New("not a Volly configuration bundle")
+    ErrInvalidSignature = errors.New("bundle signature does not verify against the operator key")
+    ErrRollback         = errors.New("bundle version is older than one already applied")
+    ErrUnknownFormat    = errors.New("bundle format is not supported")
+)
+
+// Contents is the configuration a bundle carries. API key secrets are in the clear, so a
+// bundle file needs the protection the secrets do
+type Contents struct {
+    Format int `json:"format"`
+    // Version must grow with every bundle the operator signs
+    Version      uint64                   `json:"version"`
+    CreatedAt    time.Time                `json:"createdAt"`
+    Tenants      []configstore.Tenant     `json:"tenants"`
+    APIKeys      []snapshot.APIKey        `json:"apiKeys"`
+    RoomPolicies []configstore.RoomPolicy `json:"roomPolicies"`
+}
+
+// envelope is the file: the signed Contents and the signature over them
+type envelope struct {
+    Payload   []byte `json:"payload"`
+    Algorithm string `json:"algorithm"`
+    Signature []byte `json:"signature"`
+}
+
+// Sign encodes c under signer, normally the operator's ML-DSA key. A zero CreatedAt is now
+func Sign(c Contents, signer crypto.Signer) ([]byte, error) {
+    c.Format = Format
+    if c.CreatedAt.IsZero() {
+        c.CreatedAt = time.Now().UTC()
+    }
+    payload, err := json.Marshal(c)
+    if err != nil {
+        return nil, err
+    }
+    defer secure.Wipe(payload)
+    sig, err := signer.Sign(append([]byte(signingContext), payload...))
+    if err != nil {
+        return nil, err
+    }
+    return json.MarshalIndent(envelope{Payload: payload, Algorithm: signer.Algorithm(), Signature: sig}, "", "  ")
+}
+
+// Open verifies data against the operator key and returns its contents. The caller wipes
+// the API key secrets once applied, as Apply does
+func Open(data []byte, algorithm string, publicKey []byte) (*Contents, error) {
+    var env envelope
+    if err := json.Unmarshal(data, &env); err != nil || len(env.Payload) == 0 {
+        return nil, ErrInvalidBundle
+    }
+    defer secure.Wipe(env.Payload)
+    if env.Algorithm != algorithm {
+        return nil, fmt.Errorf("%w: signed with %s, want %s", ErrInvalidSignature, env.Algorithm, algorithm)
+    }
+    if err := crypto.VerifySignature(algorithm, publicKey, append([]byte(signingContext), env.Payload...), env.Signature); err != nil {
+        return nil, ErrInvalidSignature
+    }
+    var c Contents
+    if err := json.Unmarshal(env.Payload, &c); err != nil {
+        return nil, ErrInvalidBundle
+    }
+    if c.Format != Format {
+        wipe(&c)
+        return nil, ErrUnknownFormat
+    }
+    return &c, nil
+}
+
+func wipe(c *Contents) {
+    for _, k := range c.APIKeys {
+        secure.Wipe(k.Secret)
+    }
+}
+
+// VersionStore remembers the highest bundle version applied
+type VersionStore interface {
+    Load() (uint64, error)
+    Store(version uint64) error
+}
+
+// FileVersion keeps the version in a file, written atomically. A missing file is version 0
+type FileVersion string
+
+// Load reads the version
+func (f FileVersion) Load() (uint64, error) {
+    data, err := os.ReadFile(string(f))
+    if errors.Is(err, os.ErrNotExist) {
+        return 0, nil
+    }
+    if err != nil {
+        return 0, err
+    }
+    return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// Store writes version to a temporary file and renames it into place
+func (f FileVersion) Store(version uint64) error {
+    tmp, err := os.CreateTemp(filepath.Dir(string(f)), filepath.Base(string(f))+".*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+    if _, err := tmp.WriteString(strconv.FormatUint(version, 10) + "\n"); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Sync(); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), string(f))
+}
+
+// Summary describes an applied bundle without its secrets
+type Summary struct {
+    Version      uint64    `json:"version"`
+    CreatedAt    time.Time `json:"createdAt"`
+    Tenants      int       `json:"tenants"`
+    APIKeys      int       `json:"apiKeys"`
+    RoomPolicies int       `json:"roomPolicies"`
+    // Unchanged is set when the bundle's version was already applied, e.g. on restart
+    Unchanged bool `json:"unchanged,omitempty"`
+}
+
+// Loader applies bundles to a config store, refusing rollbacks
+type Loader struct {
+    algorithm string
+    publicKey []byte
+    versions  VersionStore
+    store     configstore.Store
+}
+
+// NewLoader applies bundles signed by the operator key to store, recording versions in versions
+func NewLoader(algorithm string, publicKey []byte, versions VersionStore, store configstore.Store) *Loader {
+    return &Loader{algorithm: algorithm, publicKey: publicKey, versions: versions, store: store}
+}
+
+// Load verifies data and, unless its version is older than the last applied, replaces the
+// store's tenants, API keys and room policies with the bundle's. A bundle of the applied
+// version is applied again, so a restart with an empty store comes back to it
+func (l *Loader) Load(ctx context.Context, data []byte) (*Summary, error) {
+    c, err := Open(data, l.algorithm, l.publicKey)
+    if err != nil {
+        return nil, err
+    }
+    defer wipe(c)
+    applied, err := l.versions.Load()
+    if err != nil {
+        return nil, err
+    }
+    if c.Version < applied {
+        return nil, fmt.Errorf("%w: %d, applied %d", ErrRollback, c.Version, applied)
+    }
+    if err := Apply(ctx, l.store, c); err != nil {
+        return nil, err
+    }
+    if c.Version > applied {
+        if err := l.versions.Store(c.Version); err != nil {
+            return nil, err
+        }
+    }
+    return &Summary{
+        Version:      c.Version,
+        CreatedAt:    c.CreatedAt,
+        Tenants:      len(c.Tenants),
+        APIKeys:      len(c.APIKeys),
+        RoomPolicies: len(c.RoomPolicies),
+        Unchanged:    c.Version == applied,
+    }, nil
+}
+
+// Apply makes store hold exactly c's tenants, API keys and room policies: everything in c is
+// upserted, then whatever c does not name is deleted
+func Apply(ctx context.Context, store configstore.Store, c *Contents) error {
+    tenants := make(map[string]bool, len(c.Tenants))
+    for _, t := range c.Tenants {
+        tenants[t.ID] = true
+        if err := store.PutTenant(ctx, t); err != nil {
+            return err
+        }
+    }
+    keys := make(map[string]bool, len(c.APIKeys))
+    for _, k := range c.APIKeys {
+        keys[k.ID] = true
+        entry := configstore.APIKey{ID: k.ID, Tenant: k.Tenant, Scopes: k.Scopes, NotAfter: k.NotAfter}
+        if len(k.Secret) > 0 {
+            var err error
+            if entry.Secret, err = secure.FromBytes(append([]byte(nil), k.Secret...)); err != nil {
+                return err
+            }
+        }
+        if err := store.PutAPIKey(ctx, entry); err != nil {
+            return err
+        }
+    }
+    type policyKey struct{ tenant, name string }
+    policies := make(map[policyKey]bool, len(c.RoomPolicies))
+    for _, p := range c.RoomPolicies {
+        policies[policyKey{p.Tenant, p.Name}] = true
+        if err := store.PutRoomPolicy(ctx, p); err != nil {
+            return err
+        }
+    }
+
+    existingPolicies, err := store.ListRoomPolicies(ctx, "")
+    if err != nil {
+        return err
+    }
+    for _, p := range existingPolicies {
+        if !policies[policyKey{p.Tenant, p.Name}] {
+            if err := store.DeleteRoomPolicy(ctx, p.Tenant, p.Name); err != nil {
+                return err
+            }
+        }
+    }
+    existingKeys, err := store.ListAPIKeys(ctx, "")
+    if err != nil {
+        return err
+    }
+    for _, k := range existingKeys {
+        if !keys[k.ID] {
+            if err := store.DeleteAPIKey(ctx, k.ID); err != nil {
+                return err
+            }
+        }
+    }
+    existingTenants, err := store.ListTenants(ctx)
+    if err != nil {
+        return err
+    }
+    for _, t := range existingTenants {
+        if !tenants[t.ID] {
+            if err := store.DeleteTenant(ctx, t.ID); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
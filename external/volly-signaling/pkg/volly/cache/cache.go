@@ -0,0 +1,215 @@
+package cache
+
+import (
+    "context"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/events"
+)
+
+// EventInvalidated tells every instance to drop a cached entry; Data carries kind and key
+const EventInvalidated = "cache_invalidated"
+
+// Cached kinds, used in invalidation events and Stats
+const (
+    KindTenant     = "tenant"
+    KindAPIKey     = "apikey"
+    KindRoomPolicy = "roompolicy"
+    KindPQKey      = "pqkey"
+)
+
+// DefaultMaxEntries bounds each kind's cache
+const DefaultMaxEntries = 100000
+
+// TTLs bound how stale an entry can get when an invalidation event is lost
+type TTLs struct {
+    Tenant     time.Duration
+    APIKey     time.Duration
+    RoomPolicy time.Duration
+    PQKey      time.Duration
+    // Negative caches not-found results so unknown IDs cannot hammer the store
+    Negative time.Duration
+}
+
+// DefaultTTLs favour freshness for credentials and longer reuse for slow-moving config
+var DefaultTTLs = TTLs{
+    Tenant:     time.Minute,
+    APIKey:     30 * time.Second,
+    RoomPolicy: time.Minute,
+    PQKey:      30 * time.Second,
+    Negative:   5 * time.Second,
+}
+
+// Stats counts lookups for one kind
+type Stats struct {
+    Hits          uint64 `json:"hits"`
+    Misses        uint64 `json:"misses"`
+    Evictions     uint64 `json:"evictions"`
+    Invalidations uint64 `json:"invalidations"`
+    Entries       int    `json:"entries"`
+    // HitRate is the fraction of lookups served from the cache
+    HitRate float64 `json:"hitRate"`
+}
+
+type entry struct {
+    value   interface{}
+    err     error
+    expires time.Time
+}
+
+// table caches one kind. gen advances on every invalidation so a read-through that raced
+// with a write does not cache the value it read before the write
+type table struct {
+    ttl, negative time.Duration
+    max           int
+    clock         clock.Clock
+
+    mu      sync.Mutex
+    gen     uint64
+    entries map[string]entry
+
+    hits, misses, evictions, invalidations uint64
+}
+
+func newTable(ttl, negative time.Duration) *table {
+    return &table{ttl: ttl, negative: negative, max: DefaultMaxEntries, clock: clock.System, entries: make(map[string]entry)}
+}
+
+// load returns the cached result for key or calls fetch and caches its result;
+// errors other than notFound are never cached
+func (t *table) load(key string, notFound func(error) bool, fetch func() (interface{}, error)) (interface{}, error) {
+    now := t.clock.Now()
+    t.mu.Lock()
+    e, ok := t.entries[key]
+    if ok && now.Before(e.expires) {
+        t.mu.Unlock()
+        atomic.AddUint64(&t.hits, 1)
+        return e.value, e.err
+    }
+    gen := t.gen
+    t.mu.Unlock()
+    atomic.AddUint64(&t.misses, 1)
+
+    value, err := fetch()
+    ttl := t.ttl
+    if err != nil {
+        if !notFound(err) || t.negative <= 0 {
+            return value, err
+        }
+        ttl = t.negative
+    }
+    if ttl <= 0 {
+        return value, err
+    }
+
+    t.mu.Lock()
+    if t.gen == gen {
+        if len(t.entries) >= t.max {
+            t.evictLocked(now)
+        }
+        t.entries[key] = entry{value: value, err: err, expires: now.Add(ttl)}
+    }
+    t.mu.Unlock()
+    return value, err
+}
+
+// evictLocked drops expired entries, or an arbitrary tenth of the table when none have expired
+func (t *table) evictLocked(now time.Time) {
+    for k, e := range t.entries {
+        if !now.Before(e.expires) {
+            delete(t.entries, k)
+            t.evictions++
+        }
+    }
+    for k := range t.entries {
+        if len(t.entries) < t.max-t.max/10 {
+            break
+        }
+        delete(t.entries, k)
+        t.evictions++
+    }
+}
+
+// invalidate drops key, or everything when key is empty
+func (t *table) invalidate(key string) {
+    t.mu.Lock()
+    t.gen++
+    if key == "" {
+        t.entries = make(map[string]entry)
+    } else {
+        delete(t.entries, key)
+    }
+    t.mu.Unlock()
+    atomic.AddUint64(&t.invalidations, 1)
+}
+
+func (t *table) stats() Stats {
+    t.mu.Lock()
+    n, evictions := len(t.entries), t.evictions
+    t.mu.Unlock()
+    s := Stats{
+        Hits:          atomic.LoadUint64(&t.hits),
+        Misses:        atomic.LoadUint64(&t.misses),
+        Evictions:     evictions,
+        Invalidations: atomic.LoadUint64(&t.invalidations),
+        Entries:       n,
+    }
+    if total := s.Hits + s.Misses; total > 0 {
+        s.HitRate = float64(s.Hits) / float64(total)
+    }
+    return s
+}
+
+// invalidator drops entries locally and tells other instances over the bus
+type invalidator struct {
+    bus         events.Bus
+    tables      map[string]*table
+    unsubscribe func()
+}
+
+func newInvalidator(bus events.Bus, tables map[string]*table) *invalidator {
+    inv := &invalidator{bus: bus, tables: tables}
+    if bus != nil {
+        inv.unsubscribe = bus.Subscribe(EventInvalidated, func(ctx context.Context, event events.Event) {
+            if t, ok := inv.tables[event.Data["kind"]]; ok {
+                t.invalidate(event.Data["key"])
+            }
+        })
+    }
+    return inv
+}
+
+// invalidate runs after a successful write; the bus echoes the event back, which is harmless
+func (inv *invalidator) invalidate(ctx context.Context, kind, key string) error {
+    inv.tables[kind].invalidate(key)
+    if inv.bus == nil {
+        return nil
+    }
+    return inv.bus.Publish(ctx, events.Event{
+        Type: EventInvalidated,
+        Data: map[string]string{"kind": kind, "key": key},
+    })
+}
+
+func (inv *invalidator) setClock(c clock.Clock) {
+    for _, t := range inv.tables {
+        t.clock = c
+    }
+}
+
+func (inv *invalidator) stats() map[string]Stats {
+    out := make(map[string]Stats, len(inv.tables))
+    for kind, t := range inv.tables {
+        out[kind] = t.stats()
+    }
+    return out
+}
+
+func (inv *invalidator) close() {
+    if inv.unsubscribe != nil {
+        inv.unsubscribe()
+    }
+}
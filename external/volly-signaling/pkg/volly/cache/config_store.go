@@ -0,0 +1,139 @@
+package cache
+
+import (
+    "context"
+    "errors"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/configstore"
+    "github.com/volly-org/volly-signaling/pkg/volly/events"
+)
+
+// ConfigStore is a read-through configstore.Store. Tenant, API key and per-tenant room
+// policy lookups are cached; writes go to the backing store and then invalidate the entry
+// on every instance. Listing tenants and keys is an admin path and always hits the store
+type ConfigStore struct {
+    store configstore.Store
+    inv   *invalidator
+
+    tenants, apiKeys, policies *table
+}
+
+// NewConfigStore caches store; bus may be nil for a single instance
+func NewConfigStore(store configstore.Store, bus events.Bus, ttls TTLs) *ConfigStore {
+    s := &ConfigStore{
+        store:    store,
+        tenants:  newTable(ttls.Tenant, ttls.Negative),
+        apiKeys:  newTable(ttls.APIKey, ttls.Negative),
+        policies: newTable(ttls.RoomPolicy, 0),
+    }
+    s.inv = newInvalidator(bus, map[string]*table{
+        KindTenant:     s.tenants,
+        KindAPIKey:     s.apiKeys,
+        KindRoomPolicy: s.policies,
+    })
+    return s
+}
+
+// SetClock sets the time source for expiry
+func (s *ConfigStore) SetClock(c clock.Clock) *ConfigStore {
+    s.inv.setClock(c)
+    return s
+}
+
+// Stats reports hit rates per kind
+func (s *ConfigStore) Stats() map[string]Stats {
+    return s.inv.stats()
+}
+
+// Close detaches from the event bus
+func (s *ConfigStore) Close() {
+    s.inv.close()
+}
+
+func isNotFound(err error) bool {
+    return errors.Is(err, configstore.ErrNotFound)
+}
+
+func (s *ConfigStore) GetTenant(ctx context.Context, id string) (configstore.Tenant, error) {
+    v, err := s.tenants.load(id, isNotFound, func() (interface{}, error) {
+        return s.store.GetTenant(ctx, id)
+    })
+    t, _ := v.(configstore.Tenant)
+    return t, err
+}
+
+func (s *ConfigStore) PutTenant(ctx context.Context, t configstore.Tenant) error {
+    if err := s.store.PutTenant(ctx, t); err != nil {
+        return err
+    }
+    return s.inv.invalidate(ctx, KindTenant, t.ID)
+}
+
+func (s *ConfigStore) DeleteTenant(ctx context.Context, id string) error {
+    if err := s.store.DeleteTenant(ctx, id); err != nil {
+        return err
+    }
+    return s.inv.invalidate(ctx, KindTenant, id)
+}
+
+func (s *ConfigStore) ListTenants(ctx context.Context) ([]configstore.Tenant, error) {
+    return s.store.ListTenants(ctx)
+}
+
+// GetAPIKey shares the backing store's Secret, which the store keeps owning
+func (s *ConfigStore) GetAPIKey(ctx context.Context, id string) (configstore.APIKey, error) {
+    v, err := s.apiKeys.load(id, isNotFound, func() (interface{}, error) {
+        return s.store.GetAPIKey(ctx, id)
+    })
+    k, _ := v.(configstore.APIKey)
+    return k, err
+}
+
+func (s *ConfigStore) PutAPIKey(ctx context.Context, k configstore.APIKey) error {
+    if err := s.store.PutAPIKey(ctx, k); err != nil {
+        return err
+    }
+    return s.inv.invalidate(ctx, KindAPIKey, k.ID)
+}
+
+func (s *ConfigStore) DeleteAPIKey(ctx context.Context, id string) error {
+    if err := s.store.DeleteAPIKey(ctx, id); err != nil {
+        return err
+    }
+    return s.inv.invalidate(ctx, KindAPIKey, id)
+}
+
+func (s *ConfigStore) ListAPIKeys(ctx context.Context, tenant string) ([]configstore.APIKey, error) {
+    return s.store.ListAPIKeys(ctx, tenant)
+}
+
+func (s *ConfigStore) PutRoomPolicy(ctx context.Context, p configstore.RoomPolicy) error {
+    if err := s.store.PutRoomPolicy(ctx, p); err != nil {
+        return err
+    }
+    return s.invalidatePolicies(ctx, p.Tenant)
+}
+
+func (s *ConfigStore) DeleteRoomPolicy(ctx context.Context, tenant, name string) error {
+    if err := s.store.DeleteRoomPolicy(ctx, tenant, name); err != nil {
+        return err
+    }
+    return s.invalidatePolicies(ctx, tenant)
+}
+
+// ListRoomPolicies is cached per tenant since gateways consult it on every join; the
+// returned slice is shared and must not be modified
+func (s *ConfigStore) ListRoomPolicies(ctx context.Context, tenant string) ([]configstore.RoomPolicy, error) {
+    v, err := s.policies.load(tenant, isNotFound, func() (interface{}, error) {
+        return s.store.ListRoomPolicies(ctx, tenant)
+    })
+    p, _ := v.([]configstore.RoomPolicy)
+    return p, err
+}
+
+// invalidatePolicies drops every cached list, since the all-tenants list includes the
+// tenant's policies too; policy writes are rare enough not to matter
+func (s *ConfigStore) invalidatePolicies(ctx context.Context, tenant string) error {
+    return s.inv.invalidate(ctx, KindRoomPolicy, "")
+}
@@ -0,0 +1,304 @@
+package cache
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+)
+
+// Document cache defaults
+const (
+    DefaultDocumentFresh    = 5 * time.Minute
+    DefaultDocumentMaxStale = 24 * time.Hour
+    DefaultDocumentMaxSize  = 1 << 20
+    documentFetchTimeout    = 10 * time.Second
+)
+
+var ErrDocumentUnavailable = errors.New("remote document is unavailable and no usable copy is cached")
+
+// DocumentStats counts lookups of remote documents
+type DocumentStats struct {
+    Hits   uint64 `json:"hits"`
+    Misses uint64 `json:"misses"`
+    // Stale counts lookups answered from a stale copy while it was revalidated
+    Stale       uint64 `json:"stale"`
+    DiskLoads   uint64 `json:"diskLoads"`
+    FetchErrors uint64 `json:"fetchErrors"`
+    Entries     int    `json:"entries"`
+}
+
+// document is a fetched copy as kept in memory and on disk
+type document struct {
+    URL        string    `json:"url"`
+    Body       []byte    `json:"body"`
+    ETag       string    `json:"etag,omitempty"`
+    FetchedAt  time.Time `json:"fetchedAt"`
+    FreshUntil time.Time `json:"freshUntil"`
+
+    refreshing bool
+}
+
+// Documents caches remote documents such as partner JWKS and federation descriptors in
+// memory, then on disk, then over the network. A fresh copy is served as is; a stale one
+// within the max-stale window is served while a background fetch revalidates it, so an
+// outage at the partner's endpoint only shows once the copy is older than that window. A
+// copy on disk survives restarts. Fetches that fail, or that the caller's check refuses,
+// never replace a good copy
+type Documents struct {
+    dir      string
+    client   *http.Client
+    clock    clock.Clock
+    fresh    time.Duration
+    maxStale time.Duration
+    maxSize  int64
+
+    mu   sync.Mutex
+    docs map[string]*document
+
+    hits, misses, stale, diskLoads, fetchErrors uint64
+}
+
+// NewDocuments creates a document cache persisting to dir; an empty dir keeps it in memory
+func NewDocuments(dir string) *Documents {
+    return &Documents{
+        dir:      dir,
+        client:   &http.Client{Timeout: documentFetchTimeout},
+        clock:    clock.System,
+        fresh:    DefaultDocumentFresh,
+        maxStale: DefaultDocumentMaxStale,
+        maxSize:  DefaultDocumentMaxSize,
+        docs:     make(map[string]*document),
+    }
+}
+
+// SetHTTPClient replaces the client used to fetch documents
+func (d *Documents) SetHTTPClient(client *http.Client) *Documents {
+    d.client = client
+    return d
+}
+
+// SetClock sets the time source for freshness
+func (d *Documents) SetClock(c clock.Clock) *Documents {
+    d.clock = c
+    return d
+}
+
+// SetFresh sets how long a copy is served without revalidating; a shorter Cache-Control
+// max-age from the origin wins
+func (d *Documents) SetFresh(ttl time.Duration) *Documents {
+    d.fresh = ttl
+    return d
+}
+
+// SetMaxStale sets how long past freshness a copy is still served while the origin is down
+func (d *Documents) SetMaxStale(ttl time.Duration) *Documents {
+    d.maxStale = ttl
+    return d
+}
+
+// Get returns the document at url. check, when set, vets a fetched body before it is
+// cached, so a partner serving garbage keeps the last good copy in use
+func (d *Documents) Get(ctx context.Context, url string, check func([]byte) error) ([]byte, error) {
+    now := d.clock.Now()
+    d.mu.Lock()
+    doc, ok := d.docs[url]
+    d.mu.Unlock()
+    if !ok {
+        if doc = d.loadDisk(url); doc != nil {
+            atomic.AddUint64(&d.diskLoads, 1)
+            d.mu.Lock()
+            if current, ok := d.docs[url]; ok {
+                doc = current
+            } else {
+                d.docs[url] = doc
+            }
+            d.mu.Unlock()
+        }
+    }
+
+    switch {
+    case doc != nil && now.Before(doc.FreshUntil):
+        atomic.AddUint64(&d.hits, 1)
+        return doc.Body, nil
+    case doc != nil && now.Before(doc.FreshUntil.Add(d.maxStale)):
+        atomic.AddUint64(&d.stale, 1)
+        d.revalidate(url, check)
+        return doc.Body, nil
+    }
+    atomic.AddUint64(&d.misses, 1)
+    fetched, err := d.fetch(ctx, url, doc, check)
+    if err != nil {
+        atomic.AddUint64(&d.fetchErrors, 1)
+        return nil, fmt.Errorf("%w: %s: %v", ErrDocumentUnavailable, url, err)
+    }
+    return fetched.Body, nil
+}
+
+// Invalidate drops url from memory and disk, e.g. when a partner rotated keys ahead of time
+func (d *Documents) Invalidate(url string) {
+    d.mu.Lock()
+    delete(d.docs, url)
+    d.mu.Unlock()
+    if d.dir != "" {
+        os.Remove(d.path(url))
+    }
+}
+
+// Stats reports lookups and fetch failures
+func (d *Documents) Stats() DocumentStats {
+    d.mu.Lock()
+    n := len(d.docs)
+    d.mu.Unlock()
+    return DocumentStats{
+        Hits:        atomic.LoadUint64(&d.hits),
+        Misses:      atomic.LoadUint64(&d.misses),
+        Stale:       atomic.LoadUint64(&d.stale),
+        DiskLoads:   atomic.LoadUint64(&d.diskLoads),
+        FetchErrors: atomic.LoadUint64(&d.fetchErrors),
+        Entries:     n,
+    }
+}
+
+// revalidate refreshes url in the background, once at a time per document
+func (d *Documents) revalidate(url string, check func([]byte) error) {
+    d.mu.Lock()
+    doc, ok := d.docs[url]
+    if !ok || doc.refreshing {
+        d.mu.Unlock()
+        return
+    }
+    doc.refreshing = true
+    d.mu.Unlock()
+
+    go func() {
+        if _, err := d.fetch(context.Background(), url, doc, check); err != nil {
+            atomic.AddUint64(&d.fetchErrors, 1)
+        }
+        d.mu.Lock()
+        doc.refreshing = false
+        d.mu.Unlock()
+    }()
+}
+
+// fetch GETs url, conditionally on prev's ETag, and stores the result in memory and on disk
+func (d *Documents) fetch(ctx context.Context, url string, prev *document, check func([]byte) error) (*document, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+    if err != nil {
+        return nil, err
+    }
+    if prev != nil && prev.ETag != "" {
+        req.Header.Set("If-None-Match", prev.ETag)
+    }
+    resp, err := d.client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    now := d.clock.Now()
+    doc := &document{URL: url, ETag: resp.Header.Get("ETag"), FetchedAt: now, FreshUntil: now.Add(d.freshFor(resp.Header))}
+    switch {
+    case resp.StatusCode == http.StatusNotModified && prev != nil:
+        doc.Body = prev.Body
+        if doc.ETag == "" {
+            doc.ETag = prev.ETag
+        }
+    case resp.StatusCode == http.StatusOK:
+        body, err := io.ReadAll(io.LimitReader(resp.Body, d.maxSize+1))
+        if err != nil {
+            return nil, err
+        }
+        if int64(len(body)) > d.maxSize {
+            return nil, fmt.Errorf("document exceeds %d bytes", d.maxSize)
+        }
+        if check != nil {
+            if err := check(body); err != nil {
+                return nil, err
+            }
+        }
+        doc.Body = body
+    default:
+        return nil, fmt.Errorf("origin answered %s", resp.Status)
+    }
+
+    d.mu.Lock()
+    d.docs[url] = doc
+    d.mu.Unlock()
+    d.storeDisk(doc)
+    return doc, nil
+}
+
+// freshFor is the configured freshness, shortened by the origin's max-age
+func (d *Documents) freshFor(h http.Header) time.Duration {
+    fresh := d.fresh
+    for _, directive := range strings.Split(h.Get("Cache-Control"), ",") {
+        name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+        switch strings.ToLower(name) {
+        case "no-cache", "no-store":
+            return 0
+        case "max-age":
+            if secs, err := strconv.Atoi(value); err == nil && secs >= 0 && time.Duration(secs)*time.Second < fresh {
+                fresh = time.Duration(secs) * time.Second
+            }
+        }
+    }
+    return fresh
+}
+
+func (d *Documents) path(url string) string {
+    sum := sha256.Sum256([]byte(url))
+    return filepath.Join(d.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// loadDisk reads url's copy from disk; unreadable copies are ignored and refetched
+func (d *Documents) loadDisk(url string) *document {
+    if d.dir == "" {
+        return nil
+    }
+    data, err := os.ReadFile(d.path(url))
+    if err != nil {
+        return nil
+    }
+    var doc document
+    if json.Unmarshal(data, &doc) != nil || doc.URL != url {
+        return nil
+    }
+    return &doc
+}
+
+// storeDisk writes doc to a temporary file and renames it into place; a failed write only
+// costs the copy surviving a restart
+func (d *Documents) storeDisk(doc *document) {
+    if d.dir == "" {
+        return
+    }
+    data, err := json.Marshal(doc)
+    if err != nil {
+        return
+    }
+    path := d.path(doc.URL)
+    tmp, err := os.CreateTemp(d.dir, filepath.Base(path)+".*")
+    if err != nil {
+        return
+    }
+    defer os.Remove(tmp.Name())
+    _, err = tmp.Write(data)
+    if cerr := tmp.Close(); err != nil || cerr != nil {
+        return
+    }
+    os.Rename(tmp.Name(), path)
+}
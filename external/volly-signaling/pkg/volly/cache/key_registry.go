@@ -0,0 +1,69 @@
+package cache
+
+import (
+    "context"
+    "errors"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/events"
+    "github.com/volly-org/volly-signaling/pkg/volly/keys"
+)
+
+// KeyRegistry is a read-through keys.Registry for the PQ key lookups on the verification path
+type KeyRegistry struct {
+    registry keys.Registry
+    inv      *invalidator
+    keys     *table
+}
+
+// NewKeyRegistry caches registry; bus may be nil for a single instance
+func NewKeyRegistry(registry keys.Registry, bus events.Bus, ttls TTLs) *KeyRegistry {
+    r := &KeyRegistry{registry: registry, keys: newTable(ttls.PQKey, ttls.Negative)}
+    r.inv = newInvalidator(bus, map[string]*table{KindPQKey: r.keys})
+    return r
+}
+
+// SetClock sets the time source for cache expiry and key expiry checks
+func (r *KeyRegistry) SetClock(c clock.Clock) *KeyRegistry {
+    r.inv.setClock(c)
+    return r
+}
+
+// Stats reports the hit rate
+func (r *KeyRegistry) Stats() map[string]Stats {
+    return r.inv.stats()
+}
+
+// Close detaches from the event bus
+func (r *KeyRegistry) Close() {
+    r.inv.close()
+}
+
+// Get serves cached records, re-checking expiry so a key cannot outlive ExpiresAt in the cache
+func (r *KeyRegistry) Get(ctx context.Context, identity string) (keys.KeyRecord, error) {
+    v, err := r.keys.load(identity, func(err error) bool { return errors.Is(err, keys.ErrKeyNotFound) }, func() (interface{}, error) {
+        return r.registry.Get(ctx, identity)
+    })
+    if err != nil {
+        return keys.KeyRecord{}, err
+    }
+    record := v.(keys.KeyRecord)
+    if record.Expired(r.keys.clock.Now()) {
+        return keys.KeyRecord{}, keys.ErrKeyExpired
+    }
+    return record, nil
+}
+
+func (r *KeyRegistry) Put(ctx context.Context, record keys.KeyRecord) error {
+    if err := r.registry.Put(ctx, record); err != nil {
+        return err
+    }
+    return r.inv.invalidate(ctx, KindPQKey, record.Identity)
+}
+
+func (r *KeyRegistry) Delete(ctx context.Context, identity string) error {
+    if err := r.registry.Delete(ctx, identity); err != nil {
+        return err
+    }
+    return r.inv.invalidate(ctx, KindPQKey, identity)
+}
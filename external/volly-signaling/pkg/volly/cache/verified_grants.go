@@ -0,0 +1,256 @@
+package cache
+
+import (
+    "context"
+    "crypto/sha256"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/events"
+    "github.com/volly-org/volly-signaling/pkg/volly/killswitch"
+    "github.com/volly-org/volly-signaling/pkg/volly/revocation"
+)
+
+// DefaultGrantTTL bounds how long a verification is reused; events end it sooner
+const DefaultGrantTTL = time.Minute
+
+type grantEntry struct {
+    grant   auth.VollyVideoGrant
+    expires time.Time
+}
+
+// VerifiedGrantCache remembers successful verifications by token hash so repeat joins and
+// reconnects skip signature checks. Revocations, engaged kill switches and tenant, API key
+// and room policy changes announced on the bus drop every cached grant they could affect as
+// soon as the event arrives, and a grant is never served past its token or PQ key expiry.
+// Events only reach this instance's bus, so with a shared backend SetRecheck must re-check
+// what other instances can change on every hit
+type VerifiedGrantCache struct {
+    ttl         time.Duration
+    max         int
+    clock       clock.Clock
+    recheck     func(token string, grant *auth.VollyVideoGrant) error
+    unsubscribe func()
+
+    mu      sync.Mutex
+    gen     uint64
+    entries map[[sha256.Size]byte]grantEntry
+
+    hits, misses, evictions, invalidations uint64
+}
+
+// NewVerifiedGrantCache creates a cache; bus may be nil, leaving only the ttl
+func NewVerifiedGrantCache(bus events.Bus, ttl time.Duration) *VerifiedGrantCache {
+    c := &VerifiedGrantCache{
+        ttl:     ttl,
+        max:     DefaultMaxEntries,
+        clock:   clock.System,
+        entries: make(map[[sha256.Size]byte]grantEntry),
+    }
+    if bus != nil {
+        unsubscribers := []func(){
+            bus.Subscribe(revocation.EventRevoked, c.handleRevoked),
+            bus.Subscribe(killswitch.EventEngaged, c.handleKillSwitch),
+            bus.Subscribe(EventInvalidated, c.handleInvalidated),
+        }
+        c.unsubscribe = func() {
+            for _, unsubscribe := range unsubscribers {
+                unsubscribe()
+            }
+        }
+    }
+    return c
+}
+
+// SetClock sets the time source for expiry
+func (c *VerifiedGrantCache) SetClock(clk clock.Clock) *VerifiedGrantCache {
+    c.clock = clk
+    return c
+}
+
+// SetMaxEntries bounds the number of cached grants
+func (c *VerifiedGrantCache) SetMaxEntries(max int) *VerifiedGrantCache {
+    c.max = max
+    return c
+}
+
+// SetRecheck runs check on every cache hit; a failure drops the entry and is returned instead
+// of the grant
+func (c *VerifiedGrantCache) SetRecheck(check func(token string, grant *auth.VollyVideoGrant) error) *VerifiedGrantCache {
+    c.recheck = check
+    return c
+}
+
+// Verify returns the cached grant for token or calls verify and caches a successful result.
+// Grants are copies; slices are shared and must not be modified
+func (c *VerifiedGrantCache) Verify(token string, verify func(token string) (*auth.VollyVideoGrant, error)) (*auth.VollyVideoGrant, error) {
+    key := sha256.Sum256([]byte(token))
+    now := c.clock.Now()
+
+    c.mu.Lock()
+    e, ok := c.entries[key]
+    if ok && now.Before(e.expires) {
+        c.mu.Unlock()
+        grant := e.grant
+        if c.recheck != nil {
+            if err := c.recheck(token, &grant); err != nil {
+                c.mu.Lock()
+                delete(c.entries, key)
+                c.mu.Unlock()
+                return nil, err
+            }
+        }
+        atomic.AddUint64(&c.hits, 1)
+        return &grant, nil
+    }
+    gen := c.gen
+    c.mu.Unlock()
+    atomic.AddUint64(&c.misses, 1)
+
+    grant, err := verify(token)
+    if err != nil {
+        return nil, err
+    }
+    expires := now.Add(c.ttl)
+    if grant.ExpiresAt != 0 {
+        if exp := time.Unix(grant.ExpiresAt, 0); exp.Before(expires) {
+            expires = exp
+        }
+    }
+    if grant.PQKeyExpiry != 0 {
+        if exp := time.Unix(grant.PQKeyExpiry, 0); exp.Before(expires) {
+            expires = exp
+        }
+    }
+    if !now.Before(expires) {
+        return grant, nil
+    }
+
+    c.mu.Lock()
+    // A revocation that raced the verification may not have been checked by it
+    if c.gen == gen {
+        if len(c.entries) >= c.max {
+            c.evictLocked(now)
+        }
+        c.entries[key] = grantEntry{grant: *grant, expires: expires}
+    }
+    c.mu.Unlock()
+    return grant, nil
+}
+
+// evictLocked drops expired entries, or an arbitrary tenth of the cache when none have expired
+func (c *VerifiedGrantCache) evictLocked(now time.Time) {
+    for k, e := range c.entries {
+        if !now.Before(e.expires) {
+            delete(c.entries, k)
+            c.evictions++
+        }
+    }
+    for k := range c.entries {
+        if len(c.entries) < c.max-c.max/10 {
+            break
+        }
+        delete(c.entries, k)
+        c.evictions++
+    }
+}
+
+// invalidate drops every entry match selects, or all entries when match is nil, and returns
+// how many it dropped
+func (c *VerifiedGrantCache) invalidate(match func(g *auth.VollyVideoGrant) bool) int {
+    c.mu.Lock()
+    c.gen++
+    n := 0
+    if match == nil {
+        n = len(c.entries)
+        c.entries = make(map[[sha256.Size]byte]grantEntry)
+    } else {
+        for k, e := range c.entries {
+            if match(&e.grant) {
+                delete(c.entries, k)
+                n++
+            }
+        }
+    }
+    c.mu.Unlock()
+    atomic.AddUint64(&c.invalidations, 1)
+    return n
+}
+
+func (c *VerifiedGrantCache) handleRevoked(ctx context.Context, event events.Event) {
+    if jti, ok := event.Data["jti"]; ok {
+        c.invalidate(func(g *auth.VollyVideoGrant) bool {
+            if g.TokenID == jti {
+                return true
+            }
+            for _, id := range g.TokenChain {
+                if id == jti {
+                    return true
+                }
+            }
+            return false
+        })
+        return
+    }
+    tenant, room, identity := event.Data["tenant"], event.Data["room"], event.Data["identity"]
+    c.invalidate(func(g *auth.VollyVideoGrant) bool {
+        return (tenant == "" || g.Tenant == tenant) && (room == "" || g.Room == room) &&
+            (identity == "" || g.Identity == identity)
+    })
+}
+
+func (c *VerifiedGrantCache) handleKillSwitch(ctx context.Context, event events.Event) {
+    tenant, room := event.Data["tenant"], event.Room
+    c.invalidate(func(g *auth.VollyVideoGrant) bool {
+        return (tenant == "" || g.Tenant == tenant) && (room == "" || g.Room == room)
+    })
+}
+
+// handleInvalidated drops a changed tenant's grants; API key and policy keys do not map onto
+// grants, so those changes drop everything
+func (c *VerifiedGrantCache) handleInvalidated(ctx context.Context, event events.Event) {
+    if tenant := event.Data["key"]; event.Data["kind"] == KindTenant && tenant != "" {
+        c.invalidate(func(g *auth.VollyVideoGrant) bool { return g.Tenant == tenant })
+        return
+    }
+    if event.Data["kind"] == KindPQKey {
+        identity := event.Data["key"]
+        c.invalidate(func(g *auth.VollyVideoGrant) bool { return identity == "" || g.Identity == identity })
+        return
+    }
+    c.invalidate(nil)
+}
+
+// Forget drops the cached grants of identity in tenant, e.g. once its data is erased, and
+// returns how many it dropped
+func (c *VerifiedGrantCache) Forget(tenant, identity string) int {
+    return c.invalidate(func(g *auth.VollyVideoGrant) bool { return g.Tenant == tenant && g.Identity == identity })
+}
+
+// Stats reports the hit rate
+func (c *VerifiedGrantCache) Stats() Stats {
+    c.mu.Lock()
+    n, evictions := len(c.entries), c.evictions
+    c.mu.Unlock()
+    s := Stats{
+        Hits:          atomic.LoadUint64(&c.hits),
+        Misses:        atomic.LoadUint64(&c.misses),
+        Evictions:     evictions,
+        Invalidations: atomic.LoadUint64(&c.invalidations),
+        Entries:       n,
+    }
+    if total := s.Hits + s.Misses; total > 0 {
+        s.HitRate = float64(s.Hits) / float64(total)
+    }
+    return s
+}
+
+// Close detaches from the event bus
+func (c *VerifiedGrantCache) Close() {
+    if c.unsubscribe != nil {
+        c.unsubscribe()
+    }
+}
@@ -0,0 +1,49 @@
+package cache
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/events"
+    "github.com/volly-org/volly-signaling/pkg/volly/revocation"
+)
+
+// TestRecheckSeesOtherInstances revokes a token on one instance of a shared revocation store
+// and checks another instance stops serving its cached grant, which the bus alone misses
+func TestRecheckSeesOtherInstances(t *testing.T) {
+    ctx := context.Background()
+    shared := revocation.NewMemoryStore()
+    instance := func() (*VerifiedGrantCache, *revocation.Revoker) {
+        bus := events.NewMemoryBus()
+        return NewVerifiedGrantCache(bus, time.Hour), revocation.NewRevoker(shared).SetEventBus(bus)
+    }
+    a, revokerA := instance()
+    unchecked, _ := instance()
+    _, revokerB := instance()
+    a.SetRecheck(func(token string, grant *auth.VollyVideoGrant) error { return revokerA.Check(ctx, grant) })
+
+    verify := func(string) (*auth.VollyVideoGrant, error) {
+        return &auth.VollyVideoGrant{TokenID: "t1", Tenant: "acme", Identity: "alice"}, nil
+    }
+    for _, c := range []*VerifiedGrantCache{a, unchecked} {
+        if _, err := c.Verify("token", verify); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := revokerB.Revoke(ctx, "t1", time.Now().Add(time.Hour)); err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := unchecked.Verify("token", verify); err != nil {
+        t.Fatalf("cache without a recheck: %v; the test no longer shows what the recheck is for", err)
+    }
+    if _, err := a.Verify("token", verify); !errors.Is(err, revocation.ErrTokenRevoked) {
+        t.Fatalf("rechecked cache: got %v, want ErrTokenRevoked", err)
+    }
+    if n := a.Stats().Entries; n != 0 {
+        t.Fatalf("%d entries left after a failed recheck, want 0", n)
+    }
+}
@@ -0,0 +1,273 @@
+// Package canary continuously exercises the token path with a throwaway identity: it issues a
+// token, verifies it, revokes it and checks that it no longer verifies. A broken key
+// rotation, a store the verifier cannot reach or revocations that stop propagating show up
+// here, in readiness and metrics, before users' joins start failing
+package canary
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+)
+
+const (
+    DefaultInterval = 30 * time.Second
+    // DefaultTTL keeps canary tokens, and their revocation records, short-lived
+    DefaultTTL      = time.Minute
+    DefaultIdentity = "volly-canary"
+    // DefaultThreshold is how many runs in a row must fail before Ready reports it, so one
+    // slow store round trip does not pull every replica out of the load balancer
+    DefaultThreshold = 2
+)
+
+// Stages of a run, in order
+const (
+    StageIssue   = "issue"
+    StageVerify  = "verify"
+    StageRevoke  = "revoke"
+    StageRevoked = "revoked"
+)
+
+var stages = []string{StageIssue, StageVerify, StageRevoke, StageRevoked}
+
+var (
+    ErrNotRun     = errors.New("canary has not completed a run")
+    ErrNotRevoked = errors.New("revoked canary token still verifies")
+)
+
+// Error is a failed run; it matches the stage's underlying error
+type Error struct {
+    Stage string
+    Err   error
+}
+
+func (e *Error) Error() string { return "canary " + e.Stage + ": " + e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Path is the token path under test
+type Path interface {
+    // Issue mints a token for identity valid for ttl, returning its jti
+    Issue(ctx context.Context, identity string, ttl time.Duration) (token, jti string, expiresAt time.Time, err error)
+    // Verify checks a token the way admission does
+    Verify(ctx context.Context, token string) error
+    // Revoke revokes a single token
+    Revoke(ctx context.Context, jti string, expiresAt time.Time) error
+}
+
+// Result is one run
+type Result struct {
+    At      time.Time     `json:"at"`
+    Elapsed time.Duration `json:"elapsed"`
+    // Stage is where the run failed; empty when it passed
+    Stage string `json:"stage,omitempty"`
+    Error string `json:"error,omitempty"`
+}
+
+// Stats covers every run since start
+type Stats struct {
+    Runs                uint64            `json:"runs"`
+    Failures            uint64            `json:"failures"`
+    FailuresByStage     map[string]uint64 `json:"failuresByStage"`
+    ConsecutiveFailures int               `json:"consecutiveFailures"`
+    LastSuccess         time.Time         `json:"lastSuccess,omitempty"`
+    Last                *Result           `json:"last,omitempty"`
+}
+
+// Canary runs the path on an interval
+type Canary struct {
+    path      Path
+    interval  time.Duration
+    ttl       time.Duration
+    identity  string
+    threshold int
+    clock     clock.Clock
+
+    // probe serializes runs, so an on-demand run never overlaps a scheduled one
+    probe sync.Mutex
+
+    mu          sync.Mutex
+    runs        uint64
+    failures    map[string]uint64
+    consecutive int
+    lastSuccess time.Time
+    last        *Result
+    lastErr     error
+}
+
+// New runs path every DefaultInterval once Run is called
+func New(path Path) *Canary {
+    return &Canary{
+        path:      path,
+        interval:  DefaultInterval,
+        ttl:       DefaultTTL,
+        identity:  DefaultIdentity,
+        threshold: DefaultThreshold,
+        clock:     clock.System,
+        failures:  make(map[string]uint64),
+    }
+}
+
+// SetInterval sets the time between runs
+func (c *Canary) SetInterval(d time.Duration) *Canary {
+    c.interval = d
+    return c
+}
+
+// SetTTL sets the canary tokens' validity
+func (c *Canary) SetTTL(d time.Duration) *Canary {
+    c.ttl = d
+    return c
+}
+
+// SetIdentity sets the identity canary tokens are issued to
+func (c *Canary) SetIdentity(identity string) *Canary {
+    c.identity = identity
+    return c
+}
+
+// SetThreshold sets how many consecutive failures make Ready fail
+func (c *Canary) SetThreshold(n int) *Canary {
+    c.threshold = n
+    return c
+}
+
+// SetClock sets the clock results are timed with
+func (c *Canary) SetClock(cl clock.Clock) *Canary {
+    c.clock = cl
+    return c
+}
+
+// Run probes at once and then every interval until ctx ends
+func (c *Canary) Run(ctx context.Context) {
+    ticker := time.NewTicker(c.interval)
+    defer ticker.Stop()
+    for {
+        c.Probe(ctx)
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// Probe runs the path once and records the result
+func (c *Canary) Probe(ctx context.Context) *Result {
+    c.probe.Lock()
+    defer c.probe.Unlock()
+
+    start := c.clock.Now()
+    err := c.exercise(ctx)
+    res := &Result{At: start, Elapsed: c.clock.Now().Sub(start)}
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.runs++
+    c.last, c.lastErr = res, err
+    if err == nil {
+        c.consecutive = 0
+        c.lastSuccess = start
+        return res
+    }
+    c.consecutive++
+    var ce *Error
+    if errors.As(err, &ce) {
+        res.Stage = ce.Stage
+        c.failures[ce.Stage]++
+    }
+    res.Error = err.Error()
+    return res
+}
+
+func (c *Canary) exercise(ctx context.Context) error {
+    token, jti, expiresAt, err := c.path.Issue(ctx, c.identity, c.ttl)
+    if err != nil {
+        return &Error{Stage: StageIssue, Err: err}
+    }
+    if err := c.path.Verify(ctx, token); err != nil {
+        return &Error{Stage: StageVerify, Err: err}
+    }
+    if err := c.path.Revoke(ctx, jti, expiresAt); err != nil {
+        return &Error{Stage: StageRevoke, Err: err}
+    }
+    if err := c.path.Verify(ctx, token); err == nil {
+        return &Error{Stage: StageRevoked, Err: ErrNotRevoked}
+    }
+    return nil
+}
+
+// Ready fails once threshold runs in a row have failed, with the last run's error
+func (c *Canary) Ready() error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    switch {
+    case c.runs == 0:
+        return ErrNotRun
+    case c.consecutive >= c.threshold:
+        return c.lastErr
+    }
+    return nil
+}
+
+// Stats returns a snapshot
+func (c *Canary) Stats() Stats {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    s := Stats{
+        Runs:                c.runs,
+        FailuresByStage:     make(map[string]uint64, len(c.failures)),
+        ConsecutiveFailures: c.consecutive,
+        LastSuccess:         c.lastSuccess,
+    }
+    for stage, n := range c.failures {
+        s.FailuresByStage[stage] = n
+        s.Failures += n
+    }
+    if c.last != nil {
+        last := *c.last
+        s.Last = &last
+    }
+    return s
+}
+
+// WriteMetrics writes the stats in the Prometheus text format
+func (c *Canary) WriteMetrics(w io.Writer) error {
+    s := c.Stats()
+    up := 0
+    if s.Runs > 0 && s.ConsecutiveFailures == 0 {
+        up = 1
+    }
+    var elapsed float64
+    if s.Last != nil {
+        elapsed = s.Last.Elapsed.Seconds()
+    }
+    var lastSuccess float64
+    if !s.LastSuccess.IsZero() {
+        lastSuccess = float64(s.LastSuccess.UnixNano()) / 1e9
+    }
+
+    metric := func(name, kind, help string) {
+        fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+    }
+    metric("volly_canary_up", "gauge", "Whether the last canary run passed.")
+    fmt.Fprintf(w, "volly_canary_up %d\n", up)
+    metric("volly_canary_runs_total", "counter", "Canary runs since start.")
+    fmt.Fprintf(w, "volly_canary_runs_total %d\n", s.Runs)
+    metric("volly_canary_failures_total", "counter", "Failed canary runs by the stage that failed.")
+    for _, stage := range stages {
+        fmt.Fprintf(w, "volly_canary_failures_total{stage=%q} %d\n", stage, s.FailuresByStage[stage])
+    }
+    metric("volly_canary_consecutive_failures", "gauge", "Canary runs failed in a row.")
+    fmt.Fprintf(w, "volly_canary_consecutive_failures %d\n", s.ConsecutiveFailures)
+    metric("volly_canary_duration_seconds", "gauge", "Duration of the last canary run.")
+    fmt.Fprintf(w, "volly_canary_duration_seconds %g\n", elapsed)
+    metric("volly_canary_last_success_timestamp_seconds", "gauge", "Unix time of the last passing canary run.")
+    _, err := fmt.Fprintf(w, "volly_canary_last_success_timestamp_seconds %g\n", lastSuccess)
+    return err
+}
@@ -0,0 +1,59 @@
+package challenge
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "net/netip"
+)
+
+// HeaderResponse carries the client's solved challenge or CAPTCHA token
+const HeaderResponse = "Volly-Challenge-Response"
+
+var (
+    ErrChallengeRequired = errors.New("a solved challenge is required for guest issuance")
+    ErrChallengeFailed   = errors.New("challenge response was rejected")
+)
+
+// Provider verifies a client's challenge response, e.g. a CAPTCHA token or a proof-of-work solution
+type Provider interface {
+    Verify(ctx context.Context, response string, remoteIP netip.Addr) error
+}
+
+// Issuer is implemented by providers that hand out the challenge themselves, like the built-in
+// proof of work; CAPTCHA widgets get theirs from the vendor instead
+type Issuer interface {
+    NewChallenge() (string, error)
+}
+
+// Middleware requires a verified challenge response before next, meant to sit in front of guest
+// and anonymous issuance only. Rejected requests get 403; when the provider is an Issuer the
+// response carries a fresh challenge in the Volly-Challenge header
+func Middleware(provider Provider, next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        response := r.Header.Get(HeaderResponse)
+        err := ErrChallengeRequired
+        if response != "" {
+            err = provider.Verify(r.Context(), response, remoteAddr(r))
+        }
+        if err == nil {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        if issuer, ok := provider.(Issuer); ok {
+            if c, cerr := issuer.NewChallenge(); cerr == nil {
+                w.Header().Set("Volly-Challenge", c)
+            }
+        }
+        http.Error(w, err.Error(), http.StatusForbidden)
+    })
+}
+
+func remoteAddr(r *http.Request) netip.Addr {
+    addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+    if err != nil {
+        return netip.Addr{}
+    }
+    return addrPort.Addr()
+}
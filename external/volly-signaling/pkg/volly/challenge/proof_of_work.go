@@ -0,0 +1,162 @@
+package challenge
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/binary"
+    "math/bits"
+    "net/netip"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+)
+
+// Proof-of-work defaults: about a million hashes, a second or two in a browser
+const (
+    DefaultDifficulty   = 20
+    DefaultChallengeTTL = 2 * time.Minute
+)
+
+// maxSpent bounds the single-use solution cache
+const maxSpent = 100000
+
+// ProofOfWork is a built-in, stateless hashcash-style challenge. A challenge is
+// "nonce.difficulty.expiry.mac"; a solution appends ".counter" such that
+// SHA-256(challenge "." counter) starts with difficulty zero bits
+type ProofOfWork struct {
+    key        []byte
+    difficulty int
+    ttl        time.Duration
+    clock      clock.Clock
+
+    mu    sync.Mutex
+    spent map[string]time.Time
+}
+
+// NewProofOfWork creates the provider; key authenticates issued challenges
+func NewProofOfWork(key []byte) *ProofOfWork {
+    return &ProofOfWork{
+        key:        key,
+        difficulty: DefaultDifficulty,
+        ttl:        DefaultChallengeTTL,
+        clock:      clock.System,
+        spent:      make(map[string]time.Time),
+    }
+}
+
+// SetDifficulty sets the required number of leading zero bits
+func (p *ProofOfWork) SetDifficulty(bits int) *ProofOfWork {
+    p.difficulty = bits
+    return p
+}
+
+// SetTTL sets how long a challenge can be solved
+func (p *ProofOfWork) SetTTL(ttl time.Duration) *ProofOfWork {
+    p.ttl = ttl
+    return p
+}
+
+// SetClock sets the time source for challenge expiry
+func (p *ProofOfWork) SetClock(c clock.Clock) *ProofOfWork {
+    p.clock = c
+    return p
+}
+
+// NewChallenge issues a challenge
+func (p *ProofOfWork) NewChallenge() (string, error) {
+    nonce := make([]byte, 16)
+    if _, err := rand.Read(nonce); err != nil {
+        return "", err
+    }
+    body := base64.RawURLEncoding.EncodeToString(nonce) + "." +
+        strconv.Itoa(p.difficulty) + "." +
+        strconv.FormatInt(p.clock.Now().Add(p.ttl).Unix(), 10)
+    return body + "." + p.mac(body), nil
+}
+
+// Verify checks a solution once; replays of the same challenge are rejected
+func (p *ProofOfWork) Verify(ctx context.Context, response string, remoteIP netip.Addr) error {
+    i := strings.LastIndexByte(response, '.')
+    if i < 0 {
+        return ErrChallengeFailed
+    }
+    challenge := response[:i]
+    parts := strings.Split(challenge, ".")
+    if len(parts) != 4 {
+        return ErrChallengeFailed
+    }
+    body := strings.Join(parts[:3], ".")
+    if !hmac.Equal([]byte(parts[3]), []byte(p.mac(body))) {
+        return ErrChallengeFailed
+    }
+    difficulty, err := strconv.Atoi(parts[1])
+    if err != nil || difficulty < p.difficulty {
+        return ErrChallengeFailed
+    }
+    expiry, err := strconv.ParseInt(parts[2], 10, 64)
+    now := p.clock.Now()
+    if err != nil || now.Unix() > expiry {
+        return ErrChallengeFailed
+    }
+    if leadingZeroBits(sha256.Sum256([]byte(response))) < difficulty {
+        return ErrChallengeFailed
+    }
+
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    if _, ok := p.spent[challenge]; ok {
+        return ErrChallengeFailed
+    }
+    if len(p.spent) >= maxSpent {
+        for c, exp := range p.spent {
+            if now.After(exp) {
+                delete(p.spent, c)
+            }
+        }
+        if len(p.spent) >= maxSpent {
+            return ErrChallengeFailed
+        }
+    }
+    p.spent[challenge] = time.Unix(expiry, 0)
+    return nil
+}
+
+// Solve finds a solution by brute force, for SDKs and load tests
+func Solve(challenge string) string {
+    parts := strings.Split(challenge, ".")
+    difficulty := 0
+    if len(parts) == 4 {
+        difficulty, _ = strconv.Atoi(parts[1])
+    }
+    for counter := uint64(0); ; counter++ {
+        solution := challenge + "." + strconv.FormatUint(counter, 10)
+        if leadingZeroBits(sha256.Sum256([]byte(solution))) >= difficulty {
+            return solution
+        }
+    }
+}
+
+func (p *ProofOfWork) mac(body string) string {
+    h := hmac.New(sha256.New, p.key)
+    h.Write([]byte(body))
+    return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+func leadingZeroBits(sum [32]byte) int {
+    n := 0
+    for i := 0; i < len(sum); i += 8 {
+        word := binary.BigEndian.Uint64(sum[i:])
+        n += bits.LeadingZeros64(word)
+        if word != 0 {
+            break
+        }
+    }
+    return n
+}
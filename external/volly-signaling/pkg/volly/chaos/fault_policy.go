@@ -0,0 +1,142 @@
+package chaos
+
+import (
+    "context"
+    "errors"
+    "math/rand"
+    "sync"
+    "time"
+)
+
+var (
+    ErrInjectedFault = errors.New("injected fault")
+    ErrPartitioned   = errors.New("injected partition: backend unreachable")
+)
+
+// FaultPolicy describes the failures injected in front of a backend
+type FaultPolicy struct {
+    // Latency is added to every call, plus a uniform random delay up to Jitter
+    Latency time.Duration
+    Jitter  time.Duration
+
+    // ErrorRate is the fraction of calls, 0 to 1, that fail with Err
+    ErrorRate float64
+    Err       error
+
+    // Partitioned fails every call as if the backend were unreachable; calls
+    // block until the context is done when Hang is also set
+    Partitioned bool
+    Hang        bool
+}
+
+// Injector applies a FaultPolicy that can be changed while traffic is flowing
+type Injector struct {
+    mu     sync.RWMutex
+    policy FaultPolicy
+    rnd    *rand.Rand
+    rndMu  sync.Mutex
+}
+
+// NewInjector creates an injector starting with policy
+func NewInjector(policy FaultPolicy) *Injector {
+    return &Injector{
+        policy: policy,
+        rnd:    rand.New(rand.NewSource(time.Now().UnixNano())),
+    }
+}
+
+// SetPolicy replaces the active policy
+func (i *Injector) SetPolicy(policy FaultPolicy) *Injector {
+    i.mu.Lock()
+    i.policy = policy
+    i.mu.Unlock()
+    return i
+}
+
+// SetSeed makes error and jitter decisions reproducible
+func (i *Injector) SetSeed(seed int64) *Injector {
+    i.rndMu.Lock()
+    i.rnd = rand.New(rand.NewSource(seed))
+    i.rndMu.Unlock()
+    return i
+}
+
+// Clear removes all faults
+func (i *Injector) Clear() {
+    i.SetPolicy(FaultPolicy{})
+}
+
+// Policy returns the active policy
+func (i *Injector) Policy() FaultPolicy {
+    i.mu.RLock()
+    defer i.mu.RUnlock()
+    return i.policy
+}
+
+// Inject delays and possibly fails a call according to the active policy
+func (i *Injector) Inject(ctx context.Context) error {
+    policy := i.Policy()
+
+    if policy.Partitioned {
+        if policy.Hang {
+            <-ctx.Done()
+            return ctx.Err()
+        }
+        return ErrPartitioned
+    }
+
+    delay := policy.Latency
+    if policy.Jitter > 0 {
+        delay += time.Duration(i.float64() * float64(policy.Jitter))
+    }
+    if delay > 0 {
+        timer := time.NewTimer(delay)
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            return ctx.Err()
+        case <-timer.C:
+        }
+    }
+
+    if policy.ErrorRate > 0 && i.float64() < policy.ErrorRate {
+        if policy.Err != nil {
+            return policy.Err
+        }
+        return ErrInjectedFault
+    }
+    return nil
+}
+
+// Flap alternates between healthy and faulty every interval until ctx is done,
+// e.g. to simulate a Redis failover loop or a KMS brownout
+func (i *Injector) Flap(ctx context.Context, healthy, faulty time.Duration, policy FaultPolicy) {
+    for {
+        i.Clear()
+        if !sleep(ctx, healthy) {
+            return
+        }
+        i.SetPolicy(policy)
+        if !sleep(ctx, faulty) {
+            i.Clear()
+            return
+        }
+    }
+}
+
+func (i *Injector) float64() float64 {
+    i.rndMu.Lock()
+    defer i.rndMu.Unlock()
+    return i.rnd.Float64()
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+    timer := time.NewTimer(d)
+    defer timer.Stop()
+    select {
+    case <-ctx.Done():
+        return false
+    case <-timer.C:
+        return true
+    }
+}
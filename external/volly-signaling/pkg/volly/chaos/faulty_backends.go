@@ -0,0 +1,113 @@
+package chaos
+
+import (
+    "context"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+    "github.com/volly-org/volly-signaling/pkg/volly/keys"
+    "github.com/volly-org/volly-signaling/pkg/volly/presence"
+)
+
+// FaultyStore injects faults in front of a presence store
+type FaultyStore struct {
+    store    presence.Store
+    injector *Injector
+}
+
+// NewFaultyStore wraps store with injector
+func NewFaultyStore(store presence.Store, injector *Injector) *FaultyStore {
+    return &FaultyStore{store: store, injector: injector}
+}
+
+func (s *FaultyStore) Join(ctx context.Context, session presence.Session) error {
+    if err := s.injector.Inject(ctx); err != nil {
+        return err
+    }
+    return s.store.Join(ctx, session)
+}
+
+func (s *FaultyStore) Leave(ctx context.Context, sessionID string) error {
+    if err := s.injector.Inject(ctx); err != nil {
+        return err
+    }
+    return s.store.Leave(ctx, sessionID)
+}
+
+func (s *FaultyStore) Get(ctx context.Context, sessionID string) (presence.Session, error) {
+    if err := s.injector.Inject(ctx); err != nil {
+        return presence.Session{}, err
+    }
+    return s.store.Get(ctx, sessionID)
+}
+
+func (s *FaultyStore) ListRoom(ctx context.Context, room string) ([]presence.Session, error) {
+    if err := s.injector.Inject(ctx); err != nil {
+        return nil, err
+    }
+    return s.store.ListRoom(ctx, room)
+}
+
+func (s *FaultyStore) ListIdentity(ctx context.Context, identity string) ([]presence.Session, error) {
+    if err := s.injector.Inject(ctx); err != nil {
+        return nil, err
+    }
+    return s.store.ListIdentity(ctx, identity)
+}
+
+// FaultyRegistry injects faults in front of a key registry
+type FaultyRegistry struct {
+    registry keys.Registry
+    injector *Injector
+}
+
+// NewFaultyRegistry wraps registry with injector
+func NewFaultyRegistry(registry keys.Registry, injector *Injector) *FaultyRegistry {
+    return &FaultyRegistry{registry: registry, injector: injector}
+}
+
+func (r *FaultyRegistry) Put(ctx context.Context, record keys.KeyRecord) error {
+    if err := r.injector.Inject(ctx); err != nil {
+        return err
+    }
+    return r.registry.Put(ctx, record)
+}
+
+func (r *FaultyRegistry) Get(ctx context.Context, identity string) (keys.KeyRecord, error) {
+    if err := r.injector.Inject(ctx); err != nil {
+        return keys.KeyRecord{}, err
+    }
+    return r.registry.Get(ctx, identity)
+}
+
+func (r *FaultyRegistry) Delete(ctx context.Context, identity string) error {
+    if err := r.injector.Inject(ctx); err != nil {
+        return err
+    }
+    return r.registry.Delete(ctx, identity)
+}
+
+// FaultySigner injects faults in front of a signing backend such as a KMS
+type FaultySigner struct {
+    signer   crypto.Signer
+    injector *Injector
+}
+
+// NewFaultySigner wraps signer with injector
+func NewFaultySigner(signer crypto.Signer, injector *Injector) *FaultySigner {
+    return &FaultySigner{signer: signer, injector: injector}
+}
+
+func (s *FaultySigner) Algorithm() string { return s.signer.Algorithm() }
+
+func (s *FaultySigner) PublicKey() []byte { return s.signer.PublicKey() }
+
+func (s *FaultySigner) Sign(message []byte) ([]byte, error) {
+    // Without a context a hanging partition would block forever, so it fails fast instead
+    if s.injector.Policy().Partitioned {
+        return nil, ErrPartitioned
+    }
+    if err := s.injector.Inject(context.Background()); err != nil {
+        return nil, err
+    }
+    return s.signer.Sign(message)
+}
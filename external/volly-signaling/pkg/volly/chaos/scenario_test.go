@@ -0,0 +1,153 @@
+package chaos
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "testing"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/gateway"
+    "github.com/volly-org/volly-signaling/pkg/volly/presence"
+)
+
+// Scenarios put a presence store, as Redis backs it in production, behind an injector and
+// drive gateway admission through its session limiter while the store flaps or browns out.
+// Admission must fail closed while the store is unreachable, never count sessions it failed
+// to record, and recover fully once the store is back
+
+const scenarioLimit = 2
+
+type scenario struct {
+    store    *presence.MemoryStore
+    injector *Injector
+    admit    gateway.AdmissionHook
+}
+
+func newScenario(t *testing.T) *scenario {
+    t.Helper()
+    s := &scenario{store: presence.NewMemoryStore(), injector: NewInjector(FaultPolicy{}).SetSeed(1)}
+    limiter := presence.NewSessionLimiter(NewFaultyStore(s.store, s.injector), nil, presence.Limit{MaxSessions: scenarioLimit})
+    s.admit = gateway.AdmissionChain{gateway.AdmissionHookFunc(func(ctx context.Context, a *gateway.Admission) error {
+        _, err := limiter.Admit(ctx, presence.Session{SessionID: presence.NewSessionID(), Identity: a.Identity, Room: a.Room})
+        return err
+    })}
+    return s
+}
+
+// join admits identity to the scenario room with a deadline, as the gateway's handshake has
+func (s *scenario) join(identity string) error {
+    ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+    defer cancel()
+    return s.admit.Admit(ctx, &gateway.Admission{Identity: identity, Room: "chaos"})
+}
+
+// sessions counts what the store really holds for identity, bypassing the injector
+func (s *scenario) sessions(t *testing.T, identity string) int {
+    t.Helper()
+    held, err := s.store.ListIdentity(context.Background(), identity)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return len(held)
+}
+
+// TestScenarioStoreFlap partitions the store every other phase, as a Redis failover loop does
+func TestScenarioStoreFlap(t *testing.T) {
+    s := newScenario(t)
+    for cycle := 0; cycle < 3; cycle++ {
+        healthy := fmt.Sprintf("healthy-%d", cycle)
+        s.injector.Clear()
+        for i := 0; i < scenarioLimit; i++ {
+            if err := s.join(healthy); err != nil {
+                t.Fatalf("cycle %d: healthy store refused join %d: %v", cycle, i, err)
+            }
+        }
+        if err := s.join(healthy); !errors.Is(err, presence.ErrSessionLimitExceeded) {
+            t.Fatalf("cycle %d: join past the limit: got %v, want ErrSessionLimitExceeded", cycle, err)
+        }
+
+        partitioned := fmt.Sprintf("partitioned-%d", cycle)
+        s.injector.SetPolicy(FaultPolicy{Partitioned: true})
+        for i := 0; i < 2*scenarioLimit; i++ {
+            if err := s.join(partitioned); !errors.Is(err, ErrPartitioned) {
+                t.Fatalf("cycle %d: join during partition: got %v, want ErrPartitioned", cycle, err)
+            }
+        }
+        if n := s.sessions(t, partitioned); n != 0 {
+            t.Fatalf("cycle %d: partitioned joins left %d sessions behind", cycle, n)
+        }
+
+        // The identity refused during the partition gets its full allowance afterwards
+        s.injector.Clear()
+        for i := 0; i < scenarioLimit; i++ {
+            if err := s.join(partitioned); err != nil {
+                t.Fatalf("cycle %d: join after partition: %v", cycle, err)
+            }
+        }
+        if n := s.sessions(t, healthy); n != scenarioLimit {
+            t.Fatalf("cycle %d: %s holds %d sessions, want %d", cycle, healthy, n, scenarioLimit)
+        }
+    }
+}
+
+// TestScenarioStoreHang partitions the store so calls hang; admission must give up at the
+// handshake deadline instead of holding the connection
+func TestScenarioStoreHang(t *testing.T) {
+    s := newScenario(t)
+    s.injector.SetPolicy(FaultPolicy{Partitioned: true, Hang: true})
+
+    start := time.Now()
+    err := s.join("alice")
+    if !errors.Is(err, context.DeadlineExceeded) {
+        t.Fatalf("join during hang: got %v, want context.DeadlineExceeded", err)
+    }
+    if waited := time.Since(start); waited > 2*time.Second {
+        t.Fatalf("join during hang took %v", waited)
+    }
+
+    s.injector.Clear()
+    if err := s.join("alice"); err != nil {
+        t.Fatalf("join after hang: %v", err)
+    }
+}
+
+// TestScenarioStoreBrownout slows the store and fails a share of its calls, as a saturated
+// Redis does. Every join either succeeds or fails with the injected fault, and the limit holds
+// for an identity that keeps retrying
+func TestScenarioStoreBrownout(t *testing.T) {
+    s := newScenario(t)
+    s.injector.SetPolicy(FaultPolicy{Latency: time.Millisecond, Jitter: time.Millisecond, ErrorRate: 0.3})
+
+    var admitted, failed int
+    for i := 0; i < 100; i++ {
+        identity := fmt.Sprintf("user-%d", i)
+        switch err := s.join(identity); {
+        case err == nil:
+            admitted++
+            if n := s.sessions(t, identity); n != 1 {
+                t.Fatalf("%s was admitted but holds %d sessions", identity, n)
+            }
+        case errors.Is(err, ErrInjectedFault):
+            failed++
+            if n := s.sessions(t, identity); n != 0 {
+                t.Fatalf("%s was refused but holds %d sessions", identity, n)
+            }
+        default:
+            t.Fatalf("%s: unexpected error during brownout: %v", identity, err)
+        }
+    }
+    if admitted == 0 || failed == 0 {
+        t.Fatalf("brownout admitted %d and refused %d joins, want some of each", admitted, failed)
+    }
+
+    for i := 0; i < 20; i++ {
+        err := s.join("retrying")
+        if err != nil && !errors.Is(err, ErrInjectedFault) && !errors.Is(err, presence.ErrSessionLimitExceeded) {
+            t.Fatalf("retry %d: unexpected error during brownout: %v", i, err)
+        }
+        if n := s.sessions(t, "retrying"); n > scenarioLimit {
+            t.Fatalf("retry %d: limit of %d exceeded with %d sessions", i, scenarioLimit, n)
+        }
+    }
+}
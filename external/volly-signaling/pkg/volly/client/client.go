@@ -0,0 +1,648 @@
+// Package client is the Go SDK for watching a room's events from the gateway. A Client keeps
+// one WebSocket session open: it negotiates the signaling protocol, runs the ML-KEM key
+// exchange and checks the server's signature over it, refreshes its token before it expires,
+// and reconnects with backoff, resuming from its last ticket so a reconnect needs no token
+package client
+
+import (
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "math/rand"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+    "github.com/volly-org/volly-signaling/pkg/volly/protocol"
+    "github.com/volly-org/volly-signaling/pkg/volly/watch"
+    "github.com/volly-org/volly-signaling/pkg/volly/websocket"
+)
+
+// Defaults for reconnecting and refreshing
+const (
+    DefaultMinBackoff    = 500 * time.Millisecond
+    DefaultMaxBackoff    = 30 * time.Second
+    DefaultRefreshBefore = time.Minute
+    // RefreshRetry is how soon a token source that had no newer token is asked again
+    RefreshRetry = 5 * time.Second
+)
+
+// Name is sent in the hello so servers can tell SDK versions apart
+const Name = "volly-go/1"
+
+var (
+    ErrClosed          = errors.New("client is closed")
+    ErrRejected        = errors.New("gateway rejected the client's credentials")
+    ErrServerSignature = errors.New("key exchange signature did not verify")
+)
+
+// TokenSource supplies access tokens and when they expire. It is called for every connection
+// that cannot resume and again before the current token expires
+type TokenSource interface {
+    Token(ctx context.Context) (token string, expiresAt time.Time, err error)
+}
+
+// TokenFunc adapts a function to TokenSource
+type TokenFunc func(ctx context.Context) (string, time.Time, error)
+
+func (f TokenFunc) Token(ctx context.Context) (string, time.Time, error) { return f(ctx) }
+
+// Client watches one room
+type Client struct {
+    url    string
+    room   string
+    tokens TokenSource
+    dialer *websocket.Dialer
+    types  []watch.EventType
+
+    serverAlgorithm string
+    serverKey       []byte
+
+    minBackoff    time.Duration
+    maxBackoff    time.Duration
+    refreshBefore time.Duration
+    clock         clock.Clock
+    onHand        func(protocol.Hand)
+    onGrant       func(protocol.Grant)
+    onExpiring    func(protocol.Expiring)
+
+    events    chan *watch.RoomEvent
+    closed    chan struct{}
+    closeOnce sync.Once
+
+    mu     sync.Mutex
+    conn   *websocket.Conn
+    ticket ticket
+}
+
+// ticket is what a client keeps between connections to resume
+type ticket struct {
+    value     string
+    secret    []byte
+    expiresAt time.Time
+}
+
+// New watches room through the gateway at gatewayURL, such as wss://gateway.example.com
+func New(gatewayURL, room string, tokens TokenSource) *Client {
+    return &Client{
+        url:           strings.TrimSuffix(gatewayURL, "/"),
+        room:          room,
+        tokens:        tokens,
+        dialer:        websocket.NewDialer(),
+        minBackoff:    DefaultMinBackoff,
+        maxBackoff:    DefaultMaxBackoff,
+        refreshBefore: DefaultRefreshBefore,
+        clock:         clock.System,
+        events:        make(chan *watch.RoomEvent, 64),
+        closed:        make(chan struct{}),
+    }
+}
+
+// SetDialer replaces the WebSocket dialer, for example to use CBOR frames or a TLS config
+func (c *Client) SetDialer(d *websocket.Dialer) *Client {
+    c.dialer = d
+    return c
+}
+
+// SetTypes limits the events delivered to these types
+func (c *Client) SetTypes(types ...watch.EventType) *Client {
+    c.types = types
+    return c
+}
+
+// SetServerKey pins the gateway's signing key. Every key exchange must then carry a valid
+// signature from it, and a server that skips the exchange is refused
+func (c *Client) SetServerKey(algorithm string, publicKey []byte) *Client {
+    c.serverAlgorithm, c.serverKey = algorithm, publicKey
+    return c
+}
+
+// SetBackoff bounds the randomized exponential wait between reconnects
+func (c *Client) SetBackoff(min, max time.Duration) *Client {
+    c.minBackoff, c.maxBackoff = min, max
+    return c
+}
+
+// SetRefreshBefore is how long before a token expires the client fetches the next one
+func (c *Client) SetRefreshBefore(d time.Duration) *Client {
+    c.refreshBefore = d
+    return c
+}
+
+// SetHandHandler is called as the hand the participant raised is approved or denied
+func (c *Client) SetHandHandler(f func(protocol.Hand)) *Client {
+    c.onHand = f
+    return c
+}
+
+// SetGrantHandler is called with every token the gateway pushes, such as once a host let
+// the participant publish. It arrives before the hand it was approved for; the token source
+// should hand it out from then on
+func (c *Client) SetGrantHandler(f func(protocol.Grant)) *Client {
+    c.onGrant = f
+    return c
+}
+
+// SetExpiryHandler is called when the participant's grant runs out mid-session, with what
+// the gateway does about it, and again when their media connection ends
+func (c *Client) SetExpiryHandler(f func(protocol.Expiring)) *Client {
+    c.onExpiring = f
+    return c
+}
+
+// SetClock sets the time source for token and ticket expiry
+func (c *Client) SetClock(cl clock.Clock) *Client {
+    c.clock = cl
+    return c
+}
+
+// Events delivers room events in order. It is closed when Run returns
+func (c *Client) Events() <-chan *watch.RoomEvent { return c.events }
+
+// Run keeps the session open until ctx ends or Close is called. It returns the reason it
+// stopped: ctx's error, ErrClosed, or ErrRejected when the gateway refuses the token source's
+// credentials, since retrying those would not help
+func (c *Client) Run(ctx context.Context) error {
+    defer close(c.events)
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    go func() {
+        select {
+        case <-c.closed:
+            cancel()
+        case <-ctx.Done():
+        }
+    }()
+
+    attempt := 0
+    for {
+        connected, err := c.session(ctx)
+        if connected {
+            attempt = 0
+        }
+        select {
+        case <-c.closed:
+            return ErrClosed
+        default:
+        }
+        if ctx.Err() != nil {
+            return ctx.Err()
+        }
+        var herr *websocket.HandshakeError
+        if errors.As(err, &herr) && (herr.StatusCode == http.StatusUnauthorized || herr.StatusCode == http.StatusForbidden) {
+            return ErrRejected
+        }
+        if errors.Is(err, ErrServerSignature) {
+            return err
+        }
+
+        wait := c.backoff(attempt)
+        if herr != nil && herr.RetryAfter > wait {
+            wait = herr.RetryAfter
+        }
+        attempt++
+        t := time.NewTimer(wait)
+        select {
+        case <-ctx.Done():
+            t.Stop()
+        case <-t.C:
+        }
+    }
+}
+
+// Close ends Run and the current connection
+func (c *Client) Close() error {
+    c.closeOnce.Do(func() { close(c.closed) })
+    c.mu.Lock()
+    conn := c.conn
+    c.mu.Unlock()
+    if conn != nil {
+        return conn.Close()
+    }
+    return nil
+}
+
+// backoff is an exponential wait for the attempt'th retry in a row, jittered over its top half
+func (c *Client) backoff(attempt int) time.Duration {
+    max := c.minBackoff
+    for i := 0; i < attempt && max < c.maxBackoff; i++ {
+        max *= 2
+    }
+    if max > c.maxBackoff {
+        max = c.maxBackoff
+    }
+    if max <= 0 {
+        return 0
+    }
+    return max/2 + time.Duration(rand.Int63n(int64(max/2)+1))
+}
+
+// session runs one connection until it fails; connected reports whether it got past the
+// handshake, which resets the backoff
+func (c *Client) session(ctx context.Context) (connected bool, err error) {
+    c.mu.Lock()
+    saved := c.ticket
+    c.mu.Unlock()
+    resuming := saved.value != "" && c.clock.Now().Before(saved.expiresAt)
+
+    s := &session{client: c, ctx: ctx}
+    header := make(http.Header)
+    if !resuming {
+        token, expiresAt, err := c.tokens.Token(ctx)
+        if err != nil {
+            return false, err
+        }
+        header.Set("Authorization", "Bearer "+token)
+        s.tokenExpiresAt = expiresAt
+    }
+    conn, err := c.dialer.Dial(ctx, c.eventsURL(), header)
+    if err != nil {
+        return false, err
+    }
+    s.conn = conn
+    c.mu.Lock()
+    c.conn = conn
+    c.mu.Unlock()
+    stop := context.AfterFunc(ctx, func() { conn.Close() })
+    defer func() {
+        stop()
+        conn.Close()
+        c.mu.Lock()
+        c.conn = nil
+        c.mu.Unlock()
+    }()
+
+    if err := s.hello(); err != nil {
+        return false, err
+    }
+    if resuming {
+        if err := s.resume(saved); err != nil {
+            // The ticket is spent or refused either way; the next attempt uses a token
+            c.forgetTicket()
+            return false, err
+        }
+    } else if err := s.keyExchange(); err != nil {
+        return false, err
+    }
+    return true, s.run()
+}
+
+func (c *Client) eventsURL() string {
+    u := c.url + "/v1/rooms/" + url.PathEscape(c.room) + "/events"
+    if len(c.types) > 0 {
+        types := make([]string, len(c.types))
+        for i, t := range c.types {
+            types[i] = string(t)
+        }
+        u += "?types=" + url.QueryEscape(strings.Join(types, ","))
+    }
+    return u
+}
+
+func (c *Client) forgetTicket() {
+    c.mu.Lock()
+    c.ticket = ticket{}
+    c.mu.Unlock()
+}
+
+// deliver hands an event to Events, waiting for the reader
+func (c *Client) deliver(ctx context.Context, event *watch.RoomEvent) error {
+    select {
+    case c.events <- event:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// sessionFeatures are asked for in every hello
+var sessionFeatures = []string{
+    protocol.FeaturePing,
+    protocol.FeatureEventFilter,
+    protocol.FeatureKeyExchange,
+    protocol.FeatureResumption,
+    protocol.FeatureTokenRefresh,
+    protocol.FeatureGrantPush,
+    protocol.FeatureExpiryNotice,
+}
+
+// session is the state of one connection
+type session struct {
+    client   *Client
+    ctx      context.Context
+    conn     *websocket.Conn
+    version  int
+    features map[string]bool
+    nonce    []byte
+
+    // keyPair is the client's half of a key exchange awaiting its reply
+    keyPair        *crypto.KeyPair
+    secret         []byte
+    tokenExpiresAt time.Time
+    // offset is how far the server's clock runs ahead of ours, from its welcome; expiries
+    // are the server's times
+    offset time.Duration
+
+    refreshMu sync.Mutex
+    refresh   *time.Timer
+    stopped   bool
+}
+
+func (s *session) hello() error {
+    err := s.conn.Send(protocol.Hello{
+        Type:     protocol.TypeHello,
+        Versions: []int{protocol.Version2, protocol.Version1},
+        Features: sessionFeatures,
+        Client:   Name,
+        Time:     s.client.clock.Now().UnixMilli(),
+    })
+    if err != nil {
+        return err
+    }
+    msg, err := s.read()
+    if err != nil {
+        return err
+    }
+    var welcome protocol.Welcome
+    if err := s.expect(msg, protocol.TypeWelcome, &welcome); err != nil {
+        return err
+    }
+    s.version = welcome.Version
+    if welcome.Time != 0 {
+        s.offset = time.UnixMilli(welcome.Time).Sub(s.client.clock.Now())
+    }
+    s.features = make(map[string]bool)
+    for _, f := range welcome.Features {
+        s.features[f] = true
+    }
+    if welcome.Nonce != "" {
+        if s.nonce, err = base64.StdEncoding.DecodeString(welcome.Nonce); err != nil {
+            return websocket.ErrProtocol
+        }
+    }
+    if s.client.serverKey != nil && !s.features[protocol.FeatureKeyExchange] {
+        return ErrServerSignature
+    }
+    return nil
+}
+
+// resume presents a saved ticket; the server answers before sending any event
+func (s *session) resume(saved ticket) error {
+    if !s.features[protocol.FeatureResumption] {
+        return protocol.ErrUnauthorized
+    }
+    err := s.conn.Send(protocol.Resume{
+        Type:   protocol.TypeResume,
+        Ticket: saved.value,
+        Proof:  base64.StdEncoding.EncodeToString(protocol.ResumeProof(saved.secret, s.nonce)),
+    })
+    if err != nil {
+        return err
+    }
+    msg, err := s.read()
+    if err != nil {
+        return err
+    }
+    var resumed protocol.Resumed
+    if err := s.expect(msg, protocol.TypeResumed, &resumed); err != nil {
+        return err
+    }
+    s.secret = protocol.NextSecret(saved.secret, s.nonce)
+    s.tokenExpiresAt = resumed.ExpiresAt
+    return nil
+}
+
+// keyExchange starts the exchange; the reply is handled by run, since events may come first
+func (s *session) keyExchange() error {
+    if !s.features[protocol.FeatureKeyExchange] {
+        return nil
+    }
+    kp, err := crypto.GenerateMLKEM768KeyPair()
+    if err != nil {
+        return err
+    }
+    s.keyPair = kp
+    return s.conn.Send(protocol.KeyExchange{
+        Type:      protocol.TypeKeyExchange,
+        Algorithm: crypto.AlgorithmMLKEM768,
+        PublicKey: base64.StdEncoding.EncodeToString(kp.PublicKey),
+    })
+}
+
+// run reads until the connection fails
+func (s *session) run() error {
+    defer s.stopRefresh()
+    s.scheduleRefresh()
+    for {
+        msg, err := s.read()
+        if err != nil {
+            return err
+        }
+        if err := s.handle(msg); err != nil {
+            return err
+        }
+    }
+}
+
+func (s *session) handle(msg map[string]interface{}) error {
+    switch msg["type"] {
+    case protocol.TypeKeyExchange:
+        var reply protocol.KeyExchangeReply
+        if err := convert(msg, &reply); err != nil {
+            return err
+        }
+        return s.finishKeyExchange(reply)
+    case protocol.TypeTicket:
+        var t protocol.TicketMessage
+        if err := convert(msg, &t); err != nil {
+            return err
+        }
+        if s.secret == nil {
+            return websocket.ErrProtocol
+        }
+        s.client.mu.Lock()
+        s.client.ticket = ticket{value: t.Ticket, secret: s.secret, expiresAt: t.ExpiresAt}
+        s.client.mu.Unlock()
+    case protocol.TypeRefreshed:
+        var r protocol.Refreshed
+        if err := convert(msg, &r); err != nil {
+            return err
+        }
+        s.refreshMu.Lock()
+        s.tokenExpiresAt = r.ExpiresAt
+        s.refreshMu.Unlock()
+        s.scheduleRefresh()
+    case protocol.TypeError:
+        var e protocol.ErrorMessage
+        if err := convert(msg, &e); err != nil {
+            return err
+        }
+        perr := &protocol.Error{Code: e.Code, Type: e.MessageType, Field: e.Field, Message: e.Message}
+        // An expired or refused session is closed by the server; reconnect with a fresh token
+        if errors.Is(perr, protocol.ErrUnauthorized) {
+            s.client.forgetTicket()
+            return perr
+        }
+    case protocol.TypeHand:
+        var h protocol.Hand
+        if err := convert(msg, &h); err != nil {
+            return err
+        }
+        if s.client.onHand != nil {
+            s.client.onHand(h)
+        }
+    case protocol.TypeGrant:
+        var g protocol.Grant
+        if err := convert(msg, &g); err != nil {
+            return err
+        }
+        if s.client.onGrant != nil {
+            s.client.onGrant(g)
+        }
+    case protocol.TypeExpiring:
+        var e protocol.Expiring
+        if err := convert(msg, &e); err != nil {
+            return err
+        }
+        if s.client.onExpiring != nil {
+            s.client.onExpiring(e)
+        }
+    case protocol.TypePong, protocol.TypeWelcome, protocol.TypeResumed:
+    default:
+        var event watch.RoomEvent
+        if err := convert(msg, &event); err != nil {
+            return err
+        }
+        return s.client.deliver(s.ctx, &event)
+    }
+    return nil
+}
+
+func (s *session) finishKeyExchange(reply protocol.KeyExchangeReply) error {
+    if s.keyPair == nil {
+        return websocket.ErrProtocol
+    }
+    ciphertext, err := base64.StdEncoding.DecodeString(reply.Ciphertext)
+    if err != nil {
+        return websocket.ErrProtocol
+    }
+    transcript := protocol.KeyExchangeTranscript(s.nonce, s.keyPair.PublicKey, ciphertext)
+    if c := s.client; c.serverKey != nil {
+        signature, err := base64.StdEncoding.DecodeString(reply.Signature)
+        if err != nil || reply.SignatureAlgorithm != c.serverAlgorithm {
+            return ErrServerSignature
+        }
+        if err := crypto.VerifySignature(c.serverAlgorithm, c.serverKey, transcript, signature); err != nil {
+            return ErrServerSignature
+        }
+    }
+    shared, err := crypto.Decapsulate(s.keyPair.PrivateKey, ciphertext)
+    if err != nil {
+        return err
+    }
+    s.secret = protocol.SessionSecret(shared, transcript)
+    s.keyPair = nil
+    return nil
+}
+
+// scheduleRefresh arms a refresh ahead of the token's expiry, when the server takes refreshes
+func (s *session) scheduleRefresh() {
+    s.refreshMu.Lock()
+    defer s.refreshMu.Unlock()
+    if !s.features[protocol.FeatureTokenRefresh] || s.tokenExpiresAt.IsZero() {
+        return
+    }
+    s.armRefresh(s.tokenExpiresAt.Sub(s.serverNow()) - s.client.refreshBefore)
+}
+
+// serverNow is the time on the server's clock
+func (s *session) serverNow() time.Time {
+    return s.client.clock.Now().Add(s.offset)
+}
+
+// armRefresh fetches a token after wait and sends it unless it is no newer than the current
+// one, in which case it asks again after RefreshRetry; the caller holds refreshMu
+func (s *session) armRefresh(wait time.Duration) {
+    if s.stopped {
+        return
+    }
+    if s.refresh != nil {
+        s.refresh.Stop()
+    }
+    s.refresh = time.AfterFunc(wait, func() {
+        token, expiresAt, err := s.client.tokens.Token(s.ctx)
+        s.refreshMu.Lock()
+        defer s.refreshMu.Unlock()
+        if s.stopped {
+            return
+        }
+        if err != nil || !expiresAt.After(s.tokenExpiresAt) {
+            // Without a new token the server ends the session at expiry and run reconnects
+            if s.serverNow().Add(RefreshRetry).Before(s.tokenExpiresAt) {
+                s.armRefresh(RefreshRetry)
+            }
+            return
+        }
+        s.conn.Send(protocol.Refresh{Type: protocol.TypeRefresh, Token: token})
+    })
+}
+
+func (s *session) stopRefresh() {
+    s.refreshMu.Lock()
+    defer s.refreshMu.Unlock()
+    s.stopped = true
+    if s.refresh != nil {
+        s.refresh.Stop()
+    }
+}
+
+// read returns the next message as an object, from JSON text or CBOR binary frames
+func (s *session) read() (map[string]interface{}, error) {
+    typ, data, err := s.conn.ReadMessage()
+    if err != nil {
+        return nil, err
+    }
+    var v interface{}
+    if typ == websocket.BinaryMessage {
+        v, err = websocket.UnmarshalCBOR(data)
+    } else {
+        err = json.Unmarshal(data, &v)
+    }
+    msg, ok := v.(map[string]interface{})
+    if err != nil || !ok {
+        return nil, websocket.ErrProtocol
+    }
+    return msg, nil
+}
+
+// expect decodes msg as typ, turning a server error message into *protocol.Error
+func (s *session) expect(msg map[string]interface{}, typ string, v interface{}) error {
+    if msg["type"] == protocol.TypeError {
+        var e protocol.ErrorMessage
+        if err := convert(msg, &e); err != nil {
+            return err
+        }
+        return &protocol.Error{Code: e.Code, Type: e.MessageType, Field: e.Field, Message: e.Message}
+    }
+    if msg["type"] != typ {
+        return websocket.ErrProtocol
+    }
+    return convert(msg, v)
+}
+
+// convert fills a typed message from a decoded object through its json tags
+func convert(msg map[string]interface{}, v interface{}) error {
+    data, err := json.Marshal(msg)
+    if err != nil {
+        return err
+    }
+    if err := json.Unmarshal(data, v); err != nil {
+        return websocket.ErrProtocol
+    }
+    return nil
+}
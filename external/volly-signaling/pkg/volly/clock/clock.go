@@ -0,0 +1,78 @@
+package clock
+
+import (
+    "sync"
+    "time"
+)
+
+// Clock is the time source for expiry computations
+type Clock interface {
+    Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// System is the wall clock; it is the default everywhere a Clock is accepted
+var System Clock = systemClock{}
+
+// Manual is a clock that only moves when told to, for deterministic tests and replays
+type Manual struct {
+    mu  sync.Mutex
+    now time.Time
+}
+
+// NewManual creates a manual clock reading now
+func NewManual(now time.Time) *Manual {
+    return &Manual{now: now}
+}
+
+// Now returns the current manual time
+func (m *Manual) Now() time.Time {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return m.now
+}
+
+// Set moves the clock to now
+func (m *Manual) Set(now time.Time) {
+    m.mu.Lock()
+    m.now = now
+    m.mu.Unlock()
+}
+
+// Advance moves the clock forward by d
+func (m *Manual) Advance(d time.Duration) {
+    m.mu.Lock()
+    m.now = m.now.Add(d)
+    m.mu.Unlock()
+}
+
+// Offset corrects a base clock by a measured skew, e.g. the offset reported by
+// an NTP query, which can be updated while the clock is in use
+type Offset struct {
+    base Clock
+
+    mu     sync.RWMutex
+    offset time.Duration
+}
+
+// NewOffset creates a clock reading base plus offset
+func NewOffset(base Clock, offset time.Duration) *Offset {
+    return &Offset{base: base, offset: offset}
+}
+
+// Now returns the corrected time
+func (o *Offset) Now() time.Time {
+    o.mu.RLock()
+    defer o.mu.RUnlock()
+    return o.base.Now().Add(o.offset)
+}
+
+// SetOffset replaces the skew correction
+func (o *Offset) SetOffset(offset time.Duration) {
+    o.mu.Lock()
+    o.offset = offset
+    o.mu.Unlock()
+}
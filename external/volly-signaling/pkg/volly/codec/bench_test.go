@@ -0,0 +1,116 @@
+package codec
+
+import (
+    "crypto/rand"
+    "encoding/base64"
+    "encoding/json"
+    "testing"
+)
+
+// Benchmarks for the token decoding path. Each runs encoding/base64 and encoding/json
+// directly, as "encoding", beside this package's decoders, named by Implementation(); compare
+// the two tag settings with
+//
+//    go test -run '^$' -bench . ./pkg/volly/codec
+//    go test -run '^$' -bench . -tags volly_simd ./pkg/volly/codec
+
+// benchKeys are the PQ public key sizes tokens carry
+var benchKeys = []struct {
+    name string
+    size int
+}{
+    {"ML-KEM-768", 1184},
+    {"ML-KEM-1024", 1568},
+}
+
+type decoders struct {
+    name      string
+    std       func(string) ([]byte, error)
+    rawURL    func(string) ([]byte, error)
+    unmarshal func([]byte) (map[string]interface{}, error)
+}
+
+// benchDecoders is built when a benchmark runs: Implementation is only settled once the
+// package's init has looked at the CPU
+func benchDecoders() []decoders {
+    return []decoders{
+        {"encoding", base64.StdEncoding.DecodeString, base64.RawURLEncoding.DecodeString, func(data []byte) (map[string]interface{}, error) {
+            var m map[string]interface{}
+            err := json.Unmarshal(data, &m)
+            return m, err
+        }},
+        {Implementation(), DecodeStd, DecodeRawURL, UnmarshalObject},
+    }
+}
+
+// benchKey is a base64 public key of size bytes, as a grant's pqPublicKey holds it
+func benchKey(b *testing.B, size int) string {
+    key := make([]byte, size)
+    if _, err := rand.Read(key); err != nil {
+        b.Fatal(err)
+    }
+    return base64.StdEncoding.EncodeToString(key)
+}
+
+// benchPayload is the payload segment of a token carrying a public key of size bytes
+func benchPayload(b *testing.B, size int) string {
+    claims, err := json.Marshal(map[string]interface{}{
+        "iss": "vollybench",
+        "sub": "bench-user",
+        "nbf": 1760486400,
+        "exp": 1760508000,
+        "jti": "6f1c2a9e-4d7b-4f0a-9c3e-2b8d5e7a1f40",
+        "video": map[string]interface{}{
+            "room":        "bench",
+            "roomJoin":    true,
+            "pqPublicKey": benchKey(b, size),
+            "pqAlgorithm": "ML-KEM",
+        },
+    })
+    if err != nil {
+        b.Fatal(err)
+    }
+    return base64.RawURLEncoding.EncodeToString(claims)
+}
+
+// BenchmarkDecodePQKey decodes the base64 PQ public key carried in a token's claims
+func BenchmarkDecodePQKey(b *testing.B) {
+    for _, d := range benchDecoders() {
+        for _, k := range benchKeys {
+            b.Run(d.name+"/"+k.name, func(b *testing.B) {
+                encoded := benchKey(b, k.size)
+                b.SetBytes(int64(len(encoded)))
+                b.ReportAllocs()
+                b.ResetTimer()
+                for i := 0; i < b.N; i++ {
+                    if _, err := d.std(encoded); err != nil {
+                        b.Fatal(err)
+                    }
+                }
+            })
+        }
+    }
+}
+
+// BenchmarkDecodeClaims decodes a token's payload segment into its claims map
+func BenchmarkDecodeClaims(b *testing.B) {
+    for _, d := range benchDecoders() {
+        for _, k := range benchKeys {
+            b.Run(d.name+"/"+k.name, func(b *testing.B) {
+                payload := benchPayload(b, k.size)
+                b.SetBytes(int64(len(payload)))
+                b.ReportAllocs()
+                b.ResetTimer()
+                for i := 0; i < b.N; i++ {
+                    data, err := d.rawURL(payload)
+                    if err != nil {
+                        b.Fatal(err)
+                    }
+                    if _, err := d.unmarshal(data); err != nil {
+                        b.Fatal(err)
+                    }
+                }
+            })
+        }
+    }
+}
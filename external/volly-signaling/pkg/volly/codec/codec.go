@@ -0,0 +1,27 @@
+// Package codec decodes the base64 and JSON found on the token verification path. The default
+// build uses encoding/base64 and encoding/json. Building with -tags volly_simd on amd64 or
+// arm64 selects an AVX2 base64 decoder where the CPU has one, a table-driven scalar decoder
+// elsewhere, and a JSON object parser that skips reflection. Both builds accept and reject
+// exactly the same inputs and return the same errors, so callers need not know which is in use
+package codec
+
+// DecodeRawURL decodes unpadded URL-safe base64, as used for JWT segments
+func DecodeRawURL(s string) ([]byte, error) {
+    return decodeRawURL(s)
+}
+
+// DecodeStd decodes padded standard base64, as used for PQ public keys in claims
+func DecodeStd(s string) ([]byte, error) {
+    return decodeStd(s)
+}
+
+// UnmarshalObject decodes JSON into a generic map the way json.Unmarshal does, including
+// returning a nil map for null
+func UnmarshalObject(data []byte) (map[string]interface{}, error) {
+    return unmarshalObject(data)
+}
+
+// Implementation names the decoders selected at build time
+func Implementation() string {
+    return implementation
+}
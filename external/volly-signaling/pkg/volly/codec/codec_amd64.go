@@ -0,0 +1,89 @@
+//go:build volly_simd
+
+package codec
+
+import "golang.org/x/sys/cpu"
+
+// avx2Tables is the layout decodeAVX2 loads, each row repeated for both 128-bit lanes. A
+// character is valid when lo[low nibble] and hi[high nibble] share no bits, and its value is
+// the character plus roll[high nibble], plus fix when it equals special
+type avx2Tables struct {
+    lo, hi, roll [32]byte
+    special, fix [32]byte
+    nibbles      [32]byte
+    mergeBytes   [32]byte
+    mergeWords   [32]byte
+    shuffle      [32]byte
+    permute      [32]byte
+}
+
+var implementation = "swar"
+
+var (
+    hasAVX2 = cpu.X86.HasAVX2
+
+    stdAVX2 = newAVX2Tables(
+        [16]byte{0x15, 0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x13, 0x1a, 0x1b, 0x1b, 0x1b, 0x1a},
+        [16]byte{0x10, 0x10, 0x01, 0x02, 0x04, 0x08, 0x04, 0x08, 0x10, 0x10, 0x10, 0x10, 0x10, 0x10, 0x10, 0x10},
+        [16]int8{0, 0, 19, 4, -65, -65, -71, -71},
+        '/', -3,
+    )
+    urlAVX2 = newAVX2Tables(
+        [16]byte{0x15, 0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x13, 0x3b, 0x3b, 0x3a, 0x3b, 0x33},
+        [16]byte{0x10, 0x10, 0x01, 0x02, 0x04, 0x08, 0x04, 0x20, 0x10, 0x10, 0x10, 0x10, 0x10, 0x10, 0x10, 0x10},
+        [16]int8{0, 0, 17, 4, -65, -65, -71, -71},
+        '_', 33,
+    )
+)
+
+func init() {
+    if hasAVX2 {
+        implementation = "avx2"
+    }
+}
+
+func newAVX2Tables(lo, hi [16]byte, roll [16]int8, special byte, fix int8) *avx2Tables {
+    t := new(avx2Tables)
+    for i := 0; i < 32; i++ {
+        t.lo[i] = lo[i%16]
+        t.hi[i] = hi[i%16]
+        t.roll[i] = byte(roll[i%16])
+        t.special[i] = special
+        t.fix[i] = byte(fix)
+        t.nibbles[i] = 0x0f
+    }
+    // Pairs of characters become 12 bit words, pairs of words 24 bit dwords, and the three
+    // bytes of each dword are gathered big-endian into the low 24 bytes
+    shuffle := [16]byte{2, 1, 0, 6, 5, 4, 10, 9, 8, 14, 13, 12, 0x80, 0x80, 0x80, 0x80}
+    permute := [8]byte{0, 1, 2, 4, 5, 6, 0, 0}
+    for i := 0; i < 32; i += 4 {
+        t.mergeBytes[i], t.mergeBytes[i+1], t.mergeBytes[i+2], t.mergeBytes[i+3] = 0x40, 0x01, 0x40, 0x01
+        t.mergeWords[i], t.mergeWords[i+1], t.mergeWords[i+2], t.mergeWords[i+3] = 0x00, 0x10, 0x01, 0x00
+        t.permute[i] = permute[i/4]
+    }
+    for i := 0; i < 32; i++ {
+        t.shuffle[i] = shuffle[i%16]
+    }
+    return t
+}
+
+// decodeAVX2 decodes 32 characters into 24 bytes per step until fewer than 32 remain or a
+// block holds a character outside the alphabet, and returns the characters consumed. Each
+// step stores 32 bytes, so dst needs eight bytes beyond the decoded length
+//
+//go:noescape
+func decodeAVX2(dst []byte, src string, t *avx2Tables) int
+
+func stdBlocks(dst []byte, src string) int {
+    if !hasAVX2 || len(src) < 32 {
+        return 0
+    }
+    return decodeAVX2(dst, src, stdAVX2)
+}
+
+func urlBlocks(dst []byte, src string) int {
+    if !hasAVX2 || len(src) < 32 {
+        return 0
+    }
+    return decodeAVX2(dst, src, urlAVX2)
+}
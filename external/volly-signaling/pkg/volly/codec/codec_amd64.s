@@ -0,0 +1,60 @@
+//go:build volly_simd
+
+#include "textflag.h"
+
+// func decodeAVX2(dst []byte, src string, t *avx2Tables) int
+TEXT ·decodeAVX2(SB), NOSPLIT, $0-56
+    MOVQ dst_base+0(FP), DI
+    MOVQ src_base+24(FP), SI
+    MOVQ src_len+32(FP), CX
+    MOVQ t+40(FP), AX
+    XORQ BX, BX
+
+    VMOVDQU 0(AX), Y8
+    VMOVDQU 32(AX), Y9
+    VMOVDQU 64(AX), Y10
+    VMOVDQU 96(AX), Y11
+    VMOVDQU 128(AX), Y12
+    VMOVDQU 160(AX), Y13
+    VMOVDQU 192(AX), Y14
+    VMOVDQU 224(AX), Y15
+    VMOVDQU 288(AX), Y7
+
+loop:
+    CMPQ CX, $32
+    JB   done
+    VMOVDQU (SI), Y0
+
+    // Classify by nibble; any shared bit marks a character outside the alphabet
+    VPSRLD   $4, Y0, Y1
+    VPAND    Y13, Y1, Y1
+    VPAND    Y13, Y0, Y2
+    VPSHUFB  Y2, Y8, Y3
+    VPSHUFB  Y1, Y9, Y4
+    VPTEST   Y3, Y4
+    JNZ      done
+
+    // Map characters to their six bit values
+    VPSHUFB  Y1, Y10, Y5
+    VPCMPEQB Y11, Y0, Y6
+    VPAND    Y12, Y6, Y6
+    VPADDB   Y6, Y5, Y5
+    VPADDB   Y5, Y0, Y0
+
+    // Pack four six bit values into three bytes
+    VPMADDUBSW Y14, Y0, Y0
+    VPMADDWD   Y15, Y0, Y0
+    VPSHUFB    256(AX), Y0, Y0
+    VPERMD     Y0, Y7, Y0
+    VMOVDQU    Y0, (DI)
+
+    ADDQ $32, SI
+    ADDQ $24, DI
+    ADDQ $32, BX
+    SUBQ $32, CX
+    JMP  loop
+
+done:
+    VZEROUPPER
+    MOVQ BX, ret+48(FP)
+    RET
@@ -0,0 +1,10 @@
+//go:build volly_simd
+
+package codec
+
+// arm64 has no vector decoder yet and uses the scalar quantum path
+var implementation = "swar"
+
+func stdBlocks(dst []byte, src string) int { return 0 }
+
+func urlBlocks(dst []byte, src string) int { return 0 }
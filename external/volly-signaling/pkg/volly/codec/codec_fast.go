@@ -0,0 +1,440 @@
+//go:build volly_simd && (amd64 || arm64)
+
+package codec
+
+import (
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/json"
+    "strconv"
+    "unicode/utf16"
+    "unicode/utf8"
+)
+
+// maxFastDepth bounds nesting the fast JSON path handles; deeper documents go to encoding/json
+const maxFastDepth = 64
+
+// blockDecoder decodes as many whole 32 character blocks of src into dst as it can and
+// returns the number of characters consumed
+type blockDecoder func(dst []byte, src string) int
+
+// quantumTables holds each character's six bits pre-shifted to its place in a four character
+// quantum, so a quantum is four lookups and an OR. Characters outside the alphabet set high
+// bits that survive the OR
+type quantumTables [4][256]uint32
+
+const invalidQuantum = 0xff000000
+
+var (
+    stdQuantum = newQuantumTables(stdAlphabet)
+    urlQuantum = newQuantumTables(urlAlphabet)
+)
+
+const (
+    stdAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+    urlAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+)
+
+func newQuantumTables(alphabet string) *quantumTables {
+    t := new(quantumTables)
+    for p := range t {
+        for i := range t[p] {
+            t[p][i] = invalidQuantum
+        }
+    }
+    for i := 0; i < len(alphabet); i++ {
+        for p := range t {
+            t[p][alphabet[i]] = uint32(i) << (18 - 6*p)
+        }
+    }
+    return t
+}
+
+func decodeRawURL(s string) ([]byte, error) {
+    return decodeBase64(s, urlQuantum, urlBlocks, base64.RawURLEncoding)
+}
+
+func decodeStd(s string) ([]byte, error) {
+    return decodeBase64(s, stdQuantum, stdBlocks, base64.StdEncoding)
+}
+
+// decodeBase64 hands whole 32 character blocks to the vector decoder where there is one, then
+// decodes eight characters into six bytes per step, and leaves the final block, which may
+// carry padding or a partial quantum, to enc. Anything unusual, such as a character outside
+// the alphabet or the line breaks enc tolerates, is handed to enc whole so errors and
+// leniency match it exactly
+func decodeBase64(s string, t *quantumTables, blocks blockDecoder, enc *base64.Encoding) ([]byte, error) {
+    n := len(s) &^ 7
+    if n == len(s) && n > 0 {
+        n -= 8
+    }
+    // Vector steps store 32 bytes for 24 and scalar steps 8 for 6
+    dst := make([]byte, enc.DecodedLen(len(s))+8)
+    i := blocks(dst, s[:n])
+    j := i / 4 * 3
+    for ; i < n; i += 8 {
+        c := s[i : i+8]
+        x := t[0][c[0]] | t[1][c[1]] | t[2][c[2]] | t[3][c[3]]
+        y := t[0][c[4]] | t[1][c[5]] | t[2][c[6]] | t[3][c[7]]
+        if (x|y)&invalidQuantum != 0 {
+            return enc.DecodeString(s)
+        }
+        binary.BigEndian.PutUint64(dst[j:j+8], uint64(x)<<40|uint64(y)<<16)
+        j += 6
+    }
+
+    var tail [8]byte
+    m, err := enc.Decode(dst[j:], tail[:copy(tail[:], s[n:])])
+    if err != nil {
+        return enc.DecodeString(s)
+    }
+    return dst[: j+m : j+m], nil
+}
+
+// unmarshalObject parses the common case of a well-formed object without reflection. Input
+// the parser is unsure of is decoded again by encoding/json, which then decides the result
+func unmarshalObject(data []byte) (map[string]interface{}, error) {
+    p := parser{data: data}
+    p.skipSpace()
+    if p.peek() == '{' {
+        if m, ok := p.object(); ok {
+            if p.skipSpace(); p.pos == len(data) {
+                return m, nil
+            }
+        }
+    }
+
+    var m map[string]interface{}
+    if err := json.Unmarshal(data, &m); err != nil {
+        return nil, err
+    }
+    return m, nil
+}
+
+type parser struct {
+    data  []byte
+    pos   int
+    depth int
+}
+
+func (p *parser) peek() byte {
+    if p.pos < len(p.data) {
+        return p.data[p.pos]
+    }
+    return 0
+}
+
+func (p *parser) skipSpace() {
+    for p.pos < len(p.data) {
+        switch p.data[p.pos] {
+        case ' ', '\t', '\n', '\r':
+            p.pos++
+        default:
+            return
+        }
+    }
+}
+
+func (p *parser) value() (interface{}, bool) {
+    switch c := p.peek(); {
+    case c == '{':
+        m, ok := p.object()
+        return m, ok
+    case c == '[':
+        return p.array()
+    case c == '"':
+        return p.str()
+    case c == 't':
+        return true, p.literal("true")
+    case c == 'f':
+        return false, p.literal("false")
+    case c == 'n':
+        return nil, p.literal("null")
+    case c == '-' || c >= '0' && c <= '9':
+        return p.number()
+    }
+    return nil, false
+}
+
+func (p *parser) object() (map[string]interface{}, bool) {
+    if p.depth++; p.depth > maxFastDepth {
+        return nil, false
+    }
+    p.pos++
+    m := make(map[string]interface{})
+    if p.skipSpace(); p.peek() == '}' {
+        p.pos++
+        p.depth--
+        return m, true
+    }
+    for {
+        if p.peek() != '"' {
+            return nil, false
+        }
+        key, ok := p.str()
+        if !ok {
+            return nil, false
+        }
+        if p.skipSpace(); p.peek() != ':' {
+            return nil, false
+        }
+        p.pos++
+        p.skipSpace()
+        v, ok := p.value()
+        if !ok {
+            return nil, false
+        }
+        m[key] = v
+
+        p.skipSpace()
+        switch p.peek() {
+        case ',':
+            p.pos++
+            p.skipSpace()
+        case '}':
+            p.pos++
+            p.depth--
+            return m, true
+        default:
+            return nil, false
+        }
+    }
+}
+
+func (p *parser) array() (interface{}, bool) {
+    if p.depth++; p.depth > maxFastDepth {
+        return nil, false
+    }
+    p.pos++
+    items := make([]interface{}, 0)
+    if p.skipSpace(); p.peek() == ']' {
+        p.pos++
+        p.depth--
+        return items, true
+    }
+    for {
+        v, ok := p.value()
+        if !ok {
+            return nil, false
+        }
+        items = append(items, v)
+
+        p.skipSpace()
+        switch p.peek() {
+        case ',':
+            p.pos++
+            p.skipSpace()
+        case ']':
+            p.pos++
+            p.depth--
+            return items, true
+        default:
+            return nil, false
+        }
+    }
+}
+
+func (p *parser) literal(lit string) bool {
+    if len(p.data)-p.pos < len(lit) || string(p.data[p.pos:p.pos+len(lit)]) != lit {
+        return false
+    }
+    p.pos += len(lit)
+    return true
+}
+
+// str reads a string without copying it twice unless it contains escapes. Invalid UTF-8,
+// which encoding/json replaces rather than rejects, is left to it
+func (p *parser) str() (string, bool) {
+    p.pos++
+    start := p.pos
+    for p.pos < len(p.data) {
+        // Long values such as PQ keys are most of a payload, so skip plain bytes eight at a time
+        for p.pos+8 <= len(p.data) && !special(binary.LittleEndian.Uint64(p.data[p.pos:])) {
+            p.pos += 8
+        }
+        if p.pos == len(p.data) {
+            break
+        }
+        c := p.data[p.pos]
+        switch {
+        case c == '"':
+            s := string(p.data[start:p.pos])
+            p.pos++
+            return s, true
+        case c == '\\':
+            return p.escapedStr(start)
+        case c < 0x20:
+            return "", false
+        case c >= utf8.RuneSelf:
+            r, size := utf8.DecodeRune(p.data[p.pos:])
+            if r == utf8.RuneError && size == 1 {
+                return "", false
+            }
+            p.pos += size
+        default:
+            p.pos++
+        }
+    }
+    return "", false
+}
+
+// special reports whether any byte of w is a quote, a backslash, a control character or not ASCII
+func special(w uint64) bool {
+    const ones, highs = 0x0101010101010101, 0x8080808080808080
+    q, b := w^(ones*'"'), w^(ones*'\\')
+    return ((q-ones)&^q|(b-ones)&^b|(w-ones*0x20)&^w|w)&highs != 0
+}
+
+func (p *parser) escapedStr(start int) (string, bool) {
+    buf := make([]byte, 0, p.pos-start+16)
+    buf = append(buf, p.data[start:p.pos]...)
+    for p.pos < len(p.data) {
+        c := p.data[p.pos]
+        switch {
+        case c == '"':
+            p.pos++
+            return string(buf), true
+        case c == '\\':
+            if p.pos+1 >= len(p.data) {
+                return "", false
+            }
+            e := p.data[p.pos+1]
+            p.pos += 2
+            switch e {
+            case '"', '\\', '/':
+                buf = append(buf, e)
+            case 'b':
+                buf = append(buf, '\b')
+            case 'f':
+                buf = append(buf, '\f')
+            case 'n':
+                buf = append(buf, '\n')
+            case 'r':
+                buf = append(buf, '\r')
+            case 't':
+                buf = append(buf, '\t')
+            case 'u':
+                r, ok := p.hex4()
+                if !ok {
+                    return "", false
+                }
+                if utf16.IsSurrogate(r) {
+                    // Only well-formed pairs; encoding/json replaces lone halves
+                    if p.literal(`\u`) {
+                        if lo, ok := p.hex4(); ok {
+                            r = utf16.DecodeRune(r, lo)
+                        }
+                    }
+                    if r == utf8.RuneError {
+                        return "", false
+                    }
+                }
+                buf = utf8.AppendRune(buf, r)
+            default:
+                return "", false
+            }
+        case c < 0x20:
+            return "", false
+        case c >= utf8.RuneSelf:
+            r, size := utf8.DecodeRune(p.data[p.pos:])
+            if r == utf8.RuneError && size == 1 {
+                return "", false
+            }
+            buf = append(buf, p.data[p.pos:p.pos+size]...)
+            p.pos += size
+        default:
+            buf = append(buf, c)
+            p.pos++
+        }
+    }
+    return "", false
+}
+
+func (p *parser) hex4() (rune, bool) {
+    if len(p.data)-p.pos < 4 {
+        return 0, false
+    }
+    var r rune
+    for _, c := range p.data[p.pos : p.pos+4] {
+        switch {
+        case c >= '0' && c <= '9':
+            c -= '0'
+        case c >= 'a' && c <= 'f':
+            c = c - 'a' + 10
+        case c >= 'A' && c <= 'F':
+            c = c - 'A' + 10
+        default:
+            return 0, false
+        }
+        r = r<<4 | rune(c)
+    }
+    p.pos += 4
+    return r, true
+}
+
+// number checks the JSON number grammar and reads it as encoding/json does into interface{}.
+// Short integers, which is what timestamps are, skip strconv
+func (p *parser) number() (interface{}, bool) {
+    start := p.pos
+    if p.peek() == '-' {
+        p.pos++
+    }
+    switch c := p.peek(); {
+    case c == '0':
+        p.pos++
+    case c >= '1' && c <= '9':
+        for c := p.peek(); c >= '0' && c <= '9'; c = p.peek() {
+            p.pos++
+        }
+    default:
+        return nil, false
+    }
+    integer := true
+    if p.peek() == '.' {
+        integer = false
+        p.pos++
+        if !p.digits() {
+            return nil, false
+        }
+    }
+    if c := p.peek(); c == 'e' || c == 'E' {
+        integer = false
+        p.pos++
+        if c := p.peek(); c == '+' || c == '-' {
+            p.pos++
+        }
+        if !p.digits() {
+            return nil, false
+        }
+    }
+
+    // -0 is left to strconv, which keeps the sign
+    lit := p.data[start:p.pos]
+    if integer && len(lit) <= 15 && string(lit) != "-0" {
+        neg := lit[0] == '-'
+        if neg {
+            lit = lit[1:]
+        }
+        var n int64
+        for _, c := range lit {
+            n = n*10 + int64(c-'0')
+        }
+        if neg {
+            n = -n
+        }
+        return float64(n), true
+    }
+    f, err := strconv.ParseFloat(string(p.data[start:p.pos]), 64)
+    if err != nil {
+        return nil, false
+    }
+    return f, true
+}
+
+func (p *parser) digits() bool {
+    start := p.pos
+    for c := p.peek(); c >= '0' && c <= '9'; c = p.peek() {
+        p.pos++
+    }
+    return p.pos > start
+}
@@ -0,0 +1,26 @@
+//go:build !volly_simd || !(amd64 || arm64)
+
+package codec
+
+import (
+    "encoding/base64"
+    "encoding/json"
+)
+
+const implementation = "stdlib"
+
+func decodeRawURL(s string) ([]byte, error) {
+    return base64.RawURLEncoding.DecodeString(s)
+}
+
+func decodeStd(s string) ([]byte, error) {
+    return base64.StdEncoding.DecodeString(s)
+}
+
+func unmarshalObject(data []byte) (map[string]interface{}, error) {
+    var m map[string]interface{}
+    if err := json.Unmarshal(data, &m); err != nil {
+        return nil, err
+    }
+    return m, nil
+}
@@ -0,0 +1,227 @@
+package configstore
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/base64"
+    "encoding/hex"
+    "errors"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/secure"
+)
+
+var (
+    ErrNotFound       = errors.New("config entry not found")
+    ErrTenantRequired = errors.New("tenant is required")
+)
+
+// Tenant is the runtime configuration of one tenant
+type Tenant struct {
+    ID          string `json:"id"`
+    DisplayName string `json:"displayName,omitempty"`
+    MaxRooms    int    `json:"maxRooms,omitempty"`
+    Suspended   bool   `json:"suspended,omitempty"`
+}
+
+// APIKey is a tenant's signing credential; Secret is never serialized
+type APIKey struct {
+    ID     string              `json:"id"`
+    Tenant string              `json:"tenant"`
+    Scopes []string            `json:"scopes,omitempty"`
+    Secret *secure.SecureBytes `json:"-"`
+    // NotAfter retires a rotated-out key once its grace period ends; zero never expires
+    NotAfter time.Time `json:"notAfter,omitempty"`
+}
+
+// RoomPolicy constrains rooms whose name matches Room; a trailing * matches a prefix
+type RoomPolicy struct {
+    Tenant           string `json:"tenant"`
+    Name             string `json:"name"`
+    Room             string `json:"room"`
+    MaxParticipants  int    `json:"maxParticipants,omitempty"`
+    RequirePQ        bool   `json:"requirePQ,omitempty"`
+    RecordingAllowed bool   `json:"recordingAllowed,omitempty"`
+    // Providers admits only tokens whose provenance names one of them, e.g. oidc and saml
+    // for a room guests must stay out of; empty admits any token
+    Providers []string `json:"providers,omitempty"`
+    // PINHash makes participants enter the PIN it is the roompin hash of
+    PINHash string `json:"pinHash,omitempty"`
+    // Shadow policies are a dry run of a change: the gateway evaluates them in place of the
+    // active policies for the same Room and logs what they would decide differently
+    Shadow bool `json:"shadow,omitempty"`
+}
+
+// GenerateAPIKey returns a LiveKit-style key ID and a 256-bit base64url secret; the caller
+// should Wipe the secret once it has been stored and delivered
+func GenerateAPIKey() (string, []byte, error) {
+    buf := make([]byte, 8+32)
+    if _, err := rand.Read(buf); err != nil {
+        return "", nil, err
+    }
+    id := "VK" + hex.EncodeToString(buf[:8])
+    secret := make([]byte, base64.RawURLEncoding.EncodedLen(32))
+    base64.RawURLEncoding.Encode(secret, buf[8:])
+    secure.Wipe(buf)
+    return id, secret, nil
+}
+
+// Store holds tenants, API keys and room policies shared by every Volly service
+type Store interface {
+    GetTenant(ctx context.Context, id string) (Tenant, error)
+    PutTenant(ctx context.Context, t Tenant) error
+    DeleteTenant(ctx context.Context, id string) error
+    ListTenants(ctx context.Context) ([]Tenant, error)
+
+    // GetAPIKey and ListAPIKeys share the stored Secret: it stays usable for as long as the
+    // caller holds it, even after the key is replaced or deleted, and must not be Closed
+    GetAPIKey(ctx context.Context, id string) (APIKey, error)
+    // PutAPIKey takes ownership of the key's Secret
+    PutAPIKey(ctx context.Context, k APIKey) error
+    DeleteAPIKey(ctx context.Context, id string) error
+    ListAPIKeys(ctx context.Context, tenant string) ([]APIKey, error)
+
+    PutRoomPolicy(ctx context.Context, p RoomPolicy) error
+    DeleteRoomPolicy(ctx context.Context, tenant, name string) error
+    ListRoomPolicies(ctx context.Context, tenant string) ([]RoomPolicy, error)
+}
+
+// MemoryStore is an in-process Store; replaced and deleted secrets are zeroized once no
+// reader holds them
+type MemoryStore struct {
+    mu       sync.RWMutex
+    tenants  map[string]Tenant
+    keys     map[string]APIKey
+    policies map[policyKey]RoomPolicy
+}
+
+type policyKey struct {
+    tenant, name string
+}
+
+// NewMemoryStore creates an empty store
+func NewMemoryStore() *MemoryStore {
+    return &MemoryStore{
+        tenants:  make(map[string]Tenant),
+        keys:     make(map[string]APIKey),
+        policies: make(map[policyKey]RoomPolicy),
+    }
+}
+
+func (s *MemoryStore) GetTenant(ctx context.Context, id string) (Tenant, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    t, ok := s.tenants[id]
+    if !ok {
+        return Tenant{}, ErrNotFound
+    }
+    return t, nil
+}
+
+func (s *MemoryStore) PutTenant(ctx context.Context, t Tenant) error {
+    if t.ID == "" {
+        return ErrTenantRequired
+    }
+    s.mu.Lock()
+    s.tenants[t.ID] = t
+    s.mu.Unlock()
+    return nil
+}
+
+func (s *MemoryStore) DeleteTenant(ctx context.Context, id string) error {
+    s.mu.Lock()
+    delete(s.tenants, id)
+    s.mu.Unlock()
+    return nil
+}
+
+func (s *MemoryStore) ListTenants(ctx context.Context) ([]Tenant, error) {
+    s.mu.RLock()
+    out := make([]Tenant, 0, len(s.tenants))
+    for _, t := range s.tenants {
+        out = append(out, t)
+    }
+    s.mu.RUnlock()
+    sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+    return out, nil
+}
+
+func (s *MemoryStore) GetAPIKey(ctx context.Context, id string) (APIKey, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    k, ok := s.keys[id]
+    if !ok {
+        return APIKey{}, ErrNotFound
+    }
+    return k, nil
+}
+
+func (s *MemoryStore) PutAPIKey(ctx context.Context, k APIKey) error {
+    if k.Tenant == "" {
+        return ErrTenantRequired
+    }
+    if k.ID == "" {
+        return errors.New("key id is required")
+    }
+    s.mu.Lock()
+    s.keys[k.ID] = k
+    s.mu.Unlock()
+    return nil
+}
+
+func (s *MemoryStore) DeleteAPIKey(ctx context.Context, id string) error {
+    s.mu.Lock()
+    delete(s.keys, id)
+    s.mu.Unlock()
+    return nil
+}
+
+func (s *MemoryStore) ListAPIKeys(ctx context.Context, tenant string) ([]APIKey, error) {
+    s.mu.RLock()
+    var out []APIKey
+    for _, k := range s.keys {
+        if tenant == "" || k.Tenant == tenant {
+            out = append(out, k)
+        }
+    }
+    s.mu.RUnlock()
+    sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+    return out, nil
+}
+
+func (s *MemoryStore) PutRoomPolicy(ctx context.Context, p RoomPolicy) error {
+    if p.Tenant == "" {
+        return ErrTenantRequired
+    }
+    s.mu.Lock()
+    s.policies[policyKey{p.Tenant, p.Name}] = p
+    s.mu.Unlock()
+    return nil
+}
+
+func (s *MemoryStore) DeleteRoomPolicy(ctx context.Context, tenant, name string) error {
+    s.mu.Lock()
+    delete(s.policies, policyKey{tenant, name})
+    s.mu.Unlock()
+    return nil
+}
+
+func (s *MemoryStore) ListRoomPolicies(ctx context.Context, tenant string) ([]RoomPolicy, error) {
+    s.mu.RLock()
+    var out []RoomPolicy
+    for _, p := range s.policies {
+        if tenant == "" || p.Tenant == tenant {
+            out = append(out, p)
+        }
+    }
+    s.mu.RUnlock()
+    sort.Slice(out, func(i, j int) bool {
+        if out[i].Tenant != out[j].Tenant {
+            return out[i].Tenant < out[j].Tenant
+        }
+        return out[i].Name < out[j].Name
+    })
+    return out, nil
+}
@@ -0,0 +1,72 @@
+package configstore
+
+import (
+    "context"
+    "sync"
+    "testing"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/secure"
+)
+
+// TestSecretOutlivesRotation holds a key's secret across its rotation and deletion, as an
+// in-flight verification does, and keeps using it while the key is rotated concurrently
+func TestSecretOutlivesRotation(t *testing.T) {
+    ctx := context.Background()
+    s := NewMemoryStore()
+    put := func(value string) {
+        secret, err := secure.FromString(value)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if err := s.PutAPIKey(ctx, APIKey{ID: "k", Tenant: "t", Secret: secret}); err != nil {
+            t.Fatal(err)
+        }
+    }
+    use := func(k APIKey) string {
+        var got string
+        if err := k.Secret.Use(func(b []byte) error {
+            got = string(b)
+            return nil
+        }); err != nil {
+            t.Error(err)
+        }
+        return got
+    }
+
+    put("first")
+    held, err := s.GetAPIKey(ctx, "k")
+    if err != nil {
+        t.Fatal(err)
+    }
+    put("second")
+    if err := s.DeleteAPIKey(ctx, "k"); err != nil {
+        t.Fatal(err)
+    }
+    if got := use(held); got != "first" {
+        t.Fatalf("held secret after rotation and delete = %q, want first", got)
+    }
+
+    put("third")
+    var wg sync.WaitGroup
+    for i := 0; i < 8; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for j := 0; j < 200; j++ {
+                k, err := s.GetAPIKey(ctx, "k")
+                if err != nil {
+                    t.Error(err)
+                    return
+                }
+                if got := use(k); got != "third" && got != "fourth" {
+                    t.Errorf("secret read during rotation = %q", got)
+                    return
+                }
+            }
+        }()
+    }
+    for j := 0; j < 200; j++ {
+        put([]string{"third", "fourth"}[j%2])
+    }
+    wg.Wait()
+}
@@ -0,0 +1,461 @@
+package conformance
+
+import (
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+    "github.com/volly-org/volly-signaling/pkg/volly/protocol"
+    "github.com/volly-org/volly-signaling/pkg/volly/websocket"
+)
+
+// DefaultCaseTimeout bounds each case
+const DefaultCaseTimeout = 10 * time.Second
+
+var ErrSkipped = errors.New("case does not apply to this server")
+
+// Target is the gateway under test
+type Target struct {
+    // GatewayURL is the gateway's base URL, such as http://localhost:7880
+    GatewayURL string
+    Room       string
+    // Token is a valid token for Room
+    Token string
+    // Format is websocket.ProtocolJSON or websocket.ProtocolCBOR; JSON when empty
+    Format string
+    // ServerKey and ServerAlgorithm, when set, are the key every key exchange must be signed with
+    ServerKey       []byte
+    ServerAlgorithm string
+}
+
+// Result is one case's outcome
+type Result struct {
+    Name    string        `json:"name"`
+    Passed  bool          `json:"passed"`
+    Skipped bool          `json:"skipped,omitempty"`
+    Detail  string        `json:"detail,omitempty"`
+    Elapsed time.Duration `json:"elapsed"`
+}
+
+// Report is a whole run
+type Report struct {
+    Target  string   `json:"target"`
+    Results []Result `json:"results"`
+    Failed  int      `json:"failed"`
+}
+
+// Case is one protocol check
+type Case struct {
+    Name string
+    Run  func(ctx context.Context, t *Target) error
+}
+
+// Cases are run in order; later ones assume the handshake works
+var Cases = []Case{
+    {"handshake/hello_required", helloRequired},
+    {"handshake/unsupported_version", unsupportedVersion},
+    {"handshake/welcome", welcome},
+    {"schema/unknown_type", unknownType},
+    {"schema/unknown_field", unknownField},
+    {"schema/wrong_type", wrongType},
+    {"ping/pong", ping},
+    {"key_exchange/complete", keyExchange},
+    {"resume/ticket", resume},
+    {"resume/bad_proof", resumeBadProof},
+    {"resume/bad_ticket", resumeBadTicket},
+}
+
+// Run executes every case against t
+func Run(ctx context.Context, t *Target) *Report {
+    report := &Report{Target: t.GatewayURL}
+    for _, c := range Cases {
+        cctx, cancel := context.WithTimeout(ctx, DefaultCaseTimeout)
+        start := time.Now()
+        err := c.Run(cctx, t)
+        cancel()
+
+        r := Result{Name: c.Name, Passed: err == nil, Elapsed: time.Since(start)}
+        if errors.Is(err, ErrSkipped) {
+            r.Passed, r.Skipped = true, true
+        }
+        if err != nil {
+            r.Detail = err.Error()
+        }
+        if !r.Passed {
+            report.Failed++
+        }
+        report.Results = append(report.Results, r)
+    }
+    return report
+}
+
+// allFeatures are asked for in every hello the suite sends
+var allFeatures = []string{
+    protocol.FeaturePing,
+    protocol.FeatureEventFilter,
+    protocol.FeatureKeyExchange,
+    protocol.FeatureResumption,
+    protocol.FeatureTokenRefresh,
+}
+
+// peer is one connection from the suite
+type peer struct {
+    conn     *websocket.Conn
+    welcome  protocol.Welcome
+    features map[string]bool
+    nonce    []byte
+}
+
+func (t *Target) dial(ctx context.Context, withToken bool) (*peer, error) {
+    format := t.Format
+    if format == "" {
+        format = websocket.ProtocolJSON
+    }
+    header := make(http.Header)
+    if withToken {
+        header.Set("Authorization", "Bearer "+t.Token)
+    }
+    u := strings.TrimSuffix(t.GatewayURL, "/") + "/v1/rooms/" + url.PathEscape(t.Room) + "/events"
+    conn, err := websocket.NewDialer().SetProtocols(format).Dial(ctx, u, header)
+    if err != nil {
+        return nil, err
+    }
+    context.AfterFunc(ctx, func() { conn.Close() })
+    return &peer{conn: conn}, nil
+}
+
+// open dials and completes the handshake
+func (t *Target) open(ctx context.Context, withToken bool) (*peer, error) {
+    p, err := t.dial(ctx, withToken)
+    if err != nil {
+        return nil, err
+    }
+    if err := p.conn.Send(protocol.Hello{Type: protocol.TypeHello, Versions: []int{protocol.Version2, protocol.Version1}, Features: allFeatures, Client: "volly-conformance"}); err != nil {
+        return nil, err
+    }
+    if err := p.expect(protocol.TypeWelcome, &p.welcome); err != nil {
+        return nil, err
+    }
+    p.features = make(map[string]bool)
+    for _, f := range p.welcome.Features {
+        p.features[f] = true
+    }
+    if p.welcome.Nonce != "" {
+        if p.nonce, err = base64.StdEncoding.DecodeString(p.welcome.Nonce); err != nil {
+            return nil, fmt.Errorf("welcome nonce is not base64: %v", err)
+        }
+    }
+    return p, nil
+}
+
+// next returns the next protocol message, skipping room events
+func (p *peer) next() (map[string]interface{}, error) {
+    for {
+        typ, data, err := p.conn.ReadMessage()
+        if err != nil {
+            return nil, err
+        }
+        var v interface{}
+        if typ == websocket.BinaryMessage {
+            v, err = websocket.UnmarshalCBOR(data)
+        } else {
+            err = json.Unmarshal(data, &v)
+        }
+        msg, ok := v.(map[string]interface{})
+        if err != nil || !ok {
+            return nil, fmt.Errorf("server sent an undecodable frame")
+        }
+        switch msg["type"] {
+        case protocol.TypeWelcome, protocol.TypeError, protocol.TypePong, protocol.TypeKeyExchange,
+            protocol.TypeTicket, protocol.TypeResumed, protocol.TypeRefreshed:
+            return msg, nil
+        }
+    }
+}
+
+// expect reads the next protocol message, which must be typ, into v
+func (p *peer) expect(typ string, v interface{}) error {
+    msg, err := p.next()
+    if err != nil {
+        return err
+    }
+    if msg["type"] != typ {
+        return fmt.Errorf("expected %s, got %v %v", typ, msg["type"], msg["code"])
+    }
+    data, err := json.Marshal(msg)
+    if err != nil {
+        return err
+    }
+    return json.Unmarshal(data, v)
+}
+
+// expectError reads an error message and checks its code and, when set, its field
+func (p *peer) expectError(code, field string) (protocol.ErrorMessage, error) {
+    var e protocol.ErrorMessage
+    if err := p.expect(protocol.TypeError, &e); err != nil {
+        return e, err
+    }
+    if e.Code != code {
+        return e, fmt.Errorf("expected error code %s, got %s", code, e.Code)
+    }
+    if field != "" && e.Field != field {
+        return e, fmt.Errorf("expected error on field %s, got %q", field, e.Field)
+    }
+    return e, nil
+}
+
+func helloRequired(ctx context.Context, t *Target) error {
+    p, err := t.dial(ctx, true)
+    if err != nil {
+        return err
+    }
+    if err := p.conn.Send(map[string]interface{}{"type": protocol.TypePing}); err != nil {
+        return err
+    }
+    _, err = p.expectError(protocol.CodeHandshakeRequired, "")
+    return err
+}
+
+func unsupportedVersion(ctx context.Context, t *Target) error {
+    p, err := t.dial(ctx, true)
+    if err != nil {
+        return err
+    }
+    if err := p.conn.Send(protocol.Hello{Type: protocol.TypeHello, Versions: []int{9999}}); err != nil {
+        return err
+    }
+    e, err := p.expectError(protocol.CodeUnsupportedVersion, "versions")
+    if err != nil {
+        return err
+    }
+    if len(e.Versions) == 0 {
+        return errors.New("unsupported_version error does not list the server's versions")
+    }
+    return nil
+}
+
+func welcome(ctx context.Context, t *Target) error {
+    p, err := t.open(ctx, true)
+    if err != nil {
+        return err
+    }
+    if p.welcome.Version < protocol.Version1 {
+        return fmt.Errorf("welcome names version %d", p.welcome.Version)
+    }
+    if (p.welcome.Version >= protocol.Version2) != (len(p.nonce) == 32) {
+        return fmt.Errorf("version %d welcome has a %d byte nonce", p.welcome.Version, len(p.nonce))
+    }
+    for _, f := range p.welcome.Features {
+        found := false
+        for _, o := range allFeatures {
+            found = found || o == f
+        }
+        if !found {
+            return fmt.Errorf("welcome grants feature %q that was not asked for", f)
+        }
+    }
+    return nil
+}
+
+func unknownType(ctx context.Context, t *Target) error {
+    p, err := t.open(ctx, true)
+    if err != nil {
+        return err
+    }
+    if err := p.conn.Send(map[string]interface{}{"type": "conformance_bogus"}); err != nil {
+        return err
+    }
+    _, err = p.expectError(protocol.CodeUnknownType, "")
+    return err
+}
+
+func unknownField(ctx context.Context, t *Target) error {
+    p, err := t.open(ctx, true)
+    if err != nil {
+        return err
+    }
+    if err := p.conn.Send(map[string]interface{}{"type": protocol.TypePing, "id": "1", "bogus": true}); err != nil {
+        return err
+    }
+    _, err = p.expectError(protocol.CodeUnknownField, "bogus")
+    return err
+}
+
+func wrongType(ctx context.Context, t *Target) error {
+    p, err := t.open(ctx, true)
+    if err != nil {
+        return err
+    }
+    if err := p.conn.Send(map[string]interface{}{"type": protocol.TypeSubscribe, "types": "participant_joined"}); err != nil {
+        return err
+    }
+    _, err = p.expectError(protocol.CodeWrongType, "types")
+    return err
+}
+
+func ping(ctx context.Context, t *Target) error {
+    p, err := t.open(ctx, true)
+    if err != nil {
+        return err
+    }
+    if !p.features[protocol.FeaturePing] {
+        return ErrSkipped
+    }
+    // An invalid message must not end the session
+    if err := p.conn.Send(map[string]interface{}{"type": "conformance_bogus"}); err != nil {
+        return err
+    }
+    if _, err := p.expectError(protocol.CodeUnknownType, ""); err != nil {
+        return err
+    }
+    if err := p.conn.Send(map[string]interface{}{"type": protocol.TypePing, "id": "conformance"}); err != nil {
+        return err
+    }
+    var pong protocol.Pong
+    if err := p.expect(protocol.TypePong, &pong); err != nil {
+        return err
+    }
+    if pong.ID != "conformance" {
+        return fmt.Errorf("pong echoes id %q", pong.ID)
+    }
+    return nil
+}
+
+// session is the outcome of a key exchange
+type session struct {
+    secret []byte
+    ticket protocol.TicketMessage
+}
+
+// exchange runs the key exchange on an open peer and waits for its ticket when resumption is on
+func (t *Target) exchange(p *peer) (*session, error) {
+    if !p.features[protocol.FeatureKeyExchange] {
+        return nil, ErrSkipped
+    }
+    kp, err := crypto.GenerateMLKEM768KeyPair()
+    if err != nil {
+        return nil, err
+    }
+    err = p.conn.Send(protocol.KeyExchange{Type: protocol.TypeKeyExchange, Algorithm: crypto.AlgorithmMLKEM768, PublicKey: base64.StdEncoding.EncodeToString(kp.PublicKey)})
+    if err != nil {
+        return nil, err
+    }
+    var reply protocol.KeyExchangeReply
+    if err := p.expect(protocol.TypeKeyExchange, &reply); err != nil {
+        return nil, err
+    }
+    ct, err := base64.StdEncoding.DecodeString(reply.Ciphertext)
+    if err != nil {
+        return nil, fmt.Errorf("ciphertext is not base64: %v", err)
+    }
+    shared, err := crypto.Decapsulate(kp.PrivateKey, ct)
+    if err != nil {
+        return nil, fmt.Errorf("decapsulate: %v", err)
+    }
+    transcript := protocol.KeyExchangeTranscript(p.nonce, kp.PublicKey, ct)
+    if t.ServerKey != nil {
+        sig, err := base64.StdEncoding.DecodeString(reply.Signature)
+        if err != nil || reply.SignatureAlgorithm != t.ServerAlgorithm {
+            return nil, fmt.Errorf("key exchange is not signed with %s", t.ServerAlgorithm)
+        }
+        if err := crypto.VerifySignature(t.ServerAlgorithm, t.ServerKey, transcript, sig); err != nil {
+            return nil, fmt.Errorf("key exchange signature: %v", err)
+        }
+    }
+    s := &session{secret: protocol.SessionSecret(shared, transcript)}
+    if p.features[protocol.FeatureResumption] {
+        if err := p.expect(protocol.TypeTicket, &s.ticket); err != nil {
+            return nil, err
+        }
+    }
+    return s, nil
+}
+
+func keyExchange(ctx context.Context, t *Target) error {
+    p, err := t.open(ctx, true)
+    if err != nil {
+        return err
+    }
+    _, err = t.exchange(p)
+    return err
+}
+
+// ticketed opens a session and returns a ticket from it, skipping servers without resumption
+func (t *Target) ticketed(ctx context.Context) (*session, error) {
+    p, err := t.open(ctx, true)
+    if err != nil {
+        return nil, err
+    }
+    if !p.features[protocol.FeatureResumption] {
+        return nil, ErrSkipped
+    }
+    s, err := t.exchange(p)
+    p.conn.Close()
+    return s, err
+}
+
+func resume(ctx context.Context, t *Target) error {
+    s, err := t.ticketed(ctx)
+    if err != nil {
+        return err
+    }
+    p, err := t.open(ctx, false)
+    if err != nil {
+        return err
+    }
+    proof := base64.StdEncoding.EncodeToString(protocol.ResumeProof(s.secret, p.nonce))
+    if err := p.conn.Send(protocol.Resume{Type: protocol.TypeResume, Ticket: s.ticket.Ticket, Proof: proof}); err != nil {
+        return err
+    }
+    var resumed protocol.Resumed
+    if err := p.expect(protocol.TypeResumed, &resumed); err != nil {
+        return err
+    }
+    if resumed.Room != t.Room {
+        return fmt.Errorf("resumed into room %q", resumed.Room)
+    }
+    // A resumed session is issued a ticket for its next secret
+    var next protocol.TicketMessage
+    return p.expect(protocol.TypeTicket, &next)
+}
+
+func resumeBadProof(ctx context.Context, t *Target) error {
+    s, err := t.ticketed(ctx)
+    if err != nil {
+        return err
+    }
+    p, err := t.open(ctx, false)
+    if err != nil {
+        return err
+    }
+    // A proof over another nonce is what a replayed resume would carry
+    proof := base64.StdEncoding.EncodeToString(protocol.ResumeProof(s.secret, make([]byte, 32)))
+    if err := p.conn.Send(protocol.Resume{Type: protocol.TypeResume, Ticket: s.ticket.Ticket, Proof: proof}); err != nil {
+        return err
+    }
+    _, err = p.expectError(protocol.CodeUnauthorized, "proof")
+    return err
+}
+
+func resumeBadTicket(ctx context.Context, t *Target) error {
+    p, err := t.open(ctx, false)
+    if err != nil {
+        return err
+    }
+    if !p.features[protocol.FeatureResumption] {
+        return ErrSkipped
+    }
+    proof := base64.StdEncoding.EncodeToString(make([]byte, 32))
+    if err := p.conn.Send(protocol.Resume{Type: protocol.TypeResume, Ticket: "Y29uZm9ybWFuY2U", Proof: proof}); err != nil {
+        return err
+    }
+    _, err = p.expectError(protocol.CodeUnauthorized, "ticket")
+    return err
+}
@@ -0,0 +1,457 @@
+// Package conformance holds the golden vectors and the protocol suite other SDKs check
+// themselves against. The vectors are fixed inputs and outputs of every primitive a client
+// reimplements: access tokens carrying a PQ key, ML-KEM keys and encapsulations, signatures,
+// key wrap envelopes, the signaling key exchange and resume derivations, viewer tokens and
+// CBOR frames. Byte fields are base64
+// in the JSON file. The suite drives a running gateway through the signaling protocol and
+// reports where it diverges from this implementation
+package conformance
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    _ "embed"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "reflect"
+    "strings"
+    "time"
+
+    lkauth "github.com/livekit/protocol/auth"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+    "github.com/volly-org/volly-signaling/pkg/volly/protocol"
+    "github.com/volly-org/volly-signaling/pkg/volly/watch"
+    "github.com/volly-org/volly-signaling/pkg/volly/websocket"
+)
+
+// VectorsVersion changes whenever a vector's meaning does
+const VectorsVersion = 1
+
+//go:embed testdata/vectors.json
+var goldenVectors []byte
+
+var ErrVectorMismatch = errors.New("conformance vector mismatch")
+
+// Vectors is the whole vector file
+type Vectors struct {
+    Version      int                 `json:"version"`
+    Tokens       []TokenVector       `json:"tokens"`
+    KEM          []KEMVector         `json:"kem"`
+    Signatures   []SignatureVector   `json:"signatures"`
+    Envelopes    []EnvelopeVector    `json:"envelopes"`
+    Sessions     []SessionVector     `json:"sessions"`
+    ViewerTokens []ViewerTokenVector `json:"viewerTokens"`
+    CBOR         []CBORVector        `json:"cbor"`
+}
+
+// TokenVector is a Volly access token: a LiveKit HS256 JWT under Secret whose claims carry a
+// post-quantum public key. Claims is its decoded payload and Signature the HMAC-SHA256 of its
+// header and payload segments. The token has long expired, so implementations check its
+// signature and claims rather than verify it against the current time
+type TokenVector struct {
+    APIKey    string          `json:"apiKey"`
+    Secret    string          `json:"secret"`
+    Token     string          `json:"token"`
+    Claims    json.RawMessage `json:"claims"`
+    Signature []byte          `json:"signature"`
+    // PQPublicKey is the key the pqPublicKey claim carries, the first kem vector's, and the
+    // other fields the pq claims beside it
+    PQPublicKey   []byte `json:"pqPublicKey"`
+    PQAlgorithm   string `json:"pqAlgorithm"`
+    PQKeyIssuedAt int64  `json:"pqKeyIssuedAt"`
+    PQKeyExpiry   int64  `json:"pqKeyExpiry"`
+}
+
+// KEMVector is an ML-KEM-768 key pair and one encapsulation to it; Thumbprint is the
+// public key's auth.KeyThumbprint
+type KEMVector struct {
+    Algorithm    string `json:"algorithm"`
+    PublicKey    []byte `json:"publicKey"`
+    PrivateKey   []byte `json:"privateKey"`
+    Ciphertext   []byte `json:"ciphertext"`
+    SharedSecret []byte `json:"sharedSecret"`
+    Thumbprint   string `json:"thumbprint"`
+}
+
+// SignatureVector is a valid signature; implementations must also reject it over any other message
+type SignatureVector struct {
+    Algorithm string `json:"algorithm"`
+    PublicKey []byte `json:"publicKey"`
+    Message   []byte `json:"message"`
+    Signature []byte `json:"signature"`
+}
+
+// EnvelopeVector is a key wrapped with crypto.WrapKey, such as a member's SFrame media key
+type EnvelopeVector struct {
+    PrivateKey     []byte `json:"privateKey"`
+    AssociatedData []byte `json:"associatedData"`
+    KEMCiphertext  []byte `json:"kemCiphertext"`
+    Wrapped        []byte `json:"wrapped"`
+    Key            []byte `json:"key"`
+}
+
+// SessionVector is one signaling key exchange followed by one resume
+type SessionVector struct {
+    Nonce         []byte `json:"nonce"`
+    PublicKey     []byte `json:"publicKey"`
+    PrivateKey    []byte `json:"privateKey"`
+    Ciphertext    []byte `json:"ciphertext"`
+    Transcript    []byte `json:"transcript"`
+    SessionSecret []byte `json:"sessionSecret"`
+    ResumeNonce   []byte `json:"resumeNonce"`
+    ResumeProof   []byte `json:"resumeProof"`
+    NextSecret    []byte `json:"nextSecret"`
+}
+
+// ViewerTokenVector is a viewer token that verifies at VerifyAt (Unix seconds) under Secret
+type ViewerTokenVector struct {
+    APIKey    string `json:"apiKey"`
+    Secret    string `json:"secret"`
+    Token     string `json:"token"`
+    VerifyAt  int64  `json:"verifyAt"`
+    Tenant    string `json:"tenant"`
+    Room      string `json:"room"`
+    Identity  string `json:"identity"`
+    TokenID   string `json:"tokenId"`
+    IssuedAt  int64  `json:"issuedAt"`
+    ExpiresAt int64  `json:"expiresAt"`
+}
+
+// CBORVector is a server message as JSON and as the CBOR binary frame carrying it
+type CBORVector struct {
+    Name    string          `json:"name"`
+    JSON    json.RawMessage `json:"json"`
+    Encoded []byte          `json:"encoded"`
+}
+
+// Golden returns the vectors shipped with this package
+func Golden() (*Vectors, error) {
+    var v Vectors
+    if err := json.Unmarshal(goldenVectors, &v); err != nil {
+        return nil, err
+    }
+    return &v, nil
+}
+
+// GoldenJSON is the vector file itself, for SDK test suites to copy
+func GoldenJSON() []byte { return goldenVectors }
+
+// Generate produces a fresh vector set. It is how the golden file was made; regenerating it
+// is only right when VectorsVersion changes
+func Generate() (*Vectors, error) {
+    v := &Vectors{Version: VectorsVersion}
+
+    kp, err := crypto.GenerateMLKEM768KeyPair()
+    if err != nil {
+        return nil, err
+    }
+    ct, ss, err := crypto.Encapsulate(kp.PublicKey)
+    if err != nil {
+        return nil, err
+    }
+    v.KEM = append(v.KEM, KEMVector{
+        Algorithm:    kp.Algorithm,
+        PublicKey:    kp.PublicKey,
+        PrivateKey:   kp.PrivateKey,
+        Ciphertext:   ct,
+        SharedSecret: ss,
+        Thumbprint:   auth.KeyThumbprint(kp.PublicKey),
+    })
+
+    issued := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+    token, err := auth.NewVollyAccessToken("APIconformance", "conformance-secret-0123456789abcdef").
+        AddGrant(&auth.VollyVideoGrant{VideoGrant: lkauth.VideoGrant{RoomJoin: true, Room: "room-1"}}).
+        SetIdentity("alice").SetTenant("acme").SetTokenID("conformance-token-1").SetClock(clock.NewManual(issued)).
+        SetPostQuantumKey(kp.PublicKey, kp.Algorithm).ToJWT()
+    if err != nil {
+        return nil, err
+    }
+    tv, err := tokenVector("APIconformance", "conformance-secret-0123456789abcdef", token)
+    if err != nil {
+        return nil, err
+    }
+    v.Tokens = append(v.Tokens, tv)
+
+    message := []byte("volly conformance signature vector")
+    for _, alg := range []string{crypto.AlgorithmEd25519, crypto.AlgorithmMLDSA65} {
+        sk, err := crypto.GenerateSigningKeyPair(alg)
+        if err != nil {
+            return nil, err
+        }
+        sig, err := crypto.Sign(alg, sk.PrivateKey, message)
+        if err != nil {
+            return nil, err
+        }
+        v.Signatures = append(v.Signatures, SignatureVector{Algorithm: alg, PublicKey: sk.PublicKey, Message: message, Signature: sig})
+    }
+
+    mediaKey := make([]byte, 32)
+    for i := range mediaKey {
+        mediaKey[i] = byte(i)
+    }
+    ad := []byte("room-1/alice/epoch-7")
+    kemCT, wrapped, err := crypto.WrapKey(kp.PublicKey, mediaKey, ad)
+    if err != nil {
+        return nil, err
+    }
+    v.Envelopes = append(v.Envelopes, EnvelopeVector{
+        PrivateKey:     kp.PrivateKey,
+        AssociatedData: ad,
+        KEMCiphertext:  kemCT,
+        Wrapped:        wrapped,
+        Key:            mediaKey,
+    })
+
+    session, err := generateSession()
+    if err != nil {
+        return nil, err
+    }
+    v.Sessions = append(v.Sessions, session)
+
+    viewer, err := auth.NewViewerToken("APIconformance", "conformance-secret-0123456789abcdef").
+        SetTenant("acme").SetRoom("room-1").SetIdentity("viewer-1").
+        SetValidFor(time.Hour).SetClock(clock.NewManual(issued)).Sign()
+    if err != nil {
+        return nil, err
+    }
+    claims, err := auth.ParseViewerToken(viewer)
+    if err != nil {
+        return nil, err
+    }
+    v.ViewerTokens = append(v.ViewerTokens, ViewerTokenVector{
+        APIKey:    claims.APIKey,
+        Secret:    "conformance-secret-0123456789abcdef",
+        Token:     viewer,
+        VerifyAt:  issued.Add(30 * time.Minute).Unix(),
+        Tenant:    claims.Tenant,
+        Room:      claims.Room,
+        Identity:  claims.Identity,
+        TokenID:   claims.TokenID,
+        IssuedAt:  claims.IssuedAt.Unix(),
+        ExpiresAt: claims.ExpiresAt.Unix(),
+    })
+
+    at := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+    messages := []struct {
+        name string
+        msg  interface{}
+    }{
+        {"welcome", protocol.Welcome{Type: protocol.TypeWelcome, Version: protocol.Version2, Features: []string{protocol.FeaturePing, protocol.FeatureResumption}, Nonce: "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="}},
+        {"error", protocol.ErrorMessage{Type: protocol.TypeError, Code: protocol.CodeUnsupportedVersion, MessageType: protocol.TypeHello, Field: "versions", Versions: []int{1, 2}}},
+        {"ticket", protocol.TicketMessage{Type: protocol.TypeTicket, Ticket: "opaque", ExpiresAt: at}},
+        {"event", watch.RoomEvent{Type: "participant_joined", Room: "room-1", Identity: "alice", Timestamp: at.UnixMilli(), Epoch: 7}},
+    }
+    for _, m := range messages {
+        text, err := json.Marshal(m.msg)
+        if err != nil {
+            return nil, err
+        }
+        encoded, err := websocket.MarshalCBOR(m.msg)
+        if err != nil {
+            return nil, err
+        }
+        v.CBOR = append(v.CBOR, CBORVector{Name: m.name, JSON: text, Encoded: encoded})
+    }
+    return v, nil
+}
+
+// tokenVector records token with its payload, signature and PQ claims
+func tokenVector(apiKey, secret, token string) (TokenVector, error) {
+    t := TokenVector{APIKey: apiKey, Secret: secret, Token: token}
+    segments := strings.Split(token, ".")
+    if len(segments) != 3 {
+        return t, errors.New("token is not a compact JWT")
+    }
+    payload, err := base64.RawURLEncoding.DecodeString(segments[1])
+    if err != nil {
+        return t, err
+    }
+    if t.Signature, err = base64.RawURLEncoding.DecodeString(segments[2]); err != nil {
+        return t, err
+    }
+    t.Claims = payload
+    grant, err := auth.ParseUnverified(token)
+    if err != nil {
+        return t, err
+    }
+    if t.PQPublicKey, err = base64.StdEncoding.DecodeString(grant.PQPublicKey); err != nil {
+        return t, err
+    }
+    t.PQAlgorithm, t.PQKeyIssuedAt, t.PQKeyExpiry = grant.PQAlgorithm, grant.PQKeyIssuedAt, grant.PQKeyExpiry
+    return t, nil
+}
+
+// checkToken recomputes a token vector's signature, as an SDK holding the secret would, and
+// reads its claims and PQ key back
+func checkToken(t TokenVector) error {
+    segments := strings.Split(t.Token, ".")
+    if len(segments) != 3 {
+        return errors.New("not a compact JWT")
+    }
+    var header struct {
+        Alg string `json:"alg"`
+    }
+    if data, err := base64.RawURLEncoding.DecodeString(segments[0]); err != nil || json.Unmarshal(data, &header) != nil || header.Alg != "HS256" {
+        return errors.New("header does not name HS256")
+    }
+    signature, err := base64.RawURLEncoding.DecodeString(segments[2])
+    if err != nil || !crypto.Equal(signature, t.Signature) {
+        return errors.New("signature segment")
+    }
+    mac := hmac.New(sha256.New, []byte(t.Secret))
+    mac.Write([]byte(segments[0] + "." + segments[1]))
+    if !crypto.Equal(mac.Sum(nil), t.Signature) {
+        return errors.New("signature does not verify under the secret")
+    }
+
+    payload, err := base64.RawURLEncoding.DecodeString(segments[1])
+    if err != nil {
+        return errors.New("payload is not base64")
+    }
+    var claims map[string]interface{}
+    if err := json.Unmarshal(payload, &claims); err != nil || !sameJSON(claims, t.Claims) {
+        return errors.New("claims")
+    }
+    if claims["iss"] != t.APIKey {
+        return fmt.Errorf("issued by %v, not %s", claims["iss"], t.APIKey)
+    }
+    grant, err := auth.ParseUnverified(t.Token)
+    if err != nil {
+        return err
+    }
+    key, err := base64.StdEncoding.DecodeString(grant.PQPublicKey)
+    switch {
+    case err != nil || !crypto.Equal(key, t.PQPublicKey):
+        return errors.New("pqPublicKey")
+    case grant.PQStatus != auth.PQStatusPresent || grant.PQAlgorithm != t.PQAlgorithm:
+        return errors.New("pqAlgorithm")
+    case grant.PQKeyIssuedAt != t.PQKeyIssuedAt || grant.PQKeyExpiry != t.PQKeyExpiry:
+        return errors.New("pq key lifetime")
+    }
+    return nil
+}
+
+func generateSession() (SessionVector, error) {
+    var s SessionVector
+    kp, err := crypto.GenerateMLKEM768KeyPair()
+    if err != nil {
+        return s, err
+    }
+    ct, shared, err := crypto.Encapsulate(kp.PublicKey)
+    if err != nil {
+        return s, err
+    }
+    s.Nonce = make([]byte, 32)
+    s.ResumeNonce = make([]byte, 32)
+    for i := range s.Nonce {
+        s.Nonce[i], s.ResumeNonce[i] = byte(i), byte(0xff-i)
+    }
+    s.PublicKey, s.PrivateKey, s.Ciphertext = kp.PublicKey, kp.PrivateKey, ct
+    s.Transcript = protocol.KeyExchangeTranscript(s.Nonce, kp.PublicKey, ct)
+    s.SessionSecret = protocol.SessionSecret(shared, s.Transcript)
+    s.ResumeProof = protocol.ResumeProof(s.SessionSecret, s.ResumeNonce)
+    s.NextSecret = protocol.NextSecret(s.SessionSecret, s.ResumeNonce)
+    return s, nil
+}
+
+// Check verifies every vector against this implementation and returns one error per mismatch
+func Check(v *Vectors) []error {
+    var errs []error
+    fail := func(format string, args ...interface{}) {
+        errs = append(errs, fmt.Errorf("%w: "+format, append([]interface{}{ErrVectorMismatch}, args...)...))
+    }
+    if v.Version != VectorsVersion {
+        fail("vectors are version %d, this implementation speaks %d", v.Version, VectorsVersion)
+    }
+
+    for i, t := range v.Tokens {
+        if err := checkToken(t); err != nil {
+            fail("tokens[%d]: %v", i, err)
+        }
+    }
+    for i, k := range v.KEM {
+        ss, err := crypto.Decapsulate(k.PrivateKey, k.Ciphertext)
+        if err != nil || !crypto.Equal(ss, k.SharedSecret) {
+            fail("kem[%d]: decapsulation does not give the shared secret", i)
+        }
+        if auth.KeyThumbprint(k.PublicKey) != k.Thumbprint {
+            fail("kem[%d]: thumbprint", i)
+        }
+    }
+    for i, s := range v.Signatures {
+        if err := crypto.VerifySignature(s.Algorithm, s.PublicKey, s.Message, s.Signature); err != nil {
+            fail("signatures[%d] %s: %v", i, s.Algorithm, err)
+        }
+        tampered := append(append([]byte(nil), s.Message...), 0)
+        if crypto.VerifySignature(s.Algorithm, s.PublicKey, tampered, s.Signature) == nil {
+            fail("signatures[%d] %s: verifies over a different message", i, s.Algorithm)
+        }
+    }
+    for i, e := range v.Envelopes {
+        key, err := crypto.UnwrapKey(e.PrivateKey, e.KEMCiphertext, e.Wrapped, e.AssociatedData)
+        if err != nil || !crypto.Equal(key, e.Key) {
+            fail("envelopes[%d]: unwrap", i)
+        }
+        if _, err := crypto.UnwrapKey(e.PrivateKey, e.KEMCiphertext, e.Wrapped, append(e.AssociatedData, 0)); err == nil {
+            fail("envelopes[%d]: unwraps under different associated data", i)
+        }
+    }
+    for i, s := range v.Sessions {
+        shared, err := crypto.Decapsulate(s.PrivateKey, s.Ciphertext)
+        transcript := protocol.KeyExchangeTranscript(s.Nonce, s.PublicKey, s.Ciphertext)
+        switch {
+        case err != nil:
+            fail("sessions[%d]: decapsulate: %v", i, err)
+        case !crypto.Equal(transcript, s.Transcript):
+            fail("sessions[%d]: transcript", i)
+        case !crypto.Equal(protocol.SessionSecret(shared, transcript), s.SessionSecret):
+            fail("sessions[%d]: session secret", i)
+        case !crypto.Equal(protocol.ResumeProof(s.SessionSecret, s.ResumeNonce), s.ResumeProof):
+            fail("sessions[%d]: resume proof", i)
+        case !crypto.Equal(protocol.NextSecret(s.SessionSecret, s.ResumeNonce), s.NextSecret):
+            fail("sessions[%d]: next secret", i)
+        }
+    }
+    for i, t := range v.ViewerTokens {
+        claims, err := auth.NewViewerVerifier(t.APIKey, t.Secret).SetClock(clock.NewManual(time.Unix(t.VerifyAt, 0))).Verify(t.Token)
+        if err != nil {
+            fail("viewerTokens[%d]: %v", i, err)
+            continue
+        }
+        got := ViewerTokenVector{APIKey: claims.APIKey, Secret: t.Secret, Token: t.Token, VerifyAt: t.VerifyAt,
+            Tenant: claims.Tenant, Room: claims.Room, Identity: claims.Identity, TokenID: claims.TokenID,
+            IssuedAt: claims.IssuedAt.Unix(), ExpiresAt: claims.ExpiresAt.Unix()}
+        if got != t {
+            fail("viewerTokens[%d]: claims %+v", i, got)
+        }
+    }
+    for _, c := range v.CBOR {
+        decoded, err := websocket.UnmarshalCBOR(c.Encoded)
+        if err != nil {
+            fail("cbor %s: %v", c.Name, err)
+            continue
+        }
+        if !sameJSON(decoded, c.JSON) {
+            fail("cbor %s: decodes to a different value", c.Name)
+        }
+    }
+    return errs
+}
+
+// sameJSON compares a decoded value with a JSON document through their JSON forms
+func sameJSON(v interface{}, doc json.RawMessage) bool {
+    data, err := json.Marshal(v)
+    if err != nil {
+        return false
+    }
+    var a, b interface{}
+    if json.Unmarshal(data, &a) != nil || json.Unmarshal(doc, &b) != nil {
+        return false
+    }
+    return reflect.DeepEqual(a, b)
+}
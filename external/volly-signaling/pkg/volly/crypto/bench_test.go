@@ -0,0 +1,157 @@
+package crypto
+
+import (
+    "encoding/base64"
+    "encoding/json"
+    "testing"
+
+    "github.com/cloudflare/circl/kem"
+    "github.com/cloudflare/circl/kem/mlkem/mlkem1024"
+    "github.com/cloudflare/circl/kem/mlkem/mlkem768"
+)
+
+// Benchmarks comparing post-quantum algorithm choices by size, latency and throughput; run
+// them with go test -bench . ./pkg/volly/crypto. SLH-DSA is missing because the circl release
+// this module pins does not implement it
+
+var benchKEMs = []struct {
+    name   string
+    scheme kem.Scheme
+}{
+    {"ML-KEM-768", mlkem768.Scheme()},
+    {"ML-KEM-1024", mlkem1024.Scheme()},
+}
+
+var benchSignatureAlgorithms = []string{
+    AlgorithmEd25519,
+    AlgorithmMLDSA44,
+    AlgorithmMLDSA65,
+    AlgorithmMLDSA87,
+}
+
+var benchMessage = []byte("volly benchmark message")
+
+func BenchmarkKEMKeygen(b *testing.B) {
+    for _, k := range benchKEMs {
+        b.Run(k.name, func(b *testing.B) {
+            for i := 0; i < b.N; i++ {
+                if _, _, err := k.scheme.GenerateKeyPair(); err != nil {
+                    b.Fatal(err)
+                }
+            }
+            b.ReportMetric(float64(k.scheme.PublicKeySize()), "B/publickey")
+        })
+    }
+}
+
+func BenchmarkKEMEncapsulate(b *testing.B) {
+    for _, k := range benchKEMs {
+        b.Run(k.name, func(b *testing.B) {
+            pk, _, err := k.scheme.GenerateKeyPair()
+            if err != nil {
+                b.Fatal(err)
+            }
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                if _, _, err := k.scheme.Encapsulate(pk); err != nil {
+                    b.Fatal(err)
+                }
+            }
+            b.ReportMetric(float64(k.scheme.CiphertextSize()), "B/ciphertext")
+        })
+    }
+}
+
+func BenchmarkKEMDecapsulate(b *testing.B) {
+    for _, k := range benchKEMs {
+        b.Run(k.name, func(b *testing.B) {
+            pk, sk, err := k.scheme.GenerateKeyPair()
+            if err != nil {
+                b.Fatal(err)
+            }
+            ct, _, err := k.scheme.Encapsulate(pk)
+            if err != nil {
+                b.Fatal(err)
+            }
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                if _, err := k.scheme.Decapsulate(sk, ct); err != nil {
+                    b.Fatal(err)
+                }
+            }
+        })
+    }
+}
+
+func BenchmarkSign(b *testing.B) {
+    for _, alg := range benchSignatureAlgorithms {
+        b.Run(alg, func(b *testing.B) {
+            kp, err := GenerateSigningKeyPair(alg)
+            if err != nil {
+                b.Fatal(err)
+            }
+            var size int
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                signature, err := Sign(alg, kp.PrivateKey, benchMessage)
+                if err != nil {
+                    b.Fatal(err)
+                }
+                size = len(signature)
+            }
+            b.ReportMetric(float64(size), "B/signature")
+        })
+    }
+}
+
+func BenchmarkVerifySignature(b *testing.B) {
+    for _, alg := range benchSignatureAlgorithms {
+        b.Run(alg, func(b *testing.B) {
+            kp, err := GenerateSigningKeyPair(alg)
+            if err != nil {
+                b.Fatal(err)
+            }
+            signature, err := Sign(alg, kp.PrivateKey, benchMessage)
+            if err != nil {
+                b.Fatal(err)
+            }
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                if err := VerifySignature(alg, kp.PublicKey, benchMessage, signature); err != nil {
+                    b.Fatal(err)
+                }
+            }
+        })
+    }
+}
+
+// BenchmarkIssueSignedToken measures a compact JWT signed directly with each algorithm, the
+// size a client pays per request if tokens move from HMAC to PQ signatures
+func BenchmarkIssueSignedToken(b *testing.B) {
+    for _, alg := range benchSignatureAlgorithms {
+        b.Run(alg, func(b *testing.B) {
+            kp, err := GenerateSigningKeyPair(alg)
+            if err != nil {
+                b.Fatal(err)
+            }
+            header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+            claims, _ := json.Marshal(map[string]interface{}{
+                "iss":   "vollybench",
+                "sub":   "bench-user",
+                "video": map[string]interface{}{"roomJoin": true, "room": "bench"},
+            })
+            signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+            var size int
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                signature, err := Sign(alg, kp.PrivateKey, []byte(signingInput))
+                if err != nil {
+                    b.Fatal(err)
+                }
+                size = len(signingInput) + 1 + base64.RawURLEncoding.EncodedLen(len(signature))
+            }
+            b.ReportMetric(float64(size), "B/token")
+        })
+    }
+}
@@ -0,0 +1,26 @@
+package crypto
+
+import (
+    "crypto/sha256"
+    "crypto/subtle"
+)
+
+// Equal compares secrets or key fingerprints in constant time; only the lengths may leak
+func Equal(a, b []byte) bool {
+    defer timingProbe("equal")()
+    return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+// EqualString is Equal for string-encoded values such as thumbprints and nonces
+func EqualString(a, b string) bool {
+    defer timingProbe("equal")()
+    return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// EqualHidingLength compares values of attacker-influenced length by hashing both first
+func EqualHidingLength(a, b []byte) bool {
+    defer timingProbe("equal")()
+    ha := sha256.Sum256(a)
+    hb := sha256.Sum256(b)
+    return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
@@ -0,0 +1,64 @@
+package crypto
+
+import (
+    "testing"
+
+    "github.com/cloudflare/circl/kem/mlkem/mlkem768"
+)
+
+// Seeds live in testdata/fuzz; run one target with go test -fuzz=FuzzX
+
+var fuzzSignatureAlgorithms = []string{
+    AlgorithmEd25519,
+    AlgorithmMLDSA44,
+    AlgorithmMLDSA65,
+    AlgorithmMLDSA87,
+}
+
+// FuzzUnmarshalPublicKey feeds arbitrary public keys and signatures to every verifier
+func FuzzUnmarshalPublicKey(f *testing.F) {
+    f.Add(make([]byte, 32+64))
+    f.Fuzz(func(t *testing.T, data []byte) {
+        for _, alg := range fuzzSignatureAlgorithms {
+            size := signatureSchemes[alg].PublicKeySize()
+            if len(data) < size {
+                continue
+            }
+            if VerifySignature(alg, data[:size], []byte("volly-fuzz"), data[size:]) == nil {
+                t.Fatalf("forged signature accepted for %s", alg)
+            }
+        }
+    })
+}
+
+// FuzzUnmarshalPrivateKey feeds arbitrary private keys to every signer, which must reject
+// them or sign without panicking
+func FuzzUnmarshalPrivateKey(f *testing.F) {
+    f.Add(make([]byte, 32))
+    f.Fuzz(func(t *testing.T, data []byte) {
+        for _, alg := range fuzzSignatureAlgorithms {
+            _, _ = Sign(alg, data, []byte("volly-fuzz"))
+        }
+    })
+}
+
+// FuzzUnwrapKey feeds arbitrary KEM ciphertexts and wrapped keys to decapsulation and unwrapping
+func FuzzUnwrapKey(f *testing.F) {
+    kp, err := GenerateMLKEM768KeyPair()
+    if err != nil {
+        f.Fatal(err)
+    }
+    f.Add(make([]byte, mlkem768.Scheme().CiphertextSize()+48))
+    f.Fuzz(func(t *testing.T, data []byte) {
+        // Malformed private keys must be rejected, not panic
+        _, _ = Decapsulate(data, data)
+
+        split := len(data)
+        if size := mlkem768.Scheme().CiphertextSize(); split > size {
+            split = size
+        }
+        if _, err := UnwrapKey(kp.PrivateKey, data[:split], data[split:], nil); err == nil {
+            t.Fatal("wrapped key accepted without the matching KEM ciphertext")
+        }
+    })
+}
@@ -0,0 +1,73 @@
+package crypto
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "errors"
+)
+
+// keyWrapInfo binds derived wrapping keys to their purpose
+const keyWrapInfo = "volly-key-wrap-v1"
+
+var ErrUnwrapFailed = errors.New("unable to unwrap key")
+
+// WrapKey encrypts key to the holder of an ML-KEM-768 public key
+func WrapKey(publicKey, key, associatedData []byte) (kemCiphertext, wrapped []byte, err error) {
+    kemCiphertext, sharedSecret, err := Encapsulate(publicKey)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    aead, err := newWrapAEAD(sharedSecret, kemCiphertext)
+    if err != nil {
+        return nil, nil, err
+    }
+    nonce := make([]byte, aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return nil, nil, err
+    }
+    return kemCiphertext, aead.Seal(nonce, nonce, key, associatedData), nil
+}
+
+// UnwrapKey reverses WrapKey with the matching ML-KEM-768 private key
+func UnwrapKey(privateKey, kemCiphertext, wrapped, associatedData []byte) ([]byte, error) {
+    sharedSecret, err := Decapsulate(privateKey, kemCiphertext)
+    if err != nil {
+        return nil, err
+    }
+
+    aead, err := newWrapAEAD(sharedSecret, kemCiphertext)
+    if err != nil {
+        return nil, err
+    }
+    if len(wrapped) < aead.NonceSize() {
+        return nil, ErrUnwrapFailed
+    }
+    nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+    key, err := aead.Open(nil, nonce, sealed, associatedData)
+    if err != nil {
+        return nil, ErrUnwrapFailed
+    }
+    return key, nil
+}
+
+// newWrapAEAD derives an AES-256-GCM key from the KEM shared secret (HKDF-SHA256, one block)
+func newWrapAEAD(sharedSecret, salt []byte) (cipher.AEAD, error) {
+    extract := hmac.New(sha256.New, salt)
+    extract.Write(sharedSecret)
+    prk := extract.Sum(nil)
+
+    expand := hmac.New(sha256.New, prk)
+    expand.Write([]byte(keyWrapInfo))
+    expand.Write([]byte{1})
+    wrapKey := expand.Sum(nil)
+
+    block, err := aes.NewCipher(wrapKey)
+    if err != nil {
+        return nil, err
+    }
+    return cipher.NewGCM(block)
+}
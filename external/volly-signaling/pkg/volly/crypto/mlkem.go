@@ -0,0 +1,36 @@
+package crypto
+
+import (
+    "errors"
+)
+
+// KEM algorithm names carried in tokens and handshakes
+const (
+    AlgorithmMLKEM768  = "ML-KEM-768"
+    AlgorithmMLKEM1024 = "ML-KEM-1024"
+)
+
+var ErrInvalidKey = errors.New("invalid key encoding")
+
+// KeyPair holds a serialized post-quantum key pair
+type KeyPair struct {
+    Algorithm  string
+    PublicKey  []byte
+    PrivateKey []byte
+}
+
+// GenerateMLKEM768KeyPair creates a fresh ML-KEM-768 key pair
+func GenerateMLKEM768KeyPair() (*KeyPair, error) {
+    return CurrentProvider().GenerateKeyPair(AlgorithmMLKEM768)
+}
+
+// Encapsulate derives a shared secret for the holder of an ML-KEM-768 publicKey
+func Encapsulate(publicKey []byte) (ciphertext, sharedSecret []byte, err error) {
+    return CurrentProvider().Encapsulate(AlgorithmMLKEM768, publicKey)
+}
+
+// Decapsulate recovers the shared secret from an ML-KEM-768 ciphertext
+func Decapsulate(privateKey, ciphertext []byte) ([]byte, error) {
+    defer timingProbe("decapsulate")()
+    return CurrentProvider().Decapsulate(AlgorithmMLKEM768, privateKey, ciphertext)
+}
@@ -0,0 +1,66 @@
+package crypto
+
+import (
+    "errors"
+    "sync"
+)
+
+var ErrUnknownProvider = errors.New("unknown crypto provider")
+
+// Provider performs the post-quantum primitives behind the package-level functions.
+// Private keys are opaque to callers: serialized keys for software providers,
+// key handles for hardware-backed ones
+type Provider interface {
+    Name() string
+    GenerateKeyPair(algorithm string) (*KeyPair, error)
+    Encapsulate(algorithm string, publicKey []byte) (ciphertext, sharedSecret []byte, err error)
+    Decapsulate(algorithm string, privateKey, ciphertext []byte) ([]byte, error)
+    Sign(algorithm string, privateKey, message []byte) ([]byte, error)
+    Verify(algorithm string, publicKey, message, signature []byte) error
+}
+
+// HedgedProvider is implemented by providers that offer randomized ML-DSA signing
+type HedgedProvider interface {
+    SignHedged(algorithm string, privateKey, message []byte) ([]byte, error)
+}
+
+var (
+    providerMu sync.RWMutex
+    providers  = map[string]Provider{PureGoProviderName: pureGoProvider{}}
+    active     = Provider(pureGoProvider{})
+)
+
+// RegisterProvider makes a provider selectable by name, typically from the init
+// function of a cgo or HSM package built under its own tag
+func RegisterProvider(p Provider) {
+    providerMu.Lock()
+    providers[p.Name()] = p
+    providerMu.Unlock()
+}
+
+// SetProvider routes all package-level operations through p
+func SetProvider(p Provider) {
+    providerMu.Lock()
+    active = p
+    providerMu.Unlock()
+}
+
+// UseProvider selects a registered provider by name
+func UseProvider(name string) error {
+    providerMu.Lock()
+    defer providerMu.Unlock()
+
+    p, ok := providers[name]
+    if !ok {
+        return ErrUnknownProvider
+    }
+    active = p
+    return nil
+}
+
+// CurrentProvider returns the provider in use
+func CurrentProvider() Provider {
+    providerMu.RLock()
+    defer providerMu.RUnlock()
+    return active
+}
@@ -0,0 +1,161 @@
+package crypto
+
+import (
+    "github.com/cloudflare/circl/kem"
+    "github.com/cloudflare/circl/kem/mlkem/mlkem1024"
+    "github.com/cloudflare/circl/kem/mlkem/mlkem768"
+    "github.com/cloudflare/circl/sign"
+    "github.com/cloudflare/circl/sign/ed25519"
+    "github.com/cloudflare/circl/sign/mldsa/mldsa44"
+    "github.com/cloudflare/circl/sign/mldsa/mldsa65"
+    "github.com/cloudflare/circl/sign/mldsa/mldsa87"
+)
+
+// PureGoProviderName is the default provider, backed by circl
+const PureGoProviderName = "purego"
+
+var kemSchemes = map[string]kem.Scheme{
+    AlgorithmMLKEM768:  mlkem768.Scheme(),
+    AlgorithmMLKEM1024: mlkem1024.Scheme(),
+}
+
+var signatureSchemes = map[string]sign.Scheme{
+    AlgorithmEd25519: ed25519.Scheme(),
+    AlgorithmMLDSA44: mldsa44.Scheme(),
+    AlgorithmMLDSA65: mldsa65.Scheme(),
+    AlgorithmMLDSA87: mldsa87.Scheme(),
+}
+
+// pureGoProvider implements every primitive in software
+type pureGoProvider struct{}
+
+func (pureGoProvider) Name() string { return PureGoProviderName }
+
+func (pureGoProvider) GenerateKeyPair(algorithm string) (*KeyPair, error) {
+    var pub, priv []byte
+    var err error
+    if scheme, ok := kemSchemes[algorithm]; ok {
+        pk, sk, genErr := scheme.GenerateKeyPair()
+        if genErr != nil {
+            return nil, genErr
+        }
+        if pub, err = pk.MarshalBinary(); err != nil {
+            return nil, err
+        }
+        if priv, err = sk.MarshalBinary(); err != nil {
+            return nil, err
+        }
+    } else if scheme, ok := signatureSchemes[algorithm]; ok {
+        pk, sk, genErr := scheme.GenerateKey()
+        if genErr != nil {
+            return nil, genErr
+        }
+        if pub, err = pk.MarshalBinary(); err != nil {
+            return nil, err
+        }
+        if priv, err = sk.MarshalBinary(); err != nil {
+            return nil, err
+        }
+    } else {
+        return nil, ErrUnsupportedAlgorithm
+    }
+
+    return &KeyPair{
+        Algorithm:  algorithm,
+        PublicKey:  pub,
+        PrivateKey: priv,
+    }, nil
+}
+
+func (pureGoProvider) Encapsulate(algorithm string, publicKey []byte) ([]byte, []byte, error) {
+    scheme, ok := kemSchemes[algorithm]
+    if !ok {
+        return nil, nil, ErrUnsupportedAlgorithm
+    }
+    if len(publicKey) != scheme.PublicKeySize() {
+        return nil, nil, ErrInvalidKey
+    }
+
+    pk, err := scheme.UnmarshalBinaryPublicKey(publicKey)
+    if err != nil {
+        return nil, nil, ErrInvalidKey
+    }
+    return scheme.Encapsulate(pk)
+}
+
+func (pureGoProvider) Decapsulate(algorithm string, privateKey, ciphertext []byte) ([]byte, error) {
+    scheme, ok := kemSchemes[algorithm]
+    if !ok {
+        return nil, ErrUnsupportedAlgorithm
+    }
+    if len(privateKey) != scheme.PrivateKeySize() || len(ciphertext) != scheme.CiphertextSize() {
+        return nil, ErrInvalidKey
+    }
+
+    sk, err := scheme.UnmarshalBinaryPrivateKey(privateKey)
+    if err != nil {
+        return nil, ErrInvalidKey
+    }
+    return scheme.Decapsulate(sk, ciphertext)
+}
+
+func (pureGoProvider) Sign(algorithm string, privateKey, message []byte) ([]byte, error) {
+    return signWith(algorithm, privateKey, message, false)
+}
+
+func (pureGoProvider) SignHedged(algorithm string, privateKey, message []byte) ([]byte, error) {
+    return signWith(algorithm, privateKey, message, true)
+}
+
+func (pureGoProvider) Verify(algorithm string, publicKey, message, signature []byte) error {
+    scheme, ok := signatureSchemes[algorithm]
+    if !ok {
+        return ErrUnsupportedAlgorithm
+    }
+    if len(publicKey) != scheme.PublicKeySize() {
+        return ErrInvalidKey
+    }
+
+    pk, err := scheme.UnmarshalBinaryPublicKey(publicKey)
+    if err != nil {
+        return ErrInvalidKey
+    }
+    if !scheme.Verify(pk, message, signature, nil) {
+        return ErrInvalidSignature
+    }
+    return nil
+}
+
+func signWith(algorithm string, privateKey, message []byte, hedged bool) ([]byte, error) {
+    scheme, ok := signatureSchemes[algorithm]
+    if !ok {
+        return nil, ErrUnsupportedAlgorithm
+    }
+    if len(privateKey) != scheme.PrivateKeySize() {
+        return nil, ErrInvalidKey
+    }
+
+    sk, err := scheme.UnmarshalBinaryPrivateKey(privateKey)
+    if err != nil {
+        return nil, ErrInvalidKey
+    }
+    if !hedged {
+        return scheme.Sign(sk, message, nil), nil
+    }
+
+    sig := make([]byte, scheme.SignatureSize())
+    switch key := sk.(type) {
+    case *mldsa44.PrivateKey:
+        err = mldsa44.SignTo(key, message, nil, true, sig)
+    case *mldsa65.PrivateKey:
+        err = mldsa65.SignTo(key, message, nil, true, sig)
+    case *mldsa87.PrivateKey:
+        err = mldsa87.SignTo(key, message, nil, true, sig)
+    default:
+        return scheme.Sign(sk, message, nil), nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    return sig, nil
+}
@@ -0,0 +1,105 @@
+package crypto
+
+import (
+    "errors"
+    "strconv"
+)
+
+// Signature algorithm names carried in tokens and handshakes
+const (
+    AlgorithmEd25519 = "Ed25519"
+    AlgorithmMLDSA44 = "ML-DSA-44"
+    AlgorithmMLDSA65 = "ML-DSA-65"
+    AlgorithmMLDSA87 = "ML-DSA-87"
+)
+
+var (
+    ErrUnsupportedAlgorithm = errors.New("unsupported signature algorithm")
+    ErrInvalidSignature     = errors.New("invalid signature")
+)
+
+// GenerateSigningKeyPair creates a key pair for a supported signature algorithm
+func GenerateSigningKeyPair(algorithm string) (*KeyPair, error) {
+    if _, ok := signatureSchemes[algorithm]; !ok {
+        return nil, ErrUnsupportedAlgorithm
+    }
+    return CurrentProvider().GenerateKeyPair(algorithm)
+}
+
+// DeriveSigningKeyPair derives a key pair from a 32-byte seed, so a service key can be
+// configured as a short secret and come back the same after a restart. Derivation is
+// deterministic, so it is done in software whatever the provider
+func DeriveSigningKeyPair(algorithm string, seed []byte) (*KeyPair, error) {
+    scheme, ok := signatureSchemes[algorithm]
+    if !ok {
+        return nil, ErrUnsupportedAlgorithm
+    }
+    if len(seed) != scheme.SeedSize() {
+        return nil, errors.New("seed must be " + strconv.Itoa(scheme.SeedSize()) + " bytes")
+    }
+    pk, sk := scheme.DeriveKey(seed)
+    pub, err := pk.MarshalBinary()
+    if err != nil {
+        return nil, err
+    }
+    priv, err := sk.MarshalBinary()
+    if err != nil {
+        return nil, err
+    }
+    return &KeyPair{Algorithm: algorithm, PublicKey: pub, PrivateKey: priv}, nil
+}
+
+// Sign signs message with a serialized private key
+func Sign(algorithm string, privateKey, message []byte) ([]byte, error) {
+    return CurrentProvider().Sign(algorithm, privateKey, message)
+}
+
+// SignHedged signs with FIPS 204 hedged randomness for ML-DSA, blinding the signing
+// computation against fault and power analysis; other algorithms sign as usual
+func SignHedged(algorithm string, privateKey, message []byte) ([]byte, error) {
+    p := CurrentProvider()
+    if hp, ok := p.(HedgedProvider); ok {
+        return hp.SignHedged(algorithm, privateKey, message)
+    }
+    return p.Sign(algorithm, privateKey, message)
+}
+
+// Signer signs on behalf of a service key, e.g. tree heads or exported reports
+type Signer interface {
+    Algorithm() string
+    PublicKey() []byte
+    Sign(message []byte) ([]byte, error)
+}
+
+type keyPairSigner struct {
+    kp     *KeyPair
+    hedged bool
+}
+
+// NewSigner wraps a signing key pair as a Signer
+func NewSigner(kp *KeyPair) Signer {
+    return &keyPairSigner{kp: kp}
+}
+
+// NewHedgedSigner wraps a key pair as a Signer that uses SignHedged
+func NewHedgedSigner(kp *KeyPair) Signer {
+    return &keyPairSigner{kp: kp, hedged: true}
+}
+
+func (s *keyPairSigner) Algorithm() string { return s.kp.Algorithm }
+
+func (s *keyPairSigner) PublicKey() []byte { return s.kp.PublicKey }
+
+func (s *keyPairSigner) Sign(message []byte) ([]byte, error) {
+    if s.hedged {
+        return SignHedged(s.kp.Algorithm, s.kp.PrivateKey, message)
+    }
+    return Sign(s.kp.Algorithm, s.kp.PrivateKey, message)
+}
+
+// VerifySignature checks signature over message with a serialized public key
+func VerifySignature(algorithm string, publicKey, message, signature []byte) error {
+    defer timingProbe("verify:" + algorithm)()
+
+    return CurrentProvider().Verify(algorithm, publicKey, message, signature)
+}
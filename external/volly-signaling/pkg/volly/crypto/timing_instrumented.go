@@ -0,0 +1,95 @@
+//go:build volly_timing
+
+package crypto
+
+import (
+    "math"
+    "sync"
+    "time"
+)
+
+// maxTimingSamples bounds memory per operation; older samples are overwritten
+const maxTimingSamples = 100000
+
+type timingRing struct {
+    samples []time.Duration
+    next    int
+}
+
+var (
+    timingMu   sync.Mutex
+    timingData = make(map[string]*timingRing)
+)
+
+// timingProbe records how long an operation took for side-channel analysis
+func timingProbe(op string) func() {
+    start := time.Now()
+    return func() {
+        elapsed := time.Since(start)
+
+        timingMu.Lock()
+        ring, ok := timingData[op]
+        if !ok {
+            ring = &timingRing{}
+            timingData[op] = ring
+        }
+        if len(ring.samples) < maxTimingSamples {
+            ring.samples = append(ring.samples, elapsed)
+        } else {
+            ring.samples[ring.next] = elapsed
+            ring.next = (ring.next + 1) % maxTimingSamples
+        }
+        timingMu.Unlock()
+    }
+}
+
+// TimingSamples returns the recorded durations for an operation
+func TimingSamples(op string) []time.Duration {
+    timingMu.Lock()
+    defer timingMu.Unlock()
+
+    ring, ok := timingData[op]
+    if !ok {
+        return nil
+    }
+    out := make([]time.Duration, len(ring.samples))
+    copy(out, ring.samples)
+    return out
+}
+
+// ResetTiming discards all recorded samples
+func ResetTiming() {
+    timingMu.Lock()
+    timingData = make(map[string]*timingRing)
+    timingMu.Unlock()
+}
+
+// WelchT computes Welch's t statistic between two sample classes (dudect style);
+// |t| above roughly 4.5 indicates secret-dependent timing
+func WelchT(a, b []time.Duration) float64 {
+    meanA, varA := meanVariance(a)
+    meanB, varB := meanVariance(b)
+    denom := math.Sqrt(varA/float64(len(a)) + varB/float64(len(b)))
+    if denom == 0 {
+        return 0
+    }
+    return (meanA - meanB) / denom
+}
+
+func meanVariance(samples []time.Duration) (float64, float64) {
+    if len(samples) < 2 {
+        return 0, 0
+    }
+    var sum float64
+    for _, s := range samples {
+        sum += float64(s)
+    }
+    mean := sum / float64(len(samples))
+
+    var sq float64
+    for _, s := range samples {
+        d := float64(s) - mean
+        sq += d * d
+    }
+    return mean, sq / float64(len(samples)-1)
+}
@@ -0,0 +1,10 @@
+//go:build !volly_timing
+
+package crypto
+
+// timingProbe is a no-op unless built with -tags volly_timing
+func timingProbe(op string) func() {
+    return noopProbe
+}
+
+func noopProbe() {}
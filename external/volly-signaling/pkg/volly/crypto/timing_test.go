@@ -0,0 +1,176 @@
+//go:build volly_timing
+
+package crypto
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "math"
+    "sort"
+    "testing"
+    "time"
+)
+
+// Dudect-style leakage tests: each runs an operation on two classes of input, interleaved at
+// random, reads the durations its timing probe recorded and fails when Welch's t between the
+// classes is past timingThreshold. Both classes give the same result, so only the secret
+// part of the input differs between them: comparisons and verifications fail in both, early
+// in one and late in the other. Run them on a quiet machine with
+// go test -tags volly_timing -run Timing ./pkg/volly/crypto
+
+const (
+    timingRounds = 20000
+    // timingThreshold is dudect's bound for a leak rather than noise
+    timingThreshold = 4.5
+    // timingCrop drops the slowest samples, which are preemption and GC rather than the
+    // operation
+    timingCrop = 0.9
+    // timingAttempts is how many measurements must all be past timingThreshold
+    timingAttempts = 3
+)
+
+// dudect runs classes[0] and classes[1] timingRounds times each, in random order, and checks
+// the durations probe recorded for them. A leak shows in every measurement while noise at
+// nanosecond scale does not, so it fails only when timingAttempts in a row are past the bound
+func dudect(t *testing.T, probe string, classes [2]func()) {
+    t.Helper()
+    var tstat float64
+    for attempt := 0; attempt < timingAttempts; attempt++ {
+        tstat = measure(t, probe, classes)
+        if math.Abs(tstat) <= timingThreshold {
+            return
+        }
+    }
+    t.Errorf("%s: timing depends on the input class, |t| = %.2f > %.1f", probe, math.Abs(tstat), timingThreshold)
+}
+
+// measure is one dudect run, returning Welch's t between the classes
+func measure(t *testing.T, probe string, classes [2]func()) float64 {
+    t.Helper()
+    order := make([]byte, 2*timingRounds)
+    if _, err := rand.Read(order); err != nil {
+        t.Fatal(err)
+    }
+    // Warm caches and the branch predictor before measuring
+    for _, c := range order[:1000] {
+        classes[c&1]()
+    }
+    ResetTiming()
+    for _, c := range order {
+        classes[c&1]()
+    }
+    samples := TimingSamples(probe)
+    if len(samples) != len(order) {
+        t.Fatalf("probe %s recorded %d samples for %d calls", probe, len(samples), len(order))
+    }
+
+    sorted := append([]time.Duration(nil), samples...)
+    sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+    limit := sorted[int(float64(len(sorted))*timingCrop)]
+    var split [2][]time.Duration
+    for i, c := range order {
+        if samples[i] <= limit {
+            split[c&1] = append(split[c&1], samples[i])
+        }
+    }
+    tstat := WelchT(split[0], split[1])
+    t.Logf("%s: t = %.2f over %d and %d samples", probe, tstat, len(split[0]), len(split[1]))
+    return tstat
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+    t.Helper()
+    b := make([]byte, n)
+    if _, err := rand.Read(b); err != nil {
+        t.Fatal(err)
+    }
+    return b
+}
+
+// flipped copies b with the byte at i changed
+func flipped(b []byte, i int) []byte {
+    c := append([]byte(nil), b...)
+    c[i] ^= 0xff
+    return c
+}
+
+// TestTimingEqual compares a secret with values differing in their first and in their last
+// byte; an early-exit comparison would return sooner for the first. Both are copied into the
+// same buffer so that only their contents differ, not where they sit in memory
+func TestTimingEqual(t *testing.T) {
+    secret := randomBytes(t, 32)
+    first, last := flipped(secret, 0), flipped(secret, len(secret)-1)
+    input := make([]byte, len(secret))
+    dudect(t, "equal", [2]func(){
+        func() { copy(input, first); Equal(secret, input) },
+        func() { copy(input, last); Equal(secret, input) },
+    })
+}
+
+// TestTimingFingerprint compares key thumbprints, as DPoP and confirmation checks do, that
+// differ in their first or in their last character
+func TestTimingFingerprint(t *testing.T) {
+    thumbprint := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"
+    first := []byte("AzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs")
+    last := []byte("NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9XA")
+    input := make([]byte, len(thumbprint))
+    dudect(t, "equal", [2]func(){
+        func() { copy(input, first); EqualString(thumbprint, string(input)) },
+        func() { copy(input, last); EqualString(thumbprint, string(input)) },
+    })
+}
+
+// TestTimingMAC checks a token's HMAC-SHA256 tag against forgeries that guessed all but its
+// last byte and none of it, through the length-hiding compare used for attacker-supplied tags
+func TestTimingMAC(t *testing.T) {
+    mac := hmac.New(sha256.New, randomBytes(t, 32))
+    mac.Write([]byte("eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiJhbGljZSJ9"))
+    tag := mac.Sum(nil)
+    guessed, random := flipped(tag, len(tag)-1), randomBytes(t, len(tag))
+    dudect(t, "equal", [2]func(){
+        func() { EqualHidingLength(tag, guessed) },
+        func() { EqualHidingLength(tag, random) },
+    })
+}
+
+// TestTimingVerify verifies a token signature against copies of the token tampered with in
+// its first and in its last byte, so the rejection does not tell where a token was altered
+func TestTimingVerify(t *testing.T) {
+    for _, alg := range []string{AlgorithmMLDSA44, AlgorithmMLDSA65, AlgorithmMLDSA87} {
+        t.Run(alg, func(t *testing.T) {
+            kp, err := GenerateSigningKeyPair(alg)
+            if err != nil {
+                t.Fatal(err)
+            }
+            token := []byte("eyJhbGciOiJNTC1EU0EtNjUifQ.eyJzdWIiOiJhbGljZSIsInJvb20iOiJhdGxhcyJ9")
+            signature, err := Sign(alg, kp.PrivateKey, token)
+            if err != nil {
+                t.Fatal(err)
+            }
+            first, last := flipped(token, 0), flipped(token, len(token)-1)
+            dudect(t, "verify:"+alg, [2]func(){
+                func() { VerifySignature(alg, kp.PublicKey, first, signature) },
+                func() { VerifySignature(alg, kp.PublicKey, last, signature) },
+            })
+        })
+    }
+}
+
+// TestTimingDecapsulate decapsulates a genuine ML-KEM-768 ciphertext and a random one, which
+// implicit rejection must handle in the same time
+func TestTimingDecapsulate(t *testing.T) {
+    kp, err := GenerateMLKEM768KeyPair()
+    if err != nil {
+        t.Fatal(err)
+    }
+    ciphertext, _, err := Encapsulate(kp.PublicKey)
+    if err != nil {
+        t.Fatal(err)
+    }
+    random := randomBytes(t, len(ciphertext))
+    dudect(t, "decapsulate", [2]func(){
+        func() { Decapsulate(kp.PrivateKey, ciphertext) },
+        func() { Decapsulate(kp.PrivateKey, random) },
+    })
+}
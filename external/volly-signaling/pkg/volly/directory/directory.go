@@ -0,0 +1,334 @@
+// Package directory keeps Volly authorization in step with a corporate directory. Users and
+// groups are provisioned over SCIM 2.0 or polled from LDAP; in a tenant the directory manages,
+// tokens are only issued to active users, with the roles their groups map to, and a user who
+// is deprovisioned or loses a role has their tokens revoked
+package directory
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sort"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/revocation"
+)
+
+// Sources of provisioned users and groups; a sync only removes what its own source created
+const (
+    SourceSCIM = "scim"
+    SourceLDAP = "ldap"
+)
+
+var (
+    ErrUserNotFound   = errors.New("directory user not found")
+    ErrGroupNotFound  = errors.New("directory group not found")
+    ErrUserNameTaken  = errors.New("userName is already provisioned")
+    ErrDeprovisioned  = errors.New("identity is deprovisioned in the tenant's directory")
+    ErrNotProvisioned = errors.New("identity is not provisioned in the tenant's directory")
+    ErrRoleNotGranted = errors.New("the directory grants the identity no such role")
+    ErrNoRoles        = errors.New("the directory grants the identity no roles")
+)
+
+// User is a provisioned identity; UserName is the identity tokens carry
+type User struct {
+    Tenant      string    `json:"tenant"`
+    ID          string    `json:"id"`
+    UserName    string    `json:"userName"`
+    ExternalID  string    `json:"externalId,omitempty"`
+    DisplayName string    `json:"displayName,omitempty"`
+    Active      bool      `json:"active"`
+    Source      string    `json:"source"`
+    CreatedAt   time.Time `json:"createdAt"`
+    UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// Group is a set of users; its DisplayName is what group role mappings name
+type Group struct {
+    Tenant      string `json:"tenant"`
+    ID          string `json:"id"`
+    DisplayName string `json:"displayName"`
+    ExternalID  string `json:"externalId,omitempty"`
+    // Members are user IDs
+    Members   []string  `json:"members"`
+    Source    string    `json:"source"`
+    CreatedAt time.Time `json:"createdAt"`
+    UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Revoker revokes tokens, as revocation.Revoker does
+type Revoker interface {
+    RevokeWhere(ctx context.Context, p revocation.Predicate) error
+}
+
+// Directory applies provisioning changes and answers which roles an identity holds
+type Directory struct {
+    store   Store
+    revoker Revoker
+    clock   clock.Clock
+    // managed maps a tenant to its group role mappings, group name to role
+    managed map[string]map[string]string
+}
+
+// New creates a directory over store managing no tenants
+func New(store Store) *Directory {
+    return &Directory{store: store, clock: clock.System, managed: make(map[string]map[string]string)}
+}
+
+// Manage puts tenant under the directory, members of a group named in groupRoles holding
+// its role
+func (d *Directory) Manage(tenant string, groupRoles map[string]string) *Directory {
+    d.managed[tenant] = groupRoles
+    return d
+}
+
+// SetRevoker sets what revokes the tokens of deprovisioned users and users who lose a role;
+// without one, their tokens last until they expire
+func (d *Directory) SetRevoker(r Revoker) *Directory {
+    d.revoker = r
+    return d
+}
+
+// SetClock sets the time source for provisioning timestamps
+func (d *Directory) SetClock(c clock.Clock) *Directory {
+    d.clock = c
+    return d
+}
+
+// Store returns the underlying store
+func (d *Directory) Store() Store {
+    return d.store
+}
+
+// Manages reports whether tenant is under the directory
+func (d *Directory) Manages(tenant string) bool {
+    _, ok := d.managed[tenant]
+    return ok
+}
+
+// Roles returns the roles identity holds in a managed tenant, sorted
+func (d *Directory) Roles(ctx context.Context, tenant, identity string) ([]string, error) {
+    u, err := d.store.FindUser(ctx, tenant, identity)
+    if errors.Is(err, ErrUserNotFound) {
+        return nil, ErrNotProvisioned
+    }
+    if err != nil {
+        return nil, err
+    }
+    if !u.Active {
+        return nil, ErrDeprovisioned
+    }
+    return d.rolesOf(ctx, u)
+}
+
+// Authorize checks identity may get a token in tenant and returns the roles to give it:
+// requested if the directory grants it, or every granted role when requested is empty.
+// Tenants the directory does not manage get requested as it is
+func (d *Directory) Authorize(ctx context.Context, tenant, identity, requested string) ([]string, error) {
+    if !d.Manages(tenant) {
+        if requested == "" {
+            return nil, nil
+        }
+        return []string{requested}, nil
+    }
+    roles, err := d.Roles(ctx, tenant, identity)
+    if err != nil {
+        return nil, err
+    }
+    if requested == "" {
+        if len(roles) == 0 {
+            return nil, ErrNoRoles
+        }
+        return roles, nil
+    }
+    for _, r := range roles {
+        if r == requested {
+            return []string{requested}, nil
+        }
+    }
+    return nil, fmt.Errorf("%w: %q", ErrRoleNotGranted, requested)
+}
+
+func (d *Directory) rolesOf(ctx context.Context, u User) ([]string, error) {
+    if !u.Active {
+        return nil, nil
+    }
+    groups, err := d.store.UserGroups(ctx, u.Tenant, u.ID)
+    if err != nil {
+        return nil, err
+    }
+    mapping := d.managed[u.Tenant]
+    seen := make(map[string]bool)
+    var roles []string
+    for _, g := range groups {
+        if r, ok := mapping[g.DisplayName]; ok && !seen[r] {
+            seen[r] = true
+            roles = append(roles, r)
+        }
+    }
+    sort.Strings(roles)
+    return roles, nil
+}
+
+// CreateUser provisions a new user; ErrUserNameTaken if another user has its userName
+func (d *Directory) CreateUser(ctx context.Context, u User) (User, error) {
+    now := d.clock.Now()
+    u.CreatedAt, u.UpdatedAt = now, now
+    if err := d.store.PutUser(ctx, u); err != nil {
+        return User{}, err
+    }
+    return u, nil
+}
+
+// UpdateUser replaces a user. Deactivating it, renaming it or its losing a role revokes the
+// tokens of the identity it had
+func (d *Directory) UpdateUser(ctx context.Context, u User) (User, error) {
+    old, err := d.store.GetUser(ctx, u.Tenant, u.ID)
+    if err != nil {
+        return User{}, err
+    }
+    before, err := d.rolesOf(ctx, old)
+    if err != nil {
+        return User{}, err
+    }
+    u.CreatedAt, u.UpdatedAt = old.CreatedAt, d.clock.Now()
+    if u.Source == "" {
+        u.Source = old.Source
+    }
+    if err := d.store.PutUser(ctx, u); err != nil {
+        return User{}, err
+    }
+    after, err := d.rolesOf(ctx, u)
+    if err != nil {
+        return User{}, err
+    }
+    if (old.Active && !u.Active) || old.UserName != u.UserName || lost(before, after) {
+        if err := d.revoke(ctx, old); err != nil {
+            return User{}, err
+        }
+    }
+    return u, nil
+}
+
+// DeleteUser deprovisions a user and revokes its tokens
+func (d *Directory) DeleteUser(ctx context.Context, tenant, id string) error {
+    u, err := d.store.GetUser(ctx, tenant, id)
+    if err != nil {
+        return err
+    }
+    if err := d.store.DeleteUser(ctx, tenant, id); err != nil {
+        return err
+    }
+    return d.revoke(ctx, u)
+}
+
+// CreateGroup provisions a new group
+func (d *Directory) CreateGroup(ctx context.Context, g Group) (Group, error) {
+    now := d.clock.Now()
+    g.CreatedAt, g.UpdatedAt = now, now
+    if err := d.changeGroup(ctx, g.Tenant, g.Members, func() error { return d.store.PutGroup(ctx, g) }); err != nil {
+        return Group{}, err
+    }
+    return d.store.GetGroup(ctx, g.Tenant, g.ID)
+}
+
+// UpdateGroup replaces a group, revoking the tokens of members who lose a role
+func (d *Directory) UpdateGroup(ctx context.Context, g Group) (Group, error) {
+    old, err := d.store.GetGroup(ctx, g.Tenant, g.ID)
+    if err != nil {
+        return Group{}, err
+    }
+    g.CreatedAt, g.UpdatedAt = old.CreatedAt, d.clock.Now()
+    if g.Source == "" {
+        g.Source = old.Source
+    }
+    affected := append(append([]string(nil), old.Members...), g.Members...)
+    if err := d.changeGroup(ctx, g.Tenant, affected, func() error { return d.store.PutGroup(ctx, g) }); err != nil {
+        return Group{}, err
+    }
+    return d.store.GetGroup(ctx, g.Tenant, g.ID)
+}
+
+// DeleteGroup removes a group, revoking the tokens of members who held a role through it
+func (d *Directory) DeleteGroup(ctx context.Context, tenant, id string) error {
+    old, err := d.store.GetGroup(ctx, tenant, id)
+    if err != nil {
+        return err
+    }
+    return d.changeGroup(ctx, tenant, old.Members, func() error { return d.store.DeleteGroup(ctx, tenant, id) })
+}
+
+// changeGroup applies change and revokes the tokens of affected users who lose a role by it.
+// Gaining one needs no revocation: older tokens just lack it
+func (d *Directory) changeGroup(ctx context.Context, tenant string, affected []string, change func() error) error {
+    users := make(map[string]User)
+    before := make(map[string][]string)
+    for _, id := range affected {
+        if _, ok := users[id]; ok {
+            continue
+        }
+        u, err := d.store.GetUser(ctx, tenant, id)
+        if errors.Is(err, ErrUserNotFound) {
+            continue
+        }
+        if err != nil {
+            return err
+        }
+        if before[id], err = d.rolesOf(ctx, u); err != nil {
+            return err
+        }
+        users[id] = u
+    }
+    if err := change(); err != nil {
+        return err
+    }
+    for id, u := range users {
+        after, err := d.rolesOf(ctx, u)
+        if err != nil {
+            return err
+        }
+        if lost(before[id], after) {
+            if err := d.revoke(ctx, u); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+func (d *Directory) revoke(ctx context.Context, u User) error {
+    if d.revoker == nil || u.UserName == "" {
+        return nil
+    }
+    return d.revoker.RevokeWhere(ctx, revocation.Predicate{Tenant: u.Tenant, Identity: u.UserName})
+}
+
+// lost reports whether a role in before is missing from after
+func lost(before, after []string) bool {
+    for _, r := range before {
+        found := false
+        for _, a := range after {
+            if a == r {
+                found = true
+                break
+            }
+        }
+        if !found {
+            return true
+        }
+    }
+    return false
+}
+
+func equal(a, b []string) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
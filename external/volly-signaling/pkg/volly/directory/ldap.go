@@ -0,0 +1,280 @@
+package directory
+
+import (
+    "context"
+    "crypto/tls"
+    "errors"
+    "fmt"
+    "log"
+    "sort"
+    "time"
+)
+
+// DefaultLDAPInterval is how often an LDAPSync polls
+const DefaultLDAPInterval = 15 * time.Minute
+
+// ldapTimeout bounds one poll
+const ldapTimeout = 2 * time.Minute
+
+var ErrEmptyDirectory = errors.New("ldap search found no users; refusing to deprovision everyone")
+
+// LDAPConfig says where a tenant's users and groups are and which attributes name them.
+// Empty fields take the defaults, which suit OpenLDAP's inetOrgPerson and groupOfNames
+type LDAPConfig struct {
+    // URL is ldaps://host or ldap://host; set StartTLS with ldap:// unless the link is trusted
+    URL      string `json:"url"`
+    StartTLS bool   `json:"startTLS,omitempty"`
+    BindDN   string `json:"bindDN,omitempty"`
+    // BindPassword is not read from config files; see BindPasswordFile in cmd/volly
+    BindPassword string `json:"-"`
+    BaseDN       string `json:"baseDN"`
+    // UserFilter selects the users to provision, "(objectClass=inetOrgPerson)" by default.
+    // Users it stops matching are deprovisioned, so exclude disabled accounts here, e.g.
+    // (!(userAccountControl:1.2.840.113556.1.4.803:=2)) in Active Directory
+    UserFilter string `json:"userFilter,omitempty"`
+    // UserNameAttribute is the identity tokens carry, "uid" by default
+    UserNameAttribute    string `json:"userNameAttribute,omitempty"`
+    DisplayNameAttribute string `json:"displayNameAttribute,omitempty"`
+    // IDAttribute is a stable user ID such as entryUUID or objectGUID; the DN by default,
+    // so a renamed entry becomes a new user
+    IDAttribute     string `json:"idAttribute,omitempty"`
+    GroupFilter     string `json:"groupFilter,omitempty"`
+    GroupAttribute  string `json:"groupAttribute,omitempty"`
+    MemberAttribute string `json:"memberAttribute,omitempty"`
+}
+
+func (c LDAPConfig) withDefaults() LDAPConfig {
+    def := func(v *string, d string) {
+        if *v == "" {
+            *v = d
+        }
+    }
+    def(&c.UserFilter, "(objectClass=inetOrgPerson)")
+    def(&c.UserNameAttribute, "uid")
+    def(&c.DisplayNameAttribute, "cn")
+    def(&c.GroupFilter, "(objectClass=groupOfNames)")
+    def(&c.GroupAttribute, "cn")
+    def(&c.MemberAttribute, "member")
+    return c
+}
+
+// SyncResult counts what a poll changed
+type SyncResult struct {
+    Created       int `json:"created"`
+    Updated       int `json:"updated"`
+    Deprovisioned int `json:"deprovisioned"`
+    Groups        int `json:"groups"`
+    // Conflicts are entries skipped because another source provisioned their userName
+    Conflicts int `json:"conflicts"`
+}
+
+// LDAPSync polls a directory into one tenant. It owns the users and groups it creates, as
+// their Source says, and leaves SCIM-provisioned ones alone
+type LDAPSync struct {
+    dir      *Directory
+    tenant   string
+    cfg      LDAPConfig
+    tls      *tls.Config
+    interval time.Duration
+}
+
+// NewLDAPSync polls cfg's directory into tenant
+func NewLDAPSync(d *Directory, tenant string, cfg LDAPConfig) *LDAPSync {
+    return &LDAPSync{dir: d, tenant: tenant, cfg: cfg.withDefaults(), interval: DefaultLDAPInterval}
+}
+
+// Tenant is the tenant polled into
+func (l *LDAPSync) Tenant() string {
+    return l.tenant
+}
+
+// SetInterval sets how often Run polls
+func (l *LDAPSync) SetInterval(d time.Duration) *LDAPSync {
+    l.interval = d
+    return l
+}
+
+// SetTLSConfig sets the TLS configuration, e.g. a private CA
+func (l *LDAPSync) SetTLSConfig(cfg *tls.Config) *LDAPSync {
+    l.tls = cfg
+    return l
+}
+
+// Sync polls once. Users are applied before groups, so a departed user is deprovisioned
+// even if the group search fails
+func (l *LDAPSync) Sync(ctx context.Context) (SyncResult, error) {
+    ctx, cancel := context.WithTimeout(ctx, ldapTimeout)
+    defer cancel()
+    conn, err := dialLDAP(ctx, l.cfg.URL, l.cfg.StartTLS, l.tls)
+    if err != nil {
+        return SyncResult{}, err
+    }
+    defer conn.Close()
+    if l.cfg.BindDN != "" {
+        if err := conn.bind(l.cfg.BindDN, l.cfg.BindPassword); err != nil {
+            return SyncResult{}, err
+        }
+    }
+
+    attrs := []string{l.cfg.UserNameAttribute, l.cfg.DisplayNameAttribute}
+    if l.cfg.IDAttribute != "" {
+        attrs = append(attrs, l.cfg.IDAttribute)
+    }
+    entries, err := conn.search(l.cfg.BaseDN, l.cfg.UserFilter, attrs)
+    if err != nil {
+        return SyncResult{}, err
+    }
+    if len(entries) == 0 {
+        return SyncResult{}, ErrEmptyDirectory
+    }
+
+    var res SyncResult
+    byDN := make(map[string]string, len(entries))
+    seen := make(map[string]bool, len(entries))
+    for _, e := range entries {
+        name := e.get(l.cfg.UserNameAttribute)
+        if name == "" {
+            continue
+        }
+        id := e.DN
+        if l.cfg.IDAttribute != "" {
+            if id = e.get(l.cfg.IDAttribute); id == "" {
+                continue
+            }
+        }
+        byDN[normalizeDN(e.DN)] = id
+        seen[id] = true
+        u := User{Tenant: l.tenant, ID: id, UserName: name, DisplayName: e.get(l.cfg.DisplayNameAttribute), ExternalID: e.DN, Active: true, Source: SourceLDAP}
+        existing, err := l.dir.store.GetUser(ctx, l.tenant, id)
+        switch {
+        case errors.Is(err, ErrUserNotFound):
+            _, err := l.dir.CreateUser(ctx, u)
+            if errors.Is(err, ErrUserNameTaken) {
+                res.Conflicts++
+                continue
+            }
+            if err != nil {
+                return res, fmt.Errorf("ldap: provisioning %s: %w", name, err)
+            }
+            res.Created++
+        case err != nil:
+            return res, err
+        case existing.UserName != u.UserName || existing.DisplayName != u.DisplayName || existing.ExternalID != u.ExternalID || !existing.Active:
+            _, err := l.dir.UpdateUser(ctx, u)
+            if errors.Is(err, ErrUserNameTaken) {
+                res.Conflicts++
+                continue
+            }
+            if err != nil {
+                return res, fmt.Errorf("ldap: updating %s: %w", name, err)
+            }
+            res.Updated++
+        }
+    }
+
+    users, err := l.dir.store.ListUsers(ctx, l.tenant)
+    if err != nil {
+        return res, err
+    }
+    for _, u := range users {
+        if u.Source == SourceLDAP && !seen[u.ID] {
+            if err := l.dir.DeleteUser(ctx, l.tenant, u.ID); err != nil && !errors.Is(err, ErrUserNotFound) {
+                return res, err
+            }
+            res.Deprovisioned++
+        }
+    }
+
+    groups, err := conn.search(l.cfg.BaseDN, l.cfg.GroupFilter, []string{l.cfg.GroupAttribute, l.cfg.MemberAttribute})
+    if err != nil {
+        return res, err
+    }
+    seenGroups := make(map[string]bool, len(groups))
+    for _, e := range groups {
+        name := e.get(l.cfg.GroupAttribute)
+        if name == "" {
+            continue
+        }
+        g := Group{Tenant: l.tenant, ID: e.DN, DisplayName: name, ExternalID: e.DN, Source: SourceLDAP}
+        for _, m := range e.values(l.cfg.MemberAttribute) {
+            if id, ok := byDN[normalizeDN(m)]; ok {
+                g.Members = append(g.Members, id)
+            }
+        }
+        sort.Strings(g.Members)
+        seenGroups[g.ID] = true
+        res.Groups++
+        old, err := l.dir.store.GetGroup(ctx, l.tenant, g.ID)
+        switch {
+        case errors.Is(err, ErrGroupNotFound):
+            _, err = l.dir.CreateGroup(ctx, g)
+        case err != nil:
+        case old.DisplayName != g.DisplayName || !equal(old.Members, g.Members):
+            _, err = l.dir.UpdateGroup(ctx, g)
+        }
+        if err != nil {
+            return res, err
+        }
+    }
+    existing, err := l.dir.store.ListGroups(ctx, l.tenant)
+    if err != nil {
+        return res, err
+    }
+    for _, g := range existing {
+        if g.Source == SourceLDAP && !seenGroups[g.ID] {
+            if err := l.dir.DeleteGroup(ctx, l.tenant, g.ID); err != nil && !errors.Is(err, ErrGroupNotFound) {
+                return res, err
+            }
+        }
+    }
+    return res, nil
+}
+
+// Run polls until ctx is cancelled
+func (l *LDAPSync) Run(ctx context.Context) {
+    ticker := time.NewTicker(l.interval)
+    defer ticker.Stop()
+    for {
+        if res, err := l.Sync(ctx); err != nil {
+            log.Printf("directory: ldap sync of tenant %s failed: %v", l.tenant, err)
+        } else if res.Created+res.Updated+res.Deprovisioned+res.Conflicts > 0 {
+            log.Printf("directory: ldap sync of tenant %s: %d created, %d updated, %d deprovisioned, %d skipped as conflicts",
+                l.tenant, res.Created, res.Updated, res.Deprovisioned, res.Conflicts)
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// normalizeDN makes DNs from entries and member values comparable: attribute types are
+// case-insensitive and spacing after separators varies between servers
+func normalizeDN(dn string) string {
+    var out []byte
+    lower := true
+    for i := 0; i < len(dn); i++ {
+        c := dn[i]
+        switch {
+        case c == '\\' && i+1 < len(dn):
+            out = append(out, c, dn[i+1])
+            i++
+            continue
+        case c == ',' || c == '+':
+            out = append(out, c)
+            for i+1 < len(dn) && dn[i+1] == ' ' {
+                i++
+            }
+            lower = true
+            continue
+        case c == '=':
+            lower = false
+        }
+        if lower && c >= 'A' && c <= 'Z' {
+            c += 'a' - 'A'
+        }
+        out = append(out, c)
+    }
+    return string(out)
+}
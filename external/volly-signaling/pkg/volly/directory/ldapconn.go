@@ -0,0 +1,650 @@
+package directory
+
+import (
+    "bufio"
+    "context"
+    "crypto/tls"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/url"
+    "strconv"
+    "strings"
+)
+
+// This is the part of LDAPv3 (RFC 4511) a directory poll needs: simple bind, StartTLS and a
+// subtree search with paged results, spoken in BER over one connection
+
+var (
+    ErrLDAPProtocol = errors.New("malformed LDAP response")
+    ErrLDAPFilter   = errors.New("malformed LDAP filter")
+)
+
+// LDAPError is a non-success LDAP result
+type LDAPError struct {
+    Op      string
+    Code    int
+    Message string
+}
+
+func (e *LDAPError) Error() string {
+    return fmt.Sprintf("ldap %s: result %d: %s", e.Op, e.Code, e.Message)
+}
+
+// BER tags
+const (
+    tagBoolean     = 0x01
+    tagInteger     = 0x02
+    tagOctetString = 0x04
+    tagEnumerated  = 0x0a
+    tagSequence    = 0x30
+    tagSet         = 0x31
+
+    tagBindRequest     = 0x60
+    tagBindResponse    = 0x61
+    tagUnbindRequest   = 0x42
+    tagSearchRequest   = 0x63
+    tagSearchEntry     = 0x64
+    tagSearchDone      = 0x65
+    tagSearchReference = 0x73
+    tagExtendedRequest = 0x77
+    tagExtendedResp    = 0x78
+    tagControls        = 0xa0
+)
+
+const (
+    oidStartTLS     = "1.3.6.1.4.1.1466.20037"
+    oidPagedResults = "1.2.840.113556.1.4.319"
+    ldapPageSize    = 500
+    maxLDAPMessage  = 16 << 20
+)
+
+// ldapEntry is a search result
+type ldapEntry struct {
+    DN    string
+    Attrs map[string][]string
+}
+
+// get returns the first value of attribute name, matched case-insensitively as LDAP does
+func (e ldapEntry) get(name string) string {
+    if v := e.values(name); len(v) > 0 {
+        return v[0]
+    }
+    return ""
+}
+
+func (e ldapEntry) values(name string) []string {
+    for k, v := range e.Attrs {
+        if strings.EqualFold(k, name) {
+            return v
+        }
+    }
+    return nil
+}
+
+type ldapConn struct {
+    conn net.Conn
+    r    *bufio.Reader
+    id   int64
+}
+
+// dialLDAP connects to an ldap:// or ldaps:// URL, upgrading ldap:// with StartTLS when
+// startTLS is set
+func dialLDAP(ctx context.Context, rawURL string, startTLS bool, tlsConfig *tls.Config) (*ldapConn, error) {
+    u, err := url.Parse(rawURL)
+    if err != nil {
+        return nil, err
+    }
+    host := u.Hostname()
+    var d net.Dialer
+    var conn net.Conn
+    switch u.Scheme {
+    case "ldaps":
+        cfg := tlsConfigFor(tlsConfig, host)
+        conn, err = (&tls.Dialer{NetDialer: &d, Config: cfg}).DialContext(ctx, "tcp", hostPort(u, "636"))
+    case "ldap":
+        conn, err = d.DialContext(ctx, "tcp", hostPort(u, "389"))
+    default:
+        return nil, fmt.Errorf("ldap: unsupported scheme %q", u.Scheme)
+    }
+    if err != nil {
+        return nil, err
+    }
+    if deadline, ok := ctx.Deadline(); ok {
+        conn.SetDeadline(deadline)
+    }
+    c := &ldapConn{conn: conn, r: bufio.NewReader(conn)}
+    if u.Scheme == "ldap" && startTLS {
+        if err := c.startTLS(tlsConfigFor(tlsConfig, host)); err != nil {
+            conn.Close()
+            return nil, err
+        }
+    }
+    return c, nil
+}
+
+func hostPort(u *url.URL, port string) string {
+    if u.Port() != "" {
+        port = u.Port()
+    }
+    return net.JoinHostPort(u.Hostname(), port)
+}
+
+func tlsConfigFor(cfg *tls.Config, host string) *tls.Config {
+    if cfg == nil {
+        cfg = &tls.Config{}
+    }
+    cfg = cfg.Clone()
+    if cfg.ServerName == "" {
+        cfg.ServerName = host
+    }
+    if cfg.MinVersion == 0 {
+        cfg.MinVersion = tls.VersionTLS12
+    }
+    return cfg
+}
+
+func (c *ldapConn) Close() error {
+    c.send(berTLV(tagUnbindRequest, nil), nil)
+    return c.conn.Close()
+}
+
+func (c *ldapConn) startTLS(cfg *tls.Config) error {
+    id, err := c.send(berTLV(tagExtendedRequest, berTLV(0x80, []byte(oidStartTLS))), nil)
+    if err != nil {
+        return err
+    }
+    op, _, err := c.read(id)
+    if err != nil {
+        return err
+    }
+    if op.tag != tagExtendedResp {
+        return ErrLDAPProtocol
+    }
+    if err := ldapResult("starttls", op.data); err != nil {
+        return err
+    }
+    tc := tls.Client(c.conn, cfg)
+    if err := tc.Handshake(); err != nil {
+        return err
+    }
+    c.conn, c.r = tc, bufio.NewReader(tc)
+    return nil
+}
+
+func (c *ldapConn) bind(dn, password string) error {
+    req := berTLV(tagBindRequest, berConcat(
+        berInt(tagInteger, 3),
+        berTLV(tagOctetString, []byte(dn)),
+        berTLV(0x80, []byte(password)),
+    ))
+    id, err := c.send(req, nil)
+    if err != nil {
+        return err
+    }
+    op, _, err := c.read(id)
+    if err != nil {
+        return err
+    }
+    if op.tag != tagBindResponse {
+        return ErrLDAPProtocol
+    }
+    return ldapResult("bind", op.data)
+}
+
+// search runs a subtree search, following paged results cookies to the end
+func (c *ldapConn) search(baseDN, filter string, attrs []string) ([]ldapEntry, error) {
+    f, err := compileFilter(filter)
+    if err != nil {
+        return nil, err
+    }
+    var attrList []byte
+    for _, a := range attrs {
+        attrList = append(attrList, berTLV(tagOctetString, []byte(a))...)
+    }
+    req := berTLV(tagSearchRequest, berConcat(
+        berTLV(tagOctetString, []byte(baseDN)),
+        berInt(tagEnumerated, 2), // wholeSubtree
+        berInt(tagEnumerated, 0), // neverDerefAliases
+        berInt(tagInteger, 0),
+        berInt(tagInteger, 0),
+        berTLV(tagBoolean, []byte{0}),
+        f,
+        berTLV(tagSequence, attrList),
+    ))
+
+    var out []ldapEntry
+    var cookie []byte
+    for {
+        control := berTLV(tagSequence, berConcat(
+            berTLV(tagOctetString, []byte(oidPagedResults)),
+            berTLV(tagOctetString, berTLV(tagSequence, berConcat(berInt(tagInteger, ldapPageSize), berTLV(tagOctetString, cookie)))),
+        ))
+        id, err := c.send(req, berTLV(tagControls, control))
+        if err != nil {
+            return nil, err
+        }
+        for {
+            op, controls, err := c.read(id)
+            if err != nil {
+                return nil, err
+            }
+            switch op.tag {
+            case tagSearchEntry:
+                e, err := parseEntry(op.data)
+                if err != nil {
+                    return nil, err
+                }
+                out = append(out, e)
+                continue
+            case tagSearchReference:
+                continue
+            case tagSearchDone:
+                if err := ldapResult("search", op.data); err != nil {
+                    return nil, err
+                }
+                cookie = pagedCookie(controls)
+            default:
+                return nil, ErrLDAPProtocol
+            }
+            break
+        }
+        if len(cookie) == 0 {
+            return out, nil
+        }
+    }
+}
+
+// send writes an LDAPMessage and returns its ID
+func (c *ldapConn) send(op, controls []byte) (int64, error) {
+    c.id++
+    msg := berTLV(tagSequence, berConcat(berInt(tagInteger, c.id), op, controls))
+    _, err := c.conn.Write(msg)
+    return c.id, err
+}
+
+// read returns the protocol op and controls of the next message, which must answer id
+func (c *ldapConn) read(id int64) (tlv, []byte, error) {
+    tag, data, err := readTLV(c.r)
+    if err != nil {
+        return tlv{}, nil, err
+    }
+    if tag != tagSequence {
+        return tlv{}, nil, ErrLDAPProtocol
+    }
+    idField, rest, err := parseTLV(data)
+    if err != nil || idField.tag != tagInteger || berToInt(idField.data) != id {
+        return tlv{}, nil, ErrLDAPProtocol
+    }
+    op, rest, err := parseTLV(rest)
+    if err != nil {
+        return tlv{}, nil, ErrLDAPProtocol
+    }
+    var controls []byte
+    if len(rest) > 0 {
+        ctl, _, err := parseTLV(rest)
+        if err == nil && ctl.tag == tagControls {
+            controls = ctl.data
+        }
+    }
+    return op, controls, nil
+}
+
+// ldapResult reads an LDAPResult: resultCode, matchedDN, diagnosticMessage
+func ldapResult(op string, data []byte) error {
+    code, rest, err := parseTLV(data)
+    if err != nil || code.tag != tagEnumerated {
+        return ErrLDAPProtocol
+    }
+    if n := berToInt(code.data); n != 0 {
+        _, rest, _ = parseTLV(rest)
+        msg, _, _ := parseTLV(rest)
+        return &LDAPError{Op: op, Code: int(n), Message: string(msg.data)}
+    }
+    return nil
+}
+
+func parseEntry(data []byte) (ldapEntry, error) {
+    dn, rest, err := parseTLV(data)
+    if err != nil {
+        return ldapEntry{}, ErrLDAPProtocol
+    }
+    e := ldapEntry{DN: string(dn.data), Attrs: make(map[string][]string)}
+    attrs, _, err := parseTLV(rest)
+    if err != nil {
+        return ldapEntry{}, ErrLDAPProtocol
+    }
+    for b := attrs.data; len(b) > 0; {
+        var a tlv
+        if a, b, err = parseTLV(b); err != nil {
+            return ldapEntry{}, ErrLDAPProtocol
+        }
+        name, vals, err := parseTLV(a.data)
+        if err != nil {
+            return ldapEntry{}, ErrLDAPProtocol
+        }
+        set, _, err := parseTLV(vals)
+        if err != nil {
+            return ldapEntry{}, ErrLDAPProtocol
+        }
+        for v := set.data; len(v) > 0; {
+            var val tlv
+            if val, v, err = parseTLV(v); err != nil {
+                return ldapEntry{}, ErrLDAPProtocol
+            }
+            e.Attrs[string(name.data)] = append(e.Attrs[string(name.data)], string(val.data))
+        }
+    }
+    return e, nil
+}
+
+// pagedCookie finds the paged results cookie among response controls
+func pagedCookie(controls []byte) []byte {
+    for b := controls; len(b) > 0; {
+        ctl, rest, err := parseTLV(b)
+        if err != nil {
+            return nil
+        }
+        b = rest
+        oid, fields, err := parseTLV(ctl.data)
+        if err != nil || string(oid.data) != oidPagedResults {
+            continue
+        }
+        for len(fields) > 0 {
+            var f tlv
+            if f, fields, err = parseTLV(fields); err != nil {
+                return nil
+            }
+            if f.tag != tagOctetString {
+                continue
+            }
+            seq, _, err := parseTLV(f.data)
+            if err != nil {
+                return nil
+            }
+            _, rest, err := parseTLV(seq.data)
+            if err != nil {
+                return nil
+            }
+            cookie, _, err := parseTLV(rest)
+            if err != nil {
+                return nil
+            }
+            return cookie.data
+        }
+    }
+    return nil
+}
+
+type tlv struct {
+    tag  byte
+    data []byte
+}
+
+func berTLV(tag byte, content []byte) []byte {
+    out := []byte{tag}
+    switch n := len(content); {
+    case n < 0x80:
+        out = append(out, byte(n))
+    case n < 0x100:
+        out = append(out, 0x81, byte(n))
+    case n < 0x10000:
+        out = append(out, 0x82, byte(n>>8), byte(n))
+    default:
+        out = append(out, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+    }
+    return append(out, content...)
+}
+
+func berConcat(parts ...[]byte) []byte {
+    var out []byte
+    for _, p := range parts {
+        out = append(out, p...)
+    }
+    return out
+}
+
+func berInt(tag byte, v int64) []byte {
+    var b []byte
+    for {
+        b = append([]byte{byte(v)}, b...)
+        v >>= 8
+        if (v == 0 && b[0]&0x80 == 0) || (v == -1 && b[0]&0x80 != 0) {
+            break
+        }
+    }
+    return berTLV(tag, b)
+}
+
+func berToInt(b []byte) int64 {
+    if len(b) == 0 || len(b) > 8 {
+        return -1
+    }
+    var v int64
+    if b[0]&0x80 != 0 {
+        v = -1
+    }
+    for _, c := range b {
+        v = v<<8 | int64(c)
+    }
+    return v
+}
+
+func parseTLV(b []byte) (tlv, []byte, error) {
+    if len(b) < 2 {
+        return tlv{}, nil, ErrLDAPProtocol
+    }
+    tag, n, off := b[0], int(b[1]), 2
+    if n&0x80 != 0 {
+        k := n & 0x7f
+        if k == 0 || k > 4 || len(b) < 2+k {
+            return tlv{}, nil, ErrLDAPProtocol
+        }
+        n = 0
+        for _, c := range b[2 : 2+k] {
+            n = n<<8 | int(c)
+        }
+        off += k
+    }
+    if n < 0 || len(b)-off < n {
+        return tlv{}, nil, ErrLDAPProtocol
+    }
+    return tlv{tag: tag, data: b[off : off+n]}, b[off+n:], nil
+}
+
+func readTLV(r *bufio.Reader) (byte, []byte, error) {
+    head := make([]byte, 2)
+    if _, err := io.ReadFull(r, head); err != nil {
+        return 0, nil, err
+    }
+    n := int(head[1])
+    if n&0x80 != 0 {
+        k := n & 0x7f
+        if k == 0 || k > 4 {
+            return 0, nil, ErrLDAPProtocol
+        }
+        lb := make([]byte, k)
+        if _, err := io.ReadFull(r, lb); err != nil {
+            return 0, nil, err
+        }
+        n = 0
+        for _, c := range lb {
+            n = n<<8 | int(c)
+        }
+    }
+    if n > maxLDAPMessage {
+        return 0, nil, ErrLDAPProtocol
+    }
+    data := make([]byte, n)
+    if _, err := io.ReadFull(r, data); err != nil {
+        return 0, nil, err
+    }
+    return head[0], data, nil
+}
+
+// compileFilter encodes an RFC 4515 string filter: and, or, not, equality, presence,
+// substrings, ordering, approximate and extensible matches
+func compileFilter(s string) ([]byte, error) {
+    s = strings.TrimSpace(s)
+    if !strings.HasPrefix(s, "(") {
+        s = "(" + s + ")"
+    }
+    out, rest, err := parseFilterNode(s, 0)
+    if err != nil {
+        return nil, err
+    }
+    if rest != "" {
+        return nil, ErrLDAPFilter
+    }
+    return out, nil
+}
+
+func parseFilterNode(s string, depth int) ([]byte, string, error) {
+    if depth > 32 || len(s) < 3 || s[0] != '(' {
+        return nil, "", ErrLDAPFilter
+    }
+    s = s[1:]
+    switch s[0] {
+    case '&', '|':
+        tag := byte(0xa0)
+        if s[0] == '|' {
+            tag = 0xa1
+        }
+        s = s[1:]
+        var parts []byte
+        for len(s) > 0 && s[0] == '(' {
+            part, rest, err := parseFilterNode(s, depth+1)
+            if err != nil {
+                return nil, "", err
+            }
+            parts, s = append(parts, part...), rest
+        }
+        if !strings.HasPrefix(s, ")") {
+            return nil, "", ErrLDAPFilter
+        }
+        return berTLV(tag, parts), s[1:], nil
+    case '!':
+        inner, rest, err := parseFilterNode(s[1:], depth+1)
+        if err != nil || !strings.HasPrefix(rest, ")") {
+            return nil, "", ErrLDAPFilter
+        }
+        return berTLV(0xa2, inner), rest[1:], nil
+    }
+
+    end := strings.IndexByte(s, ')')
+    if end < 0 {
+        return nil, "", ErrLDAPFilter
+    }
+    item, rest := s[:end], s[end+1:]
+    eq := strings.IndexByte(item, '=')
+    if eq < 1 {
+        return nil, "", ErrLDAPFilter
+    }
+    attr, value := item[:eq], item[eq+1:]
+    var op byte
+    switch attr[len(attr)-1] {
+    case '>':
+        op, attr = 0xa5, attr[:len(attr)-1]
+    case '<':
+        op, attr = 0xa6, attr[:len(attr)-1]
+    case '~':
+        op, attr = 0xa8, attr[:len(attr)-1]
+    case ':':
+        return extensibleMatch(attr[:len(attr)-1], value, rest)
+    }
+    if op != 0 {
+        v, err := unescapeFilter(value)
+        if err != nil {
+            return nil, "", err
+        }
+        return berTLV(op, berConcat(berTLV(tagOctetString, []byte(attr)), berTLV(tagOctetString, v))), rest, nil
+    }
+    if value == "*" {
+        return berTLV(0x87, []byte(attr)), rest, nil
+    }
+    if strings.Contains(value, "*") {
+        pieces := strings.Split(value, "*")
+        var subs []byte
+        for i, p := range pieces {
+            if p == "" {
+                continue
+            }
+            v, err := unescapeFilter(p)
+            if err != nil {
+                return nil, "", err
+            }
+            tag := byte(0x81) // any
+            switch i {
+            case 0:
+                tag = 0x80 // initial
+            case len(pieces) - 1:
+                tag = 0x82 // final
+            }
+            subs = append(subs, berTLV(tag, v)...)
+        }
+        return berTLV(0xa4, berConcat(berTLV(tagOctetString, []byte(attr)), berTLV(tagSequence, subs))), rest, nil
+    }
+    v, err := unescapeFilter(value)
+    if err != nil {
+        return nil, "", err
+    }
+    return berTLV(0xa3, berConcat(berTLV(tagOctetString, []byte(attr)), berTLV(tagOctetString, v))), rest, nil
+}
+
+// extensibleMatch encodes attr[:dn][:rule]:=value, as Active Directory's bitwise filters use
+func extensibleMatch(spec, value, rest string) ([]byte, string, error) {
+    parts := strings.Split(spec, ":")
+    var rule, attr string
+    dn := false
+    for i, p := range parts {
+        switch {
+        case i == 0:
+            attr = p
+        case strings.EqualFold(p, "dn"):
+            dn = true
+        default:
+            rule = p
+        }
+    }
+    v, err := unescapeFilter(value)
+    if err != nil {
+        return nil, "", err
+    }
+    var body []byte
+    if rule != "" {
+        body = append(body, berTLV(0x81, []byte(rule))...)
+    }
+    if attr != "" {
+        body = append(body, berTLV(0x82, []byte(attr))...)
+    }
+    body = append(body, berTLV(0x83, v)...)
+    if dn {
+        body = append(body, berTLV(0x84, []byte{0xff})...)
+    }
+    return berTLV(0xa9, body), rest, nil
+}
+
+// unescapeFilter decodes \XX escapes
+func unescapeFilter(s string) ([]byte, error) {
+    var out []byte
+    for i := 0; i < len(s); i++ {
+        if s[i] != '\\' {
+            if s[i] == '(' {
+                return nil, ErrLDAPFilter
+            }
+            out = append(out, s[i])
+            continue
+        }
+        if i+2 >= len(s) {
+            return nil, ErrLDAPFilter
+        }
+        b, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+        if err != nil {
+            return nil, ErrLDAPFilter
+        }
+        out = append(out, byte(b))
+        i += 2
+    }
+    return out, nil
+}
@@ -0,0 +1,648 @@
+package directory
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// SCIM schemas and media type, RFC 7643 and 7644
+const (
+    schemaUser       = "urn:ietf:params:scim:schemas:core:2.0:User"
+    schemaGroup      = "urn:ietf:params:scim:schemas:core:2.0:Group"
+    schemaList       = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+    schemaError      = "urn:ietf:params:scim:api:messages:2.0:Error"
+    schemaSPConfig   = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+    schemaResource   = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+    scimContentType  = "application/scim+json"
+    defaultPageSize  = 100
+    maxPageSize      = 1000
+    maxSCIMBodyBytes = 1 << 20
+)
+
+type tenantKey struct{}
+
+// WithTenant scopes a SCIM request to tenant; the SCIM server serves nothing without it
+func WithTenant(ctx context.Context, tenant string) context.Context {
+    return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// SCIMServer serves the SCIM 2.0 Users and Groups endpoints, as directory products such as
+// Okta and Entra ID provision against them. Authentication is the caller's business: it
+// must put the tenant in the request context with WithTenant. Filters support eq on
+// userName, externalId, displayName and id
+type SCIMServer struct {
+    dir    *Directory
+    prefix string
+}
+
+// NewSCIMServer serves d under prefix, e.g. "/scim/v2"
+func NewSCIMServer(d *Directory, prefix string) *SCIMServer {
+    return &SCIMServer{dir: d, prefix: strings.TrimSuffix(prefix, "/")}
+}
+
+type scimRef struct {
+    Value   string `json:"value"`
+    Display string `json:"display,omitempty"`
+    Ref     string `json:"$ref,omitempty"`
+}
+
+type scimMeta struct {
+    ResourceType string    `json:"resourceType"`
+    Created      time.Time `json:"created"`
+    LastModified time.Time `json:"lastModified"`
+    Location     string    `json:"location"`
+}
+
+type scimUser struct {
+    Schemas     []string  `json:"schemas"`
+    ID          string    `json:"id,omitempty"`
+    ExternalID  string    `json:"externalId,omitempty"`
+    UserName    string    `json:"userName"`
+    DisplayName string    `json:"displayName,omitempty"`
+    Active      *bool     `json:"active,omitempty"`
+    Groups      []scimRef `json:"groups,omitempty"`
+    Meta        *scimMeta `json:"meta,omitempty"`
+}
+
+type scimGroup struct {
+    Schemas     []string  `json:"schemas"`
+    ID          string    `json:"id,omitempty"`
+    ExternalID  string    `json:"externalId,omitempty"`
+    DisplayName string    `json:"displayName"`
+    Members     []scimRef `json:"members"`
+    Meta        *scimMeta `json:"meta,omitempty"`
+}
+
+type scimList struct {
+    Schemas      []string      `json:"schemas"`
+    TotalResults int           `json:"totalResults"`
+    StartIndex   int           `json:"startIndex"`
+    ItemsPerPage int           `json:"itemsPerPage"`
+    Resources    []interface{} `json:"Resources"`
+}
+
+type scimPatch struct {
+    Schemas    []string `json:"schemas"`
+    Operations []struct {
+        Op    string          `json:"op"`
+        Path  string          `json:"path,omitempty"`
+        Value json.RawMessage `json:"value,omitempty"`
+    } `json:"Operations"`
+}
+
+type scimError struct {
+    Schemas  []string `json:"schemas"`
+    Status   string   `json:"status"`
+    ScimType string   `json:"scimType,omitempty"`
+    Detail   string   `json:"detail,omitempty"`
+}
+
+// badRequest is a SCIM 400 with its scimType
+type badRequest struct {
+    scimType, detail string
+}
+
+func (e *badRequest) Error() string { return e.detail }
+
+func (s *SCIMServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    tenant, _ := r.Context().Value(tenantKey{}).(string)
+    if tenant == "" {
+        writeSCIMError(w, http.StatusUnauthorized, "", "no tenant")
+        return
+    }
+    path := strings.Trim(strings.TrimPrefix(r.URL.Path, s.prefix), "/")
+    resource, id, _ := strings.Cut(path, "/")
+    r.Body = http.MaxBytesReader(w, r.Body, maxSCIMBodyBytes)
+
+    var (
+        out    interface{}
+        status = http.StatusOK
+        err    error
+    )
+    switch {
+    case resource == "ServiceProviderConfig" && id == "" && r.Method == http.MethodGet:
+        out = s.serviceProviderConfig()
+    case resource == "ResourceTypes" && id == "" && r.Method == http.MethodGet:
+        out = s.resourceTypes()
+    case resource == "Users" && id == "":
+        switch r.Method {
+        case http.MethodGet:
+            out, err = s.listUsers(r, tenant)
+        case http.MethodPost:
+            out, err = s.createUser(r, tenant)
+            status = http.StatusCreated
+        default:
+            err = errMethod
+        }
+    case resource == "Users":
+        switch r.Method {
+        case http.MethodGet:
+            var u User
+            if u, err = s.dir.store.GetUser(r.Context(), tenant, id); err == nil {
+                out, err = s.userResource(r.Context(), u)
+            }
+        case http.MethodPut:
+            out, err = s.replaceUser(r, tenant, id)
+        case http.MethodPatch:
+            out, err = s.patchUser(r, tenant, id)
+        case http.MethodDelete:
+            err = s.dir.DeleteUser(r.Context(), tenant, id)
+            status = http.StatusNoContent
+        default:
+            err = errMethod
+        }
+    case resource == "Groups" && id == "":
+        switch r.Method {
+        case http.MethodGet:
+            out, err = s.listGroups(r, tenant)
+        case http.MethodPost:
+            out, err = s.createGroup(r, tenant)
+            status = http.StatusCreated
+        default:
+            err = errMethod
+        }
+    case resource == "Groups":
+        switch r.Method {
+        case http.MethodGet:
+            var g Group
+            if g, err = s.dir.store.GetGroup(r.Context(), tenant, id); err == nil {
+                out, err = s.groupResource(r.Context(), g)
+            }
+        case http.MethodPut:
+            out, err = s.replaceGroup(r, tenant, id)
+        case http.MethodPatch:
+            out, err = s.patchGroup(r, tenant, id)
+        case http.MethodDelete:
+            err = s.dir.DeleteGroup(r.Context(), tenant, id)
+            status = http.StatusNoContent
+        default:
+            err = errMethod
+        }
+    default:
+        writeSCIMError(w, http.StatusNotFound, "", "no such endpoint")
+        return
+    }
+
+    var bad *badRequest
+    switch {
+    case err == nil:
+    case errors.As(err, &bad):
+        writeSCIMError(w, http.StatusBadRequest, bad.scimType, bad.detail)
+        return
+    case errors.Is(err, errMethod):
+        writeSCIMError(w, http.StatusMethodNotAllowed, "", err.Error())
+        return
+    case errors.Is(err, ErrUserNotFound), errors.Is(err, ErrGroupNotFound):
+        writeSCIMError(w, http.StatusNotFound, "", err.Error())
+        return
+    case errors.Is(err, ErrUserNameTaken):
+        writeSCIMError(w, http.StatusConflict, "uniqueness", err.Error())
+        return
+    default:
+        writeSCIMError(w, http.StatusInternalServerError, "", err.Error())
+        return
+    }
+    if status == http.StatusNoContent {
+        w.WriteHeader(status)
+        return
+    }
+    w.Header().Set("Content-Type", scimContentType)
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(out)
+}
+
+var errMethod = errors.New("method not allowed")
+
+func writeSCIMError(w http.ResponseWriter, status int, scimType, detail string) {
+    w.Header().Set("Content-Type", scimContentType)
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(scimError{Schemas: []string{schemaError}, Status: strconv.Itoa(status), ScimType: scimType, Detail: detail})
+}
+
+func readSCIM(r *http.Request, v interface{}) error {
+    if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+        return &badRequest{"invalidSyntax", "malformed request body"}
+    }
+    return nil
+}
+
+func (s *SCIMServer) location(r string, id string) string {
+    return s.prefix + "/" + r + "/" + id
+}
+
+func (s *SCIMServer) userResource(ctx context.Context, u User) (scimUser, error) {
+    groups, err := s.dir.store.UserGroups(ctx, u.Tenant, u.ID)
+    if err != nil {
+        return scimUser{}, err
+    }
+    active := u.Active
+    out := scimUser{
+        Schemas:     []string{schemaUser},
+        ID:          u.ID,
+        ExternalID:  u.ExternalID,
+        UserName:    u.UserName,
+        DisplayName: u.DisplayName,
+        Active:      &active,
+        Meta:        &scimMeta{ResourceType: "User", Created: u.CreatedAt, LastModified: u.UpdatedAt, Location: s.location("Users", u.ID)},
+    }
+    for _, g := range groups {
+        out.Groups = append(out.Groups, scimRef{Value: g.ID, Display: g.DisplayName, Ref: s.location("Groups", g.ID)})
+    }
+    return out, nil
+}
+
+func (s *SCIMServer) groupResource(ctx context.Context, g Group) (scimGroup, error) {
+    out := scimGroup{
+        Schemas:     []string{schemaGroup},
+        ID:          g.ID,
+        ExternalID:  g.ExternalID,
+        DisplayName: g.DisplayName,
+        Members:     []scimRef{},
+        Meta:        &scimMeta{ResourceType: "Group", Created: g.CreatedAt, LastModified: g.UpdatedAt, Location: s.location("Groups", g.ID)},
+    }
+    for _, id := range g.Members {
+        ref := scimRef{Value: id, Ref: s.location("Users", id)}
+        if u, err := s.dir.store.GetUser(ctx, g.Tenant, id); err == nil {
+            ref.Display = u.UserName
+        }
+        out.Members = append(out.Members, ref)
+    }
+    return out, nil
+}
+
+func (s *SCIMServer) createUser(r *http.Request, tenant string) (interface{}, error) {
+    var in scimUser
+    if err := readSCIM(r, &in); err != nil {
+        return nil, err
+    }
+    if in.UserName == "" {
+        return nil, &badRequest{"invalidValue", "userName is required"}
+    }
+    u := User{Tenant: tenant, ID: newID(), UserName: in.UserName, ExternalID: in.ExternalID, DisplayName: in.DisplayName, Active: true, Source: SourceSCIM}
+    if in.Active != nil {
+        u.Active = *in.Active
+    }
+    u, err := s.dir.CreateUser(r.Context(), u)
+    if err != nil {
+        return nil, err
+    }
+    return s.userResource(r.Context(), u)
+}
+
+func (s *SCIMServer) replaceUser(r *http.Request, tenant, id string) (interface{}, error) {
+    var in scimUser
+    if err := readSCIM(r, &in); err != nil {
+        return nil, err
+    }
+    if in.UserName == "" {
+        return nil, &badRequest{"invalidValue", "userName is required"}
+    }
+    u := User{Tenant: tenant, ID: id, UserName: in.UserName, ExternalID: in.ExternalID, DisplayName: in.DisplayName, Active: true}
+    if in.Active != nil {
+        u.Active = *in.Active
+    }
+    u, err := s.dir.UpdateUser(r.Context(), u)
+    if err != nil {
+        return nil, err
+    }
+    return s.userResource(r.Context(), u)
+}
+
+func (s *SCIMServer) patchUser(r *http.Request, tenant, id string) (interface{}, error) {
+    var in scimPatch
+    if err := readSCIM(r, &in); err != nil {
+        return nil, err
+    }
+    u, err := s.dir.store.GetUser(r.Context(), tenant, id)
+    if err != nil {
+        return nil, err
+    }
+    for _, op := range in.Operations {
+        switch strings.ToLower(op.Op) {
+        case "add", "replace":
+        default:
+            return nil, &badRequest{"invalidValue", "users only support add and replace operations"}
+        }
+        values := map[string]json.RawMessage{}
+        if op.Path != "" {
+            values[op.Path] = op.Value
+        } else if err := json.Unmarshal(op.Value, &values); err != nil {
+            return nil, &badRequest{"invalidValue", "a patch without a path needs an object value"}
+        }
+        for attr, raw := range values {
+            switch strings.ToLower(attr) {
+            case "active":
+                if u.Active, err = scimBool(raw); err != nil {
+                    return nil, err
+                }
+            case "username":
+                if err := json.Unmarshal(raw, &u.UserName); err != nil || u.UserName == "" {
+                    return nil, &badRequest{"invalidValue", "userName must be a string"}
+                }
+            case "displayname":
+                if err := json.Unmarshal(raw, &u.DisplayName); err != nil {
+                    return nil, &badRequest{"invalidValue", "displayName must be a string"}
+                }
+            case "externalid":
+                if err := json.Unmarshal(raw, &u.ExternalID); err != nil {
+                    return nil, &badRequest{"invalidValue", "externalId must be a string"}
+                }
+            }
+            // Attributes Volly does not keep, such as emails or name, are accepted and ignored
+        }
+    }
+    if u, err = s.dir.UpdateUser(r.Context(), u); err != nil {
+        return nil, err
+    }
+    return s.userResource(r.Context(), u)
+}
+
+// scimBool reads a boolean, which some clients send as the string "False"
+func scimBool(raw json.RawMessage) (bool, error) {
+    var b bool
+    if err := json.Unmarshal(raw, &b); err == nil {
+        return b, nil
+    }
+    var str string
+    if err := json.Unmarshal(raw, &str); err == nil {
+        if b, err := strconv.ParseBool(strings.ToLower(str)); err == nil {
+            return b, nil
+        }
+    }
+    return false, &badRequest{"invalidValue", "active must be a boolean"}
+}
+
+func (s *SCIMServer) createGroup(r *http.Request, tenant string) (interface{}, error) {
+    var in scimGroup
+    if err := readSCIM(r, &in); err != nil {
+        return nil, err
+    }
+    if in.DisplayName == "" {
+        return nil, &badRequest{"invalidValue", "displayName is required"}
+    }
+    g := Group{Tenant: tenant, ID: newID(), DisplayName: in.DisplayName, ExternalID: in.ExternalID, Members: refValues(in.Members), Source: SourceSCIM}
+    g, err := s.dir.CreateGroup(r.Context(), g)
+    if err != nil {
+        return nil, err
+    }
+    return s.groupResource(r.Context(), g)
+}
+
+func (s *SCIMServer) replaceGroup(r *http.Request, tenant, id string) (interface{}, error) {
+    var in scimGroup
+    if err := readSCIM(r, &in); err != nil {
+        return nil, err
+    }
+    if in.DisplayName == "" {
+        return nil, &badRequest{"invalidValue", "displayName is required"}
+    }
+    g, err := s.dir.UpdateGroup(r.Context(), Group{Tenant: tenant, ID: id, DisplayName: in.DisplayName, ExternalID: in.ExternalID, Members: refValues(in.Members)})
+    if err != nil {
+        return nil, err
+    }
+    return s.groupResource(r.Context(), g)
+}
+
+// memberPath matches the members[value eq "id"] path of a single member removal
+var memberPath = regexp.MustCompile(`^(?i)members\[value eq "((?:[^"\\]|\\.)*)"\]$`)
+
+func (s *SCIMServer) patchGroup(r *http.Request, tenant, id string) (interface{}, error) {
+    var in scimPatch
+    if err := readSCIM(r, &in); err != nil {
+        return nil, err
+    }
+    g, err := s.dir.store.GetGroup(r.Context(), tenant, id)
+    if err != nil {
+        return nil, err
+    }
+    for _, op := range in.Operations {
+        kind := strings.ToLower(op.Op)
+        if m := memberPath.FindStringSubmatch(op.Path); m != nil && kind == "remove" {
+            g.Members = without(g.Members, unquote(m[1]))
+            continue
+        }
+        values := map[string]json.RawMessage{}
+        if op.Path != "" {
+            values[op.Path] = op.Value
+        } else if err := json.Unmarshal(op.Value, &values); err != nil {
+            return nil, &badRequest{"invalidValue", "a patch without a path needs an object value"}
+        }
+        for attr, raw := range values {
+            switch strings.ToLower(attr) {
+            case "members":
+                var refs []scimRef
+                if len(raw) > 0 {
+                    if err := json.Unmarshal(raw, &refs); err != nil {
+                        return nil, &badRequest{"invalidValue", "members must be a list of {value}"}
+                    }
+                }
+                switch kind {
+                case "add":
+                    g.Members = append(g.Members, refValues(refs)...)
+                case "replace":
+                    g.Members = refValues(refs)
+                case "remove":
+                    if len(refs) == 0 {
+                        g.Members = nil
+                    }
+                    for _, ref := range refs {
+                        g.Members = without(g.Members, ref.Value)
+                    }
+                default:
+                    return nil, &badRequest{"invalidValue", "unknown operation " + op.Op}
+                }
+            case "displayname", "externalid":
+                var v string
+                if kind == "remove" || json.Unmarshal(raw, &v) != nil {
+                    return nil, &badRequest{"invalidValue", attr + " must be a string"}
+                }
+                if strings.ToLower(attr) == "displayname" {
+                    g.DisplayName = v
+                } else {
+                    g.ExternalID = v
+                }
+            default:
+                return nil, &badRequest{"invalidPath", "unsupported path " + attr}
+            }
+        }
+    }
+    if g, err = s.dir.UpdateGroup(r.Context(), g); err != nil {
+        return nil, err
+    }
+    return s.groupResource(r.Context(), g)
+}
+
+func refValues(refs []scimRef) []string {
+    out := make([]string, 0, len(refs))
+    for _, r := range refs {
+        out = append(out, r.Value)
+    }
+    return out
+}
+
+// filter is an attribute eq "value" filter
+type filter struct {
+    attr, value string
+}
+
+var filterExpr = regexp.MustCompile(`^\s*(\w+)\s+(?i:eq)\s+"((?:[^"\\]|\\.)*)"\s*$`)
+
+func parseFilter(s string, attrs ...string) (*filter, error) {
+    if s == "" {
+        return nil, nil
+    }
+    m := filterExpr.FindStringSubmatch(s)
+    if m == nil {
+        return nil, &badRequest{"invalidFilter", "only attribute eq \"value\" filters are supported"}
+    }
+    for _, a := range attrs {
+        if strings.EqualFold(a, m[1]) {
+            return &filter{attr: a, value: unquote(m[2])}, nil
+        }
+    }
+    return nil, &badRequest{"invalidFilter", "cannot filter on " + m[1]}
+}
+
+func unquote(s string) string {
+    return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(s)
+}
+
+// page reads startIndex and count, 1-based as SCIM counts
+func page(r *http.Request, total int) (start, end int) {
+    start, _ = strconv.Atoi(r.URL.Query().Get("startIndex"))
+    if start < 1 {
+        start = 1
+    }
+    count := defaultPageSize
+    if c, err := strconv.Atoi(r.URL.Query().Get("count")); err == nil && c >= 0 {
+        count = c
+    }
+    if count > maxPageSize {
+        count = maxPageSize
+    }
+    from := start - 1
+    if from > total {
+        from = total
+    }
+    end = from + count
+    if end > total {
+        end = total
+    }
+    return start, end
+}
+
+func (s *SCIMServer) listUsers(r *http.Request, tenant string) (interface{}, error) {
+    f, err := parseFilter(r.URL.Query().Get("filter"), "userName", "externalId", "displayName", "id")
+    if err != nil {
+        return nil, err
+    }
+    users, err := s.dir.store.ListUsers(r.Context(), tenant)
+    if err != nil {
+        return nil, err
+    }
+    if f != nil {
+        kept := users[:0]
+        for _, u := range users {
+            v := map[string]string{"userName": u.UserName, "externalId": u.ExternalID, "displayName": u.DisplayName, "id": u.ID}[f.attr]
+            // userName is case-insensitive in SCIM
+            if v == f.value || (f.attr == "userName" && strings.EqualFold(v, f.value)) {
+                kept = append(kept, u)
+            }
+        }
+        users = kept
+    }
+    start, end := page(r, len(users))
+    list := scimList{Schemas: []string{schemaList}, TotalResults: len(users), StartIndex: start, Resources: []interface{}{}}
+    if from := start - 1; from < end {
+        for _, u := range users[from:end] {
+            res, err := s.userResource(r.Context(), u)
+            if err != nil {
+                return nil, err
+            }
+            list.Resources = append(list.Resources, res)
+        }
+    }
+    list.ItemsPerPage = len(list.Resources)
+    return list, nil
+}
+
+func (s *SCIMServer) listGroups(r *http.Request, tenant string) (interface{}, error) {
+    f, err := parseFilter(r.URL.Query().Get("filter"), "displayName", "externalId", "id")
+    if err != nil {
+        return nil, err
+    }
+    groups, err := s.dir.store.ListGroups(r.Context(), tenant)
+    if err != nil {
+        return nil, err
+    }
+    if f != nil {
+        kept := groups[:0]
+        for _, g := range groups {
+            if map[string]string{"displayName": g.DisplayName, "externalId": g.ExternalID, "id": g.ID}[f.attr] == f.value {
+                kept = append(kept, g)
+            }
+        }
+        groups = kept
+    }
+    // excludedAttributes=members is what clients send to skip large member lists
+    skipMembers := strings.Contains(r.URL.Query().Get("excludedAttributes"), "members")
+    start, end := page(r, len(groups))
+    list := scimList{Schemas: []string{schemaList}, TotalResults: len(groups), StartIndex: start, Resources: []interface{}{}}
+    if from := start - 1; from < end {
+        for _, g := range groups[from:end] {
+            if skipMembers {
+                g.Members = nil
+            }
+            res, err := s.groupResource(r.Context(), g)
+            if err != nil {
+                return nil, err
+            }
+            list.Resources = append(list.Resources, res)
+        }
+    }
+    list.ItemsPerPage = len(list.Resources)
+    return list, nil
+}
+
+func (s *SCIMServer) serviceProviderConfig() interface{} {
+    supported := func(b bool) map[string]bool { return map[string]bool{"supported": b} }
+    return map[string]interface{}{
+        "schemas":        []string{schemaSPConfig},
+        "patch":          supported(true),
+        "bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+        "filter":         map[string]interface{}{"supported": true, "maxResults": maxPageSize},
+        "changePassword": supported(false),
+        "sort":           supported(false),
+        "etag":           supported(false),
+        "authenticationSchemes": []map[string]string{{
+            "type": "oauthbearertoken", "name": "Bearer", "description": "The tenant API key as key:secret",
+        }},
+    }
+}
+
+func (s *SCIMServer) resourceTypes() interface{} {
+    rt := func(name, endpoint, schema string) map[string]interface{} {
+        return map[string]interface{}{"schemas": []string{schemaResource}, "id": name, "name": name, "endpoint": "/" + endpoint, "schema": schema}
+    }
+    return scimList{
+        Schemas:      []string{schemaList},
+        TotalResults: 2,
+        StartIndex:   1,
+        ItemsPerPage: 2,
+        Resources:    []interface{}{rt("User", "Users", schemaUser), rt("Group", "Groups", schemaGroup)},
+    }
+}
+
+func newID() string {
+    b := make([]byte, 16)
+    rand.Read(b)
+    return hex.EncodeToString(b)
+}
@@ -0,0 +1,192 @@
+package directory
+
+import (
+    "context"
+    "sort"
+    "sync"
+)
+
+// Store keeps provisioned users and groups. IDs are unique per tenant, as are userNames
+type Store interface {
+    // PutUser creates or replaces a user; ErrUserNameTaken when another user has its userName
+    PutUser(ctx context.Context, u User) error
+    GetUser(ctx context.Context, tenant, id string) (User, error)
+    // FindUser looks a user up by userName
+    FindUser(ctx context.Context, tenant, userName string) (User, error)
+    // ListUsers returns a tenant's users ordered by ID
+    ListUsers(ctx context.Context, tenant string) ([]User, error)
+    // DeleteUser removes a user and its group memberships
+    DeleteUser(ctx context.Context, tenant, id string) error
+
+    // PutGroup creates or replaces a group with its members. Members that are not users of
+    // the tenant are dropped
+    PutGroup(ctx context.Context, g Group) error
+    GetGroup(ctx context.Context, tenant, id string) (Group, error)
+    // ListGroups returns a tenant's groups ordered by ID
+    ListGroups(ctx context.Context, tenant string) ([]Group, error)
+    DeleteGroup(ctx context.Context, tenant, id string) error
+    // UserGroups returns the groups a user is a member of, ordered by ID; their Members may
+    // be left out
+    UserGroups(ctx context.Context, tenant, userID string) ([]Group, error)
+}
+
+// MemoryStore is a Store for a single process
+type MemoryStore struct {
+    mu     sync.Mutex
+    users  map[string]User
+    groups map[string]Group
+}
+
+func NewMemoryStore() *MemoryStore {
+    return &MemoryStore{users: make(map[string]User), groups: make(map[string]Group)}
+}
+
+func key(tenant, id string) string {
+    return tenant + "\x00" + id
+}
+
+func (m *MemoryStore) PutUser(ctx context.Context, u User) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for _, other := range m.users {
+        if other.Tenant == u.Tenant && other.UserName == u.UserName && other.ID != u.ID {
+            return ErrUserNameTaken
+        }
+    }
+    m.users[key(u.Tenant, u.ID)] = u
+    return nil
+}
+
+func (m *MemoryStore) GetUser(ctx context.Context, tenant, id string) (User, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    u, ok := m.users[key(tenant, id)]
+    if !ok {
+        return User{}, ErrUserNotFound
+    }
+    return u, nil
+}
+
+func (m *MemoryStore) FindUser(ctx context.Context, tenant, userName string) (User, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for _, u := range m.users {
+        if u.Tenant == tenant && u.UserName == userName {
+            return u, nil
+        }
+    }
+    return User{}, ErrUserNotFound
+}
+
+func (m *MemoryStore) ListUsers(ctx context.Context, tenant string) ([]User, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    var out []User
+    for _, u := range m.users {
+        if u.Tenant == tenant {
+            out = append(out, u)
+        }
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+    return out, nil
+}
+
+func (m *MemoryStore) DeleteUser(ctx context.Context, tenant, id string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    k := key(tenant, id)
+    if _, ok := m.users[k]; !ok {
+        return ErrUserNotFound
+    }
+    delete(m.users, k)
+    for gk, g := range m.groups {
+        if g.Tenant == tenant {
+            g.Members = without(g.Members, id)
+            m.groups[gk] = g
+        }
+    }
+    return nil
+}
+
+func (m *MemoryStore) PutGroup(ctx context.Context, g Group) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    members := make([]string, 0, len(g.Members))
+    seen := make(map[string]bool, len(g.Members))
+    for _, id := range g.Members {
+        if _, ok := m.users[key(g.Tenant, id)]; ok && !seen[id] {
+            seen[id] = true
+            members = append(members, id)
+        }
+    }
+    sort.Strings(members)
+    g.Members = members
+    m.groups[key(g.Tenant, g.ID)] = g
+    return nil
+}
+
+func (m *MemoryStore) GetGroup(ctx context.Context, tenant, id string) (Group, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    g, ok := m.groups[key(tenant, id)]
+    if !ok {
+        return Group{}, ErrGroupNotFound
+    }
+    g.Members = append([]string(nil), g.Members...)
+    return g, nil
+}
+
+func (m *MemoryStore) ListGroups(ctx context.Context, tenant string) ([]Group, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    var out []Group
+    for _, g := range m.groups {
+        if g.Tenant == tenant {
+            g.Members = append([]string(nil), g.Members...)
+            out = append(out, g)
+        }
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+    return out, nil
+}
+
+func (m *MemoryStore) DeleteGroup(ctx context.Context, tenant, id string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    k := key(tenant, id)
+    if _, ok := m.groups[k]; !ok {
+        return ErrGroupNotFound
+    }
+    delete(m.groups, k)
+    return nil
+}
+
+func (m *MemoryStore) UserGroups(ctx context.Context, tenant, userID string) ([]Group, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    var out []Group
+    for _, g := range m.groups {
+        if g.Tenant != tenant {
+            continue
+        }
+        for _, id := range g.Members {
+            if id == userID {
+                g.Members = append([]string(nil), g.Members...)
+                out = append(out, g)
+                break
+            }
+        }
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+    return out, nil
+}
+
+func without(ids []string, id string) []string {
+    out := ids[:0:0]
+    for _, v := range ids {
+        if v != id {
+            out = append(out, v)
+        }
+    }
+    return out
+}
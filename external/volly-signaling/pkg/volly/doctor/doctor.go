@@ -0,0 +1,151 @@
+// Package doctor runs deployment diagnostics and reports what is wrong in terms an operator
+// can act on. Each Check returns findings: what was looked at, whether it is healthy and,
+// when it is not, what to change. The deployment-specific checks live with the binary that
+// knows the configuration; this package holds the runner and the checks that need nothing
+// but the machine, such as clock skew and crypto availability
+package doctor
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+)
+
+// Finding statuses, from best to worst
+const (
+    StatusOK   = "ok"
+    StatusWarn = "warn"
+    StatusFail = "fail"
+)
+
+// DefaultCheckTimeout bounds each check, so one unreachable dependency cannot hang the report
+const DefaultCheckTimeout = 10 * time.Second
+
+// Finding is one observation
+type Finding struct {
+    Check  string `json:"check"`
+    Status string `json:"status"`
+    Detail string `json:"detail"`
+    // Fix says what to change; empty for healthy findings
+    Fix string `json:"fix,omitempty"`
+}
+
+// OK, Warn and Fail build findings for check
+func OK(check, detail string) Finding { return Finding{Check: check, Status: StatusOK, Detail: detail} }
+
+func Warn(check, detail, fix string) Finding {
+    return Finding{Check: check, Status: StatusWarn, Detail: detail, Fix: fix}
+}
+
+func Fail(check, detail, fix string) Finding {
+    return Finding{Check: check, Status: StatusFail, Detail: detail, Fix: fix}
+}
+
+// Check is one diagnostic
+type Check struct {
+    Name string
+    Run  func(ctx context.Context) []Finding
+}
+
+// Report is the outcome of a run
+type Report struct {
+    StartedAt time.Time     `json:"startedAt"`
+    Elapsed   time.Duration `json:"elapsed"`
+    Findings  []Finding     `json:"findings"`
+    Warnings  int           `json:"warnings"`
+    Failures  int           `json:"failures"`
+}
+
+// Healthy reports whether nothing failed
+func (r *Report) Healthy() bool { return r.Failures == 0 }
+
+// Add appends findings and counts them
+func (r *Report) Add(findings ...Finding) {
+    for _, f := range findings {
+        switch f.Status {
+        case StatusWarn:
+            r.Warnings++
+        case StatusFail:
+            r.Failures++
+        }
+        r.Findings = append(r.Findings, f)
+    }
+}
+
+// Run runs checks in order, each under timeout. A check that runs out of time or panics
+// fails rather than ending the run
+func Run(ctx context.Context, timeout time.Duration, checks ...Check) *Report {
+    r := &Report{StartedAt: time.Now()}
+    for _, c := range checks {
+        r.Add(run(ctx, timeout, c)...)
+    }
+    r.Elapsed = time.Since(r.StartedAt)
+    return r
+}
+
+func run(ctx context.Context, timeout time.Duration, c Check) []Finding {
+    ctx, cancel := context.WithTimeout(ctx, timeout)
+    defer cancel()
+
+    done := make(chan []Finding, 1)
+    go func() {
+        defer func() {
+            if p := recover(); p != nil {
+                done <- []Finding{Fail(c.Name, fmt.Sprintf("check panicked: %v", p), "report this as a bug")}
+            }
+        }()
+        done <- c.Run(ctx)
+    }()
+    select {
+    case findings := <-done:
+        return findings
+    case <-ctx.Done():
+        return []Finding{Fail(c.Name, "check did not finish within "+timeout.String(),
+            "the dependency it checks is hanging; look for a firewall dropping packets or an overloaded backend")}
+    }
+}
+
+// PQAlgorithms generates, signs and encapsulates with every supported algorithm through
+// the active crypto provider, catching HSM or cgo providers built without one of them
+func PQAlgorithms() Check {
+    const name = "pq-algorithms"
+    return Check{Name: name, Run: func(ctx context.Context) []Finding {
+        provider := crypto.CurrentProvider()
+        fix := "rebuild with a provider that supports it, or select another with crypto.UseProvider"
+        var findings []Finding
+        message := []byte("volly doctor")
+        for _, alg := range []string{crypto.AlgorithmMLDSA44, crypto.AlgorithmMLDSA65, crypto.AlgorithmMLDSA87, crypto.AlgorithmEd25519} {
+            kp, err := provider.GenerateKeyPair(alg)
+            if err == nil {
+                var sig []byte
+                if sig, err = provider.Sign(alg, kp.PrivateKey, message); err == nil {
+                    err = provider.Verify(alg, kp.PublicKey, message, sig)
+                }
+            }
+            if err != nil {
+                findings = append(findings, Fail(name, fmt.Sprintf("%s signing through provider %s: %v", alg, provider.Name(), err), fix))
+                continue
+            }
+            findings = append(findings, OK(name, fmt.Sprintf("%s signs and verifies through provider %s", alg, provider.Name())))
+        }
+        for _, alg := range []string{crypto.AlgorithmMLKEM768, crypto.AlgorithmMLKEM1024} {
+            kp, err := provider.GenerateKeyPair(alg)
+            if err == nil {
+                var ct, sent, got []byte
+                if ct, sent, err = provider.Encapsulate(alg, kp.PublicKey); err == nil {
+                    if got, err = provider.Decapsulate(alg, kp.PrivateKey, ct); err == nil && !crypto.Equal(sent, got) {
+                        err = fmt.Errorf("decapsulated secret does not match")
+                    }
+                }
+            }
+            if err != nil {
+                findings = append(findings, Fail(name, fmt.Sprintf("%s key exchange through provider %s: %v", alg, provider.Name(), err), fix))
+                continue
+            }
+            findings = append(findings, OK(name, fmt.Sprintf("%s encapsulates and decapsulates through provider %s", alg, provider.Name())))
+        }
+        return findings
+    }}
+}
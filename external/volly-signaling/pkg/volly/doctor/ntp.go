@@ -0,0 +1,90 @@
+package doctor
+
+import (
+    "context"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "net"
+    "time"
+)
+
+// DefaultNTPServer is queried when no server is configured
+const DefaultNTPServer = "pool.ntp.org:123"
+
+var ErrBadNTPResponse = errors.New("malformed NTP response")
+
+// ntpEpochOffset is the seconds between the NTP epoch, 1900, and the Unix epoch
+const ntpEpochOffset = 2208988800
+
+// QueryNTP asks an NTP server, host:port, for the local clock's offset: positive when the
+// local clock is behind. It is a single SNTP exchange, good to a few milliseconds on a
+// quiet network, which is far finer than token validity needs
+func QueryNTP(ctx context.Context, server string) (offset, rtt time.Duration, err error) {
+    var d net.Dialer
+    conn, err := d.DialContext(ctx, "udp", server)
+    if err != nil {
+        return 0, 0, err
+    }
+    defer conn.Close()
+    if deadline, ok := ctx.Deadline(); ok {
+        conn.SetDeadline(deadline)
+    }
+
+    req := make([]byte, 48)
+    // Leap indicator 0, version 4, mode 3 (client)
+    req[0] = 0x23
+    sent := time.Now()
+    putNTPTime(req[40:], sent)
+    if _, err := conn.Write(req); err != nil {
+        return 0, 0, err
+    }
+    resp := make([]byte, 48)
+    n, err := conn.Read(resp)
+    received := time.Now()
+    if err != nil {
+        return 0, 0, err
+    }
+    if n < 48 || resp[0]&0x07 != 4 || resp[1] == 0 || string(resp[24:32]) != string(req[40:48]) {
+        return 0, 0, ErrBadNTPResponse
+    }
+    serverReceived := ntpTime(resp[32:])
+    serverSent := ntpTime(resp[40:])
+    offset = (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2
+    rtt = received.Sub(sent) - serverSent.Sub(serverReceived)
+    return offset, rtt, nil
+}
+
+func ntpTime(b []byte) time.Time {
+    secs := int64(binary.BigEndian.Uint32(b)) - ntpEpochOffset
+    frac := int64(binary.BigEndian.Uint32(b[4:]))
+    return time.Unix(secs, frac*1e9>>32)
+}
+
+func putNTPTime(b []byte, t time.Time) {
+    binary.BigEndian.PutUint32(b, uint32(t.Unix()+ntpEpochOffset))
+    binary.BigEndian.PutUint32(b[4:], uint32(int64(t.Nanosecond())<<32/1e9))
+}
+
+// ClockSkew compares the local clock with server and fails past max. Tokens carry nbf and
+// exp, so a clock drifting toward the verifiers' leeway rejects fresh tokens or admits
+// expired ones
+func ClockSkew(server string, max time.Duration) Check {
+    const name = "clock-skew"
+    return Check{Name: name, Run: func(ctx context.Context) []Finding {
+        offset, rtt, err := QueryNTP(ctx, server)
+        if err != nil {
+            return []Finding{Warn(name, fmt.Sprintf("could not query %s: %v", server, err),
+                "allow outbound UDP 123 to an NTP server, or set doctor.ntpServer to one that is reachable")}
+        }
+        abs := offset
+        if abs < 0 {
+            abs = -abs
+        }
+        detail := fmt.Sprintf("local clock is %s off %s (round trip %s)", offset.Round(time.Millisecond), server, rtt.Round(time.Millisecond))
+        if abs > max {
+            return []Finding{Fail(name, detail, "run an NTP daemon such as chrony on this host; as skew nears the verifiers' leeway tokens fail nbf and exp checks")}
+        }
+        return []Finding{OK(name, detail)}
+    }}
+}
@@ -0,0 +1,133 @@
+package dpop
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "math/big"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+)
+
+var ErrUnsupportedKey = errors.New("unsupported DPoP key type")
+
+// JWK is the public key embedded in a DPoP proof header
+type JWK struct {
+    Kty string `json:"kty"`
+    Crv string `json:"crv,omitempty"`
+    X   string `json:"x,omitempty"`
+    Y   string `json:"y,omitempty"`
+
+    // AKP keys (ML-DSA) carry the algorithm and raw public key
+    Alg string `json:"alg,omitempty"`
+    Pub string `json:"pub,omitempty"`
+}
+
+// NewJWK returns the JWK of a raw Ed25519 or ML-DSA public key, as crypto names the algorithms
+func NewJWK(algorithm string, publicKey []byte) (*JWK, error) {
+    switch {
+    case algorithm == crypto.AlgorithmEd25519:
+        return &JWK{Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(publicKey)}, nil
+    case isMLDSA(algorithm):
+        return &JWK{Kty: "AKP", Alg: algorithm, Pub: base64.RawURLEncoding.EncodeToString(publicKey)}, nil
+    }
+    return nil, ErrUnsupportedKey
+}
+
+// Algorithm names the key's algorithm as crypto and cnf claims do: Ed25519, ES256 or ML-DSA-*
+func (k *JWK) Algorithm() string {
+    switch {
+    case k.Kty == "OKP" && k.Crv == "Ed25519":
+        return crypto.AlgorithmEd25519
+    case k.Kty == "EC" && k.Crv == "P-256":
+        return "ES256"
+    case k.Kty == "AKP":
+        return k.Alg
+    }
+    return ""
+}
+
+// Thumbprint computes the RFC 7638 thumbprint used in cnf.jkt
+func (k *JWK) Thumbprint() (string, error) {
+    // Required members only, in lexicographic order
+    var members interface{}
+    switch k.Kty {
+    case "OKP":
+        members = struct {
+            Crv string `json:"crv"`
+            Kty string `json:"kty"`
+            X   string `json:"x"`
+        }{k.Crv, k.Kty, k.X}
+    case "EC":
+        members = struct {
+            Crv string `json:"crv"`
+            Kty string `json:"kty"`
+            X   string `json:"x"`
+            Y   string `json:"y"`
+        }{k.Crv, k.Kty, k.X, k.Y}
+    case "AKP":
+        members = struct {
+            Alg string `json:"alg"`
+            Kty string `json:"kty"`
+            Pub string `json:"pub"`
+        }{k.Alg, k.Kty, k.Pub}
+    default:
+        return "", ErrUnsupportedKey
+    }
+
+    data, err := json.Marshal(members)
+    if err != nil {
+        return "", err
+    }
+    sum := sha256.Sum256(data)
+    return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// verify checks a JWS signature made with this key under the given alg
+func (k *JWK) verify(alg string, signingInput, signature []byte) error {
+    switch {
+    case alg == "EdDSA" && k.Kty == "OKP" && k.Crv == "Ed25519":
+        pub, err := base64.RawURLEncoding.DecodeString(k.X)
+        if err != nil {
+            return ErrUnsupportedKey
+        }
+        return crypto.VerifySignature(crypto.AlgorithmEd25519, pub, signingInput, signature)
+
+    case alg == "ES256" && k.Kty == "EC" && k.Crv == "P-256":
+        x, errX := base64.RawURLEncoding.DecodeString(k.X)
+        y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+        if errX != nil || errY != nil || len(signature) != 64 {
+            return crypto.ErrInvalidSignature
+        }
+        pub := &ecdsa.PublicKey{
+            Curve: elliptic.P256(),
+            X:     new(big.Int).SetBytes(x),
+            Y:     new(big.Int).SetBytes(y),
+        }
+        if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+            return ErrUnsupportedKey
+        }
+        digest := sha256.Sum256(signingInput)
+        r := new(big.Int).SetBytes(signature[:32])
+        s := new(big.Int).SetBytes(signature[32:])
+        if !ecdsa.Verify(pub, digest[:], r, s) {
+            return crypto.ErrInvalidSignature
+        }
+        return nil
+
+    case k.Kty == "AKP" && k.Alg == alg && isMLDSA(alg):
+        pub, err := base64.RawURLEncoding.DecodeString(k.Pub)
+        if err != nil {
+            return ErrUnsupportedKey
+        }
+        return crypto.VerifySignature(alg, pub, signingInput, signature)
+    }
+    return ErrUnsupportedKey
+}
+
+func isMLDSA(alg string) bool {
+    return alg == crypto.AlgorithmMLDSA44 || alg == crypto.AlgorithmMLDSA65 || alg == crypto.AlgorithmMLDSA87
+}
@@ -0,0 +1,87 @@
+package dpop
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "strings"
+)
+
+type contextKey struct{}
+
+// KeyBinding checks that the access token is bound (cnf.jkt) to the proof key thumbprint
+type KeyBinding func(ctx context.Context, accessToken, thumbprint string) error
+
+// Middleware requires a DPoP-bound Authorization header on every request to next
+func (v *Validator) Middleware(binding KeyBinding, next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if v.nonces != nil {
+            w.Header().Set("DPoP-Nonce", v.nonces.Current())
+        }
+
+        scheme, accessToken, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+        if !strings.EqualFold(scheme, "DPoP") || accessToken == "" {
+            w.Header().Set("WWW-Authenticate", `DPoP error="invalid_token"`)
+            http.Error(w, "DPoP authorization required", http.StatusUnauthorized)
+            return
+        }
+
+        proof, err := v.Validate(r, accessToken)
+        if err == nil && binding != nil {
+            err = binding(r.Context(), accessToken, proof.Thumbprint)
+        }
+        if err != nil {
+            writeProofError(w, err)
+            return
+        }
+
+        next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, proof)))
+    })
+}
+
+// ProofMiddleware checks the DPoP proof of requests to next that authenticate some other
+// way, such as HTTP basic auth at a token endpoint. A request authorized with the DPoP
+// scheme has its proof bound to that access token, and checked against its key by binding
+// when set; a request without a proof is refused when required and passed on otherwise
+func (v *Validator) ProofMiddleware(required bool, binding KeyBinding, next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if v.nonces != nil {
+            w.Header().Set("DPoP-Nonce", v.nonces.Current())
+        }
+
+        scheme, accessToken, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+        if !strings.EqualFold(scheme, "DPoP") {
+            accessToken = ""
+            if !required && len(r.Header.Values("DPoP")) == 0 {
+                next.ServeHTTP(w, r)
+                return
+            }
+        }
+
+        proof, err := v.Validate(r, accessToken)
+        if err == nil && accessToken != "" && binding != nil {
+            err = binding(r.Context(), accessToken, proof.Thumbprint)
+        }
+        if err != nil {
+            writeProofError(w, err)
+            return
+        }
+
+        next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, proof)))
+    })
+}
+
+func writeProofError(w http.ResponseWriter, err error) {
+    code := "invalid_dpop_proof"
+    if errors.Is(err, ErrNonceRequired) {
+        code = "use_dpop_nonce"
+    }
+    w.Header().Set("WWW-Authenticate", `DPoP error="`+code+`"`)
+    http.Error(w, err.Error(), http.StatusUnauthorized)
+}
+
+// FromContext returns the validated proof stored by Middleware
+func FromContext(ctx context.Context) (*Proof, bool) {
+    proof, ok := ctx.Value(contextKey{}).(*Proof)
+    return proof, ok
+}
@@ -0,0 +1,137 @@
+package dpop
+
+import (
+    "crypto/rand"
+    "encoding/base64"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+)
+
+// DefaultNonceRotation is how often the server DPoP nonce changes
+const DefaultNonceRotation = 5 * time.Minute
+
+// NonceSource issues server nonces, accepting the current and previous value across a rotation
+type NonceSource struct {
+    rotation time.Duration
+    clock    clock.Clock
+
+    mu        sync.Mutex
+    current   string
+    previous  string
+    rotatedAt time.Time
+}
+
+// NewNonceSource creates a nonce source; rotation of 0 uses DefaultNonceRotation
+func NewNonceSource(rotation time.Duration) *NonceSource {
+    if rotation <= 0 {
+        rotation = DefaultNonceRotation
+    }
+    return &NonceSource{rotation: rotation, clock: clock.System}
+}
+
+// SetClock sets the time source that drives rotation
+func (n *NonceSource) SetClock(c clock.Clock) *NonceSource {
+    n.clock = c
+    return n
+}
+
+// Current returns the nonce clients should place in their next proof
+func (n *NonceSource) Current() string {
+    n.mu.Lock()
+    defer n.mu.Unlock()
+
+    n.rotateLocked()
+    return n.current
+}
+
+// Valid reports whether nonce is the current or immediately previous server nonce
+func (n *NonceSource) Valid(nonce string) bool {
+    if nonce == "" {
+        return false
+    }
+
+    n.mu.Lock()
+    defer n.mu.Unlock()
+
+    n.rotateLocked()
+    return crypto.EqualString(nonce, n.current) ||
+        (n.previous != "" && crypto.EqualString(nonce, n.previous))
+}
+
+func (n *NonceSource) rotateLocked() {
+    now := n.clock.Now()
+    if n.current != "" && now.Sub(n.rotatedAt) < n.rotation {
+        return
+    }
+    // After a long idle gap the previous nonce is stale too
+    if now.Sub(n.rotatedAt) < 2*n.rotation {
+        n.previous = n.current
+    } else {
+        n.previous = ""
+    }
+
+    b := make([]byte, 24)
+    if _, err := rand.Read(b); err != nil {
+        panic(err)
+    }
+    n.current = base64.RawURLEncoding.EncodeToString(b)
+    n.rotatedAt = now
+}
+
+// ReplayCache remembers proof jti values until they can no longer pass the freshness check
+type ReplayCache interface {
+    // Seen records jti and reports whether it was already present
+    Seen(jti string, expiresAt time.Time) bool
+}
+
+// MemoryReplayCache is a bounded in-process ReplayCache
+type MemoryReplayCache struct {
+    maxEntries int
+    clock      clock.Clock
+
+    mu      sync.Mutex
+    entries map[string]time.Time
+}
+
+// NewMemoryReplayCache creates a cache holding at most maxEntries live jti values
+func NewMemoryReplayCache(maxEntries int) *MemoryReplayCache {
+    return &MemoryReplayCache{
+        maxEntries: maxEntries,
+        entries:    make(map[string]time.Time),
+        clock:      clock.System,
+    }
+}
+
+// SetClock sets the time source used to prune expired entries
+func (c *MemoryReplayCache) SetClock(clk clock.Clock) *MemoryReplayCache {
+    c.clock = clk
+    return c
+}
+
+// Seen records jti; when full after pruning it reports true so proofs fail closed
+func (c *MemoryReplayCache) Seen(jti string, expiresAt time.Time) bool {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    now := c.clock.Now()
+    if exp, ok := c.entries[jti]; ok && now.Before(exp) {
+        return true
+    }
+
+    if len(c.entries) >= c.maxEntries {
+        for id, exp := range c.entries {
+            if !now.Before(exp) {
+                delete(c.entries, id)
+            }
+        }
+        if len(c.entries) >= c.maxEntries {
+            return true
+        }
+    }
+
+    c.entries[jti] = expiresAt
+    return false
+}
@@ -0,0 +1,182 @@
+package dpop
+
+import (
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+)
+
+// Defaults for proof freshness checks
+const (
+    DefaultMaxAge = 60 * time.Second
+    DefaultLeeway = 5 * time.Second
+)
+
+var (
+    ErrMissingProof  = errors.New("missing DPoP proof")
+    ErrInvalidProof  = errors.New("invalid DPoP proof")
+    ErrProofExpired  = errors.New("DPoP proof is outside the acceptable time window")
+    ErrProofReplayed = errors.New("DPoP proof has already been used")
+    ErrNonceRequired = errors.New("DPoP proof must include a current server nonce")
+    ErrTokenBinding  = errors.New("DPoP proof does not match the access token")
+)
+
+// Proof holds the validated contents of a DPoP header
+type Proof struct {
+    JTI        string
+    Method     string
+    URL        string
+    IssuedAt   time.Time
+    Key        JWK
+    Thumbprint string
+}
+
+type proofHeader struct {
+    Typ string `json:"typ"`
+    Alg string `json:"alg"`
+    JWK *JWK   `json:"jwk"`
+}
+
+type proofClaims struct {
+    JTI   string `json:"jti"`
+    HTM   string `json:"htm"`
+    HTU   string `json:"htu"`
+    IAT   int64  `json:"iat"`
+    Nonce string `json:"nonce,omitempty"`
+    ATH   string `json:"ath,omitempty"`
+}
+
+// Validator checks DPoP proofs per RFC 9449
+type Validator struct {
+    nonces *NonceSource
+    replay ReplayCache
+    maxAge time.Duration
+    leeway time.Duration
+    clock  clock.Clock
+}
+
+// NewValidator creates a validator; nonces may be nil to skip server nonce enforcement
+func NewValidator(nonces *NonceSource, replay ReplayCache) *Validator {
+    return &Validator{
+        nonces: nonces,
+        replay: replay,
+        maxAge: DefaultMaxAge,
+        leeway: DefaultLeeway,
+        clock:  clock.System,
+    }
+}
+
+// SetMaxAge sets how old a proof's iat may be
+func (v *Validator) SetMaxAge(maxAge time.Duration) *Validator {
+    v.maxAge = maxAge
+    return v
+}
+
+// SetClock sets the time source for the iat freshness check
+func (v *Validator) SetClock(c clock.Clock) *Validator {
+    v.clock = c
+    return v
+}
+
+// Validate checks the DPoP header of r; accessToken is bound via ath when non-empty
+func (v *Validator) Validate(r *http.Request, accessToken string) (*Proof, error) {
+    values := r.Header.Values("DPoP")
+    if len(values) == 0 {
+        return nil, ErrMissingProof
+    }
+    if len(values) > 1 {
+        return nil, ErrInvalidProof
+    }
+
+    parts := strings.Split(values[0], ".")
+    if len(parts) != 3 {
+        return nil, ErrInvalidProof
+    }
+    var header proofHeader
+    if err := decodeSegment(parts[0], &header); err != nil {
+        return nil, ErrInvalidProof
+    }
+    var claims proofClaims
+    if err := decodeSegment(parts[1], &claims); err != nil {
+        return nil, ErrInvalidProof
+    }
+    signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+    if err != nil {
+        return nil, ErrInvalidProof
+    }
+
+    if header.Typ != "dpop+jwt" || header.JWK == nil || header.Alg == "" || header.Alg == "none" {
+        return nil, ErrInvalidProof
+    }
+    if err := header.JWK.verify(header.Alg, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+        return nil, ErrInvalidProof
+    }
+
+    if claims.JTI == "" || claims.HTM != r.Method || claims.HTU != requestURL(r) {
+        return nil, ErrInvalidProof
+    }
+
+    now := v.clock.Now()
+    issuedAt := time.Unix(claims.IAT, 0)
+    if issuedAt.After(now.Add(v.leeway)) || issuedAt.Before(now.Add(-v.maxAge)) {
+        return nil, ErrProofExpired
+    }
+
+    if v.nonces != nil && !v.nonces.Valid(claims.Nonce) {
+        return nil, ErrNonceRequired
+    }
+
+    if accessToken != "" {
+        sum := sha256.Sum256([]byte(accessToken))
+        ath := base64.RawURLEncoding.EncodeToString(sum[:])
+        if !crypto.EqualString(ath, claims.ATH) {
+            return nil, ErrTokenBinding
+        }
+    }
+
+    // Checked last so proofs rejected for other reasons don't burn their jti
+    if v.replay != nil && v.replay.Seen(claims.JTI, issuedAt.Add(v.maxAge+v.leeway)) {
+        return nil, ErrProofReplayed
+    }
+
+    thumbprint, err := header.JWK.Thumbprint()
+    if err != nil {
+        return nil, ErrInvalidProof
+    }
+
+    return &Proof{
+        JTI:        claims.JTI,
+        Method:     claims.HTM,
+        URL:        claims.HTU,
+        IssuedAt:   issuedAt,
+        Key:        *header.JWK,
+        Thumbprint: thumbprint,
+    }, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+    data, err := base64.RawURLEncoding.DecodeString(seg)
+    if err != nil {
+        return err
+    }
+    return json.Unmarshal(data, v)
+}
+
+// requestURL reconstructs the htu value: scheme, host and path without query or fragment
+func requestURL(r *http.Request) string {
+    scheme := "https"
+    if r.TLS == nil {
+        scheme = "http"
+        if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+            scheme = proto
+        }
+    }
+    return scheme + "://" + r.Host + r.URL.EscapedPath()
+}
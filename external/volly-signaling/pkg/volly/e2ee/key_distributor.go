@@ -0,0 +1,213 @@
+package e2ee
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/binary"
+    "errors"
+    "log"
+    "strconv"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+    "github.com/volly-org/volly-signaling/pkg/volly/events"
+)
+
+// DefaultRekeyDebounce batches membership changes that arrive close together
+const DefaultRekeyDebounce = 500 * time.Millisecond
+
+// EventKeyRotated is published after a room moves to a new key epoch
+const EventKeyRotated = "key_rotated"
+
+// mediaKeySize is the SFrame base key length
+const mediaKeySize = 32
+
+var ErrMemberKeyRequired = errors.New("member ML-KEM-768 public key is required")
+
+// WrappedMediaKey is a room's SFrame key for one epoch, wrapped to a single member
+type WrappedMediaKey struct {
+    Room          string
+    Identity      string
+    Epoch         uint64
+    KEMCiphertext []byte
+    WrappedKey    []byte
+}
+
+// KeyDelivery sends a wrapped media key to a member over signaling
+type KeyDelivery interface {
+    DeliverMediaKey(ctx context.Context, key WrappedMediaKey) error
+}
+
+type roomKeys struct {
+    epoch   uint64
+    members map[string][]byte
+    timer   *time.Timer
+}
+
+// KeyDistributor ratchets SFrame keys on every membership change
+type KeyDistributor struct {
+    delivery KeyDelivery
+    bus      events.Bus
+    debounce time.Duration
+
+    mu    sync.Mutex
+    rooms map[string]*roomKeys
+}
+
+// NewKeyDistributor creates a distributor; bus may be nil
+func NewKeyDistributor(delivery KeyDelivery, bus events.Bus) *KeyDistributor {
+    return &KeyDistributor{
+        delivery: delivery,
+        bus:      bus,
+        debounce: DefaultRekeyDebounce,
+        rooms:    make(map[string]*roomKeys),
+    }
+}
+
+// SetDebounce sets how long to wait for further membership changes before rekeying; 0 rekeys immediately
+func (d *KeyDistributor) SetDebounce(debounce time.Duration) *KeyDistributor {
+    d.debounce = debounce
+    return d
+}
+
+// Join adds a member; it receives keys from the next epoch onward
+func (d *KeyDistributor) Join(ctx context.Context, room, identity string, pqPublicKey []byte) error {
+    if len(pqPublicKey) == 0 {
+        return ErrMemberKeyRequired
+    }
+
+    d.mu.Lock()
+    rk, ok := d.rooms[room]
+    if !ok {
+        rk = &roomKeys{members: make(map[string][]byte)}
+        d.rooms[room] = rk
+    }
+    rk.members[identity] = pqPublicKey
+    d.mu.Unlock()
+
+    return d.scheduleRekey(ctx, room)
+}
+
+// Leave removes a member and rekeys so it cannot decrypt future media
+func (d *KeyDistributor) Leave(ctx context.Context, room, identity string) error {
+    d.mu.Lock()
+    rk, ok := d.rooms[room]
+    if !ok {
+        d.mu.Unlock()
+        return nil
+    }
+    delete(rk.members, identity)
+    if len(rk.members) == 0 {
+        if rk.timer != nil {
+            rk.timer.Stop()
+        }
+        delete(d.rooms, room)
+        d.mu.Unlock()
+        return nil
+    }
+    d.mu.Unlock()
+
+    return d.scheduleRekey(ctx, room)
+}
+
+// Epoch returns the current key epoch for a room
+func (d *KeyDistributor) Epoch(room string) uint64 {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+
+    if rk, ok := d.rooms[room]; ok {
+        return rk.epoch
+    }
+    return 0
+}
+
+// Rekey immediately starts a new epoch and distributes it to all current members
+func (d *KeyDistributor) Rekey(ctx context.Context, room string) error {
+    key := make([]byte, mediaKeySize)
+    if _, err := rand.Read(key); err != nil {
+        return err
+    }
+
+    d.mu.Lock()
+    rk, ok := d.rooms[room]
+    if !ok {
+        d.mu.Unlock()
+        return nil
+    }
+    if rk.timer != nil {
+        rk.timer.Stop()
+        rk.timer = nil
+    }
+    rk.epoch++
+    epoch := rk.epoch
+    members := make(map[string][]byte, len(rk.members))
+    for identity, pub := range rk.members {
+        members[identity] = pub
+    }
+    d.mu.Unlock()
+
+    // Each epoch key is fresh randomness, never derived from the previous one
+    var firstErr error
+    for identity, pub := range members {
+        kemCiphertext, wrapped, err := crypto.WrapKey(pub, key, KeyAssociatedData(room, identity, epoch))
+        if err == nil {
+            err = d.delivery.DeliverMediaKey(ctx, WrappedMediaKey{
+                Room:          room,
+                Identity:      identity,
+                Epoch:         epoch,
+                KEMCiphertext: kemCiphertext,
+                WrappedKey:    wrapped,
+            })
+        }
+        if err != nil && firstErr == nil {
+            firstErr = err
+        }
+    }
+
+    if d.bus != nil {
+        _ = d.bus.Publish(ctx, events.Event{
+            Type: EventKeyRotated,
+            Room: room,
+            Data: map[string]string{
+                "epoch":   strconv.FormatUint(epoch, 10),
+                "members": strconv.Itoa(len(members)),
+            },
+        })
+    }
+    return firstErr
+}
+
+func (d *KeyDistributor) scheduleRekey(ctx context.Context, room string) error {
+    if d.debounce <= 0 {
+        return d.Rekey(ctx, room)
+    }
+
+    d.mu.Lock()
+    defer d.mu.Unlock()
+
+    rk, ok := d.rooms[room]
+    if !ok {
+        return nil
+    }
+    if rk.timer != nil {
+        rk.timer.Reset(d.debounce)
+        return nil
+    }
+    rk.timer = time.AfterFunc(d.debounce, func() {
+        if err := d.Rekey(context.Background(), room); err != nil {
+            log.Printf("rekey of room %s failed: %v", room, err)
+        }
+    })
+    return nil
+}
+
+// KeyAssociatedData binds a wrapped key to its room, recipient and epoch
+func KeyAssociatedData(room, identity string, epoch uint64) []byte {
+    ad := make([]byte, 0, len(room)+len(identity)+10)
+    ad = append(ad, room...)
+    ad = append(ad, 0)
+    ad = append(ad, identity...)
+    ad = append(ad, 0)
+    return binary.BigEndian.AppendUint64(ad, epoch)
+}
@@ -0,0 +1,207 @@
+// Package elevation grants time-boxed extra permissions, such as a minute of roomAdmin, to a
+// participant who already holds a session token, once they pass a second factor. Hosts then
+// need no long-lived admin tokens: the elevation token names the session token it was issued
+// against, expires within minutes and dies with the session token if that is revoked
+package elevation
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/netip"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+)
+
+// Permissions an elevation may grant
+const (
+    PermissionRoomAdmin  = "roomAdmin"
+    PermissionRoomRecord = "roomRecord"
+)
+
+// grants sets each permission on a grant
+var grants = map[string]func(g *auth.VollyVideoGrant){
+    PermissionRoomAdmin:  func(g *auth.VollyVideoGrant) { g.RoomAdmin = true },
+    PermissionRoomRecord: func(g *auth.VollyVideoGrant) { g.RoomRecord = true },
+}
+
+const (
+    DefaultTTL = time.Minute
+    // DefaultMaxTTL caps requested lifetimes, so an elevation is always short
+    DefaultMaxTTL = 5 * time.Minute
+)
+
+var (
+    ErrNotElevatable       = errors.New("permission cannot be granted by elevation")
+    ErrAlreadyElevated     = errors.New("an elevation token cannot be elevated again")
+    ErrDerivedToken        = errors.New("attenuated and delegated tokens cannot be elevated")
+    ErrNoSession           = errors.New("elevation needs a room session token with a jti")
+    ErrSessionExpired      = errors.New("session token has expired")
+    ErrSecondFactorMissing = errors.New("a second factor response is required for elevation")
+    ErrSecondFactorFailed  = errors.New("second factor was rejected")
+)
+
+// Subject is who asks to be elevated
+type Subject struct {
+    Tenant   string `json:"tenant"`
+    Identity string `json:"identity"`
+    Room     string `json:"room"`
+    // TokenID is the session token's jti
+    TokenID  string     `json:"tokenId"`
+    RemoteIP netip.Addr `json:"remoteIP,omitempty"`
+}
+
+// Factor checks a second factor, e.g. a TOTP code, a WebAuthn assertion or a push approval
+// relayed by an MFA service. It returns ErrSecondFactorFailed, or an error wrapping it, when
+// the response is wrong and any other error when it could not tell
+type Factor interface {
+    Verify(ctx context.Context, subject Subject, response string) error
+}
+
+// FactorFunc adapts a function to Factor
+type FactorFunc func(ctx context.Context, subject Subject, response string) error
+
+func (f FactorFunc) Verify(ctx context.Context, subject Subject, response string) error {
+    return f(ctx, subject, response)
+}
+
+// Request asks for permissions for ttl; zero means DefaultTTL
+type Request struct {
+    Permissions []string
+    TTL         time.Duration
+    // Response is the second factor
+    Response string
+    RemoteIP netip.Addr
+}
+
+// Elevator checks requests and derives elevated grants from session grants
+type Elevator struct {
+    factor      Factor
+    permissions map[string]bool
+    maxTTL      time.Duration
+    clock       clock.Clock
+}
+
+// New creates an elevator that grants roomAdmin and roomRecord behind factor
+func New(factor Factor) *Elevator {
+    return &Elevator{
+        factor:      factor,
+        permissions: map[string]bool{PermissionRoomAdmin: true, PermissionRoomRecord: true},
+        maxTTL:      DefaultMaxTTL,
+        clock:       clock.System,
+    }
+}
+
+// SetPermissions narrows the permissions an elevation may grant; others are ignored
+func (e *Elevator) SetPermissions(permissions ...string) *Elevator {
+    e.permissions = make(map[string]bool, len(permissions))
+    for _, p := range permissions {
+        e.permissions[p] = true
+    }
+    return e
+}
+
+// SetMaxTTL caps the elevation lifetime
+func (e *Elevator) SetMaxTTL(d time.Duration) *Elevator {
+    e.maxTTL = d
+    return e
+}
+
+// SetClock sets the time source for lifetimes
+func (e *Elevator) SetClock(c clock.Clock) *Elevator {
+    e.clock = c
+    return e
+}
+
+// Elevate checks the second factor and returns the elevated grant and how long it may live:
+// the request's ttl, capped by the elevator and by the session token's own expiry. The grant
+// keeps the session's room, PQ key and confirmation key, so it is bound to the same client,
+// and the first token of a renewed session, so revoking that revokes it too; sign it with
+// VollyAccessToken.SetElevatedFrom(session.TokenID). Attenuated and delegated tokens are
+// refused, as the elevation would shed their caveats and actor
+func (e *Elevator) Elevate(ctx context.Context, session *auth.VollyVideoGrant, req Request) (*auth.VollyVideoGrant, time.Duration, error) {
+    switch {
+    case session.ElevatedFrom != "":
+        return nil, 0, ErrAlreadyElevated
+    case derived(session):
+        return nil, 0, ErrDerivedToken
+    case session.TokenID == "" || session.Room == "" || !session.RoomJoin:
+        return nil, 0, ErrNoSession
+    }
+    if len(req.Permissions) == 0 {
+        return nil, 0, fmt.Errorf("%w: none requested", ErrNotElevatable)
+    }
+    for _, p := range req.Permissions {
+        if !e.permissions[p] || grants[p] == nil {
+            return nil, 0, fmt.Errorf("%w: %s", ErrNotElevatable, p)
+        }
+    }
+
+    ttl := req.TTL
+    if ttl <= 0 {
+        ttl = DefaultTTL
+    }
+    if ttl > e.maxTTL {
+        ttl = e.maxTTL
+    }
+    if session.ExpiresAt != 0 {
+        left := time.Unix(session.ExpiresAt, 0).Sub(e.clock.Now())
+        if left <= 0 {
+            return nil, 0, ErrSessionExpired
+        }
+        if left < ttl {
+            ttl = left
+        }
+    }
+
+    if req.Response == "" {
+        return nil, 0, ErrSecondFactorMissing
+    }
+    subject := Subject{
+        Tenant:   session.Tenant,
+        Identity: session.Identity,
+        Room:     session.Room,
+        TokenID:  session.TokenID,
+        RemoteIP: req.RemoteIP,
+    }
+    if err := e.factor.Verify(ctx, subject, req.Response); err != nil {
+        return nil, 0, err
+    }
+
+    elevated := &auth.VollyVideoGrant{
+        VideoGrant:       session.VideoGrant,
+        PQPublicKey:      session.PQPublicKey,
+        PQAlgorithm:      session.PQAlgorithm,
+        PQKeyExpiry:      session.PQKeyExpiry,
+        PQKeyIssuedAt:    session.PQKeyIssuedAt,
+        Tenant:           session.Tenant,
+        AllowedCountries: session.AllowedCountries,
+        DeniedCIDRs:      session.DeniedCIDRs,
+        Claims:           session.Claims,
+        Tags:             session.Tags,
+        Confirmation:     session.Confirmation,
+        PINHash:          session.PINHash,
+        EndTime:          session.EndTime,
+        OnExpiry:         session.OnExpiry,
+        ElevatedFrom:     session.TokenID,
+        RenewedFrom:      session.RenewedFrom,
+    }
+    for _, p := range req.Permissions {
+        grants[p](elevated)
+    }
+    return elevated, ttl, nil
+}
+
+// derived reports whether session was attenuated from or exchanged for another token. A
+// renewal's chain holds only the session's first token
+func derived(session *auth.VollyVideoGrant) bool {
+    if session.Actor != nil || session.OnBehalfOf != "" {
+        return true
+    }
+    if session.RenewedFrom != nil {
+        return len(session.TokenChain) != 1 || session.TokenChain[0] != session.RenewedFrom.TokenID
+    }
+    return len(session.TokenChain) > 0
+}
@@ -0,0 +1,50 @@
+package elevation
+
+import (
+    "context"
+    "errors"
+    "testing"
+
+    lkauth "github.com/livekit/protocol/auth"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+)
+
+// TestElevateRefusesDerivedTokens elevates a session token and a renewal of one, and checks
+// attenuated and delegated tokens are refused rather than elevated into plain session grants
+func TestElevateRefusesDerivedTokens(t *testing.T) {
+    e := New(FactorFunc(func(ctx context.Context, subject Subject, response string) error { return nil }))
+    session := func() *auth.VollyVideoGrant {
+        return &auth.VollyVideoGrant{VideoGrant: lkauth.VideoGrant{RoomJoin: true, Room: "r"}, TokenID: "t2", Tenant: "acme", Identity: "alice"}
+    }
+    elevate := func(g *auth.VollyVideoGrant) (*auth.VollyVideoGrant, error) {
+        elevated, _, err := e.Elevate(context.Background(), g, Request{Permissions: []string{PermissionRoomAdmin}, Response: "123456"})
+        return elevated, err
+    }
+
+    if _, err := elevate(session()); err != nil {
+        t.Fatalf("session token: %v", err)
+    }
+    renewed := session()
+    renewed.RenewedFrom = &auth.Renewal{TokenID: "t1", IssuedAt: 1}
+    renewed.TokenChain = []string{"t1"}
+    elevated, err := elevate(renewed)
+    if err != nil {
+        t.Fatalf("renewed session token: %v", err)
+    }
+    if elevated.RenewedFrom == nil || elevated.RenewedFrom.TokenID != "t1" {
+        t.Fatalf("elevation of a renewal names %+v, want the first token t1", elevated.RenewedFrom)
+    }
+
+    attenuated := session()
+    attenuated.TokenChain = []string{"t1"}
+    delegated := session()
+    delegated.Actor = &auth.Actor{Identity: "svc.internal", Tenant: "acme", TokenID: "s1"}
+    delegated.OnBehalfOf = "t1"
+    delegated.TokenChain = []string{"t1", "s1"}
+    for name, g := range map[string]*auth.VollyVideoGrant{"attenuated": attenuated, "delegated": delegated} {
+        if _, err := elevate(g); !errors.Is(err, ErrDerivedToken) {
+            t.Errorf("%s token: got %v, want ErrDerivedToken", name, err)
+        }
+    }
+}
@@ -0,0 +1,89 @@
+package elevation
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/challenge"
+)
+
+// HeaderSignature carries the webhook body's HMAC-SHA256 under the shared secret, hex encoded
+const HeaderSignature = "Volly-Signature"
+
+// webhookTimeout bounds an MFA service round trip so elevation fails closed instead of hanging
+const webhookTimeout = 10 * time.Second
+
+// Webhook asks an external MFA service whether a response is valid. It POSTs the subject and
+// response as JSON; 200 or 204 accepts, 401 and 403 reject and anything else is an error
+type Webhook struct {
+    url    string
+    secret []byte
+    client *http.Client
+}
+
+// webhookRequest is the body the MFA service receives
+type webhookRequest struct {
+    Subject
+    Response string `json:"response"`
+}
+
+// NewWebhook creates a factor backed by url; secret, when set, signs every request
+func NewWebhook(url string, secret []byte) *Webhook {
+    return &Webhook{url: url, secret: secret, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// SetHTTPClient replaces the client used to call the service
+func (w *Webhook) SetHTTPClient(client *http.Client) *Webhook {
+    w.client = client
+    return w
+}
+
+func (w *Webhook) Verify(ctx context.Context, subject Subject, response string) error {
+    body, err := json.Marshal(webhookRequest{Subject: subject, Response: response})
+    if err != nil {
+        return err
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if len(w.secret) > 0 {
+        mac := hmac.New(sha256.New, w.secret)
+        mac.Write(body)
+        req.Header.Set(HeaderSignature, hex.EncodeToString(mac.Sum(nil)))
+    }
+
+    resp, err := w.client.Do(req)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    switch resp.StatusCode {
+    case http.StatusOK, http.StatusNoContent:
+        return nil
+    case http.StatusUnauthorized, http.StatusForbidden:
+        return ErrSecondFactorFailed
+    }
+    return fmt.Errorf("second factor service answered %s", resp.Status)
+}
+
+// Challenge uses a challenge provider, such as a CAPTCHA, as the factor. It proves a human
+// is present rather than who they are, so it suits low-stakes permissions only
+func Challenge(provider challenge.Provider) Factor {
+    return FactorFunc(func(ctx context.Context, subject Subject, response string) error {
+        err := provider.Verify(ctx, response, subject.RemoteIP)
+        if errors.Is(err, challenge.ErrChallengeFailed) {
+            return ErrSecondFactorFailed
+        }
+        return err
+    })
+}
@@ -0,0 +1,343 @@
+// Package envelope encrypts secrets before they reach a store. Records are sealed with
+// AES-256-GCM under a data key; data keys are kept only wrapped by a key-encryption key
+// that lives in a KMS, so a dump of the store, data keys included, opens nothing without
+// the KMS. Data keys rotate on a schedule and the KMS key rotates on its own; ResealJob
+// moves records and data keys onto the current ones
+package envelope
+
+import (
+    "bytes"
+    "context"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/secure"
+)
+
+// magic starts every sealed record, telling it apart from values sealed before envelopes
+var magic = []byte("VE1")
+
+var (
+    ErrNotSealed      = errors.New("value is not envelope sealed")
+    ErrTampered       = errors.New("sealed value failed authentication")
+    ErrUnknownDataKey = errors.New("value is sealed under a data key this store does not hold")
+)
+
+// DataKey is a data key as stored: wrapped under the KMS key KEKID
+type DataKey struct {
+    ID        string    `json:"id"`
+    KEKID     string    `json:"kekId"`
+    Wrapped   []byte    `json:"-"`
+    CreatedAt time.Time `json:"createdAt"`
+}
+
+// KeyStore persists wrapped data keys next to the records they seal
+type KeyStore interface {
+    PutDataKey(ctx context.Context, k DataKey) error
+    ListDataKeys(ctx context.Context) ([]DataKey, error)
+}
+
+// MemoryKeyStore is a KeyStore for tests and single-process deployments
+type MemoryKeyStore struct {
+    mu   sync.Mutex
+    keys map[string]DataKey
+}
+
+// NewMemoryKeyStore creates an empty key store
+func NewMemoryKeyStore() *MemoryKeyStore {
+    return &MemoryKeyStore{keys: make(map[string]DataKey)}
+}
+
+func (m *MemoryKeyStore) PutDataKey(ctx context.Context, k DataKey) error {
+    m.mu.Lock()
+    m.keys[k.ID] = k
+    m.mu.Unlock()
+    return nil
+}
+
+func (m *MemoryKeyStore) ListDataKeys(ctx context.Context) ([]DataKey, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    out := make([]DataKey, 0, len(m.keys))
+    for _, k := range m.keys {
+        out = append(out, k)
+    }
+    return out, nil
+}
+
+type dataKey struct {
+    DataKey
+    aead cipher.AEAD
+    // key is kept for Rewrap
+    key *secure.SecureBytes
+}
+
+// Sealer seals and opens records under the current data key. It unwraps every stored data
+// key once, at NewSealer or when one is added, so opening a record never calls the KMS
+type Sealer struct {
+    kms   KeyWrapper
+    store KeyStore
+    clock clock.Clock
+
+    mu      sync.RWMutex
+    keys    map[string]*dataKey
+    current *dataKey
+}
+
+// NewSealer unwraps the data keys in store through kms, creating the first if there is none
+func NewSealer(ctx context.Context, kms KeyWrapper, store KeyStore) (*Sealer, error) {
+    s := &Sealer{kms: kms, store: store, clock: clock.System, keys: make(map[string]*dataKey)}
+    if err := s.Reload(ctx); err != nil {
+        return nil, err
+    }
+    if s.current == nil {
+        if _, err := s.Rotate(ctx); err != nil {
+            return nil, err
+        }
+    }
+    return s, nil
+}
+
+// SetClock sets the time source for data key creation stamps
+func (s *Sealer) SetClock(c clock.Clock) *Sealer {
+    s.clock = c
+    return s
+}
+
+// Reload picks up data keys other processes sharing the store have added; the newest is current
+func (s *Sealer) Reload(ctx context.Context) error {
+    stored, err := s.store.ListDataKeys(ctx)
+    if err != nil {
+        return err
+    }
+    sort.Slice(stored, func(i, j int) bool { return stored[i].CreatedAt.Before(stored[j].CreatedAt) })
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for _, k := range stored {
+        if have, ok := s.keys[k.ID]; ok {
+            have.DataKey = k
+        } else {
+            dk, err := s.unwrap(ctx, k)
+            if err != nil {
+                return fmt.Errorf("data key %s: %w", k.ID, err)
+            }
+            s.keys[k.ID] = dk
+        }
+        s.current = s.keys[k.ID]
+    }
+    return nil
+}
+
+func (s *Sealer) unwrap(ctx context.Context, k DataKey) (*dataKey, error) {
+    raw, err := s.kms.Unwrap(ctx, k.KEKID, k.Wrapped)
+    if err != nil {
+        return nil, err
+    }
+    return newDataKey(k, raw)
+}
+
+// newDataKey takes ownership of raw
+func newDataKey(k DataKey, raw []byte) (*dataKey, error) {
+    if len(raw) != 32 {
+        secure.Wipe(raw)
+        return nil, errors.New("data key is not 32 bytes")
+    }
+    key, err := secure.FromBytes(raw)
+    if err != nil {
+        return nil, err
+    }
+    dk := &dataKey{DataKey: k, key: key}
+    err = key.Use(func(b []byte) error {
+        block, err := aes.NewCipher(b)
+        if err != nil {
+            return err
+        }
+        dk.aead, err = cipher.NewGCM(block)
+        return err
+    })
+    if err != nil {
+        key.Close()
+        return nil, err
+    }
+    return dk, nil
+}
+
+// Rotate makes a new data key current. Records sealed under older keys still open;
+// ResealJob moves them over
+func (s *Sealer) Rotate(ctx context.Context) (DataKey, error) {
+    raw := make([]byte, 32)
+    if _, err := rand.Read(raw); err != nil {
+        return DataKey{}, err
+    }
+    id := make([]byte, 8)
+    if _, err := rand.Read(id); err != nil {
+        return DataKey{}, err
+    }
+    kekID, wrapped, err := s.kms.Wrap(ctx, raw)
+    if err != nil {
+        secure.Wipe(raw)
+        return DataKey{}, err
+    }
+    k := DataKey{ID: hex.EncodeToString(id), KEKID: kekID, Wrapped: wrapped, CreatedAt: s.clock.Now()}
+    dk, err := newDataKey(k, raw)
+    if err != nil {
+        return DataKey{}, err
+    }
+    if err := s.store.PutDataKey(ctx, k); err != nil {
+        dk.key.Close()
+        return DataKey{}, err
+    }
+    s.mu.Lock()
+    s.keys[k.ID] = dk
+    s.current = dk
+    s.mu.Unlock()
+    return k, nil
+}
+
+// Rewrap wraps every data key again under the KMS's current key, returning how many
+// changed. Data keys are unchanged, so sealed records need no work
+func (s *Sealer) Rewrap(ctx context.Context) (int, error) {
+    current, err := s.kms.CurrentKey(ctx)
+    if err != nil {
+        return 0, err
+    }
+    s.mu.RLock()
+    var stale []*dataKey
+    for _, dk := range s.keys {
+        if dk.KEKID != current {
+            stale = append(stale, dk)
+        }
+    }
+    s.mu.RUnlock()
+    n := 0
+    for _, dk := range stale {
+        var k DataKey
+        err := dk.key.Use(func(raw []byte) error {
+            kekID, wrapped, err := s.kms.Wrap(ctx, raw)
+            k = DataKey{ID: dk.ID, KEKID: kekID, Wrapped: wrapped, CreatedAt: dk.CreatedAt}
+            return err
+        })
+        if err != nil {
+            return n, err
+        }
+        if err := s.store.PutDataKey(ctx, k); err != nil {
+            return n, err
+        }
+        s.mu.Lock()
+        dk.DataKey = k
+        s.mu.Unlock()
+        n++
+    }
+    return n, nil
+}
+
+// Current describes the data key new records are sealed under
+func (s *Sealer) Current() DataKey {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    return s.current.DataKey
+}
+
+// DataKeys describes every data key held, oldest first
+func (s *Sealer) DataKeys() []DataKey {
+    s.mu.RLock()
+    out := make([]DataKey, 0, len(s.keys))
+    for _, dk := range s.keys {
+        k := dk.DataKey
+        k.Wrapped = nil
+        out = append(out, k)
+    }
+    s.mu.RUnlock()
+    sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+    return out
+}
+
+// Seal encrypts plaintext under the current data key, bound to aad, usually the record's ID
+func (s *Sealer) Seal(aad, plaintext []byte) ([]byte, error) {
+    s.mu.RLock()
+    dk := s.current
+    s.mu.RUnlock()
+    id := []byte(dk.ID)
+    nonceSize := dk.aead.NonceSize()
+    out := make([]byte, 0, len(magic)+1+len(id)+nonceSize+len(plaintext)+dk.aead.Overhead())
+    out = append(out, magic...)
+    out = append(out, byte(len(id)))
+    out = append(out, id...)
+    nonce := make([]byte, nonceSize)
+    if _, err := rand.Read(nonce); err != nil {
+        return nil, err
+    }
+    out = append(out, nonce...)
+    return dk.aead.Seal(out, nonce, plaintext, aad), nil
+}
+
+// Open decrypts a value Seal produced with the same aad
+func (s *Sealer) Open(aad, sealed []byte) ([]byte, error) {
+    id, rest, err := parse(sealed)
+    if err != nil {
+        return nil, err
+    }
+    s.mu.RLock()
+    dk, ok := s.keys[id]
+    s.mu.RUnlock()
+    if !ok {
+        return nil, fmt.Errorf("%w: %s", ErrUnknownDataKey, id)
+    }
+    if len(rest) < dk.aead.NonceSize() {
+        return nil, ErrTampered
+    }
+    nonce, ciphertext := rest[:dk.aead.NonceSize()], rest[dk.aead.NonceSize():]
+    plaintext, err := dk.aead.Open(nil, nonce, ciphertext, aad)
+    if err != nil {
+        return nil, ErrTampered
+    }
+    return plaintext, nil
+}
+
+// Stale reports whether sealed should be sealed again: it predates envelopes or is under
+// a data key other than the current one
+func (s *Sealer) Stale(sealed []byte) bool {
+    id, _, err := parse(sealed)
+    if err != nil {
+        return true
+    }
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    return id != s.current.ID
+}
+
+// IsSealed reports whether b is in the envelope format; stores use it to tell values
+// sealed before envelopes apart
+func IsSealed(b []byte) bool {
+    return bytes.HasPrefix(b, magic)
+}
+
+func parse(sealed []byte) (id string, rest []byte, err error) {
+    if !IsSealed(sealed) {
+        return "", nil, ErrNotSealed
+    }
+    sealed = sealed[len(magic):]
+    if len(sealed) < 1 || len(sealed) < 1+int(sealed[0]) {
+        return "", nil, ErrTampered
+    }
+    n := int(sealed[0])
+    return string(sealed[1 : 1+n]), sealed[1+n:], nil
+}
+
+// Close zeroizes the unwrapped data keys
+func (s *Sealer) Close() error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for _, dk := range s.keys {
+        dk.key.Close()
+    }
+    return nil
+}
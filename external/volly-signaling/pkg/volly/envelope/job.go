@@ -0,0 +1,225 @@
+package envelope
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "log"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+)
+
+const (
+    // DefaultInterval is how often Run checks for rotation
+    DefaultInterval = time.Hour
+    // DefaultDataKeyLifetime is how long a data key seals new records before Run rotates it
+    DefaultDataKeyLifetime = 30 * 24 * time.Hour
+)
+
+// resealTimeout bounds one store's reseal
+const resealTimeout = 10 * time.Minute
+
+// Resealer is implemented by stores holding sealed records. Reseal seals again every
+// record for which s.Stale is true, including any sealed before envelopes, and returns
+// how many it rewrote
+type Resealer interface {
+    Reseal(ctx context.Context, s *Sealer) (int, error)
+}
+
+// ResealerFunc adapts a function to Resealer
+type ResealerFunc func(ctx context.Context, s *Sealer) (int, error)
+
+// Reseal calls f
+func (f ResealerFunc) Reseal(ctx context.Context, s *Sealer) (int, error) {
+    return f(ctx, s)
+}
+
+// Result is one pass of the job
+type Result struct {
+    At time.Time `json:"at"`
+    // Rotated is the data key made current by this pass, if it rotated
+    Rotated string `json:"rotated,omitempty"`
+    // Rewrapped counts data keys moved onto the KMS's current key
+    Rewrapped int `json:"rewrapped"`
+    // Resealed counts records moved onto the current data key, by store
+    Resealed map[string]int    `json:"resealed"`
+    Errors   map[string]string `json:"errors,omitempty"`
+}
+
+type target struct {
+    name     string
+    resealer Resealer
+}
+
+// ResealJob keeps records and data keys current: it rotates the data key once it is
+// older than its lifetime, rewraps data keys the KMS has rotated away from, and reseals
+// records still under an older data key. Data keys are never deleted, since a record a
+// failed pass missed must still open
+type ResealJob struct {
+    sealer   *Sealer
+    interval time.Duration
+    lifetime time.Duration
+    clock    clock.Clock
+
+    mu        sync.Mutex
+    targets   []target
+    resealed  map[string]int64
+    failures  int64
+    rotations int64
+    last      *Result
+}
+
+// NewResealJob creates a job for sealer with no stores
+func NewResealJob(sealer *Sealer) *ResealJob {
+    return &ResealJob{
+        sealer:   sealer,
+        interval: DefaultInterval,
+        lifetime: DefaultDataKeyLifetime,
+        clock:    clock.System,
+        resealed: make(map[string]int64),
+    }
+}
+
+// SetInterval sets how often Run makes a pass
+func (j *ResealJob) SetInterval(d time.Duration) *ResealJob {
+    j.interval = d
+    return j
+}
+
+// SetDataKeyLifetime sets how long a data key stays current; zero never rotates it on age
+func (j *ResealJob) SetDataKeyLifetime(d time.Duration) *ResealJob {
+    j.lifetime = d
+    return j
+}
+
+// SetClock sets the time source for data key age
+func (j *ResealJob) SetClock(c clock.Clock) *ResealJob {
+    j.clock = c
+    return j
+}
+
+// Add reseals store's records on every pass
+func (j *ResealJob) Add(name string, r Resealer) *ResealJob {
+    j.mu.Lock()
+    j.targets = append(j.targets, target{name: name, resealer: r})
+    j.mu.Unlock()
+    return j
+}
+
+// Pass rotates if the data key is due, or if rotate is set, then rewraps and reseals
+func (j *ResealJob) Pass(ctx context.Context, rotate bool) Result {
+    res := Result{At: j.clock.Now(), Resealed: make(map[string]int)}
+    fail := func(step string, err error) {
+        if res.Errors == nil {
+            res.Errors = make(map[string]string)
+        }
+        res.Errors[step] = err.Error()
+    }
+    // Another process sharing the store may have rotated already
+    if err := j.sealer.Reload(ctx); err != nil {
+        fail("reload", err)
+    }
+    if rotate || j.lifetime > 0 && res.At.Sub(j.sealer.Current().CreatedAt) >= j.lifetime {
+        if k, err := j.sealer.Rotate(ctx); err != nil {
+            fail("rotate", err)
+        } else {
+            res.Rotated = k.ID
+        }
+    }
+    n, err := j.sealer.Rewrap(ctx)
+    res.Rewrapped = n
+    if err != nil {
+        fail("rewrap", err)
+    }
+
+    j.mu.Lock()
+    targets := append([]target(nil), j.targets...)
+    j.mu.Unlock()
+    for _, t := range targets {
+        tctx, cancel := context.WithTimeout(ctx, resealTimeout)
+        n, err := t.resealer.Reseal(tctx, j.sealer)
+        cancel()
+        res.Resealed[t.name] = n
+        if err != nil {
+            fail(t.name, err)
+        }
+    }
+
+    j.mu.Lock()
+    for name, n := range res.Resealed {
+        j.resealed[name] += int64(n)
+    }
+    if res.Rotated != "" {
+        j.rotations++
+    }
+    if len(res.Errors) > 0 {
+        j.failures++
+    }
+    j.last = &res
+    j.mu.Unlock()
+    return res
+}
+
+// Run makes a pass every interval until ctx is cancelled
+func (j *ResealJob) Run(ctx context.Context) {
+    ticker := time.NewTicker(j.interval)
+    defer ticker.Stop()
+    for {
+        res := j.Pass(ctx, false)
+        for step, err := range res.Errors {
+            log.Printf("envelope: %s failed: %s", step, err)
+        }
+        if res.Rotated != "" {
+            log.Printf("envelope: rotated to data key %s", res.Rotated)
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// Last returns the latest pass, nil before the first
+func (j *ResealJob) Last() *Result {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    return j.last
+}
+
+// WriteMetrics writes the job's counters in the Prometheus text format
+func (j *ResealJob) WriteMetrics(w io.Writer) error {
+    j.mu.Lock()
+    names := make([]string, 0, len(j.resealed))
+    for name := range j.resealed {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    resealed := make([]int64, len(names))
+    for i, name := range names {
+        resealed[i] = j.resealed[name]
+    }
+    rotations, failures := j.rotations, j.failures
+    j.mu.Unlock()
+    current := j.sealer.Current()
+
+    metric := func(name, kind, help string) {
+        fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+    }
+    metric("volly_envelope_data_keys", "gauge", "Data keys held, current and retired.")
+    fmt.Fprintf(w, "volly_envelope_data_keys %d\n", len(j.sealer.DataKeys()))
+    metric("volly_envelope_data_key_age_seconds", "gauge", "Age of the data key new records are sealed under.")
+    fmt.Fprintf(w, "volly_envelope_data_key_age_seconds %.0f\n", j.clock.Now().Sub(current.CreatedAt).Seconds())
+    metric("volly_envelope_rotations_total", "counter", "Data key rotations by this process.")
+    fmt.Fprintf(w, "volly_envelope_rotations_total %d\n", rotations)
+    metric("volly_envelope_resealed_total", "counter", "Records moved onto the current data key since start by store.")
+    for i, name := range names {
+        fmt.Fprintf(w, "volly_envelope_resealed_total{store=%q} %d\n", name, resealed[i])
+    }
+    metric("volly_envelope_pass_failures_total", "counter", "Passes with at least one failed step.")
+    _, err := fmt.Fprintf(w, "volly_envelope_pass_failures_total %d\n", failures)
+    return err
+}
@@ -0,0 +1,236 @@
+package envelope
+
+import (
+    "bytes"
+    "context"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/secure"
+)
+
+var ErrUnknownKEK = errors.New("data key is wrapped under a key the KMS does not hold")
+
+// KeyWrapper is the KMS holding the key-encryption key. Wrap uses its current key and
+// names it, so Unwrap can pick the key a data key was wrapped under after rotation
+type KeyWrapper interface {
+    CurrentKey(ctx context.Context) (string, error)
+    Wrap(ctx context.Context, dataKey []byte) (kekID string, wrapped []byte, err error)
+    Unwrap(ctx context.Context, kekID string, wrapped []byte) ([]byte, error)
+}
+
+// LocalKEK wraps with AES-256-GCM keys held in process, such as VOLLY_SEAL_KEY, for
+// deployments without a KMS. Retired keys stay to unwrap what they wrapped until Rewrap
+type LocalKEK struct {
+    mu      sync.RWMutex
+    current string
+    keys    map[string]*secure.SecureBytes
+}
+
+// NewLocalKEK wraps under key, named id; it takes ownership of key
+func NewLocalKEK(id string, key *secure.SecureBytes) *LocalKEK {
+    return &LocalKEK{current: id, keys: map[string]*secure.SecureBytes{id: key}}
+}
+
+// AddRetired holds an earlier key, taking ownership of it, so data keys it wrapped still open
+func (l *LocalKEK) AddRetired(id string, key *secure.SecureBytes) *LocalKEK {
+    l.mu.Lock()
+    l.keys[id] = key
+    l.mu.Unlock()
+    return l
+}
+
+func (l *LocalKEK) CurrentKey(ctx context.Context) (string, error) {
+    l.mu.RLock()
+    defer l.mu.RUnlock()
+    return l.current, nil
+}
+
+func (l *LocalKEK) Wrap(ctx context.Context, dataKey []byte) (string, []byte, error) {
+    l.mu.RLock()
+    id, key := l.current, l.keys[l.current]
+    l.mu.RUnlock()
+    var wrapped []byte
+    err := withGCM(key, func(aead cipher.AEAD) error {
+        nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(dataKey)+aead.Overhead())
+        if _, err := rand.Read(nonce); err != nil {
+            return err
+        }
+        wrapped = aead.Seal(nonce, nonce, dataKey, []byte(id))
+        return nil
+    })
+    return id, wrapped, err
+}
+
+func (l *LocalKEK) Unwrap(ctx context.Context, kekID string, wrapped []byte) ([]byte, error) {
+    l.mu.RLock()
+    key, ok := l.keys[kekID]
+    l.mu.RUnlock()
+    if !ok {
+        return nil, fmt.Errorf("%w: %s", ErrUnknownKEK, kekID)
+    }
+    var dataKey []byte
+    err := withGCM(key, func(aead cipher.AEAD) error {
+        if len(wrapped) < aead.NonceSize() {
+            return ErrTampered
+        }
+        var err error
+        dataKey, err = aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(kekID))
+        if err != nil {
+            return ErrTampered
+        }
+        return nil
+    })
+    return dataKey, err
+}
+
+// Close zeroizes the keys
+func (l *LocalKEK) Close() error {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    for _, k := range l.keys {
+        k.Close()
+    }
+    return nil
+}
+
+func withGCM(key *secure.SecureBytes, fn func(aead cipher.AEAD) error) error {
+    if key == nil || key.Len() != 32 {
+        return errors.New("key-encryption key must be 32 bytes")
+    }
+    return key.Use(func(b []byte) error {
+        block, err := aes.NewCipher(b)
+        if err != nil {
+            return err
+        }
+        aead, err := cipher.NewGCM(block)
+        if err != nil {
+            return err
+        }
+        return fn(aead)
+    })
+}
+
+// VaultTransit wraps data keys with a HashiCorp Vault (or OpenBao) transit key, which never
+// leaves Vault. Rotating the transit key in Vault is picked up on the next Wrap; the key
+// version is the KEK ID, so Rewrap moves data keys off old versions before Vault's
+// min_decryption_version retires them
+type VaultTransit struct {
+    address string
+    mount   string
+    key     string
+    token   string
+    client  *http.Client
+}
+
+// NewVaultTransit uses transit key key at the Vault at address, authenticating with token
+func NewVaultTransit(address, key, token string) *VaultTransit {
+    return &VaultTransit{
+        address: strings.TrimSuffix(address, "/"),
+        mount:   "transit",
+        key:     key,
+        token:   token,
+        client:  &http.Client{},
+    }
+}
+
+// SetMount uses a transit engine mounted somewhere other than transit/
+func (v *VaultTransit) SetMount(mount string) *VaultTransit {
+    v.mount = strings.Trim(mount, "/")
+    return v
+}
+
+// SetHTTPClient replaces the client used to call Vault
+func (v *VaultTransit) SetHTTPClient(client *http.Client) *VaultTransit {
+    v.client = client
+    return v
+}
+
+// CurrentKey asks Vault for the transit key's latest version
+func (v *VaultTransit) CurrentKey(ctx context.Context) (string, error) {
+    var out struct {
+        Data struct {
+            LatestVersion int `json:"latest_version"`
+        } `json:"data"`
+    }
+    if err := v.call(ctx, http.MethodGet, "keys", nil, &out); err != nil {
+        return "", err
+    }
+    return fmt.Sprintf("%s:v%d", v.key, out.Data.LatestVersion), nil
+}
+
+func (v *VaultTransit) Wrap(ctx context.Context, dataKey []byte) (string, []byte, error) {
+    var out struct {
+        Data struct {
+            Ciphertext string `json:"ciphertext"`
+        } `json:"data"`
+    }
+    if err := v.call(ctx, http.MethodPost, "encrypt", map[string]string{"plaintext": base64.StdEncoding.EncodeToString(dataKey)}, &out); err != nil {
+        return "", nil, err
+    }
+    // Ciphertexts are vault:v<version>:<base64>
+    parts := strings.SplitN(out.Data.Ciphertext, ":", 3)
+    if len(parts) != 3 || parts[0] != "vault" {
+        return "", nil, errors.New("vault transit: unexpected ciphertext format")
+    }
+    return v.key + ":" + parts[1], []byte(out.Data.Ciphertext), nil
+}
+
+func (v *VaultTransit) Unwrap(ctx context.Context, kekID string, wrapped []byte) ([]byte, error) {
+    if !strings.HasPrefix(kekID, v.key+":") {
+        return nil, fmt.Errorf("%w: %s", ErrUnknownKEK, kekID)
+    }
+    var out struct {
+        Data struct {
+            Plaintext string `json:"plaintext"`
+        } `json:"data"`
+    }
+    if err := v.call(ctx, http.MethodPost, "decrypt", map[string]string{"ciphertext": string(wrapped)}, &out); err != nil {
+        return nil, err
+    }
+    return base64.StdEncoding.DecodeString(out.Data.Plaintext)
+}
+
+func (v *VaultTransit) call(ctx context.Context, method, op string, body map[string]string, out interface{}) error {
+    var data []byte
+    if body != nil {
+        var err error
+        if data, err = json.Marshal(body); err != nil {
+            return err
+        }
+    }
+    u := v.address + "/v1/" + v.mount + "/" + op + "/" + url.PathEscape(v.key)
+    req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(data))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("X-Vault-Token", v.token)
+    if body != nil {
+        req.Header.Set("Content-Type", "application/json")
+    }
+    resp, err := v.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        var e struct {
+            Errors []string `json:"errors"`
+        }
+        json.NewDecoder(resp.Body).Decode(&e)
+        if len(e.Errors) > 0 {
+            return fmt.Errorf("vault transit %s: %s: %s", op, resp.Status, strings.Join(e.Errors, "; "))
+        }
+        return fmt.Errorf("vault transit %s: %s", op, resp.Status)
+    }
+    return json.NewDecoder(resp.Body).Decode(out)
+}
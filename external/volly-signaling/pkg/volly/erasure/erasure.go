@@ -0,0 +1,200 @@
+// Package erasure carries out right-to-be-forgotten requests: it purges a data subject from
+// every registered store and returns a receipt signed by the operator. Records that must
+// outlive the subject, such as the audit trail, are tombstoned instead of deleted
+package erasure
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+)
+
+// receiptContext domain-separates receipt signatures
+const receiptContext = "volly-erasure-receipt-v1"
+
+// Actions a store takes on the subject's records
+const (
+    ActionDeleted    = "deleted"
+    ActionTombstoned = "tombstoned"
+)
+
+var (
+    ErrNoSubject      = errors.New("tenant and identity are required")
+    ErrInvalidReceipt = errors.New("invalid erasure receipt")
+)
+
+// Subject is whose data is erased. Tombstone is the pseudonym that replaces Identity in
+// tombstoned records; it is keyed, so it cannot be recomputed from the identity elsewhere
+type Subject struct {
+    Tenant    string
+    Identity  string
+    Tombstone string
+}
+
+// Target erases a subject from one store and returns how many records it changed. It must
+// be idempotent, so a failed erasure can be retried
+type Target interface {
+    Erase(ctx context.Context, s Subject) (int, error)
+}
+
+// TargetFunc adapts a function to Target
+type TargetFunc func(ctx context.Context, s Subject) (int, error)
+
+// Erase calls f
+func (f TargetFunc) Erase(ctx context.Context, s Subject) (int, error) {
+    return f(ctx, s)
+}
+
+// StoreError is a store that failed to erase the subject
+type StoreError struct {
+    Store string
+    Err   error
+}
+
+func (e *StoreError) Error() string {
+    return fmt.Sprintf("erasing from %s: %v", e.Store, e.Err)
+}
+
+func (e *StoreError) Unwrap() error {
+    return e.Err
+}
+
+// Result is what one store did
+type Result struct {
+    Store   string `json:"store"`
+    Action  string `json:"action"`
+    Records int    `json:"records"`
+}
+
+// Receipt attests that an erasure was carried out. It names the subject only by its
+// tombstone, which the operator can match against tombstoned records
+type Receipt struct {
+    ID       string    `json:"id"`
+    Tenant   string    `json:"tenant"`
+    Subject  string    `json:"subject"`
+    ErasedAt time.Time `json:"erasedAt"`
+    Results  []Result  `json:"results"`
+    // Retained lists data deliberately kept and why
+    Retained  []string `json:"retained,omitempty"`
+    Signature []byte   `json:"signature"`
+}
+
+type target struct {
+    store  string
+    action string
+    target Target
+}
+
+// Eraser runs erasures over the stores registered with Add
+type Eraser struct {
+    key      []byte
+    clock    clock.Clock
+    targets  []target
+    retained []string
+}
+
+// New creates an eraser that signs receipts and derives tombstones with key
+func New(key []byte) *Eraser {
+    return &Eraser{key: key, clock: clock.System}
+}
+
+// SetClock sets the time source for receipts
+func (e *Eraser) SetClock(c clock.Clock) *Eraser {
+    e.clock = c
+    return e
+}
+
+// Add registers a store; stores are erased in the order they are added
+func (e *Eraser) Add(store, action string, t Target) *Eraser {
+    e.targets = append(e.targets, target{store: store, action: action, target: t})
+    return e
+}
+
+// Retain records data an erasure leaves in place, for the receipt
+func (e *Eraser) Retain(note string) *Eraser {
+    e.retained = append(e.retained, note)
+    return e
+}
+
+// Tombstone is the pseudonym identity in tenant is replaced with
+func (e *Eraser) Tombstone(tenant, identity string) string {
+    mac := hmac.New(sha256.New, e.key)
+    mac.Write([]byte("tombstone\x00" + tenant + "\x00" + identity))
+    return "erased-" + hex.EncodeToString(mac.Sum(nil)[:12])
+}
+
+// Erase purges identity in tenant from every store. Every store is tried even when one
+// fails; a receipt is only returned once all have succeeded
+func (e *Eraser) Erase(ctx context.Context, tenant, identity string) (*Receipt, error) {
+    if tenant == "" || identity == "" {
+        return nil, ErrNoSubject
+    }
+    s := Subject{Tenant: tenant, Identity: identity, Tombstone: e.Tombstone(tenant, identity)}
+    results := make([]Result, 0, len(e.targets))
+    var errs []error
+    for _, t := range e.targets {
+        n, err := t.target.Erase(ctx, s)
+        if err != nil {
+            errs = append(errs, &StoreError{Store: t.store, Err: err})
+            continue
+        }
+        results = append(results, Result{Store: t.store, Action: t.action, Records: n})
+    }
+    if len(errs) > 0 {
+        return nil, errors.Join(errs...)
+    }
+
+    id := make([]byte, 16)
+    if _, err := rand.Read(id); err != nil {
+        return nil, err
+    }
+    r := &Receipt{
+        ID:       hex.EncodeToString(id),
+        Tenant:   tenant,
+        Subject:  s.Tombstone,
+        ErasedAt: e.clock.Now().UTC().Truncate(time.Second),
+        Results:  results,
+        Retained: e.retained,
+    }
+    sig, err := e.sign(r)
+    if err != nil {
+        return nil, err
+    }
+    r.Signature = sig
+    return r, nil
+}
+
+// Verify checks that r was signed by this eraser's key and has not been altered
+func (e *Eraser) Verify(r *Receipt) error {
+    sig, err := e.sign(r)
+    if err != nil {
+        return err
+    }
+    if !hmac.Equal(sig, r.Signature) {
+        return ErrInvalidReceipt
+    }
+    return nil
+}
+
+// sign MACs the receipt without its signature; struct field order makes the JSON encoding
+// deterministic
+func (e *Eraser) sign(r *Receipt) ([]byte, error) {
+    unsigned := *r
+    unsigned.Signature = nil
+    data, err := json.Marshal(&unsigned)
+    if err != nil {
+        return nil, err
+    }
+    mac := hmac.New(sha256.New, e.key)
+    mac.Write([]byte(receiptContext))
+    mac.Write(data)
+    return mac.Sum(nil), nil
+}
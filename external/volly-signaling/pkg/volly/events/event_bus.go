@@ -0,0 +1,83 @@
+package events
+
+import (
+    "context"
+    "sync"
+    "time"
+)
+
+// Event is a lifecycle notification emitted by Volly services
+type Event struct {
+    Type      string            `json:"type"`
+    Room      string            `json:"room,omitempty"`
+    Identity  string            `json:"identity,omitempty"`
+    Timestamp int64             `json:"timestamp"`
+    Data      map[string]string `json:"data,omitempty"`
+}
+
+// Handler receives published events
+type Handler func(ctx context.Context, event Event)
+
+// Bus distributes events between components and gateway instances
+type Bus interface {
+    Publish(ctx context.Context, event Event) error
+    // Subscribe registers a handler for an event type, or all types when eventType is empty
+    Subscribe(eventType string, handler Handler) (unsubscribe func())
+}
+
+// MemoryBus is an in-process Bus for single-instance deployments
+type MemoryBus struct {
+    mu       sync.RWMutex
+    nextID   int
+    handlers map[string]map[int]Handler
+}
+
+// NewMemoryBus creates an empty in-process bus
+func NewMemoryBus() *MemoryBus {
+    return &MemoryBus{
+        handlers: make(map[string]map[int]Handler),
+    }
+}
+
+// Publish delivers the event synchronously to all matching handlers
+func (b *MemoryBus) Publish(ctx context.Context, event Event) error {
+    if event.Timestamp == 0 {
+        event.Timestamp = time.Now().Unix()
+    }
+
+    b.mu.RLock()
+    var targets []Handler
+    for _, h := range b.handlers[event.Type] {
+        targets = append(targets, h)
+    }
+    if event.Type != "" {
+        for _, h := range b.handlers[""] {
+            targets = append(targets, h)
+        }
+    }
+    b.mu.RUnlock()
+
+    for _, h := range targets {
+        h(ctx, event)
+    }
+    return nil
+}
+
+// Subscribe registers a handler and returns a function that removes it
+func (b *MemoryBus) Subscribe(eventType string, handler Handler) func() {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    if b.handlers[eventType] == nil {
+        b.handlers[eventType] = make(map[int]Handler)
+    }
+    id := b.nextID
+    b.nextID++
+    b.handlers[eventType][id] = handler
+
+    return func() {
+        b.mu.Lock()
+        defer b.mu.Unlock()
+        delete(b.handlers[eventType], id)
+    }
+}
@@ -0,0 +1,107 @@
+package extension
+
+import (
+    "context"
+    "errors"
+    "net/rpc"
+
+    goplugin "github.com/hashicorp/go-plugin"
+)
+
+var ErrAttestationRejected = errors.New("attestation rejected")
+
+// AttestationRequest is device or app attestation evidence a client presents with a token
+// request, e.g. a Play Integrity token or an App Attest assertion
+type AttestationRequest struct {
+    Tenant   string `json:"tenant,omitempty"`
+    Identity string `json:"identity"`
+    // Format names the evidence's kind for the verifier, e.g. play-integrity or app-attest
+    Format   string `json:"format"`
+    Evidence []byte `json:"evidence"`
+}
+
+// Attestation is what a verifier vouches for about the client
+type Attestation struct {
+    Format   string `json:"format"`
+    DeviceID string `json:"deviceId,omitempty"`
+    // Level is the verifier's own rating of the device, e.g. strong or basic
+    Level string `json:"level,omitempty"`
+}
+
+// AttestationVerifier checks attestation evidence. Evidence that is not genuine is refused
+// with an error wrapping ErrAttestationRejected; any other error is the verifier failing
+type AttestationVerifier interface {
+    VerifyAttestation(ctx context.Context, req AttestationRequest) (Attestation, error)
+}
+
+// attestationPlugin carries an AttestationVerifier over net/rpc
+type attestationPlugin struct {
+    impl AttestationVerifier
+}
+
+func (p *attestationPlugin) Server(*goplugin.MuxBroker) (interface{}, error) {
+    return &attestationServer{impl: p.impl}, nil
+}
+
+func (p *attestationPlugin) Client(_ *goplugin.MuxBroker, c *rpc.Client) (interface{}, error) {
+    return &attestationClient{r: &remote{client: c, timeout: DefaultCallTimeout}}, nil
+}
+
+// attestationReply tells a refusal apart from a failure, which net/rpc flattens to strings
+type attestationReply struct {
+    Attestation Attestation
+    Rejected    bool
+    Reason      string
+}
+
+type attestationServer struct {
+    impl AttestationVerifier
+}
+
+func (s *attestationServer) Implemented(_ bool, reply *bool) error {
+    *reply = s.impl != nil
+    return nil
+}
+
+func (s *attestationServer) VerifyAttestation(args AttestationRequest, reply *attestationReply) error {
+    a, err := s.impl.VerifyAttestation(context.Background(), args)
+    if errors.Is(err, ErrAttestationRejected) {
+        reply.Rejected, reply.Reason = true, err.Error()
+        return nil
+    }
+    reply.Attestation = a
+    return err
+}
+
+// attestationClient is the plugin's verifier as the gateway sees it
+type attestationClient struct {
+    r *remote
+}
+
+func (c *attestationClient) rpc() *remote {
+    return c.r
+}
+
+func (c *attestationClient) VerifyAttestation(ctx context.Context, req AttestationRequest) (Attestation, error) {
+    var reply attestationReply
+    if err := c.r.call(ctx, "Plugin.VerifyAttestation", req, &reply); err != nil {
+        return Attestation{}, err
+    }
+    if reply.Rejected {
+        return Attestation{}, &rejectedError{reason: reply.Reason}
+    }
+    return reply.Attestation, nil
+}
+
+// rejectedError is a refusal as the plugin worded it
+type rejectedError struct {
+    reason string
+}
+
+func (e *rejectedError) Error() string {
+    return e.reason
+}
+
+func (e *rejectedError) Unwrap() error {
+    return ErrAttestationRejected
+}
@@ -0,0 +1,179 @@
+// Package extension lets third parties extend Volly without forking it, with plugins that
+// run out of process under hashicorp/go-plugin and talk to the gateway over net/rpc. A
+// plugin is a separate binary whose main calls Serve with the extensions it implements: a
+// revocation store or an attestation verifier. The gateway starts it, checks its checksum
+// when one is configured, and negotiates the protocol version both sides speak, so a plugin
+// built against an older version keeps working while it is still offered
+package extension
+
+import (
+    "context"
+    "crypto/sha256"
+    "errors"
+    "fmt"
+    "net/rpc"
+    "os/exec"
+    "time"
+
+    goplugin "github.com/hashicorp/go-plugin"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/revocation"
+)
+
+// ProtocolVersion is the newest plugin protocol this package speaks
+const ProtocolVersion = 1
+
+// Names plugins are dispensed under
+const (
+    NameRevocationStore     = "revocation_store"
+    NameAttestationVerifier = "attestation_verifier"
+)
+
+// DefaultCallTimeout bounds one call into a plugin
+const DefaultCallTimeout = 5 * time.Second
+
+var (
+    ErrNotImplemented = errors.New("plugin does not implement this extension")
+    ErrPluginCall     = errors.New("plugin call failed")
+)
+
+// Handshake keeps the gateway from starting binaries that are not Volly plugins, and plugins
+// from being run by hand
+var Handshake = goplugin.HandshakeConfig{
+    MagicCookieKey:   "VOLLY_PLUGIN",
+    MagicCookieValue: "0b4c6f2e9a1d4e57b3f8c2a6d1e0f9b7",
+}
+
+// Extensions are what a plugin implements; nil fields are not offered
+type Extensions struct {
+    RevocationStore     revocation.Store
+    AttestationVerifier AttestationVerifier
+}
+
+// versionedPlugins are the plugin sets of every protocol version still spoken, serving ext;
+// the zero Extensions gives the gateway its clients
+func versionedPlugins(ext Extensions) map[int]goplugin.PluginSet {
+    return map[int]goplugin.PluginSet{
+        1: {
+            NameRevocationStore:     &revocationPlugin{impl: ext.RevocationStore},
+            NameAttestationVerifier: &attestationPlugin{impl: ext.AttestationVerifier},
+        },
+    }
+}
+
+// Serve runs a plugin binary's extensions until the gateway stops it; call it from main
+func Serve(ext Extensions) {
+    goplugin.Serve(&goplugin.ServeConfig{
+        HandshakeConfig:  Handshake,
+        VersionedPlugins: versionedPlugins(ext),
+    })
+}
+
+// Options tune how a plugin is started and called
+type Options struct {
+    Args []string
+    // SHA256 is the checksum the binary must have, unchecked when empty
+    SHA256 []byte
+    // CallTimeout bounds one call; DefaultCallTimeout when zero
+    CallTimeout time.Duration
+}
+
+// Client is a running plugin
+type Client struct {
+    client  *goplugin.Client
+    rpc     goplugin.ClientProtocol
+    timeout time.Duration
+}
+
+// Open starts the plugin at path and negotiates a protocol version with it
+func Open(path string, opts Options) (*Client, error) {
+    cfg := &goplugin.ClientConfig{
+        HandshakeConfig:  Handshake,
+        VersionedPlugins: versionedPlugins(Extensions{}),
+        Cmd:              exec.Command(path, opts.Args...),
+        AllowedProtocols: []goplugin.Protocol{goplugin.ProtocolNetRPC},
+        Managed:          true,
+    }
+    if len(opts.SHA256) > 0 {
+        cfg.SecureConfig = &goplugin.SecureConfig{Checksum: opts.SHA256, Hash: sha256.New()}
+    }
+    c := &Client{client: goplugin.NewClient(cfg), timeout: opts.CallTimeout}
+    if c.timeout <= 0 {
+        c.timeout = DefaultCallTimeout
+    }
+    var err error
+    if c.rpc, err = c.client.Client(); err != nil {
+        c.client.Kill()
+        return nil, fmt.Errorf("plugin %s: %w", path, err)
+    }
+    return c, nil
+}
+
+// Version is the protocol version negotiated with the plugin
+func (c *Client) Version() int {
+    return c.client.NegotiatedVersion()
+}
+
+// Close stops the plugin
+func (c *Client) Close() error {
+    c.client.Kill()
+    return nil
+}
+
+// RevocationStore is the plugin's revocation store
+func (c *Client) RevocationStore() (revocation.Store, error) {
+    raw, err := c.dispense(NameRevocationStore)
+    if err != nil {
+        return nil, err
+    }
+    return raw.(*revocationClient), nil
+}
+
+// AttestationVerifier is the plugin's attestation verifier
+func (c *Client) AttestationVerifier() (AttestationVerifier, error) {
+    raw, err := c.dispense(NameAttestationVerifier)
+    if err != nil {
+        return nil, err
+    }
+    return raw.(*attestationClient), nil
+}
+
+// dispense returns the extension's RPC client once the plugin says it implements it
+func (c *Client) dispense(name string) (interface{}, error) {
+    raw, err := c.rpc.Dispense(name)
+    if err != nil {
+        return nil, err
+    }
+    r := raw.(interface{ rpc() *remote }).rpc()
+    r.timeout = c.timeout
+    var implemented bool
+    if err := r.call(context.Background(), "Plugin.Implemented", true, &implemented); err != nil {
+        return nil, err
+    }
+    if !implemented {
+        return nil, fmt.Errorf("%w: %s", ErrNotImplemented, name)
+    }
+    return raw, nil
+}
+
+// remote makes net/rpc calls under a timeout and the caller's context
+type remote struct {
+    client  *rpc.Client
+    timeout time.Duration
+}
+
+func (r *remote) call(ctx context.Context, method string, args, reply interface{}) error {
+    ctx, cancel := context.WithTimeout(ctx, r.timeout)
+    defer cancel()
+
+    call := r.client.Go(method, args, reply, make(chan *rpc.Call, 1))
+    select {
+    case <-call.Done:
+        if call.Error != nil {
+            return fmt.Errorf("%w: %s: %v", ErrPluginCall, method, call.Error)
+        }
+        return nil
+    case <-ctx.Done():
+        return fmt.Errorf("%w: %s: %v", ErrPluginCall, method, ctx.Err())
+    }
+}
@@ -0,0 +1,82 @@
+package extension
+
+import (
+    "context"
+    "net/rpc"
+    "time"
+
+    goplugin "github.com/hashicorp/go-plugin"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/revocation"
+)
+
+// revocationPlugin carries a revocation.Store over net/rpc
+type revocationPlugin struct {
+    impl revocation.Store
+}
+
+func (p *revocationPlugin) Server(*goplugin.MuxBroker) (interface{}, error) {
+    return &revocationServer{impl: p.impl}, nil
+}
+
+func (p *revocationPlugin) Client(_ *goplugin.MuxBroker, c *rpc.Client) (interface{}, error) {
+    return &revocationClient{r: &remote{client: c, timeout: DefaultCallTimeout}}, nil
+}
+
+type revokeWhereArgs struct {
+    Predicate revocation.Predicate
+    Cutoff    time.Time
+}
+
+type revokeTokenArgs struct {
+    JTI       string
+    ExpiresAt time.Time
+}
+
+// revocationServer runs in the plugin; net/rpc carries no context, so calls get a fresh one
+// and the gateway's timeout abandons them on its side
+type revocationServer struct {
+    impl revocation.Store
+}
+
+func (s *revocationServer) Implemented(_ bool, reply *bool) error {
+    *reply = s.impl != nil
+    return nil
+}
+
+func (s *revocationServer) RevokeWhere(args revokeWhereArgs, _ *struct{}) error {
+    return s.impl.RevokeWhere(context.Background(), args.Predicate, args.Cutoff)
+}
+
+func (s *revocationServer) RevokeToken(args revokeTokenArgs, _ *struct{}) error {
+    return s.impl.RevokeToken(context.Background(), args.JTI, args.ExpiresAt)
+}
+
+func (s *revocationServer) IsRevoked(args revocation.Token, reply *bool) error {
+    revoked, err := s.impl.IsRevoked(context.Background(), args)
+    *reply = revoked
+    return err
+}
+
+// revocationClient is the plugin's store as the gateway sees it
+type revocationClient struct {
+    r *remote
+}
+
+func (c *revocationClient) rpc() *remote {
+    return c.r
+}
+
+func (c *revocationClient) RevokeWhere(ctx context.Context, p revocation.Predicate, cutoff time.Time) error {
+    return c.r.call(ctx, "Plugin.RevokeWhere", revokeWhereArgs{Predicate: p, Cutoff: cutoff}, new(struct{}))
+}
+
+func (c *revocationClient) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+    return c.r.call(ctx, "Plugin.RevokeToken", revokeTokenArgs{JTI: jti, ExpiresAt: expiresAt}, new(struct{}))
+}
+
+func (c *revocationClient) IsRevoked(ctx context.Context, t revocation.Token) (bool, error) {
+    var revoked bool
+    err := c.r.call(ctx, "Plugin.IsRevoked", t, &revoked)
+    return revoked, err
+}
@@ -0,0 +1,132 @@
+// Package federation discovers peer Volly deployments from their domain name. A deployment
+// publishes a federation descriptor at https://<domain>/.well-known/volly-federation naming
+// its gateway and the JWKS of its public keys, and may anchor it in DNS under
+// _volly-federation.<domain>:
+//
+//  _volly-federation.example.com. TXT   "v=volly1; url=https://volly.example.com/fed.json; sha256=<hex>"
+//  _volly-federation.example.com. HTTPS 1 volly.example.com.
+//
+// The TXT record can point at the descriptor and pin its SHA-256; an HTTPS record names the
+// host serving the well-known path instead. When the records are DNSSEC-signed the pin ties
+// the descriptor to the zone, not just to whoever holds a TLS certificate for the domain.
+// Without records, discovery falls back to the well-known path on the domain itself unless
+// DNSSEC is required
+package federation
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/url"
+    "strings"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/dpop"
+)
+
+// DescriptorVersion is the descriptor format this package reads and writes
+const DescriptorVersion = 1
+
+// WellKnownPath is where a deployment serves its descriptor
+const WellKnownPath = "/.well-known/volly-federation"
+
+var ErrInvalidDescriptor = errors.New("federation descriptor is malformed")
+
+// Descriptor is what a deployment tells its peers about itself
+type Descriptor struct {
+    Version int    `json:"version"`
+    Domain  string `json:"domain"`
+    // Issuer is the iss of the deployment's tokens, when it sets one
+    Issuer       string `json:"issuer,omitempty"`
+    GatewayURL   string `json:"gatewayUrl"`
+    KeyserverURL string `json:"keyserverUrl,omitempty"`
+    JWKSURI      string `json:"jwksUri"`
+}
+
+// Validate checks d is complete and only points at https URLs
+func (d *Descriptor) Validate() error {
+    if d.Version != DescriptorVersion {
+        return fmt.Errorf("%w: version %d", ErrInvalidDescriptor, d.Version)
+    }
+    if d.Domain == "" {
+        return fmt.Errorf("%w: domain is required", ErrInvalidDescriptor)
+    }
+    for name, u := range map[string]string{"gatewayUrl": d.GatewayURL, "jwksUri": d.JWKSURI, "keyserverUrl": d.KeyserverURL} {
+        if u == "" && name == "keyserverUrl" {
+            continue
+        }
+        if err := checkHTTPS(u); err != nil {
+            return fmt.Errorf("%w: %s: %v", ErrInvalidDescriptor, name, err)
+        }
+    }
+    return nil
+}
+
+// ParseDescriptor decodes and validates a descriptor claiming to be domain's
+func ParseDescriptor(data []byte, domain string) (*Descriptor, error) {
+    var d Descriptor
+    if err := json.Unmarshal(data, &d); err != nil {
+        return nil, fmt.Errorf("%w: %v", ErrInvalidDescriptor, err)
+    }
+    if err := d.Validate(); err != nil {
+        return nil, err
+    }
+    if !strings.EqualFold(d.Domain, domain) {
+        return nil, fmt.Errorf("%w: describes %q, not %q", ErrInvalidDescriptor, d.Domain, domain)
+    }
+    return &d, nil
+}
+
+// Key is one public key in a peer's JWKS
+type Key struct {
+    dpop.JWK
+    Kid string `json:"kid"`
+    Use string `json:"use,omitempty"`
+}
+
+// ParseJWKS decodes a JWKS as vollyctl ceremony writes it; it must hold a key
+func ParseJWKS(data []byte) ([]Key, error) {
+    var set struct {
+        Keys []Key `json:"keys"`
+    }
+    if err := json.Unmarshal(data, &set); err != nil {
+        return nil, fmt.Errorf("%w: jwks: %v", ErrInvalidDescriptor, err)
+    }
+    if len(set.Keys) == 0 {
+        return nil, fmt.Errorf("%w: jwks holds no keys", ErrInvalidDescriptor)
+    }
+    return set.Keys, nil
+}
+
+// Peer is a discovered deployment
+type Peer struct {
+    Domain     string      `json:"domain"`
+    Descriptor *Descriptor `json:"descriptor"`
+    Keys       []Key       `json:"keys"`
+    // Source is how the descriptor was found: SourceTXT, SourceHTTPS or SourceWellKnown
+    Source string `json:"source"`
+    // DNSSEC is set when the DNS records were authenticated
+    DNSSEC       bool      `json:"dnssec"`
+    DiscoveredAt time.Time `json:"discoveredAt"`
+}
+
+// Key returns the peer's key with kid
+func (p *Peer) Key(kid string) (Key, bool) {
+    for _, k := range p.Keys {
+        if k.Kid == kid {
+            return k, true
+        }
+    }
+    return Key{}, false
+}
+
+func checkHTTPS(raw string) error {
+    u, err := url.Parse(raw)
+    if err != nil {
+        return err
+    }
+    if u.Scheme != "https" || u.Host == "" {
+        return fmt.Errorf("%q is not an https URL", raw)
+    }
+    return nil
+}
@@ -0,0 +1,198 @@
+package federation
+
+import (
+    "context"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "strings"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/cache"
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+)
+
+// Where a descriptor was found
+const (
+    SourceTXT       = "dns-txt"
+    SourceHTTPS     = "dns-https"
+    SourceWellKnown = "well-known"
+)
+
+// txtVersion starts every federation TXT record
+const txtVersion = "volly1"
+
+var (
+    ErrNoRecords      = errors.New("domain publishes no federation records")
+    ErrInsecureDNS    = errors.New("federation records are not DNSSEC-authenticated")
+    ErrInvalidRecord  = errors.New("federation TXT record is malformed")
+    ErrPinMismatch    = errors.New("federation descriptor does not match the pin in DNS")
+    ErrPeerNotAllowed = errors.New("domain is not a configured federation peer")
+)
+
+// Discoverer finds peers' descriptors and keys. Documents are fetched through a cache so a
+// peer whose endpoints are briefly down keeps resolving from its last good copies
+type Discoverer struct {
+    resolver      Resolver
+    docs          *cache.Documents
+    clock         clock.Clock
+    requireDNSSEC bool
+}
+
+// NewDiscoverer looks records up with resolver and fetches through docs
+func NewDiscoverer(resolver Resolver, docs *cache.Documents) *Discoverer {
+    return &Discoverer{resolver: resolver, docs: docs, clock: clock.System}
+}
+
+// SetClock sets the time source for DiscoveredAt
+func (d *Discoverer) SetClock(c clock.Clock) *Discoverer {
+    d.clock = c
+    return d
+}
+
+// SetRequireDNSSEC refuses peers without DNSSEC-authenticated records, and so without the
+// well-known fallback
+func (d *Discoverer) SetRequireDNSSEC(require bool) *Discoverer {
+    d.requireDNSSEC = require
+    return d
+}
+
+// txtRecord is a parsed federation TXT record
+type txtRecord struct {
+    url string
+    pin []byte
+}
+
+// parseTXT reads "v=volly1; url=...; sha256=..."; records for other versions are skipped
+func parseTXT(txt []string) (*txtRecord, error) {
+    for _, t := range txt {
+        fields := make(map[string]string)
+        for _, part := range strings.Split(t, ";") {
+            k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+            fields[strings.ToLower(k)] = strings.TrimSpace(v)
+        }
+        if fields["v"] != txtVersion {
+            continue
+        }
+        rec := &txtRecord{url: fields["url"]}
+        if rec.url != "" {
+            if err := checkHTTPS(rec.url); err != nil {
+                return nil, fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+            }
+        }
+        if pin := fields["sha256"]; pin != "" {
+            sum, err := hex.DecodeString(pin)
+            if err != nil || len(sum) != sha256.Size {
+                return nil, fmt.Errorf("%w: sha256 must be 64 hex digits", ErrInvalidRecord)
+            }
+            rec.pin = sum
+        }
+        return rec, nil
+    }
+    return nil, nil
+}
+
+// Discover finds domain's descriptor and keys: from the URL in its TXT record, else from
+// the host in its HTTPS record, else from the domain's well-known path
+func (d *Discoverer) Discover(ctx context.Context, domain string) (*Peer, error) {
+    domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+    recs, err := d.resolver.Lookup(ctx, domain)
+    if err != nil {
+        return nil, err
+    }
+    txt, err := parseTXT(recs.TXT)
+    if err != nil {
+        return nil, err
+    }
+    found := txt != nil || len(recs.HTTPS) > 0
+    switch {
+    case found && !recs.Authenticated && d.requireDNSSEC:
+        return nil, fmt.Errorf("%w: %s", ErrInsecureDNS, domain)
+    case !found && d.requireDNSSEC:
+        return nil, fmt.Errorf("%w: %s", ErrNoRecords, domain)
+    }
+
+    peer := &Peer{Domain: domain, DNSSEC: found && recs.Authenticated, Source: SourceWellKnown}
+    descriptorURL := "https://" + domain + WellKnownPath
+    switch {
+    case txt != nil && txt.url != "":
+        descriptorURL, peer.Source = txt.url, SourceTXT
+    case len(recs.HTTPS) > 0:
+        descriptorURL, peer.Source = "https://"+recs.HTTPS[0]+WellKnownPath, SourceHTTPS
+    }
+
+    check := func(body []byte) error {
+        if txt != nil && !matchesPin(body, txt.pin) {
+            return ErrPinMismatch
+        }
+        _, err := ParseDescriptor(body, domain)
+        return err
+    }
+    body, err := d.docs.Get(ctx, descriptorURL, check)
+    // The cache only holds bodies that passed the check, but the pin may have changed since
+    if err == nil && txt != nil && !matchesPin(body, txt.pin) {
+        d.docs.Invalidate(descriptorURL)
+        body, err = d.docs.Get(ctx, descriptorURL, check)
+    }
+    if err != nil {
+        return nil, err
+    }
+    if peer.Descriptor, err = ParseDescriptor(body, domain); err != nil {
+        return nil, err
+    }
+
+    jwks, err := d.docs.Get(ctx, peer.Descriptor.JWKSURI, func(body []byte) error {
+        _, err := ParseJWKS(body)
+        return err
+    })
+    if err != nil {
+        return nil, err
+    }
+    if peer.Keys, err = ParseJWKS(jwks); err != nil {
+        return nil, err
+    }
+    peer.DiscoveredAt = d.clock.Now()
+    return peer, nil
+}
+
+// matchesPin reports whether body hashes to pin; no pin matches anything
+func matchesPin(body, pin []byte) bool {
+    if pin == nil {
+        return true
+    }
+    sum := sha256.Sum256(body)
+    return subtle.ConstantTimeCompare(sum[:], pin) == 1
+}
+
+// Peers discovers only the domains it was created with, so a caller-supplied name cannot
+// make the deployment fetch from arbitrary hosts
+type Peers struct {
+    discoverer *Discoverer
+    domains    []string
+}
+
+// NewPeers allows domains
+func NewPeers(discoverer *Discoverer, domains []string) *Peers {
+    normalized := make([]string, len(domains))
+    for i, domain := range domains {
+        normalized[i] = strings.ToLower(strings.TrimSuffix(domain, "."))
+    }
+    return &Peers{discoverer: discoverer, domains: normalized}
+}
+
+// Domains lists the configured peers
+func (p *Peers) Domains() []string {
+    return p.domains
+}
+
+// Get discovers domain when it is a configured peer
+func (p *Peers) Get(ctx context.Context, domain string) (*Peer, error) {
+    domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+    for _, allowed := range p.domains {
+        if allowed == domain {
+            return p.discoverer.Discover(ctx, domain)
+        }
+    }
+    return nil, fmt.Errorf("%w: %q", ErrPeerNotAllowed, domain)
+}
@@ -0,0 +1,125 @@
+package federation
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net"
+    "sort"
+    "strings"
+
+    "github.com/miekg/dns"
+)
+
+// RecordPrefix is prepended to a domain to find its federation records
+const RecordPrefix = "_volly-federation."
+
+var ErrDNSLookup = errors.New("federation DNS lookup failed")
+
+// Records are the federation records found for a domain
+type Records struct {
+    TXT []string
+    // HTTPS holds the hosts, with any port, named by HTTPS records in priority order
+    HTTPS []string
+    // Authenticated is set when the resolver validated every answer with DNSSEC
+    Authenticated bool
+}
+
+// Resolver looks up a domain's federation records
+type Resolver interface {
+    Lookup(ctx context.Context, domain string) (Records, error)
+}
+
+// DNSResolver asks a recursive resolver with the DNSSEC OK bit set and trusts its AD bit.
+// The resolver therefore must validate and be reached over a path nobody can tamper with,
+// such as a local validating resolver on the loopback address
+type DNSResolver struct {
+    server string
+    client *dns.Client
+}
+
+// NewDNSResolver asks server, host:port; an empty server uses the first nameserver in
+// /etc/resolv.conf
+func NewDNSResolver(server string) (*DNSResolver, error) {
+    if server == "" {
+        conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+        if err != nil {
+            return nil, err
+        }
+        if len(conf.Servers) == 0 {
+            return nil, errors.New("/etc/resolv.conf names no nameserver")
+        }
+        server = net.JoinHostPort(conf.Servers[0], conf.Port)
+    }
+    return &DNSResolver{server: server, client: new(dns.Client)}, nil
+}
+
+func (r *DNSResolver) Lookup(ctx context.Context, domain string) (Records, error) {
+    name := dns.Fqdn(RecordPrefix + domain)
+    txt, txtAD, err := r.query(ctx, name, dns.TypeTXT)
+    if err != nil {
+        return Records{}, err
+    }
+    https, httpsAD, err := r.query(ctx, name, dns.TypeHTTPS)
+    if err != nil {
+        return Records{}, err
+    }
+    recs := Records{Authenticated: txtAD && httpsAD}
+    for _, rr := range txt {
+        if t, ok := rr.(*dns.TXT); ok {
+            recs.TXT = append(recs.TXT, strings.Join(t.Txt, ""))
+        }
+    }
+    recs.HTTPS = httpsHosts(https, domain)
+    return recs, nil
+}
+
+// query asks for name's records of qtype, retrying over TCP when the answer is truncated.
+// A name that does not exist has no records, which DNSSEC can authenticate as well
+func (r *DNSResolver) query(ctx context.Context, name string, qtype uint16) ([]dns.RR, bool, error) {
+    m := new(dns.Msg)
+    m.SetQuestion(name, qtype)
+    m.SetEdns0(4096, true)
+    m.AuthenticatedData = true
+
+    in, _, err := r.client.ExchangeContext(ctx, m, r.server)
+    if err == nil && in.Truncated {
+        tcp := &dns.Client{Net: "tcp"}
+        in, _, err = tcp.ExchangeContext(ctx, m, r.server)
+    }
+    if err != nil {
+        return nil, false, fmt.Errorf("%w: %s: %v", ErrDNSLookup, name, err)
+    }
+    switch in.Rcode {
+    case dns.RcodeSuccess, dns.RcodeNameError:
+    default:
+        return nil, false, fmt.Errorf("%w: %s: %s", ErrDNSLookup, name, dns.RcodeToString[in.Rcode])
+    }
+    return in.Answer, in.AuthenticatedData, nil
+}
+
+// httpsHosts turns HTTPS records into hosts, lowest priority first; a target of "." means
+// the domain itself
+func httpsHosts(rrs []dns.RR, domain string) []string {
+    var records []*dns.HTTPS
+    for _, rr := range rrs {
+        if h, ok := rr.(*dns.HTTPS); ok {
+            records = append(records, h)
+        }
+    }
+    sort.SliceStable(records, func(i, j int) bool { return records[i].Priority < records[j].Priority })
+    var hosts []string
+    for _, h := range records {
+        host := strings.TrimSuffix(h.Target, ".")
+        if host == "" {
+            host = domain
+        }
+        for _, kv := range h.Value {
+            if p, ok := kv.(*dns.SVCBPort); ok {
+                host = net.JoinHostPort(host, fmt.Sprint(p.Port))
+            }
+        }
+        hosts = append(hosts, host)
+    }
+    return hosts
+}
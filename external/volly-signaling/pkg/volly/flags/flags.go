@@ -0,0 +1,186 @@
+// Package flags lets a feature flag service toggle per-tenant and per-room features at
+// runtime. Issuance and admission ask a Provider, here or through the OpenFeature adapter,
+// about a Target and fall back to the configured default whenever the provider fails, so
+// an outage of the flag service changes nothing
+package flags
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "log"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+)
+
+// Flags consulted by tokend and the gateway
+const (
+    // E2EERequired refuses admission to tokens without the ML-KEM key end-to-end
+    // encrypted rooms wrap media keys to
+    E2EERequired = "e2ee-required"
+    // ViewerTokens lets tokend issue and the gateway admit subscribe-only viewer tokens
+    ViewerTokens = "viewer-tokens"
+    // HandshakeV2 offers protocol version 2 on room event streams
+    HandshakeV2 = "handshake-v2"
+)
+
+// Defaults for the cache in front of a provider and the bound on one evaluation
+const (
+    DefaultCacheTTL   = 5 * time.Second
+    DefaultTimeout    = 250 * time.Millisecond
+    DefaultMaxEntries = 10000
+)
+
+// Target is what a flag is evaluated for; fields not known at the call are empty
+type Target struct {
+    Tenant   string
+    Room     string
+    Identity string
+}
+
+// Provider evaluates boolean flags
+type Provider interface {
+    Bool(ctx context.Context, flag string, target Target, defaultValue bool) (bool, error)
+}
+
+// ProviderFunc adapts a function to Provider
+type ProviderFunc func(ctx context.Context, flag string, target Target, defaultValue bool) (bool, error)
+
+// Bool calls f
+func (f ProviderFunc) Bool(ctx context.Context, flag string, target Target, defaultValue bool) (bool, error) {
+    return f(ctx, flag, target, defaultValue)
+}
+
+type cacheKey struct {
+    flag   string
+    target Target
+}
+
+type cached struct {
+    value   bool
+    expires time.Time
+}
+
+type statKey struct {
+    flag, result string
+}
+
+// Flags answers flag questions from a provider, caching answers briefly so admissions do not
+// wait on the flag service. A nil *Flags or one without a provider answers every question
+// with its default. Safe for concurrent use
+type Flags struct {
+    provider   Provider
+    ttl        time.Duration
+    timeout    time.Duration
+    maxEntries int
+    clock      clock.Clock
+
+    mu    sync.Mutex
+    cache map[cacheKey]cached
+    stats map[statKey]int64
+}
+
+// New creates flags backed by provider
+func New(provider Provider) *Flags {
+    return &Flags{
+        provider:   provider,
+        ttl:        DefaultCacheTTL,
+        timeout:    DefaultTimeout,
+        maxEntries: DefaultMaxEntries,
+        clock:      clock.System,
+        cache:      make(map[cacheKey]cached),
+        stats:      make(map[statKey]int64),
+    }
+}
+
+// SetCacheTTL sets how long an answer is reused; zero asks the provider every time
+func (f *Flags) SetCacheTTL(d time.Duration) *Flags {
+    f.ttl = d
+    return f
+}
+
+// SetTimeout bounds one provider evaluation
+func (f *Flags) SetTimeout(d time.Duration) *Flags {
+    f.timeout = d
+    return f
+}
+
+// SetMaxEntries bounds the cache; it is emptied when full
+func (f *Flags) SetMaxEntries(n int) *Flags {
+    f.maxEntries = n
+    return f
+}
+
+// SetClock sets the time source for cache expiry
+func (f *Flags) SetClock(c clock.Clock) *Flags {
+    f.clock = c
+    return f
+}
+
+// Bool evaluates flag for target, or returns defaultValue when the provider cannot
+func (f *Flags) Bool(ctx context.Context, flag string, target Target, defaultValue bool) bool {
+    if f == nil || f.provider == nil {
+        return defaultValue
+    }
+    key := cacheKey{flag, target}
+    now := f.clock.Now()
+    f.mu.Lock()
+    if c, ok := f.cache[key]; ok && now.Before(c.expires) {
+        f.mu.Unlock()
+        return c.value
+    }
+    f.mu.Unlock()
+
+    if f.timeout > 0 {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, f.timeout)
+        defer cancel()
+    }
+    value, err := f.provider.Bool(ctx, flag, target, defaultValue)
+    result := fmt.Sprint(value)
+    if err != nil {
+        log.Printf("flags: %s: %v; using the default %v", flag, err, defaultValue)
+        value, result = defaultValue, "error"
+    }
+
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.stats[statKey{flag, result}]++
+    if err == nil && f.ttl > 0 {
+        if f.maxEntries > 0 && len(f.cache) >= f.maxEntries {
+            f.cache = make(map[cacheKey]cached)
+        }
+        f.cache[key] = cached{value: value, expires: now.Add(f.ttl)}
+    }
+    return value
+}
+
+// WriteMetrics writes provider evaluations by flag and result in the Prometheus text format
+func (f *Flags) WriteMetrics(w io.Writer) error {
+    f.mu.Lock()
+    keys := make([]statKey, 0, len(f.stats))
+    for k := range f.stats {
+        keys = append(keys, k)
+    }
+    counts := make(map[statKey]int64, len(f.stats))
+    for k, v := range f.stats {
+        counts[k] = v
+    }
+    f.mu.Unlock()
+    sort.Slice(keys, func(i, j int) bool {
+        if keys[i].flag != keys[j].flag {
+            return keys[i].flag < keys[j].flag
+        }
+        return keys[i].result < keys[j].result
+    })
+    fmt.Fprintf(w, "# HELP volly_flag_evaluations_total Feature flag evaluations by the provider, by result.\n# TYPE volly_flag_evaluations_total counter\n")
+    for _, k := range keys {
+        if _, err := fmt.Fprintf(w, "volly_flag_evaluations_total{flag=%q,result=%q} %d\n", k.flag, k.result, counts[k]); err != nil {
+            return err
+        }
+    }
+    return nil
+}
@@ -0,0 +1,106 @@
+package flags
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/url"
+    "strings"
+)
+
+var ErrNotBoolean = errors.New("flag value is not a boolean")
+
+// OFREP evaluates flags through the OpenFeature Remote Evaluation Protocol, which flagd,
+// GO Feature Flag, Flipt and other OpenFeature providers serve, so any of them can drive
+// Volly without an SDK. The target becomes the evaluation context: the identity, or the
+// tenant when there is none, is the targeting key, with tenant and room as attributes
+type OFREP struct {
+    endpoint string
+    headers  http.Header
+    client   *http.Client
+}
+
+// NewOFREP creates a provider for the OFREP service at endpoint, e.g. http://flagd:8016
+func NewOFREP(endpoint string) *OFREP {
+    return &OFREP{
+        endpoint: strings.TrimSuffix(endpoint, "/"),
+        headers:  make(http.Header),
+        client:   &http.Client{},
+    }
+}
+
+// SetHeader sends a header, such as Authorization, with every evaluation
+func (o *OFREP) SetHeader(name, value string) *OFREP {
+    o.headers.Set(name, value)
+    return o
+}
+
+// SetHTTPClient replaces the client used to call the service
+func (o *OFREP) SetHTTPClient(client *http.Client) *OFREP {
+    o.client = client
+    return o
+}
+
+type ofrepRequest struct {
+    Context map[string]string `json:"context"`
+}
+
+type ofrepResponse struct {
+    Value        interface{} `json:"value"`
+    ErrorCode    string      `json:"errorCode"`
+    ErrorDetails string      `json:"errorDetails"`
+}
+
+// Bool evaluates flag. A flag the service does not know is defaultValue, not an error
+func (o *OFREP) Bool(ctx context.Context, flag string, target Target, defaultValue bool) (bool, error) {
+    evalCtx := map[string]string{"targetingKey": target.Identity}
+    if target.Identity == "" {
+        evalCtx["targetingKey"] = target.Tenant
+    }
+    if target.Tenant != "" {
+        evalCtx["tenant"] = target.Tenant
+    }
+    if target.Room != "" {
+        evalCtx["room"] = target.Room
+    }
+    body, err := json.Marshal(ofrepRequest{Context: evalCtx})
+    if err != nil {
+        return defaultValue, err
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.endpoint+"/ofrep/v1/evaluate/flags/"+url.PathEscape(flag), bytes.NewReader(body))
+    if err != nil {
+        return defaultValue, err
+    }
+    for name, values := range o.headers {
+        req.Header[name] = values
+    }
+    req.Header.Set("Content-Type", "application/json")
+
+    resp, err := o.client.Do(req)
+    if err != nil {
+        return defaultValue, err
+    }
+    defer resp.Body.Close()
+
+    var result ofrepResponse
+    decodeErr := json.NewDecoder(resp.Body).Decode(&result)
+    switch {
+    case resp.StatusCode == http.StatusNotFound || result.ErrorCode == "FLAG_NOT_FOUND":
+        return defaultValue, nil
+    case resp.StatusCode != http.StatusOK:
+        if result.ErrorCode != "" {
+            return defaultValue, fmt.Errorf("ofrep: %s: %s %s", resp.Status, result.ErrorCode, result.ErrorDetails)
+        }
+        return defaultValue, fmt.Errorf("ofrep: %s", resp.Status)
+    case decodeErr != nil:
+        return defaultValue, decodeErr
+    }
+    value, ok := result.Value.(bool)
+    if !ok {
+        return defaultValue, fmt.Errorf("%w: %s", ErrNotBoolean, flag)
+    }
+    return value, nil
+}
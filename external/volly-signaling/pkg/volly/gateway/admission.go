@@ -0,0 +1,52 @@
+package gateway
+
+import (
+    "context"
+    "net/netip"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/biscuit"
+)
+
+// Admission describes a connection whose token has been verified and is awaiting entry
+type Admission struct {
+    Identity string
+    Room     string
+    RemoteIP netip.Addr
+    Grant    *auth.VollyVideoGrant
+
+    // KeyProof is the client's answer to the handshake challenge for cnf-bound tokens
+    KeyProof *KeyProof
+
+    // PIN is the room access code the participant entered, if the room asked for one
+    PIN string
+
+    // Biscuit is set instead of Grant when the client presented a biscuit credential
+    Biscuit *biscuit.Biscuit
+}
+
+// AdmissionHook can reject a verified connection before it joins the room
+type AdmissionHook interface {
+    Admit(ctx context.Context, a *Admission) error
+}
+
+// AdmissionHookFunc adapts a function to AdmissionHook
+type AdmissionHookFunc func(ctx context.Context, a *Admission) error
+
+// Admit calls f
+func (f AdmissionHookFunc) Admit(ctx context.Context, a *Admission) error {
+    return f(ctx, a)
+}
+
+// AdmissionChain runs hooks in order and stops at the first rejection
+type AdmissionChain []AdmissionHook
+
+// Admit runs every hook in the chain
+func (c AdmissionChain) Admit(ctx context.Context, a *Admission) error {
+    for _, hook := range c {
+        if err := hook.Admit(ctx, a); err != nil {
+            return err
+        }
+    }
+    return nil
+}
@@ -0,0 +1,51 @@
+package gateway
+
+import (
+    "context"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/biscuit"
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+)
+
+// BiscuitPolicy authorizes connections presenting a biscuit credential against local
+// Datalog policies, so edge gateways can admit derived credentials without the issuer.
+// The authorizer sees time($unix), identity($id), room($room), operation("join") and,
+// when known, remote_ip($ip)
+type BiscuitPolicy struct {
+    code  string
+    clock clock.Clock
+}
+
+// NewBiscuitPolicy validates the policy code up front, e.g.
+// allow if right($room, "join"), room($room)
+func NewBiscuitPolicy(code string) (*BiscuitPolicy, error) {
+    if err := biscuit.NewAuthorizer(nil).AddCode(code); err != nil {
+        return nil, err
+    }
+    return &BiscuitPolicy{code: code, clock: clock.System}, nil
+}
+
+// SetClock sets the time source for the time fact
+func (p *BiscuitPolicy) SetClock(c clock.Clock) *BiscuitPolicy {
+    p.clock = c
+    return p
+}
+
+// Admit runs the credential's checks and the gateway policies; JWT connections pass through
+func (p *BiscuitPolicy) Admit(ctx context.Context, a *Admission) error {
+    if a.Biscuit == nil {
+        return nil
+    }
+    authorizer := biscuit.NewAuthorizer(a.Biscuit).
+        AddFact(biscuit.Fact("time", p.clock.Now().Unix())).
+        AddFact(biscuit.Fact("identity", a.Identity)).
+        AddFact(biscuit.Fact("room", a.Room)).
+        AddFact(biscuit.Fact("operation", "join"))
+    if a.RemoteIP.IsValid() {
+        authorizer.AddFact(biscuit.Fact("remote_ip", a.RemoteIP.Unmap().String()))
+    }
+    if err := authorizer.AddCode(p.code); err != nil {
+        return err
+    }
+    return authorizer.Authorize()
+}
@@ -0,0 +1,395 @@
+package gateway
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "net/netip"
+    "strconv"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+)
+
+// DefaultRetryAfter is the wait suggested to rejected clients
+const DefaultRetryAfter = 5 * time.Second
+
+// Rejection reasons reported to clients
+const (
+    RejectInstanceFull = "instance_full"
+    RejectTenantLimit  = "tenant_limit"
+    RejectIPLimit      = "ip_limit"
+)
+
+var (
+    ErrConnectionLimit = errors.New("connection limit reached")
+    ErrLeaseNotFound   = errors.New("connection lease not found")
+)
+
+// ConnectionLimitError says which cap turned a connection away; it matches ErrConnectionLimit
+type ConnectionLimitError struct {
+    Reason     string
+    Limit      int
+    RetryAfter time.Duration
+}
+
+func (e *ConnectionLimitError) Error() string {
+    return ErrConnectionLimit.Error() + ": " + e.Reason + " (" + strconv.Itoa(e.Limit) + ")"
+}
+
+func (e *ConnectionLimitError) Unwrap() error { return ErrConnectionLimit }
+
+// ConnectionLimits cap concurrent connections; zero fields are unlimited
+type ConnectionLimits struct {
+    MaxConnections int `json:"maxConnections,omitempty"`
+    MaxPerTenant   int `json:"maxPerTenant,omitempty"`
+    MaxPerIP       int `json:"maxPerIP,omitempty"`
+}
+
+// Lease holds one connection slot until it is released or, without renewal, expires
+type Lease struct {
+    ID        string     `json:"id"`
+    Tenant    string     `json:"tenant,omitempty"`
+    RemoteIP  netip.Addr `json:"remoteIP,omitempty"`
+    ExpiresAt time.Time  `json:"expiresAt,omitempty"`
+}
+
+// ConnectionStats counts ConnectionLimiter work
+type ConnectionStats struct {
+    Active   int               `json:"active"`
+    Queued   int               `json:"queued"`
+    Tenants  int               `json:"tenants"`
+    Admitted uint64            `json:"admitted"`
+    Rejected map[string]uint64 `json:"rejected,omitempty"`
+}
+
+type connWaiter struct {
+    tenant string
+    ip     netip.Addr
+    ready  chan struct{}
+    lease  *Lease
+}
+
+// ConnectionLimiter caps connections per instance, tenant and source IP. Tenant and IP caps
+// count waiting connections too and reject at once, since waiting cannot free a caller's own
+// slots. When only the instance is full, connections wait in per-tenant queues and freed
+// slots go to each waiting tenant in turn, so a surge from one tenant queues behind itself
+type ConnectionLimiter struct {
+    limits       ConnectionLimits
+    maxQueue     int
+    queueTimeout time.Duration
+    leaseTTL     time.Duration
+    clock        clock.Clock
+    onExpire     func(lease Lease)
+
+    mu        sync.Mutex
+    tenantMax map[string]int
+    leases    map[string]*Lease
+    active    int
+    tenants   map[string]int
+    ips       map[netip.Addr]int
+    queues    map[string][]*connWaiter
+    order     []string
+    next      int
+    queued    int
+    admitted  uint64
+    rejected  map[string]uint64
+}
+
+// NewConnectionLimiter creates a limiter whose connections do not wait and whose leases
+// never expire
+func NewConnectionLimiter(limits ConnectionLimits) *ConnectionLimiter {
+    return &ConnectionLimiter{
+        limits:    limits,
+        clock:     clock.System,
+        tenantMax: make(map[string]int),
+        leases:    make(map[string]*Lease),
+        tenants:   make(map[string]int),
+        ips:       make(map[netip.Addr]int),
+        queues:    make(map[string][]*connWaiter),
+        rejected:  make(map[string]uint64),
+    }
+}
+
+// SetQueue lets up to max connections wait as long as timeout for an instance slot
+func (l *ConnectionLimiter) SetQueue(max int, timeout time.Duration) *ConnectionLimiter {
+    l.mu.Lock()
+    l.maxQueue, l.queueTimeout = max, timeout
+    l.mu.Unlock()
+    return l
+}
+
+// SetLeaseTTL expires leases not renewed within ttl, so slots of connections whose edge went
+// away are reclaimed by Run
+func (l *ConnectionLimiter) SetLeaseTTL(ttl time.Duration) *ConnectionLimiter {
+    l.leaseTTL = ttl
+    return l
+}
+
+// SetOnExpire calls fn with each lease reclaimed because it was not renewed in time, so
+// state kept per connection can be dropped with it
+func (l *ConnectionLimiter) SetOnExpire(fn func(lease Lease)) *ConnectionLimiter {
+    l.onExpire = fn
+    return l
+}
+
+// SetClock sets the time source for lease expiry
+func (l *ConnectionLimiter) SetClock(c clock.Clock) *ConnectionLimiter {
+    l.clock = c
+    return l
+}
+
+// SetTenantLimit overrides MaxPerTenant for one tenant
+func (l *ConnectionLimiter) SetTenantLimit(tenant string, max int) *ConnectionLimiter {
+    l.mu.Lock()
+    l.tenantMax[tenant] = max
+    l.mu.Unlock()
+    return l
+}
+
+// Acquire takes a slot for a connection from tenant at ip, which may be the zero Addr when
+// unknown, waiting its turn while the instance is full. Rejections are *ConnectionLimitError
+func (l *ConnectionLimiter) Acquire(ctx context.Context, tenant string, ip netip.Addr) (Lease, error) {
+    l.mu.Lock()
+    tenantMax := l.limits.MaxPerTenant
+    if max, ok := l.tenantMax[tenant]; ok {
+        tenantMax = max
+    }
+    if tenantMax > 0 && l.tenants[tenant] >= tenantMax {
+        l.mu.Unlock()
+        return Lease{}, l.reject(RejectTenantLimit, tenantMax)
+    }
+    if l.limits.MaxPerIP > 0 && ip.IsValid() && l.ips[ip] >= l.limits.MaxPerIP {
+        l.mu.Unlock()
+        return Lease{}, l.reject(RejectIPLimit, l.limits.MaxPerIP)
+    }
+
+    // Arrivals join the queue while anyone waits, so nobody skips ahead of it
+    if l.limits.MaxConnections <= 0 || l.active < l.limits.MaxConnections && l.queued == 0 {
+        l.hold(tenant, ip)
+        lease := l.grantLocked(tenant, ip)
+        l.mu.Unlock()
+        return *lease, nil
+    }
+    if l.queued >= l.maxQueue || l.queueTimeout <= 0 {
+        l.mu.Unlock()
+        return Lease{}, l.reject(RejectInstanceFull, l.limits.MaxConnections)
+    }
+    l.hold(tenant, ip)
+    w := &connWaiter{tenant: tenant, ip: ip, ready: make(chan struct{})}
+    if len(l.queues[tenant]) == 0 {
+        l.order = append(l.order, tenant)
+    }
+    l.queues[tenant] = append(l.queues[tenant], w)
+    l.queued++
+    timeout := l.queueTimeout
+    l.mu.Unlock()
+
+    timer := time.NewTimer(timeout)
+    defer timer.Stop()
+    var err error
+    select {
+    case <-w.ready:
+        return *w.lease, nil
+    case <-ctx.Done():
+        err = ctx.Err()
+    case <-timer.C:
+        err = l.reject(RejectInstanceFull, l.limits.MaxConnections)
+    }
+
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    if w.lease != nil {
+        // Granted while giving up; pass the slot on
+        l.releaseLocked(w.lease)
+        return Lease{}, err
+    }
+    l.dequeueLocked(w)
+    l.unhold(tenant, ip)
+    return Lease{}, err
+}
+
+// Renew extends a lease by the lease ttl
+func (l *ConnectionLimiter) Renew(id string) (Lease, error) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    lease, ok := l.leases[id]
+    if !ok {
+        return Lease{}, ErrLeaseNotFound
+    }
+    if l.leaseTTL > 0 {
+        lease.ExpiresAt = l.clock.Now().Add(l.leaseTTL)
+    }
+    return *lease, nil
+}
+
+// Release frees a lease's slot for the next waiting connection
+func (l *ConnectionLimiter) Release(id string) error {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    lease, ok := l.leases[id]
+    if !ok {
+        return ErrLeaseNotFound
+    }
+    l.releaseLocked(lease)
+    return nil
+}
+
+// Run reclaims expired leases until ctx is cancelled; without a lease ttl it returns at once
+func (l *ConnectionLimiter) Run(ctx context.Context) {
+    if l.leaseTTL <= 0 {
+        return
+    }
+    ticker := time.NewTicker(l.leaseTTL / 2)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            l.expire()
+        }
+    }
+}
+
+// Stats reports slot usage and rejections by reason
+func (l *ConnectionLimiter) Stats() ConnectionStats {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    st := ConnectionStats{
+        Active:   l.active,
+        Queued:   l.queued,
+        Tenants:  len(l.tenants),
+        Admitted: l.admitted,
+        Rejected: make(map[string]uint64, len(l.rejected)),
+    }
+    for reason, n := range l.rejected {
+        st.Rejected[reason] = n
+    }
+    return st
+}
+
+func (l *ConnectionLimiter) expire() {
+    now := l.clock.Now()
+    var expired []Lease
+    l.mu.Lock()
+    for _, lease := range l.leases {
+        if now.After(lease.ExpiresAt) {
+            expired = append(expired, *lease)
+            l.releaseLocked(lease)
+        }
+    }
+    l.mu.Unlock()
+
+    if l.onExpire != nil {
+        for _, lease := range expired {
+            l.onExpire(lease)
+        }
+    }
+}
+
+// reject counts a rejection; it takes the lock itself
+func (l *ConnectionLimiter) reject(reason string, limit int) error {
+    l.mu.Lock()
+    l.rejected[reason]++
+    l.mu.Unlock()
+    return &ConnectionLimitError{Reason: reason, Limit: limit, RetryAfter: DefaultRetryAfter}
+}
+
+// hold counts a held or waiting connection against its tenant and IP
+func (l *ConnectionLimiter) hold(tenant string, ip netip.Addr) {
+    l.tenants[tenant]++
+    if ip.IsValid() {
+        l.ips[ip]++
+    }
+}
+
+func (l *ConnectionLimiter) unhold(tenant string, ip netip.Addr) {
+    if l.tenants[tenant]--; l.tenants[tenant] <= 0 {
+        delete(l.tenants, tenant)
+    }
+    if ip.IsValid() {
+        if l.ips[ip]--; l.ips[ip] <= 0 {
+            delete(l.ips, ip)
+        }
+    }
+}
+
+func (l *ConnectionLimiter) grantLocked(tenant string, ip netip.Addr) *Lease {
+    lease := &Lease{ID: newLeaseID(), Tenant: tenant, RemoteIP: ip}
+    if l.leaseTTL > 0 {
+        lease.ExpiresAt = l.clock.Now().Add(l.leaseTTL)
+    }
+    l.leases[lease.ID] = lease
+    l.active++
+    l.admitted++
+    return lease
+}
+
+// releaseLocked frees a slot and hands free slots to waiting tenants in turn
+func (l *ConnectionLimiter) releaseLocked(lease *Lease) {
+    delete(l.leases, lease.ID)
+    l.active--
+    l.unhold(lease.Tenant, lease.RemoteIP)
+
+    for l.queued > 0 && l.active < l.limits.MaxConnections {
+        if l.next >= len(l.order) {
+            l.next = 0
+        }
+        tenant := l.order[l.next]
+        queue := l.queues[tenant]
+        w := queue[0]
+        queue[0] = nil
+        if queue = queue[1:]; len(queue) == 0 {
+            delete(l.queues, tenant)
+            l.order = append(l.order[:l.next], l.order[l.next+1:]...)
+        } else {
+            l.queues[tenant] = queue
+            l.next++
+        }
+        l.queued--
+        w.lease = l.grantLocked(w.tenant, w.ip)
+        close(w.ready)
+    }
+}
+
+func (l *ConnectionLimiter) dequeueLocked(w *connWaiter) {
+    queue := l.queues[w.tenant]
+    for i, q := range queue {
+        if q != w {
+            continue
+        }
+        queue = append(queue[:i], queue[i+1:]...)
+        l.queued--
+        break
+    }
+    if len(queue) > 0 {
+        l.queues[w.tenant] = queue
+        return
+    }
+    delete(l.queues, w.tenant)
+    for i, tenant := range l.order {
+        if tenant == w.tenant {
+            l.order = append(l.order[:i], l.order[i+1:]...)
+            if l.next > i {
+                l.next--
+            }
+            break
+        }
+    }
+}
+
+func newLeaseID() string {
+    b := make([]byte, 16)
+    if _, err := rand.Read(b); err != nil {
+        panic(err)
+    }
+    return hex.EncodeToString(b)
+}
@@ -0,0 +1,35 @@
+package gateway
+
+import (
+    "context"
+    "errors"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/flags"
+)
+
+var ErrRoomRequiresE2EE = errors.New("room requires end-to-end encryption")
+
+// FeatureFlags enforces the admission features a flag provider toggles per tenant and room.
+// With flags.E2EERequired on, tokens without a post-quantum key are refused, since the
+// room's media keys are wrapped to that key
+type FeatureFlags struct {
+    flags *flags.Flags
+}
+
+// NewFeatureFlags enforces the flags f answers
+func NewFeatureFlags(f *flags.Flags) *FeatureFlags {
+    return &FeatureFlags{flags: f}
+}
+
+// Admit rejects connections a flag turns away
+func (h *FeatureFlags) Admit(ctx context.Context, a *Admission) error {
+    if a.Grant == nil {
+        return nil
+    }
+    target := flags.Target{Tenant: a.Grant.Tenant, Room: a.Room, Identity: a.Identity}
+    if a.Grant.PQStatus != auth.PQStatusPresent && h.flags.Bool(ctx, flags.E2EERequired, target, false) {
+        return ErrRoomRequiresE2EE
+    }
+    return nil
+}
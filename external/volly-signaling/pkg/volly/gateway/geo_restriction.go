@@ -0,0 +1,68 @@
+package gateway
+
+import (
+    "context"
+    "errors"
+    "net/netip"
+    "strings"
+)
+
+var (
+    ErrLocationDenied  = errors.New("token may not be used from this network location")
+    ErrLocationUnknown = errors.New("unable to determine client location")
+)
+
+// GeoIPResolver maps a client address to an ISO 3166-1 alpha-2 country code
+type GeoIPResolver interface {
+    Country(ctx context.Context, ip netip.Addr) (string, error)
+}
+
+// GeoRestriction enforces the allowedCountries and deniedCIDRs token claims
+type GeoRestriction struct {
+    resolver GeoIPResolver
+}
+
+// NewGeoRestriction creates the hook; resolver may be nil if only CIDR claims are issued
+func NewGeoRestriction(resolver GeoIPResolver) *GeoRestriction {
+    return &GeoRestriction{resolver: resolver}
+}
+
+// Admit rejects connections from denied networks or countries outside the allow list
+func (g *GeoRestriction) Admit(ctx context.Context, a *Admission) error {
+    grant := a.Grant
+    if grant == nil || (len(grant.DeniedCIDRs) == 0 && len(grant.AllowedCountries) == 0) {
+        return nil
+    }
+    // Restricted tokens fail closed when the client address is unknown
+    if !a.RemoteIP.IsValid() {
+        return ErrLocationUnknown
+    }
+    ip := a.RemoteIP.Unmap()
+
+    for _, cidr := range grant.DeniedCIDRs {
+        prefix, err := netip.ParsePrefix(cidr)
+        if err != nil {
+            return ErrLocationDenied
+        }
+        if prefix.Contains(ip) {
+            return ErrLocationDenied
+        }
+    }
+
+    if len(grant.AllowedCountries) == 0 {
+        return nil
+    }
+    if g.resolver == nil {
+        return ErrLocationUnknown
+    }
+    country, err := g.resolver.Country(ctx, ip)
+    if err != nil || country == "" {
+        return ErrLocationUnknown
+    }
+    for _, allowed := range grant.AllowedCountries {
+        if strings.EqualFold(allowed, country) {
+            return nil
+        }
+    }
+    return ErrLocationDenied
+}
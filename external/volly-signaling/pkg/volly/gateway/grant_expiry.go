@@ -0,0 +1,224 @@
+package gateway
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+)
+
+// Expiry enforcement defaults
+const (
+    DefaultExpiryInterval = time.Second
+    // DefaultExpiryStaleAfter forgets connections whose lease was not renewed for this long
+    DefaultExpiryStaleAfter = 10 * time.Minute
+)
+
+var ErrGrantEnded = errors.New("grant has reached its end time")
+
+// Expiry is what becomes of a connection whose grant ran out mid-session
+type Expiry struct {
+    ConnectionID string            `json:"connectionId"`
+    Tenant       string            `json:"tenant,omitempty"`
+    Room         string            `json:"room"`
+    Identity     string            `json:"identity"`
+    Action       auth.ExpiryAction `json:"action"`
+    // Deadline is when the grant ran out, and DisconnectAt when the connection ends; it is
+    // zero for a downgrade that lasts until the participant leaves
+    Deadline     time.Time `json:"deadline"`
+    DisconnectAt time.Time `json:"disconnectAt,omitempty"`
+    // Ended is set once the connection must be closed
+    Ended bool `json:"ended,omitempty"`
+}
+
+type expiryState struct {
+    expiry   Expiry
+    grace    time.Duration
+    seen     time.Time
+    reported int
+}
+
+// Reports an enforcer makes for one connection, in order
+const (
+    reportedNone = iota
+    reportedDeadline
+    reportedEnded
+)
+
+// due works out the connection's state at now and which report it has reached
+func (s *expiryState) due(now time.Time) (Expiry, int) {
+    e := s.expiry
+    if e.Deadline.IsZero() || now.Before(e.Deadline) {
+        return e, reportedNone
+    }
+    switch e.Action {
+    case auth.ExpiryGrace, auth.ExpiryDowngrade:
+        if s.grace > 0 {
+            e.DisconnectAt = e.Deadline.Add(s.grace)
+        }
+    default:
+        e.DisconnectAt = e.Deadline
+    }
+    if !e.DisconnectAt.IsZero() && !now.Before(e.DisconnectAt) {
+        e.Ended = true
+        return e, reportedEnded
+    }
+    return e, reportedDeadline
+}
+
+// ExpiryEnforcer applies each grant's expiry policy to its connection when exp or the grant's
+// EndTime passes mid-session: it reports the warning, downgrade or disconnect once, as it
+// becomes due, and answers the edge's lease renewals with the connection's state. As an
+// admission hook it refuses grants whose EndTime has already passed
+type ExpiryEnforcer struct {
+    interval   time.Duration
+    staleAfter time.Duration
+    clock      clock.Clock
+    report     func(ctx context.Context, e Expiry)
+
+    mu    sync.Mutex
+    conns map[string]*expiryState
+}
+
+// NewExpiryEnforcer creates an enforcer that checks its connections every second
+func NewExpiryEnforcer() *ExpiryEnforcer {
+    return &ExpiryEnforcer{
+        interval:   DefaultExpiryInterval,
+        staleAfter: DefaultExpiryStaleAfter,
+        clock:      clock.System,
+        conns:      make(map[string]*expiryState),
+    }
+}
+
+// SetInterval sets how often due connections are looked for
+func (x *ExpiryEnforcer) SetInterval(d time.Duration) *ExpiryEnforcer {
+    x.interval = d
+    return x
+}
+
+// SetStaleAfter forgets connections whose lease was not renewed for d; keep it above the
+// lease ttl
+func (x *ExpiryEnforcer) SetStaleAfter(d time.Duration) *ExpiryEnforcer {
+    x.staleAfter = d
+    return x
+}
+
+// SetClock sets the time source for deadlines
+func (x *ExpiryEnforcer) SetClock(c clock.Clock) *ExpiryEnforcer {
+    x.clock = c
+    return x
+}
+
+// SetReport sets the function told when a connection's grant runs out and again when the
+// connection must end, e.g. to warn the participant over signaling
+func (x *ExpiryEnforcer) SetReport(report func(ctx context.Context, e Expiry)) *ExpiryEnforcer {
+    x.report = report
+    return x
+}
+
+// Admit refuses grants whose EndTime has passed
+func (x *ExpiryEnforcer) Admit(ctx context.Context, a *Admission) error {
+    if a.Grant != nil && a.Grant.EndTime != 0 && !x.clock.Now().Before(time.Unix(a.Grant.EndTime, 0)) {
+        return ErrGrantEnded
+    }
+    return nil
+}
+
+// Track starts enforcing grant's expiry policy on the connection with the lease id
+func (x *ExpiryEnforcer) Track(id string, grant *auth.VollyVideoGrant) {
+    st := &expiryState{
+        expiry: Expiry{
+            ConnectionID: id,
+            Tenant:       grant.Tenant,
+            Room:         grant.Room,
+            Identity:     grant.Identity,
+            Action:       grant.ExpiryAction(),
+            Deadline:     grant.Deadline(),
+        },
+        grace: grant.OnExpiry.GracePeriod(),
+        seen:  x.clock.Now(),
+    }
+    x.mu.Lock()
+    x.conns[id] = st
+    x.mu.Unlock()
+}
+
+// Untrack stops enforcing on a connection, e.g. when its lease is released
+func (x *ExpiryEnforcer) Untrack(id string) {
+    x.mu.Lock()
+    delete(x.conns, id)
+    x.mu.Unlock()
+}
+
+// Check returns the state of a connection whose grant has run out, nil while it has not or
+// when the connection is not tracked. The edge closes the connection once Ended is set, and
+// drops its publish rights for a downgrade
+func (x *ExpiryEnforcer) Check(ctx context.Context, id string) *Expiry {
+    now := x.clock.Now()
+    x.mu.Lock()
+    st, ok := x.conns[id]
+    if !ok {
+        x.mu.Unlock()
+        return nil
+    }
+    st.seen = now
+    e, stage := st.due(now)
+    // Reported here too, so a connection released on this answer is not missed by Run
+    report := stage > st.reported
+    if report {
+        st.reported = stage
+    }
+    x.mu.Unlock()
+    if report && x.report != nil {
+        x.report(ctx, e)
+    }
+    if stage == reportedNone {
+        return nil
+    }
+    return &e
+}
+
+// Run reports connections as their grants run out until ctx is cancelled
+func (x *ExpiryEnforcer) Run(ctx context.Context) {
+    ticker := time.NewTicker(x.interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            x.reportDue(ctx, x.clock.Now())
+        }
+    }
+}
+
+// reportDue reports every connection that reached its next stage and forgets stale ones
+func (x *ExpiryEnforcer) reportDue(ctx context.Context, now time.Time) {
+    var due []Expiry
+    x.mu.Lock()
+    for id, st := range x.conns {
+        if now.Sub(st.seen) > x.staleAfter {
+            delete(x.conns, id)
+            continue
+        }
+        e, stage := st.due(now)
+        if stage <= st.reported {
+            continue
+        }
+        // A connection that ends before its deadline was reported skips the warning
+        st.reported = stage
+        due = append(due, e)
+    }
+    x.mu.Unlock()
+
+    if x.report == nil {
+        return
+    }
+    for _, e := range due {
+        x.report(ctx, e)
+    }
+}
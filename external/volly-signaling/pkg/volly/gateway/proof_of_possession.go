@@ -0,0 +1,142 @@
+package gateway
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+)
+
+// DefaultChallengeTTL is how long a client has to answer a key proof challenge
+const DefaultChallengeTTL = 30 * time.Second
+
+// keyProofContext domain-separates proof signatures from other uses of the client key
+const keyProofContext = "volly-key-proof-v1"
+
+var (
+    ErrKeyProofRequired  = errors.New("token is key-bound and requires proof of possession")
+    ErrKeyProofChallenge = errors.New("unknown or expired key proof challenge")
+    ErrKeyProofMismatch  = errors.New("proof key does not match token confirmation")
+)
+
+// KeyProofChallenge is sent to the client during the handshake
+type KeyProofChallenge struct {
+    Nonce     []byte
+    ExpiresAt time.Time
+}
+
+// KeyProof is the client's signature over a challenge with its bound key
+type KeyProof struct {
+    Nonce     []byte
+    Algorithm string
+    PublicKey []byte
+    Signature []byte
+}
+
+// KeyProofMessage builds the bytes a client signs to answer a challenge
+func KeyProofMessage(nonce []byte, identity, room string) []byte {
+    msg := make([]byte, 0, len(keyProofContext)+len(nonce)+len(identity)+len(room)+3)
+    msg = append(msg, keyProofContext...)
+    msg = append(msg, 0)
+    msg = append(msg, nonce...)
+    msg = append(msg, 0)
+    msg = append(msg, identity...)
+    msg = append(msg, 0)
+    msg = append(msg, room...)
+    return msg
+}
+
+// ProofOfPossession issues single-use challenges and verifies key proofs for cnf-bound tokens
+type ProofOfPossession struct {
+    ttl   time.Duration
+    clock clock.Clock
+
+    mu      sync.Mutex
+    pending map[string]time.Time
+}
+
+// NewProofOfPossession creates the hook; ttl of 0 uses DefaultChallengeTTL
+func NewProofOfPossession(ttl time.Duration) *ProofOfPossession {
+    if ttl <= 0 {
+        ttl = DefaultChallengeTTL
+    }
+    return &ProofOfPossession{
+        ttl:     ttl,
+        clock:   clock.System,
+        pending: make(map[string]time.Time),
+    }
+}
+
+// SetClock sets the time source for challenge expiry
+func (p *ProofOfPossession) SetClock(c clock.Clock) *ProofOfPossession {
+    p.clock = c
+    return p
+}
+
+// NewChallenge creates a fresh nonce for one handshake
+func (p *ProofOfPossession) NewChallenge() (*KeyProofChallenge, error) {
+    nonce := make([]byte, 32)
+    if _, err := rand.Read(nonce); err != nil {
+        return nil, err
+    }
+    now := p.clock.Now()
+    expires := now.Add(p.ttl)
+
+    p.mu.Lock()
+    for id, exp := range p.pending {
+        if now.After(exp) {
+            delete(p.pending, id)
+        }
+    }
+    p.pending[hex.EncodeToString(nonce)] = expires
+    p.mu.Unlock()
+
+    return &KeyProofChallenge{Nonce: nonce, ExpiresAt: expires}, nil
+}
+
+// Admit verifies the key proof when the token carries a cnf claim
+func (p *ProofOfPossession) Admit(ctx context.Context, a *Admission) error {
+    if a.Grant == nil || a.Grant.Confirmation == nil {
+        return nil
+    }
+    proof := a.KeyProof
+    if proof == nil {
+        return ErrKeyProofRequired
+    }
+    if !p.consume(proof.Nonce) {
+        return ErrKeyProofChallenge
+    }
+
+    cnf := a.Grant.Confirmation
+    if proof.Algorithm != cnf.Algorithm {
+        return ErrKeyProofMismatch
+    }
+    thumbprint, err := auth.JWKThumbprint(proof.PublicKey, proof.Algorithm)
+    if err != nil || !crypto.EqualString(thumbprint, cnf.KeyThumbprint) {
+        return ErrKeyProofMismatch
+    }
+
+    msg := KeyProofMessage(proof.Nonce, a.Identity, a.Room)
+    return crypto.VerifySignature(proof.Algorithm, proof.PublicKey, msg, proof.Signature)
+}
+
+// consume removes a challenge so each nonce answers exactly one handshake
+func (p *ProofOfPossession) consume(nonce []byte) bool {
+    id := hex.EncodeToString(nonce)
+
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    expires, ok := p.pending[id]
+    if !ok {
+        return false
+    }
+    delete(p.pending, id)
+    return p.clock.Now().Before(expires)
+}
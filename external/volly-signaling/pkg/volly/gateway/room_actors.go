@@ -0,0 +1,273 @@
+package gateway
+
+import (
+    "context"
+    "errors"
+    "hash/maphash"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Room actor defaults
+const (
+    DefaultMailboxSize = 256
+    DefaultRoomIdle    = time.Minute
+    roomShards         = 64
+)
+
+var ErrRoomActorsClosed = errors.New("room actors are closed")
+
+// RoomState is owned by one room's actor. Only functions run through RoomActors may read or
+// write it, and no two of them run at once for the same room
+type RoomState struct {
+    Room string
+    // Admissions counts connections admitted through RoomActors.Hook
+    Admissions uint64
+    // Sessions holds the connections a TokenRenewer tracks in the room
+    Sessions map[string]*ConnectedSession
+}
+
+// RoomActorStats counts RoomActors work
+type RoomActorStats struct {
+    Rooms     int    `json:"rooms"`
+    Queued    int    `json:"queued"`
+    Processed uint64 `json:"processed"`
+    Started   uint64 `json:"started"`
+    Stopped   uint64 `json:"stopped"`
+}
+
+type roomMessage struct {
+    ctx  context.Context
+    fn   func(ctx context.Context, room *RoomState) error
+    done chan error
+}
+
+type roomActor struct {
+    mailbox chan roomMessage
+    // pending counts senders holding the actor but not yet delivered; it only changes under
+    // the shard's read lock and the actor only stops under its write lock, so an actor never
+    // stops with a message on the way
+    pending int64
+    state   RoomState
+}
+
+type roomShard struct {
+    mu     sync.RWMutex
+    actors map[string]*roomActor
+}
+
+// RoomActors gives every room its own goroutine that runs the room's messages one at a time
+// in the order they were sent. Rooms share nothing, so work for different rooms never waits
+// on a common lock; the registry is sharded and only locked to find or retire an actor. An
+// actor stops after sitting idle with no tracked sessions
+type RoomActors struct {
+    mailbox int
+    idle    time.Duration
+    seed    maphash.Seed
+    shards  [roomShards]roomShard
+
+    closed  atomic.Bool
+    closing chan struct{}
+
+    processed, started, stopped uint64
+}
+
+// NewRoomActors creates an empty registry; actors start on a room's first message
+func NewRoomActors() *RoomActors {
+    r := &RoomActors{
+        mailbox: DefaultMailboxSize,
+        idle:    DefaultRoomIdle,
+        seed:    maphash.MakeSeed(),
+        closing: make(chan struct{}),
+    }
+    for i := range r.shards {
+        r.shards[i].actors = make(map[string]*roomActor)
+    }
+    return r
+}
+
+// SetMailboxSize bounds each room's queue; senders wait while it is full
+func (r *RoomActors) SetMailboxSize(n int) *RoomActors {
+    r.mailbox = n
+    return r
+}
+
+// SetIdleTimeout sets how long an actor without sessions waits for work before stopping
+func (r *RoomActors) SetIdleTimeout(d time.Duration) *RoomActors {
+    r.idle = d
+    return r
+}
+
+// Do runs fn on room's actor and waits for its result. If ctx ends first Do returns its
+// error, and fn, if it has not started, is skipped
+func (r *RoomActors) Do(ctx context.Context, room string, fn func(ctx context.Context, room *RoomState) error) error {
+    done := make(chan error, 1)
+    if err := r.send(ctx, room, roomMessage{ctx: ctx, fn: fn, done: done}); err != nil {
+        return err
+    }
+    select {
+    case err := <-done:
+        return err
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// Post queues fn on room's actor without waiting for it to run
+func (r *RoomActors) Post(room string, fn func(ctx context.Context, room *RoomState) error) error {
+    return r.send(context.Background(), room, roomMessage{ctx: context.Background(), fn: fn})
+}
+
+// Hook runs hook for each admission on its room's actor, so admissions to one room are
+// decided one at a time in arrival order while other rooms proceed in parallel
+func (r *RoomActors) Hook(hook AdmissionHook) AdmissionHook {
+    return AdmissionHookFunc(func(ctx context.Context, a *Admission) error {
+        return r.Do(ctx, a.Room, func(ctx context.Context, room *RoomState) error {
+            if err := hook.Admit(ctx, a); err != nil {
+                return err
+            }
+            room.Admissions++
+            return nil
+        })
+    })
+}
+
+// Rooms lists the rooms that currently have an actor
+func (r *RoomActors) Rooms() []string {
+    var out []string
+    for i := range r.shards {
+        s := &r.shards[i]
+        s.mu.RLock()
+        for room := range s.actors {
+            out = append(out, room)
+        }
+        s.mu.RUnlock()
+    }
+    return out
+}
+
+// Stats reports live actors and queue depth
+func (r *RoomActors) Stats() RoomActorStats {
+    var st RoomActorStats
+    for i := range r.shards {
+        s := &r.shards[i]
+        s.mu.RLock()
+        st.Rooms += len(s.actors)
+        for _, a := range s.actors {
+            st.Queued += len(a.mailbox)
+        }
+        s.mu.RUnlock()
+    }
+    st.Processed = atomic.LoadUint64(&r.processed)
+    st.Started = atomic.LoadUint64(&r.started)
+    st.Stopped = atomic.LoadUint64(&r.stopped)
+    return st
+}
+
+// Close stops every actor once its queue drains; later sends get ErrRoomActorsClosed
+func (r *RoomActors) Close() {
+    if r.closed.CompareAndSwap(false, true) {
+        close(r.closing)
+    }
+}
+
+func (r *RoomActors) shard(room string) *roomShard {
+    return &r.shards[maphash.String(r.seed, room)%roomShards]
+}
+
+func (r *RoomActors) send(ctx context.Context, room string, msg roomMessage) error {
+    s := r.shard(room)
+    s.mu.RLock()
+    a := s.actors[room]
+    if a != nil {
+        atomic.AddInt64(&a.pending, 1)
+    }
+    s.mu.RUnlock()
+
+    if a == nil {
+        s.mu.Lock()
+        if r.closed.Load() {
+            s.mu.Unlock()
+            return ErrRoomActorsClosed
+        }
+        if a = s.actors[room]; a == nil {
+            a = &roomActor{mailbox: make(chan roomMessage, r.mailbox), state: RoomState{Room: room}}
+            s.actors[room] = a
+            atomic.AddUint64(&r.started, 1)
+            go r.run(s, a)
+        }
+        atomic.AddInt64(&a.pending, 1)
+        s.mu.Unlock()
+    } else if r.closed.Load() {
+        atomic.AddInt64(&a.pending, -1)
+        return ErrRoomActorsClosed
+    }
+
+    defer atomic.AddInt64(&a.pending, -1)
+    select {
+    case a.mailbox <- msg:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// run is a room's actor. Once the registry closes it keeps draining until nothing is queued
+// or on the way, then stops and drops its state
+func (r *RoomActors) run(s *roomShard, a *roomActor) {
+    idle := time.NewTimer(r.idle)
+    defer idle.Stop()
+
+    closing := r.closing
+    for {
+        select {
+        case msg := <-a.mailbox:
+            r.handle(a, msg)
+            if closing != nil {
+                if !idle.Stop() {
+                    select {
+                    case <-idle.C:
+                    default:
+                    }
+                }
+                idle.Reset(r.idle)
+                continue
+            }
+        case <-idle.C:
+            idle.Reset(r.idle)
+        case <-closing:
+            closing = nil
+        }
+        if r.retire(s, a, closing == nil) {
+            return
+        }
+    }
+}
+
+func (r *RoomActors) handle(a *roomActor, msg roomMessage) {
+    err := msg.ctx.Err()
+    if err == nil {
+        err = msg.fn(msg.ctx, &a.state)
+    }
+    if msg.done != nil {
+        msg.done <- err
+    }
+    atomic.AddUint64(&r.processed, 1)
+}
+
+// retire removes an idle actor from its shard; state is dropped only when closing
+func (r *RoomActors) retire(s *roomShard, a *roomActor, closing bool) bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if atomic.LoadInt64(&a.pending) != 0 || len(a.mailbox) != 0 {
+        return false
+    }
+    if !closing && len(a.state.Sessions) != 0 {
+        return false
+    }
+    delete(s.actors, a.state.Room)
+    atomic.AddUint64(&r.stopped, 1)
+    return true
+}
@@ -0,0 +1,176 @@
+package gateway
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/configstore"
+)
+
+var (
+    ErrRoomFull            = errors.New("room is at its participant limit")
+    ErrRoomRequiresPQ      = errors.New("room requires a post-quantum key")
+    ErrRecordingNotAllowed = errors.New("room does not allow recording")
+    ErrProviderNotAllowed  = errors.New("room does not admit tokens from this provider")
+)
+
+// PolicyError is a rejection by a room policy
+type PolicyError struct {
+    Policy string
+    Err    error
+}
+
+func (e *PolicyError) Error() string {
+    return fmt.Sprintf("room policy %s: %v", e.Policy, e.Err)
+}
+
+func (e *PolicyError) Unwrap() error {
+    return e.Err
+}
+
+// PolicySource lists a tenant's room policies, as configstore.Store does
+type PolicySource interface {
+    ListRoomPolicies(ctx context.Context, tenant string) ([]configstore.RoomPolicy, error)
+}
+
+// RoomCounter counts the participants in a room other than except
+type RoomCounter interface {
+    Participants(room, except string) int
+}
+
+// ShadowDecision reports an admission that shadow policies decide differently from the
+// active ones. Enforced is what the gateway did, Proposed what it would do with the shadow
+// policies switched on; a nil error admits
+type ShadowDecision struct {
+    Tenant   string
+    Room     string
+    Identity string
+    Enforced error
+    Proposed error
+}
+
+// RoomPolicies enforces the tenant's room policies on admission. Every active policy whose
+// Room matches must admit. Shadow policies are evaluated alongside: the proposed set is the
+// active policies with each shadow policy replacing those for the same Room pattern, and
+// admissions it decides differently are reported without being enforced
+type RoomPolicies struct {
+    source  PolicySource
+    counter RoomCounter
+    report  func(ctx context.Context, d ShadowDecision)
+}
+
+// NewRoomPolicies enforces the policies in source; without a counter MaxParticipants is not
+// enforced
+func NewRoomPolicies(source PolicySource) *RoomPolicies {
+    return &RoomPolicies{source: source}
+}
+
+// SetCounter sets where room occupancy for MaxParticipants comes from
+func (p *RoomPolicies) SetCounter(counter RoomCounter) *RoomPolicies {
+    p.counter = counter
+    return p
+}
+
+// SetShadowReport sets the function told of admissions the shadow policies decide
+// differently; without one shadow policies are not evaluated
+func (p *RoomPolicies) SetShadowReport(report func(ctx context.Context, d ShadowDecision)) *RoomPolicies {
+    p.report = report
+    return p
+}
+
+// Admit rejects connections the active policies of the grant's tenant refuse
+func (p *RoomPolicies) Admit(ctx context.Context, a *Admission) error {
+    if a.Grant == nil {
+        return nil
+    }
+    policies, err := p.source.ListRoomPolicies(ctx, a.Grant.Tenant)
+    if err != nil {
+        return err
+    }
+    var active, shadow []configstore.RoomPolicy
+    for _, policy := range policies {
+        if !MatchRoom(policy.Room, a.Room) {
+            continue
+        }
+        if policy.Shadow {
+            shadow = append(shadow, policy)
+        } else {
+            active = append(active, policy)
+        }
+    }
+    enforced := p.evaluate(active, a)
+    if len(shadow) == 0 || p.report == nil {
+        return enforced
+    }
+
+    replaced := make(map[string]bool, len(shadow))
+    for _, policy := range shadow {
+        replaced[policy.Room] = true
+    }
+    proposed := shadow
+    for _, policy := range active {
+        if !replaced[policy.Room] {
+            proposed = append(proposed, policy)
+        }
+    }
+    if would := p.evaluate(proposed, a); (would == nil) != (enforced == nil) {
+        p.report(ctx, ShadowDecision{
+            Tenant:   a.Grant.Tenant,
+            Room:     a.Room,
+            Identity: a.Identity,
+            Enforced: enforced,
+            Proposed: would,
+        })
+    }
+    return enforced
+}
+
+func (p *RoomPolicies) evaluate(policies []configstore.RoomPolicy, a *Admission) error {
+    for _, policy := range policies {
+        if err := p.check(policy, a); err != nil {
+            return &PolicyError{Policy: policy.Name, Err: err}
+        }
+    }
+    return nil
+}
+
+// check applies one policy. Recorders are only admitted where recording is allowed
+func (p *RoomPolicies) check(policy configstore.RoomPolicy, a *Admission) error {
+    if policy.RequirePQ && a.Grant.PQStatus != auth.PQStatusPresent {
+        return ErrRoomRequiresPQ
+    }
+    if a.Grant.Recorder && !policy.RecordingAllowed {
+        return ErrRecordingNotAllowed
+    }
+    if len(policy.Providers) > 0 && !providerAllowed(policy.Providers, a.Grant.Provenance) {
+        return ErrProviderNotAllowed
+    }
+    if policy.MaxParticipants > 0 && p.counter != nil && p.counter.Participants(a.Room, a.Identity) >= policy.MaxParticipants {
+        return ErrRoomFull
+    }
+    return nil
+}
+
+// providerAllowed is false for tokens without provenance, which can name no provider
+func providerAllowed(providers []string, prov *auth.Provenance) bool {
+    if prov == nil {
+        return false
+    }
+    for _, p := range providers {
+        if p == prov.Provider {
+            return true
+        }
+    }
+    return false
+}
+
+// MatchRoom reports whether a policy's Room pattern covers room; a trailing * matches a prefix
+func MatchRoom(pattern, room string) bool {
+    if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+        return strings.HasPrefix(room, prefix)
+    }
+    return pattern == room
+}
@@ -0,0 +1,324 @@
+package gateway
+
+import (
+    "context"
+    "errors"
+    "log"
+    "sync"
+    "time"
+
+    "github.com/livekit/protocol/livekit"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/secure"
+)
+
+// Renewal defaults
+const (
+    DefaultRenewBefore     = 5 * time.Minute
+    DefaultRenewalInterval = 30 * time.Second
+    DefaultMaxSessionAge   = 12 * time.Hour
+)
+
+var (
+    ErrRenewalDenied = errors.New("token renewal denied by policy")
+    // ErrNotRenewable refuses to renew attenuated, elevated and delegated tokens, whose limits
+    // a re-minted session token would drop
+    ErrNotRenewable = errors.New("only session tokens can be renewed")
+)
+
+// ConnectedSession is the renewal state for one authenticated connection
+type ConnectedSession struct {
+    SessionID   string
+    Identity    string
+    Room        string
+    Grant       *auth.VollyVideoGrant
+    Kind        livekit.ParticipantInfo_Kind
+    ConnectedAt time.Time
+    ExpiresAt   time.Time
+}
+
+// TokenIssuer mints a replacement token for a connected session
+type TokenIssuer interface {
+    RenewToken(ctx context.Context, session ConnectedSession) (token string, expiresAt time.Time, err error)
+}
+
+// TokenDelivery pushes a renewed token to the client over its signaling connection
+type TokenDelivery interface {
+    DeliverToken(ctx context.Context, sessionID, token string, expiresAt time.Time) error
+}
+
+// RenewalPolicy decides whether a session may be renewed; return an error to deny
+type RenewalPolicy func(session ConnectedSession) error
+
+// MaxSessionAge denies renewal once a session has been connected longer than maxAge
+func MaxSessionAge(maxAge time.Duration) RenewalPolicy {
+    return MaxSessionAgeWithClock(maxAge, clock.System)
+}
+
+// MaxSessionAgeWithClock is MaxSessionAge measured against c
+func MaxSessionAgeWithClock(maxAge time.Duration, c clock.Clock) RenewalPolicy {
+    return func(session ConnectedSession) error {
+        if c.Now().Sub(session.ConnectedAt) > maxAge {
+            return ErrRenewalDenied
+        }
+        return nil
+    }
+}
+
+// TokenRenewer renews tokens of connected participants before they expire. Sessions live in
+// their room's actor, so tracking and renewal in one room never contend with another
+type TokenRenewer struct {
+    issuer      TokenIssuer
+    delivery    TokenDelivery
+    policy      RenewalPolicy
+    renewBefore time.Duration
+    interval    time.Duration
+    clock       clock.Clock
+    rooms       *RoomActors
+
+    // sessionRooms maps session IDs to their room for Untrack
+    sessionRooms sync.Map
+}
+
+// NewTokenRenewer creates a renewer with default timing and a 12-hour session cap
+func NewTokenRenewer(issuer TokenIssuer, delivery TokenDelivery) *TokenRenewer {
+    return &TokenRenewer{
+        issuer:      issuer,
+        delivery:    delivery,
+        policy:      MaxSessionAge(DefaultMaxSessionAge),
+        renewBefore: DefaultRenewBefore,
+        interval:    DefaultRenewalInterval,
+        clock:       clock.System,
+        rooms:       NewRoomActors(),
+    }
+}
+
+// SetPolicy replaces the renewal policy
+func (r *TokenRenewer) SetPolicy(policy RenewalPolicy) *TokenRenewer {
+    r.policy = policy
+    return r
+}
+
+// SetRenewBefore sets how long before expiry a token is renewed
+func (r *TokenRenewer) SetRenewBefore(d time.Duration) *TokenRenewer {
+    r.renewBefore = d
+    return r
+}
+
+// SetClock sets the time source for expiry deadlines; pair it with
+// MaxSessionAgeWithClock when the session cap should follow the same clock
+func (r *TokenRenewer) SetClock(c clock.Clock) *TokenRenewer {
+    r.clock = c
+    return r
+}
+
+// SetRoomActors keeps sessions in actors shared with the rest of the gateway; set it before
+// tracking any session
+func (r *TokenRenewer) SetRoomActors(rooms *RoomActors) *TokenRenewer {
+    r.rooms = rooms
+    return r
+}
+
+// Track starts watching a session's token expiry
+func (r *TokenRenewer) Track(session ConnectedSession) {
+    if session.ConnectedAt.IsZero() {
+        session.ConnectedAt = r.clock.Now()
+    }
+    r.sessionRooms.Store(session.SessionID, session.Room)
+    // Once the actors are closed the gateway is shutting down and nothing is renewed anyway
+    r.rooms.Post(session.Room, func(ctx context.Context, room *RoomState) error {
+        if room.Sessions == nil {
+            room.Sessions = make(map[string]*ConnectedSession)
+        }
+        room.Sessions[session.SessionID] = &session
+        return nil
+    })
+}
+
+// Untrack stops renewing a session, e.g. on disconnect
+func (r *TokenRenewer) Untrack(sessionID string) {
+    name, ok := r.sessionRooms.LoadAndDelete(sessionID)
+    if !ok {
+        return
+    }
+    r.rooms.Post(name.(string), func(ctx context.Context, room *RoomState) error {
+        delete(room.Sessions, sessionID)
+        return nil
+    })
+}
+
+// Run renews due tokens until ctx is cancelled
+func (r *TokenRenewer) Run(ctx context.Context) {
+    ticker := time.NewTicker(r.interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            r.renewDue(ctx)
+        }
+    }
+}
+
+func (r *TokenRenewer) renewDue(ctx context.Context) {
+    deadline := r.clock.Now().Add(r.renewBefore)
+
+    // Each room hands over copies of its due sessions so issuing tokens does not hold up the room
+    var due []ConnectedSession
+    for _, name := range r.rooms.Rooms() {
+        err := r.rooms.Do(ctx, name, func(ctx context.Context, room *RoomState) error {
+            for _, s := range room.Sessions {
+                if s.ExpiresAt.Before(deadline) {
+                    due = append(due, *s)
+                }
+            }
+            return nil
+        })
+        if err != nil {
+            return
+        }
+    }
+
+    for _, s := range due {
+        if err := r.renew(ctx, s); err != nil {
+            log.Printf("token renewal for session %s failed: %v", s.SessionID, err)
+        }
+    }
+}
+
+func (r *TokenRenewer) renew(ctx context.Context, s ConnectedSession) error {
+    if r.policy != nil {
+        if err := r.policy(s); err != nil {
+            // The session runs until its current token expires and is then disconnected
+            r.Untrack(s.SessionID)
+            return err
+        }
+    }
+
+    token, expiresAt, err := r.issuer.RenewToken(ctx, s)
+    if err != nil {
+        return err
+    }
+    if err := r.delivery.DeliverToken(ctx, s.SessionID, token, expiresAt); err != nil {
+        return err
+    }
+
+    return r.rooms.Post(s.Room, func(ctx context.Context, room *RoomState) error {
+        if tracked, ok := room.Sessions[s.SessionID]; ok {
+            tracked.ExpiresAt = expiresAt
+            // The next renewal and its checks see the token the client now holds
+            if renewed, err := auth.ParseUnverified(token); err == nil && tracked.Grant != nil {
+                grant := *tracked.Grant
+                grant.TokenID, grant.TokenChain, grant.RenewedFrom = renewed.TokenID, renewed.TokenChain, renewed.RenewedFrom
+                grant.IssuedAt, grant.ExpiresAt = renewed.IssuedAt, renewed.ExpiresAt
+                tracked.Grant = &grant
+            }
+        }
+        return nil
+    })
+}
+
+// LocalIssuer re-mints session tokens in-process with the same grant and a fresh TTL, never
+// past the lifetime cap of the session's first token
+type LocalIssuer struct {
+    apiKey      string
+    secret      *secure.SecureBytes
+    ttl         time.Duration
+    maxLifetime time.Duration
+    check       func(ctx context.Context, grant *auth.VollyVideoGrant) error
+    clock       clock.Clock
+}
+
+// NewLocalIssuer creates an issuer for gateways that hold the API secret
+func NewLocalIssuer(apiKey string, secret *secure.SecureBytes, ttl time.Duration) *LocalIssuer {
+    return &LocalIssuer{apiKey: apiKey, secret: secret, ttl: ttl, maxLifetime: DefaultMaxSessionAge, clock: clock.System}
+}
+
+// SetClock sets the time source for reported expiry and PQ key lifetimes
+func (i *LocalIssuer) SetClock(c clock.Clock) *LocalIssuer {
+    i.clock = c
+    return i
+}
+
+// SetMaxLifetime caps how long after the session's first token was issued its renewals may
+// expire
+func (i *LocalIssuer) SetMaxLifetime(d time.Duration) *LocalIssuer {
+    i.maxLifetime = d
+    return i
+}
+
+// SetGrantCheck runs check, e.g. revocation and the kill switch, against the session's
+// current grant before every renewal; an error denies it
+func (i *LocalIssuer) SetGrantCheck(check func(ctx context.Context, grant *auth.VollyVideoGrant) error) *LocalIssuer {
+    i.check = check
+    return i
+}
+
+// RenewToken issues a replacement session token preserving the session's grants, kind and
+// PQ key. The replacement names the session's first token, so revoking that revokes it too
+func (i *LocalIssuer) RenewToken(ctx context.Context, session ConnectedSession) (string, time.Time, error) {
+    if !renewable(session.Grant) {
+        return "", time.Time{}, ErrNotRenewable
+    }
+    if i.check != nil {
+        if err := i.check(ctx, session.Grant); err != nil {
+            return "", time.Time{}, err
+        }
+    }
+
+    root, origin := session.Grant.TokenID, session.ConnectedAt
+    if session.Grant.IssuedAt != 0 {
+        origin = time.Unix(session.Grant.IssuedAt, 0)
+    }
+    if from := session.Grant.RenewedFrom; from != nil {
+        root, origin = from.TokenID, time.Unix(from.IssuedAt, 0)
+    }
+    now := i.clock.Now()
+    expiresAt := now.Add(i.ttl)
+    for _, limit := range []time.Time{origin.Add(i.maxLifetime), unixOrZero(session.Grant.EndTime), unixOrZero(session.Grant.PQKeyExpiry)} {
+        if !limit.IsZero() && limit.Before(expiresAt) {
+            expiresAt = limit
+        }
+    }
+    if !expiresAt.After(now) {
+        return "", time.Time{}, ErrRenewalDenied
+    }
+
+    grant := *session.Grant
+    grant.TokenID, grant.TokenChain, grant.RenewedFrom = "", nil, nil
+    token, err := auth.NewVollyAccessTokenWithSecret(i.apiKey, i.secret).
+        SetClock(i.clock).
+        AddGrant(&grant).
+        SetIdentity(session.Identity).
+        SetKind(session.Kind).
+        SetRenewedFrom(root, origin).
+        SetValidFor(expiresAt.Sub(now)).
+        ToJWT()
+    if err != nil {
+        return "", time.Time{}, err
+    }
+    return token, expiresAt, nil
+}
+
+// renewable reports whether grant is a session token, or a renewal of one, as opposed to one
+// attenuated, elevated or exchanged from another
+func renewable(grant *auth.VollyVideoGrant) bool {
+    if grant == nil || grant.TokenID == "" || grant.Actor != nil || grant.OnBehalfOf != "" || grant.ElevatedFrom != "" {
+        return false
+    }
+    if grant.RenewedFrom != nil {
+        return len(grant.TokenChain) == 1 && grant.TokenChain[0] == grant.RenewedFrom.TokenID
+    }
+    return len(grant.TokenChain) == 0
+}
+
+func unixOrZero(sec int64) time.Time {
+    if sec == 0 {
+        return time.Time{}
+    }
+    return time.Unix(sec, 0)
+}
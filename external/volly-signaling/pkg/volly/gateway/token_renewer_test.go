@@ -0,0 +1,106 @@
+package gateway
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    lkauth "github.com/livekit/protocol/auth"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/secure"
+)
+
+const testSecret = "renewer-secret-with-enough-entropy-01"
+
+// TestLocalIssuerRenewsSessionTokens renews a session token twice and checks every renewal
+// names the first token and stops at its lifetime cap, that a check failing denies renewal
+// and that an elevation is not renewed
+func TestLocalIssuerRenewsSessionTokens(t *testing.T) {
+    ctx := context.Background()
+    start := time.Unix(1_700_000_000, 0)
+    now := clock.NewManual(start)
+    secret, err := secure.FromString(testSecret)
+    if err != nil {
+        t.Fatal(err)
+    }
+    verifier := auth.NewVerifier("k", testSecret).SetClock(now).SetLegacyPolicy(auth.AdmitLegacy)
+    issue := func(elevated bool) *auth.VollyVideoGrant {
+        t.Helper()
+        at := auth.NewVollyAccessToken("k", testSecret).SetClock(now).
+            AddGrant(&auth.VollyVideoGrant{VideoGrant: lkauth.VideoGrant{RoomJoin: true, Room: "r"}}).
+            SetIdentity("alice").
+            SetValidFor(time.Hour)
+        if elevated {
+            at.SetElevatedFrom("session")
+        }
+        token, err := at.ToJWT()
+        if err != nil {
+            t.Fatal(err)
+        }
+        grant, err := verifier.Verify(token)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return grant
+    }
+
+    var revoked string
+    issuer := NewLocalIssuer("k", secret, time.Hour).SetClock(now).SetMaxLifetime(2 * time.Hour).
+        SetGrantCheck(func(ctx context.Context, grant *auth.VollyVideoGrant) error {
+            for _, jti := range append(grant.TokenChain, grant.TokenID) {
+                if jti == revoked {
+                    return errors.New("revoked")
+                }
+            }
+            return nil
+        })
+    first := issue(false)
+    renew := func(grant *auth.VollyVideoGrant) (*auth.VollyVideoGrant, time.Time, error) {
+        t.Helper()
+        token, expiresAt, err := issuer.RenewToken(ctx, ConnectedSession{SessionID: "s", Identity: "alice", Room: "r", Grant: grant, ConnectedAt: start})
+        if err != nil {
+            return nil, expiresAt, err
+        }
+        renewed, err := verifier.Verify(token)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return renewed, expiresAt, nil
+    }
+
+    now.Advance(50 * time.Minute)
+    second, expiresAt, err := renew(first)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !expiresAt.Equal(now.Now().Add(time.Hour)) {
+        t.Fatalf("first renewal expires at %v, want a full TTL", expiresAt)
+    }
+    now.Advance(50 * time.Minute)
+    third, expiresAt, err := renew(second)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !expiresAt.Equal(start.Add(2 * time.Hour)) {
+        t.Fatalf("second renewal expires at %v, want the cap of the first token at %v", expiresAt, start.Add(2*time.Hour))
+    }
+    if len(third.TokenChain) != 1 || third.TokenChain[0] != first.TokenID {
+        t.Fatalf("renewal of a renewal has chain %v, want the first token %s", third.TokenChain, first.TokenID)
+    }
+
+    revoked = first.TokenID
+    if _, _, err := renew(third); err == nil {
+        t.Fatal("renewed a session whose first token is revoked")
+    }
+    revoked = ""
+    now.Advance(time.Hour)
+    if _, _, err := renew(third); !errors.Is(err, ErrRenewalDenied) {
+        t.Fatalf("renewal past the cap: got %v, want ErrRenewalDenied", err)
+    }
+    if _, _, err := renew(issue(true)); !errors.Is(err, ErrNotRenewable) {
+        t.Fatalf("renewal of an elevation: got %v, want ErrNotRenewable", err)
+    }
+}
@@ -0,0 +1,210 @@
+package grants
+
+import (
+    "sort"
+    "strconv"
+    "strings"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/tagset"
+)
+
+// Change is one field that differs between two grants
+type Change struct {
+    Field string `json:"field"`
+    From  string `json:"from"`
+    To    string `json:"to"`
+    // Escalation is true when the change widens what the holder can do
+    Escalation bool `json:"escalation"`
+}
+
+// permission is a boolean capability; holding it is always the broader side
+type permission struct {
+    field string
+    get   func(g *auth.VollyVideoGrant) bool
+}
+
+// Flags like hidden and recorder widen privilege too, so every false to true is an escalation
+var permissions = []permission{
+    {"roomCreate", func(g *auth.VollyVideoGrant) bool { return g.RoomCreate }},
+    {"roomList", func(g *auth.VollyVideoGrant) bool { return g.RoomList }},
+    {"roomRecord", func(g *auth.VollyVideoGrant) bool { return g.RoomRecord }},
+    {"roomAdmin", func(g *auth.VollyVideoGrant) bool { return g.RoomAdmin }},
+    {"roomJoin", func(g *auth.VollyVideoGrant) bool { return g.RoomJoin }},
+    {"canPublish", func(g *auth.VollyVideoGrant) bool { return g.GetCanPublish() }},
+    {"canSubscribe", func(g *auth.VollyVideoGrant) bool { return g.GetCanSubscribe() }},
+    {"canPublishData", func(g *auth.VollyVideoGrant) bool { return g.GetCanPublishData() }},
+    {"canUpdateOwnMetadata", func(g *auth.VollyVideoGrant) bool { return g.GetCanUpdateOwnMetadata() }},
+    {"ingressAdmin", func(g *auth.VollyVideoGrant) bool { return g.IngressAdmin }},
+    {"hidden", func(g *auth.VollyVideoGrant) bool { return g.Hidden }},
+    {"recorder", func(g *auth.VollyVideoGrant) bool { return g.Recorder }},
+    {"agent", func(g *auth.VollyVideoGrant) bool { return g.Agent }},
+}
+
+// Diff lists the differences from a to b, in a stable field order
+func Diff(a, b *auth.VollyVideoGrant) []Change {
+    if a == nil {
+        a = &auth.VollyVideoGrant{}
+    }
+    if b == nil {
+        b = &auth.VollyVideoGrant{}
+    }
+
+    var changes []Change
+    for _, p := range permissions {
+        from, to := p.get(a), p.get(b)
+        if from != to {
+            changes = append(changes, Change{
+                Field:      p.field,
+                From:       strconv.FormatBool(from),
+                To:         strconv.FormatBool(to),
+                Escalation: to,
+            })
+        }
+    }
+
+    if a.Tenant != b.Tenant {
+        changes = append(changes, Change{Field: "tenant", From: a.Tenant, To: b.Tenant, Escalation: true})
+    }
+    if a.Room != b.Room {
+        // Moving to another room or dropping the room scope both reach new rooms
+        changes = append(changes, Change{Field: "room", From: a.Room, To: b.Room, Escalation: true})
+    }
+
+    fromSources, toSources := sortedSet(a.CanPublishSources), sortedSet(b.CanPublishSources)
+    if fromSources != toSources {
+        changes = append(changes, Change{
+            Field:      "canPublishSources",
+            From:       fromSources,
+            To:         toSources,
+            Escalation: !isSubset(b.CanPublishSources, a.CanPublishSources) || (len(b.CanPublishSources) == 0 && len(a.CanPublishSources) > 0),
+        })
+    }
+
+    fromCountries, toCountries := sortedSet(a.AllowedCountries), sortedSet(b.AllowedCountries)
+    if fromCountries != toCountries {
+        changes = append(changes, Change{
+            Field:      "allowedCountries",
+            From:       fromCountries,
+            To:         toCountries,
+            Escalation: len(b.AllowedCountries) == 0 || (len(a.AllowedCountries) > 0 && !isSubset(b.AllowedCountries, a.AllowedCountries)),
+        })
+    }
+
+    fromCIDRs, toCIDRs := sortedSet(a.DeniedCIDRs), sortedSet(b.DeniedCIDRs)
+    if fromCIDRs != toCIDRs {
+        changes = append(changes, Change{
+            Field:      "deniedCIDRs",
+            From:       fromCIDRs,
+            To:         toCIDRs,
+            Escalation: !isSubset(a.DeniedCIDRs, b.DeniedCIDRs),
+        })
+    }
+
+    // Tags only attribute usage, so changing them widens nothing
+    if fromTags, toTags := tagset.Encode(a.Tags), tagset.Encode(b.Tags); fromTags != toTags {
+        changes = append(changes, Change{Field: "tags", From: fromTags, To: toTags})
+    }
+
+    fromCnf, toCnf := confirmation(a), confirmation(b)
+    if fromCnf != toCnf {
+        changes = append(changes, Change{Field: "cnf", From: fromCnf, To: toCnf, Escalation: toCnf == ""})
+    }
+
+    // Rooms may admit by provider, so a new one can open rooms; guest and none open none
+    if fromProv, toProv := provider(a), provider(b); fromProv != toProv {
+        changes = append(changes, Change{Field: "provenance", From: fromProv, To: toProv,
+            Escalation: toProv != "" && toProv != auth.ProviderGuest})
+    }
+
+    // The hash itself stays out of room events; dropping the PIN opens the room
+    if a.PINHash != b.PINHash {
+        changes = append(changes, Change{Field: "pinHash", From: pinState(a), To: pinState(b), Escalation: b.PINHash == ""})
+    }
+
+    // A later end or a softer expiry keeps the holder connected for longer
+    if a.EndTime != b.EndTime {
+        changes = append(changes, Change{Field: "endTime", From: unixTime(a.EndTime), To: unixTime(b.EndTime),
+            Escalation: b.EndTime == 0 || (a.EndTime != 0 && b.EndTime > a.EndTime)})
+    }
+    if fromExp, toExp := expiry(a), expiry(b); fromExp != toExp {
+        changes = append(changes, Change{Field: "onExpiry", From: fromExp, To: toExp,
+            Escalation: b.ExpiryAction() != auth.ExpiryDisconnect})
+    }
+
+    if a.PQPublicKey != b.PQPublicKey || a.PQAlgorithm != b.PQAlgorithm {
+        changes = append(changes, Change{
+            Field:      "pqPublicKey",
+            From:       a.PQAlgorithm,
+            To:         b.PQAlgorithm,
+            Escalation: b.PQPublicKey == "" && a.PQPublicKey != "",
+        })
+    }
+    return changes
+}
+
+// Escalations filters changes down to those that widen privilege
+func Escalations(changes []Change) []Change {
+    var out []Change
+    for _, c := range changes {
+        if c.Escalation {
+            out = append(out, c)
+        }
+    }
+    return out
+}
+
+func confirmation(g *auth.VollyVideoGrant) string {
+    if g.Confirmation == nil {
+        return ""
+    }
+    return g.Confirmation.Algorithm + ":" + g.Confirmation.KeyThumbprint
+}
+
+func pinState(g *auth.VollyVideoGrant) string {
+    if g.PINHash == "" {
+        return ""
+    }
+    return "set"
+}
+
+func unixTime(t int64) string {
+    if t == 0 {
+        return ""
+    }
+    return strconv.FormatInt(t, 10)
+}
+
+func expiry(g *auth.VollyVideoGrant) string {
+    if g.OnExpiry == nil {
+        return ""
+    }
+    return string(g.OnExpiry.Action) + ":" + strconv.FormatInt(g.OnExpiry.Grace, 10)
+}
+
+func provider(g *auth.VollyVideoGrant) string {
+    if g.Provenance == nil {
+        return ""
+    }
+    return g.Provenance.Provider
+}
+
+func sortedSet(values []string) string {
+    sorted := append([]string(nil), values...)
+    sort.Strings(sorted)
+    return strings.Join(sorted, ",")
+}
+
+// isSubset reports whether every value in sub appears in super
+func isSubset(sub, super []string) bool {
+    set := make(map[string]bool, len(super))
+    for _, v := range super {
+        set[v] = true
+    }
+    for _, v := range sub {
+        if !set[v] {
+            return false
+        }
+    }
+    return true
+}
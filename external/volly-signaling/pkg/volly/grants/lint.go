@@ -0,0 +1,141 @@
+package grants
+
+import (
+    "errors"
+    "net/netip"
+    "strings"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+)
+
+// Severity ranks lint findings
+type Severity int
+
+// Lint severities, in increasing order
+const (
+    SeverityInfo Severity = iota
+    SeverityWarning
+    SeverityError
+)
+
+func (s Severity) String() string {
+    switch s {
+    case SeverityInfo:
+        return "info"
+    case SeverityWarning:
+        return "warning"
+    default:
+        return "error"
+    }
+}
+
+// MarshalText encodes the severity by name for JSON reports
+func (s Severity) MarshalText() ([]byte, error) {
+    return []byte(s.String()), nil
+}
+
+// Warning is one lint finding, stable enough for CI to match on Code
+type Warning struct {
+    Code     string   `json:"code"`
+    Severity Severity `json:"severity"`
+    Field    string   `json:"field"`
+    Message  string   `json:"message"`
+}
+
+// ErrGrantRejected is returned by Gate when a grant has findings at or above the threshold
+var ErrGrantRejected = errors.New("grant rejected by least-privilege lint")
+
+// Lint flags over-broad or inconsistent grant combinations
+func Lint(grant *auth.VollyVideoGrant) []Warning {
+    if grant == nil {
+        return nil
+    }
+    var out []Warning
+    add := func(code string, severity Severity, field, message string) {
+        out = append(out, Warning{Code: code, Severity: severity, Field: field, Message: message})
+    }
+
+    participates := grant.RoomJoin || grant.RoomAdmin
+    if grant.RoomJoin && grant.Room == "" {
+        add("join-without-room", SeverityError, "room", "roomJoin requires a room")
+    }
+    if grant.RoomAdmin && grant.Room == "" {
+        add("admin-without-room", SeverityError, "room", "roomAdmin without a room is meaningless; scope it to one room")
+    }
+    if explicitPublish(grant) && grant.Room == "" {
+        add("publish-without-room", SeverityError, "canPublish", "publish permissions are granted without a room")
+    }
+    if grant.RoomAdmin && grant.Hidden {
+        add("hidden-admin", SeverityWarning, "hidden", "an invisible participant with roomAdmin can act on the room unseen")
+    }
+    if grant.RoomAdmin && grant.Confirmation == nil {
+        add("unbound-admin", SeverityWarning, "cnf", "roomAdmin tokens should be bound to a client key")
+    }
+    if participates && (grant.RoomCreate || grant.RoomList || grant.RoomRecord || grant.IngressAdmin) {
+        add("server-permission-on-participant", SeverityWarning, "roomCreate",
+            "server-level permissions are combined with a participant grant; issue them as separate tokens")
+    }
+    if grant.Hidden && !grant.Recorder && !grant.Agent {
+        add("hidden-participant", SeverityInfo, "hidden", "hidden is usually reserved for recorders and agents")
+    }
+    if grant.Agent && grant.GetCanPublish() && grant.GetCanUpdateOwnMetadata() {
+        add("broad-agent", SeverityInfo, "agent", "agent may publish and update its own metadata")
+    }
+    if grant.Recorder && grant.GetCanPublish() {
+        add("publishing-recorder", SeverityWarning, "canPublish", "recorders should be subscribe-only")
+    }
+    for _, cidr := range grant.DeniedCIDRs {
+        if _, err := netip.ParsePrefix(cidr); err != nil {
+            add("invalid-cidr", SeverityError, "deniedCIDRs", "not a CIDR prefix: "+cidr)
+        }
+    }
+    for _, country := range grant.AllowedCountries {
+        if len(country) != 2 || strings.ToUpper(country) != country {
+            add("invalid-country", SeverityError, "allowedCountries", "not an ISO 3166-1 alpha-2 code: "+country)
+        }
+    }
+    if participates && grant.PQPublicKey == "" {
+        add("pq-key-missing", SeverityInfo, "pqPublicKey", "participant token carries no post-quantum key")
+    }
+    return out
+}
+
+// LintError carries the findings that blocked issuance; it matches ErrGrantRejected
+type LintError struct {
+    Warnings []Warning
+}
+
+func (e *LintError) Error() string {
+    codes := make([]string, len(e.Warnings))
+    for i, w := range e.Warnings {
+        codes[i] = w.Code
+    }
+    return ErrGrantRejected.Error() + ": " + strings.Join(codes, ", ")
+}
+
+func (e *LintError) Unwrap() error { return ErrGrantRejected }
+
+// Gate returns an issuance check that rejects grants with findings at or above min,
+// for use with VollyAccessToken.SetIssuanceCheck
+func Gate(min Severity) func(grant *auth.VollyVideoGrant) error {
+    return func(grant *auth.VollyVideoGrant) error {
+        var blocking []Warning
+        for _, w := range Lint(grant) {
+            if w.Severity >= min {
+                blocking = append(blocking, w)
+            }
+        }
+        if len(blocking) > 0 {
+            return &LintError{Warnings: blocking}
+        }
+        return nil
+    }
+}
+
+// explicitPublish reports publish rights the issuer asked for, as opposed to
+// LiveKit's implicit defaults when no permission is set
+func explicitPublish(grant *auth.VollyVideoGrant) bool {
+    return (grant.CanPublish != nil && *grant.CanPublish) ||
+        (grant.CanPublishData != nil && *grant.CanPublishData) ||
+        len(grant.CanPublishSources) > 0
+}
@@ -0,0 +1,453 @@
+// Package handraise lets a participant who may only watch ask to publish, and a host of the
+// room let them. The approved grant is an elevation of the participant's session token: it
+// keeps their identity, room and keys, adds publish rights, dies with the session token and
+// is pushed to the participant's signaling sessions so they need not ask for it out of band
+package handraise
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/events"
+)
+
+// Events published as hands are raised and decided; Data carries tenant, hand and, once a
+// host decided, by
+const (
+    EventRaised   = "hand_raised"
+    EventLowered  = "hand_lowered"
+    EventApproved = "hand_approved"
+    EventDenied   = "hand_denied"
+)
+
+const (
+    // DefaultTTL is how long a hand stays raised without a decision, and how long a decided
+    // hand can still be looked up
+    DefaultTTL = 10 * time.Minute
+    // DefaultMaxPending bounds the hands raised in one room at a time
+    DefaultMaxPending = 100
+    maxReasonLength   = 256
+)
+
+// Sources a host may let a participant publish, as LiveKit names them
+var sources = map[string]bool{
+    "camera":             true,
+    "microphone":         true,
+    "screen_share":       true,
+    "screen_share_audio": true,
+}
+
+var (
+    ErrNoSession      = errors.New("raising a hand needs a room session token with a jti")
+    ErrCanPublish     = errors.New("participant can already publish")
+    ErrNotHost        = errors.New("only an admin of the room can decide on raised hands")
+    ErrHandNotFound   = errors.New("no such raised hand")
+    ErrHandDecided    = errors.New("hand is no longer raised")
+    ErrTooManyHands   = errors.New("too many hands are raised in this room")
+    ErrReasonTooLong  = errors.New("reason is longer than 256 bytes")
+    ErrInvalidSource  = errors.New("unknown publish source")
+    ErrSessionExpired = errors.New("session token has expired")
+)
+
+// State is where a raised hand stands
+type State string
+
+const (
+    StatePending  State = "pending"
+    StateApproved State = "approved"
+    StateDenied   State = "denied"
+    StateLowered  State = "lowered"
+)
+
+// Hand is one participant's request to publish
+type Hand struct {
+    ID       string    `json:"id"`
+    Tenant   string    `json:"tenant"`
+    Room     string    `json:"room"`
+    Identity string    `json:"identity"`
+    Reason   string    `json:"reason,omitempty"`
+    State    State     `json:"state"`
+    RaisedAt time.Time `json:"raisedAt"`
+    // DecidedBy is the host who approved or denied the hand
+    DecidedBy string    `json:"decidedBy,omitempty"`
+    DecidedAt time.Time `json:"decidedAt,omitempty"`
+    // Sources are the sources the host let the participant publish; empty means all
+    Sources []string `json:"sources,omitempty"`
+}
+
+// Minter signs an approved grant valid for ttl; zero means the session token never expires
+type Minter func(ctx context.Context, grant *auth.VollyVideoGrant, ttl time.Duration) (token string, expiresAt time.Time, err error)
+
+// Pusher delivers a decided hand to one of the participant's signaling sessions, with the
+// new token once it was approved
+type Pusher func(hand Hand, token string, expiresAt time.Time) error
+
+type entry struct {
+    hand    Hand
+    session *auth.VollyVideoGrant
+    // token is kept until the hand expires, for sessions that cannot be pushed to
+    token     string
+    expiresAt time.Time
+}
+
+type sessionKey struct {
+    tenant, room, identity string
+}
+
+// Desk holds the hands raised on this instance and the signaling sessions they are pushed to
+type Desk struct {
+    bus        events.Bus
+    ttl        time.Duration
+    maxPending int
+    clock      clock.Clock
+
+    mu      sync.Mutex
+    hands   map[string]*entry
+    nextID  int
+    pushers map[sessionKey]map[int]Pusher
+}
+
+// New creates a desk that publishes its events on bus, which may be nil
+func New(bus events.Bus) *Desk {
+    return &Desk{
+        bus:        bus,
+        ttl:        DefaultTTL,
+        maxPending: DefaultMaxPending,
+        clock:      clock.System,
+        hands:      make(map[string]*entry),
+        pushers:    make(map[sessionKey]map[int]Pusher),
+    }
+}
+
+// SetTTL sets how long a hand stays raised without a decision
+func (d *Desk) SetTTL(ttl time.Duration) *Desk {
+    d.ttl = ttl
+    return d
+}
+
+// SetMaxPending bounds the hands raised in one room at a time
+func (d *Desk) SetMaxPending(n int) *Desk {
+    d.maxPending = n
+    return d
+}
+
+// SetClock sets the time source for expiry
+func (d *Desk) SetClock(c clock.Clock) *Desk {
+    d.clock = c
+    return d
+}
+
+// Raise asks for publish rights on behalf of the holder of session. A participant with a
+// hand already raised in the room gets that hand back
+func (d *Desk) Raise(ctx context.Context, session *auth.VollyVideoGrant, reason string) (Hand, error) {
+    switch {
+    case session.TokenID == "" || session.Room == "" || !session.RoomJoin || session.ElevatedFrom != "":
+        return Hand{}, ErrNoSession
+    case session.GetCanPublish():
+        return Hand{}, ErrCanPublish
+    case len(reason) > maxReasonLength:
+        return Hand{}, ErrReasonTooLong
+    }
+    now := d.clock.Now()
+    id, err := newID()
+    if err != nil {
+        return Hand{}, err
+    }
+
+    d.mu.Lock()
+    d.prune(now)
+    pending := 0
+    for _, e := range d.hands {
+        if e.hand.Tenant != session.Tenant || e.hand.Room != session.Room || e.hand.State != StatePending {
+            continue
+        }
+        if e.hand.Identity == session.Identity {
+            d.mu.Unlock()
+            return e.hand, nil
+        }
+        pending++
+    }
+    if pending >= d.maxPending {
+        d.mu.Unlock()
+        return Hand{}, ErrTooManyHands
+    }
+    hand := Hand{
+        ID:       id,
+        Tenant:   session.Tenant,
+        Room:     session.Room,
+        Identity: session.Identity,
+        Reason:   reason,
+        State:    StatePending,
+        RaisedAt: now,
+    }
+    d.hands[id] = &entry{hand: hand, session: session}
+    d.mu.Unlock()
+
+    d.publish(ctx, EventRaised, hand)
+    return hand, nil
+}
+
+// Lower withdraws a hand the holder of session raised
+func (d *Desk) Lower(ctx context.Context, session *auth.VollyVideoGrant, id string) (Hand, error) {
+    d.mu.Lock()
+    e, err := d.find(id, session.Tenant, session.Room)
+    if err == nil && e.hand.Identity != session.Identity {
+        err = ErrHandNotFound
+    }
+    if err == nil && e.hand.State != StatePending {
+        err = ErrHandDecided
+    }
+    if err != nil {
+        d.mu.Unlock()
+        return Hand{}, err
+    }
+    e.hand.State = StateLowered
+    e.hand.DecidedAt = d.clock.Now()
+    hand := e.hand
+    d.mu.Unlock()
+
+    d.publish(ctx, EventLowered, hand)
+    return hand, nil
+}
+
+// Get returns a hand in session's room. The participant who raised it also gets the token it
+// was approved with
+func (d *Desk) Get(session *auth.VollyVideoGrant, id string) (hand Hand, token string, expiresAt time.Time, err error) {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    d.prune(d.clock.Now())
+    e, err := d.find(id, session.Tenant, session.Room)
+    if err != nil {
+        return Hand{}, "", time.Time{}, err
+    }
+    if e.hand.Identity != session.Identity {
+        if !isHost(session, e.hand) {
+            return Hand{}, "", time.Time{}, ErrHandNotFound
+        }
+        return e.hand, "", time.Time{}, nil
+    }
+    return e.hand, e.token, e.expiresAt, nil
+}
+
+// Pending lists the hands raised in host's room, oldest first
+func (d *Desk) Pending(host *auth.VollyVideoGrant) ([]Hand, error) {
+    if !isHost(host, Hand{Tenant: host.Tenant, Room: host.Room}) {
+        return nil, ErrNotHost
+    }
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    d.prune(d.clock.Now())
+    var hands []Hand
+    for _, e := range d.hands {
+        if e.hand.Tenant == host.Tenant && e.hand.Room == host.Room && e.hand.State == StatePending {
+            hands = append(hands, e.hand)
+        }
+    }
+    sort.Slice(hands, func(i, j int) bool { return hands[i].RaisedAt.Before(hands[j].RaisedAt) })
+    return hands, nil
+}
+
+// Approve lets the participant publish from sources, or from every source when none are
+// given, signing the new grant with mint. Their new token lives as long as the session token
+// it elevates and is pushed to every signaling session they have attached
+func (d *Desk) Approve(ctx context.Context, host *auth.VollyVideoGrant, id string, publish []string, mint Minter) (Hand, error) {
+    for _, s := range publish {
+        if !sources[s] {
+            return Hand{}, fmt.Errorf("%w: %s", ErrInvalidSource, s)
+        }
+    }
+    e, err := d.decide(host, id)
+    if err != nil {
+        return Hand{}, err
+    }
+    session := e.session
+    var ttl time.Duration
+    if session.ExpiresAt != 0 {
+        if ttl = time.Unix(session.ExpiresAt, 0).Sub(d.clock.Now()); ttl <= 0 {
+            d.reopen(e)
+            return Hand{}, ErrSessionExpired
+        }
+    }
+    token, expiresAt, err := mint(ctx, promote(session, publish), ttl)
+    if err != nil {
+        d.reopen(e)
+        return Hand{}, err
+    }
+
+    d.mu.Lock()
+    e.hand.State = StateApproved
+    e.hand.DecidedBy = host.Identity
+    e.hand.DecidedAt = d.clock.Now()
+    e.hand.Sources = publish
+    e.token, e.expiresAt = token, expiresAt
+    hand := e.hand
+    d.mu.Unlock()
+
+    d.publish(ctx, EventApproved, hand)
+    d.push(hand, token, expiresAt)
+    return hand, nil
+}
+
+// Deny turns a hand down
+func (d *Desk) Deny(ctx context.Context, host *auth.VollyVideoGrant, id string) (Hand, error) {
+    e, err := d.decide(host, id)
+    if err != nil {
+        return Hand{}, err
+    }
+    d.mu.Lock()
+    e.hand.State = StateDenied
+    e.hand.DecidedBy = host.Identity
+    e.hand.DecidedAt = d.clock.Now()
+    hand := e.hand
+    d.mu.Unlock()
+
+    d.publish(ctx, EventDenied, hand)
+    d.push(hand, "", time.Time{})
+    return hand, nil
+}
+
+// Attach pushes the decisions on identity's hands in room to a signaling session until the
+// returned function is called
+func (d *Desk) Attach(tenant, room, identity string, push Pusher) (detach func()) {
+    key := sessionKey{tenant: tenant, room: room, identity: identity}
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    if d.pushers[key] == nil {
+        d.pushers[key] = make(map[int]Pusher)
+    }
+    id := d.nextID
+    d.nextID++
+    d.pushers[key][id] = push
+    return func() {
+        d.mu.Lock()
+        defer d.mu.Unlock()
+        delete(d.pushers[key], id)
+        if len(d.pushers[key]) == 0 {
+            delete(d.pushers, key)
+        }
+    }
+}
+
+// decide claims a pending hand for host, so two hosts cannot both decide it
+func (d *Desk) decide(host *auth.VollyVideoGrant, id string) (*entry, error) {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    d.prune(d.clock.Now())
+    e, err := d.find(id, host.Tenant, host.Room)
+    if err != nil {
+        return nil, err
+    }
+    if !isHost(host, e.hand) {
+        return nil, ErrNotHost
+    }
+    if e.hand.State != StatePending {
+        return nil, ErrHandDecided
+    }
+    // Claimed but not yet decided; reopen puts it back
+    e.hand.State = ""
+    return e, nil
+}
+
+func (d *Desk) reopen(e *entry) {
+    d.mu.Lock()
+    e.hand.State = StatePending
+    d.mu.Unlock()
+}
+
+// find looks a hand up in a room; call it with d.mu held
+func (d *Desk) find(id, tenant, room string) (*entry, error) {
+    e, ok := d.hands[id]
+    if !ok || e.hand.Tenant != tenant || e.hand.Room != room {
+        return nil, ErrHandNotFound
+    }
+    return e, nil
+}
+
+// prune drops hands raised or decided more than the TTL ago; call it with d.mu held
+func (d *Desk) prune(now time.Time) {
+    for id, e := range d.hands {
+        since := e.hand.RaisedAt
+        if !e.hand.DecidedAt.IsZero() {
+            since = e.hand.DecidedAt
+        }
+        if e.hand.State != "" && now.Sub(since) > d.ttl {
+            delete(d.hands, id)
+        }
+    }
+}
+
+func (d *Desk) push(hand Hand, token string, expiresAt time.Time) {
+    key := sessionKey{tenant: hand.Tenant, room: hand.Room, identity: hand.Identity}
+    d.mu.Lock()
+    var targets []Pusher
+    for _, p := range d.pushers[key] {
+        targets = append(targets, p)
+    }
+    d.mu.Unlock()
+    for _, p := range targets {
+        // A session that has gone away detaches itself; the token can still be fetched
+        _ = p(hand, token, expiresAt)
+    }
+}
+
+func (d *Desk) publish(ctx context.Context, typ string, hand Hand) {
+    if d.bus == nil {
+        return
+    }
+    data := map[string]string{"tenant": hand.Tenant, "hand": hand.ID}
+    if hand.DecidedBy != "" {
+        data["by"] = hand.DecidedBy
+    }
+    _ = d.bus.Publish(ctx, events.Event{Type: typ, Room: hand.Room, Identity: hand.Identity, Data: data})
+}
+
+// isHost reports whether grant administers hand's room; viewer and delegation tokens never do
+func isHost(grant *auth.VollyVideoGrant, hand Hand) bool {
+    return grant.RoomAdmin && grant.PQStatus != auth.PQStatusViewer && grant.Actor == nil &&
+        grant.Tenant == hand.Tenant && grant.Room == hand.Room
+}
+
+// promote derives the publishing grant from the participant's session grant, the way an
+// elevation does; a viewer token's hidden, subscribe-only grant becomes a visible one
+func promote(session *auth.VollyVideoGrant, publish []string) *auth.VollyVideoGrant {
+    t := true
+    grant := &auth.VollyVideoGrant{
+        VideoGrant:       session.VideoGrant,
+        PQPublicKey:      session.PQPublicKey,
+        PQAlgorithm:      session.PQAlgorithm,
+        PQKeyExpiry:      session.PQKeyExpiry,
+        PQKeyIssuedAt:    session.PQKeyIssuedAt,
+        Tenant:           session.Tenant,
+        AllowedCountries: session.AllowedCountries,
+        DeniedCIDRs:      session.DeniedCIDRs,
+        Claims:           session.Claims,
+        Tags:             session.Tags,
+        Confirmation:     session.Confirmation,
+        PINHash:          session.PINHash,
+        EndTime:          session.EndTime,
+        OnExpiry:         session.OnExpiry,
+        Provenance:       session.Provenance,
+        Identity:         session.Identity,
+        ElevatedFrom:     session.TokenID,
+    }
+    grant.CanPublish = &t
+    grant.CanPublishSources = publish
+    grant.Hidden = false
+    return grant
+}
+
+func newID() (string, error) {
+    b := make([]byte, 12)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,83 @@
+package hsm
+
+import (
+    "errors"
+    "sync"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+)
+
+// Mechanism is a PKCS#11 CKM_* mechanism type
+type Mechanism uint
+
+// Mechanisms used for token signing
+const (
+    MechanismSHA256HMAC Mechanism = 0x00000251 // CKM_SHA256_HMAC
+    MechanismMLDSA      Mechanism = 0x0000001d // CKM_ML_DSA, PKCS#11 v3.2
+)
+
+// SessionHandle and ObjectHandle are CK_SESSION_HANDLE and CK_OBJECT_HANDLE values
+type (
+    SessionHandle uint
+    ObjectHandle  uint
+)
+
+var (
+    ErrKeyNotFound         = errors.New("signing key not found on HSM partition")
+    ErrNoHealthyPartitions = errors.New("no healthy HSM partition available")
+    ErrPoolClosed          = errors.New("HSM session pool is closed")
+    ErrUnknownModule       = errors.New("no PKCS#11 module is registered under that name")
+)
+
+// Module is the subset of a PKCS#11 library used for signing, bound to one
+// partition (slot); it is typically a thin adapter over a cgo binding such as
+// miekg/pkcs11 with the slot PIN already configured
+type Module interface {
+    // OpenSession opens and logs in a read-only user session
+    OpenSession() (SessionHandle, error)
+    CloseSession(session SessionHandle) error
+    // FindKey looks up a private or secret key by CKA_LABEL
+    FindKey(session SessionHandle, label string) (ObjectHandle, error)
+    // Sign runs C_SignInit and C_Sign in one call
+    Sign(session SessionHandle, mechanism Mechanism, key ObjectHandle, data []byte) ([]byte, error)
+}
+
+// ModuleOpener opens a Module bound to one slot
+type ModuleOpener func(slot string) (Module, error)
+
+var (
+    modulesMu sync.RWMutex
+    modules   = map[string]ModuleOpener{}
+)
+
+// RegisterModule makes a PKCS#11 binding openable by name, typically from the init function
+// of an adapter package built under its own tag
+func RegisterModule(name string, open ModuleOpener) {
+    modulesMu.Lock()
+    modules[name] = open
+    modulesMu.Unlock()
+}
+
+// OpenModule opens slot with the binding registered as name
+func OpenModule(name, slot string) (Module, error) {
+    modulesMu.RLock()
+    open, ok := modules[name]
+    modulesMu.RUnlock()
+    if !ok {
+        return nil, ErrUnknownModule
+    }
+    return open(slot)
+}
+
+// mechanismFor maps a token signing algorithm to its PKCS#11 mechanism
+func mechanismFor(algorithm string) (Mechanism, error) {
+    switch algorithm {
+    case AlgorithmHS256:
+        return MechanismSHA256HMAC, nil
+    case crypto.AlgorithmMLDSA44, crypto.AlgorithmMLDSA65, crypto.AlgorithmMLDSA87:
+        // The parameter set is a property of the key object, not the mechanism
+        return MechanismMLDSA, nil
+    default:
+        return 0, crypto.ErrUnsupportedAlgorithm
+    }
+}
@@ -0,0 +1,130 @@
+package hsm
+
+import (
+    "context"
+    "sync"
+)
+
+// SessionPool reuses logged-in sessions on one partition; PKCS#11 sessions are
+// not safe for concurrent use, so each signature holds a session exclusively
+type SessionPool struct {
+    module Module
+    tokens chan struct{}
+
+    mu     sync.Mutex
+    idle   []SessionHandle
+    keys   map[SessionHandle]map[string]ObjectHandle
+    closed bool
+}
+
+// NewSessionPool creates a pool of at most maxSessions open sessions
+func NewSessionPool(module Module, maxSessions int) *SessionPool {
+    if maxSessions < 1 {
+        maxSessions = 1
+    }
+    return &SessionPool{
+        module: module,
+        tokens: make(chan struct{}, maxSessions),
+        keys:   make(map[SessionHandle]map[string]ObjectHandle),
+    }
+}
+
+// Sign signs data with the key labelled label, waiting for a free session if needed
+func (p *SessionPool) Sign(ctx context.Context, label string, mechanism Mechanism, data []byte) ([]byte, error) {
+    select {
+    case p.tokens <- struct{}{}:
+    case <-ctx.Done():
+        return nil, ctx.Err()
+    }
+    defer func() { <-p.tokens }()
+
+    session, err := p.acquire()
+    if err != nil {
+        return nil, err
+    }
+
+    key, err := p.findKey(session, label)
+    if err == nil {
+        var sig []byte
+        if sig, err = p.module.Sign(session, mechanism, key, data); err == nil {
+            p.release(session)
+            return sig, nil
+        }
+    }
+    // A failed session may be invalidated by the HSM (e.g. after a partition
+    // failover), so it is closed rather than returned to the pool
+    p.discard(session)
+    return nil, err
+}
+
+// Close closes idle sessions and rejects further use
+func (p *SessionPool) Close() error {
+    p.mu.Lock()
+    idle := p.idle
+    p.idle = nil
+    p.closed = true
+    p.mu.Unlock()
+
+    var firstErr error
+    for _, s := range idle {
+        if err := p.module.CloseSession(s); err != nil && firstErr == nil {
+            firstErr = err
+        }
+    }
+    return firstErr
+}
+
+func (p *SessionPool) acquire() (SessionHandle, error) {
+    p.mu.Lock()
+    if p.closed {
+        p.mu.Unlock()
+        return 0, ErrPoolClosed
+    }
+    if n := len(p.idle); n > 0 {
+        s := p.idle[n-1]
+        p.idle = p.idle[:n-1]
+        p.mu.Unlock()
+        return s, nil
+    }
+    p.mu.Unlock()
+    return p.module.OpenSession()
+}
+
+func (p *SessionPool) findKey(session SessionHandle, label string) (ObjectHandle, error) {
+    p.mu.Lock()
+    if key, ok := p.keys[session][label]; ok {
+        p.mu.Unlock()
+        return key, nil
+    }
+    p.mu.Unlock()
+
+    key, err := p.module.FindKey(session, label)
+    if err != nil {
+        return 0, err
+    }
+    p.mu.Lock()
+    if p.keys[session] == nil {
+        p.keys[session] = make(map[string]ObjectHandle)
+    }
+    p.keys[session][label] = key
+    p.mu.Unlock()
+    return key, nil
+}
+
+func (p *SessionPool) release(session SessionHandle) {
+    p.mu.Lock()
+    if !p.closed {
+        p.idle = append(p.idle, session)
+        p.mu.Unlock()
+        return
+    }
+    p.mu.Unlock()
+    p.discard(session)
+}
+
+func (p *SessionPool) discard(session SessionHandle) {
+    p.mu.Lock()
+    delete(p.keys, session)
+    p.mu.Unlock()
+    _ = p.module.CloseSession(session)
+}
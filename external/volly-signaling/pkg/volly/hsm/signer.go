@@ -0,0 +1,247 @@
+package hsm
+
+import (
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+)
+
+// AlgorithmHS256 is HMAC-SHA256 with a secret key held on the HSM
+const AlgorithmHS256 = "HS256"
+
+// DefaultCooldown is how long a failing partition is skipped before it is retried
+const DefaultCooldown = 30 * time.Second
+
+var (
+    ErrInvalidToken     = errors.New("invalid HSM-signed token")
+    ErrTokenExpired     = errors.New("token has expired")
+    ErrTokenNotYetValid = errors.New("token is not valid yet")
+    ErrNoExpiry         = errors.New("token claims have no exp")
+)
+
+// Partition is one HSM partition holding a replica of the signing key
+type Partition struct {
+    Name string
+    Pool *SessionPool
+}
+
+type partitionState struct {
+    Partition
+    downUntil time.Time
+}
+
+// Signer signs with a key that lives only on the HSM, failing over between
+// partitions in order of preference
+type Signer struct {
+    label     string
+    algorithm string
+    mechanism Mechanism
+    publicKey []byte
+    cooldown  time.Duration
+    clock     clock.Clock
+
+    mu         sync.Mutex
+    partitions []*partitionState
+}
+
+// NewSigner creates a signer for the key labelled label; publicKey is the
+// exported ML-DSA public key and is nil for HS256
+func NewSigner(label, algorithm string, publicKey []byte, partitions ...Partition) (*Signer, error) {
+    mechanism, err := mechanismFor(algorithm)
+    if err != nil {
+        return nil, err
+    }
+    s := &Signer{
+        label:     label,
+        algorithm: algorithm,
+        mechanism: mechanism,
+        publicKey: publicKey,
+        cooldown:  DefaultCooldown,
+        clock:     clock.System,
+    }
+    for _, p := range partitions {
+        s.partitions = append(s.partitions, &partitionState{Partition: p})
+    }
+    return s, nil
+}
+
+// SetCooldown sets how long a failed partition is skipped
+func (s *Signer) SetCooldown(cooldown time.Duration) *Signer {
+    s.cooldown = cooldown
+    return s
+}
+
+// SetClock sets the time source for partition cooldowns and token expiry
+func (s *Signer) SetClock(c clock.Clock) *Signer {
+    s.clock = c
+    return s
+}
+
+// Algorithm returns the signing algorithm
+func (s *Signer) Algorithm() string { return s.algorithm }
+
+// PublicKey returns the ML-DSA public key, or nil for HMAC keys
+func (s *Signer) PublicKey() []byte { return s.publicKey }
+
+// Sign implements crypto.Signer without a deadline; prefer SignContext
+func (s *Signer) Sign(message []byte) ([]byte, error) {
+    return s.SignContext(context.Background(), message)
+}
+
+// SignContext signs on the first healthy partition, failing over on errors;
+// when all are cooling down the least recently failed one is tried anyway
+func (s *Signer) SignContext(ctx context.Context, message []byte) ([]byte, error) {
+    lastErr := ErrNoHealthyPartitions
+    for _, p := range s.candidates() {
+        sig, err := p.Pool.Sign(ctx, s.label, s.mechanism, message)
+        if err == nil {
+            s.markUp(p)
+            return sig, nil
+        }
+        if ctx.Err() != nil {
+            return nil, ctx.Err()
+        }
+        s.markDown(p)
+        lastErr = err
+    }
+    return nil, lastErr
+}
+
+func (s *Signer) candidates() []*partitionState {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    now := s.clock.Now()
+    var healthy []*partitionState
+    var oldest *partitionState
+    for _, p := range s.partitions {
+        if now.After(p.downUntil) {
+            healthy = append(healthy, p)
+        } else if oldest == nil || p.downUntil.Before(oldest.downUntil) {
+            oldest = p
+        }
+    }
+    if len(healthy) == 0 && oldest != nil {
+        return []*partitionState{oldest}
+    }
+    return healthy
+}
+
+func (s *Signer) markDown(p *partitionState) {
+    s.mu.Lock()
+    p.downUntil = s.clock.Now().Add(s.cooldown)
+    s.mu.Unlock()
+}
+
+func (s *Signer) markUp(p *partitionState) {
+    s.mu.Lock()
+    p.downUntil = time.Time{}
+    s.mu.Unlock()
+}
+
+// SignToken signs claims as a compact JWT with the HSM key; keyID is placed in the kid header.
+// claims must carry an exp, since VerifyToken refuses tokens without one
+func (s *Signer) SignToken(ctx context.Context, keyID string, claims map[string]interface{}) (string, error) {
+    if !hasExpiry(claims["exp"]) {
+        return "", ErrNoExpiry
+    }
+    header, err := json.Marshal(map[string]string{
+        "alg": s.algorithm,
+        "typ": "JWT",
+        "kid": keyID,
+    })
+    if err != nil {
+        return "", err
+    }
+    payload, err := json.Marshal(claims)
+    if err != nil {
+        return "", err
+    }
+    signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+    signature, err := s.SignContext(ctx, []byte(signingInput))
+    if err != nil {
+        return "", err
+    }
+    return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// VerifyToken checks a token from SignToken and returns its claims, requiring an exp that has
+// not passed and an nbf, if any, that has; HS256 tokens are recomputed on the HSM since the
+// secret never leaves it
+func (s *Signer) VerifyToken(ctx context.Context, token string) (map[string]interface{}, error) {
+    parts := strings.Split(token, ".")
+    if len(parts) != 3 {
+        return nil, ErrInvalidToken
+    }
+    headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+    if err != nil {
+        return nil, ErrInvalidToken
+    }
+    var header struct {
+        Alg string `json:"alg"`
+    }
+    if err := json.Unmarshal(headerJSON, &header); err != nil || header.Alg != s.algorithm {
+        return nil, ErrInvalidToken
+    }
+    signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+    if err != nil {
+        return nil, ErrInvalidToken
+    }
+
+    signingInput := []byte(parts[0] + "." + parts[1])
+    if s.algorithm == AlgorithmHS256 {
+        expected, err := s.SignContext(ctx, signingInput)
+        if err != nil {
+            return nil, err
+        }
+        if !crypto.Equal(expected, signature) {
+            return nil, ErrInvalidToken
+        }
+    } else if err := crypto.VerifySignature(s.algorithm, s.publicKey, signingInput, signature); err != nil {
+        return nil, ErrInvalidToken
+    }
+
+    payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+    if err != nil {
+        return nil, ErrInvalidToken
+    }
+    var claims map[string]interface{}
+    if err := json.Unmarshal(payload, &claims); err != nil {
+        return nil, ErrInvalidToken
+    }
+    now := s.clock.Now().Unix()
+    exp, ok := claims["exp"].(float64)
+    if !ok {
+        return nil, ErrInvalidToken
+    }
+    if now > int64(exp) {
+        return nil, ErrTokenExpired
+    }
+    if nbf, ok := claims["nbf"]; ok {
+        n, ok := nbf.(float64)
+        if !ok {
+            return nil, ErrInvalidToken
+        }
+        if now < int64(n) {
+            return nil, ErrTokenNotYetValid
+        }
+    }
+    return claims, nil
+}
+
+// hasExpiry reports whether exp is a numeric exp claim as it is signed
+func hasExpiry(exp interface{}) bool {
+    switch exp.(type) {
+    case int, int32, int64, uint32, uint64, float32, float64, json.Number:
+        return true
+    }
+    return false
+}
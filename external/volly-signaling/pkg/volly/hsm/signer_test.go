@@ -0,0 +1,104 @@
+package hsm
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "errors"
+    "testing"
+    "time"
+
+    lkauth "github.com/livekit/protocol/auth"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+)
+
+const testSecret = "hsm-secret-with-enough-entropy-0001"
+
+// hmacModule is a partition holding one HMAC key, computed in memory
+type hmacModule struct{}
+
+func (hmacModule) OpenSession() (SessionHandle, error)                 { return 1, nil }
+func (hmacModule) CloseSession(SessionHandle) error                    { return nil }
+func (hmacModule) FindKey(SessionHandle, string) (ObjectHandle, error) { return 1, nil }
+
+func (hmacModule) Sign(_ SessionHandle, mechanism Mechanism, _ ObjectHandle, data []byte) ([]byte, error) {
+    if mechanism != MechanismSHA256HMAC {
+        return nil, errors.New("unexpected mechanism")
+    }
+    h := hmac.New(sha256.New, []byte(testSecret))
+    h.Write(data)
+    return h.Sum(nil), nil
+}
+
+func newTestSigner(t *testing.T, now time.Time) *Signer {
+    t.Helper()
+    s, err := NewSigner("volly", AlgorithmHS256, nil, Partition{Name: "a", Pool: NewSessionPool(hmacModule{}, 1)})
+    if err != nil {
+        t.Fatal(err)
+    }
+    return s.SetClock(clock.NewManual(now))
+}
+
+// TestVerifyTokenWindow refuses tokens without an exp, past their exp or before their nbf
+func TestVerifyTokenWindow(t *testing.T) {
+    ctx := context.Background()
+    now := time.Unix(1_700_000_000, 0)
+    s := newTestSigner(t, now)
+
+    if _, err := s.SignToken(ctx, "k", map[string]interface{}{"sub": "alice"}); !errors.Is(err, ErrNoExpiry) {
+        t.Fatalf("sign without exp: got %v, want ErrNoExpiry", err)
+    }
+    for _, tc := range []struct {
+        name   string
+        claims map[string]interface{}
+        want   error
+    }{
+        {"valid", map[string]interface{}{"nbf": now.Unix(), "exp": now.Add(time.Hour).Unix()}, nil},
+        {"expired", map[string]interface{}{"exp": now.Add(-time.Second).Unix()}, ErrTokenExpired},
+        {"not yet valid", map[string]interface{}{"nbf": now.Add(time.Minute).Unix(), "exp": now.Add(time.Hour).Unix()}, ErrTokenNotYetValid},
+        {"non-numeric nbf", map[string]interface{}{"nbf": "soon", "exp": now.Add(time.Hour).Unix()}, ErrInvalidToken},
+    } {
+        token, err := s.SignToken(ctx, "k", tc.claims)
+        if err != nil {
+            t.Fatalf("%s: %v", tc.name, err)
+        }
+        if _, err := s.VerifyToken(ctx, token); !errors.Is(err, tc.want) {
+            t.Errorf("%s: got %v, want %v", tc.name, err, tc.want)
+        }
+    }
+}
+
+// TestSignerBacksAccessTokens signs Volly tokens on the HSM and verifies them with the plain
+// secret, and the other way round
+func TestSignerBacksAccessTokens(t *testing.T) {
+    s := newTestSigner(t, time.Now())
+    grant := func() *auth.VollyVideoGrant {
+        return &auth.VollyVideoGrant{VideoGrant: lkauth.VideoGrant{RoomJoin: true, Room: "r"}}
+    }
+
+    token, err := auth.NewVollyAccessTokenWithSigner("k", s).AddGrant(grant()).SetIdentity("alice").ToJWT()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := auth.NewVerifier("k", testSecret).SetLegacyPolicy(auth.AdmitLegacy).Verify(token); err != nil {
+        t.Fatalf("plain verifier: %v", err)
+    }
+
+    token, err = auth.NewVollyAccessToken("k", testSecret).AddGrant(grant()).SetIdentity("alice").ToJWT()
+    if err != nil {
+        t.Fatal(err)
+    }
+    v := auth.NewVerifierWithSigner("k", s).SetLegacyPolicy(auth.AdmitLegacy)
+    if _, err := v.Verify(token); err != nil {
+        t.Fatalf("HSM verifier: %v", err)
+    }
+    forged, err := auth.NewVollyAccessToken("k", "another-secret-with-enough-entropy").AddGrant(grant()).SetIdentity("alice").ToJWT()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := v.Verify(forged); !errors.Is(err, auth.ErrInvalidToken) {
+        t.Fatalf("token under another secret: got %v, want ErrInvalidToken", err)
+    }
+}
@@ -0,0 +1,93 @@
+package idempotency
+
+import (
+    "bytes"
+    "errors"
+    "io"
+    "net/http"
+)
+
+// HeaderKey carries the client's idempotency key
+const HeaderKey = "Idempotency-Key"
+
+// HeaderReplayed marks responses served from the store
+const HeaderReplayed = "Idempotent-Replayed"
+
+// maxBodySize bounds request bodies read for fingerprinting
+const maxBodySize = 1 << 20
+
+// Middleware replays the stored response for retried requests that carry an Idempotency-Key.
+// The fingerprint covers method, path, Authorization and body, so a key reused by another
+// client or for another payload is rejected instead of leaking the first response.
+// Requests without the header pass through; 5xx responses are not cached
+func Middleware(store Store, next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        key := r.Header.Get(HeaderKey)
+        if key == "" {
+            next.ServeHTTP(w, r)
+            return
+        }
+        if len(key) > MaxKeyLength {
+            http.Error(w, ErrInvalidKey.Error(), http.StatusBadRequest)
+            return
+        }
+
+        body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+        if err != nil || len(body) > maxBodySize {
+            http.Error(w, "request body too large for idempotent replay", http.StatusRequestEntityTooLarge)
+            return
+        }
+        r.Body = io.NopCloser(bytes.NewReader(body))
+
+        fingerprint := Fingerprint([]byte(r.Method), []byte(r.URL.Path), []byte(r.Header.Get("Authorization")), body)
+        cached, err := store.Begin(r.Context(), key, fingerprint, DefaultTTL)
+        switch {
+        case errors.Is(err, ErrInProgress):
+            http.Error(w, err.Error(), http.StatusConflict)
+            return
+        case errors.Is(err, ErrKeyReused):
+            http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+            return
+        case err != nil:
+            http.Error(w, err.Error(), http.StatusServiceUnavailable)
+            return
+        case cached != nil:
+            for k, v := range cached.Header {
+                w.Header()[k] = v
+            }
+            w.Header().Set(HeaderReplayed, "true")
+            w.WriteHeader(cached.Status)
+            _, _ = w.Write(cached.Body)
+            return
+        }
+
+        rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+        next.ServeHTTP(rec, r)
+        if rec.status >= http.StatusInternalServerError {
+            _ = store.Abort(r.Context(), key)
+            return
+        }
+        _ = store.Complete(r.Context(), key, &Response{
+            Status: rec.status,
+            Header: w.Header().Clone(),
+            Body:   rec.body.Bytes(),
+        })
+    })
+}
+
+// recorder tees the response so it can be stored
+type recorder struct {
+    http.ResponseWriter
+    status int
+    body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+    r.status = status
+    r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+    r.body.Write(b)
+    return r.ResponseWriter.Write(b)
+}
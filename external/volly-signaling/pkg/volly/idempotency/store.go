@@ -0,0 +1,163 @@
+package idempotency
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "encoding/binary"
+    "errors"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+)
+
+// DefaultTTL is how long a completed response is replayed for a retried key
+const DefaultTTL = 24 * time.Hour
+
+// MaxKeyLength bounds client supplied keys
+const MaxKeyLength = 255
+
+var (
+    ErrInvalidKey = errors.New("idempotency key is empty or too long")
+    ErrInProgress = errors.New("a request with this idempotency key is still in progress")
+    ErrKeyReused  = errors.New("idempotency key was already used for a different request")
+    ErrStoreFull  = errors.New("idempotency store is full")
+)
+
+// Response is what gets replayed to a retried request
+type Response struct {
+    Status int                 `json:"status,omitempty"`
+    Header map[string][]string `json:"header,omitempty"`
+    Body   []byte              `json:"body"`
+}
+
+// Store reserves keys and remembers the response of the first request to complete
+type Store interface {
+    // Begin reserves key for a request with the given fingerprint. It returns the cached
+    // response if one completed, ErrInProgress if another attempt holds the key, or
+    // ErrKeyReused if the key belongs to a different request
+    Begin(ctx context.Context, key string, fingerprint []byte, ttl time.Duration) (*Response, error)
+    Complete(ctx context.Context, key string, resp *Response) error
+    // Abort releases a reservation whose request failed so a retry can run it again
+    Abort(ctx context.Context, key string) error
+}
+
+// Fingerprint hashes the parts of a request that must match for a key to be replayed
+func Fingerprint(parts ...[]byte) []byte {
+    h := sha256.New()
+    for _, p := range parts {
+        var n [8]byte
+        binary.BigEndian.PutUint64(n[:], uint64(len(p)))
+        h.Write(n[:])
+        h.Write(p)
+    }
+    return h.Sum(nil)
+}
+
+// Do runs fn at most once per key, e.g. for gRPC methods carrying an idempotency_key field.
+// Failed calls are not cached so the client can retry them
+func Do(ctx context.Context, store Store, key string, fingerprint []byte, fn func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+    if key == "" || len(key) > MaxKeyLength {
+        return nil, ErrInvalidKey
+    }
+    cached, err := store.Begin(ctx, key, fingerprint, DefaultTTL)
+    if err != nil {
+        return nil, err
+    }
+    if cached != nil {
+        return cached.Body, nil
+    }
+
+    body, err := fn(ctx)
+    if err != nil {
+        _ = store.Abort(ctx, key)
+        return nil, err
+    }
+    if err := store.Complete(ctx, key, &Response{Body: body}); err != nil {
+        return nil, err
+    }
+    return body, nil
+}
+
+type entry struct {
+    fingerprint []byte
+    response    *Response
+    expiresAt   time.Time
+}
+
+// MemoryStore is a bounded in-process Store
+type MemoryStore struct {
+    maxEntries int
+    clock      clock.Clock
+
+    mu      sync.Mutex
+    entries map[string]*entry
+}
+
+// NewMemoryStore creates a store holding at most maxEntries keys
+func NewMemoryStore(maxEntries int) *MemoryStore {
+    return &MemoryStore{
+        maxEntries: maxEntries,
+        clock:      clock.System,
+        entries:    make(map[string]*entry),
+    }
+}
+
+// SetClock sets the time source for expiry
+func (s *MemoryStore) SetClock(c clock.Clock) *MemoryStore {
+    s.clock = c
+    return s
+}
+
+// Begin reserves key; when full after pruning it returns ErrStoreFull rather than risk a double execution
+func (s *MemoryStore) Begin(ctx context.Context, key string, fingerprint []byte, ttl time.Duration) (*Response, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    now := s.clock.Now()
+    if e, ok := s.entries[key]; ok && now.Before(e.expiresAt) {
+        if !bytes.Equal(e.fingerprint, fingerprint) {
+            return nil, ErrKeyReused
+        }
+        if e.response == nil {
+            return nil, ErrInProgress
+        }
+        return e.response, nil
+    }
+
+    if len(s.entries) >= s.maxEntries {
+        for k, e := range s.entries {
+            if !now.Before(e.expiresAt) {
+                delete(s.entries, k)
+            }
+        }
+        if len(s.entries) >= s.maxEntries {
+            return nil, ErrStoreFull
+        }
+    }
+    s.entries[key] = &entry{fingerprint: fingerprint, expiresAt: now.Add(ttl)}
+    return nil, nil
+}
+
+// Complete stores the response for replay
+func (s *MemoryStore) Complete(ctx context.Context, key string, resp *Response) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if e, ok := s.entries[key]; ok {
+        e.response = resp
+    }
+    return nil
+}
+
+// Abort drops an unfinished reservation
+func (s *MemoryStore) Abort(ctx context.Context, key string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if e, ok := s.entries[key]; ok && e.response == nil {
+        delete(s.entries, key)
+    }
+    return nil
+}
@@ -0,0 +1,72 @@
+package issuance
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "strings"
+)
+
+var (
+    ErrUnauthenticated = errors.New("caller could not be authenticated")
+    ErrUnmappedCaller  = errors.New("caller identity is not mapped to a tenant")
+    ErrScopeDenied     = errors.New("caller is not allowed this issuance scope")
+)
+
+// Principal is an authenticated internal caller of the issuance API
+type Principal struct {
+    // Name is the authenticated identity, e.g. a certificate SAN or SPIFFE ID
+    Name   string
+    Tenant string
+    Scopes []string
+    // Method records how the caller authenticated, e.g. "mtls"
+    Method string
+}
+
+// Allows reports whether scope is granted; a trailing * in a granted scope matches any suffix,
+// so "room:support-*" covers "room:support-42"
+func (p *Principal) Allows(scope string) bool {
+    for _, s := range p.Scopes {
+        if s == scope || strings.HasSuffix(s, "*") && strings.HasPrefix(scope, strings.TrimSuffix(s, "*")) {
+            return true
+        }
+    }
+    return false
+}
+
+// Authenticator establishes who is calling the issuance API
+type Authenticator interface {
+    Authenticate(r *http.Request) (*Principal, error)
+}
+
+type contextKey struct{}
+
+// Middleware authenticates every request to next and stores the Principal in its context
+func Middleware(auth Authenticator, next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        principal, err := auth.Authenticate(r)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusUnauthorized)
+            return
+        }
+        next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, principal)))
+    })
+}
+
+// FromContext returns the Principal stored by Middleware
+func FromContext(ctx context.Context) (*Principal, bool) {
+    p, ok := ctx.Value(contextKey{}).(*Principal)
+    return p, ok
+}
+
+// RequireScope rejects callers without scope with 403
+func RequireScope(scope string, next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        p, ok := FromContext(r.Context())
+        if !ok || !p.Allows(scope) {
+            http.Error(w, ErrScopeDenied.Error(), http.StatusForbidden)
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
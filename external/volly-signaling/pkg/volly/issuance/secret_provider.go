@@ -0,0 +1,164 @@
+package issuance
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "os"
+    "sync"
+    "time"
+)
+
+var ErrNoBundle = errors.New("trust bundle contains no certificates")
+
+// SecretProvider supplies the workload credentials used for service-to-service issuance;
+// implementations rotate them without a restart
+type SecretProvider interface {
+    Certificate() (*tls.Certificate, error)
+    Bundle() (*x509.CertPool, error)
+}
+
+// ClientTLSConfig presents the provider's current certificate on every handshake
+func ClientTLSConfig(p SecretProvider) *tls.Config {
+    return &tls.Config{
+        MinVersion: tls.VersionTLS13,
+        GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+            return p.Certificate()
+        },
+        // Chain verification moves to VerifyConnection so bundle rotation takes effect
+        InsecureSkipVerify: true,
+        VerifyConnection: func(cs tls.ConnectionState) error {
+            return verifyPeer(p, cs, x509.ExtKeyUsageServerAuth)
+        },
+    }
+}
+
+// ProviderServerTLSConfig serves the provider's current certificate and verifies client
+// certificates against its current bundle; clientAuth is tls.RequireAndVerifyClientCert, or
+// tls.VerifyClientCertIfGiven for a server that also takes other credentials
+func ProviderServerTLSConfig(p SecretProvider, clientAuth tls.ClientAuthType) *tls.Config {
+    return &tls.Config{
+        MinVersion: tls.VersionTLS13,
+        // A fresh config per handshake picks up rotated credentials and keeps VerifiedChains
+        // populated for SPIFFEAuthenticator
+        GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+            cert, err := p.Certificate()
+            if err != nil {
+                return nil, err
+            }
+            bundle, err := p.Bundle()
+            if err != nil {
+                return nil, err
+            }
+            return &tls.Config{
+                MinVersion:   tls.VersionTLS13,
+                Certificates: []tls.Certificate{*cert},
+                ClientCAs:    bundle,
+                ClientAuth:   clientAuth,
+            }, nil
+        },
+    }
+}
+
+// verifyPeer checks the peer chain against the current bundle; SPIFFE identities are URIs,
+// not host names, so no name check applies
+func verifyPeer(p SecretProvider, cs tls.ConnectionState, usage x509.ExtKeyUsage) error {
+    if len(cs.PeerCertificates) == 0 {
+        return ErrUnauthenticated
+    }
+    roots, err := p.Bundle()
+    if err != nil {
+        return err
+    }
+    intermediates := x509.NewCertPool()
+    for _, c := range cs.PeerCertificates[1:] {
+        intermediates.AddCert(c)
+    }
+    _, err = cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+        Roots:         roots,
+        Intermediates: intermediates,
+        KeyUsages:     []x509.ExtKeyUsage{usage},
+    })
+    return err
+}
+
+// FileSVIDProvider serves an X.509 SVID written to disk by spire-agent or spiffe-helper,
+// reloading it when the files change
+type FileSVIDProvider struct {
+    certFile, keyFile, bundleFile string
+
+    mu        sync.Mutex
+    cert      *tls.Certificate
+    certMod   time.Time
+    bundle    *x509.CertPool
+    bundleMod time.Time
+}
+
+// NewFileSVIDProvider loads the SVID, its key and the trust bundle
+func NewFileSVIDProvider(certFile, keyFile, bundleFile string) (*FileSVIDProvider, error) {
+    p := &FileSVIDProvider{certFile: certFile, keyFile: keyFile, bundleFile: bundleFile}
+    if _, err := p.Certificate(); err != nil {
+        return nil, err
+    }
+    if _, err := p.Bundle(); err != nil {
+        return nil, err
+    }
+    return p, nil
+}
+
+// Certificate returns the current SVID, reloading it after rotation
+func (p *FileSVIDProvider) Certificate() (*tls.Certificate, error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    info, err := os.Stat(p.certFile)
+    if err != nil {
+        if p.cert != nil {
+            return p.cert, nil
+        }
+        return nil, err
+    }
+    if p.cert != nil && !info.ModTime().After(p.certMod) {
+        return p.cert, nil
+    }
+    cert, err := tls.LoadX509KeyPair(p.certFile, p.keyFile)
+    if err != nil {
+        // Key and certificate are written separately; keep serving the old pair mid-rotation
+        if p.cert != nil {
+            return p.cert, nil
+        }
+        return nil, err
+    }
+    p.cert, p.certMod = &cert, info.ModTime()
+    return p.cert, nil
+}
+
+// Bundle returns the current trust bundle, reloading it after rotation
+func (p *FileSVIDProvider) Bundle() (*x509.CertPool, error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    info, err := os.Stat(p.bundleFile)
+    if err != nil {
+        if p.bundle != nil {
+            return p.bundle, nil
+        }
+        return nil, err
+    }
+    if p.bundle != nil && !info.ModTime().After(p.bundleMod) {
+        return p.bundle, nil
+    }
+    data, err := os.ReadFile(p.bundleFile)
+    if err != nil {
+        return nil, err
+    }
+    pool := x509.NewCertPool()
+    if !pool.AppendCertsFromPEM(data) {
+        if p.bundle != nil {
+            return p.bundle, nil
+        }
+        return nil, ErrNoBundle
+    }
+    p.bundle, p.bundleMod = pool, info.ModTime()
+    return p.bundle, nil
+}
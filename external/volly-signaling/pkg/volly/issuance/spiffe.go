@@ -0,0 +1,114 @@
+package issuance
+
+import (
+    "crypto/x509"
+    "errors"
+    "net/http"
+    "net/url"
+    "strings"
+)
+
+// MethodSPIFFE marks principals authenticated by X.509 SVID
+const MethodSPIFFE = "spiffe"
+
+var ErrInvalidSPIFFEID = errors.New("certificate does not carry a valid SPIFFE ID")
+
+// SPIFFERule maps a SPIFFE ID to a tenant and issuance scopes; an ID ending in /* matches
+// every workload below that path
+type SPIFFERule struct {
+    ID     string   `json:"id"`
+    Tenant string   `json:"tenant"`
+    Scopes []string `json:"scopes"`
+}
+
+// SPIFFEAuthenticator accepts X.509 SVIDs from one trust domain, so mesh workloads can call
+// issuance with the identity SPIRE already gives them
+type SPIFFEAuthenticator struct {
+    trustDomain string
+    rules       []SPIFFERule
+}
+
+// NewSPIFFEAuthenticator creates the authenticator; the server must verify client certificates
+// against the trust domain's bundle, e.g. ServerTLSConfig with the bundle from a SecretProvider
+func NewSPIFFEAuthenticator(trustDomain string, rules []SPIFFERule) *SPIFFEAuthenticator {
+    return &SPIFFEAuthenticator{trustDomain: trustDomain, rules: rules}
+}
+
+// Authenticate maps the SVID's SPIFFE ID to a Principal; the most specific rule wins
+func (a *SPIFFEAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+    if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+        return nil, ErrUnauthenticated
+    }
+    id, err := SPIFFEID(r.TLS.VerifiedChains[0][0])
+    if err != nil {
+        return nil, err
+    }
+    if id.Host != a.trustDomain {
+        return nil, ErrUnauthenticated
+    }
+
+    name := id.String()
+    var best *SPIFFERule
+    for i := range a.rules {
+        rule := &a.rules[i]
+        if !matchSPIFFE(rule.ID, name) {
+            continue
+        }
+        if best == nil || len(rule.ID) > len(best.ID) {
+            best = rule
+        }
+    }
+    if best == nil {
+        return nil, ErrUnmappedCaller
+    }
+    return &Principal{Name: name, Tenant: best.Tenant, Scopes: best.Scopes, Method: MethodSPIFFE}, nil
+}
+
+// SPIFFEID extracts the ID from an X.509 SVID, which must carry exactly one spiffe URI SAN.
+// Its path segments must be non-empty, must not be . or .., and like the trust domain may only
+// use letters, digits, '.', '-' and '_', so no two spellings name one workload and a rule's
+// /* cannot be escaped
+func SPIFFEID(cert *x509.Certificate) (*url.URL, error) {
+    if len(cert.URIs) != 1 {
+        return nil, ErrInvalidSPIFFEID
+    }
+    id := cert.URIs[0]
+    if id.Scheme != "spiffe" || id.Opaque != "" || id.Host == "" || id.Port() != "" || id.User != nil ||
+        id.RawPath != "" || id.ForceQuery || id.RawQuery != "" || id.Fragment != "" || !spiffeChars(id.Host, false) {
+        return nil, ErrInvalidSPIFFEID
+    }
+    if id.Path == "" {
+        return id, nil
+    }
+    segments, ok := strings.CutPrefix(id.Path, "/")
+    if !ok {
+        return nil, ErrInvalidSPIFFEID
+    }
+    for _, segment := range strings.Split(segments, "/") {
+        if segment == "" || segment == "." || segment == ".." || !spiffeChars(segment, true) {
+            return nil, ErrInvalidSPIFFEID
+        }
+    }
+    return id, nil
+}
+
+// spiffeChars reports whether s only uses the characters SPIFFE allows: lowercase letters,
+// digits, '.', '-' and '_', and uppercase letters too in a path
+func spiffeChars(s string, path bool) bool {
+    for _, c := range s {
+        switch {
+        case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '.', c == '-', c == '_':
+        case path && c >= 'A' && c <= 'Z':
+        default:
+            return false
+        }
+    }
+    return true
+}
+
+func matchSPIFFE(pattern, id string) bool {
+    if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+        return strings.HasPrefix(id, prefix+"/")
+    }
+    return pattern == id
+}
@@ -0,0 +1,157 @@
+package keys
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/listing"
+    "github.com/volly-org/volly-signaling/pkg/volly/secure"
+)
+
+var (
+    ErrKeyNotFound = errors.New("no key registered for identity")
+    ErrKeyExpired  = errors.New("registered key has expired")
+)
+
+// KeyRecord is the current PQ key of an identity; PrivateKey is set only for server-held keys
+type KeyRecord struct {
+    Identity   string              `json:"identity"`
+    Algorithm  string              `json:"algorithm"`
+    PublicKey  []byte              `json:"publicKey"`
+    PrivateKey *secure.SecureBytes `json:"-"`
+    CreatedAt  time.Time           `json:"createdAt"`
+    ExpiresAt  time.Time           `json:"expiresAt,omitempty"`
+}
+
+// Expired reports whether the key is past its ExpiresAt; a zero ExpiresAt never expires
+func (r KeyRecord) Expired(now time.Time) bool {
+    return !r.ExpiresAt.IsZero() && !now.Before(r.ExpiresAt)
+}
+
+// Registry stores the PQ keys published for each identity
+type Registry interface {
+    Put(ctx context.Context, record KeyRecord) error
+    Get(ctx context.Context, identity string) (KeyRecord, error)
+    Delete(ctx context.Context, identity string) error
+}
+
+// Lister is implemented by registries that can page through keys for admin APIs.
+// Keys sort by identity (default), algorithm, createdAt or expiresAt
+type Lister interface {
+    List(ctx context.Context, q listing.Query) (records []KeyRecord, next string, err error)
+}
+
+// MemoryRegistry is an in-process Registry; replaced and deleted private keys are zeroized
+// once no reader holds them
+type MemoryRegistry struct {
+    mu      sync.RWMutex
+    records map[string]KeyRecord
+    clock   clock.Clock
+}
+
+// NewMemoryRegistry creates an empty registry
+func NewMemoryRegistry() *MemoryRegistry {
+    return &MemoryRegistry{
+        records: make(map[string]KeyRecord),
+        clock:   clock.System,
+    }
+}
+
+// SetClock sets the time source for creation stamps and expiry checks
+func (r *MemoryRegistry) SetClock(c clock.Clock) *MemoryRegistry {
+    r.clock = c
+    return r
+}
+
+// Put registers a key, taking ownership of its PrivateKey
+func (r *MemoryRegistry) Put(ctx context.Context, record KeyRecord) error {
+    if record.Identity == "" || len(record.PublicKey) == 0 {
+        return errors.New("identity and public key are required")
+    }
+    if record.CreatedAt.IsZero() {
+        record.CreatedAt = r.clock.Now()
+    }
+
+    // A replaced private key may still be held by readers; it is released once they drop it
+    r.mu.Lock()
+    r.records[record.Identity] = record
+    r.mu.Unlock()
+    return nil
+}
+
+// Get returns the key registered for an identity
+func (r *MemoryRegistry) Get(ctx context.Context, identity string) (KeyRecord, error) {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+
+    record, ok := r.records[identity]
+    if !ok {
+        return KeyRecord{}, ErrKeyNotFound
+    }
+    if record.Expired(r.clock.Now()) {
+        return KeyRecord{}, ErrKeyExpired
+    }
+    return record, nil
+}
+
+// Delete removes an identity's key; any private key is zeroized once no reader holds it
+func (r *MemoryRegistry) Delete(ctx context.Context, identity string) error {
+    r.mu.Lock()
+    _, ok := r.records[identity]
+    delete(r.records, identity)
+    r.mu.Unlock()
+
+    if !ok {
+        return ErrKeyNotFound
+    }
+    return nil
+}
+
+// List pages through registered keys, expired ones included, matching the identity and algorithm filters
+func (r *MemoryRegistry) List(ctx context.Context, q listing.Query) ([]KeyRecord, string, error) {
+    f := q.Filter
+    r.mu.RLock()
+    var records []KeyRecord
+    for _, record := range r.records {
+        if listing.Match(f.Identity, record.Identity) && listing.Match(f.Algorithm, record.Algorithm) {
+            records = append(records, record)
+        }
+    }
+    r.mu.RUnlock()
+
+    items := make([]listing.Item, len(records))
+    for i, record := range records {
+        items[i] = listing.Item{ID: record.Identity, Keys: map[string]string{
+            "identity":  record.Identity,
+            "algorithm": record.Algorithm,
+            "createdAt": listing.TimeKey(record.CreatedAt),
+            "expiresAt": listing.TimeKey(record.ExpiresAt),
+        }}
+    }
+    page, next, err := listing.Paginate(items, "identity", q)
+    if err != nil {
+        return nil, "", err
+    }
+    out := make([]KeyRecord, len(page))
+    for i, idx := range page {
+        out[i] = records[idx]
+    }
+    return out, next, nil
+}
+
+// Close zeroizes every held private key
+func (r *MemoryRegistry) Close() error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    for identity, record := range r.records {
+        if record.PrivateKey != nil {
+            record.PrivateKey.Close()
+        }
+        delete(r.records, identity)
+    }
+    return nil
+}
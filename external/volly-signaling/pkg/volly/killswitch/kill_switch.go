@@ -0,0 +1,275 @@
+package killswitch
+
+import (
+    "context"
+    "errors"
+    "log"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/events"
+    "github.com/volly-org/volly-signaling/pkg/volly/gateway"
+)
+
+// DefaultSyncInterval bounds how long an instance that missed a bus event keeps admitting
+const DefaultSyncInterval = 5 * time.Second
+
+// Kill switch event types
+const (
+    EventEngaged  = "kill_switch_engaged"
+    EventReleased = "kill_switch_released"
+)
+
+var (
+    ErrKilled     = errors.New("access is blocked by an emergency kill switch")
+    ErrEmptyScope = errors.New("kill switch scope must name a tenant or room")
+)
+
+// Scope is a tenant, a room, or a room within a tenant
+type Scope struct {
+    Tenant string `json:"tenant,omitempty"`
+    Room   string `json:"room,omitempty"`
+}
+
+// Entry is an engaged kill switch
+type Entry struct {
+    Scope     Scope     `json:"scope"`
+    Reason    string    `json:"reason,omitempty"`
+    EngagedAt time.Time `json:"engagedAt"`
+}
+
+// Store is the shared source of truth that instances resync from
+type Store interface {
+    Put(ctx context.Context, entry Entry) error
+    Delete(ctx context.Context, scope Scope) error
+    List(ctx context.Context) ([]Entry, error)
+}
+
+// Switch blocks verification and admission for engaged scopes. Changes are pushed over the
+// event bus and every instance also resyncs from the store, so a lost event delays enforcement
+// by at most the sync interval
+type Switch struct {
+    store       Store
+    bus         events.Bus
+    interval    time.Duration
+    clock       clock.Clock
+    unsubscribe func()
+
+    mu      sync.RWMutex
+    engaged map[Scope]Entry
+}
+
+// NewSwitch creates a switch; bus may be nil for a single instance
+func NewSwitch(store Store, bus events.Bus) *Switch {
+    s := &Switch{
+        store:    store,
+        bus:      bus,
+        interval: DefaultSyncInterval,
+        clock:    clock.System,
+        engaged:  make(map[Scope]Entry),
+    }
+    if bus != nil {
+        unEngaged := bus.Subscribe(EventEngaged, s.handleEvent)
+        unReleased := bus.Subscribe(EventReleased, s.handleEvent)
+        s.unsubscribe = func() {
+            unEngaged()
+            unReleased()
+        }
+    }
+    return s
+}
+
+// SetSyncInterval sets how often Run resyncs from the store
+func (s *Switch) SetSyncInterval(d time.Duration) *Switch {
+    s.interval = d
+    return s
+}
+
+// SetClock sets the time source for EngagedAt
+func (s *Switch) SetClock(c clock.Clock) *Switch {
+    s.clock = c
+    return s
+}
+
+// Engage blocks scope immediately on this instance and announces it to the others
+func (s *Switch) Engage(ctx context.Context, scope Scope, reason string) error {
+    if scope == (Scope{}) {
+        return ErrEmptyScope
+    }
+    entry := Entry{Scope: scope, Reason: reason, EngagedAt: s.clock.Now()}
+    if err := s.store.Put(ctx, entry); err != nil {
+        return err
+    }
+    s.mu.Lock()
+    s.engaged[scope] = entry
+    s.mu.Unlock()
+    return s.publish(ctx, EventEngaged, scope, reason)
+}
+
+// Release unblocks scope
+func (s *Switch) Release(ctx context.Context, scope Scope) error {
+    if err := s.store.Delete(ctx, scope); err != nil {
+        return err
+    }
+    s.mu.Lock()
+    delete(s.engaged, scope)
+    s.mu.Unlock()
+    return s.publish(ctx, EventReleased, scope, "")
+}
+
+// Engaged lists the scopes currently blocked on this instance
+func (s *Switch) Engaged() []Entry {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    out := make([]Entry, 0, len(s.engaged))
+    for _, e := range s.engaged {
+        out = append(out, e)
+    }
+    return out
+}
+
+// Check returns ErrKilled if the tenant, the room, or the room within the tenant is blocked
+func (s *Switch) Check(tenant, room string) error {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    if len(s.engaged) == 0 {
+        return nil
+    }
+    if _, ok := s.engaged[Scope{Tenant: tenant}]; ok && tenant != "" {
+        return ErrKilled
+    }
+    if _, ok := s.engaged[Scope{Room: room}]; ok && room != "" {
+        return ErrKilled
+    }
+    if _, ok := s.engaged[Scope{Tenant: tenant, Room: room}]; ok {
+        return ErrKilled
+    }
+    return nil
+}
+
+// CheckGrant is a verification check for auth.Verifier.SetGrantCheck
+func (s *Switch) CheckGrant(grant *auth.VollyVideoGrant) error {
+    return s.Check(grant.Tenant, grant.Room)
+}
+
+// Admit rejects connections to blocked tenants and rooms, also catching sessions whose token
+// was verified before the switch was engaged
+func (s *Switch) Admit(ctx context.Context, a *gateway.Admission) error {
+    tenant := ""
+    if a.Grant != nil {
+        tenant = a.Grant.Tenant
+    }
+    return s.Check(tenant, a.Room)
+}
+
+// Sync replaces local state with the store's
+func (s *Switch) Sync(ctx context.Context) error {
+    entries, err := s.store.List(ctx)
+    if err != nil {
+        return err
+    }
+    engaged := make(map[Scope]Entry, len(entries))
+    for _, e := range entries {
+        engaged[e.Scope] = e
+    }
+    s.mu.Lock()
+    s.engaged = engaged
+    s.mu.Unlock()
+    return nil
+}
+
+// Run resyncs until ctx is cancelled
+func (s *Switch) Run(ctx context.Context) {
+    if err := s.Sync(ctx); err != nil {
+        log.Printf("kill switch sync failed: %v", err)
+    }
+    ticker := time.NewTicker(s.interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            if err := s.Sync(ctx); err != nil {
+                log.Printf("kill switch sync failed: %v", err)
+            }
+        }
+    }
+}
+
+// Close detaches the switch from the event bus
+func (s *Switch) Close() {
+    if s.unsubscribe != nil {
+        s.unsubscribe()
+    }
+}
+
+func (s *Switch) publish(ctx context.Context, eventType string, scope Scope, reason string) error {
+    if s.bus == nil {
+        return nil
+    }
+    data := map[string]string{"tenant": scope.Tenant}
+    if reason != "" {
+        data["reason"] = reason
+    }
+    return s.bus.Publish(ctx, events.Event{Type: eventType, Room: scope.Room, Data: data})
+}
+
+func (s *Switch) handleEvent(ctx context.Context, event events.Event) {
+    scope := Scope{Tenant: event.Data["tenant"], Room: event.Room}
+    if scope == (Scope{}) {
+        return
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if event.Type == EventReleased {
+        delete(s.engaged, scope)
+        return
+    }
+    s.engaged[scope] = Entry{Scope: scope, Reason: event.Data["reason"], EngagedAt: time.Unix(event.Timestamp, 0)}
+}
+
+// MemoryStore is an in-process Store for single-instance deployments
+type MemoryStore struct {
+    mu      sync.Mutex
+    entries map[Scope]Entry
+}
+
+// NewMemoryStore creates an empty store
+func NewMemoryStore() *MemoryStore {
+    return &MemoryStore{entries: make(map[Scope]Entry)}
+}
+
+// Put engages a scope
+func (s *MemoryStore) Put(ctx context.Context, entry Entry) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.entries[entry.Scope] = entry
+    return nil
+}
+
+// Delete releases a scope
+func (s *MemoryStore) Delete(ctx context.Context, scope Scope) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    delete(s.entries, scope)
+    return nil
+}
+
+// List returns every engaged scope
+func (s *MemoryStore) List(ctx context.Context) ([]Entry, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    out := make([]Entry, 0, len(s.entries))
+    for _, e := range s.entries {
+        out = append(out, e)
+    }
+    return out, nil
+}
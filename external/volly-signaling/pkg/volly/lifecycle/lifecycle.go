@@ -0,0 +1,332 @@
+// Package lifecycle runs deployment logic at points in a room's life without patching the
+// gateway: before an empty room opens, before each participant joins and after each one
+// leaves. Hooks are Go callbacks registered in process or remote services called over
+// signed webhooks; each has a timeout and fails open or closed
+package lifecycle
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/gateway"
+)
+
+// Stage is the point in a room's life a hook runs at
+type Stage string
+
+const (
+    // StagePreCreate runs before the first participant joins an empty room and can refuse to
+    // open it
+    StagePreCreate Stage = "pre_create"
+    // StagePreJoin runs before every participant joins and can refuse them
+    StagePreJoin Stage = "pre_join"
+    // StagePostLeave runs after a participant's connection is gone; it only observes
+    StagePostLeave Stage = "post_leave"
+)
+
+// Lifecycle defaults
+const (
+    DefaultTimeout = 2 * time.Second
+    // DefaultStaleAfter treats connections not seen for this long as gone
+    DefaultStaleAfter = 10 * time.Minute
+    defaultInterval   = time.Minute
+)
+
+var (
+    ErrDenied       = errors.New("refused by a room hook")
+    ErrHookFailed   = errors.New("room hook failed")
+    ErrUnknownStage = errors.New("unknown room hook stage")
+)
+
+// Event is what a hook is told about
+type Event struct {
+    Stage        Stage             `json:"stage"`
+    Tenant       string            `json:"tenant,omitempty"`
+    Room         string            `json:"room"`
+    Identity     string            `json:"identity"`
+    Tags         map[string]string `json:"tags,omitempty"`
+    ConnectionID string            `json:"connectionId,omitempty"`
+    Time         time.Time         `json:"time"`
+}
+
+// Hook runs deployment logic at a stage. A pre-create or pre-join hook refuses with an
+// error wrapping ErrDenied; any other error is a failure its policy decides on
+type Hook interface {
+    Call(ctx context.Context, e Event) error
+}
+
+// HookFunc adapts a function to Hook
+type HookFunc func(ctx context.Context, e Event) error
+
+// Call calls f
+func (f HookFunc) Call(ctx context.Context, e Event) error {
+    return f(ctx, e)
+}
+
+// Options tune one registered hook
+type Options struct {
+    // Timeout bounds a call; DefaultTimeout when zero
+    Timeout time.Duration
+    // FailOpen admits when the hook errs or times out instead of refusing. It does not
+    // override a refusal
+    FailOpen bool
+}
+
+// DeniedError is a refusal by a named hook
+type DeniedError struct {
+    Stage  Stage
+    Hook   string
+    Reason string
+}
+
+func (e *DeniedError) Error() string {
+    if e.Reason == "" {
+        return fmt.Sprintf("%s hook %s refused", e.Stage, e.Hook)
+    }
+    return fmt.Sprintf("%s hook %s refused: %s", e.Stage, e.Hook, e.Reason)
+}
+
+func (e *DeniedError) Unwrap() error {
+    return ErrDenied
+}
+
+// Failure reports a hook that erred or timed out, and whether its policy let the
+// participant in anyway
+type Failure struct {
+    Stage    Stage
+    Hook     string
+    Err      error
+    FailOpen bool
+}
+
+type registered struct {
+    name string
+    hook Hook
+    opts Options
+}
+
+// Hooks runs the hooks registered for each stage in the order they were registered. As an
+// admission hook it runs pre-create when the room is empty and then pre-join; Joined and
+// Left run post-leave for connections it admitted, as does Run for those whose edge stopped
+// renewing them
+type Hooks struct {
+    counter    gateway.RoomCounter
+    clock      clock.Clock
+    staleAfter time.Duration
+    report     func(ctx context.Context, f Failure)
+
+    mu    sync.RWMutex
+    hooks map[Stage][]registered
+
+    connMu sync.Mutex
+    conns  map[string]*connection
+}
+
+type connection struct {
+    event Event
+    seen  time.Time
+}
+
+// New creates a set with no hooks
+func New() *Hooks {
+    return &Hooks{
+        clock:      clock.System,
+        staleAfter: DefaultStaleAfter,
+        hooks:      make(map[Stage][]registered),
+        conns:      make(map[string]*connection),
+    }
+}
+
+// SetCounter sets where room occupancy comes from; without one pre-create never runs
+func (h *Hooks) SetCounter(counter gateway.RoomCounter) *Hooks {
+    h.counter = counter
+    return h
+}
+
+// SetClock sets the time source for event times and staleness
+func (h *Hooks) SetClock(c clock.Clock) *Hooks {
+    h.clock = c
+    return h
+}
+
+// SetStaleAfter treats connections not seen for d as gone; keep it above the lease ttl
+func (h *Hooks) SetStaleAfter(d time.Duration) *Hooks {
+    h.staleAfter = d
+    return h
+}
+
+// SetReport sets the function told of every hook failure, including those failing open
+func (h *Hooks) SetReport(report func(ctx context.Context, f Failure)) *Hooks {
+    h.report = report
+    return h
+}
+
+// Register adds a hook at stage under name
+func (h *Hooks) Register(stage Stage, name string, hook Hook, opts Options) error {
+    switch stage {
+    case StagePreCreate, StagePreJoin, StagePostLeave:
+    default:
+        return ErrUnknownStage
+    }
+    if opts.Timeout <= 0 {
+        opts.Timeout = DefaultTimeout
+    }
+    h.mu.Lock()
+    h.hooks[stage] = append(h.hooks[stage], registered{name: name, hook: hook, opts: opts})
+    h.mu.Unlock()
+    return nil
+}
+
+// Empty reports whether no hook is registered
+func (h *Hooks) Empty() bool {
+    h.mu.RLock()
+    defer h.mu.RUnlock()
+    return len(h.hooks) == 0
+}
+
+// Dispatch calls e.Stage's hooks one after another and returns the first refusal, or the
+// first failure of a hook that fails closed
+func (h *Hooks) Dispatch(ctx context.Context, e Event) error {
+    h.mu.RLock()
+    hooks := h.hooks[e.Stage]
+    h.mu.RUnlock()
+    if e.Time.IsZero() {
+        e.Time = h.clock.Now()
+    }
+
+    for _, r := range hooks {
+        err := h.call(ctx, r, e)
+        if err == nil {
+            continue
+        }
+        var denied *DeniedError
+        if errors.As(err, &denied) {
+            return err
+        }
+        if errors.Is(err, ErrDenied) {
+            return &DeniedError{Stage: e.Stage, Hook: r.name}
+        }
+        if h.report != nil {
+            h.report(ctx, Failure{Stage: e.Stage, Hook: r.name, Err: err, FailOpen: r.opts.FailOpen})
+        }
+        if !r.opts.FailOpen {
+            return fmt.Errorf("%w: %s hook %s: %v", ErrHookFailed, e.Stage, r.name, err)
+        }
+    }
+    return nil
+}
+
+// call runs one hook under its timeout; a hook that overruns is abandoned, not waited for
+func (h *Hooks) call(ctx context.Context, r registered, e Event) error {
+    ctx, cancel := context.WithTimeout(ctx, r.opts.Timeout)
+    defer cancel()
+
+    done := make(chan error, 1)
+    go func() {
+        done <- r.hook.Call(ctx, e)
+    }()
+    select {
+    case err := <-done:
+        var denied *DeniedError
+        if errors.As(err, &denied) && denied.Hook == "" {
+            denied.Stage, denied.Hook = e.Stage, r.name
+        }
+        return err
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// Admit runs pre-create for the first participant of an empty room and pre-join for every
+// participant. It belongs after the hooks that refuse on their own, so deployment logic
+// only sees joins that would otherwise go ahead
+func (h *Hooks) Admit(ctx context.Context, a *gateway.Admission) error {
+    e := Event{Room: a.Room, Identity: a.Identity, Time: h.clock.Now()}
+    if a.Grant != nil {
+        e.Tenant, e.Tags = a.Grant.Tenant, a.Grant.Tags
+    }
+    if h.counter != nil && h.counter.Participants(a.Room, a.Identity) == 0 {
+        e.Stage = StagePreCreate
+        if err := h.Dispatch(ctx, e); err != nil {
+            return err
+        }
+    }
+    e.Stage = StagePreJoin
+    return h.Dispatch(ctx, e)
+}
+
+// Joined remembers an admitted connection by its lease id, for post-leave
+func (h *Hooks) Joined(id string, grant *auth.VollyVideoGrant) {
+    now := h.clock.Now()
+    h.connMu.Lock()
+    h.conns[id] = &connection{
+        event: Event{Tenant: grant.Tenant, Room: grant.Room, Identity: grant.Identity, Tags: grant.Tags, ConnectionID: id},
+        seen:  now,
+    }
+    h.connMu.Unlock()
+}
+
+// Seen marks a connection alive, e.g. when its lease is renewed
+func (h *Hooks) Seen(id string) {
+    now := h.clock.Now()
+    h.connMu.Lock()
+    if c, ok := h.conns[id]; ok {
+        c.seen = now
+    }
+    h.connMu.Unlock()
+}
+
+// Left runs post-leave for a connection once, in the background so the edge never waits on
+// it; connections Joined never saw are ignored
+func (h *Hooks) Left(ctx context.Context, id string) {
+    h.connMu.Lock()
+    c, ok := h.conns[id]
+    delete(h.conns, id)
+    h.connMu.Unlock()
+    if ok {
+        h.leave(ctx, c.event)
+    }
+}
+
+// Run runs post-leave for connections that went stale until ctx is cancelled
+func (h *Hooks) Run(ctx context.Context) {
+    ticker := time.NewTicker(defaultInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            h.expire(ctx, h.clock.Now())
+        }
+    }
+}
+
+func (h *Hooks) expire(ctx context.Context, now time.Time) {
+    var gone []Event
+    h.connMu.Lock()
+    for id, c := range h.conns {
+        if now.Sub(c.seen) > h.staleAfter {
+            delete(h.conns, id)
+            gone = append(gone, c.event)
+        }
+    }
+    h.connMu.Unlock()
+    for _, e := range gone {
+        h.leave(ctx, e)
+    }
+}
+
+// leave runs post-leave detached from the caller's cancellation; its result only matters
+// to the report
+func (h *Hooks) leave(ctx context.Context, e Event) {
+    e.Stage = StagePostLeave
+    e.Time = h.clock.Now()
+    go h.Dispatch(context.WithoutCancel(ctx), e)
+}
@@ -0,0 +1,75 @@
+package lifecycle
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// HeaderSignature carries the webhook body's HMAC-SHA256 under the shared secret, hex encoded
+const HeaderSignature = "Volly-Signature"
+
+// webhookTimeout backs up the hook's own timeout for clients that never set a deadline
+const webhookTimeout = 10 * time.Second
+
+// maxReason bounds the refusal reason read from a webhook's answer
+const maxReason = 256
+
+// Webhook runs a hook in a remote service. It POSTs the Event as JSON; 200 or 204 lets the
+// participant through, 403 refuses with the response body as the reason and anything else
+// is a failure
+type Webhook struct {
+    url    string
+    secret []byte
+    client *http.Client
+}
+
+// NewWebhook creates a hook backed by url; secret, when set, signs every request
+func NewWebhook(url string, secret []byte) *Webhook {
+    return &Webhook{url: url, secret: secret, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// SetHTTPClient replaces the client used to call the service
+func (w *Webhook) SetHTTPClient(client *http.Client) *Webhook {
+    w.client = client
+    return w
+}
+
+func (w *Webhook) Call(ctx context.Context, e Event) error {
+    body, err := json.Marshal(e)
+    if err != nil {
+        return err
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if len(w.secret) > 0 {
+        mac := hmac.New(sha256.New, w.secret)
+        mac.Write(body)
+        req.Header.Set(HeaderSignature, hex.EncodeToString(mac.Sum(nil)))
+    }
+
+    resp, err := w.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    switch resp.StatusCode {
+    case http.StatusOK, http.StatusNoContent:
+        return nil
+    case http.StatusForbidden:
+        reason, _ := io.ReadAll(io.LimitReader(resp.Body, maxReason))
+        return &DeniedError{Reason: strings.TrimSpace(string(reason))}
+    }
+    return fmt.Errorf("room hook service answered %s", resp.Status)
+}
@@ -0,0 +1,180 @@
+package listing
+
+import (
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Page size bounds
+const (
+    DefaultLimit = 100
+    MaxLimit     = 1000
+)
+
+var (
+    ErrInvalidCursor = errors.New("cursor is malformed or was issued for a different query")
+    ErrUnknownField  = errors.New("unknown sort or projection field")
+)
+
+// Filter narrows a list; empty fields match everything and not every list supports every field
+type Filter struct {
+    RoomPrefix string `json:"roomPrefix,omitempty"`
+    Tenant     string `json:"tenant,omitempty"`
+    Identity   string `json:"identity,omitempty"`
+    Algorithm  string `json:"algorithm,omitempty"`
+}
+
+// Query selects one page of a list
+type Query struct {
+    Filter     Filter   `json:"filter"`
+    SortBy     string   `json:"sortBy,omitempty"`
+    Descending bool     `json:"descending,omitempty"`
+    Limit      int      `json:"limit,omitempty"`
+    Cursor     string   `json:"cursor,omitempty"`
+    Fields     []string `json:"fields,omitempty"`
+}
+
+// Item is one row as seen by Paginate: its unique ID breaks ties so the order is total
+type Item struct {
+    ID   string
+    Keys map[string]string
+}
+
+// cursor resumes after the last item of a page
+type cursor struct {
+    SortBy     string `json:"s"`
+    Descending bool   `json:"d,omitempty"`
+    Key        string `json:"k"`
+    ID         string `json:"i"`
+}
+
+// Paginate orders items by q.SortBy (defaultSort when empty) then ID, and returns the indexes
+// of the requested page and the cursor for the next one, empty on the last page. Keys must
+// sort lexically; use TimeKey and IntKey for other values
+func Paginate(items []Item, defaultSort string, q Query) ([]int, string, error) {
+    sortBy := q.SortBy
+    if sortBy == "" {
+        sortBy = defaultSort
+    }
+    if len(items) > 0 {
+        if _, ok := items[0].Keys[sortBy]; !ok {
+            return nil, "", ErrUnknownField
+        }
+    }
+
+    order := make([]int, len(items))
+    for i := range order {
+        order[i] = i
+    }
+    less := func(a, b Item) bool {
+        ka, kb := a.Keys[sortBy], b.Keys[sortBy]
+        if ka != kb {
+            return (ka < kb) != q.Descending
+        }
+        return a.ID != b.ID && (a.ID < b.ID) != q.Descending
+    }
+    sort.Slice(order, func(i, j int) bool { return less(items[order[i]], items[order[j]]) })
+
+    start := 0
+    if q.Cursor != "" {
+        c, err := decodeCursor(q.Cursor)
+        if err != nil || c.SortBy != sortBy || c.Descending != q.Descending {
+            return nil, "", ErrInvalidCursor
+        }
+        after := Item{ID: c.ID, Keys: map[string]string{sortBy: c.Key}}
+        start = sort.Search(len(order), func(i int) bool { return less(after, items[order[i]]) })
+    }
+
+    limit := q.Limit
+    if limit <= 0 {
+        limit = DefaultLimit
+    }
+    if limit > MaxLimit {
+        limit = MaxLimit
+    }
+    end := start + limit
+    if end >= len(order) {
+        return order[start:], "", nil
+    }
+    last := items[order[end-1]]
+    next, err := encodeCursor(cursor{SortBy: sortBy, Descending: q.Descending, Key: last.Keys[sortBy], ID: last.ID})
+    if err != nil {
+        return nil, "", err
+    }
+    return order[start:end], next, nil
+}
+
+// MatchRoom reports whether room satisfies the filter's room prefix
+func (f Filter) MatchRoom(room string) bool {
+    return strings.HasPrefix(room, f.RoomPrefix)
+}
+
+// Match reports whether value equals want, treating an empty want as a wildcard
+func Match(want, value string) bool {
+    return want == "" || want == value
+}
+
+// TimeKey formats t so lexical order is chronological; the zero time sorts first
+func TimeKey(t time.Time) string {
+    if t.IsZero() {
+        return IntKey(0)
+    }
+    return IntKey(t.UnixNano())
+}
+
+// IntKey formats n so lexical order is numeric; negative values sort as zero
+func IntKey(n int64) string {
+    if n < 0 {
+        n = 0
+    }
+    s := strconv.FormatInt(n, 10)
+    return strings.Repeat("0", 20-len(s)) + s
+}
+
+// Project keeps only the requested top-level JSON fields of each item; no fields keeps everything
+func Project(items interface{}, fields []string) ([]map[string]json.RawMessage, error) {
+    data, err := json.Marshal(items)
+    if err != nil {
+        return nil, err
+    }
+    var rows []map[string]json.RawMessage
+    if err := json.Unmarshal(data, &rows); err != nil {
+        return nil, err
+    }
+    if len(fields) == 0 {
+        return rows, nil
+    }
+    for i, row := range rows {
+        projected := make(map[string]json.RawMessage, len(fields))
+        for _, f := range fields {
+            if v, ok := row[f]; ok {
+                projected[f] = v
+            }
+        }
+        rows[i] = projected
+    }
+    return rows, nil
+}
+
+func encodeCursor(c cursor) (string, error) {
+    data, err := json.Marshal(c)
+    if err != nil {
+        return "", err
+    }
+    return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(s string) (cursor, error) {
+    var c cursor
+    data, err := base64.RawURLEncoding.DecodeString(s)
+    if err != nil {
+        return c, err
+    }
+    err = json.Unmarshal(data, &c)
+    return c, err
+}
@@ -0,0 +1,219 @@
+// Package matrix lets users of a Matrix homeserver, e.g. from Element, join Volly rooms with
+// the account they already have. The client asks its homeserver for an OpenID token and
+// hands it over; the validator checks it with the homeserver's federation userinfo endpoint,
+// which answers with the Matrix user ID the token belongs to. Only configured homeservers
+// are asked, and each maps its users to a tenant and to Volly identities, so a Matrix user
+// can be the same identity they already have through SAML or the directory.
+//
+// The OpenID token proves who the user is, not that they are in a Matrix room; which Volly
+// rooms they reach is up to Rooms and the rooms' own policies
+package matrix
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+)
+
+// DefaultFederationPort is where a homeserver without delegation serves federation
+const DefaultFederationPort = "8448"
+
+// homeserverTimeout backs up the caller's deadline for the homeserver calls
+const homeserverTimeout = 10 * time.Second
+
+// maxResponse bounds what is read from a homeserver
+const maxResponse = 64 << 10
+
+var (
+    ErrMalformedUserID   = errors.New("malformed Matrix user ID")
+    ErrMalformedToken    = errors.New("malformed Matrix OpenID token")
+    ErrUnknownHomeserver = errors.New("Matrix homeserver is not bridged")
+    ErrTokenRejected     = errors.New("Matrix homeserver rejected the OpenID token")
+    ErrWrongHomeserver   = errors.New("Matrix user belongs to another homeserver")
+    ErrHomeserverFailed  = errors.New("Matrix homeserver could not be reached")
+    ErrUserNotBridged    = errors.New("Matrix user is not bridged")
+)
+
+// UserID is a Matrix user ID, @localpart:server
+type UserID struct {
+    Localpart string
+    Server    string
+}
+
+// ParseUserID splits a Matrix user ID; the server keeps any port
+func ParseUserID(s string) (UserID, error) {
+    if !strings.HasPrefix(s, "@") {
+        return UserID{}, fmt.Errorf("%w: %q", ErrMalformedUserID, s)
+    }
+    local, server, ok := strings.Cut(s[1:], ":")
+    if !ok || local == "" || server == "" || len(s) > 255 {
+        return UserID{}, fmt.Errorf("%w: %q", ErrMalformedUserID, s)
+    }
+    return UserID{Localpart: local, Server: server}, nil
+}
+
+func (u UserID) String() string {
+    return "@" + u.Localpart + ":" + u.Server
+}
+
+// OpenIDToken is what a homeserver returns from POST
+// /_matrix/client/v3/user/{userId}/openid/request_token
+type OpenIDToken struct {
+    AccessToken      string `json:"access_token"`
+    TokenType        string `json:"token_type"`
+    MatrixServerName string `json:"matrix_server_name"`
+    ExpiresIn        int    `json:"expires_in,omitempty"`
+}
+
+// Homeserver is a bridged homeserver and how its users map to Volly
+type Homeserver struct {
+    // ServerName is the homeserver's Matrix server name, the part after the colon
+    ServerName string `json:"serverName"`
+    Tenant     string `json:"tenant"`
+    // Identity is a template for the Volly identity, with {localpart}, {server} and {mxid}
+    // replaced; the Matrix user ID itself by default. Users overrides it per user
+    Identity string            `json:"identity,omitempty"`
+    Users    map[string]string `json:"users,omitempty"`
+    // OnlyListedUsers refuses users not in Users
+    OnlyListedUsers bool   `json:"onlyListedUsers,omitempty"`
+    Role            string `json:"role,omitempty"`
+    // FederationURL skips server discovery, e.g. https://matrix.example.com:8448
+    FederationURL string `json:"federationUrl,omitempty"`
+}
+
+// identity maps user to a Volly identity
+func (h *Homeserver) identity(user UserID) (string, error) {
+    if id, ok := h.Users[user.String()]; ok {
+        return id, nil
+    }
+    if h.OnlyListedUsers {
+        return "", fmt.Errorf("%w: %s", ErrUserNotBridged, user)
+    }
+    if h.Identity == "" {
+        return user.String(), nil
+    }
+    return strings.NewReplacer("{localpart}", user.Localpart, "{server}", user.Server, "{mxid}", user.String()).Replace(h.Identity), nil
+}
+
+// Login is a validated OpenID token, mapped
+type Login struct {
+    UserID   UserID
+    Tenant   string
+    Identity string
+    Role     string
+}
+
+// Validator checks OpenID tokens with the homeservers they name
+type Validator struct {
+    homeservers map[string]*Homeserver
+    client      *http.Client
+}
+
+// NewValidator creates a validator that bridges no homeserver yet
+func NewValidator() *Validator {
+    return &Validator{homeservers: make(map[string]*Homeserver), client: &http.Client{Timeout: homeserverTimeout}}
+}
+
+// SetHTTPClient replaces the client used to call homeservers
+func (v *Validator) SetHTTPClient(client *http.Client) *Validator {
+    v.client = client
+    return v
+}
+
+// AddHomeserver bridges h's users
+func (v *Validator) AddHomeserver(h Homeserver) error {
+    if h.ServerName == "" || h.Tenant == "" {
+        return errors.New("matrix: a homeserver needs a serverName and a tenant")
+    }
+    if h.FederationURL != "" {
+        if u, err := url.Parse(h.FederationURL); err != nil || u.Scheme != "https" || u.Host == "" {
+            return fmt.Errorf("matrix: %s: federationUrl must be an https URL", h.ServerName)
+        }
+    }
+    v.homeservers[strings.ToLower(h.ServerName)] = &h
+    return nil
+}
+
+// Validate asks the token's homeserver who it belongs to and maps the answer. The user must
+// belong to the homeserver that vouched for them
+func (v *Validator) Validate(ctx context.Context, tok OpenIDToken) (Login, error) {
+    if tok.AccessToken == "" || tok.MatrixServerName == "" || (tok.TokenType != "" && tok.TokenType != "Bearer") {
+        return Login{}, ErrMalformedToken
+    }
+    h, ok := v.homeservers[strings.ToLower(tok.MatrixServerName)]
+    if !ok {
+        return Login{}, fmt.Errorf("%w: %q", ErrUnknownHomeserver, tok.MatrixServerName)
+    }
+    base := v.federationURL(ctx, h)
+    var info struct {
+        Sub string `json:"sub"`
+    }
+    status, err := v.getJSON(ctx, base+"/_matrix/federation/v1/openid/userinfo?access_token="+url.QueryEscape(tok.AccessToken), &info)
+    switch {
+    case err != nil:
+        return Login{}, err
+    case status == http.StatusUnauthorized || status == http.StatusForbidden:
+        return Login{}, ErrTokenRejected
+    case status != http.StatusOK:
+        return Login{}, fmt.Errorf("%w: userinfo answered %d", ErrHomeserverFailed, status)
+    }
+    user, err := ParseUserID(info.Sub)
+    if err != nil {
+        return Login{}, err
+    }
+    if !strings.EqualFold(user.Server, h.ServerName) {
+        return Login{}, fmt.Errorf("%w: %s vouched for %s", ErrWrongHomeserver, h.ServerName, user)
+    }
+    identity, err := h.identity(user)
+    if err != nil {
+        return Login{}, err
+    }
+    return Login{UserID: user, Tenant: h.Tenant, Identity: identity, Role: h.Role}, nil
+}
+
+// federationURL finds where h serves federation: its configured URL, the delegation in its
+// /.well-known/matrix/server, or port 8448 on the server name
+func (v *Validator) federationURL(ctx context.Context, h *Homeserver) string {
+    if h.FederationURL != "" {
+        return strings.TrimSuffix(h.FederationURL, "/")
+    }
+    var wk struct {
+        Server string `json:"m.server"`
+    }
+    status, err := v.getJSON(ctx, "https://"+h.ServerName+"/.well-known/matrix/server", &wk)
+    host := h.ServerName
+    if err == nil && status == http.StatusOK && wk.Server != "" {
+        host = wk.Server
+    }
+    if _, _, err := net.SplitHostPort(host); err != nil {
+        host = net.JoinHostPort(strings.Trim(host, "[]"), DefaultFederationPort)
+    }
+    return "https://" + host
+}
+
+// getJSON GETs u and decodes a 200 answer into v, returning the status
+func (v *Validator) getJSON(ctx context.Context, u string, out interface{}) (int, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+    if err != nil {
+        return 0, err
+    }
+    resp, err := v.client.Do(req)
+    if err != nil {
+        return 0, fmt.Errorf("%w: %v", ErrHomeserverFailed, err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return resp.StatusCode, nil
+    }
+    if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponse)).Decode(out); err != nil {
+        return 0, fmt.Errorf("%w: %v", ErrHomeserverFailed, err)
+    }
+    return resp.StatusCode, nil
+}
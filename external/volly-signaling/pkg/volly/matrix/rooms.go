@@ -0,0 +1,31 @@
+package matrix
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+)
+
+var ErrRoomNotBridged = errors.New("Matrix room is not bridged to a Volly room")
+
+// Rooms maps Matrix rooms, by room ID (!opaque:server) or alias (#name:server), to Volly
+// rooms. Rooms not listed map to Prefix followed by their ID or alias when Prefix is set,
+// and are refused otherwise
+type Rooms struct {
+    Aliases map[string]string `json:"aliases,omitempty"`
+    Prefix  string            `json:"prefix,omitempty"`
+}
+
+// Resolve returns the Volly room for a Matrix room ID or alias
+func (r *Rooms) Resolve(room string) (string, error) {
+    if len(room) < 4 || (room[0] != '!' && room[0] != '#') || !strings.Contains(room[1:], ":") {
+        return "", fmt.Errorf("%w: %q", ErrRoomNotBridged, room)
+    }
+    if volly, ok := r.Aliases[room]; ok {
+        return volly, nil
+    }
+    if r.Prefix == "" {
+        return "", fmt.Errorf("%w: %q", ErrRoomNotBridged, room)
+    }
+    return r.Prefix + room, nil
+}
@@ -0,0 +1,391 @@
+// Package metering meters what tenants are billed for: tokens issued, participant-minutes and
+// egress sessions. Usage is counted per tenant, cost-attribution tags and UTC day, and
+// reports for a day or a calendar month are signed with the server key so a customer can
+// check an invoice against a report that cannot be altered without the signature failing
+package metering
+
+import (
+    "context"
+    "encoding/base64"
+    "encoding/csv"
+    "errors"
+    "fmt"
+    "io"
+    "sort"
+    "strconv"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+    "github.com/volly-org/volly-signaling/pkg/volly/tagset"
+)
+
+// Periods a report covers
+const (
+    Day   = "day"
+    Month = "month"
+)
+
+// DefaultRetention is how long daily counts are kept, a little over a year of invoices
+const DefaultRetention = 400 * 24 * time.Hour
+
+// maxReports bounds the periods one call reports on
+const maxReports = 1000
+
+// reportContext domain-separates report signatures
+const reportContext = "volly-usage-report-v1"
+
+var (
+    ErrUnknownPeriod    = errors.New("period must be day or month")
+    ErrRangeTooLarge    = errors.New("usage range covers too many periods")
+    ErrInvalidSignature = errors.New("invalid usage report signature")
+)
+
+// Report is a tenant's usage over one period, of the usage carrying Tags when they are set.
+// Final is set once nothing can still change
+// it: the period has ended and every session open in it has either ended or been seen since
+type Report struct {
+    Tenant             string            `json:"tenant"`
+    Tags               map[string]string `json:"tags,omitempty"`
+    Period             string            `json:"period"`
+    Start              time.Time         `json:"start"`
+    End                time.Time         `json:"end"`
+    TokensIssued       int64             `json:"tokensIssued"`
+    ParticipantMinutes int64             `json:"participantMinutes"`
+    EgressSessions     int64             `json:"egressSessions"`
+    Final              bool              `json:"final"`
+    GeneratedAt        time.Time         `json:"generatedAt"`
+    Algorithm          string            `json:"algorithm"`
+    Signature          []byte            `json:"signature"`
+}
+
+// Payload is the message a report's signature covers. It is built from the fields alone, so
+// a report exported as CSV verifies the same as one exported as JSON
+func (r *Report) Payload() []byte {
+    return []byte(fmt.Sprintf("%s\ntenant=%q\ntags=%q\nperiod=%s\nstart=%s\nend=%s\ntokensIssued=%d\nparticipantMinutes=%d\negressSessions=%d\nfinal=%t\ngeneratedAt=%s\nalgorithm=%s\n",
+        reportContext, r.Tenant, tagset.Encode(r.Tags), r.Period, r.Start.UTC().Format(time.RFC3339), r.End.UTC().Format(time.RFC3339),
+        r.TokensIssued, r.ParticipantMinutes, r.EgressSessions, r.Final, r.GeneratedAt.UTC().Format(time.RFC3339), r.Algorithm))
+}
+
+// Verify checks r against the server's public key
+func Verify(r *Report, publicKey []byte) error {
+    if err := crypto.VerifySignature(r.Algorithm, publicKey, r.Payload(), r.Signature); err != nil {
+        return ErrInvalidSignature
+    }
+    return nil
+}
+
+// WriteCSV writes reports with a header row; the signature column is base64
+func WriteCSV(w io.Writer, reports []Report) error {
+    cw := csv.NewWriter(w)
+    cw.Write([]string{"tenant", "tags", "period", "start", "end", "tokens_issued", "participant_minutes", "egress_sessions", "final", "generated_at", "algorithm", "signature"})
+    for _, r := range reports {
+        cw.Write([]string{
+            r.Tenant, tagset.Encode(r.Tags), r.Period, r.Start.UTC().Format(time.RFC3339), r.End.UTC().Format(time.RFC3339),
+            strconv.FormatInt(r.TokensIssued, 10), strconv.FormatInt(r.ParticipantMinutes, 10), strconv.FormatInt(r.EgressSessions, 10),
+            strconv.FormatBool(r.Final), r.GeneratedAt.UTC().Format(time.RFC3339), r.Algorithm, base64.StdEncoding.EncodeToString(r.Signature),
+        })
+    }
+    cw.Flush()
+    return cw.Error()
+}
+
+// dayKey is a tenant's usage under one set of tags, in Encode's form, on one day
+type dayKey struct {
+    tenant string
+    tags   string
+    day    time.Time
+}
+
+type counts struct {
+    tags   map[string]string
+    tokens int64
+    egress int64
+    // participant is the summed duration of ended sessions
+    participant time.Duration
+}
+
+type session struct {
+    tenant   string
+    tags     map[string]string
+    start    time.Time
+    lastSeen time.Time
+}
+
+// Meter counts usage in memory; every process meters what it served, so in a split
+// deployment tokend's reports carry tokens and the gateway's participant-minutes. Safe for
+// concurrent use
+type Meter struct {
+    signer    crypto.Signer
+    clock     clock.Clock
+    retention time.Duration
+    idle      time.Duration
+
+    mu       sync.Mutex
+    days     map[dayKey]*counts
+    sessions map[string]*session
+}
+
+// NewMeter creates a meter whose reports signer signs
+func NewMeter(signer crypto.Signer) *Meter {
+    return &Meter{
+        signer:    signer,
+        clock:     clock.System,
+        retention: DefaultRetention,
+        days:      make(map[dayKey]*counts),
+        sessions:  make(map[string]*session),
+    }
+}
+
+// SetRetention sets how long daily counts are kept
+func (m *Meter) SetRetention(d time.Duration) *Meter {
+    m.retention = d
+    return m
+}
+
+// SetIdleTimeout ends sessions not seen for d at the time they were last seen, so a
+// connection that vanished without a release is billed until its last renewal. Zero, the
+// default, keeps sessions open until they end
+func (m *Meter) SetIdleTimeout(d time.Duration) *Meter {
+    m.idle = d
+    return m
+}
+
+// SetClock sets the time source for usage and reports
+func (m *Meter) SetClock(c clock.Clock) *Meter {
+    m.clock = c
+    return m
+}
+
+// PublicKey is the key reports verify against, for handing to customers
+func (m *Meter) PublicKey() (algorithm string, key []byte) {
+    return m.signer.Algorithm(), m.signer.PublicKey()
+}
+
+// TokenIssued counts a token with tags issued to tenant
+func (m *Meter) TokenIssued(tenant string, tags map[string]string) {
+    m.mu.Lock()
+    m.day(tenant, tags, m.clock.Now()).tokens++
+    m.mu.Unlock()
+}
+
+// EgressStarted counts an egress session, such as a recording, in tenant
+func (m *Meter) EgressStarted(tenant string, tags map[string]string) {
+    m.mu.Lock()
+    m.day(tenant, tags, m.clock.Now()).egress++
+    m.mu.Unlock()
+}
+
+// SessionStarted starts billing participant time to tenant and tags under id
+func (m *Meter) SessionStarted(tenant string, tags map[string]string, id string) {
+    now := m.clock.Now()
+    m.mu.Lock()
+    m.sessions[id] = &session{tenant: tenant, tags: tags, start: now, lastSeen: now}
+    m.mu.Unlock()
+}
+
+// SessionSeen records that session id is still connected, e.g. on a lease renewal
+func (m *Meter) SessionSeen(id string) {
+    now := m.clock.Now()
+    m.mu.Lock()
+    if s, ok := m.sessions[id]; ok {
+        s.lastSeen = now
+    }
+    m.mu.Unlock()
+}
+
+// SessionEnded stops billing session id; unknown ids are ignored
+func (m *Meter) SessionEnded(id string) {
+    now := m.clock.Now()
+    m.mu.Lock()
+    if s, ok := m.sessions[id]; ok {
+        delete(m.sessions, id)
+        m.accrue(s, now)
+    }
+    m.mu.Unlock()
+}
+
+// day returns tenant's counts under tags for the UTC day of t; m.mu must be held
+func (m *Meter) day(tenant string, tags map[string]string, t time.Time) *counts {
+    k := dayKey{tenant: tenant, tags: tagset.Encode(tags), day: startOfDay(t)}
+    c, ok := m.days[k]
+    if !ok {
+        c = &counts{tags: tags}
+        m.days[k] = c
+    }
+    return c
+}
+
+// accrue splits s's time up to end across the days it spans; m.mu must be held
+func (m *Meter) accrue(s *session, end time.Time) {
+    for t := s.start; t.Before(end); {
+        next := startOfDay(t).AddDate(0, 0, 1)
+        if next.After(end) {
+            next = end
+        }
+        m.day(s.tenant, s.tags, t).participant += next.Sub(t)
+        t = next
+    }
+}
+
+// Reports returns a signed report for every period of the given kind starting in
+// [from, to), counting only usage whose tags carry every pair in filter. With a tenant it
+// reports on that tenant even where it used nothing; without one, on every tenant with
+// matching usage in the range
+func (m *Meter) Reports(tenant, period string, from, to time.Time, filter map[string]string) ([]Report, error) {
+    if period != Day && period != Month {
+        return nil, ErrUnknownPeriod
+    }
+    var starts []time.Time
+    for t := periodStart(period, from); t.Before(to); t = periodEnd(period, t) {
+        if len(starts) == maxReports {
+            return nil, ErrRangeTooLarge
+        }
+        if !t.Before(from) {
+            starts = append(starts, t)
+        }
+    }
+
+    now := m.clock.Now()
+    m.mu.Lock()
+    tenants := map[string]bool{}
+    if tenant != "" {
+        tenants[tenant] = true
+    } else if len(starts) > 0 {
+        last := periodEnd(period, starts[len(starts)-1])
+        for k, c := range m.days {
+            if !k.day.Before(starts[0]) && k.day.Before(last) && tagset.Match(c.tags, filter) {
+                tenants[k.tenant] = true
+            }
+        }
+        for _, s := range m.sessions {
+            if s.start.Before(last) && tagset.Match(s.tags, filter) {
+                tenants[s.tenant] = true
+            }
+        }
+    }
+    names := make([]string, 0, len(tenants))
+    for t := range tenants {
+        names = append(names, t)
+    }
+    sort.Strings(names)
+
+    reports := make([]Report, 0, len(names)*len(starts))
+    for _, name := range names {
+        for _, start := range starts {
+            reports = append(reports, m.report(name, filter, period, start, periodEnd(period, start), now))
+        }
+    }
+    m.mu.Unlock()
+
+    for i := range reports {
+        sig, err := m.signer.Sign(reports[i].Payload())
+        if err != nil {
+            return nil, err
+        }
+        reports[i].Signature = sig
+    }
+    return reports, nil
+}
+
+// report totals tenant's usage matching filter in [start, end); m.mu must be held
+func (m *Meter) report(tenant string, filter map[string]string, period string, start, end, now time.Time) Report {
+    final := !now.Before(end)
+    var participant time.Duration
+    r := Report{Tenant: tenant, Tags: filter, Period: period, Start: start, End: end, Algorithm: m.signer.Algorithm()}
+    for k, c := range m.days {
+        if k.tenant == tenant && !k.day.Before(start) && k.day.Before(end) && tagset.Match(c.tags, filter) {
+            r.TokensIssued += c.tokens
+            r.EgressSessions += c.egress
+            participant += c.participant
+        }
+    }
+    // Open sessions count up to now. One last seen before end may still be ended by the
+    // idle timeout at that earlier time, so until then the report is not final
+    for _, s := range m.sessions {
+        if s.tenant != tenant || !tagset.Match(s.tags, filter) {
+            continue
+        }
+        if m.idle > 0 && s.start.Before(end) && s.lastSeen.Before(end) {
+            final = false
+        }
+        from, until := maxTime(s.start, start), minTime(now, end)
+        if from.Before(until) {
+            participant += until.Sub(from)
+        }
+    }
+    // Participant time is totalled over the period, then rounded up to the minute
+    r.ParticipantMinutes = int64((participant + time.Minute - 1) / time.Minute)
+    r.Final = final
+    r.GeneratedAt = now.UTC().Truncate(time.Second)
+    return r
+}
+
+// Run ends idle sessions and drops counts past retention until ctx is cancelled
+func (m *Meter) Run(ctx context.Context) {
+    ticker := time.NewTicker(time.Minute)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            m.sweep()
+        }
+    }
+}
+
+func (m *Meter) sweep() {
+    now := m.clock.Now()
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if m.idle > 0 {
+        for id, s := range m.sessions {
+            if now.Sub(s.lastSeen) >= m.idle {
+                delete(m.sessions, id)
+                m.accrue(s, s.lastSeen)
+            }
+        }
+    }
+    cutoff := startOfDay(now.Add(-m.retention))
+    for k := range m.days {
+        if k.day.Before(cutoff) {
+            delete(m.days, k)
+        }
+    }
+}
+
+func startOfDay(t time.Time) time.Time {
+    t = t.UTC()
+    return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func periodStart(period string, t time.Time) time.Time {
+    t = startOfDay(t)
+    if period == Month {
+        return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+    }
+    return t
+}
+
+func periodEnd(period string, start time.Time) time.Time {
+    if period == Month {
+        return start.AddDate(0, 1, 0)
+    }
+    return start.AddDate(0, 0, 1)
+}
+
+func minTime(a, b time.Time) time.Time {
+    if a.Before(b) {
+        return a
+    }
+    return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+    if a.After(b) {
+        return a
+    }
+    return b
+}
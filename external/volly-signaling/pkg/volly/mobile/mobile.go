@@ -0,0 +1,270 @@
+// Package mobile is the gomobile binding surface of the Go SDK, so Android and iOS apps run
+// the same handshake, resumption and key code as Go clients. gomobile can only bind strings,
+// bools, integers, byte slices, errors and pointers to exported structs, so this package
+// flattens the client and auth APIs: lists are comma separated, times are Unix seconds, and
+// events and token requests are polled instead of delivered on channels or callbacks.
+//
+// Build with: gomobile bind -target=android,ios ./pkg/volly/mobile
+package mobile
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/client"
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+    "github.com/volly-org/volly-signaling/pkg/volly/watch"
+    "github.com/volly-org/volly-signaling/pkg/volly/websocket"
+)
+
+var (
+    ErrNotStarted     = errors.New("client has not been started")
+    ErrAlreadyStarted = errors.New("client has already been started")
+    ErrNoToken        = errors.New("no unexpired token has been set")
+)
+
+// Event is a room event
+type Event struct {
+    Type      string
+    Room      string
+    Identity  string
+    Timestamp int64
+    // Epoch is the new media key epoch, for key rotation events
+    Epoch int64
+    // Quality is the connection quality a participant dropped to
+    Quality int
+    // Changes is a comma separated list of the grant fields that changed
+    Changes string
+}
+
+// Client watches one room's events through the gateway
+type Client struct {
+    sdk *client.Client
+
+    mu        sync.Mutex
+    token     string
+    expiresAt time.Time
+    cbor      bool
+    started   bool
+    done      chan struct{}
+    err       error
+}
+
+// NewClient watches room through the gateway at gatewayURL, connecting with token, which
+// expires at expiresAt in Unix seconds. Call SetToken with the next token whenever
+// NeedsToken reports true
+func NewClient(gatewayURL, room, token string, expiresAt int64) *Client {
+    c := &Client{token: token, expiresAt: time.Unix(expiresAt, 0)}
+    c.sdk = client.New(gatewayURL, room, client.TokenFunc(c.currentToken))
+    return c
+}
+
+// SetToken replaces the token used for the next connection and the next refresh
+func (c *Client) SetToken(token string, expiresAt int64) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.token, c.expiresAt = token, time.Unix(expiresAt, 0)
+}
+
+// NeedsToken reports whether the current token expires within refreshBefore seconds
+func (c *Client) NeedsToken(refreshBefore int64) bool {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return time.Until(c.expiresAt) <= time.Duration(refreshBefore)*time.Second
+}
+
+// SetCBOR sends and receives binary CBOR frames instead of JSON text
+func (c *Client) SetCBOR(enabled bool) *Client {
+    c.mu.Lock()
+    c.cbor = enabled
+    c.mu.Unlock()
+    return c
+}
+
+// SetEventTypes limits the events delivered to a comma separated list of types
+func (c *Client) SetEventTypes(types string) *Client {
+    var list []watch.EventType
+    for _, t := range split(types) {
+        list = append(list, watch.EventType(t))
+    }
+    c.sdk.SetTypes(list...)
+    return c
+}
+
+// SetServerKey pins the gateway's signing key; see client.Client.SetServerKey
+func (c *Client) SetServerKey(algorithm string, publicKey []byte) *Client {
+    c.sdk.SetServerKey(algorithm, publicKey)
+    return c
+}
+
+// SetBackoff bounds the wait between reconnects, in milliseconds
+func (c *Client) SetBackoff(minMillis, maxMillis int64) *Client {
+    c.sdk.SetBackoff(time.Duration(minMillis)*time.Millisecond, time.Duration(maxMillis)*time.Millisecond)
+    return c
+}
+
+// SetRefreshBefore is how many seconds before expiry the client sends a newer token
+func (c *Client) SetRefreshBefore(seconds int64) *Client {
+    c.sdk.SetRefreshBefore(time.Duration(seconds) * time.Second)
+    return c
+}
+
+// Start connects in the background and keeps reconnecting until Stop
+func (c *Client) Start() error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.started {
+        return ErrAlreadyStarted
+    }
+    c.started = true
+    c.done = make(chan struct{})
+    if c.cbor {
+        c.sdk.SetDialer(websocket.NewDialer().SetProtocols(websocket.ProtocolCBOR))
+    }
+    go func() {
+        err := c.sdk.Run(context.Background())
+        c.mu.Lock()
+        c.err = err
+        c.mu.Unlock()
+        close(c.done)
+    }()
+    return nil
+}
+
+// NextEvent waits up to timeoutMillis for the next event. It returns nil and no error on
+// timeout, and the reason the client stopped once it has
+func (c *Client) NextEvent(timeoutMillis int64) (*Event, error) {
+    c.mu.Lock()
+    started := c.started
+    c.mu.Unlock()
+    if !started {
+        return nil, ErrNotStarted
+    }
+
+    t := time.NewTimer(time.Duration(timeoutMillis) * time.Millisecond)
+    defer t.Stop()
+    select {
+    case event, ok := <-c.sdk.Events():
+        if !ok {
+            <-c.done
+            return nil, c.Err()
+        }
+        return flatten(event), nil
+    case <-t.C:
+        return nil, nil
+    }
+}
+
+// Stop closes the connection and waits for the client to stop; it cannot be started again
+func (c *Client) Stop() {
+    c.sdk.Close()
+    c.mu.Lock()
+    done := c.done
+    c.mu.Unlock()
+    if done != nil {
+        <-done
+    }
+}
+
+// Err is why the client stopped, or nil while it runs
+func (c *Client) Err() error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if errors.Is(c.err, client.ErrClosed) {
+        return nil
+    }
+    return c.err
+}
+
+func (c *Client) currentToken(ctx context.Context) (string, time.Time, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.token == "" || !time.Now().Before(c.expiresAt) {
+        return "", time.Time{}, ErrNoToken
+    }
+    return c.token, c.expiresAt, nil
+}
+
+func flatten(e *watch.RoomEvent) *Event {
+    changes := make([]string, len(e.Changes))
+    for i, ch := range e.Changes {
+        changes[i] = ch.Field
+    }
+    return &Event{
+        Type:      string(e.Type),
+        Room:      e.Room,
+        Identity:  e.Identity,
+        Timestamp: e.Timestamp,
+        Epoch:     int64(e.Epoch),
+        Quality:   int(e.Quality),
+        Changes:   strings.Join(changes, ","),
+    }
+}
+
+// KeyPair is an ML-KEM-768 key pair
+type KeyPair struct {
+    PublicKey  []byte
+    PrivateKey []byte
+}
+
+// GenerateKeyPair creates the ML-KEM-768 key pair a token's pqPublicKey claim carries
+func GenerateKeyPair() (*KeyPair, error) {
+    kp, err := crypto.GenerateMLKEM768KeyPair()
+    if err != nil {
+        return nil, err
+    }
+    return &KeyPair{PublicKey: kp.PublicKey, PrivateKey: kp.PrivateKey}, nil
+}
+
+// Decapsulate recovers the shared secret a peer encapsulated to the pair's public key
+func Decapsulate(privateKey, ciphertext []byte) ([]byte, error) {
+    return crypto.Decapsulate(privateKey, ciphertext)
+}
+
+// KeyThumbprint is the base64url SHA-256 digest servers use to name a public key
+func KeyThumbprint(publicKey []byte) string {
+    return auth.KeyThumbprint(publicKey)
+}
+
+// VerifySignature checks a server or peer signature, returning an error when it does not verify
+func VerifySignature(algorithm string, publicKey, message, signature []byte) error {
+    return crypto.VerifySignature(algorithm, publicKey, message, signature)
+}
+
+// AttenuateToken derives a narrower token from parent on the device, for handing to a
+// companion app or embedded viewer. room and ttlSeconds are skipped when empty or zero
+func AttenuateToken(parent, room string, ttlSeconds int64, subscribeOnly, noPublishData bool) (string, error) {
+    var restrictions []auth.Restriction
+    if room != "" {
+        restrictions = append(restrictions, auth.WithRoom(room))
+    }
+    if ttlSeconds > 0 {
+        restrictions = append(restrictions, auth.WithTTL(time.Duration(ttlSeconds)*time.Second))
+    }
+    if subscribeOnly {
+        restrictions = append(restrictions, auth.SubscribeOnly())
+    }
+    if noPublishData {
+        restrictions = append(restrictions, auth.WithoutPublishData())
+    }
+    return auth.Attenuate(parent, restrictions...)
+}
+
+// IsViewerToken reports whether token is a subscribe-only viewer token
+func IsViewerToken(token string) bool {
+    return auth.IsViewerToken(token)
+}
+
+func split(list string) []string {
+    var out []string
+    for _, s := range strings.Split(list, ",") {
+        if s = strings.TrimSpace(s); s != "" {
+            out = append(out, s)
+        }
+    }
+    return out
+}
@@ -0,0 +1,148 @@
+package netpolicy
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/netip"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/gateway"
+)
+
+var ErrAddressBlocked = errors.New("client address is blocked")
+
+// Block is one blocked address
+type Block struct {
+    IP     netip.Addr `json:"ip"`
+    Until  time.Time  `json:"until"`
+    Reason string     `json:"reason,omitempty"`
+}
+
+// Blocklist turns away single addresses until their block lapses, e.g. one caught presenting
+// a token it should never have had. Unlike a Policy it applies to every tenant
+type Blocklist struct {
+    clock clock.Clock
+
+    mu      sync.RWMutex
+    blocked map[netip.Addr]Block
+    refused int64
+}
+
+// NewBlocklist creates an empty blocklist
+func NewBlocklist() *Blocklist {
+    return &Blocklist{clock: clock.System, blocked: make(map[netip.Addr]Block)}
+}
+
+// SetClock sets the time source for block expiry
+func (b *Blocklist) SetClock(c clock.Clock) *Blocklist {
+    b.clock = c
+    return b
+}
+
+// Block blocks ip for d, extending a shorter block already in place
+func (b *Blocklist) Block(ip netip.Addr, d time.Duration, reason string) {
+    if !ip.IsValid() {
+        return
+    }
+    ip = ip.Unmap()
+    now := b.clock.Now()
+    until := now.Add(d)
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    for addr, block := range b.blocked {
+        if !now.Before(block.Until) {
+            delete(b.blocked, addr)
+        }
+    }
+    if block, ok := b.blocked[ip]; ok && block.Until.After(until) {
+        return
+    }
+    b.blocked[ip] = Block{IP: ip, Until: until, Reason: reason}
+}
+
+// Unblock lifts the block on ip, reporting whether there was one
+func (b *Blocklist) Unblock(ip netip.Addr) bool {
+    ip = ip.Unmap()
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    block, ok := b.blocked[ip]
+    delete(b.blocked, ip)
+    return ok && b.clock.Now().Before(block.Until)
+}
+
+// Blocked reports whether ip is blocked
+func (b *Blocklist) Blocked(ip netip.Addr) bool {
+    if !ip.IsValid() {
+        return false
+    }
+    b.mu.RLock()
+    defer b.mu.RUnlock()
+    block, ok := b.blocked[ip.Unmap()]
+    return ok && b.clock.Now().Before(block.Until)
+}
+
+// List returns the blocks in place, soonest to lapse first
+func (b *Blocklist) List() []Block {
+    now := b.clock.Now()
+    b.mu.RLock()
+    out := make([]Block, 0, len(b.blocked))
+    for _, block := range b.blocked {
+        if now.Before(block.Until) {
+            out = append(out, block)
+        }
+    }
+    b.mu.RUnlock()
+    sort.Slice(out, func(i, j int) bool { return out[i].Until.Before(out[j].Until) })
+    return out
+}
+
+// Check returns ErrAddressBlocked for a blocked ip
+func (b *Blocklist) Check(ctx context.Context, ip netip.Addr) error {
+    if !b.Blocked(ip) {
+        return nil
+    }
+    b.mu.Lock()
+    b.refused++
+    b.mu.Unlock()
+    return ErrAddressBlocked
+}
+
+// Middleware rejects requests from blocked addresses with 403; clientIP resolves the
+// request's client address, e.g. NetworkPolicy.ClientIP
+func (b *Blocklist) Middleware(clientIP func(r *http.Request) netip.Addr, next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if err := b.Check(r.Context(), clientIP(r)); err != nil {
+            http.Error(w, err.Error(), http.StatusForbidden)
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// Admit refuses admissions from blocked addresses at the gateway
+func (b *Blocklist) Admit(ctx context.Context, a *gateway.Admission) error {
+    return b.Check(ctx, a.RemoteIP)
+}
+
+// WriteMetrics writes the blocklist's size and refusals in the Prometheus text format
+func (b *Blocklist) WriteMetrics(w io.Writer) error {
+    blocked := len(b.List())
+    b.mu.RLock()
+    refused := b.refused
+    b.mu.RUnlock()
+
+    metric := func(name, kind, help string) {
+        fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+    }
+    metric("volly_blocked_addresses", "gauge", "Client addresses currently blocked.")
+    fmt.Fprintf(w, "volly_blocked_addresses %d\n", blocked)
+    metric("volly_blocked_requests_total", "counter", "Requests and admissions refused for a blocked address.")
+    _, err := fmt.Fprintf(w, "volly_blocked_requests_total %d\n", refused)
+    return err
+}
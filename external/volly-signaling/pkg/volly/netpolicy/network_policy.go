@@ -0,0 +1,170 @@
+package netpolicy
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "net/netip"
+    "strings"
+    "sync"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/gateway"
+)
+
+var (
+    ErrNetworkDenied  = errors.New("client network is not allowed")
+    ErrPoorReputation = errors.New("client address has a poor reputation")
+)
+
+// Reputation scores client addresses, 0 (clean) to 100 (known abusive)
+type Reputation interface {
+    Risk(ctx context.Context, ip netip.Addr) (int, error)
+}
+
+// Policy is one tenant's network rules. Deny wins over Allow; an empty Allow list admits
+// every address not denied
+type Policy struct {
+    Allow []netip.Prefix
+    Deny  []netip.Prefix
+    // MaxRisk rejects addresses scoring above it; 0 disables reputation checks
+    MaxRisk int
+    // FailOpen admits clients when the reputation lookup errors instead of rejecting them
+    FailOpen bool
+}
+
+// NetworkPolicy applies per-tenant CIDR lists and reputation to tokend requests and gateway admissions
+type NetworkPolicy struct {
+    reputation Reputation
+    trusted    []netip.Prefix
+
+    mu            sync.RWMutex
+    defaultPolicy Policy
+    tenantPolicy  map[string]Policy
+}
+
+// NewNetworkPolicy creates the layer; reputation may be nil when no policy sets MaxRisk
+func NewNetworkPolicy(def Policy, reputation Reputation) *NetworkPolicy {
+    return &NetworkPolicy{
+        reputation:    reputation,
+        defaultPolicy: def,
+        tenantPolicy:  make(map[string]Policy),
+    }
+}
+
+// SetTenantPolicy overrides the default policy for one tenant
+func (n *NetworkPolicy) SetTenantPolicy(tenant string, policy Policy) *NetworkPolicy {
+    n.mu.Lock()
+    n.tenantPolicy[tenant] = policy
+    n.mu.Unlock()
+    return n
+}
+
+// SetTrustedProxies lists the load balancers whose X-Forwarded-For entries are believed
+func (n *NetworkPolicy) SetTrustedProxies(proxies ...netip.Prefix) *NetworkPolicy {
+    n.trusted = proxies
+    return n
+}
+
+// Check applies the tenant's policy to ip; unknown addresses fail closed when any rule is set
+func (n *NetworkPolicy) Check(ctx context.Context, tenant string, ip netip.Addr) error {
+    n.mu.RLock()
+    policy, ok := n.tenantPolicy[tenant]
+    if !ok {
+        policy = n.defaultPolicy
+    }
+    n.mu.RUnlock()
+
+    if len(policy.Allow) == 0 && len(policy.Deny) == 0 && policy.MaxRisk == 0 {
+        return nil
+    }
+    if !ip.IsValid() {
+        return ErrNetworkDenied
+    }
+    ip = ip.Unmap()
+    if containsAddr(policy.Deny, ip) {
+        return ErrNetworkDenied
+    }
+    if len(policy.Allow) > 0 && !containsAddr(policy.Allow, ip) {
+        return ErrNetworkDenied
+    }
+
+    if policy.MaxRisk == 0 || n.reputation == nil {
+        return nil
+    }
+    risk, err := n.reputation.Risk(ctx, ip)
+    if err != nil {
+        if policy.FailOpen {
+            return nil
+        }
+        return ErrPoorReputation
+    }
+    if risk > policy.MaxRisk {
+        return ErrPoorReputation
+    }
+    return nil
+}
+
+// ClientIP returns the request's client address. X-Forwarded-For is read right to left and
+// only trusted proxies are skipped, so a client cannot spoof its address by prepending entries
+func (n *NetworkPolicy) ClientIP(r *http.Request) netip.Addr {
+    addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+    if err != nil {
+        return netip.Addr{}
+    }
+    ip := addrPort.Addr().Unmap()
+    if !containsAddr(n.trusted, ip) {
+        return ip
+    }
+
+    forwarded := r.Header.Values("X-Forwarded-For")
+    if len(forwarded) == 0 {
+        return ip
+    }
+    hops := strings.Split(strings.Join(forwarded, ","), ",")
+    for i := len(hops) - 1; i >= 0; i-- {
+        hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+        if err != nil {
+            return netip.Addr{}
+        }
+        hop = hop.Unmap()
+        if !containsAddr(n.trusted, hop) {
+            return hop
+        }
+        ip = hop
+    }
+    return ip
+}
+
+// Middleware rejects requests from disallowed networks with 403; tenant maps a request to its
+// tenant and may be nil to always use the default policy
+func (n *NetworkPolicy) Middleware(tenant func(r *http.Request) string, next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        t := ""
+        if tenant != nil {
+            t = tenant(r)
+        }
+        if err := n.Check(r.Context(), t, n.ClientIP(r)); err != nil {
+            http.Error(w, err.Error(), http.StatusForbidden)
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// Admit applies the policy of the token's tenant at the gateway
+func (n *NetworkPolicy) Admit(ctx context.Context, a *gateway.Admission) error {
+    tenant := ""
+    if a.Grant != nil {
+        tenant = a.Grant.Tenant
+    }
+    return n.Check(ctx, tenant, a.RemoteIP)
+}
+
+func containsAddr(prefixes []netip.Prefix, ip netip.Addr) bool {
+    for _, p := range prefixes {
+        if p.Contains(ip) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,190 @@
+package offline
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "strconv"
+    "time"
+
+    lkauth "github.com/livekit/protocol/auth"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/secure"
+)
+
+// MaxBundleSize bounds how many tokens one bundle holds
+const MaxBundleSize = 100000
+
+// DefaultIdentityPrefix names bundled participants guest-<serial>
+const DefaultIdentityPrefix = "guest-"
+
+// bundleContext separates bundle signatures from token signatures made with the same secret
+const bundleContext = "volly-offline-bundle-v1"
+
+var (
+    ErrInvalidSpec     = errors.New("bundle spec needs a room, a positive count up to MaxBundleSize and a validity")
+    ErrBundleTampered  = errors.New("bundle signature is invalid")
+    ErrBundleExhausted = errors.New("bundle has no tokens left")
+    ErrBundleExpired   = errors.New("bundle has expired")
+    ErrBundleClosed    = errors.New("bundle has been closed")
+    ErrBundleMismatch  = errors.New("usage report is for a different bundle")
+)
+
+// Spec describes a bundle to pre-sign
+type Spec struct {
+    // ID names the bundle and prefixes every jti; one is generated when empty
+    ID     string `json:"id,omitempty"`
+    Tenant string `json:"tenant,omitempty"`
+    Room   string `json:"room"`
+    // IdentityPrefix is followed by the serial in each identity
+    IdentityPrefix string `json:"identityPrefix,omitempty"`
+    Count          int    `json:"count"`
+    // FirstSerial numbers the first token, 1 when zero
+    FirstSerial uint64 `json:"firstSerial,omitempty"`
+    // ValidFor must cover the whole disconnected period: tokens cannot be renewed offline
+    ValidFor   time.Duration `json:"validFor"`
+    CanPublish bool          `json:"canPublish,omitempty"`
+}
+
+// Token is one pre-signed token of a bundle
+type Token struct {
+    Serial   uint64 `json:"serial"`
+    Identity string `json:"identity"`
+    JTI      string `json:"jti"`
+    Token    string `json:"token"`
+}
+
+// Bundle is a signed set of pre-authorized tokens with jti values BundleID.<serial> for a
+// contiguous serial range, so the issuer can account for and revoke every one of them
+type Bundle struct {
+    ID          string    `json:"id"`
+    APIKey      string    `json:"apiKey"`
+    Tenant      string    `json:"tenant,omitempty"`
+    Room        string    `json:"room"`
+    FirstSerial uint64    `json:"firstSerial"`
+    LastSerial  uint64    `json:"lastSerial"`
+    IssuedAt    time.Time `json:"issuedAt"`
+    ExpiresAt   time.Time `json:"expiresAt"`
+    Tokens      []Token   `json:"tokens"`
+    Signature   []byte    `json:"signature,omitempty"`
+}
+
+// JTI returns the jti of the token with the given serial
+func JTI(bundleID string, serial uint64) string {
+    return bundleID + "." + strconv.FormatUint(serial, 10)
+}
+
+// Issuer pre-signs bundles with an API key's secret
+type Issuer struct {
+    apiKey string
+    secret *secure.SecureBytes
+    clock  clock.Clock
+}
+
+// NewIssuer creates an issuer signing with secret
+func NewIssuer(apiKey string, secret *secure.SecureBytes) *Issuer {
+    return &Issuer{apiKey: apiKey, secret: secret, clock: clock.System}
+}
+
+// SetClock sets the time source for issuance and expiry
+func (i *Issuer) SetClock(c clock.Clock) *Issuer {
+    i.clock = c
+    return i
+}
+
+// Issue signs spec.Count tokens and the bundle holding them
+func (i *Issuer) Issue(spec Spec) (*Bundle, error) {
+    if spec.Room == "" || spec.Count <= 0 || spec.Count > MaxBundleSize || spec.ValidFor <= 0 {
+        return nil, ErrInvalidSpec
+    }
+    if spec.ID == "" {
+        id := make([]byte, 8)
+        if _, err := rand.Read(id); err != nil {
+            return nil, err
+        }
+        spec.ID = "OB" + hex.EncodeToString(id)
+    }
+    if spec.IdentityPrefix == "" {
+        spec.IdentityPrefix = DefaultIdentityPrefix
+    }
+    if spec.FirstSerial == 0 {
+        spec.FirstSerial = 1
+    }
+
+    now := i.clock.Now()
+    b := &Bundle{
+        ID:          spec.ID,
+        APIKey:      i.apiKey,
+        Tenant:      spec.Tenant,
+        Room:        spec.Room,
+        FirstSerial: spec.FirstSerial,
+        LastSerial:  spec.FirstSerial + uint64(spec.Count) - 1,
+        IssuedAt:    now.UTC(),
+        ExpiresAt:   now.Add(spec.ValidFor).UTC(),
+        Tokens:      make([]Token, 0, spec.Count),
+    }
+    for serial := b.FirstSerial; serial <= b.LastSerial; serial++ {
+        identity := spec.IdentityPrefix + strconv.FormatUint(serial, 10)
+        jti := JTI(b.ID, serial)
+        grant := &auth.VollyVideoGrant{VideoGrant: lkauth.VideoGrant{RoomJoin: true, Room: spec.Room}}
+        grant.SetCanPublish(spec.CanPublish)
+        grant.SetCanPublishData(spec.CanPublish)
+        token, err := auth.NewVollyAccessTokenWithSecret(i.apiKey, i.secret).
+            SetClock(i.clock).
+            AddGrant(grant).
+            SetIdentity(identity).
+            SetTenant(spec.Tenant).
+            SetValidFor(spec.ValidFor).
+            SetTokenID(jti).
+            ToJWT()
+        if err != nil {
+            return nil, err
+        }
+        b.Tokens = append(b.Tokens, Token{Serial: serial, Identity: identity, JTI: jti, Token: token})
+    }
+
+    sig, err := signBundle(b, i.secret)
+    if err != nil {
+        return nil, err
+    }
+    b.Signature = sig
+    return b, nil
+}
+
+// Verify checks the bundle signature, so an edge node can refuse a bundle altered in transit
+func (b *Bundle) Verify(secret *secure.SecureBytes) error {
+    sig, err := signBundle(b, secret)
+    if err != nil {
+        return err
+    }
+    if !hmac.Equal(sig, b.Signature) {
+        return ErrBundleTampered
+    }
+    return nil
+}
+
+func signBundle(b *Bundle, secret *secure.SecureBytes) ([]byte, error) {
+    unsigned := *b
+    unsigned.Signature = nil
+    data, err := json.Marshal(unsigned)
+    if err != nil {
+        return nil, err
+    }
+    if secret.Len() == 0 {
+        return nil, auth.ErrSecretUnavailable
+    }
+    var sig []byte
+    err = secret.Use(func(key []byte) error {
+        m := hmac.New(sha256.New, key)
+        m.Write([]byte(bundleContext))
+        m.Write(data)
+        sig = m.Sum(nil)
+        return nil
+    })
+    return sig, err
+}
@@ -0,0 +1,120 @@
+package offline
+
+import (
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+)
+
+// Usage records one token handed out by an edge node
+type Usage struct {
+    Serial uint64    `json:"serial"`
+    At     time.Time `json:"at"`
+    // To is whatever the edge knows about the recipient, e.g. a cabin or seat number
+    To string `json:"to,omitempty"`
+}
+
+// UsageReport is what an edge node sends the issuer on reconnect. Closed means the edge
+// has stopped handing out this bundle, so its unused tokens can be revoked
+type UsageReport struct {
+    BundleID string  `json:"bundleId"`
+    Used     []Usage `json:"used"`
+    Closed   bool    `json:"closed,omitempty"`
+}
+
+// Dispenser hands out a bundle's tokens in serial order while the edge is disconnected.
+// Persist Report after every Next and pass it to Restore after a restart so no token is
+// handed out twice
+type Dispenser struct {
+    bundle *Bundle
+    clock  clock.Clock
+
+    mu     sync.Mutex
+    next   int
+    used   []Usage
+    closed bool
+}
+
+// NewDispenser creates a dispenser for a verified bundle
+func NewDispenser(bundle *Bundle) *Dispenser {
+    return &Dispenser{bundle: bundle, clock: clock.System}
+}
+
+// SetClock sets the time source for usage stamps and bundle expiry
+func (d *Dispenser) SetClock(c clock.Clock) *Dispenser {
+    d.clock = c
+    return d
+}
+
+// Restore resumes from a report saved before a restart
+func (d *Dispenser) Restore(report UsageReport) error {
+    if report.BundleID != d.bundle.ID {
+        return ErrBundleMismatch
+    }
+    d.mu.Lock()
+    defer d.mu.Unlock()
+
+    d.used = append([]Usage(nil), report.Used...)
+    d.closed = report.Closed
+    d.next = 0
+    for _, u := range report.Used {
+        if u.Serial < d.bundle.FirstSerial || u.Serial > d.bundle.LastSerial {
+            continue
+        }
+        if i := int(u.Serial-d.bundle.FirstSerial) + 1; i > d.next {
+            d.next = i
+        }
+    }
+    return nil
+}
+
+// Next hands out the next unused token
+func (d *Dispenser) Next(to string) (Token, error) {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+
+    now := d.clock.Now()
+    switch {
+    case d.closed:
+        return Token{}, ErrBundleClosed
+    case !now.Before(d.bundle.ExpiresAt):
+        return Token{}, ErrBundleExpired
+    case d.next >= len(d.bundle.Tokens):
+        return Token{}, ErrBundleExhausted
+    }
+    t := d.bundle.Tokens[d.next]
+    d.next++
+    d.used = append(d.used, Usage{Serial: t.Serial, At: now.UTC(), To: to})
+    return t, nil
+}
+
+// Remaining returns how many tokens are left
+func (d *Dispenser) Remaining() int {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+
+    if d.closed {
+        return 0
+    }
+    return len(d.bundle.Tokens) - d.next
+}
+
+// Close stops handing out tokens; the next report lets the issuer revoke the rest
+func (d *Dispenser) Close() {
+    d.mu.Lock()
+    d.closed = true
+    d.mu.Unlock()
+}
+
+// Report returns the usage so far
+func (d *Dispenser) Report() UsageReport {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+
+    return UsageReport{
+        BundleID: d.bundle.ID,
+        Used:     append([]Usage(nil), d.used...),
+        Closed:   d.closed,
+    }
+}
@@ -0,0 +1,55 @@
+package offline
+
+import (
+    "context"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/revocation"
+)
+
+// Reconciliation summarizes a usage report against its bundle
+type Reconciliation struct {
+    BundleID string `json:"bundleId"`
+    Used     int    `json:"used"`
+    // Revoked counts unused tokens revoked because the report closed the bundle
+    Revoked int `json:"revoked"`
+    // Unknown lists reported serials outside the bundle and Duplicates those reported more
+    // than once; either means the edge's records cannot be trusted
+    Unknown    []uint64 `json:"unknown,omitempty"`
+    Duplicates []uint64 `json:"duplicates,omitempty"`
+}
+
+// Reconcile checks report against b and, once the edge has closed the bundle, revokes every
+// token it did not hand out so leftovers cannot be used later. Reconciling the same closed
+// report twice is harmless
+func Reconcile(ctx context.Context, b *Bundle, report UsageReport, revoker *revocation.Revoker) (Reconciliation, error) {
+    if report.BundleID != b.ID {
+        return Reconciliation{}, ErrBundleMismatch
+    }
+    r := Reconciliation{BundleID: b.ID}
+    used := make(map[uint64]bool, len(report.Used))
+    for _, u := range report.Used {
+        switch {
+        case u.Serial < b.FirstSerial || u.Serial > b.LastSerial:
+            r.Unknown = append(r.Unknown, u.Serial)
+        case used[u.Serial]:
+            r.Duplicates = append(r.Duplicates, u.Serial)
+        default:
+            used[u.Serial] = true
+        }
+    }
+    r.Used = len(used)
+    if !report.Closed {
+        return r, nil
+    }
+
+    for _, t := range b.Tokens {
+        if used[t.Serial] {
+            continue
+        }
+        if err := revoker.Revoke(ctx, t.JTI, b.ExpiresAt); err != nil {
+            return r, err
+        }
+        r.Revoked++
+    }
+    return r, nil
+}
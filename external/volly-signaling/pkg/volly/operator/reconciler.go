@@ -0,0 +1,348 @@
+package operator
+
+import (
+    "context"
+    "errors"
+    "log"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/configstore"
+    "github.com/volly-org/volly-signaling/pkg/volly/secure"
+)
+
+const (
+    // DefaultResyncInterval is how often Run reconciles every resource
+    DefaultResyncInterval = 30 * time.Second
+    // DefaultRotationGrace keeps a rotated-out key valid while clients reload the Secret
+    DefaultRotationGrace = time.Hour
+
+    // Keys written to API key Secrets
+    SecretKeyAPIKey    = "apiKey"
+    SecretKeyAPISecret = "apiSecret"
+)
+
+var (
+    ErrSecretNotFound = errors.New("kubernetes secret not found")
+    ErrInvalidSpec    = errors.New("invalid resource spec")
+)
+
+// Cluster is the Kubernetes API surface the reconciler needs; the operator binary
+// implements it with client-go informers, and tests or dry runs can fake it
+type Cluster interface {
+    ListTenants(ctx context.Context) ([]*VollyTenant, error)
+    ListAPIKeys(ctx context.Context) ([]*VollyAPIKey, error)
+    ListRoomPolicies(ctx context.Context) ([]*VollyRoomPolicy, error)
+    // Update persists metadata such as finalizers
+    Update(ctx context.Context, obj Object) error
+    UpdateStatus(ctx context.Context, obj Object) error
+
+    GetSecret(ctx context.Context, namespace, name string) (map[string][]byte, error)
+    ApplySecret(ctx context.Context, namespace, name string, data map[string][]byte) error
+    DeleteSecret(ctx context.Context, namespace, name string) error
+}
+
+// Reconciler drives the config store toward the declared custom resources and rotates
+// API key secrets into Kubernetes Secrets
+type Reconciler struct {
+    cluster  Cluster
+    store    configstore.Store
+    clock    clock.Clock
+    interval time.Duration
+}
+
+// NewReconciler creates a reconciler writing into store
+func NewReconciler(cluster Cluster, store configstore.Store) *Reconciler {
+    return &Reconciler{
+        cluster:  cluster,
+        store:    store,
+        clock:    clock.System,
+        interval: DefaultResyncInterval,
+    }
+}
+
+// SetClock sets the time source for rotation
+func (r *Reconciler) SetClock(c clock.Clock) *Reconciler {
+    r.clock = c
+    return r
+}
+
+// SetResyncInterval sets how often Run reconciles
+func (r *Reconciler) SetResyncInterval(d time.Duration) *Reconciler {
+    r.interval = d
+    return r
+}
+
+// Run reconciles until ctx is cancelled
+func (r *Reconciler) Run(ctx context.Context) {
+    ticker := time.NewTicker(r.interval)
+    defer ticker.Stop()
+
+    for {
+        if err := r.Reconcile(ctx); err != nil {
+            log.Printf("volly operator reconcile failed: %v", err)
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// Reconcile applies every resource once. Tenants go first so keys and policies can
+// reference them; a failing resource is reported in its status and does not stop the rest
+func (r *Reconciler) Reconcile(ctx context.Context) error {
+    tenants, err := r.cluster.ListTenants(ctx)
+    if err != nil {
+        return err
+    }
+    for _, t := range tenants {
+        r.finish(ctx, t, r.reconcileTenant(ctx, t))
+    }
+
+    keys, err := r.cluster.ListAPIKeys(ctx)
+    if err != nil {
+        return err
+    }
+    for _, k := range keys {
+        r.finish(ctx, k, r.reconcileAPIKey(ctx, k))
+    }
+
+    policies, err := r.cluster.ListRoomPolicies(ctx)
+    if err != nil {
+        return err
+    }
+    for _, p := range policies {
+        r.finish(ctx, p, r.reconcileRoomPolicy(ctx, p))
+    }
+    return nil
+}
+
+// finish records the outcome in the resource status; deleted resources have none left to write
+func (r *Reconciler) finish(ctx context.Context, obj Object, err error) {
+    meta := obj.Meta()
+    if meta.DeletionTimestamp != nil && err == nil {
+        return
+    }
+    status := obj.Conditions()
+    status.Ready = err == nil
+    status.Message = ""
+    if err != nil {
+        status.Message = err.Error()
+        log.Printf("volly operator: %s %s/%s: %v", obj.Kind(), meta.Namespace, meta.Name, err)
+    } else {
+        status.ObservedGeneration = meta.Generation
+    }
+    if err := r.cluster.UpdateStatus(ctx, obj); err != nil {
+        log.Printf("volly operator: status update for %s %s/%s failed: %v", obj.Kind(), meta.Namespace, meta.Name, err)
+    }
+}
+
+// begin handles the finalizer: it runs cleanup for deleted resources and reports whether
+// the caller should reconcile the resource as live
+func (r *Reconciler) begin(ctx context.Context, obj Object, cleanup func() error) (bool, error) {
+    meta := obj.Meta()
+    if meta.DeletionTimestamp != nil {
+        if !meta.hasFinalizer() {
+            return false, nil
+        }
+        if err := cleanup(); err != nil {
+            return false, err
+        }
+        meta.removeFinalizer()
+        return false, r.cluster.Update(ctx, obj)
+    }
+    if !meta.hasFinalizer() {
+        meta.Finalizers = append(meta.Finalizers, Finalizer)
+        if err := r.cluster.Update(ctx, obj); err != nil {
+            return false, err
+        }
+    }
+    return true, nil
+}
+
+func (r *Reconciler) reconcileTenant(ctx context.Context, t *VollyTenant) error {
+    live, err := r.begin(ctx, t, func() error { return r.store.DeleteTenant(ctx, t.Name) })
+    if !live {
+        return err
+    }
+    return r.store.PutTenant(ctx, configstore.Tenant{
+        ID:          t.Name,
+        DisplayName: t.Spec.DisplayName,
+        MaxRooms:    t.Spec.MaxRooms,
+        Suspended:   t.Spec.Suspended,
+    })
+}
+
+func (r *Reconciler) reconcileRoomPolicy(ctx context.Context, p *VollyRoomPolicy) error {
+    live, err := r.begin(ctx, p, func() error { return r.store.DeleteRoomPolicy(ctx, p.Spec.Tenant, p.Name) })
+    if !live {
+        return err
+    }
+    if p.Spec.Tenant == "" || p.Spec.Room == "" {
+        return ErrInvalidSpec
+    }
+    if _, err := r.store.GetTenant(ctx, p.Spec.Tenant); err != nil {
+        return err
+    }
+    return r.store.PutRoomPolicy(ctx, configstore.RoomPolicy{
+        Tenant:           p.Spec.Tenant,
+        Name:             p.Name,
+        Room:             p.Spec.Room,
+        MaxParticipants:  p.Spec.MaxParticipants,
+        RequirePQ:        p.Spec.RequirePQ,
+        RecordingAllowed: p.Spec.RecordingAllowed,
+        Providers:        p.Spec.Providers,
+        PINHash:          p.Spec.PINHash,
+        Shadow:           p.Spec.Shadow,
+    })
+}
+
+func (r *Reconciler) reconcileAPIKey(ctx context.Context, k *VollyAPIKey) error {
+    live, err := r.begin(ctx, k, func() error { return r.deleteAPIKey(ctx, k) })
+    if !live {
+        return err
+    }
+    if k.Spec.Tenant == "" || k.Spec.SecretName == "" {
+        return ErrInvalidSpec
+    }
+    interval, err := parseDuration(k.Spec.RotationInterval, 0)
+    if err != nil {
+        return err
+    }
+    grace, err := parseDuration(k.Spec.RotationGrace, DefaultRotationGrace)
+    if err != nil {
+        return err
+    }
+    if _, err := r.store.GetTenant(ctx, k.Spec.Tenant); err != nil {
+        return err
+    }
+
+    now := r.clock.Now()
+    st := &k.Status
+    if st.PreviousKeyID != "" && !now.Before(st.RotatedAt.Add(grace)) {
+        if err := r.store.DeleteAPIKey(ctx, st.PreviousKeyID); err != nil {
+            return err
+        }
+        st.PreviousKeyID = ""
+    }
+
+    data, err := r.cluster.GetSecret(ctx, k.Namespace, k.Spec.SecretName)
+    if err != nil && !errors.Is(err, ErrSecretNotFound) {
+        return err
+    }
+    current := st.KeyID != "" && data != nil && string(data[SecretKeyAPIKey]) == st.KeyID
+    due := interval > 0 && !now.Before(st.RotatedAt.Add(interval))
+    if !current || due {
+        return r.rotate(ctx, k, now, grace)
+    }
+
+    // The Secret is authoritative for the current key, so a store that lost it is refilled
+    stored, err := r.store.GetAPIKey(ctx, st.KeyID)
+    if errors.Is(err, configstore.ErrNotFound) {
+        secret, err := secure.FromBytes(append([]byte(nil), data[SecretKeyAPISecret]...))
+        if err != nil {
+            return err
+        }
+        return r.store.PutAPIKey(ctx, configstore.APIKey{ID: st.KeyID, Tenant: k.Spec.Tenant, Scopes: k.Spec.Scopes, Secret: secret})
+    }
+    if err != nil {
+        return err
+    }
+    if stored.Tenant != k.Spec.Tenant || !equalStrings(stored.Scopes, k.Spec.Scopes) {
+        stored.Tenant, stored.Scopes = k.Spec.Tenant, k.Spec.Scopes
+        return r.store.PutAPIKey(ctx, stored)
+    }
+    return nil
+}
+
+// rotate issues a new key. The store learns it before the Secret does, so clients never
+// read a key the gateways reject; the old key stays valid for grace
+func (r *Reconciler) rotate(ctx context.Context, k *VollyAPIKey, now time.Time, grace time.Duration) error {
+    id, secretText, err := configstore.GenerateAPIKey()
+    if err != nil {
+        return err
+    }
+    secret, err := secure.FromBytes(append([]byte(nil), secretText...))
+    if err != nil {
+        return err
+    }
+    if err := r.store.PutAPIKey(ctx, configstore.APIKey{ID: id, Tenant: k.Spec.Tenant, Scopes: k.Spec.Scopes, Secret: secret}); err != nil {
+        return err
+    }
+    err = r.cluster.ApplySecret(ctx, k.Namespace, k.Spec.SecretName, map[string][]byte{
+        SecretKeyAPIKey:    []byte(id),
+        SecretKeyAPISecret: secretText,
+    })
+    secure.Wipe(secretText)
+    if err != nil {
+        _ = r.store.DeleteAPIKey(ctx, id)
+        return err
+    }
+
+    st := &k.Status
+    if st.PreviousKeyID != "" {
+        if err := r.store.DeleteAPIKey(ctx, st.PreviousKeyID); err != nil {
+            return err
+        }
+    }
+    st.PreviousKeyID = ""
+    if st.KeyID != "" {
+        old, err := r.store.GetAPIKey(ctx, st.KeyID)
+        switch {
+        case err == nil:
+            old.NotAfter = now.Add(grace)
+            if err := r.store.PutAPIKey(ctx, old); err != nil {
+                return err
+            }
+            st.PreviousKeyID = st.KeyID
+        case !errors.Is(err, configstore.ErrNotFound):
+            return err
+        }
+    }
+    st.KeyID = id
+    st.RotatedAt = now
+    return nil
+}
+
+func (r *Reconciler) deleteAPIKey(ctx context.Context, k *VollyAPIKey) error {
+    for _, id := range []string{k.Status.KeyID, k.Status.PreviousKeyID} {
+        if id == "" {
+            continue
+        }
+        if err := r.store.DeleteAPIKey(ctx, id); err != nil {
+            return err
+        }
+    }
+    if k.Spec.SecretName == "" {
+        return nil
+    }
+    if err := r.cluster.DeleteSecret(ctx, k.Namespace, k.Spec.SecretName); err != nil && !errors.Is(err, ErrSecretNotFound) {
+        return err
+    }
+    return nil
+}
+
+func parseDuration(s string, def time.Duration) (time.Duration, error) {
+    if s == "" {
+        return def, nil
+    }
+    d, err := time.ParseDuration(s)
+    if err != nil || d < 0 {
+        return 0, ErrInvalidSpec
+    }
+    return d, nil
+}
+
+func equalStrings(a, b []string) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
@@ -0,0 +1,130 @@
+package operator
+
+import "time"
+
+// Group and version of the Volly custom resources; manifests live in deploy/operator
+const (
+    Group   = "volly.io"
+    Version = "v1alpha1"
+
+    KindTenant     = "VollyTenant"
+    KindAPIKey     = "VollyAPIKey"
+    KindRoomPolicy = "VollyRoomPolicy"
+)
+
+// Finalizer keeps a resource until its config store entry is removed
+const Finalizer = "volly.io/config-store"
+
+// ObjectMeta is the subset of Kubernetes object metadata the reconciler needs
+type ObjectMeta struct {
+    Name              string     `json:"name"`
+    Namespace         string     `json:"namespace,omitempty"`
+    Generation        int64      `json:"generation,omitempty"`
+    ResourceVersion   string     `json:"resourceVersion,omitempty"`
+    DeletionTimestamp *time.Time `json:"deletionTimestamp,omitempty"`
+    Finalizers        []string   `json:"finalizers,omitempty"`
+}
+
+// Object is any Volly custom resource
+type Object interface {
+    Kind() string
+    Meta() *ObjectMeta
+    Conditions() *Status
+}
+
+// Status is shared by every resource: whether the last reconcile applied the current generation
+type Status struct {
+    ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+    Ready              bool   `json:"ready"`
+    Message            string `json:"message,omitempty"`
+}
+
+// VollyTenant declares a tenant; metadata.name is the tenant ID
+type VollyTenant struct {
+    ObjectMeta `json:"metadata"`
+    Spec       TenantSpec `json:"spec"`
+    Status     Status     `json:"status,omitempty"`
+}
+
+type TenantSpec struct {
+    DisplayName string `json:"displayName,omitempty"`
+    MaxRooms    int    `json:"maxRooms,omitempty"`
+    Suspended   bool   `json:"suspended,omitempty"`
+}
+
+func (t *VollyTenant) Kind() string        { return KindTenant }
+func (t *VollyTenant) Meta() *ObjectMeta   { return &t.ObjectMeta }
+func (t *VollyTenant) Conditions() *Status { return &t.Status }
+
+// VollyAPIKey declares a tenant API key; the operator generates and rotates the secret
+// and writes it to the Secret named by SecretName in the resource's namespace
+type VollyAPIKey struct {
+    ObjectMeta `json:"metadata"`
+    Spec       APIKeySpec   `json:"spec"`
+    Status     APIKeyStatus `json:"status,omitempty"`
+}
+
+type APIKeySpec struct {
+    Tenant     string   `json:"tenant"`
+    SecretName string   `json:"secretName"`
+    Scopes     []string `json:"scopes,omitempty"`
+    // RotationInterval is a Go duration such as 720h; empty disables rotation
+    RotationInterval string `json:"rotationInterval,omitempty"`
+    // RotationGrace keeps the previous key valid after rotation, default DefaultRotationGrace
+    RotationGrace string `json:"rotationGrace,omitempty"`
+}
+
+type APIKeyStatus struct {
+    Status        `json:",inline"`
+    KeyID         string    `json:"keyID,omitempty"`
+    PreviousKeyID string    `json:"previousKeyID,omitempty"`
+    RotatedAt     time.Time `json:"rotatedAt,omitempty"`
+}
+
+func (k *VollyAPIKey) Kind() string        { return KindAPIKey }
+func (k *VollyAPIKey) Meta() *ObjectMeta   { return &k.ObjectMeta }
+func (k *VollyAPIKey) Conditions() *Status { return &k.Status.Status }
+
+// VollyRoomPolicy declares limits for a tenant's rooms matching Room
+type VollyRoomPolicy struct {
+    ObjectMeta `json:"metadata"`
+    Spec       RoomPolicySpec `json:"spec"`
+    Status     Status         `json:"status,omitempty"`
+}
+
+type RoomPolicySpec struct {
+    Tenant           string `json:"tenant"`
+    Room             string `json:"room"`
+    MaxParticipants  int    `json:"maxParticipants,omitempty"`
+    RequirePQ        bool   `json:"requirePQ,omitempty"`
+    RecordingAllowed bool   `json:"recordingAllowed,omitempty"`
+    // Providers admits only tokens authenticated by one of them
+    Providers []string `json:"providers,omitempty"`
+    // PINHash asks participants for a PIN; get it from the admin API's pin-hash endpoint
+    PINHash string `json:"pinHash,omitempty"`
+    // Shadow dry-runs the policy against live admissions instead of enforcing it
+    Shadow bool `json:"shadow,omitempty"`
+}
+
+func (p *VollyRoomPolicy) Kind() string        { return KindRoomPolicy }
+func (p *VollyRoomPolicy) Meta() *ObjectMeta   { return &p.ObjectMeta }
+func (p *VollyRoomPolicy) Conditions() *Status { return &p.Status }
+
+func (m *ObjectMeta) hasFinalizer() bool {
+    for _, f := range m.Finalizers {
+        if f == Finalizer {
+            return true
+        }
+    }
+    return false
+}
+
+func (m *ObjectMeta) removeFinalizer() {
+    out := m.Finalizers[:0]
+    for _, f := range m.Finalizers {
+        if f != Finalizer {
+            out = append(out, f)
+        }
+    }
+    m.Finalizers = out
+}
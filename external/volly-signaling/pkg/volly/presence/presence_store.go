@@ -0,0 +1,212 @@
+package presence
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/listing"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// Kind distinguishes human participants from server-side agents
+type Kind string
+
+const (
+    KindHuman Kind = "human"
+    KindAgent Kind = "agent"
+)
+
+// Session is a single connected participant session in a room
+type Session struct {
+    SessionID string            `json:"sessionId"`
+    Tenant    string            `json:"tenant,omitempty"`
+    Room      string            `json:"room"`
+    Identity  string            `json:"identity"`
+    Kind      Kind              `json:"kind"`
+    JoinedAt  time.Time         `json:"joinedAt"`
+    Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+// Store tracks active sessions across rooms
+type Store interface {
+    Join(ctx context.Context, session Session) error
+    Leave(ctx context.Context, sessionID string) error
+    Get(ctx context.Context, sessionID string) (Session, error)
+    ListRoom(ctx context.Context, room string) ([]Session, error)
+    ListIdentity(ctx context.Context, identity string) ([]Session, error)
+}
+
+// Lister is implemented by stores that can page through sessions and rooms for admin APIs.
+// Sessions sort by joinedAt (default), room or identity; rooms by room (default) or sessions
+type Lister interface {
+    List(ctx context.Context, q listing.Query) (sessions []Session, next string, err error)
+    ListRooms(ctx context.Context, q listing.Query) (rooms []RoomSummary, next string, err error)
+}
+
+// RoomSummary is one row of a room listing
+type RoomSummary struct {
+    Room     string    `json:"room"`
+    Tenant   string    `json:"tenant,omitempty"`
+    Sessions int       `json:"sessions"`
+    OpenedAt time.Time `json:"openedAt"`
+}
+
+// NewSessionID returns a random session identifier
+func NewSessionID() string {
+    b := make([]byte, 16)
+    if _, err := rand.Read(b); err != nil {
+        panic(err)
+    }
+    return hex.EncodeToString(b)
+}
+
+// MemoryStore is an in-process Store for single-instance deployments
+type MemoryStore struct {
+    mu       sync.RWMutex
+    sessions map[string]Session
+}
+
+// NewMemoryStore creates an empty presence store
+func NewMemoryStore() *MemoryStore {
+    return &MemoryStore{
+        sessions: make(map[string]Session),
+    }
+}
+
+// Join records a session, replacing any previous entry with the same ID
+func (s *MemoryStore) Join(ctx context.Context, session Session) error {
+    if session.SessionID == "" {
+        return errors.New("session id is required")
+    }
+    if session.Kind == "" {
+        session.Kind = KindHuman
+    }
+    if session.JoinedAt.IsZero() {
+        session.JoinedAt = time.Now()
+    }
+
+    s.mu.Lock()
+    s.sessions[session.SessionID] = session
+    s.mu.Unlock()
+    return nil
+}
+
+// Leave removes a session
+func (s *MemoryStore) Leave(ctx context.Context, sessionID string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if _, ok := s.sessions[sessionID]; !ok {
+        return ErrSessionNotFound
+    }
+    delete(s.sessions, sessionID)
+    return nil
+}
+
+// Get returns a session by ID
+func (s *MemoryStore) Get(ctx context.Context, sessionID string) (Session, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    session, ok := s.sessions[sessionID]
+    if !ok {
+        return Session{}, ErrSessionNotFound
+    }
+    return session, nil
+}
+
+// ListRoom returns the sessions in a room ordered by join time
+func (s *MemoryStore) ListRoom(ctx context.Context, room string) ([]Session, error) {
+    return s.filter(func(session Session) bool { return session.Room == room }), nil
+}
+
+// ListIdentity returns the sessions held by an identity ordered by join time
+func (s *MemoryStore) ListIdentity(ctx context.Context, identity string) ([]Session, error) {
+    return s.filter(func(session Session) bool { return session.Identity == identity }), nil
+}
+
+func (s *MemoryStore) filter(match func(Session) bool) []Session {
+    s.mu.RLock()
+    var out []Session
+    for _, session := range s.sessions {
+        if match(session) {
+            out = append(out, session)
+        }
+    }
+    s.mu.RUnlock()
+
+    sort.Slice(out, func(i, j int) bool {
+        return out[i].JoinedAt.Before(out[j].JoinedAt)
+    })
+    return out
+}
+
+// List pages through sessions matching the room prefix, tenant and identity filters
+func (s *MemoryStore) List(ctx context.Context, q listing.Query) ([]Session, string, error) {
+    f := q.Filter
+    sessions := s.filter(func(session Session) bool {
+        return f.MatchRoom(session.Room) && listing.Match(f.Tenant, session.Tenant) && listing.Match(f.Identity, session.Identity)
+    })
+
+    items := make([]listing.Item, len(sessions))
+    for i, session := range sessions {
+        items[i] = listing.Item{ID: session.SessionID, Keys: map[string]string{
+            "joinedAt": listing.TimeKey(session.JoinedAt),
+            "room":     session.Room,
+            "identity": session.Identity,
+        }}
+    }
+    page, next, err := listing.Paginate(items, "joinedAt", q)
+    if err != nil {
+        return nil, "", err
+    }
+    out := make([]Session, len(page))
+    for i, idx := range page {
+        out[i] = sessions[idx]
+    }
+    return out, next, nil
+}
+
+// ListRooms pages through rooms with at least one session matching the room prefix and tenant filters
+func (s *MemoryStore) ListRooms(ctx context.Context, q listing.Query) ([]RoomSummary, string, error) {
+    f := q.Filter
+    byRoom := make(map[string]*RoomSummary)
+    for _, session := range s.filter(func(session Session) bool {
+        return f.MatchRoom(session.Room) && listing.Match(f.Tenant, session.Tenant)
+    }) {
+        summary, ok := byRoom[session.Room]
+        if !ok {
+            // Sessions arrive in join order, so the first one opened the room
+            summary = &RoomSummary{Room: session.Room, Tenant: session.Tenant, OpenedAt: session.JoinedAt}
+            byRoom[session.Room] = summary
+        }
+        summary.Sessions++
+    }
+
+    rooms := make([]RoomSummary, 0, len(byRoom))
+    for _, summary := range byRoom {
+        rooms = append(rooms, *summary)
+    }
+    items := make([]listing.Item, len(rooms))
+    for i, room := range rooms {
+        items[i] = listing.Item{ID: room.Room, Keys: map[string]string{
+            "room":     room.Room,
+            "sessions": listing.IntKey(int64(room.Sessions)),
+        }}
+    }
+    page, next, err := listing.Paginate(items, "room", q)
+    if err != nil {
+        return nil, "", err
+    }
+    out := make([]RoomSummary, len(page))
+    for i, idx := range page {
+        out[i] = rooms[idx]
+    }
+    return out, next, nil
+}
@@ -0,0 +1,185 @@
+package presence
+
+import (
+    "context"
+    "errors"
+    "sync"
+)
+
+var ErrSessionLimitExceeded = errors.New("identity has reached its concurrent session limit")
+
+// EvictionPolicy decides which session loses when an identity exceeds its limit
+type EvictionPolicy string
+
+const (
+    // EvictOldest displaces the longest-lived sessions to admit the new one
+    EvictOldest EvictionPolicy = "oldest"
+    // EvictNewest refuses the incoming session and keeps existing ones
+    EvictNewest EvictionPolicy = "newest"
+)
+
+// DisplaceReasonSessionLimit is sent to sessions evicted by the limiter
+const DisplaceReasonSessionLimit = "session_limit"
+
+// Limit caps concurrent sessions per identity; MaxSessions of 0 means unlimited and Policy defaults to EvictNewest
+type Limit struct {
+    MaxSessions int
+    Policy      EvictionPolicy
+}
+
+// Displacer pushes a revocation to a connected session so its client disconnects
+type Displacer interface {
+    Displace(ctx context.Context, session Session, reason string) error
+}
+
+// SessionLimiter enforces per-identity concurrent session limits on top of a Store
+type SessionLimiter struct {
+    store     Store
+    displacer Displacer
+
+    rulesMu     sync.RWMutex
+    defaultRule Limit
+    tenantRules map[string]Limit
+    roomRules   map[roomKey]Limit
+
+    scopesMu sync.Mutex
+    scopes   map[scopeKey]*scopeLock
+}
+
+type roomKey struct {
+    tenant, room string
+}
+
+// scopeKey names what one admission counts against: a tenant's room under a room rule, or
+// an identity across the tenant otherwise
+type scopeKey struct {
+    tenant, room, identity string
+}
+
+type scopeLock struct {
+    mu   sync.Mutex
+    refs int
+}
+
+// NewSessionLimiter creates a limiter applying def where no tenant or room rule exists
+func NewSessionLimiter(store Store, displacer Displacer, def Limit) *SessionLimiter {
+    return &SessionLimiter{
+        store:       store,
+        displacer:   displacer,
+        defaultRule: def,
+        tenantRules: make(map[string]Limit),
+        roomRules:   make(map[roomKey]Limit),
+        scopes:      make(map[scopeKey]*scopeLock),
+    }
+}
+
+// SetTenantLimit overrides the default for all rooms of a tenant
+func (l *SessionLimiter) SetTenantLimit(tenant string, limit Limit) *SessionLimiter {
+    l.rulesMu.Lock()
+    l.tenantRules[tenant] = limit
+    l.rulesMu.Unlock()
+    return l
+}
+
+// SetRoomLimit overrides tenant and default limits for one room of a tenant, counting only
+// sessions in that room
+func (l *SessionLimiter) SetRoomLimit(tenant, room string, limit Limit) *SessionLimiter {
+    l.rulesMu.Lock()
+    l.roomRules[roomKey{tenant, room}] = limit
+    l.rulesMu.Unlock()
+    return l
+}
+
+// Admit joins the session to the store, evicting per policy; it returns the displaced sessions
+func (l *SessionLimiter) Admit(ctx context.Context, session Session) ([]Session, error) {
+    limit, roomScoped := l.limitFor(session)
+    if limit.MaxSessions <= 0 {
+        return nil, l.store.Join(ctx, session)
+    }
+
+    // Serialize admissions to one scope so two concurrent joins can't both slip under the
+    // limit; joins to other rooms and identities don't wait on this one's store calls
+    scope := scopeKey{tenant: session.Tenant, identity: session.Identity}
+    if roomScoped {
+        scope = scopeKey{tenant: session.Tenant, room: session.Room}
+    }
+    unlock := l.lock(scope)
+    defer unlock()
+
+    existing, err := l.store.ListIdentity(ctx, session.Identity)
+    if err != nil {
+        return nil, err
+    }
+    var counted []Session
+    for _, s := range existing {
+        if s.SessionID == session.SessionID || s.Tenant != session.Tenant {
+            continue
+        }
+        if roomScoped && s.Room != session.Room {
+            continue
+        }
+        counted = append(counted, s)
+    }
+
+    excess := len(counted) + 1 - limit.MaxSessions
+    if excess <= 0 {
+        return nil, l.store.Join(ctx, session)
+    }
+    if limit.Policy != EvictOldest {
+        return nil, ErrSessionLimitExceeded
+    }
+
+    // ListIdentity is ordered by join time, so the oldest sessions come first
+    displaced := counted[:excess]
+    for _, s := range displaced {
+        if err := l.store.Leave(ctx, s.SessionID); err != nil && !errors.Is(err, ErrSessionNotFound) {
+            return nil, err
+        }
+        if l.displacer != nil {
+            if err := l.displacer.Displace(ctx, s, DisplaceReasonSessionLimit); err != nil {
+                return nil, err
+            }
+        }
+    }
+
+    if err := l.store.Join(ctx, session); err != nil {
+        return nil, err
+    }
+    return displaced, nil
+}
+
+func (l *SessionLimiter) limitFor(session Session) (Limit, bool) {
+    l.rulesMu.RLock()
+    defer l.rulesMu.RUnlock()
+
+    if limit, ok := l.roomRules[roomKey{session.Tenant, session.Room}]; ok {
+        return limit, true
+    }
+    if limit, ok := l.tenantRules[session.Tenant]; ok {
+        return limit, false
+    }
+    return l.defaultRule, false
+}
+
+// lock takes the admission lock of scope, dropping it from the map once no admission holds
+// or waits for it
+func (l *SessionLimiter) lock(scope scopeKey) func() {
+    l.scopesMu.Lock()
+    s, ok := l.scopes[scope]
+    if !ok {
+        s = &scopeLock{}
+        l.scopes[scope] = s
+    }
+    s.refs++
+    l.scopesMu.Unlock()
+
+    s.mu.Lock()
+    return func() {
+        s.mu.Unlock()
+        l.scopesMu.Lock()
+        if s.refs--; s.refs == 0 {
+            delete(l.scopes, scope)
+        }
+        l.scopesMu.Unlock()
+    }
+}
@@ -0,0 +1,57 @@
+package presence
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "testing"
+)
+
+// TestRoomLimitPerTenant checks a room rule only applies in its own tenant
+func TestRoomLimitPerTenant(t *testing.T) {
+    ctx := context.Background()
+    l := NewSessionLimiter(NewMemoryStore(), nil, Limit{}).SetRoomLimit("acme", "lobby", Limit{MaxSessions: 1})
+
+    join := func(tenant string) error {
+        _, err := l.Admit(ctx, Session{SessionID: NewSessionID(), Tenant: tenant, Room: "lobby", Identity: "alice"})
+        return err
+    }
+    if err := join("acme"); err != nil {
+        t.Fatal(err)
+    }
+    if err := join("acme"); !errors.Is(err, ErrSessionLimitExceeded) {
+        t.Fatalf("second acme session: got %v, want ErrSessionLimitExceeded", err)
+    }
+    for i := 0; i < 3; i++ {
+        if err := join("globex"); err != nil {
+            t.Fatalf("globex session %d: %v", i, err)
+        }
+    }
+}
+
+// TestConcurrentAdmitsHoldLimit races joins of one identity and checks exactly the limit get in
+func TestConcurrentAdmitsHoldLimit(t *testing.T) {
+    ctx := context.Background()
+    store := NewMemoryStore()
+    l := NewSessionLimiter(store, nil, Limit{MaxSessions: 3})
+
+    var wg sync.WaitGroup
+    for i := 0; i < 20; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            _, err := l.Admit(ctx, Session{SessionID: NewSessionID(), Tenant: "acme", Room: "lobby", Identity: "alice"})
+            if err != nil && !errors.Is(err, ErrSessionLimitExceeded) {
+                t.Error(err)
+            }
+        }()
+    }
+    wg.Wait()
+    held, err := store.ListIdentity(ctx, "alice")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(held) != 3 {
+        t.Fatalf("alice holds %d sessions, want 3", len(held))
+    }
+}
@@ -0,0 +1,94 @@
+package protocol
+
+import (
+    "fmt"
+    "io"
+    "sync"
+    "time"
+)
+
+// ClockSkewBuckets are the histogram bounds, in seconds, of observed client clock skew
+var ClockSkewBuckets = []float64{0.5, 1, 2, 5, 10, 30, 60, 300}
+
+// ClockSkewStats summarizes the skew of client clocks seen in hellos; positive skew is a
+// client clock running ahead of the server's
+type ClockSkewStats struct {
+    Observed  uint64        `json:"observed"`
+    Ahead     uint64        `json:"ahead"`
+    Behind    uint64        `json:"behind"`
+    MaxAhead  time.Duration `json:"maxAhead"`
+    MaxBehind time.Duration `json:"maxBehind"`
+}
+
+// ClockSkews collects the clock skew clients report in their hellos. The estimate includes
+// the hello's one-way latency, so only skew above a second counts as ahead or behind
+type ClockSkews struct {
+    mu      sync.Mutex
+    stats   ClockSkewStats
+    buckets []uint64
+    sum     float64
+}
+
+// NewClockSkews creates an empty collector
+func NewClockSkews() *ClockSkews {
+    return &ClockSkews{buckets: make([]uint64, len(ClockSkewBuckets))}
+}
+
+// Observe records one client's skew
+func (c *ClockSkews) Observe(skew time.Duration) {
+    abs := skew
+    if abs < 0 {
+        abs = -abs
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.stats.Observed++
+    c.sum += abs.Seconds()
+    for i, bound := range ClockSkewBuckets {
+        if abs.Seconds() <= bound {
+            c.buckets[i]++
+        }
+    }
+    switch {
+    case skew > time.Second:
+        c.stats.Ahead++
+        if skew > c.stats.MaxAhead {
+            c.stats.MaxAhead = skew
+        }
+    case skew < -time.Second:
+        c.stats.Behind++
+        if abs > c.stats.MaxBehind {
+            c.stats.MaxBehind = abs
+        }
+    }
+}
+
+// Stats returns a snapshot
+func (c *ClockSkews) Stats() ClockSkewStats {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.stats
+}
+
+// WriteMetrics writes the skew histogram and extremes in the Prometheus text format
+func (c *ClockSkews) WriteMetrics(w io.Writer) error {
+    c.mu.Lock()
+    stats, sum := c.stats, c.sum
+    buckets := append([]uint64(nil), c.buckets...)
+    c.mu.Unlock()
+
+    const name = "volly_client_clock_skew_seconds"
+    fmt.Fprintf(w, "# HELP %s Absolute skew of client clocks reported in hellos.\n# TYPE %s histogram\n", name, name)
+    for i, bound := range ClockSkewBuckets {
+        fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, bound, buckets[i])
+    }
+    fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, stats.Observed)
+    fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", name, sum, name, stats.Observed)
+    fmt.Fprintf(w, "# HELP volly_client_clock_skewed_total Clients more than a second off, by direction.\n# TYPE volly_client_clock_skewed_total counter\n")
+    fmt.Fprintf(w, "volly_client_clock_skewed_total{direction=\"ahead\"} %d\n", stats.Ahead)
+    fmt.Fprintf(w, "volly_client_clock_skewed_total{direction=\"behind\"} %d\n", stats.Behind)
+    fmt.Fprintf(w, "# HELP volly_client_clock_skew_max_seconds Largest client clock skew seen, by direction.\n# TYPE volly_client_clock_skew_max_seconds gauge\n")
+    fmt.Fprintf(w, "volly_client_clock_skew_max_seconds{direction=\"ahead\"} %g\n", stats.MaxAhead.Seconds())
+    _, err := fmt.Fprintf(w, "volly_client_clock_skew_max_seconds{direction=\"behind\"} %g\n", stats.MaxBehind.Seconds())
+    return err
+}
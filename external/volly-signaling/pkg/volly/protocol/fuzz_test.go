@@ -0,0 +1,58 @@
+package protocol
+
+import (
+    "testing"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/websocket"
+)
+
+// Seeds live in testdata/fuzz; run one target with go test -fuzz=FuzzX
+
+// FuzzDecodeMessage feeds arbitrary text and binary frames through decoding and the schema of
+// every version, as a session does before a client message reaches its handlers. Those read
+// required string fields with unchecked type assertions, so a message that validates must
+// have them
+func FuzzDecodeMessage(f *testing.F) {
+    f.Add(false, []byte(`{"type":"hello","versions":[1,2],"features":["resumption"],"time":1700000000000}`))
+    f.Add(false, []byte(`{"type":"subscribe","types":["hand","grant"]}`))
+    f.Add(false, []byte(`{"type":"key_exchange","algorithm":"ML-KEM-768","publicKey":"AAAA"}`))
+    f.Add(false, []byte(`{"type":"ping","id":"1","extra":{"deep":[[[]]]}}`))
+    if ping, err := websocket.MarshalCBOR(map[string]interface{}{"type": TypePing, "id": "1"}); err == nil {
+        f.Add(true, ping)
+    }
+    f.Fuzz(func(t *testing.T, binary bool, data []byte) {
+        typ := websocket.TextMessage
+        if binary {
+            typ = websocket.BinaryMessage
+        }
+        msg, perr := decode(typ, data)
+        if perr != nil {
+            return
+        }
+        if msg == nil {
+            t.Fatal("nil message without error")
+        }
+        schemas := []Schema{helloSchema}
+        for _, s := range Schemas {
+            schemas = append(schemas, s)
+        }
+        for _, s := range schemas {
+            err := s.Validate(msg)
+            if err != nil {
+                if _, ok := err.(*Error); !ok {
+                    t.Fatalf("Validate returned %T, not *Error", err)
+                }
+                continue
+            }
+            typ, ok := msg["type"].(string)
+            if !ok {
+                t.Fatal("message without a string type validated")
+            }
+            for name, field := range s[typ] {
+                if _, ok := msg[name].(string); field.Required && field.Kind == KindString && !ok {
+                    t.Fatalf("%s message validated without string %s", typ, name)
+                }
+            }
+        }
+    })
+}
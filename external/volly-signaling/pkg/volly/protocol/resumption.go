@@ -0,0 +1,152 @@
+package protocol
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+)
+
+// DefaultTicketTTL is how long a client may resume without a token after its last ticket
+const DefaultTicketTTL = 10 * time.Minute
+
+// Labels domain-separating the secrets and signatures of a session
+const (
+    sessionSecretInfo  = "volly-session-v1"
+    resumeSecretInfo   = "volly-resume-v1"
+    resumeProofContext = "volly-resume-proof-v1"
+    keyExchangeContext = "volly-key-exchange-v1"
+)
+
+var (
+    ErrTicketInvalid = errors.New("resumption ticket is invalid")
+    ErrTicketExpired = errors.New("resumption ticket has expired")
+)
+
+// Principal is who a session acts for
+type Principal struct {
+    Identity  string    `json:"identity"`
+    Room      string    `json:"room"`
+    Tenant    string    `json:"tenant,omitempty"`
+    ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// ticketState is sealed into a ticket; only the server can read it
+type ticketState struct {
+    Principal Principal `json:"principal"`
+    Secret    []byte    `json:"secret"`
+    NotAfter  time.Time `json:"notAfter"`
+}
+
+// Tickets seals resumption tickets. Every instance that may receive a resume must share the key
+type Tickets struct {
+    aead  cipher.AEAD
+    ttl   time.Duration
+    clock clock.Clock
+}
+
+// NewTickets seals with AES-256-GCM under a 32-byte key; ttl of 0 uses DefaultTicketTTL
+func NewTickets(key []byte, ttl time.Duration) (*Tickets, error) {
+    if len(key) != 32 {
+        return nil, errors.New("ticket key must be 32 bytes")
+    }
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, err
+    }
+    aead, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, err
+    }
+    if ttl <= 0 {
+        ttl = DefaultTicketTTL
+    }
+    return &Tickets{aead: aead, ttl: ttl, clock: clock.System}, nil
+}
+
+// SetClock sets the time source for ticket expiry
+func (t *Tickets) SetClock(c clock.Clock) *Tickets {
+    t.clock = c
+    return t
+}
+
+func (t *Tickets) seal(state ticketState) (string, time.Time, error) {
+    state.NotAfter = t.clock.Now().Add(t.ttl)
+    plain, err := json.Marshal(state)
+    if err != nil {
+        return "", time.Time{}, err
+    }
+    nonce := make([]byte, t.aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return "", time.Time{}, err
+    }
+    sealed := t.aead.Seal(nonce, nonce, plain, []byte(resumeSecretInfo))
+    return base64.RawURLEncoding.EncodeToString(sealed), state.NotAfter, nil
+}
+
+func (t *Tickets) open(ticket string) (ticketState, error) {
+    var state ticketState
+    sealed, err := base64.RawURLEncoding.DecodeString(ticket)
+    if err != nil || len(sealed) < t.aead.NonceSize() {
+        return state, ErrTicketInvalid
+    }
+    nonce, ciphertext := sealed[:t.aead.NonceSize()], sealed[t.aead.NonceSize():]
+    plain, err := t.aead.Open(nil, nonce, ciphertext, []byte(resumeSecretInfo))
+    if err != nil {
+        return state, ErrTicketInvalid
+    }
+    if err := json.Unmarshal(plain, &state); err != nil {
+        return state, ErrTicketInvalid
+    }
+    if !t.clock.Now().Before(state.NotAfter) {
+        return state, ErrTicketExpired
+    }
+    return state, nil
+}
+
+// KeyExchangeTranscript is what the server signs, and a client verifies, for one key exchange
+func KeyExchangeTranscript(nonce, publicKey, ciphertext []byte) []byte {
+    msg := make([]byte, 0, len(keyExchangeContext)+len(nonce)+len(publicKey)+len(ciphertext)+3)
+    msg = append(msg, keyExchangeContext...)
+    msg = append(msg, 0)
+    msg = append(msg, nonce...)
+    msg = append(msg, 0)
+    msg = append(msg, publicKey...)
+    msg = append(msg, 0)
+    return append(msg, ciphertext...)
+}
+
+// SessionSecret derives the resumption secret both sides hold after a key exchange
+func SessionSecret(sharedSecret, transcript []byte) []byte {
+    return hkdf(sharedSecret, transcript, sessionSecretInfo)
+}
+
+// NextSecret is the secret a session holds after resuming with nonce
+func NextSecret(secret, nonce []byte) []byte {
+    return hkdf(secret, nonce, resumeSecretInfo)
+}
+
+// ResumeProof proves to the server that a client resuming on nonce holds the ticket's secret
+func ResumeProof(secret, nonce []byte) []byte {
+    mac := hmac.New(sha256.New, secret)
+    mac.Write([]byte(resumeProofContext))
+    mac.Write(nonce)
+    return mac.Sum(nil)
+}
+
+// hkdf is HKDF-SHA256 producing one 32-byte block
+func hkdf(secret, salt []byte, info string) []byte {
+    extract := hmac.New(sha256.New, salt)
+    extract.Write(secret)
+    expand := hmac.New(sha256.New, extract.Sum(nil))
+    expand.Write([]byte(info))
+    expand.Write([]byte{1})
+    return expand.Sum(nil)
+}
@@ -0,0 +1,242 @@
+package protocol
+
+import (
+    "errors"
+    "math"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Error codes sent to clients in error messages
+const (
+    CodeHandshakeRequired  = "handshake_required"
+    CodeUnsupportedVersion = "unsupported_version"
+    CodeMalformed          = "malformed"
+    CodeUnknownType        = "unknown_type"
+    CodeUnknownField       = "unknown_field"
+    CodeMissingField       = "missing_field"
+    CodeWrongType          = "wrong_type"
+    CodeOutOfRange         = "out_of_range"
+    CodeUnauthorized       = "unauthorized"
+    CodeTokenExpired       = "token_expired"
+    CodeUnexpected         = "unexpected_message"
+)
+
+var (
+    ErrInvalidMessage     = errors.New("invalid signaling message")
+    ErrHandshakeRequired  = errors.New("signaling handshake required")
+    ErrUnsupportedVersion = errors.New("no common signaling protocol version")
+    ErrUnauthorized       = errors.New("signaling session is not authorized")
+)
+
+// Error is a rejected client message. It is sent back to the client as an error message and
+// matches ErrHandshakeRequired, ErrUnsupportedVersion, ErrUnauthorized or ErrInvalidMessage by Code
+type Error struct {
+    Code string
+    // Type is the message type, when it could be read
+    Type string
+    // Field is the dotted path of the offending field
+    Field   string
+    Message string
+}
+
+func (e *Error) Error() string {
+    s := e.Code
+    if e.Field != "" {
+        s += " " + e.Field
+    }
+    if e.Message != "" {
+        s += ": " + e.Message
+    }
+    return s
+}
+
+func (e *Error) Unwrap() error {
+    switch e.Code {
+    case CodeHandshakeRequired:
+        return ErrHandshakeRequired
+    case CodeUnsupportedVersion:
+        return ErrUnsupportedVersion
+    case CodeUnauthorized, CodeTokenExpired:
+        return ErrUnauthorized
+    }
+    return ErrInvalidMessage
+}
+
+// Kind is the JSON kind a field must have
+type Kind string
+
+const (
+    KindString  Kind = "string"
+    KindInteger Kind = "integer"
+    KindNumber  Kind = "number"
+    KindBool    Kind = "bool"
+    KindArray   Kind = "array"
+    KindObject  Kind = "object"
+)
+
+// Field describes one field of a message
+type Field struct {
+    Kind     Kind
+    Required bool
+    // Max bounds string length, array length or integer value; zero is unbounded
+    Max int
+    // Min bounds integer value and array length
+    Min int
+    // Enum lists the allowed strings
+    Enum []string
+    // Items describes array elements
+    Items *Field
+    // Fields describes object members; unknown members are rejected
+    Fields map[string]Field
+}
+
+// Schema lists the messages a client may send under one protocol version, by type
+type Schema map[string]map[string]Field
+
+// Validate checks a decoded message strictly: its type must be known and every field must be
+// declared, present when required, and of the declared kind and bounds
+func (s Schema) Validate(msg map[string]interface{}) error {
+    typ, ok := msg["type"].(string)
+    if !ok {
+        return &Error{Code: CodeMissingField, Field: "type", Message: "message has no type"}
+    }
+    fields, ok := s[typ]
+    if !ok {
+        return &Error{Code: CodeUnknownType, Type: typ, Message: "unknown message type"}
+    }
+    if err := validateObject(fields, msg, ""); err != nil {
+        err.Type = typ
+        return err
+    }
+    return nil
+}
+
+func validateObject(fields map[string]Field, obj map[string]interface{}, path string) *Error {
+    // Sorted so the same bad message always gets the same error
+    names := make([]string, 0, len(obj))
+    for name := range obj {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        if path == "" && name == "type" {
+            continue
+        }
+        f, ok := fields[name]
+        if !ok {
+            return &Error{Code: CodeUnknownField, Field: join(path, name), Message: "unknown field"}
+        }
+        if err := validateValue(f, obj[name], join(path, name)); err != nil {
+            return err
+        }
+    }
+
+    required := make([]string, 0, len(fields))
+    for name, f := range fields {
+        if _, ok := obj[name]; f.Required && !ok {
+            required = append(required, name)
+        }
+    }
+    if len(required) > 0 {
+        sort.Strings(required)
+        return &Error{Code: CodeMissingField, Field: join(path, required[0]), Message: "required field is missing"}
+    }
+    return nil
+}
+
+func validateValue(f Field, v interface{}, path string) *Error {
+    wrong := &Error{Code: CodeWrongType, Field: path, Message: "expected " + string(f.Kind)}
+    outOfRange := &Error{Code: CodeOutOfRange, Field: path}
+
+    switch f.Kind {
+    case KindString:
+        s, ok := v.(string)
+        if !ok {
+            return wrong
+        }
+        if f.Max > 0 && len(s) > f.Max {
+            outOfRange.Message = "string is too long"
+            return outOfRange
+        }
+        if len(f.Enum) > 0 && !contains(f.Enum, s) {
+            outOfRange.Message = "expected one of " + strings.Join(f.Enum, ", ")
+            return outOfRange
+        }
+    case KindInteger:
+        n, ok := integer(v)
+        if !ok {
+            return wrong
+        }
+        if n < float64(f.Min) || f.Max > 0 && n > float64(f.Max) {
+            outOfRange.Message = "integer is out of range"
+            return outOfRange
+        }
+    case KindNumber:
+        if _, ok := number(v); !ok {
+            return wrong
+        }
+    case KindBool:
+        if _, ok := v.(bool); !ok {
+            return wrong
+        }
+    case KindArray:
+        items, ok := v.([]interface{})
+        if !ok {
+            return wrong
+        }
+        if len(items) < f.Min || f.Max > 0 && len(items) > f.Max {
+            outOfRange.Message = "array length is out of range"
+            return outOfRange
+        }
+        if f.Items != nil {
+            for i, item := range items {
+                if err := validateValue(*f.Items, item, path+"["+strconv.Itoa(i)+"]"); err != nil {
+                    return err
+                }
+            }
+        }
+    case KindObject:
+        obj, ok := v.(map[string]interface{})
+        if !ok {
+            return wrong
+        }
+        return validateObject(f.Fields, obj, path)
+    }
+    return nil
+}
+
+// number accepts the numeric types both JSON and CBOR decoding produce
+func number(v interface{}) (float64, bool) {
+    switch n := v.(type) {
+    case float64:
+        return n, true
+    case int64:
+        return float64(n), true
+    case uint64:
+        return float64(n), true
+    }
+    return 0, false
+}
+
+func integer(v interface{}) (float64, bool) {
+    n, ok := number(v)
+    return n, ok && n == math.Trunc(n)
+}
+
+func contains(list []string, s string) bool {
+    for _, v := range list {
+        if v == s {
+            return true
+        }
+    }
+    return false
+}
+
+func join(path, name string) string {
+    if path == "" {
+        return name
+    }
+    return path + "." + name
+}
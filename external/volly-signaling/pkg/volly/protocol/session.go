@@ -0,0 +1,789 @@
+// Package protocol versions the signaling messages clients exchange over WebSocket. A client
+// opens with a hello listing the protocol versions and features it supports; the server
+// answers with a welcome naming the highest common version and the features both sides
+// share, or with an error listing the versions it speaks. Every later client message is
+// checked strictly against the negotiated version's schema and rejected with a typed error
+// message, so new versions can add messages without old clients or old servers misreading them.
+//
+// Version 2 adds an ML-KEM key exchange signed by the server, resumption tickets bound to the
+// exchanged secret so a reconnecting client can skip the token and the exchange, and token
+// refresh on a live session, grant push: a new token the server hands a participant
+// whose permissions changed, such as when a host let them publish, and key proofs: a client
+// whose token is bound to a key signs the welcome's nonce with it
+package protocol
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/rand"
+    "encoding/base64"
+    "encoding/json"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+    "github.com/volly-org/volly-signaling/pkg/volly/websocket"
+)
+
+// Protocol versions
+const (
+    Version1 = 1
+    Version2 = 2
+)
+
+// Features a client may ask for in its hello
+const (
+    // FeaturePing answers ping messages with pong
+    FeaturePing = "ping"
+    // FeatureEventFilter lets subscribe messages narrow the event types a stream sends
+    FeatureEventFilter = "event_filter"
+    // FeatureKeyExchange runs an ML-KEM-768 exchange signed by the server (version 2)
+    FeatureKeyExchange = "pq_key_exchange"
+    // FeatureResumption issues tickets after a key exchange and accepts resume (version 2)
+    FeatureResumption = "resumption"
+    // FeatureTokenRefresh accepts refresh with a new token before the current one expires (version 2)
+    FeatureTokenRefresh = "token_refresh"
+    // FeatureGrantPush sends hand and grant messages as a participant's raised hand is decided (version 2)
+    FeatureGrantPush = "grant_push"
+    // FeatureExpiryNotice sends expiring when the participant's grant runs out mid-session
+    FeatureExpiryNotice = "expiry_notice"
+    // FeatureKeyProof accepts key_proof from a client whose token is bound to a key (version 2)
+    FeatureKeyProof = "key_proof"
+)
+
+// featureVersions is the first version each feature needs, where it is not 1
+var featureVersions = map[string]int{
+    FeatureKeyExchange:  Version2,
+    FeatureResumption:   Version2,
+    FeatureTokenRefresh: Version2,
+    FeatureGrantPush:    Version2,
+    FeatureKeyProof:     Version2,
+}
+
+// Message types
+const (
+    TypeHello       = "hello"
+    TypeWelcome     = "welcome"
+    TypeError       = "error"
+    TypePing        = "ping"
+    TypePong        = "pong"
+    TypeSubscribe   = "subscribe"
+    TypeKeyExchange = "key_exchange"
+    TypeTicket      = "ticket"
+    TypeResume      = "resume"
+    TypeResumed     = "resumed"
+    TypeRefresh     = "refresh"
+    TypeRefreshed   = "refreshed"
+    TypeHand        = "hand"
+    TypeGrant       = "grant"
+    TypeExpiring    = "expiring"
+    TypeKeyProof    = "key_proof"
+)
+
+// DefaultHandshakeTimeout is how long a client has to send its hello
+const DefaultHandshakeTimeout = 5 * time.Second
+
+// helloSchema never changes between versions, since it is read before one is chosen
+var helloSchema = Schema{
+    TypeHello: {
+        "versions": {Kind: KindArray, Required: true, Min: 1, Max: 16, Items: &Field{Kind: KindInteger, Min: 1, Max: 1 << 16}},
+        "features": {Kind: KindArray, Max: 32, Items: &Field{Kind: KindString, Max: 64}},
+        "client":   {Kind: KindString, Max: 128},
+        "time":     {Kind: KindInteger},
+    },
+}
+
+var version1Messages = Schema{
+    TypePing: {
+        "id": {Kind: KindString, Max: 64},
+    },
+    TypeSubscribe: {
+        "types": {Kind: KindArray, Required: true, Max: 16, Items: &Field{Kind: KindString, Max: 32}},
+    },
+}
+
+// Schemas are the client messages each version accepts after the handshake
+var Schemas = map[int]Schema{
+    Version1: version1Messages,
+    Version2: {
+        TypePing:      version1Messages[TypePing],
+        TypeSubscribe: version1Messages[TypeSubscribe],
+        TypeKeyExchange: {
+            "algorithm": {Kind: KindString, Required: true, Enum: []string{crypto.AlgorithmMLKEM768}},
+            "publicKey": {Kind: KindString, Required: true, Max: 2048},
+        },
+        TypeResume: {
+            "ticket": {Kind: KindString, Required: true, Max: 4096},
+            "proof":  {Kind: KindString, Required: true, Max: 64},
+        },
+        TypeRefresh: {
+            "token": {Kind: KindString, Required: true, Max: 64 << 10},
+        },
+        TypeKeyProof: {
+            "algorithm": {Kind: KindString, Required: true, Enum: []string{crypto.AlgorithmEd25519, crypto.AlgorithmMLDSA44, crypto.AlgorithmMLDSA65, crypto.AlgorithmMLDSA87}},
+            "publicKey": {Kind: KindString, Required: true, Max: 4096},
+            "signature": {Kind: KindString, Required: true, Max: 8192},
+        },
+    },
+}
+
+// Hello opens a session, listing the versions and features a client supports
+type Hello struct {
+    Type     string   `json:"type"`
+    Versions []int    `json:"versions"`
+    Features []string `json:"features,omitempty"`
+    Client   string   `json:"client,omitempty"`
+    // Time is the client's clock, in Unix milliseconds, as it sent the hello
+    Time int64 `json:"time,omitempty"`
+}
+
+// KeyExchange offers the client's ML-KEM public key
+type KeyExchange struct {
+    Type      string `json:"type"`
+    Algorithm string `json:"algorithm"`
+    PublicKey string `json:"publicKey"`
+}
+
+// Resume reopens a session from a ticket; Proof is ResumeProof over the welcome's nonce
+type Resume struct {
+    Type   string `json:"type"`
+    Ticket string `json:"ticket"`
+    Proof  string `json:"proof"`
+}
+
+// KeyProofMessage proves the client holds the key its token is bound to: Signature is by
+// that key over what the gateway names for the welcome's nonce, identity and room
+type KeyProofMessage struct {
+    Type      string `json:"type"`
+    Algorithm string `json:"algorithm"`
+    PublicKey string `json:"publicKey"`
+    Signature string `json:"signature"`
+}
+
+// KeyProof is a client's key_proof, decoded, with the nonce it answers
+type KeyProof struct {
+    Nonce     []byte
+    Algorithm string
+    PublicKey []byte
+    Signature []byte
+}
+
+// Refresh replaces the session's token before it expires
+type Refresh struct {
+    Type  string `json:"type"`
+    Token string `json:"token"`
+}
+
+// Welcome accepts a client's hello. From version 2 it carries the nonce that key exchanges
+// and resume proofs are bound to
+type Welcome struct {
+    Type     string   `json:"type"`
+    Version  int      `json:"version"`
+    Features []string `json:"features"`
+    Nonce    string   `json:"nonce,omitempty"`
+    // Time is the server's clock, in Unix milliseconds, so clients can time refreshes against
+    // expiries the server set
+    Time int64 `json:"time,omitempty"`
+}
+
+// ErrorMessage reports a rejected client message; Versions is set when no version matched
+type ErrorMessage struct {
+    Type        string `json:"type"`
+    Code        string `json:"code"`
+    MessageType string `json:"messageType,omitempty"`
+    Field       string `json:"field,omitempty"`
+    Message     string `json:"message,omitempty"`
+    Versions    []int  `json:"versions,omitempty"`
+}
+
+// Pong answers a ping, echoing its id
+type Pong struct {
+    Type string `json:"type"`
+    ID   string `json:"id,omitempty"`
+}
+
+// KeyExchangeReply carries the ML-KEM ciphertext for the client's key and, when the server
+// has a signing key, its signature over KeyExchangeTranscript
+type KeyExchangeReply struct {
+    Type               string `json:"type"`
+    Ciphertext         string `json:"ciphertext"`
+    SignatureAlgorithm string `json:"signatureAlgorithm,omitempty"`
+    Signature          string `json:"signature,omitempty"`
+}
+
+// TicketMessage hands the client a ticket to resume with until ExpiresAt
+type TicketMessage struct {
+    Type      string    `json:"type"`
+    Ticket    string    `json:"ticket"`
+    ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Resumed confirms a resume
+type Resumed struct {
+    Type      string    `json:"type"`
+    Identity  string    `json:"identity"`
+    Room      string    `json:"room"`
+    ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Refreshed confirms a refresh; the session now lasts until ExpiresAt
+type Refreshed struct {
+    Type      string    `json:"type"`
+    ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Hand tells a participant what became of the hand they raised
+type Hand struct {
+    Type  string `json:"type"`
+    ID    string `json:"id"`
+    State string `json:"state"`
+}
+
+// Grant hands the participant a new token for the same identity and room, valid until
+// ExpiresAt. The client presents it to the media edge and may refresh the session with it
+type Grant struct {
+    Type      string    `json:"type"`
+    Token     string    `json:"token"`
+    ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Expiring tells a participant their grant ran out at Deadline and what the gateway does
+// about it: disconnect, downgrade to watching only, or a grace period. DisconnectAt is when
+// the media connection ends, and Ended is set once it has
+type Expiring struct {
+    Type         string    `json:"type"`
+    Action       string    `json:"action"`
+    Deadline     time.Time `json:"deadline"`
+    DisconnectAt time.Time `json:"disconnectAt,omitempty"`
+    Ended        bool      `json:"ended,omitempty"`
+}
+
+// TokenVerifier checks a token presented in a refresh
+type TokenVerifier func(ctx context.Context, token string) (Principal, error)
+
+// Server negotiates sessions
+type Server struct {
+    versions   []int
+    features   []string
+    timeout    time.Duration
+    signingKey *crypto.KeyPair
+    tickets    *Tickets
+    verify     TokenVerifier
+    clock      clock.Clock
+    skews      *ClockSkews
+    nonces     func() ([]byte, error)
+}
+
+// NewServer speaks every version in Schemas and offers every feature it is configured for;
+// resumption needs SetTickets, token refresh SetTokenVerifier and key proofs SetNonces
+func NewServer() *Server {
+    s := &Server{
+        features: []string{FeaturePing, FeatureEventFilter, FeatureKeyExchange, FeatureResumption, FeatureTokenRefresh, FeatureGrantPush, FeatureExpiryNotice, FeatureKeyProof},
+        timeout:  DefaultHandshakeTimeout,
+        clock:    clock.System,
+    }
+    for v := range Schemas {
+        s.versions = append(s.versions, v)
+    }
+    return s
+}
+
+// SetVersions limits the versions offered, for example to retire an old one
+func (s *Server) SetVersions(versions ...int) *Server {
+    s.versions = versions
+    return s
+}
+
+// SetFeatures limits the features offered
+func (s *Server) SetFeatures(features ...string) *Server {
+    s.features = features
+    return s
+}
+
+// SetHandshakeTimeout bounds the wait for a client's hello
+func (s *Server) SetHandshakeTimeout(d time.Duration) *Server {
+    s.timeout = d
+    return s
+}
+
+// SetSigningKey signs every key exchange so clients can tell they reached this server
+func (s *Server) SetSigningKey(key *crypto.KeyPair) *Server {
+    s.signingKey = key
+    return s
+}
+
+// SetTickets enables resumption
+func (s *Server) SetTickets(t *Tickets) *Server {
+    s.tickets = t
+    return s
+}
+
+// SetTokenVerifier enables token refresh
+func (s *Server) SetTokenVerifier(v TokenVerifier) *Server {
+    s.verify = v
+    return s
+}
+
+// SetNonces enables key proofs, taking each welcome's nonce from next so whoever checks the
+// proofs knows the challenges it issued
+func (s *Server) SetNonces(next func() ([]byte, error)) *Server {
+    s.nonces = next
+    return s
+}
+
+// SetClock sets the time source for token expiry
+func (s *Server) SetClock(c clock.Clock) *Server {
+    s.clock = c
+    return s
+}
+
+// SetClockSkews records the clock skew of every client whose hello carries its time
+func (s *Server) SetClockSkews(c *ClockSkews) *Server {
+    s.skews = c
+    return s
+}
+
+// Accept reads the client's hello and answers it. When the handshake fails the client has
+// been sent an error message and the returned error is an *Error, or the read error. ctx is
+// passed to the token verifier for the life of the session
+func (s *Server) Accept(ctx context.Context, conn *websocket.Conn) (*Session, error) {
+    if s.timeout > 0 {
+        conn.SetReadDeadline(time.Now().Add(s.timeout))
+    }
+    typ, data, err := conn.ReadMessage()
+    received := s.clock.Now()
+    conn.SetReadDeadline(time.Time{})
+    if err != nil {
+        return nil, err
+    }
+
+    msg, perr := decode(typ, data)
+    if perr == nil && msg["type"] != TypeHello {
+        perr = &Error{Code: CodeHandshakeRequired, Message: "the first message must be a hello"}
+    }
+    if perr == nil {
+        if err := helloSchema.Validate(msg); err != nil {
+            perr = err.(*Error)
+        }
+    }
+    if perr != nil {
+        sendError(conn, perr, nil)
+        return nil, perr
+    }
+
+    version := s.negotiate(msg["versions"].([]interface{}))
+    if version == 0 {
+        perr := &Error{Code: CodeUnsupportedVersion, Type: TypeHello, Field: "versions", Message: "no common protocol version"}
+        sendError(conn, perr, s.versions)
+        return nil, perr
+    }
+    session := &Session{
+        ctx:      ctx,
+        server:   s,
+        conn:     conn,
+        version:  version,
+        schema:   Schemas[version],
+        features: make(map[string]bool),
+        expired:  make(chan struct{}),
+    }
+    if at, ok := integer(msg["time"]); ok {
+        session.skew = time.UnixMilli(int64(at)).Sub(received)
+        session.hasSkew = true
+        if s.skews != nil {
+            s.skews.Observe(session.skew)
+        }
+    }
+    welcome := Welcome{Type: TypeWelcome, Version: version, Features: []string{}, Time: s.clock.Now().UnixMilli()}
+    if version >= Version2 {
+        if err := session.newNonce(); err != nil {
+            return nil, err
+        }
+        welcome.Nonce = base64.StdEncoding.EncodeToString(session.nonce)
+    }
+    offered, _ := msg["features"].([]interface{})
+    for _, f := range s.features {
+        if !s.offers(f, version) {
+            continue
+        }
+        for _, o := range offered {
+            if o == f {
+                session.features[f] = true
+                welcome.Features = append(welcome.Features, f)
+                break
+            }
+        }
+    }
+    if err := conn.Send(welcome); err != nil {
+        return nil, err
+    }
+    return session, nil
+}
+
+// negotiate picks the highest version both sides speak, or 0
+func (s *Server) negotiate(offered []interface{}) int {
+    best := 0
+    for _, o := range offered {
+        v, _ := integer(o)
+        for _, supported := range s.versions {
+            if int(v) == supported && supported > best {
+                if _, ok := Schemas[supported]; ok {
+                    best = supported
+                }
+            }
+        }
+    }
+    return best
+}
+
+// offers reports whether a feature exists in version and is configured on this server
+func (s *Server) offers(feature string, version int) bool {
+    if version < featureVersions[feature] {
+        return false
+    }
+    switch feature {
+    case FeatureResumption:
+        return s.tickets != nil
+    case FeatureTokenRefresh:
+        return s.verify != nil
+    case FeatureKeyProof:
+        return s.nonces != nil
+    }
+    return true
+}
+
+// Session is a connection that completed the handshake. Key exchange, resume and refresh are
+// handled inside it; Read hands the caller only the messages it has to act on
+type Session struct {
+    ctx      context.Context
+    server   *Server
+    conn     *websocket.Conn
+    version  int
+    schema   Schema
+    features map[string]bool
+    nonce    []byte
+    skew     time.Duration
+    hasSkew  bool
+
+    mu        sync.Mutex
+    principal Principal
+    secret    []byte
+    timer     *time.Timer
+    expired   chan struct{}
+    closeOnce sync.Once
+}
+
+// Version is the negotiated protocol version
+func (s *Session) Version() int { return s.version }
+
+// Has reports whether a feature was negotiated
+func (s *Session) Has(feature string) bool { return s.features[feature] }
+
+// ClockSkew is how far the client's clock ran ahead of the server's when it sent its hello,
+// latency included; ok is false when the hello did not carry the client's time
+func (s *Session) ClockSkew() (skew time.Duration, ok bool) { return s.skew, s.hasSkew }
+
+// Conn is the underlying connection
+func (s *Session) Conn() *websocket.Conn { return s.conn }
+
+// Principal is who the session acts for, once authenticated or resumed
+func (s *Session) Principal() Principal {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.principal
+}
+
+// Expired is closed when the principal's token expires without a refresh
+func (s *Session) Expired() <-chan struct{} { return s.expired }
+
+// Authenticate binds the session to a principal checked by the caller, such as from the
+// token the connection was opened with
+func (s *Session) Authenticate(p Principal) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.setPrincipal(p)
+}
+
+// ReadKeyProof reads the client's key_proof, which must be its next message, for the caller
+// to check against the key the session's token is bound to
+func (s *Session) ReadKeyProof() (KeyProof, error) {
+    if !s.Has(FeatureKeyProof) {
+        perr := &Error{Code: CodeUnauthorized, Message: "the token is bound to a key and needs a key proof"}
+        s.SendError(perr)
+        return KeyProof{}, perr
+    }
+    msg, err := s.readValid()
+    if err != nil {
+        return KeyProof{}, err
+    }
+    if msg["type"] != TypeKeyProof {
+        perr := &Error{Code: CodeUnauthorized, Type: msg["type"].(string), Message: "a key proof is required"}
+        s.SendError(perr)
+        return KeyProof{}, perr
+    }
+    publicKey, err := base64.StdEncoding.DecodeString(msg["publicKey"].(string))
+    if err != nil {
+        perr := &Error{Code: CodeMalformed, Type: TypeKeyProof, Field: "publicKey", Message: "publicKey must be base64"}
+        s.SendError(perr)
+        return KeyProof{}, perr
+    }
+    signature, err := base64.StdEncoding.DecodeString(msg["signature"].(string))
+    if err != nil {
+        perr := &Error{Code: CodeMalformed, Type: TypeKeyProof, Field: "signature", Message: "signature must be base64"}
+        s.SendError(perr)
+        return KeyProof{}, perr
+    }
+    return KeyProof{Nonce: s.nonce, Algorithm: msg["algorithm"].(string), PublicKey: publicKey, Signature: signature}, nil
+}
+
+// Resume authenticates a session opened without a token from the client's resume message
+func (s *Session) Resume() (Principal, error) {
+    if !s.Has(FeatureResumption) {
+        perr := &Error{Code: CodeUnauthorized, Message: "a token is required"}
+        s.SendError(perr)
+        return Principal{}, perr
+    }
+    msg, err := s.readValid()
+    if err != nil {
+        return Principal{}, err
+    }
+    if msg["type"] != TypeResume {
+        perr := &Error{Code: CodeUnauthorized, Type: msg["type"].(string), Message: "a token or resume is required"}
+        s.SendError(perr)
+        return Principal{}, perr
+    }
+
+    state, err := s.server.tickets.open(msg["ticket"].(string))
+    if err != nil {
+        perr := &Error{Code: CodeUnauthorized, Type: TypeResume, Field: "ticket", Message: err.Error()}
+        s.SendError(perr)
+        return Principal{}, perr
+    }
+    proof, err := base64.StdEncoding.DecodeString(msg["proof"].(string))
+    if err != nil || !hmac.Equal(proof, ResumeProof(state.Secret, s.nonce)) {
+        perr := &Error{Code: CodeUnauthorized, Type: TypeResume, Field: "proof", Message: "resume proof does not match the ticket"}
+        s.SendError(perr)
+        return Principal{}, perr
+    }
+    if !s.server.clock.Now().Before(state.Principal.ExpiresAt) {
+        perr := &Error{Code: CodeTokenExpired, Type: TypeResume, Message: "the session's token has expired"}
+        s.SendError(perr)
+        return Principal{}, perr
+    }
+
+    s.mu.Lock()
+    s.secret = NextSecret(state.Secret, s.nonce)
+    s.setPrincipal(state.Principal)
+    s.mu.Unlock()
+    p := state.Principal
+    if err := s.Send(Resumed{Type: TypeResumed, Identity: p.Identity, Room: p.Room, ExpiresAt: p.ExpiresAt}); err != nil {
+        return Principal{}, err
+    }
+    return p, s.issueTicket()
+}
+
+// newNonce draws the welcome's nonce, from the server's nonces when key proofs are on
+func (s *Session) newNonce() error {
+    if s.server.nonces != nil {
+        nonce, err := s.server.nonces()
+        if err != nil {
+            return err
+        }
+        s.nonce = nonce
+        return nil
+    }
+    s.nonce = make([]byte, 32)
+    _, err := rand.Read(s.nonce)
+    return err
+}
+
+// Read returns the next message for the caller. An invalid message is answered with an error
+// message and returned as *Error, after which the session is still usable; any other error
+// means the connection is gone
+func (s *Session) Read() (map[string]interface{}, error) {
+    for {
+        msg, err := s.readValid()
+        if err != nil {
+            return nil, err
+        }
+        typ := msg["type"].(string)
+        switch typ {
+        case TypeKeyExchange, TypeRefresh, TypeResume, TypeKeyProof:
+        default:
+            return msg, nil
+        }
+
+        switch {
+        case typ == TypeKeyExchange && s.Has(FeatureKeyExchange):
+            err = s.keyExchange(msg)
+        case typ == TypeRefresh && s.Has(FeatureTokenRefresh):
+            err = s.refresh(msg)
+        default:
+            perr := &Error{Code: CodeUnexpected, Type: typ, Message: "message is not expected now or its feature was not negotiated"}
+            s.SendError(perr)
+            err = perr
+        }
+        if err != nil {
+            return nil, err
+        }
+    }
+}
+
+// Send writes a server message in the connection's frame format
+func (s *Session) Send(v interface{}) error {
+    return s.conn.Send(v)
+}
+
+// SendError tells the client a message was rejected
+func (s *Session) SendError(err *Error) {
+    sendError(s.conn, err, nil)
+}
+
+func (s *Session) readValid() (map[string]interface{}, error) {
+    typ, data, err := s.conn.ReadMessage()
+    if err != nil {
+        return nil, err
+    }
+    msg, perr := decode(typ, data)
+    if perr == nil {
+        if err := s.schema.Validate(msg); err != nil {
+            perr = err.(*Error)
+        }
+    }
+    if perr != nil {
+        s.SendError(perr)
+        return nil, perr
+    }
+    return msg, nil
+}
+
+// keyExchange encapsulates to the client's key and keeps the derived session secret
+func (s *Session) keyExchange(msg map[string]interface{}) error {
+    invalid := &Error{Code: CodeOutOfRange, Type: TypeKeyExchange, Field: "publicKey", Message: "not a valid ML-KEM-768 public key"}
+    publicKey, err := base64.StdEncoding.DecodeString(msg["publicKey"].(string))
+    if err != nil {
+        s.SendError(invalid)
+        return invalid
+    }
+    ciphertext, shared, err := crypto.Encapsulate(publicKey)
+    if err != nil {
+        s.SendError(invalid)
+        return invalid
+    }
+
+    transcript := KeyExchangeTranscript(s.nonce, publicKey, ciphertext)
+    reply := KeyExchangeReply{Type: TypeKeyExchange, Ciphertext: base64.StdEncoding.EncodeToString(ciphertext)}
+    if key := s.server.signingKey; key != nil {
+        signature, err := crypto.Sign(key.Algorithm, key.PrivateKey, transcript)
+        if err != nil {
+            return err
+        }
+        reply.SignatureAlgorithm = key.Algorithm
+        reply.Signature = base64.StdEncoding.EncodeToString(signature)
+    }
+
+    s.mu.Lock()
+    s.secret = SessionSecret(shared, transcript)
+    s.mu.Unlock()
+    if err := s.Send(reply); err != nil {
+        return err
+    }
+    return s.issueTicket()
+}
+
+// refresh swaps in a new token for the same participant
+func (s *Session) refresh(msg map[string]interface{}) error {
+    p, err := s.server.verify(s.ctx, msg["token"].(string))
+    if err != nil {
+        perr := &Error{Code: CodeUnauthorized, Type: TypeRefresh, Field: "token", Message: err.Error()}
+        s.SendError(perr)
+        return perr
+    }
+
+    s.mu.Lock()
+    current := s.principal
+    if p.Identity != current.Identity || p.Room != current.Room || p.Tenant != current.Tenant {
+        s.mu.Unlock()
+        perr := &Error{Code: CodeUnauthorized, Type: TypeRefresh, Field: "token", Message: "token is for a different participant"}
+        s.SendError(perr)
+        return perr
+    }
+    select {
+    case <-s.expired:
+        s.mu.Unlock()
+        perr := &Error{Code: CodeTokenExpired, Type: TypeRefresh, Message: "the session's token has already expired"}
+        s.SendError(perr)
+        return perr
+    default:
+    }
+    s.setPrincipal(p)
+    s.mu.Unlock()
+
+    if err := s.Send(Refreshed{Type: TypeRefreshed, ExpiresAt: p.ExpiresAt}); err != nil {
+        return err
+    }
+    return s.issueTicket()
+}
+
+// issueTicket sends a ticket for the current principal and secret, when resumption is on
+func (s *Session) issueTicket() error {
+    if !s.Has(FeatureResumption) {
+        return nil
+    }
+    s.mu.Lock()
+    state := ticketState{Principal: s.principal, Secret: s.secret}
+    s.mu.Unlock()
+    if state.Secret == nil || state.Principal.Identity == "" {
+        return nil
+    }
+
+    ticket, expiresAt, err := s.server.tickets.seal(state)
+    if err != nil {
+        return err
+    }
+    return s.Send(TicketMessage{Type: TypeTicket, Ticket: ticket, ExpiresAt: expiresAt})
+}
+
+// setPrincipal rearms the expiry timer; the caller holds mu
+func (s *Session) setPrincipal(p Principal) {
+    s.principal = p
+    if s.timer != nil {
+        s.timer.Stop()
+    }
+    if p.ExpiresAt.IsZero() {
+        return
+    }
+    s.timer = time.AfterFunc(p.ExpiresAt.Sub(s.server.clock.Now()), func() {
+        s.closeOnce.Do(func() { close(s.expired) })
+    })
+}
+
+// decode reads a JSON text or CBOR binary message, which must be an object
+func decode(typ websocket.MessageType, data []byte) (map[string]interface{}, *Error) {
+    var v interface{}
+    var err error
+    if typ == websocket.BinaryMessage {
+        v, err = websocket.UnmarshalCBOR(data)
+    } else {
+        err = json.Unmarshal(data, &v)
+    }
+    if err != nil {
+        return nil, &Error{Code: CodeMalformed, Message: "message could not be decoded"}
+    }
+    msg, ok := v.(map[string]interface{})
+    if !ok {
+        return nil, &Error{Code: CodeMalformed, Message: "message must be an object"}
+    }
+    return msg, nil
+}
+
+func sendError(conn *websocket.Conn, err *Error, versions []int) {
+    conn.Send(ErrorMessage{
+        Type:        TypeError,
+        Code:        err.Code,
+        MessageType: err.Type,
+        Field:       err.Field,
+        Message:     err.Message,
+        Versions:    versions,
+    })
+}
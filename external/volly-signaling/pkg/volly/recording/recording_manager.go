@@ -0,0 +1,281 @@
+package recording
+
+import (
+    "context"
+    "encoding/base64"
+    "errors"
+    "net/url"
+    "sync"
+    "time"
+
+    "github.com/livekit/protocol/livekit"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/events"
+)
+
+// Recording lifecycle event types
+const (
+    EventRecordingStarted = "recording_started"
+    EventRecordingStopped = "recording_stopped"
+    EventRecordingFailed  = "recording_failed"
+)
+
+var (
+    ErrAlreadyRecording = errors.New("room is already being recorded")
+    ErrNotRecording     = errors.New("room is not being recorded")
+    ErrEscrowRequired   = errors.New("key escrow is required for E2EE recordings")
+    ErrTemplateRequired = errors.New("E2EE recordings need a recording template that decrypts media")
+)
+
+// KeysParam is the template URL query parameter carrying the escrow-wrapped media keys,
+// base64url encoded. The egress API has no encryption options of its own, and the template
+// is what joins the room and decrypts it
+const KeysParam = "volly_keys"
+
+// State is the lifecycle state of a room recording
+type State string
+
+const (
+    StateStarting State = "starting"
+    StateActive   State = "active"
+    StateStopping State = "stopping"
+    StateStopped  State = "stopped"
+    StateFailed   State = "failed"
+)
+
+// EgressClient is the subset of the LiveKit egress API used for recordings
+type EgressClient interface {
+    StartRoomCompositeEgress(ctx context.Context, req *livekit.RoomCompositeEgressRequest) (*livekit.EgressInfo, error)
+    StopEgress(ctx context.Context, req *livekit.StopEgressRequest) (*livekit.EgressInfo, error)
+}
+
+// KeyEscrow releases a room's E2EE media keys wrapped to a recorder's ML-KEM-768 public key
+type KeyEscrow interface {
+    WrapMediaKeys(ctx context.Context, room string, recorderPublicKey []byte) ([]byte, error)
+}
+
+// StartOptions configures a room recording
+type StartOptions struct {
+    Layout    string
+    Filepath  string
+    AudioOnly bool
+
+    // E2EE rooms need the recorder's public key so escrow can wrap media keys to it, and a
+    // recording template that unwraps them with the recorder's private key
+    E2EE              bool
+    RecorderPublicKey []byte
+    TemplateURL       string
+}
+
+// Recording tracks the state of a single room recording
+type Recording struct {
+    Room        string
+    EgressID    string
+    State       State
+    E2EE        bool
+    WrappedKeys []byte
+    Error       string
+    StartedAt   time.Time
+    EndedAt     time.Time
+}
+
+// RecordingManager orchestrates LiveKit egress and tracks recording state per room
+type RecordingManager struct {
+    egress EgressClient
+    escrow KeyEscrow
+    bus    events.Bus
+
+    mu         sync.Mutex
+    recordings map[string]*Recording
+}
+
+// NewRecordingManager creates a manager; escrow may be nil when E2EE rooms are not recorded
+func NewRecordingManager(egress EgressClient, escrow KeyEscrow, bus events.Bus) *RecordingManager {
+    return &RecordingManager{
+        egress:     egress,
+        escrow:     escrow,
+        bus:        bus,
+        recordings: make(map[string]*Recording),
+    }
+}
+
+// Start begins recording a room
+func (m *RecordingManager) Start(ctx context.Context, room string, opts StartOptions) (*Recording, error) {
+    if opts.E2EE && m.escrow == nil {
+        return nil, ErrEscrowRequired
+    }
+    if opts.E2EE && opts.TemplateURL == "" {
+        return nil, ErrTemplateRequired
+    }
+
+    m.mu.Lock()
+    if r, ok := m.recordings[room]; ok && r.isRunning() {
+        m.mu.Unlock()
+        return nil, ErrAlreadyRecording
+    }
+    rec := &Recording{
+        Room:      room,
+        State:     StateStarting,
+        E2EE:      opts.E2EE,
+        StartedAt: time.Now(),
+    }
+    m.recordings[room] = rec
+    m.mu.Unlock()
+
+    req := &livekit.RoomCompositeEgressRequest{
+        RoomName:      room,
+        Layout:        opts.Layout,
+        AudioOnly:     opts.AudioOnly,
+        CustomBaseUrl: opts.TemplateURL,
+    }
+    // Fetch escrow-wrapped media keys before egress joins so it can decrypt from the first frame
+    if opts.E2EE {
+        wrapped, err := m.escrow.WrapMediaKeys(ctx, room, opts.RecorderPublicKey)
+        if err == nil {
+            req.CustomBaseUrl, err = withKeys(opts.TemplateURL, wrapped)
+        }
+        if err != nil {
+            m.fail(ctx, rec, err)
+            return nil, err
+        }
+        m.mu.Lock()
+        rec.WrappedKeys = wrapped
+        m.mu.Unlock()
+    }
+    if opts.Filepath != "" {
+        req.FileOutputs = []*livekit.EncodedFileOutput{{Filepath: opts.Filepath}}
+    }
+
+    info, err := m.egress.StartRoomCompositeEgress(ctx, req)
+    if err != nil {
+        m.fail(ctx, rec, err)
+        return nil, err
+    }
+
+    m.mu.Lock()
+    rec.EgressID = info.EgressId
+    rec.State = StateActive
+    snapshot := *rec
+    m.mu.Unlock()
+
+    m.emit(ctx, EventRecordingStarted, &snapshot)
+    return &snapshot, nil
+}
+
+// Stop ends the active recording for a room
+func (m *RecordingManager) Stop(ctx context.Context, room string) (*Recording, error) {
+    m.mu.Lock()
+    rec, ok := m.recordings[room]
+    if !ok || !rec.isRunning() || rec.EgressID == "" {
+        m.mu.Unlock()
+        return nil, ErrNotRecording
+    }
+    rec.State = StateStopping
+    egressID := rec.EgressID
+    m.mu.Unlock()
+
+    if _, err := m.egress.StopEgress(ctx, &livekit.StopEgressRequest{EgressId: egressID}); err != nil {
+        m.fail(ctx, rec, err)
+        return nil, err
+    }
+
+    m.mu.Lock()
+    rec.State = StateStopped
+    rec.EndedAt = time.Now()
+    // Media keys are only needed while egress is running
+    rec.WrappedKeys = nil
+    snapshot := *rec
+    m.mu.Unlock()
+
+    m.emit(ctx, EventRecordingStopped, &snapshot)
+    return &snapshot, nil
+}
+
+// Get returns a copy of the latest recording state for a room
+func (m *RecordingManager) Get(room string) (*Recording, bool) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    rec, ok := m.recordings[room]
+    if !ok {
+        return nil, false
+    }
+    snapshot := *rec
+    return &snapshot, true
+}
+
+// HandleEgressUpdate reconciles state from egress webhooks so recordings ended by LiveKit are tracked
+func (m *RecordingManager) HandleEgressUpdate(ctx context.Context, info *livekit.EgressInfo) {
+    m.mu.Lock()
+    rec, ok := m.recordings[info.RoomName]
+    if !ok || rec.EgressID != info.EgressId || !rec.isRunning() {
+        m.mu.Unlock()
+        return
+    }
+
+    var eventType string
+    switch info.Status {
+    case livekit.EgressStatus_EGRESS_COMPLETE, livekit.EgressStatus_EGRESS_ABORTED, livekit.EgressStatus_EGRESS_LIMIT_REACHED:
+        rec.State = StateStopped
+        eventType = EventRecordingStopped
+    case livekit.EgressStatus_EGRESS_FAILED:
+        rec.State = StateFailed
+        rec.Error = info.Error
+        eventType = EventRecordingFailed
+    default:
+        m.mu.Unlock()
+        return
+    }
+    rec.EndedAt = time.Now()
+    rec.WrappedKeys = nil
+    snapshot := *rec
+    m.mu.Unlock()
+
+    m.emit(ctx, eventType, &snapshot)
+}
+
+func (m *RecordingManager) fail(ctx context.Context, rec *Recording, err error) {
+    m.mu.Lock()
+    rec.State = StateFailed
+    rec.Error = err.Error()
+    rec.EndedAt = time.Now()
+    rec.WrappedKeys = nil
+    snapshot := *rec
+    m.mu.Unlock()
+
+    m.emit(ctx, EventRecordingFailed, &snapshot)
+}
+
+func (m *RecordingManager) emit(ctx context.Context, eventType string, rec *Recording) {
+    if m.bus == nil {
+        return
+    }
+    data := map[string]string{
+        "egressId": rec.EgressID,
+        "state":    string(rec.State),
+    }
+    if rec.Error != "" {
+        data["error"] = rec.Error
+    }
+    _ = m.bus.Publish(ctx, events.Event{
+        Type: eventType,
+        Room: rec.Room,
+        Data: data,
+    })
+}
+
+// withKeys adds wrapped media keys to the template URL egress loads
+func withKeys(templateURL string, wrapped []byte) (string, error) {
+    u, err := url.Parse(templateURL)
+    if err != nil {
+        return "", err
+    }
+    q := u.Query()
+    q.Set(KeysParam, base64.RawURLEncoding.EncodeToString(wrapped))
+    u.RawQuery = q.Encode()
+    return u.String(), nil
+}
+
+func (r *Recording) isRunning() bool {
+    return r.State == StateStarting || r.State == StateActive || r.State == StateStopping
+}
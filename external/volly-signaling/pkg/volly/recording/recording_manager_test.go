@@ -0,0 +1,66 @@
+package recording
+
+import (
+    "bytes"
+    "context"
+    "encoding/base64"
+    "errors"
+    "net/url"
+    "testing"
+
+    "github.com/livekit/protocol/livekit"
+)
+
+// recordingEgress keeps the requests it is asked to start
+type recordingEgress struct {
+    started []*livekit.RoomCompositeEgressRequest
+}
+
+func (e *recordingEgress) StartRoomCompositeEgress(ctx context.Context, req *livekit.RoomCompositeEgressRequest) (*livekit.EgressInfo, error) {
+    e.started = append(e.started, req)
+    return &livekit.EgressInfo{EgressId: "EG_1", RoomName: req.RoomName}, nil
+}
+
+func (e *recordingEgress) StopEgress(ctx context.Context, req *livekit.StopEgressRequest) (*livekit.EgressInfo, error) {
+    return &livekit.EgressInfo{EgressId: req.EgressId}, nil
+}
+
+type fixedEscrow []byte
+
+func (k fixedEscrow) WrapMediaKeys(ctx context.Context, room string, recorderPublicKey []byte) ([]byte, error) {
+    return k, nil
+}
+
+// TestStartSendsWrappedKeys starts an E2EE recording and checks the egress request hands the
+// escrow-wrapped media keys to the recording template, and that one without a template is
+// refused before escrow releases anything
+func TestStartSendsWrappedKeys(t *testing.T) {
+    ctx := context.Background()
+    wrapped := fixedEscrow{0xde, 0xad, 0xbe, 0xef}
+    egress := &recordingEgress{}
+    m := NewRecordingManager(egress, wrapped, nil)
+
+    if _, err := m.Start(ctx, "r", StartOptions{E2EE: true, RecorderPublicKey: []byte("pk")}); !errors.Is(err, ErrTemplateRequired) {
+        t.Fatalf("E2EE recording without a template: got %v, want ErrTemplateRequired", err)
+    }
+    if _, err := m.Start(ctx, "r", StartOptions{E2EE: true, RecorderPublicKey: []byte("pk"), TemplateURL: "https://recorder.example/e2ee?theme=dark"}); err != nil {
+        t.Fatal(err)
+    }
+    if len(egress.started) != 1 {
+        t.Fatalf("%d egress requests, want 1", len(egress.started))
+    }
+    u, err := url.Parse(egress.started[0].CustomBaseUrl)
+    if err != nil {
+        t.Fatal(err)
+    }
+    got, err := base64.RawURLEncoding.DecodeString(u.Query().Get(KeysParam))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(got, wrapped) {
+        t.Fatalf("egress request carries keys %x, want the wrapped %x", got, []byte(wrapped))
+    }
+    if u.Host != "recorder.example" || u.Query().Get("theme") != "dark" {
+        t.Fatalf("template URL %s lost its own host or query", u)
+    }
+}
@@ -0,0 +1,222 @@
+// Package redact strips personal data from what Volly hands to third parties, such as a log
+// vendor: identities, client addresses and free-form metadata are kept, hashed, masked or
+// dropped as a Policy says
+package redact
+
+import (
+    "bytes"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "net/netip"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/audit"
+)
+
+// Actions a policy applies to a class of data
+const (
+    // Keep leaves the value alone
+    Keep = "keep"
+    // Hash replaces the value with a keyed hash, so one value keeps one stand-in
+    Hash = "hash"
+    // Mask keeps a coarse part: an identity's first character, an address's network
+    Mask = "mask"
+    // Drop removes the value
+    Drop = "drop"
+)
+
+var ErrUnknownAction = errors.New("unknown redaction action")
+
+// Policy says what happens to each class of data; empty fields keep it
+type Policy struct {
+    Identities string `json:"identities,omitempty"`
+    Addresses  string `json:"addresses,omitempty"`
+    // Metadata covers every other audit detail value
+    Metadata string `json:"metadata,omitempty"`
+    // Fields overrides the action for detail values by key, e.g. {"credential": "keep"}
+    Fields map[string]string `json:"fields,omitempty"`
+}
+
+// identityFields and addressFields classify audit detail values by key
+var (
+    identityFields = map[string]bool{"identity": true, "subject": true, "user": true, "userName": true, "email": true, "nameID": true}
+    addressFields  = map[string]bool{"remoteAddr": true, "remoteIP": true, "ip": true, "address": true}
+)
+
+// Redactor applies a policy. Safe for concurrent use
+type Redactor struct {
+    policy Policy
+    key    []byte
+}
+
+// New creates a redactor for policy; key is the hash key, which instances must share for
+// their hashes to match
+func New(policy Policy, key []byte) (*Redactor, error) {
+    actions := []string{policy.Identities, policy.Addresses, policy.Metadata}
+    for _, a := range policy.Fields {
+        actions = append(actions, a)
+    }
+    for _, a := range actions {
+        switch a {
+        case "", Keep, Hash, Mask, Drop:
+        default:
+            return nil, fmt.Errorf("%w: %q", ErrUnknownAction, a)
+        }
+    }
+    return &Redactor{policy: policy, key: key}, nil
+}
+
+// Identity redacts an identity per the policy
+func (r *Redactor) Identity(v string) string {
+    return r.apply(r.policy.Identities, v, maskIdentity)
+}
+
+// Address redacts an IP address, with or without a port, per the policy
+func (r *Redactor) Address(v string) string {
+    return r.apply(r.policy.Addresses, v, maskAddress)
+}
+
+// Field redacts an audit detail value by its key
+func (r *Redactor) Field(key, v string) string {
+    action, ok := r.policy.Fields[key]
+    switch {
+    case ok:
+        return r.apply(action, v, maskIdentity)
+    case identityFields[key]:
+        return r.Identity(v)
+    case addressFields[key]:
+        return r.Address(v)
+    }
+    return r.apply(r.policy.Metadata, v, func(string) string { return "***" })
+}
+
+// Entry returns a copy of e with its actor, target and detail redacted. Actors and targets
+// are redacted as identities, since either can be one
+func (r *Redactor) Entry(e audit.Entry) audit.Entry {
+    e.Actor = r.Identity(e.Actor)
+    e.Target = r.Identity(e.Target)
+    if len(e.Detail) > 0 {
+        detail := make(map[string]string, len(e.Detail))
+        for k, v := range e.Detail {
+            if v = r.Field(k, v); v != "" {
+                detail[k] = v
+            }
+        }
+        e.Detail = detail
+    }
+    return e
+}
+
+func (r *Redactor) apply(action, v string, mask func(string) string) string {
+    if v == "" {
+        return v
+    }
+    switch action {
+    case Hash:
+        mac := hmac.New(sha256.New, r.key)
+        mac.Write([]byte(v))
+        return "h-" + hex.EncodeToString(mac.Sum(nil)[:8])
+    case Mask:
+        return mask(v)
+    case Drop:
+        return ""
+    }
+    return v
+}
+
+func maskIdentity(v string) string {
+    for _, c := range v {
+        return string(c) + "***"
+    }
+    return v
+}
+
+// maskAddress keeps the /24 of an IPv4 address and the /48 of an IPv6 one, and the port
+func maskAddress(v string) string {
+    if ap, err := netip.ParseAddrPort(v); err == nil {
+        return netip.AddrPortFrom(maskAddr(ap.Addr()), ap.Port()).String()
+    }
+    if a, err := netip.ParseAddr(v); err == nil {
+        return maskAddr(a).String()
+    }
+    return "***"
+}
+
+func maskAddr(a netip.Addr) netip.Addr {
+    bits := 48
+    if a.Is4() || a.Is4In6() {
+        a, bits = a.Unmap(), 24
+    }
+    p, _ := a.WithZone("").Prefix(bits)
+    return p.Addr()
+}
+
+// Writer redacts the IP addresses in everything written through it, for log output; the
+// log package writes one line per call
+func (r *Redactor) Writer(w io.Writer) io.Writer {
+    if r.policy.Addresses == "" || r.policy.Addresses == Keep {
+        return w
+    }
+    return &writer{r: r, w: w}
+}
+
+type writer struct {
+    r *Redactor
+    w io.Writer
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+    if _, err := w.w.Write(w.r.addresses(p)); err != nil {
+        return 0, err
+    }
+    return len(p), nil
+}
+
+// addresses replaces every run of address characters in line that parses as an address
+func (r *Redactor) addresses(line []byte) []byte {
+    var out bytes.Buffer
+    for i := 0; i < len(line); {
+        if !addressByte(line[i]) {
+            out.WriteByte(line[i])
+            i++
+            continue
+        }
+        j := i
+        for j < len(line) && addressByte(line[j]) {
+            j++
+        }
+        // Sentence punctuation after an address is not part of it
+        end := j
+        for end > i && (line[end-1] == '.' || line[end-1] == ':') {
+            end--
+        }
+        run := string(line[i:end])
+        if isAddress(run) {
+            redacted := r.Address(run)
+            if redacted == "" {
+                redacted = "-"
+            }
+            out.WriteString(redacted)
+        } else {
+            out.WriteString(run)
+        }
+        out.Write(line[end:j])
+        i = j
+    }
+    return out.Bytes()
+}
+
+func addressByte(c byte) bool {
+    return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F' || c == '.' || c == ':' || c == '[' || c == ']'
+}
+
+func isAddress(v string) bool {
+    if _, err := netip.ParseAddrPort(v); err == nil {
+        return true
+    }
+    _, err := netip.ParseAddr(v)
+    return err == nil
+}
@@ -0,0 +1,262 @@
+package replay
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/websocket"
+)
+
+// DefaultDialTimeout bounds the upstream handshake
+const DefaultDialTimeout = 10 * time.Second
+
+// Proxy relays WebSocket sessions to an upstream gateway and records each one. Frames pass
+// through unchanged, so clients see the upstream exactly as if they had connected to it
+type Proxy struct {
+    upstream    *url.URL
+    sink        Sink
+    anon        *Anonymizer
+    dialer      *websocket.Dialer
+    dialTimeout time.Duration
+    clock       clock.Clock
+    onError     func(error)
+}
+
+// NewProxy relays to the gateway at upstream, an http or https base URL, and saves
+// transcripts to sink
+func NewProxy(upstream string, sink Sink) (*Proxy, error) {
+    u, err := url.Parse(upstream)
+    if err != nil {
+        return nil, err
+    }
+    switch u.Scheme {
+    case "ws", "wss", "http", "https":
+    default:
+        return nil, errors.New("replay: unsupported upstream scheme " + u.Scheme)
+    }
+    return &Proxy{
+        upstream:    u,
+        sink:        sink,
+        anon:        NewAnonymizer(),
+        dialer:      websocket.NewDialer(),
+        dialTimeout: DefaultDialTimeout,
+        clock:       clock.System,
+        onError:     func(error) {},
+    }, nil
+}
+
+// SetAnonymizer replaces the proxy's random pseudonym key
+func (p *Proxy) SetAnonymizer(a *Anonymizer) *Proxy {
+    p.anon = a
+    return p
+}
+
+// SetDialer sets the dialer used upstream; its protocol list is replaced by the client's offer
+func (p *Proxy) SetDialer(d *websocket.Dialer) *Proxy {
+    p.dialer = d
+    return p
+}
+
+// SetDialTimeout bounds the upstream handshake
+func (p *Proxy) SetDialTimeout(d time.Duration) *Proxy {
+    p.dialTimeout = d
+    return p
+}
+
+// SetClock sets the clock transcripts are timed with
+func (p *Proxy) SetClock(c clock.Clock) *Proxy {
+    p.clock = c
+    return p
+}
+
+// OnError is called when a transcript cannot be saved
+func (p *Proxy) OnError(f func(error)) *Proxy {
+    p.onError = f
+    return p
+}
+
+// ServeHTTP connects upstream before accepting the client, so an upstream refusal reaches the
+// client with its original status
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    t := &Transcript{ID: newID(), StartedAt: p.clock.Now(), Path: p.anonymizePath(r.URL.Path)}
+    start := t.StartedAt
+
+    ctx, cancel := context.WithTimeout(r.Context(), p.dialTimeout)
+    header := http.Header{}
+    if auth := r.Header.Get("Authorization"); auth != "" {
+        header.Set("Authorization", auth)
+    }
+    dialer := *p.dialer
+    dialer.SetProtocols(offeredProtocols(r.Header)...)
+    up, err := dialer.Dial(ctx, p.upstreamURL(r.URL), header)
+    cancel()
+    if err != nil {
+        var he *websocket.HandshakeError
+        if errors.As(err, &he) {
+            t.UpstreamStatus, t.UpstreamError = he.StatusCode, he.Body
+            http.Error(w, he.Body, he.StatusCode)
+        } else {
+            t.UpstreamStatus, t.UpstreamError = http.StatusBadGateway, err.Error()
+            http.Error(w, "upstream unavailable", http.StatusBadGateway)
+        }
+        p.save(t)
+        return
+    }
+
+    upgrader := websocket.NewUpgrader().SetCompression(up.Compressed(), websocket.DefaultCompressionThreshold)
+    if up.Protocol() != "" {
+        upgrader.SetProtocols(up.Protocol())
+    }
+    client, err := upgrader.Upgrade(w, r)
+    if err != nil {
+        up.Close()
+        return
+    }
+    t.Protocol, t.Compressed = up.Protocol(), up.Compressed()
+
+    rec := &recorder{t: t, anon: p.anon, start: start, clock: p.clock}
+    var wg sync.WaitGroup
+    wg.Add(2)
+    go func() {
+        defer wg.Done()
+        rec.pump(FromClient, client, up)
+    }()
+    go func() {
+        defer wg.Done()
+        rec.pump(FromServer, up, client)
+    }()
+    wg.Wait()
+    p.save(t)
+}
+
+func (p *Proxy) save(t *Transcript) {
+    if err := p.sink.Save(t); err != nil {
+        p.onError(err)
+    }
+}
+
+// upstreamURL keeps the request's path and query, access_token included
+func (p *Proxy) upstreamURL(in *url.URL) string {
+    u := *p.upstream
+    u.Path = strings.TrimSuffix(u.Path, "/") + in.Path
+    u.RawQuery = in.RawQuery
+    return u.String()
+}
+
+// anonymizePath pseudonymizes the segment after "rooms"
+func (p *Proxy) anonymizePath(path string) string {
+    parts := strings.Split(path, "/")
+    for i := 0; i+1 < len(parts); i++ {
+        if parts[i] == "rooms" && parts[i+1] != "" {
+            parts[i+1] = p.anon.Pseudonym(parts[i+1])
+        }
+    }
+    return strings.Join(parts, "/")
+}
+
+type recorder struct {
+    mu    sync.Mutex
+    t     *Transcript
+    anon  *Anonymizer
+    start time.Time
+    clock clock.Clock
+}
+
+// pump copies messages from src to dst until src ends, then closes dst the same way
+func (rec *recorder) pump(direction string, src, dst *websocket.Conn) {
+    for {
+        typ, data, err := src.ReadMessage()
+        if err != nil {
+            code, reason := websocket.CloseGoingAway, ""
+            var ce *websocket.CloseError
+            if errors.As(err, &ce) {
+                code, reason = ce.Code, ce.Reason
+                if code == websocket.CloseNoStatus {
+                    code = websocket.CloseNormal
+                }
+            }
+            rec.close(direction, ce, err)
+            dst.CloseWithCode(code, reason)
+            return
+        }
+        if err := dst.WriteMessage(typ, data); err != nil {
+            src.CloseWithCode(websocket.CloseGoingAway, "")
+            return
+        }
+        rec.add(direction, typ, data)
+    }
+}
+
+func (rec *recorder) add(direction string, typ websocket.MessageType, data []byte) {
+    e := Entry{Offset: rec.offset(), Direction: direction, Size: len(data)}
+    if msg := decodeMessage(typ, data); msg != nil {
+        e.Type, _ = msg["type"].(string)
+        e.Message = rec.anon.Redact(msg)
+    }
+    rec.mu.Lock()
+    rec.t.Entries = append(rec.t.Entries, e)
+    rec.mu.Unlock()
+}
+
+// close records the first side to end the session
+func (rec *recorder) close(direction string, ce *websocket.CloseError, err error) {
+    rec.mu.Lock()
+    defer rec.mu.Unlock()
+    if rec.t.Close != nil {
+        return
+    }
+    c := &Close{Offset: rec.offset(), Side: direction}
+    if ce != nil {
+        c.Code, c.Reason = ce.Code, ce.Reason
+    } else {
+        c.Error = err.Error()
+    }
+    rec.t.Close = c
+}
+
+func (rec *recorder) offset() int64 {
+    return rec.clock.Now().Sub(rec.start).Milliseconds()
+}
+
+// decodeMessage reads a JSON text or CBOR binary object, or returns nil
+func decodeMessage(typ websocket.MessageType, data []byte) map[string]interface{} {
+    var v interface{}
+    var err error
+    if typ == websocket.BinaryMessage {
+        v, err = websocket.UnmarshalCBOR(data)
+    } else {
+        err = json.Unmarshal(data, &v)
+    }
+    if err != nil {
+        return nil
+    }
+    msg, _ := v.(map[string]interface{})
+    return msg
+}
+
+func offeredProtocols(h http.Header) []string {
+    var protocols []string
+    for _, v := range h.Values("Sec-WebSocket-Protocol") {
+        for _, p := range strings.Split(v, ",") {
+            if p = strings.TrimSpace(p); p != "" {
+                protocols = append(protocols, p)
+            }
+        }
+    }
+    return protocols
+}
+
+func newID() string {
+    b := make([]byte, 8)
+    rand.Read(b)
+    return hex.EncodeToString(b)
+}
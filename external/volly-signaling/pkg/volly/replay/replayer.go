@@ -0,0 +1,349 @@
+package replay
+
+import (
+    "context"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "math"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+    "github.com/volly-org/volly-signaling/pkg/volly/protocol"
+    "github.com/volly-org/volly-signaling/pkg/volly/websocket"
+)
+
+// DefaultSettle is how long a replay waits for the server after the last recorded message
+const DefaultSettle = 2 * time.Second
+
+// protocolTypes are the server messages a replay compares; room events depend on what the
+// test room is doing and are only counted
+var protocolTypes = map[string]bool{
+    protocol.TypeWelcome:     true,
+    protocol.TypeError:       true,
+    protocol.TypePong:        true,
+    protocol.TypeKeyExchange: true,
+    protocol.TypeTicket:      true,
+    protocol.TypeResumed:     true,
+    protocol.TypeRefreshed:   true,
+}
+
+// Replayer plays transcripts against a test gateway. The transcript's secrets are gone, so it
+// authenticates with its own token, answers key exchanges with a fresh key pair and refreshes
+// with the same token. Resumes cannot be replayed and are skipped
+type Replayer struct {
+    gateway string
+    room    string
+    token   string
+    speed   float64
+    settle  time.Duration
+    dialer  *websocket.Dialer
+}
+
+// NewReplayer replays into room on the gateway at gatewayURL, connecting with token
+func NewReplayer(gatewayURL, room, token string) *Replayer {
+    return &Replayer{
+        gateway: strings.TrimSuffix(gatewayURL, "/"),
+        room:    room,
+        token:   token,
+        speed:   1,
+        settle:  DefaultSettle,
+        dialer:  websocket.NewDialer(),
+    }
+}
+
+// SetSpeed scales the recorded timing; 2 replays twice as fast, and 0 sends without pausing
+func (r *Replayer) SetSpeed(speed float64) *Replayer {
+    r.speed = speed
+    return r
+}
+
+// SetSettle sets how long to wait for the server after the last recorded message
+func (r *Replayer) SetSettle(d time.Duration) *Replayer {
+    r.settle = d
+    return r
+}
+
+// SetDialer sets the dialer; its protocol list is replaced by the transcript's
+func (r *Replayer) SetDialer(d *websocket.Dialer) *Replayer {
+    r.dialer = d
+    return r
+}
+
+// Step is one server message, reduced to what a replay compares
+type Step struct {
+    Type string `json:"type"`
+    Code string `json:"code,omitempty"`
+}
+
+func (s Step) String() string {
+    if s.Code != "" {
+        return s.Type + "(" + s.Code + ")"
+    }
+    return s.Type
+}
+
+// Result compares a replay with its transcript
+type Result struct {
+    TranscriptID string `json:"transcriptId"`
+    // Expected and Actual are the upstream's protocol messages, in order
+    Expected []Step `json:"expected"`
+    Actual   []Step `json:"actual"`
+    // Events counts the room events each run received
+    ExpectedEvents int      `json:"expectedEvents"`
+    ActualEvents   int      `json:"actualEvents"`
+    Status         int      `json:"status,omitempty"`
+    Close          *Close   `json:"close,omitempty"`
+    Skipped        []string `json:"skipped,omitempty"`
+    Divergences    []string `json:"divergences,omitempty"`
+}
+
+// Matches reports whether the replay behaved as recorded
+func (res *Result) Matches() bool { return len(res.Divergences) == 0 }
+
+// Replay sends t's client messages with their recorded timing and compares the server's
+// answers. It returns an error only when the replay could not run
+func (r *Replayer) Replay(ctx context.Context, t *Transcript) (*Result, error) {
+    res := &Result{TranscriptID: t.ID}
+    for _, e := range t.Entries {
+        if e.Direction != FromServer {
+            continue
+        }
+        if protocolTypes[e.Type] {
+            code, _ := e.Message["code"].(string)
+            res.Expected = append(res.Expected, Step{Type: e.Type, Code: code})
+        } else {
+            res.ExpectedEvents++
+        }
+    }
+
+    dialer := *r.dialer
+    if t.Protocol != "" {
+        dialer.SetProtocols(t.Protocol)
+    }
+    header := http.Header{}
+    header.Set("Authorization", "Bearer "+r.token)
+    start := time.Now()
+    conn, err := dialer.Dial(ctx, r.url(t.Path), header)
+    if err != nil {
+        var he *websocket.HandshakeError
+        if !errors.As(err, &he) {
+            return nil, err
+        }
+        res.Status = he.StatusCode
+        if he.StatusCode != t.UpstreamStatus {
+            res.Divergences = append(res.Divergences, fmt.Sprintf("handshake: recorded status %s, got %d", statusText(t.UpstreamStatus), he.StatusCode))
+        }
+        return res, nil
+    }
+    defer conn.Close()
+    if t.UpstreamStatus != 0 {
+        res.Divergences = append(res.Divergences, fmt.Sprintf("handshake: recorded status %d, got an upgrade", t.UpstreamStatus))
+        return res, nil
+    }
+
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        for {
+            typ, data, err := conn.ReadMessage()
+            if err != nil {
+                // Only the server's close counts; anything else is the replay hanging up
+                var ce *websocket.CloseError
+                if errors.As(err, &ce) {
+                    res.Close = &Close{Offset: time.Since(start).Milliseconds(), Side: FromServer, Code: ce.Code, Reason: ce.Reason}
+                }
+                return
+            }
+            msg := decodeMessage(typ, data)
+            kind, _ := msg["type"].(string)
+            if protocolTypes[kind] {
+                code, _ := msg["code"].(string)
+                res.Actual = append(res.Actual, Step{Type: kind, Code: code})
+            } else {
+                res.ActualEvents++
+            }
+        }
+    }()
+
+    var last int64
+    for i, e := range t.Entries {
+        if e.Offset > last {
+            last = e.Offset
+        }
+        if e.Direction != FromClient {
+            continue
+        }
+        if err := r.wait(ctx, start, e.Offset, done); err != nil {
+            break
+        }
+        msg, skip := r.rebuild(e)
+        if skip != "" {
+            res.Skipped = append(res.Skipped, fmt.Sprintf("entry %d: %s", i, skip))
+            continue
+        }
+        if err := conn.Send(msg); err != nil {
+            break
+        }
+    }
+    if t.Close != nil && t.Close.Offset > last {
+        last = t.Close.Offset
+    }
+    settle := time.NewTimer(r.scale(last) - time.Since(start) + r.settle)
+    select {
+    case <-done:
+    case <-settle.C:
+    case <-ctx.Done():
+    }
+    settle.Stop()
+    conn.Close()
+    <-done
+
+    res.Divergences = append(res.Divergences, compare(res.Expected, res.Actual)...)
+    if t.Close != nil && t.Close.Side == FromServer && t.Close.Code != 0 {
+        switch {
+        case res.Close == nil:
+            res.Divergences = append(res.Divergences, fmt.Sprintf("close: recorded server close %d, server did not close", t.Close.Code))
+        case res.Close.Code != t.Close.Code:
+            res.Divergences = append(res.Divergences, fmt.Sprintf("close: recorded server close %d, got %d", t.Close.Code, res.Close.Code))
+        }
+    }
+    return res, ctx.Err()
+}
+
+// rebuild restores a client message from its redacted form, or says why it cannot be sent
+func (r *Replayer) rebuild(e Entry) (interface{}, string) {
+    if e.Message == nil {
+        return nil, "message was not recorded"
+    }
+    switch e.Type {
+    case protocol.TypeResume:
+        return nil, "resume needs the recorded ticket"
+    case protocol.TypeKeyExchange:
+        kp, err := crypto.GenerateMLKEM768KeyPair()
+        if err != nil {
+            return nil, err.Error()
+        }
+        return protocol.KeyExchange{
+            Type:      protocol.TypeKeyExchange,
+            Algorithm: crypto.AlgorithmMLKEM768,
+            PublicKey: base64.StdEncoding.EncodeToString(kp.PublicKey),
+        }, ""
+    case protocol.TypeRefresh:
+        return protocol.Refresh{Type: protocol.TypeRefresh, Token: r.token}, ""
+    }
+    // Anything else carried no secret, or it cannot be sent without one
+    if redacted(e.Message) {
+        return nil, "message carried a redacted secret"
+    }
+    return restore(e.Message), ""
+}
+
+func redacted(v interface{}) bool {
+    switch v := v.(type) {
+    case map[string]interface{}:
+        for _, item := range v {
+            if redacted(item) {
+                return true
+            }
+        }
+    case []interface{}:
+        for _, item := range v {
+            if redacted(item) {
+                return true
+            }
+        }
+    case string:
+        return strings.HasPrefix(v, "redacted:")
+    }
+    return false
+}
+
+// restore turns the whole numbers a JSON transcript decodes as floats back into integers, so
+// CBOR sessions send them as the client did
+func restore(v interface{}) interface{} {
+    switch v := v.(type) {
+    case map[string]interface{}:
+        out := make(map[string]interface{}, len(v))
+        for k, item := range v {
+            out[k] = restore(item)
+        }
+        return out
+    case []interface{}:
+        out := make([]interface{}, len(v))
+        for i, item := range v {
+            out[i] = restore(item)
+        }
+        return out
+    case float64:
+        if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+            return int64(v)
+        }
+    }
+    return v
+}
+
+// wait sleeps until a recorded offset, scaled by the replay speed
+func (r *Replayer) wait(ctx context.Context, start time.Time, offset int64, done <-chan struct{}) error {
+    d := r.scale(offset) - time.Since(start)
+    if d <= 0 {
+        return nil
+    }
+    timer := time.NewTimer(d)
+    defer timer.Stop()
+    select {
+    case <-timer.C:
+        return nil
+    case <-done:
+        return websocket.ErrClosed
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+func (r *Replayer) scale(offset int64) time.Duration {
+    if r.speed <= 0 {
+        return 0
+    }
+    return time.Duration(float64(offset) * float64(time.Millisecond) / r.speed)
+}
+
+// url puts the replayer's room in place of the transcript's pseudonym
+func (r *Replayer) url(path string) string {
+    parts := strings.Split(path, "/")
+    for i := 0; i+1 < len(parts); i++ {
+        if parts[i] == "rooms" {
+            parts[i+1] = url.PathEscape(r.room)
+        }
+    }
+    return r.gateway + strings.Join(parts, "/")
+}
+
+func compare(expected, actual []Step) []string {
+    var out []string
+    n := len(expected)
+    if len(actual) > n {
+        n = len(actual)
+    }
+    for i := 0; i < n; i++ {
+        switch {
+        case i >= len(actual):
+            out = append(out, fmt.Sprintf("message %d: recorded %s, got nothing", i, expected[i]))
+        case i >= len(expected):
+            out = append(out, fmt.Sprintf("message %d: recorded nothing, got %s", i, actual[i]))
+        case expected[i] != actual[i]:
+            out = append(out, fmt.Sprintf("message %d: recorded %s, got %s", i, expected[i], actual[i]))
+        }
+    }
+    return out
+}
+
+func statusText(status int) string {
+    if status == 0 {
+        return "101"
+    }
+    return fmt.Sprint(status)
+}
@@ -0,0 +1,179 @@
+// Package replay records signaling sessions as anonymized transcripts and plays them back
+// against another gateway, to reproduce connection failures reported from the field. A Proxy
+// sits in front of a gateway and relays each WebSocket session unchanged while recording
+// every message with secrets redacted and identities pseudonymized. A Replayer sends a
+// transcript's client messages to a test gateway with the original timing and reports where
+// the server's answers diverge from the recorded ones
+package replay
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "os"
+    "path/filepath"
+    "strconv"
+    "time"
+)
+
+// Directions of a transcript entry
+const (
+    FromClient = "client"
+    FromServer = "server"
+)
+
+var ErrNoTranscript = errors.New("transcript not found")
+
+// Transcript is one recorded session
+type Transcript struct {
+    ID        string    `json:"id"`
+    StartedAt time.Time `json:"startedAt"`
+    // Path is the request path with its room pseudonymized
+    Path       string `json:"path"`
+    Protocol   string `json:"protocol,omitempty"`
+    Compressed bool   `json:"compressed,omitempty"`
+    // UpstreamStatus is the HTTP status of a refused upgrade; zero when the upgrade succeeded
+    UpstreamStatus int     `json:"upstreamStatus,omitempty"`
+    UpstreamError  string  `json:"upstreamError,omitempty"`
+    Entries        []Entry `json:"entries"`
+    Close          *Close  `json:"close,omitempty"`
+}
+
+// Entry is one message
+type Entry struct {
+    // Offset is milliseconds since the session started
+    Offset    int64  `json:"offset"`
+    Direction string `json:"direction"`
+    Type      string `json:"type"`
+    // Message is the decoded message after redaction, or nil when it could not be decoded
+    Message map[string]interface{} `json:"message,omitempty"`
+    Size    int                    `json:"size"`
+}
+
+// Close is how the session ended
+type Close struct {
+    Offset int64 `json:"offset"`
+    // Side is the direction that closed first
+    Side   string `json:"side"`
+    Code   int    `json:"code,omitempty"`
+    Reason string `json:"reason,omitempty"`
+    Error  string `json:"error,omitempty"`
+}
+
+// secretFields are replaced by their length wherever they appear
+var secretFields = map[string]bool{
+    "token":        true,
+    "access_token": true,
+    "ticket":       true,
+    "proof":        true,
+    "publicKey":    true,
+    "ciphertext":   true,
+    "signature":    true,
+    "nonce":        true,
+    "sharedSecret": true,
+    "secret":       true,
+}
+
+// pseudonymFields are replaced by a keyed hash, so one participant keeps one name within a
+// recorder's transcripts without revealing who it was
+var pseudonymFields = map[string]bool{
+    "identity": true,
+    "room":     true,
+    "tenant":   true,
+}
+
+// Anonymizer redacts secrets and pseudonymizes names
+type Anonymizer struct {
+    key []byte
+}
+
+// NewAnonymizer uses a random key, so pseudonyms cannot be linked across processes
+func NewAnonymizer() *Anonymizer {
+    key := make([]byte, 32)
+    rand.Read(key)
+    return &Anonymizer{key: key}
+}
+
+// Pseudonym is a stable stand-in for name
+func (a *Anonymizer) Pseudonym(name string) string {
+    if name == "" {
+        return ""
+    }
+    mac := hmac.New(sha256.New, a.key)
+    mac.Write([]byte(name))
+    return "anon-" + hex.EncodeToString(mac.Sum(nil)[:6])
+}
+
+// Redact returns a copy of msg with secrets and names replaced
+func (a *Anonymizer) Redact(msg map[string]interface{}) map[string]interface{} {
+    return a.redact("", msg).(map[string]interface{})
+}
+
+func (a *Anonymizer) redact(field string, v interface{}) interface{} {
+    switch v := v.(type) {
+    case map[string]interface{}:
+        out := make(map[string]interface{}, len(v))
+        for k, item := range v {
+            out[k] = a.redact(k, item)
+        }
+        return out
+    case []interface{}:
+        out := make([]interface{}, len(v))
+        for i, item := range v {
+            out[i] = a.redact(field, item)
+        }
+        return out
+    case string:
+        switch {
+        case secretFields[field]:
+            return Redacted(len(v))
+        case pseudonymFields[field]:
+            return a.Pseudonym(v)
+        }
+    case []byte:
+        if secretFields[field] {
+            return Redacted(len(v))
+        }
+    }
+    return v
+}
+
+// Redacted is what replaces a secret of n bytes
+func Redacted(n int) string {
+    return "redacted:" + strconv.Itoa(n)
+}
+
+// Sink stores finished transcripts
+type Sink interface {
+    Save(t *Transcript) error
+}
+
+// DirSink writes each transcript to <dir>/<id>.json
+type DirSink string
+
+func (d DirSink) Save(t *Transcript) error {
+    data, err := json.MarshalIndent(t, "", "  ")
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(filepath.Join(string(d), t.ID+".json"), data, 0o600)
+}
+
+// Load reads a transcript written by DirSink
+func Load(path string) (*Transcript, error) {
+    data, err := os.ReadFile(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil, ErrNoTranscript
+    }
+    if err != nil {
+        return nil, err
+    }
+    var t Transcript
+    if err := json.Unmarshal(data, &t); err != nil {
+        return nil, err
+    }
+    return &t, nil
+}
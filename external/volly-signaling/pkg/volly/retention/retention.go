@@ -0,0 +1,212 @@
+// Package retention prunes stores that would otherwise grow without bound. Each store has a
+// retention window and a floor the window may not go below, so a misconfiguration cannot
+// delete what a compliance window or token lifetime still needs; in dry-run mode a job only
+// counts what it would prune
+package retention
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+)
+
+// DefaultInterval is how often Run prunes
+const DefaultInterval = time.Hour
+
+// pruneTimeout bounds one store's prune
+const pruneTimeout = 5 * time.Minute
+
+var ErrBelowMinimum = errors.New("retention is below the store's minimum")
+
+// Pruner is implemented by stores with records to prune. Prune removes, or with dryRun only
+// counts, the records that aged out before before
+type Pruner interface {
+    Prune(ctx context.Context, before time.Time, dryRun bool) (int, error)
+}
+
+// PrunerFunc adapts a function to Pruner
+type PrunerFunc func(ctx context.Context, before time.Time, dryRun bool) (int, error)
+
+// Prune calls f
+func (f PrunerFunc) Prune(ctx context.Context, before time.Time, dryRun bool) (int, error) {
+    return f(ctx, before, dryRun)
+}
+
+// Result is one store's last prune
+type Result struct {
+    Store  string    `json:"store"`
+    At     time.Time `json:"at"`
+    Before time.Time `json:"before"`
+    DryRun bool      `json:"dryRun,omitempty"`
+    // Records is how many were pruned, or would have been in a dry run
+    Records int    `json:"records"`
+    Error   string `json:"error,omitempty"`
+}
+
+// StoreStats is a store's policy and totals since start
+type StoreStats struct {
+    Store     string        `json:"store"`
+    Retention time.Duration `json:"retention"`
+    Minimum   time.Duration `json:"minimum"`
+    Pruned    int64         `json:"pruned"`
+    Failures  int64         `json:"failures"`
+    Last      *Result       `json:"last,omitempty"`
+}
+
+type store struct {
+    name      string
+    pruner    Pruner
+    retention time.Duration
+    minimum   time.Duration
+
+    pruned   int64
+    failures int64
+    last     *Result
+}
+
+// Job prunes its stores every interval
+type Job struct {
+    interval time.Duration
+    dryRun   bool
+    clock    clock.Clock
+
+    mu     sync.Mutex
+    stores []*store
+}
+
+// NewJob creates a job with no stores
+func NewJob() *Job {
+    return &Job{interval: DefaultInterval, clock: clock.System}
+}
+
+// SetInterval sets how often Run prunes
+func (j *Job) SetInterval(d time.Duration) *Job {
+    j.interval = d
+    return j
+}
+
+// SetDryRun makes the job count what it would prune without removing anything
+func (j *Job) SetDryRun(dryRun bool) *Job {
+    j.dryRun = dryRun
+    return j
+}
+
+// SetClock sets the time source for cutoffs
+func (j *Job) SetClock(c clock.Clock) *Job {
+    j.clock = c
+    return j
+}
+
+// Add prunes the records of store older than retention. It returns ErrBelowMinimum when
+// retention is under minimum, the shortest window the store can safely keep
+func (j *Job) Add(name string, p Pruner, retention, minimum time.Duration) error {
+    if retention <= 0 || retention < minimum {
+        return fmt.Errorf("%w: %s retention %s, minimum %s", ErrBelowMinimum, name, retention, minimum)
+    }
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    j.stores = append(j.stores, &store{name: name, pruner: p, retention: retention, minimum: minimum})
+    return nil
+}
+
+// Prune prunes every store once, in the order they were added
+func (j *Job) Prune(ctx context.Context) []Result {
+    j.mu.Lock()
+    stores := append([]*store(nil), j.stores...)
+    j.mu.Unlock()
+
+    results := make([]Result, 0, len(stores))
+    for _, s := range stores {
+        now := j.clock.Now()
+        res := Result{Store: s.name, At: now, Before: now.Add(-s.retention), DryRun: j.dryRun}
+        pctx, cancel := context.WithTimeout(ctx, pruneTimeout)
+        n, err := s.pruner.Prune(pctx, res.Before, j.dryRun)
+        cancel()
+        res.Records = n
+        if err != nil {
+            res.Error = err.Error()
+        }
+
+        j.mu.Lock()
+        if err != nil {
+            s.failures++
+        } else if !j.dryRun {
+            s.pruned += int64(n)
+        }
+        s.last = &res
+        j.mu.Unlock()
+        results = append(results, res)
+    }
+    return results
+}
+
+// Run prunes every interval until ctx is cancelled
+func (j *Job) Run(ctx context.Context) {
+    ticker := time.NewTicker(j.interval)
+    defer ticker.Stop()
+    for {
+        for _, res := range j.Prune(ctx) {
+            switch {
+            case res.Error != "":
+                log.Printf("retention: pruning %s failed: %s", res.Store, res.Error)
+            case res.DryRun && res.Records > 0:
+                log.Printf("retention: dry run would prune %d %s records before %s", res.Records, res.Store, res.Before.Format(time.RFC3339))
+            }
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// Stats reports each store's policy and totals, sorted by store
+func (j *Job) Stats() []StoreStats {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    out := make([]StoreStats, len(j.stores))
+    for i, s := range j.stores {
+        out[i] = StoreStats{Store: s.name, Retention: s.retention, Minimum: s.minimum, Pruned: s.pruned, Failures: s.failures, Last: s.last}
+    }
+    sort.Slice(out, func(a, b int) bool { return out[a].Store < out[b].Store })
+    return out
+}
+
+// WriteMetrics writes the job's counters in the Prometheus text format
+func (j *Job) WriteMetrics(w io.Writer) error {
+    stats := j.Stats()
+    metric := func(name, kind, help string) {
+        fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+    }
+    metric("volly_retention_pruned_total", "counter", "Records pruned since start by store.")
+    for _, s := range stats {
+        fmt.Fprintf(w, "volly_retention_pruned_total{store=%q} %d\n", s.Store, s.Pruned)
+    }
+    metric("volly_retention_failures_total", "counter", "Failed prunes since start by store.")
+    for _, s := range stats {
+        fmt.Fprintf(w, "volly_retention_failures_total{store=%q} %d\n", s.Store, s.Failures)
+    }
+    metric("volly_retention_last_records", "gauge", "Records the last prune removed, or would have in a dry run, by store.")
+    for _, s := range stats {
+        n := 0
+        if s.Last != nil {
+            n = s.Last.Records
+        }
+        fmt.Fprintf(w, "volly_retention_last_records{store=%q} %d\n", s.Store, n)
+    }
+    dryRun := 0
+    if j.dryRun {
+        dryRun = 1
+    }
+    metric("volly_retention_dry_run", "gauge", "Whether pruning only counts what it would remove.")
+    _, err := fmt.Fprintf(w, "volly_retention_dry_run %d\n", dryRun)
+    return err
+}
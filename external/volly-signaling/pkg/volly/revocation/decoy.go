@@ -0,0 +1,138 @@
+package revocation
+
+import (
+    "context"
+    "errors"
+    "time"
+)
+
+var ErrDecoysUnsupported = errors.New("revocation store cannot hold decoy tokens")
+
+// Decoys is implemented by stores that can flag a revoked jti as a decoy: a token minted
+// only to be planted where a thief would find it, so any attempt to use it means the place
+// it was planted has been read. Decoys are revoked like any other token, and look like any
+// other revocation to edges and exports
+type Decoys interface {
+    MarkDecoy(ctx context.Context, jti string, expiresAt time.Time) error
+    IsDecoy(ctx context.Context, jti string) (bool, error)
+}
+
+// DecoyError is returned by Check for a decoy token. It reads as ErrTokenRevoked, so whoever
+// presented the token cannot tell they tripped a wire
+type DecoyError struct {
+    JTI      string
+    Tenant   string
+    Room     string
+    Identity string
+}
+
+func (e *DecoyError) Error() string {
+    return ErrTokenRevoked.Error()
+}
+
+// Is matches ErrTokenRevoked
+func (e *DecoyError) Is(target error) bool {
+    return target == ErrTokenRevoked
+}
+
+// Decoy revokes jti and flags it as a decoy until expiresAt. Only the jti is recorded;
+// minting the token is up to the caller
+func (r *Revoker) Decoy(ctx context.Context, jti string, expiresAt time.Time) error {
+    decoys, ok := r.store.(Decoys)
+    if !ok {
+        return ErrDecoysUnsupported
+    }
+    if err := decoys.MarkDecoy(ctx, jti, expiresAt); err != nil {
+        return err
+    }
+    // Announced as a plain revocation, so filters pick it up and nothing on the bus marks it out
+    return r.publish(ctx, map[string]string{"jti": jti})
+}
+
+// decoy returns the first of ids flagged as a decoy. Only revoked tokens are asked about,
+// so decoys cost nothing on the path of live ones
+func (r *Revoker) decoy(ctx context.Context, ids []string) (string, error) {
+    decoys, ok := r.store.(Decoys)
+    if !ok {
+        return "", nil
+    }
+    for _, id := range ids {
+        decoy, err := decoys.IsDecoy(ctx, id)
+        if err != nil || decoy {
+            return id, err
+        }
+    }
+    return "", nil
+}
+
+// MarkDecoy revokes jti and flags it as a decoy
+func (s *MemoryStore) MarkDecoy(ctx context.Context, jti string, expiresAt time.Time) error {
+    if err := s.RevokeToken(ctx, jti, expiresAt); err != nil {
+        return err
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    now := s.clock.Now()
+    for id, exp := range s.decoys {
+        if now.After(exp) {
+            delete(s.decoys, id)
+        }
+    }
+    s.decoys[jti] = expiresAt
+    return nil
+}
+
+// IsDecoy reports whether jti was flagged with MarkDecoy and has not expired
+func (s *MemoryStore) IsDecoy(ctx context.Context, jti string) (bool, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    exp, ok := s.decoys[jti]
+    return ok && !s.clock.Now().After(exp), nil
+}
+
+// MarkDecoy flags a decoy in the underlying store and adds it to the filter
+func (s *FilteredStore) MarkDecoy(ctx context.Context, jti string, expiresAt time.Time) error {
+    decoys, ok := s.store.(Decoys)
+    if !ok {
+        return ErrDecoysUnsupported
+    }
+    if err := decoys.MarkDecoy(ctx, jti, expiresAt); err != nil {
+        return err
+    }
+    s.add(tokenKey(jti))
+    return nil
+}
+
+// IsDecoy asks the underlying store
+func (s *FilteredStore) IsDecoy(ctx context.Context, jti string) (bool, error) {
+    decoys, ok := s.store.(Decoys)
+    if !ok {
+        return false, nil
+    }
+    return decoys.IsDecoy(ctx, jti)
+}
+
+// MarkDecoy flags a decoy in the underlying store and logs it as a plain token revocation,
+// so edges reject it without learning it is a decoy
+func (p *Publisher) MarkDecoy(ctx context.Context, jti string, expiresAt time.Time) error {
+    decoys, ok := p.store.(Decoys)
+    if !ok {
+        return ErrDecoysUnsupported
+    }
+    if err := decoys.MarkDecoy(ctx, jti, expiresAt); err != nil {
+        return err
+    }
+    p.append(change{token: &RevokedToken{JTI: jti, ExpiresAt: expiresAt}})
+    return nil
+}
+
+// IsDecoy asks the underlying store
+func (p *Publisher) IsDecoy(ctx context.Context, jti string) (bool, error) {
+    decoys, ok := p.store.(Decoys)
+    if !ok {
+        return false, nil
+    }
+    return decoys.IsDecoy(ctx, jti)
+}
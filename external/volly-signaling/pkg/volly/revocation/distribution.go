@@ -0,0 +1,470 @@
+package revocation
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/binary"
+    "errors"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+)
+
+// listContext domain-separates revocation list signatures
+const listContext = "volly-crl-v1"
+
+const listVersion = 1
+
+// Distribution defaults
+const (
+    DefaultUpdateInterval = time.Minute
+    DefaultRetention      = 10000
+    DefaultMaxStaleness   = 5 * time.Minute
+)
+
+var (
+    ErrDeltaUnavailable = errors.New("delta is no longer retained; fetch a full list")
+    ErrInvalidList      = errors.New("revocation list is malformed")
+    ErrListOutOfOrder   = errors.New("revocation list does not follow the applied sequence")
+    ErrListStale        = errors.New("revocation list is missing or stale")
+    ErrReadOnlyList     = errors.New("edge revocation list is read-only")
+    ErrNoLister         = errors.New("revocation store cannot list revocations")
+    ErrNoPruner         = errors.New("revocation store cannot prune revocations")
+)
+
+// SignedList is a full revocation list or a delta over the sequence range (From, To]. Epoch
+// changes when the publisher restarts, forcing edges back to a full list
+type SignedList struct {
+    Epoch      [8]byte
+    From       uint64
+    To         uint64
+    Full       bool
+    IssuedAt   time.Time
+    NextUpdate time.Time
+    Cutoffs    []Cutoff
+    Tokens     []RevokedToken
+    Algorithm  string
+    Signature  []byte
+}
+
+// signedPart encodes everything but the signature. Times are seconds relative to IssuedAt,
+// which keeps most of them to one or two bytes
+func (l *SignedList) signedPart() []byte {
+    base := l.IssuedAt.Unix()
+    buf := []byte{listVersion}
+    buf = append(buf, l.Epoch[:]...)
+    buf = binary.AppendUvarint(buf, l.From)
+    buf = binary.AppendUvarint(buf, l.To)
+    if l.Full {
+        buf = append(buf, 1)
+    } else {
+        buf = append(buf, 0)
+    }
+    buf = binary.AppendVarint(buf, base)
+    buf = binary.AppendVarint(buf, l.NextUpdate.Unix()-base)
+    buf = binary.AppendUvarint(buf, uint64(len(l.Cutoffs)))
+    for _, c := range l.Cutoffs {
+        buf = appendString(buf, c.Predicate.Tenant)
+        buf = appendString(buf, c.Predicate.Room)
+        buf = appendString(buf, c.Predicate.Identity)
+        buf = binary.AppendVarint(buf, c.Cutoff.Unix()-base)
+    }
+    buf = binary.AppendUvarint(buf, uint64(len(l.Tokens)))
+    for _, t := range l.Tokens {
+        buf = appendString(buf, t.JTI)
+        buf = binary.AppendVarint(buf, t.ExpiresAt.Unix()-base)
+    }
+    return appendString(buf, l.Algorithm)
+}
+
+// Marshal encodes the list for distribution
+func (l *SignedList) Marshal() []byte {
+    buf := l.signedPart()
+    buf = binary.AppendUvarint(buf, uint64(len(l.Signature)))
+    return append(buf, l.Signature...)
+}
+
+// Verify checks the list signature
+func (l *SignedList) Verify(publicKey []byte) error {
+    return crypto.VerifySignature(l.Algorithm, publicKey, append([]byte(listContext), l.signedPart()...), l.Signature)
+}
+
+// ParseList decodes a list produced by Marshal without verifying it
+func ParseList(data []byte) (*SignedList, error) {
+    r := &listReader{data: data}
+    if r.flag() != listVersion {
+        return nil, ErrInvalidList
+    }
+    l := &SignedList{}
+    copy(l.Epoch[:], r.take(8))
+    l.From = r.uvarint()
+    l.To = r.uvarint()
+    l.Full = r.flag() == 1
+    base := r.varint()
+    l.IssuedAt = time.Unix(base, 0).UTC()
+    l.NextUpdate = time.Unix(base+r.varint(), 0).UTC()
+    for n := r.count(); n > 0 && r.err == nil; n-- {
+        var c Cutoff
+        c.Predicate.Tenant = r.str()
+        c.Predicate.Room = r.str()
+        c.Predicate.Identity = r.str()
+        c.Cutoff = time.Unix(base+r.varint(), 0).UTC()
+        l.Cutoffs = append(l.Cutoffs, c)
+    }
+    for n := r.count(); n > 0 && r.err == nil; n-- {
+        var t RevokedToken
+        t.JTI = r.str()
+        t.ExpiresAt = time.Unix(base+r.varint(), 0).UTC()
+        l.Tokens = append(l.Tokens, t)
+    }
+    l.Algorithm = r.str()
+    l.Signature = r.take(int(r.count()))
+    if r.err != nil || len(r.data) != 0 {
+        return nil, ErrInvalidList
+    }
+    return l, nil
+}
+
+// change is one logged revocation; exactly one of cutoff and token is set
+type change struct {
+    seq    uint64
+    cutoff *Cutoff
+    token  *RevokedToken
+}
+
+// Publisher is a Store that logs every revocation it passes to the underlying store so edges
+// can be sent signed deltas. Every revocation must go through it for deltas to be complete
+type Publisher struct {
+    store     Store
+    signer    crypto.Signer
+    clock     clock.Clock
+    interval  time.Duration
+    retention int
+    epoch     [8]byte
+
+    mu  sync.Mutex
+    seq uint64
+    log []change
+}
+
+// NewPublisher wraps store; full lists need it to implement Lister
+func NewPublisher(store Store, signer crypto.Signer) (*Publisher, error) {
+    p := &Publisher{
+        store:     store,
+        signer:    signer,
+        clock:     clock.System,
+        interval:  DefaultUpdateInterval,
+        retention: DefaultRetention,
+    }
+    if _, err := rand.Read(p.epoch[:]); err != nil {
+        return nil, err
+    }
+    return p, nil
+}
+
+// SetClock sets the time source for list timestamps
+func (p *Publisher) SetClock(c clock.Clock) *Publisher {
+    p.clock = c
+    return p
+}
+
+// SetUpdateInterval sets how often edges are told to fetch the next delta
+func (p *Publisher) SetUpdateInterval(d time.Duration) *Publisher {
+    p.interval = d
+    return p
+}
+
+// SetRetention sets how many changes are at least kept for deltas; older edges get ErrDeltaUnavailable
+func (p *Publisher) SetRetention(n int) *Publisher {
+    p.retention = n
+    return p
+}
+
+// RevokeWhere stores and logs a bulk revocation
+func (p *Publisher) RevokeWhere(ctx context.Context, pred Predicate, cutoff time.Time) error {
+    if err := p.store.RevokeWhere(ctx, pred, cutoff); err != nil {
+        return err
+    }
+    p.append(change{cutoff: &Cutoff{Predicate: pred, Cutoff: cutoff}})
+    return nil
+}
+
+// RevokeToken stores and logs a single-token revocation
+func (p *Publisher) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+    if err := p.store.RevokeToken(ctx, jti, expiresAt); err != nil {
+        return err
+    }
+    p.append(change{token: &RevokedToken{JTI: jti, ExpiresAt: expiresAt}})
+    return nil
+}
+
+// IsRevoked asks the underlying store
+func (p *Publisher) IsRevoked(ctx context.Context, t Token) (bool, error) {
+    return p.store.IsRevoked(ctx, t)
+}
+
+// ListRevocations lists the underlying store, so a Publisher can back snapshot exports
+func (p *Publisher) ListRevocations(ctx context.Context) ([]Cutoff, []RevokedToken, error) {
+    lister, ok := p.store.(Lister)
+    if !ok {
+        return nil, nil, ErrNoLister
+    }
+    return lister.ListRevocations(ctx)
+}
+
+func (p *Publisher) append(c change) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    p.seq++
+    c.seq = p.seq
+    p.log = append(p.log, c)
+    // Trim in batches so appends stay amortized O(1)
+    if len(p.log) > 2*p.retention {
+        p.log = append(p.log[:0:0], p.log[len(p.log)-p.retention:]...)
+    }
+}
+
+// Full signs every active revocation as of the current sequence
+func (p *Publisher) Full(ctx context.Context) (*SignedList, error) {
+    // Read the sequence first: a revocation racing the listing is then at worst sent twice
+    p.mu.Lock()
+    seq := p.seq
+    p.mu.Unlock()
+
+    cutoffs, tokens, err := p.ListRevocations(ctx)
+    if err != nil {
+        return nil, err
+    }
+    return p.sign(&SignedList{To: seq, Full: true, Cutoffs: cutoffs, Tokens: tokens})
+}
+
+// Delta signs the changes after sequence since, or ErrDeltaUnavailable once they have been
+// dropped from the log
+func (p *Publisher) Delta(since uint64) (*SignedList, error) {
+    p.mu.Lock()
+    seq := p.seq
+    if since > seq || (len(p.log) > 0 && since+1 < p.log[0].seq) || (len(p.log) == 0 && since != seq) {
+        p.mu.Unlock()
+        return nil, ErrDeltaUnavailable
+    }
+    l := &SignedList{From: since, To: seq}
+    for _, c := range p.log {
+        if c.seq <= since {
+            continue
+        }
+        if c.cutoff != nil {
+            l.Cutoffs = append(l.Cutoffs, *c.cutoff)
+        } else {
+            l.Tokens = append(l.Tokens, *c.token)
+        }
+    }
+    p.mu.Unlock()
+    return p.sign(l)
+}
+
+func (p *Publisher) sign(l *SignedList) (*SignedList, error) {
+    now := p.clock.Now()
+    l.Epoch = p.epoch
+    l.IssuedAt = time.Unix(now.Unix(), 0).UTC()
+    l.NextUpdate = l.IssuedAt.Add(p.interval)
+    l.Algorithm = p.signer.Algorithm()
+    sig, err := p.signer.Sign(append([]byte(listContext), l.signedPart()...))
+    if err != nil {
+        return nil, err
+    }
+    l.Signature = sig
+    return l, nil
+}
+
+// EdgeList is a read-only Store for edge gateways, fed signed lists by Apply. It fails
+// closed: once NextUpdate plus the allowed staleness passes without a new list, IsRevoked
+// returns ErrListStale
+type EdgeList struct {
+    algorithm string
+    publicKey []byte
+    clock     clock.Clock
+    maxStale  time.Duration
+
+    mu         sync.RWMutex
+    loaded     bool
+    epoch      [8]byte
+    seq        uint64
+    nextUpdate time.Time
+    store      *MemoryStore
+}
+
+// NewEdgeList trusts lists signed with publicKey under algorithm
+func NewEdgeList(algorithm string, publicKey []byte) *EdgeList {
+    return &EdgeList{
+        algorithm: algorithm,
+        publicKey: publicKey,
+        clock:     clock.System,
+        maxStale:  DefaultMaxStaleness,
+        store:     NewMemoryStore(),
+    }
+}
+
+// SetClock sets the time source for staleness and token expiry
+func (e *EdgeList) SetClock(c clock.Clock) *EdgeList {
+    e.clock = c
+    e.store.SetClock(c)
+    return e
+}
+
+// SetMaxStaleness sets how long past NextUpdate the list is still trusted
+func (e *EdgeList) SetMaxStaleness(d time.Duration) *EdgeList {
+    e.maxStale = d
+    return e
+}
+
+// Sequence returns the applied sequence, the since to request the next delta with
+func (e *EdgeList) Sequence() uint64 {
+    e.mu.RLock()
+    defer e.mu.RUnlock()
+    return e.seq
+}
+
+// Apply verifies and applies a full list or the delta following the applied sequence
+func (e *EdgeList) Apply(ctx context.Context, l *SignedList) error {
+    if l.Algorithm != e.algorithm {
+        return crypto.ErrUnsupportedAlgorithm
+    }
+    if err := l.Verify(e.publicKey); err != nil {
+        return err
+    }
+
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    if !l.Full && (!e.loaded || l.Epoch != e.epoch || l.From != e.seq) {
+        return ErrListOutOfOrder
+    }
+    if l.Full {
+        // Replaying an older full list would resurrect revoked tokens
+        if e.loaded && l.Epoch == e.epoch && l.To < e.seq {
+            return ErrListOutOfOrder
+        }
+        e.store = NewMemoryStore().SetClock(e.clock)
+    }
+    e.store.merge(l.Cutoffs, l.Tokens)
+    e.loaded = true
+    e.epoch = l.Epoch
+    e.seq = l.To
+    e.nextUpdate = l.NextUpdate
+    return nil
+}
+
+// RevokeWhere is not supported: edges only learn revocations from the publisher
+func (e *EdgeList) RevokeWhere(ctx context.Context, p Predicate, cutoff time.Time) error {
+    return ErrReadOnlyList
+}
+
+// RevokeToken is not supported: edges only learn revocations from the publisher
+func (e *EdgeList) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+    return ErrReadOnlyList
+}
+
+// IsRevoked checks the applied list
+func (e *EdgeList) IsRevoked(ctx context.Context, t Token) (bool, error) {
+    e.mu.RLock()
+    defer e.mu.RUnlock()
+
+    if !e.loaded || e.clock.Now().After(e.nextUpdate.Add(e.maxStale)) {
+        return false, ErrListStale
+    }
+    return e.store.IsRevoked(ctx, t)
+}
+
+// merge applies a list in one pass; RevokeToken would prune expired entries on every call
+func (s *MemoryStore) merge(cutoffs []Cutoff, tokens []RevokedToken) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, c := range cutoffs {
+        if c.Cutoff.After(s.cutoffs[c.Predicate]) {
+            s.cutoffs[c.Predicate] = c.Cutoff
+        }
+    }
+    now := s.clock.Now()
+    for id, exp := range s.tokens {
+        if now.After(exp) {
+            delete(s.tokens, id)
+        }
+    }
+    for _, t := range tokens {
+        if !now.After(t.ExpiresAt) {
+            s.tokens[t.JTI] = t.ExpiresAt
+        }
+    }
+}
+
+func appendString(buf []byte, s string) []byte {
+    buf = binary.AppendUvarint(buf, uint64(len(s)))
+    return append(buf, s...)
+}
+
+// listReader decodes ParseList fields, remembering the first error
+type listReader struct {
+    data []byte
+    err  error
+}
+
+func (r *listReader) take(n int) []byte {
+    if r.err != nil || n < 0 || n > len(r.data) {
+        r.err = ErrInvalidList
+        return nil
+    }
+    b := r.data[:n]
+    r.data = r.data[n:]
+    return b
+}
+
+func (r *listReader) flag() byte {
+    if b := r.take(1); len(b) == 1 {
+        return b[0]
+    }
+    return 0
+}
+
+func (r *listReader) uvarint() uint64 {
+    if r.err != nil {
+        return 0
+    }
+    v, n := binary.Uvarint(r.data)
+    if n <= 0 {
+        r.err = ErrInvalidList
+        return 0
+    }
+    r.data = r.data[n:]
+    return v
+}
+
+func (r *listReader) varint() int64 {
+    if r.err != nil {
+        return 0
+    }
+    v, n := binary.Varint(r.data)
+    if n <= 0 {
+        r.err = ErrInvalidList
+        return 0
+    }
+    r.data = r.data[n:]
+    return v
+}
+
+// count reads a length, bounded by the remaining input so a forged one cannot force a huge loop
+func (r *listReader) count() uint64 {
+    n := r.uvarint()
+    if n > uint64(len(r.data)) {
+        r.err = ErrInvalidList
+        return 0
+    }
+    return n
+}
+
+func (r *listReader) str() string {
+    return string(r.take(int(r.count())))
+}
@@ -0,0 +1,466 @@
+package revocation
+
+import (
+    "context"
+    "errors"
+    "hash/maphash"
+    "log"
+    "math"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/events"
+)
+
+// EventRevoked is published by a Revoker with an event bus; Data holds the jti, or the
+// tenant, room and identity of a predicate
+const EventRevoked = "revocation_added"
+
+// Filter defaults
+const (
+    DefaultRebuildInterval   = 30 * time.Second
+    DefaultFalsePositiveRate = 0.001
+    minFilterEntries         = 1024
+)
+
+// FilterStats counts FilteredStore lookups
+type FilterStats struct {
+    Lookups uint64 `json:"lookups"`
+    // Skipped lookups were answered by the filter alone
+    Skipped uint64 `json:"skipped"`
+    // Fallthroughs went to the store; FalsePositives of them found nothing revoked
+    Fallthroughs   uint64 `json:"fallthroughs"`
+    FalsePositives uint64 `json:"falsePositives"`
+    // FalsePositiveRate is the fraction of lookups that went to the store needlessly
+    FalsePositiveRate float64   `json:"falsePositiveRate"`
+    Entries           int       `json:"entries"`
+    Rebuilds          uint64    `json:"rebuilds"`
+    LastRebuild       time.Time `json:"lastRebuild,omitempty"`
+    // Sequence is the last change feed write the filter holds; CatchUps added writes to it
+    Sequence uint64 `json:"sequence,omitempty"`
+    CatchUps uint64 `json:"catchUps,omitempty"`
+}
+
+// ChangeFeed is implemented by stores shared between instances that number their writes
+// without gaps, so a FilteredStore learns of revocations made through other instances by
+// asking for what follows the last write it holds instead of listing the store
+type ChangeFeed interface {
+    // Sequence returns the number of the latest write
+    Sequence(ctx context.Context) (uint64, error)
+    // Changes returns the writes numbered after since and the number of the last of them, or
+    // ErrDeltaUnavailable once some of them have been pruned
+    Changes(ctx context.Context, since uint64) ([]Cutoff, []RevokedToken, uint64, error)
+}
+
+// bloom is a plain Bloom filter using double hashing, sized for capacity entries
+type bloom struct {
+    bits     []uint64
+    k        uint64
+    seed1    maphash.Seed
+    seed2    maphash.Seed
+    entries  int
+    capacity int
+}
+
+func newBloom(n int, p float64) *bloom {
+    if n < minFilterEntries {
+        n = minFilterEntries
+    }
+    m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+    k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+    if k < 1 {
+        k = 1
+    }
+    return &bloom{bits: make([]uint64, (m+63)/64), k: k, seed1: maphash.MakeSeed(), seed2: maphash.MakeSeed(), capacity: n}
+}
+
+func (b *bloom) positions(key string, fn func(word int, mask uint64) bool) bool {
+    h1 := maphash.String(b.seed1, key)
+    h2 := maphash.String(b.seed2, key) | 1
+    m := uint64(len(b.bits)) * 64
+    for i := uint64(0); i < b.k; i++ {
+        bit := (h1 + i*h2) % m
+        if !fn(int(bit/64), 1<<(bit%64)) {
+            return false
+        }
+    }
+    return true
+}
+
+func (b *bloom) add(key string) {
+    b.positions(key, func(word int, mask uint64) bool {
+        b.bits[word] |= mask
+        return true
+    })
+    b.entries++
+}
+
+func (b *bloom) mayContain(key string) bool {
+    return b.positions(key, func(word int, mask uint64) bool {
+        return b.bits[word]&mask != 0
+    })
+}
+
+func tokenKey(jti string) string {
+    return "j\x00" + jti
+}
+
+func predicateKey(p Predicate) string {
+    return "p\x00" + p.Tenant + "\x00" + p.Room + "\x00" + p.Identity
+}
+
+// FilteredStore puts a Bloom filter of every revoked jti and predicate in front of a shared
+// store, so the common not-revoked lookup is answered in memory, or with one read of a
+// ChangeFeed's sequence. Revocations announced on
+// the bus by a Revoker reach this instance's filter at once. When the store is a ChangeFeed,
+// every lookup first compares the feed's sequence with the filter's and adds the writes of
+// other instances it is missing, going to the store if it cannot; Run then only rebuilds
+// once the filter has outgrown its size. Other stores are rebuilt by Run every interval,
+// and revocations made elsewhere are missed until then. Until the first rebuild every
+// lookup goes to the store
+type FilteredStore struct {
+    store       Store
+    feed        ChangeFeed
+    clock       clock.Clock
+    interval    time.Duration
+    fpRate      float64
+    unsubscribe func()
+
+    // syncMu serializes rebuilds and catch-ups, so the sequence always matches the filter
+    syncMu sync.Mutex
+
+    mu         sync.RWMutex
+    filter     *bloom
+    seq        uint64
+    rebuilding bool
+    pending    []string
+    lastBuild  time.Time
+
+    lookups, skipped, fallthroughs, falsePositives, rebuilds, catchUps uint64
+}
+
+// NewFilteredStore filters lookups to store, which must implement Lister; bus may be nil for
+// a single instance
+func NewFilteredStore(store Store, bus events.Bus) *FilteredStore {
+    s := &FilteredStore{
+        store:    store,
+        clock:    clock.System,
+        interval: DefaultRebuildInterval,
+        fpRate:   DefaultFalsePositiveRate,
+    }
+    s.feed, _ = store.(ChangeFeed)
+    if bus != nil {
+        s.unsubscribe = bus.Subscribe(EventRevoked, s.handleEvent)
+    }
+    return s
+}
+
+// SetClock sets the time source for rebuild stamps
+func (s *FilteredStore) SetClock(c clock.Clock) *FilteredStore {
+    s.clock = c
+    return s
+}
+
+// SetRebuildInterval sets how often Run rebuilds the filter from the store, or catches up
+// with a ChangeFeed
+func (s *FilteredStore) SetRebuildInterval(d time.Duration) *FilteredStore {
+    s.interval = d
+    return s
+}
+
+// SetFalsePositiveRate sets the target false positive rate of rebuilt filters
+func (s *FilteredStore) SetFalsePositiveRate(p float64) *FilteredStore {
+    s.fpRate = p
+    return s
+}
+
+// RevokeWhere stores a bulk revocation and adds it to the filter
+func (s *FilteredStore) RevokeWhere(ctx context.Context, p Predicate, cutoff time.Time) error {
+    if err := s.store.RevokeWhere(ctx, p, cutoff); err != nil {
+        return err
+    }
+    s.add(predicateKey(p))
+    return nil
+}
+
+// RevokeToken stores a single-token revocation and adds it to the filter
+func (s *FilteredStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+    if err := s.store.RevokeToken(ctx, jti, expiresAt); err != nil {
+        return err
+    }
+    s.add(tokenKey(jti))
+    return nil
+}
+
+// IsRevoked asks the store only when the filter may contain one of the token's IDs or
+// matching predicates, or may be missing revocations made through other instances
+func (s *FilteredStore) IsRevoked(ctx context.Context, t Token) (bool, error) {
+    atomic.AddUint64(&s.lookups, 1)
+    if s.feed != nil && !s.current(ctx) {
+        atomic.AddUint64(&s.fallthroughs, 1)
+        return s.store.IsRevoked(ctx, t)
+    }
+    if s.mayBeRevoked(t) {
+        atomic.AddUint64(&s.fallthroughs, 1)
+        revoked, err := s.store.IsRevoked(ctx, t)
+        if err == nil && !revoked {
+            atomic.AddUint64(&s.falsePositives, 1)
+        }
+        return revoked, err
+    }
+    atomic.AddUint64(&s.skipped, 1)
+    return false, nil
+}
+
+func (s *FilteredStore) mayBeRevoked(t Token) bool {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    if s.filter == nil {
+        return true
+    }
+    for _, id := range t.IDs {
+        if s.filter.mayContain(tokenKey(id)) {
+            return true
+        }
+    }
+    for _, p := range t.candidates() {
+        if s.filter.mayContain(predicateKey(p)) {
+            return true
+        }
+    }
+    return false
+}
+
+// ListRevocations lists the underlying store
+func (s *FilteredStore) ListRevocations(ctx context.Context) ([]Cutoff, []RevokedToken, error) {
+    lister, ok := s.store.(Lister)
+    if !ok {
+        return nil, nil, ErrNoLister
+    }
+    return lister.ListRevocations(ctx)
+}
+
+// Prune prunes the underlying store and rebuilds the filter, so pruned revocations stop
+// sending lookups to the store
+func (s *FilteredStore) Prune(ctx context.Context, before time.Time, dryRun bool) (int, error) {
+    pruner, ok := s.store.(Pruner)
+    if !ok {
+        return 0, ErrNoPruner
+    }
+    n, err := pruner.Prune(ctx, before, dryRun)
+    if err != nil || dryRun || n == 0 {
+        return n, err
+    }
+    return n, s.Rebuild(ctx)
+}
+
+// current reports whether the filter holds every write of the change feed, catching up
+// with it first when it does not. A lookup racing a rebuild or another catch-up does not
+// wait for it
+func (s *FilteredStore) current(ctx context.Context) bool {
+    seq, err := s.feed.Sequence(ctx)
+    if err != nil {
+        return false
+    }
+    s.mu.RLock()
+    built, applied := s.filter != nil, s.seq
+    s.mu.RUnlock()
+    if !built || seq <= applied {
+        return built
+    }
+    if !s.syncMu.TryLock() {
+        return false
+    }
+    defer s.syncMu.Unlock()
+    return s.catchUpLocked(ctx) == nil
+}
+
+// CatchUp adds the change feed's writes the filter does not hold yet; it does nothing for a
+// store that is not a ChangeFeed
+func (s *FilteredStore) CatchUp(ctx context.Context) error {
+    if s.feed == nil {
+        return nil
+    }
+    s.syncMu.Lock()
+    defer s.syncMu.Unlock()
+    return s.catchUpLocked(ctx)
+}
+
+func (s *FilteredStore) catchUpLocked(ctx context.Context) error {
+    s.mu.RLock()
+    built, since := s.filter != nil, s.seq
+    s.mu.RUnlock()
+    if !built {
+        return s.rebuildLocked(ctx)
+    }
+    cutoffs, tokens, to, err := s.feed.Changes(ctx, since)
+    if errors.Is(err, ErrDeltaUnavailable) {
+        return s.rebuildLocked(ctx)
+    }
+    if err != nil {
+        return err
+    }
+
+    s.mu.Lock()
+    for _, c := range cutoffs {
+        s.filter.add(predicateKey(c.Predicate))
+    }
+    for _, t := range tokens {
+        s.filter.add(tokenKey(t.JTI))
+    }
+    if to > s.seq {
+        s.seq = to
+    }
+    s.mu.Unlock()
+    atomic.AddUint64(&s.catchUps, 1)
+    return nil
+}
+
+// Rebuild replaces the filter with one built from the store, sized for what it now holds
+func (s *FilteredStore) Rebuild(ctx context.Context) error {
+    s.syncMu.Lock()
+    defer s.syncMu.Unlock()
+    return s.rebuildLocked(ctx)
+}
+
+func (s *FilteredStore) rebuildLocked(ctx context.Context) error {
+    // The sequence is read first: a write racing the listing is then at worst added twice
+    var seq uint64
+    if s.feed != nil {
+        var err error
+        if seq, err = s.feed.Sequence(ctx); err != nil {
+            return err
+        }
+    }
+    // Writes during the listing are kept aside and added to the new filter, since the
+    // listing may or may not include them
+    s.mu.Lock()
+    s.rebuilding = true
+    s.pending = nil
+    s.mu.Unlock()
+
+    cutoffs, tokens, err := s.ListRevocations(ctx)
+    if err != nil {
+        s.mu.Lock()
+        s.rebuilding = false
+        s.pending = nil
+        s.mu.Unlock()
+        return err
+    }
+    f := newBloom(2*(len(cutoffs)+len(tokens)), s.fpRate)
+    for _, c := range cutoffs {
+        f.add(predicateKey(c.Predicate))
+    }
+    for _, t := range tokens {
+        f.add(tokenKey(t.JTI))
+    }
+
+    s.mu.Lock()
+    for _, key := range s.pending {
+        f.add(key)
+    }
+    s.filter = f
+    s.seq = seq
+    s.rebuilding = false
+    s.pending = nil
+    s.lastBuild = s.clock.Now()
+    s.mu.Unlock()
+    atomic.AddUint64(&s.rebuilds, 1)
+    return nil
+}
+
+// Run rebuilds until ctx is cancelled. With a ChangeFeed it catches up instead, and only
+// rebuilds once the filter holds more entries than it was sized for
+func (s *FilteredStore) Run(ctx context.Context) {
+    if err := s.Rebuild(ctx); err != nil {
+        log.Printf("revocation filter rebuild failed: %v", err)
+    }
+    ticker := time.NewTicker(s.interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            if s.feed != nil && !s.overfull() {
+                if err := s.CatchUp(ctx); err != nil {
+                    log.Printf("revocation filter catch-up failed: %v", err)
+                }
+                continue
+            }
+            if err := s.Rebuild(ctx); err != nil {
+                log.Printf("revocation filter rebuild failed: %v", err)
+            }
+        }
+    }
+}
+
+// overfull reports whether the filter is missing or past the false positive rate it was sized for
+func (s *FilteredStore) overfull() bool {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    return s.filter == nil || s.filter.entries > s.filter.capacity
+}
+
+// Stats reports lookup counts and the false positive fallthrough rate
+func (s *FilteredStore) Stats() FilterStats {
+    s.mu.RLock()
+    entries := 0
+    if s.filter != nil {
+        entries = s.filter.entries
+    }
+    last, seq := s.lastBuild, s.seq
+    s.mu.RUnlock()
+
+    st := FilterStats{
+        Lookups:        atomic.LoadUint64(&s.lookups),
+        Skipped:        atomic.LoadUint64(&s.skipped),
+        Fallthroughs:   atomic.LoadUint64(&s.fallthroughs),
+        FalsePositives: atomic.LoadUint64(&s.falsePositives),
+        Entries:        entries,
+        Rebuilds:       atomic.LoadUint64(&s.rebuilds),
+        LastRebuild:    last,
+        Sequence:       seq,
+        CatchUps:       atomic.LoadUint64(&s.catchUps),
+    }
+    if st.Lookups > 0 {
+        st.FalsePositiveRate = float64(st.FalsePositives) / float64(st.Lookups)
+    }
+    return st
+}
+
+// Close detaches the store from the event bus
+func (s *FilteredStore) Close() {
+    if s.unsubscribe != nil {
+        s.unsubscribe()
+    }
+}
+
+func (s *FilteredStore) add(key string) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if s.filter != nil {
+        s.filter.add(key)
+    }
+    if s.rebuilding {
+        s.pending = append(s.pending, key)
+    }
+}
+
+// handleEvent adds an announced revocation; writes through this store are seen twice, which is harmless
+func (s *FilteredStore) handleEvent(ctx context.Context, event events.Event) {
+    if jti, ok := event.Data["jti"]; ok {
+        s.add(tokenKey(jti))
+        return
+    }
+    s.add(predicateKey(Predicate{
+        Tenant:   event.Data["tenant"],
+        Room:     event.Data["room"],
+        Identity: event.Data["identity"],
+    }))
+}
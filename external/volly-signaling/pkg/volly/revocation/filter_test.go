@@ -0,0 +1,96 @@
+package revocation
+
+import (
+    "context"
+    "sync"
+    "testing"
+    "time"
+)
+
+// feedStore is a MemoryStore shared by several instances that logs its writes as a ChangeFeed
+type feedStore struct {
+    *MemoryStore
+
+    mu     sync.Mutex
+    first  uint64
+    writes []RevokedToken
+}
+
+func (s *feedStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+    if err := s.MemoryStore.RevokeToken(ctx, jti, expiresAt); err != nil {
+        return err
+    }
+    s.mu.Lock()
+    s.writes = append(s.writes, RevokedToken{JTI: jti, ExpiresAt: expiresAt})
+    s.mu.Unlock()
+    return nil
+}
+
+func (s *feedStore) Sequence(ctx context.Context) (uint64, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.first + uint64(len(s.writes)), nil
+}
+
+func (s *feedStore) Changes(ctx context.Context, since uint64) ([]Cutoff, []RevokedToken, uint64, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if since < s.first {
+        return nil, nil, 0, ErrDeltaUnavailable
+    }
+    return nil, append([]RevokedToken(nil), s.writes[since-s.first:]...), s.first + uint64(len(s.writes)), nil
+}
+
+// prune drops the logged writes, as a store pruning its change log would
+func (s *feedStore) prune() {
+    s.mu.Lock()
+    s.first += uint64(len(s.writes))
+    s.writes = nil
+    s.mu.Unlock()
+}
+
+// TestFilterFollowsOtherInstances revokes through one instance's filter and checks another
+// instance's filter refuses the token on its next lookup, with no bus and no rebuild, and
+// that it relists the store once the writes it missed have been pruned
+func TestFilterFollowsOtherInstances(t *testing.T) {
+    ctx := context.Background()
+    store := &feedStore{MemoryStore: NewMemoryStore()}
+    a, b := NewFilteredStore(store, nil), NewFilteredStore(store, nil)
+    for _, f := range []*FilteredStore{a, b} {
+        if err := f.Rebuild(ctx); err != nil {
+            t.Fatal(err)
+        }
+    }
+    isRevoked := func(jti string) bool {
+        t.Helper()
+        revoked, err := a.IsRevoked(ctx, Token{IDs: []string{jti}, Tenant: "acme", Identity: "alice", IssuedAt: time.Now()})
+        if err != nil {
+            t.Fatal(err)
+        }
+        return revoked
+    }
+    if isRevoked("t1") {
+        t.Fatal("t1 revoked before anyone revoked it")
+    }
+
+    if err := b.RevokeToken(ctx, "t1", time.Now().Add(time.Hour)); err != nil {
+        t.Fatal(err)
+    }
+    if !isRevoked("t1") {
+        t.Fatal("t1 not revoked on the other instance")
+    }
+    if st := a.Stats(); st.Rebuilds != 1 || st.CatchUps != 1 || st.Sequence != 1 {
+        t.Fatalf("stats %+v, want one rebuild, one catch-up and sequence 1", st)
+    }
+
+    if err := b.RevokeToken(ctx, "t2", time.Now().Add(time.Hour)); err != nil {
+        t.Fatal(err)
+    }
+    store.prune()
+    if !isRevoked("t2") {
+        t.Fatal("t2 not revoked once its change was pruned")
+    }
+    if st := a.Stats(); st.Rebuilds != 2 {
+        t.Fatalf("%d rebuilds, want the missing change to force a second", st.Rebuilds)
+    }
+}
@@ -0,0 +1,304 @@
+package revocation
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/events"
+    "github.com/volly-org/volly-signaling/pkg/volly/gateway"
+)
+
+var (
+    ErrTokenRevoked   = errors.New("token has been revoked")
+    ErrEmptyPredicate = errors.New("revocation predicate must name a tenant, room or identity")
+)
+
+// Predicate selects tokens by tenant, room and identity; empty fields match anything
+type Predicate struct {
+    Tenant   string `json:"tenant,omitempty"`
+    Room     string `json:"room,omitempty"`
+    Identity string `json:"identity,omitempty"`
+}
+
+// Token is what revocation needs to know about a verified token
+type Token struct {
+    // IDs holds the token's jti and those of its attenuation ancestors
+    IDs      []string
+    Tenant   string
+    Room     string
+    Identity string
+    IssuedAt time.Time
+}
+
+// TokenFromGrant extracts a Token from a verified grant
+func TokenFromGrant(grant *auth.VollyVideoGrant) Token {
+    t := Token{
+        Tenant:   grant.Tenant,
+        Room:     grant.Room,
+        Identity: grant.Identity,
+    }
+    if grant.TokenID != "" {
+        t.IDs = append(t.IDs, grant.TokenID)
+    }
+    t.IDs = append(t.IDs, grant.TokenChain...)
+    if grant.IssuedAt != 0 {
+        t.IssuedAt = time.Unix(grant.IssuedAt, 0)
+    }
+    return t
+}
+
+// candidates lists every predicate that could match t, so a lookup costs seven map probes
+// however many predicates have been revoked
+func (t Token) candidates() []Predicate {
+    var out []Predicate
+    for mask := 1; mask < 8; mask++ {
+        var p Predicate
+        if mask&1 != 0 {
+            p.Tenant = t.Tenant
+        }
+        if mask&2 != 0 {
+            p.Room = t.Room
+        }
+        if mask&4 != 0 {
+            p.Identity = t.Identity
+        }
+        if (mask&1 == 0 || p.Tenant != "") && (mask&2 == 0 || p.Room != "") && (mask&4 == 0 || p.Identity != "") {
+            out = append(out, p)
+        }
+    }
+    return out
+}
+
+// Store persists revocations. Bulk revocation keeps one issuance cutoff per predicate rather
+// than enumerating jti values: matching tokens issued at or before it are revoked
+type Store interface {
+    RevokeWhere(ctx context.Context, p Predicate, cutoff time.Time) error
+    RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+    IsRevoked(ctx context.Context, t Token) (bool, error)
+}
+
+// Cutoff is a bulk revocation as stored
+type Cutoff struct {
+    Predicate Predicate `json:"predicate"`
+    Cutoff    time.Time `json:"cutoff"`
+}
+
+// RevokedToken is a single-token revocation as stored
+type RevokedToken struct {
+    JTI       string    `json:"jti"`
+    ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Lister is implemented by stores that can enumerate active revocations for export
+type Lister interface {
+    ListRevocations(ctx context.Context) ([]Cutoff, []RevokedToken, error)
+}
+
+// Pruner is implemented by stores that can drop revocations no live token can match: those
+// from, or expired, before before
+type Pruner interface {
+    Prune(ctx context.Context, before time.Time, dryRun bool) (int, error)
+}
+
+// Revoker revokes tokens and rejects revoked ones at admission
+type Revoker struct {
+    store Store
+    clock clock.Clock
+    bus   events.Bus
+}
+
+// NewRevoker creates a revoker backed by store
+func NewRevoker(store Store) *Revoker {
+    return &Revoker{store: store, clock: clock.System}
+}
+
+// SetClock sets the time source for revocation cutoffs
+func (r *Revoker) SetClock(c clock.Clock) *Revoker {
+    r.clock = c
+    return r
+}
+
+// SetEventBus announces every revocation as EventRevoked, so filters and verification
+// caches on every instance learn of it without waiting for a resync
+func (r *Revoker) SetEventBus(bus events.Bus) *Revoker {
+    r.bus = bus
+    return r
+}
+
+// RevokeWhere revokes every token matching p issued up to now, e.g. all tokens of a tenant.
+// iat has one second resolution, so tokens minted in the same second are revoked too
+func (r *Revoker) RevokeWhere(ctx context.Context, p Predicate) error {
+    if p == (Predicate{}) {
+        return ErrEmptyPredicate
+    }
+    if err := r.store.RevokeWhere(ctx, p, r.clock.Now()); err != nil {
+        return err
+    }
+    return r.publish(ctx, map[string]string{"tenant": p.Tenant, "room": p.Room, "identity": p.Identity})
+}
+
+// Revoke revokes a single token and everything attenuated from it until expiresAt
+func (r *Revoker) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+    if err := r.store.RevokeToken(ctx, jti, expiresAt); err != nil {
+        return err
+    }
+    return r.publish(ctx, map[string]string{"jti": jti})
+}
+
+func (r *Revoker) publish(ctx context.Context, data map[string]string) error {
+    if r.bus == nil {
+        return nil
+    }
+    return r.bus.Publish(ctx, events.Event{Type: EventRevoked, Data: data})
+}
+
+// Check returns ErrTokenRevoked for revoked grants, as a *DecoyError when the grant is or
+// descends from a decoy
+func (r *Revoker) Check(ctx context.Context, grant *auth.VollyVideoGrant) error {
+    t := TokenFromGrant(grant)
+    revoked, err := r.store.IsRevoked(ctx, t)
+    if err != nil {
+        return err
+    }
+    if !revoked {
+        return nil
+    }
+    jti, err := r.decoy(ctx, t.IDs)
+    if err != nil {
+        return err
+    }
+    if jti != "" {
+        return &DecoyError{JTI: jti, Tenant: t.Tenant, Room: t.Room, Identity: t.Identity}
+    }
+    return ErrTokenRevoked
+}
+
+// Admit rejects connections whose token has been revoked
+func (r *Revoker) Admit(ctx context.Context, a *gateway.Admission) error {
+    if a.Grant == nil {
+        return nil
+    }
+    return r.Check(ctx, a.Grant)
+}
+
+// MemoryStore is an in-process Store for single-instance deployments
+type MemoryStore struct {
+    clock clock.Clock
+
+    mu      sync.RWMutex
+    cutoffs map[Predicate]time.Time
+    tokens  map[string]time.Time
+    decoys  map[string]time.Time
+}
+
+// NewMemoryStore creates an empty store
+func NewMemoryStore() *MemoryStore {
+    return &MemoryStore{
+        clock:   clock.System,
+        cutoffs: make(map[Predicate]time.Time),
+        tokens:  make(map[string]time.Time),
+        decoys:  make(map[string]time.Time),
+    }
+}
+
+// SetClock sets the time source used to prune expired token revocations
+func (s *MemoryStore) SetClock(c clock.Clock) *MemoryStore {
+    s.clock = c
+    return s
+}
+
+// RevokeWhere keeps the latest cutoff per predicate
+func (s *MemoryStore) RevokeWhere(ctx context.Context, p Predicate, cutoff time.Time) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if cutoff.After(s.cutoffs[p]) {
+        s.cutoffs[p] = cutoff
+    }
+    return nil
+}
+
+// RevokeToken records a jti until it would have expired anyway
+func (s *MemoryStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    now := s.clock.Now()
+    for id, exp := range s.tokens {
+        if now.After(exp) {
+            delete(s.tokens, id)
+        }
+    }
+    s.tokens[jti] = expiresAt
+    return nil
+}
+
+// IsRevoked checks the token's IDs and every predicate that could match it
+func (s *MemoryStore) IsRevoked(ctx context.Context, t Token) (bool, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    for _, id := range t.IDs {
+        if _, ok := s.tokens[id]; ok {
+            return true, nil
+        }
+    }
+    issued := t.IssuedAt.Unix()
+    for _, p := range t.candidates() {
+        if cutoff, ok := s.cutoffs[p]; ok && issued <= cutoff.Unix() {
+            return true, nil
+        }
+    }
+    return false, nil
+}
+
+// Prune drops, or with dryRun counts, the cutoffs from before before and the token
+// revocations that expired before it. A cutoff only matters while tokens issued before it
+// can still be live, so before must be at least the longest token lifetime ago
+func (s *MemoryStore) Prune(ctx context.Context, before time.Time, dryRun bool) (int, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    n := 0
+    for p, cutoff := range s.cutoffs {
+        if cutoff.Before(before) {
+            if !dryRun {
+                delete(s.cutoffs, p)
+            }
+            n++
+        }
+    }
+    for jti, exp := range s.tokens {
+        if exp.Before(before) {
+            if !dryRun {
+                delete(s.tokens, jti)
+                delete(s.decoys, jti)
+            }
+            n++
+        }
+    }
+    return n, nil
+}
+
+// ListRevocations returns every cutoff and every unexpired token revocation
+func (s *MemoryStore) ListRevocations(ctx context.Context) ([]Cutoff, []RevokedToken, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    now := s.clock.Now()
+    cutoffs := make([]Cutoff, 0, len(s.cutoffs))
+    for p, c := range s.cutoffs {
+        cutoffs = append(cutoffs, Cutoff{Predicate: p, Cutoff: c})
+    }
+    var tokens []RevokedToken
+    for jti, exp := range s.tokens {
+        if !now.After(exp) {
+            tokens = append(tokens, RevokedToken{JTI: jti, ExpiresAt: exp})
+        }
+    }
+    return cutoffs, tokens, nil
+}
@@ -0,0 +1,156 @@
+// Package rollout ramps token features, such as a new claim layout or signature format, to a
+// percentage of identities. An identity's cohort comes from a hash of the feature name and
+// the identity, so it is the same on every issuer and verifier, raising the percentage only
+// adds identities, and setting it to zero rolls everyone back. Outcomes are counted by cohort
+// so a ramp can be judged against the identities still on the old path
+package rollout
+
+import (
+    "crypto/sha256"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "sort"
+    "sync"
+)
+
+// Cohorts an identity falls in for a feature
+const (
+    Treatment = "treatment"
+    Control   = "control"
+)
+
+// buckets is the resolution of a percentage, so 0.01% steps are honoured
+const buckets = 10000
+
+var ErrInvalidPercent = errors.New("rollout percent must be between 0 and 100")
+
+// Bucket returns identity's position for feature in [0, 100). Positions for different
+// features are independent, so the same identities do not go first on every ramp
+func Bucket(feature, identity string) float64 {
+    sum := sha256.Sum256([]byte(feature + "\x00" + identity))
+    return float64(binary.BigEndian.Uint64(sum[:8])%buckets) * 100 / buckets
+}
+
+// Count is how many times an outcome was observed in one cohort of a feature
+type Count struct {
+    Feature string `json:"feature"`
+    Cohort  string `json:"cohort"`
+    Outcome string `json:"outcome"`
+    Count   int64  `json:"count"`
+}
+
+type countKey struct {
+    feature, cohort, outcome string
+}
+
+// Rollout holds the percentage of each feature and the outcomes seen by cohort. A feature
+// with no percentage is off. Safe for concurrent use
+type Rollout struct {
+    mu       sync.RWMutex
+    percents map[string]float64
+    counts   map[countKey]int64
+}
+
+// New creates a rollout with every feature off
+func New() *Rollout {
+    return &Rollout{percents: make(map[string]float64), counts: make(map[countKey]int64)}
+}
+
+// SetPercent rolls feature out to percent of identities
+func (r *Rollout) SetPercent(feature string, percent float64) error {
+    if !(percent >= 0 && percent <= 100) {
+        return fmt.Errorf("%w: %s at %v", ErrInvalidPercent, feature, percent)
+    }
+    r.mu.Lock()
+    r.percents[feature] = percent
+    r.mu.Unlock()
+    return nil
+}
+
+// Percents returns the percentage of every feature that has one
+func (r *Rollout) Percents() map[string]float64 {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+    out := make(map[string]float64, len(r.percents))
+    for k, v := range r.percents {
+        out[k] = v
+    }
+    return out
+}
+
+// Enabled reports whether identity gets feature
+func (r *Rollout) Enabled(feature, identity string) bool {
+    r.mu.RLock()
+    percent, ok := r.percents[feature]
+    r.mu.RUnlock()
+    return ok && Bucket(feature, identity) < percent
+}
+
+// Cohort names the cohort identity is in for feature
+func (r *Rollout) Cohort(feature, identity string) string {
+    if r.Enabled(feature, identity) {
+        return Treatment
+    }
+    return Control
+}
+
+// Observe counts outcome, e.g. "issued" or "rejected", for identity under every feature
+// with a percentage
+func (r *Rollout) Observe(identity, outcome string) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    for feature, percent := range r.percents {
+        cohort := Control
+        if Bucket(feature, identity) < percent {
+            cohort = Treatment
+        }
+        r.counts[countKey{feature, cohort, outcome}]++
+    }
+}
+
+// Stats returns the counts so far, ordered by feature, cohort and outcome
+func (r *Rollout) Stats() []Count {
+    r.mu.RLock()
+    out := make([]Count, 0, len(r.counts))
+    for k, n := range r.counts {
+        out = append(out, Count{Feature: k.feature, Cohort: k.cohort, Outcome: k.outcome, Count: n})
+    }
+    r.mu.RUnlock()
+    sort.Slice(out, func(i, j int) bool {
+        a, b := out[i], out[j]
+        if a.Feature != b.Feature {
+            return a.Feature < b.Feature
+        }
+        if a.Cohort != b.Cohort {
+            return a.Cohort < b.Cohort
+        }
+        return a.Outcome < b.Outcome
+    })
+    return out
+}
+
+// WriteMetrics writes the percentages and counts in the Prometheus text format
+func (r *Rollout) WriteMetrics(w io.Writer) error {
+    percents := r.Percents()
+    features := make([]string, 0, len(percents))
+    for f := range percents {
+        features = append(features, f)
+    }
+    sort.Strings(features)
+    metric := func(name, kind, help string) {
+        fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+    }
+    metric("volly_rollout_percent", "gauge", "Percentage of identities a token feature is rolled out to.")
+    for _, f := range features {
+        fmt.Fprintf(w, "volly_rollout_percent{feature=%q} %g\n", f, percents[f])
+    }
+    metric("volly_rollout_outcomes_total", "counter", "Token outcomes by feature and cohort.")
+    for _, c := range r.Stats() {
+        if _, err := fmt.Fprintf(w, "volly_rollout_outcomes_total{feature=%q,cohort=%q,outcome=%q} %d\n", c.Feature, c.Cohort, c.Outcome, c.Count); err != nil {
+            return err
+        }
+    }
+    return nil
+}
@@ -0,0 +1,97 @@
+package roompin
+
+import (
+    "context"
+    "sync"
+    "time"
+)
+
+// AttemptStore counts wrong PINs per scope, an identity in a room, a whole room or a remote
+// address, and keeps their lockouts. Gateway instances that share one see each other's
+// guesses, so spreading them across instances gains nothing
+type AttemptStore interface {
+    // Fail counts a wrong PIN against scope and returns its count within window, which
+    // starts over once window has passed since the first
+    Fail(ctx context.Context, scope string, now time.Time, window time.Duration) (int, error)
+    // Lock locks scope out until until and starts its count over
+    Lock(ctx context.Context, scope string, until time.Time) error
+    // LockedUntil returns when scope's lockout ends, or the zero time when it is not locked
+    LockedUntil(ctx context.Context, scope string, now time.Time) (time.Time, error)
+    // Reset forgets scope's wrong PINs and lifts its lockout
+    Reset(ctx context.Context, scope string) error
+}
+
+// attempts counts one scope's wrong PINs
+type attempts struct {
+    first       time.Time
+    count       int
+    lockedUntil time.Time
+}
+
+// MemoryAttemptStore keeps attempts in this process, for single-instance deployments
+type MemoryAttemptStore struct {
+    mu       sync.Mutex
+    attempts map[string]*attempts
+}
+
+// NewMemoryAttemptStore creates an empty store
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+    return &MemoryAttemptStore{attempts: make(map[string]*attempts)}
+}
+
+func (s *MemoryAttemptStore) Fail(ctx context.Context, scope string, now time.Time, window time.Duration) (int, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    at := s.attempts[scope]
+    if at == nil || now.Sub(at.first) > window {
+        if at == nil {
+            s.prune(now, window)
+            at = &attempts{}
+            s.attempts[scope] = at
+        }
+        at.first, at.count = now, 0
+    }
+    at.count++
+    return at.count, nil
+}
+
+func (s *MemoryAttemptStore) Lock(ctx context.Context, scope string, until time.Time) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    at := s.attempts[scope]
+    if at == nil {
+        at = &attempts{}
+        s.attempts[scope] = at
+    }
+    if until.After(at.lockedUntil) {
+        at.lockedUntil = until
+    }
+    at.first, at.count = time.Time{}, 0
+    return nil
+}
+
+func (s *MemoryAttemptStore) LockedUntil(ctx context.Context, scope string, now time.Time) (time.Time, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    at := s.attempts[scope]
+    if at == nil || !now.Before(at.lockedUntil) {
+        return time.Time{}, nil
+    }
+    return at.lockedUntil, nil
+}
+
+func (s *MemoryAttemptStore) Reset(ctx context.Context, scope string) error {
+    s.mu.Lock()
+    delete(s.attempts, scope)
+    s.mu.Unlock()
+    return nil
+}
+
+// prune drops counters whose window and lockout are both over; call it with s.mu held
+func (s *MemoryAttemptStore) prune(now time.Time, window time.Duration) {
+    for scope, at := range s.attempts {
+        if now.Sub(at.first) > window && !now.Before(at.lockedUntil) {
+            delete(s.attempts, scope)
+        }
+    }
+}
@@ -0,0 +1,321 @@
+// Package roompin makes rooms ask for an access code before admitting a participant, for
+// rooms that let in less-trusted dial-in style callers. A PIN comes from a pinHash claim in
+// the token, or from the room policy covering the room, and the participant must present it
+// at admission. Wrong PINs are counted per identity in a room, per room and per remote
+// address, and too many lock that identity, room or address out for a while
+package roompin
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "net/netip"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/configstore"
+    "github.com/volly-org/volly-signaling/pkg/volly/gateway"
+)
+
+const (
+    // DefaultMaxAttempts wrong PINs within DefaultWindow lock the identity out of the room,
+    // DefaultRoomAttempts lock the room and DefaultRemoteIPAttempts the remote address
+    DefaultMaxAttempts      = 5
+    DefaultRoomAttempts     = 50
+    DefaultRemoteIPAttempts = 20
+    DefaultWindow           = 15 * time.Minute
+    DefaultLockout          = 15 * time.Minute
+)
+
+const (
+    minPINLength = 4
+    maxPINLength = 12
+)
+
+var (
+    ErrInvalidPIN  = errors.New("a PIN is 4 to 12 digits")
+    ErrPINRequired = errors.New("room requires a PIN")
+    ErrWrongPIN    = errors.New("wrong room PIN")
+    ErrLockedOut   = errors.New("too many wrong PINs for this room")
+)
+
+// LockedError is ErrLockedOut with when the lockout ends
+type LockedError struct {
+    Until time.Time
+}
+
+func (e *LockedError) Error() string {
+    return fmt.Sprintf("%s until %s", ErrLockedOut, e.Until.UTC().Format(time.RFC3339))
+}
+
+func (e *LockedError) Unwrap() error { return ErrLockedOut }
+
+// Lockout reports an identity locked out of a room, a whole room, with no Identity, or a
+// remote address, with only Tenant and RemoteIP
+type Lockout struct {
+    Tenant   string
+    Room     string
+    Identity string
+    RemoteIP netip.Addr
+    Attempts int
+    Until    time.Time
+}
+
+// scope is the AttemptStore key the lockout is kept under
+func (l Lockout) scope() string {
+    switch {
+    case l.RemoteIP.IsValid():
+        return remoteIPScope(l.Tenant, l.RemoteIP)
+    case l.Identity == "":
+        return roomScope(l.Tenant, l.Room)
+    default:
+        return identityScope(l.Tenant, l.Room, l.Identity)
+    }
+}
+
+func identityScope(tenant, room, identity string) string {
+    return "identity\x00" + tenant + "\x00" + room + "\x00" + identity
+}
+
+func roomScope(tenant, room string) string {
+    return "room\x00" + tenant + "\x00" + room
+}
+
+func remoteIPScope(tenant string, addr netip.Addr) string {
+    return "ip\x00" + tenant + "\x00" + addr.Unmap().String()
+}
+
+// ValidPIN checks that pin is 4 to 12 digits
+func ValidPIN(pin string) error {
+    if len(pin) < minPINLength || len(pin) > maxPINLength {
+        return ErrInvalidPIN
+    }
+    for _, c := range pin {
+        if c < '0' || c > '9' {
+            return ErrInvalidPIN
+        }
+    }
+    return nil
+}
+
+// Hasher derives PIN hashes. They are keyed, so a pinHash read off a token cannot be
+// brute-forced offline without the key; tokend and every gateway must share it
+type Hasher struct {
+    key []byte
+}
+
+// NewHasher creates a hasher keyed by key
+func NewHasher(key []byte) *Hasher {
+    return &Hasher{key: key}
+}
+
+// Hash returns the hash of tenant's PIN, base64url encoded
+func (h *Hasher) Hash(tenant, pin string) (string, error) {
+    if err := ValidPIN(pin); err != nil {
+        return "", err
+    }
+    return h.hash(tenant, pin), nil
+}
+
+func (h *Hasher) hash(tenant, pin string) string {
+    mac := hmac.New(sha256.New, h.key)
+    mac.Write([]byte(tenant))
+    mac.Write([]byte{0})
+    mac.Write([]byte(pin))
+    return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Gate is an admission hook asking for the PIN of the grant's pinHash claim and of every
+// active room policy with a PINHash covering the room. A connection without the PIN is
+// refused with ErrPINRequired, which the edge answers by prompting for it
+type Gate struct {
+    hasher      *Hasher
+    source      gateway.PolicySource
+    store       AttemptStore
+    maxAttempts int
+    roomMax     int
+    remoteIPMax int
+    window      time.Duration
+    lockout     time.Duration
+    clock       clock.Clock
+    report      func(ctx context.Context, l Lockout)
+}
+
+// NewGate checks PINs with hasher; source, which may be nil, supplies room policy PINs
+func NewGate(hasher *Hasher, source gateway.PolicySource) *Gate {
+    return &Gate{
+        hasher:      hasher,
+        source:      source,
+        store:       NewMemoryAttemptStore(),
+        maxAttempts: DefaultMaxAttempts,
+        roomMax:     DefaultRoomAttempts,
+        remoteIPMax: DefaultRemoteIPAttempts,
+        window:      DefaultWindow,
+        lockout:     DefaultLockout,
+        clock:       clock.System,
+    }
+}
+
+// SetAttemptStore keeps wrong PINs and lockouts in store, which gateway instances share so
+// a guesser cannot spread attempts across them
+func (g *Gate) SetAttemptStore(store AttemptStore) *Gate {
+    g.store = store
+    return g
+}
+
+// SetBudgets locks a whole room after roomAttempts wrong PINs within the window, whoever
+// entered them, and a remote address after remoteIPAttempts in any room; zero disables either
+func (g *Gate) SetBudgets(roomAttempts, remoteIPAttempts int) *Gate {
+    g.roomMax = roomAttempts
+    g.remoteIPMax = remoteIPAttempts
+    return g
+}
+
+// SetLimits locks an identity out of a room for lockout after maxAttempts wrong PINs within
+// window
+func (g *Gate) SetLimits(maxAttempts int, window, lockout time.Duration) *Gate {
+    g.maxAttempts = maxAttempts
+    g.window = window
+    g.lockout = lockout
+    return g
+}
+
+// SetClock sets the time source for windows and lockouts
+func (g *Gate) SetClock(c clock.Clock) *Gate {
+    g.clock = c
+    return g
+}
+
+// SetLockoutReport sets the function told of every lockout this gate starts
+func (g *Gate) SetLockoutReport(report func(ctx context.Context, l Lockout)) *Gate {
+    g.report = report
+    return g
+}
+
+// budget is how many wrong PINs one scope gets within the window
+type budget struct {
+    lockout Lockout
+    max     int
+}
+
+// budgets lists the scopes a connection's wrong PINs count against
+func (g *Gate) budgets(a *gateway.Admission) []budget {
+    tenant := a.Grant.Tenant
+    b := []budget{
+        {Lockout{Tenant: tenant, Room: a.Room, Identity: a.Identity}, g.maxAttempts},
+        {Lockout{Tenant: tenant, Room: a.Room}, g.roomMax},
+    }
+    if a.RemoteIP.IsValid() {
+        b = append(b, budget{Lockout{Tenant: tenant, RemoteIP: a.RemoteIP}, g.remoteIPMax})
+    }
+    return b
+}
+
+// Admit refuses connections to PIN rooms that do not carry the right PIN, and everyone in a
+// locked room or from a locked address
+func (g *Gate) Admit(ctx context.Context, a *gateway.Admission) error {
+    if a.Grant == nil {
+        return nil
+    }
+    hashes, err := g.required(ctx, a)
+    if err != nil || len(hashes) == 0 {
+        return err
+    }
+    budgets := g.budgets(a)
+    now := g.clock.Now()
+    for _, b := range budgets {
+        until, err := g.store.LockedUntil(ctx, b.lockout.scope(), now)
+        if err != nil {
+            return err
+        }
+        if !until.IsZero() {
+            return &LockedError{Until: until}
+        }
+    }
+    if a.PIN == "" {
+        return ErrPINRequired
+    }
+    got := g.hasher.hash(a.Grant.Tenant, a.PIN)
+    for _, want := range hashes {
+        if !hmac.Equal([]byte(got), []byte(want)) {
+            return g.fail(ctx, budgets, now)
+        }
+    }
+    return g.store.Reset(ctx, budgets[0].lockout.scope())
+}
+
+// Lock applies a lockout, e.g. one another instance reported
+func (g *Gate) Lock(ctx context.Context, l Lockout) error {
+    return g.store.Lock(ctx, l.scope(), l.Until)
+}
+
+// Unlock lifts the lockouts of identity in room and of the room itself, and forgets their
+// wrong PINs
+func (g *Gate) Unlock(ctx context.Context, tenant, room, identity string) error {
+    if err := g.store.Reset(ctx, identityScope(tenant, room, identity)); err != nil {
+        return err
+    }
+    return g.store.Reset(ctx, roomScope(tenant, room))
+}
+
+// required lists the PIN hashes a connection must match
+func (g *Gate) required(ctx context.Context, a *gateway.Admission) ([]string, error) {
+    var hashes []string
+    if a.Grant.PINHash != "" {
+        hashes = append(hashes, a.Grant.PINHash)
+    }
+    if g.source == nil {
+        return hashes, nil
+    }
+    policies, err := g.source.ListRoomPolicies(ctx, a.Grant.Tenant)
+    if err != nil {
+        return nil, err
+    }
+    for _, p := range policies {
+        if policyPIN(p, a.Room) {
+            hashes = append(hashes, p.PINHash)
+        }
+    }
+    return hashes, nil
+}
+
+// policyPIN reports whether p asks for a PIN in room; shadow policies never do
+func policyPIN(p configstore.RoomPolicy, room string) bool {
+    return p.PINHash != "" && !p.Shadow && gateway.MatchRoom(p.Room, room)
+}
+
+// fail counts a wrong PIN against every budget and locks out each scope it exhausts
+func (g *Gate) fail(ctx context.Context, budgets []budget, now time.Time) error {
+    var locked *LockedError
+    for _, b := range budgets {
+        if b.max <= 0 {
+            continue
+        }
+        scope := b.lockout.scope()
+        n, err := g.store.Fail(ctx, scope, now, g.window)
+        if err != nil {
+            return err
+        }
+        if n < b.max {
+            continue
+        }
+        l := b.lockout
+        l.Attempts, l.Until = n, now.Add(g.lockout)
+        if err := g.store.Lock(ctx, scope, l.Until); err != nil {
+            return err
+        }
+        if g.report != nil {
+            g.report(ctx, l)
+        }
+        if locked == nil || l.Until.After(locked.Until) {
+            locked = &LockedError{Until: l.Until}
+        }
+    }
+    if locked != nil {
+        return locked
+    }
+    return ErrWrongPIN
+}
@@ -0,0 +1,73 @@
+package roompin
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/netip"
+    "testing"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/gateway"
+)
+
+// TestGuessingAcrossIdentities guesses a room's PIN with a fresh identity for every guess,
+// spread over two instances sharing one attempt store, and checks the remote address is
+// locked out first and then the whole room once guesses come from other addresses too
+func TestGuessingAcrossIdentities(t *testing.T) {
+    ctx := context.Background()
+    hasher := NewHasher([]byte("0123456789abcdef0123456789abcdef"))
+    hash, err := hasher.Hash("acme", "4242")
+    if err != nil {
+        t.Fatal(err)
+    }
+    store := NewMemoryAttemptStore()
+    gates := []*Gate{
+        NewGate(hasher, nil).SetAttemptStore(store).SetLimits(3, time.Hour, time.Hour).SetBudgets(10, 5),
+        NewGate(hasher, nil).SetAttemptStore(store).SetLimits(3, time.Hour, time.Hour).SetBudgets(10, 5),
+    }
+    admit := func(i int, addr, pin string) error {
+        a := &gateway.Admission{
+            Identity: fmt.Sprintf("guest-%d", i),
+            Room:     "board",
+            RemoteIP: netip.MustParseAddr(addr),
+            Grant:    &auth.VollyVideoGrant{Tenant: "acme", PINHash: hash},
+            PIN:      pin,
+        }
+        return gates[i%len(gates)].Admit(ctx, a)
+    }
+
+    for i := 0; i < 4; i++ {
+        if err := admit(i, "192.0.2.1", fmt.Sprintf("%04d", i)); !errors.Is(err, ErrWrongPIN) {
+            t.Fatalf("guess %d: got %v, want ErrWrongPIN", i, err)
+        }
+    }
+    if err := admit(4, "192.0.2.1", "0004"); !errors.Is(err, ErrLockedOut) {
+        t.Fatalf("fifth guess from one address: got %v, want ErrLockedOut", err)
+    }
+    if err := admit(5, "192.0.2.1", "4242"); !errors.Is(err, ErrLockedOut) {
+        t.Fatalf("right PIN from the locked address: got %v, want ErrLockedOut", err)
+    }
+    if err := admit(6, "198.51.100.1", "4242"); err != nil {
+        t.Fatalf("right PIN from another address: %v", err)
+    }
+
+    for i := 0; i < 4; i++ {
+        if err := admit(10+i, fmt.Sprintf("203.0.113.%d", i+1), "1111"); !errors.Is(err, ErrWrongPIN) {
+            t.Fatalf("guess %d from its own address: got %v, want ErrWrongPIN", i, err)
+        }
+    }
+    if err := admit(14, "203.0.113.5", "1111"); !errors.Is(err, ErrLockedOut) {
+        t.Fatalf("tenth guess in the room: got %v, want ErrLockedOut", err)
+    }
+    if err := admit(20, "198.51.100.2", "4242"); !errors.Is(err, ErrLockedOut) {
+        t.Fatalf("right PIN in the locked room: got %v, want ErrLockedOut", err)
+    }
+    if err := gates[0].Unlock(ctx, "acme", "board", "guest-20"); err != nil {
+        t.Fatal(err)
+    }
+    if err := admit(20, "198.51.100.2", "4242"); err != nil {
+        t.Fatalf("right PIN once the room was unlocked: %v", err)
+    }
+}
@@ -0,0 +1,285 @@
+package rooms
+
+import (
+    "context"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "encoding/json"
+    "errors"
+    "sort"
+    "strings"
+    "sync"
+
+    "github.com/livekit/protocol/livekit"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/events"
+    "github.com/volly-org/volly-signaling/pkg/volly/secure"
+)
+
+// EventAttributesUpdated is published when a participant's attributes change; Data lists the
+// changed keys visible to the room, never server-only ones
+const EventAttributesUpdated = "participant_attributes_updated"
+
+var (
+    ErrInvalidAttribute  = errors.New("attribute key is empty or visibility is unknown")
+    ErrNoAttributeKey    = errors.New("server-only attributes need an encryption key")
+    ErrAttributeTampered = errors.New("server-only attribute failed to decrypt")
+)
+
+// Visibility controls who may read an attribute
+type Visibility string
+
+const (
+    // VisibilityPublic attributes may be shown to anyone who can see the participant
+    VisibilityPublic Visibility = "public"
+    // VisibilityRoom attributes are only shared with participants in the same room
+    VisibilityRoom Visibility = "room"
+    // VisibilityServer attributes are encrypted at rest and never leave the server
+    VisibilityServer Visibility = "server"
+)
+
+func (v Visibility) level() int {
+    switch v {
+    case VisibilityPublic:
+        return 0
+    case VisibilityRoom:
+        return 1
+    case VisibilityServer:
+        return 2
+    }
+    return -1
+}
+
+// Attribute is a participant key-value pair
+type Attribute struct {
+    Key        string     `json:"key"`
+    Value      string     `json:"value"`
+    Visibility Visibility `json:"visibility"`
+}
+
+// StoredAttribute is an attribute as persisted; server-only values are sealed
+type StoredAttribute struct {
+    Key        string     `json:"key"`
+    Value      []byte     `json:"value"`
+    Visibility Visibility `json:"visibility"`
+}
+
+// AttributeStore persists participant attributes per room and identity
+type AttributeStore interface {
+    Put(ctx context.Context, room, identity string, attrs []StoredAttribute) error
+    Delete(ctx context.Context, room, identity string, keys []string) error
+    List(ctx context.Context, room, identity string) ([]StoredAttribute, error)
+}
+
+// ParticipantClient is the subset of the LiveKit room service used to mirror attributes to the SFU
+type ParticipantClient interface {
+    UpdateParticipant(ctx context.Context, req *livekit.UpdateParticipantRequest) (*livekit.ParticipantInfo, error)
+}
+
+// AttributeService stores participant attributes and enforces their visibility
+type AttributeService struct {
+    store AttributeStore
+    key   *secure.SecureBytes
+    rooms ParticipantClient
+    bus   events.Bus
+}
+
+// NewAttributeService creates the service. key is a 32-byte AES-256-GCM key for server-only
+// attributes and may be nil if none are stored; rooms and bus may be nil to skip sync
+func NewAttributeService(store AttributeStore, key *secure.SecureBytes, rooms ParticipantClient, bus events.Bus) *AttributeService {
+    return &AttributeService{store: store, key: key, rooms: rooms, bus: bus}
+}
+
+// Set creates or replaces attributes, sealing server-only values before they reach the store
+func (s *AttributeService) Set(ctx context.Context, room, identity string, attrs ...Attribute) error {
+    stored := make([]StoredAttribute, 0, len(attrs))
+    var changed []string
+    for _, a := range attrs {
+        if a.Key == "" || a.Visibility.level() < 0 {
+            return ErrInvalidAttribute
+        }
+        value := []byte(a.Value)
+        if a.Visibility == VisibilityServer {
+            sealed, err := s.seal(room, identity, a.Key, value)
+            if err != nil {
+                return err
+            }
+            value = sealed
+        } else {
+            changed = append(changed, a.Key)
+        }
+        stored = append(stored, StoredAttribute{Key: a.Key, Value: value, Visibility: a.Visibility})
+    }
+    if err := s.store.Put(ctx, room, identity, stored); err != nil {
+        return err
+    }
+    return s.sync(ctx, room, identity, changed)
+}
+
+// Delete removes attributes by key
+func (s *AttributeService) Delete(ctx context.Context, room, identity string, keys ...string) error {
+    if err := s.store.Delete(ctx, room, identity, keys); err != nil {
+        return err
+    }
+    return s.sync(ctx, room, identity, keys)
+}
+
+// Get returns the attributes a viewer at the given visibility may read: VisibilityPublic for
+// outsiders, VisibilityRoom for room members and VisibilityServer for server-side callers
+func (s *AttributeService) Get(ctx context.Context, room, identity string, viewer Visibility) (map[string]string, error) {
+    stored, err := s.store.List(ctx, room, identity)
+    if err != nil {
+        return nil, err
+    }
+    out := make(map[string]string, len(stored))
+    for _, a := range stored {
+        if a.Visibility.level() > viewer.level() {
+            continue
+        }
+        value := a.Value
+        if a.Visibility == VisibilityServer {
+            if value, err = s.open(room, identity, a.Key, value); err != nil {
+                return nil, err
+            }
+        }
+        out[a.Key] = string(value)
+    }
+    return out, nil
+}
+
+// sync mirrors the room-visible attributes to the SFU and announces the change. The SFU's
+// protocol predates participant attributes, so they travel as the participant metadata JSON
+func (s *AttributeService) sync(ctx context.Context, room, identity string, changed []string) error {
+    if s.bus != nil && len(changed) > 0 {
+        _ = s.bus.Publish(ctx, events.Event{
+            Type:     EventAttributesUpdated,
+            Room:     room,
+            Identity: identity,
+            Data:     map[string]string{"keys": strings.Join(changed, ",")},
+        })
+    }
+    if s.rooms == nil {
+        return nil
+    }
+    visible, err := s.Get(ctx, room, identity, VisibilityRoom)
+    if err != nil {
+        return err
+    }
+    metadata, err := json.Marshal(visible)
+    if err != nil {
+        return err
+    }
+    _, err = s.rooms.UpdateParticipant(ctx, &livekit.UpdateParticipantRequest{
+        Room:     room,
+        Identity: identity,
+        Metadata: string(metadata),
+    })
+    return err
+}
+
+func (s *AttributeService) seal(room, identity, key string, value []byte) ([]byte, error) {
+    var sealed []byte
+    err := s.withAEAD(func(aead cipher.AEAD) error {
+        nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+        if _, err := rand.Read(nonce); err != nil {
+            return err
+        }
+        sealed = aead.Seal(nonce, nonce, value, attributeAD(room, identity, key))
+        return nil
+    })
+    return sealed, err
+}
+
+func (s *AttributeService) open(room, identity, key string, sealed []byte) ([]byte, error) {
+    var value []byte
+    err := s.withAEAD(func(aead cipher.AEAD) error {
+        if len(sealed) < aead.NonceSize() {
+            return ErrAttributeTampered
+        }
+        nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+        var err error
+        if value, err = aead.Open(nil, nonce, ciphertext, attributeAD(room, identity, key)); err != nil {
+            return ErrAttributeTampered
+        }
+        return nil
+    })
+    return value, err
+}
+
+func (s *AttributeService) withAEAD(fn func(aead cipher.AEAD) error) error {
+    if s.key == nil {
+        return ErrNoAttributeKey
+    }
+    return s.key.Use(func(key []byte) error {
+        block, err := aes.NewCipher(key)
+        if err != nil {
+            return ErrNoAttributeKey
+        }
+        aead, err := cipher.NewGCM(block)
+        if err != nil {
+            return err
+        }
+        return fn(aead)
+    })
+}
+
+// attributeAD binds a sealed value to its owner so it cannot be moved to another participant or key
+func attributeAD(room, identity, key string) []byte {
+    return []byte(room + "\x00" + identity + "\x00" + key)
+}
+
+// MemoryAttributeStore is an in-process AttributeStore for single-instance deployments
+type MemoryAttributeStore struct {
+    mu    sync.RWMutex
+    attrs map[string]map[string]StoredAttribute
+}
+
+// NewMemoryAttributeStore creates an empty store
+func NewMemoryAttributeStore() *MemoryAttributeStore {
+    return &MemoryAttributeStore{attrs: make(map[string]map[string]StoredAttribute)}
+}
+
+// Put creates or replaces attributes
+func (s *MemoryAttributeStore) Put(ctx context.Context, room, identity string, attrs []StoredAttribute) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    id := room + "\x00" + identity
+    if s.attrs[id] == nil {
+        s.attrs[id] = make(map[string]StoredAttribute)
+    }
+    for _, a := range attrs {
+        a.Value = append([]byte(nil), a.Value...)
+        s.attrs[id][a.Key] = a
+    }
+    return nil
+}
+
+// Delete removes attributes; unknown keys are ignored
+func (s *MemoryAttributeStore) Delete(ctx context.Context, room, identity string, keys []string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    id := room + "\x00" + identity
+    for _, k := range keys {
+        delete(s.attrs[id], k)
+    }
+    if len(s.attrs[id]) == 0 {
+        delete(s.attrs, id)
+    }
+    return nil
+}
+
+// List returns a participant's attributes ordered by key
+func (s *MemoryAttributeStore) List(ctx context.Context, room, identity string) ([]StoredAttribute, error) {
+    s.mu.RLock()
+    var out []StoredAttribute
+    for _, a := range s.attrs[room+"\x00"+identity] {
+        out = append(out, a)
+    }
+    s.mu.RUnlock()
+
+    sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+    return out, nil
+}
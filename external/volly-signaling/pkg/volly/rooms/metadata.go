@@ -0,0 +1,203 @@
+package rooms
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "strconv"
+    "sync"
+    "time"
+
+    "github.com/livekit/protocol/livekit"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/events"
+)
+
+// DefaultMaxMetadataSize matches LiveKit's default room metadata limit
+const DefaultMaxMetadataSize = 64 << 10
+
+// EventMetadataUpdated is published after every committed metadata write
+const EventMetadataUpdated = "room_metadata_updated"
+
+// setRetries bounds how often Set retries after losing a concurrent write
+const setRetries = 5
+
+var (
+    ErrMetadataTooLarge = errors.New("room metadata exceeds the size limit")
+    ErrInvalidMetadata  = errors.New("room metadata must be a JSON value")
+    ErrVersionConflict  = errors.New("room metadata was modified concurrently")
+)
+
+// Metadata is a room's application state; Version increases by one on every write
+type Metadata struct {
+    Room      string          `json:"room"`
+    Data      json.RawMessage `json:"data,omitempty"`
+    Version   uint64          `json:"version"`
+    UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+// MetadataStore persists room metadata
+type MetadataStore interface {
+    // Load returns Version 0 and no data for rooms that have never been written
+    Load(ctx context.Context, room string) (Metadata, error)
+    // Save writes md only if the stored version is still expected, else ErrVersionConflict
+    Save(ctx context.Context, md Metadata, expected uint64) error
+}
+
+// RoomServiceClient is the subset of the LiveKit room service used to mirror metadata to the SFU
+type RoomServiceClient interface {
+    UpdateRoomMetadata(ctx context.Context, req *livekit.UpdateRoomMetadataRequest) (*livekit.Room, error)
+}
+
+// SchemaValidator rejects metadata that does not match the application's schema
+type SchemaValidator func(room string, data json.RawMessage) error
+
+// MetadataService is the versioned source of truth for room metadata; the SFU copy is a mirror
+type MetadataService struct {
+    store    MetadataStore
+    rooms    RoomServiceClient
+    bus      events.Bus
+    validate SchemaValidator
+    maxSize  int
+    clock    clock.Clock
+}
+
+// NewMetadataService creates the service; rooms and bus may be nil to skip SFU sync or events
+func NewMetadataService(store MetadataStore, rooms RoomServiceClient, bus events.Bus) *MetadataService {
+    return &MetadataService{
+        store:   store,
+        rooms:   rooms,
+        bus:     bus,
+        maxSize: DefaultMaxMetadataSize,
+        clock:   clock.System,
+    }
+}
+
+// SetMaxSize sets the encoded size limit in bytes
+func (s *MetadataService) SetMaxSize(n int) *MetadataService {
+    s.maxSize = n
+    return s
+}
+
+// SetValidator installs a schema validation hook run before every write
+func (s *MetadataService) SetValidator(v SchemaValidator) *MetadataService {
+    s.validate = v
+    return s
+}
+
+// SetClock sets the time source for UpdatedAt
+func (s *MetadataService) SetClock(c clock.Clock) *MetadataService {
+    s.clock = c
+    return s
+}
+
+// Get returns the current metadata and its version
+func (s *MetadataService) Get(ctx context.Context, room string) (Metadata, error) {
+    return s.store.Load(ctx, room)
+}
+
+// Set replaces the metadata regardless of its current version
+func (s *MetadataService) Set(ctx context.Context, room string, data json.RawMessage) (Metadata, error) {
+    for i := 0; ; i++ {
+        current, err := s.store.Load(ctx, room)
+        if err != nil {
+            return Metadata{}, err
+        }
+        md, err := s.CompareAndSwap(ctx, room, current.Version, data)
+        if !errors.Is(err, ErrVersionConflict) || i == setRetries {
+            return md, err
+        }
+    }
+}
+
+// CompareAndSwap writes data only if the stored version equals expected, else ErrVersionConflict.
+// The write is committed before the SFU is updated; a sync error is returned with the committed
+// metadata and Sync can retry it
+func (s *MetadataService) CompareAndSwap(ctx context.Context, room string, expected uint64, data json.RawMessage) (Metadata, error) {
+    if s.maxSize > 0 && len(data) > s.maxSize {
+        return Metadata{}, ErrMetadataTooLarge
+    }
+    if len(data) > 0 && !json.Valid(data) {
+        return Metadata{}, ErrInvalidMetadata
+    }
+    if s.validate != nil {
+        if err := s.validate(room, data); err != nil {
+            return Metadata{}, err
+        }
+    }
+
+    md := Metadata{
+        Room:      room,
+        Data:      append(json.RawMessage(nil), data...),
+        Version:   expected + 1,
+        UpdatedAt: s.clock.Now(),
+    }
+    if err := s.store.Save(ctx, md, expected); err != nil {
+        return Metadata{}, err
+    }
+
+    if s.bus != nil {
+        _ = s.bus.Publish(ctx, events.Event{
+            Type: EventMetadataUpdated,
+            Room: room,
+            Data: map[string]string{"version": strconv.FormatUint(md.Version, 10)},
+        })
+    }
+    return md, s.push(ctx, md)
+}
+
+// Sync pushes the stored metadata to the SFU, e.g. after a failed write-through or an SFU restart
+func (s *MetadataService) Sync(ctx context.Context, room string) error {
+    md, err := s.store.Load(ctx, room)
+    if err != nil {
+        return err
+    }
+    return s.push(ctx, md)
+}
+
+func (s *MetadataService) push(ctx context.Context, md Metadata) error {
+    if s.rooms == nil || md.Version == 0 {
+        return nil
+    }
+    _, err := s.rooms.UpdateRoomMetadata(ctx, &livekit.UpdateRoomMetadataRequest{
+        Room:     md.Room,
+        Metadata: string(md.Data),
+    })
+    return err
+}
+
+// MemoryMetadataStore is an in-process MetadataStore for single-instance deployments
+type MemoryMetadataStore struct {
+    mu    sync.Mutex
+    rooms map[string]Metadata
+}
+
+// NewMemoryMetadataStore creates an empty store
+func NewMemoryMetadataStore() *MemoryMetadataStore {
+    return &MemoryMetadataStore{rooms: make(map[string]Metadata)}
+}
+
+// Load returns the room's metadata, or Version 0 if it has none
+func (s *MemoryMetadataStore) Load(ctx context.Context, room string) (Metadata, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    md, ok := s.rooms[room]
+    if !ok {
+        return Metadata{Room: room}, nil
+    }
+    return md, nil
+}
+
+// Save stores md if the current version matches expected
+func (s *MemoryMetadataStore) Save(ctx context.Context, md Metadata, expected uint64) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if s.rooms[md.Room].Version != expected {
+        return ErrVersionConflict
+    }
+    s.rooms[md.Room] = md
+    return nil
+}
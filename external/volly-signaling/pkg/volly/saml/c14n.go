@@ -0,0 +1,322 @@
+package saml
+
+import (
+    "bytes"
+    "encoding/xml"
+    "errors"
+    "io"
+    "sort"
+    "strings"
+)
+
+const nsXML = "http://www.w3.org/XML/1998/namespace"
+
+// maxDepth bounds nesting so a hostile document cannot exhaust the stack
+const maxDepth = 64
+
+var errMalformedXML = errors.New("malformed XML")
+
+// element is a parsed XML element with what exclusive canonicalization needs: raw prefixes
+// and every namespace in scope. Signatures are checked and claims read on the same tree, so
+// nothing is looked up again in a document an attacker could have wrapped
+type element struct {
+    prefix, local string
+    // space is the namespace URI prefix resolves to
+    space    string
+    attrs    []attr
+    scope    map[string]string
+    children []node
+    parent   *element
+}
+
+type attr struct {
+    prefix, local, space, value string
+}
+
+// node is an element, text or a processing instruction
+type node struct {
+    el     *element
+    text   string
+    target string
+    isPI   bool
+}
+
+// parseXML reads a document into a tree. Comments are dropped, and DTDs refused so no entity
+// can expand
+func parseXML(data []byte) (*element, error) {
+    d := xml.NewDecoder(bytes.NewReader(data))
+    d.Strict = true
+    var root, cur *element
+    depth := 0
+    for {
+        tok, err := d.RawToken()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return nil, errMalformedXML
+        }
+        switch t := tok.(type) {
+        case xml.StartElement:
+            if depth++; depth > maxDepth {
+                return nil, errMalformedXML
+            }
+            el := &element{prefix: t.Name.Space, local: t.Name.Local, parent: cur, scope: map[string]string{"xml": nsXML}}
+            if cur != nil {
+                el.scope = cur.scope
+            }
+            var declared bool
+            for _, a := range t.Attr {
+                prefix, isDecl := "", false
+                switch {
+                case a.Name.Space == "xmlns":
+                    prefix, isDecl = a.Name.Local, true
+                case a.Name.Space == "" && a.Name.Local == "xmlns":
+                    isDecl = true
+                }
+                if !isDecl {
+                    el.attrs = append(el.attrs, attr{prefix: a.Name.Space, local: a.Name.Local, value: a.Value})
+                    continue
+                }
+                if !declared {
+                    scope := make(map[string]string, len(el.scope)+1)
+                    for p, uri := range el.scope {
+                        scope[p] = uri
+                    }
+                    el.scope, declared = scope, true
+                }
+                el.scope[prefix] = a.Value
+            }
+            var ok bool
+            if el.space, ok = el.scope[el.prefix]; !ok && el.prefix != "" {
+                return nil, errMalformedXML
+            }
+            seen := make(map[[2]string]bool, len(el.attrs))
+            for i := range el.attrs {
+                if p := el.attrs[i].prefix; p != "" {
+                    if el.attrs[i].space, ok = el.scope[p]; !ok {
+                        return nil, errMalformedXML
+                    }
+                }
+                // A repeated attribute, such as a second ID, is not well-formed
+                k := [2]string{el.attrs[i].space, el.attrs[i].local}
+                if seen[k] {
+                    return nil, errMalformedXML
+                }
+                seen[k] = true
+            }
+            if cur == nil {
+                if root != nil {
+                    return nil, errMalformedXML
+                }
+                root = el
+            } else {
+                cur.children = append(cur.children, node{el: el})
+            }
+            cur = el
+        case xml.EndElement:
+            if cur == nil || t.Name.Space != cur.prefix || t.Name.Local != cur.local {
+                return nil, errMalformedXML
+            }
+            cur = cur.parent
+            depth--
+        case xml.CharData:
+            if cur == nil {
+                if len(bytes.TrimSpace(t)) > 0 {
+                    return nil, errMalformedXML
+                }
+                continue
+            }
+            if n := len(cur.children); n > 0 && cur.children[n-1].el == nil && !cur.children[n-1].isPI {
+                cur.children[n-1].text += string(t)
+            } else {
+                cur.children = append(cur.children, node{text: string(t)})
+            }
+        case xml.ProcInst:
+            // The XML declaration and anything else outside the root are not part of it
+            if cur != nil {
+                cur.children = append(cur.children, node{isPI: true, target: t.Target, text: string(t.Inst)})
+            }
+        case xml.Directive:
+            return nil, errMalformedXML
+        }
+    }
+    if root == nil || cur != nil {
+        return nil, errMalformedXML
+    }
+    return root, nil
+}
+
+// attr returns the value of the unprefixed attribute name
+func (e *element) attr(name string) string {
+    for _, a := range e.attrs {
+        if a.prefix == "" && a.local == name {
+            return a.value
+        }
+    }
+    return ""
+}
+
+// childrenNamed returns the child elements with namespace space and local name
+func (e *element) childrenNamed(space, local string) []*element {
+    var out []*element
+    for _, c := range e.children {
+        if c.el != nil && c.el.space == space && c.el.local == local {
+            out = append(out, c.el)
+        }
+    }
+    return out
+}
+
+// child returns the only child named so, or nil when there is none or more than one
+func (e *element) child(space, local string) *element {
+    if c := e.childrenNamed(space, local); len(c) == 1 {
+        return c[0]
+    }
+    return nil
+}
+
+// text is the element's character content, trimmed
+func (e *element) text() string {
+    var b strings.Builder
+    for _, c := range e.children {
+        if c.el == nil && !c.isPI {
+            b.WriteString(c.text)
+        }
+    }
+    return strings.TrimSpace(b.String())
+}
+
+// canonicalize renders e by Exclusive XML Canonicalization 1.0 without comments, leaving out
+// exclude and its subtree; inclusive lists prefixes handled by the inclusive rules, as an
+// InclusiveNamespaces PrefixList asks, with "" for the default namespace
+func canonicalize(e, exclude *element, inclusive []string) []byte {
+    var b bytes.Buffer
+    writeCanonical(&b, e, exclude, inclusive, map[string]string{})
+    return b.Bytes()
+}
+
+func writeCanonical(b *bytes.Buffer, e, exclude *element, inclusive []string, rendered map[string]string) {
+    utilized := map[string]bool{e.prefix: true}
+    for _, a := range e.attrs {
+        if a.prefix != "" {
+            utilized[a.prefix] = true
+        }
+    }
+    for _, p := range inclusive {
+        if _, ok := e.scope[p]; ok || p == "" {
+            utilized[p] = true
+        }
+    }
+
+    type decl struct{ prefix, uri string }
+    var decls []decl
+    next, copied := rendered, false
+    for p := range utilized {
+        if p == "xml" {
+            continue
+        }
+        uri := e.scope[p]
+        // An unset default namespace only needs xmlns="" once an ancestor set one
+        if prev, ok := rendered[p]; (ok && prev == uri) || (!ok && p == "" && uri == "") {
+            continue
+        }
+        decls = append(decls, decl{p, uri})
+        if !copied {
+            next, copied = make(map[string]string, len(rendered)+len(utilized)), true
+            for k, v := range rendered {
+                next[k] = v
+            }
+        }
+        next[p] = uri
+    }
+    sort.Slice(decls, func(i, j int) bool { return decls[i].prefix < decls[j].prefix })
+
+    attrs := append([]attr(nil), e.attrs...)
+    sort.Slice(attrs, func(i, j int) bool {
+        if attrs[i].space != attrs[j].space {
+            return attrs[i].space < attrs[j].space
+        }
+        return attrs[i].local < attrs[j].local
+    })
+
+    qname := e.local
+    if e.prefix != "" {
+        qname = e.prefix + ":" + e.local
+    }
+    b.WriteString("<" + qname)
+    for _, d := range decls {
+        if d.prefix == "" {
+            b.WriteString(` xmlns="`)
+        } else {
+            b.WriteString(" xmlns:" + d.prefix + `="`)
+        }
+        escapeAttr(b, d.uri)
+        b.WriteByte('"')
+    }
+    for _, a := range attrs {
+        b.WriteByte(' ')
+        if a.prefix != "" {
+            b.WriteString(a.prefix + ":")
+        }
+        b.WriteString(a.local + `="`)
+        escapeAttr(b, a.value)
+        b.WriteByte('"')
+    }
+    b.WriteByte('>')
+    for _, c := range e.children {
+        switch {
+        case c.el != nil:
+            if c.el != exclude {
+                writeCanonical(b, c.el, exclude, inclusive, next)
+            }
+        case c.isPI:
+            b.WriteString("<?" + c.target)
+            if c.text != "" {
+                b.WriteString(" " + c.text)
+            }
+            b.WriteString("?>")
+        default:
+            escapeText(b, c.text)
+        }
+    }
+    b.WriteString("</" + qname + ">")
+}
+
+func escapeText(b *bytes.Buffer, s string) {
+    for _, r := range s {
+        switch r {
+        case '&':
+            b.WriteString("&amp;")
+        case '<':
+            b.WriteString("&lt;")
+        case '>':
+            b.WriteString("&gt;")
+        case '\r':
+            b.WriteString("&#xD;")
+        default:
+            b.WriteRune(r)
+        }
+    }
+}
+
+func escapeAttr(b *bytes.Buffer, s string) {
+    for _, r := range s {
+        switch r {
+        case '&':
+            b.WriteString("&amp;")
+        case '<':
+            b.WriteString("&lt;")
+        case '"':
+            b.WriteString("&quot;")
+        case '\t':
+            b.WriteString("&#x9;")
+        case '\n':
+            b.WriteString("&#xA;")
+        case '\r':
+            b.WriteString("&#xD;")
+        default:
+            b.WriteRune(r)
+        }
+    }
+}
@@ -0,0 +1,112 @@
+package saml
+
+import (
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/xml"
+    "fmt"
+    "strings"
+)
+
+type entityDescriptor struct {
+    XMLName  xml.Name           `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+    EntityID string             `xml:"entityID,attr"`
+    SP       *spSSODescriptor   `xml:"SPSSODescriptor,omitempty"`
+    IdPs     []idpSSODescriptor `xml:"IDPSSODescriptor"`
+}
+
+type spSSODescriptor struct {
+    AuthnRequestsSigned  bool       `xml:"AuthnRequestsSigned,attr"`
+    WantAssertionsSigned bool       `xml:"WantAssertionsSigned,attr"`
+    Protocols            string     `xml:"protocolSupportEnumeration,attr"`
+    NameIDFormats        []string   `xml:"NameIDFormat"`
+    ACS                  []endpoint `xml:"AssertionConsumerService"`
+}
+
+type idpSSODescriptor struct {
+    Protocols string          `xml:"protocolSupportEnumeration,attr"`
+    Keys      []keyDescriptor `xml:"KeyDescriptor"`
+    SSO       []endpoint      `xml:"SingleSignOnService"`
+}
+
+type keyDescriptor struct {
+    Use          string   `xml:"use,attr,omitempty"`
+    Certificates []string `xml:"http://www.w3.org/2000/09/xmldsig# KeyInfo>X509Data>X509Certificate"`
+}
+
+type endpoint struct {
+    Binding  string `xml:"Binding,attr"`
+    Location string `xml:"Location,attr"`
+    Index    *int   `xml:"index,attr,omitempty"`
+}
+
+// Metadata returns the SP's metadata document for IdP administrators to import
+func (sp *ServiceProvider) Metadata() []byte {
+    index := 0
+    md := entityDescriptor{
+        EntityID: sp.entityID,
+        SP: &spSSODescriptor{
+            WantAssertionsSigned: true,
+            Protocols:            nsProtocol,
+            NameIDFormats:        []string{nameIDFormat},
+            ACS:                  []endpoint{{Binding: bindingPOST, Location: sp.acsURL, Index: &index}},
+        },
+    }
+    out, _ := xml.MarshalIndent(md, "", "  ")
+    return append([]byte(xml.Header), out...)
+}
+
+// ParseIdPMetadata reads an IdP's entity ID, redirect-binding SSO URL and signing
+// certificates from its metadata document
+func ParseIdPMetadata(data []byte) (IdentityProvider, error) {
+    var md entityDescriptor
+    if err := xml.Unmarshal(data, &md); err != nil {
+        return IdentityProvider{}, fmt.Errorf("saml: reading IdP metadata: %w", err)
+    }
+    if md.EntityID == "" || len(md.IdPs) == 0 {
+        return IdentityProvider{}, fmt.Errorf("saml: metadata describes no identity provider")
+    }
+    idp := IdentityProvider{EntityID: md.EntityID}
+    for _, d := range md.IdPs {
+        for _, sso := range d.SSO {
+            if sso.Binding == bindingRedirect && idp.SSOURL == "" {
+                idp.SSOURL = sso.Location
+            }
+        }
+        for _, k := range d.Keys {
+            if k.Use != "" && k.Use != "signing" {
+                continue
+            }
+            for _, c := range k.Certificates {
+                cert, err := ParseCertificate(c)
+                if err != nil {
+                    return IdentityProvider{}, err
+                }
+                idp.Certificates = append(idp.Certificates, cert)
+            }
+        }
+    }
+    if idp.SSOURL == "" {
+        return IdentityProvider{}, fmt.Errorf("saml: IdP %s has no HTTP-Redirect sign-on service", idp.EntityID)
+    }
+    if len(idp.Certificates) == 0 {
+        return IdentityProvider{}, fmt.Errorf("saml: IdP %s has no signing certificate", idp.EntityID)
+    }
+    return idp, nil
+}
+
+// ParseCertificate reads a certificate as PEM or as the bare base64 DER metadata carries
+func ParseCertificate(s string) (*x509.Certificate, error) {
+    s = strings.TrimSpace(s)
+    s = strings.TrimPrefix(s, "-----BEGIN CERTIFICATE-----")
+    s = strings.TrimSuffix(s, "-----END CERTIFICATE-----")
+    der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+    if err != nil {
+        return nil, fmt.Errorf("saml: reading certificate: %w", err)
+    }
+    cert, err := x509.ParseCertificate(der)
+    if err != nil {
+        return nil, fmt.Errorf("saml: reading certificate: %w", err)
+    }
+    return cert, nil
+}
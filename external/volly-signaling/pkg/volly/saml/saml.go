@@ -0,0 +1,544 @@
+// Package saml is a SAML 2.0 service provider for enterprise single sign-on: it publishes SP
+// metadata, sends users to an IdP with an AuthnRequest over the HTTP-Redirect binding and
+// checks the signed Response the IdP posts back to the assertion consumer service, mapping
+// its attributes to a tenant, identity and role tokens can be minted for.
+//
+// Only what SAML logins need is implemented: signed responses or assertions under exclusive
+// canonicalization and SHA-256 or better, no encrypted assertions, no single logout
+package saml
+
+import (
+    "bytes"
+    "compress/flate"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/xml"
+    "errors"
+    "fmt"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+)
+
+// SAML namespaces and values
+const (
+    nsProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+    nsAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+    nsMetadata  = "urn:oasis:names:tc:SAML:2.0:metadata"
+
+    bindingPOST     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+    bindingRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+    statusSuccess   = "urn:oasis:names:tc:SAML:2.0:status:Success"
+    methodBearer    = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+    nameIDFormat    = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+)
+
+const (
+    // DefaultClockSkew is how far IdP and SP clocks may disagree on validity windows
+    DefaultClockSkew = 2 * time.Minute
+    // DefaultRequestTTL is how long a login may take at the IdP
+    DefaultRequestTTL = 10 * time.Minute
+    // maxResponseBytes bounds the decoded SAMLResponse
+    maxResponseBytes = 256 << 10
+    // maxSeen bounds the assertion replay cache
+    maxSeen = 100000
+)
+
+var (
+    ErrMalformedResponse       = errors.New("malformed SAML response")
+    ErrUnknownIdentityProvider = errors.New("unknown SAML identity provider")
+    ErrUnsigned                = errors.New("SAML response and assertion are both unsigned")
+    ErrEncryptedAssertion      = errors.New("encrypted SAML assertions are not supported")
+    ErrResponseExpired         = errors.New("SAML assertion is outside its validity window")
+    ErrWrongAudience           = errors.New("SAML assertion is not for this service provider")
+    ErrWrongDestination        = errors.New("SAML response is not for this assertion consumer service")
+    ErrUnsolicited             = errors.New("unsolicited SAML response")
+    ErrUnknownRequest          = errors.New("SAML response answers no request of ours")
+    ErrReplayed                = errors.New("SAML assertion was already used")
+    ErrUnmapped                = errors.New("SAML assertion lacks an attribute the mapping needs")
+)
+
+// StatusError is a response whose status is not success
+type StatusError struct {
+    Code    string
+    Message string
+}
+
+func (e *StatusError) Error() string {
+    if e.Message != "" {
+        return "SAML login failed at the IdP: " + e.Code + ": " + e.Message
+    }
+    return "SAML login failed at the IdP: " + e.Code
+}
+
+// IdentityProvider is an IdP the SP trusts
+type IdentityProvider struct {
+    EntityID string
+    // SSOURL takes AuthnRequests over the HTTP-Redirect binding
+    SSOURL string
+    // Certificates verify the IdP's signatures; list the next one too while it rotates
+    Certificates []*x509.Certificate
+}
+
+// AttributeMapping turns an IdP's assertion into a tenant, identity and role
+type AttributeMapping struct {
+    // Tenant fixes the tenant of every login through the IdP
+    Tenant string `json:"tenant,omitempty"`
+    // TenantAttribute reads the tenant from an attribute instead, for an IdP serving several
+    // tenants; only those in Tenants are accepted, so an IdP cannot log into a tenant it
+    // does not serve
+    TenantAttribute string   `json:"tenantAttribute,omitempty"`
+    Tenants         []string `json:"tenants,omitempty"`
+    // IdentityAttribute names the identity; the subject's NameID by default
+    IdentityAttribute string `json:"identityAttribute,omitempty"`
+    // RoleAttribute values are looked up in Roles, IdP value to Volly role; the first value
+    // that maps wins, and DefaultRole applies when none does
+    RoleAttribute string            `json:"roleAttribute,omitempty"`
+    Roles         map[string]string `json:"roles,omitempty"`
+    DefaultRole   string            `json:"defaultRole,omitempty"`
+}
+
+// Login is a verified assertion, mapped
+type Login struct {
+    IdP      string
+    Tenant   string
+    Identity string
+    Role     string
+    NameID   string
+    // AssertionID and SessionIndex name the login in audit logs
+    AssertionID  string
+    SessionIndex string
+    Attributes   map[string][]string
+    // RelayState is what the login was started with; the caller decides what to trust of it
+    RelayState string
+}
+
+type trustedIdP struct {
+    IdentityProvider
+    mapping AttributeMapping
+}
+
+// ServiceProvider checks logins for one SP entity
+type ServiceProvider struct {
+    entityID    string
+    acsURL      string
+    key         []byte
+    idps        map[string]*trustedIdP
+    skew        time.Duration
+    requestTTL  time.Duration
+    unsolicited bool
+    clock       clock.Clock
+
+    mu   sync.Mutex
+    seen map[string]time.Time
+}
+
+// New creates a service provider; acsURL is where IdPs post responses and key signs request
+// IDs, so instances sharing it accept responses to each other's requests
+func New(entityID, acsURL string, key []byte) *ServiceProvider {
+    return &ServiceProvider{
+        entityID:   entityID,
+        acsURL:     acsURL,
+        key:        key,
+        idps:       make(map[string]*trustedIdP),
+        skew:       DefaultClockSkew,
+        requestTTL: DefaultRequestTTL,
+        clock:      clock.System,
+        seen:       make(map[string]time.Time),
+    }
+}
+
+// AddIdentityProvider trusts idp, mapping its assertions with mapping
+func (sp *ServiceProvider) AddIdentityProvider(idp IdentityProvider, mapping AttributeMapping) *ServiceProvider {
+    sp.idps[idp.EntityID] = &trustedIdP{IdentityProvider: idp, mapping: mapping}
+    return sp
+}
+
+// SetClockSkew sets the tolerance on validity windows
+func (sp *ServiceProvider) SetClockSkew(d time.Duration) *ServiceProvider {
+    sp.skew = d
+    return sp
+}
+
+// SetRequestTTL sets how long a response to an AuthnRequest is accepted
+func (sp *ServiceProvider) SetRequestTTL(d time.Duration) *ServiceProvider {
+    sp.requestTTL = d
+    return sp
+}
+
+// AllowUnsolicited accepts IdP-initiated logins, which answer no AuthnRequest. They are
+// easier to replay into another browser, so they are refused by default
+func (sp *ServiceProvider) AllowUnsolicited(allow bool) *ServiceProvider {
+    sp.unsolicited = allow
+    return sp
+}
+
+// SetClock sets the time source for validity windows
+func (sp *ServiceProvider) SetClock(c clock.Clock) *ServiceProvider {
+    sp.clock = c
+    return sp
+}
+
+// authnRequest is the AuthnRequest sent to an IdP
+type authnRequest struct {
+    XMLName         xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
+    ID              string   `xml:"ID,attr"`
+    Version         string   `xml:"Version,attr"`
+    IssueInstant    string   `xml:"IssueInstant,attr"`
+    Destination     string   `xml:"Destination,attr"`
+    ACSURL          string   `xml:"AssertionConsumerServiceURL,attr"`
+    ProtocolBinding string   `xml:"ProtocolBinding,attr"`
+    Issuer          struct {
+        XMLName xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+        Value   string   `xml:",chardata"`
+    }
+    NameIDPolicy struct {
+        XMLName     xml.Name `xml:"NameIDPolicy"`
+        AllowCreate bool     `xml:"AllowCreate,attr"`
+    }
+}
+
+// AuthnRequestURL returns the IdP URL that starts a login, with relayState carried through
+// to the response
+func (sp *ServiceProvider) AuthnRequestURL(idpEntityID, relayState string) (string, error) {
+    idp, ok := sp.idps[idpEntityID]
+    if !ok || idp.SSOURL == "" {
+        return "", ErrUnknownIdentityProvider
+    }
+    id, err := sp.requestID(idp.EntityID)
+    if err != nil {
+        return "", err
+    }
+    req := authnRequest{
+        ID:              id,
+        Version:         "2.0",
+        IssueInstant:    sp.clock.Now().UTC().Format(time.RFC3339),
+        Destination:     idp.SSOURL,
+        ACSURL:          sp.acsURL,
+        ProtocolBinding: bindingPOST,
+    }
+    req.Issuer.Value = sp.entityID
+    req.NameIDPolicy.AllowCreate = true
+    body, err := xml.Marshal(req)
+    if err != nil {
+        return "", err
+    }
+
+    var deflated bytes.Buffer
+    w, _ := flate.NewWriter(&deflated, flate.BestCompression)
+    w.Write(body)
+    w.Close()
+    u, err := url.Parse(idp.SSOURL)
+    if err != nil {
+        return "", err
+    }
+    q := u.Query()
+    q.Set("SAMLRequest", base64.StdEncoding.EncodeToString(deflated.Bytes()))
+    if relayState != "" {
+        q.Set("RelayState", relayState)
+    }
+    u.RawQuery = q.Encode()
+    return u.String(), nil
+}
+
+// ParseResponse checks a SAMLResponse form value posted to the assertion consumer service
+// and maps its assertion. The response, the assertion or both must be signed by the issuing
+// IdP; every value is read from the signed elements themselves
+func (sp *ServiceProvider) ParseResponse(samlResponse, relayState string) (*Login, error) {
+    raw, err := decodeBase64(samlResponse)
+    if err != nil || len(raw) > maxResponseBytes {
+        return nil, ErrMalformedResponse
+    }
+    resp, err := parseXML(raw)
+    if err != nil || resp.space != nsProtocol || resp.local != "Response" || resp.attr("Version") != "2.0" {
+        return nil, ErrMalformedResponse
+    }
+    if d := resp.attr("Destination"); d != "" && d != sp.acsURL {
+        return nil, ErrWrongDestination
+    }
+    issuer := resp.child(nsAssertion, "Issuer")
+    if issuer == nil {
+        return nil, ErrMalformedResponse
+    }
+    idp, ok := sp.idps[issuer.text()]
+    if !ok {
+        return nil, ErrUnknownIdentityProvider
+    }
+    if err := checkStatus(resp); err != nil {
+        return nil, err
+    }
+    responseSigned, err := verifyEnveloped(resp, idp.Certificates)
+    if err != nil {
+        return nil, err
+    }
+    if len(resp.childrenNamed(nsAssertion, "EncryptedAssertion")) > 0 {
+        return nil, ErrEncryptedAssertion
+    }
+    assertion := resp.child(nsAssertion, "Assertion")
+    if assertion == nil {
+        return nil, fmt.Errorf("%w: need exactly one assertion", ErrMalformedResponse)
+    }
+    assertionSigned, err := verifyEnveloped(assertion, idp.Certificates)
+    if err != nil {
+        return nil, err
+    }
+    if !responseSigned && !assertionSigned {
+        return nil, ErrUnsigned
+    }
+    if ai := assertion.child(nsAssertion, "Issuer"); ai == nil || ai.text() != idp.EntityID {
+        return nil, ErrUnknownIdentityProvider
+    }
+
+    inResponseTo := resp.attr("InResponseTo")
+    if inResponseTo == "" {
+        if !sp.unsolicited {
+            return nil, ErrUnsolicited
+        }
+    } else if err := sp.checkRequestID(inResponseTo, idp.EntityID); err != nil {
+        return nil, err
+    }
+
+    now := sp.clock.Now()
+    nameID, expires, err := sp.checkSubject(assertion, inResponseTo, now)
+    if err != nil {
+        return nil, err
+    }
+    notOnOrAfter, err := sp.checkConditions(assertion, now)
+    if err != nil {
+        return nil, err
+    }
+    if notOnOrAfter.IsZero() || (!expires.IsZero() && expires.Before(notOnOrAfter)) {
+        notOnOrAfter = expires
+    }
+    id := assertion.attr("ID")
+    if err := sp.remember(id, notOnOrAfter, now); err != nil {
+        return nil, err
+    }
+
+    login := &Login{
+        IdP:         idp.EntityID,
+        NameID:      nameID,
+        AssertionID: id,
+        Attributes:  attributes(assertion),
+        RelayState:  relayState,
+    }
+    if authn := assertion.childrenNamed(nsAssertion, "AuthnStatement"); len(authn) > 0 {
+        login.SessionIndex = authn[0].attr("SessionIndex")
+    }
+    if err := idp.mapping.apply(login); err != nil {
+        return nil, err
+    }
+    return login, nil
+}
+
+func checkStatus(resp *element) error {
+    status := resp.child(nsProtocol, "Status")
+    if status == nil {
+        return ErrMalformedResponse
+    }
+    code := status.child(nsProtocol, "StatusCode")
+    if code == nil {
+        return ErrMalformedResponse
+    }
+    if v := code.attr("Value"); v != statusSuccess {
+        e := &StatusError{Code: v}
+        if sub := code.child(nsProtocol, "StatusCode"); sub != nil {
+            e.Code += " (" + sub.attr("Value") + ")"
+        }
+        if msg := status.child(nsProtocol, "StatusMessage"); msg != nil {
+            e.Message = msg.text()
+        }
+        return e
+    }
+    return nil
+}
+
+// checkSubject finds a bearer confirmation for this ACS and returns the NameID and when the
+// confirmation lapses
+func (sp *ServiceProvider) checkSubject(assertion *element, inResponseTo string, now time.Time) (string, time.Time, error) {
+    subject := assertion.child(nsAssertion, "Subject")
+    if subject == nil {
+        return "", time.Time{}, fmt.Errorf("%w: no subject", ErrMalformedResponse)
+    }
+    var nameID string
+    if n := subject.child(nsAssertion, "NameID"); n != nil {
+        nameID = n.text()
+    }
+    for _, sc := range subject.childrenNamed(nsAssertion, "SubjectConfirmation") {
+        if sc.attr("Method") != methodBearer {
+            continue
+        }
+        data := sc.child(nsAssertion, "SubjectConfirmationData")
+        if data == nil || data.attr("Recipient") != sp.acsURL || data.attr("InResponseTo") != inResponseTo {
+            continue
+        }
+        expires, err := parseTime(data.attr("NotOnOrAfter"))
+        if err != nil || expires.IsZero() || !now.Add(-sp.skew).Before(expires) {
+            continue
+        }
+        if nb, err := parseTime(data.attr("NotBefore")); err != nil || (!nb.IsZero() && now.Add(sp.skew).Before(nb)) {
+            continue
+        }
+        return nameID, expires, nil
+    }
+    return "", time.Time{}, fmt.Errorf("%w: no bearer confirmation for this service provider", ErrResponseExpired)
+}
+
+// checkConditions checks the validity window and that the audience is this SP
+func (sp *ServiceProvider) checkConditions(assertion *element, now time.Time) (time.Time, error) {
+    cond := assertion.child(nsAssertion, "Conditions")
+    if cond == nil {
+        return time.Time{}, fmt.Errorf("%w: no conditions", ErrWrongAudience)
+    }
+    notBefore, err1 := parseTime(cond.attr("NotBefore"))
+    notOnOrAfter, err2 := parseTime(cond.attr("NotOnOrAfter"))
+    if err1 != nil || err2 != nil {
+        return time.Time{}, ErrMalformedResponse
+    }
+    if (!notBefore.IsZero() && now.Add(sp.skew).Before(notBefore)) || (!notOnOrAfter.IsZero() && !now.Add(-sp.skew).Before(notOnOrAfter)) {
+        return time.Time{}, ErrResponseExpired
+    }
+    // Every AudienceRestriction must name this SP
+    restrictions := cond.childrenNamed(nsAssertion, "AudienceRestriction")
+    if len(restrictions) == 0 {
+        return time.Time{}, ErrWrongAudience
+    }
+    for _, r := range restrictions {
+        found := false
+        for _, a := range r.childrenNamed(nsAssertion, "Audience") {
+            if a.text() == sp.entityID {
+                found = true
+            }
+        }
+        if !found {
+            return time.Time{}, ErrWrongAudience
+        }
+    }
+    return notOnOrAfter, nil
+}
+
+// remember records an assertion ID until it expires, refusing one seen before
+func (sp *ServiceProvider) remember(id string, until, now time.Time) error {
+    if id == "" {
+        return ErrMalformedResponse
+    }
+    sp.mu.Lock()
+    defer sp.mu.Unlock()
+    if _, ok := sp.seen[id]; ok {
+        return ErrReplayed
+    }
+    if len(sp.seen) >= maxSeen {
+        for k, exp := range sp.seen {
+            if now.After(exp.Add(sp.skew)) {
+                delete(sp.seen, k)
+            }
+        }
+        if len(sp.seen) >= maxSeen {
+            return ErrReplayed
+        }
+    }
+    sp.seen[id] = until
+    return nil
+}
+
+func attributes(assertion *element) map[string][]string {
+    out := make(map[string][]string)
+    for _, st := range assertion.childrenNamed(nsAssertion, "AttributeStatement") {
+        for _, a := range st.childrenNamed(nsAssertion, "Attribute") {
+            name := a.attr("Name")
+            for _, v := range a.childrenNamed(nsAssertion, "AttributeValue") {
+                out[name] = append(out[name], v.text())
+            }
+        }
+    }
+    return out
+}
+
+func (m AttributeMapping) apply(l *Login) error {
+    l.Tenant = m.Tenant
+    if m.TenantAttribute != "" {
+        l.Tenant = ""
+        for _, v := range l.Attributes[m.TenantAttribute] {
+            for _, t := range m.Tenants {
+                if v == t {
+                    l.Tenant = v
+                }
+            }
+        }
+    }
+    if l.Tenant == "" {
+        return fmt.Errorf("%w: tenant", ErrUnmapped)
+    }
+
+    l.Identity = l.NameID
+    if m.IdentityAttribute != "" {
+        l.Identity = ""
+        if v := l.Attributes[m.IdentityAttribute]; len(v) > 0 {
+            l.Identity = v[0]
+        }
+    }
+    if l.Identity == "" {
+        return fmt.Errorf("%w: identity", ErrUnmapped)
+    }
+
+    l.Role = m.DefaultRole
+    if m.RoleAttribute != "" {
+        for _, v := range l.Attributes[m.RoleAttribute] {
+            if role, ok := m.Roles[v]; ok {
+                l.Role = role
+                break
+            }
+        }
+    }
+    if l.Role == "" {
+        return fmt.Errorf("%w: role", ErrUnmapped)
+    }
+    return nil
+}
+
+// requestID issues an AuthnRequest ID that names its IdP and expiry under the SP key, so the
+// response is checked without keeping state. It starts with a letter, as an xs:ID must
+func (sp *ServiceProvider) requestID(idp string) (string, error) {
+    body := make([]byte, 16+8)
+    if _, err := rand.Read(body[:16]); err != nil {
+        return "", err
+    }
+    binary.BigEndian.PutUint64(body[16:], uint64(sp.clock.Now().Add(sp.requestTTL).Unix()))
+    return "id" + base64.RawURLEncoding.EncodeToString(append(body, sp.requestMAC(body, idp)...)), nil
+}
+
+func (sp *ServiceProvider) checkRequestID(id, idp string) error {
+    raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(id, "id"))
+    if err != nil || !strings.HasPrefix(id, "id") || len(raw) != 16+8+16 {
+        return ErrUnknownRequest
+    }
+    body := raw[:24]
+    if !hmac.Equal(raw[24:], sp.requestMAC(body, idp)) {
+        return ErrUnknownRequest
+    }
+    if sp.clock.Now().Unix() > int64(binary.BigEndian.Uint64(body[16:])) {
+        return ErrResponseExpired
+    }
+    return nil
+}
+
+func (sp *ServiceProvider) requestMAC(body []byte, idp string) []byte {
+    h := hmac.New(sha256.New, sp.key)
+    h.Write(body)
+    h.Write([]byte(idp))
+    return h.Sum(nil)[:16]
+}
+
+func parseTime(s string) (time.Time, error) {
+    if s == "" {
+        return time.Time{}, nil
+    }
+    return time.Parse(time.RFC3339Nano, s)
+}
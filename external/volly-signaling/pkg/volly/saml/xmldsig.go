@@ -0,0 +1,187 @@
+package saml
+
+import (
+    "crypto"
+    "crypto/ecdsa"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/sha512"
+    "crypto/subtle"
+    "crypto/x509"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "hash"
+    "math/big"
+    "strings"
+)
+
+// XML signature namespaces and algorithms. SHA-1 is refused
+const (
+    nsDSig     = "http://www.w3.org/2000/09/xmldsig#"
+    nsExcC14N  = "http://www.w3.org/2001/10/xml-exc-c14n#"
+    algExcC14N = "http://www.w3.org/2001/10/xml-exc-c14n#"
+    algEnvSig  = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+
+    algRSASHA256   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+    algRSASHA512   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+    algECDSASHA256 = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"
+    algSHA256      = "http://www.w3.org/2001/04/xmlenc#sha256"
+    algSHA512      = "http://www.w3.org/2001/04/xmlenc#sha512"
+)
+
+var (
+    ErrSignatureInvalid     = errors.New("SAML signature is invalid")
+    ErrUnsupportedAlgorithm = errors.New("unsupported XML signature algorithm")
+)
+
+// verifyEnveloped checks the signature that is a direct child of e: it must reference e by
+// its ID, use only the enveloped and exclusive canonicalization transforms and verify under
+// one of certs. KeyInfo in the document is ignored; only configured certificates count. It
+// reports false with no error when e is unsigned
+func verifyEnveloped(e *element, certs []*x509.Certificate) (bool, error) {
+    sigs := e.childrenNamed(nsDSig, "Signature")
+    switch len(sigs) {
+    case 0:
+        return false, nil
+    case 1:
+    default:
+        return false, fmt.Errorf("%w: more than one signature", ErrSignatureInvalid)
+    }
+    sig := sigs[0]
+    signedInfo := sig.child(nsDSig, "SignedInfo")
+    if signedInfo == nil {
+        return false, fmt.Errorf("%w: no SignedInfo", ErrSignatureInvalid)
+    }
+    c14n := signedInfo.child(nsDSig, "CanonicalizationMethod")
+    if c14n == nil || c14n.attr("Algorithm") != algExcC14N {
+        return false, fmt.Errorf("%w: canonicalization must be exclusive C14N", ErrUnsupportedAlgorithm)
+    }
+    method := signedInfo.child(nsDSig, "SignatureMethod")
+    if method == nil {
+        return false, fmt.Errorf("%w: no SignatureMethod", ErrSignatureInvalid)
+    }
+
+    // One reference, to e itself: anything else is how signature wrapping starts
+    ref := signedInfo.child(nsDSig, "Reference")
+    id := e.attr("ID")
+    if ref == nil || len(signedInfo.childrenNamed(nsDSig, "Reference")) != 1 {
+        return false, fmt.Errorf("%w: need exactly one reference", ErrSignatureInvalid)
+    }
+    if id == "" || ref.attr("URI") != "#"+id {
+        return false, fmt.Errorf("%w: reference is not to the signed element", ErrSignatureInvalid)
+    }
+    var inclusive []string
+    excC14N := false
+    if transforms := ref.child(nsDSig, "Transforms"); transforms != nil {
+        for _, t := range transforms.childrenNamed(nsDSig, "Transform") {
+            switch t.attr("Algorithm") {
+            case algEnvSig:
+            case algExcC14N:
+                excC14N = true
+                inclusive = prefixList(t)
+            default:
+                return false, fmt.Errorf("%w: transform %s", ErrUnsupportedAlgorithm, t.attr("Algorithm"))
+            }
+        }
+    }
+    if !excC14N {
+        return false, fmt.Errorf("%w: reference must be canonicalized with exclusive C14N", ErrUnsupportedAlgorithm)
+    }
+
+    digestMethod := ref.child(nsDSig, "DigestMethod")
+    digestValue := ref.child(nsDSig, "DigestValue")
+    if digestMethod == nil || digestValue == nil {
+        return false, fmt.Errorf("%w: no digest", ErrSignatureInvalid)
+    }
+    h, err := digestHash(digestMethod.attr("Algorithm"))
+    if err != nil {
+        return false, err
+    }
+    want, err := decodeBase64(digestValue.text())
+    if err != nil {
+        return false, fmt.Errorf("%w: malformed digest", ErrSignatureInvalid)
+    }
+    h.Write(canonicalize(e, sig, inclusive))
+    if subtle.ConstantTimeCompare(h.Sum(nil), want) != 1 {
+        return false, fmt.Errorf("%w: digest does not match", ErrSignatureInvalid)
+    }
+
+    sigValue := sig.child(nsDSig, "SignatureValue")
+    if sigValue == nil {
+        return false, fmt.Errorf("%w: no SignatureValue", ErrSignatureInvalid)
+    }
+    value, err := decodeBase64(sigValue.text())
+    if err != nil {
+        return false, fmt.Errorf("%w: malformed signature value", ErrSignatureInvalid)
+    }
+    signed := canonicalize(signedInfo, nil, prefixList(c14n))
+    for _, cert := range certs {
+        ok, err := verifyWith(cert.PublicKey, method.attr("Algorithm"), signed, value)
+        if err != nil {
+            return false, err
+        }
+        if ok {
+            return true, nil
+        }
+    }
+    return false, fmt.Errorf("%w: no configured certificate verifies it", ErrSignatureInvalid)
+}
+
+// prefixList reads an InclusiveNamespaces PrefixList under a canonicalization element
+func prefixList(method *element) []string {
+    in := method.child(nsExcC14N, "InclusiveNamespaces")
+    if in == nil {
+        return nil
+    }
+    var out []string
+    for _, p := range strings.Fields(in.attr("PrefixList")) {
+        if p == "#default" {
+            p = ""
+        }
+        out = append(out, p)
+    }
+    return out
+}
+
+func digestHash(alg string) (hash.Hash, error) {
+    switch alg {
+    case algSHA256:
+        return sha256.New(), nil
+    case algSHA512:
+        return sha512.New(), nil
+    }
+    return nil, fmt.Errorf("%w: digest %s", ErrUnsupportedAlgorithm, alg)
+}
+
+func verifyWith(pub crypto.PublicKey, alg string, signed, sig []byte) (bool, error) {
+    switch alg {
+    case algRSASHA256, algRSASHA512:
+        k, ok := pub.(*rsa.PublicKey)
+        if !ok {
+            return false, nil
+        }
+        if alg == algRSASHA256 {
+            sum := sha256.Sum256(signed)
+            return rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig) == nil, nil
+        }
+        sum := sha512.Sum512(signed)
+        return rsa.VerifyPKCS1v15(k, crypto.SHA512, sum[:], sig) == nil, nil
+    case algECDSASHA256:
+        k, ok := pub.(*ecdsa.PublicKey)
+        // XML signatures carry ECDSA as r || s, not ASN.1
+        if !ok || len(sig)%2 != 0 {
+            return false, nil
+        }
+        sum := sha256.Sum256(signed)
+        r := new(big.Int).SetBytes(sig[:len(sig)/2])
+        s := new(big.Int).SetBytes(sig[len(sig)/2:])
+        return ecdsa.Verify(k, sum[:], r, s), nil
+    }
+    return false, fmt.Errorf("%w: signature %s", ErrUnsupportedAlgorithm, alg)
+}
+
+// decodeBase64 reads standard base64 with the line breaks XML documents wrap it in
+func decodeBase64(s string) ([]byte, error) {
+    return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+}
@@ -0,0 +1,25 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package secure
+
+import "syscall"
+
+// allocate maps anonymous memory outside the Go heap and locks it into RAM
+func allocate(n int) ([]byte, bool, error) {
+    if n == 0 {
+        return []byte{}, false, nil
+    }
+
+    buf, err := syscall.Mmap(-1, 0, n, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+    if err != nil {
+        return nil, false, err
+    }
+    // mlock can fail under RLIMIT_MEMLOCK; the mapping still keeps secrets out of heap dumps
+    _ = syscall.Mlock(buf)
+    return buf, true, nil
+}
+
+func release(buf []byte) error {
+    _ = syscall.Munlock(buf)
+    return syscall.Munmap(buf)
+}
@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package secure
+
+// allocate falls back to heap memory where mmap/mlock are unavailable; Close still zeroizes
+func allocate(n int) ([]byte, bool, error) {
+    return make([]byte, n), false, nil
+}
+
+func release(buf []byte) error {
+    return nil
+}
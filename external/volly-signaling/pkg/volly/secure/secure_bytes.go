@@ -0,0 +1,96 @@
+package secure
+
+import (
+    "errors"
+    "runtime"
+    "sync"
+)
+
+var ErrClosed = errors.New("secure bytes have been closed")
+
+// SecureBytes holds secret material outside the Go heap where possible, locked into RAM
+// and zeroized on Close, so it doesn't show up in heap dumps or swap. A secret handed to
+// readers whose lifetime its owner doesn't control must not be Closed; it is zeroized and
+// released once the last of them drops it and it is garbage collected
+type SecureBytes struct {
+    mu     sync.RWMutex
+    buf    []byte
+    mapped bool
+    closed bool
+}
+
+// NewSecureBytes allocates n bytes of protected memory
+func NewSecureBytes(n int) (*SecureBytes, error) {
+    buf, mapped, err := allocate(n)
+    if err != nil {
+        return nil, err
+    }
+    s := &SecureBytes{buf: buf, mapped: mapped}
+    runtime.SetFinalizer(s, (*SecureBytes).Close)
+    return s, nil
+}
+
+// FromBytes moves src into protected memory and zeroizes src
+func FromBytes(src []byte) (*SecureBytes, error) {
+    s, err := NewSecureBytes(len(src))
+    if err != nil {
+        return nil, err
+    }
+    copy(s.buf, src)
+    Wipe(src)
+    return s, nil
+}
+
+// FromString copies a secret string into protected memory; the string itself cannot be wiped
+func FromString(src string) (*SecureBytes, error) {
+    s, err := NewSecureBytes(len(src))
+    if err != nil {
+        return nil, err
+    }
+    copy(s.buf, src)
+    return s, nil
+}
+
+// Len returns the secret length
+func (s *SecureBytes) Len() int {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    return len(s.buf)
+}
+
+// Use calls fn with the secret while holding a read lock so Close waits for it
+func (s *SecureBytes) Use(fn func(secret []byte) error) error {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    if s.closed {
+        return ErrClosed
+    }
+    return fn(s.buf)
+}
+
+// Close zeroizes and releases the memory; it is safe to call more than once
+func (s *SecureBytes) Close() error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if s.closed {
+        return nil
+    }
+    s.closed = true
+    runtime.SetFinalizer(s, nil)
+    Wipe(s.buf)
+    if s.mapped {
+        err := release(s.buf)
+        s.buf = nil
+        return err
+    }
+    s.buf = nil
+    return nil
+}
+
+// Wipe zeroizes b in place
+func Wipe(b []byte) {
+    for i := range b {
+        b[i] = 0
+    }
+}
@@ -0,0 +1,120 @@
+// Package shamir splits secrets into shares any threshold of which recover them, for
+// backing up root keys across custodians. Each byte of the secret is the constant term of
+// its own random polynomial over GF(2^8); share i holds every polynomial evaluated at i.
+// Field arithmetic is constant time, since the inputs are key material
+package shamir
+
+import (
+    "crypto/rand"
+    "errors"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/secure"
+)
+
+// MaxShares is the most shares a secret can be split into, one per nonzero field element
+const MaxShares = 255
+
+var (
+    ErrInvalidThreshold = errors.New("threshold must be between 2 and the number of shares")
+    ErrTooManyShares    = errors.New("a secret splits into at most 255 shares")
+    ErrEmptySecret      = errors.New("secret is empty")
+    ErrInvalidShares    = errors.New("shares are empty, differ in length or repeat an index")
+)
+
+// Share is one point on each of the secret's polynomials
+type Share struct {
+    // Index is the x coordinate, 1 through 255
+    Index byte   `json:"index"`
+    Value []byte `json:"value"`
+}
+
+// Split divides secret into n shares, any threshold of which recover it; fewer reveal nothing
+func Split(secret []byte, n, threshold int) ([]Share, error) {
+    switch {
+    case len(secret) == 0:
+        return nil, ErrEmptySecret
+    case n > MaxShares:
+        return nil, ErrTooManyShares
+    case threshold < 2 || threshold > n:
+        return nil, ErrInvalidThreshold
+    }
+    shares := make([]Share, n)
+    for i := range shares {
+        shares[i] = Share{Index: byte(i + 1), Value: make([]byte, len(secret))}
+    }
+    coeffs := make([]byte, threshold)
+    defer secure.Wipe(coeffs)
+    for b, s := range secret {
+        coeffs[0] = s
+        if _, err := rand.Read(coeffs[1:]); err != nil {
+            return nil, err
+        }
+        for i := range shares {
+            shares[i].Value[b] = evaluate(coeffs, shares[i].Index)
+        }
+    }
+    return shares, nil
+}
+
+// Combine recovers the secret from threshold or more shares of it. Too few shares give
+// a wrong secret rather than an error, so callers check the result, e.g. against a public key
+func Combine(shares []Share) ([]byte, error) {
+    if len(shares) < 2 {
+        return nil, ErrInvalidShares
+    }
+    size := len(shares[0].Value)
+    seen := make(map[byte]bool, len(shares))
+    for _, s := range shares {
+        if s.Index == 0 || seen[s.Index] || len(s.Value) != size || size == 0 {
+            return nil, ErrInvalidShares
+        }
+        seen[s.Index] = true
+    }
+    // Lagrange interpolation at x = 0; in GF(2^8) subtraction is addition
+    secret := make([]byte, size)
+    for i, si := range shares {
+        basis := byte(1)
+        for j, sj := range shares {
+            if i != j {
+                basis = mul(basis, mul(sj.Index, inverse(sj.Index^si.Index)))
+            }
+        }
+        for b := range secret {
+            secret[b] ^= mul(si.Value[b], basis)
+        }
+    }
+    return secret, nil
+}
+
+// evaluate computes the polynomial with coefficients coeffs, lowest first, at x
+func evaluate(coeffs []byte, x byte) byte {
+    var y byte
+    for i := len(coeffs) - 1; i >= 0; i-- {
+        y = mul(y, x) ^ coeffs[i]
+    }
+    return y
+}
+
+// mul multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x + 1 without branching on its inputs
+func mul(a, b byte) byte {
+    var p byte
+    for i := 0; i < 8; i++ {
+        p ^= a & -(b & 1)
+        carry := -(a >> 7)
+        a = a<<1 ^ 0x1b&carry
+        b >>= 1
+    }
+    return p
+}
+
+// inverse is a^254, which is a^-1 for nonzero a
+func inverse(a byte) byte {
+    result := byte(1)
+    for e := 254; e > 0; e >>= 1 {
+        if e&1 == 1 {
+            result = mul(result, a)
+        }
+        a = mul(a, a)
+    }
+    return result
+}
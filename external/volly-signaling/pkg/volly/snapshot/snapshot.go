@@ -0,0 +1,227 @@
+package snapshot
+
+import (
+    "bytes"
+    "context"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "encoding/json"
+    "errors"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/configstore"
+    "github.com/volly-org/volly-signaling/pkg/volly/revocation"
+    "github.com/volly-org/volly-signaling/pkg/volly/secure"
+)
+
+// Version is the State format written by ExportState
+const Version = 1
+
+// magic prefixes every snapshot and is authenticated with it
+var magic = []byte("VOLLYSNAP1")
+
+var (
+    ErrNoSnapshotKey    = errors.New("snapshot key must be 32 bytes")
+    ErrInvalidSnapshot  = errors.New("snapshot is not a Volly state snapshot")
+    ErrSnapshotTampered = errors.New("snapshot failed authentication or was sealed under another key")
+    ErrNoRevocationList = errors.New("revocation store cannot list revocations")
+    ErrUnknownVersion   = errors.New("snapshot format version is not supported")
+)
+
+// State is the portable auth state; it holds API secrets in the clear and only ever
+// leaves the process sealed
+type State struct {
+    Version      int                       `json:"version"`
+    CreatedAt    time.Time                 `json:"createdAt"`
+    Tenants      []configstore.Tenant      `json:"tenants"`
+    APIKeys      []APIKey                  `json:"apiKeys"`
+    RoomPolicies []configstore.RoomPolicy  `json:"roomPolicies"`
+    Cutoffs      []revocation.Cutoff       `json:"revocationCutoffs"`
+    Tokens       []revocation.RevokedToken `json:"revokedTokens"`
+}
+
+// APIKey is configstore.APIKey with its secret
+type APIKey struct {
+    ID       string    `json:"id"`
+    Tenant   string    `json:"tenant"`
+    Scopes   []string  `json:"scopes,omitempty"`
+    Secret   []byte    `json:"secret,omitempty"`
+    NotAfter time.Time `json:"notAfter,omitempty"`
+}
+
+// Stores is the state source for ExportState and the destination for ImportState
+type Stores struct {
+    Config      configstore.Store
+    Revocations revocation.Store
+}
+
+// ExportState snapshots s and seals it with AES-256-GCM under key
+func ExportState(ctx context.Context, s Stores, key *secure.SecureBytes) ([]byte, error) {
+    lister, ok := s.Revocations.(revocation.Lister)
+    if !ok {
+        return nil, ErrNoRevocationList
+    }
+
+    state := State{Version: Version, CreatedAt: time.Now().UTC()}
+    var err error
+    if state.Tenants, err = s.Config.ListTenants(ctx); err != nil {
+        return nil, err
+    }
+    keys, err := s.Config.ListAPIKeys(ctx, "")
+    if err != nil {
+        return nil, err
+    }
+    defer func() {
+        for _, k := range state.APIKeys {
+            secure.Wipe(k.Secret)
+        }
+    }()
+    for _, listed := range keys {
+        // Listings may omit secrets, so each key is fetched individually
+        k, err := s.Config.GetAPIKey(ctx, listed.ID)
+        if errors.Is(err, configstore.ErrNotFound) {
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+        entry := APIKey{ID: k.ID, Tenant: k.Tenant, Scopes: k.Scopes, NotAfter: k.NotAfter}
+        if k.Secret != nil {
+            if err := k.Secret.Use(func(b []byte) error {
+                entry.Secret = append([]byte(nil), b...)
+                return nil
+            }); err != nil {
+                return nil, err
+            }
+        }
+        state.APIKeys = append(state.APIKeys, entry)
+    }
+    if state.RoomPolicies, err = s.Config.ListRoomPolicies(ctx, ""); err != nil {
+        return nil, err
+    }
+    if state.Cutoffs, state.Tokens, err = lister.ListRevocations(ctx); err != nil {
+        return nil, err
+    }
+
+    plain, err := json.Marshal(state)
+    if err != nil {
+        return nil, err
+    }
+    defer secure.Wipe(plain)
+    return seal(key, plain)
+}
+
+// ImportState unseals a snapshot and writes it into s. Entries are upserted, so importing
+// into a live deployment merges rather than replaces; revocations only ever tighten
+func ImportState(ctx context.Context, data []byte, s Stores, key *secure.SecureBytes) (*State, error) {
+    plain, err := open(key, data)
+    if err != nil {
+        return nil, err
+    }
+    defer secure.Wipe(plain)
+
+    var state State
+    if err := json.Unmarshal(plain, &state); err != nil {
+        return nil, ErrInvalidSnapshot
+    }
+    defer func() {
+        for _, k := range state.APIKeys {
+            secure.Wipe(k.Secret)
+        }
+    }()
+    if state.Version != Version {
+        return nil, ErrUnknownVersion
+    }
+
+    for _, t := range state.Tenants {
+        if err := s.Config.PutTenant(ctx, t); err != nil {
+            return nil, err
+        }
+    }
+    for _, k := range state.APIKeys {
+        entry := configstore.APIKey{ID: k.ID, Tenant: k.Tenant, Scopes: k.Scopes, NotAfter: k.NotAfter}
+        if len(k.Secret) > 0 {
+            if entry.Secret, err = secure.FromBytes(append([]byte(nil), k.Secret...)); err != nil {
+                return nil, err
+            }
+        }
+        if err := s.Config.PutAPIKey(ctx, entry); err != nil {
+            return nil, err
+        }
+    }
+    for _, p := range state.RoomPolicies {
+        if err := s.Config.PutRoomPolicy(ctx, p); err != nil {
+            return nil, err
+        }
+    }
+    for _, c := range state.Cutoffs {
+        if err := s.Revocations.RevokeWhere(ctx, c.Predicate, c.Cutoff); err != nil {
+            return nil, err
+        }
+    }
+    for _, t := range state.Tokens {
+        if err := s.Revocations.RevokeToken(ctx, t.JTI, t.ExpiresAt); err != nil {
+            return nil, err
+        }
+    }
+
+    // Secrets have been handed to the store; the summary returned carries none
+    summary := state
+    summary.APIKeys = nil
+    for _, k := range state.APIKeys {
+        summary.APIKeys = append(summary.APIKeys, APIKey{ID: k.ID, Tenant: k.Tenant, Scopes: k.Scopes, NotAfter: k.NotAfter})
+    }
+    return &summary, nil
+}
+
+func seal(key *secure.SecureBytes, plain []byte) ([]byte, error) {
+    var out []byte
+    err := withAEAD(key, func(aead cipher.AEAD) error {
+        nonce := make([]byte, aead.NonceSize())
+        if _, err := rand.Read(nonce); err != nil {
+            return err
+        }
+        out = append(append([]byte(nil), magic...), nonce...)
+        out = aead.Seal(out, nonce, plain, magic)
+        return nil
+    })
+    return out, err
+}
+
+func open(key *secure.SecureBytes, data []byte) ([]byte, error) {
+    if !bytes.HasPrefix(data, magic) {
+        return nil, ErrInvalidSnapshot
+    }
+    data = data[len(magic):]
+    var plain []byte
+    err := withAEAD(key, func(aead cipher.AEAD) error {
+        if len(data) < aead.NonceSize() {
+            return ErrInvalidSnapshot
+        }
+        var err error
+        plain, err = aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], magic)
+        if err != nil {
+            return ErrSnapshotTampered
+        }
+        return nil
+    })
+    return plain, err
+}
+
+func withAEAD(key *secure.SecureBytes, fn func(aead cipher.AEAD) error) error {
+    if key == nil || key.Len() != 32 {
+        return ErrNoSnapshotKey
+    }
+    return key.Use(func(k []byte) error {
+        block, err := aes.NewCipher(k)
+        if err != nil {
+            return ErrNoSnapshotKey
+        }
+        aead, err := cipher.NewGCM(block)
+        if err != nil {
+            return err
+        }
+        return fn(aead)
+    })
+}
@@ -0,0 +1,310 @@
+package sqlstore
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "strings"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/audit"
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/tagset"
+)
+
+// AuditLog is a chained audit.Log and an audit.Tombstoner; rows are only ever inserted,
+// apart from the identities Tombstone rewrites. Entries from before chaining have no
+// sequence number and are outside the chain
+type AuditLog struct {
+    db    *DB
+    clock clock.Clock
+}
+
+// NewAuditLog uses db, which must have been migrated
+func NewAuditLog(db *DB) *AuditLog {
+    return &AuditLog{db: db, clock: clock.System}
+}
+
+// SetClock sets the time source for entries appended without a time
+func (l *AuditLog) SetClock(c clock.Clock) *AuditLog {
+    l.clock = c
+    return l
+}
+
+func (l *AuditLog) Append(ctx context.Context, e audit.Entry) error {
+    if e.Time.IsZero() {
+        e.Time = l.clock.Now()
+    }
+    detail := ""
+    if len(e.Detail) > 0 {
+        data, err := json.Marshal(e.Detail)
+        if err != nil {
+            return err
+        }
+        detail = string(data)
+    }
+    // Instances sharing the database race for the next sequence number; the loser reads the
+    // new head and tries again
+    for attempt := 0; attempt < appendAttempts; attempt++ {
+        head, err := l.Head(ctx)
+        if err != nil {
+            return err
+        }
+        audit.Link(&e, head)
+        salts, err := saltsColumn(e.Salts)
+        if err != nil {
+            return err
+        }
+        res, err := l.db.exec(ctx, `INSERT INTO volly_audit_log (at, actor, action, tenant, target, detail, tags, seq, prev, hash, salts)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (seq) DO NOTHING`,
+            toNanos(e.Time), e.Actor, e.Action, e.Tenant, e.Target, detail, tagsColumn(e.Tags), int64(e.Seq), e.Prev, e.Hash, salts)
+        if err != nil {
+            return err
+        }
+        if n, err := res.RowsAffected(); err != nil || n == 1 {
+            return err
+        }
+    }
+    return errors.New("audit append: lost the race for the chain head too many times")
+}
+
+// appendAttempts bounds Append's retries when other instances keep taking the head
+const appendAttempts = 10
+
+const auditColumns = `at, actor, action, tenant, target, detail, tags, seq, prev, hash, salts`
+
+func scanAuditEntries(rows *sql.Rows) ([]audit.Entry, error) {
+    var out []audit.Entry
+    for rows.Next() {
+        var e audit.Entry
+        var at int64
+        var seq sql.NullInt64
+        var detail, tags, salts string
+        if err := rows.Scan(&at, &e.Actor, &e.Action, &e.Tenant, &e.Target, &detail, &tags, &seq, &e.Prev, &e.Hash, &salts); err != nil {
+            return nil, err
+        }
+        if salts != "" {
+            if err := json.Unmarshal([]byte(salts), &e.Salts); err != nil {
+                return nil, err
+            }
+        }
+        e.Time = fromNanos(at)
+        e.Seq = uint64(seq.Int64)
+        if tags != "" {
+            var err error
+            if e.Tags, err = tagset.Parse(strings.Trim(tags, ",")); err != nil {
+                return nil, err
+            }
+        }
+        if detail != "" {
+            if err := json.Unmarshal([]byte(detail), &e.Detail); err != nil {
+                return nil, err
+            }
+        }
+        out = append(out, e)
+    }
+    return out, rows.Err()
+}
+
+func (l *AuditLog) Head(ctx context.Context) (audit.Entry, error) {
+    rows, err := l.db.query(ctx, `SELECT `+auditColumns+` FROM volly_audit_log WHERE seq IS NOT NULL ORDER BY seq DESC LIMIT 1`)
+    if err != nil {
+        return audit.Entry{}, err
+    }
+    defer rows.Close()
+    entries, err := scanAuditEntries(rows)
+    if err != nil || len(entries) == 0 {
+        return audit.Entry{}, err
+    }
+    return entries[0], nil
+}
+
+func (l *AuditLog) Range(ctx context.Context, from uint64, limit int) ([]audit.Entry, error) {
+    rows, err := l.db.query(ctx, `SELECT `+auditColumns+` FROM volly_audit_log WHERE seq >= ? ORDER BY seq LIMIT ?`, int64(from), limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    return scanAuditEntries(rows)
+}
+
+// saltsColumn stores salts as JSON; entries from before salting have none
+func saltsColumn(salts map[string][]byte) (string, error) {
+    if len(salts) == 0 {
+        return "", nil
+    }
+    data, err := json.Marshal(salts)
+    return string(data), err
+}
+
+// tagsColumn stores tags in Encode's form between commas, so every pair can be matched
+// with LIKE '%,key=value,%'
+func tagsColumn(tags map[string]string) string {
+    if len(tags) == 0 {
+        return ""
+    }
+    return "," + tagset.Encode(tags) + ","
+}
+
+func (l *AuditLog) Query(ctx context.Context, q audit.Query) ([]audit.Entry, error) {
+    limit := q.Limit
+    if limit <= 0 {
+        limit = audit.DefaultQueryLimit
+    }
+    where := `(? = '' OR tenant = ?) AND (? = '' OR actor = ?) AND (? = '' OR action = ?)
+        AND at >= ? AND (? = 0 OR at < ?)`
+    args := []interface{}{q.Tenant, q.Tenant, q.Actor, q.Actor, q.Action, q.Action,
+        toNanos(q.Since), toNanos(q.Until), toNanos(q.Until)}
+    for k, v := range q.Tags {
+        where += ` AND tags LIKE ? ESCAPE '\'`
+        args = append(args, "%,"+escapeLike(k+"="+v)+",%")
+    }
+    rows, err := l.db.query(ctx, `SELECT `+auditColumns+` FROM volly_audit_log
+        WHERE `+where+`
+        ORDER BY at DESC, id DESC LIMIT ?`, append(args, limit)...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    return scanAuditEntries(rows)
+}
+
+// Tombstone replaces identity with tombstone in tenant's entries, destroying the salts of
+// the fields replaced, returns how many changed, and appends the audit.TombstoneRecord naming
+// the chained ones. Hashes are left as they were
+func (l *AuditLog) Tombstone(ctx context.Context, tenant, identity, tombstone string) (int, error) {
+    encoded, err := json.Marshal(identity)
+    if err != nil {
+        return 0, err
+    }
+    rows, err := l.db.query(ctx, `SELECT id, seq, actor, target, detail, salts FROM volly_audit_log
+        WHERE tenant = ? AND (actor = ? OR target = ? OR detail LIKE ? ESCAPE '\')`,
+        tenant, identity, identity, "%"+escapeLike(string(encoded))+"%")
+    if err != nil {
+        return 0, err
+    }
+    type change struct {
+        id     int64
+        seq    sql.NullInt64
+        entry  audit.Entry
+        fields map[string][]byte
+    }
+    var changes []change
+    for rows.Next() {
+        var c change
+        var detail, salts string
+        if err := rows.Scan(&c.id, &c.seq, &c.entry.Actor, &c.entry.Target, &detail, &salts); err != nil {
+            rows.Close()
+            return 0, err
+        }
+        if detail != "" {
+            if err := json.Unmarshal([]byte(detail), &c.entry.Detail); err != nil {
+                rows.Close()
+                return 0, err
+            }
+        }
+        if salts != "" {
+            if err := json.Unmarshal([]byte(salts), &c.entry.Salts); err != nil {
+                rows.Close()
+                return 0, err
+            }
+        }
+        c.entry.Seq = uint64(c.seq.Int64)
+        if c.fields = audit.TombstoneEntry(&c.entry, identity, tombstone); len(c.fields) > 0 {
+            changes = append(changes, c)
+        }
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return 0, err
+    }
+
+    rewritten := make(map[uint64]map[string][]byte)
+    n := 0
+    for _, c := range changes {
+        detail := ""
+        if len(c.entry.Detail) > 0 {
+            data, err := json.Marshal(c.entry.Detail)
+            if err != nil {
+                return n, err
+            }
+            detail = string(data)
+        }
+        salts, err := saltsColumn(c.entry.Salts)
+        if err != nil {
+            return n, err
+        }
+        if _, err := l.db.exec(ctx, `UPDATE volly_audit_log SET actor = ?, target = ?, detail = ?, salts = ? WHERE id = ?`,
+            c.entry.Actor, c.entry.Target, detail, salts, c.id); err != nil {
+            return n, err
+        }
+        if c.seq.Valid {
+            rewritten[uint64(c.seq.Int64)] = c.fields
+        }
+        n++
+    }
+    if len(rewritten) > 0 {
+        if err := l.Append(ctx, audit.TombstoneRecord(tenant, tombstone, rewritten)); err != nil {
+            return n, err
+        }
+    }
+    return n, nil
+}
+
+// Prune deletes, or with dryRun counts, the entries from before before. The chain then
+// verifies from the oldest entry kept
+func (l *AuditLog) Prune(ctx context.Context, before time.Time, dryRun bool) (int, error) {
+    if dryRun {
+        var n int
+        err := l.db.queryRow(ctx, `SELECT COUNT(*) FROM volly_audit_log WHERE at < ?`, toNanos(before)).Scan(&n)
+        return n, err
+    }
+    res, err := l.db.exec(ctx, `DELETE FROM volly_audit_log WHERE at < ?`, toNanos(before))
+    if err != nil {
+        return 0, err
+    }
+    n, err := res.RowsAffected()
+    return int(n), err
+}
+
+// escapeLike escapes the LIKE wildcards in s for ESCAPE '\'
+func escapeLike(s string) string {
+    return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// AuditAnchorStore is an audit.AnchorStore. Instances anchoring the same head store one anchor
+type AuditAnchorStore struct {
+    db *DB
+}
+
+// NewAuditAnchorStore uses db, which must have been migrated
+func NewAuditAnchorStore(db *DB) *AuditAnchorStore {
+    return &AuditAnchorStore{db: db}
+}
+
+func (s *AuditAnchorStore) PutAnchor(ctx context.Context, a audit.Anchor) error {
+    _, err := s.db.exec(ctx, `INSERT INTO volly_audit_anchors (seq, head, signed_at, algorithm, signature) VALUES (?, ?, ?, ?, ?)
+        ON CONFLICT (seq) DO NOTHING`, int64(a.Seq), a.Head, a.Timestamp, a.Algorithm, a.Signature)
+    return err
+}
+
+func (s *AuditAnchorStore) ListAnchors(ctx context.Context) ([]audit.Anchor, error) {
+    rows, err := s.db.query(ctx, `SELECT seq, head, signed_at, algorithm, signature FROM volly_audit_anchors ORDER BY seq`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var out []audit.Anchor
+    for rows.Next() {
+        var a audit.Anchor
+        var seq int64
+        if err := rows.Scan(&seq, &a.Head, &a.Timestamp, &a.Algorithm, &a.Signature); err != nil {
+            return nil, err
+        }
+        a.Seq = uint64(seq)
+        out = append(out, a)
+    }
+    return out, rows.Err()
+}
@@ -0,0 +1,403 @@
+package sqlstore
+
+import (
+    "bytes"
+    "context"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "sync"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/configstore"
+    "github.com/volly-org/volly-signaling/pkg/volly/envelope"
+    "github.com/volly-org/volly-signaling/pkg/volly/secure"
+)
+
+var (
+    ErrNoSealKey      = errors.New("config store needs a 32-byte key to seal API secrets")
+    ErrSecretTampered = errors.New("sealed API secret failed authentication")
+)
+
+// ConfigStore is a configstore.Store. API secrets are sealed with AES-256-GCM and bound to
+// their key ID, so a database dump alone cannot sign tokens: under the envelope sealer's
+// data key when one is set, else directly under sealKey. Secrets sealed under sealKey
+// before a sealer was set still open, and Reseal moves them over
+type ConfigStore struct {
+    db      *DB
+    sealKey *secure.SecureBytes
+    sealer  *envelope.Sealer
+
+    // Unsealed secrets are owned by the store, as in configstore.MemoryStore, and reused
+    // while the row is unchanged rather than mapping fresh protected memory per lookup
+    mu       sync.Mutex
+    unsealed map[string]unsealedSecret
+}
+
+type unsealedSecret struct {
+    sealed []byte
+    secret *secure.SecureBytes
+}
+
+// NewConfigStore uses db, which must have been migrated. sealKey may be nil with a sealer
+// and no secrets sealed before it
+func NewConfigStore(db *DB, sealKey *secure.SecureBytes) *ConfigStore {
+    return &ConfigStore{db: db, sealKey: sealKey, unsealed: make(map[string]unsealedSecret)}
+}
+
+// SetSealer seals secrets written from now on under sealer's data keys
+func (s *ConfigStore) SetSealer(sealer *envelope.Sealer) *ConfigStore {
+    s.sealer = sealer
+    return s
+}
+
+func (s *ConfigStore) GetTenant(ctx context.Context, id string) (configstore.Tenant, error) {
+    t := configstore.Tenant{ID: id}
+    err := s.db.queryRow(ctx, `SELECT display_name, max_rooms, suspended FROM volly_tenants WHERE id = ?`, id).
+        Scan(&t.DisplayName, &t.MaxRooms, &t.Suspended)
+    if errors.Is(err, sql.ErrNoRows) {
+        return configstore.Tenant{}, configstore.ErrNotFound
+    }
+    return t, err
+}
+
+func (s *ConfigStore) PutTenant(ctx context.Context, t configstore.Tenant) error {
+    if t.ID == "" {
+        return configstore.ErrTenantRequired
+    }
+    _, err := s.db.exec(ctx, `INSERT INTO volly_tenants (id, display_name, max_rooms, suspended) VALUES (?, ?, ?, ?)
+        ON CONFLICT (id) DO UPDATE SET display_name = excluded.display_name, max_rooms = excluded.max_rooms,
+        suspended = excluded.suspended`,
+        t.ID, t.DisplayName, t.MaxRooms, t.Suspended)
+    return err
+}
+
+func (s *ConfigStore) DeleteTenant(ctx context.Context, id string) error {
+    _, err := s.db.exec(ctx, `DELETE FROM volly_tenants WHERE id = ?`, id)
+    return err
+}
+
+func (s *ConfigStore) ListTenants(ctx context.Context) ([]configstore.Tenant, error) {
+    rows, err := s.db.query(ctx, `SELECT id, display_name, max_rooms, suspended FROM volly_tenants ORDER BY id`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    out := []configstore.Tenant{}
+    for rows.Next() {
+        var t configstore.Tenant
+        if err := rows.Scan(&t.ID, &t.DisplayName, &t.MaxRooms, &t.Suspended); err != nil {
+            return nil, err
+        }
+        out = append(out, t)
+    }
+    return out, rows.Err()
+}
+
+func (s *ConfigStore) GetAPIKey(ctx context.Context, id string) (configstore.APIKey, error) {
+    k := configstore.APIKey{ID: id}
+    var scopes string
+    var sealed []byte
+    var notAfter int64
+    err := s.db.queryRow(ctx, `SELECT tenant, scopes, sealed_secret, not_after FROM volly_api_keys WHERE id = ?`, id).
+        Scan(&k.Tenant, &scopes, &sealed, &notAfter)
+    if errors.Is(err, sql.ErrNoRows) {
+        return configstore.APIKey{}, configstore.ErrNotFound
+    }
+    if err != nil {
+        return configstore.APIKey{}, err
+    }
+    if err := decodeScopes(scopes, &k.Scopes); err != nil {
+        return configstore.APIKey{}, err
+    }
+    k.NotAfter = fromNanos(notAfter)
+    if len(sealed) > 0 {
+        if k.Secret, err = s.secret(id, sealed); err != nil {
+            return configstore.APIKey{}, err
+        }
+    }
+    return k, nil
+}
+
+// secret returns the cached unsealed secret for a row, unsealing it when the row changed
+func (s *ConfigStore) secret(id string, sealed []byte) (*secure.SecureBytes, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    cached, ok := s.unsealed[id]
+    if ok && bytes.Equal(cached.sealed, sealed) {
+        return cached.secret, nil
+    }
+    secret, err := s.open(id, sealed)
+    if err != nil {
+        return nil, err
+    }
+    // Readers may still hold the previous secret; it is released once they drop it
+    s.unsealed[id] = unsealedSecret{sealed: sealed, secret: secret}
+    return secret, nil
+}
+
+// forget drops the cached secret for id, which is zeroized once no reader holds it
+func (s *ConfigStore) forget(id string) {
+    s.mu.Lock()
+    delete(s.unsealed, id)
+    s.mu.Unlock()
+}
+
+// PutAPIKey seals and stores the secret, taking ownership of it
+func (s *ConfigStore) PutAPIKey(ctx context.Context, k configstore.APIKey) error {
+    if k.Tenant == "" {
+        return configstore.ErrTenantRequired
+    }
+    if k.ID == "" {
+        return errors.New("key id is required")
+    }
+    var sealed []byte
+    if k.Secret != nil {
+        var err error
+        if sealed, err = s.seal(k.ID, k.Secret); err != nil {
+            return err
+        }
+    }
+    scopes, err := json.Marshal(k.Scopes)
+    if err != nil {
+        return err
+    }
+    _, err = s.db.exec(ctx, `INSERT INTO volly_api_keys (id, tenant, scopes, sealed_secret, not_after) VALUES (?, ?, ?, ?, ?)
+        ON CONFLICT (id) DO UPDATE SET tenant = excluded.tenant, scopes = excluded.scopes,
+        sealed_secret = excluded.sealed_secret, not_after = excluded.not_after`,
+        k.ID, k.Tenant, string(scopes), sealed, toNanos(k.NotAfter))
+    if err != nil {
+        return err
+    }
+    s.forget(k.ID)
+    if k.Secret != nil {
+        s.mu.Lock()
+        s.unsealed[k.ID] = unsealedSecret{sealed: sealed, secret: k.Secret}
+        s.mu.Unlock()
+    }
+    return nil
+}
+
+func (s *ConfigStore) DeleteAPIKey(ctx context.Context, id string) error {
+    if _, err := s.db.exec(ctx, `DELETE FROM volly_api_keys WHERE id = ?`, id); err != nil {
+        return err
+    }
+    s.forget(id)
+    return nil
+}
+
+// ListAPIKeys returns keys without their secrets; use GetAPIKey to unseal one
+func (s *ConfigStore) ListAPIKeys(ctx context.Context, tenant string) ([]configstore.APIKey, error) {
+    rows, err := s.db.query(ctx, `SELECT id, tenant, scopes, not_after FROM volly_api_keys
+        WHERE ? = '' OR tenant = ? ORDER BY id`, tenant, tenant)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var out []configstore.APIKey
+    for rows.Next() {
+        var k configstore.APIKey
+        var scopes string
+        var notAfter int64
+        if err := rows.Scan(&k.ID, &k.Tenant, &scopes, &notAfter); err != nil {
+            return nil, err
+        }
+        if err := decodeScopes(scopes, &k.Scopes); err != nil {
+            return nil, err
+        }
+        k.NotAfter = fromNanos(notAfter)
+        out = append(out, k)
+    }
+    return out, rows.Err()
+}
+
+func (s *ConfigStore) PutRoomPolicy(ctx context.Context, p configstore.RoomPolicy) error {
+    if p.Tenant == "" {
+        return configstore.ErrTenantRequired
+    }
+    providers, err := json.Marshal(p.Providers)
+    if err != nil {
+        return err
+    }
+    _, err = s.db.exec(ctx, `INSERT INTO volly_room_policies (tenant, name, room, max_participants, require_pq, recording_allowed, shadow, providers, pin_hash)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (tenant, name) DO UPDATE SET room = excluded.room, max_participants = excluded.max_participants,
+        require_pq = excluded.require_pq, recording_allowed = excluded.recording_allowed, shadow = excluded.shadow,
+        providers = excluded.providers, pin_hash = excluded.pin_hash`,
+        p.Tenant, p.Name, p.Room, p.MaxParticipants, p.RequirePQ, p.RecordingAllowed, p.Shadow, string(providers), p.PINHash)
+    return err
+}
+
+func (s *ConfigStore) DeleteRoomPolicy(ctx context.Context, tenant, name string) error {
+    _, err := s.db.exec(ctx, `DELETE FROM volly_room_policies WHERE tenant = ? AND name = ?`, tenant, name)
+    return err
+}
+
+func (s *ConfigStore) ListRoomPolicies(ctx context.Context, tenant string) ([]configstore.RoomPolicy, error) {
+    rows, err := s.db.query(ctx, `SELECT tenant, name, room, max_participants, require_pq, recording_allowed, shadow, providers, pin_hash
+        FROM volly_room_policies WHERE ? = '' OR tenant = ? ORDER BY tenant, name`, tenant, tenant)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var out []configstore.RoomPolicy
+    for rows.Next() {
+        var p configstore.RoomPolicy
+        var providers string
+        if err := rows.Scan(&p.Tenant, &p.Name, &p.Room, &p.MaxParticipants, &p.RequirePQ, &p.RecordingAllowed, &p.Shadow, &providers, &p.PINHash); err != nil {
+            return nil, err
+        }
+        if err := decodeScopes(providers, &p.Providers); err != nil {
+            return nil, err
+        }
+        out = append(out, p)
+    }
+    return out, rows.Err()
+}
+
+func decodeScopes(s string, out *[]string) error {
+    if s == "" || s == "null" {
+        return nil
+    }
+    return json.Unmarshal([]byte(s), out)
+}
+
+// Reseal seals again, under the current data key, every secret that is under an older one
+// or predates the sealer. A row written meanwhile is left to its writer
+func (s *ConfigStore) Reseal(ctx context.Context, sealer *envelope.Sealer) (int, error) {
+    rows, err := s.db.query(ctx, `SELECT id, sealed_secret FROM volly_api_keys WHERE sealed_secret IS NOT NULL`)
+    if err != nil {
+        return 0, err
+    }
+    type row struct {
+        id     string
+        sealed []byte
+    }
+    var stale []row
+    for rows.Next() {
+        var r row
+        if err := rows.Scan(&r.id, &r.sealed); err != nil {
+            rows.Close()
+            return 0, err
+        }
+        if len(r.sealed) > 0 && sealer.Stale(r.sealed) {
+            stale = append(stale, r)
+        }
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return 0, err
+    }
+
+    n := 0
+    for _, r := range stale {
+        secret, err := s.open(r.id, r.sealed)
+        if err != nil {
+            return n, fmt.Errorf("api key %s: %w", r.id, err)
+        }
+        var resealed []byte
+        err = secret.Use(func(value []byte) error {
+            resealed, err = sealer.Seal([]byte(r.id), value)
+            return err
+        })
+        secret.Close()
+        if err != nil {
+            return n, err
+        }
+        res, err := s.db.exec(ctx, `UPDATE volly_api_keys SET sealed_secret = ? WHERE id = ? AND sealed_secret = ?`, resealed, r.id, r.sealed)
+        if err != nil {
+            return n, err
+        }
+        if changed, err := res.RowsAffected(); err == nil && changed == 0 {
+            continue
+        }
+        // The cached secret is the same value; only the row it was read from changed
+        s.mu.Lock()
+        if cached, ok := s.unsealed[r.id]; ok && bytes.Equal(cached.sealed, r.sealed) {
+            s.unsealed[r.id] = unsealedSecret{sealed: resealed, secret: cached.secret}
+        }
+        s.mu.Unlock()
+        n++
+    }
+    return n, nil
+}
+
+func (s *ConfigStore) seal(id string, secret *secure.SecureBytes) ([]byte, error) {
+    var sealed []byte
+    if s.sealer != nil {
+        err := secret.Use(func(value []byte) error {
+            var err error
+            sealed, err = s.sealer.Seal([]byte(id), value)
+            return err
+        })
+        return sealed, err
+    }
+    err := s.withAEAD(func(aead cipher.AEAD) error {
+        return secret.Use(func(value []byte) error {
+            nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+            if _, err := rand.Read(nonce); err != nil {
+                return err
+            }
+            sealed = aead.Seal(nonce, nonce, value, []byte(id))
+            return nil
+        })
+    })
+    return sealed, err
+}
+
+func (s *ConfigStore) open(id string, sealed []byte) (*secure.SecureBytes, error) {
+    // A legacy value whose random nonce happens to start like an envelope falls through
+    if envelope.IsSealed(sealed) && (s.sealer != nil || s.sealKey == nil) {
+        if s.sealer == nil {
+            return nil, ErrNoSealKey
+        }
+        value, err := s.sealer.Open([]byte(id), sealed)
+        if err == nil {
+            return secure.FromBytes(value)
+        }
+        if s.sealKey == nil {
+            if errors.Is(err, envelope.ErrTampered) {
+                return nil, ErrSecretTampered
+            }
+            return nil, err
+        }
+    }
+    var secret *secure.SecureBytes
+    err := s.withAEAD(func(aead cipher.AEAD) error {
+        if len(sealed) < aead.NonceSize() {
+            return ErrSecretTampered
+        }
+        nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+        value, err := aead.Open(nil, nonce, ciphertext, []byte(id))
+        if err != nil {
+            return ErrSecretTampered
+        }
+        secret, err = secure.FromBytes(value)
+        return err
+    })
+    return secret, err
+}
+
+func (s *ConfigStore) withAEAD(fn func(aead cipher.AEAD) error) error {
+    if s.sealKey == nil || s.sealKey.Len() != 32 {
+        return ErrNoSealKey
+    }
+    return s.sealKey.Use(func(key []byte) error {
+        block, err := aes.NewCipher(key)
+        if err != nil {
+            return ErrNoSealKey
+        }
+        aead, err := cipher.NewGCM(block)
+        if err != nil {
+            return err
+        }
+        return fn(aead)
+    })
+}
@@ -0,0 +1,45 @@
+package sqlstore
+
+import (
+    "context"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/envelope"
+)
+
+// DataKeyStore is an envelope.KeyStore. Data keys are stored wrapped, so the table is no
+// use without the KMS key
+type DataKeyStore struct {
+    db *DB
+}
+
+// NewDataKeyStore uses db, which must have been migrated
+func NewDataKeyStore(db *DB) *DataKeyStore {
+    return &DataKeyStore{db: db}
+}
+
+func (s *DataKeyStore) PutDataKey(ctx context.Context, k envelope.DataKey) error {
+    _, err := s.db.exec(ctx, `INSERT INTO volly_data_keys (id, kek_id, wrapped, created_at) VALUES (?, ?, ?, ?)
+        ON CONFLICT (id) DO UPDATE SET kek_id = excluded.kek_id, wrapped = excluded.wrapped`,
+        k.ID, k.KEKID, k.Wrapped, toNanos(k.CreatedAt))
+    return err
+}
+
+func (s *DataKeyStore) ListDataKeys(ctx context.Context) ([]envelope.DataKey, error) {
+    rows, err := s.db.query(ctx, `SELECT id, kek_id, wrapped, created_at FROM volly_data_keys ORDER BY created_at`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var out []envelope.DataKey
+    for rows.Next() {
+        var k envelope.DataKey
+        var created int64
+        if err := rows.Scan(&k.ID, &k.KEKID, &k.Wrapped, &created); err != nil {
+            return nil, err
+        }
+        k.CreatedAt = fromNanos(created)
+        out = append(out, k)
+    }
+    return out, rows.Err()
+}
@@ -0,0 +1,152 @@
+package sqlstore
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Dialect selects SQL syntax and migrations; drivers are registered by the binary,
+// e.g. modernc.org/sqlite as "sqlite" and github.com/jackc/pgx/v5/stdlib as "pgx"
+type Dialect string
+
+const (
+    SQLite   Dialect = "sqlite"
+    Postgres Dialect = "postgres"
+)
+
+// Pool defaults suit a gateway doing a few lookups per connection attempt
+const (
+    DefaultMaxOpenConns    = 20
+    DefaultMaxIdleConns    = 10
+    DefaultConnMaxLifetime = 30 * time.Minute
+    DefaultConnMaxIdleTime = 5 * time.Minute
+)
+
+var ErrUnknownDialect = errors.New("unknown SQL dialect")
+
+// Options configures the connection pool; zero values take the defaults
+type Options struct {
+    Driver          string
+    MaxOpenConns    int
+    MaxIdleConns    int
+    ConnMaxLifetime time.Duration
+    ConnMaxIdleTime time.Duration
+}
+
+// DB is a pooled connection shared by every SQL-backed store
+type DB struct {
+    db      *sql.DB
+    dialect Dialect
+}
+
+// Open connects and pings the database. SQLite allows a single writer, so its pool is
+// capped at one open connection unless MaxOpenConns says otherwise
+func Open(ctx context.Context, dialect Dialect, dsn string, opts Options) (*DB, error) {
+    driver := opts.Driver
+    switch dialect {
+    case SQLite:
+        if driver == "" {
+            driver = "sqlite"
+        }
+        if opts.MaxOpenConns == 0 {
+            opts.MaxOpenConns = 1
+        }
+    case Postgres:
+        if driver == "" {
+            driver = "pgx"
+        }
+    default:
+        return nil, ErrUnknownDialect
+    }
+    if opts.MaxOpenConns == 0 {
+        opts.MaxOpenConns = DefaultMaxOpenConns
+    }
+    if opts.MaxIdleConns == 0 {
+        opts.MaxIdleConns = DefaultMaxIdleConns
+    }
+    if opts.ConnMaxLifetime == 0 {
+        opts.ConnMaxLifetime = DefaultConnMaxLifetime
+    }
+    if opts.ConnMaxIdleTime == 0 {
+        opts.ConnMaxIdleTime = DefaultConnMaxIdleTime
+    }
+
+    db, err := sql.Open(driver, dsn)
+    if err != nil {
+        return nil, err
+    }
+    db.SetMaxOpenConns(opts.MaxOpenConns)
+    db.SetMaxIdleConns(opts.MaxIdleConns)
+    db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+    db.SetConnMaxIdleTime(opts.ConnMaxIdleTime)
+    if err := db.PingContext(ctx); err != nil {
+        db.Close()
+        return nil, err
+    }
+    return &DB{db: db, dialect: dialect}, nil
+}
+
+// Wrap uses an existing pool, e.g. one shared with the application
+func Wrap(db *sql.DB, dialect Dialect) *DB {
+    return &DB{db: db, dialect: dialect}
+}
+
+// Dialect reports the database flavour
+func (d *DB) Dialect() Dialect {
+    return d.dialect
+}
+
+// Close closes the pool
+func (d *DB) Close() error {
+    return d.db.Close()
+}
+
+// rebind rewrites ? placeholders to $n for Postgres; queries here never contain a literal ?
+func (d *DB) rebind(query string) string {
+    if d.dialect != Postgres {
+        return query
+    }
+    var b strings.Builder
+    n := 0
+    for i := 0; i < len(query); i++ {
+        if query[i] == '?' {
+            n++
+            b.WriteByte('$')
+            b.WriteString(strconv.Itoa(n))
+            continue
+        }
+        b.WriteByte(query[i])
+    }
+    return b.String()
+}
+
+func (d *DB) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+    return d.db.ExecContext(ctx, d.rebind(query), args...)
+}
+
+func (d *DB) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+    return d.db.QueryContext(ctx, d.rebind(query), args...)
+}
+
+func (d *DB) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+    return d.db.QueryRowContext(ctx, d.rebind(query), args...)
+}
+
+// Times are stored as Unix nanoseconds so both dialects compare them as integers; zero stays zero
+func toNanos(t time.Time) int64 {
+    if t.IsZero() {
+        return 0
+    }
+    return t.UnixNano()
+}
+
+func fromNanos(n int64) time.Time {
+    if n == 0 {
+        return time.Time{}
+    }
+    return time.Unix(0, n)
+}
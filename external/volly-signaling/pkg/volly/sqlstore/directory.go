@@ -0,0 +1,230 @@
+package sqlstore
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/directory"
+)
+
+// DirectoryStore is a directory.Store
+type DirectoryStore struct {
+    db *DB
+}
+
+// NewDirectoryStore uses db, which must have been migrated
+func NewDirectoryStore(db *DB) *DirectoryStore {
+    return &DirectoryStore{db: db}
+}
+
+const userColumns = `tenant, id, user_name, external_id, display_name, active, source, created_at, updated_at`
+
+func (s *DirectoryStore) PutUser(ctx context.Context, u directory.User) error {
+    var other string
+    err := s.db.queryRow(ctx, `SELECT id FROM volly_directory_users WHERE tenant = ? AND user_name = ? AND id <> ?`,
+        u.Tenant, u.UserName, u.ID).Scan(&other)
+    if err == nil {
+        return directory.ErrUserNameTaken
+    }
+    if !errors.Is(err, sql.ErrNoRows) {
+        return err
+    }
+    // The unique index still refuses the loser of two racing provisioners
+    _, err = s.db.exec(ctx, `INSERT INTO volly_directory_users (`+userColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (tenant, id) DO UPDATE SET user_name = excluded.user_name, external_id = excluded.external_id,
+        display_name = excluded.display_name, active = excluded.active, source = excluded.source, updated_at = excluded.updated_at`,
+        u.Tenant, u.ID, u.UserName, u.ExternalID, u.DisplayName, u.Active, u.Source, toNanos(u.CreatedAt), toNanos(u.UpdatedAt))
+    return err
+}
+
+func (s *DirectoryStore) GetUser(ctx context.Context, tenant, id string) (directory.User, error) {
+    u, err := scanUser(s.db.queryRow(ctx, `SELECT `+userColumns+` FROM volly_directory_users WHERE tenant = ? AND id = ?`, tenant, id))
+    if errors.Is(err, sql.ErrNoRows) {
+        return directory.User{}, directory.ErrUserNotFound
+    }
+    return u, err
+}
+
+func (s *DirectoryStore) FindUser(ctx context.Context, tenant, userName string) (directory.User, error) {
+    u, err := scanUser(s.db.queryRow(ctx, `SELECT `+userColumns+` FROM volly_directory_users WHERE tenant = ? AND user_name = ?`, tenant, userName))
+    if errors.Is(err, sql.ErrNoRows) {
+        return directory.User{}, directory.ErrUserNotFound
+    }
+    return u, err
+}
+
+func (s *DirectoryStore) ListUsers(ctx context.Context, tenant string) ([]directory.User, error) {
+    rows, err := s.db.query(ctx, `SELECT `+userColumns+` FROM volly_directory_users WHERE tenant = ? ORDER BY id`, tenant)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var out []directory.User
+    for rows.Next() {
+        u, err := scanUser(rows)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, u)
+    }
+    return out, rows.Err()
+}
+
+func (s *DirectoryStore) DeleteUser(ctx context.Context, tenant, id string) error {
+    return s.inTx(ctx, func(tx *sql.Tx) error {
+        res, err := tx.ExecContext(ctx, s.db.rebind(`DELETE FROM volly_directory_users WHERE tenant = ? AND id = ?`), tenant, id)
+        if err != nil {
+            return err
+        }
+        if n, err := res.RowsAffected(); err == nil && n == 0 {
+            return directory.ErrUserNotFound
+        }
+        _, err = tx.ExecContext(ctx, s.db.rebind(`DELETE FROM volly_directory_members WHERE tenant = ? AND user_id = ?`), tenant, id)
+        return err
+    })
+}
+
+func (s *DirectoryStore) PutGroup(ctx context.Context, g directory.Group) error {
+    return s.inTx(ctx, func(tx *sql.Tx) error {
+        if _, err := tx.ExecContext(ctx, s.db.rebind(`INSERT INTO volly_directory_groups
+            (tenant, id, display_name, external_id, source, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+            ON CONFLICT (tenant, id) DO UPDATE SET display_name = excluded.display_name, external_id = excluded.external_id,
+            source = excluded.source, updated_at = excluded.updated_at`),
+            g.Tenant, g.ID, g.DisplayName, g.ExternalID, g.Source, toNanos(g.CreatedAt), toNanos(g.UpdatedAt)); err != nil {
+            return err
+        }
+        if _, err := tx.ExecContext(ctx, s.db.rebind(`DELETE FROM volly_directory_members WHERE tenant = ? AND group_id = ?`), g.Tenant, g.ID); err != nil {
+            return err
+        }
+        // Only users of the tenant become members
+        for _, id := range g.Members {
+            if _, err := tx.ExecContext(ctx, s.db.rebind(`INSERT INTO volly_directory_members (tenant, group_id, user_id)
+                SELECT tenant, ?, id FROM volly_directory_users WHERE tenant = ? AND id = ?
+                ON CONFLICT (tenant, group_id, user_id) DO NOTHING`), g.ID, g.Tenant, id); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+func (s *DirectoryStore) GetGroup(ctx context.Context, tenant, id string) (directory.Group, error) {
+    g, err := scanGroup(s.db.queryRow(ctx, `SELECT tenant, id, display_name, external_id, source, created_at, updated_at
+        FROM volly_directory_groups WHERE tenant = ? AND id = ?`, tenant, id))
+    if errors.Is(err, sql.ErrNoRows) {
+        return directory.Group{}, directory.ErrGroupNotFound
+    }
+    if err != nil {
+        return directory.Group{}, err
+    }
+    members, err := s.members(ctx, `SELECT group_id, user_id FROM volly_directory_members WHERE tenant = ? AND group_id = ? ORDER BY user_id`, tenant, id)
+    if err != nil {
+        return directory.Group{}, err
+    }
+    g.Members = members[id]
+    return g, nil
+}
+
+func (s *DirectoryStore) ListGroups(ctx context.Context, tenant string) ([]directory.Group, error) {
+    groups, err := s.groups(ctx, `SELECT tenant, id, display_name, external_id, source, created_at, updated_at
+        FROM volly_directory_groups WHERE tenant = ? ORDER BY id`, tenant)
+    if err != nil {
+        return nil, err
+    }
+    members, err := s.members(ctx, `SELECT group_id, user_id FROM volly_directory_members WHERE tenant = ? ORDER BY user_id`, tenant)
+    if err != nil {
+        return nil, err
+    }
+    for i := range groups {
+        groups[i].Members = members[groups[i].ID]
+    }
+    return groups, nil
+}
+
+func (s *DirectoryStore) DeleteGroup(ctx context.Context, tenant, id string) error {
+    return s.inTx(ctx, func(tx *sql.Tx) error {
+        res, err := tx.ExecContext(ctx, s.db.rebind(`DELETE FROM volly_directory_groups WHERE tenant = ? AND id = ?`), tenant, id)
+        if err != nil {
+            return err
+        }
+        if n, err := res.RowsAffected(); err == nil && n == 0 {
+            return directory.ErrGroupNotFound
+        }
+        _, err = tx.ExecContext(ctx, s.db.rebind(`DELETE FROM volly_directory_members WHERE tenant = ? AND group_id = ?`), tenant, id)
+        return err
+    })
+}
+
+// UserGroups leaves out Members; it runs on every token issued in a managed tenant
+func (s *DirectoryStore) UserGroups(ctx context.Context, tenant, userID string) ([]directory.Group, error) {
+    return s.groups(ctx, `SELECT g.tenant, g.id, g.display_name, g.external_id, g.source, g.created_at, g.updated_at
+        FROM volly_directory_groups g JOIN volly_directory_members m ON m.tenant = g.tenant AND m.group_id = g.id
+        WHERE m.tenant = ? AND m.user_id = ? ORDER BY g.id`, tenant, userID)
+}
+
+func (s *DirectoryStore) groups(ctx context.Context, query string, args ...interface{}) ([]directory.Group, error) {
+    rows, err := s.db.query(ctx, query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var out []directory.Group
+    for rows.Next() {
+        g, err := scanGroup(rows)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, g)
+    }
+    return out, rows.Err()
+}
+
+func (s *DirectoryStore) members(ctx context.Context, query string, args ...interface{}) (map[string][]string, error) {
+    rows, err := s.db.query(ctx, query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    out := make(map[string][]string)
+    for rows.Next() {
+        var group, user string
+        if err := rows.Scan(&group, &user); err != nil {
+            return nil, err
+        }
+        out[group] = append(out[group], user)
+    }
+    return out, rows.Err()
+}
+
+func (s *DirectoryStore) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+    tx, err := s.db.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    if err := fn(tx); err != nil {
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit()
+}
+
+func scanUser(row scanner) (directory.User, error) {
+    var u directory.User
+    var created, updated int64
+    if err := row.Scan(&u.Tenant, &u.ID, &u.UserName, &u.ExternalID, &u.DisplayName, &u.Active, &u.Source, &created, &updated); err != nil {
+        return directory.User{}, err
+    }
+    u.CreatedAt, u.UpdatedAt = fromNanos(created), fromNanos(updated)
+    return u, nil
+}
+
+func scanGroup(row scanner) (directory.Group, error) {
+    var g directory.Group
+    var created, updated int64
+    if err := row.Scan(&g.Tenant, &g.ID, &g.DisplayName, &g.ExternalID, &g.Source, &created, &updated); err != nil {
+        return directory.Group{}, err
+    }
+    g.CreatedAt, g.UpdatedAt = fromNanos(created), fromNanos(updated)
+    return g, nil
+}
@@ -0,0 +1,252 @@
+package sqlstore
+
+import (
+    "bytes"
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "sync"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/envelope"
+    "github.com/volly-org/volly-signaling/pkg/volly/keys"
+    "github.com/volly-org/volly-signaling/pkg/volly/listing"
+    "github.com/volly-org/volly-signaling/pkg/volly/secure"
+)
+
+var ErrPrivateKeyNotStored = errors.New("SQL key registry stores private keys only with an envelope sealer")
+
+// KeyRegistry is a keys.Registry for published public keys. Server-held private keys are
+// refused unless an envelope sealer is set, and then stored sealed and bound to their identity
+type KeyRegistry struct {
+    db     *DB
+    clock  clock.Clock
+    sealer *envelope.Sealer
+
+    // Unsealed private keys are owned by the registry, as in keys.MemoryRegistry
+    mu       sync.Mutex
+    unsealed map[string]unsealedSecret
+}
+
+// NewKeyRegistry uses db, which must have been migrated
+func NewKeyRegistry(db *DB) *KeyRegistry {
+    return &KeyRegistry{db: db, clock: clock.System, unsealed: make(map[string]unsealedSecret)}
+}
+
+// SetSealer lets the registry store server-held private keys, sealed under sealer
+func (r *KeyRegistry) SetSealer(sealer *envelope.Sealer) *KeyRegistry {
+    r.sealer = sealer
+    return r
+}
+
+// SetClock sets the time source for creation stamps and expiry checks
+func (r *KeyRegistry) SetClock(c clock.Clock) *KeyRegistry {
+    r.clock = c
+    return r
+}
+
+// Put registers a key, taking ownership of its PrivateKey
+func (r *KeyRegistry) Put(ctx context.Context, record keys.KeyRecord) error {
+    if record.Identity == "" || len(record.PublicKey) == 0 {
+        return errors.New("identity and public key are required")
+    }
+    var sealed []byte
+    if record.PrivateKey != nil {
+        if r.sealer == nil {
+            return ErrPrivateKeyNotStored
+        }
+        err := record.PrivateKey.Use(func(value []byte) error {
+            var err error
+            sealed, err = r.sealer.Seal([]byte(record.Identity), value)
+            return err
+        })
+        if err != nil {
+            return err
+        }
+    }
+    if record.CreatedAt.IsZero() {
+        record.CreatedAt = r.clock.Now()
+    }
+    _, err := r.db.exec(ctx, `INSERT INTO volly_pq_keys (identity, algorithm, public_key, created_at, expires_at, sealed_private_key)
+        VALUES (?, ?, ?, ?, ?, ?)
+        ON CONFLICT (identity) DO UPDATE SET algorithm = excluded.algorithm, public_key = excluded.public_key,
+        created_at = excluded.created_at, expires_at = excluded.expires_at, sealed_private_key = excluded.sealed_private_key`,
+        record.Identity, record.Algorithm, record.PublicKey, toNanos(record.CreatedAt), toNanos(record.ExpiresAt), sealed)
+    if err != nil {
+        return err
+    }
+    r.forget(record.Identity)
+    if record.PrivateKey != nil {
+        r.mu.Lock()
+        r.unsealed[record.Identity] = unsealedSecret{sealed: sealed, secret: record.PrivateKey}
+        r.mu.Unlock()
+    }
+    return nil
+}
+
+// privateKey returns the cached private key for a row, unsealing it when the row changed
+func (r *KeyRegistry) privateKey(identity string, sealed []byte) (*secure.SecureBytes, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    cached, ok := r.unsealed[identity]
+    if ok && bytes.Equal(cached.sealed, sealed) {
+        return cached.secret, nil
+    }
+    if r.sealer == nil {
+        return nil, ErrPrivateKeyNotStored
+    }
+    value, err := r.sealer.Open([]byte(identity), sealed)
+    if err != nil {
+        return nil, err
+    }
+    secret, err := secure.FromBytes(value)
+    if err != nil {
+        return nil, err
+    }
+    // Readers may still hold the previous key; it is released once they drop it
+    r.unsealed[identity] = unsealedSecret{sealed: sealed, secret: secret}
+    return secret, nil
+}
+
+// forget drops the cached private key for identity, which is zeroized once no reader holds it
+func (r *KeyRegistry) forget(identity string) {
+    r.mu.Lock()
+    delete(r.unsealed, identity)
+    r.mu.Unlock()
+}
+
+// Reseal seals again, under the current data key, every private key under an older one
+func (r *KeyRegistry) Reseal(ctx context.Context, sealer *envelope.Sealer) (int, error) {
+    rows, err := r.db.query(ctx, `SELECT identity, sealed_private_key FROM volly_pq_keys WHERE sealed_private_key IS NOT NULL`)
+    if err != nil {
+        return 0, err
+    }
+    type row struct {
+        identity string
+        sealed   []byte
+    }
+    var stale []row
+    for rows.Next() {
+        var rw row
+        if err := rows.Scan(&rw.identity, &rw.sealed); err != nil {
+            rows.Close()
+            return 0, err
+        }
+        if len(rw.sealed) > 0 && sealer.Stale(rw.sealed) {
+            stale = append(stale, rw)
+        }
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return 0, err
+    }
+
+    n := 0
+    for _, rw := range stale {
+        value, err := sealer.Open([]byte(rw.identity), rw.sealed)
+        if err != nil {
+            return n, fmt.Errorf("private key of %s: %w", rw.identity, err)
+        }
+        resealed, err := sealer.Seal([]byte(rw.identity), value)
+        secure.Wipe(value)
+        if err != nil {
+            return n, err
+        }
+        res, err := r.db.exec(ctx, `UPDATE volly_pq_keys SET sealed_private_key = ? WHERE identity = ? AND sealed_private_key = ?`,
+            resealed, rw.identity, rw.sealed)
+        if err != nil {
+            return n, err
+        }
+        if changed, err := res.RowsAffected(); err == nil && changed == 0 {
+            continue
+        }
+        r.mu.Lock()
+        if cached, ok := r.unsealed[rw.identity]; ok && bytes.Equal(cached.sealed, rw.sealed) {
+            r.unsealed[rw.identity] = unsealedSecret{sealed: resealed, secret: cached.secret}
+        }
+        r.mu.Unlock()
+        n++
+    }
+    return n, nil
+}
+
+func (r *KeyRegistry) Get(ctx context.Context, identity string) (keys.KeyRecord, error) {
+    record := keys.KeyRecord{Identity: identity}
+    var created, expires int64
+    var sealed []byte
+    err := r.db.queryRow(ctx, `SELECT algorithm, public_key, created_at, expires_at, sealed_private_key FROM volly_pq_keys WHERE identity = ?`, identity).
+        Scan(&record.Algorithm, &record.PublicKey, &created, &expires, &sealed)
+    if errors.Is(err, sql.ErrNoRows) {
+        return keys.KeyRecord{}, keys.ErrKeyNotFound
+    }
+    if err != nil {
+        return keys.KeyRecord{}, err
+    }
+    record.CreatedAt, record.ExpiresAt = fromNanos(created), fromNanos(expires)
+    if record.Expired(r.clock.Now()) {
+        return keys.KeyRecord{}, keys.ErrKeyExpired
+    }
+    if len(sealed) > 0 {
+        if record.PrivateKey, err = r.privateKey(identity, sealed); err != nil {
+            return keys.KeyRecord{}, err
+        }
+    }
+    return record, nil
+}
+
+func (r *KeyRegistry) Delete(ctx context.Context, identity string) error {
+    res, err := r.db.exec(ctx, `DELETE FROM volly_pq_keys WHERE identity = ?`, identity)
+    if err != nil {
+        return err
+    }
+    if n, err := res.RowsAffected(); err == nil && n == 0 {
+        return keys.ErrKeyNotFound
+    }
+    r.forget(identity)
+    return nil
+}
+
+// List pages through registered keys like keys.MemoryRegistry.List; filtering happens in SQL,
+// ordering and cursors in listing.Paginate
+func (r *KeyRegistry) List(ctx context.Context, q listing.Query) ([]keys.KeyRecord, string, error) {
+    rows, err := r.db.query(ctx, `SELECT identity, algorithm, public_key, created_at, expires_at FROM volly_pq_keys
+        WHERE (? = '' OR identity = ?) AND (? = '' OR algorithm = ?)`,
+        q.Filter.Identity, q.Filter.Identity, q.Filter.Algorithm, q.Filter.Algorithm)
+    if err != nil {
+        return nil, "", err
+    }
+    defer rows.Close()
+
+    var records []keys.KeyRecord
+    var items []listing.Item
+    for rows.Next() {
+        var record keys.KeyRecord
+        var created, expires int64
+        if err := rows.Scan(&record.Identity, &record.Algorithm, &record.PublicKey, &created, &expires); err != nil {
+            return nil, "", err
+        }
+        record.CreatedAt, record.ExpiresAt = fromNanos(created), fromNanos(expires)
+        records = append(records, record)
+        items = append(items, listing.Item{ID: record.Identity, Keys: map[string]string{
+            "identity":  record.Identity,
+            "algorithm": record.Algorithm,
+            "createdAt": listing.TimeKey(record.CreatedAt),
+            "expiresAt": listing.TimeKey(record.ExpiresAt),
+        }})
+    }
+    if err := rows.Err(); err != nil {
+        return nil, "", err
+    }
+
+    page, next, err := listing.Paginate(items, "identity", q)
+    if err != nil {
+        return nil, "", err
+    }
+    out := make([]keys.KeyRecord, len(page))
+    for i, idx := range page {
+        out[i] = records[idx]
+    }
+    return out, next, nil
+}
@@ -0,0 +1,150 @@
+package sqlstore
+
+import (
+    "context"
+    "embed"
+    "errors"
+    "path"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Migrations use goose's file format and version table, so `goose -dir
+// pkg/volly/sqlstore/migrations/postgres` can inspect or roll back what Migrate applied
+//
+//go:embed migrations
+var migrations embed.FS
+
+const versionTable = "goose_db_version"
+
+var ErrBadMigration = errors.New("malformed migration file")
+
+type migration struct {
+    version int64
+    up      string
+}
+
+// Migrate applies pending migrations, each in its own transaction
+func (d *DB) Migrate(ctx context.Context) error {
+    pending, err := loadMigrations(d.dialect)
+    if err != nil {
+        return err
+    }
+
+    idColumn := "id INTEGER PRIMARY KEY AUTOINCREMENT"
+    if d.dialect == Postgres {
+        idColumn = "id SERIAL PRIMARY KEY"
+    }
+    if _, err := d.exec(ctx, "CREATE TABLE IF NOT EXISTS "+versionTable+" ("+idColumn+
+        ", version_id BIGINT NOT NULL, is_applied BOOLEAN NOT NULL, tstamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP)"); err != nil {
+        return err
+    }
+
+    var current int64
+    row := d.queryRow(ctx, "SELECT COALESCE(MAX(version_id), 0) FROM "+versionTable+" WHERE is_applied = ?", true)
+    if err := row.Scan(&current); err != nil {
+        return err
+    }
+
+    for _, m := range pending {
+        if m.version <= current {
+            continue
+        }
+        tx, err := d.db.BeginTx(ctx, nil)
+        if err != nil {
+            return err
+        }
+        for _, stmt := range splitStatements(m.up) {
+            if _, err := tx.ExecContext(ctx, stmt); err != nil {
+                tx.Rollback()
+                return &MigrationError{Version: m.version, Err: err}
+            }
+        }
+        if _, err := tx.ExecContext(ctx, d.rebind("INSERT INTO "+versionTable+" (version_id, is_applied, tstamp) VALUES (?, ?, ?)"),
+            m.version, true, time.Now().UTC()); err != nil {
+            tx.Rollback()
+            return err
+        }
+        if err := tx.Commit(); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// MigrationError names the migration that failed
+type MigrationError struct {
+    Version int64
+    Err     error
+}
+
+func (e *MigrationError) Error() string {
+    return "migration " + strconv.FormatInt(e.Version, 10) + " failed: " + e.Err.Error()
+}
+
+func (e *MigrationError) Unwrap() error { return e.Err }
+
+// loadMigrations reads NNNNN_name.sql files and keeps their -- +goose Up section
+func loadMigrations(dialect Dialect) ([]migration, error) {
+    dir := path.Join("migrations", string(dialect))
+    files, err := migrations.ReadDir(dir)
+    if err != nil {
+        return nil, ErrUnknownDialect
+    }
+    var out []migration
+    for _, f := range files {
+        name := f.Name()
+        prefix, _, ok := strings.Cut(name, "_")
+        if !ok || !strings.HasSuffix(name, ".sql") {
+            return nil, ErrBadMigration
+        }
+        version, err := strconv.ParseInt(prefix, 10, 64)
+        if err != nil {
+            return nil, ErrBadMigration
+        }
+        data, err := migrations.ReadFile(path.Join(dir, name))
+        if err != nil {
+            return nil, err
+        }
+        up, ok := upSection(string(data))
+        if !ok {
+            return nil, ErrBadMigration
+        }
+        out = append(out, migration{version: version, up: up})
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].version < out[j].version })
+    return out, nil
+}
+
+func upSection(src string) (string, bool) {
+    _, rest, ok := strings.Cut(src, "-- +goose Up")
+    if !ok {
+        return "", false
+    }
+    up, _, _ := strings.Cut(rest, "-- +goose Down")
+    return up, true
+}
+
+// splitStatements splits on semicolons; the migrations contain no procedural bodies
+func splitStatements(src string) []string {
+    var out []string
+    for _, stmt := range strings.Split(src, ";") {
+        if s := strings.TrimSpace(stripComments(stmt)); s != "" {
+            out = append(out, s)
+        }
+    }
+    return out
+}
+
+func stripComments(stmt string) string {
+    lines := strings.Split(stmt, "\n")
+    kept := lines[:0]
+    for _, line := range lines {
+        if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+            kept = append(kept, line)
+        }
+    }
+    return strings.Join(kept, "\n")
+}
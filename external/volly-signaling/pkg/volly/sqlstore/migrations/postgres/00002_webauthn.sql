@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE volly_webauthn_credentials (
+    tenant       TEXT NOT NULL DEFAULT '',
+    id           TEXT NOT NULL,
+    identity     TEXT NOT NULL,
+    public_key   BYTEA NOT NULL,
+    algorithm    INTEGER NOT NULL,
+    sign_count   BIGINT NOT NULL DEFAULT 0,
+    created_at   BIGINT NOT NULL,
+    last_used_at BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (tenant, id)
+);
+CREATE INDEX volly_webauthn_credentials_identity ON volly_webauthn_credentials (tenant, identity);
+
+-- +goose Down
+DROP TABLE volly_webauthn_credentials;
@@ -0,0 +1,36 @@
+-- +goose Up
+CREATE TABLE volly_directory_users (
+    tenant       TEXT NOT NULL,
+    id           TEXT NOT NULL,
+    user_name    TEXT NOT NULL,
+    external_id  TEXT NOT NULL DEFAULT '',
+    display_name TEXT NOT NULL DEFAULT '',
+    active       BOOLEAN NOT NULL,
+    source       TEXT NOT NULL,
+    created_at   BIGINT NOT NULL,
+    updated_at   BIGINT NOT NULL,
+    PRIMARY KEY (tenant, id)
+);
+CREATE UNIQUE INDEX volly_directory_users_user_name ON volly_directory_users (tenant, user_name);
+CREATE TABLE volly_directory_groups (
+    tenant       TEXT NOT NULL,
+    id           TEXT NOT NULL,
+    display_name TEXT NOT NULL,
+    external_id  TEXT NOT NULL DEFAULT '',
+    source       TEXT NOT NULL,
+    created_at   BIGINT NOT NULL,
+    updated_at   BIGINT NOT NULL,
+    PRIMARY KEY (tenant, id)
+);
+CREATE TABLE volly_directory_members (
+    tenant   TEXT NOT NULL,
+    group_id TEXT NOT NULL,
+    user_id  TEXT NOT NULL,
+    PRIMARY KEY (tenant, group_id, user_id)
+);
+CREATE INDEX volly_directory_members_user ON volly_directory_members (tenant, user_id);
+
+-- +goose Down
+DROP TABLE volly_directory_members;
+DROP TABLE volly_directory_groups;
+DROP TABLE volly_directory_users;
@@ -0,0 +1,5 @@
+-- +goose Up
+CREATE INDEX volly_audit_log_at ON volly_audit_log (at);
+
+-- +goose Down
+DROP INDEX volly_audit_log_at;
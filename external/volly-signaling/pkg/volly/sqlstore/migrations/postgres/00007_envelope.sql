@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE volly_data_keys (
+    id         TEXT PRIMARY KEY,
+    kek_id     TEXT NOT NULL,
+    wrapped    BYTEA NOT NULL,
+    created_at BIGINT NOT NULL
+);
+ALTER TABLE volly_pq_keys ADD COLUMN sealed_private_key BYTEA;
+
+-- +goose Down
+ALTER TABLE volly_pq_keys DROP COLUMN sealed_private_key;
+DROP TABLE volly_data_keys;
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE volly_revoked_tokens ADD COLUMN decoy BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE volly_revoked_tokens DROP COLUMN decoy;
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE volly_room_policies ADD COLUMN providers TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE volly_room_policies DROP COLUMN providers;
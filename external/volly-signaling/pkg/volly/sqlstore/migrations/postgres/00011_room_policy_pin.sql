@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE volly_room_policies ADD COLUMN pin_hash TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE volly_room_policies DROP COLUMN pin_hash;
@@ -0,0 +1,68 @@
+-- +goose Up
+CREATE TABLE volly_revocation_cutoffs (
+    tenant   TEXT NOT NULL DEFAULT '',
+    room     TEXT NOT NULL DEFAULT '',
+    identity TEXT NOT NULL DEFAULT '',
+    cutoff   BIGINT NOT NULL,
+    PRIMARY KEY (tenant, room, identity)
+);
+
+CREATE TABLE volly_revoked_tokens (
+    jti        TEXT PRIMARY KEY,
+    expires_at BIGINT NOT NULL
+);
+CREATE INDEX volly_revoked_tokens_expiry ON volly_revoked_tokens (expires_at);
+
+CREATE TABLE volly_pq_keys (
+    identity   TEXT PRIMARY KEY,
+    algorithm  TEXT NOT NULL,
+    public_key BLOB NOT NULL,
+    created_at BIGINT NOT NULL,
+    expires_at BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE volly_tenants (
+    id           TEXT PRIMARY KEY,
+    display_name TEXT NOT NULL DEFAULT '',
+    max_rooms    INTEGER NOT NULL DEFAULT 0,
+    suspended    BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE volly_api_keys (
+    id            TEXT PRIMARY KEY,
+    tenant        TEXT NOT NULL,
+    scopes        TEXT NOT NULL DEFAULT '',
+    sealed_secret BLOB,
+    not_after     BIGINT NOT NULL DEFAULT 0
+);
+CREATE INDEX volly_api_keys_tenant ON volly_api_keys (tenant);
+
+CREATE TABLE volly_room_policies (
+    tenant            TEXT NOT NULL,
+    name              TEXT NOT NULL,
+    room              TEXT NOT NULL,
+    max_participants  INTEGER NOT NULL DEFAULT 0,
+    require_pq        BOOLEAN NOT NULL DEFAULT FALSE,
+    recording_allowed BOOLEAN NOT NULL DEFAULT FALSE,
+    PRIMARY KEY (tenant, name)
+);
+
+CREATE TABLE volly_audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    at     BIGINT NOT NULL,
+    actor  TEXT NOT NULL,
+    action TEXT NOT NULL,
+    tenant TEXT NOT NULL DEFAULT '',
+    target TEXT NOT NULL DEFAULT '',
+    detail TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX volly_audit_log_tenant_at ON volly_audit_log (tenant, at);
+
+-- +goose Down
+DROP TABLE volly_audit_log;
+DROP TABLE volly_room_policies;
+DROP TABLE volly_api_keys;
+DROP TABLE volly_tenants;
+DROP TABLE volly_pq_keys;
+DROP TABLE volly_revoked_tokens;
+DROP TABLE volly_revocation_cutoffs;
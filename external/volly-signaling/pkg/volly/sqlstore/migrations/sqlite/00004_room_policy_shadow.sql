@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE volly_room_policies ADD COLUMN shadow BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE volly_room_policies DROP COLUMN shadow;
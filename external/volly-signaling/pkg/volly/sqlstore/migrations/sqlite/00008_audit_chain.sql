@@ -0,0 +1,19 @@
+-- +goose Up
+ALTER TABLE volly_audit_log ADD COLUMN seq BIGINT;
+ALTER TABLE volly_audit_log ADD COLUMN prev BLOB;
+ALTER TABLE volly_audit_log ADD COLUMN hash BLOB;
+CREATE UNIQUE INDEX volly_audit_log_seq ON volly_audit_log (seq);
+CREATE TABLE volly_audit_anchors (
+    seq       BIGINT PRIMARY KEY,
+    head      BLOB NOT NULL,
+    signed_at BIGINT NOT NULL,
+    algorithm TEXT NOT NULL,
+    signature BLOB NOT NULL
+);
+
+-- +goose Down
+DROP TABLE volly_audit_anchors;
+DROP INDEX volly_audit_log_seq;
+ALTER TABLE volly_audit_log DROP COLUMN hash;
+ALTER TABLE volly_audit_log DROP COLUMN prev;
+ALTER TABLE volly_audit_log DROP COLUMN seq;
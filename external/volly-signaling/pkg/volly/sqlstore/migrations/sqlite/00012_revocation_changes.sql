@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE volly_revocation_changes (
+    seq        BIGINT PRIMARY KEY,
+    tenant     TEXT NOT NULL DEFAULT '',
+    room       TEXT NOT NULL DEFAULT '',
+    identity   TEXT NOT NULL DEFAULT '',
+    cutoff     BIGINT NOT NULL DEFAULT 0,
+    jti        TEXT NOT NULL DEFAULT '',
+    expires_at BIGINT NOT NULL DEFAULT 0,
+    created_at BIGINT NOT NULL
+);
+CREATE INDEX volly_revocation_changes_created ON volly_revocation_changes (created_at);
+
+-- +goose Down
+DROP TABLE volly_revocation_changes;
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE volly_audit_log ADD COLUMN salts TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE volly_audit_log DROP COLUMN salts;
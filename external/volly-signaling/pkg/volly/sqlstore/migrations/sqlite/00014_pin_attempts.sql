@@ -0,0 +1,10 @@
+-- +goose Up
+CREATE TABLE volly_pin_attempts (
+    scope        TEXT PRIMARY KEY,
+    first_at     BIGINT NOT NULL DEFAULT 0,
+    attempts     INTEGER NOT NULL DEFAULT 0,
+    locked_until BIGINT NOT NULL DEFAULT 0
+);
+
+-- +goose Down
+DROP TABLE volly_pin_attempts;
@@ -0,0 +1,59 @@
+package sqlstore
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "time"
+)
+
+// PINAttemptStore is a roompin.AttemptStore shared by every instance using the database
+type PINAttemptStore struct {
+    db *DB
+}
+
+// NewPINAttemptStore uses db, which must have been migrated
+func NewPINAttemptStore(db *DB) *PINAttemptStore {
+    return &PINAttemptStore{db: db}
+}
+
+func (s *PINAttemptStore) Fail(ctx context.Context, scope string, now time.Time, window time.Duration) (int, error) {
+    start := toNanos(now.Add(-window))
+    if _, err := s.db.exec(ctx, `DELETE FROM volly_pin_attempts WHERE first_at < ? AND locked_until < ?`, start, toNanos(now)); err != nil {
+        return 0, err
+    }
+    // One statement counts the attempt, so concurrent guesses on other instances all land
+    var n int
+    err := s.db.queryRow(ctx, `INSERT INTO volly_pin_attempts (scope, first_at, attempts) VALUES (?, ?, 1)
+        ON CONFLICT (scope) DO UPDATE SET
+            attempts = CASE WHEN volly_pin_attempts.first_at < ? THEN 1 ELSE volly_pin_attempts.attempts + 1 END,
+            first_at = CASE WHEN volly_pin_attempts.first_at < ? THEN excluded.first_at ELSE volly_pin_attempts.first_at END
+        RETURNING attempts`, scope, toNanos(now), start, start).Scan(&n)
+    return n, err
+}
+
+func (s *PINAttemptStore) Lock(ctx context.Context, scope string, until time.Time) error {
+    _, err := s.db.exec(ctx, `INSERT INTO volly_pin_attempts (scope, locked_until) VALUES (?, ?)
+        ON CONFLICT (scope) DO UPDATE SET first_at = 0, attempts = 0,
+            locked_until = CASE WHEN excluded.locked_until > volly_pin_attempts.locked_until
+                THEN excluded.locked_until ELSE volly_pin_attempts.locked_until END`,
+        scope, toNanos(until))
+    return err
+}
+
+func (s *PINAttemptStore) LockedUntil(ctx context.Context, scope string, now time.Time) (time.Time, error) {
+    var until int64
+    err := s.db.queryRow(ctx, `SELECT locked_until FROM volly_pin_attempts WHERE scope = ?`, scope).Scan(&until)
+    if errors.Is(err, sql.ErrNoRows) || (err == nil && until <= toNanos(now)) {
+        return time.Time{}, nil
+    }
+    if err != nil {
+        return time.Time{}, err
+    }
+    return fromNanos(until), nil
+}
+
+func (s *PINAttemptStore) Reset(ctx context.Context, scope string) error {
+    _, err := s.db.exec(ctx, `DELETE FROM volly_pin_attempts WHERE scope = ?`, scope)
+    return err
+}
@@ -0,0 +1,244 @@
+package sqlstore
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/revocation"
+)
+
+// RevocationStore is a revocation.Store; empty predicate fields are stored as empty-string
+// wildcards. Every write is also numbered in a change log, making it a revocation.ChangeFeed
+// for the filters of the instances sharing the database
+type RevocationStore struct {
+    db    *DB
+    clock clock.Clock
+}
+
+// NewRevocationStore uses db, which must have been migrated
+func NewRevocationStore(db *DB) *RevocationStore {
+    return &RevocationStore{db: db, clock: clock.System}
+}
+
+// SetClock sets the time source used to prune expired token revocations
+func (s *RevocationStore) SetClock(c clock.Clock) *RevocationStore {
+    s.clock = c
+    return s
+}
+
+// RevokeWhere keeps the latest cutoff per predicate
+func (s *RevocationStore) RevokeWhere(ctx context.Context, p revocation.Predicate, cutoff time.Time) error {
+    _, err := s.db.exec(ctx, `INSERT INTO volly_revocation_cutoffs (tenant, room, identity, cutoff) VALUES (?, ?, ?, ?)
+        ON CONFLICT (tenant, room, identity) DO UPDATE SET cutoff = excluded.cutoff
+        WHERE excluded.cutoff > volly_revocation_cutoffs.cutoff`,
+        p.Tenant, p.Room, p.Identity, cutoff.Unix())
+    if err != nil {
+        return err
+    }
+    return s.logChange(ctx, revocation.Cutoff{Predicate: p, Cutoff: cutoff}, revocation.RevokedToken{})
+}
+
+// Prune deletes, or with dryRun counts, the cutoffs from before before and the token
+// revocations that expired before it. Changes logged before it go too, but for the latest,
+// which keeps the sequence
+func (s *RevocationStore) Prune(ctx context.Context, before time.Time, dryRun bool) (int, error) {
+    if dryRun {
+        var cutoffs, tokens int
+        if err := s.db.queryRow(ctx, `SELECT COUNT(*) FROM volly_revocation_cutoffs WHERE cutoff < ?`, before.Unix()).Scan(&cutoffs); err != nil {
+            return 0, err
+        }
+        err := s.db.queryRow(ctx, `SELECT COUNT(*) FROM volly_revoked_tokens WHERE expires_at < ?`, toNanos(before)).Scan(&tokens)
+        return cutoffs + tokens, err
+    }
+    n := 0
+    for _, q := range []struct {
+        query string
+        arg   int64
+    }{
+        {`DELETE FROM volly_revocation_cutoffs WHERE cutoff < ?`, before.Unix()},
+        {`DELETE FROM volly_revoked_tokens WHERE expires_at < ?`, toNanos(before)},
+    } {
+        res, err := s.db.exec(ctx, q.query, q.arg)
+        if err != nil {
+            return n, err
+        }
+        affected, err := res.RowsAffected()
+        if err != nil {
+            return n, err
+        }
+        n += int(affected)
+    }
+    _, err := s.db.exec(ctx, `DELETE FROM volly_revocation_changes WHERE created_at < ?
+        AND seq < (SELECT MAX(seq) FROM volly_revocation_changes)`, toNanos(before))
+    return n, err
+}
+
+// RevokeToken records a jti until it would have expired anyway
+func (s *RevocationStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+    if _, err := s.db.exec(ctx, `DELETE FROM volly_revoked_tokens WHERE expires_at < ?`, toNanos(s.clock.Now())); err != nil {
+        return err
+    }
+    _, err := s.db.exec(ctx, `INSERT INTO volly_revoked_tokens (jti, expires_at) VALUES (?, ?)
+        ON CONFLICT (jti) DO UPDATE SET expires_at = excluded.expires_at`,
+        jti, toNanos(expiresAt))
+    if err != nil {
+        return err
+    }
+    return s.logChange(ctx, revocation.Cutoff{}, revocation.RevokedToken{JTI: jti, ExpiresAt: expiresAt})
+}
+
+// IsRevoked checks the token's IDs and every cutoff whose predicate matches it
+func (s *RevocationStore) IsRevoked(ctx context.Context, t revocation.Token) (bool, error) {
+    for _, id := range t.IDs {
+        var n int
+        err := s.db.queryRow(ctx, `SELECT COUNT(*) FROM volly_revoked_tokens WHERE jti = ?`, id).Scan(&n)
+        if err != nil {
+            return false, err
+        }
+        if n > 0 {
+            return true, nil
+        }
+    }
+
+    var n int
+    err := s.db.queryRow(ctx, `SELECT COUNT(*) FROM volly_revocation_cutoffs
+        WHERE (tenant = '' OR tenant = ?) AND (room = '' OR room = ?) AND (identity = '' OR identity = ?)
+        AND cutoff >= ?`,
+        t.Tenant, t.Room, t.Identity, t.IssuedAt.Unix()).Scan(&n)
+    if err != nil {
+        return false, err
+    }
+    return n > 0, nil
+}
+
+// MarkDecoy revokes jti and flags it as a decoy
+func (s *RevocationStore) MarkDecoy(ctx context.Context, jti string, expiresAt time.Time) error {
+    if _, err := s.db.exec(ctx, `DELETE FROM volly_revoked_tokens WHERE expires_at < ?`, toNanos(s.clock.Now())); err != nil {
+        return err
+    }
+    _, err := s.db.exec(ctx, `INSERT INTO volly_revoked_tokens (jti, expires_at, decoy) VALUES (?, ?, ?)
+        ON CONFLICT (jti) DO UPDATE SET expires_at = excluded.expires_at, decoy = excluded.decoy`,
+        jti, toNanos(expiresAt), true)
+    if err != nil {
+        return err
+    }
+    return s.logChange(ctx, revocation.Cutoff{}, revocation.RevokedToken{JTI: jti, ExpiresAt: expiresAt})
+}
+
+// IsDecoy reports whether jti was flagged with MarkDecoy and has not expired
+func (s *RevocationStore) IsDecoy(ctx context.Context, jti string) (bool, error) {
+    var n int
+    err := s.db.queryRow(ctx, `SELECT COUNT(*) FROM volly_revoked_tokens WHERE jti = ? AND decoy AND expires_at >= ?`,
+        jti, toNanos(s.clock.Now())).Scan(&n)
+    return n > 0, err
+}
+
+// ListRevocations returns every cutoff and every unexpired token revocation
+func (s *RevocationStore) ListRevocations(ctx context.Context) ([]revocation.Cutoff, []revocation.RevokedToken, error) {
+    rows, err := s.db.query(ctx, `SELECT tenant, room, identity, cutoff FROM volly_revocation_cutoffs`)
+    if err != nil {
+        return nil, nil, err
+    }
+    defer rows.Close()
+    var cutoffs []revocation.Cutoff
+    for rows.Next() {
+        var c revocation.Cutoff
+        var cutoff int64
+        if err := rows.Scan(&c.Predicate.Tenant, &c.Predicate.Room, &c.Predicate.Identity, &cutoff); err != nil {
+            return nil, nil, err
+        }
+        c.Cutoff = time.Unix(cutoff, 0)
+        cutoffs = append(cutoffs, c)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, nil, err
+    }
+
+    tokenRows, err := s.db.query(ctx, `SELECT jti, expires_at FROM volly_revoked_tokens WHERE expires_at >= ?`, toNanos(s.clock.Now()))
+    if err != nil {
+        return nil, nil, err
+    }
+    defer tokenRows.Close()
+    var tokens []revocation.RevokedToken
+    for tokenRows.Next() {
+        var t revocation.RevokedToken
+        var exp int64
+        if err := tokenRows.Scan(&t.JTI, &exp); err != nil {
+            return nil, nil, err
+        }
+        t.ExpiresAt = fromNanos(exp)
+        tokens = append(tokens, t)
+    }
+    return cutoffs, tokens, tokenRows.Err()
+}
+
+// logChange numbers a write after the latest one. Instances sharing the database race for
+// the next number; the loser reads the new latest and tries again, so numbers have no gaps
+// and a reader that has seen one has seen every write before it
+func (s *RevocationStore) logChange(ctx context.Context, c revocation.Cutoff, t revocation.RevokedToken) error {
+    var cutoff int64
+    if !c.Cutoff.IsZero() {
+        cutoff = c.Cutoff.Unix()
+    }
+    for attempt := 0; attempt < logChangeAttempts; attempt++ {
+        seq, err := s.Sequence(ctx)
+        if err != nil {
+            return err
+        }
+        res, err := s.db.exec(ctx, `INSERT INTO volly_revocation_changes (seq, tenant, room, identity, cutoff, jti, expires_at, created_at)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (seq) DO NOTHING`,
+            int64(seq+1), c.Predicate.Tenant, c.Predicate.Room, c.Predicate.Identity, cutoff, t.JTI, toNanos(t.ExpiresAt), toNanos(s.clock.Now()))
+        if err != nil {
+            return err
+        }
+        if n, err := res.RowsAffected(); err != nil || n == 1 {
+            return err
+        }
+    }
+    return errors.New("revocation change log: lost the race for the next sequence number too many times")
+}
+
+// logChangeAttempts bounds logChange's retries when other instances keep taking the next number
+const logChangeAttempts = 10
+
+// Sequence returns the number of the latest logged write
+func (s *RevocationStore) Sequence(ctx context.Context) (uint64, error) {
+    var seq int64
+    err := s.db.queryRow(ctx, `SELECT COALESCE(MAX(seq), 0) FROM volly_revocation_changes`).Scan(&seq)
+    return uint64(seq), err
+}
+
+// Changes returns the writes logged after since, or revocation.ErrDeltaUnavailable once
+// Prune has dropped some of them
+func (s *RevocationStore) Changes(ctx context.Context, since uint64) ([]revocation.Cutoff, []revocation.RevokedToken, uint64, error) {
+    rows, err := s.db.query(ctx, `SELECT seq, tenant, room, identity, cutoff, jti, expires_at FROM volly_revocation_changes
+        WHERE seq > ? ORDER BY seq`, int64(since))
+    if err != nil {
+        return nil, nil, 0, err
+    }
+    defer rows.Close()
+    var cutoffs []revocation.Cutoff
+    var tokens []revocation.RevokedToken
+    to := since
+    for rows.Next() {
+        var seq, cutoff, exp int64
+        var c revocation.Cutoff
+        var jti string
+        if err := rows.Scan(&seq, &c.Predicate.Tenant, &c.Predicate.Room, &c.Predicate.Identity, &cutoff, &jti, &exp); err != nil {
+            return nil, nil, 0, err
+        }
+        if uint64(seq) != to+1 {
+            return nil, nil, 0, revocation.ErrDeltaUnavailable
+        }
+        to = uint64(seq)
+        if jti != "" {
+            tokens = append(tokens, revocation.RevokedToken{JTI: jti, ExpiresAt: fromNanos(exp)})
+            continue
+        }
+        c.Cutoff = time.Unix(cutoff, 0)
+        cutoffs = append(cutoffs, c)
+    }
+    return cutoffs, tokens, to, rows.Err()
+}
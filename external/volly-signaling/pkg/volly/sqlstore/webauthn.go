@@ -0,0 +1,107 @@
+package sqlstore
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/webauthn"
+)
+
+// WebAuthnStore is a webauthn.Store
+type WebAuthnStore struct {
+    db *DB
+}
+
+// NewWebAuthnStore uses db, which must have been migrated
+func NewWebAuthnStore(db *DB) *WebAuthnStore {
+    return &WebAuthnStore{db: db}
+}
+
+func (s *WebAuthnStore) Put(ctx context.Context, c webauthn.Credential) error {
+    // Inserted only if absent, so two registrations racing for one ID cannot both win
+    res, err := s.db.exec(ctx, `INSERT INTO volly_webauthn_credentials
+        (tenant, id, identity, public_key, algorithm, sign_count, created_at, last_used_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (tenant, id) DO NOTHING`,
+        c.Tenant, c.ID, c.Identity, c.PublicKey, c.Algorithm, int64(c.SignCount), toNanos(c.CreatedAt), toNanos(c.LastUsedAt))
+    if err != nil {
+        return err
+    }
+    if n, err := res.RowsAffected(); err == nil && n == 0 {
+        return webauthn.ErrCredentialExists
+    }
+    return nil
+}
+
+func (s *WebAuthnStore) Get(ctx context.Context, tenant, id string) (webauthn.Credential, error) {
+    c, err := scanCredential(s.db.queryRow(ctx, `SELECT tenant, id, identity, public_key, algorithm, sign_count, created_at, last_used_at
+        FROM volly_webauthn_credentials WHERE tenant = ? AND id = ?`, tenant, id))
+    if errors.Is(err, sql.ErrNoRows) {
+        return webauthn.Credential{}, webauthn.ErrCredentialNotFound
+    }
+    return c, err
+}
+
+func (s *WebAuthnStore) List(ctx context.Context, tenant, identity string) ([]webauthn.Credential, error) {
+    rows, err := s.db.query(ctx, `SELECT tenant, id, identity, public_key, algorithm, sign_count, created_at, last_used_at
+        FROM volly_webauthn_credentials WHERE tenant = ? AND identity = ? ORDER BY created_at`, tenant, identity)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var out []webauthn.Credential
+    for rows.Next() {
+        c, err := scanCredential(rows)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, c)
+    }
+    return out, rows.Err()
+}
+
+// UpdateSignCount compares and swaps the counter in one statement, so of concurrent assertions
+// on any instance only one moves it from a given value
+func (s *WebAuthnStore) UpdateSignCount(ctx context.Context, tenant, id string, from, to uint32, usedAt time.Time) error {
+    res, err := s.db.exec(ctx, `UPDATE volly_webauthn_credentials SET sign_count = ?, last_used_at = ?
+        WHERE tenant = ? AND id = ? AND sign_count = ?`,
+        int64(to), toNanos(usedAt), tenant, id, int64(from))
+    if err != nil {
+        return err
+    }
+    n, err := res.RowsAffected()
+    if err != nil || n > 0 {
+        return err
+    }
+    if _, err := s.Get(ctx, tenant, id); err != nil {
+        return err
+    }
+    return webauthn.ErrSignCountChanged
+}
+
+func (s *WebAuthnStore) Delete(ctx context.Context, tenant, id string) error {
+    res, err := s.db.exec(ctx, `DELETE FROM volly_webauthn_credentials WHERE tenant = ? AND id = ?`, tenant, id)
+    if err != nil {
+        return err
+    }
+    if n, err := res.RowsAffected(); err == nil && n == 0 {
+        return webauthn.ErrCredentialNotFound
+    }
+    return nil
+}
+
+type scanner interface {
+    Scan(dest ...interface{}) error
+}
+
+func scanCredential(row scanner) (webauthn.Credential, error) {
+    var c webauthn.Credential
+    var count, created, used int64
+    if err := row.Scan(&c.Tenant, &c.ID, &c.Identity, &c.PublicKey, &c.Algorithm, &count, &created, &used); err != nil {
+        return webauthn.Credential{}, err
+    }
+    c.SignCount = uint32(count)
+    c.CreatedAt, c.LastUsedAt = fromNanos(created), fromNanos(used)
+    return c, nil
+}
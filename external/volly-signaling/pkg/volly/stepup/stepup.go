@@ -0,0 +1,329 @@
+// Package stepup makes sensitive room actions, such as starting a recording or removing a
+// participant, wait for fresh proof that the person holding a session token is who it names.
+// The gateway answers such an action with a challenge; the client proves itself with one of
+// the configured methods, e.g. a WebAuthn assertion, and gets a receipt the action carries
+// until it expires
+package stepup
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+)
+
+// Sensitive actions guarded by default
+const (
+    ActionStartRecording    = "start_recording"
+    ActionRemoveParticipant = "remove_participant"
+)
+
+// ActionJoin is joining a room, guarded only when Require is asked to, e.g. for a session
+// anomaly detection has flagged
+const ActionJoin = "join"
+
+const (
+    DefaultChallengeTTL = 2 * time.Minute
+    // DefaultReceiptTTL is how long one proof covers repeats of the same action
+    DefaultReceiptTTL = 5 * time.Minute
+)
+
+// maxSpent bounds the single-use challenge cache
+const maxSpent = 100000
+
+var (
+    ErrStepUpRequired   = errors.New("action requires step-up authentication")
+    ErrInvalidChallenge = errors.New("step-up challenge is invalid, expired or already used")
+    ErrUnknownMethod    = errors.New("unknown step-up method")
+    ErrProofFailed      = errors.New("step-up proof was rejected")
+)
+
+// Subject is the session asking to act
+type Subject struct {
+    Tenant   string `json:"tenant"`
+    Identity string `json:"identity"`
+    Room     string `json:"room"`
+    // TokenID is the session token's jti; challenges and receipts are bound to it
+    TokenID string `json:"tokenId"`
+}
+
+// Challenge is what a client must answer before the action is honoured
+type Challenge struct {
+    // ID is sent back with the proof
+    ID     string `json:"id"`
+    Action string `json:"action"`
+    // Nonce is what the proof must cover, base64url encoded; a WebAuthn assertion uses its
+    // decoded bytes as the challenge, an IdP redirect as the nonce
+    Nonce     string    `json:"nonce"`
+    Methods   []string  `json:"methods"`
+    ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// RequiredError is ErrStepUpRequired with the challenge that satisfies it
+type RequiredError struct {
+    Challenge *Challenge
+}
+
+func (e *RequiredError) Error() string {
+    return fmt.Sprintf("%s: %s", ErrStepUpRequired, e.Challenge.Action)
+}
+
+func (e *RequiredError) Unwrap() error { return ErrStepUpRequired }
+
+// Proof is a client's answer to a challenge
+type Proof struct {
+    Challenge string `json:"challenge"`
+    Method    string `json:"method"`
+    // Data is the method's own payload, e.g. an Assertion
+    Data json.RawMessage `json:"data"`
+}
+
+// Evidence is what a method established, kept for the audit log
+type Evidence struct {
+    Method string `json:"method"`
+    // Credential names what proved the subject, e.g. a WebAuthn credential ID or an IdP subject
+    Credential string `json:"credential"`
+    // Detail is method specific, e.g. the authenticator's counter and a digest of the proof
+    Detail map[string]string `json:"detail,omitempty"`
+}
+
+// Receipt lets the subject perform an action until it expires
+type Receipt struct {
+    Token     string    `json:"receipt"`
+    Action    string    `json:"action"`
+    ExpiresAt time.Time `json:"expiresAt"`
+    Evidence  Evidence  `json:"evidence"`
+}
+
+// Method checks one kind of proof. It returns ErrProofFailed, or an error wrapping it, when
+// the proof is wrong and any other error when it could not tell. WebAuthn is built in; an IdP
+// redirect plugs in as a Method whose data is the ID token the IdP returned, carrying the
+// challenge nonce as its nonce claim
+type Method interface {
+    Name() string
+    Verify(ctx context.Context, subject Subject, challenge Challenge, data json.RawMessage) (Evidence, error)
+}
+
+// Guard decides which actions need a step-up and checks the proofs. Challenges and receipts
+// are stateless, "kind.nonce.action.expiry.mac" with the mac also covering the subject, so
+// instances sharing a key accept each other's; only a challenge's single use is local
+type Guard struct {
+    key          []byte
+    methods      map[string]Method
+    actions      map[string]bool
+    challengeTTL time.Duration
+    receiptTTL   time.Duration
+    clock        clock.Clock
+
+    mu    sync.Mutex
+    spent map[string]time.Time
+}
+
+// NewGuard creates a guard for the default actions; key signs challenges and receipts
+func NewGuard(key []byte, methods ...Method) *Guard {
+    g := &Guard{
+        key:          key,
+        methods:      make(map[string]Method, len(methods)),
+        actions:      map[string]bool{ActionStartRecording: true, ActionRemoveParticipant: true},
+        challengeTTL: DefaultChallengeTTL,
+        receiptTTL:   DefaultReceiptTTL,
+        clock:        clock.System,
+        spent:        make(map[string]time.Time),
+    }
+    for _, m := range methods {
+        g.methods[m.Name()] = m
+    }
+    return g
+}
+
+// SetActions replaces the actions that need a step-up
+func (g *Guard) SetActions(actions ...string) *Guard {
+    g.actions = make(map[string]bool, len(actions))
+    for _, a := range actions {
+        g.actions[a] = true
+    }
+    return g
+}
+
+// SetChallengeTTL sets how long a challenge can be answered
+func (g *Guard) SetChallengeTTL(ttl time.Duration) *Guard {
+    g.challengeTTL = ttl
+    return g
+}
+
+// SetReceiptTTL sets how long a proof covers its action
+func (g *Guard) SetReceiptTTL(ttl time.Duration) *Guard {
+    g.receiptTTL = ttl
+    return g
+}
+
+// SetClock sets the time source for expiries
+func (g *Guard) SetClock(c clock.Clock) *Guard {
+    g.clock = c
+    return g
+}
+
+// Sensitive reports whether action needs a step-up
+func (g *Guard) Sensitive(action string) bool {
+    return g.actions[action]
+}
+
+// Methods lists the configured method names, sorted
+func (g *Guard) Methods() []string {
+    names := make([]string, 0, len(g.methods))
+    for name := range g.methods {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// Authorize is called before honouring action. It returns nil when the action is not
+// sensitive or receipt covers it, and a *RequiredError with a fresh challenge otherwise
+func (g *Guard) Authorize(subject Subject, action, receipt string) error {
+    if !g.Sensitive(action) {
+        return nil
+    }
+    return g.Require(subject, action, receipt)
+}
+
+// Require is Authorize for an action that needs a step-up this time whether or not it is
+// sensitive
+func (g *Guard) Require(subject Subject, action, receipt string) error {
+    if receipt != "" {
+        if got, _, err := g.open("r", receipt, subject); err == nil && got == action {
+            return nil
+        }
+    }
+    challenge, err := g.NewChallenge(subject, action)
+    if err != nil {
+        return err
+    }
+    return &RequiredError{Challenge: challenge}
+}
+
+// NewChallenge issues a challenge for subject to perform action
+func (g *Guard) NewChallenge(subject Subject, action string) (*Challenge, error) {
+    raw := make([]byte, 32)
+    if _, err := rand.Read(raw); err != nil {
+        return nil, err
+    }
+    nonce := base64.RawURLEncoding.EncodeToString(raw)
+    expires := g.clock.Now().Add(g.challengeTTL).Truncate(time.Second)
+    return &Challenge{
+        ID:        g.seal("c", nonce, action, expires, subject),
+        Action:    action,
+        Nonce:     nonce,
+        Methods:   g.Methods(),
+        ExpiresAt: expires,
+    }, nil
+}
+
+// Verify checks proof against the challenge it answers, once, and returns a receipt for the
+// challenge's action. A challenge is spent by its first answer, right or wrong
+func (g *Guard) Verify(ctx context.Context, subject Subject, proof Proof) (*Receipt, error) {
+    action, nonce, err := g.open("c", proof.Challenge, subject)
+    if err != nil {
+        return nil, err
+    }
+    m, ok := g.methods[proof.Method]
+    if !ok {
+        return nil, fmt.Errorf("%w: %q", ErrUnknownMethod, proof.Method)
+    }
+    if err := g.spend(proof.Challenge); err != nil {
+        return nil, err
+    }
+    challenge := Challenge{ID: proof.Challenge, Action: action, Nonce: nonce, Methods: g.Methods()}
+    evidence, err := m.Verify(ctx, subject, challenge, proof.Data)
+    if err != nil {
+        return nil, err
+    }
+    evidence.Method = m.Name()
+
+    raw := make([]byte, 16)
+    if _, err := rand.Read(raw); err != nil {
+        return nil, err
+    }
+    expires := g.clock.Now().Add(g.receiptTTL).Truncate(time.Second)
+    return &Receipt{
+        Token:     g.seal("r", base64.RawURLEncoding.EncodeToString(raw), action, expires, subject),
+        Action:    action,
+        ExpiresAt: expires,
+        Evidence:  evidence,
+    }, nil
+}
+
+// seal signs a challenge or receipt
+func (g *Guard) seal(kind, nonce, action string, expires time.Time, subject Subject) string {
+    body := kind + "." + nonce + "." + base64.RawURLEncoding.EncodeToString([]byte(action)) + "." +
+        strconv.FormatInt(expires.Unix(), 10)
+    return body + "." + g.mac(body, subject)
+}
+
+// open checks a sealed value of kind for subject and returns its action and nonce
+func (g *Guard) open(kind, sealed string, subject Subject) (action, nonce string, err error) {
+    parts := strings.Split(sealed, ".")
+    if len(parts) != 5 || parts[0] != kind {
+        return "", "", ErrInvalidChallenge
+    }
+    body := strings.Join(parts[:4], ".")
+    if !hmac.Equal([]byte(parts[4]), []byte(g.mac(body, subject))) {
+        return "", "", ErrInvalidChallenge
+    }
+    expiry, err := strconv.ParseInt(parts[3], 10, 64)
+    if err != nil || g.clock.Now().Unix() > expiry {
+        return "", "", ErrInvalidChallenge
+    }
+    a, err := base64.RawURLEncoding.DecodeString(parts[2])
+    if err != nil {
+        return "", "", ErrInvalidChallenge
+    }
+    return string(a), parts[1], nil
+}
+
+// spend marks a challenge used
+func (g *Guard) spend(challenge string) error {
+    now := g.clock.Now()
+    expiry, _ := strconv.ParseInt(strings.Split(challenge, ".")[3], 10, 64)
+
+    g.mu.Lock()
+    defer g.mu.Unlock()
+
+    if _, ok := g.spent[challenge]; ok {
+        return ErrInvalidChallenge
+    }
+    if len(g.spent) >= maxSpent {
+        for c, exp := range g.spent {
+            if now.After(exp) {
+                delete(g.spent, c)
+            }
+        }
+        if len(g.spent) >= maxSpent {
+            return ErrInvalidChallenge
+        }
+    }
+    g.spent[challenge] = time.Unix(expiry, 0)
+    return nil
+}
+
+// mac covers body and the subject it was issued to, so neither travels to another session
+func (g *Guard) mac(body string, subject Subject) string {
+    h := hmac.New(sha256.New, g.key)
+    h.Write([]byte(body))
+    for _, v := range []string{subject.Tenant, subject.Identity, subject.Room, subject.TokenID} {
+        h.Write([]byte{0})
+        h.Write([]byte(v))
+    }
+    return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
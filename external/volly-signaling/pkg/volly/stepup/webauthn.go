@@ -0,0 +1,58 @@
+package stepup
+
+import (
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strconv"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/webauthn"
+)
+
+// MethodWebAuthn is the built-in WebAuthn method's name
+const MethodWebAuthn = "webauthn"
+
+// WebAuthn proves the subject with an assertion, a webauthn.Assertion, over the challenge
+// nonce from one of the subject's registered credentials
+type WebAuthn struct {
+    rp *webauthn.RelyingParty
+}
+
+// NewWebAuthn creates the method for rp
+func NewWebAuthn(rp *webauthn.RelyingParty) *WebAuthn {
+    return &WebAuthn{rp: rp}
+}
+
+func (w *WebAuthn) Name() string { return MethodWebAuthn }
+
+func (w *WebAuthn) Verify(ctx context.Context, subject Subject, challenge Challenge, data json.RawMessage) (Evidence, error) {
+    var a webauthn.Assertion
+    if err := json.Unmarshal(data, &a); err != nil {
+        return Evidence{}, fmt.Errorf("%w: malformed assertion", ErrProofFailed)
+    }
+    nonce, err := base64.RawURLEncoding.DecodeString(challenge.Nonce)
+    if err != nil {
+        return Evidence{}, ErrInvalidChallenge
+    }
+    c, v, err := w.rp.VerifyAssertion(ctx, subject.Tenant, a, nonce)
+    if errors.Is(err, webauthn.ErrVerificationFailed) || errors.Is(err, webauthn.ErrCredentialNotFound) {
+        return Evidence{}, fmt.Errorf("%w: %v", ErrProofFailed, err)
+    }
+    if err != nil {
+        return Evidence{}, err
+    }
+    if c.Identity != subject.Identity {
+        return Evidence{}, fmt.Errorf("%w: credential belongs to another identity", ErrProofFailed)
+    }
+    return Evidence{
+        Credential: c.ID,
+        Detail: map[string]string{
+            "origin":       v.Origin,
+            "userVerified": strconv.FormatBool(v.UserVerified),
+            "signCount":    strconv.FormatUint(uint64(v.SignCount), 10),
+            "proof":        v.Digest,
+        },
+    }, nil
+}
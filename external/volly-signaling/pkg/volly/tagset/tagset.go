@@ -0,0 +1,118 @@
+// Package tagset handles cost-attribution tags: free-form pairs such as cost-center=eng or
+// project=atlas that tokend signs into a token and that follow it onto the sessions, usage
+// and audit entries it produces, so usage can be split between teams sharing a tenant
+package tagset
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// Limits on a token's tags; they keep tags small enough to ride on every token
+const (
+    MaxTags        = 8
+    MaxKeyLength   = 32
+    MaxValueLength = 64
+)
+
+var ErrInvalidTag = errors.New("invalid tag")
+
+// Validate checks that tags are within the limits. Keys are lower-case letters, digits and
+// '.', '-' or '_', starting with a letter or digit; values are printable and may not hold
+// ',' or '=', which Encode separates pairs with
+func Validate(tags map[string]string) error {
+    if len(tags) > MaxTags {
+        return fmt.Errorf("%w: %d tags, at most %d", ErrInvalidTag, len(tags), MaxTags)
+    }
+    for k, v := range tags {
+        if !validKey(k) {
+            return fmt.Errorf("%w: key %q", ErrInvalidTag, k)
+        }
+        if !validValue(v) {
+            return fmt.Errorf("%w: %s value %q", ErrInvalidTag, k, v)
+        }
+    }
+    return nil
+}
+
+func validKey(k string) bool {
+    if k == "" || len(k) > MaxKeyLength {
+        return false
+    }
+    for i := 0; i < len(k); i++ {
+        c := k[i]
+        switch {
+        case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+        case (c == '.' || c == '-' || c == '_') && i > 0:
+        default:
+            return false
+        }
+    }
+    return true
+}
+
+func validValue(v string) bool {
+    if v == "" || len(v) > MaxValueLength {
+        return false
+    }
+    for _, c := range v {
+        if c < ' ' || c == 0x7f || c == ',' || c == '=' {
+            return false
+        }
+    }
+    return true
+}
+
+// Match reports whether tags carry every pair in filter; an empty filter matches everything
+func Match(tags, filter map[string]string) bool {
+    for k, v := range filter {
+        if tags[k] != v {
+            return false
+        }
+    }
+    return true
+}
+
+// Encode returns tags as k=v pairs joined by ',' in key order, "" for none. Valid tags
+// encode to one string each, so the encoding can key a map or be stored in a column
+func Encode(tags map[string]string) string {
+    keys := make([]string, 0, len(tags))
+    for k := range tags {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    var b strings.Builder
+    for i, k := range keys {
+        if i > 0 {
+            b.WriteByte(',')
+        }
+        b.WriteString(k)
+        b.WriteByte('=')
+        b.WriteString(tags[k])
+    }
+    return b.String()
+}
+
+// Parse reads pairs in Encode's form, e.g. from a filter query parameter; "" is no tags
+func Parse(s string) (map[string]string, error) {
+    if s == "" {
+        return nil, nil
+    }
+    tags := make(map[string]string)
+    for _, pair := range strings.Split(s, ",") {
+        k, v, ok := strings.Cut(pair, "=")
+        if !ok {
+            return nil, fmt.Errorf("%w: %q is not key=value", ErrInvalidTag, pair)
+        }
+        if _, dup := tags[k]; dup {
+            return nil, fmt.Errorf("%w: %s given twice", ErrInvalidTag, k)
+        }
+        tags[k] = v
+    }
+    if err := Validate(tags); err != nil {
+        return nil, err
+    }
+    return tags, nil
+}
@@ -0,0 +1,171 @@
+package transparency
+
+import (
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/crypto"
+)
+
+// treeHeadContext domain-separates tree head signatures
+const treeHeadContext = "volly-kt-sth-v1"
+
+var ErrBindingNotFound = errors.New("no key binding for identity")
+
+// KeyBinding is one published identity to PQ key association
+type KeyBinding struct {
+    Identity  string `json:"identity"`
+    Algorithm string `json:"algorithm"`
+    PublicKey []byte `json:"publicKey"`
+    Timestamp int64  `json:"timestamp"`
+}
+
+// Encode returns the canonical leaf encoding of the binding
+func (b *KeyBinding) Encode() []byte {
+    // Struct field order makes the JSON encoding deterministic
+    data, _ := json.Marshal(b)
+    return data
+}
+
+// SignedTreeHead commits the log operator to a tree size and root
+type SignedTreeHead struct {
+    TreeSize  uint64 `json:"treeSize"`
+    RootHash  []byte `json:"rootHash"`
+    Timestamp int64  `json:"timestamp"`
+    Algorithm string `json:"algorithm"`
+    Signature []byte `json:"signature"`
+}
+
+// signedMessage returns the bytes covered by the tree head signature
+func (h *SignedTreeHead) signedMessage() []byte {
+    msg := make([]byte, 0, len(treeHeadContext)+16+len(h.RootHash))
+    msg = append(msg, treeHeadContext...)
+    msg = binary.BigEndian.AppendUint64(msg, h.TreeSize)
+    msg = binary.BigEndian.AppendUint64(msg, uint64(h.Timestamp))
+    return append(msg, h.RootHash...)
+}
+
+// Verify checks the tree head signature against the log's public key
+func (h *SignedTreeHead) Verify(publicKey []byte) error {
+    return crypto.VerifySignature(h.Algorithm, publicKey, h.signedMessage(), h.Signature)
+}
+
+// InclusionProof shows that a binding is in the tree described by a tree head
+type InclusionProof struct {
+    Index    uint64   `json:"index"`
+    TreeSize uint64   `json:"treeSize"`
+    Path     [][]byte `json:"path"`
+}
+
+// KeyLog is an append-only Merkle log of identity to PQ key bindings
+type KeyLog struct {
+    signer crypto.Signer
+
+    mu      sync.RWMutex
+    entries []KeyBinding
+    leaves  [][]byte
+    latest  map[string]uint64
+}
+
+// NewKeyLog creates an empty log signing tree heads with signer
+func NewKeyLog(signer crypto.Signer) *KeyLog {
+    return &KeyLog{
+        signer: signer,
+        latest: make(map[string]uint64),
+    }
+}
+
+// Append publishes a binding and returns its leaf index
+func (l *KeyLog) Append(binding KeyBinding) (uint64, error) {
+    if binding.Identity == "" || len(binding.PublicKey) == 0 {
+        return 0, errors.New("identity and public key are required")
+    }
+    if binding.Timestamp == 0 {
+        binding.Timestamp = time.Now().Unix()
+    }
+
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    index := uint64(len(l.entries))
+    l.entries = append(l.entries, binding)
+    l.leaves = append(l.leaves, LeafHash(binding.Encode()))
+    l.latest[binding.Identity] = index
+    return index, nil
+}
+
+// Lookup returns the most recent binding for an identity with its leaf index
+func (l *KeyLog) Lookup(identity string) (KeyBinding, uint64, error) {
+    l.mu.RLock()
+    defer l.mu.RUnlock()
+
+    index, ok := l.latest[identity]
+    if !ok {
+        return KeyBinding{}, 0, ErrBindingNotFound
+    }
+    return l.entries[index], index, nil
+}
+
+// TreeHead signs the current tree size and root
+func (l *KeyLog) TreeHead() (*SignedTreeHead, error) {
+    l.mu.RLock()
+    head := &SignedTreeHead{
+        TreeSize:  uint64(len(l.leaves)),
+        RootHash:  rootHash(l.leaves),
+        Timestamp: time.Now().Unix(),
+        Algorithm: l.signer.Algorithm(),
+    }
+    l.mu.RUnlock()
+
+    sig, err := l.signer.Sign(head.signedMessage())
+    if err != nil {
+        return nil, err
+    }
+    head.Signature = sig
+    return head, nil
+}
+
+// InclusionProof proves the entry at index is part of the tree of treeSize
+func (l *KeyLog) InclusionProof(index, treeSize uint64) (*InclusionProof, error) {
+    l.mu.RLock()
+    defer l.mu.RUnlock()
+
+    if treeSize > uint64(len(l.leaves)) || index >= treeSize {
+        return nil, ErrInvalidRange
+    }
+    return &InclusionProof{
+        Index:    index,
+        TreeSize: treeSize,
+        Path:     inclusionPath(int(index), l.leaves[:treeSize]),
+    }, nil
+}
+
+// ConsistencyProof proves the tree of firstSize is a prefix of the tree of secondSize
+func (l *KeyLog) ConsistencyProof(firstSize, secondSize uint64) ([][]byte, error) {
+    l.mu.RLock()
+    defer l.mu.RUnlock()
+
+    if firstSize > secondSize || secondSize > uint64(len(l.leaves)) {
+        return nil, ErrInvalidRange
+    }
+    if firstSize == 0 {
+        return nil, nil
+    }
+    return consistencyPath(int(firstSize), l.leaves[:secondSize], true), nil
+}
+
+// Entries returns bindings in [start, end) so monitors can audit every published key
+func (l *KeyLog) Entries(start, end uint64) ([]KeyBinding, error) {
+    l.mu.RLock()
+    defer l.mu.RUnlock()
+
+    if start > end || end > uint64(len(l.entries)) {
+        return nil, ErrInvalidRange
+    }
+    out := make([]KeyBinding, end-start)
+    copy(out, l.entries[start:end])
+    return out, nil
+}
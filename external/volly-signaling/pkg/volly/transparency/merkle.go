@@ -0,0 +1,163 @@
+package transparency
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "errors"
+)
+
+// Merkle tree hashing and proofs follow RFC 9162 (Certificate Transparency v2)
+
+var (
+    ErrInvalidProof = errors.New("invalid merkle proof")
+    ErrInvalidRange = errors.New("tree size or index out of range")
+)
+
+// LeafHash hashes a log entry with the leaf domain separator
+func LeafHash(data []byte) []byte {
+    h := sha256.New()
+    h.Write([]byte{0x00})
+    h.Write(data)
+    return h.Sum(nil)
+}
+
+func nodeHash(left, right []byte) []byte {
+    h := sha256.New()
+    h.Write([]byte{0x01})
+    h.Write(left)
+    h.Write(right)
+    return h.Sum(nil)
+}
+
+// rootHash computes MTH over leaf hashes
+func rootHash(leaves [][]byte) []byte {
+    switch len(leaves) {
+    case 0:
+        sum := sha256.Sum256(nil)
+        return sum[:]
+    case 1:
+        return leaves[0]
+    }
+    k := splitPoint(len(leaves))
+    return nodeHash(rootHash(leaves[:k]), rootHash(leaves[k:]))
+}
+
+// inclusionPath computes PATH(m, D[n])
+func inclusionPath(m int, leaves [][]byte) [][]byte {
+    if len(leaves) <= 1 {
+        return nil
+    }
+    k := splitPoint(len(leaves))
+    if m < k {
+        return append(inclusionPath(m, leaves[:k]), rootHash(leaves[k:]))
+    }
+    return append(inclusionPath(m-k, leaves[k:]), rootHash(leaves[:k]))
+}
+
+// consistencyPath computes SUBPROOF(m, D[n], complete)
+func consistencyPath(m int, leaves [][]byte, complete bool) [][]byte {
+    n := len(leaves)
+    if m == n {
+        if complete {
+            return nil
+        }
+        return [][]byte{rootHash(leaves)}
+    }
+    k := splitPoint(n)
+    if m <= k {
+        return append(consistencyPath(m, leaves[:k], complete), rootHash(leaves[k:]))
+    }
+    return append(consistencyPath(m-k, leaves[k:], false), rootHash(leaves[:k]))
+}
+
+// splitPoint returns the largest power of two smaller than n
+func splitPoint(n int) int {
+    k := 1
+    for k<<1 < n {
+        k <<= 1
+    }
+    return k
+}
+
+// VerifyInclusion checks that leafHash is at index in the tree with the given size and root
+func VerifyInclusion(leafHash []byte, index, treeSize uint64, path [][]byte, root []byte) error {
+    if index >= treeSize {
+        return ErrInvalidRange
+    }
+
+    fn, sn := index, treeSize-1
+    r := leafHash
+    for _, p := range path {
+        if sn == 0 {
+            return ErrInvalidProof
+        }
+        if fn&1 == 1 || fn == sn {
+            r = nodeHash(p, r)
+            for fn&1 == 0 && fn != 0 {
+                fn >>= 1
+                sn >>= 1
+            }
+        } else {
+            r = nodeHash(r, p)
+        }
+        fn >>= 1
+        sn >>= 1
+    }
+
+    if sn != 0 || !bytes.Equal(r, root) {
+        return ErrInvalidProof
+    }
+    return nil
+}
+
+// VerifyConsistency checks that the tree of firstSize is a prefix of the tree of secondSize
+func VerifyConsistency(firstSize, secondSize uint64, firstRoot, secondRoot []byte, path [][]byte) error {
+    switch {
+    case firstSize > secondSize:
+        return ErrInvalidRange
+    case firstSize == secondSize:
+        if len(path) != 0 || !bytes.Equal(firstRoot, secondRoot) {
+            return ErrInvalidProof
+        }
+        return nil
+    case firstSize == 0:
+        // The empty tree is a prefix of every tree
+        return nil
+    case len(path) == 0:
+        return ErrInvalidProof
+    }
+
+    if firstSize&(firstSize-1) == 0 {
+        path = append([][]byte{firstRoot}, path...)
+    }
+
+    fn, sn := firstSize-1, secondSize-1
+    for fn&1 == 1 {
+        fn >>= 1
+        sn >>= 1
+    }
+
+    fr, sr := path[0], path[0]
+    for _, c := range path[1:] {
+        if sn == 0 {
+            return ErrInvalidProof
+        }
+        if fn&1 == 1 || fn == sn {
+            fr = nodeHash(c, fr)
+            sr = nodeHash(c, sr)
+            for fn&1 == 0 && fn != 0 {
+                fn >>= 1
+                sn >>= 1
+            }
+        } else {
+            sr = nodeHash(sr, c)
+        }
+        fn >>= 1
+        sn >>= 1
+    }
+
+    if sn != 0 || !bytes.Equal(fr, firstRoot) || !bytes.Equal(sr, secondRoot) {
+        return ErrInvalidProof
+    }
+    return nil
+}
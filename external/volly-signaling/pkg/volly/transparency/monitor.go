@@ -0,0 +1,101 @@
+package transparency
+
+import (
+    "bytes"
+    "errors"
+    "sync"
+)
+
+var (
+    ErrTreeShrunk    = errors.New("tree head is smaller than the last trusted head")
+    ErrStaleTreeHead = errors.New("proof does not match the trusted tree head")
+)
+
+// Monitor is the client-side view of the log: it only trusts tree heads that extend the last one it saw
+type Monitor struct {
+    logPublicKey []byte
+
+    mu      sync.Mutex
+    trusted *SignedTreeHead
+    watched map[string][][]byte
+}
+
+// NewMonitor creates a monitor pinned to the log operator's public key
+func NewMonitor(logPublicKey []byte) *Monitor {
+    return &Monitor{
+        logPublicKey: logPublicKey,
+        watched:      make(map[string][][]byte),
+    }
+}
+
+// TrustedHead returns the last verified tree head, if any
+func (m *Monitor) TrustedHead() *SignedTreeHead {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return m.trusted
+}
+
+// Update verifies a new tree head and its consistency with the trusted one before adopting it
+func (m *Monitor) Update(head *SignedTreeHead, consistency [][]byte) error {
+    if err := head.Verify(m.logPublicKey); err != nil {
+        return err
+    }
+
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    if prev := m.trusted; prev != nil {
+        if head.TreeSize < prev.TreeSize {
+            return ErrTreeShrunk
+        }
+        if err := VerifyConsistency(prev.TreeSize, head.TreeSize, prev.RootHash, head.RootHash, consistency); err != nil {
+            return err
+        }
+    }
+    m.trusted = head
+    return nil
+}
+
+// VerifyBinding checks that a key served for an identity is included in the trusted tree
+func (m *Monitor) VerifyBinding(binding KeyBinding, proof *InclusionProof) error {
+    m.mu.Lock()
+    head := m.trusted
+    m.mu.Unlock()
+
+    if head == nil || proof.TreeSize != head.TreeSize {
+        return ErrStaleTreeHead
+    }
+    return VerifyInclusion(LeafHash(binding.Encode()), proof.Index, proof.TreeSize, proof.Path, head.RootHash)
+}
+
+// Watch registers the keys an identity owner expects to see published
+func (m *Monitor) Watch(identity string, expectedKeys ...[]byte) {
+    m.mu.Lock()
+    m.watched[identity] = append(m.watched[identity], expectedKeys...)
+    m.mu.Unlock()
+}
+
+// Audit returns bindings for watched identities whose key was not expected
+func (m *Monitor) Audit(entries []KeyBinding) []KeyBinding {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    var unexpected []KeyBinding
+    for _, entry := range entries {
+        expected, ok := m.watched[entry.Identity]
+        if !ok {
+            continue
+        }
+        known := false
+        for _, key := range expected {
+            if bytes.Equal(key, entry.PublicKey) {
+                known = true
+                break
+            }
+        }
+        if !known {
+            unexpected = append(unexpected, entry)
+        }
+    }
+    return unexpected
+}
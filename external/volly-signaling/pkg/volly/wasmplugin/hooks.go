@@ -0,0 +1,83 @@
+package wasmplugin
+
+import (
+    "context"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/gateway"
+)
+
+// AdmitInput is what volly_admit is given
+type AdmitInput struct {
+    Tenant   string                 `json:"tenant,omitempty"`
+    Room     string                 `json:"room"`
+    Identity string                 `json:"identity"`
+    RemoteIP string                 `json:"remoteIP,omitempty"`
+    Claims   map[string]interface{} `json:"claims,omitempty"`
+    Tags     map[string]string      `json:"tags,omitempty"`
+    // CanPublish and RoomAdmin summarise the grant's permissions
+    CanPublish bool `json:"canPublish"`
+    RoomAdmin  bool `json:"roomAdmin"`
+}
+
+// AdmitOutput is what volly_admit answers; without an answer the connection is admitted
+type AdmitOutput struct {
+    Allow  bool   `json:"allow"`
+    Reason string `json:"reason,omitempty"`
+}
+
+// TransformInput is what volly_transform is given
+type TransformInput struct {
+    Tenant   string                 `json:"tenant,omitempty"`
+    Room     string                 `json:"room"`
+    Identity string                 `json:"identity"`
+    Claims   map[string]interface{} `json:"claims,omitempty"`
+}
+
+// TransformOutput replaces the grant's tenant claims; without an answer they are kept
+type TransformOutput struct {
+    Claims map[string]interface{} `json:"claims"`
+}
+
+// Admit runs volly_admit on the connection; plugins that do not export it admit everyone
+func (p *Plugin) Admit(ctx context.Context, a *gateway.Admission) error {
+    if !p.admit {
+        return nil
+    }
+    in := AdmitInput{Room: a.Room, Identity: a.Identity}
+    if a.RemoteIP.IsValid() {
+        in.RemoteIP = a.RemoteIP.String()
+    }
+    if g := a.Grant; g != nil {
+        in.Tenant, in.Claims, in.Tags = g.Tenant, g.Claims, g.Tags
+        in.CanPublish, in.RoomAdmin = g.GetCanPublish(), g.RoomAdmin
+    }
+    var out AdmitOutput
+    answered, err := p.call(ctx, exportAdmit, in, &out)
+    if err != nil || !answered || out.Allow {
+        return err
+    }
+    return &RefusedError{Plugin: p.name, Reason: out.Reason}
+}
+
+// TransformContext runs volly_transform on the grant; plugins that do not export it change
+// nothing
+func (p *Plugin) TransformContext(ctx context.Context, grant *auth.VollyVideoGrant) error {
+    if !p.transform {
+        return nil
+    }
+    in := TransformInput{Tenant: grant.Tenant, Room: grant.Room, Identity: grant.Identity, Claims: grant.Claims}
+    var out TransformOutput
+    answered, err := p.call(ctx, exportTransform, in, &out)
+    if err != nil || !answered {
+        return err
+    }
+    grant.Claims = out.Claims
+    return nil
+}
+
+// Transform is TransformContext bounded by the plugin's own timeout alone, which makes the
+// plugin an auth.ClaimTransformer
+func (p *Plugin) Transform(grant *auth.VollyVideoGrant) error {
+    return p.TransformContext(context.Background(), grant)
+}
@@ -0,0 +1,140 @@
+package wasmplugin
+
+import (
+    "context"
+    "errors"
+    "os"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/gateway"
+)
+
+// DefaultReloadInterval is how often a plugin file is checked for a new version
+const DefaultReloadInterval = 5 * time.Second
+
+// File is a plugin loaded from a file on disk. Run swaps in a new version whenever the file
+// changes; calls already running finish on the old one, and a version that fails to load
+// leaves the running one in place
+type File struct {
+    name     string
+    path     string
+    opts     Options
+    interval time.Duration
+    failOpen bool
+    report   func(ctx context.Context, name string, err error)
+
+    current atomic.Pointer[Plugin]
+
+    mu      sync.Mutex
+    modTime time.Time
+}
+
+// OpenFile loads the plugin at path; it fails when the first version does not load
+func OpenFile(ctx context.Context, name, path string, opts Options) (*File, error) {
+    f := &File{name: name, path: path, opts: opts, interval: DefaultReloadInterval}
+    if err := f.Reload(ctx); err != nil {
+        return nil, err
+    }
+    return f, nil
+}
+
+// SetInterval sets how often the file is checked for changes
+func (f *File) SetInterval(d time.Duration) *File {
+    f.interval = d
+    return f
+}
+
+// SetFailOpen admits, or keeps the claims unchanged, when the plugin traps or overruns
+// instead of failing the call. It does not override a refusal
+func (f *File) SetFailOpen(failOpen bool) *File {
+    f.failOpen = failOpen
+    return f
+}
+
+// SetReport sets the function told of failed calls and reloads
+func (f *File) SetReport(report func(ctx context.Context, name string, err error)) *File {
+    f.report = report
+    return f
+}
+
+// Reload loads the file again if it changed since the last load
+func (f *File) Reload(ctx context.Context) error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    info, err := os.Stat(f.path)
+    if err != nil {
+        return err
+    }
+    if f.current.Load() != nil && !info.ModTime().After(f.modTime) {
+        return nil
+    }
+    wasm, err := os.ReadFile(f.path)
+    if err != nil {
+        return err
+    }
+    p, err := Load(ctx, f.name, wasm, f.opts)
+    if err != nil {
+        return err
+    }
+    f.modTime = info.ModTime()
+    if old := f.current.Swap(p); old != nil {
+        // Close waits for the old version's calls in flight
+        go old.Close(context.WithoutCancel(ctx))
+    }
+    return nil
+}
+
+// Run reloads the file as it changes until ctx is cancelled
+func (f *File) Run(ctx context.Context) {
+    ticker := time.NewTicker(f.interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            if err := f.Reload(ctx); err != nil && f.report != nil {
+                f.report(ctx, f.name, err)
+            }
+        }
+    }
+}
+
+// Admit runs the current version's admission hook
+func (f *File) Admit(ctx context.Context, a *gateway.Admission) error {
+    return f.do(ctx, func(p *Plugin) error {
+        return p.Admit(ctx, a)
+    })
+}
+
+// Transform runs the current version's claim transformer
+func (f *File) Transform(grant *auth.VollyVideoGrant) error {
+    ctx := context.Background()
+    return f.do(ctx, func(p *Plugin) error {
+        return p.TransformContext(ctx, grant)
+    })
+}
+
+// do calls fn on the current version, again on its successor when a reload closed it
+// under the call, and applies the failure policy
+func (f *File) do(ctx context.Context, fn func(p *Plugin) error) error {
+    err := fn(f.current.Load())
+    if errors.Is(err, ErrClosed) {
+        err = fn(f.current.Load())
+    }
+    if err == nil || errors.Is(err, ErrRefused) {
+        return err
+    }
+    if f.report != nil {
+        f.report(ctx, f.name, err)
+    }
+    if f.failOpen {
+        return nil
+    }
+    return err
+}
@@ -0,0 +1,235 @@
+// Package wasmplugin runs operator-supplied WebAssembly modules as admission hooks and claim
+// transformers, so policy can change without rebuilding or restarting the gateway. Modules
+// run under wazero with WASI but no filesystem or network, bounded memory and a deadline per
+// call, and every call gets a fresh instance so nothing leaks between participants.
+//
+// Under ABI version 1 a module exports its memory, volly_abi_version() returning 1 and
+// volly_alloc(size) returning size bytes the host may write to. It exports volly_admit and
+// volly_transform, both (ptr, len i32) -> i64, for the hooks it implements. The host writes
+// the call's JSON input, AdmitInput or TransformInput, to memory from volly_alloc and
+// passes it to the hook, which returns its JSON output, AdmitOutput or TransformOutput, as
+// the pointer in the high 32 bits and the length in the low 32 bits. It returns 0 for no
+// output: admit then admits and transform changes nothing. The host module "volly" provides
+// log(ptr, len i32) for messages to the gateway log
+package wasmplugin
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "sync"
+    "time"
+
+    "github.com/tetratelabs/wazero"
+    "github.com/tetratelabs/wazero/api"
+    "github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// ABIVersion is the plugin ABI this package speaks
+const ABIVersion = 1
+
+// Plugin defaults
+const (
+    DefaultTimeout = 100 * time.Millisecond
+    // DefaultMemoryPages bounds a plugin's memory to 16 MiB
+    DefaultMemoryPages = 256
+    // maxOutput bounds the JSON a hook may hand back
+    maxOutput = 1 << 20
+)
+
+const (
+    exportABIVersion = "volly_abi_version"
+    exportAlloc      = "volly_alloc"
+    exportAdmit      = "volly_admit"
+    exportTransform  = "volly_transform"
+)
+
+var (
+    ErrABIVersion   = errors.New("plugin speaks another ABI version")
+    ErrMissingABI   = errors.New("plugin does not export the plugin ABI")
+    ErrPluginFailed = errors.New("policy plugin failed")
+    ErrRefused      = errors.New("refused by a policy plugin")
+    ErrClosed       = errors.New("plugin is closed")
+)
+
+// RefusedError is a plugin's refusal to admit
+type RefusedError struct {
+    Plugin string
+    Reason string
+}
+
+func (e *RefusedError) Error() string {
+    if e.Reason == "" {
+        return fmt.Sprintf("policy plugin %s refused", e.Plugin)
+    }
+    return fmt.Sprintf("policy plugin %s refused: %s", e.Plugin, e.Reason)
+}
+
+func (e *RefusedError) Unwrap() error {
+    return ErrRefused
+}
+
+// Options bound a plugin's calls
+type Options struct {
+    // Timeout bounds one call; DefaultTimeout when zero
+    Timeout time.Duration
+    // MemoryPages bounds memory in 64 KiB pages; DefaultMemoryPages when zero
+    MemoryPages uint32
+}
+
+// Plugin is one compiled module. It is safe for concurrent use
+type Plugin struct {
+    name     string
+    opts     Options
+    runtime  wazero.Runtime
+    compiled wazero.CompiledModule
+
+    admit, transform bool
+
+    // mu is held shared by calls, so Close waits for those in flight
+    mu     sync.RWMutex
+    closed bool
+}
+
+// Load compiles a module and checks that it speaks ABIVersion
+func Load(ctx context.Context, name string, wasm []byte, opts Options) (*Plugin, error) {
+    if opts.Timeout <= 0 {
+        opts.Timeout = DefaultTimeout
+    }
+    if opts.MemoryPages == 0 {
+        opts.MemoryPages = DefaultMemoryPages
+    }
+    rt := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+        WithCloseOnContextDone(true).
+        WithMemoryLimitPages(opts.MemoryPages))
+    p := &Plugin{name: name, opts: opts, runtime: rt}
+    if err := p.compile(ctx, wasm); err != nil {
+        rt.Close(ctx)
+        return nil, fmt.Errorf("plugin %s: %w", name, err)
+    }
+    return p, nil
+}
+
+func (p *Plugin) compile(ctx context.Context, wasm []byte) error {
+    if _, err := wasi_snapshot_preview1.Instantiate(ctx, p.runtime); err != nil {
+        return err
+    }
+    _, err := p.runtime.NewHostModuleBuilder("volly").
+        NewFunctionBuilder().
+        WithFunc(func(ctx context.Context, m api.Module, ptr, size uint32) {
+            if msg, ok := m.Memory().Read(ptr, size); ok {
+                log.Printf("wasm plugin %s: %s", p.name, msg)
+            }
+        }).
+        Export("log").
+        Instantiate(ctx)
+    if err != nil {
+        return err
+    }
+    if p.compiled, err = p.runtime.CompileModule(ctx, wasm); err != nil {
+        return err
+    }
+
+    exports := p.compiled.ExportedFunctions()
+    if exports[exportABIVersion] == nil || exports[exportAlloc] == nil || len(p.compiled.ExportedMemories()) == 0 {
+        return ErrMissingABI
+    }
+    _, p.admit = exports[exportAdmit]
+    _, p.transform = exports[exportTransform]
+
+    ctx, cancel := context.WithTimeout(ctx, p.opts.Timeout)
+    defer cancel()
+    mod, err := p.instantiate(ctx)
+    if err != nil {
+        return err
+    }
+    defer mod.Close(ctx)
+    res, err := mod.ExportedFunction(exportABIVersion).Call(ctx)
+    if err != nil {
+        return err
+    }
+    if len(res) != 1 || uint32(res[0]) != ABIVersion {
+        return ErrABIVersion
+    }
+    return nil
+}
+
+// Name is the name the plugin was loaded under
+func (p *Plugin) Name() string {
+    return p.name
+}
+
+// Close waits for calls in flight and releases the compiled module; later calls fail with
+// ErrClosed
+func (p *Plugin) Close(ctx context.Context) error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.closed {
+        return nil
+    }
+    p.closed = true
+    return p.runtime.Close(ctx)
+}
+
+func (p *Plugin) instantiate(ctx context.Context) (api.Module, error) {
+    // Anonymous instances, so calls run side by side; _initialize sets up reactor modules
+    return p.runtime.InstantiateModule(ctx, p.compiled, wazero.NewModuleConfig().
+        WithName("").
+        WithStartFunctions("_initialize"))
+}
+
+// call runs export in a fresh instance with in as its JSON input and decodes its output
+// into out; it reports whether there was any
+func (p *Plugin) call(ctx context.Context, export string, in, out interface{}) (bool, error) {
+    input, err := json.Marshal(in)
+    if err != nil {
+        return false, err
+    }
+    p.mu.RLock()
+    defer p.mu.RUnlock()
+    if p.closed {
+        return false, ErrClosed
+    }
+    ctx, cancel := context.WithTimeout(ctx, p.opts.Timeout)
+    defer cancel()
+
+    mod, err := p.instantiate(ctx)
+    if err != nil {
+        return false, p.failed(err)
+    }
+    defer mod.Close(ctx)
+
+    res, err := mod.ExportedFunction(exportAlloc).Call(ctx, uint64(len(input)))
+    if err != nil {
+        return false, p.failed(err)
+    }
+    ptr := uint32(res[0])
+    if !mod.Memory().Write(ptr, input) {
+        return false, p.failed(errors.New("input buffer out of range"))
+    }
+    res, err = mod.ExportedFunction(export).Call(ctx, uint64(ptr), uint64(len(input)))
+    if err != nil {
+        return false, p.failed(err)
+    }
+    if res[0] == 0 {
+        return false, nil
+    }
+    outPtr, outLen := uint32(res[0]>>32), uint32(res[0])
+    if outLen > maxOutput {
+        return false, p.failed(errors.New("output too large"))
+    }
+    output, ok := mod.Memory().Read(outPtr, outLen)
+    if !ok {
+        return false, p.failed(errors.New("output out of range"))
+    }
+    if err := json.Unmarshal(output, out); err != nil {
+        return false, p.failed(err)
+    }
+    return true, nil
+}
+
+func (p *Plugin) failed(err error) error {
+    return fmt.Errorf("%w: %s: %v", ErrPluginFailed, p.name, err)
+}
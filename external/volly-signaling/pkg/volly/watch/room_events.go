@@ -0,0 +1,335 @@
+package watch
+
+import (
+    "context"
+    "errors"
+    "strconv"
+    "strings"
+    "sync"
+
+    "github.com/livekit/protocol/livekit"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/auth"
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+    "github.com/volly-org/volly-signaling/pkg/volly/e2ee"
+    "github.com/volly-org/volly-signaling/pkg/volly/events"
+    "github.com/volly-org/volly-signaling/pkg/volly/gateway"
+    "github.com/volly-org/volly-signaling/pkg/volly/grants"
+    "github.com/volly-org/volly-signaling/pkg/volly/handraise"
+    "github.com/volly-org/volly-signaling/pkg/volly/tagset"
+)
+
+// DefaultStreamBuffer is how many events a watcher may fall behind before it is dropped
+const DefaultStreamBuffer = 64
+
+// LiveKit webhook event names consumed by the watcher
+const (
+    webhookParticipantJoined = "participant_joined"
+    webhookParticipantLeft   = "participant_left"
+)
+
+var ErrSlowConsumer = errors.New("watcher fell too far behind and was dropped")
+
+// EventType is the kind of a RoomEvent
+type EventType string
+
+const (
+    EventJoined          EventType = "joined"
+    EventLeft            EventType = "left"
+    EventGrantUpdated    EventType = "grant_updated"
+    EventKeyRotated      EventType = "key_rotated"
+    EventQualityDegraded EventType = "quality_degraded"
+    EventHandRaised      EventType = "hand_raised"
+    EventHandLowered     EventType = "hand_lowered"
+    EventHandApproved    EventType = "hand_approved"
+    EventHandDenied      EventType = "hand_denied"
+)
+
+// handEvents maps the hand events on the bus to room events
+var handEvents = map[string]EventType{
+    handraise.EventRaised:   EventHandRaised,
+    handraise.EventLowered:  EventHandLowered,
+    handraise.EventApproved: EventHandApproved,
+    handraise.EventDenied:   EventHandDenied,
+}
+
+// RoomEvent is one typed event on a WatchRoom stream; only the fields for its Type are set
+type RoomEvent struct {
+    Type      EventType `json:"type"`
+    Room      string    `json:"room"`
+    Identity  string    `json:"identity,omitempty"`
+    Timestamp int64     `json:"timestamp"`
+    // Tags are the cost-attribution tags of the participant's grant
+    Tags map[string]string `json:"tags,omitempty"`
+
+    // Changes lists what differs from the participant's previous grant
+    Changes []grants.Change `json:"changes,omitempty"`
+    // Epoch is the new media key epoch
+    Epoch uint64 `json:"epoch,omitempty"`
+    // Quality is the connection quality the participant dropped to
+    Quality livekit.ConnectionQuality `json:"quality,omitempty"`
+    // Hand is the id of the hand the participant raised, and By the host who decided it
+    Hand string `json:"hand,omitempty"`
+    By   string `json:"by,omitempty"`
+}
+
+// WatchRequest selects a room and optionally a subset of event types and the participants
+// whose grants carry Tags; room-wide events such as key rotations are sent regardless
+type WatchRequest struct {
+    Room  string
+    Types []EventType
+    Tags  map[string]string
+}
+
+// Stream is the server side of a WatchRoom call, matching a generated gRPC server stream
+type Stream interface {
+    Context() context.Context
+    Send(event *RoomEvent) error
+}
+
+type subscriber struct {
+    types  map[EventType]bool
+    tags   map[string]string
+    events chan *RoomEvent
+}
+
+// Watcher assembles room events from LiveKit webhooks, gateway admissions and the event bus
+// and fans them out to WatchRoom streams
+type Watcher struct {
+    buffer      int
+    clock       clock.Clock
+    unsubscribe []func()
+
+    mu      sync.Mutex
+    nextID  int
+    subs    map[string]map[int]*subscriber
+    grants  map[string]*auth.VollyVideoGrant
+    quality map[string]livekit.ConnectionQuality
+    // rooms counts the participants with a grant in each room
+    rooms map[string]int
+}
+
+// NewWatcher creates a watcher; bus may be nil when key rotation and hand events are not needed
+func NewWatcher(bus events.Bus) *Watcher {
+    w := &Watcher{
+        buffer:  DefaultStreamBuffer,
+        clock:   clock.System,
+        subs:    make(map[string]map[int]*subscriber),
+        grants:  make(map[string]*auth.VollyVideoGrant),
+        quality: make(map[string]livekit.ConnectionQuality),
+        rooms:   make(map[string]int),
+    }
+    if bus != nil {
+        w.unsubscribe = append(w.unsubscribe, bus.Subscribe(e2ee.EventKeyRotated, w.handleKeyRotated))
+        for typ := range handEvents {
+            w.unsubscribe = append(w.unsubscribe, bus.Subscribe(typ, w.handleHand))
+        }
+    }
+    return w
+}
+
+// SetBuffer sets the per-stream buffer; it applies to streams opened afterwards
+func (w *Watcher) SetBuffer(n int) *Watcher {
+    w.buffer = n
+    return w
+}
+
+// SetClock sets the time source for event timestamps
+func (w *Watcher) SetClock(c clock.Clock) *Watcher {
+    w.clock = c
+    return w
+}
+
+// Close detaches the watcher from the event bus
+func (w *Watcher) Close() {
+    for _, unsubscribe := range w.unsubscribe {
+        unsubscribe()
+    }
+}
+
+// Watch streams events for a room until the client goes away; it is the WatchRoom handler
+func (w *Watcher) Watch(req WatchRequest, stream Stream) error {
+    sub := &subscriber{tags: req.Tags, events: make(chan *RoomEvent, w.buffer)}
+    if len(req.Types) > 0 {
+        sub.types = make(map[EventType]bool, len(req.Types))
+        for _, t := range req.Types {
+            sub.types[t] = true
+        }
+    }
+
+    w.mu.Lock()
+    id := w.nextID
+    w.nextID++
+    if w.subs[req.Room] == nil {
+        w.subs[req.Room] = make(map[int]*subscriber)
+    }
+    w.subs[req.Room][id] = sub
+    w.mu.Unlock()
+
+    defer w.remove(req.Room, id)
+
+    ctx := stream.Context()
+    for {
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case event, ok := <-sub.events:
+            if !ok {
+                return ErrSlowConsumer
+            }
+            if err := stream.Send(event); err != nil {
+                return err
+            }
+        }
+    }
+}
+
+// HandleWebhook turns LiveKit participant webhooks into joined and left events
+func (w *Watcher) HandleWebhook(ctx context.Context, event *livekit.WebhookEvent) {
+    if event.GetRoom() == nil || event.GetParticipant() == nil {
+        return
+    }
+    room, identity := event.GetRoom().GetName(), event.GetParticipant().GetIdentity()
+    switch event.GetEvent() {
+    case webhookParticipantJoined:
+        w.emit(&RoomEvent{Type: EventJoined, Room: room, Identity: identity})
+    case webhookParticipantLeft:
+        var tags map[string]string
+        w.mu.Lock()
+        if grant, ok := w.grants[participantKey(room, identity)]; ok {
+            tags = grant.Tags
+            if w.rooms[room]--; w.rooms[room] <= 0 {
+                delete(w.rooms, room)
+            }
+        }
+        delete(w.grants, participantKey(room, identity))
+        delete(w.quality, participantKey(room, identity))
+        w.mu.Unlock()
+        w.emit(&RoomEvent{Type: EventLeft, Room: room, Identity: identity, Tags: tags})
+    }
+}
+
+// Admit records each admitted grant and emits grant_updated when a participant reconnects
+// or renews with different permissions; it never rejects
+func (w *Watcher) Admit(ctx context.Context, a *gateway.Admission) error {
+    if a.Grant == nil {
+        return nil
+    }
+    key := participantKey(a.Room, a.Identity)
+    w.mu.Lock()
+    previous, seen := w.grants[key]
+    w.grants[key] = a.Grant
+    if !seen {
+        w.rooms[a.Room]++
+    }
+    w.mu.Unlock()
+
+    if !seen {
+        return nil
+    }
+    if changes := grants.Diff(previous, a.Grant); len(changes) > 0 {
+        w.emit(&RoomEvent{Type: EventGrantUpdated, Room: a.Room, Identity: a.Identity, Changes: changes})
+    }
+    return nil
+}
+
+// Participants counts the participants this watcher admitted to room and has not seen
+// leave, other than except; it is the gateway's RoomCounter
+func (w *Watcher) Participants(room, except string) int {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    n := w.rooms[room]
+    if _, ok := w.grants[participantKey(room, except)]; ok {
+        n--
+    }
+    return n
+}
+
+// Forget drops what the watcher holds about identity in tenant's rooms, as if it had left
+// them all, and returns how many rooms it was in
+func (w *Watcher) Forget(tenant, identity string) int {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    n := 0
+    for key, grant := range w.grants {
+        if grant.Tenant != tenant || grant.Identity != identity {
+            continue
+        }
+        room := strings.TrimSuffix(key, "\x00"+identity)
+        if w.rooms[room]--; w.rooms[room] <= 0 {
+            delete(w.rooms, room)
+        }
+        delete(w.grants, key)
+        delete(w.quality, key)
+        n++
+    }
+    return n
+}
+
+// ReportQuality feeds gateway connection quality samples; quality_degraded is emitted only
+// when a participant drops from good or excellent to poor or lost
+func (w *Watcher) ReportQuality(room, identity string, quality livekit.ConnectionQuality) {
+    key := participantKey(room, identity)
+    w.mu.Lock()
+    previous, seen := w.quality[key]
+    w.quality[key] = quality
+    w.mu.Unlock()
+
+    wasHealthy := !seen || previous == livekit.ConnectionQuality_GOOD || previous == livekit.ConnectionQuality_EXCELLENT
+    degraded := quality == livekit.ConnectionQuality_POOR || quality == livekit.ConnectionQuality_LOST
+    if wasHealthy && degraded {
+        w.emit(&RoomEvent{Type: EventQualityDegraded, Room: room, Identity: identity, Quality: quality})
+    }
+}
+
+func (w *Watcher) handleKeyRotated(ctx context.Context, event events.Event) {
+    epoch, _ := strconv.ParseUint(event.Data["epoch"], 10, 64)
+    w.emit(&RoomEvent{Type: EventKeyRotated, Room: event.Room, Epoch: epoch})
+}
+
+func (w *Watcher) handleHand(ctx context.Context, event events.Event) {
+    w.emit(&RoomEvent{Type: handEvents[event.Type], Room: event.Room, Identity: event.Identity,
+        Hand: event.Data["hand"], By: event.Data["by"]})
+}
+
+// emit delivers without blocking; a full buffer drops that subscriber rather than stalling the room
+func (w *Watcher) emit(event *RoomEvent) {
+    event.Timestamp = w.clock.Now().Unix()
+
+    w.mu.Lock()
+    defer w.mu.Unlock()
+
+    if event.Identity != "" && event.Tags == nil {
+        if grant, ok := w.grants[participantKey(event.Room, event.Identity)]; ok {
+            event.Tags = grant.Tags
+        }
+    }
+    for id, sub := range w.subs[event.Room] {
+        if sub.types != nil && !sub.types[event.Type] {
+            continue
+        }
+        if event.Identity != "" && !tagset.Match(event.Tags, sub.tags) {
+            continue
+        }
+        select {
+        case sub.events <- event:
+        default:
+            close(sub.events)
+            delete(w.subs[event.Room], id)
+        }
+    }
+}
+
+func (w *Watcher) remove(room string, id int) {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+
+    delete(w.subs[room], id)
+    if len(w.subs[room]) == 0 {
+        delete(w.subs, room)
+    }
+}
+
+func participantKey(room, identity string) string {
+    return room + "\x00" + identity
+}
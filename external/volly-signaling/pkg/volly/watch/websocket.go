@@ -0,0 +1,84 @@
+package watch
+
+import (
+    "context"
+    "errors"
+    "sync"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/protocol"
+)
+
+type webSocketStream struct {
+    ctx     context.Context
+    session *protocol.Session
+
+    mu    sync.Mutex
+    types map[EventType]bool
+}
+
+// NewWebSocketStream serves a WatchRoom stream over a negotiated WebSocket session. The
+// stream's context ends with ctx, when the client goes away, or when the session's token
+// expires without a refresh. Clients may send ping, and a subscribe that narrows the event
+// types sent, when they negotiated those features
+func NewWebSocketStream(ctx context.Context, session *protocol.Session) Stream {
+    ctx, cancel := context.WithCancel(ctx)
+    s := &webSocketStream{ctx: ctx, session: session}
+    go func() {
+        defer cancel()
+        s.read()
+    }()
+    go func() {
+        select {
+        case <-session.Expired():
+            session.SendError(&protocol.Error{Code: protocol.CodeTokenExpired, Message: "token expired"})
+            cancel()
+        case <-ctx.Done():
+        }
+    }()
+    return s
+}
+
+func (s *webSocketStream) Context() context.Context { return s.ctx }
+
+func (s *webSocketStream) Send(event *RoomEvent) error {
+    s.mu.Lock()
+    skip := s.types != nil && !s.types[event.Type]
+    s.mu.Unlock()
+    if skip {
+        return nil
+    }
+    return s.session.Send(event)
+}
+
+// read handles client messages until the connection fails; invalid messages have already
+// been answered by the session and do not end the stream
+func (s *webSocketStream) read() {
+    for {
+        msg, err := s.session.Read()
+        var invalid *protocol.Error
+        if errors.As(err, &invalid) {
+            continue
+        }
+        if err != nil {
+            return
+        }
+
+        switch msg["type"] {
+        case protocol.TypePing:
+            if s.session.Has(protocol.FeaturePing) {
+                id, _ := msg["id"].(string)
+                s.session.Send(protocol.Pong{Type: protocol.TypePong, ID: id})
+            }
+        case protocol.TypeSubscribe:
+            if s.session.Has(protocol.FeatureEventFilter) {
+                types := make(map[EventType]bool)
+                for _, t := range msg["types"].([]interface{}) {
+                    types[EventType(t.(string))] = true
+                }
+                s.mu.Lock()
+                s.types = types
+                s.mu.Unlock()
+            }
+        }
+    }
+}
@@ -0,0 +1,93 @@
+package webauthn
+
+import (
+    "context"
+    "sort"
+    "sync"
+    "time"
+)
+
+// Store keeps registered credentials. IDs are unique per tenant, since a passkey login names
+// only its credential
+type Store interface {
+    // Put registers a credential; ErrCredentialExists when its ID is taken
+    Put(ctx context.Context, c Credential) error
+    Get(ctx context.Context, tenant, id string) (Credential, error)
+    // List returns identity's credentials, oldest first
+    List(ctx context.Context, tenant, identity string) ([]Credential, error)
+    // UpdateSignCount records the counter and time of the latest assertion if the counter
+    // still is from; ErrSignCountChanged when it is not
+    UpdateSignCount(ctx context.Context, tenant, id string, from, to uint32, usedAt time.Time) error
+    Delete(ctx context.Context, tenant, id string) error
+}
+
+// MemoryStore is a Store for a single process
+type MemoryStore struct {
+    mu    sync.Mutex
+    creds map[string]Credential
+}
+
+func NewMemoryStore() *MemoryStore {
+    return &MemoryStore{creds: make(map[string]Credential)}
+}
+
+func (m *MemoryStore) Put(ctx context.Context, c Credential) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    k := c.Tenant + "\x00" + c.ID
+    if _, ok := m.creds[k]; ok {
+        return ErrCredentialExists
+    }
+    m.creds[k] = c
+    return nil
+}
+
+func (m *MemoryStore) Get(ctx context.Context, tenant, id string) (Credential, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    c, ok := m.creds[tenant+"\x00"+id]
+    if !ok {
+        return Credential{}, ErrCredentialNotFound
+    }
+    return c, nil
+}
+
+func (m *MemoryStore) List(ctx context.Context, tenant, identity string) ([]Credential, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    var out []Credential
+    for _, c := range m.creds {
+        if c.Tenant == tenant && c.Identity == identity {
+            out = append(out, c)
+        }
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+    return out, nil
+}
+
+func (m *MemoryStore) UpdateSignCount(ctx context.Context, tenant, id string, from, to uint32, usedAt time.Time) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    k := tenant + "\x00" + id
+    c, ok := m.creds[k]
+    if !ok {
+        return ErrCredentialNotFound
+    }
+    if c.SignCount != from {
+        return ErrSignCountChanged
+    }
+    c.SignCount, c.LastUsedAt = to, usedAt
+    m.creds[k] = c
+    return nil
+}
+
+func (m *MemoryStore) Delete(ctx context.Context, tenant, id string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    k := tenant + "\x00" + id
+    if _, ok := m.creds[k]; !ok {
+        return ErrCredentialNotFound
+    }
+    delete(m.creds, k)
+    return nil
+}
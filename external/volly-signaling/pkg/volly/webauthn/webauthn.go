@@ -0,0 +1,619 @@
+// Package webauthn runs the server side of WebAuthn: registration ceremonies that enrol a
+// passkey for an identity, and assertion checks that prove the identity later, for passwordless
+// login and step-up. Ceremonies are stateless: a challenge is signed and names the tenant and
+// identity it was issued for, so instances sharing a key accept each other's.
+//
+// Attestation statements are not verified. Passkeys send "none", and which authenticator made
+// a credential matters less here than that the identity registering it was authenticated
+package webauthn
+
+import (
+    "bytes"
+    "context"
+    "crypto"
+    "crypto/ecdsa"
+    "crypto/ed25519"
+    "crypto/elliptic"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/subtle"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/volly-org/volly-signaling/pkg/volly/clock"
+)
+
+// COSE algorithms credentials may use
+const (
+    AlgES256 = -7
+    AlgEdDSA = -8
+    AlgRS256 = -257
+)
+
+// DefaultTimeout is how long a ceremony's challenge can be answered
+const DefaultTimeout = 5 * time.Minute
+
+// maxSpent bounds the single-use challenge cache
+const maxSpent = 100000
+
+// Authenticator data flags
+const (
+    flagUserPresent  = 0x01
+    flagUserVerified = 0x04
+    flagAttested     = 0x40
+)
+
+var (
+    ErrInvalidChallenge   = errors.New("webauthn challenge is invalid, expired or already used")
+    ErrVerificationFailed = errors.New("webauthn verification failed")
+    ErrCredentialNotFound = errors.New("webauthn credential not found")
+    ErrCredentialExists   = errors.New("webauthn credential is already registered")
+    ErrUnsupportedKey     = errors.New("unsupported webauthn public key")
+    // ErrSignCountChanged is a Store's answer when the counter moved since it was read
+    ErrSignCountChanged = errors.New("webauthn signature counter changed since it was read")
+)
+
+// Credential is a registered authenticator
+type Credential struct {
+    // ID is the credential ID, base64url encoded
+    ID       string `json:"id"`
+    Tenant   string `json:"tenant,omitempty"`
+    Identity string `json:"identity"`
+    // PublicKey is PKIX DER, as AuthenticatorAttestationResponse.getPublicKey() returns it
+    PublicKey  []byte    `json:"publicKey"`
+    Algorithm  int       `json:"algorithm"`
+    SignCount  uint32    `json:"signCount,omitempty"`
+    CreatedAt  time.Time `json:"createdAt"`
+    LastUsedAt time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// Options shared by both ceremonies, in the JSON form PublicKeyCredential.parseCreationOptionsFromJSON
+// and parseRequestOptionsFromJSON take
+type (
+    RelyingPartyEntity struct {
+        ID   string `json:"id"`
+        Name string `json:"name"`
+    }
+
+    UserEntity struct {
+        // ID is the user handle, base64url encoded
+        ID          string `json:"id"`
+        Name        string `json:"name"`
+        DisplayName string `json:"displayName"`
+    }
+
+    CredentialParameter struct {
+        Type string `json:"type"`
+        Alg  int    `json:"alg"`
+    }
+
+    CredentialDescriptor struct {
+        Type string `json:"type"`
+        ID   string `json:"id"`
+    }
+
+    AuthenticatorSelection struct {
+        ResidentKey      string `json:"residentKey"`
+        UserVerification string `json:"userVerification"`
+    }
+)
+
+// CreationOptions start a registration
+type CreationOptions struct {
+    Challenge              string                 `json:"challenge"`
+    RP                     RelyingPartyEntity     `json:"rp"`
+    User                   UserEntity             `json:"user"`
+    PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+    Timeout                int64                  `json:"timeout"`
+    ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials,omitempty"`
+    AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+    Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions start a login
+type RequestOptions struct {
+    Challenge        string                 `json:"challenge"`
+    Timeout          int64                  `json:"timeout"`
+    RPID             string                 `json:"rpId"`
+    AllowCredentials []CredentialDescriptor `json:"allowCredentials,omitempty"`
+    UserVerification string                 `json:"userVerification"`
+}
+
+// Registration is PublicKeyCredential.toJSON() after navigator.credentials.create
+type Registration struct {
+    ID       string              `json:"id"`
+    Response AttestationResponse `json:"response"`
+}
+
+// AttestationResponse carries the fields of AuthenticatorAttestationResponse it is checked
+// by, every byte field base64url encoded
+type AttestationResponse struct {
+    ClientDataJSON     string `json:"clientDataJSON"`
+    AuthenticatorData  string `json:"authenticatorData"`
+    PublicKey          string `json:"publicKey"`
+    PublicKeyAlgorithm int    `json:"publicKeyAlgorithm"`
+}
+
+// Assertion is PublicKeyCredential.toJSON() after navigator.credentials.get
+type Assertion struct {
+    ID       string            `json:"id"`
+    Response AssertionResponse `json:"response"`
+}
+
+// AssertionResponse is an AuthenticatorAssertionResponse, every field base64url encoded
+type AssertionResponse struct {
+    ClientDataJSON    string `json:"clientDataJSON"`
+    AuthenticatorData string `json:"authenticatorData"`
+    Signature         string `json:"signature"`
+    UserHandle        string `json:"userHandle,omitempty"`
+}
+
+// Verification is what a checked assertion established
+type Verification struct {
+    Origin       string
+    UserVerified bool
+    SignCount    uint32
+    // Digest is SHA-256 over the signed data and signature, naming the assertion in audit logs
+    Digest string
+}
+
+// clientData is the part of CollectedClientData a ceremony is checked against
+type clientData struct {
+    Type      string `json:"type"`
+    Challenge string `json:"challenge"`
+    Origin    string `json:"origin"`
+}
+
+// RelyingParty runs ceremonies for one WebAuthn relying party ID, usually the site's
+// registrable domain
+type RelyingParty struct {
+    id         string
+    name       string
+    idHash     [32]byte
+    origins    map[string]bool
+    store      Store
+    key        []byte
+    verifyUser bool
+    timeout    time.Duration
+    clock      clock.Clock
+
+    mu    sync.Mutex
+    spent map[string]time.Time
+}
+
+// New creates a relying party accepting ceremonies made on origins; key signs challenges
+// and user handles
+func New(id, name string, origins []string, store Store, key []byte) *RelyingParty {
+    rp := &RelyingParty{
+        id:         id,
+        name:       name,
+        idHash:     sha256.Sum256([]byte(id)),
+        origins:    make(map[string]bool, len(origins)),
+        store:      store,
+        key:        key,
+        verifyUser: true,
+        timeout:    DefaultTimeout,
+        clock:      clock.System,
+        spent:      make(map[string]time.Time),
+    }
+    for _, o := range origins {
+        rp.origins[o] = true
+    }
+    return rp
+}
+
+// SetUserVerification sets whether ceremonies need the authenticator to verify the user with
+// a PIN or biometric, not just see them present; required by default
+func (rp *RelyingParty) SetUserVerification(required bool) *RelyingParty {
+    rp.verifyUser = required
+    return rp
+}
+
+// SetTimeout sets how long a ceremony's challenge can be answered
+func (rp *RelyingParty) SetTimeout(d time.Duration) *RelyingParty {
+    rp.timeout = d
+    return rp
+}
+
+// SetClock sets the time source for challenge expiry and usage stamps
+func (rp *RelyingParty) SetClock(c clock.Clock) *RelyingParty {
+    rp.clock = c
+    return rp
+}
+
+// Store returns the credential store
+func (rp *RelyingParty) Store() Store { return rp.store }
+
+// BeginRegistration starts enrolling an authenticator for identity, who must already be
+// authenticated by the caller. Credentials the identity has are excluded, so the same
+// authenticator is not registered twice
+func (rp *RelyingParty) BeginRegistration(ctx context.Context, tenant, identity, displayName string) (*CreationOptions, error) {
+    existing, err := rp.store.List(ctx, tenant, identity)
+    if err != nil {
+        return nil, err
+    }
+    challenge, err := rp.challenge("reg", tenant, identity)
+    if err != nil {
+        return nil, err
+    }
+    if displayName == "" {
+        displayName = identity
+    }
+    return &CreationOptions{
+        Challenge: challenge,
+        RP:        RelyingPartyEntity{ID: rp.id, Name: rp.name},
+        User:      UserEntity{ID: rp.userHandle(tenant, identity), Name: identity, DisplayName: displayName},
+        PubKeyCredParams: []CredentialParameter{
+            {Type: "public-key", Alg: AlgES256},
+            {Type: "public-key", Alg: AlgEdDSA},
+            {Type: "public-key", Alg: AlgRS256},
+        },
+        Timeout:                rp.timeout.Milliseconds(),
+        ExcludeCredentials:     descriptors(existing),
+        AuthenticatorSelection: AuthenticatorSelection{ResidentKey: "preferred", UserVerification: rp.userVerification()},
+        Attestation:            "none",
+    }, nil
+}
+
+// FinishRegistration checks the authenticator's response to BeginRegistration and stores
+// the new credential
+func (rp *RelyingParty) FinishRegistration(ctx context.Context, tenant, identity string, reg Registration) (Credential, error) {
+    raw, err1 := decode(reg.Response.ClientDataJSON)
+    authData, err2 := decode(reg.Response.AuthenticatorData)
+    der, err3 := decode(reg.Response.PublicKey)
+    id, err4 := decode(reg.ID)
+    if err := errors.Join(err1, err2, err3, err4); err != nil {
+        return Credential{}, fmt.Errorf("%w: malformed registration", ErrVerificationFailed)
+    }
+    cd, err := rp.clientData(raw, "webauthn.create")
+    if err != nil {
+        return Credential{}, err
+    }
+    challengeTenant, challengeIdentity, err := rp.spend("reg", cd.Challenge)
+    if err != nil {
+        return Credential{}, err
+    }
+    if challengeTenant != tenant || challengeIdentity != identity {
+        return Credential{}, ErrInvalidChallenge
+    }
+
+    flags, count, err := rp.authenticatorData(authData)
+    if err != nil {
+        return Credential{}, err
+    }
+    // attestedCredentialData: aaguid(16) credentialIdLength(2) credentialId, then the COSE key
+    if flags&flagAttested == 0 || len(authData) < 55 {
+        return Credential{}, fmt.Errorf("%w: no attested credential data", ErrVerificationFailed)
+    }
+    n := int(binary.BigEndian.Uint16(authData[53:55]))
+    if len(authData) < 55+n || !bytes.Equal(authData[55:55+n], id) {
+        return Credential{}, fmt.Errorf("%w: credential ID does not match the authenticator data", ErrVerificationFailed)
+    }
+    if err := checkKey(der, reg.Response.PublicKeyAlgorithm); err != nil {
+        return Credential{}, err
+    }
+
+    c := Credential{
+        ID:        base64.RawURLEncoding.EncodeToString(id),
+        Tenant:    tenant,
+        Identity:  identity,
+        PublicKey: der,
+        Algorithm: reg.Response.PublicKeyAlgorithm,
+        SignCount: count,
+        CreatedAt: rp.clock.Now(),
+    }
+    if err := rp.store.Put(ctx, c); err != nil {
+        return Credential{}, err
+    }
+    return c, nil
+}
+
+// BeginLogin starts a login. With an identity the browser offers only its credentials; without
+// one it offers any passkey it holds for the relying party. An identity without passkeys gets
+// the same answer as one with, naming a credential no authenticator holds, so begin calls
+// cannot tell which identities exist
+func (rp *RelyingParty) BeginLogin(ctx context.Context, tenant, identity string) (*RequestOptions, error) {
+    var allow []CredentialDescriptor
+    if identity != "" {
+        creds, err := rp.store.List(ctx, tenant, identity)
+        if err != nil {
+            return nil, err
+        }
+        allow = descriptors(creds)
+        if len(allow) == 0 {
+            allow = []CredentialDescriptor{{Type: "public-key", ID: rp.decoyCredential(tenant, identity)}}
+        }
+    }
+    challenge, err := rp.challenge("login", tenant, identity)
+    if err != nil {
+        return nil, err
+    }
+    return &RequestOptions{
+        Challenge:        challenge,
+        Timeout:          rp.timeout.Milliseconds(),
+        RPID:             rp.id,
+        AllowCredentials: allow,
+        UserVerification: rp.userVerification(),
+    }, nil
+}
+
+// FinishLogin checks an assertion answering BeginLogin and returns the credential that made
+// it; its Identity is who logged in
+func (rp *RelyingParty) FinishLogin(ctx context.Context, tenant string, a Assertion) (Credential, Verification, error) {
+    var identity string
+    c, v, err := rp.verify(ctx, tenant, a, func(challenge string) error {
+        challengeTenant, challengeIdentity, err := rp.spend("login", challenge)
+        if err != nil {
+            return err
+        }
+        if challengeTenant != tenant {
+            return ErrInvalidChallenge
+        }
+        identity = challengeIdentity
+        return nil
+    })
+    if err != nil {
+        return Credential{}, Verification{}, err
+    }
+    if identity != "" && c.Identity != identity {
+        return Credential{}, Verification{}, fmt.Errorf("%w: credential belongs to another identity", ErrVerificationFailed)
+    }
+    return c, v, nil
+}
+
+// VerifyAssertion checks an assertion over a challenge issued elsewhere, such as a step-up
+// nonce; the caller checks the credential's Identity and that the challenge is used once
+func (rp *RelyingParty) VerifyAssertion(ctx context.Context, tenant string, a Assertion, challenge []byte) (Credential, Verification, error) {
+    want := base64.RawURLEncoding.EncodeToString(challenge)
+    return rp.verify(ctx, tenant, a, func(got string) error {
+        if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+            return fmt.Errorf("%w: assertion is for another challenge", ErrVerificationFailed)
+        }
+        return nil
+    })
+}
+
+// verify checks an assertion, with checkChallenge judging its client data's challenge, and
+// records the credential's new counter
+func (rp *RelyingParty) verify(ctx context.Context, tenant string, a Assertion, checkChallenge func(string) error) (Credential, Verification, error) {
+    raw, err1 := decode(a.Response.ClientDataJSON)
+    authData, err2 := decode(a.Response.AuthenticatorData)
+    sig, err3 := decode(a.Response.Signature)
+    if err := errors.Join(err1, err2, err3); err != nil {
+        return Credential{}, Verification{}, fmt.Errorf("%w: malformed assertion", ErrVerificationFailed)
+    }
+    cd, err := rp.clientData(raw, "webauthn.get")
+    if err != nil {
+        return Credential{}, Verification{}, err
+    }
+    if err := checkChallenge(cd.Challenge); err != nil {
+        return Credential{}, Verification{}, err
+    }
+    flags, count, err := rp.authenticatorData(authData)
+    if err != nil {
+        return Credential{}, Verification{}, err
+    }
+
+    c, err := rp.store.Get(ctx, tenant, strings.TrimRight(a.ID, "="))
+    if err != nil {
+        return Credential{}, Verification{}, err
+    }
+    if a.Response.UserHandle != "" && strings.TrimRight(a.Response.UserHandle, "=") != rp.userHandle(tenant, c.Identity) {
+        return Credential{}, Verification{}, fmt.Errorf("%w: user handle does not match the credential", ErrVerificationFailed)
+    }
+    clientDataHash := sha256.Sum256(raw)
+    signed := append(append(make([]byte, 0, len(authData)+32), authData...), clientDataHash[:]...)
+    if err := verifySignature(c.PublicKey, signed, sig); err != nil {
+        return Credential{}, Verification{}, err
+    }
+    // Authenticators without a counter always report zero; one going back may be a clone
+    if (count != 0 || c.SignCount != 0) && count <= c.SignCount {
+        return Credential{}, Verification{}, fmt.Errorf("%w: signature counter went back, the authenticator may be cloned", ErrVerificationFailed)
+    }
+    // Moved only from the value checked above, so of two assertions replaying one counter
+    // value, on this instance or another, only the first is accepted
+    now := rp.clock.Now()
+    err = rp.store.UpdateSignCount(ctx, tenant, c.ID, c.SignCount, count, now)
+    if errors.Is(err, ErrSignCountChanged) {
+        return Credential{}, Verification{}, fmt.Errorf("%w: another assertion moved the signature counter first", ErrVerificationFailed)
+    }
+    if err != nil {
+        return Credential{}, Verification{}, err
+    }
+    c.SignCount, c.LastUsedAt = count, now
+
+    digest := sha256.Sum256(append(signed, sig...))
+    return c, Verification{
+        Origin:       cd.Origin,
+        UserVerified: flags&flagUserVerified != 0,
+        SignCount:    count,
+        Digest:       base64.RawURLEncoding.EncodeToString(digest[:]),
+    }, nil
+}
+
+// clientData parses and checks collected client data of a ceremony type
+func (rp *RelyingParty) clientData(raw []byte, typ string) (clientData, error) {
+    var cd clientData
+    if err := json.Unmarshal(raw, &cd); err != nil {
+        return clientData{}, fmt.Errorf("%w: malformed client data", ErrVerificationFailed)
+    }
+    cd.Challenge = strings.TrimRight(cd.Challenge, "=")
+    switch {
+    case cd.Type != typ:
+        return clientData{}, fmt.Errorf("%w: client data is a %q, not a %q", ErrVerificationFailed, cd.Type, typ)
+    case !rp.origins[cd.Origin]:
+        return clientData{}, fmt.Errorf("%w: origin %q is not allowed", ErrVerificationFailed, cd.Origin)
+    }
+    return cd, nil
+}
+
+// authenticatorData checks rpIdHash(32) flags(1) signCount(4) and returns the flags and count
+func (rp *RelyingParty) authenticatorData(authData []byte) (byte, uint32, error) {
+    if len(authData) < 37 || subtle.ConstantTimeCompare(authData[:32], rp.idHash[:]) != 1 {
+        return 0, 0, fmt.Errorf("%w: authenticator data is for another relying party", ErrVerificationFailed)
+    }
+    flags := authData[32]
+    if flags&flagUserPresent == 0 {
+        return 0, 0, fmt.Errorf("%w: user was not present", ErrVerificationFailed)
+    }
+    if rp.verifyUser && flags&flagUserVerified == 0 {
+        return 0, 0, fmt.Errorf("%w: user was not verified", ErrVerificationFailed)
+    }
+    return flags, binary.BigEndian.Uint32(authData[33:37]), nil
+}
+
+func (rp *RelyingParty) userVerification() string {
+    if rp.verifyUser {
+        return "required"
+    }
+    return "preferred"
+}
+
+// challenge issues "kind.nonce.tenant.identity.expiry.mac" as the ceremony's challenge bytes
+func (rp *RelyingParty) challenge(kind, tenant, identity string) (string, error) {
+    nonce := make([]byte, 16)
+    if _, err := rand.Read(nonce); err != nil {
+        return "", err
+    }
+    enc := base64.RawURLEncoding
+    body := kind + "." + enc.EncodeToString(nonce) + "." + enc.EncodeToString([]byte(tenant)) + "." +
+        enc.EncodeToString([]byte(identity)) + "." + strconv.FormatInt(rp.clock.Now().Add(rp.timeout).Unix(), 10)
+    return enc.EncodeToString([]byte(body + "." + rp.mac(body))), nil
+}
+
+// spend opens a challenge of kind as it came back in client data, once, and returns the
+// tenant and identity it was issued for
+func (rp *RelyingParty) spend(kind, encoded string) (tenant, identity string, err error) {
+    enc := base64.RawURLEncoding
+    raw, err := enc.DecodeString(encoded)
+    if err != nil {
+        return "", "", ErrInvalidChallenge
+    }
+    parts := strings.Split(string(raw), ".")
+    if len(parts) != 6 || parts[0] != kind {
+        return "", "", ErrInvalidChallenge
+    }
+    body := strings.Join(parts[:5], ".")
+    if !hmac.Equal([]byte(parts[5]), []byte(rp.mac(body))) {
+        return "", "", ErrInvalidChallenge
+    }
+    expiry, err := strconv.ParseInt(parts[4], 10, 64)
+    now := rp.clock.Now()
+    if err != nil || now.Unix() > expiry {
+        return "", "", ErrInvalidChallenge
+    }
+    t, err1 := enc.DecodeString(parts[2])
+    i, err2 := enc.DecodeString(parts[3])
+    if err1 != nil || err2 != nil {
+        return "", "", ErrInvalidChallenge
+    }
+
+    rp.mu.Lock()
+    defer rp.mu.Unlock()
+
+    if _, ok := rp.spent[body]; ok {
+        return "", "", ErrInvalidChallenge
+    }
+    if len(rp.spent) >= maxSpent {
+        for c, exp := range rp.spent {
+            if now.After(exp) {
+                delete(rp.spent, c)
+            }
+        }
+        if len(rp.spent) >= maxSpent {
+            return "", "", ErrInvalidChallenge
+        }
+    }
+    rp.spent[body] = time.Unix(expiry, 0)
+    return string(t), string(i), nil
+}
+
+func (rp *RelyingParty) mac(body string) string {
+    h := hmac.New(sha256.New, rp.key)
+    h.Write([]byte(body))
+    return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// userHandle is the opaque, stable handle for identity the authenticator stores, so a passkey
+// login can name its user without the identity itself leaving the server
+func (rp *RelyingParty) userHandle(tenant, identity string) string {
+    h := hmac.New(sha256.New, rp.key)
+    h.Write([]byte("user\x00" + tenant + "\x00" + identity))
+    return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// decoyCredential is a stable credential ID for an identity without passkeys, shaped like a
+// real one, which no authenticator holds
+func (rp *RelyingParty) decoyCredential(tenant, identity string) string {
+    h := hmac.New(sha256.New, rp.key)
+    h.Write([]byte("decoy\x00" + tenant + "\x00" + identity))
+    return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// checkKey accepts a PKIX key of a supported type that matches alg
+func checkKey(der []byte, alg int) error {
+    pub, err := x509.ParsePKIXPublicKey(der)
+    if err != nil {
+        return fmt.Errorf("%w: %v", ErrUnsupportedKey, err)
+    }
+    ok := false
+    switch k := pub.(type) {
+    case *ecdsa.PublicKey:
+        ok = alg == AlgES256 && k.Curve == elliptic.P256()
+    case ed25519.PublicKey:
+        ok = alg == AlgEdDSA
+    case *rsa.PublicKey:
+        ok = alg == AlgRS256 && k.N.BitLen() >= 2048
+    }
+    if !ok {
+        return fmt.Errorf("%w: %T with algorithm %d", ErrUnsupportedKey, pub, alg)
+    }
+    return nil
+}
+
+// verifySignature checks an assertion signature under a PKIX public key
+func verifySignature(der, signed, sig []byte) error {
+    pub, err := x509.ParsePKIXPublicKey(der)
+    if err != nil {
+        return fmt.Errorf("%w: %v", ErrUnsupportedKey, err)
+    }
+    digest := sha256.Sum256(signed)
+    ok := false
+    switch k := pub.(type) {
+    case *ecdsa.PublicKey:
+        ok = ecdsa.VerifyASN1(k, digest[:], sig)
+    case ed25519.PublicKey:
+        ok = ed25519.Verify(k, signed, sig)
+    case *rsa.PublicKey:
+        ok = rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+    default:
+        return fmt.Errorf("%w: %T", ErrUnsupportedKey, pub)
+    }
+    if !ok {
+        return fmt.Errorf("%w: bad signature", ErrVerificationFailed)
+    }
+    return nil
+}
+
+func descriptors(creds []Credential) []CredentialDescriptor {
+    out := make([]CredentialDescriptor, 0, len(creds))
+    for _, c := range creds {
+        out = append(out, CredentialDescriptor{Type: "public-key", ID: c.ID})
+    }
+    return out
+}
+
+// decode reads base64url with or without padding
+func decode(s string) ([]byte, error) {
+    return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
@@ -0,0 +1,117 @@
+package webauthn
+
+import (
+    "context"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "testing"
+    "time"
+)
+
+const (
+    testRPID   = "example.com"
+    testOrigin = "https://example.com"
+)
+
+// staleStore answers Get with the credential as it was first read, as another instance
+// that read it just before this one's update would
+type staleStore struct {
+    *MemoryStore
+    seen map[string]Credential
+}
+
+func (s *staleStore) Get(ctx context.Context, tenant, id string) (Credential, error) {
+    if c, ok := s.seen[id]; ok {
+        return c, nil
+    }
+    c, err := s.MemoryStore.Get(ctx, tenant, id)
+    if err == nil {
+        s.seen[id] = c
+    }
+    return c, err
+}
+
+// TestLoginIsUniformAndCountsOnce checks begin answers an identity without passkeys like one
+// with them, and that two assertions with one counter value are not both accepted when the
+// second is checked against a counter read before the first was recorded
+func TestLoginIsUniformAndCountsOnce(t *testing.T) {
+    ctx := context.Background()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+    if err != nil {
+        t.Fatal(err)
+    }
+    store := &staleStore{MemoryStore: NewMemoryStore(), seen: make(map[string]Credential)}
+    cred := Credential{ID: "Y3JlZC0x", Tenant: "acme", Identity: "alice", PublicKey: der, Algorithm: AlgES256, CreatedAt: time.Now()}
+    if err := store.Put(ctx, cred); err != nil {
+        t.Fatal(err)
+    }
+    rp := New(testRPID, "Example", []string{testOrigin}, store, []byte("0123456789abcdef0123456789abcdef"))
+
+    known, err := rp.BeginLogin(ctx, "acme", "alice")
+    if err != nil {
+        t.Fatal(err)
+    }
+    unknown, err := rp.BeginLogin(ctx, "acme", "mallory")
+    if err != nil {
+        t.Fatalf("begin for an identity without passkeys: %v", err)
+    }
+    again, err := rp.BeginLogin(ctx, "acme", "mallory")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(unknown.AllowCredentials) != len(known.AllowCredentials) || unknown.AllowCredentials[0].Type != known.AllowCredentials[0].Type {
+        t.Fatalf("begin for an unknown identity offers %+v, want one credential like %+v", unknown.AllowCredentials, known.AllowCredentials)
+    }
+    if unknown.AllowCredentials[0].ID != again.AllowCredentials[0].ID {
+        t.Fatal("begin offers an unknown identity a different credential each time, which tells it apart")
+    }
+
+    assert := func(count uint32) Assertion {
+        t.Helper()
+        options, err := rp.BeginLogin(ctx, "acme", "alice")
+        if err != nil {
+            t.Fatal(err)
+        }
+        clientData, err := json.Marshal(clientData{Type: "webauthn.get", Challenge: options.Challenge, Origin: testOrigin})
+        if err != nil {
+            t.Fatal(err)
+        }
+        rpIDHash := sha256.Sum256([]byte(testRPID))
+        authData := append(rpIDHash[:], flagUserPresent|flagUserVerified, 0, 0, 0, 0)
+        binary.BigEndian.PutUint32(authData[33:], count)
+        clientDataHash := sha256.Sum256(clientData)
+        digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+        sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+        if err != nil {
+            t.Fatal(err)
+        }
+        enc := base64.RawURLEncoding
+        return Assertion{ID: cred.ID, Response: AssertionResponse{
+            ClientDataJSON:    enc.EncodeToString(clientData),
+            AuthenticatorData: enc.EncodeToString(authData),
+            Signature:         enc.EncodeToString(sig),
+        }}
+    }
+
+    first, second := assert(1), assert(1)
+    if _, _, err := rp.FinishLogin(ctx, "acme", first); err != nil {
+        t.Fatalf("first assertion: %v", err)
+    }
+    if _, _, err := rp.FinishLogin(ctx, "acme", second); !errors.Is(err, ErrVerificationFailed) {
+        t.Fatalf("second assertion with the same counter: got %v, want ErrVerificationFailed", err)
+    }
+    if c, err := store.MemoryStore.Get(ctx, "acme", cred.ID); err != nil || c.SignCount != 1 {
+        t.Fatalf("stored counter %d, %v; want 1", c.SignCount, err)
+    }
+}
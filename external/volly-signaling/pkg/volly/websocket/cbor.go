@@ -0,0 +1,280 @@
+package websocket
+
+import (
+    "encoding"
+    "encoding/binary"
+    "errors"
+    "math"
+    "reflect"
+    "sort"
+    "strings"
+    "sync"
+)
+
+var ErrUnsupportedType = errors.New("value cannot be encoded as CBOR")
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// CBOR major types
+const (
+    cborUint   = 0 << 5
+    cborNegint = 1 << 5
+    cborBytes  = 2 << 5
+    cborText   = 3 << 5
+    cborArray  = 4 << 5
+    cborMap    = 5 << 5
+    cborTag    = 6 << 5
+    cborSimple = 7 << 5
+
+    cborFalse   = 0xf4
+    cborTrue    = 0xf5
+    cborNull    = 0xf6
+    cborFloat64 = 0xfb
+)
+
+// MarshalCBOR encodes v as RFC 8949 CBOR shaped like its encoding/json form: struct fields
+// take their json tag names and honour omitempty, maps are written in sorted key order and
+// TextMarshalers become strings. Byte slices become CBOR byte strings rather than base64, and
+// json.Marshaler implementations are not consulted
+func MarshalCBOR(v interface{}) ([]byte, error) {
+    e := cborEncoder{buf: make([]byte, 0, 128)}
+    if err := e.encode(reflect.ValueOf(v)); err != nil {
+        return nil, err
+    }
+    return e.buf, nil
+}
+
+type cborEncoder struct {
+    buf []byte
+}
+
+func (e *cborEncoder) encode(v reflect.Value) error {
+    if !v.IsValid() {
+        e.buf = append(e.buf, cborNull)
+        return nil
+    }
+    if v.Type().Implements(textMarshalerType) {
+        if v.Kind() == reflect.Pointer && v.IsNil() {
+            e.buf = append(e.buf, cborNull)
+            return nil
+        }
+        text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+        if err != nil {
+            return err
+        }
+        e.head(cborText, uint64(len(text)))
+        e.buf = append(e.buf, text...)
+        return nil
+    }
+
+    switch v.Kind() {
+    case reflect.Pointer, reflect.Interface:
+        if v.IsNil() {
+            e.buf = append(e.buf, cborNull)
+            return nil
+        }
+        return e.encode(v.Elem())
+    case reflect.Bool:
+        if v.Bool() {
+            e.buf = append(e.buf, cborTrue)
+        } else {
+            e.buf = append(e.buf, cborFalse)
+        }
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        if n := v.Int(); n >= 0 {
+            e.head(cborUint, uint64(n))
+        } else {
+            e.head(cborNegint, uint64(-1-n))
+        }
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+        e.head(cborUint, v.Uint())
+    case reflect.Float32, reflect.Float64:
+        f := v.Float()
+        if math.IsNaN(f) || math.IsInf(f, 0) {
+            return ErrUnsupportedType
+        }
+        e.buf = append(e.buf, cborFloat64)
+        e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(f))
+    case reflect.String:
+        e.head(cborText, uint64(v.Len()))
+        e.buf = append(e.buf, v.String()...)
+    case reflect.Slice:
+        if v.IsNil() {
+            e.buf = append(e.buf, cborNull)
+            return nil
+        }
+        if v.Type().Elem().Kind() == reflect.Uint8 {
+            e.head(cborBytes, uint64(v.Len()))
+            e.buf = append(e.buf, v.Bytes()...)
+            return nil
+        }
+        return e.array(v)
+    case reflect.Array:
+        return e.array(v)
+    case reflect.Map:
+        return e.mapValue(v)
+    case reflect.Struct:
+        return e.structValue(v)
+    default:
+        return ErrUnsupportedType
+    }
+    return nil
+}
+
+func (e *cborEncoder) array(v reflect.Value) error {
+    e.head(cborArray, uint64(v.Len()))
+    for i := 0; i < v.Len(); i++ {
+        if err := e.encode(v.Index(i)); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (e *cborEncoder) mapValue(v reflect.Value) error {
+    if v.IsNil() {
+        e.buf = append(e.buf, cborNull)
+        return nil
+    }
+    if v.Type().Key().Kind() != reflect.String {
+        return ErrUnsupportedType
+    }
+    keys := v.MapKeys()
+    sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+
+    e.head(cborMap, uint64(len(keys)))
+    for _, k := range keys {
+        e.head(cborText, uint64(k.Len()))
+        e.buf = append(e.buf, k.String()...)
+        if err := e.encode(v.MapIndex(k)); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (e *cborEncoder) structValue(v reflect.Value) error {
+    fields := cachedFields(v.Type())
+    present := make([]reflect.Value, 0, len(fields))
+    var names []string
+    for _, f := range fields {
+        fv, ok := fieldByIndex(v, f.index)
+        if !ok || f.omitEmpty && isEmpty(fv) {
+            continue
+        }
+        present = append(present, fv)
+        names = append(names, f.name)
+    }
+
+    e.head(cborMap, uint64(len(present)))
+    for i, fv := range present {
+        e.head(cborText, uint64(len(names[i])))
+        e.buf = append(e.buf, names[i]...)
+        if err := e.encode(fv); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// head writes a major type and its argument in the shortest form
+func (e *cborEncoder) head(major byte, n uint64) {
+    switch {
+    case n < 24:
+        e.buf = append(e.buf, major|byte(n))
+    case n <= math.MaxUint8:
+        e.buf = append(e.buf, major|24, byte(n))
+    case n <= math.MaxUint16:
+        e.buf = binary.BigEndian.AppendUint16(append(e.buf, major|25), uint16(n))
+    case n <= math.MaxUint32:
+        e.buf = binary.BigEndian.AppendUint32(append(e.buf, major|26), uint32(n))
+    default:
+        e.buf = binary.BigEndian.AppendUint64(append(e.buf, major|27), n)
+    }
+}
+
+type cborField struct {
+    name      string
+    index     []int
+    omitEmpty bool
+}
+
+var fieldCache sync.Map
+
+// cachedFields lists a struct's encoded fields the way encoding/json names them, promoting
+// the fields of untagged embedded structs
+func cachedFields(t reflect.Type) []cborField {
+    if f, ok := fieldCache.Load(t); ok {
+        return f.([]cborField)
+    }
+    var fields []cborField
+    seen := make(map[string]bool)
+    var walk func(t reflect.Type, index []int)
+    walk = func(t reflect.Type, index []int) {
+        for i := 0; i < t.NumField(); i++ {
+            sf := t.Field(i)
+            tag := sf.Tag.Get("json")
+            if tag == "-" {
+                continue
+            }
+            name, opts, _ := strings.Cut(tag, ",")
+            idx := append(append([]int(nil), index...), i)
+
+            ft := sf.Type
+            if ft.Kind() == reflect.Pointer {
+                ft = ft.Elem()
+            }
+            if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+                walk(ft, idx)
+                continue
+            }
+            if !sf.IsExported() {
+                continue
+            }
+            if name == "" {
+                name = sf.Name
+            }
+            // Outer fields shadow promoted ones of the same name
+            if seen[name] {
+                continue
+            }
+            seen[name] = true
+            fields = append(fields, cborField{name: name, index: idx, omitEmpty: strings.Contains(","+opts+",", ",omitempty,")})
+        }
+    }
+    walk(t, nil)
+    fieldCache.Store(t, fields)
+    return fields
+}
+
+// fieldByIndex follows index, reporting false when it passes through a nil embedded pointer
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+    for i, x := range index {
+        if i > 0 && v.Kind() == reflect.Pointer {
+            if v.IsNil() {
+                return reflect.Value{}, false
+            }
+            v = v.Elem()
+        }
+        v = v.Field(x)
+    }
+    return v, true
+}
+
+func isEmpty(v reflect.Value) bool {
+    switch v.Kind() {
+    case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+        return v.Len() == 0
+    case reflect.Bool:
+        return !v.Bool()
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        return v.Int() == 0
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+        return v.Uint() == 0
+    case reflect.Float32, reflect.Float64:
+        return v.Float() == 0
+    case reflect.Interface, reflect.Pointer:
+        return v.IsNil()
+    }
+    return false
+}
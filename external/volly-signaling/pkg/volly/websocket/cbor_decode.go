@@ -0,0 +1,200 @@
+package websocket
+
+import (
+    "errors"
+    "math"
+    "unicode/utf8"
+)
+
+// maxCBORDepth bounds nesting so a hostile message cannot exhaust the stack
+const maxCBORDepth = 32
+
+var ErrMalformedCBOR = errors.New("malformed CBOR")
+
+// UnmarshalCBOR decodes one CBOR data item into the generic values encoding/json would
+// produce: map[string]interface{}, []interface{}, string, bool, nil and float64 or int64
+// numbers, uint64 for integers past MaxInt64, plus []byte for byte strings. It is strict:
+// map keys must be unique text, text must be UTF-8, and indefinite lengths, tags and
+// trailing bytes are rejected
+func UnmarshalCBOR(data []byte) (interface{}, error) {
+    d := cborDecoder{data: data}
+    v, err := d.value(0)
+    if err != nil {
+        return nil, err
+    }
+    if d.off != len(d.data) {
+        return nil, ErrMalformedCBOR
+    }
+    return v, nil
+}
+
+type cborDecoder struct {
+    data []byte
+    off  int
+}
+
+func (d *cborDecoder) value(depth int) (interface{}, error) {
+    if depth > maxCBORDepth {
+        return nil, ErrMalformedCBOR
+    }
+    major, info, arg, err := d.head()
+    if err != nil {
+        return nil, err
+    }
+
+    switch major {
+    case cborUint:
+        if arg > math.MaxInt64 {
+            return arg, nil
+        }
+        return int64(arg), nil
+    case cborNegint:
+        if arg > math.MaxInt64 {
+            return nil, ErrMalformedCBOR
+        }
+        return -1 - int64(arg), nil
+    case cborBytes:
+        b, err := d.bytes(arg)
+        if err != nil {
+            return nil, err
+        }
+        return append([]byte(nil), b...), nil
+    case cborText:
+        b, err := d.bytes(arg)
+        if err != nil || !utf8.Valid(b) {
+            return nil, ErrMalformedCBOR
+        }
+        return string(b), nil
+    case cborArray:
+        // Every element takes at least one byte, which bounds the allocation
+        if arg > uint64(len(d.data)-d.off) {
+            return nil, ErrMalformedCBOR
+        }
+        out := make([]interface{}, 0, arg)
+        for i := uint64(0); i < arg; i++ {
+            v, err := d.value(depth + 1)
+            if err != nil {
+                return nil, err
+            }
+            out = append(out, v)
+        }
+        return out, nil
+    case cborMap:
+        if arg > uint64(len(d.data)-d.off)/2 {
+            return nil, ErrMalformedCBOR
+        }
+        out := make(map[string]interface{}, arg)
+        for i := uint64(0); i < arg; i++ {
+            k, err := d.value(depth + 1)
+            if err != nil {
+                return nil, err
+            }
+            key, ok := k.(string)
+            if !ok {
+                return nil, ErrMalformedCBOR
+            }
+            if _, dup := out[key]; dup {
+                return nil, ErrMalformedCBOR
+            }
+            if out[key], err = d.value(depth + 1); err != nil {
+                return nil, err
+            }
+        }
+        return out, nil
+    }
+    return d.simple(info, arg)
+}
+
+// head reads an item's major type, additional information and argument
+func (d *cborDecoder) head() (major, info byte, arg uint64, err error) {
+    if d.off >= len(d.data) {
+        return 0, 0, 0, ErrMalformedCBOR
+    }
+    b := d.data[d.off]
+    d.off++
+    major, info = b&0xe0, b&0x1f
+    if major == cborTag {
+        return 0, 0, 0, ErrMalformedCBOR
+    }
+
+    var n int
+    switch {
+    case info < 24:
+        return major, info, uint64(info), nil
+    case info == 24:
+        n = 1
+    case info == 25:
+        n = 2
+    case info == 26:
+        n = 4
+    case info == 27:
+        n = 8
+    default:
+        // Indefinite lengths and reserved values
+        return 0, 0, 0, ErrMalformedCBOR
+    }
+    raw, err := d.bytes(uint64(n))
+    if err != nil {
+        return 0, 0, 0, err
+    }
+    for _, c := range raw {
+        arg = arg<<8 | uint64(c)
+    }
+    return major, info, arg, nil
+}
+
+func (d *cborDecoder) bytes(n uint64) ([]byte, error) {
+    if n > uint64(len(d.data)-d.off) {
+        return nil, ErrMalformedCBOR
+    }
+    b := d.data[d.off : d.off+int(n)]
+    d.off += int(n)
+    return b, nil
+}
+
+// simple decodes major type 7, where info says whether arg is a simple value or float bits
+func (d *cborDecoder) simple(info byte, arg uint64) (interface{}, error) {
+    var f float64
+    switch cborSimple | info {
+    case cborFalse:
+        return false, nil
+    case cborTrue:
+        return true, nil
+    case cborNull, cborNull + 1:
+        return nil, nil
+    case cborSimple | 25:
+        f = halfToFloat(uint16(arg))
+    case cborSimple | 26:
+        f = float64(math.Float32frombits(uint32(arg)))
+    case cborFloat64:
+        f = math.Float64frombits(arg)
+    default:
+        return nil, ErrMalformedCBOR
+    }
+    if math.IsNaN(f) || math.IsInf(f, 0) {
+        return nil, ErrMalformedCBOR
+    }
+    return f, nil
+}
+
+func halfToFloat(h uint16) float64 {
+    exp := int(h>>10) & 0x1f
+    mant := float64(h & 0x3ff)
+    var f float64
+    switch exp {
+    case 0:
+        f = math.Ldexp(mant, -24)
+    case 31:
+        if mant == 0 {
+            f = math.Inf(1)
+        } else {
+            f = math.NaN()
+        }
+    default:
+        f = math.Ldexp(mant+1024, exp-25)
+    }
+    if h&0x8000 != 0 {
+        return -f
+    }
+    return f
+}
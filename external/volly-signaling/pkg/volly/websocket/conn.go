@@ -0,0 +1,609 @@
+// Package websocket serves and dials signaling streams over RFC 6455 WebSockets using only
+// the standard library. Clients choose the frame format with Sec-WebSocket-Protocol: volly.json.v1 sends
+// JSON text frames and volly.cbor.v1 sends the same documents as CBOR binary frames, keyed by
+// their JSON names so one schema serves both. permessage-deflate (RFC 7692) is used when the
+// client offers it
+package websocket
+
+import (
+    "bufio"
+    "crypto/rand"
+    "crypto/sha1"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "io"
+    "net"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    "unicode/utf8"
+)
+
+// Subprotocols naming the frame format
+const (
+    ProtocolJSON = "volly.json.v1"
+    ProtocolCBOR = "volly.cbor.v1"
+)
+
+// Upgrader defaults
+const (
+    DefaultMaxMessageSize       = 1 << 20
+    DefaultCompressionThreshold = 256
+    DefaultWriteTimeout         = 10 * time.Second
+)
+
+// Close codes from RFC 6455 section 7.4
+const (
+    CloseNormal          = 1000
+    CloseGoingAway       = 1001
+    CloseProtocolError   = 1002
+    CloseNoStatus        = 1005
+    CloseInvalidPayload  = 1007
+    ClosePolicyViolation = 1008
+    CloseMessageTooBig   = 1009
+    CloseInternalError   = 1011
+    CloseTryAgainLater   = 1013
+)
+
+// MessageType is the opcode of a data message
+type MessageType byte
+
+const (
+    TextMessage   MessageType = 1
+    BinaryMessage MessageType = 2
+)
+
+const (
+    opContinuation = 0
+    opClose        = 8
+    opPing         = 9
+    opPong         = 10
+
+    maxControlPayload = 125
+    acceptGUID        = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+var (
+    ErrNotWebSocket        = errors.New("request is not a websocket upgrade")
+    ErrUnsupportedVersion  = errors.New("websocket version must be 13")
+    ErrUnsupportedProtocol = errors.New("no offered websocket subprotocol is supported")
+    ErrProtocol            = errors.New("websocket protocol violation")
+    ErrMessageTooLarge     = errors.New("websocket message exceeds the size limit")
+    ErrClosed              = errors.New("websocket connection is closed")
+)
+
+// CloseError is returned by ReadMessage once the peer closes; it matches ErrClosed
+type CloseError struct {
+    Code   int
+    Reason string
+}
+
+func (e *CloseError) Error() string {
+    return ErrClosed.Error() + ": " + strconv.Itoa(e.Code) + " " + e.Reason
+}
+
+func (e *CloseError) Unwrap() error { return ErrClosed }
+
+// Upgrader accepts WebSocket handshakes
+type Upgrader struct {
+    protocols    []string
+    compression  bool
+    threshold    int
+    maxMessage   int64
+    writeTimeout time.Duration
+}
+
+// NewUpgrader accepts both frame formats and compresses when the client offers it
+func NewUpgrader() *Upgrader {
+    return &Upgrader{
+        protocols:    []string{ProtocolJSON, ProtocolCBOR},
+        compression:  true,
+        threshold:    DefaultCompressionThreshold,
+        maxMessage:   DefaultMaxMessageSize,
+        writeTimeout: DefaultWriteTimeout,
+    }
+}
+
+// SetProtocols restricts the frame formats clients may choose
+func (u *Upgrader) SetProtocols(protocols ...string) *Upgrader {
+    u.protocols = protocols
+    return u
+}
+
+// SetCompression turns permessage-deflate on or off; messages shorter than threshold bytes
+// are sent uncompressed, since deflate only grows them
+func (u *Upgrader) SetCompression(enabled bool, threshold int) *Upgrader {
+    u.compression, u.threshold = enabled, threshold
+    return u
+}
+
+// SetMaxMessageSize bounds incoming messages, before and after decompression
+func (u *Upgrader) SetMaxMessageSize(n int64) *Upgrader {
+    u.maxMessage = n
+    return u
+}
+
+// SetWriteTimeout bounds each write so a stalled client cannot hold a sender
+func (u *Upgrader) SetWriteTimeout(d time.Duration) *Upgrader {
+    u.writeTimeout = d
+    return u
+}
+
+// Upgrade completes the handshake and takes over the connection. A client that offers no
+// subprotocol gets JSON. On failure Upgrade has already written the HTTP error response
+func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+    if r.Method != http.MethodGet || !hasToken(r.Header, "Connection", "upgrade") || !hasToken(r.Header, "Upgrade", "websocket") {
+        http.Error(w, ErrNotWebSocket.Error(), http.StatusBadRequest)
+        return nil, ErrNotWebSocket
+    }
+    if r.Header.Get("Sec-WebSocket-Version") != "13" {
+        w.Header().Set("Sec-WebSocket-Version", "13")
+        http.Error(w, ErrUnsupportedVersion.Error(), http.StatusUpgradeRequired)
+        return nil, ErrUnsupportedVersion
+    }
+    key := r.Header.Get("Sec-WebSocket-Key")
+    if k, err := base64.StdEncoding.DecodeString(key); err != nil || len(k) != 16 {
+        http.Error(w, ErrNotWebSocket.Error(), http.StatusBadRequest)
+        return nil, ErrNotWebSocket
+    }
+    protocol, offered := u.selectProtocol(r.Header)
+    if protocol == "" {
+        http.Error(w, ErrUnsupportedProtocol.Error(), http.StatusBadRequest)
+        return nil, ErrUnsupportedProtocol
+    }
+    var extension string
+    if u.compression {
+        extension = negotiateDeflate(r.Header)
+    }
+
+    hj, ok := w.(http.Hijacker)
+    if !ok {
+        err := errors.New("websocket: response writer cannot be hijacked")
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return nil, err
+    }
+    netConn, rw, err := hj.Hijack()
+    if err != nil {
+        return nil, err
+    }
+    // Deadlines set by the HTTP server no longer apply
+    netConn.SetDeadline(time.Time{})
+
+    var b strings.Builder
+    b.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: ")
+    b.WriteString(acceptKey(key))
+    if offered {
+        b.WriteString("\r\nSec-WebSocket-Protocol: " + protocol)
+    }
+    if extension != "" {
+        b.WriteString("\r\nSec-WebSocket-Extensions: " + extension)
+    }
+    b.WriteString("\r\n\r\n")
+    if _, err := rw.WriteString(b.String()); err != nil {
+        netConn.Close()
+        return nil, err
+    }
+    if err := rw.Flush(); err != nil {
+        netConn.Close()
+        return nil, err
+    }
+
+    return &Conn{
+        conn:         netConn,
+        br:           rw.Reader,
+        bw:           rw.Writer,
+        protocol:     protocol,
+        compressed:   extension != "",
+        threshold:    u.threshold,
+        maxMessage:   u.maxMessage,
+        writeTimeout: u.writeTimeout,
+    }, nil
+}
+
+// selectProtocol takes the client's first offer the upgrader supports; offered is false
+// when the client named none, in which case JSON is used without echoing a protocol
+func (u *Upgrader) selectProtocol(h http.Header) (protocol string, offered bool) {
+    for _, v := range h.Values("Sec-WebSocket-Protocol") {
+        for _, p := range strings.Split(v, ",") {
+            p = strings.TrimSpace(p)
+            if p == "" {
+                continue
+            }
+            offered = true
+            for _, supported := range u.protocols {
+                if p == supported {
+                    return p, true
+                }
+            }
+        }
+    }
+    if offered {
+        return "", true
+    }
+    return ProtocolJSON, false
+}
+
+// Conn is a WebSocket connection from Upgrade or Dial. Reads must come from one goroutine;
+// writes may come from any
+type Conn struct {
+    conn net.Conn
+    br   *bufio.Reader
+    // client is set on dialed connections, which mask what they send and expect no masking
+    client       bool
+    protocol     string
+    compressed   bool
+    threshold    int
+    maxMessage   int64
+    writeTimeout time.Duration
+
+    readErr error
+
+    wmu       sync.Mutex
+    bw        *bufio.Writer
+    closeSent bool
+}
+
+// Protocol is the negotiated frame format
+func (c *Conn) Protocol() string { return c.protocol }
+
+// Compressed reports whether permessage-deflate was negotiated
+func (c *Conn) Compressed() bool { return c.compressed }
+
+// RemoteAddr is the client's network address
+func (c *Conn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// SetReadDeadline bounds the wait in ReadMessage; a zero time waits indefinitely
+func (c *Conn) SetReadDeadline(t time.Time) error { return c.conn.SetReadDeadline(t) }
+
+// Send encodes v in the negotiated format: a text frame of JSON or a binary frame of CBOR
+func (c *Conn) Send(v interface{}) error {
+    if c.protocol == ProtocolCBOR {
+        data, err := MarshalCBOR(v)
+        if err != nil {
+            return err
+        }
+        return c.WriteMessage(BinaryMessage, data)
+    }
+    data, err := json.Marshal(v)
+    if err != nil {
+        return err
+    }
+    return c.WriteMessage(TextMessage, data)
+}
+
+// WriteMessage sends one unfragmented message, compressed when negotiated and long enough
+func (c *Conn) WriteMessage(typ MessageType, data []byte) error {
+    compress := c.compressed && len(data) >= c.threshold
+    if compress {
+        var err error
+        if data, err = deflate(data); err != nil {
+            return err
+        }
+    }
+
+    c.wmu.Lock()
+    defer c.wmu.Unlock()
+
+    if c.closeSent {
+        return ErrClosed
+    }
+    return c.writeFrame(byte(typ), compress, data)
+}
+
+// Ping sends a ping; the client's pong is consumed by ReadMessage
+func (c *Conn) Ping() error {
+    c.wmu.Lock()
+    defer c.wmu.Unlock()
+
+    if c.closeSent {
+        return ErrClosed
+    }
+    return c.writeFrame(opPing, false, nil)
+}
+
+// ReadMessage returns the next data message, answering pings and close frames on the way.
+// After the peer closes it returns *CloseError, and after a violation of the protocol or the
+// size limit it closes the connection and returns ErrProtocol or ErrMessageTooLarge
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+    if c.readErr != nil {
+        return 0, nil, c.readErr
+    }
+    typ, data, err := c.readMessage()
+    if err != nil {
+        c.readErr = err
+        switch {
+        case errors.Is(err, ErrProtocol):
+            c.CloseWithCode(CloseProtocolError, "")
+        case errors.Is(err, ErrMessageTooLarge):
+            c.CloseWithCode(CloseMessageTooBig, "")
+        case errors.Is(err, errInvalidPayload):
+            c.readErr = ErrProtocol
+            c.CloseWithCode(CloseInvalidPayload, "")
+        }
+        return 0, nil, c.readErr
+    }
+    return typ, data, nil
+}
+
+// CloseWithCode sends a close frame, if none was sent, and closes the connection
+func (c *Conn) CloseWithCode(code int, reason string) error {
+    c.wmu.Lock()
+    if !c.closeSent {
+        c.closeSent = true
+        c.writeFrame(opClose, false, closePayload(code, reason))
+    }
+    c.wmu.Unlock()
+    return c.conn.Close()
+}
+
+// Close closes the connection normally
+func (c *Conn) Close() error {
+    return c.CloseWithCode(CloseNormal, "")
+}
+
+var errInvalidPayload = errors.New("websocket text message is not valid UTF-8")
+
+type frameHeader struct {
+    fin    bool
+    rsv1   bool
+    op     byte
+    length int64
+    masked bool
+    mask   [4]byte
+}
+
+func (c *Conn) readMessage() (MessageType, []byte, error) {
+    var (
+        typ        MessageType
+        msg        []byte
+        inMessage  bool
+        compressed bool
+    )
+    for {
+        h, err := c.readHeader()
+        if err != nil {
+            return 0, nil, err
+        }
+
+        if h.op >= opClose {
+            if h.op > opPong || !h.fin || h.rsv1 || h.length > maxControlPayload {
+                return 0, nil, ErrProtocol
+            }
+            payload, err := c.readPayload(h)
+            if err != nil {
+                return 0, nil, err
+            }
+            if err := c.control(h.op, payload); err != nil {
+                return 0, nil, err
+            }
+            continue
+        }
+
+        switch h.op {
+        case byte(TextMessage), byte(BinaryMessage):
+            if inMessage || h.rsv1 && !c.compressed {
+                return 0, nil, ErrProtocol
+            }
+            typ, inMessage, compressed = MessageType(h.op), true, h.rsv1
+        case opContinuation:
+            if !inMessage || h.rsv1 {
+                return 0, nil, ErrProtocol
+            }
+        default:
+            return 0, nil, ErrProtocol
+        }
+        if c.maxMessage > 0 && int64(len(msg))+h.length > c.maxMessage {
+            return 0, nil, ErrMessageTooLarge
+        }
+        payload, err := c.readPayload(h)
+        if err != nil {
+            return 0, nil, err
+        }
+        msg = append(msg, payload...)
+        if !h.fin {
+            continue
+        }
+
+        if compressed {
+            if msg, err = inflate(msg, c.maxMessage); err != nil {
+                return 0, nil, err
+            }
+        }
+        if typ == TextMessage && !utf8.Valid(msg) {
+            return 0, nil, errInvalidPayload
+        }
+        return typ, msg, nil
+    }
+}
+
+func (c *Conn) readHeader() (frameHeader, error) {
+    var h frameHeader
+    var b [8]byte
+    if _, err := io.ReadFull(c.br, b[:2]); err != nil {
+        return h, err
+    }
+    // RSV2 and RSV3 belong to extensions that are never negotiated
+    if b[0]&0x30 != 0 {
+        return h, ErrProtocol
+    }
+    h.fin = b[0]&0x80 != 0
+    h.rsv1 = b[0]&0x40 != 0
+    h.op = b[0] & 0x0f
+    // Clients must mask every frame and servers must not
+    h.masked = b[1]&0x80 != 0
+    if h.masked == c.client {
+        return h, ErrProtocol
+    }
+
+    switch n := b[1] & 0x7f; n {
+    case 126:
+        if _, err := io.ReadFull(c.br, b[:2]); err != nil {
+            return h, err
+        }
+        h.length = int64(binary.BigEndian.Uint16(b[:2]))
+    case 127:
+        if _, err := io.ReadFull(c.br, b[:8]); err != nil {
+            return h, err
+        }
+        u := binary.BigEndian.Uint64(b[:8])
+        if u>>63 != 0 {
+            return h, ErrProtocol
+        }
+        h.length = int64(u)
+    default:
+        h.length = int64(n)
+    }
+    if h.masked {
+        if _, err := io.ReadFull(c.br, h.mask[:]); err != nil {
+            return h, err
+        }
+    }
+    return h, nil
+}
+
+func (c *Conn) readPayload(h frameHeader) ([]byte, error) {
+    payload := make([]byte, h.length)
+    if _, err := io.ReadFull(c.br, payload); err != nil {
+        return nil, err
+    }
+    if h.masked {
+        maskBytes(payload, h.mask)
+    }
+    return payload, nil
+}
+
+// control answers pings and close frames; a close ends reading with *CloseError
+func (c *Conn) control(op byte, payload []byte) error {
+    switch op {
+    case opPing:
+        c.wmu.Lock()
+        defer c.wmu.Unlock()
+        if c.closeSent {
+            return nil
+        }
+        return c.writeFrame(opPong, false, payload)
+    case opPong:
+        return nil
+    }
+
+    closeErr := &CloseError{Code: CloseNoStatus}
+    switch {
+    case len(payload) == 1:
+        return ErrProtocol
+    case len(payload) >= 2:
+        closeErr.Code = int(binary.BigEndian.Uint16(payload))
+        closeErr.Reason = string(payload[2:])
+        if !validCloseCode(closeErr.Code) {
+            return ErrProtocol
+        }
+        if !utf8.Valid(payload[2:]) {
+            return errInvalidPayload
+        }
+    }
+
+    c.wmu.Lock()
+    if !c.closeSent {
+        c.closeSent = true
+        var echo []byte
+        if closeErr.Code != CloseNoStatus {
+            echo = closePayload(closeErr.Code, "")
+        }
+        c.writeFrame(opClose, false, echo)
+    }
+    c.wmu.Unlock()
+    c.conn.Close()
+    return closeErr
+}
+
+// writeFrame writes one final frame; the caller holds wmu
+func (c *Conn) writeFrame(op byte, rsv1 bool, payload []byte) error {
+    var header [14]byte
+    header[0] = 0x80 | op
+    if rsv1 {
+        header[0] |= 0x40
+    }
+    n := 2
+    switch {
+    case len(payload) < 126:
+        header[1] = byte(len(payload))
+    case len(payload) <= 0xffff:
+        header[1] = 126
+        binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+        n = 4
+    default:
+        header[1] = 127
+        binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+        n = 10
+    }
+    if c.client {
+        var mask [4]byte
+        if _, err := rand.Read(mask[:]); err != nil {
+            return err
+        }
+        header[1] |= 0x80
+        n += copy(header[n:], mask[:])
+        payload = append([]byte(nil), payload...)
+        maskBytes(payload, mask)
+    }
+
+    if c.writeTimeout > 0 {
+        c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+    }
+    if _, err := c.bw.Write(header[:n]); err != nil {
+        return err
+    }
+    if _, err := c.bw.Write(payload); err != nil {
+        return err
+    }
+    return c.bw.Flush()
+}
+
+func maskBytes(b []byte, mask [4]byte) {
+    for i := range b {
+        b[i] ^= mask[i&3]
+    }
+}
+
+func closePayload(code int, reason string) []byte {
+    if code == CloseNoStatus {
+        return nil
+    }
+    if len(reason) > maxControlPayload-2 {
+        reason = reason[:maxControlPayload-2]
+    }
+    b := make([]byte, 2, 2+len(reason))
+    binary.BigEndian.PutUint16(b, uint16(code))
+    return append(b, reason...)
+}
+
+// validCloseCode accepts the codes a peer may send, per RFC 6455 section 7.4
+func validCloseCode(code int) bool {
+    switch {
+    case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+        return true
+    case code >= 3000 && code <= 4999:
+        return true
+    }
+    return false
+}
+
+func acceptKey(key string) string {
+    sum := sha1.Sum([]byte(key + acceptGUID))
+    return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// hasToken reports whether a comma-separated header lists token, ignoring case
+func hasToken(h http.Header, name, token string) bool {
+    for _, v := range h.Values(name) {
+        for _, t := range strings.Split(v, ",") {
+            if strings.EqualFold(strings.TrimSpace(t), token) {
+                return true
+            }
+        }
+    }
+    return false
+}
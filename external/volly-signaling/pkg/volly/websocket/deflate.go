@@ -0,0 +1,105 @@
+package websocket
+
+import (
+    "bytes"
+    "compress/flate"
+    "io"
+    "net/http"
+    "strings"
+    "sync"
+)
+
+// deflateResponse turns off context takeover both ways, so every message is compressed on its
+// own and neither side keeps a 32KiB window for each idle connection
+const deflateResponse = "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+
+// deflateTail ends a message's sync flush and adds an empty final block, so the reader stops
+// cleanly at the end of the message instead of waiting for more
+const deflateTail = "\x00\x00\xff\xff\x01\x00\x00\xff\xff"
+
+var flateWriters sync.Pool
+
+// negotiateDeflate accepts the first permessage-deflate offer compress/flate can honour,
+// which is any offer that does not shrink the server's window below 32KiB
+func negotiateDeflate(h http.Header) string {
+    for _, v := range h.Values("Sec-WebSocket-Extensions") {
+        for _, offer := range strings.Split(v, ",") {
+            params := strings.Split(offer, ";")
+            if strings.TrimSpace(params[0]) != "permessage-deflate" {
+                continue
+            }
+            if acceptableDeflate(params[1:]) {
+                return deflateResponse
+            }
+        }
+    }
+    return ""
+}
+
+func acceptableDeflate(params []string) bool {
+    seen := make(map[string]bool, len(params))
+    for _, p := range params {
+        name, value, _ := strings.Cut(strings.TrimSpace(p), "=")
+        name = strings.TrimSpace(name)
+        value = strings.Trim(strings.TrimSpace(value), `"`)
+        if seen[name] {
+            return false
+        }
+        seen[name] = true
+
+        switch name {
+        case "server_no_context_takeover", "client_no_context_takeover":
+        case "client_max_window_bits":
+            // Client windows are the client's business; the reader handles any size
+        case "server_max_window_bits":
+            if value != "15" {
+                return false
+            }
+        default:
+            return false
+        }
+    }
+    return true
+}
+
+// deflate compresses one message, dropping the sync flush marker the peer adds back
+func deflate(data []byte) ([]byte, error) {
+    var buf bytes.Buffer
+    fw, _ := flateWriters.Get().(*flate.Writer)
+    if fw == nil {
+        var err error
+        if fw, err = flate.NewWriter(&buf, flate.BestSpeed); err != nil {
+            return nil, err
+        }
+    } else {
+        fw.Reset(&buf)
+    }
+    defer flateWriters.Put(fw)
+
+    if _, err := fw.Write(data); err != nil {
+        return nil, err
+    }
+    if err := fw.Flush(); err != nil {
+        return nil, err
+    }
+    return bytes.TrimSuffix(buf.Bytes(), []byte(deflateTail[:4])), nil
+}
+
+// inflate decompresses one message, failing with ErrMessageTooLarge past max bytes
+func inflate(data []byte, max int64) ([]byte, error) {
+    fr := flate.NewReader(io.MultiReader(bytes.NewReader(data), strings.NewReader(deflateTail)))
+    defer fr.Close()
+
+    r := io.Reader(fr)
+    if max > 0 {
+        r = io.LimitReader(fr, max+1)
+    }
+    out, err := io.ReadAll(r)
+    if err != nil {
+        return nil, ErrProtocol
+    }
+    if max > 0 && int64(len(out)) > max {
+        return nil, ErrMessageTooLarge
+    }
+    return out, nil
+}
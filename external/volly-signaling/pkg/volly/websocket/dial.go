@@ -0,0 +1,248 @@
+package websocket
+
+import (
+    "bufio"
+    "context"
+    "crypto/rand"
+    "crypto/tls"
+    "encoding/base64"
+    "errors"
+    "io"
+    "net"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+var ErrBadHandshake = errors.New("websocket handshake failed")
+
+// HandshakeError is a server's refusal to upgrade; it matches ErrBadHandshake
+type HandshakeError struct {
+    StatusCode int
+    // RetryAfter is the server's Retry-After, when it sent one
+    RetryAfter time.Duration
+    Body       string
+}
+
+func (e *HandshakeError) Error() string {
+    s := ErrBadHandshake.Error() + ": " + strconv.Itoa(e.StatusCode)
+    if e.Body != "" {
+        s += " " + e.Body
+    }
+    return s
+}
+
+func (e *HandshakeError) Unwrap() error { return ErrBadHandshake }
+
+// Dialer opens client WebSocket connections
+type Dialer struct {
+    protocols    []string
+    compression  bool
+    threshold    int
+    maxMessage   int64
+    writeTimeout time.Duration
+    tlsConfig    *tls.Config
+}
+
+// NewDialer offers JSON frames and compression
+func NewDialer() *Dialer {
+    return &Dialer{
+        protocols:    []string{ProtocolJSON},
+        compression:  true,
+        threshold:    DefaultCompressionThreshold,
+        maxMessage:   DefaultMaxMessageSize,
+        writeTimeout: DefaultWriteTimeout,
+    }
+}
+
+// SetProtocols sets the frame formats offered, most preferred first
+func (d *Dialer) SetProtocols(protocols ...string) *Dialer {
+    d.protocols = protocols
+    return d
+}
+
+// SetCompression turns the permessage-deflate offer on or off; see Upgrader.SetCompression
+func (d *Dialer) SetCompression(enabled bool, threshold int) *Dialer {
+    d.compression, d.threshold = enabled, threshold
+    return d
+}
+
+// SetMaxMessageSize bounds incoming messages, before and after decompression
+func (d *Dialer) SetMaxMessageSize(n int64) *Dialer {
+    d.maxMessage = n
+    return d
+}
+
+// SetTLSConfig sets the TLS configuration for wss URLs
+func (d *Dialer) SetTLSConfig(c *tls.Config) *Dialer {
+    d.tlsConfig = c
+    return d
+}
+
+// Dial connects to a ws, wss, http or https URL, sending header, such as Authorization, with
+// the handshake. A server that refuses the upgrade yields a *HandshakeError carrying its status
+func (d *Dialer) Dial(ctx context.Context, rawURL string, header http.Header) (*Conn, error) {
+    u, err := url.Parse(rawURL)
+    if err != nil {
+        return nil, err
+    }
+    secure := false
+    switch u.Scheme {
+    case "ws", "http":
+    case "wss", "https":
+        secure = true
+    default:
+        return nil, errors.New("websocket: unsupported URL scheme " + u.Scheme)
+    }
+    host := u.Host
+    if u.Port() == "" {
+        if secure {
+            host = net.JoinHostPort(u.Hostname(), "443")
+        } else {
+            host = net.JoinHostPort(u.Hostname(), "80")
+        }
+    }
+
+    var nd net.Dialer
+    netConn, err := nd.DialContext(ctx, "tcp", host)
+    if err != nil {
+        return nil, err
+    }
+    // The handshake obeys ctx; the connection outlives it
+    if deadline, ok := ctx.Deadline(); ok {
+        netConn.SetDeadline(deadline)
+    }
+    stop := context.AfterFunc(ctx, func() { netConn.SetDeadline(time.Unix(1, 0)) })
+    defer stop()
+
+    if secure {
+        cfg := d.tlsConfig.Clone()
+        if cfg == nil {
+            cfg = &tls.Config{}
+        }
+        if cfg.ServerName == "" {
+            cfg.ServerName = u.Hostname()
+        }
+        tlsConn := tls.Client(netConn, cfg)
+        if err := tlsConn.HandshakeContext(ctx); err != nil {
+            netConn.Close()
+            return nil, err
+        }
+        netConn = tlsConn
+    }
+
+    conn, err := d.handshake(netConn, u, header)
+    if err != nil {
+        netConn.Close()
+        if ctx.Err() != nil {
+            return nil, ctx.Err()
+        }
+        return nil, err
+    }
+    if !stop() {
+        netConn.Close()
+        return nil, ctx.Err()
+    }
+    netConn.SetDeadline(time.Time{})
+    return conn, nil
+}
+
+func (d *Dialer) handshake(netConn net.Conn, u *url.URL, header http.Header) (*Conn, error) {
+    var nonce [16]byte
+    if _, err := rand.Read(nonce[:]); err != nil {
+        return nil, err
+    }
+    key := base64.StdEncoding.EncodeToString(nonce[:])
+
+    req := &http.Request{
+        Method:     http.MethodGet,
+        URL:        &url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery},
+        Proto:      "HTTP/1.1",
+        ProtoMajor: 1,
+        ProtoMinor: 1,
+        Header:     make(http.Header),
+        Host:       u.Host,
+    }
+    if req.URL.Path == "" {
+        req.URL.Path = "/"
+    }
+    for k, v := range header {
+        req.Header[k] = v
+    }
+    req.Header.Set("Upgrade", "websocket")
+    req.Header.Set("Connection", "Upgrade")
+    req.Header.Set("Sec-WebSocket-Version", "13")
+    req.Header.Set("Sec-WebSocket-Key", key)
+    if len(d.protocols) > 0 {
+        req.Header.Set("Sec-WebSocket-Protocol", strings.Join(d.protocols, ", "))
+    }
+    if d.compression {
+        req.Header.Set("Sec-WebSocket-Extensions", "permessage-deflate; client_no_context_takeover; server_no_context_takeover")
+    }
+    bw := bufio.NewWriter(netConn)
+    if err := req.Write(bw); err != nil {
+        return nil, err
+    }
+    if err := bw.Flush(); err != nil {
+        return nil, err
+    }
+
+    br := bufio.NewReader(netConn)
+    resp, err := http.ReadResponse(br, req)
+    if err != nil {
+        return nil, err
+    }
+    if resp.StatusCode != http.StatusSwitchingProtocols {
+        body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+        resp.Body.Close()
+        herr := &HandshakeError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+        if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+            herr.RetryAfter = time.Duration(secs) * time.Second
+        }
+        return nil, herr
+    }
+    if !hasToken(resp.Header, "Upgrade", "websocket") || !hasToken(resp.Header, "Connection", "upgrade") ||
+        resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+        return nil, ErrProtocol
+    }
+
+    protocol := resp.Header.Get("Sec-WebSocket-Protocol")
+    switch {
+    case protocol == "" && (len(d.protocols) == 0 || d.protocols[0] == ProtocolJSON):
+        protocol = ProtocolJSON
+    case !contains(d.protocols, protocol):
+        return nil, ErrUnsupportedProtocol
+    }
+
+    compressed := false
+    if ext := resp.Header.Get("Sec-WebSocket-Extensions"); ext != "" {
+        // Messages are inflated one at a time, so the server must not keep its window
+        if !d.compression || !strings.HasPrefix(ext, "permessage-deflate") || !strings.Contains(ext, "server_no_context_takeover") {
+            return nil, ErrProtocol
+        }
+        compressed = true
+    }
+
+    return &Conn{
+        conn:         netConn,
+        br:           br,
+        bw:           bw,
+        client:       true,
+        protocol:     protocol,
+        compressed:   compressed,
+        threshold:    d.threshold,
+        maxMessage:   d.maxMessage,
+        writeTimeout: d.writeTimeout,
+    }, nil
+}
+
+func contains(list []string, s string) bool {
+    for _, v := range list {
+        if v == s {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,47 @@
+package websocket
+
+import (
+    "bytes"
+    "testing"
+)
+
+// Seeds live in testdata/fuzz; run one target with go test -fuzz=FuzzX
+
+// FuzzUnmarshalCBOR feeds arbitrary binary frames to the CBOR decoder. Whatever it accepts
+// must encode again, and decoding and encoding that once more must give the same bytes
+func FuzzUnmarshalCBOR(f *testing.F) {
+    for _, v := range []interface{}{
+        map[string]interface{}{"type": "ping", "id": "1"},
+        map[string]interface{}{"type": "subscribe", "types": []interface{}{"hand", "grant"}},
+        map[string]interface{}{"n": int64(-1), "big": uint64(1 << 63), "f": 1.5, "b": []byte{0, 1}, "t": true, "z": nil},
+    } {
+        if data, err := MarshalCBOR(v); err == nil {
+            f.Add(data)
+        }
+    }
+    // Headers claiming far more items than follow, and nesting past the depth limit
+    f.Add([]byte{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+    f.Add([]byte{0xbb, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+    f.Add(bytes.Repeat([]byte{0x81}, 64))
+    f.Fuzz(func(t *testing.T, data []byte) {
+        v, err := UnmarshalCBOR(data)
+        if err != nil {
+            return
+        }
+        encoded, err := MarshalCBOR(v)
+        if err != nil {
+            t.Fatalf("decoded value does not encode: %v", err)
+        }
+        again, err := UnmarshalCBOR(encoded)
+        if err != nil {
+            t.Fatalf("encoded value does not decode: %v", err)
+        }
+        reencoded, err := MarshalCBOR(again)
+        if err != nil {
+            t.Fatalf("decoded value does not encode: %v", err)
+        }
+        if !bytes.Equal(encoded, reencoded) {
+            t.Fatalf("encoding is not stable: %x then %x", encoded, reencoded)
+        }
+    })
+}